@@ -45,6 +45,7 @@ type Config struct {
 	Version          string `yaml:"version"`
 	IgnoreParseError bool   `yaml:"ignore_parse_error"`
 	ParseErrorsLog   string `yaml:"parse_errors_log"`
+	CheckBoundValues bool   `yaml:"check_bound_values"`
 	Handlers         []struct {
 		Handler  string
 		Queries  []string
@@ -84,6 +85,7 @@ func (acraCensor *AcraCensor) LoadConfiguration(configuration []byte) error {
 		return ErrUnsupportedConfigVersion
 	}
 	acraCensor.ignoreParseError = censorConfiguration.IgnoreParseError
+	acraCensor.checkBoundValues = censorConfiguration.CheckBoundValues
 	if !strings.EqualFold(censorConfiguration.ParseErrorsLog, "") {
 		queryWriter, err := common.NewFileQueryWriter(censorConfiguration.ParseErrorsLog)
 		if err != nil {
@@ -17,6 +17,11 @@ limitations under the License.
 package acracensor
 
 import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/cossacklabs/acra/acra-censor/common"
 	"github.com/cossacklabs/acra/acra-censor/handlers"
 	"github.com/cossacklabs/acra/logging"
@@ -31,6 +36,7 @@ const ServiceName = "acra-censor"
 type AcraCensor struct {
 	handlers              []QueryHandlerInterface
 	ignoreParseError      bool
+	checkBoundValues      bool
 	unparsedQueriesWriter *common.QueryWriter
 	logger                *log.Entry
 	parser                *sqlparser.Parser
@@ -65,6 +71,7 @@ func (acraCensor *AcraCensor) RemoveHandler(handler QueryHandlerInterface) {
 // ReleaseAll stops all handlers.
 func (acraCensor *AcraCensor) ReleaseAll() {
 	acraCensor.ignoreParseError = false
+	acraCensor.checkBoundValues = false
 	for _, handler := range acraCensor.handlers {
 		handler.Release()
 	}
@@ -76,6 +83,21 @@ func (acraCensor *AcraCensor) ReleaseAll() {
 
 // HandleQuery processes every query through each handler.
 func (acraCensor *AcraCensor) HandleQuery(rawQuery string) error {
+	return acraCensor.handleQuery(rawQuery, acraCensor.logger)
+}
+
+// HandleQueryWithContext processes query like HandleQuery and logs verdict with fields of logger from ctx, like
+// client_id and client_address of the client's session
+func (acraCensor *AcraCensor) HandleQueryWithContext(ctx context.Context, rawQuery string) error {
+	return acraCensor.handleQuery(rawQuery, acraCensor.contextLogger(ctx))
+}
+
+// contextLogger returns logger from ctx with field of the service
+func (acraCensor *AcraCensor) contextLogger(ctx context.Context) *log.Entry {
+	return logging.GetLoggerFromContext(ctx).WithField("service", ServiceName)
+}
+
+func (acraCensor *AcraCensor) handleQuery(rawQuery string, logger *log.Entry) error {
 	if len(acraCensor.handlers) == 0 && acraCensor.unparsedQueriesWriter == nil {
 		// no handlers, AcraCensor won't work
 		return nil
@@ -86,22 +108,109 @@ func (acraCensor *AcraCensor) HandleQuery(rawQuery string) error {
 		acraCensor.saveUnparsedQuery(rawQuery)
 		if acraCensor.ignoreParseError {
 			// log warning if we ignore such errors
-			acraCensor.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryParseError).Warning("Failed to parse input query")
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryParseError).Warning("Failed to parse input query")
 		} else {
-			acraCensor.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryParseError).Errorln("Unparsed query has been denied")
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryParseError).Errorln("Unparsed query has been denied")
 			return err
 		}
 	}
+	return acraCensor.checkQuery(rawQuery, normalizedQuery, queryWithHiddenValues, parsedQuery, true, logger)
+}
+
+// HandleBoundQuery processes query of prepared statement with values of its parameters substituted instead of
+// placeholders, so rules with literal values apply to queries sent with extended protocol too. It does nothing if
+// check_bound_values isn't turned on in the config. Query isn't captured again, it's captured by HandleQuery when
+// the statement is prepared.
+func (acraCensor *AcraCensor) HandleBoundQuery(rawQuery string, values []BoundValue) error {
+	return acraCensor.handleBoundQuery(rawQuery, values, acraCensor.logger)
+}
+
+// HandleBoundQueryWithContext processes query like HandleBoundQuery and logs verdict with fields of logger from ctx
+func (acraCensor *AcraCensor) HandleBoundQueryWithContext(ctx context.Context, rawQuery string, values []BoundValue) error {
+	return acraCensor.handleBoundQuery(rawQuery, values, acraCensor.contextLogger(ctx))
+}
+
+func (acraCensor *AcraCensor) handleBoundQuery(rawQuery string, values []BoundValue, logger *log.Entry) error {
+	if !acraCensor.checkBoundValues || len(acraCensor.handlers) == 0 {
+		return nil
+	}
+	sqlStripped, _ := sqlparser.SplitMarginComments(rawQuery)
+	statement, err := acraCensor.parser.Parse(strings.TrimSuffix(sqlStripped, ";"))
+	if err != nil {
+		// unparsed queries are handled by HandleQuery when the statement is prepared
+		logger.WithError(err).Debugln("Can't parse prepared statement to check bound values")
+		return nil
+	}
+	substituteBoundValues(statement, values)
+	boundQuery := sqlparser.String(statement)
+	normalizedQuery, queryWithHiddenValues, parsedQuery, err := acraCensor.parser.HandleRawSQLQuery(boundQuery)
+	if err != nil {
+		logger.WithError(err).Warningln("Can't parse prepared statement with bound values, skip check")
+		return nil
+	}
+	return acraCensor.checkQuery(boundQuery, normalizedQuery, queryWithHiddenValues, parsedQuery, false, logger)
+}
+
+// substituteBoundValues replaces placeholders of statement with literals of bound values. NULL values, values in binary
+// format and placeholders without value are left as is. Type of literal is guessed from the text, because types of
+// columns are unknown: integer and float values become numbers, other values become strings
+func substituteBoundValues(statement sqlparser.Statement, values []BoundValue) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		value, ok := node.(*sqlparser.SQLVal)
+		if !ok {
+			return true, nil
+		}
+		var prefix string
+		switch value.Type {
+		case sqlparser.PgPlaceholder:
+			// PostgreSQL placeholders look like "$1"
+			prefix = "$"
+		case sqlparser.ValArg:
+			// MySQL placeholders look like ":v1"
+			prefix = ":v"
+		default:
+			return true, nil
+		}
+		// placeholders use 1-based indexing
+		index, err := strconv.Atoi(strings.TrimPrefix(string(value.Val), prefix))
+		if err != nil || index < 1 || index > len(values) {
+			return true, nil
+		}
+		bound := values[index-1]
+		if bound.Data == nil || bound.Binary {
+			return true, nil
+		}
+		value.Type = boundValueType(bound.Data)
+		value.Val = append([]byte{}, bound.Data...)
+		return true, nil
+	}, statement)
+}
+
+func boundValueType(data []byte) sqlparser.ValType {
+	text := string(data)
+	if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return sqlparser.IntVal
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+		return sqlparser.FloatVal
+	}
+	return sqlparser.StrVal
+}
+
+// checkQuery passes parsed query through handlers, captureQuery turns on query capture handlers
+func (acraCensor *AcraCensor) checkQuery(rawQuery, normalizedQuery, queryWithHiddenValues string, parsedQuery sqlparser.Statement, captureQuery bool, logger *log.Entry) error {
 	// Handlers work
 	for _, handler := range acraCensor.handlers {
 		if queryCaptureHandler, ok := handler.(*handlers.QueryCaptureHandler); ok {
-			queryCaptureHandler.CheckQuery(queryWithHiddenValues, parsedQuery)
+			if captureQuery {
+				queryCaptureHandler.CheckQuery(queryWithHiddenValues, parsedQuery)
+			}
 			continue
 		}
 		if queryIgnoreHandler, ok := handler.(*handlers.QueryIgnoreHandler); ok {
 			continueHandling, _ := queryIgnoreHandler.CheckQuery(rawQuery, nil)
 			if !continueHandling {
-				acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery)
+				acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery, logger)
 				return nil
 			}
 			continue
@@ -109,44 +218,44 @@ func (acraCensor *AcraCensor) HandleQuery(rawQuery string) error {
 		// Security checks (allow/deny handlers)
 		continueHandling, err := handler.CheckQuery(normalizedQuery, parsedQuery)
 		if err != nil {
-			acraCensor.logDeniedQuery(queryWithHiddenValues, handler, parsedQuery)
+			acraCensor.logDeniedQuery(queryWithHiddenValues, handler, parsedQuery, logger)
 			return err
 		}
 		//we don't have errors so allow query
 		if !continueHandling {
-			acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery)
+			acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery, logger)
 			return nil
 		}
 	}
-	acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery)
+	acraCensor.logAllowedQuery(queryWithHiddenValues, parsedQuery, logger)
 	return nil
 }
 
-func (acraCensor *AcraCensor) logAllowedQuery(queryWithHiddenValues string, parsedQuery sqlparser.Statement) {
+func (acraCensor *AcraCensor) logAllowedQuery(queryWithHiddenValues string, parsedQuery sqlparser.Statement, logger *log.Entry) {
 	if parsedQuery != nil && queryWithHiddenValues != "" {
-		acraCensor.logger.Infof("Allowed query: '%s'", common.TrimStringToN(queryWithHiddenValues, common.LogQueryLength))
+		logger.Infof("Allowed query: '%s'", common.TrimStringToN(queryWithHiddenValues, common.LogQueryLength))
 		return
 	}
 	if parsedQuery == nil && queryWithHiddenValues == "" {
-		acraCensor.logger.Infoln("Allowed query can't be shown in plaintext")
+		logger.Infoln("Allowed query can't be shown in plaintext")
 		return
 	}
-	acraCensor.logger.Debugf("parsedQuery: %T, queryWithHiddenValues: %s", parsedQuery, queryWithHiddenValues)
+	logger.Debugf("parsedQuery: %T, queryWithHiddenValues: %s", parsedQuery, queryWithHiddenValues)
 	return
 }
 
-func (acraCensor *AcraCensor) logDeniedQuery(queryWithHiddenValues string, handler QueryHandlerInterface, parsedQuery sqlparser.Statement) {
+func (acraCensor *AcraCensor) logDeniedQuery(queryWithHiddenValues string, handler QueryHandlerInterface, parsedQuery sqlparser.Statement, logger *log.Entry) {
 	if parsedQuery != nil && queryWithHiddenValues != "" {
-		acraCensor.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorf("Denied query: '%s'", common.TrimStringToN(queryWithHiddenValues, common.LogQueryLength))
-		acraCensor.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Debugf("Denied query by %T", handler)
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorf("Denied query: '%s'", common.TrimStringToN(queryWithHiddenValues, common.LogQueryLength))
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Debugf("Denied query by %T", handler)
 		return
 	}
 	if parsedQuery == nil && queryWithHiddenValues == "" {
-		acraCensor.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorln("Denied query can't be shown in plaintext")
-		acraCensor.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Debugf("Denied query by %T", handler)
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorln("Denied query can't be shown in plaintext")
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Debugf("Denied query by %T", handler)
 		return
 	}
-	acraCensor.logger.Debugf("parsedQuery: %T, queryWithHiddenValues: %s", parsedQuery, queryWithHiddenValues)
+	logger.Debugf("parsedQuery: %T, queryWithHiddenValues: %s", parsedQuery, queryWithHiddenValues)
 	return
 }
 
@@ -23,6 +23,8 @@ limitations under the License.
 package acracensor
 
 import (
+	"context"
+
 	"github.com/cossacklabs/acra/sqlparser"
 )
 
@@ -35,7 +37,19 @@ type QueryHandlerInterface interface {
 // AcraCensorInterface describes main AcraCensor methods: adding and removing query handlers and processing query
 type AcraCensorInterface interface {
 	HandleQuery(sqlQuery string) error
+	HandleBoundQuery(sqlQuery string, values []BoundValue) error
+	// HandleQueryWithContext and HandleBoundQueryWithContext log verdicts with fields of logger from ctx, like
+	// client_id and client_address of the client's session
+	HandleQueryWithContext(ctx context.Context, sqlQuery string) error
+	HandleBoundQueryWithContext(ctx context.Context, sqlQuery string, values []BoundValue) error
 	AddHandler(handler QueryHandlerInterface)
 	RemoveHandler(handler QueryHandlerInterface)
 	ReleaseAll()
 }
+
+// BoundValue is a value of prepared statement parameter passed to AcraCensor.HandleBoundQuery.
+// Data is nil for NULL values. Values in binary format aren't substituted into the query.
+type BoundValue struct {
+	Data   []byte
+	Binary bool
+}
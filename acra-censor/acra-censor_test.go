@@ -24,6 +24,7 @@ package acracensor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -39,7 +40,9 @@ import (
 	"fmt"
 
 	"github.com/cossacklabs/acra/acra-censor/handlers"
+	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestAllowQueries(t *testing.T) {
@@ -1805,3 +1808,118 @@ handlers:
 		}
 	}
 }
+
+func TestHandleBoundQuery(t *testing.T) {
+	configuration := `version: 0.85.0
+check_bound_values: true
+handlers:
+  - handler: deny
+    queries:
+      - SELECT * FROM users WHERE id = 1
+      - INSERT INTO users (name, score) VALUES ('admin', 1.5)
+  - handler: allowall
+`
+	acraCensor := NewAcraCensor()
+	defer acraCensor.ReleaseAll()
+	if err := acraCensor.LoadConfiguration([]byte(configuration)); err != nil {
+		t.Fatal(err)
+	}
+	if !acraCensor.checkBoundValues {
+		t.Fatal("check_bound_values must be turned on")
+	}
+	textValue := func(value string) BoundValue {
+		return BoundValue{Data: []byte(value)}
+	}
+	testCases := []struct {
+		query    string
+		values   []BoundValue
+		expected error
+	}{
+		{"SELECT * FROM users WHERE id = $1", []BoundValue{textValue("1")}, common.ErrDenyByQueryError},
+		{"SELECT * FROM users WHERE id = ?", []BoundValue{textValue("1")}, common.ErrDenyByQueryError},
+		{"SELECT * FROM users WHERE id = $1;", []BoundValue{textValue("2")}, nil},
+		{"INSERT INTO users (name, score) VALUES ($1, $2)", []BoundValue{textValue("admin"), textValue("1.5")}, common.ErrDenyByQueryError},
+		{"INSERT INTO users (name, score) VALUES ($2, $1)", []BoundValue{textValue("1.5"), textValue("admin")}, common.ErrDenyByQueryError},
+		{"INSERT INTO users (name, score) VALUES ($1, $2)", []BoundValue{textValue("user"), textValue("1.5")}, nil},
+		// NULL, binary values and placeholders without values aren't substituted
+		{"SELECT * FROM users WHERE id = $1", []BoundValue{{Data: nil}}, nil},
+		{"SELECT * FROM users WHERE id = $1", []BoundValue{{Data: []byte("1"), Binary: true}}, nil},
+		{"SELECT * FROM users WHERE id = $1", nil, nil},
+	}
+	for i, testCase := range testCases {
+		// query text of prepared statement is allowed
+		if err := acraCensor.HandleQuery(testCase.query); err != nil {
+			t.Fatalf("[%d] Unexpected error on prepared query: %s", i, err)
+		}
+		if err := acraCensor.HandleBoundQuery(testCase.query, testCase.values); err != testCase.expected {
+			t.Fatalf("[%d] Expected %v, took %v", i, testCase.expected, err)
+		}
+	}
+
+	acraCensor.checkBoundValues = false
+	if err := acraCensor.HandleBoundQuery(testCases[0].query, testCases[0].values); err != nil {
+		t.Fatalf("Bound values must not be checked if option is turned off, took %s", err)
+	}
+}
+
+func TestHandleQueryWithContext(t *testing.T) {
+	configuration := `version: 0.85.0
+check_bound_values: true
+handlers:
+  - handler: deny
+    queries:
+      - SELECT * FROM users WHERE id = 1
+  - handler: allowall
+`
+	acraCensor := NewAcraCensor()
+	defer acraCensor.ReleaseAll()
+	if err := acraCensor.LoadConfiguration([]byte(configuration)); err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	logger := log.New()
+	logger.SetOutput(output)
+	logger.SetFormatter(&log.JSONFormatter{})
+	ctx := logging.SetLoggerToContext(context.Background(), log.NewEntry(logger).WithField("client_address", "10.0.0.1:8080"))
+
+	if err := acraCensor.HandleQueryWithContext(ctx, "SELECT * FROM users WHERE id = 1"); err != common.ErrDenyByQueryError {
+		t.Fatalf("Expected %v, took %v", common.ErrDenyByQueryError, err)
+	}
+	if err := acraCensor.HandleBoundQueryWithContext(ctx, "SELECT * FROM users WHERE id = $1", []BoundValue{{Data: []byte("1")}}); err != common.ErrDenyByQueryError {
+		t.Fatalf("Expected %v, took %v", common.ErrDenyByQueryError, err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 denied queries in log, took %q", output.String())
+	}
+	for _, line := range lines {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["client_address"] != "10.0.0.1:8080" || entry["service"] != ServiceName {
+			t.Fatalf("Expected verdict logged with fields of context logger, took %s", line)
+		}
+	}
+}
+
+func TestBoundValueType(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected sqlparser.ValType
+	}{
+		{"1", sqlparser.IntVal},
+		{"-10", sqlparser.IntVal},
+		{"1.5", sqlparser.FloatVal},
+		{"1e3", sqlparser.FloatVal},
+		{"NaN", sqlparser.StrVal},
+		{"Infinity", sqlparser.StrVal},
+		{"text", sqlparser.StrVal},
+		{"", sqlparser.StrVal},
+	}
+	for _, testCase := range testCases {
+		if valType := boundValueType([]byte(testCase.value)); valType != testCase.expected {
+			t.Fatalf("Expected type %v of '%s', took %v", testCase.expected, testCase.value, valType)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acrawriter encrypts data on client-side into AcraStructs, AcraBlocks and searchable containers with keys
+// exported from the keystore of AcraServer. Writer uses encryptor config of AcraServer and the same encryptors as its
+// transparent encryption, so values encrypted by the application and by AcraServer are interchangeable: AcraServer
+// doesn't encrypt them twice, decrypts them and matches searchable hashes of them.
+//
+// Keys of clientID may be read with `acra-keys read` and passed with StaticKeyStore, or keystore directory may be
+// opened with filesystem.KeyStore which implements KeyStore too.
+package acrawriter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+
+	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Errors returned by Writer
+var (
+	ErrEncryptorConfigNotSet  = errors.New("encryptor config is not set")
+	ErrUnsupportedSetting     = errors.New("column setting is supported only by AcraServer")
+	ErrPrivateKeysUnavailable = errors.New("private keys are not available on client-side")
+)
+
+// KeyStore provides keys of clientIDs required for encryption on client-side
+type KeyStore interface {
+	keystore.PublicKeyStore
+	keystore.HmacKeyStore
+	GetClientIDSymmetricKey(id []byte) ([]byte, error)
+}
+
+// ClientIDKeys are keys of one clientID. SymmetricKey and HmacKey may be nil if AcraBlocks or searchable encryption
+// aren't used
+type ClientIDKeys struct {
+	StoragePublicKey *keys.PublicKey
+	SymmetricKey     []byte
+	HmacKey          []byte
+}
+
+// StaticKeyStore is KeyStore with keys of clientIDs kept in memory
+type StaticKeyStore struct {
+	keys map[string]ClientIDKeys
+}
+
+// NewStaticKeyStore returns empty StaticKeyStore
+func NewStaticKeyStore() *StaticKeyStore {
+	return &StaticKeyStore{keys: make(map[string]ClientIDKeys)}
+}
+
+// Add sets keys of clientID
+func (store *StaticKeyStore) Add(clientID []byte, keys ClientIDKeys) {
+	store.keys[string(clientID)] = keys
+}
+
+// GetClientIDEncryptionPublicKey returns storage public key of clientID
+func (store *StaticKeyStore) GetClientIDEncryptionPublicKey(clientID []byte) (*keys.PublicKey, error) {
+	clientKeys, ok := store.keys[string(clientID)]
+	if !ok || clientKeys.StoragePublicKey == nil {
+		return nil, keystore.ErrKeysNotFound
+	}
+	return clientKeys.StoragePublicKey, nil
+}
+
+// GetClientIDSymmetricKey returns copy of symmetric key of clientID, because encryptors zeroize keys after use
+func (store *StaticKeyStore) GetClientIDSymmetricKey(clientID []byte) ([]byte, error) {
+	clientKeys, ok := store.keys[string(clientID)]
+	if !ok || clientKeys.SymmetricKey == nil {
+		return nil, keystore.ErrKeysNotFound
+	}
+	return append([]byte{}, clientKeys.SymmetricKey...), nil
+}
+
+// GetHMACSecretKey returns copy of HMAC key of clientID, because encryptors zeroize keys after use
+func (store *StaticKeyStore) GetHMACSecretKey(clientID []byte) ([]byte, error) {
+	clientKeys, ok := store.keys[string(clientID)]
+	if !ok || clientKeys.HmacKey == nil {
+		return nil, keystore.ErrKeysNotFound
+	}
+	return append([]byte{}, clientKeys.HmacKey...), nil
+}
+
+// Zeroize removes keys of all clientIDs from memory
+func (store *StaticKeyStore) Zeroize() {
+	for clientID, clientKeys := range store.keys {
+		utils.ZeroizeSymmetricKey(clientKeys.SymmetricKey)
+		utils.ZeroizeSymmetricKey(clientKeys.HmacKey)
+		delete(store.keys, clientID)
+	}
+}
+
+// writerKeyStore adapts KeyStore to keystore interfaces of server-side encryptors. Private keys are never available,
+// so already encrypted values can't be decrypted to be hashed
+type writerKeyStore struct {
+	KeyStore
+}
+
+// GetClientIDSymmetricKeys returns the only symmetric key of clientID
+func (store writerKeyStore) GetClientIDSymmetricKeys(clientID []byte) ([][]byte, error) {
+	key, err := store.GetClientIDSymmetricKey(clientID)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{key}, nil
+}
+
+// GetServerDecryptionPrivateKey returns ErrPrivateKeysUnavailable
+func (store writerKeyStore) GetServerDecryptionPrivateKey(clientID []byte) (*keys.PrivateKey, error) {
+	return nil, ErrPrivateKeysUnavailable
+}
+
+// GetServerDecryptionPrivateKeys returns ErrPrivateKeysUnavailable
+func (store writerKeyStore) GetServerDecryptionPrivateKeys(clientID []byte) ([]*keys.PrivateKey, error) {
+	return nil, ErrPrivateKeysUnavailable
+}
+
+// Writer encrypts values of columns like transparent encryption of AcraServer with the same encryptor config
+type Writer struct {
+	keyStore        writerKeyStore
+	registryHandler crypto.RegistryHandler
+	dataEncryptor   encryptor.DataEncryptor
+	schemaStore     config.TableSchemaStore
+}
+
+// NewWriter returns Writer which encrypts with keys from keyStore. schemaStore may be nil if values are encrypted
+// only with EncryptWithClientID, CreateAcraStruct and CreateAcraBlock
+func NewWriter(keyStore KeyStore, schemaStore config.TableSchemaStore) (*Writer, error) {
+	// applications which don't run other Acra services have to initialize registry of crypto envelopes
+	if !crypto.IsRegistryInitialized() {
+		if err := crypto.InitRegistry(nil); err != nil {
+			return nil, err
+		}
+	}
+	writerStore := writerKeyStore{keyStore}
+	registryHandler := crypto.NewRegistryHandler(writerStore)
+	searchableEncryptor, err := hmac.NewSearchableEncryptor(writerStore, registryHandler, registryHandler)
+	if err != nil {
+		return nil, err
+	}
+	// the same order of encryptors as in AcraServer, only one of them processes the value
+	dataEncryptor := encryptor.NewChainDataEncryptor(crypto.NewEncryptHandler(registryHandler), searchableEncryptor)
+	return &Writer{keyStore: writerStore, registryHandler: registryHandler, dataEncryptor: dataEncryptor, schemaStore: schemaStore}, nil
+}
+
+// NewWriterFromEncryptorConfig returns Writer which uses encryptor config file of AcraServer
+func NewWriterFromEncryptorConfig(keyStore KeyStore, path string, useMySQL bool) (*Writer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schemaStore, err := config.MapTableSchemaStoreFromConfig(data, useMySQL)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(keyStore, schemaStore)
+}
+
+// CreateAcraStruct encrypts data with AcraStruct by storage public key of clientID
+func (writer *Writer) CreateAcraStruct(clientID, data []byte) ([]byte, error) {
+	return writer.createWithHandler(crypto.AcraStructEnvelopeID, clientID, data)
+}
+
+// CreateAcraBlock encrypts data with AcraBlock by symmetric key of clientID with default cipher
+func (writer *Writer) CreateAcraBlock(clientID, data []byte) ([]byte, error) {
+	return writer.createWithHandler(crypto.AcraBlockEnvelopeID, clientID, data)
+}
+
+func (writer *Writer) createWithHandler(envelopeID byte, clientID, data []byte) ([]byte, error) {
+	handler, err := crypto.GetHandlerByEnvelopeID(envelopeID)
+	if err != nil {
+		return nil, err
+	}
+	return writer.registryHandler.EncryptWithHandler(handler, clientID, data)
+}
+
+// SearchableHash returns hash of data which AcraServer compares with searchable values of clientID
+func (writer *Writer) SearchableHash(clientID, data []byte) ([]byte, error) {
+	key, err := writer.keyStore.GetHMACSecretKey(clientID)
+	if err != nil {
+		return nil, err
+	}
+	// GenerateHMAC zeroizes the key
+	return hmac.GenerateHMAC(key, data), nil
+}
+
+// EncryptWithClientID encrypts data according to setting of column. ClientID of setting overrides clientID, as in
+// AcraServer. Already encrypted values are returned as is
+func (writer *Writer) EncryptWithClientID(clientID, data []byte, setting config.ColumnEncryptionSetting) ([]byte, error) {
+	if setting.IsTokenized() || setting.IsPseudonymized() || setting.GetMaskingPattern() != "" {
+		return nil, fmt.Errorf("%w: column %s", ErrUnsupportedSetting, setting.ColumnName())
+	}
+	if settingClientID := setting.ClientID(); len(settingClientID) != 0 {
+		clientID = settingClientID
+	}
+	return writer.dataEncryptor.EncryptWithClientID(clientID, data, setting)
+}
+
+// EncryptColumn encrypts value of column according to encryptor config. Values of columns which aren't encrypted by
+// AcraServer are returned as is
+func (writer *Writer) EncryptColumn(clientID []byte, table, column string, data []byte) ([]byte, error) {
+	if writer.schemaStore == nil {
+		return nil, ErrEncryptorConfigNotSet
+	}
+	schema := writer.schemaStore.GetTableSchema(table)
+	if schema == nil {
+		return data, nil
+	}
+	setting := schema.GetColumnEncryptionSettings(column)
+	if setting == nil {
+		return data, nil
+	}
+	return writer.EncryptWithClientID(clientID, data, setting)
+}
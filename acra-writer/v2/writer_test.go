@@ -0,0 +1,224 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acrawriter
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+
+	"github.com/cossacklabs/acra/acrablock"
+	"github.com/cossacklabs/acra/acrastruct"
+	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
+)
+
+const testEncryptorConfig = `
+schemas:
+  - table: users
+    columns:
+      - id
+      - email
+      - name
+      - data
+      - token
+    encrypted:
+      - column: email
+        searchable: true
+      - column: name
+        crypto_envelope: acrablock
+      - column: data
+        crypto_envelope: acrastruct
+        client_id: other
+      - column: token
+        token_type: str
+`
+
+func newTestWriter(t *testing.T) (*Writer, map[string]*keys.Keypair, map[string]ClientIDKeys) {
+	keyStore := NewStaticKeyStore()
+	keypairs := make(map[string]*keys.Keypair)
+	clientKeys := make(map[string]ClientIDKeys)
+	for _, clientID := range []string{"client", "other"} {
+		keypair, err := keys.New(keys.TypeEC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symmetricKey, err := keystore.GenerateSymmetricKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hmacKey, err := keystore.GenerateSymmetricKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keypairs[clientID] = keypair
+		clientKeys[clientID] = ClientIDKeys{StoragePublicKey: keypair.Public, SymmetricKey: symmetricKey, HmacKey: hmacKey}
+		keyStore.Add([]byte(clientID), clientKeys[clientID])
+	}
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(testEncryptorConfig), config.UseMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewWriter(keyStore, schemaStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writer, keypairs, clientKeys
+}
+
+func deserialize(t *testing.T, data []byte, expectedEnvelopeID byte) []byte {
+	internal, envelopeID, err := crypto.DeserializeEncryptedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelopeID != expectedEnvelopeID {
+		t.Fatalf("Expected envelope %d, took %d", expectedEnvelopeID, envelopeID)
+	}
+	return internal
+}
+
+func TestWriterEncryptColumn(t *testing.T) {
+	writer, keypairs, clientKeys := newTestWriter(t)
+	data := []byte("some data")
+
+	encrypted, err := writer.EncryptColumn([]byte("client"), "users", "id", data)
+	if err != nil || !bytes.Equal(encrypted, data) {
+		t.Fatalf("Not encrypted column shouldn't be changed, took %v, %v", encrypted, err)
+	}
+	encrypted, err = writer.EncryptColumn([]byte("client"), "orders", "id", data)
+	if err != nil || !bytes.Equal(encrypted, data) {
+		t.Fatalf("Column of unknown table shouldn't be changed, took %v, %v", encrypted, err)
+	}
+
+	encrypted, err = writer.EncryptColumn([]byte("client"), "users", "name", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := acrablock.NewAcraBlockFromData(deserialize(t, encrypted, crypto.AcraBlockEnvelopeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := block.Decrypt([][]byte{clientKeys["client"].SymmetricKey}, nil)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected %s, took %s, %v", data, decrypted, err)
+	}
+	// already encrypted values are not encrypted twice, as in AcraServer
+	reEncrypted, err := writer.EncryptColumn([]byte("client"), "users", "name", encrypted)
+	if err != nil || !bytes.Equal(reEncrypted, encrypted) {
+		t.Fatal("Encrypted value shouldn't be encrypted again")
+	}
+
+	// client_id of column overrides clientID
+	encrypted, err = writer.EncryptColumn([]byte("client"), "users", "data", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = acrastruct.DecryptAcrastruct(deserialize(t, encrypted, crypto.AcraStructEnvelopeID), keypairs["other"].Private, nil)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected %s, took %s, %v", data, decrypted, err)
+	}
+
+	encrypted, err = writer.EncryptColumn([]byte("client"), "users", "email", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, container := hmac.ExtractHashAndData(encrypted)
+	if hash == nil {
+		t.Fatal("Expected searchable hash")
+	}
+	expectedHash, err := writer.SearchableHash([]byte("client"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash.Marshal(), expectedHash) {
+		t.Fatal("Hash of searchable value doesn't match SearchableHash")
+	}
+	decrypted, err = acrastruct.DecryptAcrastruct(deserialize(t, container, crypto.AcraStructEnvelopeID), keypairs["client"].Private, nil)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected %s, took %s, %v", data, decrypted, err)
+	}
+
+	if _, err = writer.EncryptColumn([]byte("client"), "users", "token", data); !errors.Is(err, ErrUnsupportedSetting) {
+		t.Fatalf("Expected ErrUnsupportedSetting, took %v", err)
+	}
+	if _, err = writer.EncryptColumn([]byte("unknown"), "users", "name", data); err == nil {
+		t.Fatal("Expected error for clientID without keys")
+	}
+}
+
+func TestWriterCreateContainers(t *testing.T) {
+	writer, keypairs, clientKeys := newTestWriter(t)
+	data := []byte("some data")
+
+	encrypted, err := writer.CreateAcraStruct([]byte("other"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := acrastruct.DecryptAcrastruct(deserialize(t, encrypted, crypto.AcraStructEnvelopeID), keypairs["other"].Private, nil)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected %s, took %s, %v", data, decrypted, err)
+	}
+
+	encrypted, err = writer.CreateAcraBlock([]byte("other"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := acrablock.NewAcraBlockFromData(deserialize(t, encrypted, crypto.AcraBlockEnvelopeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err = block.Decrypt([][]byte{clientKeys["other"].SymmetricKey}, nil)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Expected %s, took %s, %v", data, decrypted, err)
+	}
+
+	writer, err = NewWriter(NewStaticKeyStore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.EncryptColumn([]byte("client"), "users", "name", data); err != ErrEncryptorConfigNotSet {
+		t.Fatalf("Expected ErrEncryptorConfigNotSet, took %v", err)
+	}
+}
+
+func TestStaticKeyStore(t *testing.T) {
+	keyStore := NewStaticKeyStore()
+	key := []byte("key")
+	keyStore.Add([]byte("client"), ClientIDKeys{SymmetricKey: key, HmacKey: key})
+	if _, err := keyStore.GetClientIDEncryptionPublicKey([]byte("client")); err != keystore.ErrKeysNotFound {
+		t.Fatalf("Expected ErrKeysNotFound, took %v", err)
+	}
+	copied, err := keyStore.GetClientIDSymmetricKey([]byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied[0] = 0
+	if key[0] == 0 {
+		t.Fatal("Key store should return copies of keys")
+	}
+	keyStore.Zeroize()
+	if !bytes.Equal(key, []byte{0, 0, 0}) {
+		t.Fatal("Keys should be zeroized")
+	}
+	if _, err = keyStore.GetHMACSecretKey([]byte("client")); err != keystore.ErrKeysNotFound {
+		t.Fatalf("Expected ErrKeysNotFound, took %v", err)
+	}
+}
@@ -18,10 +18,14 @@ package acrablock
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
+
 	"github.com/cossacklabs/acra/acrastruct"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/cell"
@@ -51,6 +55,78 @@ const (
 // ErrInvalidAcraBlock defines invalid AcraBlock error
 var ErrInvalidAcraBlock = errors.New("invalid AcraBlock")
 
+// ErrUnsupportedFormatVersion returned for AcraBlocks with format version newer than accepted one
+var ErrUnsupportedFormatVersion = errors.New("unsupported AcraBlock format version")
+
+// FormatVersion is version of AcraBlock layout. It is stored in the most significant byte of the rest length which
+// is always zero in AcraBlocks created before versioning because they are shorter than 2^56 bytes
+type FormatVersion uint8
+
+// Set of known AcraBlock format versions
+const (
+	// FormatVersionLegacy used by AcraBlocks created before versioning
+	FormatVersionLegacy FormatVersion = iota
+	// FormatVersion1 has the same layout as FormatVersionLegacy with explicit version
+	FormatVersion1
+	// FormatVersion2 has metadata section after DataEncryptionKeyLength with key version hint and optional binding to
+	// associated data. Header, metadata and associated data are authenticated by encryption backends
+	FormatVersion2
+)
+
+// CurrentFormatVersion is the newest format version supported by this version of Acra
+const CurrentFormatVersion = FormatVersion2
+
+// DefaultWriterFormatVersion is format version of created AcraBlocks by default. Versions of Acra before versioning
+// read the whole rest length with explicit version as invalid length and pass such AcraBlocks through without
+// decryption, so FormatVersion1 and newer should be enabled only after every reader is upgraded
+const DefaultWriterFormatVersion = FormatVersionLegacy
+
+// maxRestAcraBlockLength is the max length of AcraBlock after TagBegin which doesn't overlap with format version
+const maxRestAcraBlockLength = 1<<56 - 1
+
+// maxAcceptedFormatVersion limits format versions of decrypted and created AcraBlocks
+var maxAcceptedFormatVersion = CurrentFormatVersion
+
+// SetMaxAcceptedFormatVersion configures the newest format version of AcraBlocks which are decrypted. AcraBlocks
+// of newer versions are refused with ErrUnsupportedFormatVersion. New AcraBlocks aren't created with newer version
+// to be readable by this instance. Should be called before processing of data
+func SetMaxAcceptedFormatVersion(version uint) error {
+	if version > uint(CurrentFormatVersion) {
+		return fmt.Errorf("%w: %d, the newest supported is %d", ErrUnsupportedFormatVersion, version, CurrentFormatVersion)
+	}
+	maxAcceptedFormatVersion = FormatVersion(version)
+	return nil
+}
+
+// MaxAcceptedFormatVersion returns the newest format version of AcraBlocks which are decrypted
+func MaxAcceptedFormatVersion() FormatVersion {
+	return maxAcceptedFormatVersion
+}
+
+// writerFormatVersion is format version of created AcraBlocks which aren't bound to associated data
+var writerFormatVersion = DefaultWriterFormatVersion
+
+// SetWriterFormatVersion configures format version of created AcraBlocks. It can't be newer than max accepted version,
+// so SetMaxAcceptedFormatVersion should be called first. Should be called before processing of data
+func SetWriterFormatVersion(version uint) error {
+	if version > uint(maxAcceptedFormatVersion) {
+		return fmt.Errorf("%w: %d, max accepted is %d", ErrUnsupportedFormatVersion, version, maxAcceptedFormatVersion)
+	}
+	writerFormatVersion = FormatVersion(version)
+	return nil
+}
+
+// WriterFormatVersion returns format version of created AcraBlocks which aren't bound to associated data
+func WriterFormatVersion() FormatVersion {
+	if writerFormatVersion > maxAcceptedFormatVersion {
+		return maxAcceptedFormatVersion
+	}
+	return writerFormatVersion
+}
+
+// ErrInvalidAES256Key used when key for AES-256 backend has invalid length
+var ErrInvalidAES256Key = errors.New("AES-256 key should be 32 bytes length")
+
 // SecureCellSymmetricBackend implement SymmetricBackend with SecureCell backend
 type SecureCellSymmetricBackend struct{}
 
@@ -72,6 +148,46 @@ func (s SecureCellSymmetricBackend) Decrypt(key []byte, data []byte, context []b
 	return seal.Decrypt(data, context)
 }
 
+// AES256GCMSymmetricBackend implement SymmetricBackend with AES-256-GCM. Random nonce is placed before ciphertext and
+// context is used as additional authenticated data
+type AES256GCMSymmetricBackend struct{}
+
+func newAES256GCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != SymmetricDataEncryptionKeyLength {
+		return nil, ErrInvalidAES256Key
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt AES256GCMSymmetricBackend implementation of SymmetricBackend interface for key and data encryption
+func (s AES256GCMSymmetricBackend) Encrypt(key []byte, data []byte, context []byte) ([]byte, error) {
+	aead, err := newAES256GCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, context), nil
+}
+
+// Decrypt AES256GCMSymmetricBackend implementation of SymmetricBackend interface for key and data decryption
+func (s AES256GCMSymmetricBackend) Decrypt(key []byte, data []byte, context []byte) ([]byte, error) {
+	aead, err := newAES256GCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidAcraBlock
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], context)
+}
+
 // KeyEncryptionBackendType used as storage for known backends to encrypt symmetric keys in AcraBLock
 type KeyEncryptionBackendType uint8
 
@@ -85,6 +201,7 @@ func (k KeyEncryptionBackendType) MarshalBinary() (data []byte, err error) {
 // Set of known backends for key encryption
 const (
 	KeyEncryptionBackendTypeSecureCell KeyEncryptionBackendType = iota
+	KeyEncryptionBackendTypeAES256GCM
 )
 
 const defaultKeyEncryptionBackendType = KeyEncryptionBackendTypeSecureCell
@@ -92,6 +209,7 @@ const defaultKeyEncryptionBackendType = KeyEncryptionBackendTypeSecureCell
 // map backend type value to implementation
 var keyEncryptionBackendTypeMap = map[KeyEncryptionBackendType]SymmetricBackend{
 	KeyEncryptionBackendTypeSecureCell: SecureCellSymmetricBackend{},
+	KeyEncryptionBackendTypeAES256GCM:  AES256GCMSymmetricBackend{},
 }
 
 // DataEncryptionBackendType used as storage for known backends to encrypt data in AcraBlock
@@ -107,12 +225,14 @@ func (t DataEncryptionBackendType) MarshalBinary() (data []byte, err error) {
 // Set of known backends for data encryption in AcraBlock
 const (
 	DataEncryptionBackendTypeSecureCell DataEncryptionBackendType = iota
+	DataEncryptionBackendTypeAES256GCM
 )
 const defaultDataEncryptionBackendType = DataEncryptionBackendTypeSecureCell
 
 // map backend type value to implementation
 var dataEncryptionBackendTypeMap = map[DataEncryptionBackendType]SymmetricBackend{
 	DataEncryptionBackendTypeSecureCell: SecureCellSymmetricBackend{},
+	DataEncryptionBackendTypeAES256GCM:  AES256GCMSymmetricBackend{},
 }
 
 // ErrDataEncryptionKeyGeneration used when can't generate random key with crypto.Rand
@@ -137,6 +257,7 @@ func (s Sha256KeyIDGenerator) GenerateKeyID(key, context []byte) ([]byte, error)
 var defaultKeyIDGenerator KeyIDGenerator = Sha256KeyIDGenerator{}
 
 // AcraBlock array of several parts: TagBegin[4] + LengthOfRestData[4] + KeyEncryptionKeyType[1] + KeyEncryptionKeyID[2] + DataEncryptionType[1] + DataEncryptionKeyLength[2] + EncryptedDataEncryptionKey[*] + EncryptedData[*]
+// AcraBlocks of FormatVersion2 have MetadataLength[2] + Metadata[*] before EncryptedDataEncryptionKey
 type AcraBlock []byte
 
 var tagBegin = acrastruct.TagBegin[:TagBeginSize]
@@ -179,13 +300,14 @@ func (b AcraBlock) getKeyEncryptionKeyID() ([]byte, error) {
 
 // setEncryptedDataEncryptionKey place key length and key into AcraBlock
 func (b AcraBlock) setEncryptedDataEncryptionKey(key []byte) error {
-	if len(b) < EncryptedDataEncryptionKeyPosition+len(key) {
+	keyPosition := b.encryptedDataEncryptionKeyPosition()
+	if len(b) < keyPosition+len(key) {
 		return ErrInvalidAcraBlock
 	}
 	buf := [8]byte{}
 	binary.LittleEndian.PutUint32(buf[:], uint32(len(key)))
 	copy(b[DataEncryptionKeyLengthPosition:DataEncryptionKeyLengthPosition+DataEncryptionKeyLengthSize], buf[:DataEncryptionKeyLengthSize])
-	copy(b[EncryptedDataEncryptionKeyPosition:EncryptedDataEncryptionKeyPosition+len(key)], key)
+	copy(b[keyPosition:keyPosition+len(key)], key)
 	return nil
 }
 
@@ -205,17 +327,19 @@ func (b AcraBlock) setEncryptedData(data []byte) error {
 	if len(b) < EncryptedDataEncryptionKeyPosition {
 		return ErrInvalidAcraBlock
 	}
+	keyPosition := b.encryptedDataEncryptionKeyPosition()
 	keySize := b.EncryptedDataEncryptionKeyLength()
-	if len(b) < EncryptedDataEncryptionKeyPosition+keySize {
+	if len(b) < keyPosition+keySize {
 		return ErrInvalidAcraBlock
 	}
-	if n := copy(b[EncryptedDataEncryptionKeyPosition+keySize:], data); n != len(data) {
+	if n := copy(b[keyPosition+keySize:], data); n != len(data) {
 		return ErrInvalidAcraBlock
 	}
 	return nil
 }
 
-// Build create final acraBlock by encryptedKey and encryptedData
+// Build create final acraBlock by encryptedKey and encryptedData. AcraBlocks with metadata keep FormatVersion2, others
+// are created with WriterFormatVersion limited by FormatVersion1
 func (b AcraBlock) Build(encryptedKey, encryptedData []byte) ([]byte, error) {
 	if err := b.setEncryptedDataEncryptionKey(encryptedKey); err != nil {
 		return nil, err
@@ -223,10 +347,21 @@ func (b AcraBlock) Build(encryptedKey, encryptedData []byte) ([]byte, error) {
 	if err := b.setEncryptedData(encryptedData); err != nil {
 		return nil, err
 	}
+	version := b.FormatVersion()
+	if version < FormatVersion2 {
+		version = WriterFormatVersion()
+		if version > FormatVersion1 {
+			version = FormatVersion1
+		}
+	}
 	sumLength := len(b) - TagBeginSize
+	if uint64(sumLength) > maxRestAcraBlockLength {
+		return nil, ErrInvalidAcraBlock
+	}
 	sumLengthBuf := [8]byte{}
 	binary.LittleEndian.PutUint64(sumLengthBuf[:], uint64(sumLength))
 	copy(b[TagBeginSize:TagBeginSize+RestAcraBlockLengthSize], sumLengthBuf[:RestAcraBlockLengthSize])
+	b[FormatVersionPosition] = byte(version)
 	return b, nil
 }
 
@@ -238,8 +373,15 @@ const (
 	DataEncryptionTypePosition         = KeyEncryptionKeyIDPosition + KeyEncryptionKeyIDSize
 	DataEncryptionKeyLengthPosition    = DataEncryptionTypePosition + DataEncryptionTypeSize
 	EncryptedDataEncryptionKeyPosition = DataEncryptionKeyLengthPosition + DataEncryptionKeyLengthSize
+	FormatVersionPosition              = RestAcraBlockLengthPosition + RestAcraBlockLengthSize - 1
+	MetadataLengthPosition             = DataEncryptionKeyLengthPosition + DataEncryptionKeyLengthSize
 )
 
+// FormatVersion returns version of AcraBlock format
+func (b AcraBlock) FormatVersion() FormatVersion {
+	return FormatVersion(b[FormatVersionPosition])
+}
+
 // KeyEncryptionBackend read SymmetricBackend by KeyEncryptionKeyTypePosition
 func (b AcraBlock) KeyEncryptionBackend() SymmetricBackend {
 	return keyEncryptionBackendTypeMap[KeyEncryptionBackendType(b[KeyEncryptionKeyTypePosition])]
@@ -257,33 +399,66 @@ func (b AcraBlock) EncryptedDataEncryptionKeyLength() int {
 
 // Decrypt AcraBlock using all keys sequentially until successful decryption and context
 func (b AcraBlock) Decrypt(keys [][]byte, context []byte) ([]byte, error) {
+	return b.DecryptWithAssociatedData(keys, context, nil)
+}
+
+// DecryptWithAssociatedData decrypt AcraBlock bound to associatedData using all keys sequentially until successful
+// decryption. associatedData is ignored for AcraBlocks which aren't bound to it, so binding may be turned on for columns
+// which already contain encrypted data
+func (b AcraBlock) DecryptWithAssociatedData(keys [][]byte, context, associatedData []byte) ([]byte, error) {
+	keyPosition := b.encryptedDataEncryptionKeyPosition()
 	keySize := b.EncryptedDataEncryptionKeyLength()
-	encryptedKey := b[EncryptedDataEncryptionKeyPosition : EncryptedDataEncryptionKeyPosition+keySize]
-	encryptedData := b[AcraBlockMinSize+keySize:]
+	if len(b) < keyPosition+keySize {
+		return nil, ErrInvalidAcraBlock
+	}
+	encryptedKey := b[keyPosition : keyPosition+keySize]
+	encryptedData := b[keyPosition+keySize:]
 	keyEncryptionKeyBackend := b.KeyEncryptionBackend()
 	dataEncryptionBackend := b.DataEncryptionBackend()
 	blockKeyID, err := b.getKeyEncryptionKeyID()
 	if err != nil {
 		return nil, err
 	}
+	encryptionContext := context
+	var keyVersionHint []byte
+	if b.FormatVersion() >= FormatVersion2 {
+		metadata, err := b.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		if !metadata.AssociatedData {
+			associatedData = nil
+		} else if associatedData == nil {
+			return nil, ErrMissingAssociatedData
+		}
+		if metadata.KeyHintAlgorithm == KeyHintAlgorithmSHA256 {
+			keyVersionHint = metadata.KeyVersionHint
+		}
+		encryptionContext = b.encryptionContext(context, associatedData)
+	}
 	var dataEncryptionKey []byte
 	for _, key := range keys {
 		keyID, err := Sha256KeyIDGenerator{}.GenerateKeyID(key, context)
 		if err != nil {
 			return nil, err
 		}
-		if bytes.Equal(keyID, blockKeyID) {
-			decryptedKey, err := keyEncryptionKeyBackend.Decrypt(key, encryptedKey, context)
-			if err == nil {
-				dataEncryptionKey = decryptedKey
-				break
-			}
+		if !bytes.Equal(keyID, blockKeyID) {
+			continue
+		}
+		// short KeyEncryptionKeyID may match several rotated keys, the hint allows to skip them without decryption
+		if keyVersionHint != nil && !bytes.Equal(generateKeyVersionHint(key, context), keyVersionHint) {
+			continue
+		}
+		decryptedKey, err := keyEncryptionKeyBackend.Decrypt(key, encryptedKey, encryptionContext)
+		if err == nil {
+			dataEncryptionKey = decryptedKey
+			break
 		}
 	}
 	if dataEncryptionKey == nil {
 		return nil, ErrInvalidAcraBlock
 	}
-	decryptedData, err := dataEncryptionBackend.Decrypt(dataEncryptionKey, encryptedData, context)
+	decryptedData, err := dataEncryptionBackend.Decrypt(dataEncryptionKey, encryptedData, encryptionContext)
 	utils.ZeroizeSymmetricKey(dataEncryptionKey)
 	if err != nil {
 		return nil, ErrInvalidAcraBlock
@@ -297,7 +472,8 @@ const (
 	validAcraBlockMask = 1 << 4
 )
 
-// ExtractAcraBlockFromData return AcraBlock that stored at start of data and return size in bytes of parsed AcraBlockLength
+// ExtractAcraBlockFromData return AcraBlock that stored at start of data and return size in bytes of parsed AcraBlockLength.
+// Returns error wrapping ErrUnsupportedFormatVersion with size of AcraBlock if its format version is newer than accepted
 func ExtractAcraBlockFromData(data []byte) (int, AcraBlock, error) {
 	if len(data) < AcraBlockMinSize {
 		return 0, nil, ErrInvalidAcraBlock
@@ -306,7 +482,7 @@ func ExtractAcraBlockFromData(data []byte) (int, AcraBlock, error) {
 	if bytes.Equal(data[:TagBeginSize], acrastruct.TagBegin[:TagBeginSize]) {
 		validMask <<= 1
 	}
-	restLength := binary.LittleEndian.Uint64(data[RestAcraBlockLengthPosition : RestAcraBlockLengthPosition+RestAcraBlockLengthSize])
+	restLength := binary.LittleEndian.Uint64(data[RestAcraBlockLengthPosition:RestAcraBlockLengthPosition+RestAcraBlockLengthSize]) & maxRestAcraBlockLength
 	if len(data) >= int(restLength+TagBeginSize) {
 		validMask <<= 1
 	}
@@ -322,8 +498,16 @@ func ExtractAcraBlockFromData(data []byte) (int, AcraBlock, error) {
 		return 0, nil, ErrInvalidAcraBlock
 	}
 	length := TagBeginSize + restLength
-	return int(length), AcraBlock(data[:length]), nil
-
+	// refuse valid AcraBlocks of newer format instead of misparsing them. Length is returned to let callers skip them
+	version := FormatVersion(data[FormatVersionPosition])
+	if version > maxAcceptedFormatVersion {
+		return int(length), nil, fmt.Errorf("%w: %d, max accepted is %d", ErrUnsupportedFormatVersion, version, maxAcceptedFormatVersion)
+	}
+	acraBlock := AcraBlock(data[:length])
+	if version >= FormatVersion2 && (length < MetadataLengthPosition+MetadataLengthSize || int(length) < acraBlock.encryptedDataEncryptionKeyPosition()) {
+		return 0, nil, ErrInvalidAcraBlock
+	}
+	return int(length), acraBlock, nil
 }
 
 // NewAcraBlockFromData expects that whole data is one AcraBlock, validate and return, otherwise error
@@ -343,6 +527,47 @@ func CreateAcraBlock(data []byte, key []byte, context []byte) ([]byte, error) {
 
 // CreateAcraBlockWithBackends create AcraBlock using specified encryption backends
 func CreateAcraBlockWithBackends(data []byte, key []byte, context []byte, keyEncryptionBackend KeyEncryptionBackendType, dataEncryptionBackend DataEncryptionBackendType) ([]byte, error) {
+	return CreateAcraBlockWithAssociatedData(data, key, context, nil, keyEncryptionBackend, dataEncryptionBackend)
+}
+
+// CreateAcraBlockWithAssociatedData create AcraBlock using specified encryption backends and bound to associatedData,
+// for example, table and column of the value. Such AcraBlock can be decrypted only with the same associatedData.
+// AcraBlocks with associatedData are always created in FormatVersion2, others use WriterFormatVersion
+func CreateAcraBlockWithAssociatedData(data, key, context, associatedData []byte, keyEncryptionBackend KeyEncryptionBackendType, dataEncryptionBackend DataEncryptionBackendType) ([]byte, error) {
+	version := WriterFormatVersion()
+	if associatedData != nil {
+		if maxAcceptedFormatVersion < FormatVersion2 {
+			return nil, fmt.Errorf("%w: binding to associated data requires %d, max accepted is %d", ErrUnsupportedFormatVersion, FormatVersion2, maxAcceptedFormatVersion)
+		}
+		version = FormatVersion2
+	}
+	var metadata []byte
+	if version >= FormatVersion2 {
+		metadata = Metadata{
+			KeyHintAlgorithm: KeyHintAlgorithmSHA256,
+			KeyVersionHint:   generateKeyVersionHint(key, context),
+			AssociatedData:   associatedData != nil,
+		}.Marshal()
+	}
+	// header with backend types, key ID and metadata is filled before encryption because it is authenticated in FormatVersion2
+	header := NewEmptyAcraBlock(AcraBlockMinSize + metadataSectionSize(metadata))
+	if err := header.SetKeyEncryptionKeyType(keyEncryptionBackend); err != nil {
+		return nil, err
+	}
+	if err := header.SetKeyEncryptionKeyID(key, context, defaultKeyIDGenerator); err != nil {
+		return nil, err
+	}
+	if err := header.SetDataEncryptionType(dataEncryptionBackend); err != nil {
+		return nil, err
+	}
+	encryptionContext := context
+	if metadata != nil {
+		if err := header.setMetadata(metadata); err != nil {
+			return nil, err
+		}
+		encryptionContext = header.encryptionContext(context, associatedData)
+	}
+
 	dataEncryptionKey := make([]byte, 32)
 	n, err := rand.Read(dataEncryptionKey)
 	if err != nil {
@@ -352,26 +577,18 @@ func CreateAcraBlockWithBackends(data []byte, key []byte, context []byte, keyEnc
 		return nil, ErrDataEncryptionKeyGeneration
 	}
 	dataEncryptor := dataEncryptionBackendTypeMap[dataEncryptionBackend]
-	encryptedData, err := dataEncryptor.Encrypt(dataEncryptionKey, data, context)
+	encryptedData, err := dataEncryptor.Encrypt(dataEncryptionKey, data, encryptionContext)
 	if err != nil {
 		return nil, err
 	}
 	keyEncryptionKeyEncryptor := keyEncryptionBackendTypeMap[keyEncryptionBackend]
-	encryptedDataEncryptionKey, err := keyEncryptionKeyEncryptor.Encrypt(key, dataEncryptionKey, context)
+	encryptedDataEncryptionKey, err := keyEncryptionKeyEncryptor.Encrypt(key, dataEncryptionKey, encryptionContext)
 	if err != nil {
 		return nil, err
 	}
 	utils.ZeroizeSymmetricKey(dataEncryptionKey)
 
-	acraBlock := NewEmptyAcraBlock(AcraBlockMinSize + len(encryptedData) + len(encryptedDataEncryptionKey))
-	if err := acraBlock.SetKeyEncryptionKeyType(keyEncryptionBackend); err != nil {
-		return nil, err
-	}
-	if err := acraBlock.SetKeyEncryptionKeyID(key, context, defaultKeyIDGenerator); err != nil {
-		return nil, err
-	}
-	if err := acraBlock.SetDataEncryptionType(dataEncryptionBackend); err != nil {
-		return nil, err
-	}
+	acraBlock := NewEmptyAcraBlock(len(header) + len(encryptedData) + len(encryptedDataEncryptionKey))
+	copy(acraBlock, header)
 	return acraBlock.Build(encryptedDataEncryptionKey, encryptedData)
 }
@@ -18,6 +18,7 @@ package acrablock
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"github.com/cossacklabs/acra/acrastruct"
 	"github.com/cossacklabs/acra/keystore"
@@ -302,6 +303,65 @@ func TestFailedDecryptionOnInvalidEncryptedData(t *testing.T) {
 	}
 }
 
+func TestAES256GCMSymmetricBackend(t *testing.T) {
+	backend := AES256GCMSymmetricBackend{}
+	key, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`some data`)
+	context := []byte(`some context`)
+	encrypted, err := backend.Encrypt(key, data, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := backend.Decrypt(key, encrypted, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("Decrypted data != source data")
+	}
+	if _, err := backend.Decrypt(key, encrypted, []byte(`other context`)); err == nil {
+		t.Fatal("Expect error on decryption with invalid context")
+	}
+	if _, err := backend.Decrypt(key, encrypted[:10], context); err != ErrInvalidAcraBlock {
+		t.Fatalf("Expect ErrInvalidAcraBlock on short data, took %v", err)
+	}
+	if _, err := backend.Encrypt(key[:16], data, context); err != ErrInvalidAES256Key {
+		t.Fatalf("Expect ErrInvalidAES256Key on short key, took %v", err)
+	}
+}
+
+func TestAcraBlockWithAES256GCMBackends(t *testing.T) {
+	key, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`some data`)
+	context := []byte(`some context`)
+	encrypted, err := CreateAcraBlockWithBackends(data, key, context, KeyEncryptionBackendTypeAES256GCM, DataEncryptionBackendTypeAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acraBlock, err := NewAcraBlockFromData(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := acraBlock.Decrypt([][]byte{key}, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("Decrypted data != source data")
+	}
+	// corrupt acraBlock by changing value of last byte
+	encrypted[len(encrypted)-1]++
+	if _, err := acraBlock.Decrypt([][]byte{key}, context); err != ErrInvalidAcraBlock {
+		t.Fatal("Expect ErrInvalidAcraBlock on decryption corrupted encrypted data with correct key")
+	}
+}
+
 type testKeyEncryptionBackend struct {
 	called int
 }
@@ -407,3 +467,317 @@ func TestPrefixedAcraBlockExtraction(t *testing.T) {
 		t.Fatal("Extracted AcraBlock != prefixed AcraBlock")
 	}
 }
+
+func TestAcraBlockFormatVersion(t *testing.T) {
+	testData := []byte(`test data`)
+	key := []byte(`key`)
+	encryptedData, err := CreateAcraBlock(testData, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := AcraBlock(encryptedData).FormatVersion(); version != DefaultWriterFormatVersion {
+		t.Fatalf("Expected %d version, took %d", DefaultWriterFormatVersion, version)
+	}
+
+	// AcraBlocks created before versioning should be decrypted
+	legacy := append([]byte{}, encryptedData...)
+	legacy[FormatVersionPosition] = byte(FormatVersionLegacy)
+	explicit := append([]byte{}, encryptedData...)
+	explicit[FormatVersionPosition] = byte(FormatVersion1)
+	// newer versions should be skipped with correct length to not break processing of the rest data
+	newer := append([]byte{}, encryptedData...)
+	newer[FormatVersionPosition] = byte(CurrentFormatVersion + 1)
+
+	testcases := []struct {
+		data []byte
+		err  error
+	}{
+		{encryptedData, nil},
+		{legacy, nil},
+		{explicit, nil},
+		{newer, ErrUnsupportedFormatVersion},
+	}
+	for i, tcase := range testcases {
+		n, acraBlock, err := ExtractAcraBlockFromData(tcase.data)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if n != len(encryptedData) {
+			t.Fatalf("[%d] Expected %d length, took %d", i, len(encryptedData), n)
+		}
+		if err != nil {
+			continue
+		}
+		decrypted, err := acraBlock.Decrypt([][]byte{key}, nil)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if !bytes.Equal(decrypted, testData) {
+			t.Fatalf("[%d] Decrypted data not equal to source data", i)
+		}
+	}
+}
+
+func TestSetMaxAcceptedFormatVersion(t *testing.T) {
+	defer SetMaxAcceptedFormatVersion(uint(CurrentFormatVersion))
+	defer SetWriterFormatVersion(uint(DefaultWriterFormatVersion))
+	if err := SetMaxAcceptedFormatVersion(uint(CurrentFormatVersion) + 1); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+	if MaxAcceptedFormatVersion() != CurrentFormatVersion {
+		t.Fatal("Max accepted version changed after invalid value")
+	}
+	if err := SetWriterFormatVersion(uint(FormatVersion1)); err != nil {
+		t.Fatal(err)
+	}
+	current, err := CreateAcraBlock([]byte(`test data`), []byte(`key`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pinned legacy version allows rollback to Acra which doesn't support versioning
+	if err := SetMaxAcceptedFormatVersion(uint(FormatVersionLegacy)); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := CreateAcraBlock([]byte(`test data`), []byte(`key`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := AcraBlock(legacy).FormatVersion(); version != FormatVersionLegacy {
+		t.Fatalf("Expected %d version, took %d", FormatVersionLegacy, version)
+	}
+	if _, _, err := ExtractAcraBlockFromData(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ExtractAcraBlockFromData(current); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+}
+
+// extractAcraBlockWithoutVersion parses AcraBlock like versions of Acra before versioning which read the whole rest
+// length field as length
+func extractAcraBlockWithoutVersion(data []byte) (int, AcraBlock, error) {
+	if len(data) < AcraBlockMinSize || !bytes.Equal(data[:TagBeginSize], acrastruct.TagBegin[:TagBeginSize]) {
+		return 0, nil, ErrInvalidAcraBlock
+	}
+	restLength := binary.LittleEndian.Uint64(data[RestAcraBlockLengthPosition : RestAcraBlockLengthPosition+RestAcraBlockLengthSize])
+	if uint64(len(data)) < restLength+TagBeginSize {
+		return 0, nil, ErrInvalidAcraBlock
+	}
+	length := TagBeginSize + restLength
+	return int(length), AcraBlock(data[:length]), nil
+}
+
+func TestDefaultFormatVersionReadableByUnversionedReaders(t *testing.T) {
+	defer SetWriterFormatVersion(uint(DefaultWriterFormatVersion))
+	testData := []byte(`test data`)
+	key := []byte(`key`)
+	// AcraBlocks created by default should be recognized by Acra before versioning
+	block, err := CreateAcraBlock(testData, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := AcraBlock(block).FormatVersion(); version != FormatVersionLegacy {
+		t.Fatalf("Expected %d version by default, took %d", FormatVersionLegacy, version)
+	}
+	n, acraBlock, err := extractAcraBlockWithoutVersion(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(block) {
+		t.Fatalf("Expected %d length, took %d", len(block), n)
+	}
+	if decrypted, err := acraBlock.Decrypt([][]byte{key}, nil); err != nil || !bytes.Equal(decrypted, testData) {
+		t.Fatalf("Expected decrypted data, took %v", err)
+	}
+
+	// explicit version is read as invalid length by unversioned readers, so FormatVersion1 is opt-in
+	if err := SetWriterFormatVersion(uint(FormatVersion1)); err != nil {
+		t.Fatal(err)
+	}
+	block, err = CreateAcraBlock(testData, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := extractAcraBlockWithoutVersion(block); err != ErrInvalidAcraBlock {
+		t.Fatalf("Expected ErrInvalidAcraBlock, took %v", err)
+	}
+	// readers pinned to legacy version refuse such AcraBlocks with their length instead of passing them through
+	defer SetMaxAcceptedFormatVersion(uint(CurrentFormatVersion))
+	if err := SetMaxAcceptedFormatVersion(uint(FormatVersionLegacy)); err != nil {
+		t.Fatal(err)
+	}
+	n, acraBlock, err = ExtractAcraBlockFromData(block)
+	if !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+	if n != len(block) || acraBlock != nil {
+		t.Fatalf("Expected refused AcraBlock with %d length, took %d", len(block), n)
+	}
+}
+
+func TestSetWriterFormatVersion(t *testing.T) {
+	defer SetMaxAcceptedFormatVersion(uint(CurrentFormatVersion))
+	defer SetWriterFormatVersion(uint(DefaultWriterFormatVersion))
+	if err := SetWriterFormatVersion(uint(CurrentFormatVersion) + 1); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+	if err := SetWriterFormatVersion(uint(FormatVersion2)); err != nil {
+		t.Fatal(err)
+	}
+	block, err := CreateAcraBlock([]byte(`test data`), []byte(`key`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := AcraBlock(block).FormatVersion(); version != FormatVersion2 {
+		t.Fatalf("Expected %d version, took %d", FormatVersion2, version)
+	}
+	// writer version is limited by max accepted one to keep created AcraBlocks readable
+	if err := SetMaxAcceptedFormatVersion(uint(FormatVersion1)); err != nil {
+		t.Fatal(err)
+	}
+	block, err = CreateAcraBlock([]byte(`test data`), []byte(`key`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := AcraBlock(block).FormatVersion(); version != FormatVersion1 {
+		t.Fatalf("Expected %d version, took %d", FormatVersion1, version)
+	}
+	if err := SetWriterFormatVersion(uint(FormatVersion2)); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+	if _, err := CreateAcraBlockWithAssociatedData([]byte(`test data`), []byte(`key`), nil, []byte(`table.column`), KeyEncryptionBackendTypeSecureCell, DataEncryptionBackendTypeSecureCell); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Expected ErrUnsupportedFormatVersion, took %v", err)
+	}
+}
+
+func TestAcraBlockFormatVersion2(t *testing.T) {
+	defer SetWriterFormatVersion(uint(DefaultWriterFormatVersion))
+	if err := SetWriterFormatVersion(uint(FormatVersion2)); err != nil {
+		t.Fatal(err)
+	}
+	testData := []byte(`test data`)
+	context := []byte(`context`)
+	rotatedKeys := [][]byte{[]byte(`new key`), []byte(`old key`)}
+	for _, backend := range []struct {
+		key  KeyEncryptionBackendType
+		data DataEncryptionBackendType
+	}{
+		{KeyEncryptionBackendTypeSecureCell, DataEncryptionBackendTypeSecureCell},
+		{KeyEncryptionBackendTypeAES256GCM, DataEncryptionBackendTypeAES256GCM},
+	} {
+		keys := rotatedKeys
+		if backend.key == KeyEncryptionBackendTypeAES256GCM {
+			keys = [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+		}
+		encrypted, err := CreateAcraBlockWithBackends(testData, keys[1], context, backend.key, backend.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acraBlock, err := NewAcraBlockFromData(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := acraBlock.FormatVersion(); version != FormatVersion2 {
+			t.Fatalf("Expected %d version, took %d", FormatVersion2, version)
+		}
+		metadata, err := acraBlock.Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.KeyHintAlgorithm != KeyHintAlgorithmSHA256 || !bytes.Equal(metadata.KeyVersionHint, generateKeyVersionHint(keys[1], context)) || metadata.AssociatedData {
+			t.Fatalf("Unexpected metadata %+v", metadata)
+		}
+		decrypted, err := acraBlock.Decrypt(keys, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, testData) {
+			t.Fatal("Decrypted data not equal to source data")
+		}
+		// associated data is ignored for AcraBlocks which aren't bound to it
+		if _, err := acraBlock.DecryptWithAssociatedData(keys, context, []byte(`table.column`)); err != nil {
+			t.Fatal(err)
+		}
+		// metadata and backend types are authenticated
+		tampered := append(AcraBlock{}, acraBlock...)
+		tampered[KeyEncryptionKeyIDPosition+KeyEncryptionKeyIDSize+DataEncryptionTypeSize+DataEncryptionKeyLengthSize+MetadataLengthSize+MetadataEntryTypeSize+MetadataEntryLengthSize] = 0xff
+		if _, err := tampered.Decrypt(keys, context); err != ErrInvalidAcraBlock {
+			t.Fatalf("Expected ErrInvalidAcraBlock, took %v", err)
+		}
+	}
+}
+
+func TestAcraBlockAssociatedData(t *testing.T) {
+	testData := []byte(`test data`)
+	key := []byte(`key`)
+	associatedData := []byte(`table.column`)
+	// binding uses FormatVersion2 regardless of writer version
+	encrypted, err := CreateAcraBlockWithAssociatedData(testData, key, nil, associatedData, KeyEncryptionBackendTypeSecureCell, DataEncryptionBackendTypeSecureCell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acraBlock, err := NewAcraBlockFromData(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := acraBlock.FormatVersion(); version != FormatVersion2 {
+		t.Fatalf("Expected %d version, took %d", FormatVersion2, version)
+	}
+	metadata, err := acraBlock.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.AssociatedData {
+		t.Fatal("AcraBlock is not marked as bound to associated data")
+	}
+	decrypted, err := acraBlock.DecryptWithAssociatedData([][]byte{key}, nil, associatedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, testData) {
+		t.Fatal("Decrypted data not equal to source data")
+	}
+	if _, err := acraBlock.Decrypt([][]byte{key}, nil); err != ErrMissingAssociatedData {
+		t.Fatalf("Expected ErrMissingAssociatedData, took %v", err)
+	}
+	if _, err := acraBlock.DecryptWithAssociatedData([][]byte{key}, nil, []byte(`table.other_column`)); err != ErrInvalidAcraBlock {
+		t.Fatalf("Expected ErrInvalidAcraBlock, took %v", err)
+	}
+}
+
+func TestParseInvalidMetadata(t *testing.T) {
+	testcases := [][]byte{
+		{byte(MetadataEntryKeyVersionHint)},
+		{byte(MetadataEntryKeyVersionHint), 2, 0, 1},
+		{byte(MetadataEntryKeyVersionHint), 0, 0},
+	}
+	for i, tcase := range testcases {
+		if _, err := ParseMetadata(tcase); err != ErrInvalidAcraBlock {
+			t.Fatalf("[%d] Expected ErrInvalidAcraBlock, took %v", i, err)
+		}
+	}
+	// unknown entries are skipped
+	metadata, err := ParseMetadata([]byte{0xff, 1, 0, 1, byte(MetadataEntryAssociatedData), 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.AssociatedData {
+		t.Fatal("Expected parsed associated data entry")
+	}
+
+	// AcraBlock with metadata length out of its bounds is invalid
+	defer SetWriterFormatVersion(uint(DefaultWriterFormatVersion))
+	if err := SetWriterFormatVersion(uint(FormatVersion2)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := CreateAcraBlock([]byte(`test data`), []byte(`key`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted[MetadataLengthPosition] = 0xff
+	encrypted[MetadataLengthPosition+1] = 0xff
+	if _, _, err := ExtractAcraBlockFromData(encrypted); err != ErrInvalidAcraBlock {
+		t.Fatalf("Expected ErrInvalidAcraBlock, took %v", err)
+	}
+}
@@ -2,6 +2,9 @@ package acrablock
 
 import (
 	"context"
+	"errors"
+	"fmt"
+
 	"github.com/cossacklabs/acra/acrastruct"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
@@ -37,8 +40,8 @@ func (d *DataEncryptor) EncryptWithClientID(clientID, data []byte, setting confi
 	if d.needSkipEncryptionFunc(setting) {
 		return data, nil
 	}
-	// skip already encrypted AcraBlock
-	if _, _, err := ExtractAcraBlockFromData(data); err == nil {
+	// skip already encrypted AcraBlock, including AcraBlocks of newer format
+	if _, _, err := ExtractAcraBlockFromData(data); err == nil || errors.Is(err, ErrUnsupportedFormatVersion) {
 		return data, nil
 	}
 	if setting.ShouldReEncryptAcraStructToAcraBlock() {
@@ -58,5 +61,31 @@ func (d *DataEncryptor) EncryptWithClientID(clientID, data []byte, setting confi
 	if err != nil {
 		return data, err
 	}
-	return CreateAcraBlock(data, keys, nil)
+	return CreateAcraBlockWithSetting(data, keys, nil, setting)
+}
+
+// ErrUnsupportedAcraBlockCipher used when encryptor config's cipher has no matched AcraBlock backends
+var ErrUnsupportedAcraBlockCipher = errors.New("unsupported AcraBlock cipher")
+
+// backend types used for encryption with ciphers configured in encryptor config
+var cipherBackendTypes = map[config.AcraBlockCipherType]struct {
+	keyEncryption  KeyEncryptionBackendType
+	dataEncryption DataEncryptionBackendType
+}{
+	config.AcraBlockCipherTypeSecureCell: {KeyEncryptionBackendTypeSecureCell, DataEncryptionBackendTypeSecureCell},
+	config.AcraBlockCipherTypeAES256GCM:  {KeyEncryptionBackendTypeAES256GCM, DataEncryptionBackendTypeAES256GCM},
+}
+
+// CreateAcraBlockWithSetting create AcraBlock using cipher configured for the column. Uses default backends if setting is nil.
+// Decryption doesn't depend on cipher because AcraBlock stores types of used backends, but AcraBlocks bound to the column
+// require the same setting on decryption
+func CreateAcraBlockWithSetting(data, key, context []byte, setting config.ColumnEncryptionSetting) ([]byte, error) {
+	if setting == nil {
+		return CreateAcraBlock(data, key, context)
+	}
+	backends, ok := cipherBackendTypes[setting.GetAcraBlockCipher()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAcraBlockCipher, setting.GetAcraBlockCipher())
+	}
+	return CreateAcraBlockWithAssociatedData(data, key, context, setting.GetAcraBlockAssociatedData(), backends.keyEncryption, backends.dataEncryption)
 }
@@ -267,3 +267,49 @@ func TestFailedDataEncryptionOnEmptyKeys(t *testing.T) {
 		t.Fatal("Expect that data result will be same as source data")
 	}
 }
+
+func TestDataEncryptionWithAcraBlockCipher(t *testing.T) {
+	keyStore := mocks.ServerKeyStore{}
+	dataEncryptor, err := NewDataEncryptor(&keyStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symKey := bytes.Repeat([]byte{1}, keystore.SymmetricKeyLength)
+	clientID := []byte(`clientid`)
+	keyStore.On("GetClientIDSymmetricKey", clientID).Return(symKey, nil)
+	envelopeType := config.CryptoEnvelopeTypeAcraBlock
+	testcases := []struct {
+		cipher         config.AcraBlockCipherType
+		keyEncryption  KeyEncryptionBackendType
+		dataEncryption DataEncryptionBackendType
+	}{
+		{config.AcraBlockCipherTypeSecureCell, KeyEncryptionBackendTypeSecureCell, DataEncryptionBackendTypeSecureCell},
+		{config.AcraBlockCipherTypeAES256GCM, KeyEncryptionBackendTypeAES256GCM, DataEncryptionBackendTypeAES256GCM},
+	}
+	testData := []byte(`test data`)
+	for _, tcase := range testcases {
+		cipher := tcase.cipher
+		setting := &config.BasicColumnEncryptionSetting{CryptoEnvelope: &envelopeType, AcraBlockCipher: &cipher}
+		encrypted, err := dataEncryptor.EncryptWithClientID(clientID, testData, setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acraBlock, err := NewAcraBlockFromData(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if KeyEncryptionBackendType(acraBlock[KeyEncryptionKeyTypePosition]) != tcase.keyEncryption {
+			t.Fatalf("[%s] Unexpected key encryption backend %d", cipher, acraBlock[KeyEncryptionKeyTypePosition])
+		}
+		if DataEncryptionBackendType(acraBlock[DataEncryptionTypePosition]) != tcase.dataEncryption {
+			t.Fatalf("[%s] Unexpected data encryption backend %d", cipher, acraBlock[DataEncryptionTypePosition])
+		}
+		decrypted, err := acraBlock.Decrypt([][]byte{symKey}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, testData) {
+			t.Fatalf("[%s] Decrypted data != testData", cipher)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acrablock
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Set of constants with sizes of metadata of AcraBlocks of FormatVersion2
+const (
+	MetadataLengthSize      = 2
+	MetadataEntryTypeSize   = 1
+	MetadataEntryLengthSize = 2
+	KeyHintAlgorithmSize    = 1
+	KeyVersionHintSize      = 8
+	maxMetadataLength       = 1<<(8*MetadataLengthSize) - 1
+)
+
+// ErrMissingAssociatedData returned on decryption of AcraBlock bound to associated data without it
+var ErrMissingAssociatedData = errors.New("AcraBlock is bound to associated data which wasn't passed")
+
+// MetadataEntryType is type of entry in metadata of AcraBlock. Each entry is stored as Type[1] + Length[2] + Value[*]
+type MetadataEntryType uint8
+
+// Set of known metadata entries. Unknown entries are skipped by parser but still authenticated on decryption
+const (
+	// MetadataEntryKeyVersionHint stores KeyHintAlgorithm[1] + hint of key encryption key. Unlike 2 bytes of
+	// KeyEncryptionKeyID it is long enough to select right key among rotated ones without trial decryptions
+	MetadataEntryKeyVersionHint MetadataEntryType = iota + 1
+	// MetadataEntryAssociatedData marks AcraBlocks bound to associated data. Associated data itself is not stored
+	// and should be passed on decryption
+	MetadataEntryAssociatedData
+)
+
+// KeyHintAlgorithm identifies algorithm used to generate key version hint
+type KeyHintAlgorithm uint8
+
+// Set of known algorithms of key version hint
+const (
+	// KeyHintAlgorithmSHA256 uses first KeyVersionHintSize bytes of sha256 of key and context
+	KeyHintAlgorithmSHA256 KeyHintAlgorithm = iota + 1
+)
+
+// Metadata of AcraBlock of FormatVersion2
+type Metadata struct {
+	KeyHintAlgorithm KeyHintAlgorithm
+	KeyVersionHint   []byte
+	// AssociatedData is true if AcraBlock is bound to associated data
+	AssociatedData bool
+}
+
+// Marshal encodes metadata as list of entries
+func (m Metadata) Marshal() []byte {
+	var output []byte
+	if m.KeyVersionHint != nil {
+		value := append([]byte{byte(m.KeyHintAlgorithm)}, m.KeyVersionHint...)
+		output = appendMetadataEntry(output, MetadataEntryKeyVersionHint, value)
+	}
+	if m.AssociatedData {
+		output = appendMetadataEntry(output, MetadataEntryAssociatedData, nil)
+	}
+	return output
+}
+
+func appendMetadataEntry(output []byte, entryType MetadataEntryType, value []byte) []byte {
+	lengthBuf := [MetadataEntryLengthSize]byte{}
+	binary.LittleEndian.PutUint16(lengthBuf[:], uint16(len(value)))
+	output = append(output, byte(entryType))
+	output = append(output, lengthBuf[:]...)
+	return append(output, value...)
+}
+
+// ParseMetadata decodes list of metadata entries
+func ParseMetadata(data []byte) (Metadata, error) {
+	metadata := Metadata{}
+	for len(data) > 0 {
+		if len(data) < MetadataEntryTypeSize+MetadataEntryLengthSize {
+			return Metadata{}, ErrInvalidAcraBlock
+		}
+		entryType := MetadataEntryType(data[0])
+		length := int(binary.LittleEndian.Uint16(data[MetadataEntryTypeSize : MetadataEntryTypeSize+MetadataEntryLengthSize]))
+		data = data[MetadataEntryTypeSize+MetadataEntryLengthSize:]
+		if len(data) < length {
+			return Metadata{}, ErrInvalidAcraBlock
+		}
+		value := data[:length]
+		data = data[length:]
+		switch entryType {
+		case MetadataEntryKeyVersionHint:
+			if len(value) < KeyHintAlgorithmSize {
+				return Metadata{}, ErrInvalidAcraBlock
+			}
+			metadata.KeyHintAlgorithm = KeyHintAlgorithm(value[0])
+			metadata.KeyVersionHint = value[KeyHintAlgorithmSize:]
+		case MetadataEntryAssociatedData:
+			metadata.AssociatedData = true
+		}
+	}
+	return metadata, nil
+}
+
+// generateKeyVersionHint returns hint of key with KeyHintAlgorithmSHA256
+func generateKeyVersionHint(key, context []byte) []byte {
+	h := sha256.New()
+	h.Write(key)
+	h.Write(context)
+	return h.Sum(nil)[:KeyVersionHintSize]
+}
+
+// metadataSectionSize returns size of metadata with its length
+func metadataSectionSize(metadata []byte) int {
+	if metadata == nil {
+		return 0
+	}
+	return MetadataLengthSize + len(metadata)
+}
+
+// metadataLength returns size of metadata section, AcraBlocks of formats older than FormatVersion2 have no metadata
+func (b AcraBlock) metadataLength() int {
+	if len(b) < MetadataLengthPosition+MetadataLengthSize || b.FormatVersion() < FormatVersion2 {
+		return 0
+	}
+	return MetadataLengthSize + int(binary.LittleEndian.Uint16(b[MetadataLengthPosition:MetadataLengthPosition+MetadataLengthSize]))
+}
+
+// encryptedDataEncryptionKeyPosition returns position of encrypted key which follows metadata in FormatVersion2
+func (b AcraBlock) encryptedDataEncryptionKeyPosition() int {
+	return EncryptedDataEncryptionKeyPosition + b.metadataLength()
+}
+
+// setMetadata places metadata after DataEncryptionKeyLength and marks AcraBlock as FormatVersion2
+func (b AcraBlock) setMetadata(metadata []byte) error {
+	if len(metadata) > maxMetadataLength || len(b) < MetadataLengthPosition+metadataSectionSize(metadata) {
+		return ErrInvalidAcraBlock
+	}
+	binary.LittleEndian.PutUint16(b[MetadataLengthPosition:MetadataLengthPosition+MetadataLengthSize], uint16(len(metadata)))
+	copy(b[MetadataLengthPosition+MetadataLengthSize:], metadata)
+	b[FormatVersionPosition] = byte(FormatVersion2)
+	return nil
+}
+
+// Metadata returns parsed metadata of AcraBlock. AcraBlocks of formats older than FormatVersion2 have empty metadata
+func (b AcraBlock) Metadata() (Metadata, error) {
+	length := b.metadataLength()
+	if length == 0 {
+		return Metadata{}, nil
+	}
+	if len(b) < MetadataLengthPosition+length {
+		return Metadata{}, ErrInvalidAcraBlock
+	}
+	return ParseMetadata(b[MetadataLengthPosition+MetadataLengthSize : MetadataLengthPosition+length])
+}
+
+// IsBoundToAssociatedData returns true if AcraBlock can be decrypted only with associated data
+func (b AcraBlock) IsBoundToAssociatedData() bool {
+	metadata, err := b.Metadata()
+	return err == nil && metadata.AssociatedData
+}
+
+// encryptionContext returns context used by encryption backends for AcraBlocks of FormatVersion2. It authenticates
+// backend types, key ID, metadata and associated data, so they can't be changed or swapped between AcraBlocks
+func (b AcraBlock) encryptionContext(context, associatedData []byte) []byte {
+	header := b[KeyEncryptionKeyTypePosition:DataEncryptionKeyLengthPosition]
+	metadata := b[MetadataLengthPosition : MetadataLengthPosition+b.metadataLength()]
+	output := make([]byte, 0, len(context)+len(header)+len(metadata)+len(associatedData))
+	output = append(output, context...)
+	output = append(output, header...)
+	output = append(output, metadata...)
+	return append(output, associatedData...)
+}
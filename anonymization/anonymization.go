@@ -0,0 +1,240 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package anonymization implements aggregation-only mode for analytics clients. Decrypted values of configured
+// columns are never returned to connections of these clients, they are replaced with generalized values (like
+// bucketed ages or truncated zip codes) or suppressed. Each value is generalized on its own, the result set isn't
+// checked to have at least k rows with equal values, so the policy doesn't guarantee k-anonymity of the result.
+package anonymization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config/common"
+	"github.com/cossacklabs/acra/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// Generalizations supported by the config
+const (
+	// GeneralizationBucket replaces integer with lower bound of its bucket
+	GeneralizationBucket = "bucket"
+	// GeneralizationTruncate keeps prefix of value and masks the rest
+	GeneralizationTruncate = "truncate"
+	// GeneralizationSuppress replaces value with constant
+	GeneralizationSuppress = "suppress"
+)
+
+// truncateMask replaces characters removed by truncation, so generalized value keeps the length of the source value
+const truncateMask = '*'
+
+// Errors returned on loading of anonymization config
+var (
+	ErrNoClientIDs              = errors.New("anonymization config doesn't contain client_ids")
+	ErrNoColumns                = errors.New("anonymization config doesn't contain columns")
+	ErrNoTable                  = errors.New("column config doesn't contain table")
+	ErrDuplicateColumn          = errors.New("column is configured several times")
+	ErrUnknownGeneralization    = errors.New("unknown generalization")
+	ErrInvalidGeneralizationArg = errors.New("invalid option of generalization")
+)
+
+// ColumnConfig describes generalization of one column from encryptor config
+type ColumnConfig struct {
+	Table          string `yaml:"table"`
+	Column         string `yaml:"column"`
+	Generalization string `yaml:"generalization"`
+	// BucketSize is width of buckets used by GeneralizationBucket
+	BucketSize int64 `yaml:"bucket_size"`
+	// KeepPrefix is count of characters left by GeneralizationTruncate
+	KeepPrefix int `yaml:"keep_prefix"`
+	// Replacement is returned by GeneralizationSuppress and for values which can't be generalized, empty by default.
+	// SQL NULL is returned instead if it isn't a valid value of the column type
+	Replacement string `yaml:"replacement"`
+}
+
+func (config ColumnConfig) validate() error {
+	if config.Table == "" {
+		return fmt.Errorf("%w: %s", ErrNoTable, config.Column)
+	}
+	switch config.Generalization {
+	case GeneralizationBucket:
+		if config.BucketSize <= 0 {
+			return fmt.Errorf("%w: bucket_size of column %s should be greater than 0", ErrInvalidGeneralizationArg, config.name())
+		}
+		if _, err := strconv.ParseInt(config.Replacement, 10, 64); config.Replacement != "" && err != nil {
+			return fmt.Errorf("%w: replacement of integer column %s should be integer", ErrInvalidGeneralizationArg, config.name())
+		}
+	case GeneralizationTruncate:
+		if config.KeepPrefix < 0 {
+			return fmt.Errorf("%w: keep_prefix of column %s can't be negative", ErrInvalidGeneralizationArg, config.name())
+		}
+	case GeneralizationSuppress:
+	default:
+		return fmt.Errorf("%w: %s of column %s", ErrUnknownGeneralization, config.Generalization, config.name())
+	}
+	return nil
+}
+
+func (config ColumnConfig) name() string {
+	return config.Table + "." + config.Column
+}
+
+// columnKey identifies configured column, rules of columns with the same name in other tables don't match it
+type columnKey struct {
+	table  string
+	column string
+}
+
+// FileConfig is the structure of anonymization config file
+type FileConfig struct {
+	// ClientIDs of analytics clients which receive only anonymized values
+	ClientIDs []string       `yaml:"client_ids"`
+	Columns   []ColumnConfig `yaml:"columns"`
+}
+
+// Policy is base.DecryptionSubscriber which generalizes values of configured columns for connections of analytics
+// clientIDs. ClientID is taken from access context of each value because it may be changed after TLS handshake.
+// Policy should be subscribed after decryption and transforms, right before encoding of values for the client
+type Policy struct {
+	clientIDs map[string]bool
+	columns   map[columnKey]ColumnConfig
+}
+
+// NewPolicy validates config and returns Policy
+func NewPolicy(config FileConfig) (*Policy, error) {
+	if len(config.ClientIDs) == 0 {
+		return nil, ErrNoClientIDs
+	}
+	if len(config.Columns) == 0 {
+		return nil, ErrNoColumns
+	}
+	clientIDs := make(map[string]bool, len(config.ClientIDs))
+	for _, clientID := range config.ClientIDs {
+		clientIDs[clientID] = true
+	}
+	columns := make(map[columnKey]ColumnConfig, len(config.Columns))
+	for _, column := range config.Columns {
+		if err := column.validate(); err != nil {
+			return nil, err
+		}
+		key := columnKey{table: column.Table, column: column.Column}
+		if _, ok := columns[key]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateColumn, column.name())
+		}
+		columns[key] = column
+	}
+	return &Policy{clientIDs: clientIDs, columns: columns}, nil
+}
+
+// NewPolicyFromFile reads config file and creates Policy
+func NewPolicyFromFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := FileConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	return NewPolicy(config)
+}
+
+// ID returns name of subscriber
+func (policy *Policy) ID() string {
+	return "AnonymizationPolicy"
+}
+
+// OnColumn replaces values of configured columns with generalized values for analytics clientIDs. Values which
+// weren't decrypted are suppressed too, so analytics clients receive neither ciphertext nor values which can't be
+// generalized. Columns are matched by table and name, so values of columns with unknown table aren't changed
+func (policy *Policy) OnColumn(ctx context.Context, data []byte) (context.Context, []byte, error) {
+	if !policy.clientIDs[string(base.AccessContextFromContext(ctx).GetClientID())] {
+		return ctx, data, nil
+	}
+	setting, ok := encryptor.EncryptionSettingFromContext(ctx)
+	if !ok {
+		return ctx, data, nil
+	}
+	column, ok := policy.columns[columnKey{table: encryptor.TableNameFromContext(ctx), column: setting.ColumnName()}]
+	if !ok {
+		return ctx, data, nil
+	}
+	if !base.IsDecryptedFromContext(ctx) || column.Generalization == GeneralizationSuppress {
+		return suppress(ctx, column, setting.GetDBDataTypeID())
+	}
+	generalized, err := generalize(column, data)
+	if err != nil {
+		logging.GetLoggerFromContext(ctx).WithError(err).WithField("column", column.name()).
+			Warningln("Can't generalize value, suppress it")
+		return suppress(ctx, column, setting.GetDBDataTypeID())
+	}
+	return ctx, generalized, nil
+}
+
+// suppress returns replacement of the column or marks value as SQL NULL if replacement can't be encoded as value of
+// the column type, so the client doesn't receive malformed values in binary format
+func suppress(ctx context.Context, column ColumnConfig, dataTypeID uint32) (context.Context, []byte, error) {
+	dataType, ok := common.PostgreSQLDataTypeIDEncryptedType[dataTypeID]
+	if !ok {
+		dataType = common.MySQLDataTypeIDEncryptedType[dataTypeID]
+	}
+	bitSize := 0
+	switch dataType {
+	case "int32":
+		bitSize = 32
+	case "int64":
+		bitSize = 64
+	}
+	if bitSize != 0 {
+		if _, err := strconv.ParseInt(column.Replacement, 10, bitSize); err != nil {
+			return base.MarkNullValueContext(ctx), nil, nil
+		}
+	}
+	return ctx, []byte(column.Replacement), nil
+}
+
+func generalize(column ColumnConfig, data []byte) ([]byte, error) {
+	switch column.Generalization {
+	case GeneralizationBucket:
+		value, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		lowerBound := value - value%column.BucketSize
+		// remainder has sign of dividend, negative values belong to the bucket below
+		if value%column.BucketSize < 0 {
+			lowerBound -= column.BucketSize
+		}
+		return strconv.AppendInt(nil, lowerBound, 10), nil
+	case GeneralizationTruncate:
+		if !utf8.Valid(data) {
+			return nil, errors.New("value isn't valid UTF-8 string")
+		}
+		chars := []rune(string(data))
+		for i := column.KeepPrefix; i < len(chars); i++ {
+			chars[i] = truncateMask
+		}
+		return []byte(string(chars)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownGeneralization, column.Generalization)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package anonymization
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestNewPolicyFromFile(t *testing.T) {
+	testcases := []struct {
+		config string
+		err    error
+	}{
+		{"columns:\n  - table: users\n    column: age\n    generalization: suppress\n", ErrNoClientIDs},
+		{"client_ids: [analytics]\n", ErrNoColumns},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: round\n", ErrUnknownGeneralization},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: bucket\n", ErrInvalidGeneralizationArg},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: zip\n    generalization: truncate\n    keep_prefix: -1\n", ErrInvalidGeneralizationArg},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: suppress\n  - table: users\n    column: age\n    generalization: suppress\n", ErrDuplicateColumn},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: bucket\n    bucket_size: 10\n    replacement: unknown\n", ErrInvalidGeneralizationArg},
+		{"client_ids: [analytics]\ncolumns:\n  - column: age\n    generalization: suppress\n", ErrNoTable},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: suppress\n  - table: orders\n    column: age\n    generalization: suppress\n", nil},
+		{"client_ids: [analytics]\ncolumns:\n  - table: users\n    column: age\n    generalization: bucket\n    bucket_size: 10\n", nil},
+	}
+	for i, tcase := range testcases {
+		path := filepath.Join(t.TempDir(), "anonymization.yaml")
+		if err := os.WriteFile(path, []byte(tcase.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewPolicyFromFile(path); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy(FileConfig{
+		ClientIDs: []string{"analytics"},
+		Columns: []ColumnConfig{
+			{Table: "users", Column: "age", Generalization: GeneralizationBucket, BucketSize: 10},
+			{Table: "users", Column: "zip", Generalization: GeneralizationTruncate, KeepPrefix: 3},
+			{Table: "users", Column: "ssn", Generalization: GeneralizationSuppress, Replacement: "<suppressed>"},
+			{Table: "users", Column: "score", Generalization: GeneralizationBucket, BucketSize: 100},
+			{Table: "users", Column: "level", Generalization: GeneralizationSuppress, Replacement: "-1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// score and level are typed integer columns
+	dataTypeIDs := map[string]uint32{"score": pgtype.Int4OID, "level": pgtype.Int8OID}
+	newContext := func(clientID, table, column string, decrypted bool) context.Context {
+		ctx := base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID([]byte(clientID))))
+		ctx = encryptor.NewContextWithEncryptionSetting(ctx, &config.BasicColumnEncryptionSetting{Name: column, DataTypeID: dataTypeIDs[column]})
+		ctx = encryptor.NewContextWithTableName(ctx, table)
+		if decrypted {
+			ctx = base.MarkDecryptedContext(ctx)
+		}
+		return ctx
+	}
+	testcases := []struct {
+		clientID  string
+		table     string
+		column    string
+		decrypted bool
+		data      string
+		expected  string
+		null      bool
+	}{
+		{"analytics", "users", "age", true, "37", "30", false},
+		{"analytics", "users", "age", true, "40", "40", false},
+		{"analytics", "users", "age", true, "-5", "-10", false},
+		{"analytics", "users", "zip", true, "94107", "941**", false},
+		{"analytics", "users", "zip", true, "12", "12", false},
+		{"analytics", "users", "ssn", true, "123-45-6789", "<suppressed>", false},
+		{"analytics", "users", "score", true, "250", "200", false},
+		{"analytics", "users", "level", true, "3", "-1", false},
+		// values which can't be generalized are suppressed
+		{"analytics", "users", "age", true, "unknown", "", false},
+		{"analytics", "users", "age", false, "ciphertext", "", false},
+		// empty replacement isn't valid integer, so values of integer columns are suppressed as NULL
+		{"analytics", "users", "score", true, "unknown", "", true},
+		{"analytics", "users", "score", false, "ciphertext", "", true},
+		// other columns, tables and clients aren't changed
+		{"analytics", "users", "name", true, "John", "John", false},
+		{"analytics", "orders", "age", true, "37", "37", false},
+		{"analytics", "", "age", true, "37", "37", false},
+		{"application", "users", "age", true, "37", "37", false},
+	}
+	for i, tcase := range testcases {
+		ctx := newContext(tcase.clientID, tcase.table, tcase.column, tcase.decrypted)
+		ctx, data, err := policy.OnColumn(ctx, []byte(tcase.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tcase.expected {
+			t.Fatalf("[%d] Expected %s, took %s", i, tcase.expected, data)
+		}
+		if base.IsNullValueFromContext(ctx) != tcase.null {
+			t.Fatalf("[%d] Expected NULL %v", i, tcase.null)
+		}
+	}
+}
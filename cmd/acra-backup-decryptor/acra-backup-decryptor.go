@@ -0,0 +1,253 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraBackupDecryptor utility. AcraBackupDecryptor decrypts PostgreSQL dumps created
+// by pg_dump in plain or custom format without running AcraServer: it reads the dump, decrypts values of columns
+// configured in encryptor config with keys from keystore and writes plain SQL dump ready to be restored with psql.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/encryptor/config_loader"
+	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Constants used by AcraBackupDecryptor
+var (
+	// defaultConfigPath relative path to config which will be parsed as default
+	defaultConfigPath = utils.GetConfigPathByName("acra-backup-decryptor")
+	serviceName       = "acra-backup-decryptor"
+)
+
+// stdStream is value of input and output parameters used for stdin and stdout
+const stdStream = "-"
+
+// customDumpSignature is the beginning of dumps in custom format of pg_dump
+var customDumpSignature = []byte("PGDMP")
+
+// ErrNotEncrypted returned for values which are not AcraStructs or AcraBlocks
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
+	clientID := flag.String("client_id", "", "Client ID used to decrypt columns without client_id in encryptor config")
+	input := flag.String("input", stdStream, "Path to dump created by pg_dump in plain or custom format, '-' to read from stdin")
+	output := flag.String("output", stdStream, "Path to file for decrypted dump in plain format, '-' to write into stdout")
+	pgRestorePath := flag.String("pg_restore_path", "pg_restore", "Path to pg_restore used to convert dumps of custom format into plain SQL")
+	encryptorConfigStorageType := flag.String("encryptor_config_storage_type", config_loader.EncryptoConfigStorageTypeFilesystem, fmt.Sprintf("Encryptor configuration file storage types: <%s", strings.Join(config_loader.SupportedEncryptorConfigStorages, "|")))
+
+	config_loader.RegisterEncryptorConfigLoaderParameters()
+	network.RegisterTLSBaseArgs(flag.CommandLine)
+	keyloader.RegisterKeyStoreStrategyParameters()
+	logging.SetLogLevel(logging.LogVerbose)
+
+	err := cmd.Parse(defaultConfigPath, serviceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
+			Errorln("Can't parse args")
+		os.Exit(1)
+	}
+	if *clientID != "" {
+		cmd.ValidateClientID(*clientID)
+	}
+	if !config_loader.IsEncryptorConfigLoaderCLIConfigured() {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Encryptor config is not configured, specify it to decrypt dump")
+		os.Exit(1)
+	}
+	encryptorConfigLoader, err := config_loader.NewConfigLoader(*encryptorConfigStorageType, flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't init encryptor config loader")
+		os.Exit(1)
+	}
+	mapConfig, err := encryptorConfigLoader.Load()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't read config for encryptor")
+		os.Exit(1)
+	}
+	schemaStore, err := encryptorConfig.MapTableSchemaStoreFromConfig(mapConfig, false)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse table schemas from config")
+		os.Exit(1)
+	}
+
+	var keystorage keystore.ServerKeyStore
+	if filesystemV2.IsKeyDirectory(*keysDir) {
+		keystorage = openKeyStoreV2(*keysDir)
+	} else {
+		keystorage = openKeyStoreV1(*keysDir)
+	}
+	if err := crypto.InitRegistry(keystorage); err != nil {
+		log.WithError(err).Errorln("Can't initialize crypto registry")
+		os.Exit(1)
+	}
+
+	inputReader := os.Stdin
+	if *input != stdStream {
+		inputReader, err = os.Open(*input)
+		if err != nil {
+			log.WithError(err).Errorln("Can't open dump")
+			os.Exit(1)
+		}
+		defer inputReader.Close()
+	}
+	outputWriter := os.Stdout
+	if *output != stdStream {
+		outputWriter, err = os.Create(*output)
+		if err != nil {
+			log.WithError(err).Errorln("Can't create output file")
+			os.Exit(1)
+		}
+		defer outputWriter.Close()
+	}
+
+	dump, waitDump, err := openDump(inputReader, *pgRestorePath)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read dump")
+		os.Exit(1)
+	}
+	dumpDecryptor := NewDumpDecryptor(schemaStore, &containerDecryptor{keystore: keystorage, clientID: []byte(*clientID)})
+	if err := dumpDecryptor.Decrypt(dump, outputWriter); err != nil {
+		log.WithError(err).Errorln("Can't decrypt dump")
+		os.Exit(1)
+	}
+	if err := waitDump(); err != nil {
+		log.WithError(err).Errorln("Can't convert dump with pg_restore")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{"decrypted": dumpDecryptor.Decrypted, "failed": dumpDecryptor.Failed}).Infoln("Dump decrypted")
+	if dumpDecryptor.Failed > 0 {
+		log.Warnln("Some values of encrypted columns can't be decrypted and are left as is")
+	}
+}
+
+// openDump returns reader of plain SQL dump and function which waits for the end of conversion. Dumps in custom
+// format are converted with pg_restore
+func openDump(input io.Reader, pgRestorePath string) (io.Reader, func() error, error) {
+	reader := bufio.NewReader(input)
+	signature, err := reader.Peek(len(customDumpSignature))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if !bytes.Equal(signature, customDumpSignature) {
+		return reader, func() error { return nil }, nil
+	}
+	log.Infoln("Convert dump of custom format with pg_restore")
+	command := exec.Command(pgRestorePath, "--file=-")
+	command.Stdin = reader
+	command.Stderr = os.Stderr
+	dump, err := command.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := command.Start(); err != nil {
+		return nil, nil, err
+	}
+	return dump, command.Wait, nil
+}
+
+// containerDecryptor decrypts AcraStructs and AcraBlocks with keys from keystore like AcraServer does
+type containerDecryptor struct {
+	keystore keystore.ServerKeyStore
+	// clientID used for columns without ClientID in encryptor config
+	clientID []byte
+}
+
+// DecryptValue decrypts AcraStruct or AcraBlock with keys of ClientID of the column
+func (decryptor *containerDecryptor) DecryptValue(setting encryptorConfig.ColumnEncryptionSetting, value []byte) ([]byte, error) {
+	if setting.IsSearchable() {
+		// searchable values are stored with hash before encrypted data
+		_, value = hmac.ExtractHashAndData(value)
+		if value == nil {
+			return nil, ErrNotEncrypted
+		}
+	}
+	internal, envelopeID, err := crypto.DeserializeEncryptedData(value)
+	if err != nil {
+		return nil, ErrNotEncrypted
+	}
+	handler, err := crypto.GetHandlerByEnvelopeID(envelopeID)
+	if err != nil {
+		return nil, err
+	}
+	clientID := setting.ClientID()
+	if len(clientID) == 0 {
+		clientID = decryptor.clientID
+	}
+	ctx := base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID(clientID)))
+	// setting is required to decrypt AcraBlocks bound to the column
+	ctx = encryptor.NewContextWithEncryptionSetting(ctx, setting)
+	dataContext := base.NewDataProcessorContext(decryptor.keystore)
+	dataContext.Context = ctx
+	return handler.Decrypt(internal, dataContext)
+}
+
+func openKeyStoreV1(keysDir string) keystore.ServerKeyStore {
+	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).Errorln("Can't init keystore KeyEncryptor")
+		os.Exit(1)
+	}
+
+	keystorage, err := filesystem.NewFilesystemKeyStore(keysDir, keyStoreEncryptor)
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialize keystore")
+		os.Exit(1)
+	}
+	return keystorage
+}
+
+func openKeyStoreV2(keyDirPath string) keystore.ServerKeyStore {
+	keyStoreSuite, err := keyloader.CreateKeyEncryptorSuite(flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).Errorln("Can't init keystore keyStoreSuite")
+		os.Exit(1)
+	}
+	keyDir, err := filesystemV2.OpenDirectoryRW(keyDirPath, keyStoreSuite)
+	if err != nil {
+		log.WithError(err).WithField("path", keyDirPath).Error("cannot open key directory")
+		os.Exit(1)
+	}
+	return keystoreV2.NewServerKeyStore(keyDir)
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// ValueDecryptor decrypts value of the column with encryption setting
+type ValueDecryptor interface {
+	DecryptValue(setting config.ColumnEncryptionSetting, value []byte) ([]byte, error)
+}
+
+// ErrInvalidCopyRow returned for rows of COPY block with unexpected number of columns
+var ErrInvalidCopyRow = errors.New("number of values in COPY row doesn't match number of columns")
+
+// copyStatementRegexp matches COPY statements of plain dumps created by pg_dump and pg_restore
+var copyStatementRegexp = regexp.MustCompile(`^COPY (\S+) \((.*)\) FROM stdin;$`)
+
+const (
+	// copyEndMarker terminates data of COPY statement
+	copyEndMarker = `\.`
+	// copyNull is representation of NULL in text format of COPY
+	copyNull = `\N`
+)
+
+// DumpDecryptor reads plain SQL dump created by pg_dump and decrypts values of encrypted columns in COPY blocks.
+// Dump is processed line by line, so tables of any size are decrypted with constant memory
+type DumpDecryptor struct {
+	schemaStore config.TableSchemaStore
+	decryptor   ValueDecryptor
+	// Decrypted is number of decrypted values
+	Decrypted int
+	// Failed is number of values of encrypted columns left as is because they can't be decrypted
+	Failed       int
+	insertsFound bool
+}
+
+// NewDumpDecryptor returns DumpDecryptor which decrypts columns configured in schemaStore
+func NewDumpDecryptor(schemaStore config.TableSchemaStore, decryptor ValueDecryptor) *DumpDecryptor {
+	return &DumpDecryptor{schemaStore: schemaStore, decryptor: decryptor}
+}
+
+// Decrypt reads dump from reader and writes it with decrypted values to writer
+func (dumpDecryptor *DumpDecryptor) Decrypt(reader io.Reader, writer io.Writer) error {
+	bufReader := bufio.NewReaderSize(reader, 64*1024)
+	bufWriter := bufio.NewWriterSize(writer, 64*1024)
+	inCopy := false
+	// settings of columns of the current COPY block, nil if table has no encrypted columns
+	var settings []config.ColumnEncryptionSetting
+	var table string
+	for {
+		line, readErr := bufReader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		content := strings.TrimSuffix(line, "\n")
+		lineEnd := line[len(content):]
+		switch {
+		case line == "":
+		case inCopy && content == copyEndMarker:
+			inCopy = false
+			settings = nil
+		case inCopy && settings != nil:
+			decrypted, err := dumpDecryptor.decryptCopyRow(content, settings)
+			if err != nil {
+				log.WithError(err).WithField("table", table).Errorln("Can't process row of COPY block")
+				return err
+			}
+			line = decrypted + lineEnd
+		case inCopy:
+		default:
+			if match := copyStatementRegexp.FindStringSubmatch(content); match != nil {
+				inCopy = true
+				table = match[1]
+				settings = dumpDecryptor.columnSettings(match[1], match[2])
+			} else if strings.HasPrefix(content, "INSERT INTO ") && !dumpDecryptor.insertsFound {
+				dumpDecryptor.insertsFound = true
+				log.Warnln("INSERT statements are passed as is, create dump without --inserts and --column-inserts options to decrypt data")
+			}
+		}
+		if _, err := bufWriter.WriteString(line); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return bufWriter.Flush()
+}
+
+// columnSettings returns encryption settings of listed columns or nil if table has no encrypted columns
+func (dumpDecryptor *DumpDecryptor) columnSettings(table, columns string) []config.ColumnEncryptionSetting {
+	tableNames := splitIdentifiers(table, '.')
+	// encryptor config contains tables without schema
+	schema := dumpDecryptor.schemaStore.GetTableSchema(tableNames[len(tableNames)-1])
+	if schema == nil {
+		return nil
+	}
+	columnNames := splitIdentifiers(columns, ',')
+	settings := make([]config.ColumnEncryptionSetting, len(columnNames))
+	encrypted := false
+	for i, column := range columnNames {
+		settings[i] = schema.GetColumnEncryptionSettings(column)
+		encrypted = encrypted || settings[i] != nil
+	}
+	if !encrypted {
+		return nil
+	}
+	log.WithField("table", table).Infoln("Decrypt table")
+	return settings
+}
+
+// decryptCopyRow decrypts values of encrypted columns of one row in text format of COPY
+func (dumpDecryptor *DumpDecryptor) decryptCopyRow(row string, settings []config.ColumnEncryptionSetting) (string, error) {
+	values := strings.Split(row, "\t")
+	if len(values) != len(settings) {
+		return "", ErrInvalidCopyRow
+	}
+	for i, setting := range settings {
+		if setting == nil || values[i] == copyNull {
+			continue
+		}
+		// values of encrypted columns are bytea in hex or escape format
+		value, err := utils.DecodeEscaped(unescapeCopyValue(values[i]))
+		if err != nil {
+			dumpDecryptor.Failed++
+			continue
+		}
+		decrypted, err := dumpDecryptor.decryptor.DecryptValue(setting, value)
+		if err != nil {
+			log.WithError(err).WithField("column", setting.ColumnName()).Debugln("Can't decrypt value")
+			dumpDecryptor.Failed++
+			continue
+		}
+		values[i] = escapeCopyValue(utils.PgEncodeToHex(decrypted))
+		utils.ZeroizeBytes(decrypted)
+		dumpDecryptor.Decrypted++
+	}
+	return strings.Join(values, "\t"), nil
+}
+
+// splitIdentifiers splits list of optionally quoted identifiers separated with separator and unquotes them
+func splitIdentifiers(list string, separator byte) []string {
+	var identifiers []string
+	current := &strings.Builder{}
+	quoted := false
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case c == '"' && quoted && i+1 < len(list) && list[i+1] == '"':
+			current.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == separator && !quoted:
+			identifiers = append(identifiers, strings.TrimSpace(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	return append(identifiers, strings.TrimSpace(current.String()))
+}
+
+// unescapeCopyValue decodes backslash escape sequences of text format of COPY
+// See https://www.postgresql.org/docs/current/sql-copy.html#id-1.9.3.55.9.2
+func unescapeCopyValue(value string) []byte {
+	if !strings.Contains(value, `\`) {
+		return []byte(value)
+	}
+	output := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			output = append(output, value[i])
+			continue
+		}
+		i++
+		switch c := value[i]; c {
+		case 'b':
+			output = append(output, '\b')
+		case 'f':
+			output = append(output, '\f')
+		case 'n':
+			output = append(output, '\n')
+		case 'r':
+			output = append(output, '\r')
+		case 't':
+			output = append(output, '\t')
+		case 'v':
+			output = append(output, '\v')
+		case 'x':
+			b, n := parseDigits(value[i+1:], 16, 2)
+			if n == 0 {
+				output = append(output, c)
+				continue
+			}
+			output = append(output, b)
+			i += n
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			b, n := parseDigits(value[i:], 8, 3)
+			output = append(output, b)
+			i += n - 1
+		default:
+			output = append(output, c)
+		}
+	}
+	return output
+}
+
+// parseDigits parses up to maxDigits digits of base and returns value with number of parsed digits
+func parseDigits(value string, base, maxDigits int) (byte, int) {
+	result, n := 0, 0
+	for ; n < maxDigits && n < len(value); n++ {
+		digit := strings.IndexByte("0123456789abcdef", value[n]|0x20)
+		if digit < 0 || digit >= base {
+			break
+		}
+		result = result*base + digit
+	}
+	return byte(result), n
+}
+
+// escapeCopyValue encodes value for text format of COPY
+func escapeCopyValue(value []byte) string {
+	output := bytes.NewBuffer(make([]byte, 0, len(value)+1))
+	for _, c := range value {
+		switch c {
+		case '\\':
+			output.WriteString(`\\`)
+		case '\n':
+			output.WriteString(`\n`)
+		case '\r':
+			output.WriteString(`\r`)
+		case '\t':
+			output.WriteString(`\t`)
+		default:
+			output.WriteByte(c)
+		}
+	}
+	return output.String()
+}
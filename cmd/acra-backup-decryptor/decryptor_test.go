@@ -0,0 +1,119 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+const testEncryptorConfig = `
+schemas:
+  - table: users
+    columns:
+      - id
+      - email
+      - name
+    encrypted:
+      - column: email
+`
+
+// prefixDecryptor "decrypts" values with "encrypted:" prefix by removing it
+type prefixDecryptor struct{}
+
+func (prefixDecryptor) DecryptValue(setting config.ColumnEncryptionSetting, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte("encrypted:")) {
+		return nil, errors.New("not encrypted")
+	}
+	return append([]byte(nil), value[len("encrypted:"):]...), nil
+}
+
+func TestDumpDecryptor(t *testing.T) {
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(testEncryptorConfig), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "encrypted:a\tb\n" in hex is 656e637279707465643a6109620a
+	dump := strings.Join([]string{
+		`CREATE TABLE public.users (id integer, email bytea, name text);`,
+		`COPY public.users (id, email, name) FROM stdin;`,
+		`1	\\x656e637279707465643a6109620a	encrypted:name`,
+		`2	\N	\N`,
+		`3	\\x6f74686572	name`,
+		`4	encrypted:\\001	name`,
+		`\.`,
+		`COPY public.orders (id, email) FROM stdin;`,
+		`1	\\x656e637279707465643a61`,
+		`\.`,
+		``,
+	}, "\n")
+	expected := strings.Join([]string{
+		`CREATE TABLE public.users (id integer, email bytea, name text);`,
+		`COPY public.users (id, email, name) FROM stdin;`,
+		`1	\\x6109620a	encrypted:name`,
+		`2	\N	\N`,
+		`3	\\x6f74686572	name`,
+		`4	\\x01	name`,
+		`\.`,
+		`COPY public.orders (id, email) FROM stdin;`,
+		`1	\\x656e637279707465643a61`,
+		`\.`,
+		``,
+	}, "\n")
+	output := &bytes.Buffer{}
+	dumpDecryptor := NewDumpDecryptor(schemaStore, prefixDecryptor{})
+	if err := dumpDecryptor.Decrypt(strings.NewReader(dump), output); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", output.String(), expected)
+	}
+	if dumpDecryptor.Decrypted != 2 || dumpDecryptor.Failed != 1 {
+		t.Fatalf("Unexpected counters: decrypted %d, failed %d", dumpDecryptor.Decrypted, dumpDecryptor.Failed)
+	}
+
+	invalidRow := "COPY public.users (id, email, name) FROM stdin;\n1\t\\N\n\\.\n"
+	if err := NewDumpDecryptor(schemaStore, prefixDecryptor{}).Decrypt(strings.NewReader(invalidRow), &bytes.Buffer{}); err != ErrInvalidCopyRow {
+		t.Fatalf("Expected ErrInvalidCopyRow, took %v", err)
+	}
+}
+
+func TestSplitIdentifiers(t *testing.T) {
+	identifiers := splitIdentifiers(`id, "Email", "with ""quotes"", and comma"`, ',')
+	expected := []string{"id", "Email", `with "quotes", and comma`}
+	if len(identifiers) != len(expected) {
+		t.Fatalf("Expected %v, took %v", expected, identifiers)
+	}
+	for i := range expected {
+		if identifiers[i] != expected[i] {
+			t.Fatalf("Expected %v, took %v", expected, identifiers)
+		}
+	}
+}
+
+func TestCopyValueEscaping(t *testing.T) {
+	if value := unescapeCopyValue(`a\\b\tc\nd\101\x42\z`); string(value) != "a\\b\tc\ndABz" {
+		t.Fatalf("Unexpected unescaped value %q", value)
+	}
+	if value := escapeCopyValue([]byte("a\\b\tc\nd\re")); value != `a\\b\tc\nd\re` {
+		t.Fatalf("Unexpected escaped value %q", value)
+	}
+}
@@ -69,7 +69,12 @@ func main() {
 	log.WithField("version", utils.VERSION).Infof("Starting service %v [pid=%v]", ServiceName, os.Getpid())
 	var storage filesystem.Storage
 	if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
-		storage, err = filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, nil)
+		redisOptions, err := redis.KeysOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			os.Exit(1)
+		}
+		storage, err = filesystem.NewRedisStorage(redisOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Can't initialize redis storage")
 			os.Exit(1)
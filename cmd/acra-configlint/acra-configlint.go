@@ -0,0 +1,184 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraConfigLint utility. AcraConfigLint connects directly to the database,
+// introspects definitions of tables and reports mismatches with encryptor config: missing tables and columns,
+// column types which can't store encrypted data or tokens, incomplete lists of columns. It exits with non-zero
+// status if errors were found, so it may be used in CI before deployment of changed encryptor config.
+package main
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	// register encoders of data types used by encryptor config validation
+	_ "github.com/cossacklabs/acra/decryptor/mysql/types"
+	_ "github.com/cossacklabs/acra/decryptor/postgresql/types"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/encryptor/config_loader"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Constants used by AcraConfigLint
+var (
+	// defaultConfigPath relative path to config which will be parsed as default
+	defaultConfigPath = utils.GetConfigPathByName("acra-configlint")
+	serviceName       = "acra-configlint"
+)
+
+func main() {
+	connectionString := flag.String("connection_string", "", "Connection string to the database for PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname}). May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+	dbTLSEnabled := flag.Bool("tls_database_enabled", false, "Enable TLS for DB")
+	encryptorConfigStorageType := flag.String("encryptor_config_storage_type", config_loader.EncryptoConfigStorageTypeFilesystem, fmt.Sprintf("Encryptor configuration file storage types: <%s", strings.Join(config_loader.SupportedEncryptorConfigStorages, "|")))
+	warningsAsErrors := flag.Bool("warnings_as_errors", false, "Exit with non-zero status if only warnings were found")
+
+	config_loader.RegisterEncryptorConfigLoaderParameters()
+	network.RegisterTLSArgsForService(flag.CommandLine, true, "", network.DatabaseNameConstructorFunc())
+	network.RegisterTLSBaseArgs(flag.CommandLine)
+	logging.SetLogLevel(logging.LogVerbose)
+	cmd.RegisterSecretParameters("connection_string")
+
+	err := cmd.Parse(defaultConfigPath, serviceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
+			Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	twoDrivers := *useMysql && *usePostgresql
+	noDrivers := !(*useMysql || *usePostgresql)
+	if twoDrivers || noDrivers {
+		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+		os.Exit(1)
+	}
+	if *connectionString == "" {
+		log.Errorln("Connection_string arg is missing")
+		os.Exit(1)
+	}
+	if !config_loader.IsEncryptorConfigLoaderCLIConfigured() {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Encryptor config is not configured, specify it to lint")
+		os.Exit(1)
+	}
+
+	encryptorConfigLoader, err := config_loader.NewConfigLoader(*encryptorConfigStorageType, flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't init encryptor config loader")
+		os.Exit(1)
+	}
+	mapConfig, err := encryptorConfigLoader.Load()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't read config for encryptor")
+		os.Exit(1)
+	}
+	schemaStore, err := encryptorConfig.MapTableSchemaStoreFromConfig(mapConfig, *useMysql)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse table schemas from config")
+		os.Exit(1)
+	}
+
+	var dbTLSConfig *tls.Config
+	if *dbTLSEnabled {
+		host, err := network.GetDriverConnectionStringHost(*connectionString, *useMysql)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to get DB host from connection URL")
+			os.Exit(1)
+		}
+		dbTLSConfig, err = network.NewTLSConfigByName(flag.CommandLine, "", host, network.DatabaseNameConstructorFunc())
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't create database TLS config")
+			os.Exit(1)
+		}
+	}
+
+	var db *sql.DB
+	if *useMysql {
+		config, err := mysql.ParseDSN(*connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
+			os.Exit(1)
+		}
+		if dbTLSConfig != nil {
+			tlsConfigName := "custom"
+			if err := mysql.RegisterTLSConfig(tlsConfigName, dbTLSConfig); err != nil {
+				log.WithError(err).Errorln("Failed to register TLS config")
+				os.Exit(1)
+			}
+			config.TLSConfig = tlsConfigName
+		}
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			log.WithError(err).Errorln("Can't initialize MySQL connector")
+			os.Exit(1)
+		}
+		db = sql.OpenDB(connector)
+	} else {
+		config, err := pgx.ParseConfig(*connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse config ")
+			os.Exit(1)
+		}
+		if dbTLSConfig != nil {
+			config.TLSConfig = dbTLSConfig
+		}
+		db = stdlib.OpenDB(*config)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		log.WithError(err).Errorln("Can't connect to db")
+		os.Exit(1)
+	}
+
+	tables, err := LoadTableDefinitions(db, *useMysql)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load definitions of tables from the database")
+		os.Exit(1)
+	}
+	issues := Lint(schemaStore, tables, *useMysql)
+	errorsCount, warningsCount := 0, 0
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+		if issue.Severity == SeverityError {
+			errorsCount++
+		} else {
+			warningsCount++
+		}
+	}
+	log.WithFields(log.Fields{"errors": errorsCount, "warnings": warningsCount}).Infoln("Encryptor config checked")
+	if errorsCount > 0 || (*warningsAsErrors && warningsCount > 0) {
+		// close explicitly because deferred calls are skipped by os.Exit
+		db.Close()
+		os.Exit(1)
+	}
+}
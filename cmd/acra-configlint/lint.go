@@ -0,0 +1,285 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+// Severity of found mismatch
+type Severity string
+
+// Supported severities. Errors break processing of queries by AcraServer, warnings may break some of them
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue describes mismatch between encryptor config and the database
+type Issue struct {
+	Severity Severity
+	Table    string
+	Column   string
+	Message  string
+}
+
+// String returns issue as one line of report
+func (issue Issue) String() string {
+	name := issue.Table
+	if issue.Column != "" {
+		name += "." + issue.Column
+	}
+	return fmt.Sprintf("%s: %s: %s", issue.Severity, name, issue.Message)
+}
+
+// ColumnDefinition describes column of the table in the database
+type ColumnDefinition struct {
+	Name     string
+	TypeName string
+	// TypeOID is PostgreSQL OID of column type, 0 for MySQL
+	TypeOID uint32
+}
+
+// TableDefinitions stores columns of tables in the database in order of definition by table name
+type TableDefinitions map[string][]ColumnDefinition
+
+// pgColumnsQuery returns columns of tables and views visible through search_path. Tables are returned also with
+// schema name to match schema qualified names from encryptor config
+const pgColumnsQuery = `SELECT n.nspname, c.relname, a.attname, a.atttypid::bigint, format_type(a.atttypid, a.atttypmod)
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+AND n.nspname = ANY (current_schemas(false))
+ORDER BY array_position(current_schemas(false), n.nspname), c.relname, a.attnum`
+
+// mysqlColumnsQuery returns columns of tables and views of the current database
+const mysqlColumnsQuery = `SELECT table_name, column_name, data_type
+FROM information_schema.columns
+WHERE table_schema = DATABASE()
+ORDER BY table_name, ordinal_position`
+
+// LoadTableDefinitions introspects definitions of tables from the database
+func LoadTableDefinitions(db *sql.DB, useMySQL bool) (TableDefinitions, error) {
+	if useMySQL {
+		return loadMySQLTableDefinitions(db)
+	}
+	return loadPostgreSQLTableDefinitions(db)
+}
+
+func loadPostgreSQLTableDefinitions(db *sql.DB) (TableDefinitions, error) {
+	rows, err := db.Query(pgColumnsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := make(TableDefinitions)
+	// table name without schema refers to the first schema in search_path which contains it
+	tableSchemas := make(map[string]string)
+	for rows.Next() {
+		var schemaName, tableName string
+		var typeOID int64
+		column := ColumnDefinition{}
+		if err := rows.Scan(&schemaName, &tableName, &column.Name, &typeOID, &column.TypeName); err != nil {
+			return nil, err
+		}
+		column.TypeOID = uint32(typeOID)
+		qualifiedName := schemaName + "." + tableName
+		tables[qualifiedName] = append(tables[qualifiedName], column)
+		if schema, ok := tableSchemas[tableName]; !ok || schema == schemaName {
+			tableSchemas[tableName] = schemaName
+			tables[tableName] = append(tables[tableName], column)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func loadMySQLTableDefinitions(db *sql.DB) (TableDefinitions, error) {
+	rows, err := db.Query(mysqlColumnsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := make(TableDefinitions)
+	for rows.Next() {
+		var tableName string
+		column := ColumnDefinition{}
+		if err := rows.Scan(&tableName, &column.Name, &column.TypeName); err != nil {
+			return nil, err
+		}
+		column.TypeName = strings.ToLower(column.TypeName)
+		tables[tableName] = append(tables[tableName], column)
+	}
+	return tables, rows.Err()
+}
+
+// expectedColumnType describes types of the column compatible with encryptor config
+type expectedColumnType struct {
+	description string
+	oids        []uint32
+	mysqlTypes  []string
+}
+
+func (t expectedColumnType) match(column ColumnDefinition, useMySQL bool) bool {
+	if useMySQL {
+		for _, name := range t.mysqlTypes {
+			if column.TypeName == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, oid := range t.oids {
+		if column.TypeOID == oid {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	binaryColumnType = expectedColumnType{
+		description: "binary",
+		oids:        []uint32{pgtype.ByteaOID},
+		mysqlTypes:  []string{"binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob"},
+	}
+	stringColumnType = expectedColumnType{
+		description: "string",
+		oids:        []uint32{pgtype.TextOID, pgtype.VarcharOID, pgtype.BPCharOID},
+		mysqlTypes:  []string{"char", "varchar", "tinytext", "text", "mediumtext", "longtext"},
+	}
+	int32ColumnType = expectedColumnType{
+		description: "32-bit integer",
+		oids:        []uint32{pgtype.Int4OID},
+		mysqlTypes:  []string{"int"},
+	}
+	int64ColumnType = expectedColumnType{
+		description: "64-bit integer",
+		oids:        []uint32{pgtype.Int8OID},
+		mysqlTypes:  []string{"bigint"},
+	}
+)
+
+// columnTypeForSetting returns type of the column which can store values processed with setting. Tokens have the same
+// type as source data, other modes store encrypted containers which are binary
+func columnTypeForSetting(setting config.ColumnEncryptionSetting) expectedColumnType {
+	if !setting.IsTokenized() {
+		return binaryColumnType
+	}
+	switch setting.GetTokenType() {
+	case tokenCommon.TokenType_Int32:
+		return int32ColumnType
+	case tokenCommon.TokenType_Int64:
+		return int64ColumnType
+	case tokenCommon.TokenType_Bytes:
+		return binaryColumnType
+	default:
+		return stringColumnType
+	}
+}
+
+// findColumn returns column by name. MySQL column names are case insensitive
+func findColumn(columns []ColumnDefinition, name string, useMySQL bool) (ColumnDefinition, bool) {
+	for _, column := range columns {
+		if column.Name == name || (useMySQL && strings.EqualFold(column.Name, name)) {
+			return column, true
+		}
+	}
+	return ColumnDefinition{}, false
+}
+
+// columnsFromNames returns columns without types to search names with findColumn
+func columnsFromNames(names []string) []ColumnDefinition {
+	columns := make([]ColumnDefinition, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, ColumnDefinition{Name: name})
+	}
+	return columns
+}
+
+// Lint compares tables from encryptor config with definitions from the database and returns found mismatches
+func Lint(store *config.MapTableSchemaStore, tables TableDefinitions, useMySQL bool) []Issue {
+	var issues []Issue
+	for _, schema := range store.Tables() {
+		columns, ok := tables[schema.Name()]
+		if !ok {
+			issues = append(issues, Issue{SeverityError, schema.Name(), "", "table not found in the database"})
+			continue
+		}
+		configured := make(map[string]bool, len(schema.Columns()))
+		for _, name := range schema.Columns() {
+			configured[name] = true
+		}
+		// encrypted columns may be omitted in the list of columns
+		names := append([]string{}, schema.Columns()...)
+		for _, setting := range schema.EncryptedColumns() {
+			if !configured[setting.ColumnName()] {
+				names = append(names, setting.ColumnName())
+			}
+		}
+		for _, name := range names {
+			if _, ok := findColumn(columns, name, useMySQL); !ok {
+				issues = append(issues, Issue{SeverityError, schema.Name(), name, "column not found in the database"})
+			}
+		}
+		// order of columns matters only for queries without column names which are processed if the list is defined
+		if len(schema.Columns()) > 0 {
+			listed := columnsFromNames(schema.Columns())
+			unlisted := false
+			for _, column := range columns {
+				if _, ok := findColumn(listed, column.Name, useMySQL); !ok {
+					unlisted = true
+					issues = append(issues, Issue{SeverityWarning, schema.Name(), column.Name,
+						"column is missing in the list of columns, INSERT queries without column names will be processed incorrectly"})
+				}
+			}
+			if !unlisted && len(listed) == len(columns) {
+				for i, column := range columns {
+					if _, ok := findColumn(listed[i:i+1], column.Name, useMySQL); !ok {
+						issues = append(issues, Issue{SeverityWarning, schema.Name(), "",
+							"order of columns differs from the database, INSERT queries without column names will be processed incorrectly"})
+						break
+					}
+				}
+			}
+		}
+		for _, setting := range schema.EncryptedColumns() {
+			column, ok := findColumn(columns, setting.ColumnName(), useMySQL)
+			if !ok {
+				continue
+			}
+			expected := columnTypeForSetting(setting)
+			if expected.match(column, useMySQL) {
+				continue
+			}
+			actual := column.TypeName
+			if !useMySQL {
+				actual = fmt.Sprintf("%s (OID %d)", column.TypeName, column.TypeOID)
+			}
+			issues = append(issues, Issue{SeverityError, schema.Name(), setting.ColumnName(),
+				fmt.Sprintf("column has type %s incompatible with encryptor config, expected %s type", actual, expected.description)})
+		}
+	}
+	return issues
+}
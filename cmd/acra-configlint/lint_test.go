@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+const testEncryptorConfig = `
+schemas:
+  - table: users
+    columns:
+      - id
+      - email
+      - phone
+    encrypted:
+      - column: email
+      - column: phone
+        token_type: int64
+      - column: comment
+  - table: orders
+    columns:
+      - id
+      - amount
+    encrypted:
+      - column: amount
+        token_type: int32
+  - table: missing_table
+    columns:
+      - id
+`
+
+func TestLintPostgreSQL(t *testing.T) {
+	store, err := config.MapTableSchemaStoreFromConfig([]byte(testEncryptorConfig), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := TableDefinitions{
+		"users": {
+			{Name: "id", TypeName: "integer", TypeOID: pgtype.Int4OID},
+			{Name: "email", TypeName: "text", TypeOID: pgtype.TextOID},
+			{Name: "phone", TypeName: "bigint", TypeOID: pgtype.Int8OID},
+			{Name: "created_at", TypeName: "timestamp", TypeOID: pgtype.TimestampOID},
+		},
+		"orders": {
+			{Name: "amount", TypeName: "integer", TypeOID: pgtype.Int4OID},
+			{Name: "id", TypeName: "integer", TypeOID: pgtype.Int4OID},
+		},
+	}
+	expected := []Issue{
+		{SeverityError, "missing_table", "", "table not found in the database"},
+		{SeverityWarning, "orders", "", "order of columns differs from the database, INSERT queries without column names will be processed incorrectly"},
+		{SeverityError, "users", "comment", "column not found in the database"},
+		{SeverityWarning, "users", "created_at", "column is missing in the list of columns, INSERT queries without column names will be processed incorrectly"},
+		{SeverityError, "users", "email", "column has type text (OID 25) incompatible with encryptor config, expected binary type"},
+	}
+	issues := Lint(store, tables, config.UsePostgreSQL)
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, took %d: %v", len(expected), len(issues), issues)
+	}
+	for i := range expected {
+		if issues[i] != expected[i] {
+			t.Fatalf("[%d] Expected %v, took %v", i, expected[i], issues[i])
+		}
+	}
+}
+
+func TestLintMySQL(t *testing.T) {
+	store, err := config.MapTableSchemaStoreFromConfig([]byte(testEncryptorConfig), config.UseMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := TableDefinitions{
+		"users": {
+			{Name: "ID", TypeName: "int"},
+			{Name: "Email", TypeName: "blob"},
+			{Name: "phone", TypeName: "int"},
+			{Name: "comment", TypeName: "varbinary"},
+		},
+		"orders": {
+			{Name: "id", TypeName: "int"},
+			{Name: "amount", TypeName: "int"},
+		},
+		"missing_table": {
+			{Name: "id", TypeName: "int"},
+		},
+	}
+	expected := []Issue{
+		// column names are case insensitive, but comment is not in the list of columns
+		{SeverityWarning, "users", "comment", "column is missing in the list of columns, INSERT queries without column names will be processed incorrectly"},
+		{SeverityError, "users", "phone", "column has type int incompatible with encryptor config, expected 64-bit integer type"},
+	}
+	issues := Lint(store, tables, config.UseMySQL)
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, took %d: %v", len(expected), len(issues), issues)
+	}
+	for i := range expected {
+		if issues[i] != expected[i] {
+			t.Fatalf("[%d] Expected %v, took %v", i, expected[i], issues[i])
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraExplain utility. AcraExplain loads encryptor config, reads SQL queries from
+// stdin and prints how every result column and placeholder of the query resolves to encryption settings, or why
+// it doesn't. It uses the same components as AcraServer, so it helps to find out why columns are returned
+// as ciphertext or values are not encrypted.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/encryptor/config_loader"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/sqlparser"
+	mysqlDialect "github.com/cossacklabs/acra/sqlparser/dialect/mysql"
+	pgDialect "github.com/cossacklabs/acra/sqlparser/dialect/postgresql"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Constants used by AcraExplain
+var (
+	// defaultConfigPath relative path to config which will be parsed as default
+	defaultConfigPath = utils.GetConfigPathByName("acra-explain")
+	serviceName       = "acra-explain"
+)
+
+const prompt = "acra-explain> "
+
+func main() {
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+	encryptorConfigStorageType := flag.String("encryptor_config_storage_type", config_loader.EncryptoConfigStorageTypeFilesystem, fmt.Sprintf("Encryptor configuration file storage types: <%s", strings.Join(config_loader.SupportedEncryptorConfigStorages, "|")))
+	sqlParseErrorExitEnable := flag.Bool("sql_parse_on_error_exit_enable", false, "Parse queries in strict mode like AcraServer with the same option")
+
+	config_loader.RegisterEncryptorConfigLoaderParameters()
+	network.RegisterTLSBaseArgs(flag.CommandLine)
+	logging.SetLogLevel(logging.LogVerbose)
+
+	err := cmd.Parse(defaultConfigPath, serviceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
+			Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	twoDrivers := *useMysql && *usePostgresql
+	noDrivers := !(*useMysql || *usePostgresql)
+	if twoDrivers || noDrivers {
+		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+		os.Exit(1)
+	}
+	if !config_loader.IsEncryptorConfigLoaderCLIConfigured() {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Encryptor config is not configured, specify it to explain queries")
+		os.Exit(1)
+	}
+
+	encryptorConfigLoader, err := config_loader.NewConfigLoader(*encryptorConfigStorageType, flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't init encryptor config loader")
+		os.Exit(1)
+	}
+	mapConfig, err := encryptorConfigLoader.Load()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't read config for encryptor")
+		os.Exit(1)
+	}
+	schemaStore, err := encryptorConfig.MapTableSchemaStoreFromConfig(mapConfig, *useMysql)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse table schemas from config")
+		os.Exit(1)
+	}
+
+	// same dialect as AcraServer uses
+	if *useMysql {
+		caseSensitiveTableName := schemaStore.GetDatabaseSettings().GetMySQLDatabaseSettings().GetCaseSensitiveTableIdentifiers()
+		sqlparser.SetDefaultDialect(mysqlDialect.NewMySQLDialect(mysqlDialect.SetTableNameCaseSensitivity(caseSensitiveTableName)))
+	} else {
+		sqlparser.SetDefaultDialect(pgDialect.NewPostgreSQLDialect())
+	}
+	parser := sqlparser.New(sqlparser.ModeDefault)
+	if *sqlParseErrorExitEnable {
+		parser = sqlparser.New(sqlparser.ModeStrict)
+	}
+
+	// show prompt only for interactive input
+	interactive := false
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+	}
+	if err := explainQueries(NewExplainer(schemaStore, parser, *useMysql), os.Stdin, os.Stdout, interactive); err != nil {
+		log.WithError(err).Errorln("Can't read queries")
+		os.Exit(1)
+	}
+}
+
+// explainQueries reads queries terminated by semicolon at the end of line and writes explanation of every query
+func explainQueries(explainer *Explainer, reader io.Reader, writer io.Writer, interactive bool) error {
+	scanner := bufio.NewScanner(reader)
+	// queries may be much longer than default limit of line
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	query := &strings.Builder{}
+	explain := func() {
+		text := strings.TrimSpace(query.String())
+		query.Reset()
+		if text == "" {
+			return
+		}
+		explanation, err := explainer.Explain(text)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %s\n", err)
+			return
+		}
+		explanation.WriteTo(writer)
+	}
+	for {
+		if interactive && query.Len() == 0 {
+			fmt.Fprint(os.Stderr, prompt)
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		query.WriteString(line)
+		query.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			explain()
+		}
+	}
+	// the last query may be without semicolon
+	explain()
+	return scanner.Err()
+}
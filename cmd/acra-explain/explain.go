@@ -0,0 +1,368 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/postgresql"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+// Sources of placeholder settings
+const (
+	PlaceholderSourceValue          = "inserted/updated value"
+	PlaceholderSourceSearchable     = "searchable comparison"
+	PlaceholderSourceTokenizedValue = "consistent tokenization comparison"
+)
+
+// ColumnExplanation describes how result column resolves to encryption setting
+type ColumnExplanation struct {
+	Index      int
+	Expression string
+	Item       *encryptor.QueryDataItem
+	Reason     string
+}
+
+// PlaceholderExplanation describes encryption setting applied to bound value of placeholder
+type PlaceholderExplanation struct {
+	Index   int
+	Source  string
+	Setting config.ColumnEncryptionSetting
+}
+
+// Explanation is the result of query explanation
+type Explanation struct {
+	Query        string
+	Columns      []ColumnExplanation
+	Placeholders []PlaceholderExplanation
+	Notes        []string
+}
+
+// Explainer resolves encryption settings of result columns and placeholders of queries in the same way as AcraServer
+type Explainer struct {
+	schemaStore config.TableSchemaStore
+	parser      *sqlparser.Parser
+	useMySQL    bool
+}
+
+// NewExplainer returns new Explainer for encryptor config
+func NewExplainer(schemaStore config.TableSchemaStore, parser *sqlparser.Parser, useMySQL bool) *Explainer {
+	return &Explainer{schemaStore: schemaStore, parser: parser, useMySQL: useMySQL}
+}
+
+// Explain parses query and resolves encryption settings of its result columns and placeholders
+func (explainer *Explainer) Explain(query string) (*Explanation, error) {
+	statement, err := explainer.parser.Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse query, AcraServer passes it as is without encryption settings: %w", err)
+	}
+	explanation := &Explanation{Query: query}
+	if explainer.useMySQL && base.OnlyDefaultEncryptorSettings(explainer.schemaStore) {
+		explanation.Notes = append(explanation.Notes, "encryptor config has only encryption settings, AcraServer doesn't "+
+			"resolve settings of MySQL result columns and decrypts any AcraStruct/AcraBlock with client ID of connection")
+	}
+
+	session := newExplainSession()
+	ctx := base.SetClientSessionToContext(context.Background(), session)
+	ctx = base.SetAccessContextToContext(ctx, base.NewAccessContext())
+	if err := explainer.explainColumns(ctx, query, statement, explanation); err != nil {
+		return nil, err
+	}
+	if err := explainer.explainPlaceholders(ctx, query, explanation); err != nil {
+		return nil, err
+	}
+	return explanation, nil
+}
+
+// explainColumns matches result columns to settings with EncryptionSettingExtractor for PostgreSQL and
+// QueryDataEncryptor for MySQL, like PgProxy and MySQL proxy do
+func (explainer *Explainer) explainColumns(ctx context.Context, query string, statement sqlparser.Statement, explanation *Explanation) error {
+	var items []*encryptor.QueryDataItem
+	var err error
+	if explainer.useMySQL {
+		var queryEncryptor *encryptor.QueryDataEncryptor
+		queryEncryptor, err = encryptor.NewMysqlQueryEncryptor(explainer.schemaStore, explainer.parser, nil)
+		if err != nil {
+			return err
+		}
+		_, _, err = queryEncryptor.OnQuery(ctx, base.NewOnQueryObjectFromQuery(query, explainer.parser))
+		items = queryEncryptor.GetQueryEncryptionSettings()
+	} else {
+		var extractor postgresql.EncryptionSettingExtractor
+		extractor, err = postgresql.NewEncryptionSettingExtractor(ctx, explainer.schemaStore, explainer.parser)
+		if err != nil {
+			return err
+		}
+		items, err = extractor.GetEncryptorSettingsForQuery(base.NewOnQueryObjectFromQuery(query, explainer.parser))
+	}
+	if err != nil {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("result columns are not matched to encryptor config: %s", err))
+		return nil
+	}
+	if items == nil {
+		if _, ok := statement.(*sqlparser.Select); !ok {
+			explanation.Notes = append(explanation.Notes, "result columns are resolved only for SELECT statements and RETURNING clauses")
+		}
+		return nil
+	}
+	expressions := selectExpressions(statement)
+	for i, item := range items {
+		column := ColumnExplanation{Index: i, Item: item}
+		if len(expressions) == len(items) {
+			column.Expression = sqlparser.String(expressions[i])
+		}
+		if item == nil {
+			column.Reason = unresolvedColumnReason(expressions, len(items), i)
+		}
+		explanation.Columns = append(explanation.Columns, column)
+	}
+	return nil
+}
+
+// explainPlaceholders collects settings of placeholders saved by QueryDataEncryptor for inserted and updated values
+// and placeholders compared with searchable or consistently tokenized columns, like HashQuery and TokenizeQuery do
+func (explainer *Explainer) explainPlaceholders(ctx context.Context, query string, explanation *Explanation) error {
+	var queryEncryptor *encryptor.QueryDataEncryptor
+	var err error
+	if explainer.useMySQL {
+		queryEncryptor, err = encryptor.NewMysqlQueryEncryptor(explainer.schemaStore, explainer.parser, noopDataEncryptor{})
+	} else {
+		queryEncryptor, err = encryptor.NewPostgresqlQueryEncryptor(explainer.schemaStore, explainer.parser, noopDataEncryptor{})
+	}
+	if err != nil {
+		return err
+	}
+	if _, _, err := queryEncryptor.OnQuery(ctx, base.NewOnQueryObjectFromQuery(query, explainer.parser)); err != nil {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("values are not matched to encryptor config: %s", err))
+	}
+	placeholders := make(map[int]PlaceholderExplanation)
+	for index, setting := range encryptor.PlaceholderSettingsFromClientSession(base.ClientSessionFromContext(ctx)) {
+		placeholders[index] = PlaceholderExplanation{Index: index, Source: PlaceholderSourceValue, Setting: setting}
+	}
+
+	filters := []struct {
+		mode   encryptor.SearchableQueryFilterMode
+		source string
+	}{
+		{encryptor.QueryFilterModeSearchableEncryption, PlaceholderSourceSearchable},
+		{encryptor.QueryFilterModeConsistentTokenization, PlaceholderSourceTokenizedValue},
+	}
+	for _, filter := range filters {
+		statement, err := explainer.parser.Parse(query)
+		if err != nil {
+			return err
+		}
+		items := encryptor.NewSearchableQueryFilter(explainer.schemaStore, filter.mode).FilterSearchableComparisons(statement)
+		for _, item := range items {
+			for _, value := range item.Values() {
+				index, err := encryptor.ParsePlaceholderIndex(value)
+				if err != nil {
+					continue
+				}
+				placeholders[index] = PlaceholderExplanation{Index: index, Source: filter.source, Setting: item.Setting}
+			}
+		}
+	}
+
+	for _, placeholder := range placeholders {
+		explanation.Placeholders = append(explanation.Placeholders, placeholder)
+	}
+	sort.Slice(explanation.Placeholders, func(i, j int) bool {
+		return explanation.Placeholders[i].Index < explanation.Placeholders[j].Index
+	})
+	return nil
+}
+
+// selectExpressions returns expressions of SELECT statement if every of them is one result column
+func selectExpressions(statement sqlparser.Statement) sqlparser.SelectExprs {
+	selectStatement, ok := statement.(*sqlparser.Select)
+	if !ok {
+		return nil
+	}
+	for _, expr := range selectStatement.SelectExprs {
+		if _, ok := expr.(*sqlparser.StarExpr); ok {
+			return nil
+		}
+	}
+	return selectStatement.SelectExprs
+}
+
+// unresolvedColumnReason explains why result column has no encryption setting
+func unresolvedColumnReason(expressions sqlparser.SelectExprs, columnsCount, index int) string {
+	if len(expressions) != columnsCount {
+		return "column has no encryption settings in encryptor config"
+	}
+	aliased, ok := expressions[index].(*sqlparser.AliasedExpr)
+	if !ok {
+		return "expression is not a column"
+	}
+	switch expr := aliased.Expr.(type) {
+	case *sqlparser.ColName:
+		if expr.Qualifier.IsEmpty() {
+			return "column has no encryption settings in encryptor config or its table can't be determined from FROM clause"
+		}
+		return fmt.Sprintf("column has no encryption settings in encryptor config or qualifier %s doesn't match any table in FROM clause", sqlparser.String(expr.Qualifier))
+	case *sqlparser.Subquery:
+		return "subquery column has no encryption settings in encryptor config"
+	}
+	return "expression is not a column, only values of columns are matched to encryptor config"
+}
+
+// describeSetting returns short description of encryption setting
+func describeSetting(setting config.ColumnEncryptionSetting) string {
+	parts := make([]string, 0, 6)
+	if clientID := setting.ClientID(); len(clientID) > 0 {
+		parts = append(parts, fmt.Sprintf("client_id=%s", clientID))
+	} else {
+		parts = append(parts, "client_id=<from connection>")
+	}
+	if setting.IsTokenized() {
+		tokenType, err := setting.GetTokenType().ToConfigString()
+		if err != nil {
+			tokenType = setting.GetTokenType().String()
+		}
+		parts = append(parts, fmt.Sprintf("token_type=%s", tokenType))
+		if setting.IsConsistentTokenization() {
+			parts = append(parts, "consistent_tokenization")
+		}
+		return strings.Join(parts, ", ")
+	}
+	parts = append(parts, fmt.Sprintf("crypto_envelope=%s", setting.GetCryptoEnvelope()))
+	if setting.IsSearchable() {
+		parts = append(parts, "searchable")
+	}
+	if setting.GetMaskingPattern() != "" {
+		parts = append(parts, "masking")
+	}
+	if dataType, err := setting.GetEncryptedDataType().ToConfigString(); err == nil {
+		parts = append(parts, fmt.Sprintf("data_type=%s", dataType))
+	}
+	if responseOnFail := setting.GetResponseOnFail(); responseOnFail != "" {
+		parts = append(parts, fmt.Sprintf("response_on_fail=%s", responseOnFail))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WriteTo writes human-readable explanation
+func (explanation *Explanation) WriteTo(writer io.Writer) (int64, error) {
+	builder := &strings.Builder{}
+	builder.WriteString("Result columns:\n")
+	if len(explanation.Columns) == 0 {
+		builder.WriteString("  none\n")
+	}
+	for _, column := range explanation.Columns {
+		fmt.Fprintf(builder, "  %d.", column.Index+1)
+		if column.Expression != "" {
+			fmt.Fprintf(builder, " %s", column.Expression)
+		}
+		if column.Item == nil {
+			fmt.Fprintf(builder, " -> not resolved: %s\n", column.Reason)
+			continue
+		}
+		name := column.Item.TableName() + "." + column.Item.ColumnName()
+		if column.Item.ColumnAlias() != "" {
+			name += " as " + column.Item.ColumnAlias()
+		}
+		fmt.Fprintf(builder, " -> %s: %s\n", name, describeSetting(column.Item.Setting()))
+	}
+	builder.WriteString("Placeholders:\n")
+	if len(explanation.Placeholders) == 0 {
+		builder.WriteString("  none\n")
+	}
+	for _, placeholder := range explanation.Placeholders {
+		fmt.Fprintf(builder, "  #%d (%s) -> column %s: %s\n", placeholder.Index+1, placeholder.Source,
+			placeholder.Setting.ColumnName(), describeSetting(placeholder.Setting))
+	}
+	for _, note := range explanation.Notes {
+		fmt.Fprintf(builder, "Note: %s\n", note)
+	}
+	n, err := io.WriteString(writer, builder.String())
+	return int64(n), err
+}
+
+// noopDataEncryptor returns data as is to collect settings of placeholders without encryption
+type noopDataEncryptor struct{}
+
+// EncryptWithClientID returns data as is
+func (noopDataEncryptor) EncryptWithClientID(clientID, data []byte, setting config.ColumnEncryptionSetting) ([]byte, error) {
+	return data, nil
+}
+
+// explainSession is ClientSession without connections used to store data of query processing
+type explainSession struct {
+	data map[string]interface{}
+}
+
+func newExplainSession() *explainSession {
+	return &explainSession{data: make(map[string]interface{})}
+}
+
+func (session *explainSession) Context() context.Context {
+	return context.Background()
+}
+
+func (session *explainSession) ClientConnection() net.Conn {
+	return nil
+}
+
+func (session *explainSession) ClientAddress() net.Addr {
+	return nil
+}
+
+func (session *explainSession) DatabaseConnection() net.Conn {
+	return nil
+}
+
+func (session *explainSession) PreparedStatementRegistry() base.PreparedStatementRegistry {
+	return nil
+}
+
+func (session *explainSession) SetPreparedStatementRegistry(registry base.PreparedStatementRegistry) {
+}
+
+func (session *explainSession) ProtocolState() interface{} {
+	return nil
+}
+
+func (session *explainSession) SetProtocolState(state interface{}) {}
+
+func (session *explainSession) GetData(key string) (interface{}, bool) {
+	value, ok := session.data[key]
+	return value, ok
+}
+
+func (session *explainSession) SetData(key string, value interface{}) {
+	session.data[key] = value
+}
+
+func (session *explainSession) DeleteData(key string) {
+	delete(session.data, key)
+}
+
+func (session *explainSession) HasData(key string) bool {
+	_, ok := session.data[key]
+	return ok
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+const testEncryptorConfig = `
+schemas:
+  - table: test_table
+    columns:
+      - id
+      - data
+      - email
+    encrypted:
+      - column: data
+        searchable: true
+      - column: email
+        token_type: email
+        consistent_tokenization: true
+`
+
+func newTestExplainer(t *testing.T) *Explainer {
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(testEncryptorConfig), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewExplainer(schemaStore, sqlparser.New(sqlparser.ModeDefault), false)
+}
+
+func TestExplainColumns(t *testing.T) {
+	explainer := newTestExplainer(t)
+	explanation, err := explainer.Explain("select id, data, email as contact, lower(email) from test_table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Columns) != 4 {
+		t.Fatalf("Expected 4 columns, took %v", explanation.Columns)
+	}
+	resolved := []string{"", "data", "email", ""}
+	for i, column := range explanation.Columns {
+		if resolved[i] == "" {
+			if column.Item != nil || column.Reason == "" {
+				t.Fatalf("[%d] Expected not resolved column with reason, took %v", i, column)
+			}
+			continue
+		}
+		if column.Item == nil || column.Item.ColumnName() != resolved[i] {
+			t.Fatalf("[%d] Expected %v, took %v", i, resolved[i], column)
+		}
+	}
+	if explanation.Columns[2].Item.ColumnAlias() != "contact" {
+		t.Fatalf("Expected alias contact, took %v", explanation.Columns[2].Item.ColumnAlias())
+	}
+}
+
+func TestExplainPlaceholders(t *testing.T) {
+	explainer := newTestExplainer(t)
+	testcases := []struct {
+		query        string
+		placeholders map[int]string
+	}{
+		{"insert into test_table (id, data, email) values ($1, $2, $3)", map[int]string{1: PlaceholderSourceValue, 2: PlaceholderSourceValue}},
+		{"update test_table set data=$1 where id=$2", map[int]string{0: PlaceholderSourceValue}},
+		{"select id from test_table where data=$1 and email=$2 and id=$3", map[int]string{0: PlaceholderSourceSearchable, 1: PlaceholderSourceTokenizedValue}},
+		{"select id from test_table where data in ($1, $2)", map[int]string{0: PlaceholderSourceSearchable, 1: PlaceholderSourceSearchable}},
+		{"select id from test_table where id=$1", map[int]string{}},
+	}
+	for i, tcase := range testcases {
+		explanation, err := explainer.Explain(tcase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(explanation.Placeholders) != len(tcase.placeholders) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.placeholders, explanation.Placeholders)
+		}
+		for _, placeholder := range explanation.Placeholders {
+			if tcase.placeholders[placeholder.Index] != placeholder.Source {
+				t.Fatalf("[%d] Expected %v, took %v", i, tcase.placeholders, explanation.Placeholders)
+			}
+		}
+	}
+}
+
+func TestExplainQueries(t *testing.T) {
+	explainer := newTestExplainer(t)
+	input := "select data\nfrom test_table;\nselect from;\nselect email from test_table"
+	output := &bytes.Buffer{}
+	if err := explainQueries(explainer, strings.NewReader(input), output, false); err != nil {
+		t.Fatal(err)
+	}
+	text := output.String()
+	if strings.Count(text, "Result columns:") != 2 {
+		t.Fatalf("Expected explanation of 2 queries, took %s", text)
+	}
+	if strings.Count(text, "Error: can't parse query") != 1 {
+		t.Fatalf("Expected parse error of 1 query, took %s", text)
+	}
+	if !strings.Contains(text, "test_table.data: client_id=<from connection>, crypto_envelope=acrablock, searchable") {
+		t.Fatalf("Expected description of searchable column, took %s", text)
+	}
+	if !strings.Contains(text, "token_type=email, consistent_tokenization") {
+		t.Fatalf("Expected description of tokenized column, took %s", text)
+	}
+}
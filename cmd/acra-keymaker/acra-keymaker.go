@@ -23,9 +23,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/pem"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -36,6 +33,8 @@ import (
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/azure"
+	"github.com/cossacklabs/acra/keystore/keyloader/gcp"
 	"github.com/cossacklabs/acra/keystore/keyloader/kms"
 	"github.com/cossacklabs/acra/keystore/kms/base"
 	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
@@ -70,9 +69,12 @@ func main() {
 
 	tlsClientCert := flag.String("tls_cert", "", "Path to TLS certificate to use as client_id identifier")
 	tlsIdentifierExtractorType := flag.String("tls_identifier_extractor_type", network.IdentifierExtractorTypeDistinguishedName, fmt.Sprintf("Decide which field of TLS certificate to use as ClientID (%s). Default is %s.", strings.Join(network.IdentifierExtractorTypesList, "|"), network.IdentifierExtractorTypeDistinguishedName))
+	network.RegisterIdentifierExtractorTemplateArgs(flag.CommandLine)
+	acraServerConfigFile := flag.String("acra_server_config_file", "", "Path to AcraServer config file to use its tls_identifier_extractor_type instead of --tls_identifier_extractor_type, so clientID is derived from certificate the same way as AcraServer does")
 
 	cmd.RegisterRedisKeystoreParameters()
 	keyloader.RegisterKeyStoreStrategyParameters()
+	loggingParams := cmd.RegisterLoggingParameters()
 	logging.SetLogLevel(logging.LogVerbose)
 
 	err := cmd.Parse(DefaultConfigPath, ServiceName)
@@ -81,6 +83,12 @@ func main() {
 			Errorln("Can't parse args")
 		os.Exit(1)
 	}
+	logFinalize, err := loggingParams.Apply(ServiceName, logging.LogVerbose)
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialise output writer for logging customization")
+		os.Exit(1)
+	}
+	defer logFinalize()
 
 	if len(*clientID) != 0 && *tlsClientCert != "" {
 		log.Errorln("You can either specify identifier for keys via specific clientID by --client_id parameter or via TLS certificate by --tls_cert parameter.")
@@ -88,39 +96,16 @@ func main() {
 	}
 
 	if len(*clientID) == 0 && *tlsClientCert != "" {
-		idConverter, err := network.NewDefaultHexIdentifierConverter()
-		if err != nil {
-			log.WithError(err).Errorln("Can't initialize identifier converter")
-			os.Exit(1)
-		}
-		identifierExtractor, err := network.NewIdentifierExtractorByType(*tlsIdentifierExtractorType)
-		if err != nil {
-			log.WithField("type", *tlsIdentifierExtractorType).WithError(err).Errorln("Can't initialize identifier extractor")
-			os.Exit(1)
-		}
-		clientIDExtractor, err := network.NewTLSClientIDExtractor(identifierExtractor, idConverter)
-		if err != nil {
-			log.WithError(err).Errorln("Can't initialize clientID extractor")
-			os.Exit(1)
-		}
-		pemCertificateFile, err := ioutil.ReadFile(*tlsClientCert)
-		if err != nil {
-			log.WithError(err).Errorln("Can't read TLS certificate")
-			os.Exit(1)
-		}
-		block, _ := pem.Decode(pemCertificateFile)
-		if block == nil {
-			log.WithError(err).Errorln("Can't parse TLS certificate as PEM encoded file")
-			os.Exit(1)
-		}
-		certificate, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			log.WithError(err).Errorln("Can't parse TLS certificate")
-			os.Exit(1)
+		if *acraServerConfigFile != "" {
+			*tlsIdentifierExtractorType, err = cmd.TLSIdentifierExtractorTypeFromConfig(*acraServerConfigFile)
+			if err != nil {
+				log.WithError(err).WithField("path", *acraServerConfigFile).Errorln("Can't read tls_identifier_extractor_type from AcraServer config")
+				os.Exit(1)
+			}
 		}
-		tlsClientID, err := clientIDExtractor.ExtractClientID(certificate)
+		tlsClientID, err := network.ClientIDFromCertificateFile(*tlsClientCert, *tlsIdentifierExtractorType)
 		if err != nil {
-			log.WithError(err).Errorln("Can't extract clientID from TLS certificate")
+			log.WithError(err).WithField("type", *tlsIdentifierExtractorType).Errorln("Can't extract clientID from TLS certificate")
 			os.Exit(1)
 		}
 		*clientID = string(tlsClientID)
@@ -150,7 +135,8 @@ func main() {
 			os.Exit(1)
 		}
 
-		if keystoreOptions := keyloader.ParseCLIOptions(); keystoreOptions.KeystoreEncryptorType == keyloader.KeystoreStrategyKMSMasterKey {
+		switch keyloader.ParseCLIOptions().KeystoreEncryptorType {
+		case keyloader.KeystoreStrategyKMSMasterKey:
 			keyManager, err := kms.NewKeyManager(kms.ParseCLIParameters())
 			if err != nil {
 				log.WithError(err).WithField("path", *masterKey).Errorln("Failed to initializer kms KeyManager")
@@ -169,6 +155,29 @@ func main() {
 				log.WithField("supported", kms.SupportedPolicies).WithField("policy", *kmsKeyPolicy).Errorln("Unsupported key policy for `kms_key_policy`")
 				os.Exit(1)
 			}
+		case keyloader.KeystoreStrategyAzureKeyVaultMasterKey:
+			azureOptions := azure.ParseCLIParametersFromFlags(flag.CommandLine, "")
+			client, err := azureOptions.NewKeyVaultClient()
+			if err != nil {
+				log.WithError(err).Errorln("Failed to initialize Azure Key Vault client")
+				os.Exit(1)
+			}
+			newKey, err = azure.WrapMasterKey(client, azureOptions.KeyName, azureOptions.KeyVersion, azureOptions.Algorithm, newKey)
+			if err != nil {
+				log.WithError(err).WithField("path", *masterKey).Errorln("Failed to wrap master key with Azure Key Vault")
+				os.Exit(1)
+			}
+		case keyloader.KeystoreStrategyGCPKMSMasterKey:
+			client, err := gcp.ParseCLIParametersFromFlags(flag.CommandLine, "").NewKMSClient()
+			if err != nil {
+				log.WithError(err).Errorln("Failed to initialize Cloud KMS client")
+				os.Exit(1)
+			}
+			newKey, err = gcp.EncryptMasterKey(client, newKey)
+			if err != nil {
+				log.WithError(err).WithField("path", *masterKey).Errorln("Failed to encrypt master key with Cloud KMS")
+				os.Exit(1)
+			}
 		}
 
 		if err := ioutil.WriteFile(*masterKey, newKey, 0600); err != nil {
@@ -297,18 +306,13 @@ func openKeyStoreV1(output, outputPublic string) keystore.KeyMaking {
 	keyStoreBuilder.Encryptor(keyStoreEncryptor)
 
 	if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
-		// if redisTLS = nil then will not be used TLS for Redis
-		var redisTLS *tls.Config
-		var err error
-		if redis.TLSEnable {
-			redisTLS, err = network.NewTLSConfigByName(flag.CommandLine, "redis", redis.HostPort, network.ClientNameConstructorFunc())
-			if err != nil {
-				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
-					Errorln("Can't initialize TLS config for Redis client")
-				os.Exit(1)
-			}
+		redisOptions, err := redis.KeysOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
+				Errorln("Can't get Redis options")
+			os.Exit(1)
 		}
-		keyStorage, err := filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, redisTLS)
+		keyStorage, err := filesystem.NewRedisStorage(redisOptions)
 		if err != nil {
 			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
 				Errorln("Can't initialize Redis client")
@@ -25,6 +25,9 @@
 //   - read key data
 //   - destroy keys
 //   - generate keys
+//   - rotate and destroy keys selected by tags
+//   - inspect keystore configuration
+//   - approve destruction and rotation of keys requested by another operator
 package main
 
 import (
@@ -41,6 +44,11 @@ func main() {
 		&keys.DestroyKeySubcommand{},
 		&keys.GenerateKeySubcommand{},
 		&keys.ExtractClientIDSubcommand{},
+		&keys.InspectKeystoreSubcommand{},
+		&keys.RotateKeysSubcommand{},
+		&keys.AuditKeystoreSubcommand{},
+		&keys.VerifyStorageSubcommand{},
+		&keys.ApproveSubcommand{},
 	}
 	subcommand := keys.ParseParameters(subcommands)
 	if subcommand != nil {
@@ -0,0 +1,424 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// ErrInvalidApprovalParameters error represent approval request without operator key or with options which can't be approved
+var ErrInvalidApprovalParameters = errors.New("approval request requires --operator_key and supports only permanent destruction and rotation by tags")
+
+// ErrMissingApprovalRequestID error represent "acra-keys approve" without request ID, --list_requests, --reject or --init_policy
+var ErrMissingApprovalRequestID = errors.New("missing approval request ID")
+
+// ErrMissingOperatorsDir error represent "acra-keys approve --init_policy" without --operators_dir
+var ErrMissingOperatorsDir = errors.New("approval policy requires --operators_dir")
+
+// ApprovalParameters are parameters of operators who request and approve key operations.
+type ApprovalParameters struct {
+	requestApproval bool
+	operatorKey     string
+}
+
+// Register registers operator flags with the given flag set.
+func (p *ApprovalParameters) Register(flags *flag.FlagSet) {
+	flags.StringVar(&p.operatorKey, "operator_key", "", "Path to PEM encoded Ed25519 private key of operator trusted by approval policy of keystore which signs approval requests")
+}
+
+// RegisterApprovalRequest registers operator flags and --approval_request flag of destructive operations.
+func (p *ApprovalParameters) RegisterApprovalRequest(flags *flag.FlagSet) {
+	p.Register(flags)
+	flags.BoolVar(&p.requestApproval, "approval_request", false, "Save pending request signed by --operator_key instead of execution. Another operator executes it with \"acra-keys approve\"")
+}
+
+// RequestApproval returns true if operation should be saved as pending approval request.
+func (p *ApprovalParameters) RequestApproval() bool {
+	return p.requestApproval
+}
+
+// validate checks that operator key is configured.
+func (p *ApprovalParameters) validate() error {
+	if p.operatorKey == "" {
+		log.Errorln("Approval of key operations requires --operator_key")
+		return ErrInvalidApprovalParameters
+	}
+	return nil
+}
+
+// LoadOperator returns approval policy of keystore and the operator of --operator_key trusted by it.
+func (p *ApprovalParameters) LoadOperator(approvalStore keystore.ApprovalStore) (*keystore.ApprovalPolicy, *keystore.Operator, error) {
+	policy, err := approvalStore.GetApprovalPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+	operator, err := policy.Operators.LoadOperator(p.operatorKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policy, operator, nil
+}
+
+// CheckApprovalPolicy returns ErrApprovalRequired if operation should be requested with --approval_request because
+// keystore has approval policy.
+func (p *ApprovalParameters) CheckApprovalPolicy(keyStore keystore.KeyMaking) error {
+	if p.requestApproval {
+		return nil
+	}
+	return keystore.CheckApprovalPolicy(keyStore)
+}
+
+// AsApprovalStore returns keystore as ApprovalStore or ErrApprovalsNotSupported if it can't store approval requests.
+func AsApprovalStore(keyStore interface{}) (keystore.ApprovalStore, error) {
+	approvalStore, ok := keyStore.(keystore.ApprovalStore)
+	if !ok {
+		return nil, keystore.ErrApprovalsNotSupported
+	}
+	return approvalStore, nil
+}
+
+// SaveApprovalRequest signs request of key operation by the operator and saves it into keystore instead of execution.
+func SaveApprovalRequest(params *ApprovalParameters, request *keystore.ApprovalRequest, keyStore keystore.KeyMaking, writer io.Writer) error {
+	approvalStore, err := AsApprovalStore(keyStore)
+	if err != nil {
+		return err
+	}
+	_, operator, err := params.LoadOperator(approvalStore)
+	if err != nil {
+		return err
+	}
+	if err := request.Sign(operator); err != nil {
+		return err
+	}
+	if err := approvalStore.SaveApprovalRequest(request); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": request.ID, "operation": request.Operation, "requester": request.Requester}).Infoln("Saved approval request")
+	fmt.Fprintf(writer, "Approval request %s should be confirmed by another operator with \"acra-keys %s %s\"\n", request.ID, CmdApprove, request.ID)
+	return nil
+}
+
+// ApproveSubcommand is the "acra-keys approve" subcommand.
+type ApproveSubcommand struct {
+	CommonKeyStoreParameters
+	FlagSet  *flag.FlagSet
+	approval ApprovalParameters
+
+	requestID    string
+	listRequests bool
+	rejectID     string
+	initPolicy   bool
+	operatorsDir string
+	requestTTL   time.Duration
+}
+
+// Name returns the same of this subcommand.
+func (p *ApproveSubcommand) Name() string {
+	return CmdApprove
+}
+
+// GetFlagSet returns flag set of this subcommand.
+func (p *ApproveSubcommand) GetFlagSet() *flag.FlagSet {
+	return p.FlagSet
+}
+
+// RegisterFlags registers command-line flags of "acra-keys approve".
+func (p *ApproveSubcommand) RegisterFlags() {
+	p.FlagSet = flag.NewFlagSet(CmdApprove, flag.ContinueOnError)
+	p.CommonKeyStoreParameters.Register(p.FlagSet)
+	p.approval.Register(p.FlagSet)
+	p.FlagSet.BoolVar(&p.listRequests, "list_requests", false, "List pending approval requests")
+	p.FlagSet.StringVar(&p.rejectID, "reject", "", "Remove pending approval request with the ID without execution")
+	p.FlagSet.BoolVar(&p.initPolicy, "init_policy", false, "Save approval policy with operators from --operators_dir into keystore. After that destruction and rotation of keys require approval. Saved policy can't be changed by acra-keys")
+	p.FlagSet.StringVar(&p.operatorsDir, "operators_dir", "", "Folder with PEM encoded Ed25519 public keys of trusted operators named <operator>.pub saved into approval policy with --init_policy")
+	p.FlagSet.DurationVar(&p.requestTTL, "request_ttl", keystore.DefaultApprovalRequestTTL, "Time after creation when approval request may be approved, saved into approval policy with --init_policy")
+	p.FlagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command \"%s\": confirm and execute key operation requested by another operator\n", CmdApprove)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...] --operator_key <path> <request-ID>\n", os.Args[0], CmdApprove)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --operator_key <path> --reject <request-ID>\n", os.Args[0], CmdApprove)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --list_requests\n", os.Args[0], CmdApprove)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --init_policy --operators_dir <path> [--request_ttl <duration>]\n", os.Args[0], CmdApprove)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		cmd.PrintFlags(p.FlagSet)
+	}
+}
+
+// Parse command-line parameters of the subcommand.
+func (p *ApproveSubcommand) Parse(arguments []string) error {
+	err := cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+	if err != nil {
+		return err
+	}
+	args := p.FlagSet.Args()
+	if p.listRequests {
+		return nil
+	}
+	if p.initPolicy {
+		if p.operatorsDir == "" {
+			log.Errorln("--init_policy requires --operators_dir")
+			return ErrMissingOperatorsDir
+		}
+		return nil
+	}
+	switch {
+	case p.rejectID != "" && len(args) == 0:
+	case p.rejectID == "" && len(args) == 1:
+		p.requestID = args[0]
+	default:
+		log.Errorf("\"%s\" command requires either request ID, --reject, --list_requests or --init_policy", CmdApprove)
+		return ErrMissingApprovalRequestID
+	}
+	return p.approval.validate()
+}
+
+// Execute this subcommand.
+func (p *ApproveSubcommand) Execute() {
+	keyStore, err := OpenKeyStoreForWriting(p)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to open keystore")
+	}
+	switch {
+	case p.listRequests:
+		if err := ListApprovalRequests(keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to list approval requests")
+		}
+	case p.initPolicy:
+		if err := InitApprovalPolicy(p.operatorsDir, p.requestTTL, keyStore); err != nil {
+			log.WithError(err).Fatal("Failed to save approval policy")
+		}
+	case p.rejectID != "":
+		if err := RejectApprovalRequest(&p.approval, p.rejectID, keyStore); err != nil {
+			log.WithError(err).Fatal("Failed to reject approval request")
+		}
+	default:
+		if err := ApproveRequest(&p.approval, p.requestID, keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to approve request")
+		}
+	}
+}
+
+// InitApprovalPolicy saves approval policy with trusted operators from operatorsDir into keystore. Keystore with
+// policy requires approval of key destruction and rotation, its policy can't be replaced.
+func InitApprovalPolicy(operatorsDir string, requestTTL time.Duration, keyStore keystore.KeyMaking) error {
+	approvalStore, err := AsApprovalStore(keyStore)
+	if err != nil {
+		return err
+	}
+	policy, err := keystore.NewApprovalPolicy(operatorsDir, requestTTL)
+	if err != nil {
+		return err
+	}
+	if err := approvalStore.InitApprovalPolicy(policy); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(policy.Operators))
+	for name := range policy.Operators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.WithFields(log.Fields{"operators": strings.Join(names, ","), "request_ttl": policy.RequestTTL}).Infoln("Saved approval policy")
+	return nil
+}
+
+// ListApprovalRequests prints pending approval requests into the writer.
+func ListApprovalRequests(keyStore keystore.KeyMaking, writer io.Writer) error {
+	approvalStore, err := AsApprovalStore(keyStore)
+	if err != nil {
+		return err
+	}
+	policy, err := approvalStore.GetApprovalPolicy()
+	if err != nil && err != keystore.ErrApprovalPolicyNotConfigured {
+		return err
+	}
+	requests, err := approvalStore.ListApprovalRequests()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, request := range requests {
+		expired := ""
+		if policy != nil && now.Sub(request.CreatedAt) > policy.RequestTTL {
+			expired = " (expired)"
+		}
+		fmt.Fprintf(writer, "%s | %s %s | requested by %s at %s%s\n", request.ID, request.Operation, describeApprovalRequest(request), request.Requester, request.CreatedAt.Format(time.RFC3339), expired)
+	}
+	return nil
+}
+
+// describeApprovalRequest returns arguments of requested operation like arguments of "acra-keys destroy" and "acra-keys rotate".
+func describeApprovalRequest(request *keystore.ApprovalRequest) string {
+	arguments := make([]string, 0, 3)
+	switch {
+	case len(request.TagSelector) > 0:
+		arguments = append(arguments, "--tag_selector "+request.TagSelector.String())
+	case len(request.KeyIDPatterns) > 0:
+		arguments = append(arguments, strings.Join(request.KeyIDPatterns, " "))
+	default:
+		arguments = append(arguments, fmt.Sprintf("%s %s --index %d", request.KeyKind, request.ClientID, request.Index))
+	}
+	if request.RotatedKeys {
+		arguments = append(arguments, "--rotated-keys")
+	}
+	return strings.Join(arguments, " ")
+}
+
+// RejectApprovalRequest removes pending approval request. Any trusted operator may reject it.
+func RejectApprovalRequest(params *ApprovalParameters, id string, keyStore keystore.KeyMaking) error {
+	approvalStore, err := AsApprovalStore(keyStore)
+	if err != nil {
+		return err
+	}
+	_, operator, err := params.LoadOperator(approvalStore)
+	if err != nil {
+		return err
+	}
+	if err := approvalStore.RemoveApprovalRequest(id); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"id": id, "operator": operator.Name}).Infoln("Rejected approval request")
+	return nil
+}
+
+// ApproveRequest confirms pending request by the second operator, saves audit record with signatures of both
+// operators, executes requested operation and removes the request. Expired requests aren't executed.
+func ApproveRequest(params *ApprovalParameters, id string, keyStore keystore.KeyMaking, writer io.Writer) error {
+	approvalStore, err := AsApprovalStore(keyStore)
+	if err != nil {
+		return err
+	}
+	policy, approver, err := params.LoadOperator(approvalStore)
+	if err != nil {
+		return err
+	}
+	request, err := keystore.GetApprovalRequest(approvalStore, id)
+	if err != nil {
+		return err
+	}
+	record, err := policy.Approve(request, approver, time.Now())
+	if err != nil {
+		return err
+	}
+	// operation isn't executed without evidence of approval
+	if err := approvalStore.SaveApprovalAuditRecord(record); err != nil {
+		return err
+	}
+	if err := ExecuteApprovalRequest(request, keyStore, writer); err != nil {
+		return err
+	}
+	if err := approvalStore.RemoveApprovalRequest(request.ID); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"id":        request.ID,
+		"operation": request.Operation,
+		"requester": request.Requester,
+		"approver":  approver.Name,
+		"approval":  hex.EncodeToString(record.ApproverSignature),
+	}).Infoln("Executed approved request")
+	return nil
+}
+
+// ExecuteApprovalRequest executes operation of approved request.
+func ExecuteApprovalRequest(request *keystore.ApprovalRequest, keyStore keystore.KeyMaking, writer io.Writer) error {
+	operation := approvedOperation{request}
+	switch request.Operation {
+	case keystore.ApprovalOperationDestroy:
+		switch {
+		case len(request.TagSelector) > 0:
+			return DestroyKeysByTags(operation, keyStore)
+		case len(request.KeyIDPatterns) > 0:
+			return DestroyKeysByPatterns(operation, keyStore, nil, writer)
+		default:
+			return DestroyKey(operation, keyStore)
+		}
+	case keystore.ApprovalOperationRotate:
+		return RotateKeysByTags(operation, keyStore)
+	}
+	return keystore.ErrUnsupportedApprovalRequest
+}
+
+// approvedOperation provides parameters of "acra-keys destroy" and "acra-keys rotate" from approved request.
+// Approved keys are always destroyed permanently without additional confirmation.
+type approvedOperation struct {
+	request *keystore.ApprovalRequest
+}
+
+// DestroyKeyKind returns kind of the key to destroy.
+func (operation approvedOperation) DestroyKeyKind() string {
+	return operation.request.KeyKind
+}
+
+// ClientID returns client ID of the key to destroy.
+func (operation approvedOperation) ClientID() []byte {
+	if operation.request.ClientID == "" {
+		return nil
+	}
+	return []byte(operation.request.ClientID)
+}
+
+// Index returns index of the key to destroy.
+func (operation approvedOperation) Index() int {
+	return operation.request.Index
+}
+
+// KeyTagSelector returns tags of selected keys.
+func (operation approvedOperation) KeyTagSelector() keystore.KeyTags {
+	return operation.request.TagSelector
+}
+
+// DestroyRotatedKeys returns true if rotated keys should be destroyed instead of current ones.
+func (operation approvedOperation) DestroyRotatedKeys() bool {
+	return operation.request.RotatedKeys
+}
+
+// KeyIDPatterns returns key IDs with optional wildcards of keys to destroy.
+func (operation approvedOperation) KeyIDPatterns() []string {
+	return operation.request.KeyIDPatterns
+}
+
+// AssumeYes returns true because destruction is confirmed by approval.
+func (operation approvedOperation) AssumeYes() bool {
+	return true
+}
+
+// DryRun returns false because approved keys are destroyed.
+func (operation approvedOperation) DryRun() bool {
+	return false
+}
+
+// SoftDestroy returns false because approved keys are destroyed permanently.
+func (operation approvedOperation) SoftDestroy() bool {
+	return false
+}
+
+// GracePeriod returns zero period because approved keys are destroyed permanently.
+func (operation approvedOperation) GracePeriod() time.Duration {
+	return 0
+}
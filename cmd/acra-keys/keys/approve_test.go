@@ -0,0 +1,222 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+)
+
+// newTestOperator saves keys of operator and returns parameters with its private key
+func newTestOperator(t *testing.T, name, operatorsDir string) ApprovalParameters {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateData, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicData, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), name+".key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateData}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(operatorsDir, name+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicData}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return ApprovalParameters{requestApproval: true, operatorKey: keyPath}
+}
+
+func TestApproveKeyOperations_FS_V1(t *testing.T) {
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdApprove, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	store, err := openKeyStoreV1(&GenerateKeySubcommand{CommonKeyStoreParameters: CommonKeyStoreParameters{keyDir: dirName}, flagSet: flagSet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	if err := store.GenerateClientIDSymmetricKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+
+	operatorsDir := t.TempDir()
+	alice := newTestOperator(t, "alice", operatorsDir)
+	bob := newTestOperator(t, "bob", operatorsDir)
+
+	destroyCMD := &DestroyKeySubcommand{destroyKeyKind: keystore.KeySymmetric, contextID: clientID, index: 1}
+	output := &bytes.Buffer{}
+	// operators are trusted only by approval policy of keystore
+	if err := SaveApprovalRequest(&alice, destroyCMD.approvalRequest(), store, output); err != keystore.ErrApprovalPolicyNotConfigured {
+		t.Fatalf("Expected ErrApprovalPolicyNotConfigured, took %v", err)
+	}
+	if err := destroyCMD.approval.CheckApprovalPolicy(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitApprovalPolicy(operatorsDir, 0, store); err != nil {
+		t.Fatal(err)
+	}
+	// policy can't be replaced with another operators
+	mallory := newTestOperator(t, "mallory", t.TempDir())
+	if err := InitApprovalPolicy(t.TempDir(), 0, store); err != keystore.ErrNotEnoughOperators {
+		t.Fatalf("Expected ErrNotEnoughOperators, took %v", err)
+	}
+	if err := InitApprovalPolicy(operatorsDir, time.Minute, store); err != keystore.ErrApprovalPolicyExists {
+		t.Fatalf("Expected ErrApprovalPolicyExists, took %v", err)
+	}
+	if err := SaveApprovalRequest(&mallory, destroyCMD.approvalRequest(), store, output); err != keystore.ErrUnknownOperator {
+		t.Fatalf("Expected ErrUnknownOperator, took %v", err)
+	}
+	// destruction without approval is forbidden by policy
+	if err := destroyCMD.approval.CheckApprovalPolicy(store); err != keystore.ErrApprovalRequired {
+		t.Fatalf("Expected ErrApprovalRequired, took %v", err)
+	}
+
+	if err := SaveApprovalRequest(&alice, destroyCMD.approvalRequest(), store, output); err != nil {
+		t.Fatal(err)
+	}
+	requests, err := store.ListApprovalRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].Requester != "alice" || !strings.Contains(output.String(), requests[0].ID) {
+		t.Fatalf("Expected pending request of alice, took %v", requests)
+	}
+	id := requests[0].ID
+
+	// request is not executed until approval, requester can't approve it
+	if _, err := store.GetClientIDSymmetricKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApproveRequest(&alice, id, store, output); err != keystore.ErrSameOperator {
+		t.Fatalf("Expected ErrSameOperator, took %v", err)
+	}
+	listOutput := &bytes.Buffer{}
+	if err := ListApprovalRequests(store, listOutput); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(listOutput.String(), id) || !strings.Contains(listOutput.String(), "requested by alice") {
+		t.Fatalf("Expected pending request in list, took %s", listOutput.String())
+	}
+
+	if err := ApproveRequest(&bob, id, store, output); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClientIDSymmetricKey(clientID); err == nil {
+		t.Fatal("Expected destroyed key after approval")
+	}
+	records, err := store.ListApprovalAuditRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Request.ID != id || records[0].Approver != "bob" {
+		t.Fatalf("Expected audit record of approved request, took %v", records)
+	}
+	policy, err := store.GetApprovalPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := records[0].Verify(policy.Operators); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keystore.GetApprovalRequest(store, id); err != keystore.ErrApprovalRequestNotFound {
+		t.Fatalf("Expected removed request after execution, took %v", err)
+	}
+
+	// rejected rotation is removed without execution
+	tags, _ := keystore.ParseKeyTags("env=staging")
+	request := &keystore.ApprovalRequest{Operation: keystore.ApprovalOperationRotate, TagSelector: tags}
+	if err := SaveApprovalRequest(&bob, request, store, output); err != nil {
+		t.Fatal(err)
+	}
+	if err := RejectApprovalRequest(&alice, request.ID, store); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApproveRequest(&alice, request.ID, store, output); err != keystore.ErrApprovalRequestNotFound {
+		t.Fatalf("Expected ErrApprovalRequestNotFound, took %v", err)
+	}
+
+	// expired request isn't executed
+	request = &keystore.ApprovalRequest{Operation: keystore.ApprovalOperationRotate, TagSelector: tags}
+	if err := SaveApprovalRequest(&alice, request, store, output); err != nil {
+		t.Fatal(err)
+	}
+	request.CreatedAt = request.CreatedAt.Add(-policy.RequestTTL - time.Minute)
+	if err := store.SaveApprovalRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApproveRequest(&bob, request.ID, store, output); err != keystore.ErrApprovalRequestExpired {
+		t.Fatalf("Expected ErrApprovalRequestExpired, took %v", err)
+	}
+	listOutput.Reset()
+	if err := ListApprovalRequests(store, listOutput); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(listOutput.String(), "(expired)") {
+		t.Fatalf("Expected expired request in list, took %s", listOutput.String())
+	}
+	if records, err := store.ListApprovalAuditRecords(); err != nil || len(records) != 1 {
+		t.Fatalf("Expected only audit record of executed request, took %v, %v", records, err)
+	}
+}
+
+func TestApprovalParametersValidation(t *testing.T) {
+	rotateCMD := &RotateKeysSubcommand{}
+	rotateCMD.RegisterFlags()
+	if err := rotateCMD.Parse([]string{"--approval_request", "--tag_selector", "env=staging"}); err != ErrInvalidApprovalParameters {
+		t.Fatalf("Expected ErrInvalidApprovalParameters without operator keys, took %v", err)
+	}
+	destroyCMD := &DestroyKeySubcommand{}
+	destroyCMD.RegisterFlags()
+	if err := destroyCMD.Parse([]string{"--approval_request", "--operator_key", "key", "--soft", "client/client/symmetric"}); err != ErrInvalidApprovalParameters {
+		t.Fatalf("Expected ErrInvalidApprovalParameters with soft destruction, took %v", err)
+	}
+	if _, err := AsApprovalStore(struct{ keystore.KeyMaking }{}); err != keystore.ErrApprovalsNotSupported {
+		t.Fatalf("Expected ErrApprovalsNotSupported, took %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+)
+
+// Values of KeystoreAuditReport status
+const (
+	AuditStatusOK       = "ok"
+	AuditStatusWarning  = "warning"
+	AuditStatusCritical = "critical"
+	AuditStatusFailed   = "failed"
+)
+
+// Exit codes of "acra-keys audit" follow monitoring plugins convention
+const (
+	AuditExitCodeOK = iota
+	AuditExitCodeWarning
+	AuditExitCodeCritical
+	AuditExitCodeFailed
+)
+
+// ErrAuditNotSupported returned for keystore backends without integrity audit support
+var ErrAuditNotSupported = errors.New("keystore doesn't support integrity audit")
+
+// KeyRingAuditor is implemented by keystores which support integrity audit of key rings.
+type KeyRingAuditor interface {
+	AuditKeyRings() (*filesystemV2.KeyRingAuditReport, error)
+}
+
+// KeystoreAuditReport is the result of "acra-keys audit".
+type KeystoreAuditReport struct {
+	Status string `json:"status"`
+	*filesystemV2.KeyRingAuditReport
+	Error string `json:"error,omitempty"`
+}
+
+// ExitCode returns process exit code corresponding to report status.
+func (report *KeystoreAuditReport) ExitCode() int {
+	return auditExitCode(report.Status)
+}
+
+// auditExitCode returns process exit code corresponding to status of audit or verification.
+func auditExitCode(status string) int {
+	switch status {
+	case AuditStatusOK:
+		return AuditExitCodeOK
+	case AuditStatusWarning:
+		return AuditExitCodeWarning
+	case AuditStatusCritical:
+		return AuditExitCodeCritical
+	}
+	return AuditExitCodeFailed
+}
+
+// AuditKeystoreSubcommand is the "acra-keys audit" subcommand.
+type AuditKeystoreSubcommand struct {
+	CommonKeyStoreParameters
+	FlagSet *flag.FlagSet
+
+	useJSON   bool
+	outWriter io.Writer
+}
+
+// Name returns the same of this subcommand.
+func (p *AuditKeystoreSubcommand) Name() string {
+	return CmdAuditKeystore
+}
+
+// GetFlagSet returns flag set of this subcommand.
+func (p *AuditKeystoreSubcommand) GetFlagSet() *flag.FlagSet {
+	return p.FlagSet
+}
+
+// RegisterFlags registers command-line flags of "acra-keys audit".
+func (p *AuditKeystoreSubcommand) RegisterFlags() {
+	p.FlagSet = flag.NewFlagSet(CmdAuditKeystore, flag.ContinueOnError)
+	p.CommonKeyStoreParameters.Register(p.FlagSet)
+	p.FlagSet.BoolVar(&p.useJSON, "json", false, "use machine-readable JSON output")
+	p.FlagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command \"%s\": verify integrity of all key rings of keystore v2\n", CmdAuditKeystore)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...]\n", os.Args[0], CmdAuditKeystore)
+		fmt.Fprintf(os.Stderr, "\nExit codes: %d - no issues, %d - orphaned files or invalid permissions, %d - invalid signatures, truncated or corrupted key rings, %d - audit failed\n",
+			AuditExitCodeOK, AuditExitCodeWarning, AuditExitCodeCritical, AuditExitCodeFailed)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		cmd.PrintFlags(p.FlagSet)
+	}
+}
+
+// Parse command-line parameters of the subcommand.
+func (p *AuditKeystoreSubcommand) Parse(arguments []string) error {
+	return cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+}
+
+// Execute this subcommand.
+func (p *AuditKeystoreSubcommand) Execute() {
+	report := AuditKeyStore(p)
+	writer := p.outWriter
+	if writer == nil {
+		writer = os.Stdout
+	}
+	var err error
+	if p.useJSON {
+		err = printKeystoreAuditReportJSON(report, writer)
+	} else {
+		err = printKeystoreAuditReport(report, writer)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Failed to print keystore audit report")
+		os.Exit(AuditExitCodeFailed)
+	}
+	os.Exit(report.ExitCode())
+}
+
+// AuditKeyStore verifies integrity of key rings and returns report with status of the keystore.
+// Failures of the audit itself are recorded in the report with AuditStatusFailed.
+func AuditKeyStore(params KeyStoreParameters) *KeystoreAuditReport {
+	report := &KeystoreAuditReport{Status: AuditStatusFailed}
+	auditor, err := OpenKeyStoreForAudit(params)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.KeyRingAuditReport, err = auditor.AuditKeyRings()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to audit keystore")
+		report.Error = err.Error()
+		return report
+	}
+	report.Status = AuditStatusOK
+	for _, issue := range report.Issues {
+		if issue.Critical() {
+			report.Status = AuditStatusCritical
+			break
+		}
+		report.Status = AuditStatusWarning
+	}
+	return report
+}
+
+func printKeystoreAuditReportJSON(report *KeystoreAuditReport, writer io.Writer) error {
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(writer, string(jsonReport))
+	return err
+}
+
+func printKeystoreAuditReport(report *KeystoreAuditReport, writer io.Writer) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Status:    %s\n", report.Status)
+	if report.Error != "" {
+		fmt.Fprintf(&buffer, "Error:     %s\n", report.Error)
+	}
+	if report.KeyRingAuditReport != nil {
+		fmt.Fprintf(&buffer, "Key rings: %d\n", report.KeyRings)
+		fmt.Fprintf(&buffer, "Verified:  %d\n", report.Verified)
+		if len(report.Issues) > 0 {
+			fmt.Fprintf(&buffer, "Issues:\n")
+		}
+		for _, issue := range report.Issues {
+			fmt.Fprintf(&buffer, "  %-20s %s: %s\n", issue.Issue, issue.Path, issue.Error)
+		}
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"encoding/base64"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+)
+
+func TestAuditKeyStore_FS_V2(t *testing.T) {
+	dirName := filepath.Join(t.TempDir(), "keys")
+
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystoreV2.NewSerializedMasterKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdAuditKeystore, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+	auditCmd := &AuditKeystoreSubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{keyDir: dirName},
+		FlagSet:                  flagSet,
+	}
+
+	report := AuditKeyStore(auditCmd)
+	if report.Status != AuditStatusFailed || report.ExitCode() != AuditExitCodeFailed {
+		t.Fatalf("Expected failed audit of missing keystore, took %s", report.Status)
+	}
+
+	store, err := openKeyStoreV2(auditCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GenerateClientIDSymmetricKey([]byte("client1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GenerateClientIDSymmetricKey([]byte("client2")); err != nil {
+		t.Fatal(err)
+	}
+	report = AuditKeyStore(auditCmd)
+	if report.Status != AuditStatusOK || report.KeyRings != 2 || report.Verified != 2 {
+		t.Fatalf("Unexpected report of valid keystore: %+v", report)
+	}
+
+	err = filepath.Walk(dirName, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".keyring" {
+			return err
+		}
+		return os.WriteFile(path+".new", []byte("leftover"), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = AuditKeyStore(auditCmd)
+	if report.Status != AuditStatusWarning || report.ExitCode() != AuditExitCodeWarning {
+		t.Fatalf("Expected warning for orphaned files, took %+v", report)
+	}
+
+	err = filepath.Walk(dirName, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".keyring" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data[:len(data)-1], 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = AuditKeyStore(auditCmd)
+	if report.Status != AuditStatusCritical || report.ExitCode() != AuditExitCodeCritical {
+		t.Fatalf("Expected critical status for truncated key rings, took %+v", report)
+	}
+	for _, issue := range report.Issues {
+		if issue.Issue != filesystemV2.AuditIssueOrphaned && issue.Issue != filesystemV2.AuditIssueTruncated {
+			t.Fatalf("Unexpected issue %+v", issue)
+		}
+	}
+}
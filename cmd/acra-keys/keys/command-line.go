@@ -50,6 +50,11 @@ const (
 	CmdReadKey         = "read"
 	CmdDestroyKey      = "destroy"
 	CmdExtractClientID = "extract-client-id"
+	CmdInspectKeystore = "inspect-keystore"
+	CmdRotateKeys      = "rotate"
+	CmdAuditKeystore   = "audit"
+	CmdVerifyStorage   = "verify-storage"
+	CmdApprove         = "approve"
 )
 
 // Command-line parsing errors:
@@ -85,6 +90,7 @@ func ParseParameters(subcommands []Subcommand) Subcommand {
 		}
 		fmt.Fprintf(os.Stderr, "\nSupported commands:\n  %s\n", strings.Join(names, ", "))
 	}
+	loggingParams := cmd.RegisterLoggingParameters()
 	for _, c := range subcommands {
 		c.RegisterFlags()
 	}
@@ -98,6 +104,10 @@ func ParseParameters(subcommands []Subcommand) Subcommand {
 			WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
 			Fatal("Cannot parse arguments")
 	}
+	// log file is closed on process exit after subcommand execution
+	if _, err := loggingParams.Apply(ServiceName, logging.LogVerbose); err != nil {
+		log.WithError(err).Fatal("Can't initialise output writer for logging customization")
+	}
 	return subcommand
 }
 
@@ -20,7 +20,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -34,6 +36,19 @@ var SupportedDestroyKeyKinds = []string{}
 // ErrInvalidIndex error represent invalid index for --index flag
 var ErrInvalidIndex = errors.New("invalid index value provided")
 
+// ErrKeyIDWithTagSelector error represent key ID or --index used together with --tag_selector
+var ErrKeyIDWithTagSelector = errors.New("key ID and index can't be used with tag selector")
+
+// ErrInvalidGracePeriod error represent invalid value of --grace_period_days flag
+var ErrInvalidGracePeriod = errors.New("grace period of soft deleted keys should be positive")
+
+// ErrConflictingSoftDeleteOptions error represent --restore, --purge_expired or --list_soft_deleted used together
+// or with key ID and tag selector
+var ErrConflictingSoftDeleteOptions = errors.New("soft deleted keys options can't be used together or with key ID")
+
+// DefaultSoftDeleteGracePeriodDays is the number of days soft deleted keys may be restored
+const DefaultSoftDeleteGracePeriodDays = 7
+
 // DestroyKeyParams are parameters of "acra-keys destroy" subcommand.
 type DestroyKeyParams interface {
 	DestroyKeyKind() string
@@ -41,6 +56,27 @@ type DestroyKeyParams interface {
 	Index() int
 }
 
+// DestroyKeyModeParams are parameters of "acra-keys destroy" subcommand which define what happens with key material.
+type DestroyKeyModeParams interface {
+	DryRun() bool
+	SoftDestroy() bool
+	GracePeriod() time.Duration
+}
+
+// DestroyKeysByPatternsParams are parameters of "acra-keys destroy" subcommand for multiple keys selected by key IDs with wildcards.
+type DestroyKeysByPatternsParams interface {
+	DestroyKeyModeParams
+	KeyIDPatterns() []string
+	DestroyRotatedKeys() bool
+	AssumeYes() bool
+}
+
+// DestroyKeysByTagsParams are parameters of "acra-keys destroy" subcommand for keys selected by tags.
+type DestroyKeysByTagsParams interface {
+	KeyTagSelector() keystore.KeyTags
+	DestroyRotatedKeys() bool
+}
+
 // DestroyKeySubcommand is the "acra-keys destroy" subcommand.
 type DestroyKeySubcommand struct {
 	CommonKeyStoreParameters
@@ -49,6 +85,20 @@ type DestroyKeySubcommand struct {
 	index          int
 	destroyKeyKind string
 	contextID      []byte
+	tagSelector    string
+	keyTagSelector keystore.KeyTags
+	rotatedKeys    bool
+	keyIDPatterns  []string
+	assumeYes      bool
+
+	dryRun          bool
+	soft            bool
+	gracePeriodDays int
+	restoreID       string
+	purgeExpired    bool
+	listSoftDeleted bool
+
+	approval ApprovalParameters
 }
 
 // Name returns the same of this subcommand.
@@ -66,9 +116,23 @@ func (p *DestroyKeySubcommand) RegisterFlags() {
 	p.FlagSet = flag.NewFlagSet(CmdReadKey, flag.ContinueOnError)
 	p.CommonKeyStoreParameters.Register(p.FlagSet)
 	p.FlagSet.IntVar(&p.index, "index", 1, "Index of key to destroy (1 - represents current key, 2..n - rotated key)")
+	p.FlagSet.StringVar(&p.tagSelector, "tag_selector", "", "Destroy all keys with all of the tags instead of key ID: <name>=<value>[,<name>=<value>...]")
+	p.FlagSet.BoolVar(&p.rotatedKeys, "rotated-keys", false, "Destroy rotated keys selected by --tag_selector or key ID patterns instead of current ones")
+	p.FlagSet.BoolVar(&p.assumeYes, "yes", false, "Destroy multiple keys selected by key IDs or patterns without confirmation")
+	p.FlagSet.BoolVar(&p.dryRun, "dry-run", false, "Only print key files which would be destroyed without changing keystore")
+	p.FlagSet.BoolVar(&p.soft, "soft", false, "Soft delete keys which may be restored with --restore until grace period passes")
+	p.FlagSet.IntVar(&p.gracePeriodDays, "grace_period_days", DefaultSoftDeleteGracePeriodDays, "Number of days soft deleted keys may be restored before permanent shredding")
+	p.FlagSet.StringVar(&p.restoreID, "restore", "", "Restore soft deleted key file with the ID")
+	p.FlagSet.BoolVar(&p.purgeExpired, "purge_expired", false, "Permanently shred soft deleted keys with passed grace period")
+	p.FlagSet.BoolVar(&p.listSoftDeleted, "list_soft_deleted", false, "List soft deleted keys")
+	p.approval.RegisterApprovalRequest(p.FlagSet)
 	p.FlagSet.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Command \"%s\": destroy key material\n", CmdDestroyKey)
-		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...] <key-ID>\n\n", os.Args[0], CmdDestroyKey)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...] <key-ID>\n", os.Args[0], CmdDestroyKey)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] <key-ID-pattern> [<key-ID-pattern>...] [--rotated-keys] [--yes]\n", os.Args[0], CmdDestroyKey)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --tag_selector <name>=<value>[,<name>=<value>...] [--rotated-keys]\n", os.Args[0], CmdDestroyKey)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --list_soft_deleted | --restore <ID> | --purge_expired\n", os.Args[0], CmdDestroyKey)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --approval_request --operator_key <path> <key-ID> | <key-ID-pattern>... | --tag_selector <tags>\n\n", os.Args[0], CmdDestroyKey)
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		cmd.PrintFlags(p.FlagSet)
 	}
@@ -81,15 +145,36 @@ func (p *DestroyKeySubcommand) Parse(arguments []string) error {
 		return err
 	}
 	args := p.FlagSet.Args()
+	if p.approval.RequestApproval() {
+		if p.dryRun || p.soft || p.restoreID != "" || p.purgeExpired || p.listSoftDeleted {
+			log.Errorf("\"%s\" command supports --approval_request only with permanent destruction", CmdDestroyKey)
+			return ErrInvalidApprovalParameters
+		}
+		if err := p.approval.validate(); err != nil {
+			return err
+		}
+	}
+	if p.soft && p.gracePeriodDays <= 0 {
+		log.Errorf("\"%s\" expected --grace_period_days flag value greater than 0", CmdDestroyKey)
+		return ErrInvalidGracePeriod
+	}
+	if p.restoreID != "" || p.purgeExpired || p.listSoftDeleted {
+		return p.parseSoftDeleteOptions(args)
+	}
+	if p.tagSelector != "" {
+		return p.parseTagSelector(args)
+	}
+	if len(args) > 1 || (len(args) == 1 && isKeyIDPattern(args[0])) {
+		return p.parseKeyIDPatterns(args)
+	}
+	if p.rotatedKeys {
+		log.Errorf("\"%s\" command supports --rotated-keys only with --tag_selector or key ID patterns", CmdDestroyKey)
+		return ErrEmptyTagSelector
+	}
 	if len(args) < 1 {
 		log.Errorf("\"%s\" command requires key kind", CmdDestroyKey)
 		return ErrMissingKeyKind
 	}
-	// It makes sense to allow multiple keys, but currently we don't allow it.
-	if len(args) > 1 {
-		log.Errorf("\"%s\" command does not support more than one key kind", CmdDestroyKey)
-		return ErrMultipleKeyKinds
-	}
 
 	if p.index <= 0 {
 		log.Errorf("\"%s\" expected --index flag value greater than 1", CmdDestroyKey)
@@ -114,13 +199,111 @@ func (p *DestroyKeySubcommand) Parse(arguments []string) error {
 	return nil
 }
 
+func (p *DestroyKeySubcommand) parseKeyIDPatterns(args []string) error {
+	if p.index != 1 {
+		log.Errorf("\"%s\" command doesn't support --index with multiple key IDs or key ID patterns", CmdDestroyKey)
+		return ErrIndexWithKeyIDPatterns
+	}
+	if err := ValidateKeyIDPatterns(args); err != nil {
+		return err
+	}
+	p.keyIDPatterns = args
+	return nil
+}
+
+func (p *DestroyKeySubcommand) parseSoftDeleteOptions(args []string) error {
+	options := 0
+	for _, set := range []bool{p.restoreID != "", p.purgeExpired, p.listSoftDeleted} {
+		if set {
+			options++
+		}
+	}
+	if options > 1 || len(args) > 0 || p.tagSelector != "" || p.soft || p.rotatedKeys {
+		log.Errorf("\"%s\" command accepts only one of --restore, --purge_expired and --list_soft_deleted without key ID", CmdDestroyKey)
+		return ErrConflictingSoftDeleteOptions
+	}
+	return nil
+}
+
+func (p *DestroyKeySubcommand) parseTagSelector(args []string) error {
+	if len(args) > 0 || p.index != 1 {
+		log.Errorf("\"%s\" command accepts either key ID or --tag_selector", CmdDestroyKey)
+		return ErrKeyIDWithTagSelector
+	}
+	selector, err := keystore.ParseKeyTags(p.tagSelector)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid --tag_selector")
+		return err
+	}
+	if len(selector) == 0 {
+		log.Errorln("--tag_selector should contain at least one tag")
+		return ErrEmptyTagSelector
+	}
+	p.keyTagSelector = selector
+	return nil
+}
+
 // Execute this subcommand.
 func (p *DestroyKeySubcommand) Execute() {
 	keyStore, err := OpenKeyStoreForWriting(p)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to open keystore")
 	}
-	DestroyKeyCommand(p, keyStore)
+	switch {
+	case p.listSoftDeleted:
+		if err := ListSoftDeletedKeys(keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to list soft deleted keys")
+		}
+		return
+	case p.restoreID != "":
+		if err := RestoreSoftDeletedKey(p.restoreID, keyStore); err != nil {
+			log.WithError(err).Fatal("Failed to restore soft deleted key")
+		}
+		return
+	case p.purgeExpired:
+		if err := PurgeExpiredSoftDeletedKeys(p, keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to purge soft deleted keys")
+		}
+		return
+	}
+	if p.approval.RequestApproval() {
+		if err := SaveApprovalRequest(&p.approval, p.approvalRequest(), keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to request approval of keys destruction")
+		}
+		return
+	}
+	if err := p.approval.CheckApprovalPolicy(keyStore); err != nil {
+		log.WithError(err).Fatal("Keys destruction should be requested with --approval_request")
+	}
+	if err := SetKeyDestructionMode(p, keyStore); err != nil {
+		log.WithError(err).Fatal("Failed to destroy keys")
+	}
+	switch {
+	case len(p.keyTagSelector) > 0:
+		if err := DestroyKeysByTags(p, keyStore); err != nil {
+			log.WithError(err).Fatal("Failed to destroy keys")
+		}
+	case len(p.keyIDPatterns) > 0:
+		if err := DestroyKeysByPatterns(p, keyStore, os.Stdin, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to destroy keys")
+		}
+	default:
+		DestroyKeyCommand(p, keyStore)
+	}
+	PrintAffectedKeyFiles(p, keyStore, os.Stdout)
+}
+
+// approvalRequest returns request of destruction of the selected keys.
+func (p *DestroyKeySubcommand) approvalRequest() *keystore.ApprovalRequest {
+	return &keystore.ApprovalRequest{
+		Operation:     keystore.ApprovalOperationDestroy,
+		KeyKind:       p.destroyKeyKind,
+		ClientID:      string(p.contextID),
+		Index:         p.index,
+		KeyIDPatterns: p.keyIDPatterns,
+		TagSelector:   p.keyTagSelector,
+		RotatedKeys:   p.rotatedKeys,
+	}
 }
 
 // DestroyKeyKind returns requested kind of the key to destroy.
@@ -138,6 +321,237 @@ func (p *DestroyKeySubcommand) Index() int {
 	return p.index
 }
 
+// KeyTagSelector returns tags of keys to be destroyed.
+func (p *DestroyKeySubcommand) KeyTagSelector() keystore.KeyTags {
+	return p.keyTagSelector
+}
+
+// DestroyRotatedKeys returns true if rotated keys should be destroyed instead of current ones.
+func (p *DestroyKeySubcommand) DestroyRotatedKeys() bool {
+	return p.rotatedKeys
+}
+
+// KeyIDPatterns returns key IDs with optional wildcards of keys to be destroyed.
+func (p *DestroyKeySubcommand) KeyIDPatterns() []string {
+	return p.keyIDPatterns
+}
+
+// AssumeYes returns true if multiple keys should be destroyed without confirmation.
+func (p *DestroyKeySubcommand) AssumeYes() bool {
+	return p.assumeYes
+}
+
+// DryRun returns true if keys should only be reported without destruction.
+func (p *DestroyKeySubcommand) DryRun() bool {
+	return p.dryRun
+}
+
+// SoftDestroy returns true if keys should be soft deleted.
+func (p *DestroyKeySubcommand) SoftDestroy() bool {
+	return p.soft
+}
+
+// GracePeriod returns period when soft deleted keys may be restored.
+func (p *DestroyKeySubcommand) GracePeriod() time.Duration {
+	return time.Duration(p.gracePeriodDays) * 24 * time.Hour
+}
+
+// SetKeyDestructionMode configures keystore for dry-run or soft destruction of keys.
+// Permanent destruction is supported by all keystores.
+func SetKeyDestructionMode(params DestroyKeyModeParams, keyStore keystore.KeyMaking) error {
+	mode := keystore.KeyDestructionPermanent
+	switch {
+	case params.DryRun():
+		mode = keystore.KeyDestructionDryRun
+	case params.SoftDestroy():
+		mode = keystore.KeyDestructionSoft
+	}
+	softKeyStore, ok := keyStore.(keystore.SoftKeyDestruction)
+	if !ok {
+		if mode == keystore.KeyDestructionPermanent {
+			return nil
+		}
+		log.Errorln("Dry-run and soft destruction of keys are supported only by keystore v1")
+		return keystore.ErrKeyDestructionModeNotSupported
+	}
+	softKeyStore.SetKeyDestructionMode(mode, params.GracePeriod())
+	return nil
+}
+
+// PrintAffectedKeyFiles prints key files destroyed by the keystore or which would be destroyed in dry-run mode.
+func PrintAffectedKeyFiles(params DestroyKeyModeParams, keyStore keystore.KeyMaking, writer io.Writer) {
+	softKeyStore, ok := keyStore.(keystore.SoftKeyDestruction)
+	if !ok {
+		return
+	}
+	affected := softKeyStore.AffectedKeyFiles()
+	switch {
+	case params.DryRun():
+		fmt.Fprintf(writer, "Dry run, %d key files would be destroyed:\n", len(affected))
+	case params.SoftDestroy():
+		fmt.Fprintf(writer, "Soft deleted %d key files, they may be restored during %s:\n", len(affected), params.GracePeriod())
+	default:
+		fmt.Fprintf(writer, "Destroyed %d key files:\n", len(affected))
+	}
+	for _, file := range affected {
+		if file.TombstoneID != "" {
+			fmt.Fprintf(writer, "%s (ID: %s)\n", file.Path, file.TombstoneID)
+			continue
+		}
+		fmt.Fprintln(writer, file.Path)
+	}
+}
+
+func asSoftKeyDestruction(keyStore keystore.KeyMaking) (keystore.SoftKeyDestruction, error) {
+	softKeyStore, ok := keyStore.(keystore.SoftKeyDestruction)
+	if !ok {
+		log.Errorln("Soft deleted keys are supported only by keystore v1")
+		return nil, keystore.ErrKeyDestructionModeNotSupported
+	}
+	return softKeyStore, nil
+}
+
+// ListSoftDeletedKeys prints soft deleted keys into the writer.
+func ListSoftDeletedKeys(keyStore keystore.KeyMaking, writer io.Writer) error {
+	softKeyStore, err := asSoftKeyDestruction(keyStore)
+	if err != nil {
+		return err
+	}
+	tombstones, err := softKeyStore.ListKeyTombstones()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, tombstone := range tombstones {
+		state := "restorable until " + tombstone.ExpiresAt.Format(time.RFC3339)
+		if tombstone.Expired(now) {
+			state = "expired, will be shredded with --purge_expired"
+		}
+		fmt.Fprintf(writer, "%s | %s | deleted at %s, %s\n", tombstone.ID, tombstone.Path, tombstone.DeletedAt.Format(time.RFC3339), state)
+	}
+	return nil
+}
+
+// RestoreSoftDeletedKey restores soft deleted key file by ID.
+func RestoreSoftDeletedKey(id string, keyStore keystore.KeyMaking) error {
+	softKeyStore, err := asSoftKeyDestruction(keyStore)
+	if err != nil {
+		return err
+	}
+	if err := softKeyStore.RestoreKeyTombstone(id); err != nil {
+		return err
+	}
+	log.WithField("id", id).Infoln("Restored soft deleted key")
+	return nil
+}
+
+// PurgeExpiredSoftDeletedKeys permanently shreds soft deleted keys with passed grace period and prints them into the writer.
+// With --dry-run keys are only printed.
+func PurgeExpiredSoftDeletedKeys(params DestroyKeyModeParams, keyStore keystore.KeyMaking, writer io.Writer) error {
+	softKeyStore, err := asSoftKeyDestruction(keyStore)
+	if err != nil {
+		return err
+	}
+	if params.DryRun() {
+		softKeyStore.SetKeyDestructionMode(keystore.KeyDestructionDryRun, 0)
+	}
+	purged, err := softKeyStore.PurgeExpiredKeyTombstones(time.Now())
+	for _, tombstone := range purged {
+		fmt.Fprintf(writer, "%s | %s\n", tombstone.ID, tombstone.Path)
+	}
+	if err != nil {
+		return err
+	}
+	if params.DryRun() {
+		log.Infof("Dry run, %d soft deleted keys would be shredded", len(purged))
+	} else {
+		log.Infof("Shredded %d soft deleted keys", len(purged))
+	}
+	return nil
+}
+
+// DestroyKeysByPatterns destroys current or rotated keys which IDs match any of patterns like "client/*/symmetric".
+// List of keys is printed into output and destruction is confirmed by user with input unless AssumeYes or DryRun is set.
+func DestroyKeysByPatterns(params DestroyKeysByPatternsParams, keyStore keystore.KeyMaking, input io.Reader, output io.Writer) error {
+	lister, ok := keyStore.(keyLister)
+	if !ok {
+		return ErrKeyListingNotSupported
+	}
+	var descriptions []keystore.KeyDescription
+	var err error
+	if params.DestroyRotatedKeys() {
+		descriptions, err = lister.ListRotatedKeys()
+	} else {
+		descriptions, err = lister.ListKeys()
+	}
+	if err != nil {
+		return err
+	}
+	references := matchKeyIDPatterns(selectKeys(descriptions, nil), params.KeyIDPatterns())
+	if len(references) == 0 {
+		log.WithField("patterns", params.KeyIDPatterns()).Warnln("No keys match key IDs")
+		return nil
+	}
+	fmt.Fprintf(output, "Selected %d keys:\n", len(references))
+	for _, reference := range references {
+		keyID, _ := reference.keyID()
+		fmt.Fprintf(output, "%s (index %d)\n", keyID, reference.index)
+	}
+	if !params.AssumeYes() && !params.DryRun() {
+		if err := confirmDestruction(len(references), input, output); err != nil {
+			return err
+		}
+	}
+	action := "Destroyed"
+	if params.DryRun() {
+		action = "Dry run, would destroy"
+	}
+	for _, reference := range references {
+		if err := DestroyKey(reference, keyStore); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"kind": reference.kind, "client_id": reference.clientID, "index": reference.index}).Infof("%s key", action)
+	}
+	log.WithField("patterns", params.KeyIDPatterns()).Infof("%s %d keys", action, len(references))
+	return nil
+}
+
+// DestroyKeysByTags destroys current or rotated keys which tags match the selector.
+func DestroyKeysByTags(params DestroyKeysByTagsParams, keyStore keystore.KeyMaking) error {
+	taggedKeyStore, err := AsTaggedKeyStore(keyStore)
+	if err != nil {
+		return err
+	}
+	var descriptions []keystore.KeyDescription
+	if params.DestroyRotatedKeys() {
+		descriptions, err = taggedKeyStore.ListRotatedKeys()
+	} else {
+		descriptions, err = taggedKeyStore.ListKeys()
+	}
+	if err != nil {
+		return err
+	}
+	action := "Destroyed"
+	if modeParams, ok := params.(DestroyKeyModeParams); ok && modeParams.DryRun() {
+		action = "Dry run, would destroy"
+	}
+	destroyed := 0
+	for _, reference := range selectKeys(descriptions, params.KeyTagSelector()) {
+		logger := log.WithFields(log.Fields{"kind": reference.kind, "client_id": reference.clientID, "index": reference.index})
+		if reference.kind == string(keystore.PurposeAuditLog) {
+			logger.Warnln("Skip audit log key which can't be destroyed")
+			continue
+		}
+		if err := DestroyKey(reference, keyStore); err != nil {
+			return err
+		}
+		logger.Infof("%s key", action)
+		destroyed++
+	}
+	log.WithField("tags", params.KeyTagSelector().String()).Infof("%s %d keys", action, destroyed)
+	return nil
+}
+
 // DestroyKeyCommand implements the "destroy" command.
 func DestroyKeyCommand(params DestroyKeyParams, keyStore keystore.KeyMaking) {
 	err := DestroyKey(params, keyStore)
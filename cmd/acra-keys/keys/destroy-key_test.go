@@ -792,3 +792,166 @@ func TestDestroyRotatedCMD_FS_V2(t *testing.T) {
 		}
 	})
 }
+
+func TestSoftDestroyCMD_FS_V1(t *testing.T) {
+	clientID := []byte("testclientid")
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flagSet := flag.NewFlagSet(CmdMigrateKeys, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+
+	err = flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	destroyCMD := &DestroyKeySubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{
+			keyDir: dirName,
+		},
+		contextID:       clientID,
+		destroyKeyKind:  keystore.KeyStorageKeypair,
+		FlagSet:         flagSet,
+		gracePeriodDays: 1,
+	}
+
+	store, err := openKeyStoreV1(destroyCMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.GenerateDataEncryptionKeys(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("dry run doesn't change keystore", func(t *testing.T) {
+		destroyCMD.dryRun = true
+		defer func() { destroyCMD.dryRun = false }()
+		if err := SetKeyDestructionMode(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		if err := DestroyKey(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		if affected := store.AffectedKeyFiles(); len(affected) != 2 {
+			t.Fatalf("expected 2 affected key files, took %d", len(affected))
+		}
+		if _, err := store.GetClientIDEncryptionPublicKey(clientID); err != nil {
+			t.Fatal("expected nil error after dry run destruction")
+		}
+		if _, err := store.GetServerDecryptionPrivateKey(clientID); err != nil {
+			t.Fatal("expected nil error after dry run destruction")
+		}
+	})
+
+	t.Run("soft destroy and restore", func(t *testing.T) {
+		destroyCMD.soft = true
+		defer func() { destroyCMD.soft = false }()
+		if err := SetKeyDestructionMode(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		if err := DestroyKey(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		affected := store.AffectedKeyFiles()
+		if len(affected) != 2 {
+			t.Fatalf("expected 2 affected key files, took %d", len(affected))
+		}
+		if _, err := store.GetClientIDEncryptionPublicKey(clientID); err == nil || !os.IsNotExist(err) {
+			t.Fatal("expected not exist error after soft key destruction")
+		}
+		// tombstones aren't listed as keys
+		if _, err := store.ListKeys(); err != nil {
+			t.Fatal(err)
+		}
+		tombstones, err := store.ListKeyTombstones()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tombstones) != 2 {
+			t.Fatalf("expected 2 soft deleted keys, took %d", len(tombstones))
+		}
+		purged, err := store.PurgeExpiredKeyTombstones(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(purged) != 0 {
+			t.Fatal("expected no purged keys before end of grace period")
+		}
+
+		for _, file := range affected {
+			if err := RestoreSoftDeletedKey(file.TombstoneID, store); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := RestoreSoftDeletedKey(affected[0].TombstoneID, store); err != keystore.ErrKeyTombstoneNotFound {
+			t.Fatalf("expected ErrKeyTombstoneNotFound, took %v", err)
+		}
+		if _, err := store.GetClientIDEncryptionPublicKey(clientID); err != nil {
+			t.Fatal("expected nil error after key restoration")
+		}
+		if _, err := store.GetServerDecryptionPrivateKey(clientID); err != nil {
+			t.Fatal("expected nil error after key restoration")
+		}
+	})
+
+	t.Run("purge expired soft deleted keys", func(t *testing.T) {
+		destroyCMD.soft = true
+		defer func() { destroyCMD.soft = false }()
+		if err := SetKeyDestructionMode(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		if err := DestroyKey(destroyCMD, store); err != nil {
+			t.Fatal(err)
+		}
+		affected := store.AffectedKeyFiles()
+
+		store.SetKeyDestructionMode(keystore.KeyDestructionDryRun, 0)
+		purged, err := store.PurgeExpiredKeyTombstones(time.Now().Add(destroyCMD.GracePeriod()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(purged) != 2 {
+			t.Fatalf("expected 2 keys to purge, took %d", len(purged))
+		}
+		tombstones, err := store.ListKeyTombstones()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tombstones) != 2 {
+			t.Fatal("expected soft deleted keys after dry run purge")
+		}
+
+		store.SetKeyDestructionMode(keystore.KeyDestructionPermanent, 0)
+		purged, err = store.PurgeExpiredKeyTombstones(time.Now().Add(destroyCMD.GracePeriod()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(purged) != 2 {
+			t.Fatalf("expected 2 purged keys, took %d", len(purged))
+		}
+		tombstones, err = store.ListKeyTombstones()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tombstones) != 0 {
+			t.Fatal("expected no soft deleted keys after purge")
+		}
+		if err := RestoreSoftDeletedKey(affected[0].TombstoneID, store); err != keystore.ErrKeyTombstoneNotFound {
+			t.Fatalf("expected ErrKeyTombstoneNotFound, took %v", err)
+		}
+	})
+}
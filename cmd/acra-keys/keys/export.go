@@ -223,7 +223,13 @@ func (p *ExportKeysSubcommand) Parse(arguments []string) error {
 // Execute this subcommand.
 func (p *ExportKeysSubcommand) Execute() {
 	var err error
-	if IsKeyStoreV2(p) {
+	if IsRemoteKeyStore(p) {
+		p.exporter, err = OpenRemoteKeyStore(p)
+		if err != nil {
+			log.WithError(err).Errorln("Can't connect to remote keystore")
+			os.Exit(1)
+		}
+	} else if IsKeyStoreV2(p) {
 		var keyStore api.BackupKeystore
 		keyStore, err = openKeyStoreV2(p)
 		if err != nil {
@@ -247,7 +253,12 @@ func (p *ExportKeysSubcommand) Execute() {
 
 		var storge filesystem.Storage
 		if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
-			storge, err = filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, nil)
+			redisOptions, err := redis.KeysOptions(flag.CommandLine)
+			if err != nil {
+				log.WithError(err).Errorln("Can't get Redis options")
+				os.Exit(1)
+			}
+			storge, err = filesystem.NewRedisStorage(redisOptions)
 			if err != nil {
 				log.WithError(err).Errorln("Can't initialize redis storage")
 				os.Exit(1)
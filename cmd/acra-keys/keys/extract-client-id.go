@@ -1,13 +1,10 @@
 package keys
 
 import (
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"strings"
 
@@ -26,6 +23,7 @@ type ExtractClientIDParams interface {
 // CommonExtractClientIDParameters is a mix-in of command line parameters for extracting clientID from TLS certificate.
 type CommonExtractClientIDParameters struct {
 	tlsClientCert, tlsIdentifierExtractorType string
+	acraServerConfigFile                      string
 	printJSON                                 bool
 }
 
@@ -50,6 +48,22 @@ func (p *CommonExtractClientIDParameters) Register(flags *flag.FlagSet) {
 	flags.StringVar(&p.tlsClientCert, "tls_cert", "", "Path to TLS certificate to use as client_id identifier")
 	flags.StringVar(&p.tlsIdentifierExtractorType, "tls_identifier_extractor_type", network.IdentifierExtractorTypeDistinguishedName,
 		fmt.Sprintf("Decide which field of TLS certificate to use as ClientID (%s). Default is %s.", strings.Join(network.IdentifierExtractorTypesList, "|"), network.IdentifierExtractorTypeDistinguishedName))
+	network.RegisterIdentifierExtractorTemplateArgs(flags)
+	flags.StringVar(&p.acraServerConfigFile, "acra_server_config_file", "", "Path to AcraServer config file to use its tls_identifier_extractor_type instead of --tls_identifier_extractor_type, so clientID is derived from certificate the same way as AcraServer does")
+}
+
+// ApplyAcraServerConfig replaces TLS identifier extractor type with the one configured in --acra_server_config_file.
+func (p *CommonExtractClientIDParameters) ApplyAcraServerConfig() error {
+	if p.acraServerConfigFile == "" {
+		return nil
+	}
+	extractorType, err := cmd.TLSIdentifierExtractorTypeFromConfig(p.acraServerConfigFile)
+	if err != nil {
+		log.WithError(err).WithField("path", p.acraServerConfigFile).Errorln("Can't read tls_identifier_extractor_type from AcraServer config")
+		return err
+	}
+	p.tlsIdentifierExtractorType = extractorType
+	return nil
 }
 
 // ExtractClientIDSubcommand is the "acra-keys extract-client-id" subcommand.
@@ -90,7 +104,7 @@ func (p *ExtractClientIDSubcommand) Parse(arguments []string) error {
 	if p.tlsClientCert == "" {
 		return ErrMissingTLSCertPath
 	}
-	return nil
+	return p.ApplyAcraServerConfig()
 }
 
 // Execute this subcommand.
@@ -107,42 +121,13 @@ func (p *ExtractClientIDSubcommand) Execute() {
 
 // ExtractClientID extract clientID based on ExtractClientIDParams.
 func ExtractClientID(params ExtractClientIDParams) (string, error) {
-	idConverter, err := network.NewDefaultHexIdentifierConverter()
-	if err != nil {
-		log.WithError(err).Errorln("Can't initialize identifier converter")
-		return "", err
-	}
-	identifierExtractor, err := network.NewIdentifierExtractorByType(params.TLSIdentifierExtractorType())
-	if err != nil {
-		log.WithField("type", params.TLSIdentifierExtractorType()).WithError(err).Errorln("Can't initialize identifier extractor")
-		return "", err
-	}
-	clientIDExtractor, err := network.NewTLSClientIDExtractor(identifierExtractor, idConverter)
-	if err != nil {
-		log.WithError(err).Errorln("Can't initialize clientID extractor")
-		return "", err
-	}
-	pemCertificateFile, err := ioutil.ReadFile(params.TLSClientCert())
-	if err != nil {
-		log.WithError(err).Errorln("Can't read TLS certificate")
-		return "", err
-	}
-	block, _ := pem.Decode(pemCertificateFile)
-	if block == nil {
-		log.WithError(err).Errorln("Can't parse TLS certificate as PEM encoded file")
-		return "", err
-	}
-	certificate, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		log.WithError(err).Errorln("Can't parse TLS certificate")
-		return "", err
-	}
-	tlsClientID, err := clientIDExtractor.ExtractClientID(certificate)
+	clientID, err := network.ClientIDFromCertificateFile(params.TLSClientCert(), params.TLSIdentifierExtractorType())
 	if err != nil {
-		log.WithError(err).Errorln("Can't extract clientID from TLS certificate")
+		log.WithError(err).WithField("path", params.TLSClientCert()).WithField("type", params.TLSIdentifierExtractorType()).
+			Errorln("Can't extract clientID from TLS certificate")
 		return "", err
 	}
-	return string(tlsClientID), nil
+	return string(clientID), nil
 }
 
 func (p *ExtractClientIDSubcommand) printClientID(writer io.Writer, clientID string) error {
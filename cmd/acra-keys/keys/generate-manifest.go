@@ -0,0 +1,218 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// Kinds of keys in manifest of "acra-keys generate --from-file", same as names of corresponding flags
+const (
+	ManifestKindClientStorageKey          = "client_storage_key"
+	ManifestKindClientStorageSymmetricKey = "client_storage_symmetric_key"
+	ManifestKindSearchHMACSymmetricKey    = "search_hmac_symmetric_key"
+	ManifestKindPoisonRecordKeys          = "poison_record_keys"
+	ManifestKindAuditLogSymmetricKey      = "audit_log_symmetric_key"
+	ManifestKindPseudonymizationKey       = "pseudonymization_symmetric_key"
+	ManifestKindReIdentificationKey       = "re_identification_key"
+)
+
+// SupportedManifestKinds is a list of key kinds which may be generated from manifest.
+var SupportedManifestKinds = []string{
+	ManifestKindClientStorageKey,
+	ManifestKindClientStorageSymmetricKey,
+	ManifestKindSearchHMACSymmetricKey,
+	ManifestKindPoisonRecordKeys,
+	ManifestKindAuditLogSymmetricKey,
+	ManifestKindPseudonymizationKey,
+	ManifestKindReIdentificationKey,
+}
+
+// clientManifestKinds are kinds of keys which require client ID
+var clientManifestKinds = map[string]bool{
+	ManifestKindClientStorageKey:          true,
+	ManifestKindClientStorageSymmetricKey: true,
+	ManifestKindSearchHMACSymmetricKey:    true,
+	ManifestKindPseudonymizationKey:       true,
+	ManifestKindReIdentificationKey:       true,
+}
+
+// Manifest errors:
+var (
+	ErrInvalidManifest          = errors.New("invalid key generation manifest")
+	ErrManifestWithKeyFlags     = errors.New("--from-file can't be used with flags selecting keys, client ID or tags")
+	ErrManifestGenerationFailed = errors.New("failed to generate some keys from manifest")
+)
+
+// Statuses of manifest entries
+const (
+	ManifestEntryStatusOK     = "ok"
+	ManifestEntryStatusFailed = "failed"
+)
+
+// KeyGenerationManifestEntry selects keys generated for one client ID or common keys if client ID is empty.
+type KeyGenerationManifestEntry struct {
+	ClientID string   `yaml:"client_id"`
+	Kinds    []string `yaml:"kinds"`
+	Tags     string   `yaml:"tags"`
+
+	keyTags keystore.KeyTags
+}
+
+// KeyGenerationManifest is YAML file with keys generated by "acra-keys generate --from-file".
+type KeyGenerationManifest struct {
+	Keys []*KeyGenerationManifestEntry `yaml:"keys"`
+}
+
+// ParseKeyGenerationManifest parses and validates manifest of keys.
+func ParseKeyGenerationManifest(data []byte) (*KeyGenerationManifest, error) {
+	manifest := &KeyGenerationManifest{}
+	if err := yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidManifest, err)
+	}
+	if len(manifest.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrInvalidManifest)
+	}
+	for i, entry := range manifest.Keys {
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %s", ErrInvalidManifest, i+1, err)
+		}
+	}
+	return manifest, nil
+}
+
+// ReadKeyGenerationManifest reads manifest of keys from file.
+func ReadKeyGenerationManifest(path string) (*KeyGenerationManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyGenerationManifest(data)
+}
+
+func (entry *KeyGenerationManifestEntry) validate() error {
+	if len(entry.Kinds) == 0 {
+		return errors.New("no key kinds")
+	}
+	needClientID := false
+	for _, kind := range entry.Kinds {
+		if !isSupportedManifestKind(kind) {
+			return fmt.Errorf("unknown key kind %q, expected <%s>", kind, strings.Join(SupportedManifestKinds, "|"))
+		}
+		needClientID = needClientID || clientManifestKinds[kind]
+	}
+	if needClientID && entry.ClientID == "" {
+		return errors.New("client_id is required for client keys")
+	}
+	if entry.ClientID != "" && !keystore.ValidateID([]byte(entry.ClientID)) {
+		return keystore.ErrInvalidClientID
+	}
+	keyTags, err := keystore.ParseKeyTags(entry.Tags)
+	if err != nil {
+		return err
+	}
+	entry.keyTags = keyTags
+	return nil
+}
+
+func isSupportedManifestKind(kind string) bool {
+	for _, supported := range SupportedManifestKinds {
+		if kind == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyGenerationManifestResult is the result of key generation for one manifest entry.
+type KeyGenerationManifestResult struct {
+	ClientID string
+	Kinds    []string
+	Status   string
+	Error    error
+}
+
+// manifestEntryParams returns parameters of "acra-keys generate" which select keys of the manifest entry
+func manifestEntryParams(params *GenerateKeySubcommand, entry *KeyGenerationManifestEntry) *GenerateKeySubcommand {
+	entryParams := *params
+	entryParams.clientID = entry.ClientID
+	entryParams.keyTags = entry.keyTags
+	entryParams.acraWriter, entryParams.acraBlocks, entryParams.searchHMAC = false, false, false
+	entryParams.poisonRecord, entryParams.auditLog = false, false
+	entryParams.pseudonym, entryParams.reIdentify = false, false
+	for _, kind := range entry.Kinds {
+		switch kind {
+		case ManifestKindClientStorageKey:
+			entryParams.acraWriter = true
+		case ManifestKindClientStorageSymmetricKey:
+			entryParams.acraBlocks = true
+		case ManifestKindSearchHMACSymmetricKey:
+			entryParams.searchHMAC = true
+		case ManifestKindPoisonRecordKeys:
+			entryParams.poisonRecord = true
+		case ManifestKindAuditLogSymmetricKey:
+			entryParams.auditLog = true
+		case ManifestKindPseudonymizationKey:
+			entryParams.pseudonym = true
+		case ManifestKindReIdentificationKey:
+			entryParams.reIdentify = true
+		}
+	}
+	return &entryParams
+}
+
+// GenerateKeysFromManifest generates keys of all manifest entries and writes result of every entry.
+// Failure of one entry doesn't stop generation of others, ErrManifestGenerationFailed is returned in this case.
+func GenerateKeysFromManifest(params *GenerateKeySubcommand, manifest *KeyGenerationManifest, keyStore keystore.KeyMaking, output io.Writer) ([]KeyGenerationManifestResult, error) {
+	results := make([]KeyGenerationManifestResult, 0, len(manifest.Keys))
+	failed := 0
+	for i, entry := range manifest.Keys {
+		result := KeyGenerationManifestResult{ClientID: entry.ClientID, Kinds: entry.Kinds, Status: ManifestEntryStatusOK}
+		logger := log.WithField("entry", i+1).WithField("client_id", entry.ClientID)
+		if _, err := GenerateAcraKeys(manifestEntryParams(params, entry), keyStore, GenerateAsRequested); err != nil {
+			logger.WithError(err).Errorln("Failed to generate keys of manifest entry")
+			result.Status = ManifestEntryStatusFailed
+			result.Error = err
+			failed++
+		}
+		results = append(results, result)
+		clientID := entry.ClientID
+		if clientID == "" {
+			clientID = "-"
+		}
+		line := fmt.Sprintf("%d\t%s\t%s\t%s", i+1, clientID, strings.Join(entry.Kinds, ","), result.Status)
+		if result.Error != nil {
+			line += "\t" + result.Error.Error()
+		}
+		if _, err := fmt.Fprintln(output, line); err != nil {
+			return results, err
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%w: %d of %d entries", ErrManifestGenerationFailed, failed, len(manifest.Keys))
+	}
+	return results, nil
+}
@@ -0,0 +1,126 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+)
+
+func TestParseKeyGenerationManifest(t *testing.T) {
+	manifest, err := ParseKeyGenerationManifest([]byte(`
+keys:
+  - client_id: tenant1
+    kinds: [client_storage_key, client_storage_symmetric_key]
+    tags: team=payments
+  - kinds: [poison_record_keys]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Keys) != 2 || manifest.Keys[0].keyTags["team"] != "payments" {
+		t.Fatalf("Unexpected manifest %+v", manifest.Keys)
+	}
+
+	invalid := []string{
+		``,
+		`keys: []`,
+		`unknown: true`,
+		"keys:\n  - client_id: tenant1\n",
+		"keys:\n  - client_id: tenant1\n    kinds: [storage]\n",
+		"keys:\n  - kinds: [client_storage_key]\n",
+		"keys:\n  - client_id: 'invalid/client'\n    kinds: [client_storage_key]\n",
+		"keys:\n  - client_id: tenant1\n    kinds: [client_storage_key]\n    tags: team\n",
+	}
+	for _, data := range invalid {
+		if _, err := ParseKeyGenerationManifest([]byte(data)); !errors.Is(err, ErrInvalidManifest) {
+			t.Fatalf("Expected %v for %q, took %v", ErrInvalidManifest, data, err)
+		}
+	}
+}
+
+func TestGenerateKeysFromManifest(t *testing.T) {
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdGenerate, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.yaml")
+	manifestData := `
+keys:
+  - client_id: tenant1
+    kinds: [client_storage_key, search_hmac_symmetric_key]
+  - client_id: tenant2
+    kinds: [client_storage_symmetric_key]
+  - kinds: [poison_record_keys, audit_log_symmetric_key]
+`
+	if err := os.WriteFile(manifestPath, []byte(manifestData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	generateCmd := &GenerateKeySubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{keyDir: dirName},
+		flagSet:                  flagSet,
+		fromFile:                 manifestPath,
+	}
+	if err := generateCmd.parseManifest(); err != nil {
+		t.Fatal(err)
+	}
+	keyStore, err := openKeyStoreV1(generateCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	results, err := GenerateKeysFromManifest(generateCmd, generateCmd.manifest, keyStore, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || strings.Count(output.String(), ManifestEntryStatusOK) != 3 {
+		t.Fatalf("Expected 3 successful entries, took %v: %s", results, output.String())
+	}
+	keys, err := keyStore.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		purpose  keystore.KeyPurpose
+		clientID string
+	}{
+		{keystore.PurposeStorageClientPrivateKey, "tenant1"},
+		{keystore.PurposeSearchHMAC, "tenant1"},
+		{keystore.PurposeStorageClientSymmetricKey, "tenant2"},
+		{keystore.PurposePoisonRecordKeyPair, ""},
+		{keystore.PurposeAuditLog, ""},
+	}
+	for _, key := range expected {
+		if countKeyDescriptions(keys, key.purpose, key.clientID) != 1 {
+			t.Fatalf("Expected generated %s key of %q, took %v", key.purpose, key.clientID, keys)
+		}
+	}
+	if countKeyDescriptions(keys, keystore.PurposeStorageClientSymmetricKey, "tenant1") != 0 {
+		t.Fatalf("Expected only requested keys of tenant1, took %v", keys)
+	}
+
+	generateCmd.clientID = "tenant1"
+	if err := generateCmd.parseManifest(); err != ErrManifestWithKeyFlags {
+		t.Fatalf("Expected %v, took %v", ErrManifestWithKeyFlags, err)
+	}
+}
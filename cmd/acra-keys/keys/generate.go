@@ -20,8 +20,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -44,9 +46,12 @@ type GenerateKeyParams interface {
 	GenerateSearchHMAC() bool
 	GeneratePoisonRecord() bool
 	GenerateAuditLog() bool
+	GeneratePseudonymization() bool
+	GenerateReIdentification() bool
 	SetClientID(clientID string)
 	TLSClientCert() string
 	TLSIdentifierExtractorType() string
+	KeyTags() keystore.KeyTags
 
 	SpecificKeysRequested() bool
 }
@@ -75,6 +80,13 @@ type GenerateKeySubcommand struct {
 	auditLog        bool
 	searchHMAC      bool
 	poisonRecord    bool
+	pseudonym       bool
+	reIdentify      bool
+	tags            string
+	keyTags         keystore.KeyTags
+	fromFile        string
+	manifest        *KeyGenerationManifest
+	outWriter       io.Writer
 }
 
 // GenerateAuditLog get auditLog flag
@@ -82,6 +94,16 @@ func (g *GenerateKeySubcommand) GenerateAuditLog() bool {
 	return g.auditLog
 }
 
+// GeneratePseudonymization get pseudonym flag
+func (g *GenerateKeySubcommand) GeneratePseudonymization() bool {
+	return g.pseudonym
+}
+
+// GenerateReIdentification get reIdentify flag
+func (g *GenerateKeySubcommand) GenerateReIdentification() bool {
+	return g.reIdentify
+}
+
 // GeneratePoisonRecord get poisonRecord flag
 func (g *GenerateKeySubcommand) GeneratePoisonRecord() bool {
 	return g.poisonRecord
@@ -123,10 +145,15 @@ func (g *GenerateKeySubcommand) GenerateSearchHMAC() bool {
 	return g.searchHMAC
 }
 
+// KeyTags returns tags assigned to generated keys.
+func (g *GenerateKeySubcommand) KeyTags() keystore.KeyTags {
+	return g.keyTags
+}
+
 // SpecificKeysRequested returns true if the user has requested any key specifically.
 // It returns false if no keys were requested.
 func (g *GenerateKeySubcommand) SpecificKeysRequested() bool {
-	return g.acraWriter || g.acraBlocks || g.auditLog || g.searchHMAC || g.poisonRecord
+	return g.acraWriter || g.acraBlocks || g.auditLog || g.searchHMAC || g.poisonRecord || g.pseudonym || g.reIdentify
 }
 
 // Name returns the same of this subcommand.
@@ -151,6 +178,10 @@ func (g *GenerateKeySubcommand) RegisterFlags() {
 	g.flagSet.BoolVar(&g.auditLog, "audit_log_symmetric_key", false, "Generate symmetric key for log integrity checks")
 	g.flagSet.BoolVar(&g.searchHMAC, "search_hmac_symmetric_key", false, "Generate symmetric key for searchable encryption HMAC")
 	g.flagSet.BoolVar(&g.poisonRecord, "poison_record_keys", false, "Generate keypair and symmetric key for poison records")
+	g.flagSet.BoolVar(&g.pseudonym, "pseudonymization_symmetric_key", false, "Generate symmetric key for derivation of pseudonyms (for a client)")
+	g.flagSet.BoolVar(&g.reIdentify, "re_identification_key", false, "Generate keypair for re-identification of pseudonyms by AcraTranslator (for a client)")
+	g.flagSet.StringVar(&g.tags, "tags", "", "Tags assigned to generated keys in keystore metadata: <name>=<value>[,<name>=<value>...] (e.g. team=payments,env=staging)")
+	g.flagSet.StringVar(&g.fromFile, "from-file", "", fmt.Sprintf("Path to YAML manifest with keys generated for many client IDs: list of \"keys\" with \"client_id\", \"kinds\" <%s> and optional \"tags\"", strings.Join(SupportedManifestKinds, "|")))
 	keyloader.RegisterKeyStoreStrategyParametersWithFlags(g.flagSet, "", "")
 
 	g.flagSet.Usage = func() {
@@ -167,10 +198,36 @@ func (g *GenerateKeySubcommand) Parse(arguments []string) error {
 	if err != nil {
 		return err
 	}
+	if g.fromFile != "" {
+		return g.parseManifest()
+	}
+	if err := g.ApplyAcraServerConfig(); err != nil {
+		return err
+	}
 	err = ValidateClientID(g)
 	if err != nil {
 		return err
 	}
+	g.keyTags, err = keystore.ParseKeyTags(g.tags)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid --tags")
+		return err
+	}
+	return nil
+}
+
+// parseManifest reads manifest of --from-file which replaces flags selecting generated keys
+func (g *GenerateKeySubcommand) parseManifest() error {
+	if g.clientID != "" || g.TLSClientCert() != "" || g.tags != "" || g.masterKeyFile != "" || g.SpecificKeysRequested() {
+		log.WithError(ErrManifestWithKeyFlags).Errorln("Invalid --from-file")
+		return ErrManifestWithKeyFlags
+	}
+	manifest, err := ReadKeyGenerationManifest(g.fromFile)
+	if err != nil {
+		log.WithError(err).WithField("path", g.fromFile).Errorln("Can't read manifest of keys")
+		return err
+	}
+	g.manifest = manifest
 	return nil
 }
 
@@ -198,7 +255,8 @@ func ValidateClientID(params GenerateKeyParams) error {
 		// Unless we're only generating the master key.
 		masterKey := params.GenerateMasterKeyFile() != ""
 		firstGeneration := params.KeystoreVersion() != ""
-		requestedClientKeys := params.GenerateAcraWriter() || params.GenerateAcraBlocks() || params.GenerateSearchHMAC()
+		requestedClientKeys := params.GenerateAcraWriter() || params.GenerateAcraBlocks() || params.GenerateSearchHMAC() ||
+			params.GeneratePseudonymization() || params.GenerateReIdentification()
 
 		requestedNonClientKeys := params.GeneratePoisonRecord() || params.GenerateAuditLog()
 
@@ -240,7 +298,10 @@ func (g *GenerateKeySubcommand) Execute() {
 	var keyStore keystore.KeyMaking
 	var err error
 	keystoreVersion := g.KeystoreVersion()
-	if keystoreVersion == "" {
+	if IsRemoteKeyStore(g) {
+		// version of remote keystore is chosen by AcraServer or AcraTranslator
+		keystoreVersion = "remote"
+	} else if keystoreVersion == "" {
 		if IsKeyStoreV2(g) {
 			keystoreVersion = "v2"
 		} else if IsKeyStoreV1(g) {
@@ -249,6 +310,8 @@ func (g *GenerateKeySubcommand) Execute() {
 	}
 
 	switch keystoreVersion {
+	case "remote":
+		keyStore, err = OpenRemoteKeyStore(g)
 	case "v1":
 		keyStore, err = openKeyStoreV1(g)
 	case "v2":
@@ -262,6 +325,17 @@ func (g *GenerateKeySubcommand) Execute() {
 		log.WithError(err).Fatal("Failed to open keystore")
 	}
 
+	if g.manifest != nil {
+		var writer io.Writer = os.Stdout
+		if g.outWriter != nil {
+			writer = g.outWriter
+		}
+		if _, err := GenerateKeysFromManifest(g, g.manifest, keyStore, writer); err != nil {
+			log.WithError(err).Fatal("Failed to generate keys from manifest")
+		}
+		return
+	}
+
 	generatedKeys, err := GenerateAcraKeys(g, keyStore, GenerateOnInitialize)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to generate keys")
@@ -324,6 +398,19 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 	generateSearchHMAC := params.GenerateSearchHMAC()
 	generatePoisonKeys := params.GeneratePoisonRecord()
 	generateAuditLogKey := params.GenerateAuditLog()
+	generatePseudonymizationKey := params.GeneratePseudonymization()
+	generateReIdentificationKey := params.GenerateReIdentification()
+
+	// check support of tags before generation to not leave untagged keys
+	var taggedKeyStore TaggedKeyStore
+	if len(params.KeyTags()) > 0 {
+		var err error
+		taggedKeyStore, err = AsTaggedKeyStore(keyStore)
+		if err != nil {
+			log.WithError(err).Error("Can't assign tags to generated keys")
+			return false, err
+		}
+	}
 
 	// If this is keystore initialization, allow the user to avoid specifying keys.
 	// They will need all of them so just generate the default set.
@@ -352,6 +439,7 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 	// Return this state to the caller so that we can at least tell the user than nothing changed
 	// instead of keeping an ominous silence.
 	didSomething := false
+	var clientPurposes, commonPurposes []keystore.KeyPurpose
 
 	if generateAcraWriter {
 		err := keyStore.GenerateDataEncryptionKeys(params.ClientID())
@@ -361,6 +449,7 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 		}
 		log.Info("Generated client storage key")
 		didSomething = true
+		clientPurposes = append(clientPurposes, keystore.PurposeStorageClientPrivateKey, keystore.PurposeStorageClientPublicKey)
 	}
 
 	if generateAcraBlocks {
@@ -371,6 +460,7 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 		}
 		log.Info("Generated client storage symmetric key")
 		didSomething = true
+		clientPurposes = append(clientPurposes, keystore.PurposeStorageClientSymmetricKey)
 	}
 
 	if generateAuditLogKey {
@@ -381,6 +471,7 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 		}
 		log.Info("Generated HMAC key for audit log")
 		didSomething = true
+		commonPurposes = append(commonPurposes, keystore.PurposeAuditLog)
 	}
 
 	if generateSearchHMAC {
@@ -391,6 +482,7 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 		}
 		log.Info("Generated HMAC key for searchable encryption")
 		didSomething = true
+		clientPurposes = append(clientPurposes, keystore.PurposeSearchHMAC)
 	}
 
 	if generatePoisonKeys {
@@ -408,6 +500,45 @@ func GenerateAcraKeys(params GenerateKeyParams, keyStore keystore.KeyMaking, def
 			return didSomething, err
 		}
 		log.Info("Generated keypair for poison records")
+		commonPurposes = append(commonPurposes, keystore.PurposePoisonRecordSymmetricKey, keystore.PurposePoisonRecordKeyPair)
+	}
+
+	if generatePseudonymizationKey || generateReIdentificationKey {
+		pseudonymizationKeyStore, ok := keyStore.(keystore.PseudonymizationKeyGenerator)
+		if !ok {
+			log.WithError(keystore.ErrPseudonymizationNotSupported).Error("Can't generate keys for pseudonymization")
+			return didSomething, keystore.ErrPseudonymizationNotSupported
+		}
+		if generatePseudonymizationKey {
+			if err := pseudonymizationKeyStore.GeneratePseudonymizationSymmetricKey(params.ClientID()); err != nil {
+				log.WithError(err).Error("Failed to generate symmetric key for pseudonymization")
+				return didSomething, err
+			}
+			log.Info("Generated symmetric key for pseudonymization")
+			didSomething = true
+			clientPurposes = append(clientPurposes, keystore.PurposePseudonymization)
+		}
+		if generateReIdentificationKey {
+			if err := pseudonymizationKeyStore.GenerateReIdentificationKeyPair(params.ClientID()); err != nil {
+				log.WithError(err).Error("Failed to generate keypair for re-identification")
+				return didSomething, err
+			}
+			log.Info("Generated keypair for re-identification")
+			didSomething = true
+			clientPurposes = append(clientPurposes, keystore.PurposeReIdentificationKeyPair)
+		}
+	}
+
+	if taggedKeyStore != nil {
+		if err := tagKeys(taggedKeyStore, params.KeyTags(), params.ClientID(), clientPurposes...); err != nil {
+			log.WithError(err).Error("Failed to assign tags to generated keys")
+			return didSomething, err
+		}
+		if err := tagKeys(taggedKeyStore, params.KeyTags(), nil, commonPurposes...); err != nil {
+			log.WithError(err).Error("Failed to assign tags to generated keys")
+			return didSomething, err
+		}
+		log.WithField("tags", params.KeyTags().String()).Info("Assigned tags to generated keys")
 	}
 
 	return didSomething, nil
@@ -14,6 +14,7 @@ import (
 	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
 	"github.com/cossacklabs/acra/keystore/v2/keystore/api"
 	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/utils/redisclient"
 )
 
 // ImportKeysParams are parameters of "acra-keys import" subcommand.
@@ -71,7 +72,7 @@ func (p *ImportKeysSubcommand) Parse(arguments []string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return p.CommonKeyListingParameters.validateFormat()
 }
 
 // Execute this subcommand.
@@ -80,6 +81,10 @@ func (p *ImportKeysSubcommand) Execute() {
 	if IsKeyStoreV2(p) {
 		var keyStore api.BackupKeystore
 		keyStore, err = openKeyStoreV2(p)
+		if err != nil {
+			log.WithError(err).Errorln("Can't open V2 keystore")
+			os.Exit(1)
+		}
 
 		backuper, err := keystoreV2.NewKeyBackuper(p.keyDirPublic, p.keyDir, keyStore)
 		if err != nil {
@@ -90,8 +95,14 @@ func (p *ImportKeysSubcommand) Execute() {
 		p.importer = backuper
 	} else {
 		var storage filesystem.Storage
+		var redisOptions *redisclient.Options
 		if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
-			storage, err = filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, nil)
+			redisOptions, err = redis.KeysOptions(flag.CommandLine)
+			if err != nil {
+				log.WithError(err).Errorln("Can't get Redis options")
+				os.Exit(1)
+			}
+			storage, err = filesystem.NewRedisStorage(redisOptions)
 			if err != nil {
 				log.WithError(err).Errorln("Can't initialize redis storage")
 				os.Exit(1)
@@ -0,0 +1,476 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/api"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+	filesystemBackendV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend"
+	"github.com/cossacklabs/acra/utils/redisclient"
+)
+
+// Values of KeystoreReport fields
+const (
+	KeystoreVersionV1       = "v1"
+	KeystoreVersionV2       = "v2"
+	KeystoreVersionNotFound = "not found"
+
+	KeystoreBackendFilesystem = "filesystem"
+	KeystoreBackendRedis      = "redis"
+
+	ProbeStatusOK      = "ok"
+	ProbeStatusFailed  = "failed"
+	ProbeStatusSkipped = "skipped"
+)
+
+// probeClientID used as key context of the key written by read/write probe
+const probeClientID = "acra-keys-inspect-probe"
+
+// probeKeyRing is the path of the key ring created in temporary keystore v2 by read/write probe
+const probeKeyRing = "inspect/probe"
+
+// redisScanCount is the number of keys of temporary keystore requested from Redis at once
+const redisScanCount = 10
+
+// ErrProbeKeyMismatch returned when the key read back by the probe differs from the written one
+var ErrProbeKeyMismatch = errors.New("key read from keystore differs from written one")
+
+// KeystoreReport describes effective keystore configuration and state.
+type KeystoreReport struct {
+	Version            string         `json:"version"`
+	Backend            string         `json:"backend"`
+	Address            string         `json:"address"`
+	KeysDir            string         `json:"keys_dir"`
+	KeysDirPublic      string         `json:"keys_dir_public"`
+	RedisDB            *int           `json:"redis_db,omitempty"`
+	RedisTLS           bool           `json:"redis_tls,omitempty"`
+	EncryptionStrategy string         `json:"encryption_strategy"`
+	MasterKeyInEnv     *bool          `json:"master_key_in_env,omitempty"`
+	Cache              string         `json:"cache"`
+	CurrentKeys        map[string]int `json:"current_keys"`
+	RotatedKeys        map[string]int `json:"rotated_keys"`
+	ListError          string         `json:"list_error,omitempty"`
+	Probe              string         `json:"probe"`
+	ProbeError         string         `json:"probe_error,omitempty"`
+}
+
+// InspectKeystoreSubcommand is the "acra-keys inspect-keystore" subcommand.
+type InspectKeystoreSubcommand struct {
+	CommonKeyStoreParameters
+	FlagSet *flag.FlagSet
+
+	useJSON   bool
+	probe     bool
+	cacheSize int
+	outWriter io.Writer
+}
+
+// Name returns the same of this subcommand.
+func (p *InspectKeystoreSubcommand) Name() string {
+	return CmdInspectKeystore
+}
+
+// GetFlagSet returns flag set of this subcommand.
+func (p *InspectKeystoreSubcommand) GetFlagSet() *flag.FlagSet {
+	return p.FlagSet
+}
+
+// RegisterFlags registers command-line flags of "acra-keys inspect-keystore".
+func (p *InspectKeystoreSubcommand) RegisterFlags() {
+	p.FlagSet = flag.NewFlagSet(CmdInspectKeystore, flag.ContinueOnError)
+	p.CommonKeyStoreParameters.Register(p.FlagSet)
+	p.FlagSet.BoolVar(&p.useJSON, "json", false, "use machine-readable JSON output")
+	p.FlagSet.BoolVar(&p.probe, "probe", true, fmt.Sprintf("Write, read back and remove a temporary key to check that keystore is accessible. Key of the client ID \"%s\" is used for encryption", probeClientID))
+	p.FlagSet.IntVar(&p.cacheSize, "keystore_cache_size", keystore.DefaultCacheSize, fmt.Sprintf("Value of keystore_cache_size used by services to describe. 0 - no limits, -1 - turn off cache. Default is %d", keystore.DefaultCacheSize))
+	p.FlagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command \"%s\": print effective keystore configuration and check its accessibility\n", CmdInspectKeystore)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...]\n", os.Args[0], CmdInspectKeystore)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		cmd.PrintFlags(p.FlagSet)
+	}
+}
+
+// Parse command-line parameters of the subcommand.
+func (p *InspectKeystoreSubcommand) Parse(arguments []string) error {
+	return cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+}
+
+// Execute this subcommand.
+func (p *InspectKeystoreSubcommand) Execute() {
+	report := InspectKeyStore(p, p.cacheSize, p.probe)
+	writer := p.outWriter
+	if writer == nil {
+		writer = os.Stdout
+	}
+	var err error
+	if p.useJSON {
+		err = printKeystoreReportJSON(report, writer)
+	} else {
+		err = printKeystoreReport(report, writer)
+	}
+	if err != nil {
+		log.WithError(err).Fatal("Failed to print keystore report")
+	}
+}
+
+// InspectKeyStore collects keystore configuration, counts keys and optionally performs read/write probe.
+// Failures are recorded in the report instead of being returned to show as much information as possible.
+func InspectKeyStore(params KeyStoreParameters, cacheSize int, probe bool) *KeystoreReport {
+	flags := params.GetFlagSet()
+	report := &KeystoreReport{
+		KeysDir:            params.KeyDir(),
+		KeysDirPublic:      params.KeyDirPublic(),
+		EncryptionStrategy: keyloader.ParseCLIOptionsFromFlags(flags, "").KeystoreEncryptorType,
+		CurrentKeys:        make(map[string]int),
+		RotatedKeys:        make(map[string]int),
+		Probe:              ProbeStatusSkipped,
+	}
+	if report.EncryptionStrategy == keyloader.KeystoreStrategyEnvMasterKey {
+		_, ok := os.LookupEnv(keystore.AcraMasterKeyVarName)
+		report.MasterKeyInEnv = &ok
+	}
+
+	redisOptions := cmd.ParseRedisCLIParametersFromFlags(flags, "")
+	if redisOptions.KeysConfigured() {
+		report.Backend = KeystoreBackendRedis
+		report.Address = redisOptions.HostPort
+		report.RedisDB = &redisOptions.DBKeys
+		report.RedisTLS = redisOptions.TLSEnable
+	} else {
+		report.Backend = KeystoreBackendFilesystem
+		report.Address = params.KeyDir()
+	}
+
+	switch {
+	case IsKeyStoreV2(params):
+		report.Version = KeystoreVersionV2
+	case IsKeyStoreV1(params):
+		report.Version = KeystoreVersionV1
+	default:
+		report.Version = KeystoreVersionNotFound
+	}
+	report.Cache = describeKeystoreCache(report.Version, cacheSize)
+
+	if report.Version != KeystoreVersionNotFound {
+		if err := countKeys(params, report); err != nil {
+			report.ListError = err.Error()
+		}
+	}
+
+	if probe {
+		var err error
+		if report.Version == KeystoreVersionV1 {
+			err = probeKeyStoreV1(params)
+		} else {
+			// new keystores are created as v2, so check that it may be created with current configuration
+			err = probeKeyStoreV2(params)
+		}
+		if err != nil {
+			report.Probe = ProbeStatusFailed
+			report.ProbeError = err.Error()
+		} else {
+			report.Probe = ProbeStatusOK
+		}
+	}
+	return report
+}
+
+func countKeys(params KeyStoreParameters, report *KeystoreReport) error {
+	keyStore, err := OpenKeyStoreForReading(params)
+	if err != nil {
+		return err
+	}
+	current, err := keyStore.ListKeys()
+	if err != nil {
+		return err
+	}
+	rotated, err := keyStore.ListRotatedKeys()
+	if err != nil {
+		return err
+	}
+	countKeysByPurpose(current, report.CurrentKeys)
+	countKeysByPurpose(rotated, report.RotatedKeys)
+	return nil
+}
+
+// countKeysByPurpose accumulates count of keys of each purpose into counts
+func countKeysByPurpose(descriptions []keystore.KeyDescription, counts map[string]int) {
+	for _, description := range descriptions {
+		counts[description.Purpose.String()]++
+	}
+}
+
+// describeKeystoreCache returns human-readable description of cache behaviour for keystore_cache_size value
+func describeKeystoreCache(version string, cacheSize int) string {
+	if version == KeystoreVersionV2 {
+		return "not supported by keystore v2"
+	}
+	switch {
+	case cacheSize == keystore.WithoutCache:
+		return "disabled"
+	case cacheSize == keystore.InfiniteCacheSize:
+		return "unlimited"
+	case cacheSize < 0:
+		return fmt.Sprintf("invalid size %d", cacheSize)
+	}
+	return fmt.Sprintf("up to %d keys", cacheSize)
+}
+
+// probeKeyStoreV1 writes encrypted key into temporary file in key directory, reads and decrypts it back
+func probeKeyStoreV1(params KeyStoreParameters) error {
+	flags := params.GetFlagSet()
+	encryptor, err := keyloader.CreateKeyEncryptor(flags, "")
+	if err != nil {
+		return err
+	}
+	var storage filesystem.Storage = &filesystem.DummyStorage{}
+	if redisOptions := cmd.ParseRedisCLIParametersFromFlags(flags, ""); redisOptions.KeysConfigured() {
+		redisClientOptions, err := redisOptions.KeysOptions(flags)
+		if err != nil {
+			return err
+		}
+		storage, err = filesystem.NewRedisStorage(redisClientOptions)
+		if err != nil {
+			return err
+		}
+	}
+	key, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		return err
+	}
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientSymmetricKey, []byte(probeClientID))
+	encrypted, err := encryptor.Encrypt(context.Background(), key, keyContext)
+	if err != nil {
+		return fmt.Errorf("can't encrypt key: %w", err)
+	}
+	path, err := storage.TempFile(filepath.Join(params.KeyDir(), ".inspect-probe"), filesystem.PrivateFileMode)
+	if err != nil {
+		return fmt.Errorf("can't create file: %w", err)
+	}
+	defer storage.Remove(path)
+	if err := storage.WriteFile(path, encrypted, filesystem.PrivateFileMode); err != nil {
+		return fmt.Errorf("can't write file: %w", err)
+	}
+	data, err := storage.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read file: %w", err)
+	}
+	decrypted, err := encryptor.Decrypt(context.Background(), data, keyContext)
+	if err != nil {
+		return fmt.Errorf("can't decrypt key: %w", err)
+	}
+	if !bytes.Equal(decrypted, key) {
+		return ErrProbeKeyMismatch
+	}
+	return nil
+}
+
+// probeKeyStoreV2 creates temporary keystore next to the configured one using the same backend and cryptosuite,
+// writes a key into the ring, reads it back with new keystore instance and removes temporary keystore
+func probeKeyStoreV2(params KeyStoreParameters) error {
+	flags := params.GetFlagSet()
+	suite, err := keyloader.CreateKeyEncryptorSuite(flags, "")
+	if err != nil {
+		return err
+	}
+	var openBackend func() (filesystemBackendV2.Backend, error)
+	if redisOptions := cmd.ParseRedisCLIParametersFromFlags(flags, ""); redisOptions.KeysConfigured() {
+		redisClientOptions, err := redisOptions.KeysOptions(flags)
+		if err != nil {
+			return err
+		}
+		config := &filesystemBackendV2.RedisConfig{
+			RootDir: filepath.Clean(params.KeyDir()) + ".inspect-probe." + strconv.FormatInt(time.Now().UnixNano(), 10),
+			Options: redisClientOptions,
+		}
+		defer removeRedisKeys(redisClientOptions, config.RootDir)
+		openBackend = func() (filesystemBackendV2.Backend, error) {
+			return filesystemBackendV2.CreateRedisBackend(config)
+		}
+	} else {
+		dir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(params.KeyDir())), ".acra-keys-inspect-probe-")
+		if err != nil {
+			return fmt.Errorf("can't create directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		openBackend = func() (filesystemBackendV2.Backend, error) {
+			return filesystemBackendV2.CreateDirectoryBackend(dir)
+		}
+	}
+
+	key, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		return err
+	}
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	keyStore, err := filesystemV2.CustomKeyStore(backend, suite)
+	if err != nil {
+		backend.Close()
+		return err
+	}
+	ring, err := keyStore.OpenKeyRingRW(probeKeyRing)
+	if err != nil {
+		keyStore.Close()
+		return fmt.Errorf("can't create key ring: %w", err)
+	}
+	now := time.Now()
+	seqnum, err := ring.AddKey(api.KeyDescription{
+		ValidSince: now,
+		ValidUntil: now.Add(time.Hour),
+		Data:       []api.KeyData{{Format: api.ThemisSymmetricKeyFormat, SymmetricKey: key}},
+	})
+	if err == nil {
+		err = ring.SetCurrent(seqnum)
+	}
+	keyStore.Close()
+	if err != nil {
+		return fmt.Errorf("can't write key: %w", err)
+	}
+
+	// read with new instance to be sure that the key is taken from the backend
+	backend, err = openBackend()
+	if err != nil {
+		return err
+	}
+	keyStore, err = filesystemV2.CustomKeyStore(backend, suite)
+	if err != nil {
+		backend.Close()
+		return err
+	}
+	defer keyStore.Close()
+	readRing, err := keyStore.OpenKeyRing(probeKeyRing)
+	if err != nil {
+		return fmt.Errorf("can't open key ring: %w", err)
+	}
+	current, err := readRing.CurrentKey()
+	if err != nil {
+		return fmt.Errorf("can't read key: %w", err)
+	}
+	readKey, err := readRing.SymmetricKey(current, api.ThemisSymmetricKeyFormat)
+	if err != nil {
+		return fmt.Errorf("can't read key: %w", err)
+	}
+	if !bytes.Equal(readKey, key) {
+		return ErrProbeKeyMismatch
+	}
+	return nil
+}
+
+// removeRedisKeys removes all keys of temporary keystore created by probe
+func removeRedisKeys(options *redisclient.Options, rootDir string) {
+	client, err := redisclient.New(options)
+	if err != nil {
+		log.WithError(err).WithField("path", rootDir).Warnln("Can't connect to Redis to remove temporary keystore")
+		return
+	}
+	defer client.Close()
+	keys, err := redisclient.ScanKeys(client, rootDir+"*", redisScanCount)
+	if err != nil {
+		log.WithError(err).WithField("path", rootDir).Warnln("Can't list keys of temporary keystore")
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if _, err := redisclient.Del(client, keys...); err != nil {
+		log.WithError(err).WithField("path", rootDir).Warnln("Can't remove temporary keystore")
+	}
+}
+
+func printKeystoreReportJSON(report *KeystoreReport, writer io.Writer) error {
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(writer, string(jsonReport))
+	return err
+}
+
+func printKeystoreReport(report *KeystoreReport, writer io.Writer) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Keystore version:    %s\n", report.Version)
+	fmt.Fprintf(&buffer, "Backend:             %s\n", report.Backend)
+	if report.Backend == KeystoreBackendRedis {
+		fmt.Fprintf(&buffer, "Redis address:       %s\n", report.Address)
+		if report.RedisDB != nil {
+			fmt.Fprintf(&buffer, "Redis DB:            %d\n", *report.RedisDB)
+		}
+		fmt.Fprintf(&buffer, "Redis TLS:           %t\n", report.RedisTLS)
+		fmt.Fprintf(&buffer, "Keys root path:      %s\n", report.KeysDir)
+	} else {
+		fmt.Fprintf(&buffer, "Keys directory:      %s\n", report.KeysDir)
+		if report.KeysDirPublic != report.KeysDir {
+			fmt.Fprintf(&buffer, "Public keys dir:     %s\n", report.KeysDirPublic)
+		}
+	}
+	fmt.Fprintf(&buffer, "Encryption strategy: %s\n", report.EncryptionStrategy)
+	if report.MasterKeyInEnv != nil {
+		fmt.Fprintf(&buffer, "%s set:   %t\n", keystore.AcraMasterKeyVarName, *report.MasterKeyInEnv)
+	}
+	fmt.Fprintf(&buffer, "Cache:               %s\n", report.Cache)
+	if report.ListError != "" {
+		fmt.Fprintf(&buffer, "Key listing:         failed: %s\n", report.ListError)
+	} else if report.Version != KeystoreVersionNotFound {
+		printKeyCounts(&buffer, "Current keys:", report.CurrentKeys)
+		printKeyCounts(&buffer, "Rotated keys:", report.RotatedKeys)
+	}
+	if report.ProbeError != "" {
+		fmt.Fprintf(&buffer, "Read/write probe:    %s: %s\n", report.Probe, report.ProbeError)
+	} else {
+		fmt.Fprintf(&buffer, "Read/write probe:    %s\n", report.Probe)
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+func printKeyCounts(buffer *bytes.Buffer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		fmt.Fprintf(buffer, "%-20s none\n", title)
+		return
+	}
+	fmt.Fprintf(buffer, "%s\n", title)
+	purposes := make([]string, 0, len(counts))
+	for purpose := range counts {
+		purposes = append(purposes, purpose)
+	}
+	sort.Strings(purposes)
+	for _, purpose := range purposes {
+		fmt.Fprintf(buffer, "  %-18s %d\n", purpose, counts[purpose])
+	}
+}
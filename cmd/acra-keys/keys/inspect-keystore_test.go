@@ -0,0 +1,147 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+)
+
+func TestDescribeKeystoreCache(t *testing.T) {
+	testcases := []struct {
+		version  string
+		size     int
+		expected string
+	}{
+		{KeystoreVersionV1, keystore.WithoutCache, "disabled"},
+		{KeystoreVersionV1, keystore.InfiniteCacheSize, "unlimited"},
+		{KeystoreVersionV1, 10, "up to 10 keys"},
+		{KeystoreVersionV1, -5, "invalid size -5"},
+		{KeystoreVersionV2, 10, "not supported by keystore v2"},
+	}
+	for _, tcase := range testcases {
+		if description := describeKeystoreCache(tcase.version, tcase.size); description != tcase.expected {
+			t.Fatalf("Expected '%s' for %s/%d, took '%s'", tcase.expected, tcase.version, tcase.size, description)
+		}
+	}
+}
+
+func TestInspectKeystoreCMD_FS_V2(t *testing.T) {
+	dirName := filepath.Join(t.TempDir(), "keys")
+
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystoreV2.NewSerializedMasterKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdInspectKeystore, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	output := &bytes.Buffer{}
+	inspectCmd := &InspectKeystoreSubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{
+			keyDir: dirName,
+		},
+		FlagSet:   flagSet,
+		useJSON:   true,
+		probe:     true,
+		cacheSize: keystore.DefaultCacheSize,
+		outWriter: output,
+	}
+
+	t.Run("missing keystore", func(t *testing.T) {
+		output.Reset()
+		inspectCmd.Execute()
+		report := KeystoreReport{}
+		if err := json.Unmarshal(output.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Version != KeystoreVersionNotFound {
+			t.Fatalf("Expected missing keystore, took %s", report.Version)
+		}
+		// probe uses temporary directory next to keys_dir, so it should succeed
+		if report.Probe != ProbeStatusOK {
+			t.Fatalf("Expected successful probe, took %s: %s", report.Probe, report.ProbeError)
+		}
+	})
+
+	t.Run("keystore with keys", func(t *testing.T) {
+		store, err := openKeyStoreV2(inspectCmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GenerateClientIDSymmetricKey([]byte("client1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GenerateClientIDSymmetricKey([]byte("client2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GenerateClientIDSymmetricKey([]byte("client2")); err != nil {
+			t.Fatal(err)
+		}
+
+		output.Reset()
+		inspectCmd.Execute()
+		report := KeystoreReport{}
+		if err := json.Unmarshal(output.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Version != KeystoreVersionV2 || report.Backend != KeystoreBackendFilesystem {
+			t.Fatalf("Unexpected keystore %s/%s", report.Version, report.Backend)
+		}
+		if report.MasterKeyInEnv == nil || !*report.MasterKeyInEnv {
+			t.Fatal("Expected master key in environment")
+		}
+		purpose := keystore.PurposeStorageClientSymmetricKey.String()
+		if report.CurrentKeys[purpose] != 2 || report.RotatedKeys[purpose] != 1 {
+			t.Fatalf("Unexpected key counts: %v, %v", report.CurrentKeys, report.RotatedKeys)
+		}
+		if report.Probe != ProbeStatusOK {
+			t.Fatalf("Expected successful probe, took %s: %s", report.Probe, report.ProbeError)
+		}
+		// probe should not leave anything next to the keystore
+		entries, err := os.ReadDir(filepath.Dir(dirName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("Expected only keystore directory, took %d entries", len(entries))
+		}
+	})
+
+	t.Run("probe with invalid master key", func(t *testing.T) {
+		t.Setenv(keystore.AcraMasterKeyVarName, "invalid")
+		report := InspectKeyStore(inspectCmd, keystore.DefaultCacheSize, true)
+		if report.Probe != ProbeStatusFailed {
+			t.Fatalf("Expected failed probe, took %s", report.Probe)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// Key ID patterns errors:
+var (
+	ErrInvalidKeyIDPattern     = errors.New("invalid key ID pattern")
+	ErrKeyListingNotSupported  = errors.New("keystore doesn't support listing of keys")
+	ErrDestructionNotConfirmed = errors.New("destruction of keys wasn't confirmed")
+	ErrIndexWithKeyIDPatterns  = errors.New("index can't be used with multiple key IDs or key ID patterns")
+)
+
+// keyIDPatternMeta are special characters of path.Match patterns
+const keyIDPatternMeta = "*?[\\"
+
+// clientKeyKindNames are names of client key kinds used in "client/<client ID>/<kind>" key IDs
+var clientKeyKindNames = map[string]string{
+	keystore.KeySymmetric:      "symmetric",
+	keystore.KeyStorageKeypair: "storage",
+	keystore.KeySearch:         "searchable",
+}
+
+// poisonKeyIDs are key IDs of poison record keys
+var poisonKeyIDs = map[string]string{
+	keystore.KeyPoisonKeypair:   "poison-record",
+	keystore.KeyPoisonSymmetric: "poison-record-symmetric",
+}
+
+// keyLister lists keys of keystore, it is implemented by keystore v1 and v2
+type keyLister interface {
+	ListKeys() ([]keystore.KeyDescription, error)
+	ListRotatedKeys() ([]keystore.KeyDescription, error)
+}
+
+// isKeyIDPattern returns true if key ID contains wildcards like "client/*/symmetric"
+func isKeyIDPattern(keyID string) bool {
+	return strings.ContainsAny(keyID, keyIDPatternMeta)
+}
+
+// ValidateKeyIDPatterns checks that key IDs are known or valid patterns in path.Match syntax.
+func ValidateKeyIDPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !isKeyIDPattern(pattern) {
+			if _, _, err := ParseKeyKind(pattern); err != nil {
+				log.WithField("key_id", pattern).Errorln("Unknown key ID")
+				return err
+			}
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.WithError(err).WithField("pattern", pattern).Errorln("Invalid key ID pattern")
+			return ErrInvalidKeyIDPattern
+		}
+	}
+	return nil
+}
+
+// keyID returns ID of the referenced key in format accepted by "acra-keys destroy"
+func (reference keyReference) keyID() (string, error) {
+	if reference.clientID != "" {
+		name, ok := clientKeyKindNames[reference.kind]
+		if !ok {
+			return "", ErrUnknownKeyKind
+		}
+		return "client/" + reference.clientID + "/" + name, nil
+	}
+	keyID, ok := poisonKeyIDs[reference.kind]
+	if !ok {
+		return "", ErrUnknownKeyKind
+	}
+	return keyID, nil
+}
+
+// matchKeyIDPatterns returns references to keys with IDs matching any of patterns
+func matchKeyIDPatterns(references []keyReference, patterns []string) []keyReference {
+	matched := make([]keyReference, 0, len(references))
+	for _, reference := range references {
+		keyID, err := reference.keyID()
+		if err != nil {
+			continue
+		}
+		for _, pattern := range patterns {
+			// patterns are validated by ValidateKeyIDPatterns
+			if ok, _ := path.Match(pattern, keyID); ok {
+				matched = append(matched, reference)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// confirmDestruction asks user to type "yes" to destroy keys
+func confirmDestruction(count int, input io.Reader, output io.Writer) error {
+	fmt.Fprintf(output, "Destroy %d keys? Type \"yes\" to confirm: ", count)
+	answer, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "yes" && answer != "y" {
+		log.Errorln("Destruction of keys wasn't confirmed, use --yes to destroy keys without confirmation")
+		return ErrDestructionNotConfirmed
+	}
+	return nil
+}
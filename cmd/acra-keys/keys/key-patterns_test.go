@@ -0,0 +1,142 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+)
+
+func TestValidateKeyIDPatterns(t *testing.T) {
+	valid := [][]string{
+		{"client/*/symmetric"},
+		{"client/tenant1/*", "poison-record*"},
+		{"client/tenant1/storage", "client/tenant2/storage"},
+		{"client/tenant[12]/searchable"},
+	}
+	for _, patterns := range valid {
+		if err := ValidateKeyIDPatterns(patterns); err != nil {
+			t.Fatalf("Expected valid patterns %v, took %v", patterns, err)
+		}
+	}
+	if err := ValidateKeyIDPatterns([]string{"client/tenant[/*"}); err != ErrInvalidKeyIDPattern {
+		t.Fatalf("Expected %v, took %v", ErrInvalidKeyIDPattern, err)
+	}
+	if err := ValidateKeyIDPatterns([]string{"client/*/symmetric", "client/tenant/unknown"}); err != ErrUnknownKeyKind {
+		t.Fatalf("Expected %v, took %v", ErrUnknownKeyKind, err)
+	}
+}
+
+func TestMatchKeyIDPatterns(t *testing.T) {
+	references := []keyReference{
+		{kind: keystore.KeySymmetric, clientID: "tenant1", index: 1},
+		{kind: keystore.KeyStorageKeypair, clientID: "tenant1", index: 1},
+		{kind: keystore.KeySymmetric, clientID: "tenant2", index: 1},
+		{kind: keystore.KeySearch, clientID: "tenant2", index: 1},
+		{kind: keystore.KeyPoisonKeypair, index: 1},
+		{kind: string(keystore.PurposeAuditLog), index: 1},
+	}
+	testcases := []struct {
+		patterns []string
+		expected int
+	}{
+		{[]string{"client/*/symmetric"}, 2},
+		{[]string{"client/tenant1/*"}, 2},
+		{[]string{"client/tenant1/*", "client/tenant2/searchable"}, 3},
+		{[]string{"client/*/*", "client/tenant1/storage"}, 4},
+		{[]string{"poison-record*"}, 1},
+		// audit log key has no key ID and can't be matched
+		{[]string{"*"}, 1},
+		{[]string{"client/tenant3/*"}, 0},
+	}
+	for _, testcase := range testcases {
+		if matched := matchKeyIDPatterns(references, testcase.patterns); len(matched) != testcase.expected {
+			t.Fatalf("Expected %d keys matching %v, took %v", testcase.expected, testcase.patterns, matched)
+		}
+	}
+}
+
+func TestDestroyKeysByPatterns_FS_V1(t *testing.T) {
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdDestroyKey, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	destroyCMD := &DestroyKeySubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{keyDir: dirName},
+		FlagSet:                  flagSet,
+		keyIDPatterns:            []string{"client/tenant1/*", "client/*/searchable"},
+	}
+	store, err := openKeyStoreV1(destroyCMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clientID := range [][]byte{[]byte("tenant1"), []byte("tenant2")} {
+		if err := store.GenerateClientIDSymmetricKey(clientID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GenerateDataEncryptionKeys(clientID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GenerateHmacKey(clientID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := &bytes.Buffer{}
+	if err := DestroyKeysByPatterns(destroyCMD, store, strings.NewReader("no\n"), output); err != ErrDestructionNotConfirmed {
+		t.Fatalf("Expected %v, took %v", ErrDestructionNotConfirmed, err)
+	}
+	if !strings.Contains(output.String(), "Selected 4 keys") {
+		t.Fatalf("Expected list of 4 selected keys, took %s", output.String())
+	}
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countKeyDescriptions(keys, keystore.PurposeStorageClientSymmetricKey, "tenant1") != 1 {
+		t.Fatalf("Expected untouched keys without confirmation, took %v", keys)
+	}
+
+	if err := DestroyKeysByPatterns(destroyCMD, store, strings.NewReader("yes\n"), output); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, purpose := range []keystore.KeyPurpose{keystore.PurposeStorageClientSymmetricKey, keystore.PurposeStorageClientPrivateKey, keystore.PurposeSearchHMAC} {
+		if countKeyDescriptions(keys, purpose, "tenant1") != 0 {
+			t.Fatalf("Expected destroyed tenant1 keys, took %v", keys)
+		}
+	}
+	if countKeyDescriptions(keys, keystore.PurposeSearchHMAC, "tenant2") != 0 {
+		t.Fatalf("Expected destroyed tenant2 searchable key, took %v", keys)
+	}
+	if countKeyDescriptions(keys, keystore.PurposeStorageClientSymmetricKey, "tenant2") != 1 || countKeyDescriptions(keys, keystore.PurposeStorageClientPrivateKey, "tenant2") != 1 {
+		t.Fatalf("Expected untouched tenant2 keys, took %v", keys)
+	}
+
+	// nothing left to confirm
+	destroyCMD.keyIDPatterns = []string{"client/tenant1/*"}
+	if err := DestroyKeysByPatterns(destroyCMD, store, strings.NewReader(""), output); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -19,23 +19,33 @@ package keys
 import (
 	"errors"
 	"flag"
+	"net"
 
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/remote"
 	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
 	"github.com/cossacklabs/acra/keystore/v2/keystore/api"
 	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
 	filesystemBackendV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend"
 	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // KeyStoreFactory should return one of those errors when it is not able to construct requested keystore.
 var (
-	ErrNotImplementedV1 = errors.New("not implemented for keystore v1")
+	ErrNotImplementedV1     = errors.New("not implemented for keystore v1")
+	ErrNotImplementedRemote = errors.New("not implemented for remote keystore")
+)
+
+// remoteKeyStoreFlag is the name of flag with address of remote keystore, TLS flags of connection use the same prefix
+const (
+	remoteKeyStoreFlag    = "remote_keystore_address"
+	remoteKeyStoreTLSName = "remote_keystore"
 )
 
 // KeyStoreParameters are parameters for DefaultKeyStoreFactory.
@@ -77,6 +87,42 @@ func (p *CommonKeyStoreParameters) Register(flags *flag.FlagSet) {
 	p.RegisterPrefixed(flags, DefaultKeyDirectory, "", "")
 	cmd.RegisterRedisKeystoreParametersWithPrefix(flags, "", "")
 	keyloader.RegisterKeyStoreStrategyParametersWithFlags(flags, "", "")
+	registerRemoteKeyStoreParameters(flags)
+}
+
+// registerRemoteKeyStoreParameters registers address and TLS parameters of remote keystore
+func registerRemoteKeyStoreParameters(flags *flag.FlagSet) {
+	flags.String(remoteKeyStoreFlag, "", "<host>:<port> of keystore API of AcraServer or AcraTranslator used instead of local keystore. Supported by generate, list, rotate, destroy and export subcommands")
+	network.RegisterTLSArgsForService(flags, true, remoteKeyStoreTLSName, network.ClientNameConstructorFunc())
+}
+
+// RemoteKeyStoreAddress returns address of remote keystore or empty string if local keystore is used
+func RemoteKeyStoreAddress(params KeyStoreParameters) string {
+	if f := params.GetFlagSet().Lookup(remoteKeyStoreFlag); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// IsRemoteKeyStore returns true if keys are managed by keystore API of AcraServer or AcraTranslator
+func IsRemoteKeyStore(params KeyStoreParameters) bool {
+	return RemoteKeyStoreAddress(params) != ""
+}
+
+// OpenRemoteKeyStore connects to keystore API of AcraServer or AcraTranslator with client certificate
+func OpenRemoteKeyStore(params KeyStoreParameters) (*remote.Client, error) {
+	address := RemoteKeyStoreAddress(params)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid address of remote keystore")
+		return nil, err
+	}
+	tlsConfig, err := network.NewTLSConfigByName(params.GetFlagSet(), remoteKeyStoreTLSName, host, network.ClientNameConstructorFunc())
+	if err != nil {
+		log.WithError(err).Errorln("Can't create TLS config of remote keystore")
+		return nil, err
+	}
+	return remote.NewClient(address, tlsConfig)
 }
 
 // RegisterPrefixed registers keystore flags with the given flag set, using given prefix and description.
@@ -99,6 +145,9 @@ func OpenKeyStoreForReading(params KeyStoreParameters) (keystore.ServerKeyStore,
 
 // OpenKeyStoreForWriting opens a keystore suitable for modifications.
 func OpenKeyStoreForWriting(params KeyStoreParameters) (keyStore keystore.KeyMaking, err error) {
+	if IsRemoteKeyStore(params) {
+		return OpenRemoteKeyStore(params)
+	}
 	if IsKeyStoreV2(params) {
 		return openKeyStoreV2(params)
 	}
@@ -123,7 +172,27 @@ func OpenKeyStoreForImport(params KeyStoreParameters) (api.MutableKeyStore, erro
 	return nil, ErrNotImplementedV1
 }
 
+// OpenKeyStoreForAudit opens a keystore suitable for integrity audit of key rings.
+func OpenKeyStoreForAudit(params KeyStoreParameters) (KeyRingAuditor, error) {
+	if !IsKeyStoreV2(params) {
+		// Key files of keystore v1 are not signed
+		return nil, ErrNotImplementedV1
+	}
+	keyStore, err := openKeyStoreV2(params)
+	if err != nil {
+		return nil, err
+	}
+	auditor, ok := keyStore.MutableKeyStore.(KeyRingAuditor)
+	if !ok {
+		return nil, ErrAuditNotSupported
+	}
+	return auditor, nil
+}
+
 func openKeyStoreV1(params KeyStoreParameters) (*filesystem.KeyStore, error) {
+	if IsRemoteKeyStore(params) {
+		return nil, ErrNotImplementedRemote
+	}
 	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(params.GetFlagSet(), "")
 	if err != nil {
 		log.WithError(err).Errorln("Can't init keystore KeyEncryptor")
@@ -147,7 +216,7 @@ func openKeyStoreV1(params KeyStoreParameters) (*filesystem.KeyStore, error) {
 			log.WithError(err).Errorln("Failed to get Redis options")
 			return nil, err
 		}
-		keyStorage, err := filesystem.NewRedisStorage(redisOptions.HostPort, redisOptions.Password, redisOptions.DBKeys, redisClientOptions.TLSConfig)
+		keyStorage, err := filesystem.NewRedisStorage(redisClientOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Failed to initialise Redis storage")
 			return nil, err
@@ -164,6 +233,9 @@ func openKeyStoreV1(params KeyStoreParameters) (*filesystem.KeyStore, error) {
 }
 
 func openKeyStoreV2(params KeyStoreParameters) (*keystoreV2.ServerKeyStore, error) {
+	if IsRemoteKeyStore(params) {
+		return nil, ErrNotImplementedRemote
+	}
 	keyStoreSuite, err := keyloader.CreateKeyEncryptorSuite(params.GetFlagSet(), "")
 	if err != nil {
 		log.WithError(err).Errorln("Can't init keystore keyStoreSuite")
@@ -239,7 +311,7 @@ func IsKeyStoreV1(params KeyStoreParameters) bool {
 				Errorln("Can't get Redis options")
 			return false
 		}
-		redisStorage, err := filesystem.NewRedisStorage(redisOptions.HostPort, redisOptions.Password, redisOptions.DBKeys, redisClientOptions.TLSConfig)
+		redisStorage, err := filesystem.NewRedisStorage(redisClientOptions)
 		if err != nil {
 			log.WithError(err).Debug("Failed to open redis storage for version check")
 			return false
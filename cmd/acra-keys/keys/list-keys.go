@@ -18,10 +18,13 @@ package keys
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -29,21 +32,55 @@ import (
 	"github.com/cossacklabs/acra/keystore"
 )
 
+// Supported output formats of key list
+const (
+	ListKeysFormatTable = "table"
+	ListKeysFormatJSON  = "json"
+)
+
+// Errors returned by "acra-keys list" for invalid parameters
+var (
+	ErrInvalidListKeysFormat = errors.New("unsupported format of key list")
+	ErrInvalidKeyKind        = errors.New("unknown key kind")
+	ErrInvalidKeyAgeFilter   = errors.New("--min_age should be less than --max_age")
+)
+
 // ListKeysParams ara parameters of "acra-keys list" subcommand.
 type ListKeysParams interface {
 	UseJSON() bool
 	ListRotatedKeys() bool
+	ListKeyHistory() bool
+	KeyTagSelector() keystore.KeyTags
+	KeyFilter() KeyFilter
+	Verbose() bool
 }
 
 // CommonKeyListingParameters is a mix-in of command line parameters for keystore listing.
 type CommonKeyListingParameters struct {
-	useJSON     bool
-	rotatedKeys bool
+	useJSON        bool
+	format         string
+	rotatedKeys    bool
+	history        bool
+	tagSelector    string
+	keyTagSelector keystore.KeyTags
+	kinds          string
+	keyFilter      KeyFilter
+	verbose        bool
 }
 
 // UseJSON tells if machine-readable JSON should be used.
 func (p *CommonKeyListingParameters) UseJSON() bool {
-	return p.useJSON
+	return p.useJSON || p.format == ListKeysFormatJSON
+}
+
+// ListKeyHistory tells if rotated keys should be listed together with current keys.
+func (p *CommonKeyListingParameters) ListKeyHistory() bool {
+	return p.history
+}
+
+// KeyFilter returns filter of listed keys.
+func (p *CommonKeyListingParameters) KeyFilter() KeyFilter {
+	return p.keyFilter
 }
 
 // ListRotatedKeys return param if command should display rotated keys.
@@ -51,9 +88,106 @@ func (p *CommonKeyListingParameters) ListRotatedKeys() bool {
 	return p.rotatedKeys
 }
 
+// KeyTagSelector returns tags which listed keys should have, empty selector matches all keys.
+func (p *CommonKeyListingParameters) KeyTagSelector() keystore.KeyTags {
+	return p.keyTagSelector
+}
+
+// Verbose tells if usage statistics of keys should be listed.
+func (p *CommonKeyListingParameters) Verbose() bool {
+	return p.verbose
+}
+
 // Register registers key formatting flags with the given flag set.
 func (p *CommonKeyListingParameters) Register(flags *flag.FlagSet) {
 	flags.BoolVar(&p.useJSON, "json", false, "use machine-readable JSON output")
+	flags.StringVar(&p.format, "format", ListKeysFormatTable, fmt.Sprintf("output format of key list: <%s|%s>", ListKeysFormatTable, ListKeysFormatJSON))
+}
+
+// validateFormat checks value of --format.
+func (p *CommonKeyListingParameters) validateFormat() error {
+	switch p.format {
+	case ListKeysFormatTable, ListKeysFormatJSON:
+		return nil
+	case ReadKeyFormatRaw, ReadKeyFormatPEM, ReadKeyFormatBase64:
+		// config file is shared between subcommands and may contain --format of "acra-keys read"
+		p.format = ListKeysFormatTable
+		return nil
+	}
+	return ErrInvalidListKeysFormat
+}
+
+// KeyFilter selects listed keys by client ID, kind and age. Zero values of fields match all keys.
+type KeyFilter struct {
+	ClientID string
+	// Kinds contains key kinds (e.g. symmetric-key) or key purposes (e.g. storage_sym_key)
+	Kinds []string
+	// MinAge and MaxAge select keys by creation time, keys without creation time don't match them
+	MinAge time.Duration
+	MaxAge time.Duration
+}
+
+// parseKeyKinds parses comma-separated list of key kinds or key purposes.
+func parseKeyKinds(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	known := map[string]bool{
+		keystore.PurposeAuditLog.String():             true,
+		keystore.PurposeStorageClientKeyPair.String(): true,
+		keystore.PurposePoisonRecordKeyPair.String():  true,
+		keystore.PurposeLegacy.String():               true,
+	}
+	for purpose, kind := range keystore.KeyPurposeToKeyKind {
+		known[purpose.String()] = true
+		known[kind] = true
+	}
+	kinds := strings.Split(value, ",")
+	for i, kind := range kinds {
+		kinds[i] = strings.TrimSpace(kind)
+		if !known[kinds[i]] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKeyKind, kinds[i])
+		}
+	}
+	return kinds, nil
+}
+
+// Match returns true if key matches all conditions of the filter.
+func (filter KeyFilter) Match(key keystore.KeyDescription, now time.Time) bool {
+	if filter.ClientID != "" && key.ClientID != filter.ClientID {
+		return false
+	}
+	if len(filter.Kinds) > 0 {
+		matched := false
+		for _, kind := range filter.Kinds {
+			if key.Purpose.String() == kind || keystore.KeyPurposeToKeyKind[key.Purpose] == kind {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if filter.MinAge == 0 && filter.MaxAge == 0 {
+		return true
+	}
+	if key.CreationTime == nil {
+		return false
+	}
+	age := now.Sub(*key.CreationTime)
+	return (filter.MinAge == 0 || age >= filter.MinAge) && (filter.MaxAge == 0 || age <= filter.MaxAge)
+}
+
+// Filter returns keys matched by the filter.
+func (filter KeyFilter) Filter(keys []keystore.KeyDescription, now time.Time) []keystore.KeyDescription {
+	filtered := make([]keystore.KeyDescription, 0, len(keys))
+	for _, key := range keys {
+		if filter.Match(key, now) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
 }
 
 // ListKeySubcommand is the "acra-keys list" subcommand.
@@ -79,6 +213,13 @@ func (p *ListKeySubcommand) RegisterFlags() {
 	p.CommonKeyStoreParameters.Register(p.FlagSet)
 	p.CommonKeyListingParameters.Register(p.FlagSet)
 	p.FlagSet.BoolVar(&p.rotatedKeys, "rotated-keys", false, "List rotated keys")
+	p.FlagSet.BoolVar(&p.history, "history", false, "List rotated keys as history of current keys with the same purpose and client ID in JSON output. Same as --rotated-keys for table output")
+	p.FlagSet.StringVar(&p.tagSelector, "tag_selector", "", "List only keys with all of the tags: <name>=<value>[,<name>=<value>...]")
+	p.FlagSet.StringVar(&p.keyFilter.ClientID, "client_id", "", "List only keys of the client ID")
+	p.FlagSet.StringVar(&p.kinds, "kind", "", "List only keys of comma-separated kinds (e.g. storage-keypair,symmetric-key) or purposes (e.g. storage_sym_key)")
+	p.FlagSet.DurationVar(&p.keyFilter.MinAge, "min_age", 0, "List only keys created at least the duration ago (e.g. 2160h). 0 - disabled")
+	p.FlagSet.DurationVar(&p.keyFilter.MaxAge, "max_age", 0, "List only keys created at most the duration ago (e.g. 24h). 0 - disabled")
+	p.FlagSet.BoolVar(&p.verbose, "verbose", false, "List usage statistics of current keys (encryptions, decryptions, last access) saved by services with --keystore_usage_tracking_interval")
 	p.FlagSet.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Command \"%s\": list available keys in the keystore\n", CmdListKeys)
 		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...]\n", os.Args[0], CmdListKeys)
@@ -89,12 +230,40 @@ func (p *ListKeySubcommand) RegisterFlags() {
 
 // Parse command-line parameters of the subcommand.
 func (p *ListKeySubcommand) Parse(arguments []string) error {
-	return cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+	err := cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+	if err != nil {
+		return err
+	}
+	p.keyTagSelector, err = keystore.ParseKeyTags(p.tagSelector)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid --tag_selector")
+		return err
+	}
+	if err := p.validateFormat(); err != nil {
+		log.WithError(err).Errorln("Invalid --format")
+		return err
+	}
+	p.keyFilter.Kinds, err = parseKeyKinds(p.kinds)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid --kind")
+		return err
+	}
+	if p.keyFilter.MaxAge != 0 && p.keyFilter.MinAge > p.keyFilter.MaxAge {
+		log.WithError(ErrInvalidKeyAgeFilter).Errorln("Invalid --min_age")
+		return ErrInvalidKeyAgeFilter
+	}
+	return nil
 }
 
 // Execute this subcommand.
 func (p *ListKeySubcommand) Execute() {
-	keyStore, err := OpenKeyStoreForReading(p)
+	var keyStore keyLister
+	var err error
+	if IsRemoteKeyStore(p) {
+		keyStore, err = OpenRemoteKeyStore(p)
+	} else {
+		keyStore, err = OpenKeyStoreForReading(p)
+	}
 	if err != nil {
 		log.WithError(err).Fatal("Failed to open keystore")
 	}
@@ -102,20 +271,48 @@ func (p *ListKeySubcommand) Execute() {
 }
 
 // ListKeysCommand implements the "list" command.
-func ListKeysCommand(params ListKeysParams, keyStore keystore.ServerKeyStore) {
+func ListKeysCommand(params ListKeysParams, keyStore keyLister) {
 	keyDescriptions, err := keyStore.ListKeys()
 	if err != nil {
 		log.WithError(err).Fatal("Failed to read key list")
 	}
 
 	var rotatedDescriptions []keystore.KeyDescription
-	if params.ListRotatedKeys() {
+	if params.ListRotatedKeys() || params.ListKeyHistory() {
 		rotatedDescriptions, err = keyStore.ListRotatedKeys()
 		if err != nil {
 			log.WithError(err).Fatal("Failed to read rotated key list")
 		}
 	}
 
+	if selector := params.KeyTagSelector(); len(selector) > 0 {
+		keyDescriptions = keystore.FilterKeysByTags(keyDescriptions, selector)
+		rotatedDescriptions = keystore.FilterKeysByTags(rotatedDescriptions, selector)
+	}
+	now := time.Now()
+	filter := params.KeyFilter()
+	keyDescriptions = filter.Filter(keyDescriptions, now)
+	if !params.ListKeyHistory() {
+		// history contains all rotated keys of listed current keys
+		rotatedDescriptions = filter.Filter(rotatedDescriptions, now)
+	}
+
+	if params.Verbose() {
+		usageStore, ok := keyStore.(keystore.KeyUsageStore)
+		if !ok {
+			log.WithError(keystore.ErrKeyUsageTrackingNotSupported).Fatal("Can't list usage of keys")
+		}
+		if err := keystore.FillKeysUsage(keyDescriptions, usageStore); err != nil {
+			log.WithError(err).Fatal("Failed to read usage of keys")
+		}
+	}
+
+	if params.UseJSON() && params.ListKeyHistory() {
+		if err := printKeysWithHistoryJSON(keyDescriptions, rotatedDescriptions, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to print key list in JSON")
+		}
+		return
+	}
 	if params.UseJSON() {
 		keyDescriptions = append(keyDescriptions, rotatedDescriptions...)
 
@@ -126,12 +323,16 @@ func ListKeysCommand(params ListKeysParams, keyStore keystore.ServerKeyStore) {
 	}
 
 	// print current keys in table format
-	err = keystore.PrintKeysTable(keyDescriptions, os.Stdout)
+	if params.Verbose() {
+		err = keystore.PrintKeysUsageTable(keyDescriptions, os.Stdout)
+	} else {
+		err = keystore.PrintKeysTable(keyDescriptions, os.Stdout)
+	}
 	if err != nil {
 		log.WithError(err).Fatal("Failed to print key list")
 	}
 
-	if params.ListRotatedKeys() {
+	if params.ListRotatedKeys() || params.ListKeyHistory() {
 		// print rotated keys in table format
 		err = keystore.PrintRotatedKeysTable(rotatedDescriptions, os.Stdout)
 		if err != nil {
@@ -157,3 +358,33 @@ func printKeysJSON(keys []keystore.KeyDescription, writer io.Writer) error {
 	_, err = writer.Write(json)
 	return err
 }
+
+// keyWithHistory is current key with rotated keys of the same purpose and client ID
+type keyWithHistory struct {
+	keystore.KeyDescription
+	History []keystore.KeyDescription `json:",omitempty"`
+}
+
+// groupKeyHistory attaches rotated keys to current keys with the same purpose and client ID
+func groupKeyHistory(keys, rotatedKeys []keystore.KeyDescription) []keyWithHistory {
+	result := make([]keyWithHistory, len(keys))
+	for i, key := range keys {
+		result[i].KeyDescription = key
+		for _, rotated := range rotatedKeys {
+			if rotated.Purpose == key.Purpose && rotated.ClientID == key.ClientID {
+				result[i].History = append(result[i].History, rotated)
+			}
+		}
+	}
+	return result
+}
+
+func printKeysWithHistoryJSON(keys, rotatedKeys []keystore.KeyDescription, writer io.Writer) error {
+	json, err := json.Marshal(groupKeyHistory(keys, rotatedKeys))
+	if err != nil {
+		return err
+	}
+	json = append(json, byte('\n'))
+	_, err = writer.Write(json)
+	return err
+}
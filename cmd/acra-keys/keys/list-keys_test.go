@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"os"
@@ -422,3 +423,107 @@ func equalDescriptionLists(a, b []keystore.KeyDescription) bool {
 func equalDescriptions(a, b keystore.KeyDescription) bool {
 	return a.KeyID == b.KeyID && a.Purpose == b.Purpose && bytes.Equal([]byte(a.ClientID), []byte(b.ClientID)) && a.Index == b.Index
 }
+
+func TestKeyFilter(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	fresh := now.Add(-time.Hour)
+	keys := []keystore.KeyDescription{
+		{KeyID: "old symmetric", Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client1", CreationTime: &old},
+		{KeyID: "fresh private", Purpose: keystore.PurposeStorageClientPrivateKey, ClientID: "client1", CreationTime: &fresh},
+		{KeyID: "other client", Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client2", CreationTime: &fresh},
+		{KeyID: "without time", Purpose: keystore.PurposeAuditLog},
+	}
+	testcases := []struct {
+		name     string
+		filter   KeyFilter
+		expected []string
+	}{
+		{"empty", KeyFilter{}, []string{"old symmetric", "fresh private", "other client", "without time"}},
+		{"client", KeyFilter{ClientID: "client1"}, []string{"old symmetric", "fresh private"}},
+		{"kind", KeyFilter{Kinds: []string{keystore.KeySymmetric}}, []string{"old symmetric", "other client"}},
+		{"purpose", KeyFilter{Kinds: []string{keystore.PurposeAuditLog.String(), keystore.KeyStorageKeypair}}, []string{"fresh private", "without time"}},
+		{"min age", KeyFilter{MinAge: 90 * 24 * time.Hour}, []string{"old symmetric"}},
+		{"max age", KeyFilter{MaxAge: 24 * time.Hour}, []string{"fresh private", "other client"}},
+		{"client and kind", KeyFilter{ClientID: "client1", Kinds: []string{keystore.KeySymmetric}}, []string{"old symmetric"}},
+	}
+	for _, tcase := range testcases {
+		filtered := tcase.filter.Filter(keys, now)
+		if len(filtered) != len(tcase.expected) {
+			t.Fatalf("[%s] Expected %v, took %v", tcase.name, tcase.expected, filtered)
+		}
+		for i, key := range filtered {
+			if key.KeyID != tcase.expected[i] {
+				t.Fatalf("[%s] Expected %v, took %v", tcase.name, tcase.expected, filtered)
+			}
+		}
+	}
+}
+
+func TestParseKeyKinds(t *testing.T) {
+	kinds, err := parseKeyKinds("storage-keypair, storage_sym_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kinds) != 2 || kinds[0] != keystore.KeyStorageKeypair || kinds[1] != keystore.PurposeStorageClientSymmetricKey.String() {
+		t.Fatalf("Unexpected kinds %v", kinds)
+	}
+	if _, err := parseKeyKinds("unknown"); !errors.Is(err, ErrInvalidKeyKind) {
+		t.Fatalf("Expected ErrInvalidKeyKind, took %v", err)
+	}
+}
+
+func TestListKeysFormat(t *testing.T) {
+	testcases := []struct {
+		format  string
+		useJSON bool
+		err     error
+	}{
+		{ListKeysFormatTable, false, nil},
+		{ListKeysFormatJSON, true, nil},
+		// format of "acra-keys read" from shared config file
+		{ReadKeyFormatRaw, false, nil},
+		{"xml", false, ErrInvalidListKeysFormat},
+	}
+	for _, tcase := range testcases {
+		params := &CommonKeyListingParameters{format: tcase.format}
+		if err := params.validateFormat(); err != tcase.err {
+			t.Fatalf("[%s] Expected %v, took %v", tcase.format, tcase.err, err)
+		}
+		if tcase.err == nil && params.UseJSON() != tcase.useJSON {
+			t.Fatalf("[%s] Unexpected UseJSON", tcase.format)
+		}
+	}
+}
+
+func TestPrintKeysWithHistoryJSON(t *testing.T) {
+	keys := []keystore.KeyDescription{
+		{KeyID: "current1", Index: 1, Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client1", State: keystore.StateCurrent},
+		{KeyID: "current2", Index: 1, Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client2", State: keystore.StateCurrent},
+	}
+	rotatedKeys := []keystore.KeyDescription{
+		{KeyID: "rotated1", Index: 2, Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client1", State: keystore.StateRotated},
+		{KeyID: "rotated2", Index: 3, Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client1", State: keystore.StateRotated},
+		{KeyID: "other purpose", Index: 2, Purpose: keystore.PurposeSearchHMAC, ClientID: "client1", State: keystore.StateRotated},
+	}
+	output := bytes.Buffer{}
+	if err := printKeysWithHistoryJSON(keys, rotatedKeys, &output); err != nil {
+		t.Fatal(err)
+	}
+	var result []struct {
+		KeyID   string
+		History []keystore.KeyDescription
+	}
+	if err := json.Unmarshal(output.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0].KeyID != "current1" || result[1].KeyID != "current2" {
+		t.Fatalf("Unexpected output:\n%s", output.String())
+	}
+	if len(result[0].History) != 2 || result[0].History[0].KeyID != "rotated1" || result[0].History[1].KeyID != "rotated2" {
+		t.Fatalf("Unexpected history of current1:\n%s", output.String())
+	}
+	if len(result[1].History) != 0 {
+		t.Fatalf("Unexpected history of current2:\n%s", output.String())
+	}
+}
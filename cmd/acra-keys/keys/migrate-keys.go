@@ -256,7 +256,12 @@ func (m *MigrateKeysSubcommand) openKeyStoreV1(params KeyStoreParameters) (*file
 	}
 
 	if redisOptions := cmd.ParseRedisCLIParametersFromFlags(params.GetFlagSet(), ""); redisOptions.KeysConfigured() {
-		keyStorage, err := filesystem.NewRedisStorage(redisOptions.HostPort, redisOptions.Password, redisOptions.DBKeys, nil)
+		redisKeyOptions, err := redisOptions.KeysOptions(params.GetFlagSet())
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			return nil, err
+		}
+		keyStorage, err := filesystem.NewRedisStorage(redisKeyOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Failed to initialise Redis storage")
 			return nil, err
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Output formats of "acra-keys read"
+const (
+	ReadKeyFormatRaw    = "raw"
+	ReadKeyFormatJSON   = "json"
+	ReadKeyFormatPEM    = "pem"
+	ReadKeyFormatBase64 = "base64"
+)
+
+// SupportedReadKeyFormats is a list of output formats supported by `read` subcommand.
+var SupportedReadKeyFormats = []string{
+	ReadKeyFormatRaw,
+	ReadKeyFormatJSON,
+	ReadKeyFormatPEM,
+	ReadKeyFormatBase64,
+}
+
+// ErrUnknownReadKeyFormat returned for unsupported --format value
+var ErrUnknownReadKeyFormat = errors.New("unknown key output format")
+
+// currentKeyIndex is the index of current key, "acra-keys read" reads only current keys
+const currentKeyIndex = 1
+
+// readKeyPurposes are purposes under which keystores describe keys of the read kind, the first one is used
+// if the key isn't described by keystore
+var readKeyPurposes = map[string][]keystore.KeyPurpose{
+	keystore.KeyPoisonPublic:   {keystore.PurposePoisonRecordKeyPair},
+	keystore.KeyPoisonPrivate:  {keystore.PurposePoisonRecordKeyPair},
+	keystore.KeyStoragePublic:  {keystore.PurposeStorageClientPublicKey, keystore.PurposeStorageClientKeyPair},
+	keystore.KeyStoragePrivate: {keystore.PurposeStorageClientPrivateKey, keystore.PurposeStorageClientKeyPair},
+	keystore.KeySymmetric:      {keystore.PurposeStorageClientSymmetricKey},
+}
+
+// readKeyPEMTypes are types of PEM blocks by kind of key
+var readKeyPEMTypes = map[string]string{
+	keystore.KeyPoisonPublic:   "ACRA PUBLIC KEY",
+	keystore.KeyPoisonPrivate:  "ACRA PRIVATE KEY",
+	keystore.KeyStoragePublic:  "ACRA PUBLIC KEY",
+	keystore.KeyStoragePrivate: "ACRA PRIVATE KEY",
+	keystore.KeySymmetric:      "ACRA SYMMETRIC KEY",
+}
+
+// ValidateReadKeyFormat checks that format is supported by "acra-keys read".
+func ValidateReadKeyFormat(format string) error {
+	for _, supported := range SupportedReadKeyFormats {
+		if format == supported {
+			return nil
+		}
+	}
+	return ErrUnknownReadKeyFormat
+}
+
+// ReadKeyMetadata describes the key printed by "acra-keys read".
+type ReadKeyMetadata struct {
+	Purpose      keystore.KeyPurpose `json:"purpose"`
+	ClientID     string              `json:"client_id,omitempty"`
+	Index        int                 `json:"index"`
+	CreationTime *time.Time          `json:"creation_time,omitempty"`
+}
+
+// readKeyJSON is JSON output of "acra-keys read"
+type readKeyJSON struct {
+	ReadKeyMetadata
+	Key []byte `json:"key"`
+}
+
+// ReadKeyMetadataFromKeyStore returns metadata of the requested key. Creation time is filled if keystore
+// describes the key.
+func ReadKeyMetadataFromKeyStore(params ReadKeyParams, keyStore keyLister) ReadKeyMetadata {
+	kind := params.ReadKeyKind()
+	metadata := ReadKeyMetadata{ClientID: string(params.ClientID()), Index: currentKeyIndex}
+	purposes := readKeyPurposes[kind]
+	if len(purposes) > 0 {
+		metadata.Purpose = purposes[0]
+	}
+	descriptions, err := keyStore.ListKeys()
+	if err != nil {
+		log.WithError(err).Warnln("Can't list keys to read key metadata")
+		return metadata
+	}
+	for _, description := range descriptions {
+		if description.ClientID != metadata.ClientID || description.State == keystore.StateRotated {
+			continue
+		}
+		for _, purpose := range purposes {
+			if description.Purpose == purpose {
+				metadata.Purpose = description.Purpose
+				metadata.CreationTime = description.CreationTime
+				return metadata
+			}
+		}
+	}
+	return metadata
+}
+
+// WriteKey writes key in the requested format. Empty format is the same as raw.
+func WriteKey(writer io.Writer, format, kind string, metadata ReadKeyMetadata, key []byte) error {
+	var output []byte
+	switch format {
+	case "", ReadKeyFormatRaw:
+		_, err := writer.Write(key)
+		return err
+	case ReadKeyFormatBase64:
+		output = make([]byte, base64.StdEncoding.EncodedLen(len(key))+1)
+		base64.StdEncoding.Encode(output, key)
+		output[len(output)-1] = '\n'
+	case ReadKeyFormatPEM:
+		headers := map[string]string{
+			"Purpose": string(metadata.Purpose),
+			"Index":   strconv.Itoa(metadata.Index),
+		}
+		if metadata.ClientID != "" {
+			headers["Client-ID"] = metadata.ClientID
+		}
+		if metadata.CreationTime != nil {
+			headers["Creation-Time"] = metadata.CreationTime.UTC().Format(time.RFC3339)
+		}
+		output = pem.EncodeToMemory(&pem.Block{Type: readKeyPEMTypes[kind], Headers: headers, Bytes: key})
+	case ReadKeyFormatJSON:
+		var err error
+		output, err = json.Marshal(readKeyJSON{ReadKeyMetadata: metadata, Key: key})
+		if err != nil {
+			return err
+		}
+		defer utils.ZeroizeBytes(output)
+		if _, err := writer.Write(output); err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte{'\n'})
+		return err
+	default:
+		return ErrUnknownReadKeyFormat
+	}
+	// encoded output contains key material too
+	defer utils.ZeroizeBytes(output)
+	_, err := writer.Write(output)
+	return err
+}
@@ -0,0 +1,109 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+type testKeyLister struct {
+	keys []keystore.KeyDescription
+}
+
+func (lister *testKeyLister) ListKeys() ([]keystore.KeyDescription, error) {
+	return lister.keys, nil
+}
+
+func (lister *testKeyLister) ListRotatedKeys() ([]keystore.KeyDescription, error) {
+	return nil, nil
+}
+
+func TestValidateReadKeyFormat(t *testing.T) {
+	for _, format := range SupportedReadKeyFormats {
+		if err := ValidateReadKeyFormat(format); err != nil {
+			t.Fatalf("Expected valid format %s, took %v", format, err)
+		}
+	}
+	if err := ValidateReadKeyFormat("der"); err != ErrUnknownReadKeyFormat {
+		t.Fatalf("Expected %v, took %v", ErrUnknownReadKeyFormat, err)
+	}
+}
+
+func TestReadKeyMetadataFromKeyStore(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rotated := created.Add(-time.Hour)
+	lister := &testKeyLister{keys: []keystore.KeyDescription{
+		{Purpose: keystore.PurposeStorageClientKeyPair, ClientID: "client", State: keystore.StateRotated, CreationTime: &rotated},
+		{Purpose: keystore.PurposeStorageClientKeyPair, ClientID: "another", State: keystore.StateCurrent},
+		{Purpose: keystore.PurposeStorageClientKeyPair, ClientID: "client", State: keystore.StateCurrent, CreationTime: &created},
+	}}
+	params := &ReadKeySubcommand{readKeyKind: keystore.KeyStoragePrivate, contextID: []byte("client")}
+	metadata := ReadKeyMetadataFromKeyStore(params, lister)
+	if metadata.Purpose != keystore.PurposeStorageClientKeyPair || metadata.ClientID != "client" || metadata.Index != 1 {
+		t.Fatalf("Unexpected metadata %+v", metadata)
+	}
+	if metadata.CreationTime == nil || !metadata.CreationTime.Equal(created) {
+		t.Fatalf("Expected creation time of current key, took %v", metadata.CreationTime)
+	}
+
+	// keys not described by keystore have only default purpose
+	params = &ReadKeySubcommand{readKeyKind: keystore.KeySymmetric, contextID: []byte("client")}
+	metadata = ReadKeyMetadataFromKeyStore(params, lister)
+	if metadata.Purpose != keystore.PurposeStorageClientSymmetricKey || metadata.CreationTime != nil {
+		t.Fatalf("Unexpected metadata %+v", metadata)
+	}
+}
+
+func TestWriteKey(t *testing.T) {
+	key := []byte("key material")
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	metadata := ReadKeyMetadata{Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client", Index: 1, CreationTime: &created}
+
+	output := &bytes.Buffer{}
+	if err := WriteKey(output, "", keystore.KeySymmetric, metadata, key); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Bytes(), key) {
+		t.Fatalf("Expected raw key, took %q", output.Bytes())
+	}
+
+	output.Reset()
+	if err := WriteKey(output, ReadKeyFormatBase64, keystore.KeySymmetric, metadata, key); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "a2V5IG1hdGVyaWFs\n" {
+		t.Fatalf("Unexpected base64 output %q", output.String())
+	}
+
+	output.Reset()
+	if err := WriteKey(output, ReadKeyFormatPEM, keystore.KeySymmetric, metadata, key); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(output.Bytes())
+	if block == nil || block.Type != "ACRA SYMMETRIC KEY" || !bytes.Equal(block.Bytes, key) {
+		t.Fatalf("Unexpected PEM output %q", output.String())
+	}
+	if block.Headers["Client-ID"] != "client" || block.Headers["Creation-Time"] != "2020-01-02T03:04:05Z" || block.Headers["Purpose"] != string(keystore.PurposeStorageClientSymmetricKey) {
+		t.Fatalf("Unexpected PEM headers %v", block.Headers)
+	}
+
+	output.Reset()
+	if err := WriteKey(output, ReadKeyFormatJSON, keystore.KeySymmetric, metadata, key); err != nil {
+		t.Fatal(err)
+	}
+	var decoded readKeyJSON
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Key, key) || decoded.ClientID != "client" || decoded.Index != 1 || !decoded.CreationTime.Equal(created) {
+		t.Fatalf("Unexpected JSON output %s", output.String())
+	}
+
+	if err := WriteKey(output, "der", keystore.KeySymmetric, metadata, key); err != ErrUnknownReadKeyFormat {
+		t.Fatalf("Expected %v, took %v", ErrUnknownReadKeyFormat, err)
+	}
+}
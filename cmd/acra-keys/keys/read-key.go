@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -61,6 +62,7 @@ type ReadKeySubcommand struct {
 	FlagSet *flag.FlagSet
 
 	public, private bool
+	format          string
 
 	readKeyKind string
 	contextID   []byte
@@ -83,6 +85,7 @@ func (p *ReadKeySubcommand) RegisterFlags() {
 	p.CommonKeyStoreParameters.Register(p.FlagSet)
 	p.FlagSet.BoolVar(&p.public, "public", false, "read public key of the keypair")
 	p.FlagSet.BoolVar(&p.private, "private", false, "read private key of the keypair")
+	p.FlagSet.StringVar(&p.format, "format", ReadKeyFormatRaw, fmt.Sprintf("output format of the key: <%s>. json and pem include purpose, client ID, index and creation time of the key", strings.Join(SupportedReadKeyFormats, "|")))
 	p.FlagSet.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Command \"%s\": read and print key material in plaintext\n", CmdReadKey)
 		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...] <key-ID>\n\n", os.Args[0], CmdReadKey)
@@ -97,6 +100,10 @@ func (p *ReadKeySubcommand) Parse(arguments []string) error {
 	if err != nil {
 		return err
 	}
+	if err := ValidateReadKeyFormat(p.format); err != nil {
+		log.WithField("expected", SupportedReadKeyFormats).Errorf("Unknown output format: %s", p.format)
+		return err
+	}
 	args := p.FlagSet.Args()
 	if len(args) < 1 {
 		log.Errorf("\"%s\" command requires key kind", CmdReadKey)
@@ -191,7 +198,11 @@ func (p *ReadKeySubcommand) PrintKeyCommand(params ReadKeyParams, keyStore keyst
 	if p.outWriter != nil {
 		writer = p.outWriter
 	}
-	_, err = writer.Write(keyBytes)
+	var metadata ReadKeyMetadata
+	if p.format == ReadKeyFormatJSON || p.format == ReadKeyFormatPEM {
+		metadata = ReadKeyMetadataFromKeyStore(params, keyStore)
+	}
+	err = WriteKey(writer, p.format, params.ReadKeyKind(), metadata, keyBytes)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to write key")
 	}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/rotation"
+)
+
+// ErrScheduledRotationNotSupported is returned if keystore can't list keys for scheduled rotation
+var ErrScheduledRotationNotSupported = errors.New("keystore doesn't support scheduled rotation")
+
+// RotateKeysParams are parameters of "acra-keys rotate" subcommand.
+type RotateKeysParams interface {
+	KeyTagSelector() keystore.KeyTags
+}
+
+// RotateKeysSubcommand is the "acra-keys rotate" subcommand.
+type RotateKeysSubcommand struct {
+	CommonKeyStoreParameters
+	FlagSet *flag.FlagSet
+
+	tagSelector    string
+	keyTagSelector keystore.KeyTags
+	scheduleSpec   string
+	schedule       rotation.Schedule
+	keyKindsValue  string
+	keyKinds       []string
+	approval       ApprovalParameters
+}
+
+// Name returns the same of this subcommand.
+func (p *RotateKeysSubcommand) Name() string {
+	return CmdRotateKeys
+}
+
+// GetFlagSet returns flag set of this subcommand.
+func (p *RotateKeysSubcommand) GetFlagSet() *flag.FlagSet {
+	return p.FlagSet
+}
+
+// RegisterFlags registers command-line flags of "acra-keys rotate".
+func (p *RotateKeysSubcommand) RegisterFlags() {
+	p.FlagSet = flag.NewFlagSet(CmdRotateKeys, flag.ContinueOnError)
+	p.CommonKeyStoreParameters.Register(p.FlagSet)
+	p.FlagSet.StringVar(&p.tagSelector, "tag_selector", "", "Rotate all keys with all of the tags: <name>=<value>[,<name>=<value>...]")
+	p.FlagSet.StringVar(&p.scheduleSpec, "schedule", "", "Run until interrupted and rotate keys of all clientIDs on cron-like schedule: 'minute hour day-of-month month day-of-week', @daily, @weekly, @monthly or '@every <duration>'. --tag_selector is optional with it")
+	p.approval.RegisterApprovalRequest(p.FlagSet)
+	p.FlagSet.StringVar(&p.keyKindsValue, "schedule_key_kinds", strings.Join(rotation.SupportedKeyKinds, ","), fmt.Sprintf("Comma-separated kinds of keys rotated on --schedule: <%s>", strings.Join(rotation.SupportedKeyKinds, "|")))
+	p.FlagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command \"%s\": generate new versions of keys selected by tags or on schedule\n", CmdRotateKeys)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...] --tag_selector <name>=<value>[,<name>=<value>...]\n", os.Args[0], CmdRotateKeys)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --schedule <schedule> [--tag_selector <name>=<value>[,<name>=<value>...]]\n", os.Args[0], CmdRotateKeys)
+		fmt.Fprintf(os.Stderr, "\t%s %s [options...] --approval_request --operator_key <path> --tag_selector <name>=<value>[,<name>=<value>...]\n", os.Args[0], CmdRotateKeys)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		cmd.PrintFlags(p.FlagSet)
+	}
+}
+
+// Parse command-line parameters of the subcommand.
+func (p *RotateKeysSubcommand) Parse(arguments []string) error {
+	err := cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName)
+	if err != nil {
+		return err
+	}
+	p.keyTagSelector, err = keystore.ParseKeyTags(p.tagSelector)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid --tag_selector")
+		return err
+	}
+	if p.approval.RequestApproval() {
+		if p.scheduleSpec != "" {
+			log.Errorf("\"%s\" command supports --approval_request only with --tag_selector", CmdRotateKeys)
+			return ErrInvalidApprovalParameters
+		}
+		if err := p.approval.validate(); err != nil {
+			return err
+		}
+	}
+	if p.scheduleSpec != "" {
+		p.schedule, err = rotation.ParseSchedule(p.scheduleSpec)
+		if err != nil {
+			log.WithError(err).Errorln("Invalid --schedule")
+			return err
+		}
+		p.keyKinds, err = rotation.ParseKeyKinds(p.keyKindsValue)
+		if err != nil {
+			log.WithError(err).Errorln("Invalid --schedule_key_kinds")
+			return err
+		}
+		return nil
+	}
+	if len(p.keyTagSelector) == 0 {
+		log.Errorf("\"%s\" command requires --tag_selector with at least one tag", CmdRotateKeys)
+		return ErrEmptyTagSelector
+	}
+	return nil
+}
+
+// KeyTagSelector returns tags of keys to be rotated.
+func (p *RotateKeysSubcommand) KeyTagSelector() keystore.KeyTags {
+	return p.keyTagSelector
+}
+
+// Execute this subcommand.
+func (p *RotateKeysSubcommand) Execute() {
+	keyStore, err := OpenKeyStoreForWriting(p)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to open keystore")
+	}
+	if p.approval.RequestApproval() {
+		request := &keystore.ApprovalRequest{Operation: keystore.ApprovalOperationRotate, TagSelector: p.keyTagSelector}
+		if err := SaveApprovalRequest(&p.approval, request, keyStore, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to request approval of keys rotation")
+		}
+		return
+	}
+	if err := p.approval.CheckApprovalPolicy(keyStore); err != nil {
+		log.WithError(err).Fatal("Keys rotation should be requested with --approval_request")
+	}
+	if p.schedule != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := RotateKeysOnSchedule(ctx, p, keyStore); err != nil {
+			log.WithError(err).Fatal("Failed to start scheduled rotation of keys")
+		}
+		return
+	}
+	if err := RotateKeysByTags(p, keyStore); err != nil {
+		log.WithError(err).Fatal("Failed to rotate keys")
+	}
+}
+
+// Schedule returns schedule of rotation or nil if keys are rotated once.
+func (p *RotateKeysSubcommand) Schedule() rotation.Schedule {
+	return p.schedule
+}
+
+// ScheduleKeyKinds returns kinds of keys rotated on schedule.
+func (p *RotateKeysSubcommand) ScheduleKeyKinds() []string {
+	return p.keyKinds
+}
+
+// ScheduledRotateKeysParams are parameters of "acra-keys rotate --schedule".
+type ScheduledRotateKeysParams interface {
+	RotateKeysParams
+	Schedule() rotation.Schedule
+	ScheduleKeyKinds() []string
+}
+
+// RotateKeysOnSchedule generates new versions of clientID keys of selected kinds and tags on schedule until ctx is done.
+func RotateKeysOnSchedule(ctx context.Context, params ScheduledRotateKeysParams, keyStore keystore.KeyMaking) error {
+	rotationKeyStore, ok := keyStore.(rotation.KeyStore)
+	if !ok {
+		return ErrScheduledRotationNotSupported
+	}
+	rotator, err := rotation.NewRotator(rotationKeyStore, params.ScheduleKeyKinds(), params.KeyTagSelector())
+	if err != nil {
+		return err
+	}
+	log.WithField("kinds", strings.Join(params.ScheduleKeyKinds(), ",")).Infoln("Started scheduled rotation of keys")
+	rotator.Run(ctx, params.Schedule())
+	return nil
+}
+
+// RotateKeysByTags generates new versions of current keys which tags match the selector. Previous versions become
+// rotated keys and new ones keep tags of the key ID.
+func RotateKeysByTags(params RotateKeysParams, keyStore keystore.KeyMaking) error {
+	taggedKeyStore, err := AsTaggedKeyStore(keyStore)
+	if err != nil {
+		return err
+	}
+	descriptions, err := taggedKeyStore.ListKeys()
+	if err != nil {
+		return err
+	}
+	rotated := 0
+	for _, reference := range selectKeys(descriptions, params.KeyTagSelector()) {
+		if err := rotateKey(reference, keyStore); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"kind": reference.kind, "client_id": reference.clientID}).Infoln("Rotated key")
+		rotated++
+	}
+	log.WithField("tags", params.KeyTagSelector().String()).Infof("Rotated %d keys", rotated)
+	return nil
+}
+
+// rotateKey generates new version of the referenced key.
+func rotateKey(reference keyReference, keyStore keystore.KeyMaking) error {
+	switch reference.kind {
+	case keystore.KeyStorageKeypair:
+		return keyStore.GenerateDataEncryptionKeys(reference.ClientID())
+	case keystore.KeySymmetric:
+		return keyStore.GenerateClientIDSymmetricKey(reference.ClientID())
+	case keystore.KeySearch:
+		return keyStore.GenerateHmacKey(reference.ClientID())
+	case keystore.KeyPoisonKeypair:
+		return keyStore.GeneratePoisonKeyPair()
+	case keystore.KeyPoisonSymmetric:
+		return keyStore.GeneratePoisonSymmetricKey()
+	case string(keystore.PurposeAuditLog):
+		return keyStore.GenerateLogKey()
+	default:
+		return ErrUnknownKeyKind
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keys
+
+import (
+	"errors"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// Key tags errors:
+var (
+	ErrKeyTagsNotSupported = errors.New("keystore doesn't support key tags")
+	ErrEmptyTagSelector    = errors.New("empty tag selector")
+)
+
+// TaggedKeyStore lists keys with tags and assigns tags to them.
+type TaggedKeyStore interface {
+	keystore.KeyTagStore
+	ListKeys() ([]keystore.KeyDescription, error)
+	ListRotatedKeys() ([]keystore.KeyDescription, error)
+}
+
+// AsTaggedKeyStore returns keystore as TaggedKeyStore or ErrKeyTagsNotSupported if it can't store tags.
+func AsTaggedKeyStore(keyStore interface{}) (TaggedKeyStore, error) {
+	taggedKeyStore, ok := keyStore.(TaggedKeyStore)
+	if !ok {
+		return nil, ErrKeyTagsNotSupported
+	}
+	return taggedKeyStore, nil
+}
+
+// keyReference identifies the key by kind, client ID and index like arguments of "acra-keys destroy".
+type keyReference struct {
+	kind     string
+	clientID string
+	index    int
+}
+
+// keyKindByPurpose returns kind of the key with purpose. Audit log key has no kind in KeyPurposeToKeyKind
+// because it can't be destroyed, so it's returned only for rotation.
+func keyKindByPurpose(purpose keystore.KeyPurpose) (string, bool) {
+	if purpose == keystore.PurposeAuditLog {
+		return string(keystore.PurposeAuditLog), true
+	}
+	kind, ok := keystore.KeyPurposeToKeyKind[purpose]
+	return kind, ok
+}
+
+// selectKeys returns references to keys matching the selector. Private and public parts of key pairs are described
+// separately but referenced once. Rotated keys are ordered by index in descending order so destruction of one of them
+// doesn't shift indexes of the next ones.
+func selectKeys(descriptions []keystore.KeyDescription, selector keystore.KeyTags) []keyReference {
+	references := make([]keyReference, 0, len(descriptions))
+	seen := make(map[keyReference]bool, len(descriptions))
+	for _, description := range keystore.FilterKeysByTags(descriptions, selector) {
+		kind, ok := keyKindByPurpose(description.Purpose)
+		if !ok {
+			log.WithField("KeyID", description.KeyID).Warnf("Skip key with unsupported purpose %s", description.Purpose)
+			continue
+		}
+		reference := keyReference{kind: kind, clientID: description.ClientID, index: description.Index}
+		if seen[reference] {
+			continue
+		}
+		seen[reference] = true
+		references = append(references, reference)
+	}
+	sort.SliceStable(references, func(i, j int) bool {
+		return references[i].index > references[j].index
+	})
+	return references
+}
+
+// tagKeys merges tags into tags of current keys with any of purposes and client ID.
+func tagKeys(keyStore TaggedKeyStore, tags keystore.KeyTags, clientID []byte, purposes ...keystore.KeyPurpose) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	descriptions, err := keyStore.ListKeys()
+	if err != nil {
+		return err
+	}
+	for _, description := range descriptions {
+		if description.ClientID != string(clientID) {
+			continue
+		}
+		for _, purpose := range purposes {
+			if description.Purpose != purpose {
+				continue
+			}
+			merged := make(keystore.KeyTags, len(description.Tags)+len(tags))
+			for name, value := range description.Tags {
+				merged[name] = value
+			}
+			for name, value := range tags {
+				merged[name] = value
+			}
+			if err := keyStore.SetKeyTags(description.KeyID, merged); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DestroyKeyKind returns kind of the referenced key.
+func (reference keyReference) DestroyKeyKind() string {
+	return reference.kind
+}
+
+// ClientID returns client ID of the referenced key.
+func (reference keyReference) ClientID() []byte {
+	if reference.clientID == "" {
+		return nil
+	}
+	return []byte(reference.clientID)
+}
+
+// Index returns index of the referenced key.
+func (reference keyReference) Index() int {
+	return reference.index
+}
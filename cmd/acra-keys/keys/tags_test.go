@@ -0,0 +1,128 @@
+package keys
+
+import (
+	"encoding/base64"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+)
+
+func countKeyDescriptions(keys []keystore.KeyDescription, purpose keystore.KeyPurpose, clientID string) int {
+	count := 0
+	for _, key := range keys {
+		if key.Purpose == purpose && key.ClientID == clientID {
+			count++
+		}
+	}
+	return count
+}
+
+func TestKeyTagsBulkOperations_FS_V1(t *testing.T) {
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdGenerate, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	storeParams := CommonKeyStoreParameters{keyDir: dirName}
+	store, err := openKeyStoreV1(&GenerateKeySubcommand{CommonKeyStoreParameters: storeParams, flagSet: flagSet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stagingTags, _ := keystore.ParseKeyTags("env=staging,team=payments")
+	productionTags, _ := keystore.ParseKeyTags("env=production,team=payments")
+	for clientID, tags := range map[string]keystore.KeyTags{"staging": stagingTags, "production": productionTags} {
+		generateCMD := &GenerateKeySubcommand{
+			CommonKeyStoreParameters: storeParams,
+			flagSet:                  flagSet,
+			clientID:                 clientID,
+			acraBlocks:               true,
+			searchHMAC:               true,
+			keyTags:                  tags,
+		}
+		if _, err := GenerateAcraKeys(generateCMD, store, GenerateAsRequested); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stagingSelector, _ := keystore.ParseKeyTags("env=staging")
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keystore.FilterKeysByTags(keys, productionTags)) != 2 {
+		t.Fatalf("Expected 2 keys with production tags, took %v", keys)
+	}
+	teamSelector, _ := keystore.ParseKeyTags("team=payments")
+	if len(keystore.FilterKeysByTags(keys, teamSelector)) != 4 {
+		t.Fatalf("Expected 4 keys of the team, took %v", keys)
+	}
+
+	// rotate staging keys twice, production keys stay untouched
+	for i := 0; i < 2; i++ {
+		if err := RotateKeysByTags(&RotateKeysSubcommand{keyTagSelector: stagingSelector}, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotatedKeys, err := store.ListRotatedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotatedKeys) != 4 || len(keystore.FilterKeysByTags(rotatedKeys, stagingTags)) != 4 {
+		t.Fatalf("Expected 4 rotated staging keys with tags, took %v", rotatedKeys)
+	}
+
+	// destroy all rotated staging keys
+	destroyCMD := &DestroyKeySubcommand{keyTagSelector: stagingSelector, rotatedKeys: true}
+	if err := DestroyKeysByTags(destroyCMD, store); err != nil {
+		t.Fatal(err)
+	}
+	rotatedKeys, err = store.ListRotatedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotatedKeys) != 0 {
+		t.Fatalf("Expected no rotated keys, took %v", rotatedKeys)
+	}
+
+	// destroy current staging keys
+	destroyCMD.rotatedKeys = false
+	if err := DestroyKeysByTags(destroyCMD, store); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countKeyDescriptions(keys, keystore.PurposeStorageClientSymmetricKey, "staging") != 0 || countKeyDescriptions(keys, keystore.PurposeSearchHMAC, "staging") != 0 {
+		t.Fatalf("Expected destroyed staging keys, took %v", keys)
+	}
+	if countKeyDescriptions(keys, keystore.PurposeStorageClientSymmetricKey, "production") != 1 || countKeyDescriptions(keys, keystore.PurposeSearchHMAC, "production") != 1 {
+		t.Fatalf("Expected untouched production keys, took %v", keys)
+	}
+}
+
+func TestKeyTagsNotSupported(t *testing.T) {
+	if _, err := AsTaggedKeyStore(&filesystem.KeyStore{}); err != nil {
+		t.Fatalf("Expected support of tags by filesystem keystore, took %v", err)
+	}
+	if _, err := AsTaggedKeyStore(struct{ keystore.KeyMaking }{}); err != ErrKeyTagsNotSupported {
+		t.Fatalf("Expected %v, took %v", ErrKeyTagsNotSupported, err)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/cmd/acra-tokens/tokens"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+	tokenStorage "github.com/cossacklabs/acra/pseudonymization/storage"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Kinds of records verified by "acra-keys verify-storage"
+const (
+	StorageRecordKey   = "key"
+	StorageRecordToken = "token"
+)
+
+// Kinds of problems with token records found by "acra-keys verify-storage".
+const (
+	// TokenIssueForeign means token which context doesn't match any client ID with storage symmetric key in keystore
+	TokenIssueForeign = "foreign"
+	// TokenIssueCorrupted means token record which can't be decoded or decrypted with keys of its client ID
+	TokenIssueCorrupted = "corrupted"
+)
+
+// Errors returned by storage verification
+var (
+	ErrTokenVerificationNotSupported = errors.New("token storage doesn't support verification of records")
+	errUnknownTokenContext           = errors.New("context of token doesn't match any client ID with storage symmetric key")
+)
+
+// StorageVerificationIssue describes problem with one key file or token record.
+type StorageVerificationIssue struct {
+	Record string `json:"record"`
+	Path   string `json:"path"`
+	Issue  string `json:"issue"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Critical returns true for issues which make keys or tokens unusable, other issues are warnings.
+func (issue StorageVerificationIssue) Critical() bool {
+	switch issue.Issue {
+	case filesystemV2.AuditIssueOrphaned, filesystemV2.AuditIssueInvalidPermissions:
+		return false
+	case filesystem.KeyFileIssueForeign:
+		// unknown files in key directory don't affect keys, while foreign tokens can't be detokenized
+		return issue.Record == StorageRecordToken
+	}
+	return true
+}
+
+// StorageVerificationReport is the result of "acra-keys verify-storage".
+type StorageVerificationReport struct {
+	Status         string                     `json:"status"`
+	Keys           int                        `json:"keys"`
+	VerifiedKeys   int                        `json:"verified_keys"`
+	Tokens         int                        `json:"tokens"`
+	VerifiedTokens int                        `json:"verified_tokens"`
+	Issues         []StorageVerificationIssue `json:"issues"`
+	Error          string                     `json:"error,omitempty"`
+}
+
+// ExitCode returns process exit code corresponding to report status.
+func (report *StorageVerificationReport) ExitCode() int {
+	return auditExitCode(report.Status)
+}
+
+func (report *StorageVerificationReport) addIssue(record, path, issue string, err error) {
+	report.Issues = append(report.Issues, StorageVerificationIssue{Record: record, Path: path, Issue: issue, Error: err.Error()})
+}
+
+// VerifyStorageSubcommand is the "acra-keys verify-storage" subcommand.
+type VerifyStorageSubcommand struct {
+	CommonKeyStoreParameters
+	FlagSet *flag.FlagSet
+
+	tokenStorage tokens.CommonTokenStorageParameters
+	useJSON      bool
+	outWriter    io.Writer
+}
+
+// Name returns the same of this subcommand.
+func (p *VerifyStorageSubcommand) Name() string {
+	return CmdVerifyStorage
+}
+
+// GetFlagSet returns flag set of this subcommand.
+func (p *VerifyStorageSubcommand) GetFlagSet() *flag.FlagSet {
+	return p.FlagSet
+}
+
+// RegisterFlags registers command-line flags of "acra-keys verify-storage".
+func (p *VerifyStorageSubcommand) RegisterFlags() {
+	p.FlagSet = flag.NewFlagSet(CmdVerifyStorage, flag.ContinueOnError)
+	p.CommonKeyStoreParameters.Register(p.FlagSet)
+	p.tokenStorage.Register(p.FlagSet)
+	cmd.RegisterRedisTokenStoreParametersWithPrefix(p.FlagSet, "", "")
+	p.FlagSet.BoolVar(&p.useJSON, "json", false, "use machine-readable JSON output")
+	p.FlagSet.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command \"%s\": decrypt and verify all keys of keystore and tokens of token storage with current master keys\n", CmdVerifyStorage)
+		fmt.Fprintf(os.Stderr, "\n\t%s %s [options...]\n", os.Args[0], CmdVerifyStorage)
+		fmt.Fprintf(os.Stderr, "\nTokens are verified if --token_db, --redis_db_tokens or --token_storage_backend is set.\n")
+		fmt.Fprintf(os.Stderr, "\nExit codes: %d - no issues, %d - unknown files or invalid permissions in keystore, %d - corrupted or foreign keys and tokens, %d - verification failed\n",
+			AuditExitCodeOK, AuditExitCodeWarning, AuditExitCodeCritical, AuditExitCodeFailed)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		cmd.PrintFlags(p.FlagSet)
+	}
+}
+
+// Parse command-line parameters of the subcommand.
+func (p *VerifyStorageSubcommand) Parse(arguments []string) error {
+	if err := cmd.ParseFlagsWithConfig(p.FlagSet, arguments, DefaultConfigPath, ServiceName); err != nil {
+		return err
+	}
+	if p.tokenStorage.Configured(p.FlagSet) {
+		return p.tokenStorage.Validate(p.FlagSet)
+	}
+	return nil
+}
+
+// Execute this subcommand.
+func (p *VerifyStorageSubcommand) Execute() {
+	var storage tokenCommon.TokenStorage
+	if p.tokenStorage.Configured(p.FlagSet) {
+		var err error
+		storage, err = p.tokenStorage.Open(p.FlagSet)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to open token storage")
+			os.Exit(AuditExitCodeFailed)
+		}
+	}
+	report := VerifyStorage(p, storage)
+	writer := p.outWriter
+	if writer == nil {
+		writer = os.Stdout
+	}
+	var err error
+	if p.useJSON {
+		err = printStorageVerificationReportJSON(report, writer)
+	} else {
+		err = printStorageVerificationReport(report, writer)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Failed to print storage verification report")
+		os.Exit(AuditExitCodeFailed)
+	}
+	os.Exit(report.ExitCode())
+}
+
+// VerifyStorage decrypts all keys of keystore and tokens of storage, if it isn't nil, and returns report with status
+// of storages. Key rings of keystore v2 are verified with AuditKeyRings, key files of keystore v1 are decrypted.
+// Failures of the verification itself are recorded in the report with AuditStatusFailed.
+func VerifyStorage(params KeyStoreParameters, storage tokenCommon.TokenStorage) *StorageVerificationReport {
+	report := &StorageVerificationReport{Status: AuditStatusFailed, Issues: make([]StorageVerificationIssue, 0)}
+	if err := verifyKeys(params, report); err != nil {
+		log.WithError(err).Errorln("Failed to verify keys")
+		report.Error = err.Error()
+		return report
+	}
+	if storage != nil {
+		keyStore, err := OpenKeyStoreForReading(params)
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		if err := verifyTokens(keyStore, storage, report); err != nil {
+			log.WithError(err).Errorln("Failed to verify tokens")
+			report.Error = err.Error()
+			return report
+		}
+	}
+	report.Status = AuditStatusOK
+	for _, issue := range report.Issues {
+		if issue.Critical() {
+			report.Status = AuditStatusCritical
+			break
+		}
+		report.Status = AuditStatusWarning
+	}
+	return report
+}
+
+func verifyKeys(params KeyStoreParameters, report *StorageVerificationReport) error {
+	if IsKeyStoreV2(params) {
+		auditor, err := OpenKeyStoreForAudit(params)
+		if err != nil {
+			return err
+		}
+		auditReport, err := auditor.AuditKeyRings()
+		if err != nil {
+			return err
+		}
+		report.Keys, report.VerifiedKeys = auditReport.KeyRings, auditReport.Verified
+		for _, issue := range auditReport.Issues {
+			report.Issues = append(report.Issues, StorageVerificationIssue{Record: StorageRecordKey, Path: issue.Path, Issue: issue.Issue, Error: issue.Error})
+		}
+		return nil
+	}
+	keyStore, err := openKeyStoreV1(params)
+	if err != nil {
+		return err
+	}
+	verificationReport, err := keyStore.VerifyKeyFiles()
+	if err != nil {
+		return err
+	}
+	report.Keys, report.VerifiedKeys = verificationReport.Keys, verificationReport.Verified
+	for _, issue := range verificationReport.Issues {
+		report.Issues = append(report.Issues, StorageVerificationIssue{Record: StorageRecordKey, Path: issue.Path, Issue: issue.Issue, Error: issue.Error})
+	}
+	return nil
+}
+
+// verifyTokens decrypts tokens with storage symmetric keys of client IDs which contexts match digests of records
+func verifyTokens(keyStore keystore.ServerKeyStore, storage tokenCommon.TokenStorage, report *StorageVerificationReport) error {
+	visitor, ok := storage.(tokenCommon.TokenRecordVisitor)
+	if !ok {
+		return ErrTokenVerificationNotSupported
+	}
+	contexts, err := tokenContextsByDigest(keyStore)
+	if err != nil {
+		return err
+	}
+	encryptor, err := tokenStorage.NewSCellEncryptor(keyStore)
+	if err != nil {
+		return err
+	}
+	return visitor.VisitRecords(func(record tokenCommon.TokenRecord, err error) error {
+		report.Tokens++
+		path := hex.EncodeToString(record.ContextDigest) + "/" + hex.EncodeToString(record.ID)
+		if err != nil {
+			report.addIssue(StorageRecordToken, path, TokenIssueCorrupted, err)
+			return nil
+		}
+		context, ok := contexts[string(record.ContextDigest)]
+		if !ok {
+			report.addIssue(StorageRecordToken, path, TokenIssueForeign, errUnknownTokenContext)
+			return nil
+		}
+		data, err := encryptor.Decrypt(record.Data, context)
+		if err != nil {
+			report.addIssue(StorageRecordToken, path, TokenIssueCorrupted, err)
+			return nil
+		}
+		utils.ZeroizeBytes(data)
+		report.VerifiedTokens++
+		return nil
+	})
+}
+
+// tokenContextsByDigest returns token contexts of client IDs with storage symmetric keys by their digests
+func tokenContextsByDigest(keyStore keystore.ServerKeyStore) (map[string]tokenCommon.TokenContext, error) {
+	descriptions, err := keyStore.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	contexts := make(map[string]tokenCommon.TokenContext)
+	for _, description := range descriptions {
+		if description.Purpose != keystore.PurposeStorageClientSymmetricKey && description.Purpose != keystoreV2.PurposeStorageClientSym {
+			continue
+		}
+		context := tokenCommon.TokenContext{ClientID: []byte(description.ClientID)}
+		contexts[string(tokenCommon.AggregateTokenContextToBytes(context))] = context
+	}
+	return contexts, nil
+}
+
+func printStorageVerificationReportJSON(report *StorageVerificationReport, writer io.Writer) error {
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(writer, string(jsonReport))
+	return err
+}
+
+func printStorageVerificationReport(report *StorageVerificationReport, writer io.Writer) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Status:          %s\n", report.Status)
+	if report.Error != "" {
+		fmt.Fprintf(&buffer, "Error:           %s\n", report.Error)
+	}
+	fmt.Fprintf(&buffer, "Keys:            %d\n", report.Keys)
+	fmt.Fprintf(&buffer, "Verified keys:   %d\n", report.VerifiedKeys)
+	fmt.Fprintf(&buffer, "Tokens:          %d\n", report.Tokens)
+	fmt.Fprintf(&buffer, "Verified tokens: %d\n", report.VerifiedTokens)
+	if len(report.Issues) > 0 {
+		fmt.Fprintf(&buffer, "Issues:\n")
+	}
+	for _, issue := range report.Issues {
+		fmt.Fprintf(&buffer, "  %-6s %-20s %s: %s\n", issue.Record, issue.Issue, issue.Path, issue.Error)
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
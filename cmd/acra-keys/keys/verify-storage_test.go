@@ -0,0 +1,125 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keys
+
+import (
+	"encoding/base64"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+	tokenStorage "github.com/cossacklabs/acra/pseudonymization/storage"
+)
+
+func TestVerifyStorage_FS_V1(t *testing.T) {
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagSet := flag.NewFlagSet(CmdVerifyStorage, flag.ContinueOnError)
+	keyloader.RegisterCLIParametersWithFlagSet(flagSet, "", "")
+	if err := flagSet.Set("keystore_encryption_type", keyloader.KeystoreStrategyEnvMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+
+	dirName := t.TempDir()
+	if err := os.Chmod(dirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+	verifyCmd := &VerifyStorageSubcommand{
+		CommonKeyStoreParameters: CommonKeyStoreParameters{keyDir: dirName},
+		FlagSet:                  flagSet,
+	}
+	store, err := openKeyStoreV1(verifyCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	if err := store.GenerateClientIDSymmetricKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := tokenStorage.NewMemoryTokenStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := tokenStorage.NewSCellEncryptor(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := tokenCommon.TokenContext{ClientID: clientID}
+	encrypted, err := encryptor.Encrypt([]byte("token"), context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Save([]byte("valid"), context, encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	report := VerifyStorage(verifyCmd, tokens)
+	if report.Status != AuditStatusOK || report.Keys != 2 || report.VerifiedKeys != 2 || report.Tokens != 1 || report.VerifiedTokens != 1 {
+		t.Fatalf("Unexpected report of valid storages: %+v", report)
+	}
+
+	if err := os.WriteFile(filepath.Join(dirName, "unknown.keyring"), []byte("unknown"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report = VerifyStorage(verifyCmd, tokens)
+	if report.Status != AuditStatusWarning || report.ExitCode() != AuditExitCodeWarning || len(report.Issues) != 1 || report.Issues[0].Issue != filesystem.KeyFileIssueForeign {
+		t.Fatalf("Expected warning about foreign file, took %+v", report)
+	}
+
+	if err := tokens.Save([]byte("foreign"), tokenCommon.TokenContext{ClientID: []byte("unknown")}, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Save([]byte("corrupted"), context, []byte("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dirName, filesystem.GetServerDecryptionKeyFilename(clientID))
+	if err := os.WriteFile(keyPath, []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report = VerifyStorage(verifyCmd, tokens)
+	if report.Status != AuditStatusCritical || report.ExitCode() != AuditExitCodeCritical {
+		t.Fatalf("Expected critical status, took %+v", report)
+	}
+	tokenIssues := make(map[string]int)
+	for _, issue := range report.Issues {
+		if issue.Record == StorageRecordToken {
+			tokenIssues[issue.Issue]++
+		} else if issue.Path == keyPath && issue.Issue != filesystem.KeyFileIssueCorrupted {
+			t.Fatalf("Expected corrupted key, took %+v", issue)
+		}
+	}
+	if report.VerifiedKeys != 1 || report.Tokens != 3 || report.VerifiedTokens != 1 || len(report.Issues) != 4 {
+		t.Fatalf("Unexpected report of corrupted storages: %+v", report)
+	}
+	if tokenIssues[TokenIssueForeign] != 1 || tokenIssues[TokenIssueCorrupted] != 1 {
+		t.Fatalf("Expected foreign and corrupted tokens, took %+v", report.Issues)
+	}
+}
@@ -26,6 +26,7 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -41,9 +42,13 @@ import (
 	filesystemBackendV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/poison"
+	"github.com/cossacklabs/acra/pseudonymization"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+	tokenStorage "github.com/cossacklabs/acra/pseudonymization/storage"
 	"github.com/cossacklabs/acra/utils"
 
 	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 )
 
 // Constants used by AcraPoisonRecordsMaker
@@ -59,12 +64,35 @@ const (
 	RecordTypeAcraBlock  = "acrablock"
 )
 
+// Modes of poison record generation for columns with different protection
+const (
+	ModeEncryption   = "encryption"
+	ModeSearchable   = "searchable"
+	ModeTokenization = "tokenization"
+)
+
+// Token types of tokenized columns which may store poison records
+const (
+	TokenTypeString = "str"
+	TokenTypeBytes  = "bytes"
+)
+
+// default open mode with which to initialize BoltDB storage
+const boltDBOpenMode = os.FileMode(0600)
+
 func main() {
-	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+	keysDir := cmd.RegisterKeysDirParameter()
 	dataLength := flag.Int("data_length", poison.UseDefaultDataLength, fmt.Sprintf("Length of random data for data block in acrastruct. -1 is random in range 1..%v", poison.DefaultDataLength))
 	recordType := flag.String("type", RecordTypeAcraStruct, fmt.Sprintf("Type of poison record: \"%s\" | \"%s\"\n", RecordTypeAcraStruct, RecordTypeAcraBlock))
+	mode := flag.String("mode", ModeEncryption, fmt.Sprintf("Protection of column where poison record will be stored: <%s|%s|%s>. '%s' prefixes poison record with HMAC calculated with search key of --client_id, '%s' saves poison record in token storage and outputs token of --client_id", ModeEncryption, ModeSearchable, ModeTokenization, ModeSearchable, ModeTokenization))
+	clientID := flag.String("client_id", "", "Client ID of searchable or tokenized column")
+	tokenType := flag.String("token_type", TokenTypeBytes, fmt.Sprintf("Token type of tokenized column: <%s|%s>", TokenTypeString, TokenTypeBytes))
+	tokenDB := flag.String("token_db", "", "Path to BoltDB database file with tokens used in tokenization mode")
+	cmd.RegisterTokenStorageBackendParameters()
 
+	cmd.RegisterRedisKeystoreParameters()
 	keyloader.RegisterKeyStoreStrategyParameters()
+	loggingParams := cmd.RegisterLoggingParameters()
 	logging.SetLogLevel(logging.LogDiscard)
 
 	err := cmd.Parse(defaultConfigPath, serviceName)
@@ -73,6 +101,28 @@ func main() {
 			Errorln("can't parse args")
 		os.Exit(1)
 	}
+	logFinalize, err := loggingParams.Apply(serviceName, logging.LogDiscard)
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialise output writer for logging customization")
+		os.Exit(1)
+	}
+	defer logFinalize()
+
+	if *recordType != RecordTypeAcraStruct && *recordType != RecordTypeAcraBlock {
+		log.Errorf("Incorrect type of record. Should be used \"%s\" or \"%s\"\n", RecordTypeAcraStruct, RecordTypeAcraBlock)
+		os.Exit(1)
+	}
+	switch *mode {
+	case ModeEncryption:
+	case ModeSearchable, ModeTokenization:
+		if *clientID == "" || !keystore.ValidateID([]byte(*clientID)) {
+			log.Errorf("--client_id is required and should be valid for %s mode", *mode)
+			os.Exit(1)
+		}
+	default:
+		log.Errorf("Incorrect mode. Should be used \"%s\", \"%s\" or \"%s\"\n", ModeEncryption, ModeSearchable, ModeTokenization)
+		os.Exit(1)
+	}
 
 	var store keystore.PoisonKeyStorageAndGenerator
 	if filesystemV2.IsKeyDirectory(*keysDir) {
@@ -81,22 +131,86 @@ func main() {
 		store = openKeyStoreV1(*keysDir)
 	}
 	var poisonRecord []byte
-	switch *recordType {
-	case RecordTypeAcraStruct:
-		poisonRecord, err = poison.CreatePoisonRecord(store, *dataLength)
-	case RecordTypeAcraBlock:
+	if *mode == ModeSearchable {
+		hmacKeyStore, ok := store.(keystore.HmacKeyStore)
+		if !ok {
+			log.Errorln("Keystore doesn't support search keys required for searchable mode")
+			os.Exit(1)
+		}
+		poisonRecord, err = poison.CreateSearchablePoisonRecord(store, hmacKeyStore, []byte(*clientID), *dataLength, *recordType == RecordTypeAcraBlock)
+	} else if *recordType == RecordTypeAcraBlock {
 		poisonRecord, err = poison.CreateSymmetricPoisonRecord(store, *dataLength)
-	default:
-		log.Errorf("Incorrect type of record. Should be used \"%s\" or \"%s\"\n", RecordTypeAcraStruct, RecordTypeAcraBlock)
-		os.Exit(1)
+	} else {
+		poisonRecord, err = poison.CreatePoisonRecord(store, *dataLength)
 	}
 	if err != nil {
 		log.WithError(err).Errorln("Can't create poison record")
 		os.Exit(1)
 	}
+	if *mode == ModeTokenization {
+		token, err := tokenizePoisonRecord(store, poisonRecord, *tokenDB, []byte(*clientID), *tokenType)
+		if err != nil {
+			log.WithError(err).Errorln("Can't tokenize poison record")
+			os.Exit(1)
+		}
+		if *tokenType == TokenTypeString {
+			fmt.Println(string(token))
+			return
+		}
+		poisonRecord = token
+	}
 	fmt.Println(base64.StdEncoding.EncodeToString(poisonRecord))
 }
 
+// tokenizePoisonRecord saves poison record in token storage configured with --token_db or --token_storage_backend
+// and returns its token, token storage is encrypted with keys of clientID same as by AcraServer
+func tokenizePoisonRecord(store keystore.PoisonKeyStorageAndGenerator, poisonRecord []byte, tokenDB string, clientID []byte, tokenTypeName string) ([]byte, error) {
+	var tokenType tokenCommon.TokenType
+	switch tokenTypeName {
+	case TokenTypeString:
+		tokenType = tokenCommon.TokenType_String
+	case TokenTypeBytes:
+		tokenType = tokenCommon.TokenType_Bytes
+	default:
+		return nil, poison.ErrUnsupportedTokenType
+	}
+	tokenKeyStore, ok := store.(keystore.SymmetricEncryptionKeyStore)
+	if !ok {
+		return nil, errors.New("keystore doesn't support symmetric keys required to encrypt token storage")
+	}
+	var storage tokenCommon.TokenStorage
+	backend := cmd.ParseTokenStorageBackendParametersFromFlags(flag.CommandLine)
+	switch {
+	case backend.Configured() && tokenDB != "":
+		return nil, cmd.ErrTokenStorageConflict
+	case backend.Configured():
+		backendStorage, closer, err := tokenStorage.OpenTokenStorageBackend(backend.Backend, backend.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		defer closer.Close()
+		storage = backendStorage
+	case tokenDB != "":
+		db, err := bolt.Open(tokenDB, boltDBOpenMode, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		storage = tokenStorage.NewBoltDBTokenStorage(db)
+	default:
+		return nil, errors.New("--token_db or --token_storage_backend is required for tokenization mode")
+	}
+	tokenEncryptor, err := tokenStorage.NewSCellEncryptor(tokenKeyStore)
+	if err != nil {
+		return nil, err
+	}
+	tokenizer, err := pseudonymization.NewPseudoanonymizer(tokenStorage.WrapStorageWithEncryption(storage, tokenEncryptor))
+	if err != nil {
+		return nil, err
+	}
+	return poison.CreateTokenizedPoisonRecord(poisonRecord, tokenizer, clientID, tokenType)
+}
+
 func openKeyStoreV1(output string) keystore.PoisonKeyStorageAndGenerator {
 	var keyStoreEncryptor keystore.KeyEncryptor
 
@@ -116,7 +230,7 @@ func openKeyStoreV1(output string) keystore.PoisonKeyStorageAndGenerator {
 				Errorln("Can't get Redis options")
 			os.Exit(1)
 		}
-		keyStorage, err := filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, redisOptions.TLSConfig)
+		keyStorage, err := filesystem.NewRedisStorage(redisOptions)
 		if err != nil {
 			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
 				Errorln("Can't initialize Redis client")
@@ -24,11 +24,13 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
 	"container/list"
 	"crypto/tls"
 	"database/sql"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -71,41 +73,90 @@ type Executor interface {
 
 // InsertExecutor will run Insert statement
 type InsertExecutor struct {
+	db              *sql.DB
 	insertStatement *sql.Stmt
+	// batchSize is number of inserts executed in one transaction, each insert is committed separately if it's less than 2
+	batchSize   int
+	tx          *sql.Tx
+	txStatement *sql.Stmt
+	txInserts   int
 }
 
 // NewInsertExecutor creates new executor for Insert statements
-func NewInsertExecutor(sql string, db *sql.DB) *InsertExecutor {
+func NewInsertExecutor(sql string, db *sql.DB, batchSize int) *InsertExecutor {
 	stmt, err := db.Prepare(sql)
 	if err != nil {
 		ErrorExit("can't prepare sql statement", err)
 	}
-	return &InsertExecutor{insertStatement: stmt}
+	return &InsertExecutor{db: db, insertStatement: stmt, batchSize: batchSize}
 }
 
 // Execute inserts
 func (ex *InsertExecutor) Execute(data []byte) {
-	_, err := ex.insertStatement.Exec(&data)
-	if err != nil {
+	if ex.batchSize < 2 {
+		_, err := ex.insertStatement.Exec(&data)
+		if err != nil {
+			ErrorExit("can't bind args to prepared statement", err)
+		}
+		return
+	}
+	if ex.tx == nil {
+		tx, err := ex.db.Begin()
+		if err != nil {
+			ErrorExit("can't begin transaction", err)
+		}
+		ex.tx = tx
+		ex.txStatement = tx.Stmt(ex.insertStatement)
+	}
+	if _, err := ex.txStatement.Exec(&data); err != nil {
+		if rollbackErr := ex.tx.Rollback(); rollbackErr != nil {
+			log.WithError(rollbackErr).Errorln("Can't rollback transaction")
+		}
 		ErrorExit("can't bind args to prepared statement", err)
 	}
+	ex.txInserts++
+	if ex.txInserts >= ex.batchSize {
+		ex.commit()
+	}
+}
+
+// commit finishes current transaction with batch of inserts
+func (ex *InsertExecutor) commit() {
+	ex.txStatement.Close()
+	if err := ex.tx.Commit(); err != nil {
+		ErrorExit("can't commit transaction", err)
+	}
+	ex.tx = nil
+	ex.txStatement = nil
+	ex.txInserts = 0
 }
 
 // Close executor
 func (ex *InsertExecutor) Close() {
+	if ex.tx != nil {
+		ex.commit()
+	}
 	ex.insertStatement.Close()
 }
 
+// Supported compression of output file
+const (
+	OutputCompressionNone = ""
+	OutputCompressionGzip = "gzip"
+)
+
 // WriteToFileExecutor writes to file
 type WriteToFileExecutor struct {
 	encoder utils.BinaryEncoder
 	file    *os.File
-	sql     string
-	writer  *bufio.Writer
+	// compressor is nil if output isn't compressed
+	compressor io.WriteCloser
+	sql        string
+	writer     *bufio.Writer
 }
 
-// NewWriteToFileExecutor creates new object ready to write encoded sql to filePath
-func NewWriteToFileExecutor(filePath string, sql string, encoder utils.BinaryEncoder) *WriteToFileExecutor {
+// NewWriteToFileExecutor creates new object ready to write encoded sql to filePath compressed with compression
+func NewWriteToFileExecutor(filePath string, sql string, encoder utils.BinaryEncoder, compression string) *WriteToFileExecutor {
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
 		ErrorExit("can't get absolute path for output file", err)
@@ -114,8 +165,17 @@ func NewWriteToFileExecutor(filePath string, sql string, encoder utils.BinaryEnc
 	if err != nil {
 		ErrorExit("can't create output file", err)
 	}
-	writer := bufio.NewWriter(file)
-	return &WriteToFileExecutor{sql: sql, file: file, writer: writer, encoder: encoder}
+	executor := &WriteToFileExecutor{sql: sql, file: file, encoder: encoder}
+	switch compression {
+	case OutputCompressionNone:
+		executor.writer = bufio.NewWriter(file)
+	case OutputCompressionGzip:
+		executor.compressor = gzip.NewWriter(file)
+		executor.writer = bufio.NewWriter(executor.compressor)
+	default:
+		ErrorExit("can't create output file", fmt.Errorf("unsupported compression %s", compression))
+	}
+	return executor
 }
 
 // PLACEHOLDER char
@@ -151,6 +211,11 @@ func (ex *WriteToFileExecutor) Close() {
 	if err := ex.writer.Flush(); err != nil {
 		log.WithError(err).Errorln("Can't flush data in writer")
 	}
+	if ex.compressor != nil {
+		if err := ex.compressor.Close(); err != nil {
+			log.WithError(err).Errorln("Can't flush compressed data")
+		}
+	}
 	if err := ex.file.Sync(); err != nil {
 		log.WithError(err).Errorln("Can't sync file")
 	}
@@ -162,12 +227,15 @@ func (ex *WriteToFileExecutor) Close() {
 func main() {
 	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
 	clientID := flag.String("client_id", "", "Client ID should be name of file with private key")
-	connectionString := flag.String("connection_string", "", "Connection string for DB PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname})")
+	connectionString := flag.String("connection_string", "", "Connection string for DB PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname}). May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
 	sqlSelect := flag.String("select", "", "Query to fetch data for decryption")
 	sqlInsert := flag.String("insert", "", "Query for insert decrypted data with placeholders (pg: $n, mysql: ?)")
 	outputFile := flag.String("output_file", "decrypted.sql", "File for store inserts queries")
+	outputCompression := flag.String("output_compression", OutputCompressionNone, "Compression of output_file: <gzip>. Empty value writes plain SQL")
 	execute := flag.Bool("execute", false, "Execute inserts")
-	escapeFormat := flag.Bool("escape", false, "Escape bytea format")
+	executeConnectionString := flag.String("execute_connection_string", "", "Connection string for DB where inserts are executed with --execute to stream decrypted data into another database. connection_string is used if empty. May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
+	executeBatchSize := flag.Int("execute_batch_size", 1, "Number of inserts executed with --execute in one transaction")
+	escapeFormat := flag.Bool("escape", false, "Escape format of binary data: bytea escape format for PostgreSQL, escaped string literals for MySQL. Hex format is used by default")
 	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
 	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
 	dbTLSEnabled := flag.Bool("tls_database_enabled", false, "Enable TLS for DB")
@@ -176,6 +244,7 @@ func main() {
 	network.RegisterTLSBaseArgs(flag.CommandLine)
 	keyloader.RegisterKeyStoreStrategyParameters()
 	logging.SetLogLevel(logging.LogVerbose)
+	cmd.RegisterSecretParameters("connection_string", "execute_connection_string")
 
 	err := cmd.Parse(defaultConfigPath, serviceName)
 	if err != nil {
@@ -219,6 +288,11 @@ func main() {
 		log.Errorln("Output_file missing or execute flag")
 		os.Exit(1)
 	}
+	if *outputCompression != OutputCompressionNone && *outputCompression != OutputCompressionGzip {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorf("Unsupported output_compression %s", *outputCompression)
+		os.Exit(1)
+	}
 
 	var keystorage keystore.DecryptionKeyStore
 	if filesystemV2.IsKeyDirectory(*keysDir) {
@@ -227,9 +301,73 @@ func main() {
 		keystorage = openKeyStoreV1(*keysDir)
 	}
 
+	db := openDatabase(*connectionString, *useMysql, *dbTLSEnabled)
+	defer db.Close()
+	insertDB := db
+	if *executeConnectionString != "" && *execute {
+		insertDB = openDatabase(*executeConnectionString, *useMysql, *dbTLSEnabled)
+		defer insertDB.Close()
+	}
+	rows, err := db.Query(*sqlSelect)
+	if err != nil {
+		log.WithError(err).Errorf("Error with select query '%v'", *sqlSelect)
+		os.Exit(1)
+	}
+	defer rows.Close()
+
+	executors := list.New()
+	if *outputFile != "" {
+		var encoder utils.BinaryEncoder
+		switch {
+		case *useMysql && *escapeFormat:
+			encoder = &utils.MysqlEscapeEncoder{}
+		case *useMysql:
+			encoder = &utils.MysqlEncoder{}
+		case *escapeFormat:
+			encoder = &utils.EscapeEncoder{}
+		default:
+			encoder = &utils.HexEncoder{}
+		}
+		executors.PushFront(NewWriteToFileExecutor(*outputFile, *sqlInsert, encoder, *outputCompression))
+	}
+	if *execute {
+		executors.PushFront(NewInsertExecutor(*sqlInsert, insertDB, *executeBatchSize))
+	}
+	for e := executors.Front(); e != nil; e = e.Next() {
+		executor := e.Value.(Executor)
+		defer executor.Close()
+	}
+
+	for i := 0; rows.Next(); i++ {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			ErrorExit("Can't read data from row", err)
+		}
+		privateKeys, err := keystorage.GetServerDecryptionPrivateKeys([]byte(*clientID))
+		if err != nil {
+			log.WithError(err).Errorf("Can't get private key for row with number %v", i)
+			continue
+		}
+
+		decrypted, err := acrastruct.DecryptRotatedAcrastruct(data, privateKeys, nil)
+		utils.ZeroizePrivateKeys(privateKeys)
+		if err != nil {
+			log.WithError(err).Errorf("Can't decrypt acrastruct in row with number %v", i)
+			continue
+		}
+		for e := executors.Front(); e != nil; e = e.Next() {
+			executor := e.Value.(Executor)
+			executor.Execute(decrypted)
+		}
+	}
+}
+
+// openDatabase connects to database and exits on errors
+func openDatabase(connectionString string, useMysql, tlsEnabled bool) *sql.DB {
 	var dbTLSConfig *tls.Config
-	if *dbTLSEnabled {
-		host, err := network.GetDriverConnectionStringHost(*connectionString, *useMysql)
+	if tlsEnabled {
+		host, err := network.GetDriverConnectionStringHost(connectionString, useMysql)
 		if err != nil {
 			log.WithError(err).Errorln("Failed to get DB host from connection URL")
 			os.Exit(1)
@@ -244,14 +382,15 @@ func main() {
 	}
 
 	var db *sql.DB
-	if *useMysql {
-		config, err := mysql.ParseDSN(*connectionString)
+	if useMysql {
+		config, err := mysql.ParseDSN(connectionString)
 		if err != nil {
 			log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
 			os.Exit(1)
 		}
 		if dbTLSConfig != nil {
-			tlsConfigName := "custom"
+			// each connection registers own config because TLS config depends on host
+			tlsConfigName := "custom-" + config.Addr
 			if err := mysql.RegisterTLSConfig(tlsConfigName, dbTLSConfig); err != nil {
 				log.WithError(err).Errorln("Failed to register TLS config")
 				os.Exit(1)
@@ -265,7 +404,7 @@ func main() {
 		}
 		db = sql.OpenDB(connector)
 	} else {
-		config, err := pgx.ParseConfig(*connectionString)
+		config, err := pgx.ParseConfig(connectionString)
 		if err != nil {
 			log.WithError(err).Errorln("Can't parse config ")
 			os.Exit(1)
@@ -278,62 +417,11 @@ func main() {
 		db = stdlib.OpenDB(*config)
 	}
 
-	defer db.Close()
-	err = db.Ping()
-	if err != nil {
+	if err := db.Ping(); err != nil {
 		log.WithError(err).Errorln("Can't connect to db")
 		os.Exit(1)
 	}
-	rows, err := db.Query(*sqlSelect)
-	if err != nil {
-		log.WithError(err).Errorf("Error with select query '%v'", *sqlSelect)
-		os.Exit(1)
-	}
-	defer rows.Close()
-
-	executors := list.New()
-	if *outputFile != "" {
-		if *useMysql {
-			executors.PushFront(NewWriteToFileExecutor(*outputFile, *sqlInsert, &utils.MysqlEncoder{}))
-		} else {
-			if *escapeFormat {
-				executors.PushFront(NewWriteToFileExecutor(*outputFile, *sqlInsert, &utils.EscapeEncoder{}))
-			} else {
-				executors.PushFront(NewWriteToFileExecutor(*outputFile, *sqlInsert, &utils.HexEncoder{}))
-			}
-		}
-	}
-	if *execute {
-		executors.PushFront(NewInsertExecutor(*sqlInsert, db))
-	}
-	for e := executors.Front(); e != nil; e = e.Next() {
-		executor := e.Value.(Executor)
-		defer executor.Close()
-	}
-
-	for i := 0; rows.Next(); i++ {
-		var data []byte
-		err = rows.Scan(&data)
-		if err != nil {
-			ErrorExit("Can't read data from row", err)
-		}
-		privateKeys, err := keystorage.GetServerDecryptionPrivateKeys([]byte(*clientID))
-		if err != nil {
-			log.WithError(err).Errorf("Can't get private key for row with number %v", i)
-			continue
-		}
-
-		decrypted, err := acrastruct.DecryptRotatedAcrastruct(data, privateKeys, nil)
-		utils.ZeroizePrivateKeys(privateKeys)
-		if err != nil {
-			log.WithError(err).Errorf("Can't decrypt acrastruct in row with number %v", i)
-			continue
-		}
-		for e := executors.Front(); e != nil; e = e.Next() {
-			executor := e.Value.(Executor)
-			executor.Execute(decrypted)
-		}
-	}
+	return db
 }
 
 func openKeyStoreV1(keysDir string) keystore.DecryptionKeyStore {
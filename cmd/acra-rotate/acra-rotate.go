@@ -51,22 +51,28 @@ var (
 )
 
 func main() {
-	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
+	keysDir := cmd.RegisterKeysDirParameter()
 	fileMapConfig := flag.String("file_map_config", "", "Path to file with map of <ClientId>: <FilePaths> in json format {\"client_id1\": [\"filepath1\", \"filepath2\"], \"client_id2\": [\"filepath1\", \"filepath2\"]}")
 	sqlSelect := flag.String("sql_select", "", "Select query with ? as placeholders where last columns in result must be ClientId and AcraStruct. Other columns will be passed into insert/update query into placeholders")
 	sqlUpdate := flag.String("sql_update", "", "Insert/Update query with ? as placeholder where into first will be placed rotated AcraStruct")
-	connectionString := flag.String("db_connection_string", "", "Connection string for DB PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname})")
+	connectionString := flag.String("db_connection_string", "", "Connection string for DB PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname}). May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
 	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
 	_ = flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
 	dryRun := flag.Bool("dry-run", false, "perform rotation without saving rotated AcraStructs and keys")
 	dbTLSEnabled := flag.Bool("tls_database_enabled", false, "Enable TLS for DB")
+	reEncryptionConfigFile := flag.String("reencrypt_config_file", "", "Path to config with tables and columns which data should be decrypted with rotated keys and encrypted with current keys")
+	reEncryptionBatchSize := flag.Int("reencrypt_batch_size", DefaultReEncryptionBatchSize, "Number of rows re-encrypted and updated in one transaction")
+	reEncryptionRowsPerSecond := flag.Int("reencrypt_max_rows_per_second", 0, "Limit of processed rows per second to reduce load of database. 0 turns off limit")
+	reEncryptionProgressFile := flag.String("reencrypt_progress_file", "", "Path to file where the last processed primary keys are saved to resume re-encryption after interruption")
 
+	loggingParams := cmd.RegisterLoggingParameters()
 	logging.SetLogLevel(logging.LogVerbose)
 
 	network.RegisterTLSArgsForService(flag.CommandLine, true, "", network.DatabaseNameConstructorFunc())
 	network.RegisterTLSBaseArgs(flag.CommandLine)
 	cmd.RegisterRedisKeystoreParameters()
 	keyloader.RegisterKeyStoreStrategyParameters()
+	cmd.RegisterSecretParameters("db_connection_string")
 
 	err := cmd.Parse(DefaultConfigPath, ServiceName)
 	if err != nil {
@@ -74,6 +80,12 @@ func main() {
 			Errorln("Can't parse args")
 		os.Exit(1)
 	}
+	logFinalize, err := loggingParams.Apply(ServiceName, logging.LogVerbose)
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialise output writer for logging customization")
+		os.Exit(1)
+	}
+	defer logFinalize()
 
 	var keystorage keystore.ServerKeyStore
 	if filesystemV2.IsKeyDirectory(*keysDir) {
@@ -96,78 +108,98 @@ func main() {
 			log.Errorln("sql_select and sql_update must be set both")
 			os.Exit(1)
 		}
-
-		var dbTLSConfig *tls.Config
-		if *dbTLSEnabled {
-			host, err := network.GetDriverConnectionStringHost(*connectionString, *useMysql)
-			if err != nil {
-				log.WithError(err).Errorln("Failed to get DB host from connection URL")
-				os.Exit(1)
-			}
-
-			dbTLSConfig, err = network.NewTLSConfigByName(flag.CommandLine, "", host, network.DatabaseNameConstructorFunc())
-			if err != nil {
-				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
-					Errorln("Configuration error: can't create database TLS config")
-				os.Exit(1)
-			}
-		}
-
-		var db *sql.DB
-		var encoder utils.BinaryEncoder
+		db := openDatabase(*connectionString, *useMysql, *dbTLSEnabled)
+		var encoder utils.BinaryEncoder = &utils.MysqlEncoder{}
 		if *useMysql {
-			config, err := mysql.ParseDSN(*connectionString)
-			if err != nil {
-				log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
-				os.Exit(1)
-			}
-			if dbTLSConfig != nil {
-				tlsConfigName := "custom"
-				if err := mysql.RegisterTLSConfig(tlsConfigName, dbTLSConfig); err != nil {
-					log.WithError(err).Errorln("Failed to register TLS config")
-					os.Exit(1)
-				}
-				config.TLSConfig = tlsConfigName
-			}
-			connector, err := mysql.NewConnector(config)
-			if err != nil {
-				log.WithError(err).Errorln("Can't initialize MySQL connector")
-				os.Exit(1)
-			}
-			db = sql.OpenDB(connector)
 			encoder = &utils.HexEncoder{}
-		} else {
-			config, err := pgx.ParseConfig(*connectionString)
-			if err != nil {
-				log.WithError(err).Errorln("Can't parse config")
-				os.Exit(1)
-			}
-
-			if dbTLSConfig != nil {
-				config.TLSConfig = dbTLSConfig
-			}
+		}
+		log.WithFields(log.Fields{"select_query": *sqlSelect, "update_query": *sqlUpdate}).Infoln("Rotate data in database")
+		if !rotateDb(*sqlSelect, *sqlUpdate, db, keystorage, encoder, *dryRun) {
+			os.Exit(1)
+		}
+	}
+	if *reEncryptionConfigFile != "" {
+		reEncryptionConfig, err := LoadReEncryptionConfig(*reEncryptionConfigFile)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load re-encryption config")
+			os.Exit(1)
+		}
+		if *reEncryptionBatchSize <= 0 {
+			log.WithError(ErrInvalidBatchSize).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid reencrypt_batch_size")
+			os.Exit(1)
+		}
+		db := openDatabase(*connectionString, *useMysql, *dbTLSEnabled)
+		log.WithField("config", *reEncryptionConfigFile).Infoln("Re-encrypt data in database")
+		if !reEncryptDb(reEncryptionConfig, db, keystorage, *useMysql, *reEncryptionBatchSize, *reEncryptionRowsPerSecond, *reEncryptionProgressFile, *dryRun) {
+			os.Exit(1)
+		}
+	}
+}
 
-			db = stdlib.OpenDB(*config)
-			encoder = &utils.MysqlEncoder{}
+// openDatabase connects to database and exits on errors
+func openDatabase(connectionString string, useMysql, tlsEnabled bool) *sql.DB {
+	var dbTLSConfig *tls.Config
+	if tlsEnabled {
+		host, err := network.GetDriverConnectionStringHost(connectionString, useMysql)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to get DB host from connection URL")
+			os.Exit(1)
 		}
 
+		dbTLSConfig, err = network.NewTLSConfigByName(flag.CommandLine, "", host, network.DatabaseNameConstructorFunc())
 		if err != nil {
-			log.WithError(err).Errorln("Can't connect to db")
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't create database TLS config")
 			os.Exit(1)
 		}
-		if db == nil {
-			log.Errorln("Can't initialize db driver")
+	}
+
+	var db *sql.DB
+	if useMysql {
+		config, err := mysql.ParseDSN(connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
 			os.Exit(1)
 		}
-		if err := db.Ping(); err != nil {
-			log.WithError(err).Errorln("Error on pinging database", *connectionString)
+		if dbTLSConfig != nil {
+			tlsConfigName := "custom"
+			if err := mysql.RegisterTLSConfig(tlsConfigName, dbTLSConfig); err != nil {
+				log.WithError(err).Errorln("Failed to register TLS config")
+				os.Exit(1)
+			}
+			config.TLSConfig = tlsConfigName
+		}
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			log.WithError(err).Errorln("Can't initialize MySQL connector")
 			os.Exit(1)
 		}
-		log.WithFields(log.Fields{"select_query": *sqlSelect, "update_query": *sqlUpdate}).Infoln("Rotate data in database")
-		if !rotateDb(*sqlSelect, *sqlUpdate, db, keystorage, encoder, *dryRun) {
+		db = sql.OpenDB(connector)
+	} else {
+		config, err := pgx.ParseConfig(connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse config")
 			os.Exit(1)
 		}
+
+		if dbTLSConfig != nil {
+			config.TLSConfig = dbTLSConfig
+		}
+
+		db = stdlib.OpenDB(*config)
+	}
+
+	if db == nil {
+		log.Errorln("Can't initialize db driver")
+		os.Exit(1)
+	}
+	if err := db.Ping(); err != nil {
+		log.WithError(err).Errorln("Error on pinging database", connectionString)
+		os.Exit(1)
 	}
+	return db
 }
 
 func openKeyStoreV1(dirPath string) keystore.ServerKeyStore {
@@ -187,7 +219,7 @@ func openKeyStoreV1(dirPath string) keystore.ServerKeyStore {
 				Errorln("Can't get Redis options")
 			os.Exit(1)
 		}
-		keyStorage, err := filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, redisOptions.TLSConfig)
+		keyStorage, err := filesystem.NewRedisStorage(redisOptions)
 		if err != nil {
 			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
 				Errorln("Can't initialize Redis client")
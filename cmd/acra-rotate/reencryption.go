@@ -0,0 +1,479 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultReEncryptionBatchSize is number of rows fetched and updated in one transaction by default
+const DefaultReEncryptionBatchSize = 100
+
+// Errors returned while loading configuration of re-encryption
+var (
+	ErrEmptyReEncryptionTables = errors.New("re-encryption config should contain at least one table")
+	ErrInvalidIdentifier       = errors.New("invalid table or column name")
+	ErrEmptyColumns            = errors.New("table should have at least one column")
+	ErrEmptyPrimaryKey         = errors.New("table should have primary_key")
+	ErrInvalidClientIDSource   = errors.New("table should have one of client_id or client_id_column")
+	ErrDuplicateTableName      = errors.New("table defined several times")
+	ErrInvalidBatchSize        = errors.New("batch size should be greater than 0")
+)
+
+// identifierRegexp matches table and column names allowed in config, optionally with schema name
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// ReEncryptionTable describes table which encrypted columns are re-encrypted with current keys
+type ReEncryptionTable struct {
+	Table string `yaml:"table"`
+	// PrimaryKey is unique sortable column used to iterate over table in batches and to update rows
+	PrimaryKey string   `yaml:"primary_key"`
+	Columns    []string `yaml:"columns"`
+	// ClientID used to decrypt and encrypt all rows or ClientIDColumn with clientID of each row
+	ClientID       string `yaml:"client_id"`
+	ClientIDColumn string `yaml:"client_id_column"`
+	// CryptoEnvelope of re-encrypted data, AcraBlock by default
+	CryptoEnvelope config.CryptoEnvelopeType `yaml:"crypto_envelope"`
+}
+
+// ReEncryptionConfig describes tables with data which should be re-encrypted
+type ReEncryptionConfig struct {
+	Tables []ReEncryptionTable `yaml:"tables"`
+}
+
+// LoadReEncryptionConfig reads and validates configuration from file
+func LoadReEncryptionConfig(path string) (*ReEncryptionConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReEncryptionConfig(data)
+}
+
+// ParseReEncryptionConfig parses and validates configuration
+func ParseReEncryptionConfig(data []byte) (*ReEncryptionConfig, error) {
+	reEncryptionConfig := &ReEncryptionConfig{}
+	if err := yaml.UnmarshalStrict(data, reEncryptionConfig); err != nil {
+		return nil, err
+	}
+	if len(reEncryptionConfig.Tables) == 0 {
+		return nil, ErrEmptyReEncryptionTables
+	}
+	tables := make(map[string]struct{}, len(reEncryptionConfig.Tables))
+	for i := range reEncryptionConfig.Tables {
+		table := &reEncryptionConfig.Tables[i]
+		if !identifierRegexp.MatchString(table.Table) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidIdentifier, table.Table)
+		}
+		if _, ok := tables[table.Table]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateTableName, table.Table)
+		}
+		tables[table.Table] = struct{}{}
+		if table.PrimaryKey == "" {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyPrimaryKey, table.Table)
+		}
+		if len(table.Columns) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyColumns, table.Table)
+		}
+		if (table.ClientID == "") == (table.ClientIDColumn == "") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientIDSource, table.Table)
+		}
+		columns := append([]string{table.PrimaryKey}, table.Columns...)
+		if table.ClientIDColumn != "" {
+			columns = append(columns, table.ClientIDColumn)
+		}
+		for _, column := range columns {
+			if !identifierRegexp.MatchString(column) {
+				return nil, fmt.Errorf("%w: %s.%s", ErrInvalidIdentifier, table.Table, column)
+			}
+		}
+		if table.CryptoEnvelope == "" {
+			table.CryptoEnvelope = config.CryptoEnvelopeTypeAcraBlock
+		}
+		if err := config.ValidateCryptoEnvelopeType(table.CryptoEnvelope); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, table.Table)
+		}
+	}
+	return reEncryptionConfig, nil
+}
+
+// placeholder returns placeholder of query parameter with 1-based index
+func placeholder(index int, useMySQL bool) string {
+	if useMySQL {
+		return "?"
+	}
+	return "$" + strconv.Itoa(index)
+}
+
+// BuildSelectBatchQuery returns query which fetches next batch of rows ordered by primary key. Rows contain primary key,
+// encrypted columns and optional client_id column. Query has placeholder for the last processed primary key if fromStart is false
+func BuildSelectBatchQuery(table ReEncryptionTable, fromStart bool, batchSize int, useMySQL bool) string {
+	columns := append([]string{table.PrimaryKey}, table.Columns...)
+	if table.ClientIDColumn != "" {
+		columns = append(columns, table.ClientIDColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), table.Table)
+	if !fromStart {
+		query += fmt.Sprintf(" WHERE %s > %s", table.PrimaryKey, placeholder(1, useMySQL))
+	}
+	return query + fmt.Sprintf(" ORDER BY %s LIMIT %d", table.PrimaryKey, batchSize)
+}
+
+// BuildUpdateQuery returns query which updates encrypted columns of one row by primary key
+func BuildUpdateQuery(table ReEncryptionTable, useMySQL bool) string {
+	assignments := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		assignments[i] = fmt.Sprintf("%s = %s", column, placeholder(i+1, useMySQL))
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s", table.Table, strings.Join(assignments, ", "),
+		table.PrimaryKey, placeholder(len(table.Columns)+1, useMySQL))
+}
+
+// ReEncryptionProgress stores the last processed primary key of each table to resume re-encryption after interruption
+type ReEncryptionProgress struct {
+	path   string
+	Tables map[string]string `json:"tables"`
+}
+
+// LoadReEncryptionProgress reads progress from file. Empty progress is returned if path is empty or file doesn't exist
+func LoadReEncryptionProgress(path string) (*ReEncryptionProgress, error) {
+	progress := &ReEncryptionProgress{path: path, Tables: make(map[string]string)}
+	if path == "" {
+		return progress, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, err
+	}
+	if progress.Tables == nil {
+		progress.Tables = make(map[string]string)
+	}
+	return progress, nil
+}
+
+// Update saves the last processed primary key of the table and writes progress into file if it's configured
+func (progress *ReEncryptionProgress) Update(table, lastPrimaryKey string) error {
+	progress.Tables[table] = lastPrimaryKey
+	if progress.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	// write into temporary file and rename to not lose progress if process is killed during writing
+	tmpPath := filepath.Join(filepath.Dir(progress.path), "."+filepath.Base(progress.path)+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, progress.path)
+}
+
+// rowsThrottler limits average rate of processed rows
+type rowsThrottler struct {
+	rowsPerSecond int
+	started       time.Time
+	rows          int
+	sleep         func(time.Duration)
+}
+
+func newRowsThrottler(rowsPerSecond int) *rowsThrottler {
+	return &rowsThrottler{rowsPerSecond: rowsPerSecond, started: time.Now(), sleep: time.Sleep}
+}
+
+// Wait accounts processed rows and sleeps while rate is above the limit. Zero limit turns off throttling
+func (throttler *rowsThrottler) Wait(rows int) {
+	if throttler.rowsPerSecond <= 0 {
+		return
+	}
+	throttler.rows += rows
+	expected := time.Duration(float64(throttler.rows) / float64(throttler.rowsPerSecond) * float64(time.Second))
+	if elapsed := time.Since(throttler.started); expected > elapsed {
+		throttler.sleep(expected - elapsed)
+	}
+}
+
+// TableReEncryptionReport contains statistics of re-encryption of one table
+type TableReEncryptionReport struct {
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+	// UpdatedRows is number of rows with at least one re-encrypted value, in dry-run mode rows are not updated
+	UpdatedRows int `json:"updated_rows"`
+	ReEncrypted int `json:"reencrypted_values"`
+	// Skipped is number of NULL values and values which are not AcraStructs or AcraBlocks
+	Skipped        int    `json:"skipped_values"`
+	LastPrimaryKey string `json:"last_primary_key,omitempty"`
+}
+
+// dataReEncryptor decrypts AcraStructs and AcraBlocks stored in database with all rotated keys and encrypts data with current keys
+type dataReEncryptor struct {
+	db        *sql.DB
+	keystore  keystore.DataEncryptorKeyStore
+	useMySQL  bool
+	batchSize int
+	dryRun    bool
+	throttler *rowsThrottler
+	progress  *ReEncryptionProgress
+}
+
+// reEncryptValue returns data encrypted with current key of clientID or false if value isn't encrypted
+func (reEncryptor *dataReEncryptor) reEncryptValue(clientID, value []byte, envelope config.CryptoEnvelopeType) ([]byte, bool, error) {
+	internal, envelopeID, err := crypto.DeserializeEncryptedData(value)
+	if err != nil {
+		return nil, false, nil
+	}
+	handler, err := crypto.GetHandlerByEnvelopeID(envelopeID)
+	if err != nil {
+		return nil, false, err
+	}
+	accessContext := base.NewAccessContext(base.WithClientID(clientID))
+	dataContext := base.NewDataProcessorContext(reEncryptor.keystore)
+	dataContext.Context = base.SetAccessContextToContext(context.Background(), accessContext)
+	decrypted, err := handler.Decrypt(internal, dataContext)
+	if err != nil {
+		return nil, false, err
+	}
+	defer utils.ZeroizeBytes(decrypted)
+	targetHandler, err := crypto.GetHandlerByName(string(envelope))
+	if err != nil {
+		return nil, false, err
+	}
+	encrypted, err := targetHandler.EncryptWithClientID(clientID, decrypted, &encryptor.DataEncryptorContext{Keystore: reEncryptor.keystore})
+	if err != nil {
+		return nil, false, err
+	}
+	serialized, err := crypto.SerializeEncryptedData(encrypted, targetHandler.ID())
+	if err != nil {
+		return nil, false, err
+	}
+	return serialized, true, nil
+}
+
+// valueToBytes converts value scanned from database into bytes, returns false for NULL
+func valueToBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	case nil:
+		return nil, false
+	default:
+		return []byte(fmt.Sprint(v)), true
+	}
+}
+
+// reEncryptTable processes table in batches ordered by primary key starting after the last processed row
+func (reEncryptor *dataReEncryptor) reEncryptTable(table ReEncryptionTable) (*TableReEncryptionReport, error) {
+	report := &TableReEncryptionReport{Table: table.Table}
+	logger := log.WithField("table", table.Table)
+	updateQuery := BuildUpdateQuery(table, reEncryptor.useMySQL)
+	var lastPrimaryKey interface{}
+	if last, ok := reEncryptor.progress.Tables[table.Table]; ok {
+		logger.WithField("primary_key", last).Infoln("Resume re-encryption after the last processed row")
+		lastPrimaryKey = last
+		report.LastPrimaryKey = last
+	}
+	for {
+		query := BuildSelectBatchQuery(table, lastPrimaryKey == nil, reEncryptor.batchSize, reEncryptor.useMySQL)
+		var args []interface{}
+		if lastPrimaryKey != nil {
+			args = append(args, lastPrimaryKey)
+		}
+		batch, err := reEncryptor.fetchBatch(query, args)
+		if err != nil {
+			return report, fmt.Errorf("can't fetch rows: %w", err)
+		}
+		if len(batch) == 0 {
+			return report, nil
+		}
+		var updates [][]interface{}
+		for _, row := range batch {
+			update, err := reEncryptor.reEncryptRow(table, row, report)
+			if err != nil {
+				primaryKey, _ := valueToBytes(row[0])
+				return report, fmt.Errorf("can't re-encrypt row with %s=%s: %w", table.PrimaryKey, primaryKey, err)
+			}
+			if update != nil {
+				updates = append(updates, update)
+			}
+		}
+		report.Rows += len(batch)
+		report.UpdatedRows += len(updates)
+		lastPrimaryKey = batch[len(batch)-1][0]
+		lastPrimaryKeyValue, _ := valueToBytes(lastPrimaryKey)
+		report.LastPrimaryKey = string(lastPrimaryKeyValue)
+		if !reEncryptor.dryRun {
+			if err := reEncryptor.updateBatch(updateQuery, updates); err != nil {
+				return report, fmt.Errorf("can't update rows: %w", err)
+			}
+			if err := reEncryptor.progress.Update(table.Table, report.LastPrimaryKey); err != nil {
+				return report, fmt.Errorf("can't save progress: %w", err)
+			}
+		}
+		logger.WithFields(log.Fields{"rows": report.Rows, "primary_key": report.LastPrimaryKey}).Infoln("Processed batch")
+		reEncryptor.throttler.Wait(len(batch))
+		if len(batch) < reEncryptor.batchSize {
+			return report, nil
+		}
+	}
+}
+
+// fetchBatch reads all rows of the batch, so the connection is free for updates
+func (reEncryptor *dataReEncryptor) fetchBatch(query string, args []interface{}) ([][]interface{}, error) {
+	rows, err := reEncryptor.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var batch [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		rowPointers := make([]interface{}, len(columns))
+		for i := range row {
+			rowPointers[i] = &row[i]
+		}
+		if err := rows.Scan(rowPointers...); err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// reEncryptRow returns arguments of update query or nil if row has no encrypted values
+func (reEncryptor *dataReEncryptor) reEncryptRow(table ReEncryptionTable, row []interface{}, report *TableReEncryptionReport) ([]interface{}, error) {
+	clientID := []byte(table.ClientID)
+	if table.ClientIDColumn != "" {
+		value, ok := valueToBytes(row[len(row)-1])
+		if !ok {
+			return nil, fmt.Errorf("empty %s", table.ClientIDColumn)
+		}
+		clientID = value
+	}
+	updateArgs := make([]interface{}, 0, len(table.Columns)+1)
+	changed := false
+	for i, column := range table.Columns {
+		value, ok := valueToBytes(row[i+1])
+		if !ok {
+			report.Skipped++
+			updateArgs = append(updateArgs, nil)
+			continue
+		}
+		reEncrypted, encrypted, err := reEncryptor.reEncryptValue(clientID, value, table.CryptoEnvelope)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column, err)
+		}
+		if !encrypted {
+			report.Skipped++
+			updateArgs = append(updateArgs, value)
+			continue
+		}
+		report.ReEncrypted++
+		changed = true
+		updateArgs = append(updateArgs, reEncrypted)
+	}
+	if !changed {
+		return nil, nil
+	}
+	return append(updateArgs, row[0]), nil
+}
+
+// updateBatch updates all rows of the batch in one transaction
+func (reEncryptor *dataReEncryptor) updateBatch(query string, updates [][]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	tx, err := reEncryptor.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, args := range updates {
+		if _, err := tx.Exec(query, args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.WithError(rollbackErr).Errorln("Can't rollback transaction")
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// reEncryptDb re-encrypts data of all configured tables and prints report in JSON
+func reEncryptDb(reEncryptionConfig *ReEncryptionConfig, db *sql.DB, keystore keystore.DataEncryptorKeyStore, useMySQL bool, batchSize, rowsPerSecond int, progressFile string, dryRun bool) bool {
+	progress, err := LoadReEncryptionProgress(progressFile)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load progress of re-encryption")
+		return false
+	}
+	reEncryptor := &dataReEncryptor{
+		db:        db,
+		keystore:  keystore,
+		useMySQL:  useMySQL,
+		batchSize: batchSize,
+		dryRun:    dryRun,
+		throttler: newRowsThrottler(rowsPerSecond),
+		progress:  progress,
+	}
+	success := true
+	reports := make([]*TableReEncryptionReport, 0, len(reEncryptionConfig.Tables))
+	for _, table := range reEncryptionConfig.Tables {
+		report, err := reEncryptor.reEncryptTable(table)
+		reports = append(reports, report)
+		if err != nil {
+			log.WithError(err).WithField("table", table.Table).Errorln("Can't re-encrypt table")
+			success = false
+			break
+		}
+	}
+	jsonOutput, err := json.Marshal(reports)
+	if err != nil {
+		log.WithError(err).Errorln("Can't encode to json")
+		return false
+	}
+	fmt.Println(string(jsonOutput))
+	return success
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+const testReEncryptionConfig = `
+tables:
+  - table: users
+    primary_key: id
+    columns: [email, phone]
+    client_id: user0
+  - table: public.orders
+    primary_key: id
+    columns: [address]
+    client_id_column: client
+    crypto_envelope: acrastruct
+`
+
+func TestParseReEncryptionConfig(t *testing.T) {
+	reEncryptionConfig, err := ParseReEncryptionConfig([]byte(testReEncryptionConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reEncryptionConfig.Tables) != 2 {
+		t.Fatalf("Expected 2 tables, took %d", len(reEncryptionConfig.Tables))
+	}
+	if reEncryptionConfig.Tables[0].CryptoEnvelope != config.CryptoEnvelopeTypeAcraBlock {
+		t.Fatalf("Expected AcraBlock by default, took %s", reEncryptionConfig.Tables[0].CryptoEnvelope)
+	}
+	if reEncryptionConfig.Tables[1].CryptoEnvelope != config.CryptoEnvelopeTypeAcraStruct {
+		t.Fatalf("Expected AcraStruct, took %s", reEncryptionConfig.Tables[1].CryptoEnvelope)
+	}
+
+	testcases := []struct {
+		name   string
+		config string
+		err    error
+	}{
+		{"empty", `tables: []`, ErrEmptyReEncryptionTables},
+		{"invalid table", `{tables: [{table: "users; drop", primary_key: id, columns: [a], client_id: c}]}`, ErrInvalidIdentifier},
+		{"invalid column", `{tables: [{table: users, primary_key: id, columns: ["a b"], client_id: c}]}`, ErrInvalidIdentifier},
+		{"without primary key", `{tables: [{table: users, columns: [a], client_id: c}]}`, ErrEmptyPrimaryKey},
+		{"without columns", `{tables: [{table: users, primary_key: id, client_id: c}]}`, ErrEmptyColumns},
+		{"without client id", `{tables: [{table: users, primary_key: id, columns: [a]}]}`, ErrInvalidClientIDSource},
+		{"both client ids", `{tables: [{table: users, primary_key: id, columns: [a], client_id: c, client_id_column: c}]}`, ErrInvalidClientIDSource},
+		{"duplicate table", `{tables: [{table: users, primary_key: id, columns: [a], client_id: c}, {table: users, primary_key: id, columns: [b], client_id: c}]}`, ErrDuplicateTableName},
+		{"invalid envelope", `{tables: [{table: users, primary_key: id, columns: [a], client_id: c, crypto_envelope: unknown}]}`, config.ErrInvalidCryptoEnvelopeType},
+	}
+	for _, tcase := range testcases {
+		if _, err := ParseReEncryptionConfig([]byte(tcase.config)); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%s] Expected %s, took %v", tcase.name, tcase.err, err)
+		}
+	}
+}
+
+func TestBuildReEncryptionQueries(t *testing.T) {
+	table := ReEncryptionTable{Table: "users", PrimaryKey: "id", Columns: []string{"email", "phone"}, ClientIDColumn: "client"}
+	testcases := []struct {
+		fromStart bool
+		useMySQL  bool
+		query     string
+	}{
+		{true, false, "SELECT id, email, phone, client FROM users ORDER BY id LIMIT 10"},
+		{false, false, "SELECT id, email, phone, client FROM users WHERE id > $1 ORDER BY id LIMIT 10"},
+		{false, true, "SELECT id, email, phone, client FROM users WHERE id > ? ORDER BY id LIMIT 10"},
+	}
+	for _, tcase := range testcases {
+		if query := BuildSelectBatchQuery(table, tcase.fromStart, 10, tcase.useMySQL); query != tcase.query {
+			t.Fatalf("Expected %s, took %s", tcase.query, query)
+		}
+	}
+	if query := BuildUpdateQuery(table, false); query != "UPDATE users SET email = $1, phone = $2 WHERE id = $3" {
+		t.Fatalf("Unexpected PostgreSQL update query: %s", query)
+	}
+	if query := BuildUpdateQuery(table, true); query != "UPDATE users SET email = ?, phone = ? WHERE id = ?" {
+		t.Fatalf("Unexpected MySQL update query: %s", query)
+	}
+}
+
+func TestReEncryptionProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.json")
+	progress, err := LoadReEncryptionProgress(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress.Tables) != 0 {
+		t.Fatal("Expected empty progress")
+	}
+	if err := progress.Update("users", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := progress.Update("users", "20"); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReEncryptionProgress(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Tables["users"] != "20" {
+		t.Fatalf("Expected saved primary key 20, took %s", loaded.Tables["users"])
+	}
+
+	// progress without file is kept only in memory
+	inMemory, err := LoadReEncryptionProgress("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inMemory.Update("users", "1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRowsThrottler(t *testing.T) {
+	var slept time.Duration
+	throttler := newRowsThrottler(10)
+	throttler.sleep = func(duration time.Duration) { slept += duration }
+	throttler.Wait(20)
+	// 20 rows with limit 10 rows per second should take about 2 seconds
+	if slept < time.Second || slept > 2*time.Second {
+		t.Fatalf("Unexpected throttling time %s", slept)
+	}
+
+	slept = 0
+	disabled := newRowsThrottler(0)
+	disabled.sleep = func(duration time.Duration) { slept += duration }
+	disabled.Wait(1000)
+	if slept != 0 {
+		t.Fatal("Throttler without limit shouldn't sleep")
+	}
+}
@@ -48,17 +48,24 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/acme/autocert"
 
+	"github.com/cossacklabs/acra/acrablock"
+	"github.com/cossacklabs/acra/anonymization"
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/cmd/acra-server/common"
 	"github.com/cossacklabs/acra/crypto"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/decryptor/mysql"
 	"github.com/cossacklabs/acra/decryptor/postgresql"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/encryptor/config_loader"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/keystore/keyloader"
+	"github.com/cossacklabs/acra/keystore/keyloader/env_loader"
+	"github.com/cossacklabs/acra/keystore/replication"
+	"github.com/cossacklabs/acra/keystore/rotation"
 	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
 	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
 	filesystemBackendV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend"
@@ -69,7 +76,9 @@ import (
 	pseudonymizationCommon "github.com/cossacklabs/acra/pseudonymization/common"
 	"github.com/cossacklabs/acra/pseudonymization/storage"
 	"github.com/cossacklabs/acra/sqlparser"
+	"github.com/cossacklabs/acra/transform"
 	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/utils/redisclient"
 )
 
 var restartSignalsChannel chan os.Signal
@@ -82,6 +91,11 @@ const (
 	ServiceName                  = "acra-server"
 	SignalToStartForkedProcess   = "forked process is allowed to continue"
 
+	// DefaultDecryptionLatencyColumnCost estimated decryption time of one column value
+	DefaultDecryptionLatencyColumnCost = 50 * time.Microsecond
+	// DefaultDBHeartbeatTimeout time of waiting for response to heartbeat probe from the database
+	DefaultDBHeartbeatTimeout = 5 * time.Second
+
 	// We use this values as a file descriptors pointers on SIGHUP signal processing.
 	// We definitely know (because we implement this), that new forked process starts
 	// with three descriptors in mind - stdin (0), stdout (1), stderr(2). And then we
@@ -106,6 +120,33 @@ var ErrPipeWrite = errors.New("can't write exit signal to pipe")
 // ErrPipeReadWrongSignal occurs if we read unexpected signal from pipe between parent and forked processes
 var ErrPipeReadWrongSignal = errors.New("wrong signal has been read from pipe")
 
+// ErrEncryptorConfigNotConfigured occurs if --migrate_encryptor_config, --encryptor_config_reload_on_sighup or --encryptor_config_poll_interval used without encryptor config
+var ErrEncryptorConfigNotConfigured = errors.New("encryptor config is not configured")
+
+// ErrDataAccessAuditWithoutAuditLog occurs if --audit_log_data_access_enable used without --audit_log_enable
+var ErrDataAccessAuditWithoutAuditLog = errors.New("audit log of data access requires enabled audit log")
+
+// ErrInvalidQueryLatencyThreshold occurs if --query_latency_threshold is negative
+var ErrInvalidQueryLatencyThreshold = errors.New("invalid threshold of query latency")
+
+// ErrInvalidColumnMetricsLimit occurs if --column_metrics_max_columns isn't positive
+var ErrInvalidColumnMetricsLimit = errors.New("invalid limit of columns in per-column metrics")
+
+// ErrColumnMetricsWithoutPrometheus occurs if --column_metrics_enable used without exporter of prometheus metrics
+var ErrColumnMetricsWithoutPrometheus = errors.New("per-column metrics require exporter of prometheus metrics")
+
+// ErrDifferentialPrivacyNotSupported occurs if --differential_privacy_config_file used with --mysql_enable
+var ErrDifferentialPrivacyNotSupported = errors.New("differential privacy is supported only for PostgreSQL")
+
+// ErrDiagnosticsWithoutHTTPAPI occurs if --http_api_diagnostics_enable used without --http_api_enable
+var ErrDiagnosticsWithoutHTTPAPI = errors.New("diagnostics endpoints require enabled HTTP API")
+
+// ErrDiagnosticsWithoutAuth occurs if --http_api_diagnostics_enable used without basic auth credentials and TLS
+var ErrDiagnosticsWithoutAuth = errors.New("diagnostics endpoints require basic auth credentials or TLS transport of HTTP API")
+
+// ErrStaticClientIDRequired occurs if --tls_client_id_from_cert=false used without --client_id
+var ErrStaticClientIDRequired = errors.New("static clientID is required without clientID from TLS certificate")
+
 func main() {
 	err := realMain()
 	if err != nil {
@@ -114,19 +155,33 @@ func main() {
 }
 
 func realMain() error {
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
 	dbHost := flag.String("db_host", "", "Host to db")
 	dbPort := flag.Int("db_port", 5432, "Port to db")
+	dbFailoverHosts := flag.String("db_failover_hosts", "", "Comma separated list of secondary databases <host>[:<port>] connected in order when --db_host is unavailable, --db_port used if port is omitted. With TLS certificates of databases should be valid for --tls_database_sni or --db_host")
+	dbHealthCheckInterval := flag.Duration("db_health_check_interval", 0, "Interval of TCP health checks of --db_host and --db_failover_hosts (e.g. 10s). Unavailable databases are skipped by new connections and checked again with exponential backoff. 0 - checked only on connection")
 
 	prometheusAddress := flag.String("incoming_connection_prometheus_metrics_string", "", "URL (tcp://host:port) which will be used to expose Prometheus metrics (<URL>/metrics address to pull metrics)")
+	enableColumnMetrics := flag.Bool("column_metrics_enable", false, "Export Prometheus metrics of decryptions, encryptions and masking fallbacks labeled by table and column. Requires --incoming_connection_prometheus_metrics_string")
+	columnMetricsMaxColumns := flag.Int("column_metrics_max_columns", base.DefaultColumnMetricsLimit, fmt.Sprintf("Maximum number of distinct table/column pairs in per-column metrics, next columns are reported with '%s' labels", base.LabelValueOtherColumns))
+	columnTransformsConfig := flag.String("column_transforms_config_file", "", "Path to config of transforms applied to decrypted values before sending them to the client, with Go plugins which register transforms")
+	anonymizationConfig := flag.String("anonymization_config_file", "", "Path to config of columns which values are replaced with generalized or suppressed values for analytics clientIDs instead of decrypted ones")
+	differentialPrivacyConfig := flag.String("differential_privacy_config_file", "", "Path to config of protected tables, clientIDs and privacy budgets used to add Laplace noise to results of aggregate queries (PostgreSQL only)")
 
 	host := flag.String("incoming_connection_host", cmd.DefaultAcraServerHost, "Host for AcraServer")
 	port := flag.Int("incoming_connection_port", cmd.DefaultAcraServerPort, "Port for AcraServer")
 	apiPort := flag.Int("incoming_connection_api_port", cmd.DefaultAcraServerAPIPort, "Port for AcraServer for HTTP API")
 
-	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+	keysDir := cmd.RegisterKeysDirParameter()
 	cacheKeystoreOnStart := flag.Bool("keystore_cache_on_start_enable", true, "Load all keys to cache on start")
 	keysCacheSize := flag.Int("keystore_cache_size", keystore.DefaultCacheSize, fmt.Sprintf("Maximum number of keys stored in in-memory LRU cache in encrypted form. 0 - no limits, -1 - turn off cache. Default is %d", keystore.DefaultCacheSize))
+	keysCacheTTL := flag.Duration("keystore_cache_ttl", 0, "Time after which keys cached by keystore v1 are removed from cache and read from keystore again (e.g. 10m). 0 - cached keys don't expire")
+	keyUsageTrackingInterval := flag.Duration("keystore_usage_tracking_interval", 0, "Interval of saving usage statistics of storage keys (encryptions, decryptions, last access) to keystore, listed by \"acra-keys list --verbose\" (e.g. 1m). Supported only by keystore v1. 0 - disabled")
+	keysRotationSchedule := flag.String("keys_rotation_schedule", "", "Cron-like schedule of automatic rotation of storage keys of all clientIDs: 'minute hour day-of-month month day-of-week', @daily, @weekly, @monthly or '@every <duration>'. Previous keys are kept for decryption. Enable only on one AcraServer of shared keystore. Empty value disables rotation")
+	keystoreMigrationMode := flag.String("keystore_migration_mode", "", fmt.Sprintf("Online migration from keystore v1 in --keys_dir to keystore v2 in --keystore_migration_v2_keys_dir: <%s>. dual_read reads keys from keystore v2 and falls back to keystore v1, lazy also imports keys read from keystore v1 into keystore v2. Master key of keystore v2 is read from %s with env_master_key strategy. Empty value disables migration", strings.Join(keystoreV2.SupportedMigrationModes, "|"), MigrationMasterKeyVarName))
+	keystoreMigrationV2KeysDir := flag.String("keystore_migration_v2_keys_dir", "", "Folder of keystore v2 used by --keystore_migration_mode")
+	keysRotationKinds := flag.String("keys_rotation_kinds", strings.Join(rotation.SupportedKeyKinds, ","), fmt.Sprintf("Comma-separated kinds of keys rotated by --keys_rotation_schedule: <%s>", strings.Join(rotation.SupportedKeyKinds, "|")))
+	keystoreReplicationKeysDir := flag.String("keystore_replication_keys_dir", "", "Folder of standby keystore where new and rotated keys from --keys_dir are copied with integrity verification. Standby keystore is stored in Redis if --keystore_replication_redis_host_port is set. Supported only by keystore v1. Empty value disables replication")
+	keystoreReplicationInterval := flag.Duration("keystore_replication_interval", time.Minute, "Interval of replication of keys to --keystore_replication_keys_dir (e.g. 1m)")
 
 	_ = flag.Bool("pgsql_hex_bytea", false, "Hex format for Postgresql bytea data (deprecated, ignored)")
 	flag.Bool("pgsql_escape_bytea", false, "Escape format for Postgresql bytea data (deprecated, ignored)")
@@ -136,22 +191,70 @@ func realMain() error {
 
 	debugServer := flag.Bool("ds", false, "Turn on HTTP debug server")
 	closeConnectionTimeout := flag.Int("incoming_connection_close_timeout", DefaultAcraServerWaitTimeout, "Time that AcraServer will wait (in seconds) on restart before closing all connections")
+	proxyProtocolEnable := flag.Bool("proxy_protocol_enable", false, "Require HAProxy PROXY protocol header (v1 or v2) on database connections from L4 load balancer and use client's address from it in logs, AcraCensor and audit log. Requires --proxy_protocol_trusted_networks")
+	proxyProtocolTrustedNetworks := flag.String("proxy_protocol_trusted_networks", "", "Comma separated CIDRs of load balancers allowed to send PROXY protocol header, connections from other addresses are rejected. Required with --proxy_protocol_enable")
+	listenerReusePort := flag.Bool("listener_reuse_port_enable", false, "Listen TCP addresses of database connections and HTTP API with SO_REUSEPORT option, so new AcraServer process started with this option may take over them while the old one drains connections with --drain_timeout (binary upgrade without refused connections)")
+	drainTimeout := flag.Duration("drain_timeout", 0, "Time that AcraServer will wait on SIGTERM for active sessions to finish transactions after it stopped accepting new connections (e.g. 30s). Sessions are closed when they become idle, activity is tracked only for PostgreSQL. 0 - disabled")
 
 	detectPoisonRecords := flag.Bool("poison_detect_enable", false, "Turn on poison record detection, if server shutdown is disabled, AcraServer logs the poison record detection and returns decrypted data")
+	detectInboundPoisonRecords := flag.Bool("poison_detect_inbound_enable", false, "Search poison records also in query literals and prepared statement values sent by clients. Requires --poison_detect_enable")
 	stopOnPoison := flag.Bool("poison_shutdown_enable", false, "On detecting poison record: log about poison record detection, stop and shutdown")
 	scriptOnPoison := flag.String("poison_run_script_file", "", "On detecting poison record: log about poison record detection, execute script, return decrypted data")
+	poisonActionsConfig := flag.String("poison_actions_config_file", "", "Path to YAML configuration of ordered actions called on detecting poison record with per-clientID overrides. Overrides --poison_run_script_file and --poison_shutdown_enable")
+	poisonActions := flag.String("poison_actions", "", fmt.Sprintf("Comma separated ordered list of actions called on detecting poison record: <%s>. 'run_script' uses --poison_run_script_file. Overrides --poison_shutdown_enable, ignored with --poison_actions_config_file", strings.Join(poison.SupportedActionTypes, "|")))
+	poisonWebhookURL := flag.String("poison_webhook_url", "", "URL used by 'webhook' action of --poison_actions")
+	poisonWebhookSecretEnv := flag.String("poison_webhook_secret_env", "", "Name of environment variable with secret used to sign payload of 'webhook' action of --poison_actions with HMAC-SHA256")
+
+	latencyBudget := flag.Duration("decryption_latency_budget", 0, "Budget of estimated decryption time per query response (e.g. 50ms). Rows which exceed it are returned in --decryption_latency_degraded_mode. 0 - disabled")
+	latencyColumnCost := flag.Duration("decryption_latency_column_cost", DefaultDecryptionLatencyColumnCost, "Estimated decryption time of one column value used to calculate decryption time of query response")
+	latencyDegradedMode := flag.String("decryption_latency_degraded_mode", string(base.DegradedModeCiphertext), fmt.Sprintf("Processing of rows which exceed --decryption_latency_budget: <%s|%s>", base.DegradedModeCiphertext, base.DegradedModeMasked))
+
+	maxAcceptedContainerFormat := flag.Uint("max_accepted_container_format", uint(acrablock.CurrentFormatVersion), "The newest format version of AcraBlocks which are decrypted, newer AcraBlocks are refused. New AcraBlocks aren't created with newer version. Use lower value during upgrade of instances to keep AcraBlocks readable by not upgraded ones")
+	containerFormatVersion := flag.Uint("container_format_version", uint(acrablock.DefaultWriterFormatVersion), "Format version of created AcraBlocks, can't be newer than --max_accepted_container_format. 0 (default) is readable by all versions of Acra. Enable 1 or 2 only after every reader is upgraded, older versions don't recognize AcraBlocks with explicit version and pass them through without decryption. Version 2 adds key version hint and authenticated metadata and is always used for AcraBlocks bound to column with acrablock_column_binding")
+	replicationPolicy := flag.String("postgresql_replication_policy", string(base.ReplicationPolicyDeny), fmt.Sprintf("Handling of PostgreSQL connections in streaming replication mode (replication=true|database): <%s|%s>. '%s' forwards replication traffic untouched without decryption and AcraCensor checks", base.ReplicationPolicyDeny, base.ReplicationPolicyPassthrough, base.ReplicationPolicyPassthrough))
+	requireClientTLS := flag.Bool("postgresql_tls_required_enable", false, "Deny PostgreSQL clients which send StartupMessage without switching to TLS with SSLRequest (sslmode=disable|allow|prefer on database deny). Requires TLS configuration of AcraServer")
+	columnCopyPolicy := flag.String("column_copy_policy", string(base.ColumnCopyPolicyDeny), fmt.Sprintf("Handling of INSERT ... SELECT and UPDATE queries which copy data between columns with different encryptor config settings: <%s|%s>. '%s' stores copied data as is and logs the query", base.ColumnCopyPolicyDeny, base.ColumnCopyPolicyAllow, base.ColumnCopyPolicyAllow))
+	dbHeartbeatInterval := flag.Duration("db_heartbeat_interval", 0, "Interval of inactivity of connection to the database after which AcraServer sends liveness probe to it (e.g. 30s). Supported only for PostgreSQL. 0 - disabled")
+	dbHeartbeatTimeout := flag.Duration("db_heartbeat_timeout", DefaultDBHeartbeatTimeout, "Time of waiting for response to liveness probe after which connection to the database is closed")
+	sessionIdleTimeout := flag.Duration("session_idle_timeout", 0, "Time without client's requests after which AcraServer sends error to the client and closes its session (e.g. 10m). Time of waiting for database's responses is not counted. 0 - disabled")
+	maxSessions := flag.Uint("max_sessions", 0, "Maximum number of simultaneous client sessions, new connections above the limit are rejected with error of database protocol. 0 - unlimited")
+	maxClientIDSessions := flag.Uint("max_sessions_per_client_id", 0, "Maximum number of simultaneous client sessions per clientID, new connections above the limit are rejected with error of database protocol. 0 - unlimited")
+	queryLatencyThreshold := flag.Duration("query_latency_threshold", 0, "Latency of query including processing by AcraCensor, encryption, database and decryption of response after which AcraServer logs event with normalized query, clientID and timings (e.g. 2s). 0 - disabled")
+	queryResponseRowsThreshold := flag.Uint("query_response_rows_threshold", 0, "Number of rows of query response after which AcraServer logs event with normalized query, clientID and timings. 0 - disabled")
+	queryResponseBytesThreshold := flag.Uint("query_response_bytes_threshold", 0, "Size of rows of query response in bytes after which AcraServer logs event with normalized query, clientID and timings. 0 - disabled")
+	sessionMaxLifetime := flag.Duration("session_max_lifetime", 0, "Maximum duration of client's session after which AcraServer sends error to the client and closes it when it doesn't wait for database's response (e.g. 8h). 0 - disabled")
 
 	enableHTTPAPI := flag.Bool("http_api_enable", false, "Enable HTTP API. Use together with --http_api_tls_transport_enable whenever possible.")
+	httpAPITokenFile := flag.String("http_api_token_file", "", "Path to file with token required in `Authorization: Bearer <token>` header of HTTP API requests. Empty value turns off authentication of requests")
+	httpAPIGRPCReflectionEnable := flag.Bool("http_api_grpc_reflection_enable", false, "Enable gRPC server reflection service on HTTP API listener alongside gRPC health checking. Requests require token of --http_api_token_file like HTTP requests")
+	httpAPIDiagnosticsEnable := flag.Bool("http_api_diagnostics_enable", false, "Enable /debug endpoints of HTTP API with pprof profiles, runtime metrics and goroutine dumps. Requires --http_api_diagnostics_basic_auth_file or --http_api_tls_transport_enable")
+	httpAPIDiagnosticsAuthFile := flag.String("http_api_diagnostics_basic_auth_file", "", "Path to file with `<username>:<password>` credentials of basic auth required by diagnostics endpoints of HTTP API")
+	httpAPIDiagnosticsClientIDs := flag.String("http_api_diagnostics_client_ids", "", "Comma-separated list of clientIDs of TLS certificates allowed to access diagnostics endpoints of HTTP API with --http_api_tls_transport_enable. Empty value allows all clients with verified certificates")
 	httpAPIUseTLS := flag.Bool("http_api_tls_transport_enable", false, "Enable HTTPS support for the API. Use together with the --http_api_enable. TLS configuration is the same as in the Acra Proxy. Starting from 0.96.0 the flag value will be true by default.")
 
 	network.RegisterTLSBaseArgs(flag.CommandLine)
 	network.RegisterTLSArgsForService(flag.CommandLine, false, "", network.ClientNameConstructorFunc())
 	network.RegisterTLSArgsForService(flag.CommandLine, true, "", network.DatabaseNameConstructorFunc())
+	tlsCertificateReloadInterval := flag.Duration("tls_certificate_reload_interval", 0, "Interval of checking changes of certificate and private key files used for connections from clients (--tls_client_cert/--tls_client_key or --tls_cert/--tls_key). Changed certificate is used for new connections without restart (e.g. 1m). 0 - disabled")
+	tlsACMEEnable := flag.Bool("tls_acme_enable", false, "Obtain and renew certificate used for connections from clients with ACME (Let's Encrypt or internal CA) instead of --tls_client_cert/--tls_cert. Clients should send SNI with one of --tls_acme_domains")
+	tlsACMEDomains := flag.String("tls_acme_domains", "", "Comma separated domains of ACME certificate")
+	tlsACMEDirectoryURL := flag.String("tls_acme_directory_url", network.DefaultACMEDirectoryURL, "Directory URL of ACME CA")
+	tlsACMECacheDir := flag.String("tls_acme_cache_dir", "", "Folder where ACME certificates and account key are stored between restarts. Empty value keeps them only in memory")
+	tlsACMEEmail := flag.String("tls_acme_email", "", "Contact email of ACME account")
+	tlsOCSPStaplingEnable := flag.Bool("tls_ocsp_stapling_enable", false, "Staple OCSP response about certificate used for connections from clients to TLS handshakes. Certificate file should contain issuer's certificate and certificate should contain URL of OCSP server. Not supported with --tls_acme_enable")
+	tlsACMEHTTPAddress := flag.String("tls_acme_http_challenge_address", ":80", "Address of HTTP server which answers HTTP-01 challenges of ACME CA")
+	tlsSPIFFEEnable := flag.Bool("tls_spiffe_workload_api_enable", false, "Obtain certificate used for connections from clients and CA certificates to verify clients' certificates from SPIFFE Workload API instead of --tls_client_cert/--tls_cert and --tls_client_ca/--tls_ca")
+	tlsSPIFFESocket := flag.String("tls_spiffe_workload_api_socket", "", "Address of SPIFFE Workload API (e.g. unix:///run/spire/sockets/agent.sock). Empty value means address from "+network.SPIFFEEndpointSocketEnv+" environment variable")
+	tlsClientCertificatePinsFile := flag.String("tls_client_certificate_pins_file", "", "Path to YAML file with pins (base64 encoded SHA-256 hashes of SubjectPublicKeyInfo) of clients' certificates per clientID. Certificates of clientIDs with pins are accepted only if they match one of pins, in addition to CA validation")
+	tlsClientCertificatePinsReloadInterval := flag.Duration("tls_client_certificate_pins_reload_interval", 0, "Interval of checking --tls_client_certificate_pins_file for changes (e.g. 30s). Zero value disables reloading")
+	tlsClientCertificatePinsStrictEnable := flag.Bool("tls_client_certificate_pins_strict_enable", false, "Deny certificates of clientIDs without pins in --tls_client_certificate_pins_file")
 	tlsUseClientIDFromCertificate := flag.Bool("tls_client_id_from_cert", true, "Extract clientID from TLS certificate from application connection. Can't be used with --tls_client_auth=0 or --tls_auth=0")
 	tlsIdentifierExtractorType := flag.String("tls_identifier_extractor_type", network.DefaultIdentifierExtractorTypeDistinguishedName, fmt.Sprintf("Decide which field of TLS certificate to use as ClientID (%s). Default is %s.", strings.Join(network.IdentifierExtractorTypesList, "|"), network.IdentifierExtractorTypeDistinguishedName))
+	network.RegisterIdentifierExtractorTemplateArgs(flag.CommandLine)
 	clientID := flag.String("client_id", "", "Static ClientID used by AcraServer for data protection operations")
 	acraConnectionString := flag.String("incoming_connection_string", network.BuildConnectionString(cmd.DefaultAcraServerConnectionProtocol, cmd.DefaultAcraServerHost, cmd.DefaultAcraServerPort, ""), "Connection string like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
 	acraAPIConnectionString := flag.String("incoming_connection_api_string", network.BuildConnectionString(cmd.DefaultAcraServerConnectionProtocol, cmd.DefaultAcraServerHost, cmd.DefaultAcraServerAPIPort, ""), "Connection string for api like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
+	webSocketConnectionString := flag.String("incoming_connection_websocket_string", "", "Connection string like ws://x.x.x.x:yyyy/path or wss://x.x.x.x:yyyy/path to accept database connections tunneled over WebSocket. wss uses the same TLS configuration as for application connections. Empty value disables the listener")
 	sqlParseErrorExitEnable := flag.Bool("sql_parse_on_error_exit_enable", false, "Stop AcraServer execution in case of SQL query parse error. Default is false")
 
 	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
@@ -159,19 +262,26 @@ func realMain() error {
 	censorConfig := flag.String("acracensor_config_file", "", "Path to AcraCensor configuration file")
 	boltTokebDB := flag.String("token_db", "", "Path to BoltDB database file to store tokens")
 
+	migrateEncryptorConfig := flag.Bool("migrate_encryptor_config", false, "Rewrite encryptor config to the newest schema_version and exit")
+	validateConfig := flag.Bool("validate-config", false, "Validate config file and configuration of network, TLS, keystore, AcraCensor and encryptor and exit. Exits with error on unknown, duplicated or contradictory options. Options of config file may be grouped into sections like `tls: {tls_ca: ca.crt}`")
+	encryptorConfigPollInterval := flag.Duration("encryptor_config_poll_interval", 0, "Interval of polling encryptor config storage (e.g. 30s) to apply changed config without restart. 0 - disabled")
+	reloadEncryptorConfigOnSIGHUP := flag.Bool("encryptor_config_reload_on_sighup", false, "Reload encryptor config on SIGHUP signal without restart instead of graceful restart of AcraServer")
 	encryptorConfigStorageType := flag.String("encryptor_config_storage_type", config_loader.EncryptoConfigStorageTypeFilesystem, fmt.Sprintf("Encryptor configuration file storage types: <%s", strings.Join(config_loader.SupportedEncryptorConfigStorages, "|")))
 
 	enableAuditLog := flag.Bool("audit_log_enable", false, "Enable audit log functionality")
+	enableDataAccessAuditLog := flag.Bool("audit_log_data_access_enable", false, "Write event with clientID, encrypted tables and columns, count of rows and AcraCensor verdict for each query into audit log. Events are numbered to find missing ones on verification. Requires --audit_log_enable")
 	cmd.RegisterRedisKeystoreParameters()
+	cmd.RegisterRedisKeystoreParametersWithPrefix(flag.CommandLine, keystoreReplicationFlagsPrefix, "standby keystore for --keystore_replication_keys_dir")
 	cmd.RegisterRedisTokenStoreParameters()
+	cmd.RegisterTokenStorageBackendParameters()
+	cmd.RegisterRemoteKeyStoreAPIParameters(flag.CommandLine)
 	keyloader.RegisterKeyStoreStrategyParameters()
 	config_loader.RegisterEncryptorConfigLoaderParameters()
 	cmd.RegisterTracingCmdParameters()
 	cmd.RegisterJaegerCmdParameters()
-	logging.RegisterCLIArgs()
-
-	verbose := flag.Bool("v", false, "Log to stderr all INFO, WARNING and ERROR logs")
-	debug := flag.Bool("d", false, "Log everything to stderr")
+	cmd.RegisterOTLPCmdParameters()
+	loggingParams := cmd.RegisterLoggingParameters()
+	logging.RegisterEventSinkCLIArgs(flag.CommandLine)
 
 	err := cmd.Parse(DefaultConfigPath, ServiceName)
 	if err != nil {
@@ -193,7 +303,7 @@ func realMain() error {
 	}
 
 	// Start customizing logs here (directly after command line arguments parsing)
-	formatter := logging.CreateCryptoFormatter(*loggingFormat)
+	formatter := logging.CreateCryptoFormatter(loggingParams.Format)
 	// Set formatter early in order to have consistent format for further logs
 	formatter.SetServiceName(ServiceName)
 	log.SetFormatter(formatter)
@@ -206,6 +316,35 @@ func realMain() error {
 	defer logFinalize()
 	log.SetOutput(writer)
 
+	if *validateConfig {
+		if err := cmd.ValidateConfigFile(flag.CommandLine, DefaultConfigPath); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid config file")
+			return err
+		}
+	}
+
+	if err := acrablock.SetMaxAcceptedFormatVersion(*maxAcceptedContainerFormat); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --max_accepted_container_format")
+		return err
+	}
+	if err := acrablock.SetWriterFormatVersion(*containerFormatVersion); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --container_format_version")
+		return err
+	}
+
+	eventSinkHook, err := logging.NewEventSinkHookFromCLI(ServiceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't initialize export of security events")
+		return err
+	}
+	if eventSinkHook != nil {
+		log.AddHook(eventSinkHook)
+	}
+
 	version, err := utils.GetParsedVersion()
 	if err != nil {
 		log.WithError(err).Errorln("Cannot parse version")
@@ -213,6 +352,10 @@ func realMain() error {
 	}
 	log.WithFields(log.Fields{"version": version.String()}).Infof("Starting service %v [pid=%v]", ServiceName, os.Getpid())
 
+	if *migrateEncryptorConfig {
+		return migrateEncryptorConfigSchema(*encryptorConfigStorageType)
+	}
+
 	serverConfig, err := common.NewConfig()
 	if err != nil {
 		log.WithError(err).Errorln("Can't initialize config")
@@ -233,6 +376,19 @@ func realMain() error {
 	if *apiPort != cmd.DefaultAcraServerAPIPort {
 		serverConfig.SetAcraAPIConnectionString(network.BuildConnectionString("tcp", *host, *apiPort, ""))
 	}
+	serverConfig.SetWebSocketConnectionString(*webSocketConnectionString)
+	serverConfig.SetListenerReusePort(*listenerReusePort)
+	serverConfig.SetSessionLimits(*maxSessions, *maxClientIDSessions)
+	if *proxyProtocolEnable {
+		proxyProtocolReader, err := network.NewProxyProtocolReader(*proxyProtocolTrustedNetworks, network.DefaultProxyProtocolHeaderTimeout)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --proxy_protocol_trusted_networks")
+			return err
+		}
+		serverConfig.SetProxyProtocolReader(proxyProtocolReader)
+		log.Infoln("Enabled PROXY protocol on database connections")
+	}
 
 	if *dbHost == "" {
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
@@ -241,6 +397,19 @@ func realMain() error {
 		return err
 	}
 	serverConfig.SetDBConnectionSettings(*dbHost, *dbPort)
+	dbAddresses, err := common.ParseDatabaseAddresses(*dbHost, *dbPort, *dbFailoverHosts)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --db_failover_hosts")
+		return err
+	}
+	dbUpstream, err := common.NewDatabaseUpstream(dbAddresses, *dbHealthCheckInterval)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't configure connections to the database")
+		return err
+	}
+	serverConfig.SetDatabaseUpstream(dbUpstream)
 
 	if err := serverConfig.SetDatabaseType(*useMysql, *usePostgresql); err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
@@ -254,6 +423,14 @@ func realMain() error {
 			return err
 		}
 		log.Infoln("Encryptor configuration loaded")
+	} else if *reloadEncryptorConfigOnSIGHUP {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--encryptor_config_reload_on_sighup requires configured encryptor config")
+		return ErrEncryptorConfigNotConfigured
+	} else if *encryptorConfigPollInterval != 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--encryptor_config_poll_interval requires configured encryptor config")
+		return ErrEncryptorConfigNotConfigured
 	}
 
 	if err := serverConfig.SetCensor(*censorConfig); err != nil {
@@ -265,16 +442,18 @@ func realMain() error {
 	// now it's stub as default values
 	serverConfig.SetDetectPoisonRecords(*detectPoisonRecords)
 	serverConfig.SetEnableHTTPAPI(*enableHTTPAPI)
-	serverConfig.SetDebug(*debug)
+	serverConfig.SetDebug(loggingParams.Debug)
 	serverConfig.SetServiceName(ServiceName)
 	serverConfig.SetConfigPath(cmd.ConfigPath(DefaultConfigPath))
 
 	log.Infof("Initialising keystore...")
 	var keyStore keystore.ServerKeyStore
-	if filesystemV2.IsKeyDirectory(*keysDir) {
+	if *keystoreMigrationMode != "" {
+		keyStore, err = openMigratingKeyStore(*keysDir, *keystoreMigrationV2KeysDir, *keysCacheSize, *keysCacheTTL, *keystoreMigrationMode)
+	} else if filesystemV2.IsKeyDirectory(*keysDir) {
 		keyStore, err = openKeyStoreV2(*keysDir, *keysCacheSize)
 	} else {
-		keyStore, err = openKeyStoreV1(*keysDir, *keysCacheSize)
+		keyStore, err = openKeyStoreV1(*keysDir, *keysCacheSize, *keysCacheTTL)
 	}
 	if err != nil {
 		log.WithError(err).Errorln("Can't open keyStore")
@@ -301,6 +480,63 @@ func realMain() error {
 		return err
 	}
 
+	var keyUsageTracker *keystore.KeyUsageTracker
+	var keyUsageStore keystore.KeyUsageTrackingKeyStore
+	if *keyUsageTrackingInterval != 0 {
+		var ok bool
+		keyUsageStore, ok = keyStore.(keystore.KeyUsageTrackingKeyStore)
+		if !ok {
+			log.WithError(keystore.ErrKeyUsageTrackingNotSupported).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't use --keystore_usage_tracking_interval")
+			return keystore.ErrKeyUsageTrackingNotSupported
+		}
+		keyUsageTracker = keystore.NewKeyUsageTracker()
+		keyUsageStore.SetKeyUsageTracker(keyUsageTracker)
+	}
+
+	var keysRotator *rotation.Rotator
+	var rotationSchedule rotation.Schedule
+	if *keysRotationSchedule != "" {
+		rotationSchedule, err = rotation.ParseSchedule(*keysRotationSchedule)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --keys_rotation_schedule")
+			return err
+		}
+		kinds, err := rotation.ParseKeyKinds(*keysRotationKinds)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --keys_rotation_kinds")
+			return err
+		}
+		keysRotator, err = rotation.NewRotator(keyStore, kinds, nil)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't initialize rotation of keys")
+			return err
+		}
+	}
+
+	var keysReplicator *replication.Replicator
+	if *keystoreReplicationKeysDir != "" {
+		if filesystemV2.IsKeyDirectory(*keysDir) {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("--keystore_replication_keys_dir is supported only by keystore v1")
+			return replication.ErrInvalidLocation
+		}
+		if *keystoreReplicationInterval <= 0 {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --keystore_replication_interval, should be positive")
+			return replication.ErrInvalidInterval
+		}
+		keysReplicator, err = newKeysReplicator(*keysDir, *keystoreReplicationKeysDir)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't initialize replication of keys")
+			return err
+		}
+	}
+
 	var auditLogHandler *logging.AuditLogHandler
 	if *enableAuditLog {
 		auditLogKey, err := keyStore.GetLogSecretKey()
@@ -309,7 +545,7 @@ func realMain() error {
 			return err
 		}
 
-		hooks, err := logging.NewHooks(auditLogKey, *loggingFormat)
+		hooks, err := logging.NewHooks(auditLogKey, loggingParams.Format)
 		if err != nil {
 			log.WithError(err).Errorln("Can't initialise necessary hooks for logging customization")
 			return err
@@ -341,6 +577,65 @@ func realMain() error {
 			Errorln("Configuration error: can't create application TLS config")
 		os.Exit(1)
 	}
+	var acmeManager *autocert.Manager
+	var certificateReloader *network.CertificateReloader
+	var spiffeSource *network.SPIFFEWorkloadSource
+	if *tlsSPIFFEEnable {
+		if *tlsACMEEnable || *tlsCertificateReloadInterval != 0 {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --tls_spiffe_workload_api_enable is not supported with --tls_acme_enable and --tls_certificate_reload_interval")
+			os.Exit(1)
+		}
+		spiffeSource, err = network.NewSPIFFEWorkloadSource(*tlsSPIFFESocket)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: can't initialize SPIFFE Workload API client")
+			os.Exit(1)
+		}
+		fetchCtx, cancelFetch := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+		err = spiffeSource.Fetch(fetchCtx)
+		cancelFetch()
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Can't fetch X509-SVID from SPIFFE Workload API")
+			os.Exit(1)
+		}
+		spiffeSource.ApplyToConfig(appSideTLSConfig)
+	} else if *tlsACMEEnable {
+		acmeManager, err = network.NewACMECertificateManager(*tlsACMEDomains, *tlsACMEDirectoryURL, *tlsACMECacheDir, *tlsACMEEmail)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: can't initialize ACME certificate manager")
+			os.Exit(1)
+		}
+		network.ApplyACMEToConfig(acmeManager, appSideTLSConfig)
+		log.WithField("domains", *tlsACMEDomains).WithField("directory", *tlsACMEDirectoryURL).Infoln("Enabled ACME certificates")
+	} else if *tlsCertificateReloadInterval != 0 {
+		certPath, keyPath := network.TLSCertificatePathsByName(flag.CommandLine, "", network.ClientNameConstructorFunc())
+		certificateReloader, err = network.NewCertificateReloader(certPath, keyPath, *tlsCertificateReloadInterval)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: can't initialize reloading of TLS certificate")
+			os.Exit(1)
+		}
+		certificateReloader.ApplyToConfig(appSideTLSConfig)
+	}
+	var ocspStapler *network.OCSPStapler
+	if *tlsOCSPStaplingEnable {
+		if *tlsACMEEnable {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --tls_ocsp_stapling_enable is not supported with --tls_acme_enable")
+			os.Exit(1)
+		}
+		ocspStapler, err = network.NewOCSPStapler(appSideTLSConfig, network.NewDefaultOCSPClient())
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: can't initialize OCSP stapling")
+			os.Exit(1)
+		}
+		ocspStapler.ApplyToConfig(appSideTLSConfig)
+	}
+	serverConfig.SetWebSocketTLSConfig(appSideTLSConfig)
 
 	dbTLSConfig, err := network.NewTLSConfigByName(flag.CommandLine, "", *dbHost, network.DatabaseNameConstructorFunc())
 	if err != nil {
@@ -368,6 +663,21 @@ func realMain() error {
 		log.WithError(err).Errorln("Can't initialize clientID extractor")
 		os.Exit(1)
 	}
+	var certificatePinStore *network.FileCertificatePinStore
+	if *tlsClientCertificatePinsFile != "" {
+		if !*tlsUseClientIDFromCertificate {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --tls_client_certificate_pins_file requires --tls_client_id_from_cert")
+			os.Exit(1)
+		}
+		certificatePinStore, err = network.NewFileCertificatePinStore(*tlsClientCertificatePinsFile, *tlsClientCertificatePinsReloadInterval)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: can't load --tls_client_certificate_pins_file")
+			os.Exit(1)
+		}
+		clientIDExtractor = network.NewPinnedTLSClientIDExtractor(clientIDExtractor, certificatePinStore, *tlsClientCertificatePinsStrictEnable)
+	}
 	serverConfig.SetTLSClientIDExtractor(clientIDExtractor)
 	// configured TLS wrapper which may be used for communication with app or database
 	tlsWrapper, err := network.NewTLSAuthenticationConnectionWrapper(
@@ -404,6 +714,33 @@ func realMain() error {
 			os.Exit(1)
 		}
 		serverConfig.HTTPAPIConnectionWrapper = httpAPIConnWrapper
+
+		if *httpAPITokenFile != "" {
+			token, err := os.ReadFile(*httpAPITokenFile)
+			if err == nil {
+				token = bytes.TrimSpace(token)
+				if len(token) == 0 {
+					err = errors.New("token file is empty")
+				}
+			}
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't read HTTP API token")
+				os.Exit(1)
+			}
+			serverConfig.SetHTTPAPIToken(token)
+		}
+		serverConfig.SetHTTPAPIGRPCReflection(*httpAPIGRPCReflectionEnable)
+
+		if *httpAPIDiagnosticsEnable {
+			diagnosticsAuth, err := buildDiagnosticsAuth(*enableHTTPAPI, *httpAPIUseTLS, *httpAPIDiagnosticsAuthFile, *httpAPIDiagnosticsClientIDs)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't configure diagnostics endpoints of HTTP API")
+				os.Exit(1)
+			}
+			serverConfig.SetHTTPAPIDiagnostics(diagnosticsAuth)
+		}
 	}
 
 	proxyTLSWrapper := base.NewTLSConnectionWrapper(*tlsUseClientIDFromCertificate, tlsWrapper)
@@ -415,7 +752,12 @@ func realMain() error {
 	if (len(serverConfig.GetStaticClientID()) == 0) && !*tlsUseClientIDFromCertificate {
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
 			Errorln("Configuration error: without encryption you must set <client_id> which will be used to connect to AcraServer")
-		return err
+		return ErrStaticClientIDRequired
+	}
+
+	if *validateConfig {
+		log.Infoln("Configuration is valid")
+		return nil
 	}
 
 	log.Debugf("Registering process signal handlers")
@@ -458,27 +800,150 @@ func realMain() error {
 		sigHandlerSIGHUP.RegisterWithContext(mainContext)
 	}()
 
-	poisonCallbacks := poison.NewCallbackStorage()
+	go dbUpstream.Run(mainContext)
+	if eventSinkHook != nil {
+		go eventSinkHook.Run(mainContext)
+		log.Infoln("Enabled export of security events")
+	}
+	if certificateReloader != nil {
+		go certificateReloader.Run(mainContext)
+		log.WithField("interval", tlsCertificateReloadInterval.String()).Infoln("Enabled reloading of TLS certificate")
+	}
+	if certificatePinStore != nil && *tlsClientCertificatePinsReloadInterval != 0 {
+		go certificatePinStore.Run(mainContext)
+		log.WithField("interval", tlsClientCertificatePinsReloadInterval.String()).Infoln("Enabled reloading of client certificate pins")
+	}
+	if spiffeSource != nil {
+		go spiffeSource.Run(mainContext)
+		log.Infoln("Enabled X509-SVID rotation from SPIFFE Workload API")
+	}
+	if ocspStapler != nil {
+		go ocspStapler.Run(mainContext)
+		log.Infoln("Enabled OCSP stapling")
+	}
+	if acmeManager != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			challengeServer := &http.Server{ReadTimeout: network.DefaultNetworkTimeout, WriteTimeout: network.DefaultNetworkTimeout, Addr: *tlsACMEHTTPAddress, Handler: acmeManager.HTTPHandler(nil)}
+			go func() {
+				err := challengeServer.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartService).
+						Errorln("System error: got error from ACME challenge server")
+				}
+			}()
+			<-mainContext.Done()
+			_ = challengeServer.Shutdown(context.TODO())
+		}()
+	}
+	if *encryptorConfigPollInterval != 0 {
+		if err := serverConfig.WatchTableSchema(mainContext, *encryptorConfigPollInterval); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't start polling of encryptor config")
+			cancel()
+			return err
+		}
+		log.WithField("interval", encryptorConfigPollInterval.String()).Infoln("Enabled polling of encryptor config storage")
+	}
+
+	if keyUsageTracker != nil {
+		go keyUsageTracker.Run(mainContext, keyUsageStore, *keyUsageTrackingInterval)
+		log.WithField("interval", keyUsageTrackingInterval.String()).Infoln("Enabled tracking of keys usage")
+	}
+
+	if keysRotator != nil {
+		go keysRotator.Run(mainContext, rotationSchedule)
+		log.WithField("schedule", *keysRotationSchedule).WithField("kinds", *keysRotationKinds).Infoln("Enabled scheduled rotation of keys")
+	}
+
+	if keysReplicator != nil {
+		go keysReplicator.Run(mainContext, *keystoreReplicationInterval)
+		log.WithField("path", *keystoreReplicationKeysDir).WithField("interval", keystoreReplicationInterval.String()).Infoln("Enabled replication of keys to standby keystore")
+	}
+
+	if keyStoreAPIParams := cmd.ParseRemoteKeyStoreAPIParameters(flag.CommandLine); keyStoreAPIParams.Configured() {
+		var exporter keystore.Exporter
+		if keyStoreAPIParams.ExportEnable {
+			exporter, err = newKeysExporter(*keysDir, keyStore)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't initialize export of keys over keystore API")
+				cancel()
+				return err
+			}
+		}
+		if err := cmd.RunRemoteKeyStoreAPI(mainContext, keyStoreAPIParams, keyStore, exporter, appSideTLSConfig, clientIDExtractor); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartService).
+				Errorln("Can't start keystore API")
+			cancel()
+			return err
+		}
+		log.WithField("address", keyStoreAPIParams.Address).Infoln("Enabled keystore API")
+	}
+
+	var poisonCallbacks base.PoisonRecordCallbackStorage = poison.NewCallbackStorage()
 	if *detectPoisonRecords {
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetectionMessage).Infoln("Turned on poison record detection")
-		// used to turn off poison record detection which rely on HasCallbacks
-		poisonCallbacks.AddCallback(poison.EmptyCallback{})
-		if *scriptOnPoison != "" {
-			poisonCallbacks.AddCallback(poison.NewExecuteScriptCallback(*scriptOnPoison))
-			serverConfig.SetScriptOnPoison(*scriptOnPoison)
-			log.WithField("poison_run_script_file", *scriptOnPoison).Infoln("Turned on script execution for on detected poison record")
-		}
-		// should setup "stopOnPoison" as last poison record callback"
-		if *stopOnPoison {
-			poisonCallbacks.AddCallback(&poison.StopCallback{})
-			serverConfig.SetStopOnPoison(*stopOnPoison)
-			log.Infoln("Turned on poison record callback that stops acra-server after poison record detection")
+		if *poisonActionsConfig != "" {
+			poisonCallbacks, err = poison.NewActionPipelineFromFile(*poisonActionsConfig)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					WithField("poison_actions_config_file", *poisonActionsConfig).Errorln("Can't load poison record actions configuration")
+				return err
+			}
+			log.WithField("poison_actions_config_file", *poisonActionsConfig).Infoln("Loaded poison record actions pipeline")
+		} else if *poisonActions != "" {
+			actionsConfig, err := poison.NewActionsConfigFromList(*poisonActions, poison.ActionConfig{
+				URL:       *poisonWebhookURL,
+				SecretEnv: *poisonWebhookSecretEnv,
+				Script:    *scriptOnPoison,
+			})
+			if err == nil {
+				poisonCallbacks, err = poison.NewActionPipelineFromConfig(actionsConfig)
+			}
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					WithField("poison_actions", *poisonActions).Errorln("Can't initialize poison record actions pipeline")
+				return err
+			}
+			log.WithField("poison_actions", *poisonActions).Infoln("Initialized poison record actions pipeline")
+		} else {
+			poisonCallbacks, err = poison.NewLegacyActionPipeline(*scriptOnPoison, *stopOnPoison)
+			if err != nil {
+				log.WithError(err).Errorln("Can't initialize poison record actions pipeline")
+				return err
+			}
+			if *scriptOnPoison != "" {
+				serverConfig.SetScriptOnPoison(*scriptOnPoison)
+				log.WithField("poison_run_script_file", *scriptOnPoison).Infoln("Turned on script execution for on detected poison record")
+			}
+			if *stopOnPoison {
+				serverConfig.SetStopOnPoison(*stopOnPoison)
+				log.Infoln("Turned on poison record callback that stops acra-server after poison record detection")
+			}
 		}
 	}
 
 	var tokenStorage pseudonymizationCommon.TokenStorage
 	redis := cmd.ParseRedisCLIParametersFromFlags(flag.CommandLine, "")
-	if *boltTokebDB != "" {
+	tokenStorageBackend := cmd.ParseTokenStorageBackendParametersFromFlags(flag.CommandLine)
+	if tokenStorageBackend.Configured() && (*boltTokebDB != "" || redis.TokensConfigured()) {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--token_storage_backend can't be used together with --token_db or --redis_host_port")
+		return cmd.ErrTokenStorageConflict
+	}
+	if tokenStorageBackend.Configured() {
+		log.WithField("backend", tokenStorageBackend.Backend).Infoln("Initialize token storage backend")
+		backendStorage, closer, err := storage.OpenTokenStorageBackend(tokenStorageBackend.Backend, tokenStorageBackend.ConnectionString)
+		if err != nil {
+			log.WithError(err).WithField("backend", tokenStorageBackend.Backend).Errorln("Can't initialize token storage backend")
+			return err
+		}
+		defer closer.Close()
+		tokenStorage = backendStorage
+		log.WithField("backend", tokenStorageBackend.Backend).Infoln("Initialized token storage backend")
+	} else if *boltTokebDB != "" {
 		log.Infoln("Initialize bolt db storage for tokens")
 		db, err := bolt.Open(*boltTokebDB, 0600, nil)
 		if err != nil {
@@ -490,16 +955,12 @@ func realMain() error {
 		log.Infoln("Initialized bolt db storage for tokens")
 	} else if redis.TokensConfigured() {
 		log.Infoln("Initialize redis db storage for tokens")
-		// if redisTLSConfig = nil then will not be used TLS for Redis
-		var redisTLSConfig *tls.Config
-		if redis.TLSEnable {
-			redisTLSConfig, err = network.NewTLSConfigByName(flag.CommandLine, "redis", redis.HostPort, network.ClientNameConstructorFunc())
-			if err != nil {
-				log.WithError(err).Errorln("Can't initialize tls config for redis client")
-				return err
-			}
+		redisOptions, err := redis.TokensOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			return err
 		}
-		redisClient, err := storage.NewRedisClient(redis.HostPort, redis.Password, redis.DBTokens, redisTLSConfig)
+		redisClient, err := redisclient.Open(redisOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Can't initialize redis client")
 			return err
@@ -541,8 +1002,180 @@ func realMain() error {
 		return err
 	}
 
+	var proxySettingOptions []base.ProxySettingOption
+	if *latencyBudget > 0 {
+		degradedMode, err := base.ParseDegradedMode(*latencyDegradedMode)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --decryption_latency_degraded_mode")
+			return err
+		}
+		budget, err := base.NewLatencyBudget(*latencyBudget, *latencyColumnCost, degradedMode)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't initialize decryption latency budget")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithLatencyBudget(budget))
+		log.WithField("budget", budget.Budget.String()).WithField("degraded_mode", budget.Mode).Infoln("Enabled decryption latency budget")
+	}
+
+	if !*useMysql {
+		policy, err := base.ParseReplicationPolicy(*replicationPolicy)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Invalid --postgresql_replication_policy")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithReplicationPolicy(policy))
+		if policy == base.ReplicationPolicyPassthrough {
+			log.Warningln("Replication connections to PostgreSQL are forwarded untouched without decryption and AcraCensor checks")
+		}
+		if *requireClientTLS {
+			proxySettingOptions = append(proxySettingOptions, base.WithRequireClientTLS(true))
+			log.Infoln("PostgreSQL connections without TLS are denied")
+		}
+	}
+
+	copyPolicy, err := base.ParseColumnCopyPolicy(*columnCopyPolicy)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --column_copy_policy")
+		return err
+	}
+	proxySettingOptions = append(proxySettingOptions, base.WithColumnCopyPolicy(copyPolicy))
+	// source values of pseudonyms are kept in the same storage as tokens
+	proxySettingOptions = append(proxySettingOptions, base.WithPseudonymStorage(tokenStorage))
+
+	if *detectInboundPoisonRecords {
+		if !*detectPoisonRecords {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Warningln("--poison_detect_inbound_enable is ignored without --poison_detect_enable")
+		} else {
+			proxySettingOptions = append(proxySettingOptions, base.WithInboundPoisonRecordDetection(true))
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetectionMessage).Infoln("Turned on poison record detection in queries from clients")
+		}
+	}
+
+	if *dbHeartbeatInterval > 0 {
+		if *useMysql {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Warningln("--db_heartbeat_interval is supported only for PostgreSQL and will be ignored")
+		} else {
+			heartbeat, err := base.NewHeartbeatSettings(*dbHeartbeatInterval, *dbHeartbeatTimeout)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't initialize heartbeat of connections to database")
+				return err
+			}
+			proxySettingOptions = append(proxySettingOptions, base.WithHeartbeat(heartbeat))
+			log.WithField("interval", heartbeat.Interval.String()).WithField("timeout", heartbeat.Timeout.String()).
+				Infoln("Enabled heartbeat of idle connections to database")
+		}
+	}
+
+	sessionTimeouts, err := base.NewSessionTimeouts(*sessionIdleTimeout, *sessionMaxLifetime)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't initialize timeouts of client sessions")
+		return err
+	}
+	if sessionTimeouts != nil {
+		proxySettingOptions = append(proxySettingOptions, base.WithSessionTimeouts(sessionTimeouts))
+		log.WithField("idle_timeout", sessionTimeouts.Idle.String()).WithField("max_lifetime", sessionTimeouts.Lifetime.String()).
+			Infoln("Enabled timeouts of client sessions")
+	}
+
+	if *enableDataAccessAuditLog {
+		if !*enableAuditLog {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --audit_log_data_access_enable requires --audit_log_enable")
+			return ErrDataAccessAuditWithoutAuditLog
+		}
+		dataAccessAuditLog, err := logging.NewDataAccessAuditLog()
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't initialize audit log of data access")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithDataAccessAuditLog(dataAccessAuditLog))
+		log.Infoln("Enabled audit log of data access")
+	}
+
+	queryThresholds := &base.QueryThresholds{Latency: *queryLatencyThreshold, ResponseRows: *queryResponseRowsThreshold, ResponseBytes: *queryResponseBytesThreshold}
+	if *queryLatencyThreshold < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Configuration error: --query_latency_threshold shouldn't be negative")
+		return ErrInvalidQueryLatencyThreshold
+	}
+	if queryThresholds.IsEnabled() {
+		proxySettingOptions = append(proxySettingOptions, base.WithQueryThresholds(queryThresholds))
+		log.WithFields(log.Fields{"latency": queryThresholds.Latency.String(), "rows": queryThresholds.ResponseRows, "bytes": queryThresholds.ResponseBytes}).
+			Infoln("Enabled logging of queries which exceed thresholds")
+	}
+
+	if *enableColumnMetrics {
+		if *prometheusAddress == "" {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --column_metrics_enable requires --incoming_connection_prometheus_metrics_string")
+			return ErrColumnMetricsWithoutPrometheus
+		}
+		if *columnMetricsMaxColumns <= 0 {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --column_metrics_max_columns should be greater than 0")
+			return ErrInvalidColumnMetricsLimit
+		}
+		base.RegisterColumnMetrics()
+		proxySettingOptions = append(proxySettingOptions, base.WithColumnMetrics(base.NewColumnMetrics(*columnMetricsMaxColumns)))
+		log.WithField("max_columns", *columnMetricsMaxColumns).Infoln("Enabled per-column metrics")
+	}
+
+	if *columnTransformsConfig != "" {
+		columnTransforms, err := transform.NewProcessorFromFile(*columnTransformsConfig)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load config of column transforms")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithColumnTransforms(columnTransforms))
+		log.WithField("path", *columnTransformsConfig).Infoln("Enabled column transforms")
+	}
+
+	if *anonymizationConfig != "" {
+		anonymizationPolicy, err := anonymization.NewPolicyFromFile(*anonymizationConfig)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load config of anonymization")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithAnonymizationPolicy(anonymizationPolicy))
+		log.WithField("path", *anonymizationConfig).Infoln("Enabled anonymization for analytics clientIDs")
+	}
+
+	if *differentialPrivacyConfig != "" {
+		if *useMysql {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: --differential_privacy_config_file is supported only for PostgreSQL")
+			return ErrDifferentialPrivacyNotSupported
+		}
+		configData, err := os.ReadFile(*differentialPrivacyConfig)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't read config of differential privacy")
+			return err
+		}
+		privacyConfig, err := base.ParseDifferentialPrivacyConfig(configData)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't parse config of differential privacy")
+			return err
+		}
+		proxySettingOptions = append(proxySettingOptions, base.WithDifferentialPrivacy(base.NewDifferentialPrivacy(privacyConfig, sqlParser)))
+		log.WithField("path", *differentialPrivacyConfig).Infoln("Enabled differential privacy for aggregate queries")
+	}
+
 	var proxyFactory base.ProxyFactory
-	proxySetting := base.NewProxySetting(sqlParser, serverConfig.GetTableSchema(), keyStore, proxyTLSWrapper, serverConfig.GetCensor(), poisonCallbacks)
+	proxySetting := base.NewProxySetting(sqlParser, serverConfig.GetTableSchema(), keyStore, proxyTLSWrapper, serverConfig.GetCensor(), poisonCallbacks, proxySettingOptions...)
 	if *useMysql {
 		proxyFactory, err = mysql.NewProxyFactory(proxySetting, keyStore, tokenizer)
 		if err != nil {
@@ -609,7 +1242,9 @@ func realMain() error {
 				log.WithError(err).Errorln("Error on prometheus server close")
 			}
 		}
-		sigHandlerSIGHUP.AddCallback(stopPrometheusServer)
+		if !*reloadEncryptorConfigOnSIGHUP {
+			sigHandlerSIGHUP.AddCallback(stopPrometheusServer)
+		}
 		sigHandlerSIGTERM.AddCallback(stopPrometheusServer)
 	}
 
@@ -655,6 +1290,12 @@ func realMain() error {
 	sigHandlerSIGTERM.AddCallback(func() {
 		once.Do(func() {
 			log.Infof("Received incoming SIGTERM or SIGINT signal")
+			if *drainTimeout > 0 {
+				log.WithField("timeout", drainTimeout.String()).Infoln("Draining database connections before shutdown")
+				if err := server.DrainWithTimeout(*drainTimeout); err != nil {
+					log.WithError(err).Warningln("Not all sessions became idle in time, close them")
+				}
+			}
 			server.StopListeners()
 			server.Close()
 			cancel()
@@ -664,9 +1305,22 @@ func realMain() error {
 		})
 	})
 
+	if *reloadEncryptorConfigOnSIGHUP {
+		// reload only encryptor config and keep current process with all connections
+		sigHandlerSIGHUP.AddCallback(func() {
+			log.Infof("Received incoming SIGHUP signal, reloading encryptor config")
+			if err := serverConfig.ReloadTableSchema(); err != nil {
+				log.WithError(err).Errorln("Can't reload encryptor config, previous config left in use")
+			}
+		})
+	}
+
 	// we initialize pipeWrite only in SIGHUP handler
 	var pipeWrite *os.File
 	sigHandlerSIGHUP.AddCallback(func() {
+		if *reloadEncryptorConfigOnSIGHUP {
+			return
+		}
 		shutdownCurrentInstance := func(err error) {
 			server.Close()
 			cancel()
@@ -756,12 +1410,12 @@ func realMain() error {
 	// by default should be false
 	sqlparser.SetTokenizerVerbosity(false)
 	sqlparser.SetSQLParserErrorVerboseLevel(false)
-	if *debug {
+	if loggingParams.Debug {
 		log.Infof("Enabling DEBUG log level")
 		logging.SetLogLevel(logging.LogDebug)
 		sqlparser.SetSQLParserErrorVerboseLevel(true)
 		sqlparser.SetTokenizerVerbosity(true)
-	} else if *verbose {
+	} else if loggingParams.Verbose {
 		log.Infof("Enabling VERBOSE log level")
 		logging.SetLogLevel(logging.LogVerbose)
 	} else {
@@ -791,6 +1445,34 @@ func realMain() error {
 	return err
 }
 
+// migrateEncryptorConfigSchema rewrites encryptor config in storage to the newest schema version
+func migrateEncryptorConfigSchema(storageType string) error {
+	if !config_loader.IsEncryptorConfigLoaderCLIConfigured() {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Encryptor config is not configured, specify it to migrate")
+		return ErrEncryptorConfigNotConfigured
+	}
+	encryptorConfigLoader, err := config_loader.NewConfigLoader(storageType, flag.CommandLine, "")
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't init encryptor config loader")
+		return err
+	}
+	version, err := encryptorConfigLoader.Migrate()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't migrate encryptor config")
+		return err
+	}
+	logger := log.WithFields(log.Fields{"schema_version": version, "current_schema_version": encryptorConfig.SchemaVersionCurrent})
+	if version == encryptorConfig.SchemaVersionCurrent {
+		logger.Infoln("Encryptor config already uses the newest schema version")
+		return nil
+	}
+	logger.Infoln("Encryptor config migrated to the newest schema version")
+	return nil
+}
+
 func waitReadPipe(timeoutDuration time.Duration) error {
 	// unblock our pipe in order to use deadline for Read operation. It is important to call this before creating *os.File object from file descriptor
 	err := syscall.SetNonblock(DescriptorPipe, true)
@@ -823,7 +1505,7 @@ func waitReadPipe(timeoutDuration time.Duration) error {
 	return nil
 }
 
-func openKeyStoreV1(output string, cacheSize int) (keystore.ServerKeyStore, error) {
+func openKeyStoreV1(output string, cacheSize int, cacheTTL time.Duration) (keystore.ServerKeyStore, error) {
 	var keyStoreEncryptor keystore.KeyEncryptor
 
 	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(flag.CommandLine, "")
@@ -835,26 +1517,17 @@ func openKeyStoreV1(output string, cacheSize int) (keystore.ServerKeyStore, erro
 	keyStore := filesystem.NewCustomFilesystemKeyStore()
 	keyStore.KeyDirectory(output)
 	keyStore.CacheSize(cacheSize)
+	keyStore.CacheTTL(cacheTTL)
 	keyStore.Encryptor(keyStoreEncryptor)
 
 	redis := cmd.ParseRedisCLIParameters()
 	cmd.ValidateRedisCLIOptions(redis)
 
-	if redis.KeysConfigured() {
-		redisOptions, err := redis.KeysOptions(flag.CommandLine)
-		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
-				Errorln("Can't get Redis options")
-			return nil, err
-		}
-		keyStorage, err := filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, redisOptions.TLSConfig)
-		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
-				Errorln("Can't initialize Redis client")
-			return nil, err
-		}
-		keyStore.Storage(keyStorage)
+	keyStorage, err := openKeyStorage(redis)
+	if err != nil {
+		return nil, err
 	}
+	keyStore.Storage(keyStorage)
 	keyStoreV1, err := keyStore.Build()
 	if err != nil {
 		log.WithError(err).Errorln("Can't init keystore")
@@ -863,6 +1536,98 @@ func openKeyStoreV1(output string, cacheSize int) (keystore.ServerKeyStore, erro
 	return keyStoreV1, nil
 }
 
+// keystoreReplicationFlagsPrefix is the prefix of Redis flags of standby keystore used by --keystore_replication_keys_dir
+const keystoreReplicationFlagsPrefix = "keystore_replication_"
+
+// openKeyStorage returns Redis storage of keystore v1 if it's configured, otherwise keys are stored in filesystem.
+// tlsName is the name of service used to register Redis TLS flags.
+func openKeyStorage(redis *cmd.RedisOptions) (filesystem.Storage, error) {
+	if !redis.KeysConfigured() {
+		return &filesystem.DummyStorage{}, nil
+	}
+	redisOptions, err := redis.KeysOptions(flag.CommandLine)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
+			Errorln("Can't get Redis options")
+		return nil, err
+	}
+	keyStorage, err := filesystem.NewRedisStorage(redisOptions)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
+			Errorln("Can't initialize Redis client")
+		return nil, err
+	}
+	return keyStorage, nil
+}
+
+// newKeysExporter returns exporter of keys from keystore v1 used by keystore API
+func newKeysExporter(keysDir string, keyStore keystore.ServerKeyStore) (keystore.Exporter, error) {
+	if filesystemV2.IsKeyDirectory(keysDir) {
+		return nil, cmd.ErrRemoteKeyStoreNotSupported
+	}
+	keyStorage, err := openKeyStorage(cmd.ParseRedisCLIParameters())
+	if err != nil {
+		return nil, err
+	}
+	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(flag.CommandLine, "")
+	if err != nil {
+		return nil, err
+	}
+	return filesystem.NewKeyBackuper(keysDir, "", keyStorage, keyStoreEncryptor, keyStore)
+}
+
+// newKeysReplicator returns Replicator of keys from keystore v1 to standby keystore location
+func newKeysReplicator(keysDir, standbyKeysDir string) (*replication.Replicator, error) {
+	sourceStorage, err := openKeyStorage(cmd.ParseRedisCLIParameters())
+	if err != nil {
+		return nil, err
+	}
+	standbyRedis := cmd.ParseRedisCLIParametersFromFlags(flag.CommandLine, keystoreReplicationFlagsPrefix)
+	standbyStorage, err := openKeyStorage(standbyRedis)
+	if err != nil {
+		return nil, err
+	}
+	return replication.NewReplicator(
+		replication.Location{Storage: sourceStorage, Dir: keysDir},
+		replication.Location{Storage: standbyStorage, Dir: standbyKeysDir})
+}
+
+// MigrationMasterKeyVarName is environment variable with master key of keystore v2 used by --keystore_migration_mode
+const MigrationMasterKeyVarName = keystore.AcraMasterKeyVarName + "_V2"
+
+// openMigratingKeyStore opens keystore v1 and keystore v2 which are used together during online migration
+func openMigratingKeyStore(keyDirV1, keyDirV2 string, cacheSize int, cacheTTL time.Duration, mode string) (keystore.ServerKeyStore, error) {
+	if filesystemV2.IsKeyDirectory(keyDirV1) {
+		log.WithField("path", keyDirV1).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--keystore_migration_mode requires keystore v1 in --keys_dir")
+		return nil, keystoreV2.ErrInvalidMigrationKeyDirs
+	}
+	if keyDirV2 == "" || !filesystemV2.IsKeyDirectory(keyDirV2) {
+		log.WithField("path", keyDirV2).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--keystore_migration_mode requires keystore v2 in --keystore_migration_v2_keys_dir")
+		return nil, keystoreV2.ErrInvalidMigrationKeyDirs
+	}
+	keyStoreV1, err := openKeyStoreV1(keyDirV1, cacheSize, cacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	// keystore v2 uses master keys of another format, so they are read from separate environment variable
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(MigrationMasterKeyVarName))
+	keyStoreV2, err := openKeyStoreV2(keyDirV2, keystore.WithoutCache)
+	keyloader.RegisterKeyEncryptorFabric(keyloader.KeystoreStrategyEnvMasterKey, env_loader.NewEnvKeyEncryptorFabric(keystore.AcraMasterKeyVarName))
+	if err != nil {
+		return nil, err
+	}
+	keyStore, err := keystoreV2.NewMigratingKeyStore(keyStoreV2.(*keystoreV2.ServerKeyStore), keyStoreV1.(*filesystem.KeyStore), mode)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --keystore_migration_mode")
+		return nil, err
+	}
+	log.WithField("mode", mode).WithField("v2_path", keyDirV2).Infoln("Keystore migration from v1 to v2 enabled")
+	return keyStore, nil
+}
+
 func openKeyStoreV2(keyDirPath string, cacheSize int) (keystore.ServerKeyStore, error) {
 	if cacheSize != keystore.WithoutCache {
 		return nil, keystore.ErrCacheIsNotSupportedV2
@@ -908,3 +1673,28 @@ func openKeyStoreV2(keyDirPath string, cacheSize int) (keystore.ServerKeyStore,
 	}
 	return keystoreV2.NewServerKeyStore(keyDirectory), nil
 }
+
+// buildDiagnosticsAuth validates configuration of diagnostics endpoints of HTTP API and returns their auth
+func buildDiagnosticsAuth(httpAPIEnabled, httpAPIUseTLS bool, basicAuthFile, clientIDs string) (*common.DiagnosticsAuth, error) {
+	if !httpAPIEnabled {
+		return nil, ErrDiagnosticsWithoutHTTPAPI
+	}
+	if basicAuthFile == "" && !httpAPIUseTLS {
+		return nil, ErrDiagnosticsWithoutAuth
+	}
+	auth := &common.DiagnosticsAuth{ClientCertificate: httpAPIUseTLS}
+	if basicAuthFile != "" {
+		username, password, err := common.ReadDiagnosticsCredentials(basicAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.Username = username
+		auth.Password = password
+	}
+	for _, clientID := range strings.Split(clientIDs, ",") {
+		if clientID = strings.TrimSpace(clientID); clientID != "" {
+			auth.ClientIDs = append(auth.ClientIDs, clientID)
+		}
+	}
+	return auth, nil
+}
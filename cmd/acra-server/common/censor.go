@@ -0,0 +1,160 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	acracensor "github.com/cossacklabs/acra/acra-censor"
+)
+
+// ErrCensorNotReloadable returned on reload of AcraCensor configured without config file
+var ErrCensorNotReloadable = errors.New("AcraCensor config is not configured and can't be reloaded")
+
+// CensorStatus describes AcraCensor config in use
+type CensorStatus struct {
+	ConfigPath string `json:"config_path,omitempty"`
+	// Version is value of `version` field of AcraCensor config
+	Version string `json:"version,omitempty"`
+	// checksum of loaded config, reported with checksums of other configs
+	checksum string
+}
+
+// reloadableCensor implements AcraCensorInterface and allows to replace policies of AcraCensor without restart
+type reloadableCensor struct {
+	lock   sync.RWMutex
+	censor *acracensor.AcraCensor
+	status CensorStatus
+}
+
+// newReloadableCensor returns AcraCensor with config loaded from configPath, without policies if path is empty
+func newReloadableCensor(configPath string) (*reloadableCensor, error) {
+	censor, status, err := loadCensor(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadableCensor{censor: censor, status: status}, nil
+}
+
+func loadCensor(configPath string) (*acracensor.AcraCensor, CensorStatus, error) {
+	censor := acracensor.NewAcraCensor()
+	status := CensorStatus{ConfigPath: configPath}
+	if configPath == "" {
+		return censor, status, nil
+	}
+	configuration, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, status, err
+	}
+	if err := censor.LoadConfiguration(configuration); err != nil {
+		censor.ReleaseAll()
+		return nil, status, err
+	}
+	// config already parsed by LoadConfiguration, so only version is taken from it
+	censorConfig := acracensor.Config{}
+	if err := yaml.Unmarshal(configuration, &censorConfig); err != nil {
+		censor.ReleaseAll()
+		return nil, status, err
+	}
+	status.Version = censorConfig.Version
+	status.checksum = configChecksum(configuration)
+	return censor, status, nil
+}
+
+// Reload loads config again and replaces policies if it is valid. Previous policies are left in use on error
+func (censor *reloadableCensor) Reload() error {
+	censor.lock.RLock()
+	configPath := censor.status.ConfigPath
+	censor.lock.RUnlock()
+	if configPath == "" {
+		return ErrCensorNotReloadable
+	}
+	newCensor, status, err := loadCensor(configPath)
+	if err != nil {
+		return err
+	}
+	censor.lock.Lock()
+	oldCensor := censor.censor
+	censor.censor = newCensor
+	censor.status = status
+	censor.lock.Unlock()
+	// queries handled with RLock, so nobody uses old censor here
+	oldCensor.ReleaseAll()
+	return nil
+}
+
+// Status returns description of config in use
+func (censor *reloadableCensor) Status() CensorStatus {
+	censor.lock.RLock()
+	defer censor.lock.RUnlock()
+	return censor.status
+}
+
+// HandleQuery processes query with current policies
+func (censor *reloadableCensor) HandleQuery(sqlQuery string) error {
+	censor.lock.RLock()
+	defer censor.lock.RUnlock()
+	return censor.censor.HandleQuery(sqlQuery)
+}
+
+// HandleBoundQuery processes query of prepared statement with bound values with current policies
+func (censor *reloadableCensor) HandleBoundQuery(sqlQuery string, values []acracensor.BoundValue) error {
+	censor.lock.RLock()
+	defer censor.lock.RUnlock()
+	return censor.censor.HandleBoundQuery(sqlQuery, values)
+}
+
+// HandleQueryWithContext processes query with current policies and logs verdict with logger from ctx
+func (censor *reloadableCensor) HandleQueryWithContext(ctx context.Context, sqlQuery string) error {
+	censor.lock.RLock()
+	defer censor.lock.RUnlock()
+	return censor.censor.HandleQueryWithContext(ctx, sqlQuery)
+}
+
+// HandleBoundQueryWithContext processes query of prepared statement with bound values with current policies and logs
+// verdict with logger from ctx
+func (censor *reloadableCensor) HandleBoundQueryWithContext(ctx context.Context, sqlQuery string, values []acracensor.BoundValue) error {
+	censor.lock.RLock()
+	defer censor.lock.RUnlock()
+	return censor.censor.HandleBoundQueryWithContext(ctx, sqlQuery, values)
+}
+
+// AddHandler adds handler to current policies, it is removed on reload
+func (censor *reloadableCensor) AddHandler(handler acracensor.QueryHandlerInterface) {
+	censor.lock.Lock()
+	defer censor.lock.Unlock()
+	censor.censor.AddHandler(handler)
+}
+
+// RemoveHandler removes handler from current policies
+func (censor *reloadableCensor) RemoveHandler(handler acracensor.QueryHandlerInterface) {
+	censor.lock.Lock()
+	defer censor.lock.Unlock()
+	censor.censor.RemoveHandler(handler)
+}
+
+// ReleaseAll stops all handlers of current policies
+func (censor *reloadableCensor) ReleaseAll() {
+	censor.lock.Lock()
+	defer censor.lock.Unlock()
+	censor.censor.ReleaseAll()
+}
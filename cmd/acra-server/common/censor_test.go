@@ -0,0 +1,62 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadableCensor(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "acra-censor.yaml")
+	denyConfig := []byte(`version: 0.85.0
+handlers:
+  - handler: deny
+    queries:
+      - SELECT * FROM users
+  - handler: allowall
+`)
+	if err := os.WriteFile(configPath, denyConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	censor, err := newReloadableCensor(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer censor.ReleaseAll()
+	if err := censor.HandleQuery("SELECT * FROM users"); err == nil {
+		t.Fatal("Expected denied query")
+	}
+	status := censor.Status()
+	if status.Version != "0.85.0" || status.checksum != configChecksum(denyConfig) {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	if err := os.WriteFile(configPath, []byte("version: 0.85.0\nhandlers:\n  - handler: allowall\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := censor.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := censor.HandleQuery("SELECT * FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid config doesn't replace policies in use
+	if err := os.WriteFile(configPath, []byte("handlers: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := censor.Reload(); err == nil {
+		t.Fatal("Expected error on config without version")
+	}
+	if err := censor.HandleQuery("SELECT * FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	withoutConfig, err := newReloadableCensor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := withoutConfig.Reload(); err != ErrCensorNotReloadable {
+		t.Fatalf("Expected %v, took %v", ErrCensorNotReloadable, err)
+	}
+}
@@ -19,6 +19,7 @@ package common
 import (
 	"context"
 	"net"
+	"sync"
 	"sync/atomic"
 
 	"github.com/cossacklabs/acra/decryptor/base"
@@ -37,6 +38,7 @@ type ClientSession struct {
 	statements     base.PreparedStatementRegistry
 	protocolState  interface{}
 	data           map[string]interface{}
+	closeOnce      sync.Once
 }
 
 var sessionCounter uint32
@@ -94,6 +96,15 @@ func (clientSession *ClientSession) ClientConnection() net.Conn {
 	return clientSession.connection
 }
 
+// ClientAddress returns address of the client, it's taken from PROXY protocol header if it is turned on
+func (clientSession *ClientSession) ClientAddress() net.Addr {
+	if clientSession.connection == nil {
+		return nil
+	}
+	// listener replaces address of connection with address from PROXY protocol header and wrappers keep it
+	return clientSession.connection.RemoteAddr()
+}
+
 // DatabaseConnection returns connection to database.
 // It must be established first by ConnectToDb().
 func (clientSession *ClientSession) DatabaseConnection() net.Conn {
@@ -122,8 +133,16 @@ func (clientSession *ClientSession) SetProtocolState(state interface{}) {
 	clientSession.protocolState = state
 }
 
-// ConnectToDb connects to the database via tcp using Host and Port from config.
+// ConnectToDb connects to the database via tcp using DatabaseUpstream or Host and Port from config.
 func (clientSession *ClientSession) ConnectToDb() error {
+	if upstream := clientSession.config.GetDatabaseUpstream(); upstream != nil {
+		conn, err := upstream.Connect()
+		if err != nil {
+			return err
+		}
+		clientSession.connectionToDb = conn
+		return nil
+	}
 	conn, err := network.Dial(network.BuildConnectionString("tcp", clientSession.config.GetDBHost(), clientSession.config.GetDBPort(), ""))
 	if err != nil {
 		return err
@@ -132,8 +151,12 @@ func (clientSession *ClientSession) ConnectToDb() error {
 	return nil
 }
 
-// Close session connections to AcraConnector and database.
+// Close session connections to AcraConnector and database. Connections closed only once, following calls do nothing
 func (clientSession *ClientSession) Close() {
+	clientSession.closeOnce.Do(clientSession.closeConnections)
+}
+
+func (clientSession *ClientSession) closeConnections() {
 	clientSession.logger.Debugln("Close acra-connector connection")
 
 	err := clientSession.connection.Close()
@@ -17,9 +17,15 @@ limitations under the License.
 package common
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"flag"
-	"io/ioutil"
+	"os"
+	"sync"
+	"time"
 
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/encryptor"
@@ -45,6 +51,8 @@ type Config struct {
 	withAPI                    bool
 	acraConnectionString       string
 	acraAPIConnectionString    string
+	webSocketConnectionString  string
+	webSocketTLSConfig         *tls.Config
 	ConnectionWrapper          network.ConnectionWrapper
 	HTTPAPIConnectionWrapper   network.HTTPServerConnectionWrapper
 	tlsClientIDExtractor       network.TLSClientIDExtractor
@@ -53,14 +61,27 @@ type Config struct {
 	postgresql                 bool
 	debug                      bool
 	censor                     acracensor.AcraCensorInterface
+	reloadableCensor           *reloadableCensor
 	TraceToLog                 bool
 	tableSchema                encryptorConfig.TableSchemaStore
+	reloadableTableSchema      *encryptorConfig.ReloadableTableSchemaStore
+	encryptorConfigLoader      *config_loader.ConfigLoader
+	encryptorConfigData        []byte
+	encryptorConfigLock        sync.RWMutex
 	dataEncryptor              encryptor.DataEncryptor
 	keystore                   keystore.ServerKeyStore
 	traceOptions               []trace.StartOption
 	serviceName                string
 	configPath                 string
 	clientID                   []byte
+	httpAPIToken               []byte
+	httpAPIDiagnostics         *DiagnosticsAuth
+	httpAPIGRPCReflection      bool
+	listenerReusePort          bool
+	dbUpstream                 *DatabaseUpstream
+	proxyProtocolReader        *network.ProxyProtocolReader
+	maxSessions                uint
+	maxClientIDSessions        uint
 }
 
 // NewConfig returns new Config object
@@ -76,12 +97,26 @@ func NewConfig() (*Config, error) {
 // ErrTwoDBSetup shows that AcraServer can connects only to one database at the same time
 var ErrTwoDBSetup = errors.New("only one db supported at one time")
 
+// ErrTableSchemaNotReloadable returned on reload of encryptor config which was not loaded with LoadMapTableSchemaConfig
+var ErrTableSchemaNotReloadable = errors.New("encryptor config is not configured and can't be reloaded")
+
 // SetDBConnectionSettings sets address of the database.
 func (config *Config) SetDBConnectionSettings(host string, port int) {
 	config.dbHost = host
 	config.dbPort = port
 }
 
+// SetDatabaseUpstream sets DatabaseUpstream used to connect to the database instead of address from
+// SetDBConnectionSettings
+func (config *Config) SetDatabaseUpstream(upstream *DatabaseUpstream) {
+	config.dbUpstream = upstream
+}
+
+// GetDatabaseUpstream returns DatabaseUpstream or nil if it is not configured
+func (config *Config) GetDatabaseUpstream() *DatabaseUpstream {
+	return config.dbUpstream
+}
+
 // LoadMapTableSchemaConfig load table schemas from config file
 func (config *Config) LoadMapTableSchemaConfig(storageType string, useMySQL bool) error {
 	encryptorConfigLoader, err := config_loader.NewConfigLoader(storageType, flag.CommandLine, "")
@@ -100,7 +135,58 @@ func (config *Config) LoadMapTableSchemaConfig(storageType string, useMySQL bool
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).WithError(err).Errorln("Can't parse table schemas from config")
 		return err
 	}
-	config.tableSchema = schema
+	reloadableSchema, err := encryptorConfig.NewReloadableTableSchemaStore(schema)
+	if err != nil {
+		return err
+	}
+	config.encryptorConfigLoader = encryptorConfigLoader
+	config.encryptorConfigData = mapConfig
+	config.reloadableTableSchema = reloadableSchema
+	config.tableSchema = reloadableSchema
+	return nil
+}
+
+// ReloadTableSchema reads encryptor config again and replaces table schemas used by AcraServer if new config is valid.
+// Previous schemas are left in use on error
+func (config *Config) ReloadTableSchema() error {
+	if config.reloadableTableSchema == nil || config.encryptorConfigLoader == nil {
+		return ErrTableSchemaNotReloadable
+	}
+	mapConfig, err := config.encryptorConfigLoader.Load()
+	if err != nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).WithError(err).Errorln("Can't read config for encryptor")
+		return err
+	}
+	return config.applyTableSchemaConfig(mapConfig)
+}
+
+// applyTableSchemaConfig replaces table schemas with schemas from mapConfig if it is valid
+func (config *Config) applyTableSchemaConfig(mapConfig []byte) error {
+	if err := config.reloadableTableSchema.ReloadFromConfig(mapConfig, config.mysql); err != nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).WithError(err).Errorln("Can't parse table schemas from config, previous config left in use")
+		return err
+	}
+	config.encryptorConfigLock.Lock()
+	config.encryptorConfigData = mapConfig
+	config.encryptorConfigLock.Unlock()
+	log.Infoln("Encryptor configuration reloaded")
+	return nil
+}
+
+// WatchTableSchema polls encryptor config storage with interval and reloads table schemas when config changes until
+// ctx is done
+func (config *Config) WatchTableSchema(ctx context.Context, interval time.Duration) error {
+	if config.reloadableTableSchema == nil || config.encryptorConfigLoader == nil {
+		return ErrTableSchemaNotReloadable
+	}
+	config.encryptorConfigLock.RLock()
+	loadedConfig := config.encryptorConfigData
+	config.encryptorConfigLock.RUnlock()
+	watcher, err := config_loader.NewConfigWatcher(config.encryptorConfigLoader, interval, loadedConfig, config.applyTableSchemaConfig)
+	if err != nil {
+		return err
+	}
+	go watcher.Run(ctx)
 	return nil
 }
 
@@ -116,20 +202,12 @@ func (config *Config) GetTableSchema() encryptorConfig.TableSchemaStore {
 
 // SetCensor creates AcraCensor and sets its configuration
 func (config *Config) SetCensor(censorConfigPath string) error {
-	censor := acracensor.NewAcraCensor()
-	config.censor = censor
-	//skip if flag not specified
-	if censorConfigPath == "" {
-		return nil
-	}
-	configuration, err := ioutil.ReadFile(censorConfigPath)
-	if err != nil {
-		return err
-	}
-	err = censor.LoadConfiguration(configuration)
+	censor, err := newReloadableCensor(censorConfigPath)
 	if err != nil {
 		return err
 	}
+	config.censor = censor
+	config.reloadableCensor = censor
 	return nil
 }
 
@@ -138,6 +216,56 @@ func (config *Config) GetCensor() acracensor.AcraCensorInterface {
 	return config.censor
 }
 
+// ReloadCensor reads AcraCensor config again and replaces its policies if new config is valid.
+// Previous policies are left in use on error
+func (config *Config) ReloadCensor() error {
+	if config.reloadableCensor == nil {
+		return ErrCensorNotReloadable
+	}
+	if err := config.reloadableCensor.Reload(); err != nil {
+		if err != ErrCensorNotReloadable {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorSetupError).WithError(err).Errorln("Can't reload AcraCensor config, previous config left in use")
+		}
+		return err
+	}
+	log.Infoln("AcraCensor configuration reloaded")
+	return nil
+}
+
+// GetCensorStatus returns description of AcraCensor config in use
+func (config *Config) GetCensorStatus() CensorStatus {
+	if config.reloadableCensor == nil {
+		return CensorStatus{}
+	}
+	return config.reloadableCensor.Status()
+}
+
+// GetConfigChecksums returns SHA-256 checksums of AcraServer config file, encryptor config and AcraCensor config in use.
+// Only configured ones are returned
+func (config *Config) GetConfigChecksums() map[string]string {
+	checksums := make(map[string]string, 3)
+	if config.configPath != "" {
+		if data, err := os.ReadFile(config.configPath); err == nil {
+			checksums["acra_server_config"] = configChecksum(data)
+		}
+	}
+	config.encryptorConfigLock.RLock()
+	if config.encryptorConfigData != nil {
+		checksums["encryptor_config"] = configChecksum(config.encryptorConfigData)
+	}
+	config.encryptorConfigLock.RUnlock()
+	if checksum := config.GetCensorStatus().checksum; checksum != "" {
+		checksums["acra_censor_config"] = checksum
+	}
+	return checksums
+}
+
+// configChecksum returns hex encoded SHA-256 of config
+func configChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // UseMySQL returns if AcraServer should connect to MySQL database
 func (config *Config) UseMySQL() bool {
 	return config.mysql
@@ -171,6 +299,16 @@ func (config *Config) SetAcraAPIConnectionString(str string) {
 	config.acraAPIConnectionString = str
 }
 
+// SetWebSocketConnectionString sets connection string of WebSocket tunnel listener, empty string disables it
+func (config *Config) SetWebSocketConnectionString(str string) {
+	config.webSocketConnectionString = str
+}
+
+// SetWebSocketTLSConfig sets TLS config used by WebSocket tunnel listener with wss scheme
+func (config *Config) SetWebSocketTLSConfig(tlsConfig *tls.Config) {
+	config.webSocketTLSConfig = tlsConfig
+}
+
 // SetDetectPoisonRecords sets if AcraServer should detect Poison records
 func (config *Config) SetDetectPoisonRecords(val bool) {
 	config.detectPoisonRecords = val
@@ -201,6 +339,37 @@ func (config *Config) GetEnableHTTPAPI() bool {
 	return config.withAPI
 }
 
+// SetListenerReusePort sets if AcraServer should listen TCP addresses with SO_REUSEPORT option
+func (config *Config) SetListenerReusePort(reusePort bool) {
+	config.listenerReusePort = reusePort
+}
+
+// GetListenerReusePort returns if AcraServer should listen TCP addresses with SO_REUSEPORT option
+func (config *Config) GetListenerReusePort() bool {
+	return config.listenerReusePort
+}
+
+// SetProxyProtocolReader sets reader of PROXY protocol header of database connections, nil turns it off
+func (config *Config) SetProxyProtocolReader(reader *network.ProxyProtocolReader) {
+	config.proxyProtocolReader = reader
+}
+
+// GetProxyProtocolReader returns reader of PROXY protocol header or nil if it is turned off
+func (config *Config) GetProxyProtocolReader() *network.ProxyProtocolReader {
+	return config.proxyProtocolReader
+}
+
+// SetSessionLimits sets maximum number of simultaneous client sessions in total and per clientID, 0 means unlimited
+func (config *Config) SetSessionLimits(maxSessions, maxClientIDSessions uint) {
+	config.maxSessions = maxSessions
+	config.maxClientIDSessions = maxClientIDSessions
+}
+
+// GetSessionLimits returns maximum number of simultaneous client sessions in total and per clientID
+func (config *Config) GetSessionLimits() (uint, uint) {
+	return config.maxSessions, config.maxClientIDSessions
+}
+
 // GetDBHost returns AcraServer database host
 func (config *Config) GetDBHost() string {
 	return config.dbHost
@@ -216,6 +385,36 @@ func (config *Config) SetConfigPath(path string) {
 	config.configPath = path
 }
 
+// SetHTTPAPIToken sets token required in requests to HTTP API, empty token turns off authentication
+func (config *Config) SetHTTPAPIToken(token []byte) {
+	config.httpAPIToken = token
+}
+
+// GetHTTPAPIToken returns token required in requests to HTTP API
+func (config *Config) GetHTTPAPIToken() []byte {
+	return config.httpAPIToken
+}
+
+// SetHTTPAPIGRPCReflection turns on gRPC server reflection service of HTTP API listener
+func (config *Config) SetHTTPAPIGRPCReflection(enable bool) {
+	config.httpAPIGRPCReflection = enable
+}
+
+// GetHTTPAPIGRPCReflection returns true if gRPC server reflection service of HTTP API listener is turned on
+func (config *Config) GetHTTPAPIGRPCReflection() bool {
+	return config.httpAPIGRPCReflection
+}
+
+// SetHTTPAPIDiagnostics turns on diagnostics endpoints of HTTP API accessible with auth
+func (config *Config) SetHTTPAPIDiagnostics(auth *DiagnosticsAuth) {
+	config.httpAPIDiagnostics = auth
+}
+
+// GetHTTPAPIDiagnostics returns auth of diagnostics endpoints of HTTP API or nil if they are turned off
+func (config *Config) GetHTTPAPIDiagnostics() *DiagnosticsAuth {
+	return config.httpAPIDiagnostics
+}
+
 // GetConfigPath returns AcraServer config path
 func (config *Config) GetConfigPath() string {
 	return config.configPath
@@ -231,6 +430,16 @@ func (config *Config) GetAcraAPIConnectionString() string {
 	return config.acraAPIConnectionString
 }
 
+// GetWebSocketConnectionString returns connection string of WebSocket tunnel listener
+func (config *Config) GetWebSocketConnectionString() string {
+	return config.webSocketConnectionString
+}
+
+// GetWebSocketTLSConfig returns TLS config used by WebSocket tunnel listener with wss scheme
+func (config *Config) GetWebSocketTLSConfig() *tls.Config {
+	return config.webSocketTLSConfig
+}
+
 // SetKeyStore sets keystore.
 func (config *Config) SetKeyStore(k keystore.ServerKeyStore) {
 	config.keystore = k
@@ -0,0 +1,216 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+const (
+	// dbDialTimeout limits time of establishing connection to the database endpoint, so unavailable endpoint doesn't
+	// block failover to the next one
+	dbDialTimeout = time.Second * 5
+	// dbMinBackoff and dbMaxBackoff limit delay before unavailable endpoint is tried again
+	dbMinBackoff = time.Second
+	dbMaxBackoff = time.Minute
+)
+
+// ErrNoDatabaseAddresses returned if DatabaseUpstream created without addresses
+var ErrNoDatabaseAddresses = errors.New("no database addresses configured")
+
+var dbUpstreamAvailableGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "acraserver_db_upstream_available",
+	Help: "availability of database endpoint: 1 - available, 0 - unavailable",
+}, []string{"address"})
+
+// ParseDatabaseAddresses returns address of primary database followed by comma separated <host>[:<port>] addresses
+// of secondary databases. port is used for addresses without port
+func ParseDatabaseAddresses(host string, port int, failoverHosts string) ([]string, error) {
+	addresses := []string{net.JoinHostPort(host, strconv.Itoa(port))}
+	for _, value := range strings.Split(failoverHosts, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		failoverHost, failoverPort, err := net.SplitHostPort(value)
+		if err != nil {
+			// value without port
+			failoverHost, failoverPort = strings.Trim(value, "[]"), strconv.Itoa(port)
+		}
+		if failoverHost == "" {
+			return nil, fmt.Errorf("invalid database address %q", value)
+		}
+		if _, err := strconv.ParseUint(failoverPort, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port of database address %q", value)
+		}
+		addresses = append(addresses, net.JoinHostPort(failoverHost, failoverPort))
+	}
+	return addresses, nil
+}
+
+type dbEndpoint struct {
+	address  string
+	healthy  bool
+	failures int
+	// retryAt is time after which unhealthy endpoint may be used again
+	retryAt time.Time
+}
+
+// DatabaseUpstream connects to the first available database endpoint in order of priority. Unavailable endpoints are
+// skipped and tried again with exponential backoff, optionally checked in background
+type DatabaseUpstream struct {
+	lock          sync.Mutex
+	endpoints     []*dbEndpoint
+	checkInterval time.Duration
+	dial          func(address string, timeout time.Duration) (net.Conn, error)
+	now           func() time.Time
+}
+
+// NewDatabaseUpstream returns DatabaseUpstream with endpoints in order of priority. checkInterval is interval of
+// background health checks, 0 turns them off
+func NewDatabaseUpstream(addresses []string, checkInterval time.Duration) (*DatabaseUpstream, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoDatabaseAddresses
+	}
+	endpoints := make([]*dbEndpoint, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, &dbEndpoint{address: address, healthy: true})
+		dbUpstreamAvailableGauge.WithLabelValues(address).Set(1)
+	}
+	return &DatabaseUpstream{
+		endpoints:     endpoints,
+		checkInterval: checkInterval,
+		dial: func(address string, timeout time.Duration) (net.Conn, error) {
+			return network.DialWithTimeout("tcp://"+address, timeout)
+		},
+		now: time.Now,
+	}, nil
+}
+
+// Connect returns connection to the first available endpoint. If all endpoints are unavailable, all of them are tried
+func (upstream *DatabaseUpstream) Connect() (net.Conn, error) {
+	var lastErr error
+	for _, endpoint := range upstream.candidates() {
+		conn, err := upstream.dial(endpoint.address, dbDialTimeout)
+		if err != nil {
+			upstream.markFailed(endpoint, err)
+			lastErr = err
+			continue
+		}
+		upstream.markHealthy(endpoint)
+		return conn, nil
+	}
+	return nil, lastErr
+}
+
+// candidates returns endpoints available for connection in order of priority
+func (upstream *DatabaseUpstream) candidates() []*dbEndpoint {
+	upstream.lock.Lock()
+	defer upstream.lock.Unlock()
+	now := upstream.now()
+	candidates := make([]*dbEndpoint, 0, len(upstream.endpoints))
+	for _, endpoint := range upstream.endpoints {
+		if endpoint.healthy || !now.Before(endpoint.retryAt) {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		// better to try unavailable endpoints than fail without attempt
+		candidates = append(candidates, upstream.endpoints...)
+	}
+	return candidates
+}
+
+func (upstream *DatabaseUpstream) markFailed(endpoint *dbEndpoint, err error) {
+	upstream.lock.Lock()
+	defer upstream.lock.Unlock()
+	endpoint.failures++
+	backoff := dbMaxBackoff
+	// limit shift to not overflow
+	if endpoint.failures <= 16 {
+		if delay := dbMinBackoff << (endpoint.failures - 1); delay < dbMaxBackoff {
+			backoff = delay
+		}
+	}
+	endpoint.retryAt = upstream.now().Add(backoff)
+	if endpoint.healthy {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantConnectToDB).
+			WithField("address", endpoint.address).Warningln("Database endpoint is unavailable")
+	}
+	endpoint.healthy = false
+	dbUpstreamAvailableGauge.WithLabelValues(endpoint.address).Set(0)
+}
+
+func (upstream *DatabaseUpstream) markHealthy(endpoint *dbEndpoint) {
+	upstream.lock.Lock()
+	defer upstream.lock.Unlock()
+	if !endpoint.healthy {
+		log.WithField("address", endpoint.address).Infoln("Database endpoint is available again")
+	}
+	endpoint.healthy = true
+	endpoint.failures = 0
+	dbUpstreamAvailableGauge.WithLabelValues(endpoint.address).Set(1)
+}
+
+// Run checks availability of endpoints every checkInterval until ctx is done. Should be called as goroutine
+func (upstream *DatabaseUpstream) Run(ctx context.Context) {
+	if upstream.checkInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(upstream.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			upstream.check()
+		}
+	}
+}
+
+// check opens and closes TCP connection to healthy endpoints and unhealthy ones which backoff elapsed
+func (upstream *DatabaseUpstream) check() {
+	for _, endpoint := range upstream.endpoints {
+		upstream.lock.Lock()
+		skip := !endpoint.healthy && upstream.now().Before(endpoint.retryAt)
+		upstream.lock.Unlock()
+		if skip {
+			continue
+		}
+		conn, err := upstream.dial(endpoint.address, dbDialTimeout)
+		if err != nil {
+			upstream.markFailed(endpoint, err)
+			continue
+		}
+		conn.Close()
+		upstream.markHealthy(endpoint)
+	}
+}
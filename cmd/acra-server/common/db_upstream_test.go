@@ -0,0 +1,83 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDatabaseAddresses(t *testing.T) {
+	addresses, err := ParseDatabaseAddresses("primary", 5432, "secondary, third:5433,[::1]:5434,::2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"primary:5432", "secondary:5432", "third:5433", "[::1]:5434", "[::2]:5432"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("Expected %v, took %v", expected, addresses)
+	}
+	for _, invalid := range []string{"host:port", ":5432", "host:70000"} {
+		if _, err := ParseDatabaseAddresses("primary", 5432, invalid); err == nil {
+			t.Fatalf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestDatabaseUpstreamFailover(t *testing.T) {
+	upstream, err := NewDatabaseUpstream([]string{"primary:5432", "secondary:5432"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	upstream.now = func() time.Time { return now }
+	available := map[string]bool{"primary:5432": false, "secondary:5432": true}
+	var dialed []string
+	upstream.dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if !available[address] {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	connect := func(expected ...string) {
+		dialed = nil
+		conn, err := upstream.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if !reflect.DeepEqual(dialed, expected) {
+			t.Fatalf("Expected dialed %v, took %v", expected, dialed)
+		}
+	}
+	connect("primary:5432", "secondary:5432")
+	// primary skipped until backoff elapsed
+	connect("secondary:5432")
+	now = now.Add(dbMinBackoff)
+	connect("primary:5432", "secondary:5432")
+	if retryAt := upstream.endpoints[0].retryAt; !retryAt.Equal(now.Add(dbMinBackoff * 2)) {
+		t.Fatalf("Expected exponential backoff, took retry at %v", retryAt)
+	}
+
+	available["primary:5432"] = true
+	now = now.Add(dbMaxBackoff)
+	upstream.check()
+	if !upstream.endpoints[0].healthy || upstream.endpoints[0].failures != 0 {
+		t.Fatal("Expected healthy primary after check")
+	}
+	connect("primary:5432")
+
+	// all endpoints unavailable are still tried
+	available["primary:5432"], available["secondary:5432"] = false, false
+	upstream.check()
+	dialed = nil
+	if _, err := upstream.Connect(); err == nil {
+		t.Fatal("Expected error without available endpoints")
+	}
+	if len(dialed) != 2 {
+		t.Fatalf("Expected tried all endpoints, took %v", dialed)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2022, Cossack Labs Limited
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// DiagnosticsPathPrefix is prefix of paths of diagnostics endpoints of HTTP API
+const DiagnosticsPathPrefix = "/debug"
+
+// ErrInvalidDiagnosticsCredentials returned for file with credentials of diagnostics endpoints in wrong format
+var ErrInvalidDiagnosticsCredentials = errors.New("credentials should be in format <username>:<password>")
+
+// DiagnosticsAuth defines who may access diagnostics endpoints: clients with valid basic auth credentials or clients
+// with verified TLS certificate which clientID is allowed. At least one of them should be configured
+type DiagnosticsAuth struct {
+	Username string
+	Password []byte
+	// ClientCertificate allows requests over TLS with verified client's certificate
+	ClientCertificate bool
+	// ClientIDs limits clientIDs of certificates allowed to access diagnostics, empty list allows all verified clients
+	ClientIDs []string
+}
+
+// ReadDiagnosticsCredentials reads basic auth credentials in format <username>:<password> from file
+func ReadDiagnosticsCredentials(path string) (string, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	username, password, found := bytes.Cut(bytes.TrimSpace(data), []byte(":"))
+	if !found || len(username) == 0 || len(password) == 0 {
+		return "", nil, ErrInvalidDiagnosticsCredentials
+	}
+	return string(username), password, nil
+}
+
+// WithDiagnostics returns option that turns on pprof, runtime metrics and goroutine dumps under /debug protected
+// with auth
+func WithDiagnostics(auth DiagnosticsAuth) HTTPAPIServerOption {
+	return func(api *APICore) {
+		api.diagnostics = &auth
+	}
+}
+
+// RuntimeStats is a response of /debug/runtime endpoint
+type RuntimeStats struct {
+	GoVersion    string `json:"go_version"`
+	Goroutines   int    `json:"goroutines"`
+	CPUs         int    `json:"cpus"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	CgoCalls     int64  `json:"cgo_calls"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapSys      uint64 `json:"heap_sys_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"gc_count"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+	LastGC       string `json:"gc_last,omitempty"`
+}
+
+// initDiagnostics registers diagnostics endpoints protected with diagnostics auth
+func (apiServer *HTTPAPIServer) initDiagnostics(engine *gin.Engine) {
+	group := engine.Group(DiagnosticsPathPrefix, diagnosticsAuthMiddleware(apiServer.api.diagnostics))
+	group.GET("/runtime", runtimeStatsGin)
+	group.GET("/goroutines", goroutinesGin)
+	group.GET("/pprof/", gin.WrapF(pprof.Index))
+	group.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	group.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+	group.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	// pprof.Index serves named profiles like heap, goroutine, block and mutex
+	group.GET("/pprof/:profile", gin.WrapF(pprof.Index))
+}
+
+func runtimeStatsGin(ctx *gin.Context) {
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+	stats := RuntimeStats{
+		GoVersion:    runtime.Version(),
+		Goroutines:   runtime.NumGoroutine(),
+		CPUs:         runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		CgoCalls:     runtime.NumCgoCall(),
+		HeapAlloc:    memStats.HeapAlloc,
+		HeapSys:      memStats.HeapSys,
+		HeapObjects:  memStats.HeapObjects,
+		StackInuse:   memStats.StackInuse,
+		Sys:          memStats.Sys,
+		NumGC:        memStats.NumGC,
+		PauseTotalNs: memStats.PauseTotalNs,
+	}
+	if memStats.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(memStats.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	ctx.JSON(http.StatusOK, stats)
+}
+
+// goroutinesGin writes stacks of all goroutines in the same format as unrecovered panic
+func goroutinesGin(ctx *gin.Context) {
+	ctx.Header("Content-Type", "text/plain; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	if err := runtimepprof.Lookup("goroutine").WriteTo(ctx.Writer, 2); err != nil {
+		ginGetLogger(ctx).WithError(err).Errorln("Can't write goroutine dump")
+	}
+}
+
+// diagnosticsAuthMiddleware rejects requests without valid basic auth credentials or allowed client's certificate
+func diagnosticsAuthMiddleware(auth *DiagnosticsAuth) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if isDiagnosticsAllowed(ctx, auth) {
+			ctx.Next()
+			return
+		}
+		ginGetLogger(ctx).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).
+			Warningln("Rejected request to diagnostics endpoint without valid credentials")
+		if auth.Username != "" {
+			ctx.Header("WWW-Authenticate", `Basic realm="acra-server diagnostics"`)
+		}
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+func isDiagnosticsAllowed(ctx *gin.Context, auth *DiagnosticsAuth) bool {
+	if auth.Username != "" {
+		if username, password, ok := ctx.Request.BasicAuth(); ok {
+			usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(auth.Username))
+			passwordMatch := subtle.ConstantTimeCompare([]byte(password), auth.Password)
+			if usernameMatch&passwordMatch == 1 {
+				return true
+			}
+		}
+	}
+	if !auth.ClientCertificate {
+		return false
+	}
+	// clientID may be static for connections without certificate, so require certificate verified by TLS handshake
+	if network.GetPeerCertificateFromConnection(network.GetConnectionFromHTTPContext(ctx.Request.Context())) == nil {
+		return false
+	}
+	if len(auth.ClientIDs) == 0 {
+		return true
+	}
+	clientID := ginGetClientID(ctx)
+	for _, allowed := range auth.ClientIDs {
+		if subtle.ConstantTimeCompare(clientID, []byte(allowed)) == 1 {
+			return true
+		}
+	}
+	return false
+}
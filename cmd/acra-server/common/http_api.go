@@ -16,10 +16,13 @@ package common
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"io/ioutil"
 	stdlog "log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +33,15 @@ import (
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"go.opencensus.io/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -40,18 +52,76 @@ const (
 	clientIDKey = "clientID"
 )
 
-// HTTPAPIServer handles all HTTP api logic
+// HTTPAPIServer handles all HTTP api logic. gRPC health checking and optional reflection services are served on the
+// same listener for HTTP/2 requests with gRPC content type and require the same token as HTTP requests
 type HTTPAPIServer struct {
-	ctx        context.Context
-	api        APICore
-	httpServer *http.Server
+	ctx          context.Context
+	api          APICore
+	engine       *gin.Engine
+	httpServer   *http.Server
+	grpcServer   *grpc.Server
+	healthServer *health.Server
 }
 
 // APICore contains the API logic of the HTTP API server
 // Is used to decouple the API logic from actual HTTP setting routine
 // In the future could be used to abstract HTTP setting up from API configuring
 type APICore struct {
-	keystore keystore.ServerKeyStore
+	keystore       keystore.ServerKeyStore
+	schemaReloader TableSchemaReloader
+	controller     ServerController
+	censorReloader CensorReloader
+	authToken      []byte
+	diagnostics    *DiagnosticsAuth
+	grpcReflection bool
+}
+
+// TableSchemaReloader reloads encryptor config used by AcraServer without restart
+type TableSchemaReloader interface {
+	ReloadTableSchema() error
+}
+
+// CensorReloader reloads AcraCensor config used by AcraServer without restart
+type CensorReloader interface {
+	ReloadCensor() error
+}
+
+// ServerController reports runtime status of AcraServer and stops accepting database connections before shutdown
+type ServerController interface {
+	Status() ServerStatus
+	Drain()
+}
+
+// HTTPAPIServerOption configures optional features of HTTP API
+type HTTPAPIServerOption func(api *APICore)
+
+// WithServerController returns option that turns on /status and /drainConnections endpoints
+func WithServerController(controller ServerController) HTTPAPIServerOption {
+	return func(api *APICore) {
+		api.controller = controller
+	}
+}
+
+// WithCensorReloader returns option that turns on /reloadCensor endpoint
+func WithCensorReloader(reloader CensorReloader) HTTPAPIServerOption {
+	return func(api *APICore) {
+		api.censorReloader = reloader
+	}
+}
+
+// WithAuthToken returns option that requires token in `Authorization: Bearer <token>` header of all requests.
+// Empty token turns off authentication
+func WithAuthToken(token []byte) HTTPAPIServerOption {
+	return func(api *APICore) {
+		api.authToken = token
+	}
+}
+
+// WithGRPCReflection returns option that turns on gRPC server reflection service
+func WithGRPCReflection() HTTPAPIServerOption {
+	return func(api *APICore) {
+		api.grpcReflection = true
+	}
 }
 
 // ConnectionContextCallback is callback that is called to map context for
@@ -63,20 +133,27 @@ type ConnectionContextCallback func(ctx context.Context, c net.Conn) context.Con
 // NewHTTPAPIServer creates new AcraAPIServer
 // The arguments:
 // - keystore is a keystore operated by the Acra
+// - schemaReloader reloads encryptor config on API request
 // - traceOn controls the tracing. Often provided from the config.
 // - traceOptions - options for the tracer. Often provided from the config.
 // - tlsIDExtractor is used to extract IDs from the TLS connection
 // - connCtxCallback is a callback for setting context for each connection
+// - options turn on admin endpoints and authentication
 func NewHTTPAPIServer(
 	ctx context.Context,
 	keystore keystore.ServerKeyStore,
+	schemaReloader TableSchemaReloader,
 	traceOn bool,
 	traceOptions []trace.StartOption,
 	tlsIDExtractor network.TLSClientIDExtractor,
 	connCtxCallback ConnectionContextCallback,
+	options ...HTTPAPIServerOption,
 ) HTTPAPIServer {
 	gin.SetMode(gin.ReleaseMode)
-	api := NewAPICore(ctx, keystore)
+	api := NewAPICore(ctx, keystore, schemaReloader)
+	for _, option := range options {
+		option(&api)
+	}
 
 	engine := gin.New()
 	engine.
@@ -88,16 +165,21 @@ func NewHTTPAPIServer(
 
 	engine.HandleMethodNotAllowed = true
 
+	grpcServer, healthServer := newGRPCHealthServer(api.authToken, api.grpcReflection)
 	apiServer := HTTPAPIServer{
-		ctx:        ctx,
-		api:        api,
-		httpServer: nil,
+		ctx:          ctx,
+		api:          api,
+		engine:       engine,
+		httpServer:   nil,
+		grpcServer:   grpcServer,
+		healthServer: healthServer,
 	}
 
 	apiServer.InitEngine(engine)
 
 	httpServer := &http.Server{
-		Handler:      engine,
+		// h2c allows gRPC clients to use HTTP/2 without TLS negotiation of protocol
+		Handler:      h2c.NewHandler(grpcHandler(grpcServer, engine), &http2.Server{}),
 		ReadTimeout:  network.DefaultNetworkTimeout,
 		WriteTimeout: network.DefaultNetworkTimeout,
 		ConnContext:  connCtxCallback,
@@ -116,6 +198,9 @@ func (apiServer *HTTPAPIServer) Start(listener net.Listener, group *sync.WaitGro
 	go func() {
 		defer group.Done()
 		<-apiServer.ctx.Done()
+		// report NOT_SERVING to health checks while connections are closing
+		apiServer.healthServer.Shutdown()
+		defer apiServer.grpcServer.Stop()
 		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(network.DefaultNetworkTimeout))
 		defer cancel()
 		if err := apiServer.httpServer.Shutdown(ctx); err != nil {
@@ -132,15 +217,94 @@ func (apiServer *HTTPAPIServer) Start(listener net.Listener, group *sync.WaitGro
 	return err
 }
 
+// newGRPCHealthServer returns gRPC server with standard health checking service and optional reflection service.
+// Non-empty token is required in authorization metadata of all requests
+func newGRPCHealthServer(token []byte, reflectionEnable bool) (*grpc.Server, *health.Server) {
+	var options []grpc.ServerOption
+	if len(token) > 0 {
+		options = append(options, grpc.UnaryInterceptor(grpcAuthTokenUnaryInterceptor(token)),
+			grpc.StreamInterceptor(grpcAuthTokenStreamInterceptor(token)))
+	}
+	grpcServer := grpc.NewServer(options...)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if reflectionEnable {
+		reflection.Register(grpcServer)
+	}
+	return grpcServer, healthServer
+}
+
+// checkGRPCAuthToken returns Unauthenticated error if incoming metadata doesn't have token in `authorization: Bearer <token>`
+func checkGRPCAuthToken(ctx context.Context, token []byte, method string) error {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	requestToken, err := network.GetBearerToken(authorization)
+	if err != nil || subtle.ConstantTimeCompare([]byte(requestToken), token) != 1 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).WithField("method", method).
+			Warningln("Rejected gRPC request to HTTP API without valid token")
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return nil
+}
+
+// grpcAuthTokenUnaryInterceptor rejects unary gRPC requests without token like authTokenMiddleware
+func grpcAuthTokenUnaryInterceptor(token []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkGRPCAuthToken(ctx, token, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcAuthTokenStreamInterceptor rejects streaming gRPC requests without token like authTokenMiddleware
+func grpcAuthTokenStreamInterceptor(token []byte) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkGRPCAuthToken(stream.Context(), token, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// grpcHandler passes gRPC requests to grpcServer and others to httpHandler
+func grpcHandler(grpcServer *grpc.Server, httpHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		httpHandler.ServeHTTP(w, r)
+	})
+}
+
 // NewAPICore creates new APICore
-func NewAPICore(ctx context.Context, keystore keystore.ServerKeyStore) APICore {
-	return APICore{keystore}
+func NewAPICore(ctx context.Context, keystore keystore.ServerKeyStore, schemaReloader TableSchemaReloader) APICore {
+	return APICore{keystore: keystore, schemaReloader: schemaReloader}
 }
 
 // InitEngine configures all path handlers for the API
 func (apiServer *HTTPAPIServer) InitEngine(engine *gin.Engine) {
-	engine.GET("/resetKeyStorage", apiServer.resetKeyStorageGin)
-	engine.NoRoute(respondWithError)
+	// diagnostics endpoints use own authentication with Authorization header, so token protects all other paths
+	var authHandlers []gin.HandlerFunc
+	if len(apiServer.api.authToken) > 0 {
+		authHandlers = append(authHandlers, authTokenMiddleware(apiServer.api.authToken))
+	}
+	admin := engine.Group("/", authHandlers...)
+	admin.GET("/resetKeyStorage", apiServer.resetKeyStorageGin)
+	admin.GET("/reloadEncryptorConfig", apiServer.reloadEncryptorConfigGin)
+	admin.GET("/reloadCensor", apiServer.reloadCensorGin)
+	admin.GET("/status", apiServer.statusGin)
+	admin.GET("/drainConnections", apiServer.drainConnectionsGin)
+	if apiServer.api.diagnostics != nil {
+		apiServer.initDiagnostics(engine)
+	}
+	engine.NoRoute(append(authHandlers, respondWithError)...)
+	engine.NoMethod(authHandlers...)
 }
 
 func (api *APICore) resetKeyStorage() {
@@ -155,6 +319,88 @@ func (apiServer *HTTPAPIServer) resetKeyStorageGin(ctx *gin.Context) {
 	ctx.String(http.StatusOK, "")
 }
 
+func (api *APICore) reloadEncryptorConfig() error {
+	if api.schemaReloader == nil {
+		return ErrTableSchemaNotReloadable
+	}
+	return api.schemaReloader.ReloadTableSchema()
+}
+
+func (apiServer *HTTPAPIServer) reloadEncryptorConfigGin(ctx *gin.Context) {
+	logger := ginGetLogger(ctx)
+
+	if err := apiServer.api.reloadEncryptorConfig(); err != nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).WithError(err).
+			Errorln("Can't reload encryptor config")
+		if errors.Is(err, ErrTableSchemaNotReloadable) {
+			ctx.String(http.StatusBadRequest, errorRequestMessage)
+			return
+		}
+		ctx.String(http.StatusInternalServerError, "can't reload encryptor config")
+		return
+	}
+	logger.Infoln("Reloaded encryptor config")
+	ctx.String(http.StatusOK, "")
+}
+
+func (api *APICore) reloadCensor() error {
+	if api.censorReloader == nil {
+		return ErrCensorNotReloadable
+	}
+	return api.censorReloader.ReloadCensor()
+}
+
+func (apiServer *HTTPAPIServer) reloadCensorGin(ctx *gin.Context) {
+	logger := ginGetLogger(ctx)
+
+	if err := apiServer.api.reloadCensor(); err != nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorSetupError).WithError(err).
+			Errorln("Can't reload AcraCensor config")
+		if errors.Is(err, ErrCensorNotReloadable) {
+			ctx.String(http.StatusBadRequest, errorRequestMessage)
+			return
+		}
+		ctx.String(http.StatusInternalServerError, "can't reload AcraCensor config")
+		return
+	}
+	logger.Infoln("Reloaded AcraCensor config")
+	ctx.String(http.StatusOK, "")
+}
+
+func (apiServer *HTTPAPIServer) statusGin(ctx *gin.Context) {
+	if apiServer.api.controller == nil {
+		respondWithError(ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, apiServer.api.controller.Status())
+}
+
+func (apiServer *HTTPAPIServer) drainConnectionsGin(ctx *gin.Context) {
+	logger := ginGetLogger(ctx)
+
+	if apiServer.api.controller == nil {
+		respondWithError(ctx)
+		return
+	}
+	apiServer.api.controller.Drain()
+	logger.Infoln("Draining database connections")
+	ctx.String(http.StatusOK, "")
+}
+
+// authTokenMiddleware rejects requests without token in Authorization header
+func authTokenMiddleware(token []byte) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestToken, err := network.GetBearerToken(ctx.GetHeader("Authorization"))
+		if err != nil || subtle.ConstantTimeCompare([]byte(requestToken), token) != 1 {
+			ginGetLogger(ctx).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Warningln("Rejected HTTP API request without valid token")
+			ctx.Header("WWW-Authenticate", "Bearer")
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		ctx.Next()
+	}
+}
+
 func respondWithError(ctx *gin.Context) {
 	ctx.String(http.StatusNotFound, errorRequestMessage)
 }
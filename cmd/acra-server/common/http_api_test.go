@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
@@ -21,6 +22,12 @@ import (
 	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/network/testutils"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func getListener(connWrapper network.HTTPServerConnectionWrapper, t *testing.T) net.Listener {
@@ -61,6 +68,7 @@ func runWithServer(t *testing.T, keyStorage keystore.ServerKeyStore, tlsWrapper
 	apiServer := NewHTTPAPIServer(
 		ctx,
 		sserver.config.GetKeyStore(),
+		sserver.config,
 		sserver.config.TraceToLog,
 		sserver.config.GetTraceOptions(),
 		sserver.config.GetTLSClientIDExtractor(),
@@ -122,6 +130,26 @@ func TestPlainHTTPAPI(t *testing.T) {
 			keyStorage.AssertCalled(t, "Reset")
 		})
 
+		t.Run("Test /reloadEncryptorConfig without encryptor config", func(t *testing.T) {
+			response, err := http.Get(fmt.Sprintf("http://%s/reloadEncryptorConfig", url))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sc := response.StatusCode; sc != http.StatusBadRequest {
+				t.Fatalf("status code (%d) != %d", sc, http.StatusBadRequest)
+			}
+
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(body, []byte(errorRequestMessage)) {
+				t.Fatalf("expected body %q, but found %q", errorRequestMessage, body)
+			}
+		})
+
 		t.Run("Test non-existed", func(t *testing.T) {
 			response, err := http.Get(fmt.Sprintf("http://%s/GloryToUkraine", url))
 			if err != nil {
@@ -156,6 +184,23 @@ func TestPlainHTTPAPI(t *testing.T) {
 				t.Fatalf("expected %q, but found %q", expectedError, err)
 			}
 		})
+
+		t.Run("gRPC health check", func(t *testing.T) {
+			conn, err := grpc.Dial(url, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Fatalf("expected SERVING status, but found %s", response.Status)
+			}
+		})
 	})
 }
 
@@ -336,6 +381,7 @@ func testClientID(
 	apiServer := NewHTTPAPIServer(
 		ctx,
 		config.GetKeyStore(),
+		config,
 		config.TraceToLog,
 		config.GetTraceOptions(),
 		config.GetTLSClientIDExtractor(),
@@ -343,7 +389,7 @@ func testClientID(
 	)
 
 	// inject endpoint for retrieving the client id
-	apiServer.httpServer.Handler.(*gin.Engine).GET("/client_id", func(ctx *gin.Context) {
+	apiServer.engine.GET("/client_id", func(ctx *gin.Context) {
 		clientID := ginGetClientID(ctx)
 		ctx.JSON(http.StatusOK, clientID)
 	})
@@ -390,3 +436,155 @@ func testClientID(
 		t.Fatal("Timeout fired")
 	}
 }
+
+type testServerController struct {
+	drained bool
+}
+
+func (controller *testServerController) Status() ServerStatus {
+	return ServerStatus{Draining: controller.drained, Sessions: map[string]int{"client": 2}}
+}
+
+func (controller *testServerController) Drain() {
+	controller.drained = true
+}
+
+func TestAdminHTTPAPI(t *testing.T) {
+	controller := &testServerController{}
+	apiServer := NewHTTPAPIServer(context.Background(), &mocks.ServerKeyStore{}, nil, false, nil, nil, nil,
+		WithServerController(controller), WithAuthToken([]byte("token")))
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		httpRequest := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			httpRequest.Header.Set("Authorization", authorization)
+		}
+		apiServer.httpServer.Handler.ServeHTTP(recorder, httpRequest)
+		return recorder
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "Basic dG9rZW4="} {
+		if response := request("/status", authorization); response.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %d for %q, but found %d", http.StatusUnauthorized, authorization, response.Code)
+		}
+	}
+
+	response := request("/status", "Bearer token")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status code (%d) != %d", response.Code, http.StatusOK)
+	}
+	status := ServerStatus{}
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Draining || status.Sessions["client"] != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if response := request("/drainConnections", "Bearer token"); response.Code != http.StatusOK {
+		t.Fatalf("status code (%d) != %d", response.Code, http.StatusOK)
+	}
+	if !controller.drained {
+		t.Fatal("expected drained server")
+	}
+
+	if response := request("/reloadCensor", "Bearer token"); response.Code != http.StatusBadRequest {
+		t.Fatalf("status code (%d) != %d", response.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDiagnosticsHTTPAPI(t *testing.T) {
+	apiServer := NewHTTPAPIServer(context.Background(), &mocks.ServerKeyStore{}, nil, false, nil, nil, nil,
+		WithAuthToken([]byte("token")), WithDiagnostics(DiagnosticsAuth{Username: "admin", Password: []byte("secret")}))
+	request := func(path string, setAuth func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		httpRequest := httptest.NewRequest(http.MethodGet, path, nil)
+		setAuth(httpRequest)
+		apiServer.httpServer.Handler.ServeHTTP(recorder, httpRequest)
+		return recorder
+	}
+	noAuth := func(*http.Request) {}
+	token := func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }
+	wrongPassword := func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }
+	validPassword := func(r *http.Request) { r.SetBasicAuth("admin", "secret") }
+
+	for _, path := range []string{"/debug/runtime", "/debug/goroutines", "/debug/pprof/"} {
+		for _, setAuth := range []func(*http.Request){noAuth, token, wrongPassword} {
+			if response := request(path, setAuth); response.Code != http.StatusUnauthorized {
+				t.Fatalf("expected %d for %s, but found %d", http.StatusUnauthorized, path, response.Code)
+			}
+		}
+		if response := request(path, validPassword); response.Code != http.StatusOK {
+			t.Fatalf("status code (%d) != %d for %s", response.Code, http.StatusOK, path)
+		}
+	}
+
+	response := request("/debug/runtime", validPassword)
+	stats := RuntimeStats{}
+	if err := json.Unmarshal(response.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines == 0 || stats.GoVersion == "" || stats.HeapAlloc == 0 {
+		t.Fatalf("unexpected runtime stats %+v", stats)
+	}
+	if response := request("/debug/goroutines", validPassword); !strings.Contains(response.Body.String(), "goroutine") {
+		t.Fatal("expected goroutine dump")
+	}
+
+	// basic auth credentials don't replace token of admin endpoints
+	if response := request("/status", validPassword); response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, but found %d", http.StatusUnauthorized, response.Code)
+	}
+	if response := request("/unknown", noAuth); response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, but found %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestGRPCHealthAuthToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	apiServer := NewHTTPAPIServer(ctx, &mocks.ServerKeyStore{}, nil, false, nil, nil, nil, WithAuthToken([]byte("token")))
+	if _, ok := apiServer.grpcServer.GetServiceInfo()["grpc.reflection.v1alpha.ServerReflection"]; ok {
+		t.Fatal("expected reflection service turned off by default")
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	wait := sync.WaitGroup{}
+	go apiServer.Start(listener, &wait)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	for _, authorization := range []string{"", "Bearer wrong", "Basic dG9rZW4="} {
+		requestCtx, requestCancel := context.WithTimeout(context.Background(), time.Second)
+		if authorization != "" {
+			requestCtx = metadata.AppendToOutgoingContext(requestCtx, "authorization", authorization)
+		}
+		_, err := client.Check(requestCtx, &healthpb.HealthCheckRequest{})
+		requestCancel()
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected Unauthenticated for %q, but found %v", authorization, err)
+		}
+	}
+	requestCtx, requestCancel := context.WithTimeout(context.Background(), time.Second)
+	defer requestCancel()
+	requestCtx = metadata.AppendToOutgoingContext(requestCtx, "authorization", "Bearer token")
+	response, err := client.Check(requestCtx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING status, but found %s", response.Status)
+	}
+
+	reflectionServer := NewHTTPAPIServer(ctx, &mocks.ServerKeyStore{}, nil, false, nil, nil, nil, WithGRPCReflection())
+	if _, ok := reflectionServer.grpcServer.GetServiceInfo()["grpc.reflection.v1alpha.ServerReflection"]; !ok {
+		t.Fatal("expected registered reflection service")
+	}
+}
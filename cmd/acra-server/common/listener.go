@@ -65,6 +65,7 @@ type SServer struct {
 	config                *Config
 	listenerACRA          net.Listener
 	listenerAPI           net.Listener
+	listenerWebSocket     net.Listener
 	connectionManager     *network.ConnectionManager
 	listeners             []net.Listener
 	errorSignalChannel    chan os.Signal
@@ -74,6 +75,9 @@ type SServer struct {
 	stopListenersSignal   chan bool
 	errCh                 chan error
 	lock                  sync.RWMutex
+	sessions              *sessionRegistry
+	// draining is 1 after Drain call, accessed atomically
+	draining int32
 }
 
 // ErrWaitTimeout error indicates that server was shutdown and waited N seconds while shutting down all connections.
@@ -163,8 +167,17 @@ func (server *SServer) handleClientSession(clientID []byte, clientSession *Clien
 	sessionLogger.Infof("Handle client's connection")
 	proxyErrCh := make(chan base.ProxyError)
 
+	registeredSession, err := server.sessions.add(clientID, clientSession.Close)
+	if err != nil {
+		sessionLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeSessionLimitExceeded).
+			Warningln("Rejected client's connection")
+		server.rejectConnection(clientSession.ClientConnection(), err, sessionLogger)
+		return
+	}
+	defer server.sessions.remove(registeredSession)
+
 	sessionLogger.Debugf("Connecting to db")
-	err := clientSession.ConnectToDb()
+	err = clientSession.ConnectToDb()
 	if err != nil {
 		sessionLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantConnectToDB).
 			Errorln("Can't connect to db")
@@ -185,7 +198,9 @@ func (server *SServer) handleClientSession(clientID []byte, clientSession *Clien
 	accessContext := base.NewAccessContext(base.WithClientID(clientID))
 	// subscribe on clientID changes after switching connection to TLS and using ClientID from TLS certificates
 	proxy.AddClientIDObserver(accessContext)
+	proxy.AddClientIDObserver(registeredSession)
 	clientSession.ctx = base.SetAccessContextToContext(clientSession.ctx, accessContext)
+	clientSession.ctx = base.SetSessionActivityObserverToContext(clientSession.ctx, registeredSession)
 
 	// We launch two goroutines to serve the client and db-side asynchronously.
 	// Synchronous processing, like:
@@ -232,6 +247,19 @@ func (server *SServer) handleClientSession(clientID []byte, clientSession *Clien
 	sessionLogger.Infoln("Finished processing client's connection")
 }
 
+// rejectConnection sends error of database protocol with reason to the client if proxy supports it and closes connection
+func (server *SServer) rejectConnection(connection net.Conn, reason error, logger *log.Entry) {
+	if rejecter, ok := server.proxyFactory.(base.ConnectionRejecter); ok {
+		if err := rejecter.RejectConnection(connection, reason); err != nil {
+			logger.WithError(err).Debugln("Can't send error to the client")
+		}
+	}
+	if err := connection.Close(); err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnection).
+			Errorln("Can't close connection")
+	}
+}
+
 func (server *SServer) processConnection(parentContext context.Context, connection net.Conn, callback *callbackData) {
 	connectionCounter.WithLabelValues(callback.connectionType).Inc()
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(connectionProcessingTimeHistogram.WithLabelValues(callback.connectionType).Observe))
@@ -242,6 +270,23 @@ func (server *SServer) processConnection(parentContext context.Context, connecti
 	wrapCtx, wrapSpan := trace.StartSpan(ctx, "WrapServer", server.config.GetTraceOptions()...)
 	logger := logging.NewLoggerWithTrace(wrapCtx)
 
+	if proxyProtocolReader := server.config.GetProxyProtocolReader(); proxyProtocolReader != nil && callback.connectionType == dbConnectionType {
+		proxiedConnection, err := proxyProtocolReader.ReadHeader(connection)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantWrapConnection).
+				WithField("remote_address", connection.RemoteAddr().String()).Errorln("Can't read PROXY protocol header")
+			if closeErr := connection.Close(); closeErr != nil {
+				logger.WithError(closeErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnection).
+					Errorln("Can't close connection")
+			}
+			wrapSpan.End()
+			return
+		}
+		connection = proxiedConnection
+		logger = logger.WithField("client_address", connection.RemoteAddr().String())
+		logger.Debugln("Read client's address from PROXY protocol header")
+	}
+
 	wrappedConnection, clientID, err := server.config.ConnectionWrapper.WrapServer(wrapCtx, connection)
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantWrapConnection).
@@ -276,6 +321,10 @@ func (server *SServer) start(parentContext context.Context, listener net.Listene
 
 		connection, err := listener.Accept()
 		if err != nil {
+			if server.IsDraining() {
+				logger.Infoln("Stop listening connections due to draining")
+				return
+			}
 			select {
 			case <-server.stopListenersSignal:
 				// situation when listener is stopped while accepting.
@@ -331,11 +380,19 @@ func (server *SServer) waitForExitTimeout() {
 	}
 }
 
+// listen returns listener for connection string with SO_REUSEPORT option if it's turned on
+func (server *SServer) listen(connectionString string) (net.Listener, error) {
+	if server.config.GetListenerReusePort() {
+		return network.ListenWithReusePort(connectionString)
+	}
+	return network.Listen(connectionString)
+}
+
 // Start listening connections from proxy
 func (server *SServer) Start(parentContext context.Context) {
 	logger := log.WithFields(log.Fields{"connection_string": server.config.GetAcraConnectionString(), "from_descriptor": false})
 	logger.Infoln("Create listener")
-	var listener, err = network.Listen(server.config.GetAcraConnectionString())
+	var listener, err = server.listen(server.config.GetAcraConnectionString())
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartListenConnections).
 			Errorln("Can't start listen connections")
@@ -347,6 +404,24 @@ func (server *SServer) Start(parentContext context.Context) {
 	server.run(parentContext, listener, &callbackData{funcName: "handleConnection", connectionType: dbConnectionType, callbackFunc: server.handleConnection}, logger)
 }
 
+// StartWebSocket starts listening database connections tunneled over WebSocket.
+func (server *SServer) StartWebSocket(parentContext context.Context) {
+	logger := log.WithFields(log.Fields{"connection_string": server.config.GetWebSocketConnectionString(), "from_descriptor": false})
+	logger.Infoln("Create WebSocket tunnel listener")
+	listener, err := network.ListenWebSocket(server.config.GetWebSocketConnectionString(), server.config.GetWebSocketTLSConfig())
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartListenConnections).
+			Errorln("Can't start listen WebSocket tunnel connections")
+		server.errorSignalChannel <- syscall.SIGTERM
+		return
+	}
+	server.lock.Lock()
+	server.listenerWebSocket = listener
+	server.lock.Unlock()
+	server.addListener(listener)
+	server.run(parentContext, listener, &callbackData{funcName: "handleConnection", connectionType: dbConnectionType, callbackFunc: server.handleConnection}, logger)
+}
+
 // StartFromFileDescriptor starts listening Acra data connections from file descriptor.
 func (server *SServer) StartFromFileDescriptor(parentContext context.Context, fd uintptr) {
 	logger := log.WithFields(log.Fields{"connection_string": server.config.GetAcraConnectionString(), "from_descriptor": true})
@@ -413,6 +488,12 @@ func (server *SServer) StopListeners() {
 			log.WithError(err).Warningln("Can't set deadline for listener")
 		}
 	}
+	// WebSocket listener can't be passed to forked process by file descriptor, so it releases the port for it
+	if server.listenerWebSocket != nil {
+		if err = server.listenerWebSocket.Close(); err != nil {
+			log.WithError(err).Warningln("Can't close WebSocket listener")
+		}
+	}
 	server.lock.RUnlock()
 }
 
@@ -447,7 +528,7 @@ func (server *SServer) ConnectionsCounter() int {
 // StartCommands starts listening commands connections from proxy.
 func (server *SServer) StartCommands(parentContext context.Context) {
 	logger := log.WithFields(log.Fields{"connection_string": server.config.GetAcraAPIConnectionString(), "from_descriptor": false})
-	var listener, err = network.Listen(server.config.GetAcraAPIConnectionString())
+	var listener, err = server.listen(server.config.GetAcraAPIConnectionString())
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartListenConnections).
 			Errorln("Can't start listen command API connections")
@@ -465,13 +546,26 @@ func (server *SServer) runCommands(ctx context.Context, listener net.Listener, l
 	defer server.waitForExitTimeout()
 
 	connContextCallback := server.config.HTTPAPIConnectionWrapper.OnConnectionContext
+	options := []HTTPAPIServerOption{
+		WithServerController(server),
+		WithCensorReloader(server.config),
+		WithAuthToken(server.config.GetHTTPAPIToken()),
+	}
+	if diagnostics := server.config.GetHTTPAPIDiagnostics(); diagnostics != nil {
+		options = append(options, WithDiagnostics(*diagnostics))
+	}
+	if server.config.GetHTTPAPIGRPCReflection() {
+		options = append(options, WithGRPCReflection())
+	}
 	apiServer := NewHTTPAPIServer(
 		ctx,
 		server.config.GetKeyStore(),
+		server.config,
 		server.config.TraceToLog,
 		server.config.GetTraceOptions(),
 		server.config.GetTLSClientIDExtractor(),
 		connContextCallback,
+		options...,
 	)
 	err := apiServer.Start(listener, &server.backgroundWorkersSync)
 	if err != nil {
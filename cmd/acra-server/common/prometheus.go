@@ -23,12 +23,19 @@ import (
 
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	kmsBase "github.com/cossacklabs/acra/keystore/kms/base"
+	"github.com/cossacklabs/acra/keystore/replication"
+	"github.com/cossacklabs/acra/keystore/rotation"
 	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/poison"
 	"github.com/cossacklabs/acra/utils"
 )
 
 const (
 	connectionTypeLabel = "connection_type"
+	clientIDLabel       = "client_id"
+	reasonLabel         = "reason"
 	apiConnectionType   = "api"
 	dbConnectionType    = "db"
 )
@@ -45,6 +52,16 @@ var (
 		Help:    "Time of connection processing",
 		Buckets: []float64{0.1, 0.2, 0.5, 1, 10, 60, 3600, 86400},
 	}, []string{connectionTypeLabel})
+
+	activeSessionsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "acraserver_active_sessions",
+		Help: "number of active client sessions per clientID",
+	}, []string{clientIDLabel})
+
+	rejectedSessionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "acraserver_rejected_sessions_total",
+		Help: "number of client sessions rejected due to limits of simultaneous sessions",
+	}, []string{reasonLabel})
 )
 
 var registerLock = sync.Once{}
@@ -54,9 +71,19 @@ func RegisterMetrics(serviceName string, version *utils.Version, edition utils.P
 	registerLock.Do(func() {
 		prometheus.MustRegister(connectionCounter)
 		prometheus.MustRegister(connectionProcessingTimeHistogram)
+		prometheus.MustRegister(dbUpstreamAvailableGauge)
+		prometheus.MustRegister(activeSessionsGauge)
+		prometheus.MustRegister(rejectedSessionsCounter)
 		base.RegisterAcraStructProcessingMetrics()
 		base.RegisterEncryptionDecryptionProcessingMetrics()
 		base.RegisterTokenizationProcessingMetrics()
+		poison.RegisterPoisonRecordMetrics()
+		kmsBase.RegisterCacheMetrics()
+		keystore.RegisterKeyUsageMetrics()
+		rotation.RegisterMetrics()
+		replication.RegisterMetrics()
+		network.RegisterOCSPMetrics()
+		network.RegisterCRLMetrics()
 		base.RegisterDbProcessingMetrics()
 		cmd.RegisterVersionMetrics(serviceName, version)
 		cmd.RegisterBuildInfoMetrics(serviceName, edition)
@@ -19,6 +19,8 @@ var ErrShutdownAttemptWithoutExit = errors.New("unexpected way to shutdown servi
 
 // NewEEAcraServerMainComponent creates new SServer wrapper
 func NewEEAcraServerMainComponent(config *Config, proxyFactory base.ProxyFactory, errorChan chan os.Signal, restartChan chan os.Signal) (*SServer, error) {
+	sessions := newSessionRegistry()
+	sessions.setLimits(config.GetSessionLimits())
 	return &SServer{
 		config:                config,
 		connectionManager:     network.NewConnectionManager(),
@@ -27,6 +29,7 @@ func NewEEAcraServerMainComponent(config *Config, proxyFactory base.ProxyFactory
 		proxyFactory:          proxyFactory,
 		stopListenersSignal:   make(chan bool),
 		errCh:                 make(chan error),
+		sessions:              sessions,
 	}, nil
 }
 
@@ -51,6 +54,7 @@ func (server *SServer) StartServer(parentContext context.Context, group *sync.Wa
 		defer group.Done()
 		server.Start(parentContext)
 	}()
+	server.startWebSocket(parentContext, group)
 
 	// here we block execution until global context is done.
 	// Start and StartCommands also blocks on it so we are in sync when shutdown occurs
@@ -73,6 +77,8 @@ func (server *SServer) StartServerFromFileDescriptor(parentContext context.Conte
 		defer group.Done()
 		server.StartFromFileDescriptor(parentContext, fdAcra)
 	}()
+	// WebSocket listener isn't inherited from the parent process and listens again
+	server.startWebSocket(parentContext, group)
 
 	// here we block execution until global context is done.
 	// StartFromFileDescriptor and StartCommandsFromFileDescriptor also blocks on it so we are in sync when shutdown occurs
@@ -81,6 +87,18 @@ func (server *SServer) StartServerFromFileDescriptor(parentContext context.Conte
 	return server.checkShutdownViaExitFunc()
 }
 
+// startWebSocket starts WebSocket tunnel listener if it is configured
+func (server *SServer) startWebSocket(parentContext context.Context, group *sync.WaitGroup) {
+	if server.config.GetWebSocketConnectionString() == "" {
+		return
+	}
+	group.Add(1)
+	go func() {
+		defer group.Done()
+		server.StartWebSocket(parentContext)
+	}()
+}
+
 func (server *SServer) checkShutdownViaExitFunc() error {
 	// server has been stopped by global `cancel`. Here we check if some errors occurred. If so, it will exit with non-zero code
 	select {
@@ -0,0 +1,273 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+)
+
+// ServerStatus is runtime status of AcraServer returned by HTTP API
+type ServerStatus struct {
+	// Draining is true if AcraServer stopped accepting new database connections
+	Draining bool `json:"draining"`
+	// Sessions is number of active database sessions per clientID
+	Sessions map[string]int `json:"sessions"`
+	// KeystoreCache is nil if keystore doesn't report usage of cache
+	KeystoreCache *keystore.CacheStats `json:"keystore_cache,omitempty"`
+	Censor        CensorStatus         `json:"censor"`
+	// ConfigChecksums are hex encoded SHA-256 checksums of configs in use
+	ConfigChecksums map[string]string `json:"config_checksums"`
+}
+
+// Reasons of rejected sessions used as metric labels
+const (
+	sessionLimitReasonTotal    = "total_limit"
+	sessionLimitReasonClientID = "client_id_limit"
+)
+
+// sessionRegistry tracks active client sessions, their clientIDs and activity used to close idle sessions while
+// AcraServer drains connections, and limits number of simultaneous sessions
+type sessionRegistry struct {
+	lock     sync.Mutex
+	sessions map[*trackedSession]struct{}
+	// clientIDSessions is number of sessions per clientID
+	clientIDSessions map[string]int
+	// maxSessions and maxClientIDSessions are limits of sessions in total and per clientID, 0 means unlimited
+	maxSessions         uint
+	maxClientIDSessions uint
+	draining            bool
+	// drained closed when registry is draining and all sessions finished
+	drained chan struct{}
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions:         make(map[*trackedSession]struct{}),
+		clientIDSessions: make(map[string]int),
+		drained:          make(chan struct{}),
+	}
+}
+
+// setLimits sets maximum number of simultaneous sessions in total and per clientID, 0 means unlimited
+func (registry *sessionRegistry) setLimits(maxSessions, maxClientIDSessions uint) {
+	registry.lock.Lock()
+	registry.maxSessions = maxSessions
+	registry.maxClientIDSessions = maxClientIDSessions
+	registry.lock.Unlock()
+}
+
+// clientIDLimitReached returns true if clientID has maximum number of sessions, should be called with acquired lock
+func (registry *sessionRegistry) clientIDLimitReached(clientID string) bool {
+	return registry.maxClientIDSessions > 0 && uint(registry.clientIDSessions[clientID]) >= registry.maxClientIDSessions
+}
+
+// addClientIDSession counts session of clientID, should be called with acquired lock
+func (registry *sessionRegistry) addClientIDSession(clientID string) {
+	registry.clientIDSessions[clientID]++
+	activeSessionsGauge.WithLabelValues(clientID).Inc()
+}
+
+// removeClientIDSession uncounts session of clientID, should be called with acquired lock
+func (registry *sessionRegistry) removeClientIDSession(clientID string) {
+	registry.clientIDSessions[clientID]--
+	if registry.clientIDSessions[clientID] > 0 {
+		activeSessionsGauge.WithLabelValues(clientID).Dec()
+		return
+	}
+	delete(registry.clientIDSessions, clientID)
+	activeSessionsGauge.DeleteLabelValues(clientID)
+}
+
+// trackedSession follows clientID changes of session, like switching to clientID from TLS certificate, and its
+// activity reported by proxy
+type trackedSession struct {
+	registry *sessionRegistry
+	clientID string
+	// idle is true if session is out of transaction and waits for client's request
+	idle  bool
+	close func()
+}
+
+// OnNewClientID updates clientID of session and closes session if new clientID already has maximum number of sessions
+func (session *trackedSession) OnNewClientID(clientID []byte) {
+	registry := session.registry
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if session.clientID == string(clientID) {
+		return
+	}
+	registry.removeClientIDSession(session.clientID)
+	limitReached := registry.clientIDLimitReached(string(clientID))
+	session.clientID = string(clientID)
+	registry.addClientIDSession(session.clientID)
+	if limitReached {
+		rejectedSessionsCounter.WithLabelValues(sessionLimitReasonClientID).Inc()
+		log.WithField("client_id", session.clientID).WithField(logging.FieldKeyEventCode, logging.EventCodeSessionLimitExceeded).
+			Warningln("Close session, clientID has maximum number of simultaneous sessions")
+		session.close()
+	}
+}
+
+// OnSessionBusy marks session as processing client's request
+func (session *trackedSession) OnSessionBusy() {
+	session.registry.lock.Lock()
+	session.idle = false
+	session.registry.lock.Unlock()
+}
+
+// OnSessionIdle marks session as idle and closes it if AcraServer drains connections
+func (session *trackedSession) OnSessionIdle() {
+	session.registry.lock.Lock()
+	defer session.registry.lock.Unlock()
+	session.idle = true
+	if session.registry.draining {
+		session.close()
+	}
+}
+
+// add registers session, close should interrupt it when session is idle while draining. Returns
+// base.ErrTooManySessions if limit of sessions in total or per clientID is reached
+func (registry *sessionRegistry) add(clientID []byte, close func()) (*trackedSession, error) {
+	session := &trackedSession{registry: registry, clientID: string(clientID), close: close}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registry.maxSessions > 0 && uint(len(registry.sessions)) >= registry.maxSessions {
+		rejectedSessionsCounter.WithLabelValues(sessionLimitReasonTotal).Inc()
+		return nil, base.ErrTooManySessions
+	}
+	if registry.clientIDLimitReached(session.clientID) {
+		rejectedSessionsCounter.WithLabelValues(sessionLimitReasonClientID).Inc()
+		return nil, fmt.Errorf("%w of clientID", base.ErrTooManySessions)
+	}
+	registry.sessions[session] = struct{}{}
+	registry.addClientIDSession(session.clientID)
+	return session, nil
+}
+
+func (registry *sessionRegistry) remove(session *trackedSession) {
+	registry.lock.Lock()
+	delete(registry.sessions, session)
+	registry.removeClientIDSession(session.clientID)
+	if registry.draining && len(registry.sessions) == 0 {
+		registry.closeDrained()
+	}
+	registry.lock.Unlock()
+}
+
+// drain closes idle sessions and closes the rest when they become idle
+func (registry *sessionRegistry) drain() {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registry.draining {
+		return
+	}
+	registry.draining = true
+	for session := range registry.sessions {
+		if session.idle {
+			session.close()
+		}
+	}
+	if len(registry.sessions) == 0 {
+		registry.closeDrained()
+	}
+}
+
+// closeDrained signals that all sessions finished, should be called with acquired lock
+func (registry *sessionRegistry) closeDrained() {
+	select {
+	case <-registry.drained:
+	default:
+		close(registry.drained)
+	}
+}
+
+// perClientID returns number of sessions per clientID
+func (registry *sessionRegistry) perClientID() map[string]int {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	counts := make(map[string]int, len(registry.clientIDSessions))
+	for clientID, count := range registry.clientIDSessions {
+		counts[clientID] = count
+	}
+	return counts
+}
+
+// Status returns runtime status of AcraServer
+func (server *SServer) Status() ServerStatus {
+	status := ServerStatus{
+		Draining:        server.IsDraining(),
+		Sessions:        server.sessions.perClientID(),
+		Censor:          server.config.GetCensorStatus(),
+		ConfigChecksums: server.config.GetConfigChecksums(),
+	}
+	if provider, ok := server.config.GetKeyStore().(keystore.CacheStatsProvider); ok {
+		stats := provider.CacheStats()
+		status.KeystoreCache = &stats
+	}
+	return status
+}
+
+// IsDraining returns true if AcraServer stopped accepting new database connections
+func (server *SServer) IsDraining() bool {
+	return atomic.LoadInt32(&server.draining) == 1
+}
+
+// Drain stops accepting new database connections and closes sessions when they are idle, out of transaction and not
+// processing client's requests, so clients may reconnect to another instance without interruption of transactions.
+// Sessions which don't report their activity are processed until clients close them
+func (server *SServer) Drain() {
+	if !atomic.CompareAndSwapInt32(&server.draining, 0, 1) {
+		return
+	}
+	server.lock.RLock()
+	listeners := []net.Listener{server.listenerACRA, server.listenerWebSocket}
+	server.lock.RUnlock()
+	for _, listener := range listeners {
+		if listener == nil {
+			continue
+		}
+		if err := listener.Close(); err != nil {
+			log.WithError(err).Warningln("Can't close listener of database connections")
+		}
+	}
+	server.sessions.drain()
+	log.Infoln("Stopped accepting new database connections, waiting for active sessions to become idle")
+}
+
+// DrainWithTimeout drains connections and waits until all sessions are closed. Returns ErrWaitTimeout if some sessions
+// are still active after timeout
+func (server *SServer) DrainWithTimeout(timeout time.Duration) error {
+	server.Drain()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-server.sessions.drained:
+		return nil
+	case <-timer.C:
+		return ErrWaitTimeout
+	}
+}
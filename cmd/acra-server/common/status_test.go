@@ -0,0 +1,105 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestSessionRegistryDrain(t *testing.T) {
+	registry := newSessionRegistry()
+	closed := map[string]int{}
+	newSession := func(clientID string) *trackedSession {
+		session, err := registry.add([]byte(clientID), func() { closed[clientID]++ })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+	idle := newSession("idle")
+	idle.OnSessionIdle()
+	inTransaction := newSession("in_transaction")
+	inTransaction.OnSessionIdle()
+	inTransaction.OnSessionBusy()
+	silent := newSession("silent")
+
+	registry.drain()
+	if closed["idle"] != 1 || closed["in_transaction"] != 0 || closed["silent"] != 0 {
+		t.Fatalf("Expected closed only idle session, took %v", closed)
+	}
+	registry.remove(idle)
+
+	inTransaction.OnSessionIdle()
+	if closed["in_transaction"] != 1 {
+		t.Fatal("Expected closed session after it became idle")
+	}
+	registry.remove(inTransaction)
+	select {
+	case <-registry.drained:
+		t.Fatal("Registry drained with active session")
+	default:
+	}
+
+	registry.remove(silent)
+	select {
+	case <-registry.drained:
+	default:
+		t.Fatal("Expected drained registry without sessions")
+	}
+	// second drain doesn't close channel twice
+	registry.drain()
+}
+
+func TestSessionRegistryDrainWithoutSessions(t *testing.T) {
+	registry := newSessionRegistry()
+	registry.drain()
+	select {
+	case <-registry.drained:
+	default:
+		t.Fatal("Expected drained registry without sessions")
+	}
+}
+
+func TestSessionRegistryLimits(t *testing.T) {
+	registry := newSessionRegistry()
+	registry.setLimits(3, 2)
+	closed := map[string]int{}
+	add := func(clientID string) (*trackedSession, error) {
+		return registry.add([]byte(clientID), func() { closed[clientID]++ })
+	}
+	first, err := add("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = add("client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = add("client"); !errors.Is(err, base.ErrTooManySessions) {
+		t.Fatalf("Expected %v for clientID limit, took %v", base.ErrTooManySessions, err)
+	}
+	other, err := add("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = add("another"); !errors.Is(err, base.ErrTooManySessions) {
+		t.Fatalf("Expected %v for total limit, took %v", base.ErrTooManySessions, err)
+	}
+	if counts := registry.perClientID(); counts["client"] != 2 || counts["other"] != 1 || len(counts) != 2 {
+		t.Fatalf("Unexpected counts of sessions %v", counts)
+	}
+
+	// session which switched to clientID with maximum number of sessions is closed
+	other.OnNewClientID([]byte("client"))
+	if closed["other"] != 1 {
+		t.Fatal("Expected closed session after switching to clientID with maximum number of sessions")
+	}
+	registry.remove(other)
+	registry.remove(first)
+	if _, err = add("another"); err != nil {
+		t.Fatal(err)
+	}
+	if counts := registry.perClientID(); counts["client"] != 1 || counts["another"] != 1 || len(counts) != 2 {
+		t.Fatalf("Unexpected counts of sessions %v", counts)
+	}
+}
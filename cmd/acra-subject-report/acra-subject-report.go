@@ -0,0 +1,186 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraSubjectReport utility. AcraSubjectReport automates data subject access
+// requests: it queries tables described in config through AcraServer, which decrypts and detokenizes data
+// for the connected clientID, and exports subject's rows as JSON or CSV. Each generated report is recorded
+// into audit log file without subject's data.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"database/sql"
+	"flag"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Constants used by AcraSubjectReport
+var (
+	// defaultConfigPath relative path to config which will be parsed as default
+	defaultConfigPath = utils.GetConfigPathByName("acra-subject-report")
+	serviceName       = "acra-subject-report"
+)
+
+func main() {
+	clientID := flag.String("client_id", "", "ClientID which AcraServer uses for the connection, recorded into audit log")
+	connectionString := flag.String("connection_string", "", "Connection string to AcraServer for PostgreSQL(postgresql://{user}:{password}@{host}:{port}/{dbname}?sslmode={sslmode}), MySQL ({user}:{password}@tcp({host}:{port})/{dbname}). May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+	dbTLSEnabled := flag.Bool("tls_database_enabled", false, "Enable TLS for connection to AcraServer")
+	subjectConfigFile := flag.String("subject_config_file", "", "Path to YAML file with tables, columns and lookup columns of subject's data")
+	subjectKeys := flag.String("subject_keys", "", "Comma separated list of subject keys in format name=value, for example user_id=42,email=user@example.com")
+	format := flag.String("format", FormatJSON, "Format of report: <json|csv>")
+	outputFile := flag.String("output_file", "", "File to write report into. Report is written into stdout if empty")
+	auditLogFile := flag.String("audit_log_file", "acra-subject-report.audit.log", "File to append audit records of generated reports")
+
+	network.RegisterTLSArgsForService(flag.CommandLine, true, "", network.DatabaseNameConstructorFunc())
+	network.RegisterTLSBaseArgs(flag.CommandLine)
+	logging.SetLogLevel(logging.LogVerbose)
+	cmd.RegisterSecretParameters("connection_string")
+
+	err := cmd.Parse(defaultConfigPath, serviceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
+			Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	twoDrivers := *useMysql && *usePostgresql
+	noDrivers := !(*useMysql || *usePostgresql)
+	if twoDrivers || noDrivers {
+		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+		os.Exit(1)
+	}
+	if *connectionString == "" {
+		log.Errorln("Connection_string arg is missing")
+		os.Exit(1)
+	}
+	cmd.ValidateClientID(*clientID)
+	if *format != FormatJSON && *format != FormatCSV {
+		log.WithField("format", *format).Errorln("Unsupported report format")
+		os.Exit(1)
+	}
+	if *auditLogFile == "" {
+		log.Errorln("Audit_log_file arg is missing")
+		os.Exit(1)
+	}
+
+	subjectConfig, err := LoadConfig(*subjectConfigFile)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load subject config")
+		os.Exit(1)
+	}
+	keys, err := ParseSubjectKeys(*subjectKeys, subjectConfig)
+	if err != nil {
+		log.WithError(err).Errorln("Invalid subject keys")
+		os.Exit(1)
+	}
+
+	var dbTLSConfig *tls.Config
+	if *dbTLSEnabled {
+		host, err := network.GetDriverConnectionStringHost(*connectionString, *useMysql)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to get DB host from connection URL")
+			os.Exit(1)
+		}
+		dbTLSConfig, err = network.NewTLSConfigByName(flag.CommandLine, "", host, network.DatabaseNameConstructorFunc())
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't create database TLS config")
+			os.Exit(1)
+		}
+	}
+
+	var db *sql.DB
+	if *useMysql {
+		config, err := mysql.ParseDSN(*connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
+			os.Exit(1)
+		}
+		if dbTLSConfig != nil {
+			tlsConfigName := "custom"
+			if err := mysql.RegisterTLSConfig(tlsConfigName, dbTLSConfig); err != nil {
+				log.WithError(err).Errorln("Failed to register TLS config")
+				os.Exit(1)
+			}
+			config.TLSConfig = tlsConfigName
+		}
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			log.WithError(err).Errorln("Can't initialize MySQL connector")
+			os.Exit(1)
+		}
+		db = sql.OpenDB(connector)
+	} else {
+		config, err := pgx.ParseConfig(*connectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse config ")
+			os.Exit(1)
+		}
+		if dbTLSConfig != nil {
+			config.TLSConfig = dbTLSConfig
+		}
+		db = stdlib.OpenDB(*config)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		log.WithError(err).Errorln("Can't connect to db")
+		os.Exit(1)
+	}
+
+	report, err := BuildReport(db, subjectConfig, *clientID, keys, *useMysql)
+	if err != nil {
+		log.WithError(err).Errorln("Can't collect subject's data")
+		os.Exit(1)
+	}
+	var output bytes.Buffer
+	if err := WriteReport(report, *format, &output); err != nil {
+		log.WithError(err).Errorln("Can't format report")
+		os.Exit(1)
+	}
+	outputName := *outputFile
+	if outputName == "" {
+		outputName = "stdout"
+	}
+	// record audit before export to not leave reports without audit records
+	record := NewAuditRecord(report, keys, *format, outputName, output.Bytes())
+	if err := AppendAuditRecord(*auditLogFile, record); err != nil {
+		log.WithError(err).Errorln("Can't write audit record")
+		os.Exit(1)
+	}
+	if *outputFile == "" {
+		_, err = os.Stdout.Write(output.Bytes())
+	} else {
+		err = os.WriteFile(*outputFile, output.Bytes(), 0600)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Can't write report")
+		os.Exit(1)
+	}
+	log.WithField("tables", record.Tables).WithField("report_sha256", record.ReportSHA256).Infoln("Subject report generated")
+}
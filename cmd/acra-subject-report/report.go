@@ -0,0 +1,375 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Supported report formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Errors returned while loading configuration and building report
+var (
+	ErrEmptyTables        = errors.New("subject report config should contain at least one table")
+	ErrInvalidIdentifier  = errors.New("invalid table or column name")
+	ErrEmptyColumns       = errors.New("table should have at least one column")
+	ErrEmptyLookup        = errors.New("table should have at least one lookup key")
+	ErrInvalidSubjectKey  = errors.New("subject key should be in format name=value")
+	ErrUnknownSubjectKey  = errors.New("subject key is not used by any table")
+	ErrNoSubjectKeys      = errors.New("at least one subject key should be specified")
+	ErrUnsupportedFormat  = errors.New("unsupported report format")
+	ErrDuplicateTableName = errors.New("table defined several times")
+)
+
+// identifierRegexp matches table and column names allowed in config, optionally with schema name
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// TableConfig describes table which contains subject's data
+type TableConfig struct {
+	Table   string   `yaml:"table"`
+	Columns []string `yaml:"columns"`
+	// Lookup maps name of subject key to the column which contains it
+	Lookup map[string]string `yaml:"lookup"`
+}
+
+// Config describes tables queried for subject's data
+type Config struct {
+	Tables []TableConfig `yaml:"tables"`
+}
+
+// LoadConfig reads and validates configuration from file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates configuration
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if len(config.Tables) == 0 {
+		return nil, ErrEmptyTables
+	}
+	tables := make(map[string]struct{}, len(config.Tables))
+	for _, table := range config.Tables {
+		if !identifierRegexp.MatchString(table.Table) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidIdentifier, table.Table)
+		}
+		if _, ok := tables[table.Table]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateTableName, table.Table)
+		}
+		tables[table.Table] = struct{}{}
+		if len(table.Columns) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyColumns, table.Table)
+		}
+		if len(table.Lookup) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyLookup, table.Table)
+		}
+		for _, column := range table.Columns {
+			if !identifierRegexp.MatchString(column) {
+				return nil, fmt.Errorf("%w: %s.%s", ErrInvalidIdentifier, table.Table, column)
+			}
+		}
+		for _, column := range table.Lookup {
+			if !identifierRegexp.MatchString(column) {
+				return nil, fmt.Errorf("%w: %s.%s", ErrInvalidIdentifier, table.Table, column)
+			}
+		}
+	}
+	return config, nil
+}
+
+// ParseSubjectKeys parses comma separated list of name=value pairs and checks that each key is used by config
+func ParseSubjectKeys(value string, config *Config) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSubjectKey, pair)
+		}
+		keys[parts[0]] = parts[1]
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoSubjectKeys
+	}
+	for name := range keys {
+		used := false
+		for _, table := range config.Tables {
+			if _, ok := table.Lookup[name]; ok {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSubjectKey, name)
+		}
+	}
+	return keys, nil
+}
+
+// BuildQuery returns SELECT query with placeholders and its arguments for subject keys used by the table.
+// Returns empty query if table doesn't use any of provided keys
+func BuildQuery(table TableConfig, keys map[string]string, useMySQL bool) (string, []interface{}) {
+	names := make([]string, 0, len(table.Lookup))
+	for name := range table.Lookup {
+		if _, ok := keys[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	conditions := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		placeholder := "?"
+		if !useMySQL {
+			placeholder = "$" + strconv.Itoa(i+1)
+		}
+		conditions[i] = table.Lookup[name] + " = " + placeholder
+		args[i] = keys[name]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(table.Columns, ", "), table.Table, strings.Join(conditions, " OR "))
+	return query, args
+}
+
+// TableReport contains subject's rows from one table
+type TableReport struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	// Base64Columns lists columns with binary data encoded with base64
+	Base64Columns []string             `json:"base64_columns,omitempty"`
+	Rows          []map[string]*string `json:"rows"`
+}
+
+// Report is structured export of subject's data
+type Report struct {
+	ClientID    string        `json:"client_id"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	SubjectKeys []string      `json:"subject_keys"`
+	Tables      []TableReport `json:"tables"`
+}
+
+// BuildReport queries all tables which use provided subject keys and collects rows
+func BuildReport(db *sql.DB, config *Config, clientID string, keys map[string]string, useMySQL bool) (*Report, error) {
+	report := &Report{ClientID: clientID, GeneratedAt: time.Now().UTC(), SubjectKeys: sortedKeyNames(keys)}
+	for _, table := range config.Tables {
+		query, args := BuildQuery(table, keys, useMySQL)
+		if query == "" {
+			continue
+		}
+		tableReport, err := queryTable(db, table, query, args)
+		if err != nil {
+			return nil, fmt.Errorf("can't query table %s: %w", table.Table, err)
+		}
+		report.Tables = append(report.Tables, *tableReport)
+	}
+	return report, nil
+}
+
+func queryTable(db *sql.DB, table TableConfig, query string, args []interface{}) (*TableReport, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values [][][]byte
+	for rows.Next() {
+		row := make([][]byte, len(table.Columns))
+		pointers := make([]interface{}, len(row))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return NewTableReport(table, values), nil
+}
+
+// NewTableReport converts raw values into report rows. Columns with values which are not valid UTF-8 strings
+// are encoded with base64 entirely to keep column's format the same in all rows
+func NewTableReport(table TableConfig, values [][][]byte) *TableReport {
+	binary := make([]bool, len(table.Columns))
+	for _, row := range values {
+		for i, value := range row {
+			if value != nil && !utf8.Valid(value) {
+				binary[i] = true
+			}
+		}
+	}
+	report := &TableReport{Table: table.Table, Columns: table.Columns, Rows: make([]map[string]*string, 0, len(values))}
+	for i, isBinary := range binary {
+		if isBinary {
+			report.Base64Columns = append(report.Base64Columns, table.Columns[i])
+		}
+	}
+	for _, row := range values {
+		reportRow := make(map[string]*string, len(row))
+		for i, value := range row {
+			if value == nil {
+				reportRow[table.Columns[i]] = nil
+				continue
+			}
+			var text string
+			if binary[i] {
+				text = base64.StdEncoding.EncodeToString(value)
+			} else {
+				text = string(value)
+			}
+			reportRow[table.Columns[i]] = &text
+		}
+		report.Rows = append(report.Rows, reportRow)
+	}
+	return report
+}
+
+// WriteReport writes report in specified format
+func WriteReport(report *Report, format string, writer io.Writer) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case FormatCSV:
+		return writeReportCSV(report, writer)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// writeReportCSV writes one record per value because tables have different columns
+func writeReportCSV(report *Report, writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write([]string{"table", "row", "column", "value", "is_null", "is_base64"}); err != nil {
+		return err
+	}
+	for _, table := range report.Tables {
+		base64Columns := make(map[string]bool, len(table.Base64Columns))
+		for _, column := range table.Base64Columns {
+			base64Columns[column] = true
+		}
+		for i, row := range table.Rows {
+			for _, column := range table.Columns {
+				value := row[column]
+				record := []string{table.Table, strconv.Itoa(i + 1), column, "", strconv.FormatBool(value == nil), strconv.FormatBool(base64Columns[column])}
+				if value != nil {
+					record[3] = *value
+				}
+				if err := csvWriter.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// AuditRecord describes generated report without subject's data
+type AuditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	ClientID  string    `json:"client_id"`
+	// SubjectKeys maps key name to SHA-256 hash of its value to not store subject's identifiers in plaintext
+	SubjectKeys  map[string]string `json:"subject_keys"`
+	Tables       map[string]int    `json:"tables"`
+	Format       string            `json:"format"`
+	Output       string            `json:"output"`
+	ReportSHA256 string            `json:"report_sha256"`
+}
+
+// NewAuditRecord returns audit record of the report written in reportData
+func NewAuditRecord(report *Report, keys map[string]string, format, output string, reportData []byte) *AuditRecord {
+	record := &AuditRecord{
+		Timestamp:   report.GeneratedAt,
+		ClientID:    report.ClientID,
+		SubjectKeys: make(map[string]string, len(keys)),
+		Tables:      make(map[string]int, len(report.Tables)),
+		Format:      format,
+		Output:      output,
+	}
+	for name, value := range keys {
+		hash := sha256.Sum256([]byte(value))
+		record.SubjectKeys[name] = hex.EncodeToString(hash[:])
+	}
+	for _, table := range report.Tables {
+		record.Tables[table.Table] = len(table.Rows)
+	}
+	reportHash := sha256.Sum256(reportData)
+	record.ReportSHA256 = hex.EncodeToString(reportHash[:])
+	return record
+}
+
+// AppendAuditRecord appends record as JSON line into the file, creating it if necessary
+func AppendAuditRecord(path string, record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func sortedKeyNames(keys map[string]string) []string {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSubjectConfig = `
+tables:
+  - table: users
+    columns: [id, email, avatar]
+    lookup:
+      user_id: id
+      email: email
+  - table: public.orders
+    columns: [id, address]
+    lookup:
+      user_id: customer_id
+`
+
+func TestParseConfig(t *testing.T) {
+	testcases := []struct {
+		config string
+		err    error
+	}{
+		{testSubjectConfig, nil},
+		{`tables: []`, ErrEmptyTables},
+		{"tables:\n  - table: users\n    lookup: {user_id: id}\n", ErrEmptyColumns},
+		{"tables:\n  - table: users\n    columns: [id]\n", ErrEmptyLookup},
+		{"tables:\n  - table: \"users; drop table users\"\n    columns: [id]\n    lookup: {user_id: id}\n", ErrInvalidIdentifier},
+		{"tables:\n  - table: users\n    columns: [\"id, password\"]\n    lookup: {user_id: id}\n", ErrInvalidIdentifier},
+		{"tables:\n  - table: users\n    columns: [id]\n    lookup: {user_id: \"id OR 1=1\"}\n", ErrInvalidIdentifier},
+		{"tables:\n  - table: users\n    columns: [id]\n    lookup: {user_id: id}\n  - table: users\n    columns: [id]\n    lookup: {user_id: id}\n", ErrDuplicateTableName},
+	}
+	for i, tcase := range testcases {
+		if _, err := ParseConfig([]byte(tcase.config)); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestParseSubjectKeys(t *testing.T) {
+	config, err := ParseConfig([]byte(testSubjectConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseSubjectKeys("user_id=42, email=user@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, map[string]string{"user_id": "42", "email": "user@example.com"}) {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+	if _, err := ParseSubjectKeys("", config); err != ErrNoSubjectKeys {
+		t.Fatalf("Expected %v, took %v", ErrNoSubjectKeys, err)
+	}
+	if _, err := ParseSubjectKeys("user_id", config); !errors.Is(err, ErrInvalidSubjectKey) {
+		t.Fatalf("Expected %v, took %v", ErrInvalidSubjectKey, err)
+	}
+	if _, err := ParseSubjectKeys("phone=123", config); !errors.Is(err, ErrUnknownSubjectKey) {
+		t.Fatalf("Expected %v, took %v", ErrUnknownSubjectKey, err)
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	config, err := ParseConfig([]byte(testSubjectConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{"user_id": "42", "email": "user@example.com"}
+	query, args := BuildQuery(config.Tables[0], keys, false)
+	if query != "SELECT id, email, avatar FROM users WHERE email = $1 OR id = $2" {
+		t.Fatalf("Unexpected query: %s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"user@example.com", "42"}) {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+	query, _ = BuildQuery(config.Tables[1], keys, true)
+	if query != "SELECT id, address FROM public.orders WHERE customer_id = ?" {
+		t.Fatalf("Unexpected query: %s", query)
+	}
+	if query, args = BuildQuery(config.Tables[1], map[string]string{"email": "user@example.com"}, true); query != "" || args != nil {
+		t.Fatalf("Expected empty query, took %s", query)
+	}
+}
+
+func testReport() *Report {
+	table := TableConfig{Table: "users", Columns: []string{"id", "email", "avatar"}}
+	values := [][][]byte{
+		{[]byte("1"), []byte("user@example.com"), {0xff, 0x00}},
+		{[]byte("2"), nil, []byte("text")},
+	}
+	return &Report{ClientID: "client", SubjectKeys: []string{"user_id"}, Tables: []TableReport{*NewTableReport(table, values)}}
+}
+
+func TestNewTableReport(t *testing.T) {
+	table := testReport().Tables[0]
+	if !reflect.DeepEqual(table.Base64Columns, []string{"avatar"}) {
+		t.Fatalf("Unexpected base64 columns: %v", table.Base64Columns)
+	}
+	if len(table.Rows) != 2 {
+		t.Fatalf("Unexpected rows count %d", len(table.Rows))
+	}
+	if *table.Rows[0]["avatar"] != "/wA=" || *table.Rows[1]["avatar"] != "dGV4dA==" {
+		t.Fatal("Binary column should be encoded with base64 in all rows")
+	}
+	if table.Rows[1]["email"] != nil {
+		t.Fatal("NULL value should be nil")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := testReport()
+	output := &bytes.Buffer{}
+	if err := WriteReport(report, FormatJSON, output); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Report{}
+	if err := json.Unmarshal(output.Bytes(), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Tables, report.Tables) {
+		t.Fatalf("Unexpected JSON report: %s", output.String())
+	}
+
+	output.Reset()
+	if err := WriteReport(report, FormatCSV, output); err != nil {
+		t.Fatal(err)
+	}
+	expected := `table,row,column,value,is_null,is_base64
+users,1,id,1,false,false
+users,1,email,user@example.com,false,false
+users,1,avatar,/wA=,false,true
+users,2,id,2,false,false
+users,2,email,,true,false
+users,2,avatar,dGV4dA==,false,true
+`
+	if output.String() != expected {
+		t.Fatalf("Unexpected CSV report:\n%s", output.String())
+	}
+
+	if err := WriteReport(report, "xml", output); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Expected %v, took %v", ErrUnsupportedFormat, err)
+	}
+}
+
+func TestAppendAuditRecord(t *testing.T) {
+	report := testReport()
+	keys := map[string]string{"user_id": "subject-42"}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < 2; i++ {
+		record := NewAuditRecord(report, keys, FormatJSON, "report.json", []byte("report"))
+		if err := AppendAuditRecord(path, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "subject-42") {
+		t.Fatal("Audit log shouldn't contain subject's data")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lines := 0
+	for scanner.Scan() {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Tables["users"] != 2 || record.ClientID != "client" {
+			t.Fatalf("Unexpected audit record: %s", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Fatalf("Expected 2 audit records, took %d", lines)
+	}
+}
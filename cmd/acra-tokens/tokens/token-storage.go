@@ -17,15 +17,14 @@
 package tokens
 
 import (
-	"crypto/tls"
 	"errors"
 	"flag"
-	"github.com/cossacklabs/acra/network"
 	"os"
 
 	"github.com/cossacklabs/acra/cmd"
 	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
 	tokenStorage "github.com/cossacklabs/acra/pseudonymization/storage"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
 )
@@ -46,6 +45,7 @@ var (
 // Register registers token storage flags with the given flag set.
 func (p *CommonTokenStorageParameters) Register(flags *flag.FlagSet) {
 	flags.StringVar(&p.boltDB, "token_db", "", "path to BoltDB used for token data")
+	cmd.RegisterTokenStorageBackendParametersWithFlags(flags)
 }
 
 // BoltDBConfigured returns true if BoltDB is configured.
@@ -53,16 +53,33 @@ func (p *CommonTokenStorageParameters) BoltDBConfigured() bool {
 	return p.boltDB != ""
 }
 
-// Validate token storage parameter set.
-func (p *CommonTokenStorageParameters) Validate(flagSet *flag.FlagSet) error {
+// Configured returns true if any token storage is configured.
+func (p *CommonTokenStorageParameters) Configured(flagSet *flag.FlagSet) bool {
+	return p.configuredCount(flagSet) > 0
+}
+
+func (p *CommonTokenStorageParameters) configuredCount(flagSet *flag.FlagSet) int {
 	redisOptions := cmd.ParseRedisCLIParametersFromFlags(flagSet, "")
+	backendOptions := cmd.ParseTokenStorageBackendParametersFromFlags(flagSet)
 
-	if p.BoltDBConfigured() && redisOptions.TokensConfigured() {
-		log.Warn("Both --redis_host_port and --token_db cannot be used simultaneously")
+	configured := 0
+	for _, ok := range []bool{p.BoltDBConfigured(), redisOptions.TokensConfigured(), backendOptions.Configured()} {
+		if ok {
+			configured++
+		}
+	}
+	return configured
+}
+
+// Validate token storage parameter set.
+func (p *CommonTokenStorageParameters) Validate(flagSet *flag.FlagSet) error {
+	configured := p.configuredCount(flagSet)
+	if configured > 1 {
+		log.Warn("Only one of --redis_host_port, --token_db or --token_storage_backend can be used")
 		return ErrInvalidTokenStorage
 	}
-	if !p.BoltDBConfigured() && !redisOptions.TokensConfigured() {
-		log.Warn("Either --redis_host_port or --token_db is required")
+	if configured == 0 {
+		log.Warn("Either --redis_host_port, --token_db or --token_storage_backend is required")
 		return ErrInvalidTokenStorage
 	}
 	return nil
@@ -70,6 +87,14 @@ func (p *CommonTokenStorageParameters) Validate(flagSet *flag.FlagSet) error {
 
 // Open a token storage based on the command-line configuration.
 func (p *CommonTokenStorageParameters) Open(flagSet *flag.FlagSet) (tokenCommon.TokenStorage, error) {
+	if backendOptions := cmd.ParseTokenStorageBackendParametersFromFlags(flagSet); backendOptions.Configured() {
+		storage, _, err := tokenStorage.OpenTokenStorageBackend(backendOptions.Backend, backendOptions.ConnectionString)
+		if err != nil {
+			log.WithError(err).WithField("backend", backendOptions.Backend).Warn("Cannot initialize token storage backend")
+			return nil, err
+		}
+		return storage, nil
+	}
 	if p.BoltDBConfigured() {
 		db, err := bolt.Open(p.boltDB, boltDBOpenMode, nil)
 		if err != nil {
@@ -78,19 +103,14 @@ func (p *CommonTokenStorageParameters) Open(flagSet *flag.FlagSet) (tokenCommon.
 		}
 		return tokenStorage.NewBoltDBTokenStorage(db), nil
 	}
-	if redisOptions := cmd.ParseRedisCLIParametersFromFlags(flagSet, ""); redisOptions.KeysConfigured() {
-		var redisTLSConfig *tls.Config
-		var err error
-
-		if redisOptions.TLSEnable {
-			redisTLSConfig, err = network.NewTLSConfigByName(flagSet, "redis", redisOptions.HostPort, network.ClientNameConstructorFunc())
-			if err != nil {
-				log.WithError(err).Errorln("Can't initialize tls config for redis client")
-				return nil, err
-			}
+	if redisOptions := cmd.ParseRedisCLIParametersFromFlags(flagSet, ""); redisOptions.TokensConfigured() {
+		redisClientOptions, err := redisOptions.TokensOptions(flagSet)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			return nil, err
 		}
 
-		redisClient, err := tokenStorage.NewRedisClient(redisOptions.HostPort, redisOptions.Password, redisOptions.DBTokens, redisTLSConfig)
+		redisClient, err := redisclient.Open(redisClientOptions)
 		if err != nil {
 			log.WithError(err).Warn("Cannot initialize Redis client")
 			return nil, err
@@ -103,5 +123,5 @@ func (p *CommonTokenStorageParameters) Open(flagSet *flag.FlagSet) (tokenCommon.
 		}
 		return storage, nil
 	}
-	panic("unreachable: either BoltDB or Redis must be configured")
+	panic("unreachable: either BoltDB, Redis or token storage backend must be configured")
 }
@@ -34,12 +34,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cossacklabs/acra/acrablock"
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
 	_ "github.com/cossacklabs/acra/cmd/acra-translator/docs"
 	"github.com/cossacklabs/acra/cmd/acra-translator/grpc_api"
 	"github.com/cossacklabs/acra/cmd/acra-translator/server"
 	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/keystore/keyloader"
@@ -53,6 +55,7 @@ import (
 	common2 "github.com/cossacklabs/acra/pseudonymization/common"
 	"github.com/cossacklabs/acra/pseudonymization/storage"
 	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	bolt "go.etcd.io/bbolt"
 
 	log "github.com/sirupsen/logrus"
@@ -107,20 +110,25 @@ var ErrPipeWrite = errors.New("can't write exit signal to pipe")
 // ErrPipeReadWrongSignal occurs if we read unexpected signal from pipe between parent and forked processes
 var ErrPipeReadWrongSignal = errors.New("wrong signal has been read from pipe")
 
+// ErrProvisioningNotSupported occurs if keystore can't generate all keys of new clientIDs
+var ErrProvisioningNotSupported = errors.New("keystore doesn't support provisioning of clientIDs")
+
 func realMain() error {
 	config := common.NewConfig()
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
 
 	incomingConnectionHTTPString := flag.String("incoming_connection_http_string", "", "Connection string for HTTP transport like http://0.0.0.0:9595")
 	incomingConnectionGRPCString := flag.String("incoming_connection_grpc_string", "", "Default option: connection string for gRPC transport like grpc://0.0.0.0:9696")
 
-	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+	keysDir := cmd.RegisterKeysDirParameter()
 	cacheKeystoreOnStart := flag.Bool("keystore_cache_on_start_enable", true, "Load all keys to cache on start")
 	keysCacheSize := flag.Int("keystore_cache_size", keystore.DefaultCacheSize, fmt.Sprintf("Maximum number of keys stored in in-memory LRU cache in encrypted form. 0 - no limits, -1 - turn off cache. Default is %d", keystore.DefaultCacheSize))
+	keysCacheTTL := flag.Duration("keystore_cache_ttl", 0, "Time after which keys cached by keystore v1 are removed from cache and read from keystore again (e.g. 10m). 0 - cached keys don't expire")
+	keyUsageTrackingInterval := flag.Duration("keystore_usage_tracking_interval", 0, "Interval of saving usage statistics of storage keys (encryptions, decryptions, last access) to keystore, listed by \"acra-keys list --verbose\" (e.g. 1m). Supported only by keystore v1. 0 - disabled")
 
 	detectPoisonRecords := flag.Bool("poison_detect_enable", false, "Turn on poison record detection, if server shutdown is disabled, AcraTranslator logs the poison record detection and returns error")
 	stopOnPoison := flag.Bool("poison_shutdown_enable", false, "On detecting poison record: log about poison record detection, stop and shutdown")
 	scriptOnPoison := flag.String("poison_run_script_file", "", "On detecting poison record: log about poison record detection, execute script, return decrypted data")
+	poisonActionsConfig := flag.String("poison_actions_config_file", "", "Path to YAML configuration of ordered actions called on detecting poison record with per-clientID overrides. Overrides --poison_run_script_file and --poison_shutdown_enable")
 
 	closeConnectionTimeout := flag.Int("incoming_connection_close_timeout", DefaultAcraTranslatorWaitTimeout, "Time that AcraTranslator will wait (in seconds) on stop signal before closing all connections")
 
@@ -128,20 +136,40 @@ func realMain() error {
 	boltTokenbDB := flag.String("token_db", "", "Path to BoltDB database file to store tokens")
 
 	tlsIdentifierExtractorType := flag.String("tls_identifier_extractor_type", network.IdentifierExtractorTypeDistinguishedName, fmt.Sprintf("Decide which field of TLS certificate to use as ClientID (%s). Default is %s.", strings.Join(network.IdentifierExtractorTypesList, "|"), network.IdentifierExtractorTypeDistinguishedName))
+	network.RegisterIdentifierExtractorTemplateArgs(flag.CommandLine)
 	useClientIDFromConnection := flag.Bool("acratranslator_client_id_from_connection_enable", false, "Use clientID from TLS certificates or secure session handshake instead directly passed values in gRPC methods")
+	jwtAuthEnable := flag.Bool("jwt_auth_enable", false, "Authenticate HTTP and gRPC requests with JWT passed as \"Authorization: Bearer <token>\" header/metadata and use value of its claim as clientID instead of clientID from TLS certificates or request")
+	jwtJWKSURL := flag.String("jwt_jwks_url", "", "URL of JWKS with public keys used to verify JWT signatures, like jwks_uri of OpenID Connect provider. Keys are reloaded on tokens signed with unknown key")
+	jwtJWKSFile := flag.String("jwt_jwks_file", "", "Path to JWKS file with public keys used to verify JWT signatures")
+	jwtIssuer := flag.String("jwt_issuer", "", "Expected value of \"iss\" claim of JWT. Required with --jwt_auth_enable")
+	jwtAudience := flag.String("jwt_audience", "", "Expected value in \"aud\" claim of JWT. Required with --jwt_auth_enable")
+	jwtClientIDClaim := flag.String("jwt_client_id_claim", network.DefaultJWTClientIDClaim, "Name of JWT claim with string value used as clientID")
+	rateLimitQPS := flag.Float64("rate_limit_qps", 0, "Max number of operations per second for each clientID, exceeding requests are rejected. 0 - no limit")
+	rateLimitBurst := flag.Int("rate_limit_burst", 0, "Max number of operations allowed at once above --rate_limit_qps for each clientID. 0 - --rate_limit_qps rounded up")
+	rateLimitDailyQuota := flag.Uint64("rate_limit_daily_quota", 0, "Max number of operations per day (UTC) for each clientID, exceeding requests are rejected. 0 - no limit")
+	rateLimitConfig := flag.String("rate_limit_config_file", "", "Path to YAML configuration of rate limits and daily quotas with per-clientID overrides. Overrides --rate_limit_qps, --rate_limit_burst and --rate_limit_daily_quota")
+	accessReasonRequired := flag.Bool("access_reason_required", false, "Reject decryption and detokenization requests without reason of access to plaintext data. Passed reasons are logged with clientID and operation")
+	reIdentificationClientIDs := flag.String("re_identification_client_ids", "", "Comma-separated list of clientIDs allowed to re-identify pseudonyms of AcraServer with /v2/reIdentify HTTP endpoint. Re-identification is turned off if empty")
+	provisioningAdminClientIDs := flag.String("provisioning_admin_client_ids", "", "Comma-separated list of clientIDs allowed to provision new clientIDs with /v2/provisionClientID HTTP endpoint. Provisioning is turned off if empty")
+	provisioningCertificatePinsFile := flag.String("provisioning_certificate_pins_file", "", "Path to --tls_client_certificate_pins_file of AcraServer where pins of certificates of provisioned clientIDs are added. Provisioning with certificates is rejected if empty")
+	encryptorConfigFile := flag.String("encryptor_config_file", "", "Path to encryptor config of AcraServer used to generate query hashes for searchable columns by table and column names")
+	useMySQL := flag.Bool("mysql_enable", false, "Interpret data types of encryptor config as MySQL ones, PostgreSQL used by default")
+	maxAcceptedContainerFormat := flag.Uint("max_accepted_container_format", uint(acrablock.CurrentFormatVersion), "The newest format version of AcraBlocks which are decrypted, newer AcraBlocks are refused. New AcraBlocks aren't created with newer version. Use lower value during upgrade of instances to keep AcraBlocks readable by not upgraded ones")
+	containerFormatVersion := flag.Uint("container_format_version", uint(acrablock.DefaultWriterFormatVersion), "Format version of created AcraBlocks, can't be newer than --max_accepted_container_format. 0 (default) is readable by all versions of Acra. Enable 1 or 2 only after every reader is upgraded, older versions don't recognize AcraBlocks with explicit version and pass them through without decryption. Version 2 adds key version hint and authenticated metadata and is always used for AcraBlocks bound to column with acrablock_column_binding")
 	enableAuditLog := flag.Bool("audit_log_enable", false, "Enable audit log functionality")
 
 	cmd.RegisterRedisKeystoreParameters()
 	cmd.RegisterRedisTokenStoreParameters()
+	cmd.RegisterTokenStorageBackendParameters()
+	cmd.RegisterRemoteKeyStoreAPIParameters(flag.CommandLine)
 	keyloader.RegisterKeyStoreStrategyParameters()
 	cmd.RegisterTracingCmdParameters()
 	cmd.RegisterJaegerCmdParameters()
-	logging.RegisterCLIArgs()
+	cmd.RegisterOTLPCmdParameters()
+	loggingParams := cmd.RegisterLoggingParameters()
+	logging.RegisterEventSinkCLIArgs(flag.CommandLine)
 	network.RegisterTLSBaseArgs(flag.CommandLine)
 
-	verbose := flag.Bool("v", false, "Log to stderr all INFO, WARNING and ERROR logs")
-	debug := flag.Bool("d", false, "Log everything to stderr")
-
 	err := cmd.Parse(DefaultConfigPath, ServiceName)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
@@ -162,7 +190,7 @@ func realMain() error {
 	}
 
 	// Start customizing logs here (directly after command line arguments parsing)
-	formatter := logging.CreateCryptoFormatter(*loggingFormat)
+	formatter := logging.CreateCryptoFormatter(loggingParams.Format)
 	// Set formatter early in order to have consistent format for further logs
 	formatter.SetServiceName(ServiceName)
 	log.SetFormatter(formatter)
@@ -175,6 +203,27 @@ func realMain() error {
 	defer logFinalize()
 	log.SetOutput(writer)
 
+	if err := acrablock.SetMaxAcceptedFormatVersion(*maxAcceptedContainerFormat); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --max_accepted_container_format")
+		return err
+	}
+	if err := acrablock.SetWriterFormatVersion(*containerFormatVersion); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Invalid --container_format_version")
+		return err
+	}
+
+	eventSinkHook, err := logging.NewEventSinkHookFromCLI(ServiceName)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't initialize export of security events")
+		return err
+	}
+	if eventSinkHook != nil {
+		log.AddHook(eventSinkHook)
+	}
+
 	log.WithField("version", utils.VERSION).Infof("Starting service %v [pid=%v]", ServiceName, os.Getpid())
 	log.Infof("Validating service configuration...")
 	if len(*incomingConnectionHTTPString) == 0 && len(*incomingConnectionGRPCString) == 0 {
@@ -190,9 +239,10 @@ func realMain() error {
 	config.SetIncomingConnectionHTTPString(*incomingConnectionHTTPString)
 	config.SetIncomingConnectionGRPCString(*incomingConnectionGRPCString)
 	config.SetConfigPath(DefaultConfigPath)
-	config.SetDebug(*debug)
+	config.SetDebug(loggingParams.Debug)
 	config.SetTraceToLog(cmd.IsTraceToLogOn())
 	config.SetUseClientIDFromConnection(*useClientIDFromConnection)
+	config.SetAccessReasonRequired(*accessReasonRequired)
 
 	cmd.SetupTracing(ServiceName)
 
@@ -202,7 +252,7 @@ func realMain() error {
 	if filesystem2.IsKeyDirectory(*keysDir) {
 		keyStore, transportKeystore, err = openKeyStoreV2(*keysDir, *keysCacheSize)
 	} else {
-		keyStore, transportKeystore, err = openKeyStoreV1(*keysDir, *keysCacheSize)
+		keyStore, transportKeystore, err = openKeyStoreV1(*keysDir, *keysCacheSize, *keysCacheTTL)
 	}
 	if err != nil {
 		log.WithError(err).Errorln("Can't open keyStore")
@@ -232,7 +282,21 @@ func realMain() error {
 		return err
 	}
 
-	if *debug {
+	var keyUsageTracker *keystore.KeyUsageTracker
+	var keyUsageStore keystore.KeyUsageTrackingKeyStore
+	if *keyUsageTrackingInterval != 0 {
+		var ok bool
+		keyUsageStore, ok = keyStore.(keystore.KeyUsageTrackingKeyStore)
+		if !ok {
+			log.WithError(keystore.ErrKeyUsageTrackingNotSupported).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't use --keystore_usage_tracking_interval")
+			return keystore.ErrKeyUsageTrackingNotSupported
+		}
+		keyUsageTracker = keystore.NewKeyUsageTracker()
+		keyUsageStore.SetKeyUsageTracker(keyUsageTracker)
+	}
+
+	if loggingParams.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
 
@@ -247,7 +311,7 @@ func realMain() error {
 			log.WithError(err).Errorln("Can't fetch log key from keystore")
 			return err
 		}
-		hooks, err := logging.NewHooks(auditLogKey, *loggingFormat)
+		hooks, err := logging.NewHooks(auditLogKey, loggingParams.Format)
 		if err != nil {
 			log.WithError(err).Errorln("Can't create hooks")
 			return err
@@ -305,6 +369,50 @@ func realMain() error {
 	}
 	config.SetTLSClientIDExtractor(clientIDExtractor)
 
+	if *jwtAuthEnable {
+		jwtKeySet, err := network.NewJWTKeySet(*jwtJWKSURL, *jwtJWKSFile)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load JWKS, check --jwt_jwks_url or --jwt_jwks_file")
+			os.Exit(1)
+		}
+		jwtClientIDExtractor, err := network.NewJWTClientIDExtractor(jwtKeySet, *jwtIssuer, *jwtAudience, *jwtClientIDClaim)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't initialize JWT authentication, check --jwt_issuer and --jwt_audience")
+			os.Exit(1)
+		}
+		config.SetJWTClientIDExtractor(jwtClientIDExtractor)
+		log.WithFields(log.Fields{"issuer": *jwtIssuer, "audience": *jwtAudience, "claim": *jwtClientIDClaim}).Infoln("Turned on JWT authentication")
+	}
+
+	var rateLimiter *common.ClientIDRateLimiter
+	if *rateLimitConfig != "" {
+		rateLimiter, err = common.NewClientIDRateLimiterFromFile(*rateLimitConfig)
+	} else if *rateLimitQPS != 0 || *rateLimitDailyQuota != 0 {
+		rateLimiter, err = common.NewClientIDRateLimiter(&common.RateLimitsConfig{RateLimitConfig: common.RateLimitConfig{
+			QPS: *rateLimitQPS, Burst: *rateLimitBurst, DailyQuota: *rateLimitDailyQuota}})
+	}
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't initialize rate limits of clientIDs")
+		os.Exit(1)
+	}
+	if rateLimiter != nil {
+		config.SetRateLimiter(rateLimiter)
+		log.Infoln("Turned on rate limits of clientIDs")
+	}
+
+	if *encryptorConfigFile != "" {
+		tableSchemaStore, err := common.LoadTableSchemaStore(*encryptorConfigFile, *useMySQL)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load encryptor config")
+			os.Exit(1)
+		}
+		config.SetTableSchemaStore(tableSchemaStore)
+	}
+
 	// client's config nil because we don't need to establish tls connection with database or any third side
 	tlsWrapper, err := network.NewTLSAuthenticationConnectionWrapper(*useClientIDFromConnection, nil, tlsConfig, clientIDExtractor)
 	if err != nil {
@@ -326,7 +434,23 @@ func realMain() error {
 	}
 	var tokenStorage common2.TokenStorage
 	redis := cmd.ParseRedisCLIParameters()
-	if *boltTokenbDB != "" {
+	tokenStorageBackend := cmd.ParseTokenStorageBackendParametersFromFlags(flag.CommandLine)
+	if tokenStorageBackend.Configured() && (*boltTokenbDB != "" || redis.TokensConfigured()) {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("--token_storage_backend can't be used together with --token_db or --redis_host_port")
+		return cmd.ErrTokenStorageConflict
+	}
+	if tokenStorageBackend.Configured() {
+		log.WithField("backend", tokenStorageBackend.Backend).Infoln("Initialize token storage backend")
+		backendStorage, closer, err := storage.OpenTokenStorageBackend(tokenStorageBackend.Backend, tokenStorageBackend.ConnectionString)
+		if err != nil {
+			log.WithError(err).WithField("backend", tokenStorageBackend.Backend).Errorln("Can't initialize token storage backend")
+			return err
+		}
+		defer closer.Close()
+		tokenStorage = backendStorage
+		log.WithField("backend", tokenStorageBackend.Backend).Infoln("Initialized token storage backend")
+	} else if *boltTokenbDB != "" {
 		log.Infoln("Initialize bolt db storage for tokens")
 		db, err := bolt.Open(*boltTokenbDB, 0600, nil)
 		if err != nil {
@@ -338,7 +462,12 @@ func realMain() error {
 		log.Infoln("Initialized bolt db storage for tokens")
 	} else if redis.TokensConfigured() {
 		log.Infoln("Initialize redis db storage for tokens")
-		redisClient, err := storage.NewRedisClient(redis.HostPort, redis.Password, redis.DBTokens, nil)
+		redisOptions, err := redis.TokensOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			return err
+		}
+		redisClient, err := redisclient.Open(redisOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Can't initialize redis client")
 			return err
@@ -366,19 +495,80 @@ func realMain() error {
 		return err
 	}
 	config.SetTokenizer(tokenizer)
-	poisonCallbacks := poison.NewCallbackStorage()
+
+	var reIdentifier common.ReIdentifier
+	allowedReIdentificationClientIDs := make(map[string]bool)
+	if *reIdentificationClientIDs != "" {
+		reIdentificationKeyStore, ok := keyStore.(keystore.ReIdentificationKeyStore)
+		if !ok {
+			log.WithError(keystore.ErrPseudonymizationNotSupported).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't turn on re-identification of pseudonyms")
+			return keystore.ErrPseudonymizationNotSupported
+		}
+		for _, clientID := range strings.Split(*reIdentificationClientIDs, ",") {
+			if clientID = strings.TrimSpace(clientID); clientID != "" {
+				allowedReIdentificationClientIDs[clientID] = true
+			}
+		}
+		pseudonymReIdentifier, err := pseudonymization.NewReIdentifier(reIdentificationKeyStore, tokenStorage)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't initialize re-identification of pseudonyms")
+			return err
+		}
+		reIdentifier = pseudonymReIdentifier
+		log.WithField("client_ids", *reIdentificationClientIDs).Infoln("Turned on re-identification of pseudonyms")
+	}
+
+	var provisioner *common.ClientIDProvisioner
+	if *provisioningAdminClientIDs != "" {
+		provisioningKeyStore, ok := keyStore.(common.ProvisioningKeyStore)
+		if !ok {
+			log.WithError(ErrProvisioningNotSupported).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't turn on provisioning of clientIDs")
+			return ErrProvisioningNotSupported
+		}
+		adminClientIDs := make(map[string]bool)
+		for _, clientID := range strings.Split(*provisioningAdminClientIDs, ",") {
+			if clientID = strings.TrimSpace(clientID); clientID != "" {
+				adminClientIDs[clientID] = true
+			}
+		}
+		var pinRegistry common.CertificatePinRegistry
+		if *provisioningCertificatePinsFile != "" {
+			pinStore, err := network.NewFileCertificatePinStore(*provisioningCertificatePinsFile, 0)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Configuration error: can't load --provisioning_certificate_pins_file")
+				return err
+			}
+			pinRegistry = pinStore
+		}
+		provisioner = common.NewClientIDProvisioner(provisioningKeyStore, pinRegistry, adminClientIDs)
+		log.WithField("client_ids", *provisioningAdminClientIDs).Infoln("Turned on provisioning of clientIDs")
+	}
+	var poisonCallbacks base.PoisonRecordCallbackStorage = poison.NewCallbackStorage()
 	if config.DetectPoisonRecords() {
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetectionMessage).Infoln("Turned on poison record detection")
-		// used to turn off poison record detection which rely on HasCallbacks
-		poisonCallbacks.AddCallback(poison.EmptyCallback{})
-		if config.ScriptOnPoison() != "" {
-			poisonCallbacks.AddCallback(poison.NewExecuteScriptCallback(config.ScriptOnPoison()))
-			log.WithField("poison_run_script_file", *scriptOnPoison).Infoln("Turned on script execution for on detected poison record")
-		}
-		// should setup "stopOnPoison" as last poison record callback"
-		if config.StopOnPoison() {
-			poisonCallbacks.AddCallback(&poison.StopCallback{})
-			log.Infoln("Turned on poison record callback that stops acra-server after poison record detection")
+		if *poisonActionsConfig != "" {
+			poisonCallbacks, err = poison.NewActionPipelineFromFile(*poisonActionsConfig)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					WithField("poison_actions_config_file", *poisonActionsConfig).Errorln("Can't load poison record actions configuration")
+				return err
+			}
+			log.WithField("poison_actions_config_file", *poisonActionsConfig).Infoln("Loaded poison record actions pipeline")
+		} else {
+			poisonCallbacks, err = poison.NewLegacyActionPipeline(config.ScriptOnPoison(), config.StopOnPoison())
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't initialize poison record actions pipeline")
+				return err
+			}
+			if config.ScriptOnPoison() != "" {
+				log.WithField("poison_run_script_file", *scriptOnPoison).Infoln("Turned on script execution for on detected poison record")
+			}
+			if config.StopOnPoison() {
+				log.Infoln("Turned on poison record callback that stops acra-translator after poison record detection")
+			}
 		}
 	}
 	translatorData := &common.TranslatorData{
@@ -388,6 +578,15 @@ func realMain() error {
 		PoisonRecordCallbacks: poisonCallbacks,
 		UseConnectionClientID: config.GetUseClientIDFromConnection(),
 		TLSClientIDExtractor:  config.GetTLSClientIDExtractor(),
+		JWTClientIDExtractor:  config.GetJWTClientIDExtractor(),
+		RateLimiter:           config.GetRateLimiter(),
+		AccessReasonRequired:  config.GetAccessReasonRequired(),
+		TableSchemaStore:      config.GetTableSchemaStore(),
+		TraceToLog:            config.GetTraceToLog(),
+
+		ReIdentifier:              reIdentifier,
+		ReIdentificationClientIDs: allowedReIdentificationClientIDs,
+		Provisioner:               provisioner,
 	}
 	grpcServer, err := grpc_api.NewServer(translatorData, config.GRPCConnectionWrapper)
 	if err != nil {
@@ -435,6 +634,36 @@ func realMain() error {
 		sigHandlerSIGHUP.RegisterWithContext(mainContext)
 	}()
 
+	if eventSinkHook != nil {
+		go eventSinkHook.Run(mainContext)
+		log.Infoln("Enabled export of security events")
+	}
+
+	if keyUsageTracker != nil {
+		go keyUsageTracker.Run(mainContext, keyUsageStore, *keyUsageTrackingInterval)
+		log.WithField("interval", keyUsageTrackingInterval.String()).Infoln("Enabled tracking of keys usage")
+	}
+
+	if keyStoreAPIParams := cmd.ParseRemoteKeyStoreAPIParameters(flag.CommandLine); keyStoreAPIParams.Configured() {
+		var exporter keystore.Exporter
+		if keyStoreAPIParams.ExportEnable {
+			exporter, err = newKeysExporter(*keysDir, keyStore)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't initialize export of keys over keystore API")
+				cancel()
+				return err
+			}
+		}
+		if err := cmd.RunRemoteKeyStoreAPI(mainContext, keyStoreAPIParams, keyStore, exporter, tlsConfig, clientIDExtractor); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartService).
+				Errorln("Can't start keystore API")
+			cancel()
+			return err
+		}
+		log.WithField("address", keyStoreAPIParams.Address).Infoln("Enabled keystore API")
+	}
+
 	if *prometheusAddress != "" {
 		common.RegisterMetrics(ServiceName)
 		_, prometheusHTTPServer, err := cmd.RunPrometheusHTTPHandler(*prometheusAddress)
@@ -455,7 +684,7 @@ func realMain() error {
 		config.GRPCConnectionWrapper.AddOnServerHandshakeCallback(common.NewMetricConnectionCallback(common.GRPCConnectionType))
 	}
 
-	if *enableAuditLog {
+	if *enableAuditLog && len(auditLogRefreshSignals) != 0 {
 		// handle SIGUSR1 signal (we use it for force refreshing of audit log chain)
 		sigHandlerSIGUSR1 := make(chan os.Signal, 1)
 
@@ -463,7 +692,7 @@ func realMain() error {
 		go func() {
 			defer wg.Done()
 
-			signal.Notify(sigHandlerSIGUSR1, syscall.SIGUSR1)
+			signal.Notify(sigHandlerSIGUSR1, auditLogRefreshSignals...)
 			for {
 				select {
 				case <-sigHandlerSIGUSR1:
@@ -548,10 +777,7 @@ func realMain() error {
 			return
 		}
 
-		execSpec := &syscall.ProcAttr{
-			Env:   os.Environ(),
-			Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fdHTTP, fdGRPC, pipeRead.Fd()},
-		}
+		files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fdHTTP, fdGRPC, pipeRead.Fd()}
 		log.Debugf("Forking new process of %s", ServiceName)
 
 		executable, err := os.Executable()
@@ -561,7 +787,7 @@ func realMain() error {
 			return
 		}
 		// Fork new process
-		fork, err := syscall.ForkExec(executable, os.Args, execSpec)
+		fork, err := forkExec(executable, files)
 		if err != nil {
 			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantForkProcess).
 				WithField("executable", executable).
@@ -584,10 +810,10 @@ func realMain() error {
 
 	log.Infof("Setup ready. Start listening to connections. Current PID: %v", os.Getpid())
 
-	if *debug {
+	if loggingParams.Debug {
 		log.Infof("Enabling DEBUG log level")
 		logging.SetLogLevel(logging.LogDebug)
-	} else if *verbose {
+	} else if loggingParams.Verbose {
 		log.Infof("Enabling VERBOSE log level")
 		logging.SetLogLevel(logging.LogVerbose)
 	} else {
@@ -620,7 +846,7 @@ func realMain() error {
 
 func waitReadPipe(timeoutDuration time.Duration) error {
 	// unblock our pipe in order to use deadline for Read operation. It is important to call this before creating *os.File object from file descriptor
-	err := syscall.SetNonblock(DescriptorPipe, true)
+	err := setNonblock(DescriptorPipe)
 	if err != nil {
 		return err
 	}
@@ -650,7 +876,7 @@ func waitReadPipe(timeoutDuration time.Duration) error {
 	return nil
 }
 
-func openKeyStoreV1(keysDir string, cacheSize int) (keystore.ServerKeyStore, keystore.TranslationKeyStore, error) {
+func openKeyStoreV1(keysDir string, cacheSize int, cacheTTL time.Duration) (keystore.ServerKeyStore, keystore.TranslationKeyStore, error) {
 	var keyStoreEncryptor keystore.KeyEncryptor
 
 	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(flag.CommandLine, "")
@@ -661,7 +887,12 @@ func openKeyStoreV1(keysDir string, cacheSize int) (keystore.ServerKeyStore, key
 
 	var keyStorage filesystem.Storage = &filesystem.DummyStorage{}
 	if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
-		keyStorage, err = filesystem.NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, nil)
+		redisOptions, err := redis.KeysOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get Redis options")
+			return nil, nil, err
+		}
+		keyStorage, err = filesystem.NewRedisStorage(redisOptions)
 		if err != nil {
 			log.WithError(err).Errorln("Can't initialize Redis client")
 			return nil, nil, err
@@ -670,6 +901,7 @@ func openKeyStoreV1(keysDir string, cacheSize int) (keystore.ServerKeyStore, key
 	keyStore := filesystem.NewCustomFilesystemKeyStore()
 	keyStore.KeyDirectory(keysDir)
 	keyStore.CacheSize(cacheSize)
+	keyStore.CacheTTL(cacheTTL)
 	keyStore.Encryptor(keyStoreEncryptor)
 	keyStore.Storage(keyStorage)
 	keyStoreV1, err := keyStore.Build()
@@ -693,6 +925,29 @@ func openKeyStoreV1(keysDir string, cacheSize int) (keystore.ServerKeyStore, key
 	return keyStoreV1, transportKeyStoreV1, nil
 }
 
+// newKeysExporter returns exporter of keys from keystore v1 used by keystore API
+func newKeysExporter(keysDir string, keyStore keystore.ServerKeyStore) (keystore.Exporter, error) {
+	if filesystem2.IsKeyDirectory(keysDir) {
+		return nil, cmd.ErrRemoteKeyStoreNotSupported
+	}
+	keyStoreEncryptor, err := keyloader.CreateKeyEncryptor(flag.CommandLine, "")
+	if err != nil {
+		return nil, err
+	}
+	var keyStorage filesystem.Storage = &filesystem.DummyStorage{}
+	if redis := cmd.ParseRedisCLIParameters(); redis.KeysConfigured() {
+		redisOptions, err := redis.KeysOptions(flag.CommandLine)
+		if err != nil {
+			return nil, err
+		}
+		keyStorage, err = filesystem.NewRedisStorage(redisOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filesystem.NewKeyBackuper(keysDir, "", keyStorage, keyStoreEncryptor, keyStore)
+}
+
 func openKeyStoreV2(keysDir string, cacheSize int) (keystore.ServerKeyStore, keystore.TranslationKeyStore, error) {
 	if cacheSize != keystore.WithoutCache {
 		return nil, nil, keystore.ErrCacheIsNotSupportedV2
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+// maxAccessReasonLength limits size of reason written to the log
+const maxAccessReasonLength = 256
+
+// Errors returned for requests with invalid reason of access to plaintext data
+var (
+	ErrAccessReasonRequired = errors.New("reason of access to plaintext data is required")
+	ErrAccessReasonTooLong  = errors.New("reason of access to plaintext data is too long")
+)
+
+// IsAccessReasonError returns true if err returned due to missing or invalid reason of access to plaintext data
+func IsAccessReasonError(err error) bool {
+	return errors.Is(err, ErrAccessReasonRequired) || errors.Is(err, ErrAccessReasonTooLong)
+}
+
+type accessReasonKey struct{}
+
+// SetAccessReasonToContext returns context with reason of access to plaintext data passed in request
+func SetAccessReasonToContext(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, accessReasonKey{}, reason)
+}
+
+// GetAccessReasonFromContext returns reason of access to plaintext data or empty string if it wasn't set
+func GetAccessReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(accessReasonKey{}).(string)
+	return reason
+}
+
+type accessReasonWrapper struct {
+	ITranslatorService
+	required bool
+}
+
+// NewAccessReasonServiceWrapper wraps decryption and detokenization methods of service with recording of access reason
+// from context to the (audit) log. Requests without reason are rejected if required is true
+func NewAccessReasonServiceWrapper(service ITranslatorService, required bool) ITranslatorService {
+	return &accessReasonWrapper{service, required}
+}
+
+func (wrapper *accessReasonWrapper) checkReason(ctx context.Context, clientID []byte, operation string) error {
+	reason := GetAccessReasonFromContext(ctx)
+	logger := logging.GetLoggerFromContext(ctx).WithFields(logrus.Fields{"client_id": string(clientID), "operation": operation})
+	if len(reason) > maxAccessReasonLength {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorAccessReasonMissing).Warningln("Rejected access to plaintext data with too long reason")
+		return ErrAccessReasonTooLong
+	}
+	if reason == "" {
+		if wrapper.required {
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorAccessReasonMissing).Warningln("Rejected access to plaintext data without reason")
+			return ErrAccessReasonRequired
+		}
+		return nil
+	}
+	logger.WithField("reason", reason).Infoln("Access to plaintext data")
+	return nil
+}
+
+// Decrypt AcraStruct using ClientID
+func (wrapper *accessReasonWrapper) Decrypt(ctx context.Context, acraStruct, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.checkReason(ctx, clientID, decryptOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Decrypt(ctx, acraStruct, clientID, additionalContext)
+}
+
+// ReIdentify returns source value of pseudonym
+func (wrapper *accessReasonWrapper) ReIdentify(ctx context.Context, pseudonym, clientID, pseudonymClientID []byte) ([]byte, error) {
+	if err := wrapper.checkReason(ctx, clientID, reIdentifyOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.ReIdentify(ctx, pseudonym, clientID, pseudonymClientID)
+}
+
+// DecryptSearchable decrypts AcraStruct and verifies hash
+func (wrapper *accessReasonWrapper) DecryptSearchable(ctx context.Context, data, hash, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.checkReason(ctx, clientID, decryptSearchableOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSearchable(ctx, data, hash, clientID, additionalContext)
+}
+
+// Detokenize data from request according to TokenType using ClientID
+func (wrapper *accessReasonWrapper) Detokenize(ctx context.Context, data interface{}, dataType tokenCommon.TokenType, clientID, additionalContext []byte) (interface{}, error) {
+	if err := wrapper.checkReason(ctx, clientID, detokenizeOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Detokenize(ctx, data, dataType, clientID, additionalContext)
+}
+
+// DecryptSymSearchable decrypts AcraBlock and verifies hash
+func (wrapper *accessReasonWrapper) DecryptSymSearchable(ctx context.Context, data, hash, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.checkReason(ctx, clientID, decryptSymSearchableOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSymSearchable(ctx, data, hash, clientID, additionalContext)
+}
+
+// DecryptSym decrypts AcraBlock using ClientID
+func (wrapper *accessReasonWrapper) DecryptSym(ctx context.Context, acraBlock, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.checkReason(ctx, clientID, decryptSymOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSym(ctx, acraBlock, clientID, additionalContext)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+type accessReasonTestService struct {
+	ITranslatorService
+	calls int
+}
+
+func (service *accessReasonTestService) DecryptSym(ctx context.Context, acraBlock, clientID, additionalContext []byte) ([]byte, error) {
+	service.calls++
+	return acraBlock, nil
+}
+
+func (service *accessReasonTestService) Detokenize(ctx context.Context, data interface{}, dataType tokenCommon.TokenType, clientID, additionalContext []byte) (interface{}, error) {
+	service.calls++
+	return data, nil
+}
+
+func TestAccessReasonServiceWrapper(t *testing.T) {
+	service := &accessReasonTestService{}
+	clientID := []byte("client")
+	withReason := SetAccessReasonToContext(context.Background(), "support ticket 42")
+	withLongReason := SetAccessReasonToContext(context.Background(), strings.Repeat("a", maxAccessReasonLength+1))
+
+	optional := NewAccessReasonServiceWrapper(service, false)
+	if _, err := optional.DecryptSym(context.Background(), []byte("data"), clientID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := optional.DecryptSym(withReason, []byte("data"), clientID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := optional.DecryptSym(withLongReason, []byte("data"), clientID, nil); err != ErrAccessReasonTooLong {
+		t.Fatalf("Expected %v, took %v", ErrAccessReasonTooLong, err)
+	}
+
+	required := NewAccessReasonServiceWrapper(service, true)
+	if _, err := required.DecryptSym(context.Background(), []byte("data"), clientID, nil); err != ErrAccessReasonRequired {
+		t.Fatalf("Expected %v, took %v", ErrAccessReasonRequired, err)
+	}
+	if _, err := required.Detokenize(context.Background(), "token", tokenCommon.TokenType_String, clientID, nil); err != ErrAccessReasonRequired {
+		t.Fatalf("Expected %v, took %v", ErrAccessReasonRequired, err)
+	}
+	if _, err := required.Detokenize(withReason, "token", tokenCommon.TokenType_String, clientID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if service.calls != 3 {
+		t.Fatalf("Expected 3 calls of wrapped service, took %d", service.calls)
+	}
+	if GetAccessReasonFromContext(withReason) != "support ticket 42" {
+		t.Fatal("Expected reason from context")
+	}
+}
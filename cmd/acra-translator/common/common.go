@@ -19,11 +19,17 @@ package common
 
 import (
 	"github.com/cossacklabs/acra/decryptor/base"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/network"
 	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
 )
 
+// ReIdentifier restores source values of pseudonyms derived by AcraServer
+type ReIdentifier interface {
+	ReIdentify(clientID, pseudonym []byte) ([]byte, error)
+}
+
 // TranslatorData connects KeyStorage and Poison records settings for HTTP and gRPC decryptors.
 type TranslatorData struct {
 	Tokenizer             tokenCommon.Pseudoanonymizer
@@ -32,4 +38,20 @@ type TranslatorData struct {
 	Keystorage            keystore.TranslationKeyStore
 	UseConnectionClientID bool
 	TLSClientIDExtractor  network.TLSClientIDExtractor
+	// JWTClientIDExtractor is not nil if clientID should be taken from JWT of requests
+	JWTClientIDExtractor *network.JWTClientIDExtractor
+	// RateLimiter is not nil if operations of clientIDs should be limited
+	RateLimiter *ClientIDRateLimiter
+	// AccessReasonRequired is true if decryption and detokenization requests without reason should be rejected
+	AccessReasonRequired bool
+	// TableSchemaStore is encryptor config of AcraServer used to generate query hashes for columns, may be nil
+	TableSchemaStore encryptorConfig.TableSchemaStore
+	// TraceToLog is true if trace_id and span_id of requests should be added to logs
+	TraceToLog bool
+	// ReIdentifier is not nil if re-identification of pseudonyms is enabled
+	ReIdentifier ReIdentifier
+	// ReIdentificationClientIDs are clientIDs allowed to re-identify pseudonyms
+	ReIdentificationClientIDs map[string]bool
+	// Provisioner is not nil if provisioning of new clientIDs is enabled
+	Provisioner *ClientIDProvisioner
 }
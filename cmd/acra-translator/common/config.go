@@ -18,6 +18,7 @@ package common
 
 import (
 	"crypto/tls"
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/pseudonymization/common"
 	"go.opencensus.io/trace"
@@ -40,6 +41,10 @@ type AcraTranslatorConfig struct {
 	useClientIDFromConnection    bool
 	tokenizer                    common.Pseudoanonymizer
 	tlsClientIDExtractor         network.TLSClientIDExtractor
+	jwtClientIDExtractor         *network.JWTClientIDExtractor
+	rateLimiter                  *ClientIDRateLimiter
+	accessReasonRequired         bool
+	tableSchemaStore             encryptorConfig.TableSchemaStore
 }
 
 // NewConfig creates new AcraTranslatorConfig.
@@ -57,6 +62,46 @@ func (a *AcraTranslatorConfig) GetTLSClientIDExtractor() network.TLSClientIDExtr
 	return a.tlsClientIDExtractor
 }
 
+// SetJWTClientIDExtractor set clientID extractor from JWT of requests
+func (a *AcraTranslatorConfig) SetJWTClientIDExtractor(jwtClientIDExtractor *network.JWTClientIDExtractor) {
+	a.jwtClientIDExtractor = jwtClientIDExtractor
+}
+
+// GetJWTClientIDExtractor return configured JWTClientIDExtractor or nil if JWT authentication turned off
+func (a *AcraTranslatorConfig) GetJWTClientIDExtractor() *network.JWTClientIDExtractor {
+	return a.jwtClientIDExtractor
+}
+
+// SetRateLimiter set limiter of clientIDs operations
+func (a *AcraTranslatorConfig) SetRateLimiter(rateLimiter *ClientIDRateLimiter) {
+	a.rateLimiter = rateLimiter
+}
+
+// GetRateLimiter return configured ClientIDRateLimiter or nil if rate limits turned off
+func (a *AcraTranslatorConfig) GetRateLimiter() *ClientIDRateLimiter {
+	return a.rateLimiter
+}
+
+// SetAccessReasonRequired set rejecting of decryption and detokenization requests without reason of access
+func (a *AcraTranslatorConfig) SetAccessReasonRequired(required bool) {
+	a.accessReasonRequired = required
+}
+
+// GetAccessReasonRequired return true if reason of access to plaintext data is required
+func (a *AcraTranslatorConfig) GetAccessReasonRequired() bool {
+	return a.accessReasonRequired
+}
+
+// SetTableSchemaStore set encryptor config used to generate query hashes for searchable columns
+func (a *AcraTranslatorConfig) SetTableSchemaStore(tableSchemaStore encryptorConfig.TableSchemaStore) {
+	a.tableSchemaStore = tableSchemaStore
+}
+
+// GetTableSchemaStore return encryptor config or nil if it wasn't configured
+func (a *AcraTranslatorConfig) GetTableSchemaStore() encryptorConfig.TableSchemaStore {
+	return a.tableSchemaStore
+}
+
 // SetTokenizer set configured tokenizer
 func (a *AcraTranslatorConfig) SetTokenizer(tokenizer common.Pseudoanonymizer) {
 	a.tokenizer = tokenizer
@@ -99,7 +144,12 @@ func (a *AcraTranslatorConfig) SetTraceToLog(v bool) {
 
 // GetTraceOptions for opencensus trace
 func (a *AcraTranslatorConfig) GetTraceOptions() []trace.StartOption {
-	return []trace.StartOption{trace.WithSampler(trace.AlwaysSample()), trace.WithSpanKind(trace.SpanKindServer)}
+	return requestTraceOptions()
+}
+
+// GetTraceToLog returns true if trace data should be added to logs
+func (a *AcraTranslatorConfig) GetTraceToLog() bool {
+	return a.traceToLog
 }
 
 // KeysDir returns keys directory.
@@ -25,6 +25,10 @@ import (
 
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	kmsBase "github.com/cossacklabs/acra/keystore/kms/base"
+	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/poison"
 	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
 	"github.com/cossacklabs/acra/utils"
 )
@@ -53,6 +57,8 @@ const (
 
 	tokenizeOperation   = "tokenize"
 	detokenizeOperation = "detokenize"
+
+	reIdentifyOperation = "reIdentify"
 )
 
 // Valid values of connection type for metrics for Acra-Translator API
@@ -93,9 +99,15 @@ func RegisterMetrics(serviceName string) {
 		prometheus.MustRegister(connectionCounter)
 		prometheus.MustRegister(connectionProcessingTimeHistogram)
 		prometheus.MustRegister(RequestProcessingTimeHistogram)
+		prometheus.MustRegister(rateLimitedRequestsCounter)
 		base.RegisterAcraStructProcessingMetrics()
 		base.RegisterEncryptionDecryptionProcessingMetrics()
 		base.RegisterTokenizationProcessingMetrics()
+		poison.RegisterPoisonRecordMetrics()
+		kmsBase.RegisterCacheMetrics()
+		keystore.RegisterKeyUsageMetrics()
+		network.RegisterOCSPMetrics()
+		network.RegisterCRLMetrics()
 		version, err := utils.GetParsedVersion()
 		if err != nil {
 			panic(err)
@@ -261,3 +273,10 @@ func (wrapper *prometheusWrapper) DecryptSym(ctx context.Context, acraBlock, cli
 	base.AcraDecryptionCounter.WithLabelValues(base.LabelStatusSuccess, base.LabelTypeAcraBlock).Inc()
 	return decrypted, nil
 }
+
+// ReIdentify returns source value of pseudonym
+func (wrapper *prometheusWrapper) ReIdentify(ctx context.Context, pseudonym, clientID, pseudonymClientID []byte) ([]byte, error) {
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(RequestProcessingTimeHistogram.WithLabelValues(wrapper.metricType, reIdentifyOperation).Observe))
+	defer timer.ObserveDuration()
+	return wrapper.ITranslatorService.ReIdentify(ctx, pseudonym, clientID, pseudonymClientID)
+}
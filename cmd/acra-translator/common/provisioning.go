@@ -0,0 +1,159 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// Errors returned by ClientIDProvisioner
+var (
+	ErrProvisioningDenied           = errors.New("provisioning of clientIDs is denied")
+	ErrClientIDExists               = errors.New("clientID already has keys")
+	ErrInvalidCertificate           = errors.New("invalid PEM encoded certificate")
+	ErrCertificatePinningNotEnabled = errors.New("certificate pinning is not configured")
+	ErrProvision                    = errors.New("can't provision clientID")
+)
+
+// ProvisioningKeyStore generates all keys of new clientID and returns its public key
+type ProvisioningKeyStore interface {
+	keystore.StorageKeyCreation
+	keystore.SymmetricEncryptionKeyStoreGenerator
+	keystore.HmacKeyGenerator
+	keystore.PublicKeyStore
+}
+
+// CertificatePinRegistry saves pins of certificates allowed for clientID
+type CertificatePinRegistry interface {
+	AddPins(clientID []byte, pins []string) error
+}
+
+// ProvisionedClientID contains public material required by AcraWriter SDKs to encrypt data of new clientID
+type ProvisionedClientID struct {
+	ClientID         []byte
+	StoragePublicKey *keys.PublicKey
+	CertificatePins  []string
+}
+
+// ClientIDProvisioner creates new clientIDs end-to-end: generates storage keypair, symmetric and search keys, and pins
+// certificates of clients which will use clientID
+type ClientIDProvisioner struct {
+	keyStore       ProvisioningKeyStore
+	pins           CertificatePinRegistry
+	adminClientIDs map[string]bool
+	// lock serializes provisioning, so concurrent requests with the same clientID don't overwrite keys of each other
+	lock sync.Mutex
+}
+
+// NewClientIDProvisioner returns ClientIDProvisioner which allows provisioning only for adminClientIDs. pins may be nil
+// if certificate pinning isn't used
+func NewClientIDProvisioner(keyStore ProvisioningKeyStore, pins CertificatePinRegistry, adminClientIDs map[string]bool) *ClientIDProvisioner {
+	return &ClientIDProvisioner{keyStore: keyStore, pins: pins, adminClientIDs: adminClientIDs}
+}
+
+// parseCertificatePins returns pins of PEM encoded certificates
+func parseCertificatePins(certificatesPEM []string) ([]string, error) {
+	pins := make([]string, 0, len(certificatesPEM))
+	for i, certificatePEM := range certificatesPEM {
+		block, _ := pem.Decode([]byte(certificatePEM))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%w: certificate %d", ErrInvalidCertificate, i)
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: certificate %d: %s", ErrInvalidCertificate, i, err)
+		}
+		pins = append(pins, network.CertificatePin(certificate))
+	}
+	return pins, nil
+}
+
+// Provision generates keys of clientID and pins certificatesPEM to it on behalf of adminClientID. ClientID which
+// already has storage keypair is never re-provisioned, to not overwrite keys of existing data
+func (provisioner *ClientIDProvisioner) Provision(ctx context.Context, adminClientID, clientID []byte, certificatesPEM []string) (*ProvisionedClientID, error) {
+	logger := logging.GetLoggerFromContext(ctx)
+	logger = logger.WithFields(logrus.Fields{"client_id": string(adminClientID), "provisioned_client_id": string(clientID), "operation": "Provision"})
+	logger.Debugln("New request")
+	defer logger.Debugln("End processing request to provision clientID")
+
+	if len(adminClientID) == 0 {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorClientIDMissing).Errorln("Request without ClientID not allowed")
+		return nil, ErrClientIDRequired
+	}
+	if !provisioner.adminClientIDs[string(adminClientID)] {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorProvisioningDenied).Warningln("Denied provisioning of clientID")
+		return nil, ErrProvisioningDenied
+	}
+	if !keystore.ValidateID(clientID) {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorInvalidClientID).Errorln("Invalid clientID to provision")
+		return nil, keystore.ErrInvalidClientID
+	}
+	pins, err := parseCertificatePins(certificatesPEM)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't compute pins of certificates")
+		return nil, err
+	}
+	if len(pins) > 0 && provisioner.pins == nil {
+		logger.WithError(ErrCertificatePinningNotEnabled).Errorln("Can't pin certificates of clientID")
+		return nil, ErrCertificatePinningNotEnabled
+	}
+
+	provisioner.lock.Lock()
+	defer provisioner.lock.Unlock()
+	if _, err := provisioner.keyStore.GetClientIDEncryptionPublicKey(clientID); err == nil {
+		logger.Warningln("Rejected provisioning of clientID which already has keys")
+		return nil, ErrClientIDExists
+	}
+	if err := provisioner.keyStore.GenerateDataEncryptionKeys(clientID); err != nil {
+		logger.WithError(err).Errorln("Can't generate storage keypair")
+		return nil, ErrProvision
+	}
+	if err := provisioner.keyStore.GenerateClientIDSymmetricKey(clientID); err != nil {
+		logger.WithError(err).Errorln("Can't generate symmetric storage key")
+		return nil, ErrProvision
+	}
+	if err := provisioner.keyStore.GenerateHmacKey(clientID); err != nil {
+		logger.WithError(err).Errorln("Can't generate HMAC key for searchable encryption")
+		return nil, ErrProvision
+	}
+	if len(pins) > 0 {
+		if err := provisioner.pins.AddPins(clientID, pins); err != nil {
+			logger.WithError(err).Errorln("Can't save pins of certificates")
+			return nil, ErrProvision
+		}
+	}
+	publicKey, err := provisioner.keyStore.GetClientIDEncryptionPublicKey(clientID)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't load generated public key")
+		return nil, ErrProvision
+	}
+	logger.WithFields(logrus.Fields{logging.FieldKeyEventCode: logging.EventCodeClientIDProvisioned, "certificate_pins": pins}).
+		Infoln("Provisioned new clientID")
+	return &ProvisionedClientID{ClientID: clientID, StoragePublicKey: publicKey, CertificatePins: pins}, nil
+}
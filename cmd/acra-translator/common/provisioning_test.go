@@ -0,0 +1,133 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/network"
+)
+
+type testProvisioningKeyStore struct {
+	keypairs  map[string]*keys.Keypair
+	generated map[string][]string
+}
+
+func (k *testProvisioningKeyStore) GenerateDataEncryptionKeys(clientID []byte) error {
+	keypair, err := keys.New(keys.TypeEC)
+	if err != nil {
+		return err
+	}
+	return k.SaveDataEncryptionKeys(clientID, keypair)
+}
+
+func (k *testProvisioningKeyStore) SaveDataEncryptionKeys(clientID []byte, keypair *keys.Keypair) error {
+	k.keypairs[string(clientID)] = keypair
+	k.generated[string(clientID)] = append(k.generated[string(clientID)], "storage")
+	return nil
+}
+
+func (k *testProvisioningKeyStore) GenerateClientIDSymmetricKey(clientID []byte) error {
+	k.generated[string(clientID)] = append(k.generated[string(clientID)], "symmetric")
+	return nil
+}
+
+func (k *testProvisioningKeyStore) GenerateHmacKey(clientID []byte) error {
+	k.generated[string(clientID)] = append(k.generated[string(clientID)], "hmac")
+	return nil
+}
+
+func (k *testProvisioningKeyStore) GetClientIDEncryptionPublicKey(clientID []byte) (*keys.PublicKey, error) {
+	keypair, ok := k.keypairs[string(clientID)]
+	if !ok {
+		return nil, keystore.ErrKeysNotFound
+	}
+	return keypair.Public, nil
+}
+
+type testPinRegistry map[string][]string
+
+func (r testPinRegistry) AddPins(clientID []byte, pins []string) error {
+	r[string(clientID)] = append(r[string(clientID)], pins...)
+	return nil
+}
+
+func generateTestCertificate(t *testing.T) (string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), certificate
+}
+
+func TestClientIDProvisioner(t *testing.T) {
+	keyStore := &testProvisioningKeyStore{keypairs: make(map[string]*keys.Keypair), generated: make(map[string][]string)}
+	pins := testPinRegistry{}
+	provisioner := NewClientIDProvisioner(keyStore, pins, map[string]bool{"admin": true})
+	certificatePEM, certificate := generateTestCertificate(t)
+	ctx := context.Background()
+
+	if _, err := provisioner.Provision(ctx, nil, []byte("new_client"), nil); err != ErrClientIDRequired {
+		t.Fatalf("Expected ErrClientIDRequired, took %v", err)
+	}
+	if _, err := provisioner.Provision(ctx, []byte("client"), []byte("new_client"), nil); err != ErrProvisioningDenied {
+		t.Fatalf("Expected ErrProvisioningDenied, took %v", err)
+	}
+	if _, err := provisioner.Provision(ctx, []byte("admin"), []byte("a"), nil); err != keystore.ErrInvalidClientID {
+		t.Fatalf("Expected ErrInvalidClientID, took %v", err)
+	}
+	if _, err := provisioner.Provision(ctx, []byte("admin"), []byte("new_client"), []string{"invalid"}); !errors.Is(err, ErrInvalidCertificate) {
+		t.Fatalf("Expected ErrInvalidCertificate, took %v", err)
+	}
+	if len(keyStore.generated) != 0 {
+		t.Fatal("Rejected requests shouldn't generate keys")
+	}
+
+	provisioned, err := provisioner.Provision(ctx, []byte("admin"), []byte("new_client"), []string{certificatePEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provisioned.StoragePublicKey != keyStore.keypairs["new_client"].Public {
+		t.Fatal("Expected public key of generated keypair")
+	}
+	if generated := keyStore.generated["new_client"]; len(generated) != 3 {
+		t.Fatalf("Expected storage, symmetric and hmac keys, took %v", generated)
+	}
+	pin := network.CertificatePin(certificate)
+	if len(provisioned.CertificatePins) != 1 || provisioned.CertificatePins[0] != pin || len(pins["new_client"]) != 1 || pins["new_client"][0] != pin {
+		t.Fatalf("Unexpected pins %v, %v", provisioned.CertificatePins, pins)
+	}
+	if _, err := provisioner.Provision(ctx, []byte("admin"), []byte("new_client"), nil); err != ErrClientIDExists {
+		t.Fatalf("Expected ErrClientIDExists, took %v", err)
+	}
+
+	provisioner = NewClientIDProvisioner(keyStore, nil, map[string]bool{"admin": true})
+	if _, err := provisioner.Provision(ctx, []byte("admin"), []byte("other_client"), []string{certificatePEM}); err != ErrCertificatePinningNotEnabled {
+		t.Fatalf("Expected ErrCertificatePinningNotEnabled, took %v", err)
+	}
+}
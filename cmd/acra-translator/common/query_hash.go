@@ -0,0 +1,53 @@
+package common
+
+import (
+	"errors"
+	"os"
+
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
+)
+
+// Errors returned for requests of query hash for column from encryptor config
+var (
+	ErrEncryptorConfigNotConfigured = errors.New("encryptor config is not configured, table and column are not supported")
+	ErrColumnNotSearchable          = errors.New("column is not configured as searchable in encryptor config")
+	ErrIncompleteColumnIdentifier   = errors.New("both table and column should be specified")
+)
+
+// LoadTableSchemaStore reads encryptor config used by AcraServer from file
+func LoadTableSchemaStore(path string, useMySQL bool) (encryptorConfig.TableSchemaStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return encryptorConfig.MapTableSchemaStoreFromConfig(data, useMySQL)
+}
+
+// GetQueryHashClientID returns clientID whose HMAC key AcraServer uses to generate blind index of searchable column.
+// clientID returned as is if table and column are empty. Column's client_id from encryptor config overrides clientID,
+// so the returned hash may be compared with stored one in WHERE clause like:
+//
+//	WHERE substring(column, 1, <hash size>) = <hash>
+func GetQueryHashClientID(schemaStore encryptorConfig.TableSchemaStore, table, column string, clientID []byte) ([]byte, error) {
+	if table == "" && column == "" {
+		return clientID, nil
+	}
+	if table == "" || column == "" {
+		return nil, ErrIncompleteColumnIdentifier
+	}
+	if schemaStore == nil {
+		return nil, ErrEncryptorConfigNotConfigured
+	}
+	schema := schemaStore.GetTableSchema(table)
+	if schema == nil {
+		return nil, ErrColumnNotSearchable
+	}
+	setting := schema.GetColumnEncryptionSettings(column)
+	if setting == nil || !setting.IsSearchable() {
+		return nil, ErrColumnNotSearchable
+	}
+	if columnClientID := setting.ClientID(); len(columnClientID) > 0 {
+		return columnClientID, nil
+	}
+	return clientID, nil
+}
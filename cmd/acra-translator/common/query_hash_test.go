@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	encryptorConfig "github.com/cossacklabs/acra/encryptor/config"
+)
+
+func TestGetQueryHashClientID(t *testing.T) {
+	testConfig := `
+schemas:
+  - table: users
+    columns:
+      - email
+      - phone
+      - name
+    encrypted:
+      - column: email
+        searchable: true
+      - column: phone
+        searchable: true
+        client_id: phones
+      - column: name
+`
+	schemaStore, err := encryptorConfig.MapTableSchemaStoreFromConfig([]byte(testConfig), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	testcases := []struct {
+		table    string
+		column   string
+		clientID []byte
+		err      error
+	}{
+		{"", "", clientID, nil},
+		{"users", "email", clientID, nil},
+		{"users", "phone", []byte("phones"), nil},
+		{"users", "name", nil, ErrColumnNotSearchable},
+		{"users", "unknown", nil, ErrColumnNotSearchable},
+		{"unknown", "email", nil, ErrColumnNotSearchable},
+		{"users", "", nil, ErrIncompleteColumnIdentifier},
+		{"", "email", nil, ErrIncompleteColumnIdentifier},
+	}
+	for i, testcase := range testcases {
+		resultClientID, err := GetQueryHashClientID(schemaStore, testcase.table, testcase.column, clientID)
+		if err != testcase.err {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+		if !bytes.Equal(resultClientID, testcase.clientID) {
+			t.Fatalf("[%d] Expected clientID %s, took %s", i, testcase.clientID, resultClientID)
+		}
+	}
+	if _, err := GetQueryHashClientID(nil, "users", "email", clientID); err != ErrEncryptorConfigNotConfigured {
+		t.Fatalf("Expected %v, took %v", ErrEncryptorConfigNotConfigured, err)
+	}
+}
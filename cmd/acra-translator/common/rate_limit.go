@@ -0,0 +1,350 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/keystore"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+// Errors returned for requests rejected by ClientIDRateLimiter
+var (
+	ErrRateLimitExceeded      = errors.New("rate limit of clientID exceeded")
+	ErrQuotaExceeded          = errors.New("daily operations quota of clientID exceeded")
+	ErrTooManyClients         = errors.New("max number of clientIDs tracked by rate limits exceeded")
+	ErrInvalidRateLimitConfig = errors.New("invalid rate limit configuration")
+)
+
+// IsRateLimitError returns true if err returned due to exceeded rate limit or quota of clientID
+func IsRateLimitError(err error) bool {
+	return errors.Is(err, ErrRateLimitExceeded) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrTooManyClients)
+}
+
+const (
+	rejectReasonLabel     = "reason"
+	rejectReasonRateLimit = "rate_limit"
+	rejectReasonQuota     = "quota"
+	rejectReasonClients   = "max_clients"
+)
+
+var rateLimitedRequestsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acratranslator_rate_limited_requests_total",
+		Help: "number of requests rejected due to exceeded rate limit or daily quota of clientID",
+	}, []string{operationLabel, rejectReasonLabel})
+
+// RateLimitConfig describes limits of operations for clientID. Zero values turn off limits
+type RateLimitConfig struct {
+	// QPS is allowed number of operations per second
+	QPS float64 `yaml:"qps"`
+	// Burst is max number of operations allowed at once above QPS, QPS rounded up used if zero
+	Burst int `yaml:"burst"`
+	// DailyQuota is allowed number of operations per day (UTC)
+	DailyQuota uint64 `yaml:"daily_quota"`
+}
+
+// ClientRateLimitConfig overrides default limits for specific clientID
+type ClientRateLimitConfig struct {
+	ClientID        string `yaml:"client_id"`
+	RateLimitConfig `yaml:",inline"`
+}
+
+// DefaultRateLimitMaxClients is max number of tracked clientIDs without own limits used if max_clients isn't set
+const DefaultRateLimitMaxClients = 10000
+
+// RateLimitsConfig is the root of rate limits configuration file
+//
+//	qps: 10
+//	burst: 20
+//	daily_quota: 100000
+//	max_clients: 10000
+//	clients:
+//	  - client_id: reporting
+//	    qps: 100
+//	    daily_quota: 0
+type RateLimitsConfig struct {
+	RateLimitConfig `yaml:",inline"`
+	// MaxClients limits number of tracked clientIDs without own limits. Operations of new clientIDs are rejected until
+	// the end of the day when the limit is reached. DefaultRateLimitMaxClients used if zero
+	MaxClients int                     `yaml:"max_clients"`
+	Clients    []ClientRateLimitConfig `yaml:"clients"`
+}
+
+// ParseRateLimitsConfig parses YAML configuration of rate limits
+func ParseRateLimitsConfig(data []byte) (*RateLimitsConfig, error) {
+	config := &RateLimitsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewClientIDRateLimiterFromFile reads YAML configuration from file and creates ClientIDRateLimiter
+func NewClientIDRateLimiterFromFile(path string) (*ClientIDRateLimiter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseRateLimitsConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientIDRateLimiter(config)
+}
+
+type clientIDLimits struct {
+	limiter    *rate.Limiter
+	config     RateLimitConfig
+	operations uint64
+}
+
+// ClientIDRateLimiter limits number of operations per second and per day for each clientID
+type ClientIDRateLimiter struct {
+	defaultConfig RateLimitConfig
+	clientConfigs map[string]RateLimitConfig
+	lock          sync.Mutex
+	// configuredClients tracks clientIDs with own limits, their number is bounded by config
+	configuredClients map[string]*clientIDLimits
+	// clients tracks other clientIDs which may be arbitrary values of requests, so their number is bounded by
+	// maxClients. They are never evicted during the day to keep their daily usage
+	clients    map[string]*clientIDLimits
+	maxClients int
+	day        time.Time
+	now        func() time.Time
+}
+
+// NewClientIDRateLimiter creates ClientIDRateLimiter with limits from config
+func NewClientIDRateLimiter(config *RateLimitsConfig) (*ClientIDRateLimiter, error) {
+	if err := validateRateLimitConfig(config.RateLimitConfig); err != nil {
+		return nil, err
+	}
+	if config.MaxClients < 0 {
+		return nil, fmt.Errorf("%w: max_clients should be non-negative", ErrInvalidRateLimitConfig)
+	}
+	maxClients := config.MaxClients
+	if maxClients == 0 {
+		maxClients = DefaultRateLimitMaxClients
+	}
+	clientConfigs := make(map[string]RateLimitConfig, len(config.Clients))
+	for _, client := range config.Clients {
+		if client.ClientID == "" {
+			return nil, fmt.Errorf("%w: empty client_id in clients section", ErrInvalidRateLimitConfig)
+		}
+		if _, ok := clientConfigs[client.ClientID]; ok {
+			return nil, fmt.Errorf("%w: duplicated client_id %s", ErrInvalidRateLimitConfig, client.ClientID)
+		}
+		if err := validateRateLimitConfig(client.RateLimitConfig); err != nil {
+			return nil, fmt.Errorf("client_id %s: %w", client.ClientID, err)
+		}
+		clientConfigs[client.ClientID] = client.RateLimitConfig
+	}
+	return &ClientIDRateLimiter{
+		defaultConfig:     config.RateLimitConfig,
+		clientConfigs:     clientConfigs,
+		configuredClients: make(map[string]*clientIDLimits),
+		clients:           make(map[string]*clientIDLimits),
+		maxClients:        maxClients,
+		now:               time.Now,
+	}, nil
+}
+
+func validateRateLimitConfig(config RateLimitConfig) error {
+	if config.QPS < 0 || math.IsNaN(config.QPS) || math.IsInf(config.QPS, 0) || config.Burst < 0 {
+		return fmt.Errorf("%w: qps and burst should be non-negative", ErrInvalidRateLimitConfig)
+	}
+	return nil
+}
+
+func newClientIDLimits(config RateLimitConfig) *clientIDLimits {
+	limits := &clientIDLimits{config: config}
+	if config.QPS > 0 {
+		burst := config.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(config.QPS))
+		}
+		limits.limiter = rate.NewLimiter(rate.Limit(config.QPS), burst)
+	}
+	return limits
+}
+
+// getClientIDLimits returns tracked limits of clientID or starts tracking it. Returns ErrTooManyClients if clientID
+// without own limits can't be tracked because maxClients are already tracked
+func (limiter *ClientIDRateLimiter) getClientIDLimits(clientID string) (*clientIDLimits, error) {
+	if limits, ok := limiter.configuredClients[clientID]; ok {
+		return limits, nil
+	}
+	if config, ok := limiter.clientConfigs[clientID]; ok {
+		limits := newClientIDLimits(config)
+		limiter.configuredClients[clientID] = limits
+		return limits, nil
+	}
+	if limits, ok := limiter.clients[clientID]; ok {
+		return limits, nil
+	}
+	if len(limiter.clients) >= limiter.maxClients {
+		return nil, ErrTooManyClients
+	}
+	limits := newClientIDLimits(limiter.defaultConfig)
+	limiter.clients[clientID] = limits
+	return limits, nil
+}
+
+// Allow registers new operation of clientID and returns ErrRateLimitExceeded, ErrQuotaExceeded or ErrTooManyClients
+// if it should be rejected. Invalid clientIDs are rejected with keystore.ErrInvalidClientID and aren't tracked
+func (limiter *ClientIDRateLimiter) Allow(clientID []byte) error {
+	if !keystore.ValidateID(clientID) {
+		return keystore.ErrInvalidClientID
+	}
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := limiter.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if !day.Equal(limiter.day) {
+		// reset quotas and forget clientIDs seen yesterday
+		limiter.configuredClients = make(map[string]*clientIDLimits)
+		limiter.clients = make(map[string]*clientIDLimits)
+		limiter.day = day
+	}
+	limits, err := limiter.getClientIDLimits(string(clientID))
+	if err != nil {
+		return err
+	}
+	if limits.config.DailyQuota > 0 && limits.operations >= limits.config.DailyQuota {
+		return ErrQuotaExceeded
+	}
+	if limits.limiter != nil && !limits.limiter.AllowN(now, 1) {
+		return ErrRateLimitExceeded
+	}
+	limits.operations++
+	return nil
+}
+
+type rateLimitWrapper struct {
+	ITranslatorService
+	limiter *ClientIDRateLimiter
+}
+
+// NewRateLimitServiceWrapper wraps all methods of service with checks of rate limits and quotas of clientID. It should
+// wrap service after clientID is authenticated by TLS or JWT wrappers, so limits are applied to authenticated clientID
+func NewRateLimitServiceWrapper(service ITranslatorService, limiter *ClientIDRateLimiter) ITranslatorService {
+	return &rateLimitWrapper{service, limiter}
+}
+
+func (wrapper *rateLimitWrapper) allow(clientID []byte, operation string) error {
+	err := wrapper.limiter.Allow(clientID)
+	switch err {
+	case ErrRateLimitExceeded:
+		rateLimitedRequestsCounter.WithLabelValues(operation, rejectReasonRateLimit).Inc()
+	case ErrQuotaExceeded:
+		rateLimitedRequestsCounter.WithLabelValues(operation, rejectReasonQuota).Inc()
+	case ErrTooManyClients:
+		rateLimitedRequestsCounter.WithLabelValues(operation, rejectReasonClients).Inc()
+	}
+	return err
+}
+
+// Decrypt AcraStruct using ClientID
+func (wrapper *rateLimitWrapper) Decrypt(ctx context.Context, acraStruct, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, decryptOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Decrypt(ctx, acraStruct, clientID, additionalContext)
+}
+
+// Encrypt AcraStruct using ClientID
+func (wrapper *rateLimitWrapper) Encrypt(ctx context.Context, data, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, encryptOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Encrypt(ctx, data, clientID, additionalContext)
+}
+
+// EncryptSearchable encrypts data with AcraStruct and calculates hash
+func (wrapper *rateLimitWrapper) EncryptSearchable(ctx context.Context, data, clientID, additionalContext []byte) (SearchableResponse, error) {
+	if err := wrapper.allow(clientID, encryptSearchableOperation); err != nil {
+		return SearchableResponse{}, err
+	}
+	return wrapper.ITranslatorService.EncryptSearchable(ctx, data, clientID, additionalContext)
+}
+
+// DecryptSearchable decrypts AcraStruct and verifies hash
+func (wrapper *rateLimitWrapper) DecryptSearchable(ctx context.Context, data, hash, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, decryptSearchableOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSearchable(ctx, data, hash, clientID, additionalContext)
+}
+
+// GenerateQueryHash generates searchable hash for data
+func (wrapper *rateLimitWrapper) GenerateQueryHash(ctx context.Context, data, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, generateQueryHashOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.GenerateQueryHash(ctx, data, clientID, additionalContext)
+}
+
+// Tokenize data from request according to TokenType using ClientID
+func (wrapper *rateLimitWrapper) Tokenize(ctx context.Context, data interface{}, dataType tokenCommon.TokenType, clientID, additionalContext []byte) (interface{}, error) {
+	if err := wrapper.allow(clientID, tokenizeOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Tokenize(ctx, data, dataType, clientID, additionalContext)
+}
+
+// Detokenize data from request according to TokenType using ClientID
+func (wrapper *rateLimitWrapper) Detokenize(ctx context.Context, data interface{}, dataType tokenCommon.TokenType, clientID, additionalContext []byte) (interface{}, error) {
+	if err := wrapper.allow(clientID, detokenizeOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.Detokenize(ctx, data, dataType, clientID, additionalContext)
+}
+
+// EncryptSymSearchable encrypts data with AcraBlock and calculates hash
+func (wrapper *rateLimitWrapper) EncryptSymSearchable(ctx context.Context, data, clientID, additionalContext []byte) (SearchableResponse, error) {
+	if err := wrapper.allow(clientID, encryptSymSearchableOperation); err != nil {
+		return SearchableResponse{}, err
+	}
+	return wrapper.ITranslatorService.EncryptSymSearchable(ctx, data, clientID, additionalContext)
+}
+
+// DecryptSymSearchable decrypts AcraBlock and verifies hash
+func (wrapper *rateLimitWrapper) DecryptSymSearchable(ctx context.Context, data, hash, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, decryptSymSearchableOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSymSearchable(ctx, data, hash, clientID, additionalContext)
+}
+
+// EncryptSym encrypts data with AcraBlock using ClientID
+func (wrapper *rateLimitWrapper) EncryptSym(ctx context.Context, data, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, encryptSymOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.EncryptSym(ctx, data, clientID, additionalContext)
+}
+
+// DecryptSym decrypts AcraBlock using ClientID
+func (wrapper *rateLimitWrapper) DecryptSym(ctx context.Context, acraBlock, clientID, additionalContext []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, decryptSymOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.DecryptSym(ctx, acraBlock, clientID, additionalContext)
+}
+
+// ReIdentify returns source value of pseudonym
+func (wrapper *rateLimitWrapper) ReIdentify(ctx context.Context, pseudonym, clientID, pseudonymClientID []byte) ([]byte, error) {
+	if err := wrapper.allow(clientID, reIdentifyOperation); err != nil {
+		return nil, err
+	}
+	return wrapper.ITranslatorService.ReIdentify(ctx, pseudonym, clientID, pseudonymClientID)
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestClientIDRateLimiter(t *testing.T) {
+	config, err := ParseRateLimitsConfig([]byte(`
+qps: 1
+burst: 2
+daily_quota: 3
+clients:
+  - client_id: unlimited
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := NewClientIDRateLimiter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	client := []byte("client")
+	expected := []error{nil, nil, ErrRateLimitExceeded}
+	for i, expectedErr := range expected {
+		if err := limiter.Allow(client); err != expectedErr {
+			t.Fatalf("[%d] Expected %v, took %v", i, expectedErr, err)
+		}
+	}
+	// limits are separate for each clientID
+	if err := limiter.Allow([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if err := limiter.Allow(client); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second * 10)
+	if err := limiter.Allow(client); err != ErrQuotaExceeded {
+		t.Fatalf("Expected %v, took %v", ErrQuotaExceeded, err)
+	}
+	// quota resets on the next day
+	now = now.Add(time.Hour)
+	if err := limiter.Allow(client); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := limiter.Allow([]byte("unlimited")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClientIDRateLimiterMaxClients(t *testing.T) {
+	config, err := ParseRateLimitsConfig([]byte(`
+daily_quota: 1
+max_clients: 2
+clients:
+  - client_id: configured
+    daily_quota: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := NewClientIDRateLimiter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	for _, clientID := range []string{"configured", "client1", "client2"} {
+		if err := limiter.Allow([]byte(clientID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only max_clients clientIDs without own limits are tracked, new ones are rejected without evicting others
+	if err := limiter.Allow([]byte("client3")); err != ErrTooManyClients {
+		t.Fatalf("Expected %v, took %v", ErrTooManyClients, err)
+	}
+	if len(limiter.clients) != 2 {
+		t.Fatalf("Expected 2 tracked clientIDs, took %d", len(limiter.clients))
+	}
+	for _, clientID := range []string{"configured", "client1", "client2"} {
+		if err := limiter.Allow([]byte(clientID)); err != ErrQuotaExceeded {
+			t.Fatalf("[%s] Expected %v, took %v", clientID, ErrQuotaExceeded, err)
+		}
+	}
+	// invalid clientIDs aren't tracked
+	for i := 0; i < 10; i++ {
+		if err := limiter.Allow([]byte{byte(i)}); err != keystore.ErrInvalidClientID {
+			t.Fatalf("Expected %v, took %v", keystore.ErrInvalidClientID, err)
+		}
+	}
+	// new clientIDs are tracked on the next day
+	now = now.Add(time.Hour)
+	if err := limiter.Allow([]byte("client3")); err != nil {
+		t.Fatal(err)
+	}
+	if !IsRateLimitError(ErrTooManyClients) {
+		t.Fatal("ErrTooManyClients should be rate limit error")
+	}
+}
+
+func TestRateLimitsConfigValidation(t *testing.T) {
+	testcases := []string{
+		"qps: -1\n",
+		"burst: -1\n",
+		"max_clients: -1\n",
+		"clients:\n  - qps: 1\n",
+		"clients:\n  - client_id: client\n  - client_id: client\n",
+	}
+	for _, testcase := range testcases {
+		config, err := ParseRateLimitsConfig([]byte(testcase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewClientIDRateLimiter(config); !errors.Is(err, ErrInvalidRateLimitConfig) {
+			t.Fatalf("Expected %v for %q, took %v", ErrInvalidRateLimitConfig, testcase, err)
+		}
+	}
+	if _, err := ParseRateLimitsConfig([]byte("unknown: 1\n")); err == nil {
+		t.Fatal("Expected error on unknown field")
+	}
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+type testReIdentifier struct {
+	values map[string][]byte
+}
+
+func (r *testReIdentifier) ReIdentify(clientID, pseudonym []byte) ([]byte, error) {
+	return r.values[string(clientID)+"/"+string(pseudonym)], nil
+}
+
+func TestReIdentify(t *testing.T) {
+	service := &TranslatorService{data: &TranslatorData{
+		ReIdentifier:              &testReIdentifier{values: map[string][]byte{"server/pseudonym": []byte("value")}},
+		ReIdentificationClientIDs: map[string]bool{"analyst": true},
+	}}
+	ctx := SetAccessReasonToContext(context.Background(), "ticket-1")
+
+	if _, err := service.ReIdentify(ctx, []byte("pseudonym"), nil, []byte("server")); err != ErrClientIDRequired {
+		t.Fatalf("Expected ErrClientIDRequired, took %v", err)
+	}
+	if _, err := service.ReIdentify(ctx, []byte("pseudonym"), []byte("server"), nil); err != ErrReIdentificationDenied {
+		t.Fatalf("Expected ErrReIdentificationDenied, took %v", err)
+	}
+	if _, err := service.ReIdentify(context.Background(), []byte("pseudonym"), []byte("analyst"), []byte("server")); err != ErrAccessReasonRequired {
+		t.Fatalf("Expected ErrAccessReasonRequired, took %v", err)
+	}
+	value, err := service.ReIdentify(ctx, []byte("pseudonym"), []byte("analyst"), []byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, []byte("value")) {
+		t.Fatalf("Expected value, took %s", value)
+	}
+
+	service.data.ReIdentifier = nil
+	if _, err = service.ReIdentify(ctx, []byte("pseudonym"), []byte("analyst"), []byte("server")); err != ErrReIdentificationDenied {
+		t.Fatalf("Expected ErrReIdentificationDenied when re-identification disabled, took %v", err)
+	}
+}
@@ -26,6 +26,7 @@ type ITranslatorService interface {
 	DecryptSymSearchable(ctx context.Context, data, hash, clientID, additionalContext []byte) ([]byte, error)
 	EncryptSym(ctx context.Context, data, clientID, additionalContext []byte) ([]byte, error)
 	DecryptSym(ctx context.Context, acraBlock, clientID, additionalContext []byte) ([]byte, error)
+	ReIdentify(ctx context.Context, pseudonym, clientID, pseudonymClientID []byte) ([]byte, error)
 }
 
 // TranslatorService service that implements all Acra-Translator functions
@@ -300,6 +301,41 @@ func (service *TranslatorService) Detokenize(ctx context.Context, data interface
 	}
 }
 
+// ReIdentify returns source value of pseudonym derived by AcraServer with keys of pseudonymClientID or clientID if it's
+// empty. Only clientIDs allowed by --re_identification_client_ids may re-identify pseudonyms and reason of access is
+// always required
+func (service *TranslatorService) ReIdentify(ctx context.Context, pseudonym, clientID, pseudonymClientID []byte) ([]byte, error) {
+	if len(pseudonymClientID) == 0 {
+		pseudonymClientID = clientID
+	}
+	logger := logging.GetLoggerFromContext(ctx)
+	logger = logger.WithFields(logrus.Fields{"client_id": string(clientID), "pseudonym_client_id": string(pseudonymClientID), "operation": "ReIdentify"})
+	logger.Debugln("New request")
+	defer logger.Debugln("End processing request to re-identify pseudonym")
+
+	if len(clientID) == 0 {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorClientIDMissing).Errorln("Request without ClientID not allowed")
+		return nil, ErrClientIDRequired
+	}
+	if service.data.ReIdentifier == nil || !service.data.ReIdentificationClientIDs[string(clientID)] {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorReIdentificationDenied).Warningln("Denied re-identification of pseudonym")
+		return nil, ErrReIdentificationDenied
+	}
+	reason := GetAccessReasonFromContext(ctx)
+	if reason == "" {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorAccessReasonMissing).Warningln("Rejected re-identification of pseudonym without reason")
+		return nil, ErrAccessReasonRequired
+	}
+	sourceData, err := service.data.ReIdentifier.ReIdentify(pseudonymClientID, pseudonym)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't re-identify pseudonym")
+		return nil, ErrReIdentify
+	}
+	logger.WithFields(logrus.Fields{logging.FieldKeyEventCode: logging.EventCodeReIdentification, "reason": reason, "pseudonym": string(pseudonym)}).
+		Infoln("Re-identified pseudonym")
+	return sourceData, nil
+}
+
 // Errors related with gRPC requests
 var (
 	ErrKeysNotFound     = errors.New("keys not found")
@@ -307,8 +343,12 @@ var (
 	ErrDecryptionFailed = errors.New("decryption failed")
 	ErrDetokenize       = errors.New("can't detokenize")
 	ErrTokenize         = errors.New("can't tokenize")
+	ErrReIdentify       = errors.New("can't re-identify pseudonym")
 )
 
+// ErrReIdentificationDenied returned if clientID isn't allowed to re-identify pseudonyms
+var ErrReIdentificationDenied = errors.New("re-identification of pseudonyms is denied")
+
 // EncryptSymSearchable encrypts data with AcraBlock using ClientID and searchable hash
 func (service *TranslatorService) EncryptSymSearchable(ctx context.Context, data, clientID, additionalContext []byte) (SearchableResponse, error) {
 	logger := logging.GetLoggerFromContext(ctx)
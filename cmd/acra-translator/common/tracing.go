@@ -0,0 +1,66 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"net/http"
+
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// Headers of W3C Trace Context used by OpenTelemetry clients to pass span context over HTTP and gRPC metadata
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+var traceContextFormat = &tracecontext.HTTPFormat{}
+
+// SpanContextFromTraceContext returns span context of client from values of W3C Trace Context headers and false if
+// they are absent or invalid
+func SpanContextFromTraceContext(traceParent, traceState []string) (trace.SpanContext, bool) {
+	if len(traceParent) == 0 {
+		return trace.SpanContext{}, false
+	}
+	header := http.Header{}
+	for _, value := range traceParent {
+		header.Add(TraceParentHeader, value)
+	}
+	for _, value := range traceState {
+		header.Add(TraceStateHeader, value)
+	}
+	return traceContextFormat.SpanContextFromRequest(&http.Request{Header: header})
+}
+
+// StartRequestSpan starts server span of request which continues trace of client if parent span context is valid.
+// traceToLog turns on trace_id and span_id fields in logs of request
+func StartRequestSpan(ctx context.Context, name string, parent trace.SpanContext, hasParent, traceToLog bool) (context.Context, *trace.Span) {
+	ctx = logging.SetTraceStatus(ctx, traceToLog)
+	if hasParent {
+		return trace.StartSpanWithRemoteParent(ctx, name, parent, requestTraceOptions()...)
+	}
+	return trace.StartSpan(ctx, name, requestTraceOptions()...)
+}
+
+// requestTraceOptions returns options of spans of requests which are always sampled
+func requestTraceOptions() []trace.StartOption {
+	return []trace.StartOption{trace.WithSampler(trace.AlwaysSample()), trace.WithSpanKind(trace.SpanKindServer)}
+}
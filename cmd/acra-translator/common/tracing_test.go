@@ -0,0 +1,34 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"go.opencensus.io/trace"
+)
+
+func TestStartRequestSpanWithTraceContext(t *testing.T) {
+	traceParent := []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	parent, ok := SpanContextFromTraceContext(traceParent, []string{"vendor=value"})
+	if !ok {
+		t.Fatal("Expected valid span context")
+	}
+	_, span := StartRequestSpan(context.Background(), "request", parent, ok, false)
+	spanContext := span.SpanContext()
+	span.End()
+	if spanContext.TraceID != parent.TraceID || spanContext.SpanID == parent.SpanID {
+		t.Fatal("Span doesn't continue trace of client")
+	}
+
+	for _, invalid := range [][]string{nil, {"invalid"}, {"00-00000000000000000000000000000000-b7ad6b7169203331-01"}} {
+		parent, ok = SpanContextFromTraceContext(invalid, nil)
+		if ok {
+			t.Fatalf("Expected invalid span context for %v", invalid)
+		}
+		_, span = StartRequestSpan(context.Background(), "request", parent, ok, false)
+		if span.SpanContext().TraceID == (trace.TraceID{}) {
+			t.Fatal("Expected new trace")
+		}
+		span.End()
+	}
+}
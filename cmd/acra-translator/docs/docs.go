@@ -24,6 +24,43 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/v2/batch": {
+            "post": {
+                "description": "Process list of encryption, decryption and tokenization operations with ClientID from connection. Every item has \"operation\" field with name of endpoint and the same fields as request of this endpoint. Failure of one item doesn't fail the batch, every item has own status code",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Process batch of operations",
+                "parameters": [
+                    {
+                        "description": "Items with operation name and fields of operation request",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http_api.batchHTTPRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http_api.batchHTTPResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http_api.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
         "/v2/decrypt": {
             "get": {
                 "description": "Decrypt AcraStruct with specified ClientID from connection",
@@ -512,6 +549,42 @@ const docTemplate = `{
                 }
             }
         },
+        "http_api.batchHTTPRequest": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                }
+            }
+        },
+        "http_api.batchHTTPResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/http_api.batchItemHTTPResponse"
+                    }
+                }
+            }
+        },
+        "http_api.batchItemHTTPResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Can't decrypt data"
+                },
+                "response": {}
+            }
+        },
         "http_api.encryptionHTTPResponse": {
             "type": "object",
             "properties": {
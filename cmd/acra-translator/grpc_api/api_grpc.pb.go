@@ -709,3 +709,275 @@ var SearchableEncryption_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
+
+// BatchClient is the client API for Batch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BatchClient interface {
+	ProcessBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+}
+
+type batchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBatchClient(cc grpc.ClientConnInterface) BatchClient {
+	return &batchClient{cc}
+}
+
+func (c *batchClient) ProcessBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/grpc_api.Batch/ProcessBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BatchServer is the server API for Batch service.
+// All implementations must embed UnimplementedBatchServer
+// for forward compatibility
+type BatchServer interface {
+	ProcessBatch(context.Context, *BatchRequest) (*BatchResponse, error)
+	mustEmbedUnimplementedBatchServer()
+}
+
+// UnimplementedBatchServer must be embedded to have forward compatible implementations.
+type UnimplementedBatchServer struct {
+}
+
+func (UnimplementedBatchServer) ProcessBatch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProcessBatch not implemented")
+}
+func (UnimplementedBatchServer) mustEmbedUnimplementedBatchServer() {}
+
+// UnsafeBatchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BatchServer will
+// result in compilation errors.
+type UnsafeBatchServer interface {
+	mustEmbedUnimplementedBatchServer()
+}
+
+func RegisterBatchServer(s grpc.ServiceRegistrar, srv BatchServer) {
+	s.RegisterService(&Batch_ServiceDesc, srv)
+}
+
+func _Batch_ProcessBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BatchServer).ProcessBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc_api.Batch/ProcessBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BatchServer).ProcessBatch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Batch_ServiceDesc is the grpc.ServiceDesc for Batch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Batch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc_api.Batch",
+	HandlerType: (*BatchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ProcessBatch",
+			Handler:    _Batch_ProcessBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api.proto",
+}
+
+// SymStreamClient is the client API for SymStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SymStreamClient interface {
+	EncryptSymStream(ctx context.Context, opts ...grpc.CallOption) (SymStream_EncryptSymStreamClient, error)
+	DecryptSymStream(ctx context.Context, opts ...grpc.CallOption) (SymStream_DecryptSymStreamClient, error)
+}
+
+type symStreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSymStreamClient(cc grpc.ClientConnInterface) SymStreamClient {
+	return &symStreamClient{cc}
+}
+
+func (c *symStreamClient) EncryptSymStream(ctx context.Context, opts ...grpc.CallOption) (SymStream_EncryptSymStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SymStream_ServiceDesc.Streams[0], "/grpc_api.SymStream/EncryptSymStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &symStreamEncryptSymStreamClient{stream}
+	return x, nil
+}
+
+type SymStream_EncryptSymStreamClient interface {
+	Send(*EncryptSymStreamRequest) error
+	Recv() (*EncryptSymStreamResponse, error)
+	grpc.ClientStream
+}
+
+type symStreamEncryptSymStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *symStreamEncryptSymStreamClient) Send(m *EncryptSymStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *symStreamEncryptSymStreamClient) Recv() (*EncryptSymStreamResponse, error) {
+	m := new(EncryptSymStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *symStreamClient) DecryptSymStream(ctx context.Context, opts ...grpc.CallOption) (SymStream_DecryptSymStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SymStream_ServiceDesc.Streams[1], "/grpc_api.SymStream/DecryptSymStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &symStreamDecryptSymStreamClient{stream}
+	return x, nil
+}
+
+type SymStream_DecryptSymStreamClient interface {
+	Send(*DecryptSymStreamRequest) error
+	Recv() (*DecryptSymStreamResponse, error)
+	grpc.ClientStream
+}
+
+type symStreamDecryptSymStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *symStreamDecryptSymStreamClient) Send(m *DecryptSymStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *symStreamDecryptSymStreamClient) Recv() (*DecryptSymStreamResponse, error) {
+	m := new(DecryptSymStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SymStreamServer is the server API for SymStream service.
+// All implementations must embed UnimplementedSymStreamServer
+// for forward compatibility
+type SymStreamServer interface {
+	EncryptSymStream(SymStream_EncryptSymStreamServer) error
+	DecryptSymStream(SymStream_DecryptSymStreamServer) error
+	mustEmbedUnimplementedSymStreamServer()
+}
+
+// UnimplementedSymStreamServer must be embedded to have forward compatible implementations.
+type UnimplementedSymStreamServer struct {
+}
+
+func (UnimplementedSymStreamServer) EncryptSymStream(SymStream_EncryptSymStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method EncryptSymStream not implemented")
+}
+func (UnimplementedSymStreamServer) DecryptSymStream(SymStream_DecryptSymStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method DecryptSymStream not implemented")
+}
+func (UnimplementedSymStreamServer) mustEmbedUnimplementedSymStreamServer() {}
+
+// UnsafeSymStreamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SymStreamServer will
+// result in compilation errors.
+type UnsafeSymStreamServer interface {
+	mustEmbedUnimplementedSymStreamServer()
+}
+
+func RegisterSymStreamServer(s grpc.ServiceRegistrar, srv SymStreamServer) {
+	s.RegisterService(&SymStream_ServiceDesc, srv)
+}
+
+func _SymStream_EncryptSymStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SymStreamServer).EncryptSymStream(&symStreamEncryptSymStreamServer{stream})
+}
+
+type SymStream_EncryptSymStreamServer interface {
+	Send(*EncryptSymStreamResponse) error
+	Recv() (*EncryptSymStreamRequest, error)
+	grpc.ServerStream
+}
+
+type symStreamEncryptSymStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *symStreamEncryptSymStreamServer) Send(m *EncryptSymStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *symStreamEncryptSymStreamServer) Recv() (*EncryptSymStreamRequest, error) {
+	m := new(EncryptSymStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _SymStream_DecryptSymStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SymStreamServer).DecryptSymStream(&symStreamDecryptSymStreamServer{stream})
+}
+
+type SymStream_DecryptSymStreamServer interface {
+	Send(*DecryptSymStreamResponse) error
+	Recv() (*DecryptSymStreamRequest, error)
+	grpc.ServerStream
+}
+
+type symStreamDecryptSymStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *symStreamDecryptSymStreamServer) Send(m *DecryptSymStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *symStreamDecryptSymStreamServer) Recv() (*DecryptSymStreamRequest, error) {
+	m := new(DecryptSymStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SymStream_ServiceDesc is the grpc.ServiceDesc for SymStream service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SymStream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc_api.SymStream",
+	HandlerType: (*SymStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "EncryptSymStream",
+			Handler:       _SymStream_EncryptSymStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DecryptSymStream",
+			Handler:       _SymStream_DecryptSymStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api.proto",
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc_api
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrEmptyBatchItem error used if item of batch doesn't contain any request
+var ErrEmptyBatchItem = errors.New("batch item doesn't contain request")
+
+// processBatch passes every item of batch to the method of service according to item's request type. Errors don't
+// stop processing, each of them is returned as status code and message of related item
+func processBatch(ctx context.Context, service DecryptService, request *BatchRequest) *BatchResponse {
+	response := &BatchResponse{Items: make([]*BatchResponseItem, 0, len(request.Items))}
+	for _, item := range request.Items {
+		var err error
+		itemResponse := &BatchResponseItem{}
+		switch itemRequest := item.GetRequest().(type) {
+		case *BatchRequestItem_Encrypt:
+			var result *EncryptResponse
+			result, err = service.Encrypt(ctx, itemRequest.Encrypt)
+			itemResponse.Response = &BatchResponseItem_Encrypt{Encrypt: result}
+		case *BatchRequestItem_Decrypt:
+			var result *DecryptResponse
+			result, err = service.Decrypt(ctx, itemRequest.Decrypt)
+			itemResponse.Response = &BatchResponseItem_Decrypt{Decrypt: result}
+		case *BatchRequestItem_EncryptSym:
+			var result *EncryptSymResponse
+			result, err = service.EncryptSym(ctx, itemRequest.EncryptSym)
+			itemResponse.Response = &BatchResponseItem_EncryptSym{EncryptSym: result}
+		case *BatchRequestItem_DecryptSym:
+			var result *DecryptSymResponse
+			result, err = service.DecryptSym(ctx, itemRequest.DecryptSym)
+			itemResponse.Response = &BatchResponseItem_DecryptSym{DecryptSym: result}
+		case *BatchRequestItem_Tokenize:
+			var result *TokenizeResponse
+			result, err = service.Tokenize(ctx, itemRequest.Tokenize)
+			itemResponse.Response = &BatchResponseItem_Tokenize{Tokenize: result}
+		case *BatchRequestItem_Detokenize:
+			var result *TokenizeResponse
+			result, err = service.Detokenize(ctx, itemRequest.Detokenize)
+			itemResponse.Response = &BatchResponseItem_Detokenize{Detokenize: result}
+		default:
+			err = status.Error(codes.InvalidArgument, ErrEmptyBatchItem.Error())
+		}
+		if err != nil {
+			itemStatus := status.Convert(serviceErrorToStatus(err))
+			itemResponse = &BatchResponseItem{Code: uint32(itemStatus.Code()), Error: itemStatus.Message()}
+		}
+		response.Items = append(response.Items, itemResponse)
+	}
+	return response
+}
@@ -0,0 +1,63 @@
+package grpc_api
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+type batchTestService struct {
+	UnimplementedReaderServer
+	UnimplementedReaderSymServer
+	UnimplementedTokenizatorServer
+	UnimplementedSearchableEncryptionServer
+	UnimplementedWriterServer
+	UnimplementedWriterSymServer
+	UnimplementedBatchServer
+	UnimplementedSymStreamServer
+}
+
+func (service *batchTestService) Encrypt(ctx context.Context, request *EncryptRequest) (*EncryptResponse, error) {
+	if len(request.ClientId) == 0 {
+		return nil, ErrEmptyClientID
+	}
+	return &EncryptResponse{Acrastruct: append(append([]byte{}, request.ClientId...), request.Data...)}, nil
+}
+
+func TestProcessBatch(t *testing.T) {
+	request := &BatchRequest{Items: []*BatchRequestItem{
+		{Request: &BatchRequestItem_Encrypt{Encrypt: &EncryptRequest{ClientId: []byte("client1"), Data: []byte("data1")}}},
+		{Request: &BatchRequestItem_Encrypt{Encrypt: &EncryptRequest{ClientId: []byte("client2"), Data: []byte("data2")}}},
+		{Request: &BatchRequestItem_Encrypt{Encrypt: &EncryptRequest{Data: []byte("data3")}}},
+		{Request: &BatchRequestItem_DecryptSym{DecryptSym: &DecryptSymRequest{ClientId: []byte("client1")}}},
+		{},
+	}}
+	response := processBatch(context.Background(), &batchTestService{}, request)
+	if len(response.Items) != len(request.Items) {
+		t.Fatalf("Expected %d items, took %d", len(request.Items), len(response.Items))
+	}
+	expectedCodes := []codes.Code{codes.OK, codes.OK, codes.Unknown, codes.Unimplemented, codes.InvalidArgument}
+	for i, code := range expectedCodes {
+		if codes.Code(response.Items[i].Code) != code {
+			t.Fatalf("Item %d: expected code %s, took %s", i, code, codes.Code(response.Items[i].Code))
+		}
+		if code == codes.OK && response.Items[i].Error != "" {
+			t.Fatalf("Item %d: expected empty error, took %s", i, response.Items[i].Error)
+		}
+		if code != codes.OK && (response.Items[i].Error == "" || response.Items[i].Response != nil) {
+			t.Fatalf("Item %d: expected only error message", i)
+		}
+	}
+	if !bytes.Equal(response.Items[0].GetEncrypt().Acrastruct, []byte("client1data1")) ||
+		!bytes.Equal(response.Items[1].GetEncrypt().Acrastruct, []byte("client2data2")) {
+		t.Fatal("Items should be processed with their own clientID")
+	}
+	if response.Items[2].Error != ErrEmptyClientID.Error() {
+		t.Fatalf("Expected %s error, took %s", ErrEmptyClientID, response.Items[2].Error)
+	}
+	if response.Items[4].Error != ErrEmptyBatchItem.Error() {
+		t.Fatalf("Expected %s error, took %s", ErrEmptyBatchItem, response.Items[4].Error)
+	}
+}
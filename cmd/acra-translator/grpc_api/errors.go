@@ -0,0 +1,30 @@
+package grpc_api
+
+import (
+	"context"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serviceErrorToStatus converts errors of translator service which have own gRPC status codes
+func serviceErrorToStatus(err error) error {
+	if common.IsRateLimitError(err) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if common.IsAccessReasonError(err) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+func unaryServiceErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	response, err := handler(ctx, req)
+	return response, serviceErrorToStatus(err)
+}
+
+func streamServiceErrorInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return serviceErrorToStatus(handler(srv, stream))
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/cossacklabs/acra/network"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -24,13 +26,25 @@ func NewServer(data *common.TranslatorData, connectionWrapper network.GRPCConnec
 	if err != nil {
 		return nil, err
 	}
+	if data.RateLimiter != nil {
+		serviceWithMetrics = common.NewRateLimitServiceWrapper(serviceWithMetrics, data.RateLimiter)
+	}
+	serviceWithMetrics = common.NewAccessReasonServiceWrapper(serviceWithMetrics, data.AccessReasonRequired)
 
 	newService, err = NewTranslatorService(serviceWithMetrics, data)
 	if err != nil {
 		return nil, err
 	}
 
-	if data.UseConnectionClientID {
+	if data.JWTClientIDExtractor != nil {
+		logrus.Infoln("Wrap gRPC service to use clientID from JWT")
+		newService, err = NewJWTDecryptServiceWrapper(newService, data.JWTClientIDExtractor)
+		if err != nil {
+			logrus.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleGRPCConnection).
+				Errorln("Can't wrap gRPC service with JWT wrapper")
+			return nil, err
+		}
+	} else if data.UseConnectionClientID {
 		logrus.Infoln("Wrap gRPC service to use clientID from connection")
 		newService, err = NewTLSDecryptServiceWrapper(newService, data.TLSClientIDExtractor)
 		if err != nil {
@@ -40,7 +54,9 @@ func NewServer(data *common.TranslatorData, connectionWrapper network.GRPCConnec
 		}
 	}
 
-	opts = append(opts, grpc.ConnectionTimeout(network.DefaultNetworkTimeout))
+	opts = append(opts, grpc.ConnectionTimeout(network.DefaultNetworkTimeout),
+		grpc.ChainUnaryInterceptor(newTracingUnaryInterceptor(data.TraceToLog), unaryServiceErrorInterceptor),
+		grpc.ChainStreamInterceptor(newTracingStreamInterceptor(data.TraceToLog), streamServiceErrorInterceptor))
 	grpcServer := grpc.NewServer(opts...)
 	RegisterReaderServer(grpcServer, newService)
 	RegisterWriterServer(grpcServer, newService)
@@ -48,7 +64,15 @@ func NewServer(data *common.TranslatorData, connectionWrapper network.GRPCConnec
 	RegisterSearchableEncryptionServer(grpcServer, newService)
 	RegisterReaderSymServer(grpcServer, newService)
 	RegisterWriterSymServer(grpcServer, newService)
+	RegisterBatchServer(grpcServer, newService)
+	RegisterSymStreamServer(grpcServer, newService)
 	OngRPCServerInit(grpcServer, data, newService)
+	// Register health checking service for probes of orchestrators and load balancers
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	for serviceName := range grpcServer.GetServiceInfo() {
+		healthServer.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	}
 	// Register reflection service on gRPC server.
 	reflection.Register(grpcServer)
 	return grpcServer, nil
@@ -0,0 +1,34 @@
+package grpc_api
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	translatorCommon "github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/keystore/mocks"
+)
+
+func TestHealthService(t *testing.T) {
+	data := &translatorCommon.TranslatorData{Keystorage: &mocks.TranslationKeyStore{}, Tokenizer: newTokenizer(t)}
+	server := newServer(data, nil, t)
+	defer server.Stop()
+	conn := server.NewConnection([]grpc.DialOption{grpc.WithInsecure(), getgRPCUnixDialer()}, t)
+	defer conn.Close()
+
+	healthClient := healthpb.NewHealthClient(conn)
+	for _, serviceName := range []string{"", Reader_ServiceDesc.ServiceName, SymStream_ServiceDesc.ServiceName} {
+		response, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: serviceName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("Expected SERVING status of %q, took %s", serviceName, response.Status)
+		}
+	}
+	if _, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); err == nil {
+		t.Fatal("Expected error for unknown service")
+	}
+}
@@ -0,0 +1,49 @@
+package grpc_api
+
+import (
+	"context"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationMetadataKey key of gRPC metadata with "Bearer <token>" value, same as HTTP header
+const authorizationMetadataKey = "authorization"
+
+// getClientIDFromJWT returns clientID from JWT passed in metadata of request
+func getClientIDFromJWT(ctx context.Context, extractor *network.JWTClientIDExtractor) ([]byte, error) {
+	clientID, err := extractClientIDFromJWT(ctx, extractor)
+	if err != nil {
+		logging.GetLoggerFromContext(ctx).WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorClientIDMissing).
+			Warningln("Can't authenticate gRPC request with JWT")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return clientID, nil
+}
+
+func extractClientIDFromJWT(ctx context.Context, extractor *network.JWTClientIDExtractor) ([]byte, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, network.ErrMissingJWT
+	}
+	values := md.Get(authorizationMetadataKey)
+	if len(values) != 1 {
+		return nil, network.ErrMissingJWT
+	}
+	token, err := network.GetBearerToken(values[0])
+	if err != nil {
+		return nil, err
+	}
+	return extractor.ExtractClientID(token)
+}
+
+// NewJWTDecryptServiceWrapper return new service wrapper which use clientID from JWT passed in "authorization" metadata
+func NewJWTDecryptServiceWrapper(service DecryptService, jwtClientIDExtractor *network.JWTClientIDExtractor) (*TLSDecryptServiceWrapper, error) {
+	clientIDFromContext := func(ctx context.Context) ([]byte, error) {
+		return getClientIDFromJWT(ctx, jwtClientIDExtractor)
+	}
+	return newClientIDDecryptServiceWrapper(service, clientIDFromContext), nil
+}
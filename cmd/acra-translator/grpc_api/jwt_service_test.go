@@ -0,0 +1,72 @@
+package grpc_api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/network"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestJWTDecryptServiceWrapper(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &privateKey.PublicKey, KeyID: "key"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := network.NewStaticJWTKeySet(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}, (&jose.SignerOptions{}).WithHeader("kid", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "client", Issuer: "issuer", Audience: jwt.Audience{"acra-translator"},
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor, err := network.NewJWTClientIDExtractor(keySet, "issuer", "acra-translator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := NewJWTDecryptServiceWrapper(&batchTestService{}, extractor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMetadataKey, "Bearer "+token))
+	response, err := wrapper.Encrypt(ctx, &EncryptRequest{ClientId: []byte("other"), Data: []byte("data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response.Acrastruct, []byte("clientdata")) {
+		t.Fatalf("Expected clientID from JWT, took %s", response.Acrastruct)
+	}
+
+	invalidContexts := []context.Context{
+		context.Background(),
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMetadataKey, "Bearer invalid")),
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMetadataKey, token)),
+	}
+	for i, ctx := range invalidContexts {
+		_, err := wrapper.Encrypt(ctx, &EncryptRequest{ClientId: []byte("client"), Data: []byte("data")})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("[%d] Expected Unauthenticated error, took %v", i, err)
+		}
+	}
+}
@@ -21,11 +21,11 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
-	"github.com/cossacklabs/acra/acrablock"
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
 	"github.com/cossacklabs/acra/decryptor/base"
-	"github.com/cossacklabs/acra/hmac"
 	"github.com/cossacklabs/acra/logging"
 	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
 )
@@ -47,6 +47,8 @@ type TranslatorService struct {
 	UnimplementedSearchableEncryptionServer
 	UnimplementedWriterServer
 	UnimplementedWriterSymServer
+	UnimplementedBatchServer
+	UnimplementedSymStreamServer
 }
 
 // NewTranslatorService return new TranslatorService instance
@@ -57,7 +59,8 @@ func NewTranslatorService(service common.ITranslatorService, translatorData *com
 	}
 	return &TranslatorService{translatorData, logger, service,
 		UnimplementedReaderServer{}, UnimplementedReaderSymServer{}, UnimplementedTokenizatorServer{},
-		UnimplementedSearchableEncryptionServer{}, UnimplementedWriterServer{}, UnimplementedWriterSymServer{}}, nil
+		UnimplementedSearchableEncryptionServer{}, UnimplementedWriterServer{}, UnimplementedWriterSymServer{},
+		UnimplementedBatchServer{}, UnimplementedSymStreamServer{}}, nil
 }
 
 // Errors possible during decrypting AcraStructs.
@@ -89,7 +92,7 @@ func (service *TranslatorService) Decrypt(ctx context.Context, request *DecryptR
 	logger.Debugln("New request")
 	defer logger.WithFields(logrus.Fields{"client_id": string(request.ClientId), "operation": "Decrypt"}).Debugln("End processing request")
 
-	response, err := service.service.Decrypt(ctx, request.Acrastruct, request.ClientId, nil)
+	response, err := service.service.Decrypt(common.SetAccessReasonToContext(ctx, request.Reason), request.Acrastruct, request.ClientId, nil)
 	if err != nil {
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).WithError(err).Errorln("Can't decrypt AcraStruct")
 		return nil, err
@@ -118,7 +121,7 @@ func (service *TranslatorService) DecryptSearchable(ctx context.Context, request
 	logger.Debugln("New request")
 	defer logger.WithFields(logrus.Fields{"client_id": string(request.ClientId), "operation": "Decrypt (searchable)"}).Debugln("End processing request")
 
-	response, err := service.service.DecryptSearchable(ctx, request.Data, request.Hash, request.ClientId, nil)
+	response, err := service.service.DecryptSearchable(common.SetAccessReasonToContext(ctx, request.Reason), request.Data, request.Hash, request.ClientId, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't decrypt AcraStruct")
 		return nil, err
@@ -132,7 +135,12 @@ func (service *TranslatorService) GenerateQueryHash(ctx context.Context, request
 	logger.Debugln("New request")
 	defer logger.WithFields(logrus.Fields{"client_id": string(request.ClientId), "operation": "GenerateQueryHash"}).Debugln("End processing request")
 
-	response, err := service.service.GenerateQueryHash(ctx, request.Data, request.ClientId, nil)
+	clientID, err := common.GetQueryHashClientID(service.data.TableSchemaStore, request.Table, request.Column, request.ClientId)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{"table": request.Table, "column": request.Column}).Errorln("Can't find searchable column")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response, err := service.service.GenerateQueryHash(ctx, request.Data, clientID, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't generate hash")
 		return nil, err
@@ -220,7 +228,7 @@ func (service *TranslatorService) Detokenize(ctx context.Context, request *Token
 		return nil, errors.New("unsupported value type")
 	}
 
-	response, err := service.service.Detokenize(ctx, data, tokenType, request.ClientId, nil)
+	response, err := service.service.Detokenize(common.SetAccessReasonToContext(ctx, request.Reason), data, tokenType, request.ClientId, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't detokenize data")
 		return nil, err
@@ -257,28 +265,12 @@ func (service *TranslatorService) EncryptSymSearchable(ctx context.Context, requ
 		logger.Errorln("Empty ClientID")
 		return nil, ErrEmptyClientID
 	}
-	logger.Debugln("Load encryption symmetric key from KeyStore")
-	symKey, err := service.data.Keystorage.GetClientIDSymmetricKey(request.ClientId)
-	if err != nil {
-		logger.WithError(err).Errorln("Can't load symmetric keys")
-		return nil, ErrKeysNotFound
-	}
-
-	logger.Debugln("Load secret key for HMAC from KeyStore")
-	hmacKey, err := service.data.Keystorage.GetHMACSecretKey(request.ClientId)
-	if err != nil {
-		logger.WithError(err).Errorln("Can't load HMAC key")
-		return nil, ErrKeysNotFound
-	}
-	logger.Debugln("Generate HMAC")
-	dataHash := hmac.GenerateHMAC(hmacKey, request.Data)
-	logger.Debugln("Create AcraBlock")
-	acrastruct, err := acrablock.CreateAcraBlock(request.Data, symKey, nil)
+	response, err := service.service.EncryptSymSearchable(ctx, request.Data, request.ClientId, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't create AcraBlock")
-		return nil, ErrEncryptionFailed
+		return nil, err
 	}
-	return &SearchableSymEncryptionResponse{Hash: dataHash, Acrablock: acrastruct}, nil
+	return &SearchableSymEncryptionResponse{Hash: response.Hash, Acrablock: response.EncryptedData}, nil
 }
 
 // DecryptSymSearchable AcraBlock and verify hash
@@ -291,9 +283,12 @@ func (service *TranslatorService) DecryptSymSearchable(ctx context.Context, requ
 		return nil, ErrEmptyClientID
 	}
 
-	decrypted, err := service.service.DecryptSymSearchable(ctx, request.Data, request.Hash, request.ClientId, nil)
+	decrypted, err := service.service.DecryptSymSearchable(common.SetAccessReasonToContext(ctx, request.Reason), request.Data, request.Hash, request.ClientId, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't decrypt searchable AcraBlock")
+		if common.IsRateLimitError(err) || common.IsAccessReasonError(err) {
+			return nil, err
+		}
 		return nil, ErrCantDecrypt
 	}
 
@@ -319,10 +314,42 @@ func (service *TranslatorService) DecryptSym(ctx context.Context, request *Decry
 	logger := service.logger.WithFields(logrus.Fields{"client_id": string(request.ClientId), "operation": "DecryptSym"})
 	logger.Debugln("New request")
 	defer logger.WithFields(logrus.Fields{"client_id": string(request.ClientId), "operation": "DecryptSym"}).Debugln("End processing request")
-	response, err := service.service.DecryptSym(ctx, request.Acrablock, request.ClientId, nil)
+	response, err := service.service.DecryptSym(common.SetAccessReasonToContext(ctx, request.Reason), request.Acrablock, request.ClientId, nil)
 	if err != nil {
 		logger.WithError(err).Errorln("Can't create AcraStruct")
 		return nil, err
 	}
 	return &DecryptSymResponse{Data: response}, nil
 }
+
+// ProcessBatch processes every item of batch with related method and returns result of each of them
+func (service *TranslatorService) ProcessBatch(ctx context.Context, request *BatchRequest) (*BatchResponse, error) {
+	logger := service.logger.WithFields(logrus.Fields{"operation": "ProcessBatch", "items": len(request.Items)})
+	logger.Debugln("New request")
+	defer logger.Debugln("End processing request")
+	return processBatch(ctx, service, request), nil
+}
+
+// EncryptSymStream encrypts every chunk of data from stream with AcraBlock
+func (service *TranslatorService) EncryptSymStream(stream SymStream_EncryptSymStreamServer) error {
+	logger := service.logger.WithField("operation", "EncryptSymStream")
+	logger.Debugln("New request")
+	defer logger.Debugln("End processing request")
+	if err := encryptSymStream(service, stream); err != nil {
+		logger.WithError(err).Errorln("Can't process stream")
+		return err
+	}
+	return nil
+}
+
+// DecryptSymStream decrypts concatenated AcraBlocks from stream
+func (service *TranslatorService) DecryptSymStream(stream SymStream_DecryptSymStreamServer) error {
+	logger := service.logger.WithField("operation", "DecryptSymStream")
+	logger.Debugln("New request")
+	defer logger.Debugln("End processing request")
+	if err := decryptSymStream(service, stream); err != nil {
+		logger.WithError(err).Errorln("Can't process stream")
+		return err
+	}
+	return nil
+}
@@ -133,7 +133,7 @@ func testTranslatorService(storage common.TokenStorage, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	translatorData := &translatorCommon.TranslatorData{tokenizer, nil, nil, nil, false, nil}
+	translatorData := &translatorCommon.TranslatorData{Tokenizer: tokenizer}
 	serviceImplementation, err := translatorCommon.NewTranslatorService(translatorData)
 	if err != nil {
 		t.Fatal(err)
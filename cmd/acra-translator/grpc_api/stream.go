@@ -0,0 +1,107 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc_api
+
+import (
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cossacklabs/acra/crypto"
+)
+
+// maxStreamContainerLength limits size of one AcraBlock buffered by DecryptSymStream
+const maxStreamContainerLength = 64 * 1024 * 1024
+
+// Errors related to processing of streams
+var (
+	ErrIncompleteStreamContainer = errors.New("stream ends with incomplete AcraBlock")
+	ErrStreamContainerTooLarge   = errors.New("AcraBlock in stream is too large")
+)
+
+// encryptSymStream encrypts data of every message from stream into separate AcraBlock with service and sends it back
+// in the same order. ClientID of the first message is used for the whole stream
+func encryptSymStream(service DecryptService, stream SymStream_EncryptSymStreamServer) error {
+	var clientID []byte
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(clientID) == 0 {
+			clientID = request.ClientId
+		}
+		response, err := service.EncryptSym(stream.Context(), &EncryptSymRequest{ClientId: clientID, Data: request.Data})
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&EncryptSymStreamResponse{Acrablock: response.Acrablock}); err != nil {
+			return err
+		}
+	}
+}
+
+// decryptSymStream collects concatenated AcraBlocks from stream, decrypts every complete AcraBlock with service and
+// sends decrypted data back. ClientID and reason of the first message are used for the whole stream
+func decryptSymStream(service DecryptService, stream SymStream_DecryptSymStreamServer) error {
+	var clientID, buffer []byte
+	var reason string
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			if len(buffer) != 0 {
+				return status.Error(codes.InvalidArgument, ErrIncompleteStreamContainer.Error())
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(clientID) == 0 {
+			clientID = request.ClientId
+		}
+		if reason == "" {
+			reason = request.Reason
+		}
+		buffer = append(buffer, request.Acrablocks...)
+		for len(buffer) > crypto.SerializedContainerMinSize {
+			length, err := crypto.GetSerializedContainerLength(buffer)
+			if err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			if length > maxStreamContainerLength {
+				return status.Error(codes.InvalidArgument, ErrStreamContainerTooLarge.Error())
+			}
+			if len(buffer) < length {
+				break
+			}
+			response, err := service.DecryptSym(stream.Context(), &DecryptSymRequest{ClientId: clientID, Acrablock: buffer[:length], Reason: reason})
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&DecryptSymStreamResponse{Data: response.Data}); err != nil {
+				return err
+			}
+			buffer = buffer[length:]
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package grpc_api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cossacklabs/acra/crypto"
+)
+
+type streamTestService struct {
+	batchTestService
+}
+
+func (service *streamTestService) EncryptSym(ctx context.Context, request *EncryptSymRequest) (*EncryptSymResponse, error) {
+	if len(request.ClientId) == 0 {
+		return nil, ErrEmptyClientID
+	}
+	container, err := crypto.SerializeEncryptedData(append(append([]byte{}, request.ClientId...), request.Data...), crypto.AcraBlockEnvelopeID)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptSymResponse{Acrablock: container}, nil
+}
+
+func (service *streamTestService) DecryptSym(ctx context.Context, request *DecryptSymRequest) (*DecryptSymResponse, error) {
+	internal, _, err := crypto.DeserializeEncryptedData(request.Acrablock)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(internal, request.ClientId) {
+		return nil, ErrCantDecrypt
+	}
+	return &DecryptSymResponse{Data: internal[len(request.ClientId):]}, nil
+}
+
+type testEncryptSymStream struct {
+	grpc.ServerStream
+	requests  []*EncryptSymStreamRequest
+	responses []*EncryptSymStreamResponse
+}
+
+func (stream *testEncryptSymStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *testEncryptSymStream) Send(response *EncryptSymStreamResponse) error {
+	stream.responses = append(stream.responses, response)
+	return nil
+}
+
+func (stream *testEncryptSymStream) Recv() (*EncryptSymStreamRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	request := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return request, nil
+}
+
+type testDecryptSymStream struct {
+	grpc.ServerStream
+	requests  []*DecryptSymStreamRequest
+	responses []*DecryptSymStreamResponse
+}
+
+func (stream *testDecryptSymStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *testDecryptSymStream) Send(response *DecryptSymStreamResponse) error {
+	stream.responses = append(stream.responses, response)
+	return nil
+}
+
+func (stream *testDecryptSymStream) Recv() (*DecryptSymStreamRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	request := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return request, nil
+}
+
+// splitIntoDecryptRequests splits data into parts which don't match boundaries of AcraBlocks
+func splitIntoDecryptRequests(clientID, data []byte, partSize int) []*DecryptSymStreamRequest {
+	requests := []*DecryptSymStreamRequest{{ClientId: clientID}}
+	for len(data) > partSize {
+		requests = append(requests, &DecryptSymStreamRequest{Acrablocks: data[:partSize]})
+		data = data[partSize:]
+	}
+	return append(requests, &DecryptSymStreamRequest{Acrablocks: data})
+}
+
+func TestSymStream(t *testing.T) {
+	if err := crypto.InitRegistry(nil); err != nil {
+		t.Fatal(err)
+	}
+	service := &streamTestService{}
+	clientID := []byte("client")
+	chunks := [][]byte{[]byte("first chunk"), []byte("second chunk"), []byte("third chunk")}
+	encryptStream := &testEncryptSymStream{requests: []*EncryptSymStreamRequest{{ClientId: clientID, Data: chunks[0]}}}
+	for _, chunk := range chunks[1:] {
+		encryptStream.requests = append(encryptStream.requests, &EncryptSymStreamRequest{Data: chunk})
+	}
+	if err := encryptSymStream(service, encryptStream); err != nil {
+		t.Fatal(err)
+	}
+	if len(encryptStream.responses) != len(chunks) {
+		t.Fatalf("Expected %d AcraBlocks, took %d", len(chunks), len(encryptStream.responses))
+	}
+	var encrypted []byte
+	for _, response := range encryptStream.responses {
+		encrypted = append(encrypted, response.Acrablock...)
+	}
+
+	decryptStream := &testDecryptSymStream{requests: splitIntoDecryptRequests(clientID, encrypted, 5)}
+	if err := decryptSymStream(service, decryptStream); err != nil {
+		t.Fatal(err)
+	}
+	if len(decryptStream.responses) != len(chunks) {
+		t.Fatalf("Expected %d decrypted chunks, took %d", len(chunks), len(decryptStream.responses))
+	}
+	for i, response := range decryptStream.responses {
+		if !bytes.Equal(response.Data, chunks[i]) {
+			t.Fatalf("Expected %q, took %q", chunks[i], response.Data)
+		}
+	}
+
+	decryptStream = &testDecryptSymStream{requests: splitIntoDecryptRequests(clientID, encrypted[:len(encrypted)-1], 5)}
+	if err := decryptSymStream(service, decryptStream); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for incomplete AcraBlock, took %v", err)
+	}
+	if len(decryptStream.responses) != len(chunks)-1 {
+		t.Fatalf("Expected %d decrypted chunks before error, took %d", len(chunks)-1, len(decryptStream.responses))
+	}
+
+	decryptStream = &testDecryptSymStream{requests: splitIntoDecryptRequests(clientID, append([]byte("invalid data"), encrypted...), 5)}
+	if err := decryptSymStream(service, decryptStream); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for invalid data, took %v", err)
+	}
+}
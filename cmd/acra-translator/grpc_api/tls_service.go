@@ -14,18 +14,23 @@ type DecryptService interface {
 	ReaderSymServer
 	WriterSymServer
 	SearchableEncryptionServer
+	BatchServer
+	SymStreamServer
 }
 
 // TLSDecryptServiceWrapper wraps DecryptService and replace clientID in requests with clientID from connection info
+// or authentication token of request
 type TLSDecryptServiceWrapper struct {
-	decryptor            DecryptService
-	tlsClientIDExtractor network.TLSClientIDExtractor
+	decryptor           DecryptService
+	clientIDFromContext func(ctx context.Context) ([]byte, error)
 	UnimplementedReaderServer
 	UnimplementedReaderSymServer
 	UnimplementedTokenizatorServer
 	UnimplementedSearchableEncryptionServer
 	UnimplementedWriterServer
 	UnimplementedWriterSymServer
+	UnimplementedBatchServer
+	UnimplementedSymStreamServer
 }
 
 func getClientID(ctx context.Context, extractor network.TLSClientIDExtractor) ([]byte, error) {
@@ -38,7 +43,7 @@ func getClientID(ctx context.Context, extractor network.TLSClientIDExtractor) ([
 
 // Encrypt encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) Encrypt(ctx context.Context, request *EncryptRequest) (*EncryptResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +53,7 @@ func (wrapper *TLSDecryptServiceWrapper) Encrypt(ctx context.Context, request *E
 
 // Decrypt encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) Decrypt(ctx context.Context, request *DecryptRequest) (*DecryptResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +63,7 @@ func (wrapper *TLSDecryptServiceWrapper) Decrypt(ctx context.Context, request *D
 
 // Tokenize encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) Tokenize(ctx context.Context, request *TokenizeRequest) (*TokenizeResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +73,7 @@ func (wrapper *TLSDecryptServiceWrapper) Tokenize(ctx context.Context, request *
 
 // Detokenize encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) Detokenize(ctx context.Context, request *TokenizeRequest) (*TokenizeResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +83,7 @@ func (wrapper *TLSDecryptServiceWrapper) Detokenize(ctx context.Context, request
 
 // DecryptSym encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) DecryptSym(ctx context.Context, request *DecryptSymRequest) (*DecryptSymResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +93,7 @@ func (wrapper *TLSDecryptServiceWrapper) DecryptSym(ctx context.Context, request
 
 // EncryptSym encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) EncryptSym(ctx context.Context, request *EncryptSymRequest) (*EncryptSymResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +103,7 @@ func (wrapper *TLSDecryptServiceWrapper) EncryptSym(ctx context.Context, request
 
 // EncryptSearchable encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) EncryptSearchable(ctx context.Context, request *SearchableEncryptionRequest) (*SearchableEncryptionResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +113,7 @@ func (wrapper *TLSDecryptServiceWrapper) EncryptSearchable(ctx context.Context,
 
 // DecryptSearchable encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) DecryptSearchable(ctx context.Context, request *SearchableDecryptionRequest) (*SearchableDecryptionResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -118,7 +123,7 @@ func (wrapper *TLSDecryptServiceWrapper) DecryptSearchable(ctx context.Context,
 
 // EncryptSymSearchable encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) EncryptSymSearchable(ctx context.Context, request *SearchableSymEncryptionRequest) (*SearchableSymEncryptionResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +133,7 @@ func (wrapper *TLSDecryptServiceWrapper) EncryptSymSearchable(ctx context.Contex
 
 // DecryptSymSearchable encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) DecryptSymSearchable(ctx context.Context, request *SearchableSymDecryptionRequest) (*SearchableSymDecryptionResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +143,7 @@ func (wrapper *TLSDecryptServiceWrapper) DecryptSymSearchable(ctx context.Contex
 
 // GenerateQueryHash encrypt with clientID from connection info
 func (wrapper *TLSDecryptServiceWrapper) GenerateQueryHash(ctx context.Context, request *QueryHashRequest) (*QueryHashResponse, error) {
-	clientID, err := getClientID(ctx, wrapper.tlsClientIDExtractor)
+	clientID, err := wrapper.clientIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -146,9 +151,42 @@ func (wrapper *TLSDecryptServiceWrapper) GenerateQueryHash(ctx context.Context,
 	return wrapper.decryptor.GenerateQueryHash(ctx, request)
 }
 
+// ProcessBatch processes items of batch with clientID from connection info
+func (wrapper *TLSDecryptServiceWrapper) ProcessBatch(ctx context.Context, request *BatchRequest) (*BatchResponse, error) {
+	if _, err := wrapper.clientIDFromContext(ctx); err != nil {
+		return nil, err
+	}
+	// each item processed by methods of wrapper which replace clientID
+	return processBatch(ctx, wrapper, request), nil
+}
+
+// EncryptSymStream encrypts stream with clientID from connection info
+func (wrapper *TLSDecryptServiceWrapper) EncryptSymStream(stream SymStream_EncryptSymStreamServer) error {
+	if _, err := wrapper.clientIDFromContext(stream.Context()); err != nil {
+		return err
+	}
+	return encryptSymStream(wrapper, stream)
+}
+
+// DecryptSymStream decrypts stream with clientID from connection info
+func (wrapper *TLSDecryptServiceWrapper) DecryptSymStream(stream SymStream_DecryptSymStreamServer) error {
+	if _, err := wrapper.clientIDFromContext(stream.Context()); err != nil {
+		return err
+	}
+	return decryptSymStream(wrapper, stream)
+}
+
 // NewTLSDecryptServiceWrapper return new service wrapper which use clientID from TLS certificates
 func NewTLSDecryptServiceWrapper(service DecryptService, tlsClientIDExtractor network.TLSClientIDExtractor) (*TLSDecryptServiceWrapper, error) {
-	return &TLSDecryptServiceWrapper{service, tlsClientIDExtractor,
+	clientIDFromContext := func(ctx context.Context) ([]byte, error) {
+		return getClientID(ctx, tlsClientIDExtractor)
+	}
+	return newClientIDDecryptServiceWrapper(service, clientIDFromContext), nil
+}
+
+func newClientIDDecryptServiceWrapper(service DecryptService, clientIDFromContext func(ctx context.Context) ([]byte, error)) *TLSDecryptServiceWrapper {
+	return &TLSDecryptServiceWrapper{service, clientIDFromContext,
 		UnimplementedReaderServer{}, UnimplementedReaderSymServer{}, UnimplementedTokenizatorServer{},
-		UnimplementedSearchableEncryptionServer{}, UnimplementedWriterServer{}, UnimplementedWriterSymServer{}}, nil
+		UnimplementedSearchableEncryptionServer{}, UnimplementedWriterServer{}, UnimplementedWriterSymServer{},
+		UnimplementedBatchServer{}, UnimplementedSymStreamServer{}}
 }
@@ -0,0 +1,56 @@
+package grpc_api
+
+import (
+	"context"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+)
+
+// startRequestSpan starts span of gRPC method which continues trace passed by client in W3C Trace Context metadata
+func startRequestSpan(ctx context.Context, method string, traceToLog bool) (context.Context, *trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	parent, ok := common.SpanContextFromTraceContext(md.Get(common.TraceParentHeader), md.Get(common.TraceStateHeader))
+	return common.StartRequestSpan(ctx, method, parent, ok, traceToLog)
+}
+
+// endRequestSpan sets status of span by gRPC status of error and ends span
+func endRequestSpan(span *trace.Span, err error) {
+	if err != nil {
+		grpcStatus := status.Convert(err)
+		span.SetStatus(trace.Status{Code: int32(grpcStatus.Code()), Message: grpcStatus.Message()})
+	}
+	span.End()
+}
+
+func newTracingUnaryInterceptor(traceToLog bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startRequestSpan(ctx, info.FullMethod, traceToLog)
+		response, err := handler(ctx, req)
+		endRequestSpan(span, err)
+		return response, err
+	}
+}
+
+// tracedServerStream replaces context of stream with context of request span
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *tracedServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func newTracingStreamInterceptor(traceToLog bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startRequestSpan(stream.Context(), info.FullMethod, traceToLog)
+		err := handler(srv, &tracedServerStream{ServerStream: stream, ctx: ctx})
+		endRequestSpan(span, err)
+		return err
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,10 +19,12 @@ import (
 	log "github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opencensus.io/trace"
 
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
 	pseudonymizationCommon "github.com/cossacklabs/acra/pseudonymization/common"
@@ -43,6 +46,28 @@ func NewHTTPError(status int, message string) HTTPError {
 	return HTTPError{status, message}
 }
 
+// serviceErrorStatus returns HTTP status of response for error returned by translator service
+func serviceErrorStatus(err error) int {
+	if common.IsRateLimitError(err) {
+		return http.StatusTooManyRequests
+	}
+	if common.IsAccessReasonError(err) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, common.ErrReIdentificationDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// newServiceHTTPError return HTTPError for error returned by translator service, msg used for unprocessable requests
+func newServiceHTTPError(err error, msg string) HTTPError {
+	if common.IsRateLimitError(err) || common.IsAccessReasonError(err) || errors.Is(err, common.ErrReIdentificationDenied) {
+		return NewHTTPError(serviceErrorStatus(err), err.Error())
+	}
+	return NewHTTPError(http.StatusUnprocessableEntity, msg)
+}
+
 // RespondWithError encode error to proper response format and write to client using ctx
 func RespondWithError(ctx *gin.Context, err HTTPError) {
 	switch ctx.ContentType() {
@@ -106,6 +131,39 @@ func bindData(obj interface{}, data []byte, ctx *gin.Context) error {
 // encryptionHTTPRequest used to map json/xml/form data from HTTP requests
 type encryptionHTTPRequest struct {
 	Data binaryType `json:"data" swaggertype:"string" format:"base64" example:"ZGF0YQo="`
+	// Reason of access to plaintext data used by decryption operations
+	Reason string `json:"reason,omitempty" example:"support ticket 42"`
+}
+
+// queryHashHTTPRequest used to map json/xml data of query hash requests
+type queryHashHTTPRequest struct {
+	Data binaryType `json:"data" swaggertype:"string" format:"base64" example:"ZGF0YQo="`
+	// Table and Column from encryptor config to generate hash with the same key as AcraServer uses for searchable column
+	Table  string `json:"table,omitempty" example:"users"`
+	Column string `json:"column,omitempty" example:"email"`
+}
+
+// reIdentificationHTTPRequest used to map json/xml data of re-identification requests
+type reIdentificationHTTPRequest struct {
+	Pseudonym string `json:"pseudonym" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	// ClientID used by AcraServer to derive pseudonym, clientID of the request is used if empty
+	ClientID string `json:"client_id,omitempty" example:"client"`
+	// Reason of access to source value, always required
+	Reason string `json:"reason" example:"support ticket 42"`
+}
+
+// provisioningHTTPRequest used to map json/xml data of requests to provision new clientID
+type provisioningHTTPRequest struct {
+	ClientID string `json:"client_id" example:"client"`
+	// Certificates are PEM encoded certificates of clients allowed to use clientID, optional
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// provisioningHTTPResponse contains public material required by AcraWriter SDKs
+type provisioningHTTPResponse struct {
+	ClientID         string     `json:"client_id" example:"client"`
+	StoragePublicKey binaryType `swaggertype:"string" format:"base64" json:"storage_public_key" example:"VUVDMgAAAC2ELbj5Aue5"`
+	CertificatePins  []string   `json:"certificate_pins,omitempty" example:"mYfp5AtOQGWk2c9hPyGUlAuFsjNy8+Ct1S4wK5Qu3dQ="`
 }
 
 type encryptionHTTPResponse struct {
@@ -143,23 +201,32 @@ func NewHTTPService(service common.ITranslatorService, translatorData *common.Tr
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.Default()
 	engine.HandleMethodNotAllowed = true
+	engine.Use(tracingMiddleware(translatorData.TraceToLog))
 	// wrap service with metrics that track time of execution
 	serviceWithMetrics, err := common.NewPrometheusServiceWrapper(service, common.HTTPRequestType)
 	if err != nil {
 		return nil, err
 	}
+	if translatorData.RateLimiter != nil {
+		serviceWithMetrics = common.NewRateLimitServiceWrapper(serviceWithMetrics, translatorData.RateLimiter)
+	}
+	serviceWithMetrics = common.NewAccessReasonServiceWrapper(serviceWithMetrics, translatorData.AccessReasonRequired)
 	newHTTPService := &HTTPService{
 		service:        serviceWithMetrics,
 		engine:         engine,
 		ctx:            context.Background(),
 		translatorData: translatorData,
 	}
-	v1 := engine.Group("/v1")
+	var authHandlers []gin.HandlerFunc
+	if translatorData.JWTClientIDExtractor != nil {
+		authHandlers = append(authHandlers, newHTTPService.authenticateJWT)
+	}
+	v1 := engine.Group("/v1", authHandlers...)
 	{
 		v1.POST("/decrypt", newHTTPService.decryptOld)
 		v1.POST("/encrypt", newHTTPService.encryptOld)
 	}
-	v2 := engine.Group("/v2")
+	v2 := engine.Group("/v2", authHandlers...)
 	{
 		// OLD with GET method
 		// AcraStructs
@@ -191,6 +258,9 @@ func NewHTTPService(service common.ITranslatorService, translatorData *common.Tr
 		v2.POST("/generateQueryHash", newHTTPService.generateQueryHash)
 		v2.POST("/tokenize", newHTTPService.tokenize)
 		v2.POST("/detokenize", newHTTPService.detokenize)
+		v2.POST("/batch", newHTTPService.batch)
+		v2.POST("/reIdentify", newHTTPService.reIdentify)
+		v2.POST("/provisionClientID", newHTTPService.provisionClientID)
 
 		var confs []func(config *ginSwagger.Config)
 		if url, ok := os.LookupEnv("ACRA_TRANSLATOR_SWAGGER_SCHEMA_URL"); ok {
@@ -289,13 +359,69 @@ func (service *HTTPService) Start(listener net.Listener) error {
 	return service.server.Serve(listener)
 }
 
-func (service *HTTPService) decryptOld(ctx *gin.Context) {
-	logger := logging.GetLoggerFromContext(ctx.Request.Context())
+// tracingMiddleware starts span of request which continues trace passed by client in W3C Trace Context headers
+func tracingMiddleware(traceToLog bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		parent, ok := common.SpanContextFromTraceContext(ctx.Request.Header.Values(common.TraceParentHeader),
+			ctx.Request.Header.Values(common.TraceStateHeader))
+		requestCtx, span := common.StartRequestSpan(ctx.Request.Context(), ctx.Request.URL.Path, parent, ok, traceToLog)
+		defer span.End()
+		span.AddAttributes(trace.StringAttribute("method", ctx.Request.Method))
+		ctx.Request = ctx.Request.WithContext(requestCtx)
+
+		ctx.Next()
+		statusCode := ctx.Writer.Status()
+		span.AddAttributes(trace.Int64Attribute("status_code", int64(statusCode)))
+		if statusCode >= http.StatusBadRequest {
+			span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: http.StatusText(statusCode)})
+		}
+	}
+}
+
+// jwtClientIDKey key of gin context with clientID from authenticated JWT
+const jwtClientIDKey = "jwt_client_id"
+
+// authenticateJWT rejects requests without valid JWT in "Authorization: Bearer <token>" header and saves clientID
+// from token to the context. Swagger documentation is accessible without authentication
+func (service *HTTPService) authenticateJWT(ctx *gin.Context) {
+	if strings.HasPrefix(ctx.FullPath(), "/v2/swagger/") {
+		ctx.Next()
+		return
+	}
+	token, err := network.GetBearerToken(ctx.GetHeader("Authorization"))
+	var clientID []byte
+	if err == nil {
+		clientID, err = service.translatorData.JWTClientIDExtractor.ExtractClientID(token)
+	}
+	if err != nil {
+		logging.GetLoggerFromContext(ctx.Request.Context()).WithError(err).
+			WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorClientIDMissing).
+			Warningln("Can't authenticate HTTP request with JWT")
+		ctx.Header("WWW-Authenticate", "Bearer")
+		RespondWithError(ctx, NewHTTPError(http.StatusUnauthorized, "Invalid authentication token"))
+		ctx.Abort()
+		return
+	}
+	ctx.Set(jwtClientIDKey, clientID)
+	ctx.Next()
+}
+
+// getClientID returns clientID from JWT if request authenticated with it, otherwise clientID from connection
+func (service *HTTPService) getClientID(ctx *gin.Context) []byte {
+	if clientID, ok := ctx.Get(jwtClientIDKey); ok {
+		return clientID.([]byte)
+	}
 	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
+	clientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
 	if !ok {
-		connectionClientID = nil
+		return nil
 	}
+	return clientID
+}
+
+func (service *HTTPService) decryptOld(ctx *gin.Context) {
+	logger := logging.GetLoggerFromContext(ctx.Request.Context())
+	connectionClientID := service.getClientID(ctx)
 	if ctx.Request.Body == nil {
 		msg := fmt.Sprintf("HTTP request doesn't have a body, expected to get AcraStruct")
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
@@ -309,13 +435,13 @@ func (service *HTTPService) decryptOld(ctx *gin.Context) {
 		ctx.String(http.StatusBadRequest, msg)
 		return
 	}
-	decryptedStruct, err := service.service.Decrypt(service.ctx, acraStruct, connectionClientID, nil)
+	decryptedStruct, err := service.service.Decrypt(common.SetAccessReasonToContext(service.ctx, ctx.Query("reason")), acraStruct, connectionClientID, nil)
 	if err != nil {
 		//TODO: remove deprecated metrics in 1-2 versions
 		base.AcrastructDecryptionCounter.WithLabelValues(base.LabelStatusFail).Inc()
 		msg := fmt.Sprintf("Can't decrypt AcraStruct")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-		ctx.String(http.StatusUnprocessableEntity, msg)
+		ctx.String(serviceErrorStatus(err), msg)
 		return
 	}
 	//TODO: remove deprecated metrics in 1-2 versions
@@ -328,11 +454,7 @@ func (service *HTTPService) decryptOld(ctx *gin.Context) {
 func (service *HTTPService) encryptOld(ctx *gin.Context) {
 	log.Debugln("Process HTTP request to encrypt data")
 	logger := logging.GetLoggerFromContext(ctx.Request.Context())
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	if ctx.Request.Body == nil {
 		msg := fmt.Sprintf("HTTP request doesn't have a body, expected to get data")
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
@@ -350,7 +472,7 @@ func (service *HTTPService) encryptOld(ctx *gin.Context) {
 	if err != nil {
 		msg := fmt.Sprintf("Can't encrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-		ctx.String(http.StatusUnprocessableEntity, msg)
+		ctx.String(serviceErrorStatus(err), msg)
 		return
 	}
 	logger.Infoln("Encrypted data")
@@ -396,11 +518,7 @@ func (service *HTTPService) encrypt(ctx *gin.Context) {
 func (service *HTTPService) _encrypt(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "encrypt")
 	logger.Debugln("Process HTTP request to encrypt data")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -412,7 +530,7 @@ func (service *HTTPService) _encrypt(ctx *gin.Context, data []byte) (response en
 	if err != nil {
 		msg := fmt.Sprintf("Can't encrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantEncryptData).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	logger.Infoln("Encrypted data")
@@ -436,11 +554,7 @@ func (service *HTTPService) decrypt(ctx *gin.Context) {
 
 func (service *HTTPService) _decrypt(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "decrypt")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -448,13 +562,13 @@ func (service *HTTPService) _decrypt(ctx *gin.Context, data []byte) (response en
 		return
 	}
 
-	decryptedData, err := service.service.Decrypt(service.ctx, request.Data, connectionClientID, nil)
+	decryptedData, err := service.service.Decrypt(common.SetAccessReasonToContext(service.ctx, request.Reason), request.Data, connectionClientID, nil)
 	if err != nil {
 		//TODO: remove deprecated metrics in 1-2 versions
 		base.AcrastructDecryptionCounter.WithLabelValues(base.LabelStatusFail).Inc()
 		msg := fmt.Sprintf("Can't decrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	//TODO: remove deprecated metrics in 1-2 versions
@@ -480,11 +594,7 @@ func (service *HTTPService) encryptSearchable(ctx *gin.Context) {
 func (service *HTTPService) _encryptSearchable(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "encryptSearchable")
 	logger.Debugln("Process HTTP request to encrypt searchable data")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -501,7 +611,7 @@ func (service *HTTPService) _encryptSearchable(ctx *gin.Context, data []byte) (r
 	if err != nil {
 		msg := fmt.Sprintf("Can't encrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	logger.Infoln("Encrypted data")
@@ -525,11 +635,7 @@ func (service *HTTPService) decryptSearchable(ctx *gin.Context) {
 func (service *HTTPService) _decryptSearchable(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "decryptSearchable")
 	logger.Debugln("Process HTTP request to decrypt searchable AcraStruct")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -540,13 +646,13 @@ func (service *HTTPService) _decryptSearchable(ctx *gin.Context, data []byte) (r
 	hash := hmac.ExtractHash(request.Data)
 	hashData := hash.Marshal()
 	acraStruct := request.Data[len(hashData):]
-	decryptedData, err := service.service.DecryptSearchable(service.ctx, acraStruct, hashData, connectionClientID, nil)
+	decryptedData, err := service.service.DecryptSearchable(common.SetAccessReasonToContext(service.ctx, request.Reason), acraStruct, hashData, connectionClientID, nil)
 	if err != nil {
 		//TODO: remove deprecated metrics in 1-2 versions
 		base.AcrastructDecryptionCounter.WithLabelValues(base.LabelStatusFail).Inc()
 		msg := fmt.Sprintf("Can't decrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	//TODO: remove deprecated metrics in 1-2 versions
@@ -558,10 +664,10 @@ func (service *HTTPService) _decryptSearchable(ctx *gin.Context, data []byte) (r
 
 // generateQueryHash godoc
 // @Summary Generates hash
-// @Description generates hash for data that may be used as blind index
+// @Description generates hash for data that may be used as blind index. Hash for searchable column from encryptor config generated with key of its client_id if table and column passed
 // @Accept  json
 // @Produce  json
-// @Param data body string true "Binary data encoded as Base64 string"
+// @Param request body http_api.queryHashHTTPRequest true "Binary data encoded as Base64 string with optional table and column"
 // @Success 200 {object} http_api.encryptionHTTPResponse
 // @Failure 400 {object} http_api.HTTPError
 // @Failure 422 {object} http_api.HTTPError
@@ -572,12 +678,8 @@ func (service *HTTPService) generateQueryHash(ctx *gin.Context) {
 func (service *HTTPService) _generateQueryHash(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "generateQueryHash")
 	logger.Debugln("Process HTTP request to encrypt searchable data")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
-	request := encryptionHTTPRequest{}
+	connectionClientID := service.getClientID(ctx)
+	request := queryHashHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
 		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data")
@@ -588,14 +690,20 @@ func (service *HTTPService) _generateQueryHash(ctx *gin.Context, data []byte) (r
 		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data, empty data")
 		return
 	}
+	clientID, err := common.GetQueryHashClientID(service.translatorData.TableSchemaStore, request.Table, request.Column, connectionClientID)
+	if err != nil {
+		logger.WithError(err).WithFields(log.Fields{"table": request.Table, "column": request.Column}).Errorln("Can't find searchable column")
+		httpErr = NewHTTPError(http.StatusBadRequest, err.Error())
+		return
+	}
 
-	newHash, err := service.service.GenerateQueryHash(service.ctx, request.Data, connectionClientID, nil)
+	newHash, err := service.service.GenerateQueryHash(service.ctx, request.Data, clientID, nil)
 	if err != nil {
 		// TODO lagovas(2021-06-24) add and use proper metric
 		//base.AcrastructDecryptionCounter.WithLabelValues(base.EncryptionTypeFail).Inc()
 		msg := fmt.Sprintf("Can't calculate hash")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	// TODO lagovas(2021-06-24) add and use proper metric
@@ -621,11 +729,7 @@ func (service *HTTPService) encryptSymSearchable(ctx *gin.Context) {
 func (service *HTTPService) _encryptSymSearchable(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "encryptSymSearchable")
 	logger.Debugln("Process HTTP request to encrypt searchable data with AcraBlock")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -642,7 +746,7 @@ func (service *HTTPService) _encryptSymSearchable(ctx *gin.Context, data []byte)
 	if err != nil {
 		msg := fmt.Sprintf("Can't encrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	logger.Infoln("Encrypted data")
@@ -666,11 +770,7 @@ func (service *HTTPService) decryptSymSearchable(ctx *gin.Context) {
 func (service *HTTPService) _decryptSymSearchable(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "decryptSymSearchable")
 	logger.Debugln("Process HTTP request to decrypt searchable AcraBlock")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -686,11 +786,11 @@ func (service *HTTPService) _decryptSymSearchable(ctx *gin.Context, data []byte)
 	}
 	hashData := hash.Marshal()
 	acraStruct := request.Data[len(hashData):]
-	decryptedData, err := service.service.DecryptSymSearchable(service.ctx, acraStruct, hashData, connectionClientID, nil)
+	decryptedData, err := service.service.DecryptSymSearchable(common.SetAccessReasonToContext(service.ctx, request.Reason), acraStruct, hashData, connectionClientID, nil)
 	if err != nil {
 		msg := fmt.Sprintf("Can't decrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	logger.Infoln("Decrypted data")
@@ -714,11 +814,7 @@ func (service *HTTPService) encryptSym(ctx *gin.Context) {
 func (service *HTTPService) _encryptSym(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "encryptSym")
 	logger.Debugln("Process HTTP request to encrypt with AcraBlock")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -735,7 +831,7 @@ func (service *HTTPService) _encryptSym(ctx *gin.Context, data []byte) (response
 	if err != nil {
 		msg := fmt.Sprintf("Can't encrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	logger.Infoln("Encrypted data")
@@ -759,11 +855,7 @@ func (service *HTTPService) decryptSym(ctx *gin.Context) {
 func (service *HTTPService) _decryptSym(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "decryptSym")
 	logger.Debugln("Process HTTP request to decrypt searchable AcraBlock")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := encryptionHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -771,13 +863,13 @@ func (service *HTTPService) _decryptSym(ctx *gin.Context, data []byte) (response
 		return
 	}
 
-	decryptedData, err := service.service.DecryptSym(service.ctx, request.Data, connectionClientID, nil)
+	decryptedData, err := service.service.DecryptSym(common.SetAccessReasonToContext(service.ctx, request.Reason), request.Data, connectionClientID, nil)
 	if err != nil {
 		//TODO: remove deprecated metrics in 1-2 versions
 		base.AcrastructDecryptionCounter.WithLabelValues(base.LabelStatusFail).Inc()
 		msg := fmt.Sprintf("Can't decrypt data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	//TODO: remove deprecated metrics in 1-2 versions
@@ -791,6 +883,8 @@ func (service *HTTPService) _decryptSym(ctx *gin.Context, data []byte) (response
 type tokenizationHTTPRequest struct {
 	Data json.RawMessage                  `json:"data" swaggertype:"string,integer" example:"ZGF0YQo="`
 	Type pseudonymizationCommon.TokenType `json:"type" example:"1"`
+	// Reason of access to plaintext data used by detokenization
+	Reason string `json:"reason,omitempty" example:"support ticket 42"`
 }
 
 type tokenizationHTTPResponse struct {
@@ -862,11 +956,7 @@ func (service *HTTPService) tokenize(ctx *gin.Context) {
 func (service *HTTPService) _tokenize(ctx *gin.Context, data []byte) (response tokenizationHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "tokenize")
 	logger.Debugln("Process HTTP request to tokenize data")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := tokenizationHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -890,7 +980,7 @@ func (service *HTTPService) _tokenize(ctx *gin.Context, data []byte) (response t
 		//base.AcrastructDecryptionCounter.WithLabelValues(base.EncryptionTypeFail).Inc()
 		msg := fmt.Sprintf("Can't tokenize data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	response, err = prepareTokenizeResponse(tokenizedData, request.Type)
@@ -922,11 +1012,7 @@ func (service *HTTPService) detokenize(ctx *gin.Context) {
 func (service *HTTPService) _detokenize(ctx *gin.Context, data []byte) (response tokenizationHTTPResponse, httpErr HTTPError) {
 	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "detokenize")
 	logger.Debugln("Process HTTP request to detokenize data")
-	connection := network.GetConnectionFromHTTPContext(ctx.Request.Context())
-	connectionClientID, ok := network.GetClientIDFromConnection(connection, service.translatorData.TLSClientIDExtractor)
-	if !ok {
-		connectionClientID = nil
-	}
+	connectionClientID := service.getClientID(ctx)
 	request := tokenizationHTTPRequest{}
 	if err := bindData(&request, data, ctx); err != nil {
 		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
@@ -946,13 +1032,13 @@ func (service *HTTPService) _detokenize(ctx *gin.Context, data []byte) (response
 		return
 	}
 
-	detokenizedData, err := service.service.Detokenize(service.ctx, dataToDetokenize, request.Type, connectionClientID, nil)
+	detokenizedData, err := service.service.Detokenize(common.SetAccessReasonToContext(service.ctx, request.Reason), dataToDetokenize, request.Type, connectionClientID, nil)
 	if err != nil {
 		// TODO lagovas(2021-06-24) add and use proper metric
 		//base.AcrastructDecryptionCounter.WithLabelValues(base.EncryptionTypeFail).Inc()
 		msg := fmt.Sprintf("Can't detokenize data")
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
-		httpErr = NewHTTPError(http.StatusUnprocessableEntity, msg)
+		httpErr = newServiceHTTPError(err, msg)
 		return
 	}
 	if reflect.DeepEqual(dataToDetokenize, detokenizedData) {
@@ -974,6 +1060,184 @@ func (service *HTTPService) _detokenize(ctx *gin.Context, data []byte) (response
 	return
 }
 
+// reIdentify godoc
+// @Summary Re-identify pseudonym
+// @Description Return source value of pseudonym derived by AcraServer for pseudonymized column. Allowed only for ClientIDs from --re_identification_client_ids and requires reason of access
+// @Accept  json
+// @Produce  json
+// @Param data body http_api.reIdentificationHTTPRequest true "Pseudonym, its ClientID and reason of access"
+// @Success 200 {object} http_api.encryptionHTTPResponse
+// @Failure 400 {object} http_api.HTTPError
+// @Failure 403 {object} http_api.HTTPError
+// @Failure 422 {object} http_api.HTTPError
+// @Router /v2/reIdentify [post]
+func (service *HTTPService) reIdentify(ctx *gin.Context) {
+	callOperationImplementation(ctx, convertEncryptionFuncToOperation(service._reIdentify))
+}
+func (service *HTTPService) _reIdentify(ctx *gin.Context, data []byte) (response encryptionHTTPResponse, httpErr HTTPError) {
+	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "reIdentify")
+	logger.Debugln("Process HTTP request to re-identify pseudonym")
+	connectionClientID := service.getClientID(ctx)
+	request := reIdentificationHTTPRequest{}
+	if err := bindData(&request, data, ctx); err != nil {
+		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
+		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data")
+		return
+	}
+	if request.Pseudonym == "" {
+		logger.WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
+		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data, empty pseudonym")
+		return
+	}
+
+	sourceData, err := service.service.ReIdentify(common.SetAccessReasonToContext(service.ctx, request.Reason), []byte(request.Pseudonym), connectionClientID, []byte(request.ClientID))
+	if err != nil {
+		msg := "Can't re-identify pseudonym"
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln(msg)
+		httpErr = newServiceHTTPError(err, msg)
+		return
+	}
+	logger.Infoln("Re-identified pseudonym")
+	response = encryptionHTTPResponse{Data: sourceData}
+	return
+}
+
+// provisioningErrorStatus returns HTTP status of response for error returned by ClientIDProvisioner
+func provisioningErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrProvisioningDenied):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrClientIDExists):
+		return http.StatusConflict
+	case errors.Is(err, keystore.ErrInvalidClientID), errors.Is(err, common.ErrInvalidCertificate), errors.Is(err, common.ErrCertificatePinningNotEnabled):
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// provisionClientID godoc
+// @Summary Provision new ClientID
+// @Description Generate storage keypair, symmetric and HMAC keys of new ClientID and pin its certificates. Allowed only for ClientIDs from --provisioning_admin_client_ids. Returns public material required by AcraWriter SDKs
+// @Accept  json
+// @Produce  json
+// @Param data body http_api.provisioningHTTPRequest true "ClientID and PEM encoded certificates of its clients"
+// @Success 200 {object} http_api.provisioningHTTPResponse
+// @Failure 400 {object} http_api.HTTPError
+// @Failure 403 {object} http_api.HTTPError
+// @Failure 409 {object} http_api.HTTPError
+// @Failure 422 {object} http_api.HTTPError
+// @Router /v2/provisionClientID [post]
+func (service *HTTPService) provisionClientID(ctx *gin.Context) {
+	callOperationImplementation(ctx, func(ctx *gin.Context, data []byte) (interface{}, HTTPError) {
+		return service._provisionClientID(ctx, data)
+	})
+}
+func (service *HTTPService) _provisionClientID(ctx *gin.Context, data []byte) (response provisioningHTTPResponse, httpErr HTTPError) {
+	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "provisionClientID")
+	logger.Debugln("Process HTTP request to provision clientID")
+	if service.translatorData.Provisioner == nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorProvisioningDenied).Warningln("Provisioning of clientIDs is turned off")
+		httpErr = NewHTTPError(http.StatusForbidden, common.ErrProvisioningDenied.Error())
+		return
+	}
+	connectionClientID := service.getClientID(ctx)
+	request := provisioningHTTPRequest{}
+	if err := bindData(&request, data, ctx); err != nil {
+		logger.WithError(err).WithField("content_type", ctx.ContentType()).Errorln("Can't bind data")
+		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data")
+		return
+	}
+	provisioned, err := service.translatorData.Provisioner.Provision(service.ctx, connectionClientID, []byte(request.ClientID), request.Certificates)
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleHTTPRequest).Warningln("Can't provision clientID")
+		httpErr = NewHTTPError(provisioningErrorStatus(err), err.Error())
+		return
+	}
+	logger.Infoln("Provisioned clientID")
+	response = provisioningHTTPResponse{
+		ClientID:         string(provisioned.ClientID),
+		StoragePublicKey: provisioned.StoragePublicKey.Value,
+		CertificatePins:  provisioned.CertificatePins,
+	}
+	return
+}
+
+// batchItemHTTPRequest used to map operation name of batch item, other fields of item are the same as in request of
+// this operation
+type batchItemHTTPRequest struct {
+	Operation string `json:"operation" example:"encrypt"`
+}
+
+// batchHTTPRequest used to map json data of batch requests
+type batchHTTPRequest struct {
+	Items []json.RawMessage `json:"items" swaggertype:"array,object"`
+}
+
+type batchItemHTTPResponse struct {
+	Code     int         `json:"code" example:"200"`
+	Message  string      `json:"message,omitempty" example:"Can't decrypt data"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+type batchHTTPResponse struct {
+	Items []batchItemHTTPResponse `json:"items"`
+}
+
+// batch godoc
+// @Summary Process batch of operations
+// @Description Process list of encryption, decryption and tokenization operations with ClientID from connection. Every item has "operation" field with name of endpoint and the same fields as request of this endpoint. Failure of one item doesn't fail the batch, every item has own status code
+// @Accept  json
+// @Produce  json
+// @Param data body http_api.batchHTTPRequest true "Items with operation name and fields of operation request"
+// @Success 200 {object} http_api.batchHTTPResponse
+// @Failure 400 {object} http_api.HTTPError
+// @Router /v2/batch [post]
+func (service *HTTPService) batch(ctx *gin.Context) {
+	callOperationImplementation(ctx, func(ctx *gin.Context, data []byte) (interface{}, HTTPError) {
+		return service._batch(ctx, data)
+	})
+}
+
+func (service *HTTPService) _batch(ctx *gin.Context, data []byte) (response batchHTTPResponse, httpErr HTTPError) {
+	logger := logging.GetLoggerFromContext(ctx.Request.Context()).WithField("operation", "batch")
+	logger.Debugln("Process HTTP request to process batch")
+	// items are passed to operations as is, so only json supported
+	if ctx.ContentType() != gin.MIMEJSON {
+		logger.WithField("content_type", ctx.ContentType()).Errorln("Unsupported content type of batch request")
+		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data, batch supports only JSON")
+		return
+	}
+	request := batchHTTPRequest{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		logger.WithError(err).Errorln("Can't bind data")
+		httpErr = NewHTTPError(http.StatusBadRequest, "Invalid request data")
+		return
+	}
+	response.Items = make([]batchItemHTTPResponse, 0, len(request.Items))
+	for i, item := range request.Items {
+		itemRequest := batchItemHTTPRequest{}
+		if err := json.Unmarshal(item, &itemRequest); err != nil {
+			logger.WithError(err).WithField("item", i).Errorln("Can't bind batch item")
+			response.Items = append(response.Items, batchItemHTTPResponse{Code: http.StatusBadRequest, Message: "Invalid item data"})
+			continue
+		}
+		operation, err := service.operationToFunc(itemRequest.Operation)
+		if err != nil {
+			logger.WithField("item", i).WithField("item_operation", itemRequest.Operation).Errorln("Unsupported operation of batch item")
+			response.Items = append(response.Items, batchItemHTTPResponse{Code: http.StatusBadRequest, Message: "Unsupported operation"})
+			continue
+		}
+		itemResponse, itemErr := operation(ctx, item)
+		if !itemErr.Empty() {
+			response.Items = append(response.Items, batchItemHTTPResponse{Code: itemErr.Code, Message: itemErr.Message})
+			continue
+		}
+		response.Items = append(response.Items, batchItemHTTPResponse{Code: http.StatusOK, Response: itemResponse})
+	}
+	logger.WithField("items", len(request.Items)).Infoln("Processed batch")
+	return
+}
+
 func renderResponse(obj interface{}, ctx *gin.Context, logger *log.Entry) {
 	switch ctx.ContentType() {
 	case gin.MIMEJSON:
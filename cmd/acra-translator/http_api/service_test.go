@@ -3,6 +3,9 @@ package http_api
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -11,6 +14,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"text/template"
@@ -29,6 +33,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 type dialer func(ctx context.Context, network, addr string) (net.Conn, error)
@@ -329,6 +335,7 @@ func testHTTPAPIEndpoints(testContext apiTestContext, t *testing.T) {
 		testTokenizeDetokenize(testContext.endpoint, http.MethodGet, testTokenData, testContext.client, t)
 		testTokenizeDetokenize(testContext.endpoint, http.MethodPost, testTokenData, testContext.client, t)
 	}
+	testBatch(testContext.endpoint, testContext.client, t)
 }
 
 type encryptData struct {
@@ -495,8 +502,130 @@ func testTokenizeDetokenize(endpoint, method string, data tokenData, client *htt
 	}
 }
 
+func sendBatch(endpoint string, items []interface{}, client *http.Client, t *testing.T) batchHTTPResponse {
+	requestJSON, err := json.Marshal(map[string]interface{}{"items": items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v2/batch", endpoint), bytes.NewReader(requestJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Add("Content-Type", gin.MIMEJSON)
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := response.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	responseObject := batchHTTPResponse{}
+	if err := json.Unmarshal(responseBody, &responseObject); err != nil {
+		t.Fatal(err)
+	}
+	if len(responseObject.Items) != len(items) {
+		t.Fatalf("Expected %d items in response, took %d", len(items), len(responseObject.Items))
+	}
+	return responseObject
+}
+
+func testBatch(endpoint string, client *http.Client, t *testing.T) {
+	data := []byte("some bytes")
+	b64Data := base64.StdEncoding.EncodeToString(data)
+	response := sendBatch(endpoint, []interface{}{
+		map[string]interface{}{"operation": encryptOperation, "data": b64Data},
+		map[string]interface{}{"operation": encryptSymOperation, "data": b64Data},
+		map[string]interface{}{"operation": tokenizeOperation, "data": "some string", "type": pseudonymizationCommon.TokenType_String},
+		map[string]interface{}{"operation": decryptSymOperation, "data": b64Data},
+		map[string]interface{}{"operation": "unknown", "data": b64Data},
+		"invalid item",
+	}, client, t)
+	expectedCodes := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusUnprocessableEntity, http.StatusBadRequest, http.StatusBadRequest}
+	for i, code := range expectedCodes {
+		assert.Equal(t, code, response.Items[i].Code, "item %d", i)
+	}
+
+	getData := func(item batchItemHTTPResponse) interface{} {
+		return item.Response.(map[string]interface{})["data"]
+	}
+	response = sendBatch(endpoint, []interface{}{
+		map[string]interface{}{"operation": decryptOperation, "data": getData(response.Items[0])},
+		map[string]interface{}{"operation": decryptSymOperation, "data": getData(response.Items[1])},
+		map[string]interface{}{"operation": detokenizeOperation, "data": getData(response.Items[2]), "type": pseudonymizationCommon.TokenType_String},
+	}, client, t)
+	expectedData := []interface{}{b64Data, b64Data, "some string"}
+	for i, expected := range expectedData {
+		assert.Equal(t, http.StatusOK, response.Items[i].Code, "item %d", i)
+		assert.Equal(t, expected, getData(response.Items[i]), "item %d", i)
+	}
+}
+
 func init() {
 	if err := crypto.InitRegistry(nil); err != nil {
 		panic(err)
 	}
 }
+
+func TestAuthenticateJWT(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &privateKey.PublicKey, KeyID: "key"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := network.NewStaticJWTKeySet(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}, (&jose.SignerOptions{}).WithHeader("kid", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "client", Issuer: "issuer", Audience: jwt.Audience{"acra-translator"},
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extractor, err := network.NewJWTClientIDExtractor(keySet, "issuer", "acra-translator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	translatorData := &translatorCommon.TranslatorData{JWTClientIDExtractor: extractor}
+	service := &HTTPService{translatorData: translatorData}
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/v2/clientID", service.authenticateJWT, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, string(service.getClientID(ctx)))
+	})
+	testcases := []struct {
+		header string
+		status int
+		body   string
+	}{
+		{"Bearer " + token, http.StatusOK, "client"},
+		{"Bearer invalid", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	}
+	for _, testcase := range testcases {
+		request := httptest.NewRequest(http.MethodGet, "/v2/clientID", nil)
+		if testcase.header != "" {
+			request.Header.Set("Authorization", testcase.header)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		if recorder.Code != testcase.status {
+			t.Fatalf("Expected %d status, took %d", testcase.status, recorder.Code)
+		}
+		if testcase.status == http.StatusOK && recorder.Body.String() != testcase.body {
+			t.Fatalf("Expected %s, took %s", testcase.body, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// auditLogRefreshSignals force refreshing of audit log chain
+var auditLogRefreshSignals = []os.Signal{syscall.SIGUSR1}
+
+// forkExec starts new process of executable with the same arguments and environment which inherits files
+func forkExec(executable string, files []uintptr) (int, error) {
+	return syscall.ForkExec(executable, os.Args, &syscall.ProcAttr{Env: os.Environ(), Files: files})
+}
+
+// setNonblock switches inherited file descriptor into non-blocking mode
+func setNonblock(fd uintptr) error {
+	return syscall.SetNonblock(int(fd), true)
+}
@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrGracefulRestartNotSupported returned on SIGHUP because Windows can't pass listeners to forked process
+var ErrGracefulRestartNotSupported = errors.New("graceful restart is not supported on Windows")
+
+// auditLogRefreshSignals is empty because Windows has no SIGUSR1, audit log chain is refreshed on restart
+var auditLogRefreshSignals []os.Signal
+
+func forkExec(executable string, files []uintptr) (int, error) {
+	return 0, ErrGracefulRestartNotSupported
+}
+
+func setNonblock(fd uintptr) error {
+	return ErrGracefulRestartNotSupported
+}
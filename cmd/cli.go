@@ -0,0 +1,117 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+)
+
+// FlagEnvironmentPrefix is a prefix of environment variables which override CLI parameters of all acra-* services.
+// For example, ACRA_KEYS_DIR overrides --keys_dir and ACRA_REDIS_HOST_PORT overrides --redis_host_port
+const FlagEnvironmentPrefix = "ACRA_"
+
+// FlagEnvironmentName returns name of environment variable which overrides CLI parameter with flagName
+func FlagEnvironmentName(flagName string) string {
+	return FlagEnvironmentPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironmentOverrides sets values of flags which were not passed via CLI from environment variables.
+// CLI parameters have the highest priority, then environment variables, then values from config file
+func applyEnvironmentOverrides(flags *flag_.FlagSet) error {
+	setArgs := make(map[string]bool)
+	flags.Visit(func(flag *flag_.Flag) {
+		setArgs[flag.Name] = true
+	})
+	var err error
+	flags.VisitAll(func(flag *flag_.Flag) {
+		if err != nil || setArgs[flag.Name] {
+			return
+		}
+		envName := FlagEnvironmentName(flag.Name)
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value of %s: %w", envName, setErr)
+			return
+		}
+		log.WithField("env", envName).Debugln("Parameter set from environment variable")
+	})
+	return err
+}
+
+// RegisterKeysDirParameter registers `keys_dir` parameter with the same default value and description for all services
+// which load keys from filesystem keystore
+func RegisterKeysDirParameter() *string {
+	return RegisterKeysDirParameterWithFlags(flag_.CommandLine)
+}
+
+// RegisterKeysDirParameterWithFlags registers `keys_dir` parameter with given flag set
+func RegisterKeysDirParameterWithFlags(flags *flag_.FlagSet) *string {
+	return flags.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+}
+
+// LoggingParameters stores values of logging CLI parameters shared by all services
+type LoggingParameters struct {
+	Verbose bool
+	Debug   bool
+	Format  string
+}
+
+// RegisterLoggingParameters registers `v`, `d`, `logging_format`, `log_to_console` and `log_to_file` parameters
+func RegisterLoggingParameters() *LoggingParameters {
+	params := &LoggingParameters{}
+	flag_.StringVar(&params.Format, "logging_format", "plaintext", "Logging format: plaintext, json or CEF")
+	flag_.BoolVar(&params.Verbose, "v", false, "Log to stderr all INFO, WARNING and ERROR logs")
+	flag_.BoolVar(&params.Debug, "d", false, "Log everything to stderr")
+	logging.RegisterCLIArgs()
+	return params
+}
+
+// LogLevel returns log level selected by `d` and `v` parameters or defaultLevel if none of them was passed
+func (params *LoggingParameters) LogLevel(defaultLevel int) int {
+	if params.Debug {
+		return logging.LogDebug
+	}
+	if params.Verbose {
+		return logging.LogVerbose
+	}
+	return defaultLevel
+}
+
+// Apply configures log level, format and output of CLI tools. Returned function should be called on exit to close
+// log file
+func (params *LoggingParameters) Apply(serviceName string, defaultLevel int) (func(), error) {
+	formatter := logging.CreateFormatter(params.Format)
+	formatter.SetServiceName(serviceName)
+	writer, finalize, err := logging.NewWriter()
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(writer)
+	logging.SetLogLevel(params.LogLevel(defaultLevel))
+	return finalize, nil
+}
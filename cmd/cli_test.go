@@ -0,0 +1,132 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	flag_ "flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+func TestFlagEnvironmentName(t *testing.T) {
+	testcases := map[string]string{
+		"keys_dir":        "ACRA_KEYS_DIR",
+		"redis_host_port": "ACRA_REDIS_HOST_PORT",
+		"dry-run":         "ACRA_DRY_RUN",
+		"v":               "ACRA_V",
+	}
+	for flagName, expected := range testcases {
+		if name := FlagEnvironmentName(flagName); name != expected {
+			t.Fatalf("Expected %s for %s, took %s", expected, flagName, name)
+		}
+	}
+}
+
+func TestParseFlagsWithEnvironment(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "service.yaml")
+	config := fmt.Sprintf("version: %s\nfrom_config: config\nfrom_env: config\nfrom_cli: config\n", utils.VERSION)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(FlagEnvironmentName("from_env"), "env")
+	t.Setenv(FlagEnvironmentName("from_cli"), "env")
+	t.Setenv(FlagEnvironmentName("enabled"), "true")
+
+	flags := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	fromDefault := flags.String("from_default", "default", "")
+	fromConfig := flags.String("from_config", "default", "")
+	fromEnv := flags.String("from_env", "default", "")
+	fromCLI := flags.String("from_cli", "default", "")
+	enabled := flags.Bool("enabled", false, "")
+
+	if err := ParseFlagsWithConfig(flags, []string{"--from_cli=cli"}, configPath, "test"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"default": *fromDefault, "config": *fromConfig, "env": *fromEnv, "cli": *fromCLI}
+	for value, took := range expected {
+		if value != took {
+			t.Fatalf("Expected %s, took %s", value, took)
+		}
+	}
+	if !*enabled {
+		t.Fatal("Expected boolean flag set from environment")
+	}
+
+	t.Setenv(FlagEnvironmentName("enabled"), "invalid")
+	flags = flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flags.Bool("enabled", false, "")
+	if err := ParseFlagsWithConfig(flags, nil, "", "test"); err == nil {
+		t.Fatal("Expected error on invalid value from environment")
+	}
+}
+
+func TestParseFlagsWithConfigSections(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "service.yaml")
+	config := fmt.Sprintf("version: %s\nkeys_dir: keys\ntls:\n  tls_ca: ca.crt\n  client:\n    tls_client_auth: 4\n", utils.VERSION)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	flags := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	keysDir := flags.String("keys_dir", "", "")
+	tlsCA := flags.String("tls_ca", "", "")
+	tlsClientAuth := flags.Int("tls_client_auth", 0, "")
+	if err := ParseFlagsWithConfig(flags, nil, configPath, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if *keysDir != "keys" || *tlsCA != "ca.crt" || *tlsClientAuth != 4 {
+		t.Fatalf("Unexpected values: %s, %s, %d", *keysDir, *tlsCA, *tlsClientAuth)
+	}
+	if err := ValidateConfigFile(flags, configPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	flags := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flags.String("keys_dir", "", "")
+	flags.String("tls_ca", "", "")
+
+	testcases := []struct {
+		name   string
+		config string
+		err    error
+	}{
+		{"unknown option", "keys_dir: keys\nkeys_dirr: keys\n", ErrUnknownConfigOption},
+		{"unknown option in section", "tls:\n  tls_cert: server.crt\n", ErrUnknownConfigOption},
+		{"duplicate option", "tls_ca: ca.crt\ntls:\n  tls_ca: ca.crt\n", ErrDuplicateConfigOption},
+		{"duplicate option in sections", "tls:\n  tls_ca: ca.crt\nkeystore:\n  tls_ca: ca.crt\n", ErrDuplicateConfigOption},
+		{"invalid section", "tls:\n  1: ca.crt\n", ErrInvalidConfigSection},
+	}
+	for _, tcase := range testcases {
+		configPath := filepath.Join(t.TempDir(), "service.yaml")
+		config := fmt.Sprintf("version: %s\n%s", utils.VERSION, tcase.config)
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateConfigFile(flags, configPath); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%s] Expected %s, took %v", tcase.name, tcase.err, err)
+		}
+	}
+	if err := ValidateConfigFile(flags, filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, ErrConfigFileNotFound) {
+		t.Fatalf("Expected ErrConfigFileNotFound, took %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/network"
+)
+
+// Names of AcraServer parameters which define how clientID is derived from TLS certificate
+const (
+	tlsIdentifierExtractorTypeParameter     = "tls_identifier_extractor_type"
+	tlsIdentifierExtractorTemplateParameter = "tls_identifier_extractor_template"
+	tlsIdentifierNormalizationParameter     = "tls_identifier_normalization"
+)
+
+// TLSIdentifierExtractorTypeFromConfig returns type of identifier extractor configured in AcraServer config file,
+// or AcraServer's default if the config doesn't set it. Template of identifier is applied from the config too.
+func TLSIdentifierExtractorTypeFromConfig(configPath string) (string, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return "", err
+	}
+	stringValue := func(name string) string {
+		if value, ok := config[name]; ok && value != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		return ""
+	}
+	network.SetIdentifierExtractorTemplate(stringValue(tlsIdentifierExtractorTemplateParameter), stringValue(tlsIdentifierNormalizationParameter))
+	extractorType := stringValue(tlsIdentifierExtractorTypeParameter)
+	if extractorType == "" {
+		return network.DefaultIdentifierExtractorTypeDistinguishedName, nil
+	}
+	if _, err := network.NewIdentifierExtractorByType(extractorType); err != nil {
+		return "", err
+	}
+	return extractorType, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/network"
+)
+
+func TestTLSIdentifierExtractorTypeFromConfig(t *testing.T) {
+	testcases := []struct {
+		config   string
+		expected string
+		err      error
+	}{
+		{"version: 0.95.0\ntls_identifier_extractor_type: serial_number\n", network.IdentifierExtractorTypeSerialNumber, nil},
+		{"version: 0.95.0\ntls_identifier_extractor_type: distinguished_name\n", network.IdentifierExtractorTypeDistinguishedName, nil},
+		{"version: 0.95.0\ntls_identifier_extractor_type:\n", network.DefaultIdentifierExtractorTypeDistinguishedName, nil},
+		{"version: 0.95.0\n", network.DefaultIdentifierExtractorTypeDistinguishedName, nil},
+		{"version: 0.95.0\ntls_identifier_extractor_type: unknown\n", "", network.ErrInvalidIdentifierExtractorType},
+		{"version: 0.95.0\ntls_identifier_extractor_type: template\ntls_identifier_extractor_template: '{OU}/{CN}'\ntls_identifier_normalization: lowercase\n", network.IdentifierExtractorTypeTemplate, nil},
+		{"version: 0.95.0\ntls_identifier_extractor_type: template\n", "", network.ErrInvalidIdentifierTemplate},
+	}
+	configPath := filepath.Join(t.TempDir(), "acra-server.yaml")
+	for _, testcase := range testcases {
+		if err := os.WriteFile(configPath, []byte(testcase.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		extractorType, err := TLSIdentifierExtractorTypeFromConfig(configPath)
+		if err != testcase.err {
+			t.Fatalf("Expected %v, took %v", testcase.err, err)
+		}
+		if extractorType != testcase.expected {
+			t.Fatalf("Expected %s, took %s", testcase.expected, extractorType)
+		}
+	}
+	if _, err := TLSIdentifierExtractorTypeFromConfig(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("Expected missing file error, took %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+// configVersionKey is the only option of config file which isn't CLI parameter
+const configVersionKey = "version"
+
+// readConfigFile reads options of YAML config file with options of sections moved to the top level.
+// Returns nil if file doesn't exist
+func readConfigFile(configPath string) (map[string]interface{}, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := utils.FileExists(configPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var yamlConfig map[string]interface{}
+	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
+		return nil, err
+	}
+	return flattenConfig(yamlConfig)
+}
+
+// flattenConfig moves options of sections to the top level. Sections are mappings which group options by purpose,
+// like `tls: {tls_ca: ca.crt, tls_key: server.key}`, and contain options with full names of CLI parameters
+func flattenConfig(yamlConfig map[string]interface{}) (map[string]interface{}, error) {
+	if yamlConfig == nil {
+		return nil, nil
+	}
+	options := make(map[string]interface{}, len(yamlConfig))
+	var flatten func(section map[string]interface{}, path string) error
+	flatten = func(section map[string]interface{}, path string) error {
+		for name, value := range section {
+			if nested, ok := value.(map[interface{}]interface{}); ok {
+				converted := make(map[string]interface{}, len(nested))
+				for key, nestedValue := range nested {
+					keyName, ok := key.(string)
+					if !ok {
+						return fmt.Errorf("%w: key %v of section %s%s is not string", ErrInvalidConfigSection, key, path, name)
+					}
+					converted[keyName] = nestedValue
+				}
+				if err := flatten(converted, path+name+"."); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, exists := options[name]; exists {
+				return fmt.Errorf("%w: %s", ErrDuplicateConfigOption, name)
+			}
+			options[name] = value
+		}
+		return nil
+	}
+	if err := flatten(yamlConfig, ""); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// ValidateConfigFile checks that config file exists, contains only CLI parameters registered in flags and each of
+// them is set once. Unlike parsing, which ignores unknown options, validation finds typos and options of other services
+func ValidateConfigFile(flags *flag_.FlagSet, configPath string) error {
+	configPath = ConfigPath(configPath)
+	yamlConfig, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if yamlConfig == nil {
+		return fmt.Errorf("%w: %s", ErrConfigFileNotFound, configPath)
+	}
+	var unknown []string
+	for name := range yamlConfig {
+		if name != configVersionKey && flags.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: %s", ErrUnknownConfigOption, strings.Join(unknown, ", "))
+	}
+	return checkVersion(yamlConfig)
+}
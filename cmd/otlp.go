@@ -0,0 +1,74 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+var otlpOptions = logging.OTLPOptions{
+	Protocol: logging.OTLPProtocolGRPC,
+	Timeout:  10 * time.Second,
+}
+
+var otlpHeaders string
+
+// ErrInvalidOTLPExporterEndpoint incorrect endpoint for OTLP exporter
+var ErrInvalidOTLPExporterEndpoint = errors.New("empty otlp_endpoint")
+
+// ErrInvalidOTLPHeaders incorrect format of otlp_headers
+var ErrInvalidOTLPHeaders = errors.New("otlp_headers should be comma separated key=value pairs")
+
+// RegisterOTLPCmdParameters register cli parameters with flag for OTLP exporter options
+func RegisterOTLPCmdParameters() {
+	flag.StringVar(&otlpOptions.Endpoint, "otlp_endpoint", "", "URL of OpenTelemetry collector (for example, http://localhost:4317 for grpc or http://localhost:4318 for http/protobuf) that will be used to export trace data. https scheme turns on TLS")
+	flag.StringVar(&otlpOptions.Protocol, "otlp_protocol", otlpOptions.Protocol, fmt.Sprintf("Protocol of OTLP exporter: <%s>", strings.Join(logging.OTLPProtocolList, "|")))
+	flag.StringVar(&otlpHeaders, "otlp_headers", "", "Comma separated key=value pairs sent as headers with exported trace data (for example, authorization=Bearer <token>)")
+	flag.DurationVar(&otlpOptions.Timeout, "otlp_timeout", otlpOptions.Timeout, "Timeout of one export request to OpenTelemetry collector")
+}
+
+// GetOTLPCmdParameters return logging.OTLPOptions parsed from config/cmd parameters
+func GetOTLPCmdParameters() (logging.OTLPOptions, error) {
+	options := otlpOptions
+	options.Headers = make(map[string]string)
+	for _, pair := range strings.Split(otlpHeaders, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return options, ErrInvalidOTLPHeaders
+		}
+		options.Headers[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return options, nil
+}
+
+// ValidateOTLPCmdParameters validate cli parameters
+func ValidateOTLPCmdParameters() error {
+	if otlpOptions.Endpoint == "" {
+		return ErrInvalidOTLPExporterEndpoint
+	}
+	_, err := GetOTLPCmdParameters()
+	return err
+}
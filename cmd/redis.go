@@ -23,19 +23,27 @@ import (
 	"github.com/cossacklabs/acra/network"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/cossacklabs/acra/logging"
-	goRedis "github.com/go-redis/redis/v7"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	log "github.com/sirupsen/logrus"
 )
 
 // RedisOptions keep command-line options related to Redis database configuration.
 type RedisOptions struct {
-	HostPort  string
-	Password  string
-	DBKeys    int
-	DBTokens  int
-	TLSEnable bool
+	// HostPort is address of Redis or comma separated addresses of Sentinel or Cluster nodes
+	HostPort           string
+	Username           string
+	Password           string
+	DBKeys             int
+	DBTokens           int
+	TLSEnable          bool
+	SentinelMasterName string
+	SentinelPassword   string
+	ClusterEnable      bool
+	// tlsName is the name of TLS parameters with prefix of Redis parameters
+	tlsName string
 }
 
 // Note that currently "keystore" and "token store" are expected to be located
@@ -71,14 +79,7 @@ func RegisterRedisKeystoreParametersWithPrefix(flags *flag.FlagSet, prefix strin
 		description = " (" + description + ")"
 	}
 
-	if flags.Lookup(prefix+"redis_host_port") == nil {
-		flags.String(prefix+"redis_host_port", "", "<host>:<port> used to connect to Redis"+description)
-		flags.String(prefix+"redis_password", "", "Password to Redis database"+description)
-		flags.Bool(prefix+"redis_tls_enable", false, "Use TLS to connect to Redis"+description)
-	}
-	if flags.Lookup(prefix+network.ClientNameConstructorFunc()("redis", "cert", "")) == nil {
-		network.RegisterTLSArgsForService(flags, true, prefix+"redis", network.ClientNameConstructorFunc())
-	}
+	registerRedisConnectionParameters(flags, prefix, description)
 	flags.Int(prefix+"redis_db_keys", redisDefaultDB, "Number of Redis database for keys"+description)
 	checkBothKeyAndToken(flags, prefix)
 }
@@ -90,16 +91,27 @@ func RegisterRedisTokenStoreParametersWithPrefix(flags *flag.FlagSet, prefix str
 		description = " (" + description + ")"
 	}
 
+	registerRedisConnectionParameters(flags, prefix, description)
+	flags.Int(prefix+"redis_db_tokens", redisDefaultDB, "Number of Redis database for tokens"+description)
+	checkBothKeyAndToken(flags, prefix)
+}
+
+// registerRedisConnectionParameters registers parameters of connection shared by keystore and token storage
+func registerRedisConnectionParameters(flags *flag.FlagSet, prefix string, description string) {
 	if flags.Lookup(prefix+"redis_host_port") == nil {
-		flags.String(prefix+"redis_host_port", "", "<host>:<port> used to connect to Redis"+description)
-		flags.String(prefix+"redis_password", "", "Password to Redis database"+description)
+		flags.String(prefix+"redis_host_port", "", "<host>:<port> used to connect to Redis, comma separated list of Sentinel or Cluster nodes with --redis_sentinel_master_name or --redis_cluster_enable"+description)
+		flags.String(prefix+"redis_username", "", "Username of Redis ACL user (Redis 6+), empty to authenticate with password only"+description)
+		flags.String(prefix+"redis_password", "", "Password to Redis database. May reference secret as env://<name>, file://<path> or vault://<path>#<field>"+description)
+		RegisterSecretParameters(prefix + "redis_password")
 		flags.Bool(prefix+"redis_tls_enable", false, "Use TLS to connect to Redis"+description)
+		flags.String(prefix+"redis_sentinel_master_name", "", "Name of master monitored by Redis Sentinel, turns on failover with Sentinel nodes from --redis_host_port"+description)
+		flags.String(prefix+"redis_sentinel_password", "", "Password to Redis Sentinel nodes. May reference secret as env://<name>, file://<path> or vault://<path>#<field>"+description)
+		RegisterSecretParameters(prefix + "redis_sentinel_password")
+		flags.Bool(prefix+"redis_cluster_enable", false, "Use Redis Cluster with seed nodes from --redis_host_port, only database 0 is supported"+description)
 	}
 	if flags.Lookup(prefix+network.ClientNameConstructorFunc()("redis", "cert", "")) == nil {
 		network.RegisterTLSArgsForService(flags, true, prefix+"redis", network.ClientNameConstructorFunc())
 	}
-	flags.Int(prefix+"redis_db_tokens", redisDefaultDB, "Number of Redis database for tokens"+description)
-	checkBothKeyAndToken(flags, prefix)
 }
 
 // If a binary can use both key and token DB, have the user specify them explicitly.
@@ -114,14 +126,30 @@ func checkBothKeyAndToken(flags *flag.FlagSet, prefix string) {
 
 // ParseRedisCLIParametersFromFlags parse CLI args from FlagSet
 func ParseRedisCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *RedisOptions {
-	redisOptions := RedisOptions{}
+	redisOptions := RedisOptions{tlsName: prefix + "redis"}
 
 	if f := flags.Lookup(prefix + "redis_host_port"); f != nil {
 		redisOptions.HostPort = f.Value.String()
 	}
+	if f := flags.Lookup(prefix + "redis_username"); f != nil {
+		redisOptions.Username = f.Value.String()
+	}
 	if f := flags.Lookup(prefix + "redis_password"); f != nil {
 		redisOptions.Password = f.Value.String()
 	}
+	if f := flags.Lookup(prefix + "redis_sentinel_master_name"); f != nil {
+		redisOptions.SentinelMasterName = f.Value.String()
+	}
+	if f := flags.Lookup(prefix + "redis_sentinel_password"); f != nil {
+		redisOptions.SentinelPassword = f.Value.String()
+	}
+	if f := flags.Lookup(prefix + "redis_cluster_enable"); f != nil {
+		v, err := strconv.ParseBool(f.Value.String())
+		if err != nil {
+			log.WithField("value", f.Value.String()).Fatalf("Can't cast %s to boolean value", prefix+"redis_cluster_enable")
+		}
+		redisOptions.ClusterEnable = v
+	}
 	if f := flags.Lookup(prefix + "redis_db_tokens"); f != nil {
 		getter, ok := f.Value.(flag.Getter)
 		if !ok {
@@ -159,17 +187,32 @@ func ParseRedisCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *Redis
 // ValidateRedisCLIOptions validate Redis CLI options.
 func ValidateRedisCLIOptions(redisOptions *RedisOptions) {
 	if err := redisOptions.validateOptions(); err != nil {
-		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln(
-			"Identical Redis DB parameters, one of redis_db_tokens or redis_db_keys should be provided")
+		if err == ErrIdenticalRedisDBs {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln(
+				"Identical Redis DB parameters, one of redis_db_tokens or redis_db_keys should be provided")
+		} else {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln(
+				"Invalid Redis parameters")
+		}
 		os.Exit(1)
 	}
 }
 
-// validateOptions check weather DBTokens and DBKeys are not similar
+// validateOptions check weather DBTokens and DBKeys are not similar. Redis Cluster has only database 0, so keys
+// and tokens share it and are separated by prefixes
 func (redis *RedisOptions) validateOptions() error {
 	if redis.HostPort == "" {
 		return nil
 	}
+	if redis.ClusterEnable {
+		if redis.SentinelMasterName != "" {
+			return redisclient.ErrTopologyConflict
+		}
+		if redis.DBKeys > 0 || redis.DBTokens > 0 {
+			return redisclient.ErrClusterDB
+		}
+		return nil
+	}
 
 	if redis.DBTokens == redis.DBKeys {
 		return ErrIdenticalRedisDBs
@@ -187,20 +230,55 @@ func (redis *RedisOptions) TokensConfigured() bool {
 	return redis.HostPort != "" && redis.DBTokens != redisUnspecifiedDB
 }
 
+// Addresses returns addresses of Redis node, Sentinel nodes or Cluster nodes.
+func (redis *RedisOptions) Addresses() []string {
+	var addresses []string
+	for _, address := range strings.Split(redis.HostPort, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
 // KeysOptions returns Redis connection configuration for key storage.
-func (redis *RedisOptions) KeysOptions(flags *flag.FlagSet) (*goRedis.Options, error) {
+func (redis *RedisOptions) KeysOptions(flags *flag.FlagSet) (*redisclient.Options, error) {
+	return redis.clientOptions(flags, redis.DBKeys)
+}
+
+// TokensOptions returns Redis connection configuration for token storage.
+func (redis *RedisOptions) TokensOptions(flags *flag.FlagSet) (*redisclient.Options, error) {
+	return redis.clientOptions(flags, redis.DBTokens)
+}
+
+func (redis *RedisOptions) clientOptions(flags *flag.FlagSet, db int) (*redisclient.Options, error) {
+	addresses := redis.Addresses()
 	var tlsConfig *tls.Config
 	var err error
 	if redis.TLSEnable {
-		tlsConfig, err = network.NewTLSConfigByName(flags, "redis", redis.HostPort, network.ClientNameConstructorFunc())
+		tlsName := redis.tlsName
+		if tlsName == "" {
+			tlsName = "redis"
+		}
+		host := redis.HostPort
+		if redis.SentinelMasterName != "" || redis.ClusterEnable {
+			// without SNI certificates of Sentinel and Cluster nodes are verified with hostnames of each node
+			host = ""
+		}
+		tlsConfig, err = network.NewTLSConfigByName(flags, tlsName, host, network.ClientNameConstructorFunc())
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &goRedis.Options{
-		Addr:      redis.HostPort,
-		Password:  redis.Password,
-		DB:        redis.DBKeys,
-		TLSConfig: tlsConfig,
-	}, nil
+	options := &redisclient.Options{
+		Addrs:              addresses,
+		Username:           redis.Username,
+		Password:           redis.Password,
+		DB:                 db,
+		SentinelMasterName: redis.SentinelMasterName,
+		SentinelPassword:   redis.SentinelPassword,
+		Cluster:            redis.ClusterEnable,
+		TLSConfig:          tlsConfig,
+	}
+	return options, options.Validate()
 }
@@ -0,0 +1,62 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"testing"
+
+	"github.com/cossacklabs/acra/utils/redisclient"
+)
+
+func TestRedisClusterOptions(t *testing.T) {
+	flags := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	RegisterRedisKeystoreParametersWithPrefix(flags, "", "")
+	RegisterRedisTokenStoreParametersWithPrefix(flags, "", "")
+	args := []string{
+		"--redis_host_port=node1:7000, node2:7000,node3:7000",
+		"--redis_cluster_enable",
+		"--redis_username=acra",
+		"--redis_db_keys=0",
+		"--redis_db_tokens=0",
+	}
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	options := ParseRedisCLIParametersFromFlags(flags, "")
+	// keys and tokens share the only database of Redis Cluster
+	if err := options.validateOptions(); err != nil {
+		t.Fatal(err)
+	}
+	keysOptions, err := options.KeysOptions(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keysOptions.Addrs) != 3 || keysOptions.Addrs[1] != "node2:7000" || !keysOptions.Cluster || keysOptions.Username != "acra" {
+		t.Fatalf("Unexpected options %+v", keysOptions)
+	}
+
+	options.DBKeys = 1
+	if err := options.validateOptions(); err != redisclient.ErrClusterDB {
+		t.Fatalf("Expected ErrClusterDB, took %v", err)
+	}
+	options.ClusterEnable = false
+	options.DBKeys = 0
+	if err := options.validateOptions(); err != ErrIdenticalRedisDBs {
+		t.Fatalf("Expected ErrIdenticalRedisDBs, took %v", err)
+	}
+}
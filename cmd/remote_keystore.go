@@ -0,0 +1,112 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/remote"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// ErrRemoteKeyStoreNotSupported returned when keystore can't be managed over keystore API
+var ErrRemoteKeyStoreNotSupported = errors.New("keystore doesn't support remote management")
+
+// Names of parameters of keystore API used by `acra-keys --remote_keystore_address`
+const (
+	remoteKeyStoreAPIAddressParameter        = "keystore_api_address"
+	remoteKeyStoreAPIAdminClientIDsParameter = "keystore_api_admin_client_ids"
+	remoteKeyStoreAPIExportEnableParameter   = "keystore_api_export_enable"
+)
+
+// RemoteKeyStoreAPIParameters keep command-line options of keystore API
+type RemoteKeyStoreAPIParameters struct {
+	Address        string
+	AdminClientIDs map[string]bool
+	ExportEnable   bool
+}
+
+// RegisterRemoteKeyStoreAPIParameters registers keystore API parameters with given flag set
+func RegisterRemoteKeyStoreAPIParameters(flags *flag.FlagSet) {
+	if flags.Lookup(remoteKeyStoreAPIAddressParameter) == nil {
+		flags.String(remoteKeyStoreAPIAddressParameter, "", "<host>:<port> of gRPC keystore API used by `acra-keys --remote_keystore_address` to generate, list, rotate, destroy and export keys. Requires TLS with client certificates. Empty value disables API")
+		flags.String(remoteKeyStoreAPIAdminClientIDsParameter, "", "Comma-separated clientIDs, extracted from client certificates, allowed to use keystore API")
+		flags.Bool(remoteKeyStoreAPIExportEnableParameter, false, "Allow export of keys over keystore API. Supported only by keystore v1")
+	}
+}
+
+// ParseRemoteKeyStoreAPIParameters parse keystore API options from FlagSet
+func ParseRemoteKeyStoreAPIParameters(flags *flag.FlagSet) *RemoteKeyStoreAPIParameters {
+	params := RemoteKeyStoreAPIParameters{AdminClientIDs: make(map[string]bool)}
+	if f := flags.Lookup(remoteKeyStoreAPIAddressParameter); f != nil {
+		params.Address = f.Value.String()
+	}
+	if f := flags.Lookup(remoteKeyStoreAPIAdminClientIDsParameter); f != nil {
+		for _, clientID := range strings.Split(f.Value.String(), ",") {
+			if clientID = strings.TrimSpace(clientID); clientID != "" {
+				params.AdminClientIDs[clientID] = true
+			}
+		}
+	}
+	if f := flags.Lookup(remoteKeyStoreAPIExportEnableParameter); f != nil {
+		params.ExportEnable = f.Value.String() == "true"
+	}
+	return &params
+}
+
+// Configured returns true if keystore API is enabled
+func (params *RemoteKeyStoreAPIParameters) Configured() bool {
+	return params.Address != ""
+}
+
+// RunRemoteKeyStoreAPI starts gRPC keystore API in goroutine and stops it when ctx is done. exporter may be nil if
+// export of keys is disabled
+func RunRemoteKeyStoreAPI(ctx context.Context, params *RemoteKeyStoreAPIParameters, keyStore keystore.ServerKeyStore, exporter keystore.Exporter, tlsConfig *tls.Config, extractor network.TLSClientIDExtractor) error {
+	managedKeyStore, ok := keyStore.(remote.ManagedKeyStore)
+	if !ok {
+		return ErrRemoteKeyStoreNotSupported
+	}
+	grpcServer, err := remote.NewGRPCServer(remote.NewServer(managedKeyStore, exporter, params.AdminClientIDs, extractor), tlsConfig)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", params.Address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		log.WithField("address", params.Address).Infoln("Start keystore API")
+		if err := grpcServer.Serve(listener); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartService).
+				Errorln("System error: got error from keystore API")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+	return nil
+}
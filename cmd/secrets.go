@@ -0,0 +1,198 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	flag_ "flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schemes of references to secrets which may be used instead of values of sensitive CLI parameters, environment
+// variables and options of config files, for example `redis_password: vault://secret/data/acra#redis_password`
+const (
+	// SecretSchemeEnv references environment variable: env://REDIS_PASSWORD
+	SecretSchemeEnv = "env"
+	// SecretSchemeFile references file with secret: file:///run/secrets/redis_password
+	SecretSchemeFile = "file"
+	// SecretSchemeVault references field of HashiCorp Vault secret: vault://secret/data/acra#redis_password. Address of
+	// Vault and token are read from VAULT_ADDR and VAULT_TOKEN environment variables
+	SecretSchemeVault = "vault"
+)
+
+// secretSchemeSeparator separates scheme of reference from the path of secret
+const secretSchemeSeparator = "://"
+
+// vaultFieldSeparator separates path of HashiCorp Vault secret from the name of field
+const vaultFieldSeparator = "#"
+
+// Errors returned by SecretsResolver
+var (
+	ErrSecretNotFound         = errors.New("secret not found")
+	ErrInvalidSecretReference = errors.New("invalid reference to secret")
+)
+
+// secretParameters stores names of parameters which values may reference secrets
+var secretParameters = make(map[string]bool)
+
+// RegisterSecretParameters marks parameters which values may reference secrets. Other parameters are left as is,
+// because they may use the same schemes for another purpose, like file:// URLs of CRLs
+func RegisterSecretParameters(names ...string) {
+	for _, name := range names {
+		secretParameters[name] = true
+	}
+}
+
+// IsSecretParameter returns true if value of parameter may reference secret
+func IsSecretParameter(name string) bool {
+	return secretParameters[name]
+}
+
+// SecretReader reads secret by reference without scheme
+type SecretReader func(reference string) (string, error)
+
+// SecretsResolver replaces references to secrets in values of parameters with secrets, so secrets aren't stored in
+// config files. Values without known scheme are left as is
+type SecretsResolver struct {
+	readers map[string]SecretReader
+}
+
+// NewSecretsResolver returns SecretsResolver which resolves env://, file:// and vault:// references
+func NewSecretsResolver() *SecretsResolver {
+	resolver := &SecretsResolver{readers: make(map[string]SecretReader)}
+	resolver.RegisterReader(SecretSchemeEnv, readEnvironmentSecret)
+	resolver.RegisterReader(SecretSchemeFile, readFileSecret)
+	resolver.RegisterReader(SecretSchemeVault, newVaultSecretReader())
+	return resolver
+}
+
+// RegisterReader registers reader of references with scheme, overrides previously registered one
+func (resolver *SecretsResolver) RegisterReader(scheme string, reader SecretReader) {
+	resolver.readers[scheme] = reader
+}
+
+// Resolve returns secret referenced by value and true, or value and false if it isn't reference to secret
+func (resolver *SecretsResolver) Resolve(value string) (string, bool, error) {
+	scheme, reference, ok := strings.Cut(value, secretSchemeSeparator)
+	if !ok {
+		return value, false, nil
+	}
+	reader, ok := resolver.readers[scheme]
+	if !ok {
+		return value, false, nil
+	}
+	secret, err := reader(reference)
+	if err != nil {
+		return "", true, err
+	}
+	return secret, true, nil
+}
+
+// ResolveFlags replaces values of flags registered with RegisterSecretParameters which reference secrets with secrets
+func (resolver *SecretsResolver) ResolveFlags(flags *flag_.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *flag_.Flag) {
+		if err != nil || !IsSecretParameter(flag.Name) {
+			return
+		}
+		secret, isReference, resolveErr := resolver.Resolve(flag.Value.String())
+		if resolveErr != nil {
+			// don't log value, reference may contain sensitive parts of paths
+			err = fmt.Errorf("can't resolve secret of %s: %w", flag.Name, resolveErr)
+			return
+		}
+		if !isReference {
+			return
+		}
+		if setErr := flags.Set(flag.Name, secret); setErr != nil {
+			err = fmt.Errorf("invalid value of secret of %s: %w", flag.Name, setErr)
+			return
+		}
+		log.WithField("parameter", flag.Name).Debugln("Parameter set from secret")
+	})
+	return err
+}
+
+// readEnvironmentSecret reads secret from environment variable
+func readEnvironmentSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// readFileSecret reads secret from file without trailing line break
+func readFileSecret(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("%w: empty path of file", ErrInvalidSecretReference)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// newVaultSecretReader returns reader of fields of HashiCorp Vault secrets, connection is initialized on first read
+func newVaultSecretReader() SecretReader {
+	var client *api.Client
+	return func(reference string) (string, error) {
+		path, field, ok := strings.Cut(reference, vaultFieldSeparator)
+		if !ok || path == "" || field == "" {
+			return "", fmt.Errorf("%w: expects vault://<path>%s<field>", ErrInvalidSecretReference, vaultFieldSeparator)
+		}
+		if client == nil {
+			newClient, err := api.NewClient(api.DefaultConfig())
+			if err != nil {
+				return "", err
+			}
+			client = newClient
+		}
+		secret, err := client.Logical().Read(path)
+		if err != nil {
+			return "", err
+		}
+		if secret == nil {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+		}
+		return vaultSecretField(secret.Data, field)
+	}
+}
+
+// vaultSecretField returns string field of secret from KV engine of version 1 or version 2 which nests fields in data
+func vaultSecretField(data map[string]interface{}, field string) (string, error) {
+	value, ok := data[field]
+	if !ok {
+		if nested, isNested := data["data"].(map[string]interface{}); isNested {
+			value, ok = nested[field]
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: field %s", ErrSecretNotFound, field)
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: field %s is not string", ErrInvalidSecretReference, field)
+	}
+	return secret, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	flag_ "flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+func TestSecretsResolver(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretPath, []byte("file secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "env secret")
+
+	resolver := NewSecretsResolver()
+	resolver.RegisterReader(SecretSchemeVault, func(reference string) (string, error) {
+		if reference != "secret/data/acra#password" {
+			return "", ErrSecretNotFound
+		}
+		return "vault secret", nil
+	})
+	testcases := []struct {
+		value       string
+		secret      string
+		isReference bool
+		err         error
+	}{
+		{"plain", "plain", false, nil},
+		{"tcp://127.0.0.1:9393", "tcp://127.0.0.1:9393", false, nil},
+		{"env://TEST_SECRET", "env secret", true, nil},
+		{"env://UNKNOWN_TEST_SECRET", "", true, ErrSecretNotFound},
+		{"file://" + secretPath, "file secret", true, nil},
+		{"file://", "", true, ErrInvalidSecretReference},
+		{"vault://secret/data/acra#password", "vault secret", true, nil},
+		{"vault://secret/data/acra#unknown", "", true, ErrSecretNotFound},
+	}
+	for _, tcase := range testcases {
+		secret, isReference, err := resolver.Resolve(tcase.value)
+		if !errors.Is(err, tcase.err) || secret != tcase.secret || isReference != tcase.isReference {
+			t.Fatalf("[%s] Unexpected result: %s, %v, %v", tcase.value, secret, isReference, err)
+		}
+	}
+	if _, _, err := NewSecretsResolver().Resolve("vault://secret/data/acra"); !errors.Is(err, ErrInvalidSecretReference) {
+		t.Fatalf("Expected ErrInvalidSecretReference, took %v", err)
+	}
+}
+
+func TestParseFlagsWithSecrets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "service.yaml")
+	config := fmt.Sprintf("version: %s\nsecret_password: env://TEST_SECRET\ncrl_url: file:///tmp/test.crl\n", utils.VERSION)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "env secret")
+	RegisterSecretParameters("secret_password")
+
+	flags := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	password := flags.String("secret_password", "", "")
+	crlURL := flags.String("crl_url", "", "")
+	if err := ParseFlagsWithConfig(flags, nil, configPath, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if *password != "env secret" {
+		t.Fatalf("Expected secret from environment, took %s", *password)
+	}
+	// parameters which aren't registered as secrets are left as is
+	if *crlURL != "file:///tmp/test.crl" {
+		t.Fatalf("Unexpected value of not secret parameter %s", *crlURL)
+	}
+
+	flags = flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flags.String("secret_password", "", "")
+	if err := ParseFlagsWithConfig(flags, []string{"--secret_password=env://UNKNOWN_TEST_SECRET"}, "", "test"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("Expected ErrSecretNotFound, took %v", err)
+	}
+}
+
+func TestVaultSecretField(t *testing.T) {
+	v1 := map[string]interface{}{"password": "v1"}
+	v2 := map[string]interface{}{"data": map[string]interface{}{"password": "v2"}, "metadata": map[string]interface{}{}}
+	if secret, err := vaultSecretField(v1, "password"); err != nil || secret != "v1" {
+		t.Fatalf("Unexpected secret of KV v1: %s, %v", secret, err)
+	}
+	if secret, err := vaultSecretField(v2, "password"); err != nil || secret != "v2" {
+		t.Fatalf("Unexpected secret of KV v2: %s, %v", secret, err)
+	}
+	if _, err := vaultSecretField(v2, "unknown"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("Expected ErrSecretNotFound, took %v", err)
+	}
+	if _, err := vaultSecretField(map[string]interface{}{"password": 1}, "password"); !errors.Is(err, ErrInvalidSecretReference) {
+		t.Fatalf("Expected ErrInvalidSecretReference, took %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"flag"
+)
+
+// ErrTokenStorageConflict returned when several token storages configured simultaneously
+var ErrTokenStorageConflict = errors.New("only one token storage can be configured")
+
+// TokenStorageBackendOptions keep command-line options related to pluggable token storage backends.
+type TokenStorageBackendOptions struct {
+	Backend          string
+	ConnectionString string
+}
+
+// RegisterTokenStorageBackendParameters registers token storage backend parameters with CommandLine flags
+func RegisterTokenStorageBackendParameters() {
+	RegisterTokenStorageBackendParametersWithFlags(flag.CommandLine)
+}
+
+// RegisterTokenStorageBackendParametersWithFlags registers token storage backend parameters with given flag set
+func RegisterTokenStorageBackendParametersWithFlags(flags *flag.FlagSet) {
+	if flags.Lookup("token_storage_backend") == nil {
+		flags.String("token_storage_backend", "", "Name of pluggable token storage backend (postgresql). Can't be used together with --token_db or --redis_host_port")
+		flags.String("token_storage_connection_string", "", "Connection string for token storage backend selected with --token_storage_backend. May reference secret as env://<name>, file://<path> or vault://<path>#<field>")
+		RegisterSecretParameters("token_storage_connection_string")
+	}
+}
+
+// ParseTokenStorageBackendParametersFromFlags parse token storage backend options from FlagSet
+func ParseTokenStorageBackendParametersFromFlags(flags *flag.FlagSet) *TokenStorageBackendOptions {
+	options := TokenStorageBackendOptions{}
+	if f := flags.Lookup("token_storage_backend"); f != nil {
+		options.Backend = f.Value.String()
+	}
+	if f := flags.Lookup("token_storage_connection_string"); f != nil {
+		options.ConnectionString = f.Value.String()
+	}
+	return &options
+}
+
+// Configured returns true if token storage backend is selected.
+func (options *TokenStorageBackendOptions) Configured() bool {
+	return options.Backend != ""
+}
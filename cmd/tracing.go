@@ -29,11 +29,13 @@ import (
 
 var traceToLog = false
 var traceToJaeger = false
+var traceToOTLP = false
 
 // RegisterTracingCmdParameters register cli parameters with flag for tracing
 func RegisterTracingCmdParameters() {
 	flag.BoolVar(&traceToLog, "tracing_log_enable", false, "Export trace data to log")
 	flag.BoolVar(&traceToJaeger, "tracing_jaeger_enable", false, "Export trace data to jaeger")
+	flag.BoolVar(&traceToOTLP, "tracing_otlp_enable", false, "Export trace data to OpenTelemetry collector using OTLP")
 }
 
 // IsTraceToLogOn return true if turned on tracing to log output
@@ -46,6 +48,11 @@ func IsTraceToJaegerOn() bool {
 	return traceToJaeger
 }
 
+// IsTraceToOTLPOn return true if turned on tracing to OpenTelemetry collector
+func IsTraceToOTLPOn() bool {
+	return traceToOTLP
+}
+
 // SetupTracing with global options related with exporters
 func SetupTracing(serviceName string) {
 	if IsTraceToLogOn() {
@@ -66,4 +73,17 @@ func SetupTracing(serviceName string) {
 		// And now finally register it as a Trace Exporter
 		trace.RegisterExporter(jaegerEndpoint)
 	}
+	if IsTraceToOTLPOn() {
+		if err := ValidateOTLPCmdParameters(); err != nil {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorOTLPInvalidParameters).WithError(err).Errorln("Invalid OTLP parameters")
+			os.Exit(1)
+		}
+		otlpOptions, _ := GetOTLPCmdParameters()
+		otlpExporter, err := logging.NewOTLPSpanExporter(serviceName, otlpOptions)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorOTLPExporter).Errorln("Failed to create the OTLP exporter")
+			os.Exit(1)
+		}
+		trace.RegisterExporter(otlpExporter)
+	}
 }
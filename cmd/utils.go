@@ -24,7 +24,6 @@ import (
 	flag_ "flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
@@ -39,7 +38,6 @@ import (
 	"github.com/cossacklabs/acra/logging"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/utils"
@@ -53,7 +51,11 @@ var (
 
 // Argument and configuration parsing errors.
 var (
-	ErrDumpRequested = errors.New("configurtion dump requested")
+	ErrDumpRequested         = errors.New("configurtion dump requested")
+	ErrConfigFileNotFound    = errors.New("config file not found")
+	ErrUnknownConfigOption   = errors.New("unknown option in config file")
+	ErrDuplicateConfigOption = errors.New("option is set several times in config file")
+	ErrInvalidConfigSection  = errors.New("invalid section of config file")
 )
 
 func init() {
@@ -352,7 +354,9 @@ func Parse(configPath, serviceName string) error {
 	return err
 }
 
-// ParseFlagsWithConfig parses flag settings from YAML config file and command line.
+// ParseFlagsWithConfig parses flag settings from YAML config file, environment variables and command line. Values
+// of parameters registered with RegisterSecretParameters which reference secrets with env://, file:// or vault://
+// schemes are replaced with secrets.
 func ParseFlagsWithConfig(flags *flag_.FlagSet, arguments []string, configPath, serviceName string) error {
 	/*load from yaml config and cli. if dumpconfig option pass than generate config and exit*/
 	log.Debugf("Parsing config from path %v", configPath)
@@ -361,46 +365,32 @@ func ParseFlagsWithConfig(flags *flag_.FlagSet, arguments []string, configPath,
 	if err != nil {
 		return err
 	}
+	if err = applyEnvironmentOverrides(flags); err != nil {
+		return err
+	}
 
-	configPath = ConfigPath(configPath)
-	var yamlConfig map[string]interface{}
-	var extraArgs []string
 	// parse yaml and add params that wasn't passed from cli
-	if configPath != "" {
-
-		configPath, err := filepath.Abs(configPath)
-		if err != nil {
-			return err
-		}
-		exists, err := utils.FileExists(configPath)
-		if err != nil {
-			return err
-		}
-		if exists {
-			data, err := ioutil.ReadFile(configPath)
-			if err != nil {
-				return err
-			}
-			err = yaml.Unmarshal(data, &yamlConfig)
-			if err != nil {
-				return err
-			}
-			setArgs := make(map[string]bool)
-			flags.Visit(func(flag *flag_.Flag) {
-				setArgs[flag.Name] = true
-			})
-			// generate args list for flag.Parse as it was from cli args
-			flags.VisitAll(func(flag *flag_.Flag) {
-				// generate only args that wasn't set from cli
-				if _, alreadySet := setArgs[flag.Name]; !alreadySet {
-					if value, yamlOk := yamlConfig[flag.Name]; yamlOk {
-						if value != nil {
-							extraArgs = append(extraArgs, fmt.Sprintf("--%v=%v", flag.Name, value))
-						}
+	yamlConfig, err := readConfigFile(ConfigPath(configPath))
+	if err != nil {
+		return err
+	}
+	var extraArgs []string
+	if yamlConfig != nil {
+		setArgs := make(map[string]bool)
+		flags.Visit(func(flag *flag_.Flag) {
+			setArgs[flag.Name] = true
+		})
+		// generate args list for flag.Parse as it was from cli args
+		flags.VisitAll(func(flag *flag_.Flag) {
+			// generate only args that wasn't set from cli
+			if _, alreadySet := setArgs[flag.Name]; !alreadySet {
+				if value, yamlOk := yamlConfig[flag.Name]; yamlOk {
+					if value != nil {
+						extraArgs = append(extraArgs, fmt.Sprintf("--%v=%v", flag.Name, value))
 					}
 				}
-			})
-		}
+			}
+		})
 	}
 	// Set global options from config that wasn't set by CLI, if there are any.
 	if len(extraArgs) != 0 {
@@ -418,7 +408,8 @@ func ParseFlagsWithConfig(flags *flag_.FlagSet, arguments []string, configPath,
 	if err = checkVersion(yamlConfig); err != nil {
 		return err
 	}
-	return nil
+	// resolve references to secrets after dump of config to keep secrets out of it
+	return NewSecretsResolver().ResolveFlags(flags)
 }
 
 // Argon2Params describes params for Argon2 hashing
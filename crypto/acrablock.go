@@ -1,7 +1,10 @@
 package crypto
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+
 	"github.com/cossacklabs/acra/acrablock"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
@@ -43,12 +46,18 @@ func (handler AcraBlockHandler) Decrypt(data []byte, context *base.DataProcessor
 	logger := logging.GetLoggerFromContext(context.Context).WithField("handler", handler.Name())
 	logger.Debugln("Process: Decrypt AcraBlock")
 	acraBlock, err := acrablock.NewAcraBlockFromData(data)
+	if errors.Is(err, acrablock.ErrUnsupportedFormatVersion) {
+		base.UnsupportedContainerFormatCounter.WithLabelValues(base.LabelTypeAcraBlock, strconv.Itoa(int(data[acrablock.FormatVersionPosition]))).Inc()
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeUnsupportedContainerFormat).
+			Errorln("AcraBlock created by newer version of Acra, upgrade AcraServer or increase --max_accepted_container_format")
+		return data, err
+	}
 	if err != nil {
 		logger.WithError(err).Debugln("AcraBlockHandler.Process: AcraBlock not found, exit")
 		return data, err
 	}
 	accessContext := base.AccessContextFromContext(context.Context)
-	privateKeys, err := context.Keystore.GetClientIDSymmetricKeys(accessContext.GetClientID())
+	privateKeys, err := context.KeysBatch.GetClientIDSymmetricKeys(context.Keystore, accessContext.GetClientID())
 	defer utils.ZeroizeSymmetricKeys(privateKeys)
 	if err != nil {
 		logger.WithError(err).WithFields(
@@ -59,7 +68,19 @@ func (handler AcraBlockHandler) Decrypt(data []byte, context *base.DataProcessor
 			Debugln("Probably error occurred because: 1. used not appropriate TLS certificate or acra-server configured with inappropriate --client_id=<client_id>; 2. forgot to generate keys for your TLS certificate (or with specified client_id); 3. incorrectly configured keystore: incorrect path to folder or Redis database's number")
 		return []byte{}, fmt.Errorf("can't read private key for matched client_id to decrypt AcraBlock: %w", err)
 	}
-	decrypted, err := acraBlock.Decrypt(privateKeys, nil)
+	// AcraBlocks bound to the column are decrypted only with setting of the same column
+	var associatedData []byte
+	if setting, ok := encryptor.EncryptionSettingFromContext(context.Context); ok && setting != nil {
+		associatedData = setting.GetAcraBlockAssociatedData()
+		// after migration unbound AcraBlocks are refused because they may be copied from any other column
+		if setting.IsAcraBlockColumnBindingRequired() && !acraBlock.IsBoundToAssociatedData() {
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantDecryptBinary).
+				WithField("column", setting.ColumnName()).
+				Warningln("AcraBlock isn't bound to the column which requires binding, probably it was copied from another column")
+			return nil, fmt.Errorf("can't decrypt AcraBlock: %w", ErrDecryptionError)
+		}
+	}
+	decrypted, err := acraBlock.DecryptWithAssociatedData(privateKeys, nil, associatedData)
 	if err != nil {
 		return nil, fmt.Errorf("can't decrypt AcraBlock: %w", ErrDecryptionError)
 	}
@@ -69,8 +90,8 @@ func (handler AcraBlockHandler) Decrypt(data []byte, context *base.DataProcessor
 
 // EncryptWithClientID implementation of ContainerHandler method
 func (handler AcraBlockHandler) EncryptWithClientID(clientID, data []byte, context *encryptor.DataEncryptorContext) ([]byte, error) {
-	// skip already encrypted AcraBlock
-	if _, _, err := acrablock.ExtractAcraBlockFromData(data); err == nil {
+	// skip already encrypted AcraBlock, including AcraBlocks of newer format
+	if _, _, err := acrablock.ExtractAcraBlockFromData(data); err == nil || errors.Is(err, acrablock.ErrUnsupportedFormatVersion) {
 		return data, nil
 	}
 	key, err := context.Keystore.GetClientIDSymmetricKey(clientID)
@@ -79,5 +100,5 @@ func (handler AcraBlockHandler) EncryptWithClientID(clientID, data []byte, conte
 	}
 	defer utils.ZeroizeSymmetricKey(key)
 
-	return acrablock.CreateAcraBlock(data, key, nil)
+	return acrablock.CreateAcraBlockWithSetting(data, key, nil, context.Setting)
 }
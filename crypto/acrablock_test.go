@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cossacklabs/acra/acrablock"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/keystore/mocks"
+)
+
+func TestAcraBlockHandlerColumnBinding(t *testing.T) {
+	clientID := []byte("user0")
+	key := []byte(`some key`)
+	data := []byte("data")
+	keystore := &mocks.ServerKeyStore{}
+	keystore.On("GetClientIDSymmetricKeys", clientID).Return([][]byte{key}, nil)
+
+	acraBlockEnvelopeType := config.CryptoEnvelopeTypeAcraBlock
+	enabled := true
+	boundSetting := &config.BasicColumnEncryptionSetting{Name: "data", CryptoEnvelope: &acraBlockEnvelopeType, AcraBlockColumnBinding: &enabled}
+	requiredSetting := &config.BasicColumnEncryptionSetting{Name: "data", CryptoEnvelope: &acraBlockEnvelopeType,
+		AcraBlockColumnBinding: &enabled, AcraBlockColumnBindingRequired: &enabled}
+	otherSetting := &config.BasicColumnEncryptionSetting{Name: "other", CryptoEnvelope: &acraBlockEnvelopeType, AcraBlockColumnBinding: &enabled}
+
+	unbound, err := acrablock.CreateAcraBlock(data, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, err := acrablock.CreateAcraBlockWithSetting(data, key, nil, boundSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name      string
+		container []byte
+		setting   config.ColumnEncryptionSetting
+		success   bool
+	}{
+		{"unbound without setting", unbound, nil, true},
+		{"unbound in bound column", unbound, boundSetting, true},
+		{"unbound in column which requires binding", unbound, requiredSetting, false},
+		{"bound in the same column", bound, boundSetting, true},
+		{"bound in column which requires binding", bound, requiredSetting, true},
+		{"bound without setting", bound, nil, false},
+		{"bound in another column", bound, otherSetting, false},
+	}
+	handler := NewAcraBlockHandler()
+	for _, tcase := range testcases {
+		ctx := base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID(clientID)))
+		if tcase.setting != nil {
+			ctx = encryptor.NewContextWithEncryptionSetting(ctx, tcase.setting)
+		}
+		dataContext := base.NewDataProcessorContext(keystore)
+		dataContext.Context = ctx
+		decrypted, err := handler.Decrypt(tcase.container, dataContext)
+		if !tcase.success {
+			if err == nil {
+				t.Fatalf("[%s] Expected error", tcase.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %s", tcase.name, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("[%s] Decrypted data not equal to source data", tcase.name)
+		}
+	}
+}
@@ -49,7 +49,7 @@ func (handler AcraStructHandler) Decrypt(data []byte, context *base.DataProcesso
 	}
 
 	accessContext := base.AccessContextFromContext(context.Context)
-	privateKeys, err := context.Keystore.GetServerDecryptionPrivateKeys(accessContext.GetClientID())
+	privateKeys, err := context.KeysBatch.GetServerDecryptionPrivateKeys(context.Keystore, accessContext.GetClientID())
 	defer utils.ZeroizePrivateKeys(privateKeys)
 	if err != nil {
 		base.AcrastructDecryptionCounter.WithLabelValues(base.LabelStatusFail).Inc()
@@ -6,6 +6,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/logging"
 )
@@ -28,9 +29,14 @@ func NewDecryptHandler(keyStore keystore.DataEncryptorKeyStore, processor base.D
 func (d DecryptHandler) OnCryptoEnvelope(ctx context.Context, container []byte) ([]byte, error) {
 	logger := logging.GetLoggerFromContext(ctx)
 
+	if mode, ok := base.DegradedModeFromContext(ctx); ok {
+		return d.degrade(ctx, mode, container), nil
+	}
+
 	decrypted, err := d.processor.Process(container, &base.DataProcessorContext{
-		Keystore: d.keyStore,
-		Context:  ctx,
+		Keystore:  d.keyStore,
+		Context:   ctx,
+		KeysBatch: base.DecryptionKeysBatchFromContext(ctx),
 	})
 
 	if err != nil {
@@ -44,6 +50,17 @@ func (d DecryptHandler) OnCryptoEnvelope(ctx context.Context, container []byte)
 	return decrypted, nil
 }
 
+// degrade returns data for query response processed in degraded mode without decryption
+func (d DecryptHandler) degrade(ctx context.Context, mode base.DegradedMode, container []byte) []byte {
+	if mode == base.DegradedModeMasked {
+		if setting, ok := encryptor.EncryptionSettingFromContext(ctx); ok && setting.GetMaskingPattern() != "" {
+			base.ColumnMetricsRecorderFromContext(ctx).OnMaskingFallback()
+			return []byte(setting.GetMaskingPattern())
+		}
+	}
+	return container
+}
+
 // ID return string representation of DecryptHandler
 func (d DecryptHandler) ID() string {
 	return "DecryptHandler"
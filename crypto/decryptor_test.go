@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/keystore/mocks"
+)
+
+func TestDecryptHandlerDegradedMode(t *testing.T) {
+	container := []byte("encrypted data")
+	decrypted := []byte("decrypted data")
+	processorCalled := false
+	processor := base.ProcessorFunc(func(data []byte, ctx *base.DataProcessorContext) ([]byte, error) {
+		processorCalled = true
+		return decrypted, nil
+	})
+	handler := NewDecryptHandler(&mocks.ServerKeyStore{}, processor)
+	maskedSetting := &config.BasicColumnEncryptionSetting{MaskingPattern: "xxxx"}
+
+	testcases := []struct {
+		name     string
+		ctx      context.Context
+		expected []byte
+		called   bool
+	}{
+		{"without degraded mode", context.Background(), decrypted, true},
+		{"ciphertext", base.SetDegradedModeToContext(context.Background(), base.DegradedModeCiphertext), container, false},
+		{"ciphertext with masking",
+			base.SetDegradedModeToContext(encryptor.NewContextWithEncryptionSetting(context.Background(), maskedSetting), base.DegradedModeCiphertext),
+			container, false},
+		{"masked without masking", base.SetDegradedModeToContext(context.Background(), base.DegradedModeMasked), container, false},
+		{"masked with masking",
+			base.SetDegradedModeToContext(encryptor.NewContextWithEncryptionSetting(context.Background(), maskedSetting), base.DegradedModeMasked),
+			[]byte("xxxx"), false},
+	}
+	for _, tcase := range testcases {
+		processorCalled = false
+		result, err := handler.OnCryptoEnvelope(tcase.ctx, container)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %s", tcase.name, err)
+		}
+		if !bytes.Equal(result, tcase.expected) {
+			t.Fatalf("[%s] Expected '%s', took '%s'", tcase.name, tcase.expected, result)
+		}
+		if processorCalled != tcase.called {
+			t.Fatalf("[%s] Expected processor call %t, took %t", tcase.name, tcase.called, processorCalled)
+		}
+	}
+}
@@ -57,7 +57,11 @@ func (recognizer PoisonRecordDetector) OnCryptoEnvelope(ctx context.Context, con
 	if err == nil {
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorRecognizedPoisonRecord).Warningln("Recognized poison record")
 		if recognizer.callbacks.HasCallbacks() {
-			err = recognizer.callbacks.Call()
+			if contextCallbacks, ok := recognizer.callbacks.(base.ContextCallback); ok {
+				err = contextCallbacks.CallWithContext(ctx)
+			} else {
+				err = recognizer.callbacks.Call()
+			}
 			if err != nil {
 				logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantCheckPoisonRecord).WithError(err).Errorln("Unexpected error in poison record callbacks")
 			}
@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"context"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqlparser"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// PoisonRecordQueryDetector implements QueryObserver and searches poison records in literals of queries
+// and in values of prepared statements sent by clients. Found poison records trigger poison record callbacks
+// same as on decryption of database responses. Queries and values are never modified.
+type PoisonRecordQueryDetector struct {
+	coder    encryptor.DBDataCoder
+	detector base.DecryptionSubscriber
+}
+
+// NewPoisonRecordQueryDetector construct new PoisonRecordQueryDetector which passes inbound data to detector,
+// detector should be EnvelopeDetector with PoisonRecordDetector as callback
+func NewPoisonRecordQueryDetector(coder encryptor.DBDataCoder, detector base.DecryptionSubscriber) *PoisonRecordQueryDetector {
+	return &PoisonRecordQueryDetector{coder: coder, detector: detector}
+}
+
+// NewPostgresqlPoisonRecordQueryDetector construct new PoisonRecordQueryDetector with coder for postgresql
+func NewPostgresqlPoisonRecordQueryDetector(detector base.DecryptionSubscriber) *PoisonRecordQueryDetector {
+	return NewPoisonRecordQueryDetector(&encryptor.PostgresqlDBDataCoder{}, detector)
+}
+
+// NewMysqlPoisonRecordQueryDetector construct new PoisonRecordQueryDetector with coder for mysql
+func NewMysqlPoisonRecordQueryDetector(detector base.DecryptionSubscriber) *PoisonRecordQueryDetector {
+	return NewPoisonRecordQueryDetector(&encryptor.MysqlDBDataCoder{}, detector)
+}
+
+// ID returns name of this QueryObserver.
+func (queryDetector *PoisonRecordQueryDetector) ID() string {
+	return "PoisonRecordQueryDetector"
+}
+
+// OnQuery searches poison records in all literals of the query
+func (queryDetector *PoisonRecordQueryDetector) OnQuery(ctx context.Context, query base.OnQueryObject) (base.OnQueryObject, bool, error) {
+	logger := logging.GetLoggerFromContext(ctx)
+	stmt, err := query.Statement()
+	if err != nil {
+		logger.WithError(err).Debugln("Can't parse SQL statement, skip poison record detection in query")
+		return query, false, nil
+	}
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		value, ok := node.(*sqlparser.SQLVal)
+		if !ok {
+			return true, nil
+		}
+		data, err := queryDetector.coder.Decode(value)
+		if err != nil {
+			// placeholders and values with invalid encoding can't contain serialized containers
+			return true, nil
+		}
+		return true, queryDetector.detect(ctx, data)
+	}, stmt)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't process poison record callbacks on query")
+	}
+	return query, false, nil
+}
+
+// OnBind searches poison records in values of prepared statement
+func (queryDetector *PoisonRecordQueryDetector) OnBind(ctx context.Context, statement sqlparser.Statement, values []base.BoundValue) ([]base.BoundValue, bool, error) {
+	logger := logging.GetLoggerFromContext(ctx)
+	for i, value := range values {
+		data, err := value.GetData(nil)
+		if err != nil {
+			continue
+		}
+		if value.Format() == base.TextFormat {
+			// binary data in text format may be sent hex/octal encoded
+			if decoded, err := utils.DecodeEscaped(data); err == nil {
+				data = decoded
+			}
+		}
+		if err := queryDetector.detect(ctx, data); err != nil {
+			logger.WithError(err).WithField("parameter", i).Errorln("Can't process poison record callbacks on bound value")
+			return values, false, nil
+		}
+	}
+	return values, false, nil
+}
+
+func (queryDetector *PoisonRecordQueryDetector) detect(ctx context.Context, data []byte) error {
+	if len(data) < SerializedContainerMinSize {
+		return nil
+	}
+	_, _, err := queryDetector.detector.OnColumn(ctx, data)
+	return err
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	baseMocks "github.com/cossacklabs/acra/decryptor/base/mocks"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+type recordingSubscriber struct {
+	data [][]byte
+	err  error
+}
+
+func (subscriber *recordingSubscriber) OnColumn(ctx context.Context, data []byte) (context.Context, []byte, error) {
+	subscriber.data = append(subscriber.data, data)
+	return ctx, data, subscriber.err
+}
+
+func (subscriber *recordingSubscriber) ID() string {
+	return "recordingSubscriber"
+}
+
+func TestPoisonRecordQueryDetectorOnQuery(t *testing.T) {
+	container := bytes.Repeat([]byte{'a'}, SerializedContainerMinSize)
+	parser := sqlparser.New(sqlparser.ModeStrict)
+	subscriber := &recordingSubscriber{}
+	queryDetector := NewPostgresqlPoisonRecordQueryDetector(subscriber)
+
+	query := "insert into test(id, data, short) values (1, X'" + hex.EncodeToString(container) + "', 'short')"
+	queryObject := base.NewOnQueryObjectFromQuery(query, parser)
+	newQuery, changed, err := queryDetector.OnQuery(context.Background(), queryObject)
+	if err != nil || changed || newQuery != queryObject {
+		t.Fatalf("Expected unchanged query, took changed=%v, err=%v", changed, err)
+	}
+	if len(subscriber.data) != 1 || !bytes.Equal(subscriber.data[0], container) {
+		t.Fatalf("Expected only decoded container passed to detector, took %q", subscriber.data)
+	}
+
+	// errors of callbacks don't prevent processing of query
+	subscriber = &recordingSubscriber{err: errors.New("callback error")}
+	queryDetector = NewPostgresqlPoisonRecordQueryDetector(subscriber)
+	if _, changed, err := queryDetector.OnQuery(context.Background(), queryObject); err != nil || changed {
+		t.Fatalf("Expected unchanged query without error, took changed=%v, err=%v", changed, err)
+	}
+
+	// unparseable queries are skipped
+	_, changed, err = queryDetector.OnQuery(context.Background(), base.NewOnQueryObjectFromQuery("invalid query", parser))
+	if err != nil || changed {
+		t.Fatalf("Expected skipped query, took changed=%v, err=%v", changed, err)
+	}
+}
+
+func TestPoisonRecordQueryDetectorOnBind(t *testing.T) {
+	container := bytes.Repeat([]byte{'a'}, SerializedContainerMinSize)
+
+	binaryValue := &baseMocks.BoundValue{}
+	binaryValue.On("GetData", mock.Anything).Return(container, nil)
+	binaryValue.On("Format").Return(base.BinaryFormat)
+
+	textValue := &baseMocks.BoundValue{}
+	textValue.On("GetData", mock.Anything).Return([]byte("\\x"+hex.EncodeToString(container)), nil)
+	textValue.On("Format").Return(base.TextFormat)
+
+	shortValue := &baseMocks.BoundValue{}
+	shortValue.On("GetData", mock.Anything).Return([]byte("short"), nil)
+	shortValue.On("Format").Return(base.TextFormat)
+
+	subscriber := &recordingSubscriber{}
+	queryDetector := NewPostgresqlPoisonRecordQueryDetector(subscriber)
+	values := []base.BoundValue{binaryValue, textValue, shortValue}
+	newValues, changed, err := queryDetector.OnBind(context.Background(), nil, values)
+	if err != nil || changed || len(newValues) != len(values) {
+		t.Fatalf("Expected unchanged values, took changed=%v, err=%v", changed, err)
+	}
+	if len(subscriber.data) != 2 || !bytes.Equal(subscriber.data[0], container) || !bytes.Equal(subscriber.data[1], container) {
+		t.Fatalf("Expected decoded containers passed to detector, took %q", subscriber.data)
+	}
+}
@@ -22,6 +22,9 @@ func NewPrometheusContainerHandlerWrapper(handler ContainerHandler, containerHan
 // Decrypt proxy ContainerHandler.Decrypt with prometheus metrics
 func (handler PrometheusContainerHandlerWrapper) Decrypt(data []byte, context *base.DataProcessorContext) ([]byte, error) {
 	decrypted, err := handler.ContainerHandler.Decrypt(data, context)
+	if context != nil && context.Context != nil {
+		base.ColumnMetricsRecorderFromContext(context.Context).OnDecryption(err == nil)
+	}
 	if err != nil {
 		base.AcraDecryptionCounter.WithLabelValues(base.LabelStatusFail, handler.containerHandlerType).Inc()
 		return nil, err
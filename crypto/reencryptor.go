@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"context"
+	"github.com/cossacklabs/acra/acrablock"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/keystore"
@@ -30,17 +31,21 @@ func (r ReEncryptHandler) EncryptWithClientID(clientID, data []byte, setting con
 
 	// case when data encrypted on app side (for example AcraStructs with AcraWriter) and should not be encrypted second time
 	if r.MatchDataSignature(data) {
-		return data, nil
-	}
-
-	if setting.ShouldReEncryptAcraStructToAcraBlock() {
+		// AcraBlocks created before binding of the column are re-encrypted to bind them, so existing data migrates
+		// on rewriting. After migration unbound AcraBlocks may be copied from any other column, so they aren't bound
+		// and are refused on reading
+		if setting.GetAcraBlockAssociatedData() == nil || setting.IsAcraBlockColumnBindingRequired() || !isUnboundAcraBlock(data) {
+			return data, nil
+		}
+		decrypted, err := r.decrypt(clientID, data)
+		if err != nil {
+			return data, err
+		}
+		data = decrypted
+	} else if setting.ShouldReEncryptAcraStructToAcraBlock() {
 		// decrypt AcraStruct inside SerializedContainer to encrypt it with AcraBlock
 		if _, serialized, err := ExtractSerializedContainer(data); err == nil {
-			dataContext := base.NewDataProcessorContext(r.keystore)
-			accessContext := base.NewAccessContext(base.WithClientID(clientID))
-			dataContext.Context = base.SetAccessContextToContext(context.Background(), accessContext)
-
-			decrypted, err := r.handler.Process(serialized, dataContext)
+			decrypted, err := r.decrypt(clientID, serialized)
 			if err != nil {
 				return data, err
 			}
@@ -51,6 +56,27 @@ func (r ReEncryptHandler) EncryptWithClientID(clientID, data []byte, setting con
 	return r.handler.EncryptWithClientID(clientID, data, setting)
 }
 
+// decrypt returns decrypted AcraStruct or AcraBlock using keys of clientID
+func (r ReEncryptHandler) decrypt(clientID, data []byte) ([]byte, error) {
+	dataContext := base.NewDataProcessorContext(r.keystore)
+	accessContext := base.NewAccessContext(base.WithClientID(clientID))
+	dataContext.Context = base.SetAccessContextToContext(context.Background(), accessContext)
+	return r.handler.Process(data, dataContext)
+}
+
+// isUnboundAcraBlock returns true if data is AcraBlock or serialized AcraBlock which isn't bound to associated data
+func isUnboundAcraBlock(data []byte) bool {
+	internal, envelopeID, err := DeserializeEncryptedData(data)
+	if err != nil || envelopeID != AcraBlockEnvelopeID {
+		return false
+	}
+	acraBlock, err := acrablock.NewAcraBlockFromData(internal)
+	if err != nil {
+		return false
+	}
+	return !acraBlock.IsBoundToAssociatedData()
+}
+
 // MatchDataSignature implementation of ContainerHandler.MatchDataSignature method
 func (r ReEncryptHandler) MatchDataSignature(data []byte) bool {
 	handler, err := GetHandlerByName(string(config.CryptoEnvelopeTypeAcraBlock))
@@ -2,10 +2,13 @@ package crypto
 
 import (
 	"bytes"
+	"context"
 	"github.com/cossacklabs/acra/acrablock"
 	"github.com/cossacklabs/acra/acrastruct"
 	"testing"
 
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/keystore/mocks"
 	"github.com/cossacklabs/themis/gothemis/keys"
@@ -104,4 +107,101 @@ func TestReEncryptHandler(t *testing.T) {
 
 	})
 
+	t.Run("AcraBlock binding to column", func(t *testing.T) {
+		columnBinding := true
+		setting := &config.BasicColumnEncryptionSetting{
+			Name:                   "data",
+			CryptoEnvelope:         &acraBlockEnvelopeType,
+			AcraBlockColumnBinding: &columnBinding,
+		}
+		rawAcraBlock, err := acrablock.CreateAcraBlock([]byte(rawData), []byte(`some key`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serialized, err := SerializeEncryptedData(rawAcraBlock, AcraBlockEnvelopeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, data := range [][]byte{rawAcraBlock, serialized} {
+			// unbound AcraBlocks are re-encrypted to bind them to the column
+			result, err := reEncryptor.EncryptWithClientID(clientID, data, setting)
+			if err != nil {
+				t.Fatal("failure on encryption with clientID ", err)
+			}
+			internal, envelopeID, err := DeserializeEncryptedData(result)
+			if err != nil || envelopeID != AcraBlockEnvelopeID {
+				t.Fatal("invalid serialized AcraBlock", err)
+			}
+			acraBlock, err := acrablock.NewAcraBlockFromData(internal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !acraBlock.IsBoundToAssociatedData() {
+				t.Fatal("AcraBlock isn't bound to the column")
+			}
+			decrypted, err := acraBlock.DecryptWithAssociatedData([][]byte{[]byte(`some key`)}, nil, setting.GetAcraBlockAssociatedData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, []byte(rawData)) {
+				t.Fatal("decrypted data is not equals to source data")
+			}
+
+			// bound AcraBlocks are left as is
+			second, err := reEncryptor.EncryptWithClientID(clientID, result, setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(second, result) {
+				t.Fatal("bound AcraBlock was re-encrypted")
+			}
+		}
+	})
+
+	t.Run("AcraBlock from another column when binding is required", func(t *testing.T) {
+		columnBinding := true
+		setting := &config.BasicColumnEncryptionSetting{
+			Name:                           "data",
+			CryptoEnvelope:                 &acraBlockEnvelopeType,
+			AcraBlockColumnBinding:         &columnBinding,
+			AcraBlockColumnBindingRequired: &columnBinding,
+		}
+		// AcraBlock taken from another column without binding
+		rawAcraBlock, err := acrablock.CreateAcraBlock([]byte(rawData), []byte(`some key`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serialized, err := SerializeEncryptedData(rawAcraBlock, AcraBlockEnvelopeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, data := range [][]byte{rawAcraBlock, serialized} {
+			// unbound AcraBlock isn't bound to the column on writing
+			result, err := reEncryptor.EncryptWithClientID(clientID, data, setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result, data) {
+				t.Fatal("unbound AcraBlock was re-encrypted in column which requires binding")
+			}
+
+			// and is refused on reading, while the column without required binding still decrypts it
+			ctx := base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID(clientID)))
+			dataContext := base.NewDataProcessorContext(keystore)
+			dataContext.Context = encryptor.NewContextWithEncryptionSetting(ctx, setting)
+			if _, err := reEncryptor.handler.Process(result, dataContext); err == nil {
+				t.Fatal("expected error on decryption of unbound AcraBlock in column which requires binding")
+			}
+			dataContext.Context = encryptor.NewContextWithEncryptionSetting(ctx, &config.BasicColumnEncryptionSetting{
+				Name:                   "data",
+				CryptoEnvelope:         &acraBlockEnvelopeType,
+				AcraBlockColumnBinding: &columnBinding,
+			})
+			if decrypted, err := reEncryptor.handler.Process(result, dataContext); err != nil || !bytes.Equal(decrypted, []byte(rawData)) {
+				t.Fatal("unbound AcraBlock should be decrypted in column which doesn't require binding", err)
+			}
+		}
+	})
 }
@@ -49,6 +49,11 @@ func InitRegistry(keyStore keystore.ServerKeyStore) error {
 	return Register(acraStructPrometheusHandler)
 }
 
+// IsRegistryInitialized returns true if InitRegistry was called
+func IsRegistryInitialized() bool {
+	return registry != nil
+}
+
 // Register public API allows registering other handlers from other packages
 func Register(handler ContainerHandler) error {
 	_, ok := registry.envelopes[handler.Name()]
@@ -10,6 +10,7 @@ import (
 	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/keystore"
+	"math"
 )
 
 // Errors related to crypto handlers
@@ -109,7 +110,7 @@ func (r RegistryHandler) EncryptWithClientID(clientID, data []byte, setting conf
 		return data, nil
 	}
 
-	encrypted, err := handler.EncryptWithClientID(clientID, data, &encryptor.DataEncryptorContext{Keystore: r.keystore})
+	encrypted, err := handler.EncryptWithClientID(clientID, data, &encryptor.DataEncryptorContext{Keystore: r.keystore, Setting: setting})
 	if err != nil {
 		return nil, err
 	}
@@ -211,6 +212,19 @@ func ExtractSerializedContainer(data []byte) (int, []byte, error) {
 	return 0, nil, ErrNoSerializedContainerExtracted
 }
 
+// GetSerializedContainerLength returns length of serialized container declared in its header. Only header and
+// envelope id are validated, so data may contain the beginning of container
+func GetSerializedContainerLength(data []byte) (int, error) {
+	if _, err := validateSerializedContainer(data); err != nil {
+		return 0, err
+	}
+	length := binary.LittleEndian.Uint64(data[len(TagBegin) : len(TagBegin)+SerializedContainerLengthSize])
+	if length <= uint64(SerializedContainerMinSize) || length > math.MaxInt32 {
+		return 0, ErrIncorrectSerializedContainer
+	}
+	return int(length), nil
+}
+
 // getEnvelopeIDFromData return envelopeID from data
 func getEnvelopeIDFromData(data []byte) (byte, error) {
 	envelopeID, err := validateSerializedContainer(data)
@@ -250,7 +264,8 @@ func matchOldContainer(data []byte) (byte, int, error) {
 		return AcraStructEnvelopeID, acrastruct.GetDataLengthFromAcraStruct(data) + acrastruct.GetMinAcraStructLength(), nil
 	}
 
-	if length, _, err := acrablock.ExtractAcraBlockFromData(data); err == nil {
+	// AcraBlocks of unsupported format matched to be refused loudly by AcraBlockHandler
+	if length, _, err := acrablock.ExtractAcraBlockFromData(data); err == nil || errors.Is(err, acrablock.ErrUnsupportedFormatVersion) {
 		return AcraBlockEnvelopeID, length, nil
 	}
 
@@ -324,3 +324,29 @@ func TestDecryptWithHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestGetSerializedContainerLength(t *testing.T) {
+	if err := InitRegistry(nil); err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := SerializeEncryptedData([]byte("some data"), AcraBlockEnvelopeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header with envelope id is enough to get length
+	length, err := GetSerializedContainerLength(serialized[:SerializedContainerMinSize+1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != len(serialized) {
+		t.Fatalf("Expected %d length, took %d", len(serialized), length)
+	}
+	if _, err := GetSerializedContainerLength(serialized[:SerializedContainerMinSize]); err != ErrIncorrectSerializedContainer {
+		t.Fatalf("Expected %v, took %v", ErrIncorrectSerializedContainer, err)
+	}
+	invalid := append([]byte{}, serialized...)
+	invalid[0] = 0
+	if _, err := GetSerializedContainerLength(invalid); err != ErrIncorrectSerializedContainer {
+		t.Fatalf("Expected %v, took %v", ErrIncorrectSerializedContainer, err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"bytes"
+	"math/bits"
+	"sync"
+)
+
+const (
+	minPooledBytesShift = 6
+	maxPooledBytesShift = 16
+	bytesPoolsCount     = maxPooledBytesShift - minPooledBytesShift + 1
+	// MinPooledBytesSize is capacity of the smallest byte slices returned by GetBytes
+	MinPooledBytesSize = 1 << minPooledBytesShift
+	// MaxPooledBytesSize limits size of buffers which are kept in pools, so single huge packet doesn't hold memory
+	// after processing
+	MaxPooledBytesSize = 1 << maxPooledBytesShift
+	// DefaultPacketBufferSize is initial capacity of packet buffers returned by GetPacketBuffer
+	DefaultPacketBufferSize = 1024
+)
+
+// packetBufferPool reuses buffers of packets between connections
+var packetBufferPool = sync.Pool{New: func() interface{} {
+	return bytes.NewBuffer(make([]byte, 0, DefaultPacketBufferSize))
+}}
+
+// bytesPools contains pools of byte slices with capacity of power of two from MinPooledBytesSize to MaxPooledBytesSize
+var bytesPools [bytesPoolsCount]sync.Pool
+
+// GetPacketBuffer returns empty buffer from pool. Buffer should be returned with PutPacketBuffer when it's not used anymore
+func GetPacketBuffer() *bytes.Buffer {
+	return packetBufferPool.Get().(*bytes.Buffer)
+}
+
+// PutPacketBuffer resets buffer and returns it to pool. Buffers which grew more than MaxPooledBytesSize are left to GC
+func PutPacketBuffer(buffer *bytes.Buffer) {
+	if buffer == nil || buffer.Cap() > MaxPooledBytesSize {
+		return
+	}
+	buffer.Reset()
+	packetBufferPool.Put(buffer)
+}
+
+// bytesPoolIndex returns index of pool with slices which capacity is enough for length
+func bytesPoolIndex(length int) int {
+	if length <= MinPooledBytesSize {
+		return 0
+	}
+	return bits.Len(uint(length-1)) - minPooledBytesShift
+}
+
+// GetBytes returns byte slice with specified length. Content of the slice is undefined and should be overwritten by
+// caller. Slice should be returned with PutBytes when it's not used anymore
+func GetBytes(length int) *[]byte {
+	if length > MaxPooledBytesSize {
+		data := make([]byte, length)
+		return &data
+	}
+	index := bytesPoolIndex(length)
+	if data, ok := bytesPools[index].Get().(*[]byte); ok {
+		*data = (*data)[:length]
+		return data
+	}
+	data := make([]byte, length, MinPooledBytesSize<<index)
+	return &data
+}
+
+// PutBytes returns slice taken by GetBytes to pool. Slice shouldn't be used after that
+func PutBytes(data *[]byte) {
+	if data == nil {
+		return
+	}
+	capacity := cap(*data)
+	if capacity < MinPooledBytesSize || capacity > MaxPooledBytesSize || capacity&(capacity-1) != 0 {
+		return
+	}
+	bytesPools[bytesPoolIndex(capacity)].Put(data)
+}
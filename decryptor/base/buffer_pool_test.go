@@ -0,0 +1,68 @@
+package base
+
+import (
+	"testing"
+)
+
+func TestGetBytes(t *testing.T) {
+	testcases := []struct {
+		length   int
+		capacity int
+	}{
+		{1, MinPooledBytesSize},
+		{MinPooledBytesSize, MinPooledBytesSize},
+		{MinPooledBytesSize + 1, MinPooledBytesSize * 2},
+		{1000, 1024},
+		{1024, 1024},
+		{MaxPooledBytesSize, MaxPooledBytesSize},
+		{MaxPooledBytesSize + 1, MaxPooledBytesSize + 1},
+	}
+	for _, tcase := range testcases {
+		data := GetBytes(tcase.length)
+		if len(*data) != tcase.length || cap(*data) != tcase.capacity {
+			t.Fatalf("Expected slice with length %d and capacity %d, took %d and %d", tcase.length, tcase.capacity, len(*data), cap(*data))
+		}
+		PutBytes(data)
+		// slice taken from pool should have requested length too
+		data = GetBytes(tcase.length)
+		if len(*data) != tcase.length || cap(*data) < tcase.length {
+			t.Fatalf("Expected slice with length %d, took %d", tcase.length, len(*data))
+		}
+		PutBytes(data)
+	}
+	// slices not from GetBytes are ignored
+	odd := make([]byte, 100)
+	PutBytes(&odd)
+	PutBytes(nil)
+}
+
+func TestPacketBuffer(t *testing.T) {
+	buffer := GetPacketBuffer()
+	buffer.WriteString("some data")
+	PutPacketBuffer(buffer)
+	if buffer.Len() != 0 {
+		t.Fatal("Buffer wasn't reset")
+	}
+	if buffer = GetPacketBuffer(); buffer.Len() != 0 {
+		t.Fatal("Expected empty buffer")
+	}
+	PutPacketBuffer(nil)
+}
+
+var bytesSink []byte
+
+func BenchmarkGetBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data := GetBytes(1000)
+		bytesSink = *data
+		PutBytes(data)
+	}
+}
+
+func BenchmarkMakeBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bytesSink = make([]byte, 1000)
+	}
+}
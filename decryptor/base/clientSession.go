@@ -2,13 +2,18 @@ package base
 
 import (
 	"context"
+	"crypto/x509"
 	"net"
+
+	"github.com/cossacklabs/acra/network"
 )
 
 // ClientSession is a connection between the client and the database, mediated by AcraServer.
 type ClientSession interface {
 	Context() context.Context
 	ClientConnection() net.Conn
+	// ClientAddress returns address of the client, it's taken from PROXY protocol header if it is turned on
+	ClientAddress() net.Addr
 	DatabaseConnection() net.Conn
 
 	PreparedStatementRegistry() PreparedStatementRegistry
@@ -38,3 +43,25 @@ func ClientSessionFromContext(ctx context.Context) ClientSession {
 	}
 	return nil
 }
+
+// peerCertificateSessionKey is the key of client's certificate in session data, used when TLS connection was
+// established after session start (like PostgreSQL SSLRequest or MySQL SSL switch)
+const peerCertificateSessionKey = "peer_certificate"
+
+// SetPeerCertificateToSession saves client's TLS certificate in session data
+func SetPeerCertificateToSession(session ClientSession, certificate *x509.Certificate) {
+	if certificate == nil {
+		return
+	}
+	session.SetData(peerCertificateSessionKey, certificate)
+}
+
+// PeerCertificateFromSession returns client's verified TLS certificate or nil if client connected without it
+func PeerCertificateFromSession(session ClientSession) *x509.Certificate {
+	if value, ok := session.GetData(peerCertificateSessionKey); ok {
+		if certificate, ok := value.(*x509.Certificate); ok {
+			return certificate
+		}
+	}
+	return network.GetPeerCertificateFromConnection(session.ClientConnection())
+}
@@ -17,6 +17,10 @@ func (s sessionStub) ClientConnection() net.Conn {
 	panic("implement me")
 }
 
+func (s sessionStub) ClientAddress() net.Addr {
+	panic("implement me")
+}
+
 func (s sessionStub) DatabaseConnection() net.Conn {
 	panic("implement me")
 }
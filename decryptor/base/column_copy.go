@@ -0,0 +1,50 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ColumnCopyPolicy defines how AcraServer handles queries which copy data between columns inside the database, like
+// INSERT ... SELECT and UPDATE ... SET column = other_column. Such data doesn't pass through AcraServer, so it can't
+// be re-encrypted and is stored as is
+type ColumnCopyPolicy string
+
+// Supported column copy policies
+const (
+	// ColumnCopyPolicyDeny rejects queries which copy data between columns with different encryptor config settings
+	ColumnCopyPolicyDeny ColumnCopyPolicy = "deny"
+	// ColumnCopyPolicyAllow forwards such queries to the database and logs warning
+	ColumnCopyPolicyAllow ColumnCopyPolicy = "allow"
+)
+
+// ErrInvalidColumnCopyPolicy returned for unsupported column copy policy
+var ErrInvalidColumnCopyPolicy = errors.New("invalid column copy policy")
+
+// ErrColumnCopyDenied returned when query rejected according to ColumnCopyPolicyDeny
+var ErrColumnCopyDenied = errors.New("copying data between columns with different encryption settings is denied")
+
+// ParseColumnCopyPolicy returns ColumnCopyPolicy from string or ErrInvalidColumnCopyPolicy
+func ParseColumnCopyPolicy(value string) (ColumnCopyPolicy, error) {
+	switch policy := ColumnCopyPolicy(value); policy {
+	case ColumnCopyPolicyDeny, ColumnCopyPolicyAllow:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidColumnCopyPolicy, value)
+}
@@ -0,0 +1,32 @@
+package base
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseColumnCopyPolicy(t *testing.T) {
+	for _, value := range []string{"deny", "allow"} {
+		policy, err := ParseColumnCopyPolicy(value)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", value, err)
+		}
+		if string(policy) != value {
+			t.Fatalf("Expected %s, took %s", value, policy)
+		}
+	}
+	for _, value := range []string{"", "reencrypt", "Allow"} {
+		if _, err := ParseColumnCopyPolicy(value); !errors.Is(err, ErrInvalidColumnCopyPolicy) {
+			t.Fatalf("Expected ErrInvalidColumnCopyPolicy for '%s', took %v", value, err)
+		}
+	}
+	// copying denied if policy is not configured
+	setting := NewProxySetting(nil, nil, nil, nil, nil, nil)
+	if setting.ColumnCopyPolicy() != ColumnCopyPolicyDeny {
+		t.Fatalf("Expected deny policy by default, took %s", setting.ColumnCopyPolicy())
+	}
+	setting = NewProxySetting(nil, nil, nil, nil, nil, nil, WithColumnCopyPolicy(ColumnCopyPolicyAllow))
+	if setting.ColumnCopyPolicy() != ColumnCopyPolicyAllow {
+		t.Fatalf("Expected allow policy, took %s", setting.ColumnCopyPolicy())
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels of per-column metrics
+const (
+	LabelTable  = "table"
+	LabelColumn = "column"
+	// LabelValueOtherColumns used as table and column labels for columns above the limit of tracked columns
+	LabelValueOtherColumns = "_other"
+)
+
+// DefaultColumnMetricsLimit default maximum number of distinct table/column pairs tracked by per-column metrics
+const DefaultColumnMetricsLimit = 1000
+
+var (
+	// ColumnDecryptionCounter collect decryptions count success/failed per table and column
+	ColumnDecryptionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_column_decryptions_total",
+			Help: "number of decryptions of values of encrypted columns in query responses by table and column",
+		}, []string{LabelTable, LabelColumn, LabelStatus})
+
+	// ColumnEncryptionCounter collect count of values encrypted or tokenized in client's queries per table and column
+	ColumnEncryptionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_column_encryptions_total",
+			Help: "number of values encrypted or tokenized in client's queries by table and column",
+		}, []string{LabelTable, LabelColumn})
+
+	// ColumnMaskingFallbackCounter collect count of values replaced with masking pattern because they weren't decrypted
+	ColumnMaskingFallbackCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_column_masking_fallbacks_total",
+			Help: "number of values returned as masking pattern because they weren't decrypted by table and column",
+		}, []string{LabelTable, LabelColumn})
+)
+
+var columnMetricsRegisterLock = sync.Once{}
+
+// RegisterColumnMetrics register in default prometheus registry per-column metrics
+func RegisterColumnMetrics() {
+	columnMetricsRegisterLock.Do(func() {
+		prometheus.MustRegister(ColumnDecryptionCounter)
+		prometheus.MustRegister(ColumnEncryptionCounter)
+		prometheus.MustRegister(ColumnMaskingFallbackCounter)
+	})
+}
+
+type columnKey struct {
+	table  string
+	column string
+}
+
+// ColumnMetrics limits cardinality of per-column metrics: first `limit` distinct table/column pairs are reported with
+// own labels, all next pairs are reported as LabelValueOtherColumns. nil ColumnMetrics reports nothing
+type ColumnMetrics struct {
+	lock    sync.RWMutex
+	limit   int
+	columns map[columnKey]struct{}
+}
+
+// NewColumnMetrics returns new ColumnMetrics which tracks at most limit table/column pairs
+func NewColumnMetrics(limit int) *ColumnMetrics {
+	return &ColumnMetrics{limit: limit, columns: make(map[columnKey]struct{})}
+}
+
+// labels returns label values for table and column taking into account limit of tracked columns
+func (metrics *ColumnMetrics) labels(table, column string) (string, string) {
+	key := columnKey{table: table, column: column}
+	metrics.lock.RLock()
+	_, ok := metrics.columns[key]
+	metrics.lock.RUnlock()
+	if ok {
+		return table, column
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	if _, ok := metrics.columns[key]; ok {
+		return table, column
+	}
+	if len(metrics.columns) >= metrics.limit {
+		return LabelValueOtherColumns, LabelValueOtherColumns
+	}
+	metrics.columns[key] = struct{}{}
+	return table, column
+}
+
+// Column returns recorder of events related to the column
+func (metrics *ColumnMetrics) Column(table, column string) ColumnMetricsRecorder {
+	if metrics == nil || table == "" || column == "" {
+		return ColumnMetricsRecorder{}
+	}
+	table, column = metrics.labels(table, column)
+	return ColumnMetricsRecorder{enabled: true, table: table, column: column}
+}
+
+// ColumnMetricsRecorder updates per-column metrics of one column, zero value does nothing
+type ColumnMetricsRecorder struct {
+	enabled bool
+	table   string
+	column  string
+}
+
+// OnDecryption counts decryption of column's value
+func (recorder ColumnMetricsRecorder) OnDecryption(success bool) {
+	if !recorder.enabled {
+		return
+	}
+	status := LabelStatusSuccess
+	if !success {
+		status = LabelStatusFail
+	}
+	ColumnDecryptionCounter.WithLabelValues(recorder.table, recorder.column, status).Inc()
+}
+
+// OnEncryption counts encryption or tokenization of column's value
+func (recorder ColumnMetricsRecorder) OnEncryption() {
+	if !recorder.enabled {
+		return
+	}
+	ColumnEncryptionCounter.WithLabelValues(recorder.table, recorder.column).Inc()
+}
+
+// OnMaskingFallback counts column's value replaced with masking pattern
+func (recorder ColumnMetricsRecorder) OnMaskingFallback() {
+	if !recorder.enabled {
+		return
+	}
+	ColumnMaskingFallbackCounter.WithLabelValues(recorder.table, recorder.column).Inc()
+}
+
+type columnMetricsKey struct{}
+
+type columnMetricsRecorderKey struct{}
+
+// SetColumnMetricsToContext returns context with saved ColumnMetrics
+func SetColumnMetricsToContext(ctx context.Context, metrics *ColumnMetrics) context.Context {
+	return context.WithValue(ctx, columnMetricsKey{}, metrics)
+}
+
+// ColumnMetricsFromContext returns saved ColumnMetrics or nil which reports nothing
+func ColumnMetricsFromContext(ctx context.Context) *ColumnMetrics {
+	metrics, ok := ctx.Value(columnMetricsKey{}).(*ColumnMetrics)
+	if !ok {
+		return nil
+	}
+	return metrics
+}
+
+// SetColumnMetricsRecorderToContext returns context with saved recorder of currently processed column
+func SetColumnMetricsRecorderToContext(ctx context.Context, recorder ColumnMetricsRecorder) context.Context {
+	return context.WithValue(ctx, columnMetricsRecorderKey{}, recorder)
+}
+
+// ColumnMetricsRecorderFromContext returns recorder of currently processed column or recorder which does nothing
+func ColumnMetricsRecorderFromContext(ctx context.Context) ColumnMetricsRecorder {
+	recorder, ok := ctx.Value(columnMetricsRecorderKey{}).(ColumnMetricsRecorder)
+	if !ok {
+		return ColumnMetricsRecorder{}
+	}
+	return recorder
+}
@@ -0,0 +1,45 @@
+package base
+
+import (
+	"context"
+	"testing"
+)
+
+func TestColumnMetricsLimit(t *testing.T) {
+	metrics := NewColumnMetrics(2)
+	first := metrics.Column("users", "email")
+	second := metrics.Column("users", "phone")
+	overflow := metrics.Column("orders", "card")
+	if first.table != "users" || first.column != "email" || second.table != "users" || second.column != "phone" {
+		t.Fatal("Unexpected labels of tracked columns")
+	}
+	if overflow.table != LabelValueOtherColumns || overflow.column != LabelValueOtherColumns {
+		t.Fatalf("Expected overflow labels, took %s.%s", overflow.table, overflow.column)
+	}
+	// already tracked column keeps own labels after limit is reached
+	if again := metrics.Column("users", "email"); again.table != "users" || again.column != "email" {
+		t.Fatal("Tracked column lost own labels")
+	}
+}
+
+func TestColumnMetricsDisabled(t *testing.T) {
+	var metrics *ColumnMetrics
+	recorder := metrics.Column("users", "email")
+	if recorder.enabled {
+		t.Fatal("Recorder of nil metrics should be disabled")
+	}
+	recorder.OnDecryption(true)
+	recorder.OnEncryption()
+	recorder.OnMaskingFallback()
+
+	ctx := context.Background()
+	if ColumnMetricsFromContext(ctx) != nil || ColumnMetricsRecorderFromContext(ctx).enabled {
+		t.Fatal("Expected disabled metrics in empty context")
+	}
+	enabled := NewColumnMetrics(1)
+	ctx = SetColumnMetricsToContext(ctx, enabled)
+	ctx = SetColumnMetricsRecorderToContext(ctx, enabled.Column("users", "email"))
+	if ColumnMetricsFromContext(ctx) != enabled || !ColumnMetricsRecorderFromContext(ctx).enabled {
+		t.Fatal("Metrics weren't saved in context")
+	}
+}
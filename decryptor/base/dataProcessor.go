@@ -95,7 +95,7 @@ func (p DecryptProcessor) Process(data []byte, context *DataProcessorContext) ([
 	}
 	var privateKeys []*keys.PrivateKey
 	accessContext := AccessContextFromContext(context.Context)
-	privateKeys, err := context.Keystore.GetServerDecryptionPrivateKeys(accessContext.GetClientID())
+	privateKeys, err := context.KeysBatch.GetServerDecryptionPrivateKeys(context.Keystore, accessContext.GetClientID())
 	defer utils.ZeroizePrivateKeys(privateKeys)
 	if err != nil {
 		logging.GetLoggerFromContext(context.Context).WithError(err).WithFields(
@@ -114,6 +114,8 @@ func (DecryptProcessor) MatchDataSignature(data []byte) bool {
 type DataProcessorContext struct {
 	Keystore keystore.DataEncryptorKeyStore
 	Context  context.Context
+	// KeysBatch shares decryption keys fetched from Keystore between containers of one data row, may be nil
+	KeysBatch *keystore.DecryptionKeysBatch
 }
 
 // NewDataProcessorContext return context with initialized static data
@@ -121,6 +123,19 @@ func NewDataProcessorContext(keystore keystore.DataEncryptorKeyStore) *DataProce
 	return &DataProcessorContext{Keystore: keystore, Context: context.Background()}
 }
 
+type decryptionKeysBatchKey struct{}
+
+// SetDecryptionKeysBatchToContext save batch of decryption keys used to process one data row to ctx
+func SetDecryptionKeysBatchToContext(ctx context.Context, batch *keystore.DecryptionKeysBatch) context.Context {
+	return context.WithValue(ctx, decryptionKeysBatchKey{}, batch)
+}
+
+// DecryptionKeysBatchFromContext return batch of decryption keys from ctx or nil
+func DecryptionKeysBatchFromContext(ctx context.Context) *keystore.DecryptionKeysBatch {
+	batch, _ := ctx.Value(decryptionKeysBatchKey{}).(*keystore.DecryptionKeysBatch)
+	return batch
+}
+
 // UseContext replace context and return itself
 func (ctx *DataProcessorContext) UseContext(newContext context.Context) *DataProcessorContext {
 	ctx.Context = newContext
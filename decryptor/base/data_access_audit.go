@@ -0,0 +1,116 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// DataAccessAudit creates records of queries of sessions with database of dbType. nil value is valid and does nothing
+type DataAccessAudit struct {
+	auditLog *logging.DataAccessAuditLog
+	dbType   string
+}
+
+// NewDataAccessAudit returns DataAccessAudit which writes records into auditLog or nil if auditLog is nil
+func NewDataAccessAudit(auditLog *logging.DataAccessAuditLog, dbType string) *DataAccessAudit {
+	if auditLog == nil {
+		return nil
+	}
+	return &DataAccessAudit{auditLog: auditLog, dbType: dbType}
+}
+
+// NewRecord returns record of query allowed to be sent to the database by the client with clientID from AccessContext
+// and address from ClientSession of ctx
+func (audit *DataAccessAudit) NewRecord(ctx context.Context) *DataAccessRecord {
+	if audit == nil {
+		return nil
+	}
+	record := &DataAccessRecord{audit: audit, columns: make(map[string]bool)}
+	record.event.ClientID = AccessContextFromContext(ctx).GetClientID()
+	if session := ClientSessionFromContext(ctx); session != nil {
+		if address := session.ClientAddress(); address != nil {
+			record.event.ClientAddress = address.String()
+		}
+	}
+	return record
+}
+
+// OnBlockedQuery writes record of client's query blocked by AcraCensor
+func (audit *DataAccessAudit) OnBlockedQuery(ctx context.Context) {
+	audit.NewRecord(ctx).Finish(logging.DataAccessVerdictBlocked)
+}
+
+// DataAccessRecord collects encrypted columns and count of rows of query's response until it is finished. nil value
+// is valid and does nothing
+type DataAccessRecord struct {
+	audit    *DataAccessAudit
+	lock     sync.Mutex
+	columns  map[string]bool
+	event    logging.DataAccessEvent
+	finished bool
+}
+
+// OnColumn marks column of table with encryption settings as accessed by the query
+func (record *DataAccessRecord) OnColumn(table, column string) {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	name := table + "." + column
+	if record.columns[name] {
+		return
+	}
+	record.columns[name] = true
+	record.event.Columns = append(record.event.Columns, name)
+	for _, known := range record.event.Tables {
+		if known == table {
+			return
+		}
+	}
+	record.event.Tables = append(record.event.Tables, table)
+}
+
+// OnRow counts row of query's response
+func (record *DataAccessRecord) OnRow() {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	record.event.Rows++
+	record.lock.Unlock()
+}
+
+// Finish writes record with verdict into audit log, only the first call writes it
+func (record *DataAccessRecord) Finish(verdict string) {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	if record.finished {
+		return
+	}
+	record.finished = true
+	record.event.Database = record.audit.dbType
+	record.event.Verdict = verdict
+	record.audit.auditLog.Log(&record.event)
+}
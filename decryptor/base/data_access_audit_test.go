@@ -0,0 +1,89 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+func TestDataAccessAuditNil(t *testing.T) {
+	audit := NewDataAccessAudit(nil, DecryptionDBPostgresql)
+	if audit != nil {
+		t.Fatal("Expected nil audit without audit log")
+	}
+	record := audit.NewRecord(context.Background())
+	record.OnColumn("table", "column")
+	record.OnRow()
+	record.Finish(logging.DataAccessVerdictAllowed)
+	audit.OnBlockedQuery(context.Background())
+}
+
+// addressSessionStub is ClientSession of the client with address
+type addressSessionStub struct {
+	sessionStub
+	address net.Addr
+}
+
+func (s addressSessionStub) ClientAddress() net.Addr {
+	return s.address
+}
+
+func TestDataAccessRecord(t *testing.T) {
+	output := &bytes.Buffer{}
+	log.SetOutput(output)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	}()
+	auditLog, err := logging.NewDataAccessAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := NewDataAccessAudit(auditLog, DecryptionDBPostgresql)
+
+	ctx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("client"))))
+	ctx = SetClientSessionToContext(ctx, addressSessionStub{address: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}})
+	record := audit.NewRecord(ctx)
+	record.OnColumn("users", "email")
+	record.OnColumn("users", "phone")
+	record.OnColumn("orders", "card")
+	for i := 0; i < 3; i++ {
+		record.OnColumn("users", "email")
+		record.OnRow()
+	}
+	record.Finish(logging.DataAccessVerdictAllowed)
+	// record is written only once
+	record.Finish(logging.DataAccessVerdictFailed)
+	audit.OnBlockedQuery(SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("client")))))
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 events, took %d: %s", len(lines), output.String())
+	}
+	expected := []map[string]interface{}{
+		{"client_id": "client", "client_address": "10.0.0.1:8080", "db": DecryptionDBPostgresql, "tables": "users,orders", "columns": "users.email,users.phone,orders.card",
+			"rows": float64(3), "verdict": logging.DataAccessVerdictAllowed, logging.FieldKeyAuditSequence: float64(1)},
+		{"client_id": "client", "client_address": "", "db": DecryptionDBPostgresql, "tables": "", "columns": "",
+			"rows": float64(0), "verdict": logging.DataAccessVerdictBlocked, logging.FieldKeyAuditSequence: float64(2)},
+	}
+	for i, line := range lines {
+		event := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range expected[i] {
+			if event[key] != value {
+				t.Fatalf("[%d] Expected %s=%v, took %v", i, key, value, event[key])
+			}
+		}
+	}
+}
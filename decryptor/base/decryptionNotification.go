@@ -74,7 +74,9 @@ func ColumnInfoFromContext(ctx context.Context) (ColumnInfo, bool) {
 	return info, info != nil
 }
 
-// DecryptionSubscriber interface to subscribe on column's data in db responses
+// DecryptionSubscriber interface to subscribe on column's data in db responses. Data passed to OnColumn may be stored
+// in buffer from pool which is reused for next rows after the response is processed, so subscribers which keep data
+// after OnColumn returns should copy it
 type DecryptionSubscriber interface {
 	OnColumn(context.Context, []byte) (context.Context, []byte, error)
 	ID() string
@@ -159,3 +161,15 @@ func MarkErrorConvertedDataTypeContext(ctx context.Context) context.Context {
 func IsErrorConvertedDataTypeFromContext(ctx context.Context) bool {
 	return ctx.Value(errorConvertedDataTypeCtxKey{}) != nil
 }
+
+type nullValueCtxKey struct{}
+
+// MarkNullValueContext save flag in context that column value should be returned as SQL NULL
+func MarkNullValueContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nullValueCtxKey{}, true)
+}
+
+// IsNullValueFromContext return true if column value should be returned as SQL NULL
+func IsNullValueFromContext(ctx context.Context) bool {
+	return ctx.Value(nullValueCtxKey{}) != nil
+}
@@ -0,0 +1,649 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+// Errors of differential privacy
+var (
+	ErrInvalidDifferentialPrivacyConfig = errors.New("invalid config of differential privacy")
+	ErrDifferentialPrivacyQueryRejected = errors.New("query of protected tables is rejected, only aggregates of plain SELECT grouped by allowed columns are allowed")
+)
+
+// privacyBudgetTolerance absorbs rounding errors of float sums, so budget may be spent by exactly budget/epsilon queries
+const privacyBudgetTolerance = 1e-9
+
+// Kinds of aggregates which get calibrated noise
+const (
+	noisyAggregateCount = "count"
+	noisyAggregateSum   = "sum"
+	noisyAggregateAvg   = "avg"
+)
+
+// DifferentialPrivacyColumnConfig sets bounds of values of protected column used to calibrate noise of SUM and AVG.
+// Values are clamped into bounds before aggregation
+type DifferentialPrivacyColumnConfig struct {
+	Name  string  `yaml:"name"`
+	Lower float64 `yaml:"lower"`
+	Upper float64 `yaml:"upper"`
+}
+
+// DifferentialPrivacyTableConfig describes protected table
+type DifferentialPrivacyTableConfig struct {
+	Name string `yaml:"name"`
+	// Columns which may be used in SUM and AVG, these aggregates over other columns are returned as NULL
+	Columns []DifferentialPrivacyColumnConfig `yaml:"columns"`
+	// GroupingColumns may be used in GROUP BY and are returned without noise, queries grouped by other columns of the
+	// table are rejected
+	GroupingColumns []string `yaml:"grouping_columns"`
+}
+
+// DifferentialPrivacyConfig is the structure of config of differential privacy
+type DifferentialPrivacyConfig struct {
+	// ClientIDs which receive noisy results of aggregate queries over protected tables
+	ClientIDs []string `yaml:"client_ids"`
+	// Epsilon spent by each noisy aggregate of the query
+	Epsilon float64 `yaml:"epsilon"`
+	// Budget is total epsilon which clientID may spend per BudgetPeriod
+	Budget float64 `yaml:"budget"`
+	// BudgetPeriod after which spent budget is reset, budget is never reset if it's zero
+	BudgetPeriod time.Duration                    `yaml:"budget_period"`
+	Tables       []DifferentialPrivacyTableConfig `yaml:"tables"`
+}
+
+// ParseDifferentialPrivacyConfig parses and validates config of differential privacy
+func ParseDifferentialPrivacyConfig(data []byte) (*DifferentialPrivacyConfig, error) {
+	config := &DifferentialPrivacyConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if len(config.ClientIDs) == 0 {
+		return nil, fmt.Errorf("%w: client_ids are empty", ErrInvalidDifferentialPrivacyConfig)
+	}
+	if len(config.Tables) == 0 {
+		return nil, fmt.Errorf("%w: tables are empty", ErrInvalidDifferentialPrivacyConfig)
+	}
+	if config.Epsilon <= 0 {
+		return nil, fmt.Errorf("%w: epsilon should be greater than 0", ErrInvalidDifferentialPrivacyConfig)
+	}
+	if config.Budget < config.Epsilon {
+		return nil, fmt.Errorf("%w: budget should be not less than epsilon", ErrInvalidDifferentialPrivacyConfig)
+	}
+	if config.BudgetPeriod < 0 {
+		return nil, fmt.Errorf("%w: budget_period can't be negative", ErrInvalidDifferentialPrivacyConfig)
+	}
+	for _, table := range config.Tables {
+		for _, column := range table.Columns {
+			if column.Lower >= column.Upper {
+				return nil, fmt.Errorf("%w: lower bound of %s.%s should be less than upper", ErrInvalidDifferentialPrivacyConfig, table.Name, column.Name)
+			}
+		}
+	}
+	return config, nil
+}
+
+type privacyBudget struct {
+	spent       float64
+	periodStart time.Time
+}
+
+// differentialPrivacyTable is config of protected table prepared for lookups by column names
+type differentialPrivacyTable struct {
+	columns         map[string]DifferentialPrivacyColumnConfig
+	groupingColumns map[string]bool
+}
+
+// DifferentialPrivacy adds calibrated Laplace noise to results of COUNT, SUM and AVG over protected tables returned
+// to configured clientIDs and accounts their privacy budgets. Other aggregates over protected tables are returned as
+// NULL. It's shared by all sessions. nil value is valid and does nothing
+type DifferentialPrivacy struct {
+	config    DifferentialPrivacyConfig
+	clientIDs map[string]bool
+	tables    map[string]*differentialPrivacyTable
+	parser    *sqlparser.Parser
+	lock      sync.Mutex
+	budgets   map[string]*privacyBudget
+	now       func() time.Time
+	// uniform returns random value from open interval (0, 1)
+	uniform func() (float64, error)
+}
+
+// NewDifferentialPrivacy returns DifferentialPrivacy which uses parser to find aggregates in queries or nil if config
+// is nil
+func NewDifferentialPrivacy(config *DifferentialPrivacyConfig, parser *sqlparser.Parser) *DifferentialPrivacy {
+	if config == nil {
+		return nil
+	}
+	clientIDs := make(map[string]bool, len(config.ClientIDs))
+	for _, clientID := range config.ClientIDs {
+		clientIDs[clientID] = true
+	}
+	tables := make(map[string]*differentialPrivacyTable, len(config.Tables))
+	for _, table := range config.Tables {
+		protectedTable := &differentialPrivacyTable{
+			columns:         make(map[string]DifferentialPrivacyColumnConfig, len(table.Columns)),
+			groupingColumns: make(map[string]bool, len(table.GroupingColumns)),
+		}
+		for _, column := range table.Columns {
+			protectedTable.columns[column.Name] = column
+		}
+		for _, column := range table.GroupingColumns {
+			protectedTable.groupingColumns[column] = true
+		}
+		tables[table.Name] = protectedTable
+	}
+	return &DifferentialPrivacy{
+		config:    *config,
+		clientIDs: clientIDs,
+		tables:    tables,
+		parser:    parser,
+		budgets:   make(map[string]*privacyBudget),
+		now:       time.Now,
+		uniform:   cryptoUniform,
+	}
+}
+
+func cryptoUniform() (float64, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	// 53 random bits fit into mantissa, half of step excludes both 0 and 1
+	return (float64(binary.BigEndian.Uint64(buf[:])>>11) + 0.5) / (1 << 53), nil
+}
+
+// laplace returns sample of Laplace distribution with zero mean and scale
+func (privacy *DifferentialPrivacy) laplace(scale float64) (float64, error) {
+	u, err := privacy.uniform()
+	if err != nil {
+		return 0, err
+	}
+	u -= 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u), nil
+	}
+	return -scale * math.Log(1-2*u), nil
+}
+
+// spend subtracts cost from budget of clientID and returns false if budget is insufficient
+func (privacy *DifferentialPrivacy) spend(clientID string, cost float64) bool {
+	privacy.lock.Lock()
+	defer privacy.lock.Unlock()
+	now := privacy.now()
+	budget, ok := privacy.budgets[clientID]
+	if !ok || (privacy.config.BudgetPeriod > 0 && now.Sub(budget.periodStart) >= privacy.config.BudgetPeriod) {
+		budget = &privacyBudget{periodStart: now}
+		privacy.budgets[clientID] = budget
+	}
+	if budget.spent+cost > privacy.config.Budget+privacyBudgetTolerance {
+		return false
+	}
+	budget.spent += cost
+	return true
+}
+
+// aggregateNoise describes noise of one column of the result. Zero sensitivity means that column is returned as NULL
+type aggregateNoise struct {
+	kind         string
+	sensitivity  float64
+	lower, upper float64
+}
+
+// protectedTables collects protected tables used in FROM by their aliases or names
+func (privacy *DifferentialPrivacy) protectedTables(tableExprs sqlparser.TableExprs, tables map[string]*differentialPrivacyTable) {
+	for _, tableExpr := range tableExprs {
+		switch expr := tableExpr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			tableName, ok := expr.Expr.(sqlparser.TableName)
+			if !ok {
+				continue
+			}
+			table, ok := privacy.tables[tableName.Name.ValueForConfig()]
+			if !ok {
+				continue
+			}
+			alias := expr.As
+			if alias.IsEmpty() {
+				alias = tableName.Name
+			}
+			tables[alias.ValueForConfig()] = table
+		case *sqlparser.JoinTableExpr:
+			privacy.protectedTables(sqlparser.TableExprs{expr.LeftExpr, expr.RightExpr}, tables)
+		case *sqlparser.ParenTableExpr:
+			privacy.protectedTables(expr.Exprs, tables)
+		}
+	}
+}
+
+func (privacy *DifferentialPrivacy) usesProtectedTable(node sqlparser.SQLNode) bool {
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if tableName, ok := node.(sqlparser.TableName); ok {
+			if _, ok := privacy.tables[tableName.Name.ValueForConfig()]; ok {
+				found = true
+			}
+		}
+		return !found, nil
+	}, node)
+	return found
+}
+
+// usesProtectedTableInSubquery returns true if protected table is used in subquery, UNION or parenthesized SELECT
+// anywhere in the statement. Rows and columns of their results are unknown, so they can't be protected with noise
+func (privacy *DifferentialPrivacy) usesProtectedTableInSubquery(statement sqlparser.Statement) bool {
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node.(type) {
+		case *sqlparser.Subquery, *sqlparser.Union, *sqlparser.ParenSelect:
+			if privacy.usesProtectedTable(node) {
+				found = true
+			}
+			return false, nil
+		}
+		return !found, nil
+	}, statement)
+	return found
+}
+
+// isGroupingKey returns true if expression is used in GROUP BY as is
+func isGroupingKey(expr sqlparser.Expr, groupBy sqlparser.GroupBy) bool {
+	value := sqlparser.String(expr)
+	for _, key := range groupBy {
+		if sqlparser.String(key) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedGroupingKey returns true if grouping key uses only columns of unprotected tables or grouping_columns of
+// protected ones. Columns without table qualifier are resolved only by grouping_columns of protected tables in the query
+func isAllowedGroupingKey(expr sqlparser.Expr, tables map[string]*differentialPrivacyTable) bool {
+	allowed := true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		colName, ok := node.(*sqlparser.ColName)
+		if !ok {
+			return allowed, nil
+		}
+		name := colName.Name.ValueForConfig()
+		if qualifier := colName.Qualifier.Name; !qualifier.IsEmpty() {
+			if table, ok := tables[qualifier.ValueForConfig()]; ok {
+				allowed = table.groupingColumns[name]
+			}
+			return allowed, nil
+		}
+		allowed = false
+		for _, table := range tables {
+			if table.groupingColumns[name] {
+				allowed = true
+				break
+			}
+		}
+		return allowed, nil
+	}, expr)
+	return allowed
+}
+
+func containsAggregate(expr sqlparser.Expr) bool {
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch aggregate := node.(type) {
+		case *sqlparser.FuncExpr:
+			if aggregate.IsAggregate() {
+				found = true
+			}
+		case *sqlparser.GroupConcatExpr:
+			found = true
+		}
+		return !found, nil
+	}, expr)
+	return found
+}
+
+// Functions which clamp argument of SUM and AVG into bounds of column
+const (
+	clampLowerFunction = "greatest"
+	clampUpperFunction = "least"
+)
+
+// clampedColumn returns column of expression least(greatest(column, lower), upper) which is used by CheckQuery
+func clampedColumn(expr sqlparser.Expr) (*sqlparser.ColName, bool) {
+	upperFunction, ok := expr.(*sqlparser.FuncExpr)
+	if !ok || upperFunction.Name.Lowered() != clampUpperFunction || len(upperFunction.Exprs) != 2 {
+		return nil, false
+	}
+	upperArgument, ok := upperFunction.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, false
+	}
+	lowerFunction, ok := upperArgument.Expr.(*sqlparser.FuncExpr)
+	if !ok || lowerFunction.Name.Lowered() != clampLowerFunction || len(lowerFunction.Exprs) != 2 {
+		return nil, false
+	}
+	lowerArgument, ok := lowerFunction.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, false
+	}
+	colName, ok := lowerArgument.Expr.(*sqlparser.ColName)
+	return colName, ok
+}
+
+// aggregatedColumn returns argument of SUM or AVG over column with configured bounds
+func aggregatedColumn(function *sqlparser.FuncExpr, tables map[string]*differentialPrivacyTable) (*sqlparser.AliasedExpr, DifferentialPrivacyColumnConfig, bool) {
+	if len(function.Exprs) != 1 {
+		return nil, DifferentialPrivacyColumnConfig{}, false
+	}
+	aliasedExpr, ok := function.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, DifferentialPrivacyColumnConfig{}, false
+	}
+	colName, ok := aliasedExpr.Expr.(*sqlparser.ColName)
+	if !ok {
+		// prepared statements are executed with arguments clamped by CheckQuery
+		if colName, ok = clampedColumn(aliasedExpr.Expr); !ok {
+			return nil, DifferentialPrivacyColumnConfig{}, false
+		}
+	}
+	name := colName.Name.ValueForConfig()
+	var column DifferentialPrivacyColumnConfig
+	if qualifier := colName.Qualifier.Name; !qualifier.IsEmpty() {
+		if table, found := tables[qualifier.ValueForConfig()]; found {
+			column, ok = table.columns[name]
+		} else {
+			ok = false
+		}
+	} else {
+		for _, table := range tables {
+			if column, ok = table.columns[name]; ok {
+				break
+			}
+		}
+	}
+	return aliasedExpr, column, ok
+}
+
+// newAggregateNoise returns noise of COUNT, SUM or AVG. SUM and AVG of columns without configured bounds aren't
+// supported because their sensitivity is unknown
+func newAggregateNoise(function *sqlparser.FuncExpr, tables map[string]*differentialPrivacyTable) aggregateNoise {
+	kind := function.Name.Lowered()
+	if kind == noisyAggregateCount {
+		return aggregateNoise{kind: kind, sensitivity: 1}
+	}
+	_, column, ok := aggregatedColumn(function, tables)
+	if !ok {
+		return aggregateNoise{}
+	}
+	noise := aggregateNoise{kind: kind, lower: column.Lower, upper: column.Upper}
+	if kind == noisyAggregateSum {
+		// one row changes sum at most by the largest absolute value of clamped values
+		noise.sensitivity = math.Max(math.Abs(column.Lower), math.Abs(column.Upper))
+	} else {
+		// conservative bound for the group of one row, result is clamped into bounds
+		noise.sensitivity = column.Upper - column.Lower
+	}
+	return noise
+}
+
+// parseQuery returns SELECT of aggregates over protected tables with their columns by aliases, nil if the query doesn't
+// use protected tables or ErrDifferentialPrivacyQueryRejected if the query can't be parsed or may return values of
+// protected tables without noise. Only aggregates and grouping keys may be selected from protected tables, grouping
+// keys may use only grouping_columns of protected tables
+func (privacy *DifferentialPrivacy) parseQuery(query string) (*sqlparser.Select, map[string]*differentialPrivacyTable, error) {
+	statement, err := privacy.parser.Parse(query)
+	if err != nil {
+		// protected tables may be used by query which isn't supported by parser
+		return nil, nil, fmt.Errorf("%w: can't parse query: %s", ErrDifferentialPrivacyQueryRejected, err)
+	}
+	if !privacy.usesProtectedTable(statement) {
+		return nil, nil, nil
+	}
+	selectStatement, ok := statement.(*sqlparser.Select)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDifferentialPrivacyQueryRejected, "statement isn't SELECT")
+	}
+	if privacy.usesProtectedTableInSubquery(selectStatement) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDifferentialPrivacyQueryRejected, "protected table is used in subquery")
+	}
+	tables := make(map[string]*differentialPrivacyTable)
+	privacy.protectedTables(selectStatement.From, tables)
+	if len(tables) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDifferentialPrivacyQueryRejected, "protected table isn't used in FROM")
+	}
+	for _, key := range selectStatement.GroupBy {
+		if !isAllowedGroupingKey(key, tables) {
+			return nil, nil, fmt.Errorf("%w: %s isn't allowed grouping key", ErrDifferentialPrivacyQueryRejected, sqlparser.String(key))
+		}
+	}
+	aggregates := 0
+	for _, selectExpr := range selectStatement.SelectExprs {
+		expr, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrDifferentialPrivacyQueryRejected, "wildcard returns rows of protected table")
+		}
+		if containsAggregate(expr.Expr) {
+			aggregates++
+		} else if !isGroupingKey(expr.Expr, selectStatement.GroupBy) {
+			return nil, nil, fmt.Errorf("%w: %s is neither aggregate nor grouping key", ErrDifferentialPrivacyQueryRejected, sqlparser.String(expr))
+		}
+	}
+	if aggregates == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDifferentialPrivacyQueryRejected, "query doesn't select aggregates")
+	}
+	return selectStatement, tables, nil
+}
+
+// clampAggregates replaces arguments of SUM and AVG over columns with configured bounds with
+// least(greatest(column, lower), upper) because noise is calibrated for values within bounds
+func clampAggregates(selectStatement *sqlparser.Select, tables map[string]*differentialPrivacyTable) {
+	for _, selectExpr := range selectStatement.SelectExprs {
+		// parseQuery allows only aliased expressions
+		function, ok := selectExpr.(*sqlparser.AliasedExpr).Expr.(*sqlparser.FuncExpr)
+		if !ok {
+			continue
+		}
+		switch function.Name.Lowered() {
+		case noisyAggregateSum, noisyAggregateAvg:
+		default:
+			continue
+		}
+		argument, column, ok := aggregatedColumn(function, tables)
+		if !ok {
+			continue
+		}
+		lower := &sqlparser.FuncExpr{
+			Name: sqlparser.NewColIdent(clampLowerFunction),
+			Exprs: sqlparser.SelectExprs{
+				&sqlparser.AliasedExpr{Expr: argument.Expr},
+				&sqlparser.AliasedExpr{Expr: boundValue(column.Lower)},
+			},
+		}
+		argument.Expr = &sqlparser.FuncExpr{
+			Name: sqlparser.NewColIdent(clampUpperFunction),
+			Exprs: sqlparser.SelectExprs{
+				&sqlparser.AliasedExpr{Expr: lower},
+				&sqlparser.AliasedExpr{Expr: boundValue(column.Upper)},
+			},
+		}
+	}
+}
+
+// boundValue returns literal of column bound
+func boundValue(value float64) sqlparser.Expr {
+	literal := sqlparser.NewFloatVal([]byte(strconv.FormatFloat(math.Abs(value), 'f', -1, 64)))
+	if value < 0 {
+		return &sqlparser.UnaryExpr{Operator: sqlparser.UMinusStr, Expr: literal}
+	}
+	return literal
+}
+
+// CheckQuery returns query which should be sent to the database instead of original one or
+// ErrDifferentialPrivacyQueryRejected if clientID from ctx receives noisy results and the query shouldn't be sent to
+// the database because it may return values of protected tables without noise. Arguments of SUM and AVG in returned
+// query are clamped into configured bounds
+func (privacy *DifferentialPrivacy) CheckQuery(ctx context.Context, query string) (string, error) {
+	if privacy == nil {
+		return query, nil
+	}
+	clientID := string(AccessContextFromContext(ctx).GetClientID())
+	if !privacy.clientIDs[clientID] {
+		return query, nil
+	}
+	selectStatement, tables, err := privacy.parseQuery(query)
+	if err != nil {
+		logging.GetLoggerFromContext(ctx).WithError(err).WithFields(logrus.Fields{
+			logging.FieldKeyEventCode: logging.EventCodeDifferentialPrivacyRejected,
+			"client_id":               clientID,
+		}).Warningln("Query of protected tables is rejected")
+		return "", err
+	}
+	if selectStatement == nil {
+		return query, nil
+	}
+	clampAggregates(selectStatement, tables)
+	return sqlparser.String(selectStatement), nil
+}
+
+// NewRecord returns noise of aggregates of the query sent by clientID from ctx or nil if the query doesn't use
+// protected tables. Budget is spent when the query is sent to the database. Queries which should be rejected by
+// CheckQuery get record which returns all values as NULL
+func (privacy *DifferentialPrivacy) NewRecord(ctx context.Context, query string) *DifferentialPrivacyRecord {
+	if privacy == nil {
+		return nil
+	}
+	clientID := string(AccessContextFromContext(ctx).GetClientID())
+	if !privacy.clientIDs[clientID] {
+		return nil
+	}
+	selectStatement, tables, err := privacy.parseQuery(query)
+	if err != nil {
+		return &DifferentialPrivacyRecord{privacy: privacy, suppressAll: true}
+	}
+	if selectStatement == nil {
+		return nil
+	}
+	record := &DifferentialPrivacyRecord{privacy: privacy, columns: make(map[int]aggregateNoise)}
+	noisyColumns := 0
+	for i, selectExpr := range selectStatement.SelectExprs {
+		// parseQuery allows only aliased expressions
+		expr := selectExpr.(*sqlparser.AliasedExpr)
+		function, ok := expr.Expr.(*sqlparser.FuncExpr)
+		if ok {
+			switch function.Name.Lowered() {
+			case noisyAggregateCount, noisyAggregateSum, noisyAggregateAvg:
+				noise := newAggregateNoise(function, tables)
+				if noise.sensitivity > 0 {
+					noisyColumns++
+				}
+				record.columns[i] = noise
+				continue
+			}
+		}
+		// other aggregates and expressions over aggregates are returned as NULL, grouping keys as is
+		if containsAggregate(expr.Expr) {
+			record.columns[i] = aggregateNoise{}
+		}
+	}
+	if !privacy.spend(clientID, privacy.config.Epsilon*float64(noisyColumns)) {
+		record.suppressAll = true
+		logging.GetLoggerFromContext(ctx).WithFields(logrus.Fields{
+			logging.FieldKeyEventCode: logging.EventCodePrivacyBudgetExhausted,
+			"client_id":               clientID,
+			"budget":                  privacy.config.Budget,
+		}).Warningln("Privacy budget is exhausted, aggregates are returned as NULL")
+	}
+	return record
+}
+
+// DifferentialPrivacyRecord adds noise to aggregates in rows of response of one query. nil value is valid and does
+// nothing
+type DifferentialPrivacyRecord struct {
+	privacy     *DifferentialPrivacy
+	columns     map[int]aggregateNoise
+	suppressAll bool
+}
+
+// OnColumn returns value of column with index with added noise and false if the value should be replaced with NULL.
+// Only COUNT in binary format is supported, other aggregates in binary format are returned as NULL
+func (record *DifferentialPrivacyRecord) OnColumn(index int, data []byte, binaryFormat bool) ([]byte, bool) {
+	if record == nil {
+		return data, true
+	}
+	noise, ok := record.columns[index]
+	if !ok {
+		return data, !record.suppressAll
+	}
+	if record.suppressAll || noise.sensitivity == 0 {
+		return nil, false
+	}
+	delta, err := record.privacy.laplace(noise.sensitivity / record.privacy.config.Epsilon)
+	if err != nil {
+		return nil, false
+	}
+	if binaryFormat {
+		if noise.kind != noisyAggregateCount || len(data) != 8 {
+			return nil, false
+		}
+		count := addCountNoise(int64(binary.BigEndian.Uint64(data)), delta)
+		newData := make([]byte, 8)
+		binary.BigEndian.PutUint64(newData, uint64(count))
+		return newData, true
+	}
+	if noise.kind == noisyAggregateCount {
+		count, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		return strconv.AppendInt(nil, addCountNoise(count, delta), 10), true
+	}
+	value, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return nil, false
+	}
+	value += delta
+	if noise.kind == noisyAggregateAvg {
+		value = math.Min(math.Max(value, noise.lower), noise.upper)
+	}
+	// sum of integers stays integer
+	if _, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return strconv.AppendInt(nil, int64(math.Round(value)), 10), true
+	}
+	return strconv.AppendFloat(nil, value, 'f', -1, 64), true
+}
+
+func addCountNoise(count int64, delta float64) int64 {
+	count += int64(math.Round(delta))
+	if count < 0 {
+		return 0
+	}
+	return count
+}
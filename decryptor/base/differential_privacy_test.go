@@ -0,0 +1,245 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+const testDifferentialPrivacyConfig = `
+client_ids: [analytics]
+epsilon: 0.5
+budget: 1.5
+budget_period: 24h
+tables:
+  - name: users
+    columns:
+      - name: age
+        lower: 0
+        upper: 100
+      - name: score
+        lower: -1
+        upper: 1
+    grouping_columns: [city]
+`
+
+func TestParseDifferentialPrivacyConfig(t *testing.T) {
+	config, err := ParseDifferentialPrivacyConfig([]byte(testDifferentialPrivacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BudgetPeriod != time.Hour*24 || len(config.Tables) != 1 || len(config.Tables[0].Columns) != 2 {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+	invalidConfigs := []string{
+		"epsilon: 1\nbudget: 1\ntables: [{name: users}]\n",
+		"client_ids: [analytics]\nepsilon: 1\nbudget: 1\n",
+		"client_ids: [analytics]\nbudget: 1\ntables: [{name: users}]\n",
+		"client_ids: [analytics]\nepsilon: 1\nbudget: 0.5\ntables: [{name: users}]\n",
+		"client_ids: [analytics]\nepsilon: 1\nbudget: 1\nbudget_period: -1h\ntables: [{name: users}]\n",
+		"client_ids: [analytics]\nepsilon: 1\nbudget: 1\ntables: [{name: users, columns: [{name: age, lower: 10, upper: 10}]}]\n",
+	}
+	for i, data := range invalidConfigs {
+		if _, err := ParseDifferentialPrivacyConfig([]byte(data)); !errors.Is(err, ErrInvalidDifferentialPrivacyConfig) {
+			t.Fatalf("[%d] Expected ErrInvalidDifferentialPrivacyConfig, took %v", i, err)
+		}
+	}
+}
+
+func TestDifferentialPrivacyNil(t *testing.T) {
+	var privacy *DifferentialPrivacy
+	record := privacy.NewRecord(context.Background(), "select count(*) from users")
+	if record != nil {
+		t.Fatal("Expected nil record")
+	}
+	if data, ok := record.OnColumn(0, []byte("10"), false); !ok || string(data) != "10" {
+		t.Fatalf("nil record shouldn't change data, took %s, %v", data, ok)
+	}
+}
+
+func TestDifferentialPrivacyRecord(t *testing.T) {
+	config, err := ParseDifferentialPrivacyConfig([]byte(testDifferentialPrivacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	privacy := NewDifferentialPrivacy(config, sqlparser.New(sqlparser.ModeStrict))
+	// noise with u=0.75 equals to scale*ln(2)
+	privacy.uniform = func() (float64, error) { return 0.75, nil }
+	now := time.Now()
+	privacy.now = func() time.Time { return now }
+	analyticsCtx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("analytics"))))
+	appCtx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("app"))))
+
+	if record := privacy.NewRecord(appCtx, "select count(*) from users"); record != nil {
+		t.Fatal("Queries of other clientIDs shouldn't get noise")
+	}
+	if record := privacy.NewRecord(analyticsCtx, "select count(*) from orders"); record != nil {
+		t.Fatal("Queries over other tables shouldn't get noise")
+	}
+	// rows of queries which should be rejected are returned as NULL
+	if record := privacy.NewRecord(analyticsCtx, "select age from users"); record == nil {
+		t.Fatal("Queries without aggregates shouldn't return values")
+	} else if _, ok := record.OnColumn(0, []byte("42"), false); ok {
+		t.Fatal("Expected NULL for query without aggregates")
+	}
+
+	record := privacy.NewRecord(analyticsCtx, "select u.city, count(*), avg(u.age), max(u.age), sum(name), sum(score) from users as u group by u.city")
+	if record == nil {
+		t.Fatal("Expected record for aggregate query")
+	}
+	testcases := []struct {
+		index    int
+		data     string
+		expected string
+		ok       bool
+	}{
+		// not aggregate
+		{0, "London", "London", true},
+		// 10 + round(1/0.5*ln(2))
+		{1, "10", "11", true},
+		// avg is clamped into bounds
+		{2, "40.5", "100", true},
+		// max is not supported
+		{3, "80", "", false},
+		// sum over column without bounds
+		{4, "10", "", false},
+		// sum of integers stays integer
+		{5, "3", "4", true},
+		{5, "2.5", strconv.FormatFloat(2.5+1/0.5*math.Ln2, 'f', -1, 64), true},
+	}
+	for i, tcase := range testcases {
+		data, ok := record.OnColumn(tcase.index, []byte(tcase.data), false)
+		if ok != tcase.ok || string(data) != tcase.expected {
+			t.Fatalf("[%d] Expected %s, %v, took %s, %v", i, tcase.expected, tcase.ok, data, ok)
+		}
+	}
+	binaryCount := make([]byte, 8)
+	binary.BigEndian.PutUint64(binaryCount, 10)
+	data, ok := record.OnColumn(1, binaryCount, true)
+	if !ok || binary.BigEndian.Uint64(data) != 11 {
+		t.Fatalf("Unexpected binary count %v, %v", data, ok)
+	}
+	if _, ok = record.OnColumn(2, make([]byte, 8), true); ok {
+		t.Fatal("Binary avg should be returned as NULL")
+	}
+
+	// previous query spent whole budget with three noisy aggregates
+	record = privacy.NewRecord(analyticsCtx, "select count(*) from users")
+	if data, ok := record.OnColumn(0, []byte("10"), false); ok {
+		t.Fatalf("Expected NULL after exhausted budget, took %s", data)
+	}
+	now = now.Add(time.Hour * 24)
+	record = privacy.NewRecord(analyticsCtx, "select count(*) from users")
+	if data, ok := record.OnColumn(0, []byte("10"), false); !ok || string(data) != "11" {
+		t.Fatalf("Expected noisy count after reset of budget, took %s, %v", data, ok)
+	}
+}
+
+func TestDifferentialPrivacyCheckQuery(t *testing.T) {
+	config, err := ParseDifferentialPrivacyConfig([]byte(testDifferentialPrivacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	privacy := NewDifferentialPrivacy(config, sqlparser.New(sqlparser.ModeStrict))
+	analyticsCtx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("analytics"))))
+	appCtx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("app"))))
+
+	allowed := []struct {
+		query    string
+		expected string
+	}{
+		{"select count(*) from users", "select count(*) from users"},
+		{"select u.city, count(*), avg(u.age) from users as u group by u.city",
+			"select u.city, count(*), avg(least(greatest(u.age, 0), 100)) from users as u group by u.city"},
+		{"select city, sum(score), sum(name) from users group by city",
+			"select city, sum(least(greatest(score, -1), 1)), sum(name) from users group by city"},
+		{"select o.status, count(*) from users as u join orders as o on u.id = o.user_id group by o.status, u.city",
+			"select o.`status`, count(*) from users as u join orders as o on u.id = o.user_id group by o.`status`, u.city"},
+		{"select count(*) from users where city in (select name from cities)",
+			"select count(*) from users where city in (select name from cities)"},
+		{"select name from orders", "select name from orders"},
+		{"select * from orders where id in (select id from cities)", "select * from orders where id in (select id from cities)"},
+	}
+	for _, tcase := range allowed {
+		query, err := privacy.CheckQuery(analyticsCtx, tcase.query)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %s", tcase.query, err)
+		}
+		if query != tcase.expected {
+			t.Fatalf("[%s] Expected %s, took %s", tcase.query, tcase.expected, query)
+		}
+	}
+	// prepared statements are executed with clamped query
+	privacy.uniform = func() (float64, error) { return 0.75, nil }
+	record := privacy.NewRecord(analyticsCtx, "select avg(least(greatest(u.age, 0), 100)) from users as u")
+	if data, ok := record.OnColumn(0, []byte("40.5"), false); !ok || string(data) != "100" {
+		t.Fatalf("Expected noisy avg of clamped query, took %s, %v", data, ok)
+	}
+	rejected := []string{
+		// raw rows
+		"select age from users",
+		"select * from users",
+		"select age, count(*) from users",
+		"select city from users group by city",
+		// grouping keys which aren't grouping_columns return values without noise
+		"select ssn, count(*) from users group by ssn",
+		"select u.ssn, count(*) from users as u group by u.ssn",
+		"select count(*) from users group by ssn",
+		"select substr(ssn, 1, 3), count(*) from users group by substr(ssn, 1, 3)",
+		"select o.ssn, count(*) from users as u join orders as o on u.id = o.user_id group by o.ssn, u.ssn",
+		"update users set age = 1",
+		"delete from users",
+		// protected tables in UNION, parenthesized SELECT and subqueries
+		"select count(*) from users union select age from users",
+		"select count(*) from orders union select age from users",
+		"(select age from users)",
+		"select count(*) from (select age from users) as t",
+		"select count(*) from orders where id in (select age from users)",
+		"select (select age from users limit 1), count(*) from orders",
+		"select count(*) from orders where exists (select 1 from users where age > 50)",
+		"select count(*) from users where age > (select max(age) from users)",
+		// unparseable queries, including CTE which isn't supported by parser
+		"with t as (select age from users) select age from t",
+		"select age from users; select 1",
+		"not a query",
+	}
+	for _, query := range rejected {
+		if _, err := privacy.CheckQuery(analyticsCtx, query); !errors.Is(err, ErrDifferentialPrivacyQueryRejected) {
+			t.Fatalf("[%s] Expected ErrDifferentialPrivacyQueryRejected, took %v", query, err)
+		}
+		if checked, err := privacy.CheckQuery(appCtx, query); err != nil || checked != query {
+			t.Fatalf("[%s] Queries of other clientIDs shouldn't be changed, took %s, %v", query, checked, err)
+		}
+		record := privacy.NewRecord(analyticsCtx, query)
+		if _, ok := record.OnColumn(0, []byte("42"), false); ok {
+			t.Fatalf("[%s] Expected NULL for rejected query", query)
+		}
+	}
+
+	var disabled *DifferentialPrivacy
+	if _, err := disabled.CheckQuery(analyticsCtx, "select age from users"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// Errors returned by heartbeat configuration
+var (
+	ErrInvalidHeartbeatInterval = errors.New("heartbeat interval should be positive")
+	ErrInvalidHeartbeatTimeout  = errors.New("heartbeat timeout should be positive")
+)
+
+// Reasons of reaped connections used as metric labels
+const (
+	HeartbeatReasonTimeout    = "timeout"
+	HeartbeatReasonProbeError = "probe_error"
+)
+
+// HeartbeatSettings configures liveness probes of idle connections to the database
+type HeartbeatSettings struct {
+	// Interval of connection inactivity after which probe is sent
+	Interval time.Duration
+	// Timeout of waiting for probe response after which connection considered dead
+	Timeout time.Duration
+}
+
+// NewHeartbeatSettings returns validated HeartbeatSettings
+func NewHeartbeatSettings(interval, timeout time.Duration) (*HeartbeatSettings, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidHeartbeatInterval
+	}
+	if timeout <= 0 {
+		return nil, ErrInvalidHeartbeatTimeout
+	}
+	return &HeartbeatSettings{Interval: interval, Timeout: timeout}, nil
+}
+
+// HeartbeatProbe sends lightweight request to the database which response should be passed to
+// ConnectionHeartbeat.OnIdle and not forwarded to the client
+type HeartbeatProbe func() error
+
+// ConnectionHeartbeat sends probes over connection to the database when it is idle and closes connection if the
+// database doesn't respond in time, so dead connections are cleaned before client's query is sent to them.
+// Proxy should notify it about client's requests with OnClientRequest and about finished responses with OnIdle.
+// nil value is valid and does nothing
+type ConnectionHeartbeat struct {
+	settings        *HeartbeatSettings
+	dbType          string
+	probe           HeartbeatProbe
+	closeConnection func()
+	logger          *log.Entry
+
+	lock         sync.Mutex
+	idle         bool
+	idleSince    time.Time
+	probePending bool
+	probeSentAt  time.Time
+	reaped       bool
+}
+
+// NewConnectionHeartbeat returns ConnectionHeartbeat for connection to database of dbType or nil if settings is nil.
+// probe sends request to the database, closeConnection closes dead connection
+func NewConnectionHeartbeat(settings *HeartbeatSettings, dbType string, probe HeartbeatProbe, closeConnection func(), logger *log.Entry) *ConnectionHeartbeat {
+	if settings == nil {
+		return nil
+	}
+	return &ConnectionHeartbeat{settings: settings, dbType: dbType, probe: probe, closeConnection: closeConnection, logger: logger}
+}
+
+// Run checks connection until ctx is done or connection is reaped. Should be called as goroutine
+func (h *ConnectionHeartbeat) Run(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	// check more often than interval to not extend it for up to one more interval
+	ticker := time.NewTicker(h.settings.Interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !h.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// check sends probe if connection is idle long enough and reaps connection if probe is not answered in time.
+// Returns false if connection was reaped
+func (h *ConnectionHeartbeat) check(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.reaped {
+		return false
+	}
+	if h.probePending {
+		if now.Sub(h.probeSentAt) >= h.settings.Timeout {
+			h.reap(HeartbeatReasonTimeout, nil)
+			return false
+		}
+		return true
+	}
+	if !h.idle || now.Sub(h.idleSince) < h.settings.Interval {
+		return true
+	}
+	if err := h.probe(); err != nil {
+		h.reap(HeartbeatReasonProbeError, err)
+		return false
+	}
+	h.probePending = true
+	h.probeSentAt = now
+	return true
+}
+
+// reap closes connection, should be called with acquired lock
+func (h *ConnectionHeartbeat) reap(reason string, err error) {
+	h.reaped = true
+	BackendConnectionsReapedCounter.WithLabelValues(h.dbType, reason).Inc()
+	h.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeBackendConnectionReaped).
+		WithField("reason", reason).
+		WithField("timeout", h.settings.Timeout.String()).
+		Warningln("Connection to database didn't respond to heartbeat probe, close it")
+	h.closeConnection()
+}
+
+// OnClientRequest marks connection as busy and calls send which forwards client's request to the database. Probes are
+// not sent concurrently with send
+func (h *ConnectionHeartbeat) OnClientRequest(send func() error) error {
+	if h == nil {
+		return send()
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.idle = false
+	return send()
+}
+
+// OnIdle marks connection as idle after the database finished response and returns true if it was response to the
+// probe which should not be forwarded to the client
+func (h *ConnectionHeartbeat) OnIdle() bool {
+	if h == nil {
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.probePending {
+		// responses come in order of requests, so the first one after the probe is the probe's response.
+		// Client's request sent after the probe keeps connection busy
+		h.probePending = false
+		if h.idle {
+			h.idleSince = time.Now()
+		}
+		return true
+	}
+	h.idle = true
+	h.idleSince = time.Now()
+	return false
+}
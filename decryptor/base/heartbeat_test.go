@@ -0,0 +1,139 @@
+package base
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNewHeartbeatSettings(t *testing.T) {
+	testcases := []struct {
+		interval time.Duration
+		timeout  time.Duration
+		err      error
+	}{
+		{time.Second, time.Second, nil},
+		{0, time.Second, ErrInvalidHeartbeatInterval},
+		{-time.Second, time.Second, ErrInvalidHeartbeatInterval},
+		{time.Second, 0, ErrInvalidHeartbeatTimeout},
+	}
+	for i, tcase := range testcases {
+		_, err := NewHeartbeatSettings(tcase.interval, tcase.timeout)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestConnectionHeartbeatNil(t *testing.T) {
+	heartbeat := NewConnectionHeartbeat(nil, "postgresql", nil, nil, nil)
+	if heartbeat != nil {
+		t.Fatal("Expected nil heartbeat without settings")
+	}
+	sent := false
+	if err := heartbeat.OnClientRequest(func() error { sent = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !sent {
+		t.Fatal("Request wasn't sent")
+	}
+	if heartbeat.OnIdle() {
+		t.Fatal("Nil heartbeat shouldn't skip responses")
+	}
+}
+
+// testHeartbeat returns heartbeat with counters of sent probes and closed connections
+func testHeartbeat(t *testing.T, probeErr error) (*ConnectionHeartbeat, *int, *int) {
+	RegisterDbProcessingMetrics()
+	settings, err := NewHeartbeatSettings(time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probes, closed := 0, 0
+	probe := func() error {
+		probes++
+		return probeErr
+	}
+	heartbeat := NewConnectionHeartbeat(settings, "postgresql", probe, func() { closed++ }, log.NewEntry(log.New()))
+	return heartbeat, &probes, &closed
+}
+
+func TestConnectionHeartbeatProbeResponse(t *testing.T) {
+	heartbeat, probes, closed := testHeartbeat(t, nil)
+	if heartbeat.OnIdle() {
+		t.Fatal("Response to client's request shouldn't be skipped")
+	}
+	now := time.Now()
+	if !heartbeat.check(now) || *probes != 0 {
+		t.Fatal("Probe sent before interval of inactivity")
+	}
+	now = now.Add(time.Minute)
+	if !heartbeat.check(now) || *probes != 1 {
+		t.Fatal("Expected probe after interval of inactivity")
+	}
+	// probe isn't repeated until response
+	if !heartbeat.check(now.Add(time.Millisecond)) || *probes != 1 {
+		t.Fatal("Unexpected repeated probe")
+	}
+	if !heartbeat.OnIdle() {
+		t.Fatal("Response to probe should be skipped")
+	}
+	if heartbeat.OnIdle() {
+		t.Fatal("Only one response to probe should be skipped")
+	}
+	if *closed != 0 {
+		t.Fatal("Alive connection was closed")
+	}
+}
+
+func TestConnectionHeartbeatClientRequestAfterProbe(t *testing.T) {
+	heartbeat, probes, _ := testHeartbeat(t, nil)
+	heartbeat.OnIdle()
+	if !heartbeat.check(time.Now().Add(time.Minute)) || *probes != 1 {
+		t.Fatal("Expected probe after interval of inactivity")
+	}
+	if err := heartbeat.OnClientRequest(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// first response belongs to the probe, the second one to the client's request
+	if !heartbeat.OnIdle() {
+		t.Fatal("Response to probe should be skipped")
+	}
+	if heartbeat.OnIdle() {
+		t.Fatal("Response to client's request shouldn't be skipped")
+	}
+	// connection is busy until the response to the client's request
+	heartbeat, probes, _ = testHeartbeat(t, nil)
+	if err := heartbeat.OnClientRequest(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !heartbeat.check(time.Now().Add(time.Hour)) || *probes != 0 {
+		t.Fatal("Probe sent to busy connection")
+	}
+}
+
+func TestConnectionHeartbeatReap(t *testing.T) {
+	heartbeat, _, closed := testHeartbeat(t, nil)
+	heartbeat.OnIdle()
+	now := time.Now().Add(time.Minute)
+	if !heartbeat.check(now) {
+		t.Fatal("Connection reaped before timeout")
+	}
+	if heartbeat.check(now.Add(time.Second)) {
+		t.Fatal("Expected reaped connection after timeout")
+	}
+	if *closed != 1 {
+		t.Fatalf("Expected closed connection, took %d closes", *closed)
+	}
+	if heartbeat.check(now.Add(time.Hour)) || *closed != 1 {
+		t.Fatal("Connection shouldn't be closed twice")
+	}
+
+	heartbeat, _, closed = testHeartbeat(t, errors.New("broken pipe"))
+	heartbeat.OnIdle()
+	if heartbeat.check(time.Now().Add(time.Minute)) || *closed != 1 {
+		t.Fatal("Expected reaped connection after failed probe")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// DegradedMode defines how AcraServer processes data of queries which exceeded latency budget
+type DegradedMode string
+
+// Supported degraded modes
+const (
+	// DegradedModeCiphertext returns encrypted data as is without decryption
+	DegradedModeCiphertext DegradedMode = "ciphertext"
+	// DegradedModeMasked returns masking pattern for columns with masking and encrypted data as is for others
+	DegradedModeMasked DegradedMode = "masked"
+)
+
+// Errors returned by latency budget configuration
+var (
+	ErrInvalidDegradedMode        = errors.New("invalid degraded mode")
+	ErrInvalidLatencyBudget       = errors.New("latency budget should be positive")
+	ErrInvalidLatencyBudgetColumn = errors.New("column decryption cost should be positive")
+)
+
+// ParseDegradedMode returns DegradedMode from string or ErrInvalidDegradedMode
+func ParseDegradedMode(value string) (DegradedMode, error) {
+	switch mode := DegradedMode(value); mode {
+	case DegradedModeCiphertext, DegradedModeMasked:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidDegradedMode, value)
+}
+
+// LatencyBudget limits estimated time of decryption of one query response. Estimation is a sum of ColumnCost
+// for each processed column of each row
+type LatencyBudget struct {
+	Budget     time.Duration
+	ColumnCost time.Duration
+	Mode       DegradedMode
+}
+
+// NewLatencyBudget returns validated LatencyBudget
+func NewLatencyBudget(budget, columnCost time.Duration, mode DegradedMode) (*LatencyBudget, error) {
+	if budget <= 0 {
+		return nil, ErrInvalidLatencyBudget
+	}
+	if columnCost <= 0 {
+		return nil, ErrInvalidLatencyBudgetColumn
+	}
+	if _, err := ParseDegradedMode(string(mode)); err != nil {
+		return nil, err
+	}
+	return &LatencyBudget{Budget: budget, ColumnCost: columnCost, Mode: mode}, nil
+}
+
+// QueryLatencyBudget tracks estimated decryption time of current query response and switches the rest of response
+// to degraded mode once the budget is exceeded. Each connection uses own instance, it is not safe for concurrent use.
+// nil value is valid and never degrades
+type QueryLatencyBudget struct {
+	budget   *LatencyBudget
+	dbType   string
+	rows     int
+	estimate time.Duration
+	degraded bool
+}
+
+// NewQueryLatencyBudget returns QueryLatencyBudget for connection to database of dbType or nil if budget is nil
+func NewQueryLatencyBudget(budget *LatencyBudget, dbType string) *QueryLatencyBudget {
+	if budget == nil {
+		return nil
+	}
+	return &QueryLatencyBudget{budget: budget, dbType: dbType}
+}
+
+// Reset starts tracking of new query response
+func (q *QueryLatencyBudget) Reset() {
+	if q == nil {
+		return
+	}
+	q.rows = 0
+	q.estimate = 0
+	q.degraded = false
+}
+
+// IsDegraded returns true if current query response switched to degraded mode
+func (q *QueryLatencyBudget) IsDegraded() bool {
+	return q != nil && q.degraded
+}
+
+// OnRow accounts next row with columnCount columns and returns ctx marked with degraded mode if decryption of the row
+// exceeds the budget of query. Alert is logged once per query when it switches to degraded mode
+func (q *QueryLatencyBudget) OnRow(ctx context.Context, columnCount int) context.Context {
+	if q == nil {
+		return ctx
+	}
+	q.rows++
+	if !q.degraded {
+		rowCost := time.Duration(columnCount) * q.budget.ColumnCost
+		if q.estimate+rowCost <= q.budget.Budget {
+			q.estimate += rowCost
+			return ctx
+		}
+		q.degraded = true
+		LatencyBudgetExceededCounter.WithLabelValues(q.dbType, string(q.budget.Mode)).Inc()
+		logging.GetLoggerFromContext(ctx).WithField(logging.FieldKeyEventCode, logging.EventCodeLatencyBudgetExceeded).
+			WithField("budget", q.budget.Budget.String()).
+			WithField("estimate", (q.estimate+rowCost).String()).
+			WithField("row_index", q.rows-1).
+			WithField("degraded_mode", q.budget.Mode).
+			Warningln("Decryption latency budget exceeded, switch rest of query response to degraded mode")
+	}
+	return SetDegradedModeToContext(ctx, q.budget.Mode)
+}
+
+type degradedModeCtxKey struct{}
+
+// SetDegradedModeToContext marks ctx to process data in degraded mode without decryption
+func SetDegradedModeToContext(ctx context.Context, mode DegradedMode) context.Context {
+	return context.WithValue(ctx, degradedModeCtxKey{}, mode)
+}
+
+// DegradedModeFromContext returns degraded mode and true if ctx was marked with SetDegradedModeToContext
+func DegradedModeFromContext(ctx context.Context) (DegradedMode, bool) {
+	mode, ok := ctx.Value(degradedModeCtxKey{}).(DegradedMode)
+	return mode, ok
+}
@@ -0,0 +1,95 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDegradedMode(t *testing.T) {
+	for _, value := range []string{"ciphertext", "masked"} {
+		mode, err := ParseDegradedMode(value)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", value, err)
+		}
+		if string(mode) != value {
+			t.Fatalf("Expected %s, took %s", value, mode)
+		}
+	}
+	for _, value := range []string{"", "plaintext", "MASKED"} {
+		if _, err := ParseDegradedMode(value); !errors.Is(err, ErrInvalidDegradedMode) {
+			t.Fatalf("Expected ErrInvalidDegradedMode for '%s', took %v", value, err)
+		}
+	}
+}
+
+func TestNewLatencyBudget(t *testing.T) {
+	testcases := []struct {
+		budget     time.Duration
+		columnCost time.Duration
+		mode       DegradedMode
+		err        error
+	}{
+		{time.Millisecond, time.Microsecond, DegradedModeCiphertext, nil},
+		{time.Millisecond, time.Microsecond, DegradedModeMasked, nil},
+		{0, time.Microsecond, DegradedModeMasked, ErrInvalidLatencyBudget},
+		{time.Millisecond, 0, DegradedModeMasked, ErrInvalidLatencyBudgetColumn},
+		{time.Millisecond, time.Microsecond, "unknown", ErrInvalidDegradedMode},
+	}
+	for i, tcase := range testcases {
+		_, err := NewLatencyBudget(tcase.budget, tcase.columnCost, tcase.mode)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestQueryLatencyBudget(t *testing.T) {
+	// budget allows to decrypt 10 columns
+	budget, err := NewLatencyBudget(10*time.Microsecond, time.Microsecond, DegradedModeMasked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryBudget := NewQueryLatencyBudget(budget, DecryptionDBPostgresql)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		for row := 0; row < 3; row++ {
+			if _, ok := DegradedModeFromContext(queryBudget.OnRow(ctx, 3)); ok {
+				t.Fatalf("Unexpected degraded mode for row %d", row)
+			}
+		}
+		// 4th row exceeds budget and all next rows processed in degraded mode
+		for row := 3; row < 5; row++ {
+			mode, ok := DegradedModeFromContext(queryBudget.OnRow(ctx, 3))
+			if !ok || mode != DegradedModeMasked {
+				t.Fatalf("Expected degraded mode for row %d", row)
+			}
+		}
+		if !queryBudget.IsDegraded() {
+			t.Fatal("Expected degraded query")
+		}
+		// next query starts with full budget
+		queryBudget.Reset()
+		if queryBudget.IsDegraded() {
+			t.Fatal("Unexpected degraded query after reset")
+		}
+	}
+}
+
+func TestNilQueryLatencyBudget(t *testing.T) {
+	queryBudget := NewQueryLatencyBudget(nil, DecryptionDBMysql)
+	if queryBudget != nil {
+		t.Fatal("Expected nil budget")
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := DegradedModeFromContext(queryBudget.OnRow(context.Background(), 1000)); ok {
+			t.Fatal("Unexpected degraded mode without budget")
+		}
+	}
+	queryBudget.Reset()
+	if queryBudget.IsDegraded() {
+		t.Fatal("Unexpected degraded query without budget")
+	}
+}
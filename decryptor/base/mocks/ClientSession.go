@@ -17,6 +17,22 @@ type ClientSession struct {
 	mock.Mock
 }
 
+// ClientAddress provides a mock function with given fields:
+func (_m *ClientSession) ClientAddress() net.Addr {
+	ret := _m.Called()
+
+	var r0 net.Addr
+	if rf, ok := ret.Get(0).(func() net.Addr); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(net.Addr)
+		}
+	}
+
+	return r0
+}
+
 // ClientConnection provides a mock function with given fields:
 func (_m *ClientSession) ClientConnection() net.Conn {
 	ret := _m.Called()
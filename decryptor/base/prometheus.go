@@ -2,6 +2,7 @@ package base
 
 import (
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -19,6 +20,27 @@ const (
 	LabelTypeAcraStructSearch = "acrastruct_searchable"
 
 	LabelTokenType = "token_type"
+
+	LabelDegradedMode = "degraded_mode"
+
+	LabelHeartbeatReason = "reason"
+
+	LabelSessionTimeoutReason = "reason"
+
+	LabelQueryThreshold = "threshold"
+
+	LabelStage = "stage"
+
+	LabelFormatVersion = "version"
+)
+
+// Stages of processing of client's requests and database's responses
+const (
+	StagePacketParse      = "packet_parse"
+	StageCensor           = "censor"
+	StageQueryRewrite     = "query_rewrite"
+	StageColumnDecryption = "column_decryption"
+	StageNetworkWrite     = "network_write"
 )
 
 // Labels and values about db type in processing
@@ -54,6 +76,13 @@ var (
 			Help: "number of decryptions AcraStruct/AcraBlock",
 		}, []string{LabelStatus, LabelType})
 
+	// UnsupportedContainerFormatCounter collect count of refused containers with format version newer than accepted
+	UnsupportedContainerFormatCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acra_unsupported_container_format_total",
+			Help: "number of refused AcraBlocks with unsupported format version",
+		}, []string{LabelType, LabelFormatVersion})
+
 	// AcraEncryptionCounter collect encryptions count success/failed for type acrablock/acrastruct
 	AcraEncryptionCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -88,6 +117,41 @@ var (
 		Help:    "Time of response processing",
 		Buckets: []float64{0.000001, 0.00001, 0.00002, 0.00003, 0.00004, 0.00005, 0.00006, 0.00007, 0.00008, 0.00009, 0.0001, 0.0005, 0.001, 0.005, 0.01, 1, 3, 5, 10},
 	}, []string{DecryptionDBLabel})
+
+	// PipelineStageTimeHistogram collect metrics about time of separate stages of request and response processing
+	PipelineStageTimeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "acraserver_pipeline_stage_seconds",
+		Help:    "Time of processing stages of requests and responses",
+		Buckets: []float64{0.000001, 0.00001, 0.00002, 0.00003, 0.00004, 0.00005, 0.00006, 0.00007, 0.00008, 0.00009, 0.0001, 0.0005, 0.001, 0.005, 0.01, 1, 3, 5, 10},
+	}, []string{DecryptionDBLabel, LabelStage})
+
+	// LatencyBudgetExceededCounter collect count of query responses switched to degraded mode due to latency budget
+	LatencyBudgetExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_latency_budget_exceeded_total",
+			Help: "number of query responses which exceeded decryption latency budget",
+		}, []string{DecryptionDBLabel, LabelDegradedMode})
+
+	// BackendConnectionsReapedCounter collect count of connections to the database closed due to failed heartbeat probes
+	BackendConnectionsReapedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_backend_connections_reaped_total",
+			Help: "number of connections to the database closed because they didn't respond to heartbeat probes",
+		}, []string{DecryptionDBLabel, LabelHeartbeatReason})
+
+	// SessionsExpiredCounter collect count of client sessions closed due to idle timeout or maximum lifetime
+	SessionsExpiredCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_sessions_expired_total",
+			Help: "number of client sessions closed due to idle timeout or maximum session lifetime",
+		}, []string{DecryptionDBLabel, LabelSessionTimeoutReason})
+
+	// QueryThresholdExceededCounter collect count of queries which exceeded thresholds of latency or response size
+	QueryThresholdExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_query_threshold_exceeded_total",
+			Help: "number of queries which exceeded thresholds of latency or size of response",
+		}, []string{DecryptionDBLabel, LabelQueryThreshold})
 )
 
 var (
@@ -102,9 +166,19 @@ func RegisterDbProcessingMetrics() {
 	dbRegisterLock.Do(func() {
 		prometheus.MustRegister(ResponseProcessingTimeHistogram)
 		prometheus.MustRegister(RequestProcessingTimeHistogram)
+		prometheus.MustRegister(PipelineStageTimeHistogram)
+		prometheus.MustRegister(LatencyBudgetExceededCounter)
+		prometheus.MustRegister(BackendConnectionsReapedCounter)
+		prometheus.MustRegister(SessionsExpiredCounter)
+		prometheus.MustRegister(QueryThresholdExceededCounter)
 	})
 }
 
+// ObserveStageDuration observes time since start of processing stage of connection to database of dbType
+func ObserveStageDuration(dbType, stage string, start time.Time) {
+	PipelineStageTimeHistogram.WithLabelValues(dbType, stage).Observe(time.Since(start).Seconds())
+}
+
 // RegisterAcraStructProcessingMetrics register in default prometheus registry metrics related with AcraStruct decryption
 func RegisterAcraStructProcessingMetrics() {
 	acraStructRegisterLock.Do(func() {
@@ -118,6 +192,7 @@ func RegisterEncryptionDecryptionProcessingMetrics() {
 	encryptionDecryptionRegisterLock.Do(func() {
 		prometheus.MustRegister(AcraDecryptionCounter)
 		prometheus.MustRegister(AcraEncryptionCounter)
+		prometheus.MustRegister(UnsupportedContainerFormatCounter)
 	})
 }
 
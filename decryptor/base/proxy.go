@@ -18,6 +18,7 @@ package base
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 
@@ -26,6 +27,8 @@ import (
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	tokenCommon "github.com/cossacklabs/acra/pseudonymization/common"
 	"github.com/cossacklabs/acra/sqlparser"
 )
 
@@ -34,6 +37,11 @@ type Callback interface {
 	Call() error
 }
 
+// ContextCallback represents function to call on detecting poison record with context of processed data
+type ContextCallback interface {
+	CallWithContext(ctx context.Context) error
+}
+
 // PoisonRecordCallbackStorage stores all callbacks, on Call iterates
 // and calls each callbacks until error or end of iterating
 type PoisonRecordCallbackStorage interface {
@@ -50,6 +58,20 @@ type ProxySetting interface {
 	TableSchemaStore() config.TableSchemaStore
 	Censor() acracensor.AcraCensorInterface
 	TLSConnectionWrapper() TLSConnectionWrapper
+	LatencyBudget() *LatencyBudget
+	Heartbeat() *HeartbeatSettings
+	SessionTimeouts() *SessionTimeouts
+	DataAccessAuditLog() *logging.DataAccessAuditLog
+	ReplicationPolicy() ReplicationPolicy
+	ColumnCopyPolicy() ColumnCopyPolicy
+	InboundPoisonRecordDetection() bool
+	RequireClientTLS() bool
+	ColumnMetrics() *ColumnMetrics
+	QueryThresholds() *QueryThresholds
+	ColumnTransforms() DecryptionSubscriber
+	PseudonymStorage() tokenCommon.TokenStorage
+	AnonymizationPolicy() DecryptionSubscriber
+	DifferentialPrivacy() *DifferentialPrivacy
 }
 
 type proxySetting struct {
@@ -59,6 +81,133 @@ type proxySetting struct {
 	connectionWrapper           TLSConnectionWrapper
 	poisonRecordCallbackStorage PoisonRecordCallbackStorage
 	parser                      *sqlparser.Parser
+	latencyBudget               *LatencyBudget
+	heartbeat                   *HeartbeatSettings
+	sessionTimeouts             *SessionTimeouts
+	dataAccessAuditLog          *logging.DataAccessAuditLog
+	replicationPolicy           ReplicationPolicy
+	columnCopyPolicy            ColumnCopyPolicy
+	inboundPoisonDetection      bool
+	requireClientTLS            bool
+	columnMetrics               *ColumnMetrics
+	queryThresholds             *QueryThresholds
+	columnTransforms            DecryptionSubscriber
+	pseudonymStorage            tokenCommon.TokenStorage
+	anonymizationPolicy         DecryptionSubscriber
+	differentialPrivacy         *DifferentialPrivacy
+}
+
+// ProxySettingOption function used to configure optional fields of ProxySetting
+type ProxySettingOption func(setting *proxySetting)
+
+// WithLatencyBudget sets decryption latency budget for query responses
+func WithLatencyBudget(budget *LatencyBudget) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.latencyBudget = budget
+	}
+}
+
+// WithHeartbeat enables liveness probes of idle connections to the database
+func WithHeartbeat(settings *HeartbeatSettings) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.heartbeat = settings
+	}
+}
+
+// WithSessionTimeouts enables closing of idle client sessions and sessions which exceed maximum lifetime
+func WithSessionTimeouts(timeouts *SessionTimeouts) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.sessionTimeouts = timeouts
+	}
+}
+
+// WithDataAccessAuditLog enables writing of events about access to encrypted data by client's queries into audit log
+func WithDataAccessAuditLog(auditLog *logging.DataAccessAuditLog) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.dataAccessAuditLog = auditLog
+	}
+}
+
+// WithReplicationPolicy sets handling of connections in streaming replication mode
+func WithReplicationPolicy(policy ReplicationPolicy) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.replicationPolicy = policy
+	}
+}
+
+// WithColumnCopyPolicy sets handling of queries which copy data between columns with different encryption settings
+func WithColumnCopyPolicy(policy ColumnCopyPolicy) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.columnCopyPolicy = policy
+	}
+}
+
+// WithInboundPoisonRecordDetection enables search of poison records in data of queries sent by clients
+func WithInboundPoisonRecordDetection(enabled bool) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.inboundPoisonDetection = enabled
+	}
+}
+
+// ErrPlaintextConnectionDenied returned when client starts session without TLS while it is required
+var ErrPlaintextConnectionDenied = errors.New("connections without TLS are denied")
+
+// ErrTooManySessions returned when client's session exceeds limit of simultaneous sessions
+var ErrTooManySessions = errors.New("too many simultaneous sessions")
+
+// ConnectionRejecter implemented by ProxyFactory which can reject client's connection with error of database protocol
+// before connection to the database is established
+type ConnectionRejecter interface {
+	RejectConnection(connection net.Conn, reason error) error
+}
+
+// WithRequireClientTLS rejects clients which start session without switching to TLS
+func WithRequireClientTLS(required bool) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.requireClientTLS = required
+	}
+}
+
+// WithColumnMetrics enables per-table and per-column metrics of processed data
+func WithColumnMetrics(metrics *ColumnMetrics) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.columnMetrics = metrics
+	}
+}
+
+// WithQueryThresholds enables logging of queries which exceed thresholds of latency or response size
+func WithQueryThresholds(thresholds *QueryThresholds) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.queryThresholds = thresholds
+	}
+}
+
+// WithColumnTransforms enables transforms of decrypted values applied before encoding of values for the client
+func WithColumnTransforms(transforms DecryptionSubscriber) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.columnTransforms = transforms
+	}
+}
+
+// WithPseudonymStorage sets storage of encrypted source values of pseudonyms used for re-identification
+func WithPseudonymStorage(storage tokenCommon.TokenStorage) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.pseudonymStorage = storage
+	}
+}
+
+// WithAnonymizationPolicy enables replacement of decrypted values with generalized ones for analytics clientIDs
+func WithAnonymizationPolicy(policy DecryptionSubscriber) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.anonymizationPolicy = policy
+	}
+}
+
+// WithDifferentialPrivacy enables noise of aggregate queries of configured clientIDs
+func WithDifferentialPrivacy(privacy *DifferentialPrivacy) ProxySettingOption {
+	return func(setting *proxySetting) {
+		setting.differentialPrivacy = privacy
+	}
 }
 
 // SQLParser return sqlparser.Parser
@@ -91,12 +240,94 @@ func (p *proxySetting) TLSConnectionWrapper() TLSConnectionWrapper {
 	return p.connectionWrapper
 }
 
+// LatencyBudget return decryption latency budget or nil if it is not configured
+func (p *proxySetting) LatencyBudget() *LatencyBudget {
+	return p.latencyBudget
+}
+
+// Heartbeat return settings of liveness probes of idle connections to the database or nil if they are disabled
+func (p *proxySetting) Heartbeat() *HeartbeatSettings {
+	return p.heartbeat
+}
+
+// SessionTimeouts return settings of client session timeouts or nil if they are disabled
+func (p *proxySetting) SessionTimeouts() *SessionTimeouts {
+	return p.sessionTimeouts
+}
+
+// DataAccessAuditLog return audit log of data access events or nil if it is disabled
+func (p *proxySetting) DataAccessAuditLog() *logging.DataAccessAuditLog {
+	return p.dataAccessAuditLog
+}
+
+// ReplicationPolicy return handling of replication connections, they are denied by default
+func (p *proxySetting) ReplicationPolicy() ReplicationPolicy {
+	if p.replicationPolicy == "" {
+		return ReplicationPolicyDeny
+	}
+	return p.replicationPolicy
+}
+
+// ColumnCopyPolicy return handling of queries which copy data between columns with different encryption settings,
+// they are denied by default
+func (p *proxySetting) ColumnCopyPolicy() ColumnCopyPolicy {
+	if p.columnCopyPolicy == "" {
+		return ColumnCopyPolicyDeny
+	}
+	return p.columnCopyPolicy
+}
+
+// InboundPoisonRecordDetection return true if poison records should be searched in query literals and prepared
+// statement values in addition to database responses
+func (p *proxySetting) InboundPoisonRecordDetection() bool {
+	return p.inboundPoisonDetection
+}
+
+// RequireClientTLS return true if clients are allowed to start session only over TLS
+func (p *proxySetting) RequireClientTLS() bool {
+	return p.requireClientTLS
+}
+
+// ColumnMetrics return per-column metrics or nil if they are disabled
+func (p *proxySetting) ColumnMetrics() *ColumnMetrics {
+	return p.columnMetrics
+}
+
+// QueryThresholds return thresholds of query latency and response size or nil if they are disabled
+func (p *proxySetting) QueryThresholds() *QueryThresholds {
+	return p.queryThresholds
+}
+
+// ColumnTransforms return subscriber which transforms decrypted values or nil if transforms are disabled
+func (p *proxySetting) ColumnTransforms() DecryptionSubscriber {
+	return p.columnTransforms
+}
+
+// PseudonymStorage return storage of source values of pseudonyms or nil if it is not configured
+func (p *proxySetting) PseudonymStorage() tokenCommon.TokenStorage {
+	return p.pseudonymStorage
+}
+
+// AnonymizationPolicy return subscriber which anonymizes values for analytics clientIDs or nil if it is disabled
+func (p *proxySetting) AnonymizationPolicy() DecryptionSubscriber {
+	return p.anonymizationPolicy
+}
+
+// DifferentialPrivacy return noise of aggregate queries or nil if it is disabled
+func (p *proxySetting) DifferentialPrivacy() *DifferentialPrivacy {
+	return p.differentialPrivacy
+}
+
 // NewProxySetting return new ProxySetting implementation with data from params
-func NewProxySetting(parser *sqlparser.Parser, tableSchema config.TableSchemaStore, keystore keystore.DecryptionKeyStore, wrapper TLSConnectionWrapper, censor acracensor.AcraCensorInterface, callbackStorage PoisonRecordCallbackStorage) ProxySetting {
-	return &proxySetting{
+func NewProxySetting(parser *sqlparser.Parser, tableSchema config.TableSchemaStore, keystore keystore.DecryptionKeyStore, wrapper TLSConnectionWrapper, censor acracensor.AcraCensorInterface, callbackStorage PoisonRecordCallbackStorage, options ...ProxySettingOption) ProxySetting {
+	setting := &proxySetting{
 		keystore: keystore, parser: parser, tableSchemaStore: tableSchema, censor: censor,
 		connectionWrapper: wrapper, poisonRecordCallbackStorage: callbackStorage,
 	}
+	for _, option := range options {
+		option(setting)
+	}
+	return setting
 }
 
 // Proxy interface to process client's requests to database and responses
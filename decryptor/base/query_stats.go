@@ -0,0 +1,198 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+// Names of thresholds reported in events about exceeded query thresholds
+const (
+	QueryThresholdLatency       = "latency"
+	QueryThresholdResponseRows  = "response_rows"
+	QueryThresholdResponseBytes = "response_bytes"
+)
+
+// QueryThresholds defines limits of query latency and size of response after which AcraServer logs event about the
+// query. Zero value of limit turns it off
+type QueryThresholds struct {
+	Latency       time.Duration
+	ResponseRows  uint
+	ResponseBytes uint
+}
+
+// IsEnabled returns true if at least one threshold is configured
+func (thresholds QueryThresholds) IsEnabled() bool {
+	return thresholds.Latency > 0 || thresholds.ResponseRows > 0 || thresholds.ResponseBytes > 0
+}
+
+// QueryTimings stores time spent by AcraServer on client's request before it is sent to the database
+type QueryTimings struct {
+	Censor    time.Duration
+	Encryptor time.Duration
+}
+
+// Add appends time of processing of next packet of the same request
+func (timings *QueryTimings) Add(censor, encryptor time.Duration) {
+	timings.Censor += censor
+	timings.Encryptor += encryptor
+}
+
+// Take returns collected timings and resets them for next request
+func (timings *QueryTimings) Take() QueryTimings {
+	result := *timings
+	*timings = QueryTimings{}
+	return result
+}
+
+// QueryStats creates records of queries of sessions with database of dbType and checks them against thresholds.
+// nil value is valid and does nothing
+type QueryStats struct {
+	thresholds QueryThresholds
+	dbType     string
+	parser     *sqlparser.Parser
+}
+
+// NewQueryStats returns QueryStats which uses parser to log queries with hidden values or nil if thresholds are
+// nil or turned off
+func NewQueryStats(thresholds *QueryThresholds, dbType string, parser *sqlparser.Parser) *QueryStats {
+	if thresholds == nil || !thresholds.IsEnabled() {
+		return nil
+	}
+	return &QueryStats{thresholds: *thresholds, dbType: dbType, parser: parser}
+}
+
+// NewRecord returns record of client's query allowed to be sent to the database
+func (stats *QueryStats) NewRecord(ctx context.Context, query string) *QueryStatsRecord {
+	if stats == nil {
+		return nil
+	}
+	return &QueryStatsRecord{
+		stats:    stats,
+		clientID: string(AccessContextFromContext(ctx).GetClientID()),
+		logger:   logging.GetLoggerFromContext(ctx),
+		query:    query,
+		sent:     time.Now(),
+	}
+}
+
+// QueryStatsRecord collects timings and size of response of one query until it is finished. nil value is valid
+// and does nothing
+type QueryStatsRecord struct {
+	stats     *QueryStats
+	clientID  string
+	logger    *logrus.Entry
+	query     string
+	lock      sync.Mutex
+	timings   QueryTimings
+	sent      time.Time
+	decryptor time.Duration
+	rows      uint
+	bytes     uint
+	finished  bool
+}
+
+// OnRequestProcessed saves time spent on client's request and marks the moment it is sent to the database
+func (record *QueryStatsRecord) OnRequestProcessed(timings QueryTimings) {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	record.timings = timings
+	record.sent = time.Now()
+	record.lock.Unlock()
+}
+
+// OnRow counts row of query's response with its size and time spent on its decryption
+func (record *QueryStatsRecord) OnRow(size int, decryption time.Duration) {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	record.rows++
+	record.bytes += uint(size)
+	record.decryptor += decryption
+	record.lock.Unlock()
+}
+
+// Finish logs event if the query exceeded any of thresholds, only the first call checks them
+func (record *QueryStatsRecord) Finish() {
+	if record == nil {
+		return
+	}
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	if record.finished {
+		return
+	}
+	record.finished = true
+	query := record.query
+	// don't keep query with sensitive values after the end of its response
+	record.query = ""
+
+	// time since the request was sent includes waiting for the database, network and decryption of response
+	database := time.Since(record.sent) - record.decryptor
+	if database < 0 {
+		database = 0
+	}
+	latency := record.timings.Censor + record.timings.Encryptor + database + record.decryptor
+	thresholds := record.stats.thresholds
+	var exceeded []string
+	if thresholds.Latency > 0 && latency >= thresholds.Latency {
+		exceeded = append(exceeded, QueryThresholdLatency)
+	}
+	if thresholds.ResponseRows > 0 && record.rows >= thresholds.ResponseRows {
+		exceeded = append(exceeded, QueryThresholdResponseRows)
+	}
+	if thresholds.ResponseBytes > 0 && record.bytes >= thresholds.ResponseBytes {
+		exceeded = append(exceeded, QueryThresholdResponseBytes)
+	}
+	if len(exceeded) == 0 {
+		return
+	}
+	for _, threshold := range exceeded {
+		QueryThresholdExceededCounter.WithLabelValues(record.stats.dbType, threshold).Inc()
+	}
+	logger := record.logger.WithFields(logrus.Fields{
+		logging.FieldKeyEventCode: logging.EventCodeQueryThresholdExceeded,
+		"client_id":               record.clientID,
+		"db":                      record.stats.dbType,
+		"exceeded":                strings.Join(exceeded, ","),
+		"latency":                 latency.String(),
+		"censor_time":             record.timings.Censor.String(),
+		"encryptor_time":          record.timings.Encryptor.String(),
+		"db_time":                 database.String(),
+		"decryptor_time":          record.decryptor.String(),
+		"rows":                    record.rows,
+		"bytes":                   record.bytes,
+	})
+	// log only normalized query with hidden values, raw query may contain sensitive data
+	if record.stats.parser != nil {
+		if _, normalizedQuery, _, err := record.stats.parser.HandleRawSQLQuery(query); err == nil {
+			logger = logger.WithField("query", normalizedQuery)
+		}
+	}
+	logger.Warningln("Query exceeded thresholds of latency or response size")
+}
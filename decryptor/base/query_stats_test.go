@@ -0,0 +1,94 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+func TestQueryStatsNil(t *testing.T) {
+	if stats := NewQueryStats(&QueryThresholds{}, DecryptionDBPostgresql, nil); stats != nil {
+		t.Fatal("Expected nil stats without thresholds")
+	}
+	var stats *QueryStats
+	record := stats.NewRecord(context.Background(), "select 1")
+	record.OnRequestProcessed(QueryTimings{Censor: time.Second})
+	record.OnRow(10, time.Second)
+	record.Finish()
+}
+
+func TestQueryTimings(t *testing.T) {
+	timings := QueryTimings{}
+	timings.Add(time.Millisecond, 2*time.Millisecond)
+	timings.Add(0, time.Millisecond)
+	if taken := timings.Take(); taken.Censor != time.Millisecond || taken.Encryptor != 3*time.Millisecond {
+		t.Fatalf("Unexpected timings %+v", taken)
+	}
+	if timings != (QueryTimings{}) {
+		t.Fatal("Timings weren't reset")
+	}
+}
+
+func TestQueryStatsRecord(t *testing.T) {
+	output := &bytes.Buffer{}
+	log.SetOutput(output)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	}()
+	stats := NewQueryStats(&QueryThresholds{ResponseRows: 2, ResponseBytes: 1000}, DecryptionDBPostgresql, sqlparser.New(sqlparser.ModeStrict))
+	ctx := SetAccessContextToContext(context.Background(), NewAccessContext(WithClientID([]byte("client"))))
+
+	// response below thresholds
+	record := stats.NewRecord(ctx, "select secret from users where id=1")
+	record.OnRequestProcessed(QueryTimings{Censor: time.Millisecond, Encryptor: time.Millisecond})
+	record.OnRow(10, time.Millisecond)
+	record.Finish()
+
+	record = stats.NewRecord(ctx, "select secret from users where id=2")
+	record.OnRequestProcessed(QueryTimings{Censor: time.Millisecond, Encryptor: 2 * time.Millisecond})
+	record.OnRow(10, time.Millisecond)
+	record.OnRow(10, time.Millisecond)
+	record.Finish()
+	// event is logged only once
+	record.Finish()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 event, took %d: %s", len(lines), output.String())
+	}
+	event := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		logging.FieldKeyEventCode: float64(logging.EventCodeQueryThresholdExceeded),
+		"client_id":               "client",
+		"db":                      DecryptionDBPostgresql,
+		"exceeded":                QueryThresholdResponseRows,
+		"censor_time":             "1ms",
+		"encryptor_time":          "2ms",
+		"decryptor_time":          "2ms",
+		"rows":                    float64(2),
+		"bytes":                   float64(20),
+	}
+	for key, value := range expected {
+		if event[key] != value {
+			t.Fatalf("Expected %v for %s, took %v", value, key, event[key])
+		}
+	}
+	query, ok := event["query"].(string)
+	if !ok || strings.Contains(query, "2") {
+		t.Fatalf("Expected query with hidden values, took %v", event["query"])
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReplicationPolicy defines how AcraServer handles connections opened in streaming replication mode
+type ReplicationPolicy string
+
+// Supported replication policies
+const (
+	// ReplicationPolicyDeny rejects replication connections before they reach the database
+	ReplicationPolicyDeny ReplicationPolicy = "deny"
+	// ReplicationPolicyPassthrough forwards replication traffic untouched without parsing and decryption
+	ReplicationPolicyPassthrough ReplicationPolicy = "passthrough"
+)
+
+// ErrInvalidReplicationPolicy returned for unsupported replication policy
+var ErrInvalidReplicationPolicy = errors.New("invalid replication policy")
+
+// ErrReplicationConnectionDenied returned when replication connection rejected according to ReplicationPolicyDeny
+var ErrReplicationConnectionDenied = errors.New("replication connections are denied")
+
+// ParseReplicationPolicy returns ReplicationPolicy from string or ErrInvalidReplicationPolicy
+func ParseReplicationPolicy(value string) (ReplicationPolicy, error) {
+	switch policy := ReplicationPolicy(value); policy {
+	case ReplicationPolicyDeny, ReplicationPolicyPassthrough:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidReplicationPolicy, value)
+}
@@ -0,0 +1,32 @@
+package base
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseReplicationPolicy(t *testing.T) {
+	for _, value := range []string{"deny", "passthrough"} {
+		policy, err := ParseReplicationPolicy(value)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", value, err)
+		}
+		if string(policy) != value {
+			t.Fatalf("Expected %s, took %s", value, policy)
+		}
+	}
+	for _, value := range []string{"", "decrypt", "DENY"} {
+		if _, err := ParseReplicationPolicy(value); !errors.Is(err, ErrInvalidReplicationPolicy) {
+			t.Fatalf("Expected ErrInvalidReplicationPolicy for '%s', took %v", value, err)
+		}
+	}
+	// replication connections denied if policy is not configured
+	setting := NewProxySetting(nil, nil, nil, nil, nil, nil)
+	if setting.ReplicationPolicy() != ReplicationPolicyDeny {
+		t.Fatalf("Expected deny policy by default, took %s", setting.ReplicationPolicy())
+	}
+	setting = NewProxySetting(nil, nil, nil, nil, nil, nil, WithReplicationPolicy(ReplicationPolicyPassthrough))
+	if setting.ReplicationPolicy() != ReplicationPolicyPassthrough {
+		t.Fatalf("Expected passthrough policy, took %s", setting.ReplicationPolicy())
+	}
+}
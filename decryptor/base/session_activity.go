@@ -0,0 +1,49 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import "context"
+
+// SessionActivityObserver is notified by proxy when connection to the database switches between processing of
+// client's requests and idle state, when it may be closed without interruption of client's transaction
+type SessionActivityObserver interface {
+	// OnSessionBusy called before client's request is forwarded to the database
+	OnSessionBusy()
+	// OnSessionIdle called after the database finished response and connection is out of transaction
+	OnSessionIdle()
+}
+
+type noopSessionActivityObserver struct{}
+
+func (noopSessionActivityObserver) OnSessionBusy() {}
+func (noopSessionActivityObserver) OnSessionIdle() {}
+
+type sessionActivityObserverKey struct{}
+
+// SetSessionActivityObserverToContext returns context with saved SessionActivityObserver
+func SetSessionActivityObserverToContext(ctx context.Context, observer SessionActivityObserver) context.Context {
+	return context.WithValue(ctx, sessionActivityObserverKey{}, observer)
+}
+
+// SessionActivityObserverFromContext returns saved SessionActivityObserver or observer which does nothing
+func SessionActivityObserverFromContext(ctx context.Context) SessionActivityObserver {
+	observer, ok := ctx.Value(sessionActivityObserverKey{}).(SessionActivityObserver)
+	if !ok {
+		return noopSessionActivityObserver{}
+	}
+	return observer
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// Errors returned by session timeouts configuration and passed to proxies as reasons of expired sessions
+var (
+	ErrInvalidSessionTimeout   = errors.New("session timeouts should not be negative")
+	ErrSessionIdleTimeout      = errors.New("session was closed due to idle timeout")
+	ErrSessionLifetimeExceeded = errors.New("session was closed due to maximum session lifetime")
+)
+
+// Reasons of expired sessions used as metric labels
+const (
+	SessionTimeoutReasonIdle     = "idle"
+	SessionTimeoutReasonLifetime = "lifetime"
+)
+
+// sessionTimeoutMaxCheckInterval limits interval of checks to not exceed long timeouts noticeably
+const sessionTimeoutMaxCheckInterval = time.Second
+
+// SessionTimeouts configures closing of client sessions which are idle or live too long
+type SessionTimeouts struct {
+	// Idle is time without client's requests after which session is closed, 0 disables it
+	Idle time.Duration
+	// Lifetime is maximum duration of session, 0 disables it
+	Lifetime time.Duration
+}
+
+// NewSessionTimeouts returns validated SessionTimeouts or nil if both timeouts are disabled
+func NewSessionTimeouts(idle, lifetime time.Duration) (*SessionTimeouts, error) {
+	if idle < 0 || lifetime < 0 {
+		return nil, ErrInvalidSessionTimeout
+	}
+	if idle == 0 && lifetime == 0 {
+		return nil, nil
+	}
+	return &SessionTimeouts{Idle: idle, Lifetime: lifetime}, nil
+}
+
+// checkInterval returns interval of checks which is less than enabled timeouts
+func (settings *SessionTimeouts) checkInterval() time.Duration {
+	interval := sessionTimeoutMaxCheckInterval
+	for _, timeout := range []time.Duration{settings.Idle, settings.Lifetime} {
+		if timeout > 0 && timeout/2 < interval {
+			interval = timeout / 2
+		}
+	}
+	return interval
+}
+
+// SessionExpireFunc sends protocol error with reason to the client and closes session
+type SessionExpireFunc func(reason error)
+
+// SessionTimer closes client sessions on idle timeout or when they exceed maximum lifetime. Proxy should notify it about
+// client's requests with OnClientRequest and about finished responses with OnResponseFinished. Time of waiting for
+// database's response is not counted as idle and sessions which exceed lifetime are closed only when they don't wait for
+// it, so running queries are not interrupted. nil value is valid and does nothing
+type SessionTimer struct {
+	settings *SessionTimeouts
+	dbType   string
+	expire   SessionExpireFunc
+	logger   *log.Entry
+	started  time.Time
+
+	lock      sync.Mutex
+	waiting   bool
+	idleSince time.Time
+	expired   bool
+}
+
+// NewSessionTimer returns SessionTimer for session with database of dbType or nil if settings is nil
+func NewSessionTimer(settings *SessionTimeouts, dbType string, expire SessionExpireFunc, logger *log.Entry) *SessionTimer {
+	if settings == nil {
+		return nil
+	}
+	now := time.Now()
+	return &SessionTimer{settings: settings, dbType: dbType, expire: expire, logger: logger, started: now, idleSince: now}
+}
+
+// Run checks session until ctx is done or session is expired. Should be called as goroutine
+func (timer *SessionTimer) Run(ctx context.Context) {
+	if timer == nil {
+		return
+	}
+	ticker := time.NewTicker(timer.settings.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !timer.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// check expires session if it is idle too long or exceeded lifetime. Returns false if session was expired
+func (timer *SessionTimer) check(now time.Time) bool {
+	timer.lock.Lock()
+	defer timer.lock.Unlock()
+	if timer.expired {
+		return false
+	}
+	if timer.waiting {
+		return true
+	}
+	switch {
+	case timer.settings.Idle > 0 && now.Sub(timer.idleSince) >= timer.settings.Idle:
+		timer.expireSession(SessionTimeoutReasonIdle, ErrSessionIdleTimeout, timer.settings.Idle)
+	case timer.settings.Lifetime > 0 && now.Sub(timer.started) >= timer.settings.Lifetime:
+		timer.expireSession(SessionTimeoutReasonLifetime, ErrSessionLifetimeExceeded, timer.settings.Lifetime)
+	default:
+		return true
+	}
+	return false
+}
+
+// expireSession closes session, should be called with acquired lock so client's requests wait until it is closed
+func (timer *SessionTimer) expireSession(reason string, err error, timeout time.Duration) {
+	timer.expired = true
+	SessionsExpiredCounter.WithLabelValues(timer.dbType, reason).Inc()
+	timer.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeSessionExpired).
+		WithField("reason", reason).
+		WithField("timeout", timeout.String()).
+		Infoln("Close client's session due to timeout")
+	timer.expire(err)
+}
+
+// OnClientRequest marks session as waiting for database's response
+func (timer *SessionTimer) OnClientRequest() {
+	if timer == nil {
+		return
+	}
+	timer.lock.Lock()
+	timer.waiting = true
+	timer.lock.Unlock()
+}
+
+// OnResponseFinished marks session as idle after database's response to client's requests
+func (timer *SessionTimer) OnResponseFinished() {
+	if timer == nil {
+		return
+	}
+	timer.lock.Lock()
+	timer.waiting = false
+	timer.idleSince = time.Now()
+	timer.lock.Unlock()
+}
@@ -0,0 +1,122 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNewSessionTimeouts(t *testing.T) {
+	testcases := []struct {
+		idle     time.Duration
+		lifetime time.Duration
+		disabled bool
+		err      error
+	}{
+		{time.Second, time.Minute, false, nil},
+		{time.Second, 0, false, nil},
+		{0, time.Minute, false, nil},
+		{0, 0, true, nil},
+		{-time.Second, 0, false, ErrInvalidSessionTimeout},
+		{0, -time.Second, false, ErrInvalidSessionTimeout},
+	}
+	for i, tcase := range testcases {
+		timeouts, err := NewSessionTimeouts(tcase.idle, tcase.lifetime)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if err == nil && (timeouts == nil) != tcase.disabled {
+			t.Fatalf("[%d] Expected disabled=%v, took %v", i, tcase.disabled, timeouts)
+		}
+	}
+}
+
+func TestSessionTimerNil(t *testing.T) {
+	timer := NewSessionTimer(nil, "postgresql", nil, nil)
+	if timer != nil {
+		t.Fatal("Expected nil timer without settings")
+	}
+	timer.OnClientRequest()
+	timer.OnResponseFinished()
+	timer.Run(context.Background())
+}
+
+// testSessionTimer returns timer with list of reasons of expired sessions
+func testSessionTimer(t *testing.T, idle, lifetime time.Duration) (*SessionTimer, *[]error) {
+	RegisterDbProcessingMetrics()
+	settings, err := NewSessionTimeouts(idle, lifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasons []error
+	timer := NewSessionTimer(settings, "postgresql", func(reason error) { reasons = append(reasons, reason) }, log.NewEntry(log.New()))
+	return timer, &reasons
+}
+
+func TestSessionTimerIdle(t *testing.T) {
+	timer, reasons := testSessionTimer(t, time.Minute, 0)
+	start := timer.started
+	if !timer.check(start.Add(time.Second)) || len(*reasons) != 0 {
+		t.Fatal("Session expired before idle timeout")
+	}
+	// waiting for response is not counted as idle
+	timer.OnClientRequest()
+	if !timer.check(start.Add(time.Hour)) || len(*reasons) != 0 {
+		t.Fatal("Session expired while waiting for response")
+	}
+	timer.OnResponseFinished()
+	idleSince := timer.idleSince
+	if !timer.check(idleSince.Add(time.Second)) || len(*reasons) != 0 {
+		t.Fatal("Session expired right after response")
+	}
+	if timer.check(idleSince.Add(time.Minute)) {
+		t.Fatal("Session wasn't expired after idle timeout")
+	}
+	if len(*reasons) != 1 || (*reasons)[0] != ErrSessionIdleTimeout {
+		t.Fatalf("Expected expiration with %v, took %v", ErrSessionIdleTimeout, *reasons)
+	}
+	// expired session is not checked anymore
+	if timer.check(idleSince.Add(time.Hour)) || len(*reasons) != 1 {
+		t.Fatal("Session expired twice")
+	}
+}
+
+func TestSessionTimerLifetime(t *testing.T) {
+	timer, reasons := testSessionTimer(t, 0, time.Minute)
+	start := timer.started
+	timer.OnClientRequest()
+	if !timer.check(start.Add(time.Hour)) || len(*reasons) != 0 {
+		t.Fatal("Session expired while waiting for response")
+	}
+	timer.OnResponseFinished()
+	if timer.check(start.Add(time.Hour)) {
+		t.Fatal("Session wasn't expired after lifetime")
+	}
+	if len(*reasons) != 1 || (*reasons)[0] != ErrSessionLifetimeExceeded {
+		t.Fatalf("Expected expiration with %v, took %v", ErrSessionLifetimeExceeded, *reasons)
+	}
+}
+
+func TestSessionTimerRun(t *testing.T) {
+	RegisterDbProcessingMetrics()
+	settings, err := NewSessionTimeouts(time.Millisecond*50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := make(chan error, 1)
+	timer := NewSessionTimer(settings, "mysql", func(reason error) { expired <- reason }, log.NewEntry(log.New()))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	go timer.Run(ctx)
+	select {
+	case reason := <-expired:
+		if reason != ErrSessionIdleTimeout {
+			t.Fatalf("Expected %v, took %v", ErrSessionIdleTimeout, reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("Session wasn't expired")
+	}
+}
@@ -80,6 +80,10 @@ func (p *BaseMySQLDataProcessor) encodeBinary(ctx context.Context, data []byte,
 		if err != nil && err != base_mysql.ErrConvertToDataType {
 			return nil, nil, err
 		}
+		// NULL in binary protocol is marked in NULL-bitmap of the row without any value
+		if base.IsNullValueFromContext(ctx) {
+			return ctx, nil, nil
+		}
 
 		if encoded != nil {
 			return ctx, encoded, nil
@@ -178,6 +182,9 @@ func (p *BaseMySQLDataProcessor) encodeText(ctx context.Context, data []byte, se
 	if err != nil && err != base_mysql.ErrConvertToDataType {
 		return nil, nil, err
 	}
+	if base.IsNullValueFromContext(ctx) {
+		return ctx, base_mysql.PutLengthEncodedString(nil), nil
+	}
 
 	if encoded != nil {
 		return ctx, encoded, nil
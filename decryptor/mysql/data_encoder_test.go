@@ -304,3 +304,45 @@ func TestValidateDefaultValue(t *testing.T) {
 		})
 	}
 }
+
+func TestNullOnFailEncoding(t *testing.T) {
+	testcases := []struct {
+		input      []byte
+		dataTypeID uint32
+	}{
+		{[]byte("string"), uint32(base_mysql.TypeString)},
+		{[]byte("bytes"), uint32(base_mysql.TypeBlob)},
+		{[]byte("invalid_int32"), uint32(base_mysql.TypeLong)},
+		{[]byte("invalid_int64"), uint32(base_mysql.TypeLongLong)},
+	}
+
+	encoder := NewDataEncoderProcessor()
+	logger := logrus.NewEntry(logrus.New())
+	for _, testcase := range testcases {
+		setting := &config.BasicColumnEncryptionSetting{
+			Name:           "cossack_column",
+			DataTypeID:     testcase.dataTypeID,
+			ResponseOnFail: common.ResponseOnFailNull,
+		}
+
+		// text protocol encodes NULL as length encoded string
+		info := base.NewColumnInfo(0, "", textFormat, -1, 0, 0)
+		ctx, encoded, err := encoder.encodeText(context.Background(), testcase.input, setting, info, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !base.IsNullValueFromContext(ctx) || !bytes.Equal(encoded, []byte{0xFB}) {
+			t.Fatalf("[%s] expected NULL in text protocol, found %v", testcase.input, encoded)
+		}
+
+		// binary protocol marks NULL in bitmap and has no value
+		info = base.NewColumnInfo(0, "", binaryFormat, -1, 0, 0)
+		ctx, encoded, err = encoder.encodeBinary(context.Background(), testcase.input, setting, info, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !base.IsNullValueFromContext(ctx) || encoded != nil {
+			t.Fatalf("[%s] expected NULL in binary protocol, found %v", testcase.input, encoded)
+		}
+	}
+}
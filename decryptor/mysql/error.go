@@ -30,6 +30,19 @@ const (
 	ErQueryInterruptedState = "70100"
 )
 
+// Codes of errors sent to the client before closing connection
+const (
+	// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html#error_er_client_interaction_timeout
+	ErClientInteractionTimeoutCode  = 4031
+	ErClientInteractionTimeoutState = "HY000"
+	// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html#error_er_server_shutdown
+	ErServerShutdownCode  = 1053
+	ErServerShutdownState = "08S01"
+	// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html#error_er_con_count_error
+	ErConCountErrorCode  = 1040
+	ErConCountErrorState = "08004"
+)
+
 // QueryExecutionWasInterrupted is a default message of the mysql's Query
 // interrupted error
 const QueryExecutionWasInterrupted = "Query execution was interrupted"
@@ -45,7 +58,11 @@ func newQueryInterruptedError(msg string) *SQLError {
 // NewQueryInterruptedError return packed QueryInterrupted error
 // https://dev.mysql.com/doc/internals/en/packet-ERR_Packet.html
 func NewQueryInterruptedError(isProtocol41 bool, msg string) []byte {
-	mysqlError := newQueryInterruptedError(msg)
+	return newErrorPacketData(isProtocol41, newQueryInterruptedError(msg))
+}
+
+// newErrorPacketData return payload of ERR_Packet with mysqlError
+func newErrorPacketData(isProtocol41 bool, mysqlError *SQLError) []byte {
 	var data []byte
 	if isProtocol41 {
 		// 1 byte ErrPacket flag + 2 bytes of error code = 3
@@ -83,6 +83,9 @@ func (array ByteArrayDump) Dump() []byte {
 type Packet struct {
 	header []byte
 	data   []byte
+	// usePool turns on reading of payload into buffer from pool which is returned back by Release
+	usePool bool
+	pooled  *[]byte
 }
 
 // NewPacket returns new Packet
@@ -104,7 +107,8 @@ func (packet *Packet) GetSequenceNumber() byte {
 	return packet.header[SequenceIDIndex]
 }
 
-// GetData returns packet payload
+// GetData returns packet payload. Payload of packet read with ReadPooledPacket is valid only until Release, so
+// callers which keep it longer should copy it
 func (packet *Packet) GetData() []byte {
 	return packet.data
 }
@@ -255,7 +259,7 @@ func (packet *Packet) readPacket(connection net.Conn) ([]byte, error) {
 		return nil, fmt.Errorf("invalid payload length %d", length)
 	}
 
-	data := make([]byte, length)
+	data := packet.newPayload(length)
 	if _, err := io.ReadFull(connection, data); err != nil {
 		return nil, err
 	}
@@ -271,6 +275,27 @@ func (packet *Packet) readPacket(connection net.Conn) ([]byte, error) {
 	return append(data, buf...), nil
 }
 
+// newPayload returns buffer for payload with specified length. Only first part of payload is taken from pool because
+// payloads split into several packets are too big to keep them in pool
+func (packet *Packet) newPayload(length int) []byte {
+	if !packet.usePool || packet.pooled != nil {
+		return make([]byte, length)
+	}
+	packet.pooled = base.GetBytes(length)
+	return *packet.pooled
+}
+
+// Release returns buffer of payload to pool if packet was read with ReadPooledPacket. Data of packet shouldn't be used
+// after that
+func (packet *Packet) Release() {
+	if packet.pooled == nil {
+		return
+	}
+	base.PutBytes(packet.pooled)
+	packet.pooled = nil
+	packet.data = nil
+}
+
 // Dump returns packet header and data as []byte
 func (packet *Packet) Dump() []byte {
 	return append(packet.header, packet.data...)
@@ -367,3 +392,15 @@ func ReadPacket(connection net.Conn) (*Packet, error) {
 	}
 	return packet, nil
 }
+
+// ReadPooledPacket from connection and return Packet struct with data stored in buffer from pool or error. Packet
+// should be released with Release when its data isn't used anymore
+func ReadPooledPacket(connection net.Conn) (*Packet, error) {
+	packet := NewPacket()
+	packet.usePool = true
+	if err := packet.ReadPacket(connection); err != nil {
+		packet.Release()
+		return nil, err
+	}
+	return packet, nil
+}
@@ -0,0 +1,81 @@
+package mysql
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// readerConn is a connection which reads data from reader
+type readerConn struct {
+	net.Conn
+	reader *bytes.Reader
+}
+
+func (conn readerConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// newTestPacket returns packet with header and payload
+func newTestPacket(sequenceID byte, payload []byte) []byte {
+	length := len(payload)
+	return append([]byte{byte(length), byte(length >> 8), byte(length >> 16), sequenceID}, payload...)
+}
+
+func TestReadPooledPacket(t *testing.T) {
+	first := bytes.Repeat([]byte{1}, 100)
+	second := []byte{2, 3}
+	conn := readerConn{reader: bytes.NewReader(append(newTestPacket(1, first), newTestPacket(2, second)...))}
+	for i, expected := range [][]byte{first, second} {
+		packet, err := ReadPooledPacket(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(packet.GetData(), expected) || int(packet.GetSequenceNumber()) != i+1 {
+			t.Fatalf("Unexpected packet %v", packet.Dump())
+		}
+		packet.Release()
+		if packet.GetData() != nil {
+			t.Fatal("Expected released data")
+		}
+		// second call shouldn't return buffer to pool again
+		packet.Release()
+	}
+	if _, err := ReadPooledPacket(conn); err == nil {
+		t.Fatal("Expected error on reading of empty connection")
+	}
+	// packets read without pool aren't affected
+	conn = readerConn{reader: bytes.NewReader(newTestPacket(1, second))}
+	packet, err := ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.Release()
+	if !bytes.Equal(packet.GetData(), second) {
+		t.Fatal("Unexpected data of not pooled packet")
+	}
+}
+
+func benchmarkReadPacket(b *testing.B, read func(net.Conn) (*Packet, error)) {
+	data := newTestPacket(1, bytes.Repeat([]byte{1}, 1000))
+	reader := bytes.NewReader(data)
+	conn := readerConn{reader: reader}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(data)
+		packet, err := read(conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		packet.Release()
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	benchmarkReadPacket(b, ReadPacket)
+}
+
+func BenchmarkReadPooledPacket(b *testing.B) {
+	benchmarkReadPacket(b, ReadPooledPacket)
+}
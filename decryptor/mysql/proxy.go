@@ -14,6 +14,10 @@ limitations under the License.
 package mysql
 
 import (
+	"errors"
+	"net"
+	"time"
+
 	"github.com/cossacklabs/acra/crypto"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
@@ -22,8 +26,10 @@ import (
 	hashDecryptor "github.com/cossacklabs/acra/hmac/decryptor"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/masking"
+	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/pseudonymization"
 	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/wasm"
 )
 
 type proxyFactory struct {
@@ -41,6 +47,23 @@ func NewProxyFactory(proxySetting base.ProxySetting, store keystore.DecryptionKe
 	}, nil
 }
 
+// RejectConnection sends ERR_Packet with reason instead of initial handshake, like MySQL does when it can't accept
+// connection
+func (factory *proxyFactory) RejectConnection(connection net.Conn, reason error) error {
+	mysqlError := newQueryInterruptedError("AcraServer: " + reason.Error())
+	if errors.Is(reason, base.ErrTooManySessions) {
+		mysqlError.Code, mysqlError.State = ErConCountErrorCode, ErConCountErrorState
+	}
+	if err := connection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err != nil {
+		return err
+	}
+	// capabilities of client are unknown before handshake, so error is sent without SQL state
+	packet := NewPacket()
+	packet.SetData(newErrorPacketData(false, mysqlError))
+	_, err := connection.Write(packet.Dump())
+	return err
+}
+
 // New return mysql proxy implementation
 func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSession) (base.Proxy, error) {
 	sqlParser := factory.setting.SQLParser()
@@ -85,9 +108,25 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 		poisonDetector.SetPoisonRecordCallbacks(factory.setting.PoisonRecordCallbackStorage())
 
 		envelopeDetector.AddCallback(poisonDetector)
+
+		if factory.setting.InboundPoisonRecordDetection() {
+			// separate detector to not decrypt inbound data with other callbacks
+			inboundDetector := crypto.NewEnvelopeDetector()
+			inboundDetector.AddCallback(poisonDetector)
+			proxy.AddQueryObserver(crypto.NewMysqlPoisonRecordQueryDetector(inboundDetector))
+		}
 	}
 
 	chainEncryptors := make([]encryptor.DataEncryptor, 0, 10)
+	var wasmProcessor *wasm.Processor
+	if storeMask&config.SettingWasmProcessorFlag == config.SettingWasmProcessorFlag {
+		wasmProcessor, err = wasm.GetDefaultProcessor()
+		if err != nil {
+			return nil, err
+		}
+		// user-defined functions work with plaintext values before tokenization and encryption
+		chainEncryptors = append(chainEncryptors, wasmProcessor)
+	}
 	if storeMask&config.SettingTokenizationFlag == config.SettingTokenizationFlag {
 		tokenizer, err := pseudonymization.NewDataTokenizer(factory.tokenizer)
 		if err != nil {
@@ -110,6 +149,18 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 		proxy.AddQueryObserver(acraBlockStructTokenEncryptor)
 	}
 
+	if storeMask&config.SettingPseudonymizationFlag == config.SettingPseudonymizationFlag {
+		pseudonymizationKeyStore, ok := factory.keystore.(keystore.PseudonymizationKeyStore)
+		if !ok {
+			return nil, keystore.ErrPseudonymizationNotSupported
+		}
+		pseudonymizer, err := pseudonymization.NewPseudonymizer(pseudonymizationKeyStore, factory.setting.PseudonymStorage())
+		if err != nil {
+			return nil, err
+		}
+		chainEncryptors = append(chainEncryptors, pseudonymizer)
+	}
+
 	chainEncryptors = append(chainEncryptors, crypto.NewEncryptHandler(registryHandler))
 
 	var hmacProcessor *hmac.Processor
@@ -157,8 +208,20 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 	if err != nil {
 		return nil, err
 	}
+	queryEncryptor.SetColumnCopyPolicy(factory.setting.ColumnCopyPolicy())
 	proxy.AddQueryObserver(queryEncryptor)
 	proxy.SubscribeOnAllColumnsDecryption(queryEncryptor)
+	if wasmProcessor != nil {
+		proxy.SubscribeOnAllColumnsDecryption(wasmProcessor)
+	}
+	// transforms work with decrypted values before they are encoded for the client
+	if columnTransforms := factory.setting.ColumnTransforms(); columnTransforms != nil {
+		proxy.SubscribeOnAllColumnsDecryption(columnTransforms)
+	}
+	// anonymization is applied last, so analytics clients don't receive values changed only by transforms
+	if anonymizationPolicy := factory.setting.AnonymizationPolicy(); anonymizationPolicy != nil {
+		proxy.SubscribeOnAllColumnsDecryption(anonymizationPolicy)
+	}
 
 	proxy.SubscribeOnAllColumnsDecryption(NewDataEncoderProcessor())
 
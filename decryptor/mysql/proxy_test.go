@@ -144,6 +144,10 @@ func (stubSession) ClientConnection() net.Conn {
 	return nil
 }
 
+func (stubSession) ClientAddress() net.Addr {
+	return nil
+}
+
 func (stubSession) DatabaseConnection() net.Conn {
 	return nil
 }
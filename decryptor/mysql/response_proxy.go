@@ -28,6 +28,7 @@ import (
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/decryptor/base"
 	base_mysql "github.com/cossacklabs/acra/decryptor/mysql/base"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
@@ -102,6 +103,7 @@ const (
 type ResponseHandler func(ctx context.Context, packet *Packet, dbConnection, clientConnection net.Conn) error
 
 func defaultResponseHandler(ctx context.Context, packet *Packet, _, clientConnection net.Conn) error {
+	defer base.ObserveStageDuration(base.DecryptionDBMysql, base.StageNetworkWrite, time.Now())
 	if _, err := clientConnection.Write(packet.Dump()); err != nil {
 		return err
 	}
@@ -131,6 +133,15 @@ type Handler struct {
 	parser                  *sqlparser.Parser
 	protocolState           *ProtocolState
 	registry                *PreparedStatementRegistry
+	latencyBudget           *base.QueryLatencyBudget
+	sessionTimer            *base.SessionTimer
+	dataAccessAudit         *base.DataAccessAudit
+	columnMetrics           *base.ColumnMetrics
+	queryStats              *base.QueryStats
+	// dataAccess collects data access of the query which response is handled by QueryResponseHandler
+	dataAccess *base.DataAccessRecord
+	// queryStatsRecord collects timings and size of response of the query handled by QueryResponseHandler
+	queryStatsRecord *base.QueryStatsRecord
 }
 
 // NewMysqlProxy returns new Handler
@@ -143,7 +154,7 @@ func NewMysqlProxy(session base.ClientSession, parser *sqlparser.Parser, setting
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{
+	handler := &Handler{
 		isTLSHandshake:          false,
 		dbTLSHandshakeFinished:  make(chan bool),
 		clientDeprecateEOF:      false,
@@ -160,7 +171,44 @@ func NewMysqlProxy(session base.ClientSession, parser *sqlparser.Parser, setting
 		parser:                  parser,
 		protocolState:           NewProtocolState(),
 		registry:                NewPreparedStatementRegistry(),
-	}, nil
+		latencyBudget:           base.NewQueryLatencyBudget(setting.LatencyBudget(), base.DecryptionDBMysql),
+		dataAccessAudit:         base.NewDataAccessAudit(setting.DataAccessAuditLog(), base.DecryptionDBMysql),
+		columnMetrics:           setting.ColumnMetrics(),
+		queryStats:              base.NewQueryStats(setting.QueryThresholds(), base.DecryptionDBMysql, parser),
+	}
+	handler.sessionTimer = base.NewSessionTimer(setting.SessionTimeouts(), base.DecryptionDBMysql,
+		handler.expireSession, handler.logger)
+	return handler, nil
+}
+
+// expireSession sends error to the client and COM_QUIT to the database, then closes connection to the database which
+// interrupts proxying of the session
+func (handler *Handler) expireSession(reason error) {
+	mysqlError := &SQLError{Code: ErServerShutdownCode, State: ErServerShutdownState, Message: "AcraServer: " + reason.Error()}
+	if reason == base.ErrSessionIdleTimeout {
+		mysqlError.Code, mysqlError.State = ErClientInteractionTimeoutCode, ErClientInteractionTimeoutState
+	}
+	// server sends error about closed session with zero sequence number outside of command phase
+	errPacket := NewPacket()
+	errPacket.SetData(newErrorPacketData(handler.clientProtocol41, mysqlError))
+	if err := handler.clientConnection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err == nil {
+		if _, err := handler.clientConnection.Write(errPacket.Dump()); err != nil {
+			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Debugln("Can't write error to client")
+		}
+	}
+	quitPacket := NewPacket()
+	quitPacket.SetData([]byte{CommandQuit})
+	if err := handler.dbConnection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err == nil {
+		if _, err := handler.dbConnection.Write(quitPacket.Dump()); err != nil {
+			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToDB).
+				Debugln("Can't write COM_QUIT to db")
+		}
+	}
+	if err := handler.dbConnection.Close(); err != nil {
+		handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnectionDB).
+			Errorln("Can't close connection to db")
+	}
 }
 
 // SubscribeOnAllColumnsDecryption subscribes for OnColumn notifications on each column.
@@ -176,6 +224,13 @@ func (handler *Handler) Unsubscribe(subscriber base.DecryptionSubscriber) {
 func (handler *Handler) onColumnDecryption(parentCtx context.Context, column int, data []byte, isBinary bool, field *ColumnDescription) (context.Context, []byte, error) {
 	accessContext := base.AccessContextFromContext(parentCtx)
 	accessContext.SetColumnInfo(base.NewColumnInfo(column, "", isBinary, len(data), byte(field.Type), byte(field.originType)))
+	if handler.columnMetrics != nil {
+		if tableSchema := handler.setting.TableSchemaStore().GetTableSchema(string(field.Table)); tableSchema != nil &&
+			tableSchema.GetColumnEncryptionSettings(string(field.Name)) != nil {
+			parentCtx = base.SetColumnMetricsRecorderToContext(parentCtx, handler.columnMetrics.Column(string(field.Table), string(field.Name)))
+		}
+	}
+	defer base.ObserveStageDuration(base.DecryptionDBMysql, base.StageColumnDecryption, time.Now())
 	return handler.decryptionObserver.OnColumnDecryption(parentCtx, column, data)
 }
 
@@ -204,6 +259,7 @@ func (handler *Handler) getResponseHandler() ResponseHandler {
 func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<- base.ProxyError) {
 	ctx, span := trace.StartSpan(ctx, "ProxyClientConnection")
 	defer span.End()
+	ctx = base.SetColumnMetricsToContext(ctx, handler.columnMetrics)
 	clientLog := handler.logger.WithField("proxy", "client")
 	clientLog.Debugln("Start proxy client's requests")
 	firstPacket := true
@@ -226,6 +282,7 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 			return
 		}
 
+		handler.sessionTimer.OnClientRequest()
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.RequestProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
 		timerObserveFunc = timer.ObserveDuration
 
@@ -275,6 +332,9 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 					errCh <- base.NewClientProxyError(err)
 					return
 				}
+				if session := base.ClientSessionFromContext(handler.ctx); session != nil {
+					base.SetPeerCertificateToSession(session, network.GetPeerCertificateFromConnection(tlsConnection))
+				}
 				if handler.setting.TLSConnectionWrapper().UseConnectionClientID() {
 					handler.logger.WithField("client_id", clientID).Debugln("Set new clientID")
 					handler.clientIDObserverManager.OnNewClientID(clientID)
@@ -340,9 +400,13 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 				}
 			}
 
-			if err := handler.acracensor.HandleQuery(query); err != nil {
+			censorStart := time.Now()
+			err := handler.acracensor.HandleQueryWithContext(ctx, query)
+			base.ObserveStageDuration(base.DecryptionDBMysql, base.StageCensor, censorStart)
+			if err != nil {
 				censorSpan.End()
 				clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorln("Error on AcraCensor check")
+				handler.dataAccessAudit.OnBlockedQuery(ctx)
 				if err := handler.sendClientError(QueryExecutionWasInterrupted, packet); err != nil {
 					handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
 						Errorln("Can't write response with error to client")
@@ -350,8 +414,11 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 				continue
 			}
 
+			encryptorStart := time.Now()
 			queryObj := base.NewOnQueryObjectFromQuery(query, handler.parser)
 			newQuery, changed, err := handler.queryObserverManager.OnQuery(ctx, queryObj)
+			base.ObserveStageDuration(base.DecryptionDBMysql, base.StageQueryRewrite, encryptorStart)
+			timings := base.QueryTimings{Censor: encryptorStart.Sub(censorStart), Encryptor: time.Since(encryptorStart)}
 			if err != nil {
 				if filesystem.IsKeyReadError(err) {
 					errCh <- base.NewClientProxyError(err)
@@ -364,6 +431,9 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 
 			switch cmd {
 			case CommandQuery:
+				handler.dataAccess = handler.dataAccessAudit.NewRecord(ctx)
+				handler.queryStatsRecord = handler.queryStats.NewRecord(ctx, query)
+				handler.queryStatsRecord.OnRequestProcessed(timings)
 				handler.setQueryHandler(handler.QueryResponseHandler)
 			case CommandStatementPrepare:
 				handler.protocolState.SetPendingParse(queryObj)
@@ -373,11 +443,16 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 			censorSpan.End()
 			break
 		case CommandStatementExecute:
-			if err = handler.handleStatementExecute(ctx, packet); err != nil {
+			encryptorStart := time.Now()
+			query, err := handler.handleStatementExecute(ctx, packet)
+			if err != nil {
 				errCh <- base.NewClientProxyError(err)
 				return
 			}
 
+			handler.dataAccess = handler.dataAccessAudit.NewRecord(ctx)
+			handler.queryStatsRecord = handler.queryStats.NewRecord(ctx, query)
+			handler.queryStatsRecord.OnRequestProcessed(base.QueryTimings{Encryptor: time.Since(encryptorStart)})
 			handler.setQueryHandler(handler.QueryResponseHandler)
 			break
 		case CommandStatementClose, CommandStatementSendLongData:
@@ -388,16 +463,19 @@ func (handler *Handler) ProxyClientConnection(ctx context.Context, errCh chan<-
 		default:
 			clientLog.Debugf("Command %d not supported now", cmd)
 		}
+		writeStart := time.Now()
 		if _, err := handler.dbConnection.Write(packet.Dump()); err != nil {
 			clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).
 				Debugln("Can't write send packet to db")
 			errCh <- base.NewClientProxyError(err)
 			return
 		}
+		base.ObserveStageDuration(base.DecryptionDBMysql, base.StageNetworkWrite, writeStart)
 	}
 }
 
-func (handler *Handler) handleStatementExecute(ctx context.Context, packet *Packet) error {
+// handleStatementExecute encrypts parameters of executed prepared statement and returns its query text
+func (handler *Handler) handleStatementExecute(ctx context.Context, packet *Packet) (string, error) {
 	stmtID := binary.LittleEndian.Uint32(packet.GetData()[1:])
 
 	log := handler.logger.WithField("proxy", "client").WithField("statement", stmtID)
@@ -406,46 +484,56 @@ func (handler *Handler) handleStatementExecute(ctx context.Context, packet *Pack
 	statement, err := handler.registry.StatementByID(strconv.FormatUint(uint64(stmtID), 10))
 	if err != nil {
 		log.WithError(err).Error("Can't find prepared statement in registry")
-		return nil
+		return "", nil
 	}
 
 	// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_execute.html
 	// we expect list of parameters if the paramsNum > 0
 	if paramsNum := statement.ParamsNum(); paramsNum > 0 {
+		parseStart := time.Now()
 		parameters, err := packet.GetBindParameters(paramsNum)
+		base.ObserveStageDuration(base.DecryptionDBMysql, base.StagePacketParse, parseStart)
 		if err != nil {
 			log.WithError(err).Error("Can't parse OnBind parameters")
-			return err
+			return "", err
 		}
 
+		rewriteStart := time.Now()
 		newParameters, changed, err := handler.queryObserverManager.OnBind(ctx, statement.Query(), parameters)
+		base.ObserveStageDuration(base.DecryptionDBMysql, base.StageQueryRewrite, rewriteStart)
 		if err != nil {
 			// Security: here we should interrupt proxying in case of any keys read related errors
 			// in other cases we just stop the processing to let db protocol handle the error.
 			if filesystem.IsKeyReadError(err) {
-				return err
+				return "", err
 			}
 
 			log.WithError(err).Error("Failed to handle Bind packet")
-			return nil
+			return statement.QueryText(), nil
 		}
 
 		// Finally, if the parameter values have been changed, update the packet.
 		if changed {
 			if err := packet.SetParameters(newParameters); err != nil {
 				log.WithError(err).Error("Failed to update Bind packet")
-				return err
+				return "", err
 			}
 		}
 	}
 
-	return nil
+	return statement.QueryText(), nil
 }
 
 func (handler *Handler) processTextDataRow(ctx context.Context, rowData []byte, fields []*ColumnDescription) (output []byte, err error) {
 	var pos int
+	// processed row usually has size close to original, so allocate it once
+	output = make([]byte, 0, len(rowData))
 	var fieldLogger *logrus.Entry
 	handler.logger.Debugln("Process data rows in text protocol")
+	// containers of all columns of the row share rotated keys fetched from keystore once
+	keysBatch := keystore.NewDecryptionKeysBatch()
+	defer keysBatch.Close()
+	ctx = base.SetDecryptionKeysBatchToContext(ctx, keysBatch)
 	for i := range fields {
 		fieldLogger = handler.logger.WithField("field_index", i)
 		value, n, err := base_mysql.LengthEncodedString(rowData[pos:])
@@ -500,7 +588,13 @@ func (handler *Handler) processBinaryDataRow(ctx context.Context, rowData []byte
 	// 7 + 2 offset from docs
 	pos = 1 + ((len(fields) + 7 + 2) >> 3)
 	nullBitmap := rowData[1:pos]
+	// processed row usually has size close to original, so allocate it once
+	output = make([]byte, 0, len(rowData))
 	output = append(output, rowData[:pos]...)
+	// containers of all columns of the row share rotated keys fetched from keystore once
+	keysBatch := keystore.NewDecryptionKeysBatch()
+	defer keysBatch.Close()
+	ctx = base.SetDecryptionKeysBatchToContext(ctx, keysBatch)
 
 	for i := range fields {
 		// https://dev.mysql.com/doc/internals/en/null-bitmap.html
@@ -530,8 +624,13 @@ func (handler *Handler) processBinaryDataRow(ctx context.Context, rowData []byte
 			fields[i].Type = fields[i].originType
 		}
 
-		output = append(output, value...)
 		pos += n
+		if base.IsNullValueFromContext(decrCtx) {
+			// NULL values are not included into the row, only marked in NULL-bitmap
+			output[1+(i+2)/8] |= 1 << (uint(i+2) % 8)
+			continue
+		}
+		output = append(output, value...)
 	}
 	return output, nil
 }
@@ -607,6 +706,16 @@ func (handler *Handler) ResetStatementResponseHandler(ctx context.Context, packe
 // QueryResponseHandler parses data from database response
 func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet, dbConnection, clientConnection net.Conn) (err error) {
 	handler.resetQueryHandler()
+	handler.latencyBudget.Reset()
+	dataAccess := handler.dataAccess
+	handler.dataAccess = nil
+	queryStats := handler.queryStatsRecord
+	handler.queryStatsRecord = nil
+	verdict := logging.DataAccessVerdictFailed
+	defer func() {
+		dataAccess.Finish(verdict)
+		queryStats.Finish()
+	}()
 	// read fields
 	var fields []*ColumnDescription
 	var binaryFieldIndexes []int
@@ -614,6 +723,14 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 	// https://dev.mysql.com/doc/internals/en/com-query-response.html#text-resultset
 	fieldCount := int(packet.GetData()[0])
 	output := []Dumper{packet}
+	defer func() {
+		// data rows are read into buffers from pool, return them back after sending to the client
+		for _, dumper := range output {
+			if row, ok := dumper.(*Packet); ok {
+				row.Release()
+			}
+		}
+	}()
 	if fieldCount != ErrPacket && fieldCount > 0 {
 		handler.logger.Debugln("Read column descriptions")
 		for i := 0; ; i++ {
@@ -635,13 +752,21 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 				}
 			}
 			handler.logger.WithField("column_index", i).Debugln("Parse field")
+			parseStart := time.Now()
 			field, err := ParseResultField(fieldPacket)
+			base.ObserveStageDuration(base.DecryptionDBMysql, base.StagePacketParse, parseStart)
 			if err != nil {
 				handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).WithError(err).Errorln("Can't parse result field")
 				return err
 			}
 			// updating field type according to DataType provided in schemaStore
 			updateFieldEncodedType(field, handler.setting.TableSchemaStore())
+			if dataAccess != nil {
+				if tableSchema := handler.setting.TableSchemaStore().GetTableSchema(string(field.Table)); tableSchema != nil &&
+					tableSchema.GetColumnEncryptionSettings(string(field.Name)) != nil {
+					dataAccess.OnColumn(string(field.Table), string(field.Name))
+				}
+			}
 
 			if field.Type.IsBinaryType() {
 				handler.logger.WithField("column_index", i).Debugln("Binary field")
@@ -657,7 +782,7 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 		handler.logger.Debugln("Read data rows")
 		if handler.isPreparedStatementResult() {
 			for {
-				fieldDataPacket, err := ReadPacket(dbConnection)
+				fieldDataPacket, err := ReadPooledPacket(dbConnection)
 				if err != nil {
 					handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).WithError(err).Debugln("Can't read data packet")
 					return err
@@ -666,7 +791,9 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 				if fieldDataPacket.data[0] == EOFPacket {
 					break
 				}
-				newData, err := handler.processBinaryDataRow(ctx, fieldDataPacket.GetData(), fields)
+				dataAccess.OnRow()
+				rowStart := time.Now()
+				newData, err := handler.processBinaryDataRow(handler.latencyBudget.OnRow(ctx, len(fields)), fieldDataPacket.GetData(), fields)
 				if err != nil {
 					handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
 						Debugln("Can't process binary data row")
@@ -674,6 +801,7 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 				}
 				handler.logger.WithFields(logrus.Fields{"oldLength": fieldDataPacket.GetPacketPayloadLength(), "newLength": len(newData)}).Debugln("Update row data")
 				fieldDataPacket.SetData(newData)
+				queryStats.OnRow(len(newData), time.Since(rowStart))
 			}
 		} else {
 			var dataLog *logrus.Entry
@@ -681,7 +809,7 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 			for i := 0; ; i++ {
 				dataLog = handler.logger.WithField("data_row_index", i)
 				dataLog.Debugln("Read data row")
-				fieldDataPacket, err := ReadPacket(dbConnection)
+				fieldDataPacket, err := ReadPooledPacket(dbConnection)
 				if err != nil {
 					handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).WithError(err).Debugln("Can't read data packet")
 					return err
@@ -691,12 +819,15 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 					dataLog.Debugln("Empty result set")
 					break
 				}
+				dataAccess.OnRow()
 				// skip if no binary fields and nothing to decrypt
 				if len(fields) == 0 {
+					queryStats.OnRow(len(fieldDataPacket.GetData()), 0)
 					continue
 				}
 				dataLog.Debugln("Process data text row")
-				newData, err := handler.processTextDataRow(ctx, fieldDataPacket.GetData(), fields)
+				rowStart := time.Now()
+				newData, err := handler.processTextDataRow(handler.latencyBudget.OnRow(ctx, len(fields)), fieldDataPacket.GetData(), fields)
 				if err != nil {
 					dataLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
 						Debugln("Can't process text data row")
@@ -704,13 +835,18 @@ func (handler *Handler) QueryResponseHandler(ctx context.Context, packet *Packet
 				}
 				dataLog.WithFields(logrus.Fields{"oldLength": fieldDataPacket.GetPacketPayloadLength(), "newLength": len(newData)}).Debugln("Update row data")
 				fieldDataPacket.SetData(newData)
+				queryStats.OnRow(len(newData), time.Since(rowStart))
 			}
 		}
 
 	}
+	if fieldCount != ErrPacket {
+		verdict = logging.DataAccessVerdictAllowed
+	}
 
 	// proxy output
 	handler.logger.Debugln("Proxy output")
+	defer base.ObserveStageDuration(base.DecryptionDBMysql, base.StageNetworkWrite, time.Now())
 	for _, dumper := range output {
 		if _, err := clientConnection.Write(dumper.Dump()); err != nil {
 			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).
@@ -766,6 +902,9 @@ func (handler *Handler) ProxyDatabaseConnection(ctx context.Context, errCh chan<
 	serverLog.Debugln("Start proxy db responses")
 	var state databaseHandlerState = stateFirstPacket
 	var responseHandler ResponseHandler
+	sessionTimerCtx, stopSessionTimer := context.WithCancel(ctx)
+	defer stopSessionTimer()
+	go handler.sessionTimer.Run(sessionTimerCtx)
 	// use pointers to function where should be stored some function that should be called if code return error and interrupt loop
 	// default value empty func to avoid != nil check
 	var packetSpanEndFunc = func() {}
@@ -809,6 +948,9 @@ func (handler *Handler) ProxyDatabaseConnection(ctx context.Context, errCh chan<
 			return
 		}
 
+		// responses don't have end marker common for all commands, so each packet resets idle time to not interrupt
+		// long results
+		handler.sessionTimer.OnResponseFinished()
 		_, packetSpan := trace.StartSpan(ctx, "ProxyDatabaseConnectionLoop")
 		packetSpanEndFunc = packetSpan.End
 
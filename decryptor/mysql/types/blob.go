@@ -22,7 +22,7 @@ func (t *BlobDataTypeEncoder) Encode(ctx context.Context, data []byte, format ty
 		ctx, value, err := t.EncodeOnFail(ctx, format)
 		if err != nil {
 			return ctx, nil, err
-		} else if value != nil {
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
 			return ctx, value, nil
 		}
 		return ctx, nil, base_mysql.ErrConvertToDataType
@@ -51,6 +51,9 @@ func (t *BlobDataTypeEncoder) EncodeOnFail(ctx context.Context, format type_awar
 		}
 		return t.encodeDefault(ctx, []byte(*strValue), format)
 
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
 	case common.ResponseOnFailError:
 		return nil, nil, base.NewEncodingError(format.GetColumnName())
 	}
@@ -21,7 +21,7 @@ func (t *StringDataTypeEncoder) Encode(ctx context.Context, data []byte, format
 		ctx, value, err := t.EncodeOnFail(ctx, format)
 		if err != nil {
 			return ctx, nil, err
-		} else if value != nil {
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
 			return ctx, value, nil
 		}
 		return ctx, nil, base_mysql.ErrConvertToDataType
@@ -50,6 +50,9 @@ func (t *StringDataTypeEncoder) EncodeOnFail(ctx context.Context, format type_aw
 		}
 		return t.encodeDefault(ctx, []byte(*strValue), format)
 
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
 	case common.ResponseOnFailError:
 		return nil, nil, base.NewEncodingError(format.GetColumnName())
 	}
@@ -13,7 +13,7 @@ import (
 	"testing"
 
 	"github.com/cossacklabs/acra/decryptor/base"
-	_ "github.com/cossacklabs/acra/decryptor/postgresql/types"
+	"github.com/cossacklabs/acra/decryptor/postgresql/types"
 	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/encryptor/config"
 	common2 "github.com/cossacklabs/acra/encryptor/config/common"
@@ -1024,3 +1024,147 @@ func TestValidateDefaultValue(t *testing.T) {
 		})
 	}
 }
+
+func TestNullOnFail(t *testing.T) {
+	type testcase struct {
+		input      string
+		dataType   string
+		dataTypeID uint32
+	}
+
+	testcases := []testcase{
+		// we don't mark context as decrypted, to trigger
+		// `OnFail` path
+		{"string", "str", pgtype.TextOID},
+		{"bytes", "bytes", pgtype.ByteaOID},
+		{"invalid_int_32", "int32", pgtype.Int4OID},
+		{"invalid_int_64", "int64", pgtype.Int8OID},
+	}
+
+	encoder, err := NewPgSQLDataEncoderProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tcase := range testcases {
+		testSetting := config.BasicColumnEncryptionSetting{
+			DataType:       tcase.dataType,
+			DataTypeID:     tcase.dataTypeID,
+			ResponseOnFail: common2.ResponseOnFailNull,
+		}
+		ctx := encryptor.NewContextWithEncryptionSetting(context.Background(), &testSetting)
+
+		for _, binaryFormat := range []bool{false, true} {
+			columnInfo := base.NewColumnInfo(0, "", binaryFormat, 4, 0, 0)
+			accessContext := &base.AccessContext{}
+			accessContext.SetColumnInfo(columnInfo)
+			columnCtx := base.SetAccessContextToContext(ctx, accessContext)
+
+			outCtx, _, err := encoder.OnColumn(columnCtx, []byte(tcase.input))
+			if err != nil {
+				t.Fatalf("[%s] %q", tcase.input, err)
+			}
+			if !base.IsNullValueFromContext(outCtx) {
+				t.Fatalf("[%s] expected NULL value, binary format=%t", tcase.input, binaryFormat)
+			}
+
+			// successfully decrypted data should be returned as is
+			outCtx, _, err = encoder.OnColumn(base.MarkDecryptedContext(columnCtx), []byte("123"))
+			if err != nil {
+				t.Fatalf("[%s] %q", tcase.input, err)
+			}
+			if base.IsNullValueFromContext(outCtx) {
+				t.Fatalf("[%s] unexpected NULL value for decrypted data, binary format=%t", tcase.input, binaryFormat)
+			}
+		}
+	}
+}
+
+// TestPgTypeDataTypesFormats checks that decrypted values of types converted with pgtype are returned as is in text
+// format and as valid binary values in binary format
+func TestPgTypeDataTypesFormats(t *testing.T) {
+	type testcase struct {
+		input        string
+		dataTypeID   uint32
+		binaryOutput []byte
+	}
+	testcases := []testcase{
+		{"123.456", pgtype.NumericOID, []byte{0, 2, 0, 0, 0, 0, 0, 3, 0, 0x7b, 0x11, 0xd0}},
+		{"-0.0001", pgtype.NumericOID, []byte{0, 1, 0xff, 0xff, 0x40, 0, 0, 4, 0, 1}},
+		{"NaN", pgtype.NumericOID, []byte{0, 0, 0, 0, 0xc0, 0, 0, 0}},
+		{"2020-01-02", pgtype.DateOID, []byte{0, 0, 0x1c, 0x8a}},
+		{"infinity", pgtype.DateOID, []byte{0x7f, 0xff, 0xff, 0xff}},
+		{"2020-01-02 03:04:05.123456", pgtype.TimestampOID, []byte{0, 2, 0x3e, 0x1e, 0x36, 0xf0, 0xf5, 0x80}},
+		{"2020-01-02 03:04:05.123+03", pgtype.TimestamptzOID, []byte{0, 2, 0x3e, 0x1b, 0xb3, 0x36, 0x07, 0xb8}},
+		{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", pgtype.UUIDOID, []byte{0xa0, 0xee, 0xbc, 0x99, 0x9c, 0x0b, 0x4e, 0xf8,
+			0xbb, 0x6d, 0x6b, 0xb9, 0xbd, 0x38, 0x0a, 0x11}},
+	}
+	encoder, err := NewPgSQLDataEncoderProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := NewPgSQLDataDecoderProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newContext := func(setting config.ColumnEncryptionSetting, binaryFormat bool) context.Context {
+		accessContext := &base.AccessContext{}
+		accessContext.SetColumnInfo(base.NewColumnInfo(0, "", binaryFormat, 4, 0, 0))
+		ctx := base.SetAccessContextToContext(context.Background(), accessContext)
+		return encryptor.NewContextWithEncryptionSetting(ctx, setting)
+	}
+	for _, tcase := range testcases {
+		testSetting := &config.BasicColumnEncryptionSetting{Name: "data", DataTypeID: tcase.dataTypeID}
+		if _, ok := type_awareness.GetPostgreSQLDataTypeIDEncoders()[tcase.dataTypeID]; !ok {
+			t.Fatalf("[%s] Data type %d is not registered", tcase.input, tcase.dataTypeID)
+		}
+
+		_, output, err := encoder.OnColumn(base.MarkDecryptedContext(newContext(testSetting, false)), []byte(tcase.input))
+		if err != nil {
+			t.Fatalf("[%s] %s", tcase.input, err)
+		}
+		if !bytes.Equal(output, []byte(tcase.input)) {
+			t.Fatalf("[%s] Expected text value as is, took %q", tcase.input, output)
+		}
+
+		_, output, err = encoder.OnColumn(base.MarkDecryptedContext(newContext(testSetting, true)), []byte(tcase.input))
+		if err != nil {
+			t.Fatalf("[%s] %s", tcase.input, err)
+		}
+		if !bytes.Equal(output, tcase.binaryOutput) {
+			t.Fatalf("[%s] Expected binary value %x, took %x", tcase.input, tcase.binaryOutput, output)
+		}
+
+		// encrypted values are received from bytea columns as is in binary format
+		_, output, err = decoder.OnColumn(newContext(testSetting, true), tcase.binaryOutput)
+		if err != nil {
+			t.Fatalf("[%s] %s", tcase.input, err)
+		}
+		if !bytes.Equal(output, tcase.binaryOutput) {
+			t.Fatalf("[%s] Expected binary value as is, took %x", tcase.input, output)
+		}
+
+		// bound values in binary format are encrypted in text format
+		value := &pgBoundValue{data: tcase.binaryOutput, format: base.BinaryFormat}
+		output, err = value.GetData(testSetting)
+		if err != nil {
+			t.Fatalf("[%s] %s", tcase.input, err)
+		}
+		if expected, _ := types.DecodeFromBinary(tcase.dataTypeID, tcase.binaryOutput); !bytes.Equal(output, expected) {
+			t.Fatalf("[%s] Expected text bound value %q, took %q", tcase.input, expected, output)
+		}
+	}
+
+	// decrypted value which can't be represented in binary format returns error instead of malformed value
+	testSetting := &config.BasicColumnEncryptionSetting{Name: "data", DataTypeID: pgtype.UUIDOID}
+	_, _, err = encoder.OnColumn(base.MarkDecryptedContext(newContext(testSetting, true)), []byte("not uuid"))
+	var encodingError *base.EncodingError
+	if !errors.As(err, &encodingError) {
+		t.Fatalf("Expected EncodingError, took %v", err)
+	}
+	// ciphertext is returned as is if it wasn't decrypted
+	_, output, err := encoder.OnColumn(newContext(testSetting, true), []byte("ciphertext"))
+	if err != nil || !bytes.Equal(output, []byte("ciphertext")) {
+		t.Fatalf("Expected ciphertext as is, took %q, %v", output, err)
+	}
+}
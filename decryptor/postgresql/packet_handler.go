@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
@@ -21,6 +23,10 @@ type PacketHandler struct {
 	messageType          [1]byte
 	descriptionLengthBuf []byte
 	descriptionBuf       *bytes.Buffer
+	// headerBuf used to read message type, length and startup tag of packets without allocations
+	headerBuf [8]byte
+	// columnsBuf stores columns of current data row and reused by next rows
+	columnsBuf []ColumnData
 
 	columnCount     int
 	dataLength      int
@@ -53,7 +59,7 @@ func NewDbSidePacketHandler(reader io.Reader, writer *bufio.Writer, logger *logr
 // newPacketHandlerWithLogger return new PacketHandler with specific logger
 func newPacketHandlerWithLogger(reader io.Reader, writer *bufio.Writer, logger *logrus.Entry) (*PacketHandler, error) {
 	return &PacketHandler{
-		descriptionBuf:       bytes.NewBuffer(make([]byte, 0, OutputDefaultSize)),
+		descriptionBuf:       base.GetPacketBuffer(),
 		descriptionLengthBuf: make([]byte, 4),
 		reader:               reader,
 		writer:               writer,
@@ -99,18 +105,19 @@ func (packet *PacketHandler) updateDataFromColumns(queryDataItems []*encryptor.Q
 	}
 }
 
-// sendPacket marshal packet and send it with writer
+// sendPacket send packet with writer. Parts of packet are written directly to the buffered writer without
+// marshaling into intermediate buffer, the same way as Marshal does
 func (packet *PacketHandler) sendPacket() error {
-	data, err := packet.Marshal()
-	if err != nil {
-		packet.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCodingCantSerializePostgresqlPacket).WithError(err).Errorln("Can't marshal packet")
-		return err
-	}
-	// anyway try to write data that was marshaled even if not full
-
-	if _, err := packet.writer.Write(data); err != nil {
-		packet.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).WithError(err).Warningln("Can't dump marshaled packet")
-		return err
+	defer base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageNetworkWrite, time.Now())
+	parts := [3][]byte{packet.messageType[:], packet.descriptionLengthBuf, packet.descriptionBuf.Bytes()}
+	if packet.messageType[0] == WithoutMessageType {
+		parts[0] = nil
+	}
+	for _, part := range parts {
+		if _, err := packet.writer.Write(part); err != nil {
+			packet.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).WithError(err).Warningln("Can't write packet")
+			return err
+		}
 	}
 	if err := packet.writer.Flush(); err != nil {
 		packet.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkFlush).WithError(err).Warningln("Can't flush writer")
@@ -138,9 +145,12 @@ type ColumnData struct {
 	data      []byte
 	changed   bool
 	isNull    bool
+	// pooled is buffer taken from pool for data of column, it's returned back when PacketHandler reads next packet
+	pooled *[]byte
 }
 
-// GetData return raw data, decoded from db format to binary
+// GetData return raw data, decoded from db format to binary. Data is stored in buffer from pool and is valid only
+// until PacketHandler reads next packet or is released, so callers which keep it longer should copy it
 func (column *ColumnData) GetData() []byte {
 	return column.data
 }
@@ -186,21 +196,31 @@ func (column *ColumnData) readData(reader io.Reader, format base.BoundValueForma
 		column.data = nil
 		return nil
 	}
-	data := make([]byte, length)
+	pooled := base.GetBytes(length)
+	data := *pooled
 
 	// first 4 bytes is packet length and then 2 bytes of column count
 	// https://www.postgresql.org/docs/9.3/static/protocol-message-formats.html
 	n, err := io.ReadFull(reader, data)
 	if err != nil {
+		base.PutBytes(pooled)
 		return err
 	}
 	column.data = data
+	column.pooled = pooled
 
 	// ignore utils.ErrDecodeOctalString
 	err = nil
 	return base.CheckReadWrite(n, length, err)
 }
 
+// release returns data buffer of column to pool, column's data shouldn't be used after that
+func (column *ColumnData) release() {
+	base.PutBytes(column.pooled)
+	column.pooled = nil
+	column.data = nil
+}
+
 // SetData to column and update LengthBuf with new size
 func (column *ColumnData) SetData(newData []byte) {
 	column.changed = true
@@ -208,6 +228,15 @@ func (column *ColumnData) SetData(newData []byte) {
 	binary.BigEndian.PutUint32(column.LengthBuf[:], uint32(len(column.data)))
 }
 
+// SetNull replace column value with NULL and set NullColumnValue into LengthBuf
+func (column *ColumnData) SetNull() {
+	column.changed = true
+	column.isNull = true
+	column.data = nil
+	nullLength := NullColumnValue
+	binary.BigEndian.PutUint32(column.LengthBuf[:], uint32(nullLength))
+}
+
 // SetDataLength set into LengthBuf
 func (column *ColumnData) SetDataLength(length uint32) {
 	binary.BigEndian.PutUint32(column.LengthBuf[:], length)
@@ -221,9 +250,14 @@ func (packet *PacketHandler) parseColumns(columnFormats []uint16) error {
 		return nil
 	}
 	columnReader := bytes.NewReader(packet.descriptionBuf.Bytes()[2:])
-	var columns []*ColumnData
+	packet.releaseColumns()
+	if cap(packet.columnsBuf) < packet.columnCount {
+		packet.columnsBuf = make([]ColumnData, packet.columnCount)
+	}
+	packet.columnsBuf = packet.columnsBuf[:packet.columnCount]
 	for i := 0; i < packet.columnCount; i++ {
-		column := &ColumnData{}
+		column := &packet.columnsBuf[i]
+		*column = ColumnData{}
 		if err := column.ReadLength(columnReader); err != nil {
 			return err
 		}
@@ -234,21 +268,36 @@ func (packet *PacketHandler) parseColumns(columnFormats []uint16) error {
 		if err := column.readData(columnReader, format); err != nil {
 			return err
 		}
-		columns = append(columns, column)
+		packet.Columns = append(packet.Columns, column)
 	}
-	packet.Columns = columns
 	return nil
 }
 
-// Reset state of handler
+// releaseColumns returns data of columns of previous data row to pool
+func (packet *PacketHandler) releaseColumns() {
+	for _, column := range packet.Columns {
+		column.release()
+	}
+	packet.Columns = packet.Columns[:0]
+}
+
+// Reset state of handler. Data of columns of previous packet is returned to pool and shouldn't be used after that
 func (packet *PacketHandler) Reset() {
 	packet.descriptionBuf.Reset()
 	packet.dataLength = 0
 	packet.columnCount = 0
-	packet.Columns = nil
+	packet.releaseColumns()
 	packet.messageType[0] = 0
 }
 
+// Release returns buffers of handler to pools. Handler shouldn't be used after that
+func (packet *PacketHandler) Release() {
+	packet.releaseColumns()
+	packet.columnsBuf = nil
+	base.PutPacketBuffer(packet.descriptionBuf)
+	packet.descriptionBuf = nil
+}
+
 func (packet *PacketHandler) descriptionBufferCopy() []byte {
 	buffer := make([]byte, packet.descriptionBuf.Len())
 	copy(buffer, packet.descriptionBuf.Bytes())
@@ -290,6 +339,17 @@ func (packet *PacketHandler) IsReadyForQuery() bool {
 	return packet.messageType[0] == ReadyForQueryMessageType
 }
 
+// IsIdleReadyForQuery returns true if packet is ReadyForQuery with idle transaction status, when connection is
+// not in transaction block
+func (packet *PacketHandler) IsIdleReadyForQuery() bool {
+	return packet.IsReadyForQuery() && bytes.Equal(packet.descriptionBuf.Bytes(), []byte{'I'})
+}
+
+// IsSync returns true if client's packet has Sync type
+func (packet *PacketHandler) IsSync() bool {
+	return packet.messageType[0] == SyncMessageType
+}
+
 // IsSimpleQuery return true if packet has SimpleQuery type
 func (packet *PacketHandler) IsSimpleQuery() bool {
 	return packet.messageType[0] == QueryMessageType
@@ -330,6 +390,11 @@ func (packet *PacketHandler) IsExecute() bool {
 	return packet.messageType[0] == ExecuteMessageType
 }
 
+// IsDescribe returns true if client's packet has Describe type
+func (packet *PacketHandler) IsDescribe() bool {
+	return packet.messageType[0] == DescribeMessageType
+}
+
 // IsErrorResponse returns True if it is ErrorResponse from the database
 func (packet *PacketHandler) IsErrorResponse() bool {
 	return packet.messageType[0] == ErrorResponseType
@@ -383,6 +448,16 @@ func (packet *PacketHandler) GetExecuteData() (*ExecutePacket, error) {
 	return execute, nil
 }
 
+// GetDescribeData returns parsed Describe packet.
+// Use this only if IsDescribe() is true.
+func (packet *PacketHandler) GetDescribeData() (*pgproto3.Describe, error) {
+	describe := &pgproto3.Describe{}
+	if err := describe.Decode(packet.descriptionBufferCopy()); err != nil {
+		return nil, err
+	}
+	return describe, nil
+}
+
 // GetRowDescriptionData return parsed RowDescription packet
 func (packet *PacketHandler) GetRowDescriptionData() (*pgproto3.RowDescription, error) {
 	rowDescription := &pgproto3.RowDescription{}
@@ -428,11 +503,14 @@ func (packet *PacketHandler) ReplaceQuery(newQuery string) {
 // ReplaceBind update Bind packet with new data, update packet length.
 func (packet *PacketHandler) ReplaceBind(bindPacket *BindPacket) error {
 	packet.logger.Debugln("ReplaceBind for prepared statement")
-	buffer := new(bytes.Buffer)
+	buffer := base.GetPacketBuffer()
 	n, err := bindPacket.MarshalInto(buffer)
 	if err != nil {
+		// keep original packet unchanged
+		base.PutPacketBuffer(buffer)
 		return err
 	}
+	base.PutPacketBuffer(packet.descriptionBuf)
 	packet.descriptionBuf = buffer
 	packet.updatePacketLength(n)
 	return nil
@@ -581,7 +659,7 @@ func (packet *PacketHandler) ReadClientPacket() error {
 func (packet *PacketHandler) readStartupPacket() error {
 	packet.Reset()
 	// 8 bytes because all startup messages has at least 8 bytes
-	packetBuf := make([]byte, 8)
+	packetBuf := packet.headerBuf[:8]
 	packet.messageType[0] = WithoutMessageType
 
 	n, err := io.ReadFull(packet.reader, packetBuf)
@@ -625,7 +703,7 @@ func (packet *PacketHandler) readStartupPacket() error {
 func (packet *PacketHandler) readGeneralPacket() error {
 	packet.Reset()
 	// 1-byte id + 4-byte length
-	packetBuf := make([]byte, 5)
+	packetBuf := packet.headerBuf[:5]
 
 	n, err := io.ReadFull(packet.reader, packetBuf[:5])
 	if err := base.CheckReadWrite(n, 5, err); err != nil {
@@ -695,3 +773,52 @@ func (packet *PacketHandler) SetStarted() {
 func (packet *PacketHandler) IsAlreadyStarted() bool {
 	return packet.started
 }
+
+// IsStartupMessage returns true if packet is StartupMessage
+func (packet *PacketHandler) IsStartupMessage() bool {
+	return packet.messageType[0] == WithoutMessageType && bytes.HasPrefix(packet.descriptionBuf.Bytes(), StartupRequest)
+}
+
+// GetStartupParameters returns parameters of StartupMessage. StartupMessage has next structure:
+// int32 (length of packet) + int32 (protocol version) + pairs of NullTerminatedString (name and value) + terminator
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+func (packet *PacketHandler) GetStartupParameters() (map[string]string, error) {
+	if !packet.IsStartupMessage() {
+		return nil, ErrUnsupportedPacketType
+	}
+	data := packet.descriptionBuf.Bytes()[len(StartupRequest):]
+	parameters := make(map[string]string)
+	for {
+		nameEnd := bytes.Index(data, terminator)
+		if nameEnd == -1 {
+			return nil, ErrTerminatorNotFound
+		}
+		// empty name is the terminator of parameters list
+		if nameEnd == 0 {
+			return parameters, nil
+		}
+		name := string(data[:nameEnd])
+		data = data[nameEnd+1:]
+		valueEnd := bytes.Index(data, terminator)
+		if valueEnd == -1 {
+			return nil, ErrTerminatorNotFound
+		}
+		parameters[name] = string(data[:valueEnd])
+		data = data[valueEnd+1:]
+	}
+}
+
+// IsReplicationStartup returns true if startup parameters request streaming replication mode: physical with
+// boolean true value or logical with "database" value
+// https://www.postgresql.org/docs/current/protocol-replication.html
+func IsReplicationStartup(parameters map[string]string) bool {
+	value, ok := parameters["replication"]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "false", "off", "no", "0":
+		return false
+	}
+	return true
+}
@@ -22,9 +22,12 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/cmd/acra-server/common"
 	"github.com/cossacklabs/acra/sqlparser"
+	"io"
+	"reflect"
 	"testing"
 
 	"github.com/cossacklabs/acra/decryptor/base"
@@ -176,6 +179,61 @@ func TestClientStartupMessageWithData(t *testing.T) {
 	}
 }
 
+// testStartupMessage returns StartupMessage with parameters passed as pairs of name and value
+func testStartupMessage(parameters ...string) []byte {
+	payload := append([]byte{}, StartupRequest...)
+	for _, parameter := range parameters {
+		payload = append(append(payload, parameter...), 0)
+	}
+	payload = append(payload, 0)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(payload)+4))
+	return append(length, payload...)
+}
+
+func TestGetStartupParameters(t *testing.T) {
+	testcases := []struct {
+		packet      []byte
+		parameters  map[string]string
+		replication bool
+		err         error
+	}{
+		{testStartupMessage("user", "test", "database", "test"), map[string]string{"user": "test", "database": "test"}, false, nil},
+		{testStartupMessage("user", "test", "replication", "true"), map[string]string{"user": "test", "replication": "true"}, true, nil},
+		{testStartupMessage("user", "test", "replication", "database"), map[string]string{"user": "test", "replication": "database"}, true, nil},
+		{testStartupMessage("replication", "ON"), map[string]string{"replication": "ON"}, true, nil},
+		{testStartupMessage("replication", "off"), map[string]string{"replication": "off"}, false, nil},
+		{testStartupMessage("replication", "0"), map[string]string{"replication": "0"}, false, nil},
+		{testStartupMessage(), map[string]string{}, false, nil},
+		// value without terminator
+		{[]byte{0, 0, 0, 16, 0, 3, 0, 0, 'u', 's', 'e', 'r', 0, 't', 'e', 's'}, nil, false, ErrTerminatorNotFound},
+		// SSLRequest
+		{SSLRequestHeader, nil, false, ErrUnsupportedPacketType},
+	}
+	for i, tcase := range testcases {
+		packetHandler, err := NewClientSidePacketHandler(bytes.NewReader(tcase.packet), nil, logrus.NewEntry(logrus.StandardLogger()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := packetHandler.ReadClientPacket(); err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		parameters, err := packetHandler.GetStartupParameters()
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(parameters, tcase.parameters) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.parameters, parameters)
+		}
+		if IsReplicationStartup(parameters) != tcase.replication {
+			t.Fatalf("[%d] Expected replication=%t", i, tcase.replication)
+		}
+	}
+}
+
 func TestColumnData_readData(t *testing.T) {
 	type testCase struct {
 		data         []byte
@@ -241,6 +299,43 @@ func TestParseColumns(t *testing.T) {
 	}
 }
 
+func TestUpdateDataFromColumnsWithNull(t *testing.T) {
+	buffer := make([]byte, 2+4+4+4+3)
+	// column count, 2 columns
+	binary.BigEndian.PutUint16(buffer[:2], 2)
+	binary.BigEndian.PutUint32(buffer[2:6], 4)
+	copy(buffer[6:10], "\\111")
+	binary.BigEndian.PutUint32(buffer[10:14], 3)
+	copy(buffer[14:], "abc")
+
+	handler := &PacketHandler{descriptionBuf: bytes.NewBuffer(buffer), descriptionLengthBuf: make([]byte, 4), logger: logrus.NewEntry(logrus.New())}
+	if err := handler.parseColumns(nil); err != nil {
+		t.Fatal(err)
+	}
+	handler.Columns[0].SetNull()
+	handler.updateDataFromColumns(nil)
+
+	// NULL column has only length equal to -1 without data
+	expected := make([]byte, 2+4+4+3)
+	binary.BigEndian.PutUint16(expected[:2], 2)
+	binary.BigEndian.PutUint32(expected[2:6], 0xffffffff)
+	binary.BigEndian.PutUint32(expected[6:10], 3)
+	copy(expected[10:], "abc")
+	if !bytes.Equal(handler.descriptionBuf.Bytes(), expected) {
+		t.Fatalf("Incorrect data row, %v != %v", handler.descriptionBuf.Bytes(), expected)
+	}
+	if length := binary.BigEndian.Uint32(handler.descriptionLengthBuf[:]); int(length) != len(expected)+DataRowLengthBufSize {
+		t.Fatalf("Incorrect packet length %d", length)
+	}
+
+	if err := handler.parseColumns(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !handler.Columns[0].IsNull() || handler.Columns[1].IsNull() {
+		t.Fatal("Expected only first NULL column")
+	}
+}
+
 func TestSequenceOfParsePackets(t *testing.T) {
 	// Regression test for T2663 && https://github.com/cossacklabs/acra/issues/575
 	// used a dump from wireshark and java app with request + response
@@ -306,3 +401,148 @@ func TestSequenceOfParsePackets(t *testing.T) {
 	}
 
 }
+
+type testActivityObserver struct {
+	idle bool
+}
+
+func (observer *testActivityObserver) OnSessionBusy() { observer.idle = false }
+func (observer *testActivityObserver) OnSessionIdle() { observer.idle = true }
+
+func TestSessionActivityNotifications(t *testing.T) {
+	readPacket := func(data []byte, client bool) *PacketHandler {
+		writer := bufio.NewWriter(&bytes.Buffer{})
+		var packet *PacketHandler
+		var err error
+		if client {
+			packet, err = NewClientSidePacketHandler(bytes.NewReader(data), writer, logrus.NewEntry(logrus.StandardLogger()))
+			packet.started = true
+		} else {
+			packet, err = NewDbSidePacketHandler(bytes.NewReader(data), writer, logrus.NewEntry(logrus.StandardLogger()))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client {
+			err = packet.ReadClientPacket()
+		} else {
+			err = packet.ReadPacket()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+	syncPacket := readPacket([]byte{'S', 0, 0, 0, 4}, true)
+	query := readPacket([]byte{'Q', 0, 0, 0, 5, 0}, true)
+	idle := readPacket([]byte{'Z', 0, 0, 0, 5, 'I'}, false)
+	inTransaction := readPacket([]byte{'Z', 0, 0, 0, 5, 'T'}, false)
+	if !syncPacket.IsSync() || !query.IsSimpleQuery() || !idle.IsIdleReadyForQuery() || inTransaction.IsIdleReadyForQuery() {
+		t.Fatal("Unexpected types of packets")
+	}
+
+	proxy := &PgProxy{}
+	observer := &testActivityObserver{}
+	// ReadyForQuery after startup
+	proxy.onReadyForQuery(idle, observer)
+	if !observer.idle {
+		t.Fatal("Expected idle session after startup")
+	}
+	proxy.onClientRequest(query, observer)
+	proxy.onClientRequest(syncPacket, observer)
+	if observer.idle {
+		t.Fatal("Expected busy session after request")
+	}
+	proxy.onReadyForQuery(idle, observer)
+	if observer.idle {
+		t.Fatal("Expected busy session with pending Sync")
+	}
+	proxy.onReadyForQuery(idle, observer)
+	if !observer.idle {
+		t.Fatal("Expected idle session after responses to all requests")
+	}
+	proxy.onClientRequest(query, observer)
+	proxy.onReadyForQuery(inTransaction, observer)
+	if observer.idle {
+		t.Fatal("Expected busy session in transaction block")
+	}
+}
+
+// newDataRowPacket returns DataRow packet with columns of specified values
+func newDataRowPacket(values ...[]byte) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(len(values)))
+	for _, value := range values {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(value)))
+		payload = append(payload, value...)
+	}
+	packet := []byte{DataRowMessageType}
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(payload)+DataRowLengthBufSize))
+	return append(packet, payload...)
+}
+
+func TestPacketHandlerReusesColumns(t *testing.T) {
+	first := newDataRowPacket([]byte("first"), []byte("row"))
+	second := newDataRowPacket([]byte("second"))
+	output := &bytes.Buffer{}
+	packetHandler, err := NewDbSidePacketHandler(bytes.NewReader(append(first, second...)), bufio.NewWriter(output), logrus.NewEntry(logrus.StandardLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packetHandler.Release()
+
+	for _, expected := range [][][]byte{{[]byte("first"), []byte("row")}, {[]byte("second")}} {
+		packetHandler.Reset()
+		if err := packetHandler.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+		if err := packetHandler.parseColumns(nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(packetHandler.Columns) != len(expected) {
+			t.Fatalf("Expected %d columns, took %d", len(expected), len(packetHandler.Columns))
+		}
+		for i, column := range packetHandler.Columns {
+			if !bytes.Equal(column.GetData(), expected[i]) || column.changed {
+				t.Fatalf("Unexpected column %d: %q", i, column.GetData())
+			}
+		}
+		packetHandler.Columns[0].SetData([]byte("new"))
+		packetHandler.updateDataFromColumns(nil)
+		if err := packetHandler.sendPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := append(newDataRowPacket([]byte("new"), []byte("row")), newDataRowPacket([]byte("new"))...)
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Fatalf("Unexpected output %v != %v", output.Bytes(), expected)
+	}
+}
+
+func BenchmarkPacketHandlerDataRow(b *testing.B) {
+	value := bytes.Repeat([]byte{'a'}, 200)
+	packet := newDataRowPacket(value, value, value, value)
+	reader := bytes.NewReader(packet)
+	packetHandler, err := NewDbSidePacketHandler(reader, bufio.NewWriter(io.Discard), logrus.NewEntry(logrus.StandardLogger()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer packetHandler.Release()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(packet)
+		packetHandler.Reset()
+		if err := packetHandler.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+		if err := packetHandler.parseColumns(nil); err != nil {
+			b.Fatal(err)
+		}
+		packetHandler.Columns[0].SetData(value[:100])
+		packetHandler.updateDataFromColumns(nil)
+		if err := packetHandler.sendPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
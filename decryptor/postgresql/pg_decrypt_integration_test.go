@@ -526,3 +526,159 @@ func TestSequenceParsePacketsWithUnnamedPortals(t *testing.T) {
 	assert.Nil(err)
 	assert.NotEqual(integerResult, testData2)
 }
+
+// TestDescribeOnlyFlows emulates how drivers introspect prepared statements with Describe packets without Bind and
+// Execute or describe portals before execution, and verifies that acra-server rewrites OIDs of encrypted columns
+func TestDescribeOnlyFlows(t *testing.T) {
+	const timeout = time.Millisecond * 400
+	const int4OID, byteaOID = 23, 17
+	freePort := getFreePortForListener(t)
+	serverConfig := acra_server.NewDefaultAcraServerConfig(t)
+	clientID := []byte("clientID")
+	serverConfig.SetUseClientIDFromCertificate(false)
+	assert := assert.New(t)
+	assert.Nil(serverConfig.SetStaticClientID(clientID))
+	assert.Nil(serverConfig.GetKeyStore().GenerateClientIDSymmetricKey(clientID))
+	schemaConfig := `schemas:
+  - table: describe_table
+    columns:
+      - id
+      - data
+      - plain
+    encrypted:
+      - column: data
+        data_type: int32
+`
+	schemaStore, err := encryptorConfig.MapTableSchemaStoreFromConfig([]byte(schemaConfig), false)
+	assert.Nil(err)
+	serverConfig.SetTableSchema(schemaStore)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(timeout))
+	tokenStorage, err := storage.NewMemoryTokenStorage()
+	assert.Nil(err)
+	tokenizer, err := pseudonymization.NewPseudoanonymizer(tokenStorage)
+	assert.Nil(err)
+	serverProxyFactory := getProxyFactory(t, serverConfig, tokenizer)
+	serverConfig.SetAcraConnectionString("tcp://localhost:" + strconv.Itoa(freePort))
+	acraServer := acra_server.NewAcraServer(t, serverConfig, serverProxyFactory)
+	go func() {
+		acraServer.Start(ctx)
+	}()
+	defer cancel()
+	defer func() {
+		acraServer.Close()
+	}()
+
+	workingDirectory := tests.GetSourceRootDirectory(t)
+	tlsConfig, err := network.NewTLSConfig("localhost",
+		filepath.Join(workingDirectory, "tests/ssl/ca/ca.crt"),
+		filepath.Join(workingDirectory, "tests/ssl/acra-writer/acra-writer.key"),
+		filepath.Join(workingDirectory, "tests/ssl/acra-writer/acra-writer.crt"),
+		1, nil)
+	assert.Nil(err)
+	dbConfig := tests.GetDatabaseConfig(t)
+	tests.CheckConnection(t, fmt.Sprintf("localhost:%d", freePort))
+
+	// run authenticates, recreates table and runs steps with new connection
+	run := func(t *testing.T, steps ...testutils.Step) {
+		frontendConn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", freePort))
+		assert.Nil(err)
+		assert.Nil(frontendConn.SetDeadline(time.Now().Add(timeout)))
+		t.Cleanup(func() {
+			frontendConn.Close()
+		})
+		frontend := testutils.NewFrontend(frontendConn, frontendConn)
+		t.Cleanup(func() {
+			frontend.Close()
+		})
+		script := testutils.Script{append([]testutils.Step{
+			testutils.NewAuthStep(ctx, tlsConfig, dbConfig.Database, dbConfig.User, dbConfig.Password),
+			testutils.SendMessage(&pgproto3.Query{String: "drop table if exists describe_table; " +
+				"create table describe_table(id serial primary key, data bytea, plain bytea);"}),
+			testutils.NewFlushStep(),
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+		}, append(steps,
+			testutils.SendMessage(&pgproto3.Terminate{}),
+			testutils.NewFlushStep())...)}
+		assert.Nil(script.Run(frontend))
+	}
+
+	t.Run("pgx prepares and describes named statements", func(t *testing.T) {
+		insertDescription := testutils.NewCollectDescriptionStep()
+		selectDescription := testutils.NewCollectDescriptionStep()
+		run(t,
+			testutils.SendMessage(&pgproto3.Parse{Name: "insert", Query: "insert into describe_table (data, plain) values ($1, $2)"}),
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'S', Name: "insert"}),
+			testutils.SendMessage(&pgproto3.Sync{}),
+			testutils.SendMessage(&pgproto3.Parse{Name: "select", Query: "select data, plain from describe_table"}),
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'S', Name: "select"}),
+			testutils.SendMessage(&pgproto3.Sync{}),
+			testutils.NewFlushStep(),
+
+			testutils.ExpectMessage(&pgproto3.ParseComplete{}),
+			insertDescription,
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+			testutils.ExpectMessage(&pgproto3.ParseComplete{}),
+			selectDescription,
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+		)
+		assert.Equal([]uint32{int4OID, byteaOID}, insertDescription.GetParameterOIDs())
+		assert.Nil(insertDescription.GetColumnOIDs())
+		assert.Equal([]uint32{int4OID, byteaOID}, selectDescription.GetColumnOIDs())
+	})
+
+	t.Run("npgsql prepares several statements and describes them in one batch", func(t *testing.T) {
+		plainDescription := testutils.NewCollectDescriptionStep()
+		encryptedDescription := testutils.NewCollectDescriptionStep()
+		reusedDescription := testutils.NewCollectDescriptionStep()
+		run(t,
+			testutils.SendMessage(&pgproto3.Parse{Name: "_p1", Query: "select plain from describe_table"}),
+			testutils.SendMessage(&pgproto3.Parse{Name: "_p2", Query: "select data from describe_table"}),
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'S', Name: "_p1"}),
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'S', Name: "_p2"}),
+			testutils.SendMessage(&pgproto3.Sync{}),
+			testutils.NewFlushStep(),
+
+			testutils.ExpectMessage(&pgproto3.ParseComplete{}),
+			testutils.ExpectMessage(&pgproto3.ParseComplete{}),
+			plainDescription,
+			encryptedDescription,
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+
+			// statement prepared earlier is described again before execution
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'S', Name: "_p2"}),
+			testutils.SendMessage(&pgproto3.Bind{PreparedStatement: "_p2"}),
+			testutils.SendMessage(&pgproto3.Execute{}),
+			testutils.SendMessage(&pgproto3.Sync{}),
+			testutils.NewFlushStep(),
+
+			reusedDescription,
+			testutils.ExpectMessage(&pgproto3.BindComplete{}),
+			testutils.WaitForStep(&pgproto3.CommandComplete{}),
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+		)
+		assert.Equal([]uint32{byteaOID}, plainDescription.GetColumnOIDs())
+		assert.Equal([]uint32{int4OID}, encryptedDescription.GetColumnOIDs())
+		assert.Equal([]uint32{int4OID}, reusedDescription.GetColumnOIDs())
+	})
+
+	t.Run("jdbc describes unnamed portal", func(t *testing.T) {
+		portalDescription := testutils.NewCollectDescriptionStep()
+		run(t,
+			testutils.SendMessage(&pgproto3.Parse{Query: "select data, plain from describe_table where id = $1",
+				ParameterOIDs: []uint32{int4OID}}),
+			testutils.SendMessage(&pgproto3.Bind{Parameters: [][]byte{[]byte("1")}}),
+			testutils.SendMessage(&pgproto3.Describe{ObjectType: 'P'}),
+			testutils.SendMessage(&pgproto3.Execute{}),
+			testutils.SendMessage(&pgproto3.Sync{}),
+			testutils.NewFlushStep(),
+
+			testutils.ExpectMessage(&pgproto3.ParseComplete{}),
+			testutils.ExpectMessage(&pgproto3.BindComplete{}),
+			portalDescription,
+			testutils.WaitForStep(&pgproto3.CommandComplete{}),
+			testutils.WaitForStep(&pgproto3.ReadyForQuery{}),
+		)
+		assert.Nil(portalDescription.GetParameterOIDs())
+		assert.Equal([]uint32{int4OID, byteaOID}, portalDescription.GetColumnOIDs())
+	})
+}
@@ -22,7 +22,10 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -32,6 +35,7 @@ import (
 
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
@@ -75,6 +79,31 @@ func NewPgError(message string) ([]byte, error) {
 	return output, nil
 }
 
+// Codes of errors sent to the client before closing connection
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgIdleSessionTimeoutCode    = "57P05"
+	pgAdminShutdownCode         = "57P01"
+	pgTooManyConnectionsCode    = "53300"
+	pgRejectedEstablishmentCode = "08004"
+)
+
+// newPgFatalError returns packed ErrorResponse with FATAL severity which precedes closing of connection
+func newPgFatalError(code, message string) []byte {
+	output := []byte{ErrorResponseType, 0, 0, 0, 0}
+	output = append(output, 'S')
+	output = append(output, "FATAL"...)
+	output = append(output, 0, 'V')
+	output = append(output, "FATAL"...)
+	output = append(output, 0, 'C')
+	output = append(output, code...)
+	output = append(output, 0, 'M')
+	output = append(output, message...)
+	output = append(output, 0, 0)
+	binary.BigEndian.PutUint32(output[1:5], uint32(len(output)-1))
+	return output
+}
+
 // Errors returned when initializing session registries.
 var (
 	ErrInvalidPreparedStatementRegistry = errors.New("ClientSession contains invalid PreparedStatementRegistry")
@@ -94,6 +123,8 @@ const (
 	ParseMessageType         byte = 'P'
 	BindMessageType          byte = 'B'
 	ExecuteMessageType       byte = 'E'
+	SyncMessageType          byte = 'S'
+	DescribeMessageType      byte = 'D'
 	ErrorResponseType        byte = 'E'
 	ParseCompleteMessageType byte = '1'
 	BindCompleteMessageType  byte = '2'
@@ -105,6 +136,9 @@ const (
 	NoDataType                    = 'n'
 	PortalSuspendedType           = 's'
 	ClientStopTimeout             = time.Second * 2
+	// DescribeStatementType and DescribePortalType are types of object described by Describe message
+	DescribeStatementType byte = 'S'
+	DescribePortalType    byte = 'P'
 )
 
 // Specific for PgSQL values of data format
@@ -171,6 +205,33 @@ type PgProxy struct {
 	clientIDObserverManager base.ClientIDObservableManager
 	parser                  *sqlparser.Parser
 	settingExtractor        EncryptionSettingExtractor
+	latencyBudget           *base.QueryLatencyBudget
+	heartbeat               *base.ConnectionHeartbeat
+	sessionTimer            *base.SessionTimer
+	dataAccessAudit         *base.DataAccessAudit
+	columnMetrics           *base.ColumnMetrics
+	queryStats              *base.QueryStats
+	differentialPrivacy     *base.DifferentialPrivacy
+	// requestTimings collects time spent on client's packets of the query until its record is created, accessed
+	// only by client's goroutine
+	requestTimings base.QueryTimings
+	// activityLock serializes notifications of SessionActivityObserver from client's and database's goroutines
+	activityLock sync.Mutex
+	// pendingReadyForQuery counts client's Query and Sync messages which responses are not finished with
+	// ReadyForQuery yet
+	pendingReadyForQuery int
+	// resultColumnNames stores column names from the last RowDescription packet to verify columns expanded from star
+	// expression until the end of the query
+	resultColumnNames []string
+	// parsedStatementSettings stores settings of the query from the last Parse packet until its prepared statement is
+	// registered, accessed only by client's goroutine
+	parsedStatementSettings *describeSettings
+	// replicationPassthrough is set by client's goroutine before forwarding StartupMessage of replication connection
+	// and tells database's goroutine to forward responses untouched
+	replicationPassthrough atomic.Bool
+	// clientTLS is set by database's goroutine after TLS handshake with the client before it restarts client's
+	// goroutine
+	clientTLS bool
 }
 
 // NewPgProxy returns new PgProxy
@@ -201,7 +262,7 @@ func NewPgProxy(session base.ClientSession, parser *sqlparser.Parser, setting ba
 	if err != nil {
 		return nil, err
 	}
-	return &PgProxy{
+	proxy := &PgProxy{
 		session:                 session,
 		clientConnection:        session.ClientConnection(),
 		dbConnection:            session.DatabaseConnection(),
@@ -215,7 +276,62 @@ func NewPgProxy(session base.ClientSession, parser *sqlparser.Parser, setting ba
 		clientIDObserverManager: clientIDObserverManager,
 		parser:                  parser,
 		settingExtractor:        settingExtractor,
-	}, nil
+		latencyBudget:           base.NewQueryLatencyBudget(setting.LatencyBudget(), base.DecryptionDBPostgresql),
+		dataAccessAudit:         base.NewDataAccessAudit(setting.DataAccessAuditLog(), base.DecryptionDBPostgresql),
+		columnMetrics:           setting.ColumnMetrics(),
+		queryStats:              base.NewQueryStats(setting.QueryThresholds(), base.DecryptionDBPostgresql, parser),
+		differentialPrivacy:     setting.DifferentialPrivacy(),
+	}
+	proxy.heartbeat = base.NewConnectionHeartbeat(setting.Heartbeat(), base.DecryptionDBPostgresql,
+		proxy.sendHeartbeatProbe, proxy.closeDatabaseConnection, logging.GetLoggerFromContext(session.Context()))
+	proxy.sessionTimer = base.NewSessionTimer(setting.SessionTimeouts(), base.DecryptionDBPostgresql,
+		proxy.expireSession, logging.GetLoggerFromContext(session.Context()))
+	return proxy, nil
+}
+
+// heartbeatProbePacket is Sync message used as heartbeat probe, database responds to it with ReadyForQuery
+var heartbeatProbePacket = []byte{'S', 0, 0, 0, 4}
+
+// sendHeartbeatProbe sends Sync to idle connection to check that database is alive
+func (proxy *PgProxy) sendHeartbeatProbe() error {
+	if err := proxy.dbConnection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err != nil {
+		return err
+	}
+	_, err := proxy.dbConnection.Write(heartbeatProbePacket)
+	return err
+}
+
+// closeDatabaseConnection closes dead connection to the database which interrupts proxying of the session
+func (proxy *PgProxy) closeDatabaseConnection() {
+	if err := proxy.dbConnection.Close(); err != nil {
+		logging.GetLoggerFromContext(proxy.ctx).WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnectionDB).
+			Errorln("Can't close connection to db")
+	}
+}
+
+// expireSession sends FATAL error to the client and Terminate to the database, then closes connection to the database
+// which interrupts proxying of the session
+func (proxy *PgProxy) expireSession(reason error) {
+	code := pgAdminShutdownCode
+	if reason == base.ErrSessionIdleTimeout {
+		code = pgIdleSessionTimeoutCode
+	}
+	logger := logging.GetLoggerFromContext(proxy.ctx)
+	errorMessage := newPgFatalError(code, "AcraServer: "+reason.Error())
+	if err := proxy.clientConnection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err == nil {
+		n, err := proxy.clientConnection.Write(errorMessage)
+		if err := base.CheckReadWrite(n, len(errorMessage), err); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).
+				Debugln("Can't send error to the client")
+		}
+	}
+	if err := proxy.dbConnection.SetWriteDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err == nil {
+		if _, err := proxy.dbConnection.Write(TerminatePacket); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).
+				Debugln("Can't send Terminate to the database")
+		}
+	}
+	proxy.closeDatabaseConnection()
 }
 
 // SubscribeOnAllColumnsDecryption subscribes for notifications on each column.
@@ -228,14 +344,16 @@ func (proxy *PgProxy) Unsubscribe(subscriber base.DecryptionSubscriber) {
 	proxy.decryptionObserver.Unsubscribe(subscriber)
 }
 
-func (proxy *PgProxy) onColumnDecryption(parentCtx context.Context, i int, data []byte, binaryFormat bool, encryptionSetting config.ColumnEncryptionSetting) ([]byte, error) {
+func (proxy *PgProxy) onColumnDecryption(parentCtx context.Context, i int, data []byte, binaryFormat bool, tableName string, encryptionSetting config.ColumnEncryptionSetting, columnMetrics base.ColumnMetricsRecorder) (context.Context, []byte, error) {
 	accessContext := base.AccessContextFromContext(parentCtx)
 	accessContext.SetColumnInfo(base.NewColumnInfo(i, "", binaryFormat, len(data), 0, 0))
 	// create new ctx per column processing
 	ctx := base.SetAccessContextToContext(parentCtx, accessContext)
 	ctx = encryptor.NewContextWithEncryptionSetting(ctx, encryptionSetting)
-	_, newData, err := proxy.decryptionObserver.OnColumnDecryption(ctx, i, data)
-	return newData, err
+	ctx = encryptor.NewContextWithTableName(ctx, tableName)
+	ctx = base.SetColumnMetricsRecorderToContext(ctx, columnMetrics)
+	defer base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageColumnDecryption, time.Now())
+	return proxy.decryptionObserver.OnColumnDecryption(ctx, i, data)
 }
 
 // AddQueryObserver implement QueryObservable interface and proxy call to ObserverManager
@@ -253,8 +371,10 @@ func (proxy *PgProxy) RegisteredObserversCount() int {
 func (proxy *PgProxy) ProxyClientConnection(ctx context.Context, errCh chan<- base.ProxyError) {
 	ctx, span := trace.StartSpan(ctx, "ProxyClientConnection")
 	defer span.End()
+	ctx = base.SetColumnMetricsToContext(ctx, proxy.columnMetrics)
 	logger := logging.NewLoggerWithTrace(ctx).WithField("proxy", "client")
 	logger.Debugln("ProxyClientConnection")
+	activityObserver := base.SessionActivityObserverFromContext(ctx)
 	writer := bufio.NewWriter(proxy.dbConnection)
 
 	reader := bufio.NewReader(proxy.clientConnection)
@@ -264,6 +384,7 @@ func (proxy *PgProxy) ProxyClientConnection(ctx context.Context, errCh chan<- ba
 		errCh <- base.NewClientProxyError(err)
 		return
 	}
+	defer packet.Release()
 	prometheusLabels := []string{base.DecryptionDBPostgresql}
 	// use pointers to function where should be stored some function that should be called if code return error and interrupt loop
 	// default value empty func to avoid != nil check
@@ -285,6 +406,17 @@ func (proxy *PgProxy) ProxyClientConnection(ctx context.Context, errCh chan<- ba
 			errCh <- base.NewClientProxyError(err)
 			return
 		}
+		if packet.IsStartupMessage() {
+			passthrough, err := proxy.handleStartupMessage(packet, logger)
+			if err != nil {
+				errCh <- base.NewClientProxyError(err)
+				return
+			}
+			if passthrough {
+				errCh <- base.NewClientProxyError(proxy.passthroughClientConnection(packet, reader))
+				return
+			}
+		}
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.RequestProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
 		timerObserveFunc = timer.ObserveDuration
 
@@ -316,7 +448,8 @@ func (proxy *PgProxy) ProxyClientConnection(ctx context.Context, errCh chan<- ba
 		}
 
 		// After tha packet has been observed and possibly modified, forward it to the database.
-		if err := packet.sendPacket(); err != nil {
+		proxy.onClientRequest(packet, activityObserver)
+		if err := proxy.heartbeat.OnClientRequest(packet.sendPacket); err != nil {
 			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkWrite).
 				WithError(err).Errorln("Can't send packet")
 			errCh <- base.NewClientProxyError(err)
@@ -330,9 +463,85 @@ func (proxy *PgProxy) ProxyClientConnection(ctx context.Context, errCh chan<- ba
 	}
 }
 
+// handleStartupMessage applies ReplicationPolicy to connections in streaming replication mode which use CopyBoth
+// sub-protocol and replication commands instead of SQL. Returns true if connection should be forwarded untouched
+func (proxy *PgProxy) handleStartupMessage(packet *PacketHandler, logger *log.Entry) (bool, error) {
+	if proxy.setting.RequireClientTLS() && !proxy.clientTLS {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodePlaintextConnectionDenied).
+			Warningln("Connection without TLS denied, client should use sslmode=require or stricter")
+		return false, proxy.denyStartup(base.ErrPlaintextConnectionDenied)
+	}
+	parameters, err := packet.GetStartupParameters()
+	if err != nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCodingPostgresqlUnexpectedPacket).
+			WithError(err).Errorln("Can't parse StartupMessage")
+		return false, err
+	}
+	if !IsReplicationStartup(parameters) {
+		return false, nil
+	}
+	logger = logger.WithFields(log.Fields{
+		"user":        parameters["user"],
+		"database":    parameters["database"],
+		"replication": parameters["replication"],
+	})
+	if proxy.setting.ReplicationPolicy() == base.ReplicationPolicyPassthrough {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeReplicationPassthrough).
+			Warningln("Replication connection is forwarded to the database untouched, data is not decrypted and queries are not checked by AcraCensor")
+		return true, nil
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeReplicationConnectionDenied).
+		Warningln("Replication connection denied")
+	return false, proxy.denyStartup(base.ErrReplicationConnectionDenied)
+}
+
+// denyStartup sends ErrorResponse with reason to the client instead of forwarding StartupMessage and returns reason
+func (proxy *PgProxy) denyStartup(reason error) error {
+	errorMessage, err := NewPgError("AcraServer: " + reason.Error())
+	if err != nil {
+		return err
+	}
+	n, err := proxy.clientConnection.Write(errorMessage)
+	if err := base.CheckReadWrite(n, len(errorMessage), err); err != nil {
+		return err
+	}
+	return reason
+}
+
+// passthroughClientConnection forwards StartupMessage and all next client's traffic to the database as is
+func (proxy *PgProxy) passthroughClientConnection(packet *PacketHandler, reader io.Reader) error {
+	proxy.replicationPassthrough.Store(true)
+	// replication streams may stay without traffic for a long time
+	if err := proxy.dbConnection.SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+	if err := packet.sendPacket(); err != nil {
+		return err
+	}
+	return copyStream(proxy.dbConnection, reader)
+}
+
+// passthroughDatabaseConnection forwards all next database's traffic to the client as is
+func (proxy *PgProxy) passthroughDatabaseConnection(reader io.Reader) error {
+	if err := proxy.clientConnection.SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+	return copyStream(proxy.clientConnection, reader)
+}
+
+// copyStream copies data until one of the connections is closed. Returns io.EOF if source closed connection
+func copyStream(dst io.Writer, src io.Reader) error {
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
 func (proxy *PgProxy) handleClientPacket(ctx context.Context, packet *PacketHandler, logger *log.Entry) (bool, error) {
 	// Let the protocol observer take a look at the packet, keeping note of it.
+	parseStart := time.Now()
 	err := proxy.protocolState.HandleClientPacket(packet)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StagePacketParse, parseStart)
 	if err != nil {
 		return false, err
 	}
@@ -357,12 +566,19 @@ func (proxy *PgProxy) handleClientPacket(ctx context.Context, packet *PacketHand
 			return false, errors.New("invalid type of registered prepared statement")
 		}
 		queryPacket := newExtendedQueryPacket(prepared, pgCursor.bind, executePacket)
+		queryPacket.dataAccess = proxy.dataAccessAudit.NewRecord(ctx)
+		queryPacket.queryStats = proxy.queryStats.NewRecord(ctx, queryPacket.GetSQLQuery())
+		queryPacket.queryStats.OnRequestProcessed(proxy.requestTimings.Take())
+		queryPacket.privacyNoise = proxy.differentialPrivacy.NewRecord(ctx, queryPacket.GetSQLQuery())
 		if err = proxy.protocolState.pendingQueryPackets.Add(queryPacket); err != nil {
 			return false, err
 		}
 		break
 	case ParseStatementPacket:
 		censored, err := proxy.handleQueryPacket(ctx, packet, logger)
+		if censored {
+			proxy.dataAccessAudit.OnBlockedQuery(ctx)
+		}
 		if err != nil || censored {
 			return censored, err
 		}
@@ -391,12 +607,23 @@ func (proxy *PgProxy) handleClientPacket(ctx context.Context, packet *PacketHand
 			return false, err
 		}
 		queryPacket := newQueryPacket(query)
+		queryPacket.dataAccess = proxy.dataAccessAudit.NewRecord(ctx)
+		queryPacket.queryStats = proxy.queryStats.NewRecord(ctx, query)
+		queryPacket.privacyNoise = proxy.differentialPrivacy.NewRecord(ctx, query)
 		if err = proxy.protocolState.pendingQueryPackets.Add(queryPacket); err != nil {
 			return false, err
 		}
 		// If that's some sort of a packet with a query inside it,
 		// process inline data if necessary and remember the query to handle future response.
-		return proxy.handleQueryPacket(ctx, packet, logger)
+		censored, err := proxy.handleQueryPacket(ctx, packet, logger)
+		queryPacket.queryStats.OnRequestProcessed(proxy.requestTimings.Take())
+		if censored {
+			queryPacket.dataAccess.Finish(logging.DataAccessVerdictBlocked)
+		}
+		if err == nil && !censored {
+			err = proxy.protocolState.AddSimpleQueryTarget()
+		}
+		return censored, err
 
 	case BindStatementPacket:
 		// Bound query parameters may contain inline data that we need to process.
@@ -456,15 +683,37 @@ func (proxy *PgProxy) handleQueryPacket(ctx context.Context, packet *PacketHandl
 
 	// Let AcraCensor take a look at the query text.
 	// If it's not okay (and we're still alive), don't let the database see the query.
-	if censorErr := proxy.censor.HandleQuery(query); censorErr != nil {
+	censorStart := time.Now()
+	censorErr := proxy.censor.HandleQueryWithContext(ctx, query)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageCensor, censorStart)
+	if censorErr != nil {
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).
 			WithError(censorErr).Errorln("AcraCensor blocked query")
 		return true, nil
 	}
+	// queries which may return values of protected tables without noise are blocked like censored ones
+	originalQuery := query
+	query, err = proxy.differentialPrivacy.CheckQuery(ctx, query)
+	if err != nil {
+		return true, nil
+	}
+	encryptorStart := time.Now()
 
 	// Let the registered observers observe the query, potentially modifying it (e.g., transparent encryption).
 	queryObj := base.NewOnQueryObjectFromQuery(query, proxy.parser)
-	newQuery, changed, err := proxy.queryObserverManager.OnQuery(ctx, queryObj)
+	var newQuery base.OnQueryObject
+	var changed bool
+	onQuery := func() {
+		newQuery, changed, err = proxy.queryObserverManager.OnQuery(ctx, queryObj)
+	}
+	if packet.IsParse() {
+		// remember settings of the prepared statement to rewrite descriptions on Describe
+		proxy.parsedStatementSettings = collectDescribeSettings(base.ClientSessionFromContext(ctx), onQuery)
+	} else {
+		onQuery()
+	}
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageQueryRewrite, encryptorStart)
+	proxy.requestTimings.Add(encryptorStart.Sub(censorStart), time.Since(encryptorStart))
 	if err != nil {
 		if filesystem.IsKeyReadError(err) {
 			return false, err
@@ -475,6 +724,9 @@ func (proxy *PgProxy) handleQueryPacket(ctx context.Context, packet *PacketHandl
 	}
 	if changed {
 		packet.ReplaceQuery(newQuery.Query())
+	} else if query != originalQuery {
+		// arguments of aggregates over protected tables are clamped into bounds
+		packet.ReplaceQuery(query)
 	}
 	return false, nil
 }
@@ -490,6 +742,11 @@ func (proxy *PgProxy) handleBindPacket(ctx context.Context, packet *PacketHandle
 	}
 	logger = logger.WithField("portal", bind.PortalName()).WithField("statement", bind.StatementName())
 	logger.Debug("Bind packet")
+	// Let AcraCensor check the query with bound values before they are encrypted
+	// and don't register the portal of blocked query
+	if proxy.censorBoundQuery(ctx, bind, logger) {
+		return true, nil
+	}
 	if err = proxy.registerCursor(bind, logger); err != nil {
 		return false, err
 	}
@@ -510,7 +767,10 @@ func (proxy *PgProxy) handleBindPacket(ctx context.Context, packet *PacketHandle
 	}
 	// Process parameter values. If we can't -- you guessed it -- leave the packet unchanged.
 	// Note that the new parameter set might have different number of items.
+	encryptorStart := time.Now()
 	newParameters, changed, err := proxy.queryObserverManager.OnBind(ctx, statement.Query(), parameters)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageQueryRewrite, encryptorStart)
+	proxy.requestTimings.Add(0, time.Since(encryptorStart))
 	if err != nil {
 		if filesystem.IsKeyReadError(err) {
 			return false, err
@@ -533,6 +793,36 @@ func (proxy *PgProxy) handleBindPacket(ctx context.Context, packet *PacketHandle
 	return false, nil
 }
 
+// censorBoundQuery passes query of prepared statement with values of Bind packet to AcraCensor and returns true if the
+// query is blocked. Bind packets with unknown statement or invalid parameters aren't checked and are handled as usual
+func (proxy *PgProxy) censorBoundQuery(ctx context.Context, bind *BindPacket, logger *log.Entry) bool {
+	statement, err := proxy.session.PreparedStatementRegistry().StatementByName(bind.StatementName())
+	if err != nil {
+		return false
+	}
+	parameters, err := bind.GetParameters()
+	if err != nil {
+		return false
+	}
+	values := make([]acracensor.BoundValue, 0, len(parameters))
+	for _, parameter := range parameters {
+		data, err := parameter.GetData(nil)
+		if err != nil {
+			return false
+		}
+		values = append(values, acracensor.BoundValue{Data: data, Binary: parameter.Format() == base.BinaryFormat})
+	}
+	censorStart := time.Now()
+	censorErr := proxy.censor.HandleBoundQueryWithContext(ctx, statement.QueryText(), values)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StageCensor, censorStart)
+	if censorErr != nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).
+			WithError(censorErr).Errorln("AcraCensor blocked query with bound values")
+		return true
+	}
+	return false
+}
+
 func (proxy *PgProxy) sendClientError(msg string, logger *log.Entry) error {
 	errorMessage, err := NewPgError(msg)
 	if err != nil {
@@ -632,6 +922,7 @@ func (proxy *PgProxy) handleSSLRequest(packet *PacketHandler, logger *log.Entry)
 		}
 		return nil, nil, err
 	}
+	base.SetPeerCertificateToSession(proxy.session, network.GetPeerCertificateFromConnection(tlsClientConnection))
 	logger.WithField("use_client_id", proxy.setting.TLSConnectionWrapper().UseConnectionClientID()).Infoln("TLS connection to db")
 	if proxy.setting.TLSConnectionWrapper().UseConnectionClientID() {
 		logger.WithField("client_id", string(clientID)).Infoln("Set new clientID")
@@ -664,6 +955,7 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 	defer span.End()
 	logger := logging.NewLoggerWithTrace(ctx).WithField("proxy", "server")
 	logger.Debugln("Pg db proxy")
+	activityObserver := base.SessionActivityObserverFromContext(ctx)
 	// use buffered writer because we generate response by parts
 	writer := bufio.NewWriter(proxy.clientConnection)
 
@@ -673,6 +965,12 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 		errCh <- base.NewDBProxyError(err)
 		return
 	}
+	defer packetHandler.Release()
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go proxy.heartbeat.Run(heartbeatCtx)
+	go proxy.sessionTimer.Run(heartbeatCtx)
 
 	var state databaseHandlerState = stateFirstPacket
 
@@ -685,6 +983,18 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 		// end span of previous iteration
 		endLoopSpanFunc()
 
+		// client's goroutine switches to passthrough before forwarding StartupMessage, so all responses after the
+		// first one are not processed
+		if proxy.replicationPassthrough.Load() {
+			stopHeartbeat()
+			if err := writer.Flush(); err != nil {
+				errCh <- base.NewDBProxyError(err)
+				return
+			}
+			errCh <- base.NewDBProxyError(proxy.passthroughDatabaseConnection(reader))
+			return
+		}
+
 		packetHandler.Reset()
 		switch state {
 		case stateServe:
@@ -694,6 +1004,11 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 				errCh <- base.NewDBProxyError(err)
 				return
 			}
+			if packetHandler.IsReadyForQuery() && proxy.heartbeat.OnIdle() {
+				logger.Debugln("Skip response to heartbeat probe")
+				endLoopSpanFunc = func() {}
+				continue
+			}
 			timer := prometheus.NewTimer(prometheus.ObserverFunc(base.ResponseProcessingTimeHistogram.WithLabelValues(base.DecryptionDBPostgresql).Observe))
 			packetCtx, packetSpan = trace.StartSpan(ctx, "PgDecryptStreamLoop")
 			endLoopSpanFunc = packetSpan.End
@@ -726,6 +1041,9 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 				errCh <- base.NewDBProxyError(err)
 				return
 			}
+			if packetHandler.IsReadyForQuery() {
+				proxy.onReadyForQuery(packetHandler, activityObserver)
+			}
 			timer.ObserveDuration()
 
 		case stateFirstPacket:
@@ -776,6 +1094,7 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 				}
 				proxy.clientConnection = tlsClientConnection
 				proxy.dbConnection = dbTLSConnection
+				proxy.clientTLS = true
 				// restart proxing client's requests
 				go proxy.ProxyClientConnection(ctx, errCh)
 				reader = bufio.NewReader(dbTLSConnection)
@@ -813,24 +1132,79 @@ func (proxy *PgProxy) ProxyDatabaseConnection(ctx context.Context, errCh chan<-
 			last := packetHandler.IsReadyForQuery()
 			if last {
 				state = stateServe
+				proxy.heartbeat.OnIdle()
 				// Process the ReadyForQuery packet to reset the state of the
 				// protocol and do necessary cleanup
 				if err := proxy.handleDatabasePacket(packetCtx, packetHandler, logger); err != nil {
 					errCh <- base.NewDBProxyError(err)
 					return
 				}
+				proxy.onReadyForQuery(packetHandler, activityObserver)
 			}
 			logger.WithField("last", last).Debugln("Skipping the packet")
 		}
 	}
 }
 
+// onClientRequest notifies observer that session is busy before client's packet is forwarded to the database
+func (proxy *PgProxy) onClientRequest(packet *PacketHandler, observer base.SessionActivityObserver) {
+	proxy.activityLock.Lock()
+	defer proxy.activityLock.Unlock()
+	if packet.IsSimpleQuery() || packet.IsSync() {
+		proxy.pendingReadyForQuery++
+	}
+	proxy.sessionTimer.OnClientRequest()
+	observer.OnSessionBusy()
+}
+
+// onReadyForQuery notifies observer that session is idle if database finished responses to all client's requests and
+// connection is not in transaction block
+func (proxy *PgProxy) onReadyForQuery(packet *PacketHandler, observer base.SessionActivityObserver) {
+	proxy.activityLock.Lock()
+	defer proxy.activityLock.Unlock()
+	// ReadyForQuery after startup isn't response to client's request
+	if proxy.pendingReadyForQuery > 0 {
+		proxy.pendingReadyForQuery--
+	}
+	if proxy.pendingReadyForQuery == 0 {
+		proxy.sessionTimer.OnResponseFinished()
+	}
+	if proxy.pendingReadyForQuery == 0 && packet.IsIdleReadyForQuery() {
+		observer.OnSessionIdle()
+	}
+}
+
+// finishDataAccess writes data access record and checks thresholds of the query which response is finished by
+// packet, should be called before protocol state forgets the query
+func (proxy *PgProxy) finishDataAccess(packet *PacketHandler) {
+	if !(packet.IsCommandComplete() || packet.IsEmptyQueryResponse() || packet.IsPortalSuspended() || packet.IsErrorResponse()) {
+		return
+	}
+	pendingPacket, err := proxy.protocolState.pendingQueryPackets.GetPendingPacket(queryPacket{})
+	if err != nil || pendingPacket == nil {
+		return
+	}
+	verdict := logging.DataAccessVerdictAllowed
+	if packet.IsErrorResponse() {
+		verdict = logging.DataAccessVerdictFailed
+	}
+	pendingPacket.(queryPacket).dataAccess.Finish(verdict)
+	pendingPacket.(queryPacket).queryStats.Finish()
+}
+
 func (proxy *PgProxy) handleDatabasePacket(ctx context.Context, packet *PacketHandler, logger *log.Entry) error {
+	proxy.finishDataAccess(packet)
 	// Let the protocol observer take a look at the packet, keeping note of it.
+	parseStart := time.Now()
 	err := proxy.protocolState.HandleDatabasePacket(packet)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StagePacketParse, parseStart)
 	if err != nil {
 		return err
 	}
+	// response of the query is finished, next query starts with full latency budget
+	if packet.IsCommandComplete() || packet.IsErrorResponse() {
+		proxy.latencyBudget.Reset()
+	}
 	switch proxy.protocolState.LastPacketType() {
 	case DataPacket:
 		// If that's some sort of a packet with a query response inside it,
@@ -845,6 +1219,7 @@ func (proxy *PgProxy) handleDatabasePacket(ctx context.Context, packet *PacketHa
 	case ReadyForQueryPacket:
 		logger.Debugln("ReadyForQueryPacket")
 		encryptor.DeletePlaceholderSettingsFromClientSession(proxy.session)
+		proxy.resultColumnNames = nil
 		return nil
 
 	default:
@@ -853,6 +1228,18 @@ func (proxy *PgProxy) handleDatabasePacket(ctx context.Context, packet *PacketHa
 	}
 }
 
+// describedStatementSettings returns settings of prepared statement which description is handled now, nil if the
+// description isn't response to Describe of known statement. Then settings of the last query from ClientSession are used
+func (proxy *PgProxy) describedStatementSettings() *describeSettings {
+	if proxy.protocolState == nil {
+		return nil
+	}
+	if statement := proxy.protocolState.DescribedStatement(); statement != nil {
+		return statement.describeSettings
+	}
+	return nil
+}
+
 func (proxy *PgProxy) handleParameterDescription(ctx context.Context, packet *PacketHandler, logger *log.Entry) error {
 	clientSession := base.ClientSessionFromContext(ctx)
 	if clientSession == nil {
@@ -860,6 +1247,9 @@ func (proxy *PgProxy) handleParameterDescription(ctx context.Context, packet *Pa
 		return nil
 	}
 	items := encryptor.PlaceholderSettingsFromClientSession(clientSession)
+	if settings := proxy.describedStatementSettings(); settings != nil {
+		items = settings.placeholders
+	}
 	if items == nil {
 		logger.Debugln("ParameterDescription packet without registered recognized encryption settings")
 		return nil
@@ -878,7 +1268,7 @@ func (proxy *PgProxy) handleParameterDescription(ctx context.Context, packet *Pa
 			continue
 		}
 		if config.HasTypeAwareSupport(setting) {
-			newOID, ok := mapEncryptedTypeToOID(setting.GetDBDataTypeID())
+			newOID, ok := mapSettingToOID(setting)
 			if ok {
 				parameterDescription.ParameterOIDs[i] = newOID
 				changed = true
@@ -902,6 +1292,9 @@ func (proxy *PgProxy) handleRowDescription(ctx context.Context, packet *PacketHa
 		return nil
 	}
 	items := encryptor.QueryDataItemsFromClientSession(clientSession)
+	if settings := proxy.describedStatementSettings(); settings != nil {
+		items = settings.columns
+	}
 	if items == nil {
 		logger.Debugln("RowDescription packet without registered recognized encryption settings")
 		return nil
@@ -917,6 +1310,12 @@ func (proxy *PgProxy) handleRowDescription(ctx context.Context, packet *PacketHa
 		log.Errorln("Column count in RowDescription packet not same as parsed query count of columns")
 		return nil
 	}
+	proxy.resultColumnNames = make([]string, len(rowDescription.Fields))
+	for i, field := range rowDescription.Fields {
+		proxy.resultColumnNames[i] = string(field.Name)
+	}
+	items = encryptor.VerifyExpandedColumns(items, proxy.resultColumnNames)
+	encryptor.SaveQueryDataItemsToClientSession(clientSession, items)
 	changed := false
 	for i := 0; i < len(rowDescription.Fields); i++ {
 		setting := items[i]
@@ -924,7 +1323,7 @@ func (proxy *PgProxy) handleRowDescription(ctx context.Context, packet *PacketHa
 			continue
 		}
 		if config.HasTypeAwareSupport(setting.Setting()) {
-			newOID, ok := mapEncryptedTypeToOID(setting.Setting().GetDBDataTypeID())
+			newOID, ok := mapSettingToOID(setting.Setting())
 			if ok {
 				rowDescription.Fields[i].DataTypeOID = newOID
 				changed = true
@@ -959,11 +1358,21 @@ func (proxy *PgProxy) handleQueryDataPacket(ctx context.Context, packet *PacketH
 			return err
 		}
 	}
-	if err := packet.parseColumns(columnFormats); err != nil {
+	parseStart := time.Now()
+	err = packet.parseColumns(columnFormats)
+	base.ObserveStageDuration(base.DecryptionDBPostgresql, base.StagePacketParse, parseStart)
+	if err != nil {
 		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCodingPostgresqlCantParseColumnsDescription).
 			WithError(err).Errorln("Can't parse columns in packet")
 		return err
 	}
+	dataAccess := pendingPacket.(queryPacket).dataAccess
+	dataAccess.OnRow()
+	privacyNoise := pendingPacket.(queryPacket).privacyNoise
+	rowStart := time.Now()
+	defer func() {
+		pendingPacket.(queryPacket).queryStats.OnRow(packet.descriptionBuf.Len(), time.Since(rowStart))
+	}()
 	// If the packet does not contain columns to decrypt, we have nothing more to do here.
 	if packet.columnCount == 0 {
 		return nil
@@ -974,6 +1383,14 @@ func (proxy *PgProxy) handleQueryDataPacket(ctx context.Context, packet *PacketH
 		logger.WithError(err).Warningln("Can't extract encryption settings from the query")
 		encryptionSettings = nil
 	}
+	if proxy.resultColumnNames != nil {
+		encryptionSettings = encryptor.VerifyExpandedColumns(encryptionSettings, proxy.resultColumnNames)
+	}
+	ctx = proxy.latencyBudget.OnRow(ctx, packet.columnCount)
+	// containers of all columns of the row share rotated keys fetched from keystore once
+	keysBatch := keystore.NewDecryptionKeysBatch()
+	defer keysBatch.Close()
+	ctx = base.SetDecryptionKeysBatchToContext(ctx, keysBatch)
 	logger.Debugf("Process columns data")
 	for i := 0; i < packet.columnCount; i++ {
 		column := packet.Columns[i]
@@ -993,16 +1410,31 @@ func (proxy *PgProxy) handleQueryDataPacket(ctx context.Context, packet *PacketH
 			format = int(boundFormat)
 		}
 		var encryptionSetting config.ColumnEncryptionSetting = nil
+		var columnMetrics base.ColumnMetricsRecorder
+		tableName := ""
 		if encryptionSettings != nil && i <= len(encryptionSettings) && encryptionSettings[i] != nil {
 			encryptionSetting = encryptionSettings[i].Setting()
+			tableName = encryptionSettings[i].TableName()
+			dataAccess.OnColumn(encryptionSettings[i].TableName(), encryptionSettings[i].ColumnName())
+			columnMetrics = proxy.columnMetrics.Column(encryptionSettings[i].TableName(), encryptionSettings[i].ColumnName())
 		}
 		logger.WithField("data_length", len(column.GetData())).WithField("column_index", i).Debugln("Process columns data")
-		newData, err := proxy.onColumnDecryption(ctx, i, column.GetData(), format == dataFormatBinary, encryptionSetting)
+		columnCtx, newData, err := proxy.onColumnDecryption(ctx, i, column.GetData(), format == dataFormatBinary, tableName, encryptionSetting, columnMetrics)
 		if err != nil {
 			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
 				WithError(err).Errorln("Error on column data processing")
 			return err
 		}
+		if base.IsNullValueFromContext(columnCtx) {
+			column.SetNull()
+			continue
+		}
+		// noise is added to aggregates calculated by the database over protected tables
+		newData, ok := privacyNoise.OnColumn(i, newData, format == dataFormatBinary)
+		if !ok {
+			column.SetNull()
+			continue
+		}
 		column.SetData(newData)
 	}
 	// After we're done processing the columns, update the actual packet data from them
@@ -1026,6 +1458,8 @@ func (proxy *PgProxy) registerPreparedStatement(packet *PacketHandler, preparedS
 		return err
 	}
 	statement := NewPreparedStatement(name, queryText, query)
+	statement.describeSettings = proxy.parsedStatementSettings
+	proxy.parsedStatementSettings = nil
 	registry := proxy.session.PreparedStatementRegistry()
 	err = registry.AddStatement(statement)
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net"
 	"testing"
@@ -14,7 +15,10 @@ import (
 	acracensor "github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/cmd/acra-server/common"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/sqlparser"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/sirupsen/logrus"
 )
 
@@ -536,3 +540,236 @@ func writeErrorResponse(w io.Writer) error {
 	_, err = w.Write(packet)
 	return err
 }
+
+// TestDescribeOnlyFlow checks that RowDescription is rewritten according to the described prepared statement when
+// clients parse several statements and describe them without Bind and Execute or after ReadyForQuery
+func TestDescribeOnlyFlow(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	parser := sqlparser.New(sqlparser.ModeDefault)
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(`schemas:
+  - table: test_table
+    columns:
+      - id
+      - data
+    encrypted:
+      - column: data
+        data_type: int32
+`), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectionSession, err := common.NewClientSession(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := base.SetClientSessionToContext(context.Background(), connectionSession)
+	proxySetting := base.NewProxySetting(parser, schemaStore, nil, nil, acracensor.NewAcraCensor(), nil)
+	proxy, err := NewPgProxy(connectionSession, parser, proxySetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryEncryptor, err := encryptor.NewPostgresqlQueryEncryptor(schemaStore, parser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.AddQueryObserver(queryEncryptor)
+
+	const encryptedName, plainName = "encrypted", "plain"
+	encode := func(messages ...interface{ Encode([]byte) []byte }) []byte {
+		var output []byte
+		for _, message := range messages {
+			output = message.Encode(output)
+		}
+		return output
+	}
+	rowDescription := func(name string) *pgproto3.RowDescription {
+		return &pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{
+			Name: []byte(name), DataTypeOID: 17, DataTypeSize: -1, TypeModifier: -1}}}
+	}
+	// run handles client's packets first and database's packets after them like pipelined requests and returns OIDs
+	// of RowDescription packets passed to the client
+	run := func(clientData, dbData []byte) []uint32 {
+		clientPacketHandler, err := NewClientSidePacketHandler(bytes.NewReader(clientData), nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientPacketHandler.started = true
+		for {
+			err := clientPacketHandler.ReadClientPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = proxy.handleClientPacket(ctx, clientPacketHandler, logger); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dbPacketHandler, err := NewDbSidePacketHandler(bytes.NewReader(dbData), nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oids := make([]uint32, 0)
+		for {
+			err := dbPacketHandler.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = proxy.handleDatabasePacket(ctx, dbPacketHandler, logger); err != nil {
+				t.Fatal(err)
+			}
+			if dbPacketHandler.IsRowDescription() {
+				description, err := dbPacketHandler.GetRowDescriptionData()
+				if err != nil {
+					t.Fatal(err)
+				}
+				oids = append(oids, description.Fields[0].DataTypeOID)
+			}
+		}
+		return oids
+	}
+
+	// statement with encrypted column is described after another statement is parsed, like pgx and npgsql prepare
+	// several statements at once
+	oids := run(
+		encode(&pgproto3.Parse{Name: encryptedName, Query: "select data from test_table"},
+			&pgproto3.Parse{Name: plainName, Query: "select id from test_table"},
+			&pgproto3.Describe{ObjectType: 'S', Name: encryptedName},
+			&pgproto3.Describe{ObjectType: 'S', Name: plainName},
+			&pgproto3.Sync{}),
+		encode(&pgproto3.ParseComplete{}, &pgproto3.ParseComplete{},
+			&pgproto3.ParameterDescription{}, rowDescription("data"),
+			&pgproto3.ParameterDescription{}, rowDescription("id"),
+			&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	if len(oids) != 2 || oids[0] != 23 || oids[1] != 17 {
+		t.Fatalf("Unexpected OIDs of described statements: %v", oids)
+	}
+
+	// statement is described after ReadyForQuery, unknown statement fails and doesn't break the next Sync group
+	oids = run(
+		encode(&pgproto3.Describe{ObjectType: 'S', Name: "unknown"},
+			&pgproto3.Sync{},
+			&pgproto3.Query{String: "select id from test_table"},
+			&pgproto3.Describe{ObjectType: 'S', Name: encryptedName},
+			&pgproto3.Sync{}),
+		encode(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "26000", Message: "prepared statement does not exist"},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+			rowDescription("id"), &pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+			&pgproto3.ParameterDescription{}, rowDescription("data"),
+			&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	if len(oids) != 2 || oids[0] != 17 || oids[1] != 23 {
+		t.Fatalf("Unexpected OIDs after ReadyForQuery: %v", oids)
+	}
+	if target := proxy.protocolState.nextDescribeTarget(); target != nil {
+		t.Fatalf("Unexpected pending describe target: %+v", target)
+	}
+}
+
+func TestReplicationStartupPolicy(t *testing.T) {
+	parser := sqlparser.New(sqlparser.ModeDefault)
+	logger := logrus.NewEntry(logrus.New())
+	testcases := []struct {
+		options     []base.ProxySettingOption
+		packet      []byte
+		passthrough bool
+		err         error
+	}{
+		// replication connections denied by default
+		{nil, testStartupMessage("user", "test", "replication", "true"), false, base.ErrReplicationConnectionDenied},
+		{[]base.ProxySettingOption{base.WithReplicationPolicy(base.ReplicationPolicyDeny)}, testStartupMessage("replication", "database"), false, base.ErrReplicationConnectionDenied},
+		{[]base.ProxySettingOption{base.WithReplicationPolicy(base.ReplicationPolicyPassthrough)}, testStartupMessage("replication", "true"), true, nil},
+		{nil, testStartupMessage("user", "test", "database", "test"), false, nil},
+		{nil, testStartupMessage("replication", "false"), false, nil},
+	}
+	for i, tcase := range testcases {
+		clientConnection, acraConnection := net.Pipe()
+		session, err := common.NewClientSession(context.Background(), nil, acraConnection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setting := base.NewProxySetting(parser, nil, nil, nil, acracensor.NewAcraCensor(), nil, tcase.options...)
+		proxy, err := NewPgProxy(session, parser, setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := NewClientSidePacketHandler(bytes.NewReader(tcase.packet), nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := packet.ReadClientPacket(); err != nil {
+			t.Fatal(err)
+		}
+		// denied client receives ErrorResponse
+		responseCh := make(chan []byte, 1)
+		go func() {
+			response, _ := io.ReadAll(clientConnection)
+			responseCh <- response
+		}()
+		passthrough, err := proxy.handleStartupMessage(packet, logger)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if passthrough != tcase.passthrough {
+			t.Fatalf("[%d] Expected passthrough=%t", i, tcase.passthrough)
+		}
+		acraConnection.Close()
+		response := <-responseCh
+		if denied := tcase.err != nil; denied != (len(response) > 0 && response[0] == 'E') {
+			t.Fatalf("[%d] Unexpected response to the client: %v", i, response)
+		}
+		clientConnection.Close()
+	}
+}
+
+func TestRequireClientTLS(t *testing.T) {
+	parser := sqlparser.New(sqlparser.ModeDefault)
+	logger := logrus.NewEntry(logrus.New())
+	testcases := []struct {
+		required  bool
+		clientTLS bool
+		err       error
+	}{
+		{false, false, nil},
+		{true, false, base.ErrPlaintextConnectionDenied},
+		{true, true, nil},
+	}
+	for i, tcase := range testcases {
+		clientConnection, acraConnection := net.Pipe()
+		session, err := common.NewClientSession(context.Background(), nil, acraConnection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setting := base.NewProxySetting(parser, nil, nil, nil, acracensor.NewAcraCensor(), nil, base.WithRequireClientTLS(tcase.required))
+		proxy, err := NewPgProxy(session, parser, setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy.clientTLS = tcase.clientTLS
+		packet, err := NewClientSidePacketHandler(bytes.NewReader(testStartupMessage("user", "test", "database", "test")), nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := packet.ReadClientPacket(); err != nil {
+			t.Fatal(err)
+		}
+		responseCh := make(chan []byte, 1)
+		go func() {
+			response, _ := io.ReadAll(clientConnection)
+			responseCh <- response
+		}()
+		if _, err := proxy.handleStartupMessage(packet, logger); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		acraConnection.Close()
+		response := <-responseCh
+		if denied := tcase.err != nil; denied != (len(response) > 0 && response[0] == 'E') {
+			t.Fatalf("[%d] Unexpected response to the client: %v", i, response)
+		}
+		clientConnection.Close()
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"strconv"
 
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/postgresql/types"
 	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/encryptor/config"
 	"github.com/cossacklabs/acra/encryptor/config/common"
@@ -162,6 +163,8 @@ type PgPreparedStatement struct {
 	sql  sqlparser.Statement
 
 	cursors map[string]base.Cursor
+	// describeSettings are used to rewrite ParameterDescription and RowDescription on Describe, nil if unknown
+	describeSettings *describeSettings
 }
 
 // NewPreparedStatement makes a new prepared statement.
@@ -194,6 +197,52 @@ func (s *PgPreparedStatement) ParamsNum() int {
 	return 0
 }
 
+// describeSettings stores encryption settings of placeholders and result columns of prepared statement collected when
+// the statement is parsed, because Describe may be sent after other statements are parsed or after ReadyForQuery when
+// settings of the statement aren't stored in ClientSession anymore.
+type describeSettings struct {
+	placeholders map[int]config.ColumnEncryptionSetting
+	columns      []*encryptor.QueryDataItem
+}
+
+// collectDescribeSettings calls onQuery and returns settings of placeholders and result columns which it saves into
+// ClientSession. Settings of previous queries which aren't overwritten by onQuery are kept in ClientSession as before.
+func collectDescribeSettings(session base.ClientSession, onQuery func()) *describeSettings {
+	if session == nil {
+		onQuery()
+		return nil
+	}
+	previousPlaceholders := copyPlaceholderSettings(encryptor.PlaceholderSettingsFromClientSession(session))
+	previousColumns := encryptor.QueryDataItemsFromClientSession(session)
+	encryptor.DeletePlaceholderSettingsFromClientSession(session)
+	encryptor.DeleteQueryDataItemsFromClientSession(session)
+
+	onQuery()
+
+	placeholders := encryptor.PlaceholderSettingsFromClientSession(session)
+	settings := &describeSettings{
+		placeholders: copyPlaceholderSettings(placeholders),
+		columns:      encryptor.QueryDataItemsFromClientSession(session),
+	}
+	for index, setting := range previousPlaceholders {
+		if _, ok := placeholders[index]; !ok && placeholders != nil {
+			placeholders[index] = setting
+		}
+	}
+	if settings.columns == nil && previousColumns != nil {
+		encryptor.SaveQueryDataItemsToClientSession(session, previousColumns)
+	}
+	return settings
+}
+
+func copyPlaceholderSettings(settings map[int]config.ColumnEncryptionSetting) map[int]config.ColumnEncryptionSetting {
+	settingsCopy := make(map[int]config.ColumnEncryptionSetting, len(settings))
+	for index, setting := range settings {
+		settingsCopy[index] = setting
+	}
+	return settingsCopy
+}
+
 // PgPortal is a PostgreSQL Cursor.
 // Cursors are called "portals" in PostgreSQL protocol specs.
 type PgPortal struct {
@@ -256,6 +305,10 @@ func (p *pgBoundValue) SetData(newData []byte, setting config.ColumnEncryptionSe
 
 	if setting.IsTokenized() {
 		return p.setTokenizedData(newData, setting)
+	} else if setting.IsPseudonymized() {
+		// pseudonyms are text values valid for text and binary formats
+		p.data = newData
+		return nil
 	} else if config.IsBinaryDataOperation(setting) {
 		return p.setEncryptedData(newData, setting)
 	}
@@ -354,6 +407,15 @@ func (p *pgBoundValue) GetData(setting config.ColumnEncryptionSetting) ([]byte,
 				}
 				strValue := strconv.FormatInt(value, 10)
 				decodedData = []byte(strValue)
+			default:
+				// values of other types are encrypted in text format to be decoded in any format after decryption
+				if types.HasFormatConversion(setting.GetDBDataTypeID()) {
+					textValue, err := types.DecodeFromBinary(setting.GetDBDataTypeID(), p.data)
+					if err != nil {
+						return []byte{}, err
+					}
+					decodedData = textValue
+				}
 			}
 		}
 	}
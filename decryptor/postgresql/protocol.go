@@ -28,6 +28,12 @@ type queryPacket struct {
 	bindPacket        *BindPacket
 	executePacket     *ExecutePacket
 	simpleQueryPacket string
+	// dataAccess collects data access of the query until its response is finished
+	dataAccess *base.DataAccessRecord
+	// queryStats collects timings and size of response of the query until its response is finished
+	queryStats *base.QueryStatsRecord
+	// privacyNoise adds noise to aggregates in rows of response of the query
+	privacyNoise *base.DifferentialPrivacyRecord
 }
 
 func newQueryPacket(query string) queryPacket {
@@ -66,6 +72,14 @@ func (queryPacket queryPacket) zeroize() {
 	}
 }
 
+// describeTarget is a pending request which response may contain descriptions of rows: Describe message of prepared
+// statement or portal, simple query or Sync. Describe is pending until the database responds with RowDescription or
+// NoData, simple query and Sync are pending until ReadyForQuery. Statement is nil if it's unknown
+type describeTarget struct {
+	statement          *PgPreparedStatement
+	untilReadyForQuery bool
+}
+
 // PgProtocolState keeps track of PostgreSQL protocol state.
 type PgProtocolState struct {
 	parser *sqlparser.Parser
@@ -75,6 +89,8 @@ type PgProtocolState struct {
 	// transparent encryption and type awareness to the result rows
 	pendingQueryPackets *pendingPacketsList
 	registry            base.PreparedStatementRegistry
+	// describedStatement is prepared statement which ParameterDescription or RowDescription is handled now
+	describedStatement *PgPreparedStatement
 }
 
 // PacketType describes how to handle a message packet.
@@ -92,6 +108,8 @@ const (
 	ParameterDescriptionPacket
 	ReadyForQueryPacket
 	ExecutePacketType
+	DescribePacket
+	NoDataPacket
 	OtherPacket
 )
 
@@ -101,6 +119,19 @@ func NewPgProtocolState(parser *sqlparser.Parser, registry base.PreparedStatemen
 		pendingQueryPackets: newPendingPacketsList(), registry: registry}
 }
 
+// DescribedStatement returns prepared statement which ParameterDescription or RowDescription is the last seen packet,
+// nil if the description isn't response to Describe message or described statement is unknown.
+func (p *PgProtocolState) DescribedStatement() *PgPreparedStatement {
+	return p.describedStatement
+}
+
+// AddSimpleQueryTarget remembers that simple query is sent to the database, so its RowDescription packets aren't
+// confused with responses to Describe messages sent after it. It should be called only for queries which aren't
+// blocked by AcraCensor, because the database responds only to them.
+func (p *PgProtocolState) AddSimpleQueryTarget() error {
+	return p.pendingQueryPackets.Add(describeTarget{untilReadyForQuery: true})
+}
+
 // LastPacketType returns type of the last seen packet.
 func (p *PgProtocolState) LastPacketType() PacketType {
 	return p.lastPacketType
@@ -133,27 +164,102 @@ func (p *PgProtocolState) HandleClientPacket(packet *PacketHandler) error {
 		return nil
 	}
 
+	// Describe packets request descriptions of statements and portals without execution.
+	// Remember described statement to rewrite descriptions according to its settings.
+	if packet.IsDescribe() {
+		p.lastPacketType = DescribePacket
+		return p.addDescribeTarget(packet)
+	}
+
+	// Sync finishes group of extended query messages, the database skips messages until Sync after error.
+	if packet.IsSync() {
+		p.lastPacketType = OtherPacket
+		return p.pendingQueryPackets.Add(describeTarget{untilReadyForQuery: true})
+	}
+
 	// We are not interested in other packets, just pass them through.
 	p.lastPacketType = OtherPacket
 	return nil
 }
 
+func (p *PgProtocolState) addDescribeTarget(packet *PacketHandler) error {
+	describe, err := packet.GetDescribeData()
+	if err != nil {
+		return err
+	}
+	var statement base.PreparedStatement
+	switch describe.ObjectType {
+	case DescribeStatementType:
+		statement, err = p.registry.StatementByName(describe.Name)
+	case DescribePortalType:
+		var cursor base.Cursor
+		cursor, err = p.registry.CursorByName(describe.Name)
+		if err == nil {
+			statement = cursor.PreparedStatement()
+		}
+	}
+	if err != nil {
+		// the database responds with ErrorResponse which drops the target
+		log.WithError(err).WithField("name", describe.Name).Debugln("Unknown target of Describe packet")
+	}
+	target := describeTarget{}
+	if pgStatement, ok := statement.(*PgPreparedStatement); ok {
+		target.statement = pgStatement
+	}
+	return p.pendingQueryPackets.Add(target)
+}
+
+// nextDescribeTarget returns the oldest pending describe target, nil if there is no one
+func (p *PgProtocolState) nextDescribeTarget() *describeTarget {
+	target, err := p.pendingQueryPackets.GetPendingPacket(describeTarget{})
+	if err != nil || target == nil {
+		return nil
+	}
+	typedTarget := target.(describeTarget)
+	return &typedTarget
+}
+
+// dropDescribeTargets removes pending targets of Describe messages before the next Sync or simple query. The database
+// skips the rest of messages until Sync after ErrorResponse, so these Describe messages aren't answered.
+func (p *PgProtocolState) dropDescribeTargets() error {
+	for target := p.nextDescribeTarget(); target != nil && !target.untilReadyForQuery; target = p.nextDescribeTarget() {
+		if err := p.pendingQueryPackets.RemoveNextPendingPacket(describeTarget{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // HandleDatabasePacket observes a packet with database response,
 // extracts useful information from it, and confirms client requests.
 func (p *PgProtocolState) HandleDatabasePacket(packet *PacketHandler) error {
+	p.describedStatement = nil
 	// This is data response to the previously issued query.
 	if packet.IsDataRow() {
 		p.lastPacketType = DataPacket
 		return nil
 	}
 
-	if packet.IsRowDescription() {
-		p.lastPacketType = RowDescriptionPacket
-		return nil
+	// RowDescription and NoData finish response to Describe, ParameterDescription precedes them
+	// in response to Describe of prepared statement.
+	if packet.IsRowDescription() || packet.IsNoData() {
+		p.lastPacketType = NoDataPacket
+		if packet.IsRowDescription() {
+			p.lastPacketType = RowDescriptionPacket
+		}
+		target := p.nextDescribeTarget()
+		if target == nil || target.untilReadyForQuery {
+			return nil
+		}
+		p.describedStatement = target.statement
+		return p.pendingQueryPackets.RemoveNextPendingPacket(describeTarget{})
 	}
 
 	if packet.IsParameterDescription() {
 		p.lastPacketType = ParameterDescriptionPacket
+		if target := p.nextDescribeTarget(); target != nil && !target.untilReadyForQuery {
+			p.describedStatement = target.statement
+		}
 		return nil
 	}
 
@@ -167,6 +273,12 @@ func (p *PgProtocolState) HandleDatabasePacket(packet *PacketHandler) error {
 		return nil
 	}
 
+	if packet.IsErrorResponse() {
+		if err := p.dropDescribeTargets(); err != nil {
+			return err
+		}
+	}
+
 	if packet.IsCommandComplete() || packet.IsEmptyQueryResponse() || packet.IsPortalSuspended() || packet.IsErrorResponse() {
 		p.lastPacketType = OtherPacket
 		pendingQueryPacket, err := p.pendingQueryPackets.GetPendingPacket(queryPacket{})
@@ -192,6 +304,13 @@ func (p *PgProtocolState) HandleDatabasePacket(packet *PacketHandler) error {
 	// There is nothing interesting in the packet otherwise.
 	if packet.IsReadyForQuery() {
 		p.lastPacketType = ReadyForQueryPacket
+		// ReadyForQuery finishes Sync or simple query, all their Describe messages are answered
+		if err := p.dropDescribeTargets(); err != nil {
+			return err
+		}
+		if target := p.nextDescribeTarget(); target != nil {
+			return p.pendingQueryPackets.RemoveNextPendingPacket(describeTarget{})
+		}
 		return nil
 	}
 
@@ -14,6 +14,12 @@ limitations under the License.
 package postgresql
 
 import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
 	"github.com/cossacklabs/acra/crypto"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
@@ -22,8 +28,10 @@ import (
 	hashDecryptor "github.com/cossacklabs/acra/hmac/decryptor"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/masking"
+	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/pseudonymization"
 	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/wasm"
 )
 
 type proxyFactory struct {
@@ -41,6 +49,34 @@ func NewProxyFactory(proxySetting base.ProxySetting, store keystore.DecryptionKe
 	}, nil
 }
 
+// startupMessageMaxLength limits length of the first message read from rejected client, the same as PostgreSQL does
+const startupMessageMaxLength = 10000
+
+// RejectConnection reads the first client's message (SSLRequest or StartupMessage) and responds with FATAL
+// ErrorResponse with reason, so client reports it instead of broken connection
+func (factory *proxyFactory) RejectConnection(connection net.Conn, reason error) error {
+	if err := connection.SetDeadline(time.Now().Add(network.DefaultNetworkTimeout)); err != nil {
+		return err
+	}
+	lengthBuf := make([]byte, 4)
+	if _, err := io.ReadFull(connection, lengthBuf); err != nil {
+		return err
+	}
+	// message length includes itself
+	if length := binary.BigEndian.Uint32(lengthBuf); length > 4 && length <= startupMessageMaxLength {
+		if _, err := io.CopyN(io.Discard, connection, int64(length-4)); err != nil {
+			return err
+		}
+	}
+	code := pgRejectedEstablishmentCode
+	if errors.Is(reason, base.ErrTooManySessions) {
+		code = pgTooManyConnectionsCode
+	}
+	errorMessage := newPgFatalError(code, "AcraServer: "+reason.Error())
+	n, err := connection.Write(errorMessage)
+	return base.CheckReadWrite(n, len(errorMessage), err)
+}
+
 // New return postgresql proxy implementation
 func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSession) (base.Proxy, error) {
 	sqlParser := factory.setting.SQLParser()
@@ -83,9 +119,25 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 		poisonDetector.SetPoisonRecordCallbacks(factory.setting.PoisonRecordCallbackStorage())
 
 		envelopeDetector.AddCallback(poisonDetector)
+
+		if factory.setting.InboundPoisonRecordDetection() {
+			// separate detector to not decrypt inbound data with other callbacks
+			inboundDetector := crypto.NewEnvelopeDetector()
+			inboundDetector.AddCallback(poisonDetector)
+			proxy.AddQueryObserver(crypto.NewPostgresqlPoisonRecordQueryDetector(inboundDetector))
+		}
 	}
 
 	chainEncryptors := make([]encryptor.DataEncryptor, 0, 10)
+	var wasmProcessor *wasm.Processor
+	if storeMask&config.SettingWasmProcessorFlag == config.SettingWasmProcessorFlag {
+		wasmProcessor, err = wasm.GetDefaultProcessor()
+		if err != nil {
+			return nil, err
+		}
+		// user-defined functions work with plaintext values before tokenization and encryption
+		chainEncryptors = append(chainEncryptors, wasmProcessor)
+	}
 	if storeMask&config.SettingTokenizationFlag == config.SettingTokenizationFlag {
 		tokenizer, err := pseudonymization.NewDataTokenizer(factory.tokenizer)
 		if err != nil {
@@ -107,6 +159,18 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 		proxy.AddQueryObserver(acraBlockStructTokenEncryptor)
 	}
 
+	if storeMask&config.SettingPseudonymizationFlag == config.SettingPseudonymizationFlag {
+		pseudonymizationKeyStore, ok := factory.keystore.(keystore.PseudonymizationKeyStore)
+		if !ok {
+			return nil, keystore.ErrPseudonymizationNotSupported
+		}
+		pseudonymizer, err := pseudonymization.NewPseudonymizer(pseudonymizationKeyStore, factory.setting.PseudonymStorage())
+		if err != nil {
+			return nil, err
+		}
+		chainEncryptors = append(chainEncryptors, pseudonymizer)
+	}
+
 	chainEncryptors = append(chainEncryptors, crypto.NewEncryptHandler(registryHandler))
 
 	var hmacProcessor *hmac.Processor
@@ -154,7 +218,19 @@ func (factory *proxyFactory) New(clientID []byte, clientSession base.ClientSessi
 	if err != nil {
 		return nil, err
 	}
+	queryEncryptor.SetColumnCopyPolicy(factory.setting.ColumnCopyPolicy())
 	proxy.AddQueryObserver(queryEncryptor)
+	if wasmProcessor != nil {
+		proxy.SubscribeOnAllColumnsDecryption(wasmProcessor)
+	}
+	// transforms work with decrypted values before they are encoded for the client
+	if columnTransforms := factory.setting.ColumnTransforms(); columnTransforms != nil {
+		proxy.SubscribeOnAllColumnsDecryption(columnTransforms)
+	}
+	// anonymization is applied last, so analytics clients don't receive values changed only by transforms
+	if anonymizationPolicy := factory.setting.AnonymizationPolicy(); anonymizationPolicy != nil {
+		proxy.SubscribeOnAllColumnsDecryption(anonymizationPolicy)
+	}
 	// register last to encode all data into correct format according to client/database requested formats
 	// and ColumnEncryptionSetting
 	proxy.SubscribeOnAllColumnsDecryption(encoderProcessor)
@@ -1,7 +1,9 @@
 package postgresql
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"testing"
@@ -144,6 +146,10 @@ func (stubSession) ClientConnection() net.Conn {
 	return nil
 }
 
+func (stubSession) ClientAddress() net.Addr {
+	return nil
+}
+
 func (stubSession) DatabaseConnection() net.Conn {
 	return nil
 }
@@ -192,3 +198,42 @@ func TestEncryptorTurnOnOff(t *testing.T) {
 		t.Fatal("Unexpected observers count")
 	}
 }
+
+func TestRejectConnection(t *testing.T) {
+	testcases := []struct {
+		firstMessage []byte
+		reason       error
+		code         string
+	}{
+		{SSLRequestHeader, base.ErrTooManySessions, pgTooManyConnectionsCode},
+		// StartupMessage with protocol version and user parameter
+		{[]byte("\x00\x00\x00\x13\x00\x03\x00\x00user\x00test\x00\x00"), fmt.Errorf("%w of clientID", base.ErrTooManySessions), pgTooManyConnectionsCode},
+		{SSLRequestHeader, base.ErrPlaintextConnectionDenied, pgRejectedEstablishmentCode},
+	}
+	factory := &proxyFactory{}
+	for i, tcase := range testcases {
+		client, server := net.Pipe()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- factory.RejectConnection(server, tcase.reason)
+			server.Close()
+		}()
+		if _, err := client.Write(tcase.firstMessage); err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		response, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		expected := newPgFatalError(tcase.code, "AcraServer: "+tcase.reason.Error())
+		if !bytes.Equal(response, expected) {
+			t.Fatalf("[%d] Expected %q, took %q", i, expected, response)
+		}
+		if !bytes.Contains(response, []byte("SFATAL\x00")) || !bytes.Contains(response, []byte("C"+tcase.code+"\x00")) {
+			t.Fatalf("[%d] Response doesn't contain severity and code: %q", i, response)
+		}
+	}
+}
@@ -281,3 +281,50 @@ func (step *CollectDataRowsStep) Step(frontend *Frontend) error {
 	}
 	return nil
 }
+
+// CollectDescriptionStep used to receive and store OIDs from ParameterDescription and RowDescription responses on
+// Describe packet
+type CollectDescriptionStep struct {
+	parameterOIDs []uint32
+	columnOIDs    []uint32
+}
+
+// NewCollectDescriptionStep creates step that collects OIDs of parameters and columns of described statement or portal
+func NewCollectDescriptionStep() *CollectDescriptionStep {
+	return &CollectDescriptionStep{}
+}
+
+// GetParameterOIDs returns collected OIDs of parameters, nil if database didn't send ParameterDescription
+func (step *CollectDescriptionStep) GetParameterOIDs() []uint32 {
+	return step.parameterOIDs
+}
+
+// GetColumnOIDs returns collected OIDs of result columns, nil if database responded with NoData
+func (step *CollectDescriptionStep) GetColumnOIDs() []uint32 {
+	return step.columnOIDs
+}
+
+// Step receives optional ParameterDescription packet and RowDescription or NoData packet
+func (step *CollectDescriptionStep) Step(frontend *Frontend) error {
+	step.parameterOIDs, step.columnOIDs = nil, nil
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			return err
+		}
+		switch description := msg.(type) {
+		case *pgproto3.ParameterDescription:
+			step.parameterOIDs = append([]uint32{}, description.ParameterOIDs...)
+		case *pgproto3.RowDescription:
+			step.columnOIDs = make([]uint32, 0, len(description.Fields))
+			for _, field := range description.Fields {
+				step.columnOIDs = append(step.columnOIDs, field.DataTypeOID)
+			}
+			return nil
+		case *pgproto3.NoData:
+			return nil
+		default:
+			return fmt.Errorf("msg => %#v, e.want => %#v", msg, &pgproto3.RowDescription{})
+		}
+	}
+}
@@ -51,6 +51,15 @@ func (p *DataTypeFormat) GetColumnName() string {
 	return p.columnSetting.ColumnName()
 }
 
+// mapSettingToOID returns OID of user-defined domain or enum from setting if it's set, otherwise OID of data type
+func mapSettingToOID(setting config.ColumnEncryptionSetting) (uint32, bool) {
+	newOID, ok := mapEncryptedTypeToOID(setting.GetDBDataTypeID())
+	if ok && setting.GetDBTypeOID() != 0 {
+		return setting.GetDBTypeOID(), true
+	}
+	return newOID, ok
+}
+
 func mapEncryptedTypeToOID(dataTypeID uint32) (uint32, bool) {
 	pgsqlEncoders := type_awareness.GetPostgreSQLDataTypeIDEncoders()
 	if _, ok := pgsqlEncoders[dataTypeID]; !ok {
@@ -0,0 +1,277 @@
+package postgresql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/cmd/acra-server/common"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+// Snapshots of RowDescription/ParameterDescription transformations are stored in testdata and should be updated
+// only together with intended changes of mapEncryptedTypeToOID or encoders:
+//
+//	go test ./decryptor/postgresql/ -run TestTypeAwareOIDRewritingGolden -update_golden
+var updateGolden = flag.Bool("update_golden", false, "Rewrite golden files in testdata with current results")
+
+const typeAwareOIDsGoldenFile = "type_aware_oids.golden"
+
+const oidRewritingTable = "test_table"
+
+// driverTypeNames maps OIDs to types which JDBC (java.sql.Types) and psycopg use to decode values of the column.
+// pgx types are taken from pgtype.Map
+var driverTypeNames = map[uint32]struct {
+	jdbc    string
+	psycopg string
+}{
+	pgtype.TextOID:  {"VARCHAR", "str"},
+	pgtype.ByteaOID: {"BINARY", "bytes"},
+	pgtype.Int4OID:  {"INTEGER", "int"},
+	pgtype.Int8OID:  {"BIGINT", "int"},
+}
+
+// tokenTypeOIDs maps token_type to type of the column in the database, tokens are stored as values of the same type
+var tokenTypeOIDs = map[string]uint32{
+	"str":   pgtype.TextOID,
+	"bytes": pgtype.ByteaOID,
+	"int32": pgtype.Int4OID,
+	"int64": pgtype.Int8OID,
+}
+
+// oidRewritingCase describes column configured in encryptor config
+type oidRewritingCase struct {
+	mode     string
+	dataType string
+	envelope config.CryptoEnvelopeType
+}
+
+func (c oidRewritingCase) name() string {
+	if c.mode == "plaintext" {
+		return c.mode
+	}
+	dataType := c.dataType
+	if dataType == "" {
+		dataType = "none"
+	}
+	return fmt.Sprintf("%s/%s/%s", c.mode, dataType, c.envelope)
+}
+
+func (c oidRewritingCase) columnName() string {
+	return strings.ReplaceAll(c.name(), "/", "_")
+}
+
+// databaseOID returns type of the column in the database
+func (c oidRewritingCase) databaseOID() uint32 {
+	switch c.mode {
+	case "plaintext":
+		return pgtype.TextOID
+	case "tokenization":
+		return tokenTypeOIDs[c.dataType]
+	default:
+		// encrypted data stored as binary
+		return pgtype.ByteaOID
+	}
+}
+
+// columnConfig returns settings of the column for encryptor config or empty string if column is not encrypted
+func (c oidRewritingCase) columnConfig() string {
+	if c.mode == "plaintext" {
+		return ""
+	}
+	lines := []string{
+		"      - column: " + c.columnName(),
+		"        crypto_envelope: " + string(c.envelope),
+	}
+	if c.dataType != "" && c.mode != "tokenization" {
+		lines = append(lines, "        data_type: "+c.dataType)
+	}
+	switch c.mode {
+	case "searchable":
+		lines = append(lines, "        searchable: true")
+	case "masking":
+		lines = append(lines, `        masking: "xxxx"`, "        plaintext_length: 2", `        plaintext_side: "right"`)
+	case "tokenization":
+		lines = append(lines, "        token_type: "+c.dataType)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// oidRewritingCases returns matrix of column types, envelopes and encryptor config modes with valid configurations
+func oidRewritingCases() []oidRewritingCase {
+	cases := []oidRewritingCase{{mode: "plaintext"}}
+	dataTypes := []string{"", "str", "bytes", "int32", "int64"}
+	for _, envelope := range []config.CryptoEnvelopeType{config.CryptoEnvelopeTypeAcraStruct, config.CryptoEnvelopeTypeAcraBlock} {
+		for _, mode := range []string{"encryption", "searchable"} {
+			for _, dataType := range dataTypes {
+				cases = append(cases, oidRewritingCase{mode, dataType, envelope})
+			}
+		}
+		cases = append(cases, oidRewritingCase{"masking", "", envelope})
+		// masking with type awareness supported only by AcraBlocks
+		if envelope == config.CryptoEnvelopeTypeAcraBlock {
+			cases = append(cases, oidRewritingCase{"masking", "str", envelope}, oidRewritingCase{"masking", "bytes", envelope})
+		}
+		for _, tokenType := range dataTypes[1:] {
+			cases = append(cases, oidRewritingCase{"tokenization", tokenType, envelope})
+		}
+	}
+	return cases
+}
+
+func oidRewritingSchemaStore(t *testing.T, cases []oidRewritingCase) config.TableSchemaStore {
+	columns := make([]string, 0, len(cases))
+	encrypted := make([]string, 0, len(cases))
+	for _, tcase := range cases {
+		columns = append(columns, "      - "+tcase.columnName()+"\n")
+		encrypted = append(encrypted, tcase.columnConfig())
+	}
+	encryptorConfig := fmt.Sprintf("schemas:\n  - table: %s\n    columns:\n%s    encrypted:\n%s",
+		oidRewritingTable, strings.Join(columns, ""), strings.Join(encrypted, ""))
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(encryptorConfig), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatalf("Can't load encryptor config: %s\n%s", err, encryptorConfig)
+	}
+	return schemaStore
+}
+
+// readTestPacket returns PacketHandler with packet read from database side
+func readTestPacket(t *testing.T, data []byte) *PacketHandler {
+	output := bytes.NewBuffer(nil)
+	packet, err := NewDbSidePacketHandler(bytes.NewReader(data), bufio.NewWriter(output), logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := packet.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// describeOID returns line of snapshot for OID returned to the client
+func describeOID(t *testing.T, typeMap *pgtype.Map, message, name, format string, sourceOID, resultOID uint32) string {
+	pgxType, ok := typeMap.TypeForOID(resultOID)
+	if !ok {
+		t.Fatalf("[%s] pgx doesn't support OID %d", name, resultOID)
+	}
+	driverTypes, ok := driverTypeNames[resultOID]
+	if !ok {
+		t.Fatalf("[%s] Unexpected OID %d without known driver types", name, resultOID)
+	}
+	return fmt.Sprintf("%s %s format=%s oid=%d->%d pgx=%s jdbc=%s psycopg=%s",
+		message, name, format, sourceOID, resultOID, pgxType.Name, driverTypes.jdbc, driverTypes.psycopg)
+}
+
+func TestTypeAwareOIDRewritingGolden(t *testing.T) {
+	cases := oidRewritingCases()
+	schemaStore := oidRewritingSchemaStore(t, cases)
+	tableSchema := schemaStore.GetTableSchema(oidRewritingTable)
+	typeMap := pgtype.NewMap()
+	proxy := &PgProxy{}
+	logger := logrus.NewEntry(logrus.New())
+
+	snapshot := &strings.Builder{}
+	for _, tcase := range cases {
+		var setting config.ColumnEncryptionSetting
+		if tcase.mode != "plaintext" {
+			setting = tableSchema.GetColumnEncryptionSettings(tcase.columnName())
+			if setting == nil {
+				t.Fatalf("[%s] Column setting not found", tcase.name())
+			}
+		}
+		sourceOID := tcase.databaseOID()
+
+		for _, format := range []base.BoundValueFormat{base.TextFormat, base.BinaryFormat} {
+			formatName := "text"
+			if format == base.BinaryFormat {
+				formatName = "binary"
+			}
+			clientSession, err := common.NewClientSession(context.Background(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := base.SetClientSessionToContext(context.Background(), clientSession)
+			var item *encryptor.QueryDataItem
+			if setting != nil {
+				item = encryptor.NewQueryDataItem(setting, oidRewritingTable, tcase.columnName(), "")
+			}
+			encryptor.SaveQueryDataItemsToClientSession(clientSession, []*encryptor.QueryDataItem{item})
+
+			rowDescription := &pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{
+				Name:         []byte(tcase.columnName()),
+				DataTypeOID:  sourceOID,
+				DataTypeSize: -1,
+				TypeModifier: -1,
+				Format:       int16(format),
+			}}}
+			packet := readTestPacket(t, rowDescription.Encode(nil))
+			if err := proxy.handleRowDescription(ctx, packet, logger); err != nil {
+				t.Fatalf("[%s] Can't handle RowDescription: %s", tcase.name(), err)
+			}
+			result, err := packet.GetRowDescriptionData()
+			if err != nil {
+				t.Fatalf("[%s] Invalid RowDescription after processing: %s", tcase.name(), err)
+			}
+			field := result.Fields[0]
+			if field.Format != int16(format) {
+				t.Fatalf("[%s] Format of column changed from %d to %d", tcase.name(), format, field.Format)
+			}
+			pgxType, _ := typeMap.TypeForOID(field.DataTypeOID)
+			if pgxType != nil && !pgxType.Codec.FormatSupported(field.Format) {
+				t.Fatalf("[%s] pgx doesn't support %s format of OID %d", tcase.name(), formatName, field.DataTypeOID)
+			}
+			snapshot.WriteString(describeOID(t, typeMap, "RowDescription", tcase.name(), formatName, sourceOID, field.DataTypeOID) + "\n")
+		}
+
+		clientSession, err := common.NewClientSession(context.Background(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := base.SetClientSessionToContext(context.Background(), clientSession)
+		encryptor.PlaceholderSettingsFromClientSession(clientSession)[0] = setting
+		parameterDescription := &pgproto3.ParameterDescription{ParameterOIDs: []uint32{sourceOID}}
+		packet := readTestPacket(t, parameterDescription.Encode(nil))
+		if err := proxy.handleParameterDescription(ctx, packet, logger); err != nil {
+			t.Fatalf("[%s] Can't handle ParameterDescription: %s", tcase.name(), err)
+		}
+		result, err := packet.GetParameterDescriptionData()
+		if err != nil {
+			t.Fatalf("[%s] Invalid ParameterDescription after processing: %s", tcase.name(), err)
+		}
+		snapshot.WriteString(describeOID(t, typeMap, "ParameterDescription", tcase.name(), "none", sourceOID, result.ParameterOIDs[0]) + "\n")
+	}
+
+	goldenPath := filepath.Join("testdata", typeAwareOIDsGoldenFile)
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, []byte(snapshot.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Can't read golden file, run test with -update_golden to create it: %s", err)
+	}
+	expectedLines := strings.Split(strings.TrimSpace(string(expected)), "\n")
+	resultLines := strings.Split(strings.TrimSpace(snapshot.String()), "\n")
+	if len(expectedLines) != len(resultLines) {
+		t.Fatalf("Expected %d transformations, took %d", len(expectedLines), len(resultLines))
+	}
+	for i := range expectedLines {
+		if expectedLines[i] != resultLines[i] {
+			t.Fatalf("Transformation differs from %s:\nexpected: %s\ntook:     %s", goldenPath, expectedLines[i], resultLines[i])
+		}
+	}
+}
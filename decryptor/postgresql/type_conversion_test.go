@@ -1,8 +1,10 @@
 package postgresql
 
 import (
-	"github.com/jackc/pgx/v5/pgtype"
 	"testing"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 func Test_mapEncryptedTypeToOID(t *testing.T) {
@@ -34,3 +36,28 @@ func Test_mapEncryptedTypeToOID(t *testing.T) {
 		})
 	}
 }
+
+func Test_mapSettingToOID(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting *config.BasicColumnEncryptionSetting
+		want    uint32
+		want1   bool
+	}{
+		{"data type", &config.BasicColumnEncryptionSetting{DataTypeID: pgtype.TextOID}, pgtype.TextOID, true},
+		{"domain", &config.BasicColumnEncryptionSetting{DataTypeID: pgtype.Int4OID, DBTypeOID: 16400}, 16400, true},
+		{"enum", &config.BasicColumnEncryptionSetting{DataTypeID: pgtype.TextOID, DBTypeOID: 16500}, 16500, true},
+		{"without data type", &config.BasicColumnEncryptionSetting{DBTypeOID: 16400}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1 := mapSettingToOID(tt.setting)
+			if got != tt.want {
+				t.Errorf("mapSettingToOID() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("mapSettingToOID() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ func (t *ByteaDataTypeEncoder) Encode(ctx context.Context, data []byte, format t
 		ctx, value, err := t.EncodeOnFail(ctx, format)
 		if err != nil {
 			return ctx, nil, err
-		} else if value != nil {
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
 			return ctx, value, nil
 		}
 	}
@@ -80,6 +80,9 @@ func (t *ByteaDataTypeEncoder) EncodeOnFail(ctx context.Context, format type_awa
 		}
 		return t.encodeDefault(ctx, []byte(*strValue), format)
 
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
 	case common.ResponseOnFailError:
 		return nil, nil, base.NewEncodingError(format.GetColumnName())
 	}
@@ -35,7 +35,7 @@ func (t *Int8DataTypeEncoder) Encode(ctx context.Context, data []byte, format ty
 		ctx, value, err := t.EncodeOnFail(ctx, format)
 		if err != nil {
 			return ctx, nil, err
-		} else if value != nil {
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
 			return ctx, value, nil
 		}
 	}
@@ -94,6 +94,9 @@ func (t *Int8DataTypeEncoder) EncodeOnFail(ctx context.Context, format type_awar
 		}
 		return t.encodeDefault(ctx, []byte(*strValue), format)
 
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
 	case common.ResponseOnFailError:
 		return nil, nil, base.NewEncodingError(format.GetColumnName())
 	}
@@ -0,0 +1,206 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/base/type_awareness"
+	"github.com/cossacklabs/acra/encryptor/config/common"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/jackc/pgx/v5/pgtype"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnsupportedFormatConversion returned when value of data type can't be converted between text and binary formats
+var ErrUnsupportedFormatConversion = errors.New("conversion between text and binary formats is not supported for data type")
+
+// pgTypeValues returns new pgtype value used to convert values of data type between text and binary formats. Values
+// keep infinity and NaN which can't be stored in time.Time or float64. Integers, text and bytea have own encoders
+var pgTypeValues = map[uint32]func() interface{}{
+	pgtype.NumericOID:     func() interface{} { return &pgtype.Numeric{} },
+	pgtype.DateOID:        func() interface{} { return &pgtype.Date{} },
+	pgtype.TimestampOID:   func() interface{} { return &pgtype.Timestamp{} },
+	pgtype.TimestamptzOID: func() interface{} { return &pgtype.Timestamptz{} },
+	pgtype.UUIDOID:        func() interface{} { return &pgtype.UUID{} },
+}
+
+// pgtype.Map caches encoding plans and isn't safe for concurrent use
+var typeMaps = sync.Pool{New: func() interface{} { return pgtype.NewMap() }}
+
+// EncodeToBinary converts value of data type from text format to binary format
+func EncodeToBinary(dataTypeID uint32, data []byte) ([]byte, error) {
+	return convertFormat(dataTypeID, pgtype.TextFormatCode, pgtype.BinaryFormatCode, data)
+}
+
+// DecodeFromBinary converts value of data type from binary format to text format
+func DecodeFromBinary(dataTypeID uint32, data []byte) ([]byte, error) {
+	return convertFormat(dataTypeID, pgtype.BinaryFormatCode, pgtype.TextFormatCode, data)
+}
+
+// HasFormatConversion returns true if values of data type can be converted between text and binary formats
+func HasFormatConversion(dataTypeID uint32) bool {
+	_, ok := pgTypeValues[dataTypeID]
+	return ok
+}
+
+func convertFormat(dataTypeID uint32, from, to int16, data []byte) ([]byte, error) {
+	newValue, ok := pgTypeValues[dataTypeID]
+	if !ok {
+		return nil, ErrUnsupportedFormatConversion
+	}
+	typeMap := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(typeMap)
+	value := newValue()
+	if err := typeMap.Scan(dataTypeID, from, data, value); err != nil {
+		return nil, err
+	}
+	if numeric, ok := value.(*pgtype.Numeric); ok && to == pgtype.TextFormatCode && numeric.Valid && !numeric.NaN &&
+		numeric.InfinityModifier == pgtype.Finite {
+		return numericText(numeric), nil
+	}
+	return typeMap.Encode(dataTypeID, to, value, nil)
+}
+
+// numericText formats finite numeric in the same way as PostgreSQL. pgtype formats negative numbers with fewer digits
+// than scale incorrectly, for example -0.0001 as "0.00-1"
+func numericText(numeric *pgtype.Numeric) []byte {
+	digits := new(big.Int).Abs(numeric.Int).String()
+	if numeric.Exp >= 0 {
+		digits += strings.Repeat("0", int(numeric.Exp))
+	} else {
+		scale := int(-numeric.Exp)
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if numeric.Int.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return []byte(digits)
+}
+
+// PgTypeDataTypeEncoder is encoder of PostgreSQL types which are stored encrypted as text values and converted
+// to binary format with pgtype
+type PgTypeDataTypeEncoder struct {
+	dataTypeID uint32
+}
+
+// NewPgTypeDataTypeEncoder create new PgTypeDataTypeEncoder for data type
+func NewPgTypeDataTypeEncoder(dataTypeID uint32) *PgTypeDataTypeEncoder {
+	return &PgTypeDataTypeEncoder{dataTypeID: dataTypeID}
+}
+
+// Encode implementation of Encode method of DataTypeEncoder interface
+func (t *PgTypeDataTypeEncoder) Encode(ctx context.Context, data []byte, format type_awareness.DataTypeFormat) (context.Context, []byte, error) {
+	// if it's valid string literal of the type, return it in requested format
+	value, err := t.encodeText(data, format)
+	if err == nil {
+		return ctx, value, nil
+	}
+
+	if !base.IsDecryptedFromContext(ctx) {
+		ctx, value, err := t.EncodeOnFail(ctx, format)
+		if err != nil {
+			return ctx, nil, err
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
+			return ctx, value, nil
+		}
+		return ctx, data, nil
+	}
+
+	logger := logging.GetLoggerFromContext(ctx).WithError(err).WithField("data_type_id", t.dataTypeID)
+	if format.IsBinaryFormat() {
+		// decrypted value which isn't valid literal can't be represented in binary format and will break decoding
+		// on the client's side
+		logger.Errorln("Can't encode decrypted value to binary format")
+		return ctx, nil, base.NewEncodingError(format.GetColumnName())
+	}
+	logger.Warningln("Can't encode decrypted value")
+	return ctx, data, nil
+}
+
+func (t *PgTypeDataTypeEncoder) encodeText(data []byte, format type_awareness.DataTypeFormat) ([]byte, error) {
+	if format.IsBinaryFormat() {
+		return EncodeToBinary(t.dataTypeID, data)
+	}
+	// validate that it's valid literal
+	if _, err := EncodeToBinary(t.dataTypeID, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Decode implementation of Decode method of DataTypeEncoder interface
+func (t *PgTypeDataTypeEncoder) Decode(ctx context.Context, data []byte, format type_awareness.DataTypeFormat) (context.Context, []byte, error) {
+	// encrypted values are stored as bytea and in binary format they are received as is
+	if format.IsBinaryFormat() {
+		return ctx, data, nil
+	}
+
+	if format.IsBinaryDataOperation() {
+		// decryptor operates over blobs so all data types will be encrypted as hex/octal string values that we should
+		// decode before decryption
+		decodedData, err := utils.DecodeEscaped(data)
+		if err != nil {
+			if err == utils.ErrDecodeOctalString {
+				return ctx, data, nil
+			}
+			log.WithError(err).Errorln("Can't decode binary data for decryption")
+			return ctx, data, err
+		}
+		// save encoded value on successful decoding to return it as same value if it cannot be decrypted
+		return base.EncodedValueContext(ctx, data), decodedData, nil
+	}
+
+	// all other non-binary data should be valid SQL literals and Acra works with them as is
+	return ctx, data, nil
+}
+
+// EncodeOnFail implementation of EncodeOnFail method of DataTypeEncoder interface
+func (t *PgTypeDataTypeEncoder) EncodeOnFail(ctx context.Context, format type_awareness.DataTypeFormat) (context.Context, []byte, error) {
+	action := format.GetResponseOnFail()
+	switch action {
+	case common.ResponseOnFailEmpty, common.ResponseOnFailCiphertext:
+		return ctx, nil, nil
+
+	case common.ResponseOnFailDefault:
+		strValue := format.GetDefaultDataValue()
+		if strValue == nil {
+			log.Errorln("Default value is not specified")
+			return ctx, nil, nil
+		}
+		value, err := t.encodeText([]byte(*strValue), format)
+		if err != nil {
+			log.WithError(err).Errorln("Can't encode default value")
+			return ctx, nil, err
+		}
+		return ctx, value, nil
+
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
+	case common.ResponseOnFailError:
+		return nil, nil, base.NewEncodingError(format.GetColumnName())
+	}
+
+	return ctx, nil, fmt.Errorf("unknown action: %q", action)
+}
+
+// ValidateDefaultValue implementation of ValidateDefaultValue method of DataTypeEncoder interface
+func (t *PgTypeDataTypeEncoder) ValidateDefaultValue(value *string) error {
+	_, err := EncodeToBinary(t.dataTypeID, []byte(*value))
+	return err
+}
+
+func init() {
+	for dataTypeID := range pgTypeValues {
+		type_awareness.RegisterPostgreSQLDataTypeIDEncoder(dataTypeID, NewPgTypeDataTypeEncoder(dataTypeID))
+	}
+}
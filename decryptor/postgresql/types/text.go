@@ -22,7 +22,7 @@ func (t *TextDataType) Encode(ctx context.Context, data []byte, format type_awar
 		ctx, value, err := t.EncodeOnFail(ctx, format)
 		if err != nil {
 			return ctx, nil, err
-		} else if value != nil {
+		} else if value != nil || base.IsNullValueFromContext(ctx) {
 			return ctx, value, nil
 		}
 	}
@@ -72,6 +72,9 @@ func (t *TextDataType) EncodeOnFail(ctx context.Context, format type_awareness.D
 		}
 		return ctx, []byte(*strValue), nil
 
+	case common.ResponseOnFailNull:
+		return base.MarkNullValueContext(ctx), nil, nil
+
 	case common.ResponseOnFailError:
 		return nil, nil, base.NewEncodingError(format.GetColumnName())
 	}
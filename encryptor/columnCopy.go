@@ -0,0 +1,268 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqlparser"
+)
+
+// copySource describes provenance of value stored into column by INSERT ... SELECT or UPDATE queries
+type copySource struct {
+	// literal is true for values passed in the query which are encrypted by AcraServer
+	literal bool
+	// known is false if value derived from columns by expression or column can't be matched to the table
+	known   bool
+	table   string
+	column  string
+	setting config.ColumnEncryptionSetting
+}
+
+// hasColumnReferences returns true if expression uses values of columns stored in the database
+func hasColumnReferences(expr sqlparser.SQLNode) bool {
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if _, ok := node.(*sqlparser.ColName); ok {
+			found = true
+			return false, nil
+		}
+		return !found, nil
+	}, expr)
+	return found
+}
+
+// resolveCopySource traces column used as value of expr to the table from fromTables and its encryption setting
+func (encryptor *QueryDataEncryptor) resolveCopySource(expr sqlparser.Expr, fromTables sqlparser.TableExprs) copySource {
+	if !hasColumnReferences(expr) {
+		return copySource{literal: true, known: true}
+	}
+	for {
+		paren, ok := expr.(*sqlparser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	colName, ok := expr.(*sqlparser.ColName)
+	if !ok {
+		return copySource{}
+	}
+	info, err := findColumnInfo(fromTables, colName, encryptor.schemaStore)
+	if err != nil {
+		// column without qualifier which doesn't belong to any table from encryptor config is not encrypted
+		if colName.Qualifier.IsEmpty() && err == errAliasedTableNotMatched {
+			return copySource{known: true, column: colName.Name.ValueForConfig()}
+		}
+		return copySource{}
+	}
+	source := copySource{known: true, table: info.Table, column: info.Name}
+	if schema := encryptor.schemaStore.GetTableSchema(info.Table); schema != nil {
+		source.setting = schema.GetColumnEncryptionSettings(info.Name)
+	}
+	return source
+}
+
+// selectSources returns sources of values returned by SELECT in order of columns. Tables selected with * are expanded
+// according to the list of columns from encryptor config. Returns false if sources of some columns can't be found,
+// sources of the rest columns are unknown in this case
+func (encryptor *QueryDataEncryptor) selectSources(statement sqlparser.SelectStatement) ([]copySource, bool) {
+	selectStatement, ok := statement.(*sqlparser.Select)
+	if !ok {
+		// UNION and parenthesized selects are not traced
+		return nil, false
+	}
+	tables := GetTablesWithAliases(selectStatement.From)
+	qualifierMap := NewAliasToTableMapFromTables(tables)
+	sources := make([]copySource, 0, len(selectStatement.SelectExprs))
+	for _, selectExpr := range selectStatement.SelectExprs {
+		switch expr := selectExpr.(type) {
+		case *sqlparser.AliasedExpr:
+			sources = append(sources, encryptor.resolveCopySource(expr.Expr, selectStatement.From))
+		case *sqlparser.StarExpr:
+			var tableNames []string
+			if expr.TableName.Name.IsEmpty() {
+				for _, table := range tables {
+					tableNames = append(tableNames, table.TableName.Name.ValueForConfig())
+				}
+			} else {
+				tableName, ok := qualifierMap[expr.TableName.Name.ValueForConfig()]
+				if !ok {
+					return sources, false
+				}
+				tableNames = []string{tableName}
+			}
+			if len(tableNames) == 0 {
+				return sources, false
+			}
+			for _, tableName := range tableNames {
+				schema := encryptor.schemaStore.GetTableSchema(tableName)
+				if schema == nil || len(schema.Columns()) == 0 {
+					return sources, false
+				}
+				for _, column := range schema.Columns() {
+					sources = append(sources, copySource{known: true, table: tableName, column: column,
+						setting: schema.GetColumnEncryptionSettings(column)})
+				}
+			}
+		default:
+			return sources, false
+		}
+	}
+	return sources, true
+}
+
+// compatibleColumnSettings returns true if data processed according to source setting may be stored as is into column
+// with destination setting. nil setting means not encrypted column
+func compatibleColumnSettings(destination, source config.ColumnEncryptionSetting) bool {
+	if destination == nil || source == nil {
+		return destination == nil && source == nil
+	}
+	return bytes.Equal(destination.ClientID(), source.ClientID()) &&
+		destination.GetCryptoEnvelope() == source.GetCryptoEnvelope() &&
+		destination.GetAcraBlockCipher() == source.GetAcraBlockCipher() &&
+		bytes.Equal(destination.GetAcraBlockAssociatedData(), source.GetAcraBlockAssociatedData()) &&
+		destination.IsTokenized() == source.IsTokenized() &&
+		destination.GetTokenType() == source.GetTokenType() &&
+		destination.IsConsistentTokenization() == source.IsConsistentTokenization() &&
+		destination.IsSearchable() == source.IsSearchable() &&
+		destination.IsPseudonymized() == source.IsPseudonymized() &&
+		destination.GetMaskingPattern() == source.GetMaskingPattern() &&
+		destination.GetPartialPlaintextLen() == source.GetPartialPlaintextLen() &&
+		destination.IsEndMasking() == source.IsEndMasking() &&
+		destination.GetEncryptedDataType() == source.GetEncryptedDataType()
+}
+
+// checkColumnCopy applies ColumnCopyPolicy if value from source can't be stored as is into column with destination
+// setting. Values passed in the query are skipped because they are encrypted according to destination setting
+func (encryptor *QueryDataEncryptor) checkColumnCopy(ctx context.Context, table, column string, destination config.ColumnEncryptionSetting, source copySource) error {
+	if source.literal {
+		return nil
+	}
+	if source.known && compatibleColumnSettings(destination, source.setting) {
+		return nil
+	}
+	// derived values are checked only for encrypted columns, expressions over encrypted data don't return ciphertext
+	if !source.known && destination == nil {
+		return nil
+	}
+	logger := logging.GetLoggerFromContext(ctx).WithFields(logrus.Fields{
+		"table": table, "column": column, "source_table": source.table, "source_column": source.column,
+	}).WithField(logging.FieldKeyEventCode, logging.EventCodeColumnCopy)
+	if encryptor.columnCopyPolicy == base.ColumnCopyPolicyAllow {
+		logger.Warningln("Query copies data between columns with different encryption settings, data will be stored as is")
+		return nil
+	}
+	logger.Errorln("Query copies data between columns with different encryption settings, deny it")
+	return fmt.Errorf("%w: %s.%s", base.ErrColumnCopyDenied, table, column)
+}
+
+// checkInsertSelectCopy checks values copied by INSERT ... SELECT into columns of the table. schema may be nil for
+// tables not described in encryptor config, columns may be empty if they are not specified in the query and config
+func (encryptor *QueryDataEncryptor) checkInsertSelectCopy(ctx context.Context, insert *sqlparser.Insert, schema config.TableSchema, columns []string) error {
+	selectStatement, ok := insert.Rows.(sqlparser.SelectStatement)
+	if !ok {
+		return nil
+	}
+	tableName := insert.Table.Name.ValueForConfig()
+	sources, complete := encryptor.selectSources(selectStatement)
+	if len(columns) == 0 {
+		// values can't be matched to columns, so any encrypted column may get data of any source
+		if schema != nil {
+			for _, setting := range schema.EncryptedColumns() {
+				if err := encryptor.checkColumnCopy(ctx, tableName, setting.ColumnName(), setting, copySource{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, source := range sources {
+			if err := encryptor.checkColumnCopy(ctx, tableName, "", nil, source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, column := range columns {
+		var destination config.ColumnEncryptionSetting
+		if schema != nil {
+			destination = schema.GetColumnEncryptionSettings(column)
+		}
+		source := copySource{}
+		if i < len(sources) {
+			source = sources[i]
+		} else if complete {
+			break
+		}
+		if err := encryptor.checkColumnCopy(ctx, tableName, column, destination, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptInsertSelectValues encrypts values passed in the query as columns of INSERT ... SELECT like values of
+// INSERT ... VALUES
+func (encryptor *QueryDataEncryptor) encryptInsertSelectValues(ctx context.Context, selectStatement *sqlparser.Select, schema config.TableSchema, columns []string, bindPlaceholders map[int]config.ColumnEncryptionSetting) (bool, error) {
+	changed := false
+	for i, selectExpr := range selectStatement.SelectExprs {
+		if i >= len(columns) {
+			break
+		}
+		aliased, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			// * shifts positions of the next values
+			break
+		}
+		if hasColumnReferences(aliased.Expr) {
+			continue
+		}
+		changedValue, err := encryptor.encryptExpression(ctx, aliased.Expr, schema, columns[i], bindPlaceholders)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || changedValue
+	}
+	return changed, nil
+}
+
+// checkUpdateCopy checks values copied by UPDATE from other columns of updated table or tables from FROM/JOIN
+func (encryptor *QueryDataEncryptor) checkUpdateCopy(ctx context.Context, exprs sqlparser.UpdateExprs, fromTables sqlparser.TableExprs, firstTable sqlparser.TableName, qualifierMap AliasToTableMap) error {
+	for _, expr := range exprs {
+		tableName := firstTable.Name.ValueForConfig()
+		if !expr.Name.Qualifier.IsEmpty() {
+			tableName = qualifierMap[expr.Name.Qualifier.Name.String()]
+		}
+		columnName := expr.Name.Name.ValueForConfig()
+		var destination config.ColumnEncryptionSetting
+		if schema := encryptor.schemaStore.GetTableSchema(tableName); schema != nil {
+			destination = schema.GetColumnEncryptionSettings(columnName)
+		}
+		source := encryptor.resolveCopySource(expr.Expr, fromTables)
+		if err := encryptor.checkColumnCopy(ctx, tableName, columnName, destination, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
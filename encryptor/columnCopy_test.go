@@ -0,0 +1,139 @@
+package encryptor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/base/mocks"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/sqlparser"
+	"github.com/cossacklabs/acra/sqlparser/dialect/mysql"
+	"github.com/cossacklabs/acra/sqlparser/dialect/postgresql"
+)
+
+const columnCopyConfig = `
+schemas:
+  - table: users
+    columns: [id, email, phone, note]
+    encrypted:
+      - column: email
+      - column: phone
+        client_id: other_client
+  - table: archive
+    columns: [id, email, phone, note]
+    encrypted:
+      - column: email
+      - column: phone
+        client_id: other_client
+  - table: tokens
+    columns: [id, email]
+    encrypted:
+      - column: email
+        token_type: str
+`
+
+func columnCopyTestContext() context.Context {
+	ctx := base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID([]byte("client"))))
+	clientSession := &mocks.ClientSession{}
+	sessionData := make(map[string]interface{}, 2)
+	clientSession.On("GetData", mock.Anything).Return(sessionData, true)
+	clientSession.On("SetData", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sessionData[args[0].(string)] = args[1]
+	})
+	return base.SetClientSessionToContext(ctx, clientSession)
+}
+
+func TestColumnCopyPolicy(t *testing.T) {
+	defer sqlparser.SetDefaultDialect(mysql.NewMySQLDialect())
+	sqlparser.SetDefaultDialect(postgresql.NewPostgreSQLDialect())
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(columnCopyConfig), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := sqlparser.New(sqlparser.ModeStrict)
+	testcases := []struct {
+		query string
+		err   error
+	}{
+		// same settings
+		{"INSERT INTO archive (id, email, phone) SELECT id, email, phone FROM users", nil},
+		{"INSERT INTO archive SELECT * FROM users", nil},
+		{"INSERT INTO archive (id, email) SELECT u.id, (u.email) FROM users AS u WHERE u.id > 10", nil},
+		{"UPDATE archive SET email = u.email FROM users AS u WHERE archive.id = u.id", nil},
+		// not encrypted columns
+		{"INSERT INTO archive (id, note) SELECT id, lower(note) FROM users", nil},
+		{"INSERT INTO unknown_table (id, data) SELECT id, data FROM other_table", nil},
+		{"UPDATE archive SET id = id + 1", nil},
+		// values from the query
+		{"INSERT INTO archive (id, email) SELECT 1, 'value' FROM users", nil},
+		// different client_id
+		{"INSERT INTO archive (id, email, phone) SELECT id, phone, email FROM users", base.ErrColumnCopyDenied},
+		// plaintext into encrypted column
+		{"INSERT INTO archive (id, email) SELECT id, note FROM users", base.ErrColumnCopyDenied},
+		{"UPDATE archive SET email = u.note FROM users AS u WHERE archive.id = u.id", base.ErrColumnCopyDenied},
+		{"UPDATE archive SET email = note", base.ErrColumnCopyDenied},
+		// encrypted data into not encrypted column
+		{"INSERT INTO unknown_table (id, data) SELECT id, email FROM users", base.ErrColumnCopyDenied},
+		{"INSERT INTO archive (id, note) SELECT id, email FROM users", base.ErrColumnCopyDenied},
+		// encryption into tokenization
+		{"INSERT INTO tokens (id, email) SELECT u.id, u.email FROM users AS u", base.ErrColumnCopyDenied},
+		// values which can't be traced to columns
+		{"INSERT INTO archive (id, email) SELECT id, lower(note) FROM users", base.ErrColumnCopyDenied},
+		{"INSERT INTO archive (id, email) SELECT id, email FROM users UNION SELECT id, email FROM archive", base.ErrColumnCopyDenied},
+		{"INSERT INTO archive (id, email) SELECT * FROM unknown_table", base.ErrColumnCopyDenied},
+	}
+	for _, policy := range []base.ColumnCopyPolicy{"", base.ColumnCopyPolicyDeny, base.ColumnCopyPolicyAllow} {
+		queryEncryptor, err := NewPostgresqlQueryEncryptor(schemaStore, parser, &testEncryptor{value: []byte("encrypted")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryEncryptor.SetColumnCopyPolicy(policy)
+		for i, tcase := range testcases {
+			_, _, err := queryEncryptor.OnQuery(columnCopyTestContext(), base.NewOnQueryObjectFromQuery(tcase.query, parser))
+			expected := tcase.err
+			if policy == base.ColumnCopyPolicyAllow {
+				expected = nil
+			}
+			if !errors.Is(err, expected) {
+				t.Fatalf("[%s][%d] Expected %v, took %v", policy, i, expected, err)
+			}
+		}
+	}
+}
+
+func TestInsertSelectValuesEncryption(t *testing.T) {
+	defer sqlparser.SetDefaultDialect(mysql.NewMySQLDialect())
+	sqlparser.SetDefaultDialect(postgresql.NewPostgreSQLDialect())
+	schemaStore, err := config.MapTableSchemaStoreFromConfig([]byte(columnCopyConfig), config.UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := sqlparser.New(sqlparser.ModeStrict)
+	dataEncryptor := &testEncryptor{value: []byte("encrypted")}
+	queryEncryptor, err := NewPostgresqlQueryEncryptor(schemaStore, parser, dataEncryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "INSERT INTO archive (id, email, phone) SELECT id, 'value', phone FROM users"
+	result, changed, err := queryEncryptor.OnQuery(columnCopyTestContext(), base.NewOnQueryObjectFromQuery(query, parser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("Expected encrypted value in SELECT")
+	}
+	expected, err := normalizeQueryWithDialect(postgresql.NewPostgreSQLDialect(), "INSERT INTO archive (id, email, phone) SELECT id, 'encrypted', phone FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Query() != expected {
+		t.Fatalf("Expected %s, took %s", expected, result.Query())
+	}
+	if len(dataEncryptor.fetchedIDs) != 1 || string(dataEncryptor.fetchedIDs[0]) != "client" {
+		t.Fatalf("Expected encryption with connection's client_id, took %v", dataEncryptor.fetchedIDs)
+	}
+}
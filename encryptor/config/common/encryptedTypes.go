@@ -30,6 +30,10 @@ const (
 	// ResponseOnFailError indicates that db-specific error should be returned
 	// to a client
 	ResponseOnFailError ResponseOnFail = "error"
+
+	// ResponseOnFailNull indicates that SQL NULL should be returned instead of
+	// failed one. Should be quoted in YAML configs to not be parsed as empty value.
+	ResponseOnFailNull ResponseOnFail = "null"
 )
 
 // MySQLEncryptedTypeDataTypeIDs used for mapping EncryptedType with MySQL Types
@@ -112,8 +116,29 @@ var (
 	ErrUnsupportedEncryptedType = errors.New("data type not supported")
 	ErrDataTypeWithDataTypeID   = errors.New("data_type can`t be used along with data_type_db_identifier option")
 	ErrUnsupportedDataTypeID    = errors.New("unsupported data_type_db_identifier option")
+	ErrDBTypeOIDWithoutDataType = errors.New("db_type_oid can be used only with data_type or data_type_db_identifier")
+	ErrDBTypeOIDUnsupported     = errors.New("db_type_oid is supported only by PostgreSQL")
+	ErrBuiltinDBTypeOID         = errors.New("db_type_oid should be OID of user-defined type, use data_type_db_identifier for built-in types")
 )
 
+// firstUserDefinedOID is the first OID assigned by PostgreSQL to user-defined objects (FirstNormalObjectId),
+// lower OIDs belong to built-in types
+const firstUserDefinedOID = 16384
+
+// ValidateDBTypeOID returns error if OID of user-defined domain or enum can't be used with data type of the column
+func ValidateDBTypeOID(dbTypeOID, dataTypeID uint32, useMySQL bool) error {
+	if useMySQL {
+		return ErrDBTypeOIDUnsupported
+	}
+	if dataTypeID == 0 {
+		return ErrDBTypeOIDWithoutDataType
+	}
+	if dbTypeOID < firstUserDefinedOID {
+		return ErrBuiltinDBTypeOID
+	}
+	return nil
+}
+
 // ValidateEncryptedType return true if value is supported EncryptedType
 func ValidateEncryptedType(value EncryptedType) error {
 	supported, ok := supportedEncryptedTypes[value]
@@ -147,7 +172,8 @@ func ValidateOnFail(value ResponseOnFail) (err error) {
 	case ResponseOnFailEmpty,
 		ResponseOnFailCiphertext,
 		ResponseOnFailDefault,
-		ResponseOnFailError:
+		ResponseOnFailError,
+		ResponseOnFailNull:
 		return nil
 	}
 	return fmt.Errorf("unknown response_on_fail value: '%s'", value)
@@ -15,6 +15,8 @@ func TestValidateOnFail(t *testing.T) {
 		{"error", false},
 		{"default_value", false},
 		{"ciphertext", false},
+		{"null", false},
+		{"NULL", true},
 		{"gibberish", true},
 	}
 
@@ -17,6 +17,7 @@
 package config
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -47,6 +48,10 @@ const (
 	SettingDefaultDataValueFlag
 	SettingOnFailFlag
 	SettingDataTypeIDFlag
+	SettingPseudonymizationFlag
+	// SettingWasmProcessorFlag may be combined with any valid combination of other flags and isn't checked by
+	// validSettings
+	SettingWasmProcessorFlag
 )
 
 // validSettings store all valid combinations of encryption settings
@@ -131,6 +136,12 @@ var validSettings = map[SettingMask]struct{}{
 	SettingTokenizationFlag | SettingTokenTypeFlag | SettingConsistentTokenizationFlag | SettingClientIDFlag | SettingReEncryptionFlag | SettingAcraBlockEncryptionFlag: {},
 	SettingTokenTypeFlag | SettingClientIDFlag | SettingReEncryptionFlag | SettingAcraBlockEncryptionFlag:                                                               {},
 	SettingTokenTypeFlag | SettingConsistentTokenizationFlag | SettingClientIDFlag | SettingReEncryptionFlag | SettingAcraBlockEncryptionFlag:                           {},
+
+	/////////////
+	// PSEUDONYMIZATION
+	SettingPseudonymizationFlag | SettingClientIDFlag:                         {},
+	SettingPseudonymizationFlag | SettingClientIDFlag | SettingDataTypeFlag:   {},
+	SettingPseudonymizationFlag | SettingClientIDFlag | SettingDataTypeIDFlag: {},
 }
 
 // Token type names as expected in the configuration file.
@@ -167,6 +178,39 @@ func ValidateCryptoEnvelopeType(value CryptoEnvelopeType) error {
 	}
 }
 
+// AcraBlockCipherType type of symmetric cipher used by AcraBlock to encrypt data and data encryption key
+type AcraBlockCipherType string
+
+// Supported AcraBlockCipherTypes
+const (
+	AcraBlockCipherTypeSecureCell AcraBlockCipherType = "secure_cell"
+	AcraBlockCipherTypeAES256GCM  AcraBlockCipherType = "aes_256_gcm"
+)
+
+// Errors related to AcraBlock cipher configuration
+var (
+	ErrInvalidAcraBlockCipherType          = errors.New("invalid AcraBlockCipherType")
+	ErrAcraBlockCipherWithoutAcraBlock     = errors.New("acrablock_cipher can be used only with acrablock crypto_envelope")
+	ErrColumnBindingWithoutAcraBlock       = errors.New("acrablock_column_binding can be used only with acrablock crypto_envelope")
+	ErrColumnBindingRequiredWithoutBinding = errors.New("acrablock_column_binding_required can be used only with acrablock_column_binding")
+)
+
+// Errors related to pseudonymization configuration
+var (
+	ErrPseudonymizationConflict = errors.New("pseudonymized can't be used with tokenization, masking or searchable encryption")
+	ErrPseudonymizationDataType = errors.New("pseudonymized can be used only with str data type")
+)
+
+// ValidateAcraBlockCipherType return error if value is unsupported AcraBlockCipherType
+func ValidateAcraBlockCipherType(value AcraBlockCipherType) error {
+	switch value {
+	case AcraBlockCipherTypeSecureCell, AcraBlockCipherTypeAES256GCM:
+		return nil
+	default:
+		return ErrInvalidAcraBlockCipherType
+	}
+}
+
 // BasicColumnEncryptionSetting is a basic set of column encryption settings.
 type BasicColumnEncryptionSetting struct {
 	Name         string `yaml:"column"`
@@ -176,6 +220,10 @@ type BasicColumnEncryptionSetting struct {
 	DataType string `yaml:"data_type"`
 	// same as DataType but expect exact ID type
 	DataTypeID uint32 `yaml:"data_type_db_identifier"`
+	// OID of user-defined domain or enum which is declared type of the column for clients. It's returned in
+	// RowDescription/ParameterDescription instead of DataTypeID, values are encoded according to DataTypeID.
+	// PostgreSQL only
+	DBTypeOID uint32 `yaml:"db_type_oid"`
 	// string for str/email/int32/int64 ans base64 string for binary data
 	DefaultDataValue *string `yaml:"default_data_value"`
 	// an action that should be performed on failure
@@ -190,16 +238,31 @@ type BasicColumnEncryptionSetting struct {
 	Tokenized              *bool  `yaml:"tokenized"`
 	ConsistentTokenization *bool  `yaml:"consistent_tokenization"`
 	TokenType              string `yaml:"token_type"`
+	// Pseudonymized replaces values with stable pseudonyms derived by keyed PRF of ClientID. Source values are kept
+	// in token storage for re-identification by AcraTranslator
+	Pseudonymized bool `yaml:"pseudonymized"`
 
 	// Searchable encryption
 	Searchable bool `yaml:"searchable"`
 	// Data masking
-	MaskingPattern           string                      `yaml:"masking"`
-	PartialPlaintextLenBytes int                         `yaml:"plaintext_length"`
-	PlaintextSide            maskingCommon.PlainTextSide `yaml:"plaintext_side"`
-	CryptoEnvelope           *CryptoEnvelopeType         `yaml:"crypto_envelope"`
-	ReEncryptToAcraBlock     *bool                       `yaml:"reencrypting_to_acrablocks"`
-	settingMask              SettingMask
+	MaskingPattern           string                         `yaml:"masking"`
+	PartialPlaintextLenBytes int                            `yaml:"plaintext_length"`
+	PlaintextSide            maskingCommon.PlainTextSide    `yaml:"plaintext_side"`
+	MaskingTemplate          *maskingCommon.MaskingTemplate `yaml:"masking_template"`
+	MaskingAccess            *maskingCommon.MaskingAccess   `yaml:"masking_access"`
+	CryptoEnvelope           *CryptoEnvelopeType            `yaml:"crypto_envelope"`
+	AcraBlockCipher          *AcraBlockCipherType           `yaml:"acrablock_cipher"`
+	ReEncryptToAcraBlock     *bool                          `yaml:"reencrypting_to_acrablocks"`
+	// AcraBlockColumnBinding binds AcraBlocks to table and column, so they can't be decrypted after copying to another column
+	AcraBlockColumnBinding *bool `yaml:"acrablock_column_binding"`
+	// AcraBlockColumnBindingRequired refuses AcraBlocks which aren't bound to the column. Should be turned on after
+	// migration of existing data, otherwise unbound AcraBlocks may be copied from other columns
+	AcraBlockColumnBindingRequired *bool `yaml:"acrablock_column_binding_required"`
+	// WasmProcessor configures functions of WebAssembly module applied to values of the column
+	WasmProcessor *WasmProcessorSetting `yaml:"wasm_processor"`
+	settingMask   SettingMask
+	// tableName is set by schema store and used for binding of AcraBlocks
+	tableName string
 }
 
 // IsBinaryDataOperation return true if setting related to operation over binary data
@@ -241,9 +304,23 @@ func (s *BasicColumnEncryptionSetting) Init(useMySQL bool) (err error) {
 			break
 		}
 	}
+	if s.AcraBlockCipher != nil {
+		if err = ValidateAcraBlockCipherType(*s.AcraBlockCipher); err != nil {
+			return err
+		}
+		if s.GetCryptoEnvelope() != CryptoEnvelopeTypeAcraBlock {
+			return ErrAcraBlockCipherWithoutAcraBlock
+		}
+	}
 	if s.ReEncryptToAcraBlock != nil && *s.ReEncryptToAcraBlock {
 		s.settingMask |= SettingReEncryptionFlag
 	}
+	if s.AcraBlockColumnBinding != nil && *s.AcraBlockColumnBinding && s.GetCryptoEnvelope() != CryptoEnvelopeTypeAcraBlock {
+		return ErrColumnBindingWithoutAcraBlock
+	}
+	if s.IsAcraBlockColumnBindingRequired() && s.GetAcraBlockAssociatedData() == nil {
+		return ErrColumnBindingRequiredWithoutBinding
+	}
 
 	if s.Tokenized != nil {
 		tokenized := *s.Tokenized
@@ -340,6 +417,12 @@ func (s *BasicColumnEncryptionSetting) Init(useMySQL bool) (err error) {
 		}
 	}
 
+	if s.DBTypeOID != 0 {
+		if err = common.ValidateDBTypeOID(s.DBTypeOID, s.DataTypeID, useMySQL); err != nil {
+			return err
+		}
+	}
+
 	if s.DefaultDataValue != nil {
 		if s.DataTypeID == 0 {
 			return errors.New("default_data_value used without data_type_id")
@@ -372,25 +455,60 @@ func (s *BasicColumnEncryptionSetting) Init(useMySQL bool) (err error) {
 		s.settingMask |= SettingAcraBlockEncryptionFlag
 	}
 
-	if s.MaskingPattern != "" || s.PlaintextSide != "" {
+	if s.MaskingTemplate != nil {
+		if s.PartialPlaintextLenBytes != 0 || s.PlaintextSide != "" {
+			return maskingCommon.ErrMaskingTemplateConflict
+		}
+		if err = maskingCommon.ValidateMaskingTemplate(s.MaskingTemplate, s.GetEncryptedDataType()); err != nil {
+			return err
+		}
+		// masking pattern is used as replacement of data which can't be decrypted
+		if s.MaskingPattern == "" {
+			s.MaskingPattern = string(s.MaskingTemplate.MaskUnknown())
+		}
+		s.settingMask |= SettingMaskingFlag | SettingMaskingPlaintextLengthFlag | SettingMaskingPlaintextSideFlag
+	} else if s.MaskingPattern != "" || s.PlaintextSide != "" {
 		if err = maskingCommon.ValidateMaskingParams(s.MaskingPattern, s.PartialPlaintextLenBytes, s.PlaintextSide, s.GetEncryptedDataType()); err != nil {
 			return err
 		}
 		s.settingMask |= SettingMaskingFlag | SettingMaskingPlaintextLengthFlag | SettingMaskingPlaintextSideFlag
 	}
+	if s.MaskingAccess != nil {
+		if s.settingMask&SettingMaskingFlag == 0 {
+			return maskingCommon.ErrMaskingAccessWithoutMasking
+		}
+		if err = s.MaskingAccess.Validate(); err != nil {
+			return err
+		}
+	}
 	if s.Searchable {
 		s.settingMask |= SettingSearchFlag
 	}
+	if s.Pseudonymized {
+		if s.settingMask&(SettingTokenizationFlag|SettingMaskingFlag|SettingSearchFlag) != 0 {
+			return ErrPseudonymizationConflict
+		}
+		if dataType != common.EncryptedType_Unknown && dataType != common.EncryptedType_String {
+			return ErrPseudonymizationDataType
+		}
+		s.settingMask |= SettingPseudonymizationFlag
+	}
 	_, ok = validSettings[s.settingMask]
 	if !ok {
 		return ErrInvalidEncryptorConfig
 	}
+	if s.WasmProcessor != nil {
+		if err = s.WasmProcessor.Validate(); err != nil {
+			return err
+		}
+		s.settingMask |= SettingWasmProcessorFlag
+	}
 	return nil
 }
 
 // OnlyEncryption return true if should be applied only AcraStruct/AcraBlock encryption without tokenization/masking/etc
 func (s *BasicColumnEncryptionSetting) OnlyEncryption() bool {
-	return s.settingMask&(SettingMaskingFlag|SettingTokenizationFlag|SettingSearchFlag) == 0
+	return s.settingMask&(SettingMaskingFlag|SettingTokenizationFlag|SettingSearchFlag|SettingPseudonymizationFlag) == 0
 }
 
 // GetSettingMask return SettingMask
@@ -411,6 +529,36 @@ func (s *BasicColumnEncryptionSetting) GetCryptoEnvelope() CryptoEnvelopeType {
 	return *s.CryptoEnvelope
 }
 
+// GetAcraBlockCipher returns type of cipher used for AcraBlock encryption
+func (s *BasicColumnEncryptionSetting) GetAcraBlockCipher() AcraBlockCipherType {
+	if s.AcraBlockCipher == nil {
+		return AcraBlockCipherTypeSecureCell
+	}
+	return *s.AcraBlockCipher
+}
+
+// GetAcraBlockAssociatedData returns table and column which AcraBlocks of the column are bound to or nil if binding is
+// turned off. Names are prefixed with their 4-byte big-endian lengths, so names with dots (like "a.b" and "c" or
+// "a" and "b.c") don't produce the same data
+func (s *BasicColumnEncryptionSetting) GetAcraBlockAssociatedData() []byte {
+	if s.AcraBlockColumnBinding == nil || !*s.AcraBlockColumnBinding {
+		return nil
+	}
+	data := make([]byte, 0, 8+len(s.tableName)+len(s.Name))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(s.tableName)))
+	data = append(data, s.tableName...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(s.Name)))
+	return append(data, s.Name...)
+}
+
+// IsAcraBlockColumnBindingRequired returns true if AcraBlocks which aren't bound to the column should be refused
+func (s *BasicColumnEncryptionSetting) IsAcraBlockColumnBindingRequired() bool {
+	if s.AcraBlockColumnBindingRequired == nil {
+		return false
+	}
+	return *s.AcraBlockColumnBindingRequired
+}
+
 // ShouldReEncryptAcraStructToAcraBlock return true if should  re-encrypt data with AcraBlock
 func (s *BasicColumnEncryptionSetting) ShouldReEncryptAcraStructToAcraBlock() bool {
 	if s.ReEncryptToAcraBlock == nil {
@@ -429,6 +577,11 @@ func (s *BasicColumnEncryptionSetting) IsTokenized() bool {
 	return s.TokenType != ""
 }
 
+// IsPseudonymized returns true if values of the column should be replaced with pseudonyms.
+func (s *BasicColumnEncryptionSetting) IsPseudonymized() bool {
+	return s.Pseudonymized
+}
+
 // IsConsistentTokenization returns true if column tokens should be consistent.
 func (s *BasicColumnEncryptionSetting) IsConsistentTokenization() bool {
 	if s.ConsistentTokenization != nil {
@@ -469,6 +622,16 @@ func (s *BasicColumnEncryptionSetting) IsEndMasking() bool {
 	return s.PlaintextSide == maskingCommon.PlainTextSideLeft
 }
 
+// GetMaskingTemplate returns structured masking template or nil if masking configured with plaintext_length/plaintext_side.
+func (s *BasicColumnEncryptionSetting) GetMaskingTemplate() *maskingCommon.MaskingTemplate {
+	return s.MaskingTemplate
+}
+
+// GetMaskingAccess returns rules of clients which receive decrypted data of masked column or nil if all clients do.
+func (s *BasicColumnEncryptionSetting) GetMaskingAccess() *maskingCommon.MaskingAccess {
+	return s.MaskingAccess
+}
+
 // GetEncryptedDataType returns data type for encrypted data
 func (s *BasicColumnEncryptionSetting) GetEncryptedDataType() common.EncryptedType {
 	// If the configuration file contains some unknown or unsupported token type,
@@ -487,6 +650,10 @@ func (s *BasicColumnEncryptionSetting) GetDefaultDataValue() *string {
 }
 
 func (s *BasicColumnEncryptionSetting) applyDefaults(defaults defaultValues) {
+	// pseudonyms are stored without encryption
+	if s.Pseudonymized {
+		return
+	}
 	if s.CryptoEnvelope == nil {
 		v := defaults.GetCryptoEnvelope()
 		// not applicable to masking, tokenization and searchable encryption
@@ -494,6 +661,11 @@ func (s *BasicColumnEncryptionSetting) applyDefaults(defaults defaultValues) {
 			s.CryptoEnvelope = &v
 		}
 	}
+	// not applicable to AcraStruct that uses own encryption
+	if s.AcraBlockCipher == nil && defaults.AcraBlockCipher != nil && s.GetCryptoEnvelope() == CryptoEnvelopeTypeAcraBlock {
+		v := defaults.GetAcraBlockCipher()
+		s.AcraBlockCipher = &v
+	}
 	if s.ReEncryptToAcraBlock == nil {
 		v := defaults.ShouldReEncryptAcraStructToAcraBlock()
 		// not applicable to masking, tokenization and searchable encryption
@@ -512,7 +684,7 @@ func (s *BasicColumnEncryptionSetting) applyDefaults(defaults defaultValues) {
 }
 
 // GetResponseOnFail returns the action that should be performed on failure
-// Valid values are "", "ciphertext", "error" and "default"
+// Valid values are "", "ciphertext", "error", "default" and "null"
 func (s *BasicColumnEncryptionSetting) GetResponseOnFail() common.ResponseOnFail {
 	return s.ResponseOnFail
 }
@@ -539,3 +711,13 @@ func HasTypeAwareSupport(setting ColumnEncryptionSetting) bool {
 func (s *BasicColumnEncryptionSetting) GetDBDataTypeID() uint32 {
 	return s.DataTypeID
 }
+
+// GetDBTypeOID returns OID of user-defined type from `db_type_oid` encryptor config option or 0 if it's not set
+func (s *BasicColumnEncryptionSetting) GetDBTypeOID() uint32 {
+	return s.DBTypeOID
+}
+
+// GetWasmProcessor returns settings of WebAssembly processor from `wasm_processor` encryptor config option or nil
+func (s *BasicColumnEncryptionSetting) GetWasmProcessor() *WasmProcessorSetting {
+	return s.WasmProcessor
+}
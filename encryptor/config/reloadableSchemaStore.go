@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNilTableSchemaStore returned if ReloadableTableSchemaStore initialized or swapped with nil store
+var ErrNilTableSchemaStore = errors.New("table schema store is nil")
+
+// tableSchemaStoreHolder wraps TableSchemaStore to store values of different types in the same atomic.Value
+type tableSchemaStoreHolder struct {
+	store TableSchemaStore
+}
+
+// ReloadableTableSchemaStore is TableSchemaStore which may be replaced at runtime. Components which hold it (proxy
+// factories, QueryDataEncryptor, EncryptionSettingExtractor) start to use new settings without restart. Settings which
+// enable new processors (tokenization, searchable encryption, masking) take effect for new connections
+type ReloadableTableSchemaStore struct {
+	current atomic.Value
+}
+
+// NewReloadableTableSchemaStore returns ReloadableTableSchemaStore which uses store until the next reload
+func NewReloadableTableSchemaStore(store TableSchemaStore) (*ReloadableTableSchemaStore, error) {
+	reloadable := &ReloadableTableSchemaStore{}
+	if err := reloadable.Swap(store); err != nil {
+		return nil, err
+	}
+	return reloadable, nil
+}
+
+// Current returns TableSchemaStore used at the moment
+func (store *ReloadableTableSchemaStore) Current() TableSchemaStore {
+	return store.current.Load().(tableSchemaStoreHolder).store
+}
+
+// Swap atomically replaces used TableSchemaStore with new one
+func (store *ReloadableTableSchemaStore) Swap(newStore TableSchemaStore) error {
+	if newStore == nil {
+		return ErrNilTableSchemaStore
+	}
+	store.current.Store(tableSchemaStoreHolder{newStore})
+	return nil
+}
+
+// ReloadFromConfig parses and validates config and replaces used TableSchemaStore only if config is valid. Previous
+// settings are left in use on error
+func (store *ReloadableTableSchemaStore) ReloadFromConfig(config []byte, useMySQL bool) error {
+	newStore, err := MapTableSchemaStoreFromConfig(config, useMySQL)
+	if err != nil {
+		return err
+	}
+	return store.Swap(newStore)
+}
+
+// GetDatabaseSettings return struct with database-specific configuration of current store
+func (store *ReloadableTableSchemaStore) GetDatabaseSettings() DatabaseSettings {
+	return store.Current().GetDatabaseSettings()
+}
+
+// GetTableSchema return table schema of current store if exists otherwise nil
+func (store *ReloadableTableSchemaStore) GetTableSchema(tableName string) TableSchema {
+	return store.Current().GetTableSchema(tableName)
+}
+
+// GetGlobalSettingsMask return OR of all masks of column settings of current store
+func (store *ReloadableTableSchemaStore) GetGlobalSettingsMask() SettingMask {
+	return store.Current().GetGlobalSettingsMask()
+}
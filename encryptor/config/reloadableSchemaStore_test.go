@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestReloadableTableSchemaStore(t *testing.T) {
+	initialConfig := []byte(`
+schemas:
+  - table: test_table
+    columns:
+      - data
+    encrypted:
+      - column: data
+`)
+	newConfig := []byte(`
+schemas:
+  - table: test_table
+    columns:
+      - data
+      - token
+    encrypted:
+      - column: data
+      - column: token
+        token_type: str
+  - table: new_table
+    columns:
+      - data
+    encrypted:
+      - column: data
+`)
+	initialStore, err := MapTableSchemaStoreFromConfig(initialConfig, UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReloadableTableSchemaStore(nil); !errors.Is(err, ErrNilTableSchemaStore) {
+		t.Fatalf("Expected ErrNilTableSchemaStore, took %v", err)
+	}
+	store, err := NewReloadableTableSchemaStore(initialStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.GetTableSchema("new_table") != nil {
+		t.Fatal("Expected nil schema for unknown table")
+	}
+	if store.GetGlobalSettingsMask()&SettingTokenizationFlag != 0 {
+		t.Fatal("Unexpected tokenization flag before reload")
+	}
+
+	// invalid config should be rejected and previous settings left in use
+	invalidConfig := []byte("schema_version: 100\nschemas: []")
+	if err := store.ReloadFromConfig(invalidConfig, UsePostgreSQL); !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("Expected ErrUnsupportedSchemaVersion, took %v", err)
+	}
+	if store.Current() != initialStore {
+		t.Fatal("Store changed after failed reload")
+	}
+
+	if err := store.ReloadFromConfig(newConfig, UsePostgreSQL); err != nil {
+		t.Fatal(err)
+	}
+	if store.GetTableSchema("new_table") == nil {
+		t.Fatal("Expected schema of new table after reload")
+	}
+	if store.GetTableSchema("test_table").GetColumnEncryptionSettings("token") == nil {
+		t.Fatal("Expected settings of new column after reload")
+	}
+	if store.GetGlobalSettingsMask()&SettingTokenizationFlag == 0 {
+		t.Fatal("Expected tokenization flag after reload")
+	}
+}
+
+func TestReloadableTableSchemaStoreConcurrentAccess(t *testing.T) {
+	first, err := NewMapTableSchemaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewMapTableSchemaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewReloadableTableSchemaStore(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.GetTableSchema("table")
+				store.GetDatabaseSettings()
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			newStore := first
+			if i%2 == 0 {
+				newStore = second
+			}
+			if err := store.Swap(newStore); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
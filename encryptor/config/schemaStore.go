@@ -17,6 +17,9 @@
 package config
 
 import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
@@ -36,9 +39,10 @@ type TableSchemaStore interface {
 
 // defaultValues store default values for config
 type defaultValues struct {
-	CryptoEnvelope         *CryptoEnvelopeType `yaml:"crypto_envelope"`
-	ReEncryptToAcraBlock   *bool               `yaml:"reencrypting_to_acrablocks"`
-	ConsistentTokenization *bool               `yaml:"consistent_tokenization"`
+	CryptoEnvelope         *CryptoEnvelopeType  `yaml:"crypto_envelope"`
+	AcraBlockCipher        *AcraBlockCipherType `yaml:"acrablock_cipher"`
+	ReEncryptToAcraBlock   *bool                `yaml:"reencrypting_to_acrablocks"`
+	ConsistentTokenization *bool                `yaml:"consistent_tokenization"`
 }
 
 // GetCryptoEnvelope returns type of crypto envelope
@@ -49,6 +53,14 @@ func (d defaultValues) GetCryptoEnvelope() CryptoEnvelopeType {
 	return *d.CryptoEnvelope
 }
 
+// GetAcraBlockCipher returns type of cipher used for AcraBlock encryption
+func (d defaultValues) GetAcraBlockCipher() AcraBlockCipherType {
+	if d.AcraBlockCipher == nil {
+		return AcraBlockCipherTypeSecureCell
+	}
+	return *d.AcraBlockCipher
+}
+
 // GetConsistentTokenization returns if consistent tokenization by default
 func (d defaultValues) GetConsistentTokenization() bool {
 	if d.ConsistentTokenization == nil {
@@ -66,6 +78,7 @@ func (d defaultValues) ShouldReEncryptAcraStructToAcraBlock() bool {
 }
 
 type storeConfig struct {
+	SchemaVersion    int               `yaml:"schema_version"`
 	DatabaseSettings *databaseSettings `yaml:"database_settings"`
 	Defaults         *defaultValues
 	Schemas          []*tableSchema
@@ -83,8 +96,17 @@ func NewMapTableSchemaStore() (*MapTableSchemaStore, error) {
 	return &MapTableSchemaStore{schemas: make(map[string]*tableSchema)}, nil
 }
 
-// MapTableSchemaStoreFromConfig parse config and return MapTableSchemaStore with data from config
+// MapTableSchemaStoreFromConfig parse config and return MapTableSchemaStore with data from config. Configs of older
+// schema versions are upgraded in memory to SchemaVersionCurrent
 func MapTableSchemaStoreFromConfig(config []byte, useMySQL bool) (*MapTableSchemaStore, error) {
+	config, version, err := UpgradeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if version != SchemaVersionCurrent {
+		log.WithFields(log.Fields{"schema_version": version, "current_schema_version": SchemaVersionCurrent}).
+			Warningln("Encryptor config uses outdated schema version and was upgraded in memory, use acra-server --migrate_encryptor_config to rewrite it")
+	}
 	storeConfig := &storeConfig{}
 	if err := yaml.Unmarshal(config, &storeConfig); err != nil {
 		return nil, err
@@ -97,10 +119,16 @@ func MapTableSchemaStoreFromConfig(config []byte, useMySQL bool) (*MapTableSchem
 			return nil, err
 		}
 	}
+	if storeConfig.Defaults.AcraBlockCipher != nil {
+		if err := ValidateAcraBlockCipherType(*storeConfig.Defaults.AcraBlockCipher); err != nil {
+			return nil, err
+		}
+	}
 	var mask SettingMask
 	mapSchemas := make(map[string]*tableSchema, len(storeConfig.Schemas))
 	for _, schema := range storeConfig.Schemas {
 		for _, setting := range schema.EncryptionColumnSettings {
+			setting.tableName = schema.TableName
 			setting.applyDefaults(*storeConfig.Defaults)
 			if err := setting.Init(useMySQL); err != nil {
 				return nil, err
@@ -141,6 +169,20 @@ func (store *MapTableSchemaStore) GetGlobalSettingsMask() SettingMask {
 	return store.globalMask
 }
 
+// Tables returns schemas of all configured tables sorted by table name
+func (store *MapTableSchemaStore) Tables() []TableSchema {
+	names := make([]string, 0, len(store.schemas))
+	for name := range store.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tables := make([]TableSchema, 0, len(names))
+	for _, name := range names {
+		tables = append(tables, store.schemas[name])
+	}
+	return tables
+}
+
 // GetTableSchema return table schema if exists otherwise nil
 func (store *MapTableSchemaStore) GetTableSchema(tableName string) TableSchema {
 	// Explicitly check for presence and return explicit "nil" value
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/cossacklabs/acra/decryptor/base/type_awareness"
 	base_mysql "github.com/cossacklabs/acra/decryptor/mysql/base"
@@ -184,6 +185,145 @@ schemas:
 	}
 }
 
+func TestAcraBlockCipherValues(t *testing.T) {
+	testConfig := `
+defaults:
+  acrablock_cipher: aes_256_gcm
+schemas:
+  - table: test_table
+    columns:
+      - data1
+      - data2
+      - data3
+    encrypted:
+      - column: data1
+      - column: data2
+        acrablock_cipher: secure_cell
+      - column: data3
+        crypto_envelope: acrastruct
+`
+	schemaStore, err := MapTableSchemaStoreFromConfig([]byte(testConfig), UseMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableSchema := schemaStore.GetTableSchema("test_table")
+	expected := map[string]AcraBlockCipherType{
+		// default value
+		"data1": AcraBlockCipherTypeAES256GCM,
+		// changed value
+		"data2": AcraBlockCipherTypeSecureCell,
+		// default value isn't applied to AcraStruct
+		"data3": AcraBlockCipherTypeSecureCell,
+	}
+	for column, cipher := range expected {
+		setting := tableSchema.GetColumnEncryptionSettings(column)
+		if setting.GetAcraBlockCipher() != cipher {
+			t.Fatalf("[%s] Expect %s, took %s\n", column, cipher, setting.GetAcraBlockCipher())
+		}
+	}
+
+	invalidConfigs := []struct {
+		config string
+		err    error
+	}{
+		{`
+defaults:
+  acrablock_cipher: gost
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+`, ErrInvalidAcraBlockCipherType},
+		{`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        acrablock_cipher: gost
+`, ErrInvalidAcraBlockCipherType},
+		{`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        crypto_envelope: acrastruct
+        acrablock_cipher: aes_256_gcm
+`, ErrAcraBlockCipherWithoutAcraBlock},
+	}
+	for i, tcase := range invalidConfigs {
+		_, err := MapTableSchemaStoreFromConfig([]byte(tcase.config), UseMySQL)
+		if err != tcase.err {
+			t.Fatalf("[%d] Expect %s, took %s\n", i, tcase.err, err)
+		}
+	}
+}
+
+func TestAcraBlockColumnBinding(t *testing.T) {
+	testConfig := `
+schemas:
+  - table: test_table
+    columns:
+      - data1
+      - data2
+    encrypted:
+      - column: data1
+        acrablock_column_binding: true
+      - column: data2
+`
+	schemaStore, err := MapTableSchemaStoreFromConfig([]byte(testConfig), UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableSchema := schemaStore.GetTableSchema("test_table")
+	expected := []byte("\x00\x00\x00\x0atest_table\x00\x00\x00\x05data1")
+	if data := tableSchema.GetColumnEncryptionSettings("data1").GetAcraBlockAssociatedData(); !bytes.Equal(data, expected) {
+		t.Fatalf("Expect %q, took %q\n", expected, data)
+	}
+	// names with dots don't produce the same associated data
+	enabled := true
+	first := &BasicColumnEncryptionSetting{Name: "c", AcraBlockColumnBinding: &enabled, tableName: "a.b"}
+	second := &BasicColumnEncryptionSetting{Name: "b.c", AcraBlockColumnBinding: &enabled, tableName: "a"}
+	if bytes.Equal(first.GetAcraBlockAssociatedData(), second.GetAcraBlockAssociatedData()) {
+		t.Fatal("Expect different associated data of column c in table a.b and column b.c in table a")
+	}
+	if data := tableSchema.GetColumnEncryptionSettings("data2").GetAcraBlockAssociatedData(); data != nil {
+		t.Fatalf("Expect nil, took %s\n", data)
+	}
+
+	invalidConfig := `
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        crypto_envelope: acrastruct
+        acrablock_column_binding: true
+`
+	if _, err := MapTableSchemaStoreFromConfig([]byte(invalidConfig), UsePostgreSQL); err != ErrColumnBindingWithoutAcraBlock {
+		t.Fatalf("Expect %s, took %s\n", ErrColumnBindingWithoutAcraBlock, err)
+	}
+
+	requiredWithoutBinding := `
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        acrablock_column_binding_required: true
+`
+	if _, err := MapTableSchemaStoreFromConfig([]byte(requiredWithoutBinding), UsePostgreSQL); err != ErrColumnBindingRequiredWithoutBinding {
+		t.Fatalf("Expect %s, took %s\n", ErrColumnBindingRequiredWithoutBinding, err)
+	}
+}
+
 func TestCryptoEnvelopeDefaultValuesWithoutDefinedValue(t *testing.T) {
 	testConfig := `
 schemas:
@@ -382,6 +522,152 @@ schemas:
 `,
 			common.ErrInvalidMaskingPattern},
 
+		{"masking_template can't be used with plaintext_side",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        plaintext_side: "right"
+        masking_template:
+          type: keep_last
+          length: 4
+`,
+			common.ErrMaskingTemplateConflict},
+
+		{"masking_template length should be > 0",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_template:
+          type: keep_first
+`,
+			common.ErrInvalidTemplateLength},
+
+		{"regex masking_template should be applied always",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_template:
+          type: regex
+          regex: "^(.*)$"
+          replacement: "***"
+`,
+			common.ErrRegexTemplateOnFail},
+
+		{"invalid masking_template type",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_template:
+          type: unknown
+`,
+			common.ErrInvalidTemplateType},
+
+		{"valid masking templates",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+      - data2
+      - data3
+    encrypted:
+      - column: data1
+        masking_template:
+          type: keep_last
+          length: 4
+      - column: data2
+        masking: "xxxx"
+        masking_template:
+          type: email_domain
+      - column: data3
+        masking_template:
+          type: regex
+          regex: "^(.{2}).*$"
+          replacement: "${1}***"
+          apply: always
+`,
+			nil},
+
+		{"masking_access requires masking",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_access:
+          unmasked_client_ids: ["admin"]
+`,
+			common.ErrMaskingAccessWithoutMasking},
+
+		{"masking_access should not be empty",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking: "xxxx"
+        plaintext_length: 2
+        plaintext_side: "right"
+        masking_access: {}
+`,
+			common.ErrEmptyMaskingAccess},
+
+		{"masking_access with unsupported certificate attribute",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_template:
+          type: keep_last
+          length: 4
+        masking_access:
+          unmasked_certificate_attributes:
+            email: ["admin@example.com"]
+`,
+			common.ErrUnsupportedCertificateAttribute},
+
+		{"valid masking_access",
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data1
+    encrypted:
+      - column: data1
+        masking_template:
+          type: keep_last
+          length: 4
+        masking_access:
+          unmasked_client_ids: ["admin"]
+          unmasked_certificate_attributes:
+            OU: ["security", "support"]
+            serial_number: ["0a1b"]
+`,
+			nil},
+
 		{"tokenization can't be searchable",
 			`
 schemas:
@@ -959,6 +1245,38 @@ schemas:
         data_type: int64
         response_on_fail: error`},
 
+		{"onFail is 'null' if explicitly defined",
+			common2.ResponseOnFailNull,
+			`
+schemas:
+  - table: test_table
+    columns:
+      - data_str
+      - data_bytes
+      - data_int32
+      - data_int64
+      - data_searchable_str
+    encrypted:
+      - column: data_str
+        data_type: str
+        response_on_fail: "null"
+
+      - column: data_searchable_str
+        data_type: str
+        response_on_fail: "null"
+
+      - column: data_bytes
+        data_type: bytes
+        response_on_fail: "null"
+
+      - column: data_int32
+        data_type: int32
+        response_on_fail: "null"
+
+      - column: data_int64
+        data_type: int64
+        response_on_fail: "null"`},
+
 		{"onFail is implicitly 'default_value' if 'default_data_value' is defined",
 			common2.ResponseOnFailDefault,
 			`
@@ -1311,6 +1629,143 @@ schemas:
 	}
 }
 
+func TestDBTypeOIDOption(t *testing.T) {
+	const configTemplate = `
+schemas:
+  - table: test_db_type_oid
+    columns:
+      - id
+      - value
+    encrypted:
+      - column: value
+%s
+`
+	schemaStore, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, `        data_type: str
+        db_type_oid: 16400`)), UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := schemaStore.GetTableSchema("test_db_type_oid").GetColumnEncryptionSettings("value")
+	assert.Equal(t, uint32(16400), setting.GetDBTypeOID())
+	assert.Equal(t, uint32(pgtype.TextOID), setting.GetDBDataTypeID())
+
+	type testcase struct {
+		name          string
+		config        string
+		useMySQL      bool
+		expectedError error
+	}
+	testcases := []testcase{
+		{"without data type", `        db_type_oid: 16400`, UsePostgreSQL, common2.ErrDBTypeOIDWithoutDataType},
+		{"built-in type", `        data_type: str
+        db_type_oid: 25`, UsePostgreSQL, common2.ErrBuiltinDBTypeOID},
+		{"MySQL", `        data_type: str
+        db_type_oid: 16400`, UseMySQL, common2.ErrDBTypeOIDUnsupported},
+	}
+	for _, tcase := range testcases {
+		_, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, tcase.config)), tcase.useMySQL)
+		if err != tcase.expectedError {
+			t.Fatalf("[%s] expected error %s, took %v", tcase.name, tcase.expectedError, err)
+		}
+	}
+}
+
+func TestWasmProcessorOption(t *testing.T) {
+	const configTemplate = `
+schemas:
+  - table: test_wasm_processor
+    columns:
+      - id
+      - value
+    encrypted:
+      - column: value
+        searchable: true
+        wasm_processor:
+%s
+`
+	schemaStore, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, `          module: /tmp/module.wasm
+          on_encrypt: validate`)), UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := schemaStore.GetTableSchema("test_wasm_processor").GetColumnEncryptionSettings("value")
+	assert.Equal(t, &WasmProcessorSetting{Module: "/tmp/module.wasm", OnEncrypt: "validate"}, setting.GetWasmProcessor())
+	assert.Equal(t, DefaultWasmCallTimeout, setting.GetWasmProcessor().GetTimeout())
+	assert.True(t, setting.IsSearchable())
+	assert.Equal(t, SettingWasmProcessorFlag, schemaStore.GetGlobalSettingsMask()&SettingWasmProcessorFlag)
+
+	testcases := []struct {
+		name          string
+		config        string
+		expectedError error
+	}{
+		{"without module", `          on_decrypt: mask`, ErrWasmProcessorWithoutModule},
+		{"without functions", `          module: /tmp/module.wasm`, ErrWasmProcessorWithoutFunctions},
+		{"negative timeout", `          module: /tmp/module.wasm
+          on_decrypt: mask
+          timeout: -1s`, ErrWasmProcessorInvalidTimeout},
+	}
+	for _, tcase := range testcases {
+		_, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, tcase.config)), UsePostgreSQL)
+		if err != tcase.expectedError {
+			t.Fatalf("[%s] expected error %s, took %v", tcase.name, tcase.expectedError, err)
+		}
+	}
+
+	schemaStore, err = MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, `          module: /tmp/module.wasm
+          on_decrypt: mask
+          timeout: 50ms`)), UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting = schemaStore.GetTableSchema("test_wasm_processor").GetColumnEncryptionSettings("value")
+	assert.Equal(t, 50*time.Millisecond, setting.GetWasmProcessor().GetTimeout())
+}
+
+func TestPseudonymizedOption(t *testing.T) {
+	const configTemplate = `
+defaults:
+  crypto_envelope: acrablock
+  reencrypting_to_acrablocks: true
+schemas:
+  - table: test_pseudonymized
+    columns:
+      - id
+      - value
+    encrypted:
+      - column: value
+        pseudonymized: true
+%s
+`
+	schemaStore, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, `        data_type: str`)), UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := schemaStore.GetTableSchema("test_pseudonymized").GetColumnEncryptionSettings("value")
+	assert.True(t, setting.IsPseudonymized())
+	assert.False(t, setting.OnlyEncryption())
+	assert.False(t, setting.ShouldReEncryptAcraStructToAcraBlock())
+	assert.Equal(t, SettingPseudonymizationFlag, schemaStore.GetGlobalSettingsMask()&SettingPseudonymizationFlag)
+
+	testcases := []struct {
+		name          string
+		config        string
+		expectedError error
+	}{
+		{"with tokenization", `        token_type: str`, ErrPseudonymizationConflict},
+		{"with searchable encryption", `        searchable: true`, ErrPseudonymizationConflict},
+		{"with masking", "        masking: \"xxxx\"\n        plaintext_length: 2\n        plaintext_side: right", ErrPseudonymizationConflict},
+		{"with int32 data type", `        data_type: int32`, ErrPseudonymizationDataType},
+		{"with crypto envelope", `        crypto_envelope: acrastruct`, ErrInvalidEncryptorConfig},
+	}
+	for _, tcase := range testcases {
+		_, err := MapTableSchemaStoreFromConfig([]byte(fmt.Sprintf(configTemplate, tcase.config)), UsePostgreSQL)
+		if err != tcase.expectedError {
+			t.Fatalf("[%s] expected error %s, took %v", tcase.name, tcase.expectedError, err)
+		}
+	}
+}
+
 func registerMySQLDummyEncoders() {
 	type_awareness.RegisterMySQLDataTypeIDEncoder(uint32(base_mysql.TypeBlob), &dummyDataTypeEncoder{})
 	type_awareness.RegisterMySQLDataTypeIDEncoder(uint32(base_mysql.TypeString), &dummyDataTypeEncoder{})
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Versions of encryptor config schema
+const (
+	// SchemaVersionLegacy used for configs without `schema_version`
+	SchemaVersionLegacy = 1
+	// SchemaVersionCurrent is the newest schema version supported by AcraServer
+	SchemaVersionCurrent = 2
+)
+
+// schemaVersionKey name of the top level key which stores version of config schema
+const schemaVersionKey = "schema_version"
+
+// Errors related to encryptor config schema versions
+var (
+	ErrInvalidSchemaVersion     = errors.New("schema_version should be a positive integer")
+	ErrUnsupportedSchemaVersion = errors.New("unsupported schema_version, newer AcraServer is required")
+	ErrInvalidTokenizedSetting  = errors.New("invalid `tokenized` setting")
+)
+
+// schemaUpgrade converts config from the version to the next one
+type schemaUpgrade func(config yaml.MapSlice) (yaml.MapSlice, error)
+
+// schemaUpgrades maps version to function which upgrades config of this version to the next one
+var schemaUpgrades = map[int]schemaUpgrade{
+	1: upgradeSchemaV1ToV2,
+}
+
+// GetSchemaVersion returns version of config schema, SchemaVersionLegacy if it is not specified
+func GetSchemaVersion(config []byte) (int, error) {
+	rawConfig := yaml.MapSlice{}
+	if err := yaml.Unmarshal(config, &rawConfig); err != nil {
+		return 0, err
+	}
+	return getSchemaVersion(rawConfig)
+}
+
+func getSchemaVersion(config yaml.MapSlice) (int, error) {
+	value, ok := getMapSliceValue(config, schemaVersionKey)
+	if !ok {
+		return SchemaVersionLegacy, nil
+	}
+	version, ok := value.(int)
+	if !ok || version < SchemaVersionLegacy {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSchemaVersion, value)
+	}
+	if version > SchemaVersionCurrent {
+		return 0, fmt.Errorf("%w: %d, supported up to %d", ErrUnsupportedSchemaVersion, version, SchemaVersionCurrent)
+	}
+	return version, nil
+}
+
+// UpgradeConfig converts config to SchemaVersionCurrent and returns it with the version of original config.
+// Config of the current version returned as is
+func UpgradeConfig(config []byte) ([]byte, int, error) {
+	rawConfig := yaml.MapSlice{}
+	if err := yaml.Unmarshal(config, &rawConfig); err != nil {
+		return nil, 0, err
+	}
+	version, err := getSchemaVersion(rawConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version == SchemaVersionCurrent {
+		return config, version, nil
+	}
+	for v := version; v < SchemaVersionCurrent; v++ {
+		rawConfig, err = schemaUpgrades[v](rawConfig)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	rawConfig = setMapSliceValue(rawConfig, schemaVersionKey, SchemaVersionCurrent)
+	upgraded, err := yaml.Marshal(rawConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	return upgraded, version, nil
+}
+
+// upgradeSchemaV1ToV2 removes deprecated `tokenized` flag from column settings because `token_type` enables
+// tokenization itself
+func upgradeSchemaV1ToV2(config yaml.MapSlice) (yaml.MapSlice, error) {
+	schemas, _ := getMapSliceValueOrNil(config, "schemas").([]interface{})
+	for _, schema := range schemas {
+		tableSchema, ok := schema.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		columns, _ := getMapSliceValueOrNil(tableSchema, "encrypted").([]interface{})
+		for i, column := range columns {
+			columnSetting, ok := column.(yaml.MapSlice)
+			if !ok {
+				continue
+			}
+			value, ok := getMapSliceValue(columnSetting, "tokenized")
+			if !ok {
+				continue
+			}
+			tokenized, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%w: expected boolean, took %v", ErrInvalidTokenizedSetting, value)
+			}
+			tokenType, _ := getMapSliceValueOrNil(columnSetting, "token_type").(string)
+			if tokenized && tokenType == "" {
+				return nil, fmt.Errorf("%w: `tokenized` is provided without `token_type`", ErrInvalidTokenizedSetting)
+			} else if !tokenized && tokenType != "" {
+				return nil, fmt.Errorf("%w: `tokenized` is disabled, but `token_type` is provided", ErrInvalidTokenizedSetting)
+			}
+			log.Warnln("Setting `tokenized` flag is not necessary anymore and will be ignored")
+			columns[i] = deleteMapSliceValue(columnSetting, "tokenized")
+		}
+	}
+	return config, nil
+}
+
+func getMapSliceValue(slice yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range slice {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func getMapSliceValueOrNil(slice yaml.MapSlice, key string) interface{} {
+	value, _ := getMapSliceValue(slice, key)
+	return value
+}
+
+// setMapSliceValue replaces value of key or prepends it to keep it on top of the config
+func setMapSliceValue(slice yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range slice {
+		if item.Key == key {
+			slice[i].Value = value
+			return slice
+		}
+	}
+	return append(yaml.MapSlice{{Key: key, Value: value}}, slice...)
+}
+
+func deleteMapSliceValue(slice yaml.MapSlice, key string) yaml.MapSlice {
+	result := make(yaml.MapSlice, 0, len(slice))
+	for _, item := range slice {
+		if item.Key != key {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGetSchemaVersion(t *testing.T) {
+	testcases := []struct {
+		config  string
+		version int
+		err     error
+	}{
+		{"schemas: []", SchemaVersionLegacy, nil},
+		{"", SchemaVersionLegacy, nil},
+		{"schema_version: 1", 1, nil},
+		{"schema_version: 2", 2, nil},
+		{"schema_version: 3", 0, ErrUnsupportedSchemaVersion},
+		{"schema_version: 0", 0, ErrInvalidSchemaVersion},
+		{"schema_version: two", 0, ErrInvalidSchemaVersion},
+	}
+	for _, tcase := range testcases {
+		version, err := GetSchemaVersion([]byte(tcase.config))
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%s] Expected error %v, took %v", tcase.config, tcase.err, err)
+		}
+		if version != tcase.version {
+			t.Fatalf("[%s] Expected version %d, took %d", tcase.config, tcase.version, version)
+		}
+	}
+}
+
+func TestUpgradeConfig(t *testing.T) {
+	legacyConfig := []byte(`
+defaults:
+  crypto_envelope: acrablock
+schemas:
+  - table: test_table
+    columns:
+      - data
+      - token
+    encrypted:
+      - column: data
+      - column: token
+        tokenized: true
+        token_type: str
+`)
+	upgraded, version, err := UpgradeConfig(legacyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersionLegacy {
+		t.Fatalf("Expected legacy version, took %d", version)
+	}
+	if bytes.Contains(upgraded, []byte("tokenized")) {
+		t.Fatalf("Expected removed `tokenized` flag, took:\n%s", upgraded)
+	}
+	if !bytes.HasPrefix(upgraded, []byte("schema_version: 2\n")) {
+		t.Fatalf("Expected schema_version on top of config, took:\n%s", upgraded)
+	}
+	newVersion, err := GetSchemaVersion(upgraded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion != SchemaVersionCurrent {
+		t.Fatalf("Expected current version, took %d", newVersion)
+	}
+	// upgraded config describes the same settings
+	legacyStore, err := MapTableSchemaStoreFromConfig(legacyConfig, UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgradedStore, err := MapTableSchemaStoreFromConfig(upgraded, UsePostgreSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, column := range []string{"data", "token"} {
+		legacySetting := legacyStore.GetTableSchema("test_table").GetColumnEncryptionSettings(column)
+		upgradedSetting := upgradedStore.GetTableSchema("test_table").GetColumnEncryptionSettings(column)
+		if legacySetting.GetTokenType() != upgradedSetting.GetTokenType() ||
+			legacySetting.GetCryptoEnvelope() != upgradedSetting.GetCryptoEnvelope() {
+			t.Fatalf("Settings of column %s differ after upgrade", column)
+		}
+	}
+
+	// current config is not changed
+	sameConfig, version, err := UpgradeConfig(upgraded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersionCurrent || !bytes.Equal(sameConfig, upgraded) {
+		t.Fatal("Expected config of current version as is")
+	}
+}
+
+func TestLoadUnsupportedSchemaVersion(t *testing.T) {
+	config := `
+schema_version: 100
+schemas:
+  - table: test_table
+    columns:
+      - data
+    encrypted:
+      - column: data
+`
+	if _, err := MapTableSchemaStoreFromConfig([]byte(config), UseMySQL); !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("Expected ErrUnsupportedSchemaVersion, took %v", err)
+	}
+}
@@ -18,12 +18,14 @@ package config
 
 import (
 	common2 "github.com/cossacklabs/acra/encryptor/config/common"
+	maskingCommon "github.com/cossacklabs/acra/masking/common"
 	"github.com/cossacklabs/acra/pseudonymization/common"
 )
 
 // Defaults default parameters that may be configured for whole config to allow omit them for specific columns
 type Defaults interface {
 	GetCryptoEnvelope() CryptoEnvelopeType
+	GetAcraBlockCipher() AcraBlockCipherType
 	ShouldReEncryptAcraStructToAcraBlock() bool
 	GetConsistentTokenization() bool
 }
@@ -32,6 +34,8 @@ type Defaults interface {
 type TableSchema interface {
 	Name() string
 	Columns() []string
+	// EncryptedColumns returns settings of all encrypted columns in order of definition
+	EncryptedColumns() []ColumnEncryptionSetting
 	NeedToEncrypt(columnName string) bool
 	// GetColumnEncryptionSettings fetches encryption settings for given column,
 	// or returns nil if the column should not be encrypted.
@@ -44,18 +48,30 @@ type ColumnEncryptionSetting interface {
 
 	ColumnName() string
 	ClientID() []byte
+	// GetAcraBlockAssociatedData returns data which AcraBlocks are bound to or nil
+	GetAcraBlockAssociatedData() []byte
+	// IsAcraBlockColumnBindingRequired returns true if AcraBlocks which aren't bound to the column should be refused
+	IsAcraBlockColumnBindingRequired() bool
 
 	GetDBDataTypeID() uint32
+	// GetDBTypeOID returns OID of user-defined type which clients should see instead of GetDBDataTypeID or 0
+	GetDBTypeOID() uint32
+	// GetWasmProcessor returns settings of WebAssembly functions applied to values of the column or nil
+	GetWasmProcessor() *WasmProcessorSetting
 	GetEncryptedDataType() common2.EncryptedType
 	GetDefaultDataValue() *string
 	GetResponseOnFail() common2.ResponseOnFail
 
 	// Searchable encryption
 	IsSearchable() bool
+	// Pseudonymization
+	IsPseudonymized() bool
 	// Data masking
 	GetMaskingPattern() string
 	GetPartialPlaintextLen() int
 	IsEndMasking() bool
+	GetMaskingTemplate() *maskingCommon.MaskingTemplate
+	GetMaskingAccess() *maskingCommon.MaskingAccess
 	OnlyEncryption() bool
 
 	Defaults
@@ -78,6 +94,15 @@ func (schema *tableSchema) Columns() []string {
 	return schema.TableColumns
 }
 
+// EncryptedColumns returns settings of all encrypted columns in order of definition
+func (schema *tableSchema) EncryptedColumns() []ColumnEncryptionSetting {
+	settings := make([]ColumnEncryptionSetting, 0, len(schema.EncryptionColumnSettings))
+	for _, setting := range schema.EncryptionColumnSettings {
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
 // initMap create map of columns to encrypt from array
 func (schema *tableSchema) initMap() {
 	mapEncryptedColumns := make(map[string]*BasicColumnEncryptionSetting)
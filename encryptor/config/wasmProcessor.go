@@ -0,0 +1,67 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Validation errors of wasm_processor
+var (
+	ErrWasmProcessorWithoutModule    = errors.New("wasm_processor should contain path to module")
+	ErrWasmProcessorWithoutFunctions = errors.New("wasm_processor should contain on_encrypt or on_decrypt function")
+	ErrWasmProcessorInvalidTimeout   = errors.New("wasm_processor timeout should be positive")
+)
+
+// DefaultWasmCallTimeout limits execution time of function if wasm_processor doesn't specify timeout
+const DefaultWasmCallTimeout = time.Second
+
+// WasmProcessorSetting configures functions of WebAssembly module applied to values of the column. Functions may
+// replace values (tokenization, masking) or reject them (validation)
+type WasmProcessorSetting struct {
+	// Module is path to .wasm file
+	Module string `yaml:"module"`
+	// OnEncrypt is name of exported function applied to plaintext values from queries before encryption
+	OnEncrypt string `yaml:"on_encrypt"`
+	// OnDecrypt is name of exported function applied to decrypted values before they are sent to the client
+	OnDecrypt string `yaml:"on_decrypt"`
+	// Timeout limits execution time of one call, function is interrupted after it. DefaultWasmCallTimeout if not set
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GetTimeout returns execution time limit of one call
+func (s *WasmProcessorSetting) GetTimeout() time.Duration {
+	if s.Timeout == 0 {
+		return DefaultWasmCallTimeout
+	}
+	return s.Timeout
+}
+
+// Validate checks that module and at least one function are specified
+func (s *WasmProcessorSetting) Validate() error {
+	if s.Module == "" {
+		return ErrWasmProcessorWithoutModule
+	}
+	if s.OnEncrypt == "" && s.OnDecrypt == "" {
+		return ErrWasmProcessorWithoutFunctions
+	}
+	if s.Timeout < 0 {
+		return ErrWasmProcessorInvalidTimeout
+	}
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"flag"
+	"os"
 	"sync"
 
 	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -14,14 +16,19 @@ import (
 const (
 	EncryptoConfigStorageTypeConsul     = "consul"
 	EncryptoConfigStorageTypeFilesystem = "filesystem"
+	EncryptoConfigStorageTypeDatabase   = "database"
 )
 
 // SupportedEncryptorConfigStorages contains all possible values for flag `--encryptor_config_storage_type`
 var SupportedEncryptorConfigStorages = []string{
 	EncryptoConfigStorageTypeConsul,
 	EncryptoConfigStorageTypeFilesystem,
+	EncryptoConfigStorageTypeDatabase,
 }
 
+// defaultEncryptorConfigPerm used to write migrated EncryptorConfig if permissions of original one are unknown
+const defaultEncryptorConfigPerm os.FileMode = 0600
+
 var (
 	// ErrEncryptorConfigStorageNotFound represent an error of missing EncryptorConfigStorage in registry
 	ErrEncryptorConfigStorageNotFound = errors.New("ErrEncryptorConfigStorageNotFound not found by storage type")
@@ -88,6 +95,40 @@ func (c *ConfigLoader) Load() ([]byte, error) {
 	return encryptorConfig, nil
 }
 
+// Migrate rewrites EncryptorConfig in encryptor.ConfigStorage to the newest schema version keeping base64 encoding
+// if it was used. Returns version of original config, config of the current version is not rewritten
+func (c *ConfigLoader) Migrate() (int, error) {
+	configPath := c.configStorage.GetEncryptorConfigPath()
+
+	encryptorConfig, err := c.configStorage.ReadFile(configPath)
+	if err != nil {
+		return 0, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(encryptorConfig))
+	isBase64 := err == nil
+	if isBase64 {
+		encryptorConfig = decoded
+	}
+	upgraded, version, err := config.UpgradeConfig(encryptorConfig)
+	if err != nil {
+		return 0, err
+	}
+	if version == config.SchemaVersionCurrent {
+		return version, nil
+	}
+	if isBase64 {
+		upgraded = []byte(base64.StdEncoding.EncodeToString(upgraded))
+	}
+	perm := defaultEncryptorConfigPerm
+	if info, err := c.configStorage.Stat(configPath); err == nil && info.Mode().Perm() != 0 {
+		perm = info.Mode().Perm()
+	}
+	if err := c.configStorage.WriteFile(configPath, upgraded, perm); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
 // RegisterEncryptorConfigLoaderCLIWithFlags register flags for all fabrics
 func RegisterEncryptorConfigLoaderCLIWithFlags(flag *flag.FlagSet, prefix, description string) {
 	for _, v := range configStorageCreators {
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/encryptor"
+)
+
+// Errors returned by database encryptor config storage
+var (
+	ErrInvalidTableName      = errors.New("invalid encryptor config table name")
+	ErrOperationNotSupported = errors.New("operation not supported by database encryptor config storage")
+)
+
+var tableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+// StorageCreator implement config_loader.EncryptorConfigStorage via table in PostgreSQL or MySQL database
+type StorageCreator struct{}
+
+// IsStorageConfigured check weather CLI flag for database using was provided
+func (s StorageCreator) IsStorageConfigured(flags *flag.FlagSet, prefix string) bool {
+	if cliOptions := ParseCLIParametersFromFlags(flags, prefix); cliOptions.ConnectionString != "" {
+		return true
+	}
+	return false
+}
+
+// RegisterCLIParameters register CLI flags for FlagSet for database
+func (s StorageCreator) RegisterCLIParameters(flags *flag.FlagSet, prefix, description string) {
+	RegisterCLIParametersWithFlagSet(flags, prefix, description)
+}
+
+// NewStorage create config_loader.EncryptorConfigStorage from FlagSet
+func (s StorageCreator) NewStorage(flags *flag.FlagSet, prefix string) (encryptor.ConfigStorage, error) {
+	cliOptions := ParseCLIParametersFromFlags(flags, prefix)
+
+	var db *sql.DB
+	if cliOptions.UseMySQL {
+		config, err := mysql.ParseDSN(cliOptions.ConnectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse connection string for MySQL driver")
+			return nil, err
+		}
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			return nil, err
+		}
+		db = sql.OpenDB(connector)
+	} else {
+		config, err := pgx.ParseConfig(cliOptions.ConnectionString)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse connection string for PostgreSQL driver")
+			return nil, err
+		}
+		db = stdlib.OpenDB(*config)
+	}
+	storage, err := NewStorage(db, cliOptions.Table, cliOptions.EncryptorConfigName, cliOptions.UseMySQL)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Infof("Load encryptor configuration from table %s with name %s ...", cliOptions.Table, cliOptions.EncryptorConfigName)
+	return storage, nil
+}
+
+// Storage implementation of config_loader.EncryptorConfigStorage which keeps encryptor configs in the table:
+//
+//	CREATE TABLE acra_encryptor_config (name VARCHAR(255) PRIMARY KEY, config BYTEA NOT NULL);  -- PostgreSQL
+//	CREATE TABLE acra_encryptor_config (name VARCHAR(255) PRIMARY KEY, config MEDIUMBLOB NOT NULL);  -- MySQL
+//
+// Paths of files are values of `name` column
+type Storage struct {
+	db                  *sql.DB
+	encryptorConfigName string
+	selectQuery         string
+	upsertQuery         string
+	deleteQuery         string
+}
+
+// NewStorage returns Storage which uses table of db
+func NewStorage(db *sql.DB, table, encryptorConfigName string, useMySQL bool) (*Storage, error) {
+	if !tableNameRegexp.MatchString(table) {
+		return nil, ErrInvalidTableName
+	}
+	storage := &Storage{db: db, encryptorConfigName: encryptorConfigName}
+	if useMySQL {
+		storage.selectQuery = fmt.Sprintf("SELECT config FROM %s WHERE name = ?", table)
+		storage.upsertQuery = fmt.Sprintf("INSERT INTO %s (name, config) VALUES (?, ?) ON DUPLICATE KEY UPDATE config = VALUES(config)", table)
+		storage.deleteQuery = fmt.Sprintf("DELETE FROM %s WHERE name = ?", table)
+	} else {
+		storage.selectQuery = fmt.Sprintf("SELECT config FROM %s WHERE name = $1", table)
+		storage.upsertQuery = fmt.Sprintf("INSERT INTO %s (name, config) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET config = EXCLUDED.config", table)
+		storage.deleteQuery = fmt.Sprintf("DELETE FROM %s WHERE name = $1", table)
+	}
+	return storage, nil
+}
+
+// GetEncryptorConfigPath implementation of config_loader.EncryptorConfigStorage
+func (s *Storage) GetEncryptorConfigPath() string {
+	return s.encryptorConfigName
+}
+
+// ReadFile implementation of filesystem.Storage interface
+func (s *Storage) ReadFile(path string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(s.selectQuery, path).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFile implementation of filesystem.Storage interface
+func (s *Storage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	_, err := s.db.Exec(s.upsertQuery, path, data)
+	return err
+}
+
+// Remove implementation of filesystem.Storage interface
+func (s *Storage) Remove(path string) error {
+	_, err := s.db.Exec(s.deleteQuery, path)
+	return err
+}
+
+// Stat implementation of filesystem.Storage interface
+func (s *Storage) Stat(path string) (os.FileInfo, error) {
+	data, err := s.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path, size: len(data)}, nil
+}
+
+// Exists implementation of filesystem.Storage interface
+func (s *Storage) Exists(path string) (bool, error) {
+	_, err := s.ReadFile(path)
+	if err == os.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy implementation of filesystem.Storage interface
+func (s *Storage) Copy(src, dst string) error {
+	data, err := s.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(dst, data, 0)
+}
+
+// Rename implementation of filesystem.Storage interface
+func (s *Storage) Rename(oldpath, newpath string) error {
+	if err := s.Copy(oldpath, newpath); err != nil {
+		return err
+	}
+	return s.Remove(oldpath)
+}
+
+// ReadDir implementation of filesystem.Storage interface, table doesn't have hierarchy of names
+func (s *Storage) ReadDir(path string) ([]os.FileInfo, error) {
+	return nil, ErrOperationNotSupported
+}
+
+// MkdirAll implementation of filesystem.Storage interface
+func (s *Storage) MkdirAll(path string, perm os.FileMode) error {
+	// table doesn't have hierarchy of names, so there is nothing to create
+	return nil
+}
+
+// TempFile implementation of filesystem.Storage interface
+func (s *Storage) TempFile(pattern string, perm os.FileMode) (string, error) {
+	return "", ErrOperationNotSupported
+}
+
+// TempDir implementation of filesystem.Storage interface
+func (s *Storage) TempDir(pattern string, perm os.FileMode) (string, error) {
+	return "", ErrOperationNotSupported
+}
+
+// Link implementation of filesystem.Storage interface
+func (s *Storage) Link(oldpath, newpath string) error {
+	return ErrOperationNotSupported
+}
+
+// RemoveAll implementation of filesystem.Storage interface
+func (s *Storage) RemoveAll(path string) error {
+	return s.Remove(path)
+}
+
+type fileInfo struct {
+	name string
+	size int
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return int64(fi.size)
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return os.FileMode(0600)
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return false
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
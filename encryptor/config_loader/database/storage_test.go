@@ -0,0 +1,36 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewStorageQueries(t *testing.T) {
+	testcases := []struct {
+		table    string
+		useMySQL bool
+		err      error
+		query    string
+	}{
+		{"acra_encryptor_config", false, nil, "SELECT config FROM acra_encryptor_config WHERE name = $1"},
+		{"acra_encryptor_config", true, nil, "SELECT config FROM acra_encryptor_config WHERE name = ?"},
+		{"", false, ErrInvalidTableName, ""},
+		{"config; DROP TABLE users", false, ErrInvalidTableName, ""},
+		{"1config", true, ErrInvalidTableName, ""},
+	}
+	for i, tcase := range testcases {
+		storage, err := NewStorage(nil, tcase.table, defaultEncryptorConfigName, tcase.useMySQL)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if storage.selectQuery != tcase.query {
+			t.Fatalf("[%d] Expected query '%s', took '%s'", i, tcase.query, storage.selectQuery)
+		}
+		if storage.GetEncryptorConfigPath() != defaultEncryptorConfigName {
+			t.Fatalf("[%d] Unexpected encryptor config path %s", i, storage.GetEncryptorConfigPath())
+		}
+	}
+}
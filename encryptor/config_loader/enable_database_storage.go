@@ -0,0 +1,10 @@
+//go:build !database_encryptor_config_loader_off
+// +build !database_encryptor_config_loader_off
+
+package config_loader
+
+import "github.com/cossacklabs/acra/encryptor/config_loader/database"
+
+func init() {
+	RegisterEncryptorConfigStorageCreator(EncryptoConfigStorageTypeDatabase, database.StorageCreator{})
+}
@@ -0,0 +1,75 @@
+package config_loader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// ErrInvalidPollInterval returned if ConfigWatcher created with non-positive interval
+var ErrInvalidPollInterval = errors.New("encryptor config poll interval should be positive")
+
+// Loader loads EncryptorConfig from storage, implemented by ConfigLoader
+type Loader interface {
+	Load() ([]byte, error)
+}
+
+// ConfigChangeHandler applies new EncryptorConfig
+type ConfigChangeHandler func(encryptorConfig []byte) error
+
+// ConfigWatcher polls encryptor config storage and calls ConfigChangeHandler when the config changes, so several
+// instances which use the same storage (Consul KV, table in the database) stay in sync without restarts
+type ConfigWatcher struct {
+	loader   Loader
+	interval time.Duration
+	onChange ConfigChangeHandler
+	current  []byte
+}
+
+// NewConfigWatcher returns ConfigWatcher which compares loaded configs with current one, already applied config
+func NewConfigWatcher(loader Loader, interval time.Duration, current []byte, onChange ConfigChangeHandler) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidPollInterval
+	}
+	return &ConfigWatcher{loader: loader, interval: interval, onChange: onChange, current: current}, nil
+}
+
+// Run polls storage until ctx is done. Should be called as goroutine
+func (watcher *ConfigWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			watcher.check()
+		}
+	}
+}
+
+// check loads config and applies it if it differs from current one. Returns true if new config was applied
+func (watcher *ConfigWatcher) check() bool {
+	encryptorConfig, err := watcher.loader.Load()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Warningln("Can't poll encryptor config, previous config left in use")
+		return false
+	}
+	if bytes.Equal(encryptorConfig, watcher.current) {
+		return false
+	}
+	// remember invalid config too to not try to apply it on each poll until it is fixed
+	watcher.current = encryptorConfig
+	if err := watcher.onChange(encryptorConfig); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't apply changed encryptor config, previous config left in use")
+		return false
+	}
+	return true
+}
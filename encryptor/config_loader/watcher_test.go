@@ -0,0 +1,64 @@
+package config_loader
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testLoader struct {
+	config []byte
+	err    error
+}
+
+func (loader *testLoader) Load() ([]byte, error) {
+	return loader.config, loader.err
+}
+
+func TestConfigWatcher(t *testing.T) {
+	loader := &testLoader{config: []byte("initial")}
+	applied := [][]byte{}
+	var applyErr error
+	onChange := func(config []byte) error {
+		applied = append(applied, config)
+		return applyErr
+	}
+	if _, err := NewConfigWatcher(loader, 0, loader.config, onChange); !errors.Is(err, ErrInvalidPollInterval) {
+		t.Fatalf("Expected ErrInvalidPollInterval, took %v", err)
+	}
+	watcher, err := NewConfigWatcher(loader, time.Second, loader.config, onChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watcher.check() || len(applied) != 0 {
+		t.Fatal("Unchanged config was applied")
+	}
+
+	loader.config = []byte("changed")
+	if !watcher.check() || len(applied) != 1 || string(applied[0]) != "changed" {
+		t.Fatal("Changed config wasn't applied")
+	}
+	if watcher.check() || len(applied) != 1 {
+		t.Fatal("Config was applied twice")
+	}
+
+	// storage errors don't reset current config
+	loader.err = errors.New("unavailable")
+	if watcher.check() || len(applied) != 1 {
+		t.Fatal("Config applied on load error")
+	}
+	loader.err = nil
+	if watcher.check() || len(applied) != 1 {
+		t.Fatal("Config applied after restored storage")
+	}
+
+	// invalid config is applied only once
+	loader.config = []byte("invalid")
+	applyErr = errors.New("invalid config")
+	if watcher.check() || len(applied) != 2 {
+		t.Fatal("Expected failed attempt to apply invalid config")
+	}
+	if watcher.check() || len(applied) != 2 {
+		t.Fatal("Invalid config applied twice")
+	}
+}
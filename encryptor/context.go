@@ -25,3 +25,16 @@ func EncryptionSettingFromContext(ctx context.Context) (config.ColumnEncryptionS
 	setting, ok := value.(config.ColumnEncryptionSetting)
 	return setting, ok
 }
+
+type tableNameKey struct{}
+
+// NewContextWithTableName makes a new context containing name of the table which column of the result belongs to.
+func NewContextWithTableName(ctx context.Context, tableName string) context.Context {
+	return context.WithValue(ctx, tableNameKey{}, tableName)
+}
+
+// TableNameFromContext returns name of the table saved with NewContextWithTableName or empty string if it's unknown.
+func TableNameFromContext(ctx context.Context) string {
+	tableName, _ := ctx.Value(tableNameKey{}).(string)
+	return tableName
+}
@@ -28,6 +28,8 @@ import (
 type DataEncryptorContext struct {
 	Keystore keystore.DataEncryptorKeyStore
 	Context  context.Context
+	// Setting of encrypted column, may be nil if data encrypted without encryptor config
+	Setting config.ColumnEncryptionSetting
 }
 
 // DataEncryptor replace raw data in queries with encrypted
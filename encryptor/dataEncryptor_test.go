@@ -24,6 +24,7 @@ import (
 	"github.com/cossacklabs/acra/acrastruct"
 	"github.com/cossacklabs/acra/encryptor/config"
 	common2 "github.com/cossacklabs/acra/encryptor/config/common"
+	maskingCommon "github.com/cossacklabs/acra/masking/common"
 
 	"github.com/cossacklabs/acra/pseudonymization/common"
 	"github.com/cossacklabs/themis/gothemis/keys"
@@ -72,6 +73,14 @@ func (s *emptyEncryptionSetting) GetDBDataTypeID() uint32 {
 	panic("implement me")
 }
 
+func (s *emptyEncryptionSetting) GetDBTypeOID() uint32 {
+	panic("implement me")
+}
+
+func (s *emptyEncryptionSetting) GetWasmProcessor() *config.WasmProcessorSetting {
+	panic("implement me")
+}
+
 func (s *emptyEncryptionSetting) GetEncryptedDataType() common2.EncryptedType {
 	panic("implement me")
 }
@@ -92,6 +101,18 @@ func (s *emptyEncryptionSetting) GetCryptoEnvelope() config.CryptoEnvelopeType {
 	return config.CryptoEnvelopeTypeAcraStruct
 }
 
+func (s *emptyEncryptionSetting) GetAcraBlockCipher() config.AcraBlockCipherType {
+	panic("implement me")
+}
+
+func (s *emptyEncryptionSetting) GetAcraBlockAssociatedData() []byte {
+	panic("implement me")
+}
+
+func (s *emptyEncryptionSetting) IsAcraBlockColumnBindingRequired() bool {
+	panic("implement me")
+}
+
 func (s *emptyEncryptionSetting) ShouldReEncryptAcraStructToAcraBlock() bool {
 	panic("implement me")
 }
@@ -112,10 +133,22 @@ func (s *emptyEncryptionSetting) IsEndMasking() bool {
 	panic("implement me")
 }
 
+func (s *emptyEncryptionSetting) GetMaskingTemplate() *maskingCommon.MaskingTemplate {
+	panic("implement me")
+}
+
+func (s *emptyEncryptionSetting) GetMaskingAccess() *maskingCommon.MaskingAccess {
+	panic("implement me")
+}
+
 func (s *emptyEncryptionSetting) IsTokenized() bool {
 	panic("implement me")
 }
 
+func (s *emptyEncryptionSetting) IsPseudonymized() bool {
+	panic("implement me")
+}
+
 func (s *emptyEncryptionSetting) IsConsistentTokenization() bool {
 	panic("implement me")
 }
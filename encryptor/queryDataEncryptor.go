@@ -34,10 +34,16 @@ import (
 
 // QueryDataItem stores information about table column and encryption setting
 type QueryDataItem struct {
-	setting     config.ColumnEncryptionSetting
-	tableName   string
-	columnName  string
-	columnAlias string
+	setting      config.ColumnEncryptionSetting
+	tableName    string
+	columnName   string
+	columnAlias  string
+	expandedStar bool
+}
+
+// NewQueryDataItem return new QueryDataItem for column with setting
+func NewQueryDataItem(setting config.ColumnEncryptionSetting, tableName, columnName, columnAlias string) *QueryDataItem {
+	return &QueryDataItem{setting: setting, tableName: tableName, columnName: columnName, columnAlias: columnAlias}
 }
 
 // Setting return associated ColumnEncryptionSetting or nil if not found
@@ -60,6 +66,11 @@ func (q *QueryDataItem) ColumnAlias() string {
 	return q.columnAlias
 }
 
+// IsExpandedFromStar return true if item was matched by expansion of star expression against columns from encryptor config
+func (q *QueryDataItem) IsExpandedFromStar() bool {
+	return q.expandedStar
+}
+
 // QueryDataEncryptor parse query and encrypt raw data according to TableSchemaStore
 type QueryDataEncryptor struct {
 	schemaStore         config.TableSchemaStore
@@ -67,6 +78,7 @@ type QueryDataEncryptor struct {
 	dataCoder           DBDataCoder
 	querySelectSettings []*QueryDataItem
 	parser              *sqlparser.Parser
+	columnCopyPolicy    base.ColumnCopyPolicy
 }
 
 // NewMysqlQueryEncryptor create QueryDataEncryptor with MySQLDBDataCoder
@@ -79,6 +91,12 @@ func NewPostgresqlQueryEncryptor(schema config.TableSchemaStore, parser *sqlpars
 	return &QueryDataEncryptor{schemaStore: schema, parser: parser, encryptor: dataEncryptor, dataCoder: &PostgresqlDBDataCoder{}}, nil
 }
 
+// SetColumnCopyPolicy sets handling of INSERT ... SELECT and UPDATE queries which copy data between columns with
+// different encryption settings. Such queries are denied if policy is not set
+func (encryptor *QueryDataEncryptor) SetColumnCopyPolicy(policy base.ColumnCopyPolicy) {
+	encryptor.columnCopyPolicy = policy
+}
+
 // ID returns name of this QueryObserver.
 func (encryptor *QueryDataEncryptor) ID() string {
 	return "QueryDataEncryptor"
@@ -89,14 +107,22 @@ func (encryptor *QueryDataEncryptor) GetQueryEncryptionSettings() []*QueryDataIt
 	return encryptor.querySelectSettings
 }
 
-// encryptInsertQuery encrypt data in insert query in VALUES and ON DUPLICATE KEY UPDATE statements
+// encryptInsertQuery encrypt data in insert query in VALUES, SELECT and ON DUPLICATE KEY UPDATE statements
 func (encryptor *QueryDataEncryptor) encryptInsertQuery(ctx context.Context, insert *sqlparser.Insert, bindPlaceholders map[int]config.ColumnEncryptionSetting) (bool, error) {
 	tableName := insert.Table.Name
 	schema := encryptor.schemaStore.GetTableSchema(tableName.ValueForConfig())
 	if schema == nil {
 		// unsupported table, we have not schema and query hasn't columns description
 		logrus.Debugf("Hasn't schema for table %s", tableName)
-		return false, nil
+		if encryptor.encryptor == nil {
+			return false, nil
+		}
+		// encrypted data still may be copied into not encrypted table
+		var columnsName []string
+		for _, col := range insert.Columns {
+			columnsName = append(columnsName, col.ValueForConfig())
+		}
+		return false, encryptor.checkInsertSelectCopy(ctx, insert, nil, columnsName)
 	}
 
 	if encryptor.encryptor == nil {
@@ -115,6 +141,10 @@ func (encryptor *QueryDataEncryptor) encryptInsertQuery(ctx context.Context, ins
 		columnsName = cols
 	}
 
+	if err := encryptor.checkInsertSelectCopy(ctx, insert, schema, columnsName); err != nil {
+		return false, err
+	}
+
 	changed := false
 
 	if len(columnsName) > 0 {
@@ -137,6 +167,13 @@ func (encryptor *QueryDataEncryptor) encryptInsertQuery(ctx context.Context, ins
 					}
 				}
 			}
+		case *sqlparser.Select:
+			selectChanged, err := encryptor.encryptInsertSelectValues(ctx, rows, schema, columnsName, bindPlaceholders)
+			if err != nil {
+				logrus.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorCantEncryptExpression).WithError(err).Errorln("Can't encrypt expression")
+				return changed, err
+			}
+			changed = changed || selectChanged
 		}
 	}
 
@@ -226,7 +263,7 @@ func (encryptor *QueryDataEncryptor) encryptExpression(ctx context.Context, expr
 			if len(data) == 0 {
 				return data, nil
 			}
-			return encryptor.encryptWithColumnSettings(ctx, schema.GetColumnEncryptionSettings(columnName), data)
+			return encryptor.encryptWithColumnSettings(ctx, schema.Name(), schema.GetColumnEncryptionSettings(columnName), data)
 		})
 		// didn't change anything because it already encrypted
 		if err == ErrUpdateLeaveDataUnchanged {
@@ -350,10 +387,15 @@ func (encryptor *QueryDataEncryptor) encryptUpdateQuery(ctx context.Context, upd
 		return false, encryptor.onReturning(ctx, update.Returning, fromTables)
 	}
 
+	if err := encryptor.checkUpdateCopy(ctx, update.Exprs, fromTables, firstTable, qualifierMap); err != nil {
+		return false, err
+	}
+
 	return encryptor.encryptUpdateExpressions(ctx, update.Exprs, firstTable, qualifierMap, bindPlaceholders)
 }
 
-// OnColumn return new encryption setting context if info exist, otherwise column data and passed context will be returned
+// OnColumn return new context with encryption setting and table name of the column if info exist, otherwise column data
+// and passed context will be returned
 func (encryptor *QueryDataEncryptor) OnColumn(ctx context.Context, data []byte) (context.Context, []byte, error) {
 	columnInfo, ok := base.ColumnInfoFromContext(ctx)
 	if ok {
@@ -363,6 +405,7 @@ func (encryptor *QueryDataEncryptor) OnColumn(ctx context.Context, data []byte)
 			if selectSetting != nil {
 
 				logging.GetLoggerFromContext(ctx).WithField("column_index", columnInfo.Index()).WithField("column", selectSetting.ColumnName()).Debugln("Set encryption setting")
+				ctx = NewContextWithTableName(ctx, selectSetting.TableName())
 				return NewContextWithEncryptionSetting(ctx, selectSetting.Setting()), data, nil
 			}
 		}
@@ -389,10 +432,11 @@ func (encryptor *QueryDataEncryptor) onSelect(ctx context.Context, statement *sq
 						setting = nil
 						if columnSetting := schema.GetColumnEncryptionSettings(name); columnSetting != nil {
 							setting = &QueryDataItem{
-								setting:     columnSetting,
-								tableName:   data.Table,
-								columnName:  name,
-								columnAlias: "",
+								setting:      columnSetting,
+								tableName:    data.Table,
+								columnName:   name,
+								columnAlias:  "",
+								expandedStar: true,
 							}
 						}
 						querySelectSettings = append(querySelectSettings, setting)
@@ -443,56 +487,73 @@ func (encryptor *QueryDataEncryptor) onDelete(ctx context.Context, delete *sqlpa
 	return false, nil
 }
 
-func (encryptor *QueryDataEncryptor) onReturning(ctx context.Context, returning sqlparser.Returning, fromTables sqlparser.TableExprs) error {
-	if len(returning) == 0 {
-		return nil
-	}
-
+// expandReturningStar returns settings of all columns of tables matched by star expression from RETURNING clause in
+// the order of tables. Qualified star expression like table.* matches only the table with such name or alias
+func (encryptor *QueryDataEncryptor) expandReturningStar(star *sqlparser.StarExpr, fromTables sqlparser.TableExprs) ([]*QueryDataItem, error) {
 	querySelectSettings := make([]*QueryDataItem, 0, 8)
+	qualifier := star.TableName.Name.ValueForConfig()
+	matched := false
+	for _, tableExp := range fromTables {
+		aliased, ok := tableExp.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			continue
+		}
 
-	if _, ok := returning[0].(*sqlparser.StarExpr); ok {
-		for _, tableExp := range fromTables {
-			aliased, ok := tableExp.(*sqlparser.AliasedTableExpr)
-			if !ok {
-				continue
-			}
-
-			tableName, ok := aliased.Expr.(sqlparser.TableName)
-			if !ok {
+		tableName, ok := aliased.Expr.(sqlparser.TableName)
+		if !ok {
+			continue
+		}
+		if qualifier != "" && qualifier != tableName.Name.ValueForConfig() && qualifier != aliased.As.ValueForConfig() {
+			continue
+		}
+		matched = true
+
+		// if the Returning is star and we have more than one table in the query e.g.
+		// update table1 set did = tt.did from table2 as tt returning *
+		// and the table is not in the encryptor config we cant collect corresponding querySettings as we dont actual table representation
+		tableSchema := encryptor.schemaStore.GetTableSchema(tableName.Name.ValueForConfig())
+		if tableSchema == nil {
+			logrus.WithField("table", tableName.Name.ValueForConfig()).Info("Unable to collect querySettings for table not in encryptor config")
+			return nil, errors.New("error to collect settings for unknown table")
+		}
+
+		for _, name := range tableSchema.Columns() {
+			if columnSetting := tableSchema.GetColumnEncryptionSettings(name); columnSetting != nil {
+				querySelectSettings = append(querySelectSettings, &QueryDataItem{
+					setting:      columnSetting,
+					tableName:    tableName.Name.ValueForConfig(),
+					columnName:   name,
+					expandedStar: true,
+				})
 				continue
 			}
-
-			// if the Returning is star and we have more than one table in the query e.g.
-			// update table1 set did = tt.did from table2 as tt returning *
-			// and the table is not in the encryptor config we cant collect corresponding querySettings as we dont actual table representation
-			tableSchema := encryptor.schemaStore.GetTableSchema(tableName.Name.ValueForConfig())
-			if tableSchema == nil {
-				logrus.WithField("table", tableName.Name.ValueForConfig()).Info("Unable to collect querySettings for table not in encryptor config")
-				return errors.New("error to collect settings for unknown table")
-			}
-
-			for _, name := range tableSchema.Columns() {
-				if columnSetting := tableSchema.GetColumnEncryptionSettings(name); columnSetting != nil {
-					querySelectSettings = append(querySelectSettings, &QueryDataItem{
-						setting:    columnSetting,
-						tableName:  tableName.Name.ValueForConfig(),
-						columnName: name,
-					})
-					continue
-				}
-				querySelectSettings = append(querySelectSettings, nil)
-			}
+			querySelectSettings = append(querySelectSettings, nil)
 		}
+	}
+	if !matched {
+		logrus.WithField("table", qualifier).Info("Unable to collect querySettings for star expression of unknown table")
+		return nil, errors.New("error to collect settings for unknown table")
+	}
+	return querySelectSettings, nil
+}
 
-		clientSession := base.ClientSessionFromContext(ctx)
-		SaveQueryDataItemsToClientSession(clientSession, querySelectSettings)
-		encryptor.querySelectSettings = querySelectSettings
+func (encryptor *QueryDataEncryptor) onReturning(ctx context.Context, returning sqlparser.Returning, fromTables sqlparser.TableExprs) error {
+	if len(returning) == 0 {
 		return nil
 	}
 
+	querySelectSettings := make([]*QueryDataItem, 0, 8)
+
 	for _, item := range returning {
 		var colName *sqlparser.ColName
 		switch returningItem := item.(type) {
+		case *sqlparser.StarExpr:
+			starSettings, err := encryptor.expandReturningStar(returningItem, fromTables)
+			if err != nil {
+				return err
+			}
+			querySelectSettings = append(querySelectSettings, starSettings...)
+			continue
 		case *sqlparser.AliasedExpr:
 			switch expr := returningItem.Expr.(type) {
 			case *sqlparser.ColName:
@@ -504,7 +565,7 @@ func (encryptor *QueryDataEncryptor) onReturning(ctx context.Context, returning
 				continue
 			}
 		default:
-			// skip all other not relevant types: StarExpr & Nextval
+			// skip all other not relevant types: Nextval
 			querySelectSettings = append(querySelectSettings, nil)
 			continue
 		}
@@ -590,7 +651,7 @@ func (encryptor *QueryDataEncryptor) OnBind(ctx context.Context, statement sqlpa
 	return newValues, changed, nil
 }
 
-func (encryptor *QueryDataEncryptor) getInsertPlaceholders(ctx context.Context, insert *sqlparser.Insert) (map[int]string, error) {
+func (encryptor *QueryDataEncryptor) getInsertPlaceholders(ctx context.Context, insert *sqlparser.Insert, boundValuesCount int) (map[int]string, error) {
 	tableName := insert.Table.Name
 	logger := logging.GetLoggerFromContext(ctx)
 	// Look for the schema of the table where the INSERT happens.
@@ -623,9 +684,10 @@ func (encryptor *QueryDataEncryptor) getInsertPlaceholders(ctx context.Context,
 	// We can also only process simple queries of the form
 	//
 	//     INSERT INTO table(column...) VALUES ($1, $2, 'static value'...);
+	//     INSERT INTO table(column...) SELECT $1, other_column FROM other_table ...;
 	//
 	// That is, where placeholders uniquely identify the column and used directly
-	// as inserted values. We don't support functions, casts, inserting query results, etc.
+	// as inserted values. We don't support functions, casts, etc.
 	//
 	// Walk through the query to find out which placeholders stand for which columns.
 	// Also count amount of passed value to validate that placeholder's index doesn't go out of this number
@@ -648,6 +710,23 @@ func (encryptor *QueryDataEncryptor) getInsertPlaceholders(ctx context.Context,
 				}
 			}
 		}
+	case *sqlparser.Select:
+		for i, selectExpr := range rows.SelectExprs {
+			if i >= len(columns) {
+				break
+			}
+			aliased, ok := selectExpr.(*sqlparser.AliasedExpr)
+			if !ok {
+				// * shifts positions of the next values
+				break
+			}
+			if value, ok := aliased.Expr.(*sqlparser.SQLVal); ok {
+				err := encryptor.updatePlaceholderMap(boundValuesCount, placeholders, value, columns[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
 	return placeholders, nil
 }
@@ -683,7 +762,7 @@ func (encryptor *QueryDataEncryptor) encryptInsertValues(ctx context.Context, in
 		logrus.WithField("table", tableName).Debugln("No encryption schema")
 		return values, false, nil
 	}
-	placeholders, err := encryptor.getInsertPlaceholders(ctx, insert)
+	placeholders, err := encryptor.getInsertPlaceholders(ctx, insert, len(values))
 	if err != nil {
 		logger.WithError(err).Errorln("Can't extract placeholders from INSERT query")
 		return values, false, err
@@ -819,7 +898,7 @@ func (encryptor *QueryDataEncryptor) encryptValuesWithPlaceholders(ctx context.C
 		if len(valueData) == 0 {
 			continue
 		}
-		encryptedData, err := encryptor.encryptWithColumnSettings(ctx, setting, valueData)
+		encryptedData, err := encryptor.encryptWithColumnSettings(ctx, schema.Name(), setting, valueData)
 		if err != nil && err != ErrUpdateLeaveDataUnchanged {
 			logrus.WithError(err).WithFields(logrus.Fields{"index": valueIndex, "column": columnName}).
 				Debug("Failed to encrypt column")
@@ -838,7 +917,7 @@ func (encryptor *QueryDataEncryptor) encryptValuesWithPlaceholders(ctx context.C
 }
 
 // encryptWithColumnSettings encrypt data and use ClientID from ColumnEncryptionSetting if not empty otherwise static ClientID that passed to parser
-func (encryptor *QueryDataEncryptor) encryptWithColumnSettings(ctx context.Context, columnSetting config.ColumnEncryptionSetting, data []byte) ([]byte, error) {
+func (encryptor *QueryDataEncryptor) encryptWithColumnSettings(ctx context.Context, tableName string, columnSetting config.ColumnEncryptionSetting, data []byte) ([]byte, error) {
 	logger := logrus.WithFields(logrus.Fields{"column": columnSetting.ColumnName()})
 	logger.Debugln("QueryDataEncryptor.encryptWithColumnSettings")
 	accessContext := base.AccessContextFromContext(ctx)
@@ -849,5 +928,9 @@ func (encryptor *QueryDataEncryptor) encryptWithColumnSettings(ctx context.Conte
 		logger.WithField("client_id", string(accessContext.GetClientID())).Debugln("Encrypt with ClientID from connection")
 		clientID = accessContext.GetClientID()
 	}
-	return encryptor.encryptor.EncryptWithClientID(clientID, data, columnSetting)
+	encrypted, err := encryptor.encryptor.EncryptWithClientID(clientID, data, columnSetting)
+	if err == nil && !bytes.Equal(encrypted, data) {
+		base.ColumnMetricsFromContext(ctx).Column(tableName, columnSetting.ColumnName()).OnEncryption()
+	}
+	return encrypted, err
 }
@@ -82,6 +82,8 @@ func testParsing(t *testing.T, testData []parserTestData, encryptedValue, defaul
 	if err != nil {
 		t.Fatal(err)
 	}
+	// cases check encryption of values, copying between columns is tested in TestColumnCopyPolicy
+	queryEncryptor.SetColumnCopyPolicy(base.ColumnCopyPolicyAllow)
 
 	var dialect dialect.Dialect
 
@@ -1094,6 +1096,63 @@ schemas:
 			}
 		}
 	})
+
+	t.Run("RETURNING star with columns and qualified star", func(t *testing.T) {
+		sqlparser.SetDefaultDialect(postgresql.NewPostgreSQLDialect())
+
+		testCases := []struct {
+			query    string
+			columns  []string
+			expanded []bool
+		}{
+			{
+				query:    "INSERT INTO TableWithColumnSchema (specified_client_id) VALUES (1) RETURNING specified_client_id, *, 1",
+				columns:  []string{"specified_client_id", "", "default_client_id", "specified_client_id", "common_field", ""},
+				expanded: []bool{false, false, true, true, true, false},
+			},
+			{
+				query:    "UPDATE TableWithColumnSchema as t1 SET specified_client_id = t2.specified_client_id FROM TableWithColumnSchema_2 as t2 RETURNING t2.*, t1.default_client_id",
+				columns:  []string{"", "default_client_id_2", "specified_client_id_2", "common_field", "default_client_id"},
+				expanded: []bool{false, true, true, true, false},
+			},
+			{
+				query:    "DELETE FROM TableWithColumnSchema USING TableWithColumnSchema_2 WHERE specified_client_id_2 = specified_client_id RETURNING TableWithColumnSchema.*",
+				columns:  []string{"", "default_client_id", "specified_client_id", "common_field"},
+				expanded: []bool{false, true, true, true},
+			},
+		}
+
+		for i, tcase := range testCases {
+			_, _, err := encryptor.OnQuery(ctx, base.NewOnQueryObjectFromQuery(tcase.query, parser))
+			if err != nil {
+				t.Fatalf("[%d] %s", i, err.Error())
+			}
+			if len(encryptor.querySelectSettings) != len(tcase.columns) {
+				t.Fatalf("[%d] Expected %d settings, took %d", i, len(tcase.columns), len(encryptor.querySelectSettings))
+			}
+			for j, setting := range encryptor.querySelectSettings {
+				if tcase.columns[j] == "" {
+					if setting != nil {
+						t.Fatalf("[%d] Expected nil setting on %d position, took %s", i, j, setting.columnName)
+					}
+					continue
+				}
+				if setting == nil || setting.columnName != tcase.columns[j] || setting.IsExpandedFromStar() != tcase.expanded[j] {
+					t.Fatalf("[%d] Expected %s on %d position, took %v", i, tcase.columns[j], j, setting)
+				}
+			}
+		}
+	})
+
+	t.Run("RETURNING qualified star of unknown table", func(t *testing.T) {
+		sqlparser.SetDefaultDialect(postgresql.NewPostgreSQLDialect())
+
+		query := "DELETE FROM TableWithColumnSchema RETURNING t2.*"
+		_, _, err := encryptor.OnQuery(ctx, base.NewOnQueryObjectFromQuery(query, parser))
+		if err == nil {
+			t.Fatal("Expected error for star expression of unknown table")
+		}
+	})
 }
 
 func TestEncryptionSettingCollection(t *testing.T) {
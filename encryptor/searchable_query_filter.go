@@ -41,6 +41,23 @@ type SearchableExprItem struct {
 	Setting config.ColumnEncryptionSetting
 }
 
+// Values returns values compared with the column: right operand of comparison or items of IN list
+func (item SearchableExprItem) Values() []*sqlparser.SQLVal {
+	switch right := item.Expr.Right.(type) {
+	case *sqlparser.SQLVal:
+		return []*sqlparser.SQLVal{right}
+	case sqlparser.ValTuple:
+		values := make([]*sqlparser.SQLVal, 0, len(right))
+		for _, expr := range right {
+			if sqlVal, ok := expr.(*sqlparser.SQLVal); ok {
+				values = append(values, sqlVal)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
 // SearchableQueryFilter filter searchable expression based on SearchableQueryFilterMode
 type SearchableQueryFilter struct {
 	mode        SearchableQueryFilterMode
@@ -147,7 +164,22 @@ func isSupportedSQLVal(val *sqlparser.SQLVal) bool {
 	return false
 }
 
-// filterColumnEqualComparisonExprs return only <ColName> = <VALUE> or <ColName> != <VALUE> or <ColName> <=> <VALUE> expressions
+// isSupportedValTuple returns true if all items of IN list are supported values
+func isSupportedValTuple(tuple sqlparser.ValTuple) bool {
+	if len(tuple) == 0 {
+		return false
+	}
+	for _, expr := range tuple {
+		sqlVal, ok := expr.(*sqlparser.SQLVal)
+		if !ok || !isSupportedSQLVal(sqlVal) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterColumnEqualComparisonExprs return only <ColName> = <VALUE> or <ColName> != <VALUE> or <ColName> <=> <VALUE>
+// or <ColName> [NOT] IN (<VALUE>, ...) expressions
 func (filter *SearchableQueryFilter) filterColumnEqualComparisonExprs(stmt sqlparser.SQLNode, tableExpr sqlparser.TableExprs) ([]SearchableExprItem, error) {
 	var exprs []SearchableExprItem
 
@@ -220,6 +252,16 @@ func (filter *SearchableQueryFilter) filterColumnEqualComparisonExprs(stmt sqlpa
 			}
 		}
 
+		// each value of IN list is processed like value of equal comparison
+		if tuple, ok := comparisonExpr.Right.(sqlparser.ValTuple); ok && isSupportedValTuple(tuple) {
+			if comparisonExpr.Operator == sqlparser.InStr || comparisonExpr.Operator == sqlparser.NotInStr {
+				exprs = append(exprs, SearchableExprItem{
+					Expr:    comparisonExpr,
+					Setting: lColumnSetting,
+				})
+			}
+		}
+
 		return true, nil
 	}, stmt)
 	return exprs, err
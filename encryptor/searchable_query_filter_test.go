@@ -86,6 +86,8 @@ schemas:
 	queries := []string{
 		`select * from mytable where substring(not_searchable, 1, 33) = '\x7F08FFD5012B0A7659EABE5758009178A2713749B1200C0BFD505B02D4FA26B08F';`,
 		`select * from mytable where encode('é', 'hex') = 'c3a9'`,
+		`select * from mytable where name in ('value', other_column)`,
+		`select * from mytable where name in (select name from other_table)`,
 	}
 
 	parser := sqlparser.New(sqlparser.ModeStrict)
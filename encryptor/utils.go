@@ -579,3 +579,29 @@ func DeletePlaceholderSettingsFromClientSession(session base.ClientSession) {
 	bindPlaceholdersPool.Put(data)
 	session.DeleteData(placeholdersSettingKey)
 }
+
+// VerifyExpandedColumns compares names of items expanded from star expression with result column names returned by
+// database on the same positions. Expanded items which don't match, because actual table schema differs from encryptor
+// config, replaced with nil to avoid processing of data with settings of another column. Returns the same slice if all
+// items match, otherwise a copy
+func VerifyExpandedColumns(items []*QueryDataItem, columnNames []string) []*QueryDataItem {
+	if len(items) != len(columnNames) {
+		return items
+	}
+	verified := items
+	copied := false
+	for i, item := range items {
+		if item == nil || !item.IsExpandedFromStar() || strings.EqualFold(item.ColumnName(), columnNames[i]) {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"column": columnNames[i], "expected_column": item.ColumnName(), "table": item.TableName(), "column_index": i}).
+			Warningln("Column expanded from star expression doesn't match result column, encryptor config may differ from table schema, skip column processing")
+		if !copied {
+			verified = make([]*QueryDataItem, len(items))
+			copy(verified, items)
+			copied = true
+		}
+		verified[i] = nil
+	}
+	return verified
+}
@@ -576,3 +576,37 @@ func TestPlaceholderSettings(t *testing.T) {
 		t.Fatal("Source map's data wasn't cleared")
 	}
 }
+
+func TestVerifyExpandedColumns(t *testing.T) {
+	items := []*QueryDataItem{
+		{setting: &config.BasicColumnEncryptionSetting{Name: "id"}, tableName: "test_table", columnName: "id"},
+		{setting: &config.BasicColumnEncryptionSetting{Name: "data1"}, tableName: "test_table", columnName: "data1", expandedStar: true},
+		nil,
+		{setting: &config.BasicColumnEncryptionSetting{Name: "data3"}, tableName: "test_table", columnName: "data3", expandedStar: true},
+	}
+
+	verified := VerifyExpandedColumns(items, []string{"other", "DATA1", "data2", "data3"})
+	for i := range items {
+		if verified[i] != items[i] {
+			t.Fatalf("[%d] Expected %v, took %v", i, items[i], verified[i])
+		}
+	}
+
+	// schema in database has new column data0 before data1
+	verified = VerifyExpandedColumns(items, []string{"id", "data0", "data1", "data3"})
+	expected := []*QueryDataItem{items[0], nil, nil, items[3]}
+	for i := range expected {
+		if verified[i] != expected[i] {
+			t.Fatalf("[%d] Expected %v, took %v", i, expected[i], verified[i])
+		}
+	}
+	if items[1] == nil {
+		t.Fatal("Source items shouldn't be changed")
+	}
+
+	// different count of columns is verified by caller
+	verified = VerifyExpandedColumns(items, []string{"id"})
+	if len(verified) != len(items) {
+		t.Fatalf("Expected %v, took %v", items, verified)
+	}
+}
@@ -74,6 +74,10 @@ func (encryptor *HashQuery) ID() string {
 //	WHERE column = $1        ===>   WHERE substring(column, 1, <HMAC_size>) = $1
 //
 // and actual "value" is passed via parameters later. See OnBind() for details.
+//
+// Each value of IN lists is processed in the same way:
+//
+//	WHERE column IN ('a', $1)   ===>   WHERE substring(column, 1, <HMAC_size>) IN (<HMAC('a')>, $1)
 func (encryptor *HashQuery) OnQuery(ctx context.Context, query base.OnQueryObject) (base.OnQueryObject, bool, error) {
 	logrus.Debugln("HashQuery.OnQuery")
 	stmt, err := query.Statement()
@@ -122,37 +126,43 @@ func (encryptor *HashQuery) OnQuery(ctx context.Context, query base.OnQueryObjec
 		// to escape from this ambiguity added explicit casting search hash to bytes;
 		// the result expression will look like `convert(substr(searchable_column, ...), binary) = 0xFFFFF`
 		// but previously we had `substr(searchable_column, ...) = X'some_value'`
+		values := item.Values()
 		if _, ok := encryptor.coder.(*queryEncryptor.MysqlDBDataCoder); ok {
-			if rVal, ok := item.Expr.Right.(*sqlparser.SQLVal); ok && rVal.Type != sqlparser.ValArg {
+			// column with placeholders is left without casting to be found by OnBind
+			hasPlaceholders := false
+			for _, rVal := range values {
+				if rVal.Type == sqlparser.ValArg {
+					hasPlaceholders = true
+					continue
+				}
+				rVal.Type = sqlparser.HexNum
+			}
+			if !hasPlaceholders {
 				item.Expr.Left = &sqlparser.ConvertExpr{
 					Expr: item.Expr.Left,
 					Type: &sqlparser.ConvertType{
 						Type: "binary",
 					},
 				}
-
-				rVal.Type = sqlparser.HexNum
 			}
 		}
 
-		// substring(column, 1, <HMAC_size>) = 'value' ===> substring(column, 1, <HMAC_size>) = <HMAC('value')>
-		// substring(column, 1, <HMAC_size>) = $1      ===> no changes
-		err := queryEncryptor.UpdateExpressionValue(ctx, item.Expr.Right, encryptor.coder, encryptor.calculateHmac)
-		if err != nil {
-			logrus.WithError(err).Debugln("Failed to update expression")
-			return query, false, err
-		}
-		sqlVal, ok := item.Expr.Right.(*sqlparser.SQLVal)
-		if !ok {
-			continue
-		}
-		placeholderIndex, err := queryEncryptor.ParsePlaceholderIndex(sqlVal)
-		if err == queryEncryptor.ErrInvalidPlaceholder {
-			continue
-		} else if err != nil {
-			return query, false, err
+		for _, sqlVal := range values {
+			// substring(column, 1, <HMAC_size>) = 'value' ===> substring(column, 1, <HMAC_size>) = <HMAC('value')>
+			// substring(column, 1, <HMAC_size>) = $1      ===> no changes
+			err := queryEncryptor.UpdateExpressionValue(ctx, sqlVal, encryptor.coder, encryptor.calculateHmac)
+			if err != nil {
+				logrus.WithError(err).Debugln("Failed to update expression")
+				return query, false, err
+			}
+			placeholderIndex, err := queryEncryptor.ParsePlaceholderIndex(sqlVal)
+			if err == queryEncryptor.ErrInvalidPlaceholder {
+				continue
+			} else if err != nil {
+				return query, false, err
+			}
+			bindSettings[placeholderIndex] = item.Setting
 		}
-		bindSettings[placeholderIndex] = item.Setting
 	}
 	logrus.Debugln("HashQuery.OnQuery changed query")
 	return base.NewOnQueryObjectFromStatement(stmt, encryptor.parser), true, nil
@@ -196,6 +206,22 @@ func (encryptor *HashQuery) OnBind(ctx context.Context, statement sqlparser.Stat
 				return values, false, queryEncryptor.ErrInvalidPlaceholder
 			}
 			indexes = append(indexes, index)
+		case sqlparser.ValTuple:
+			// IN list may contain both literals processed by OnQuery and placeholders
+			for _, sqlVal := range item.Values() {
+				index, err := queryEncryptor.ParsePlaceholderIndex(sqlVal)
+				if err == queryEncryptor.ErrInvalidPlaceholder {
+					continue
+				} else if err != nil {
+					return values, false, err
+				}
+				if index >= len(values) {
+					logrus.WithFields(logrus.Fields{"placeholder": sqlVal.Val, "index": index, "values": len(values)}).
+						Warning("Invalid placeholder index")
+					return values, false, queryEncryptor.ErrInvalidPlaceholder
+				}
+				indexes = append(indexes, index)
+			}
 		}
 	}
 	// Finally, once we know which values to replace with HMACs, do this replacement.
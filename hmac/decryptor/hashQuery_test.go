@@ -74,6 +74,8 @@ func TestSearchablePreparedStatementsWithTextFormat(t *testing.T) {
 		{Query: "INSERT INTO table2 SELECT * FROM test_table WHERE data1=$1 and data2=$2"},
 		{Query: "DELETE FROM test_table WHERE data1=$1"},
 		{Query: "DELETE FROM test_table WHERE data1=$1 OR data2=$2"},
+		{Query: "SELECT data1 from test_table WHERE data1 IN ($1, 'other-data')"},
+		{Query: "SELECT data1 from test_table WHERE data1 NOT IN ('other-data', $1)"},
 	}
 	for _, testcase := range testcases {
 		queryObj := base.NewOnQueryObjectFromQuery(testcase.Query, parser)
@@ -205,6 +207,84 @@ func TestSearchableWithTextFormat(t *testing.T) {
 	}
 }
 
+// TestSearchableInListWithTextFormat process searchable SELECT query with IN list of values without placeholders
+func TestSearchableInListWithTextFormat(t *testing.T) {
+	clientSession := &mocks.ClientSession{}
+	sessionData := make(map[string]interface{}, 2)
+	clientSession.On("GetData", mock.Anything).Return(func(key string) interface{} {
+		return sessionData[key]
+	}, func(key string) bool {
+		_, ok := sessionData[key]
+		return ok
+	})
+	clientSession.On("DeleteData", mock.Anything).Run(func(args mock.Arguments) {
+		delete(sessionData, args[0].(string))
+	})
+	clientSession.On("SetData", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sessionData[args[0].(string)] = args[1]
+	})
+	schemaConfig := `schemas:
+  - table: test_table
+    columns:
+      - data1
+      - data2
+    encrypted:
+      - column: data1
+        searchable: true`
+
+	schema, err := config.MapTableSchemaStoreFromConfig([]byte(schemaConfig), config.UseMySQL)
+	assert.NoError(t, err)
+
+	ctx := base.SetClientSessionToContext(context.Background(), clientSession)
+	parser := sqlparser.New(sqlparser.ModeDefault)
+	keyStore := &mocks2.ServerKeyStore{}
+	keyStore.On("GetHMACSecretKey", mock.Anything).Return([]byte(`some key`), nil)
+	registryHandler := crypto.NewRegistryHandler(nil)
+	encryptor := NewPostgresqlHashQuery(keyStore, schema, registryHandler)
+	coder := &encryptor2.PostgresqlDBDataCoder{}
+	values := []string{"first-data", "second-data"}
+
+	testcases := []string{
+		"SELECT data1 from test_table WHERE data1 IN ('%s', '%s')",
+		"DELETE FROM test_table WHERE data1 NOT IN ('%s', '%s') and data2='other-data'",
+	}
+	for _, query := range testcases {
+		queryObj := base.NewOnQueryObjectFromQuery(fmt.Sprintf(query, values[0], values[1]), parser)
+		queryObj, changed, err := encryptor.OnQuery(ctx, queryObj)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+
+		stmt, err := queryObj.Statement()
+		assert.NoError(t, err)
+
+		var comparisonExpr *sqlparser.ComparisonExpr
+		err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+			if expr, ok := node.(*sqlparser.ComparisonExpr); ok && comparisonExpr == nil {
+				comparisonExpr = expr
+			}
+			return true, nil
+		}, stmt)
+		assert.NoError(t, err)
+
+		_, isSubstrExpr := comparisonExpr.Left.(*sqlparser.SubstrExpr)
+		assert.True(t, isSubstrExpr)
+
+		tuple := comparisonExpr.Right.(sqlparser.ValTuple)
+		assert.Equal(t, len(values), len(tuple))
+		for i, value := range values {
+			hmacValue, err := encryptor.calculateHmac(ctx, []byte(value))
+			assert.NoError(t, err)
+
+			rightVal := tuple[i].(*sqlparser.SQLVal)
+			assert.NotEqual(t, value, string(rightVal.Val))
+
+			newData, err := coder.Encode(rightVal, hmacValue)
+			assert.NoError(t, err)
+			assert.Equal(t, len(rightVal.Val), len(newData))
+		}
+	}
+}
+
 // TestSearchableWithJoinsWithTextFormat process searchable SELECT query with placeholder for prepared statement
 // and use binding values in text format
 func TestSearchableWithJoinsWithTextFormat(t *testing.T) {
@@ -0,0 +1,322 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key operations which may require approval of the second operator
+const (
+	ApprovalOperationDestroy = "destroy"
+	ApprovalOperationRotate  = "rotate"
+)
+
+// DefaultApprovalRequestTTL is time after creation when pending approval request still may be approved
+const DefaultApprovalRequestTTL = time.Hour * 24
+
+// Errors of approval of key operations
+var (
+	ErrApprovalsNotSupported       = errors.New("keystore doesn't support approval requests")
+	ErrApprovalRequestNotFound     = errors.New("approval request not found")
+	ErrInvalidApprovalSignature    = errors.New("invalid signature of approval request")
+	ErrUnknownOperator             = errors.New("operator key is not trusted")
+	ErrSameOperator                = errors.New("approval request should be confirmed by another operator")
+	ErrInvalidOperatorKey          = errors.New("invalid operator key, expected PEM encoded Ed25519 key")
+	ErrUnsupportedApprovalRequest  = errors.New("unsupported operation of approval request")
+	ErrApprovalRequired            = errors.New("approval policy of keystore requires approval of key destruction and rotation by two operators")
+	ErrApprovalRequestExpired      = errors.New("approval request is expired")
+	ErrApprovalPolicyNotConfigured = errors.New("approval policy of keystore is not configured")
+	ErrApprovalPolicyExists        = errors.New("approval policy of keystore is already configured and can't be changed")
+	ErrNotEnoughOperators          = errors.New("approval policy requires at least two trusted operators")
+)
+
+// operatorPublicKeyExtension is the extension of files with public keys of operators in operators directory
+const operatorPublicKeyExtension = ".pub"
+
+// ApprovalRequest is pending key operation signed by the operator who requested it. Operation is executed only after
+// another trusted operator confirms it with own key. Fields describe the operation like arguments of
+// "acra-keys destroy" and "acra-keys rotate"
+type ApprovalRequest struct {
+	ID            string    `json:"id"`
+	Operation     string    `json:"operation"`
+	KeyKind       string    `json:"key_kind,omitempty"`
+	ClientID      string    `json:"client_id,omitempty"`
+	Index         int       `json:"index,omitempty"`
+	KeyIDPatterns []string  `json:"key_id_patterns,omitempty"`
+	TagSelector   KeyTags   `json:"tag_selector,omitempty"`
+	RotatedKeys   bool      `json:"rotated_keys,omitempty"`
+	Requester     string    `json:"requester"`
+	CreatedAt     time.Time `json:"created_at"`
+	Signature     []byte    `json:"signature,omitempty"`
+}
+
+// signedData returns canonical representation of the request without signature
+func (request *ApprovalRequest) signedData() ([]byte, error) {
+	unsigned := *request
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// ApprovalStore stores approval policy, pending approval requests and audit records of approved ones in keystore
+// backend
+type ApprovalStore interface {
+	SaveApprovalRequest(request *ApprovalRequest) error
+	ListApprovalRequests() ([]*ApprovalRequest, error)
+	RemoveApprovalRequest(id string) error
+	// GetApprovalPolicy returns policy of keystore or ErrApprovalPolicyNotConfigured
+	GetApprovalPolicy() (*ApprovalPolicy, error)
+	// InitApprovalPolicy saves policy if keystore doesn't have it yet, otherwise returns ErrApprovalPolicyExists
+	InitApprovalPolicy(policy *ApprovalPolicy) error
+	SaveApprovalAuditRecord(record *ApprovalAuditRecord) error
+	ListApprovalAuditRecords() ([]*ApprovalAuditRecord, error)
+}
+
+// ApprovalPolicy is stored in keystore and makes approval of key destruction and rotation by two operators mandatory.
+// Trusted operators and TTL of requests are pinned in keystore, so they can't be changed with options of acra-keys
+type ApprovalPolicy struct {
+	Operators  Operators     `json:"operators"`
+	RequestTTL time.Duration `json:"request_ttl"`
+}
+
+// NewApprovalPolicy returns policy with trusted operators from directory like LoadOperators. Not positive requestTTL
+// is replaced with DefaultApprovalRequestTTL
+func NewApprovalPolicy(operatorsDir string, requestTTL time.Duration) (*ApprovalPolicy, error) {
+	operators, err := LoadOperators(operatorsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(operators) < 2 {
+		return nil, ErrNotEnoughOperators
+	}
+	if requestTTL <= 0 {
+		requestTTL = DefaultApprovalRequestTTL
+	}
+	return &ApprovalPolicy{Operators: operators, RequestTTL: requestTTL}, nil
+}
+
+// Approve checks that request isn't expired at the moment now and is confirmed by another trusted operator. It returns
+// audit record with signatures of both operators which should be saved before execution of the operation
+func (policy *ApprovalPolicy) Approve(request *ApprovalRequest, approver *Operator, now time.Time) (*ApprovalAuditRecord, error) {
+	if now.Sub(request.CreatedAt) > policy.RequestTTL {
+		return nil, ErrApprovalRequestExpired
+	}
+	signature, err := policy.Operators.VerifyApproval(request, approver)
+	if err != nil {
+		return nil, err
+	}
+	return &ApprovalAuditRecord{
+		Request:           *request,
+		Approver:          approver.Name,
+		ApproverSignature: signature,
+		ApprovedAt:        now.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// CheckApprovalPolicy returns ErrApprovalRequired if keyStore has approval policy, so key destruction and rotation
+// should be requested and approved by two operators instead of direct execution
+func CheckApprovalPolicy(keyStore interface{}) error {
+	approvalStore, ok := keyStore.(ApprovalStore)
+	if !ok {
+		return nil
+	}
+	_, err := approvalStore.GetApprovalPolicy()
+	switch err {
+	case nil:
+		return ErrApprovalRequired
+	case ErrApprovalPolicyNotConfigured:
+		return nil
+	}
+	return err
+}
+
+// ApprovalAuditRecord is the evidence of approved operation. It contains request with signature of requester and
+// signature of approver over the request
+type ApprovalAuditRecord struct {
+	Request           ApprovalRequest `json:"request"`
+	Approver          string          `json:"approver"`
+	ApproverSignature []byte          `json:"approver_signature"`
+	ApprovedAt        time.Time       `json:"approved_at"`
+}
+
+// Verify checks signatures of requester and approver with trusted public keys of operators
+func (record *ApprovalAuditRecord) Verify(operators Operators) error {
+	requesterKey, ok := operators[record.Request.Requester]
+	if !ok {
+		return ErrUnknownOperator
+	}
+	approverKey, ok := operators[record.Approver]
+	if !ok {
+		return ErrUnknownOperator
+	}
+	data, err := record.Request.signedData()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(requesterKey, data, record.Request.Signature) ||
+		!ed25519.Verify(approverKey, append(data, record.Request.Signature...), record.ApproverSignature) {
+		return ErrInvalidApprovalSignature
+	}
+	return nil
+}
+
+// GetApprovalRequest returns pending request by ID or ErrApprovalRequestNotFound
+func GetApprovalRequest(store ApprovalStore, id string) (*ApprovalRequest, error) {
+	requests, err := store.ListApprovalRequests()
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		if request.ID == id {
+			return request, nil
+		}
+	}
+	return nil, ErrApprovalRequestNotFound
+}
+
+// Operator signs approval requests with Ed25519 private key. Name is taken from trusted operators by public key
+type Operator struct {
+	Name       string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Operators are trusted public keys of operators by their names
+type Operators map[string]ed25519.PublicKey
+
+// LoadOperators reads trusted public keys of operators from PEM files <name>.pub in the directory
+func LoadOperators(directory string) (Operators, error) {
+	paths, err := filepath.Glob(filepath.Join(directory, "*"+operatorPublicKeyExtension))
+	if err != nil {
+		return nil, err
+	}
+	operators := make(Operators, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := parseOperatorPublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		operators[strings.TrimSuffix(filepath.Base(path), operatorPublicKeyExtension)] = publicKey
+	}
+	return operators, nil
+}
+
+func parseOperatorPublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidOperatorKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrInvalidOperatorKey
+	}
+	return publicKey, nil
+}
+
+// LoadOperator reads PEM encoded PKCS #8 private key of operator and returns operator with the name of the
+// matching trusted public key
+func (operators Operators) LoadOperator(path string) (*Operator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidOperatorKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidOperatorKey
+	}
+	name, err := operators.nameByKey(privateKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return &Operator{Name: name, PrivateKey: privateKey}, nil
+}
+
+func (operators Operators) nameByKey(publicKey ed25519.PublicKey) (string, error) {
+	for name, trustedKey := range operators {
+		if bytes.Equal(trustedKey, publicKey) {
+			return name, nil
+		}
+	}
+	return "", ErrUnknownOperator
+}
+
+// Sign assigns new ID, requester and creation time to the request with described operation and signs it by the
+// operator who requested it
+func (request *ApprovalRequest) Sign(requester *Operator) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	request.ID = hex.EncodeToString(id)
+	request.Requester = requester.Name
+	request.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	data, err := request.signedData()
+	if err != nil {
+		return err
+	}
+	request.Signature = ed25519.Sign(requester.PrivateKey, data)
+	return nil
+}
+
+// VerifyApproval checks that the request is signed by trusted requester and the approver is another trusted operator.
+// It returns signature of the approver over the request which confirms the operation
+func (operators Operators) VerifyApproval(request *ApprovalRequest, approver *Operator) ([]byte, error) {
+	requesterKey, ok := operators[request.Requester]
+	if !ok {
+		return nil, ErrUnknownOperator
+	}
+	if _, err := operators.nameByKey(approver.PrivateKey.Public().(ed25519.PublicKey)); err != nil {
+		return nil, err
+	}
+	if approver.Name == request.Requester || bytes.Equal(requesterKey, approver.PrivateKey.Public().(ed25519.PublicKey)) {
+		return nil, ErrSameOperator
+	}
+	data, err := request.signedData()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(requesterKey, data, request.Signature) {
+		return nil, ErrInvalidApprovalSignature
+	}
+	return ed25519.Sign(approver.PrivateKey, append(data, request.Signature...)), nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeOperatorKeys saves private key of operator into keysDir and public key into operatorsDir
+func writeOperatorKeys(t *testing.T, name, keysDir, operatorsDir string) string {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateData, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicData, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(keysDir, name+".key")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateData}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if operatorsDir != "" {
+		if err := os.WriteFile(filepath.Join(operatorsDir, name+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicData}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return privatePath
+}
+
+func TestApprovalRequestSignatures(t *testing.T) {
+	keysDir := t.TempDir()
+	operatorsDir := t.TempDir()
+	aliceKey := writeOperatorKeys(t, "alice", keysDir, operatorsDir)
+	bobKey := writeOperatorKeys(t, "bob", keysDir, operatorsDir)
+	malloryKey := writeOperatorKeys(t, "mallory", keysDir, "")
+
+	operators, err := LoadOperators(operatorsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operators) != 2 {
+		t.Fatalf("Expected 2 operators, took %d", len(operators))
+	}
+	alice, err := operators.LoadOperator(aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Name != "alice" {
+		t.Fatalf("Expected alice, took %s", alice.Name)
+	}
+	bob, err := operators.LoadOperator(bobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := operators.LoadOperator(malloryKey); err != ErrUnknownOperator {
+		t.Fatalf("Expected ErrUnknownOperator, took %v", err)
+	}
+
+	request := &ApprovalRequest{Operation: ApprovalOperationDestroy, KeyKind: KeyStorageKeypair, ClientID: "client", Index: 1}
+	if err := request.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	if request.ID == "" || request.Requester != "alice" {
+		t.Fatalf("Signed request should have ID and requester, took %+v", request)
+	}
+
+	if _, err := operators.VerifyApproval(request, alice); err != ErrSameOperator {
+		t.Fatalf("Expected ErrSameOperator, took %v", err)
+	}
+	approval, err := operators.VerifyApproval(request, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approval) != ed25519.SignatureSize {
+		t.Fatal("Expected signature of approver")
+	}
+
+	// changed arguments of the operation invalidate signature of requester
+	tampered := *request
+	tampered.ClientID = "other"
+	if _, err := operators.VerifyApproval(&tampered, bob); err != ErrInvalidApprovalSignature {
+		t.Fatalf("Expected ErrInvalidApprovalSignature, took %v", err)
+	}
+	// request can't be attributed to another operator
+	tampered = *request
+	tampered.Requester = "bob"
+	if _, err := operators.VerifyApproval(&tampered, alice); err != ErrInvalidApprovalSignature {
+		t.Fatalf("Expected ErrInvalidApprovalSignature, took %v", err)
+	}
+}
+
+func TestApprovalPolicy(t *testing.T) {
+	keysDir := t.TempDir()
+	operatorsDir := t.TempDir()
+	aliceKey := writeOperatorKeys(t, "alice", keysDir, operatorsDir)
+	if _, err := NewApprovalPolicy(operatorsDir, 0); err != ErrNotEnoughOperators {
+		t.Fatalf("Expected ErrNotEnoughOperators, took %v", err)
+	}
+	bobKey := writeOperatorKeys(t, "bob", keysDir, operatorsDir)
+	policy, err := NewApprovalPolicy(operatorsDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.RequestTTL != DefaultApprovalRequestTTL {
+		t.Fatalf("Expected default TTL, took %s", policy.RequestTTL)
+	}
+	alice, err := policy.Operators.LoadOperator(aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := policy.Operators.LoadOperator(bobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &ApprovalRequest{Operation: ApprovalOperationDestroy, KeyKind: KeySymmetric, ClientID: "client"}
+	if err := request.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	expiredAt := request.CreatedAt.Add(policy.RequestTTL + time.Second)
+	if _, err := policy.Approve(request, bob, expiredAt); err != ErrApprovalRequestExpired {
+		t.Fatalf("Expected ErrApprovalRequestExpired, took %v", err)
+	}
+	record, err := policy.Approve(request, bob, request.CreatedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Approver != "bob" || record.Request.Requester != "alice" {
+		t.Fatalf("Unexpected audit record %+v", record)
+	}
+	if err := record.Verify(policy.Operators); err != nil {
+		t.Fatal(err)
+	}
+	// audit record can't be attributed to another approver
+	tampered := *record
+	tampered.Approver = "alice"
+	if err := tampered.Verify(policy.Operators); err != ErrInvalidApprovalSignature {
+		t.Fatalf("Expected ErrInvalidApprovalSignature, took %v", err)
+	}
+	tampered = *record
+	tampered.Request.Index = 2
+	if err := tampered.Verify(policy.Operators); err != ErrInvalidApprovalSignature {
+		t.Fatalf("Expected ErrInvalidApprovalSignature, took %v", err)
+	}
+}
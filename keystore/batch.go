@@ -0,0 +1,135 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"sync"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+// DecryptionKeysBatch fetches and unwraps all rotated decryption keys of clientID from keystore with one call and
+// shares them between decryption of several containers, for example, all columns of one data row. Callers receive
+// copies of keys and zeroize them as usual, cached keys are zeroized by Close.
+// Nil batch passes all calls to keystore.
+type DecryptionKeysBatch struct {
+	mutex         sync.Mutex
+	symmetricKeys map[string][][]byte
+	privateKeys   map[string][]*keys.PrivateKey
+	// errors of keystore are cached too, so missing keys don't cause keystore hit for every container
+	keystoreErrors map[string]error
+}
+
+// NewDecryptionKeysBatch returns new empty DecryptionKeysBatch. Maps are allocated on first use, so batches of rows
+// without encrypted data are cheap
+func NewDecryptionKeysBatch() *DecryptionKeysBatch {
+	return &DecryptionKeysBatch{}
+}
+
+// onKeystoreError saves error of keystore, so next calls with the same key don't access keystore
+func (batch *DecryptionKeysBatch) onKeystoreError(errorKey string, err error) {
+	if batch.keystoreErrors == nil {
+		batch.keystoreErrors = make(map[string]error)
+	}
+	batch.keystoreErrors[errorKey] = err
+}
+
+// GetClientIDSymmetricKeys returns all rotated symmetric keys of clientID. Keys are fetched from keystore only once
+// per batch
+func (batch *DecryptionKeysBatch) GetClientIDSymmetricKeys(keystore SymmetricEncryptionKeyStore, id []byte) ([][]byte, error) {
+	if batch == nil {
+		return keystore.GetClientIDSymmetricKeys(id)
+	}
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	cached, ok := batch.symmetricKeys[string(id)]
+	if !ok {
+		errorKey := "symmetric:" + string(id)
+		if err, failed := batch.keystoreErrors[errorKey]; failed {
+			return nil, err
+		}
+		var err error
+		cached, err = keystore.GetClientIDSymmetricKeys(id)
+		if err != nil {
+			batch.onKeystoreError(errorKey, err)
+			return nil, err
+		}
+		if batch.symmetricKeys == nil {
+			batch.symmetricKeys = make(map[string][][]byte)
+		}
+		batch.symmetricKeys[string(id)] = cached
+	}
+	result := make([][]byte, len(cached))
+	for i, key := range cached {
+		result[i] = append([]byte(nil), key...)
+	}
+	return result, nil
+}
+
+// GetServerDecryptionPrivateKeys returns all rotated private keys of clientID used to decrypt AcraStructs. Keys are
+// fetched from keystore only once per batch
+func (batch *DecryptionKeysBatch) GetServerDecryptionPrivateKeys(keystore PrivateKeyStore, id []byte) ([]*keys.PrivateKey, error) {
+	if batch == nil {
+		return keystore.GetServerDecryptionPrivateKeys(id)
+	}
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	cached, ok := batch.privateKeys[string(id)]
+	if !ok {
+		errorKey := "private:" + string(id)
+		if err, failed := batch.keystoreErrors[errorKey]; failed {
+			return nil, err
+		}
+		var err error
+		cached, err = keystore.GetServerDecryptionPrivateKeys(id)
+		if err != nil {
+			batch.onKeystoreError(errorKey, err)
+			return nil, err
+		}
+		if batch.privateKeys == nil {
+			batch.privateKeys = make(map[string][]*keys.PrivateKey)
+		}
+		batch.privateKeys[string(id)] = cached
+	}
+	result := make([]*keys.PrivateKey, len(cached))
+	for i, key := range cached {
+		result[i] = &keys.PrivateKey{Value: append([]byte(nil), key.Value...)}
+	}
+	return result, nil
+}
+
+// Close zeroizes all cached keys. Batch may be used after that and fetches keys again
+func (batch *DecryptionKeysBatch) Close() {
+	if batch == nil {
+		return
+	}
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	for id, cached := range batch.symmetricKeys {
+		utils.ZeroizeSymmetricKeys(cached)
+		delete(batch.symmetricKeys, id)
+	}
+	for id, cached := range batch.privateKeys {
+		utils.ZeroizePrivateKeys(cached)
+		delete(batch.privateKeys, id)
+	}
+	for key := range batch.keystoreErrors {
+		delete(batch.keystoreErrors, key)
+	}
+}
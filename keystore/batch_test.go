@@ -0,0 +1,103 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+var errTestKeyNotFound = errors.New("key not found")
+
+// countingKeyStore returns keys for "client" and counts calls
+type countingKeyStore struct {
+	symmetricCalls int
+	privateCalls   int
+}
+
+func (store *countingKeyStore) GetClientIDSymmetricKeys(id []byte) ([][]byte, error) {
+	store.symmetricCalls++
+	if string(id) != "client" {
+		return nil, errTestKeyNotFound
+	}
+	return [][]byte{[]byte("new key"), []byte("old key")}, nil
+}
+
+func (store *countingKeyStore) GetClientIDSymmetricKey(id []byte) ([]byte, error) {
+	return nil, errTestKeyNotFound
+}
+
+func (store *countingKeyStore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	return nil, errTestKeyNotFound
+}
+
+func (store *countingKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	store.privateCalls++
+	if string(id) != "client" {
+		return nil, errTestKeyNotFound
+	}
+	return []*keys.PrivateKey{{Value: []byte("private key")}}, nil
+}
+
+func TestDecryptionKeysBatch(t *testing.T) {
+	store := &countingKeyStore{}
+	batch := NewDecryptionKeysBatch()
+	for i := 0; i < 3; i++ {
+		symmetricKeys, err := batch.GetClientIDSymmetricKeys(store, []byte("client"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(symmetricKeys) != 2 || !bytes.Equal(symmetricKeys[1], []byte("old key")) {
+			t.Fatalf("Unexpected symmetric keys %q", symmetricKeys)
+		}
+		// caller zeroizes own copy of keys, it shouldn't affect next calls
+		symmetricKeys[0][0] = 0
+
+		privateKeys, err := batch.GetServerDecryptionPrivateKeys(store, []byte("client"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(privateKeys) != 1 || !bytes.Equal(privateKeys[0].Value, []byte("private key")) {
+			t.Fatalf("Unexpected private keys %v", privateKeys)
+		}
+		privateKeys[0].Value[0] = 0
+
+		if _, err := batch.GetClientIDSymmetricKeys(store, []byte("unknown")); err != errTestKeyNotFound {
+			t.Fatalf("Expected error of keystore, took %v", err)
+		}
+		if _, err := batch.GetServerDecryptionPrivateKeys(store, []byte("unknown")); err != errTestKeyNotFound {
+			t.Fatalf("Expected error of keystore, took %v", err)
+		}
+	}
+	// one successful and one failed call for each type of keys
+	if store.symmetricCalls != 2 || store.privateCalls != 2 {
+		t.Fatalf("Expected 2 calls of keystore, took %d and %d", store.symmetricCalls, store.privateCalls)
+	}
+
+	cached := batch.symmetricKeys["client"]
+	batch.Close()
+	if !bytes.Equal(cached[0], make([]byte, len(cached[0]))) {
+		t.Fatal("Cached keys weren't zeroized")
+	}
+	if _, err := batch.GetClientIDSymmetricKeys(store, []byte("client")); err != nil || store.symmetricCalls != 3 {
+		t.Fatal("Expected new call of keystore after Close")
+	}
+}
+
+func TestNilDecryptionKeysBatch(t *testing.T) {
+	store := &countingKeyStore{}
+	var batch *DecryptionKeysBatch
+	for i := 0; i < 2; i++ {
+		if _, err := batch.GetClientIDSymmetricKeys(store, []byte("client")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := batch.GetServerDecryptionPrivateKeys(store, []byte("client")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.symmetricCalls != 2 || store.privateCalls != 2 {
+		t.Fatal("Nil batch should pass all calls to keystore")
+	}
+	batch.Close()
+}
@@ -47,3 +47,18 @@ type Cache interface {
 	Get(keyID string) ([]byte, bool)
 	Clear()
 }
+
+// CacheStats describes usage of keystore cache
+type CacheStats struct {
+	// Size is number of cached values
+	Size int `json:"size"`
+	// Capacity is max number of cached values, InfiniteCacheSize if unlimited
+	Capacity int   `json:"capacity"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+// CacheStatsProvider is implemented by caches and keystores which report usage of their cache
+type CacheStatsProvider interface {
+	CacheStats() CacheStats
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// Names of files in private keys directory with approval policy, pending approval requests and audit records of
+// approved ones. They start with "." (dot) to not intersect with names of key files
+const (
+	approvalPolicyFilename   = ".approval_policy"
+	approvalRequestsFilename = ".approval_requests"
+	approvalAuditFilename    = ".approval_audit"
+)
+
+// readApprovalRequests returns pending approval requests by ID. Keystore without requests returns empty map
+func (store *KeyStore) readApprovalRequests() (map[string]*keystore.ApprovalRequest, error) {
+	requests := make(map[string]*keystore.ApprovalRequest)
+	data, err := store.fs.ReadFile(store.GetPrivateKeyFilePath(approvalRequestsFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return requests, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (store *KeyStore) writeApprovalRequests(requests map[string]*keystore.ApprovalRequest) error {
+	data, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+	if err := store.fs.MkdirAll(store.privateKeyDirectory, keyDirMode); err != nil {
+		return err
+	}
+	return store.fs.WriteFile(store.GetPrivateKeyFilePath(approvalRequestsFilename), data, PrivateFileMode)
+}
+
+// SaveApprovalRequest adds pending approval request
+func (store *KeyStore) SaveApprovalRequest(request *keystore.ApprovalRequest) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	requests, err := store.readApprovalRequests()
+	if err != nil {
+		return err
+	}
+	requests[request.ID] = request
+	return store.writeApprovalRequests(requests)
+}
+
+// ListApprovalRequests returns pending approval requests ordered by creation time
+func (store *KeyStore) ListApprovalRequests() ([]*keystore.ApprovalRequest, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	requests, err := store.readApprovalRequests()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*keystore.ApprovalRequest, 0, len(requests))
+	for _, request := range requests {
+		result = append(result, request)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// RemoveApprovalRequest removes executed or rejected approval request
+func (store *KeyStore) RemoveApprovalRequest(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	requests, err := store.readApprovalRequests()
+	if err != nil {
+		return err
+	}
+	if _, ok := requests[id]; !ok {
+		return keystore.ErrApprovalRequestNotFound
+	}
+	delete(requests, id)
+	return store.writeApprovalRequests(requests)
+}
+
+// GetApprovalPolicy returns approval policy of keystore or keystore.ErrApprovalPolicyNotConfigured
+func (store *KeyStore) GetApprovalPolicy() (*keystore.ApprovalPolicy, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	data, err := store.fs.ReadFile(store.GetPrivateKeyFilePath(approvalPolicyFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, keystore.ErrApprovalPolicyNotConfigured
+		}
+		return nil, err
+	}
+	policy := &keystore.ApprovalPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// InitApprovalPolicy saves approval policy if keystore doesn't have it yet. Saved policy can't be changed
+func (store *KeyStore) InitApprovalPolicy(policy *keystore.ApprovalPolicy) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	path := store.GetPrivateKeyFilePath(approvalPolicyFilename)
+	exists, err := store.fs.Exists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return keystore.ErrApprovalPolicyExists
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	if err := store.fs.MkdirAll(store.privateKeyDirectory, keyDirMode); err != nil {
+		return err
+	}
+	return store.fs.WriteFile(path, data, PrivateFileMode)
+}
+
+func (store *KeyStore) readApprovalAuditRecords() ([]*keystore.ApprovalAuditRecord, error) {
+	var records []*keystore.ApprovalAuditRecord
+	data, err := store.fs.ReadFile(store.GetPrivateKeyFilePath(approvalAuditFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SaveApprovalAuditRecord appends audit record of approved request
+func (store *KeyStore) SaveApprovalAuditRecord(record *keystore.ApprovalAuditRecord) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	records, err := store.readApprovalAuditRecords()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(append(records, record))
+	if err != nil {
+		return err
+	}
+	if err := store.fs.MkdirAll(store.privateKeyDirectory, keyDirMode); err != nil {
+		return err
+	}
+	return store.fs.WriteFile(store.GetPrivateKeyFilePath(approvalAuditFilename), data, PrivateFileMode)
+}
+
+// ListApprovalAuditRecords returns audit records of approved requests in order of approval
+func (store *KeyStore) ListApprovalAuditRecords() ([]*keystore.ApprovalAuditRecord, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.readApprovalAuditRecords()
+}
@@ -59,6 +59,10 @@ func ReadDir(storage Storage, path string) ([]string, error) {
 		return nil, err
 	}
 	for _, info := range infos {
+		// soft deleted keys aren't backed up
+		if info.IsDir() && isTombstonesDir(info.Name()) {
+			continue
+		}
 		if info.IsDir() {
 			paths, err := ReadDir(storage, filepath.Join(path, info.Name()))
 			if err != nil {
@@ -79,6 +83,10 @@ func isPrivate(fname string) bool {
 	if fname == PoisonKeyFilename {
 		return true
 	}
+	// tags and usage of keys are stored in plaintext
+	if isMetadataFile(fname) {
+		return false
+	}
 
 	if isPublic(fname) {
 		return false
@@ -352,6 +360,15 @@ func (store *KeyBackuper) Import(backup *keystore.KeysBackup) ([]keystore.KeyDes
 
 	descriptions := make([]keystore.KeyDescription, 0, len(keys))
 	for _, key := range keys {
+		if isMetadataFile(key.Name) {
+			if err := store.storage.MkdirAll(store.privateFolder, keyDirMode); err != nil {
+				return nil, err
+			}
+			if err := store.storage.WriteFile(filepath.Join(store.privateFolder, key.Name), key.Content, PrivateFileMode); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		isPrivateKey := isPrivate(key.Name)
 		filePermission := publicFileMode
 		fullName := filepath.Join(store.privateFolder, key.Name)
@@ -172,6 +172,47 @@ func EnumerateExportedKeysByClass(enumerator KeyExportEnumerator, classifier Key
 	return exportedKeys, nil
 }
 
+// ExportedKeyByPurpose returns current key with the purpose for export. Client ID is ignored for keys without it.
+// It returns keystore.ErrKeysNotFound if the keystore doesn't have the key.
+func (store *KeyStore) ExportedKeyByPurpose(purpose keystore.KeyPurpose, clientID []byte) (*ExportedKey, error) {
+	var paths []string
+	switch purpose {
+	case keystore.PurposeStorageClientKeyPair:
+		filename := GetServerDecryptionKeyFilename(clientID)
+		paths = []string{store.GetPrivateKeyFilePath(filename), store.GetPublicKeyFilePath(filename + ".pub")}
+	case keystore.PurposeStorageClientSymmetricKey:
+		paths = []string{store.GetPrivateKeyFilePath(getClientIDSymmetricKeyName(clientID))}
+	case keystore.PurposeSearchHMAC:
+		paths = []string{store.GetPrivateKeyFilePath(getHmacKeyFilename(clientID))}
+	case keystore.PurposePoisonRecordKeyPair:
+		paths = []string{store.GetPrivateKeyFilePath(PoisonKeyFilename), store.GetPublicKeyFilePath(poisonKeyFilenamePublic)}
+	case keystore.PurposePoisonRecordSymmetricKey:
+		paths = []string{store.GetPrivateKeyFilePath(getSymmetricKeyName(PoisonKeyFilename))}
+	case keystore.PurposeAuditLog:
+		paths = []string{store.GetPrivateKeyFilePath(SecureLogKeyFilename)}
+	default:
+		return nil, ErrUnrecognizedKeyPurpose
+	}
+	var exportedKey *ExportedKey
+	for _, path := range paths {
+		exists, err := store.fs.Exists(path)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, keystore.ErrKeysNotFound
+		}
+		// classifier assigns the same key context as used by EnumerateExportedKeys
+		key := defaultClassifier.ClassifyExportedKey(path)
+		if exportedKey == nil {
+			exportedKey = key
+		} else {
+			exportedKey.addPathFrom(key)
+		}
+	}
+	return exportedKey, nil
+}
+
 // EnumerateExportedKeyPaths returns a list of key paths that can be exported from this keystore.
 func (store *KeyStore) EnumerateExportedKeyPaths() ([]string, error) {
 	paths := make([]string, 0)
@@ -189,8 +230,10 @@ func (store *KeyStore) EnumerateExportedKeyPaths() ([]string, error) {
 		for _, file := range files {
 			path := filepath.Join(directories[i], file.Name())
 			if file.IsDir() {
-				directories = append(directories, path)
-			} else {
+				if !isTombstonesDir(file.Name()) {
+					directories = append(directories, path)
+				}
+			} else if !isMetadataFile(file.Name()) {
 				paths = append(paths, path)
 			}
 		}
@@ -23,3 +23,11 @@ func getSymmetricKeyName(id string) string {
 func getClientIDSymmetricKeyName(id []byte) string {
 	return getSymmetricKeyName(GetServerDecryptionKeyFilename(id))
 }
+
+func getPseudonymizationSymmetricKeyName(id []byte) string {
+	return getSymmetricKeyName(string(id) + "_pseudonym")
+}
+
+func getReIdentificationKeyFilename(id []byte) string {
+	return string(id) + "_re_identification"
+}
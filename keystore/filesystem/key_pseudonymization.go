@@ -0,0 +1,81 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+// GeneratePseudonymizationSymmetricKey generates key of PRF used to derive pseudonyms of clientID.
+// New key changes pseudonyms of all values, so it shouldn't be rotated for columns with existing pseudonyms
+func (store *KeyStore) GeneratePseudonymizationSymmetricKey(clientID []byte) error {
+	if !keystore.ValidateID(clientID) {
+		return keystore.ErrInvalidClientID
+	}
+	keyName := getPseudonymizationSymmetricKeyName(clientID)
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposePseudonymization, clientID)
+	return store.generateAndSaveSymmetricKey(store.GetPrivateKeyFilePath(keyName), keyContext)
+}
+
+// GetPseudonymizationSymmetricKey returns key of PRF used to derive pseudonyms of clientID
+func (store *KeyStore) GetPseudonymizationSymmetricKey(clientID []byte) ([]byte, error) {
+	keyName := getPseudonymizationSymmetricKeyName(clientID)
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposePseudonymization, clientID)
+	key, err := store.getLatestSymmetricKey(keyName, keyContext)
+	if err == nil {
+		store.usageTracker.Track(keyName, keystore.KeyUsageEncryption)
+	}
+	return key, err
+}
+
+// GenerateReIdentificationKeyPair generates keypair used to encrypt source values of pseudonyms of clientID.
+// Private key is written to the private keys folder and should be deployed only to AcraTranslator
+func (store *KeyStore) GenerateReIdentificationKeyPair(clientID []byte) error {
+	if !keystore.ValidateID(clientID) {
+		return keystore.ErrInvalidClientID
+	}
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeReIdentificationKeyPair, clientID)
+	_, err := store.generateKeyPair(getReIdentificationKeyFilename(clientID), keyContext)
+	return err
+}
+
+// GetReIdentificationPublicKey returns public key used to encrypt source values of pseudonyms of clientID
+func (store *KeyStore) GetReIdentificationPublicKey(clientID []byte) (*keys.PublicKey, error) {
+	filename := getReIdentificationKeyFilename(clientID)
+	publicKey, err := store.getPublicKeyByFilename(store.GetPublicKeyFilePath(getPublicKeyFilename([]byte(filename))))
+	if err == nil {
+		store.usageTracker.Track(filename, keystore.KeyUsageEncryption)
+	}
+	return publicKey, err
+}
+
+// GetReIdentificationPrivateKeys returns current and rotated private keys used to decrypt source values of
+// pseudonyms of clientID
+func (store *KeyStore) GetReIdentificationPrivateKeys(clientID []byte) ([]*keys.PrivateKey, error) {
+	filename := getReIdentificationKeyFilename(clientID)
+	filenames, err := store.GetHistoricalPrivateKeyFilenames(filename)
+	if err != nil {
+		return nil, err
+	}
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeReIdentificationKeyPair, clientID)
+	privateKeys, err := store.getPrivateKeysByFilenames(filenames, keyContext)
+	if err == nil {
+		store.usageTracker.Track(filename, keystore.KeyUsageDecryption)
+	}
+	return privateKeys, err
+}
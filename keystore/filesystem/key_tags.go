@@ -0,0 +1,90 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// keyTagsFilename is the name of the file in private keys directory with tags of keys. It starts with "." (dot) to
+// not intersect with names of key files
+const keyTagsFilename = ".key_tags"
+
+// readKeyTags returns tags of all keys by key ID. Keystore without tags returns empty map
+func (store *KeyStore) readKeyTags() (map[string]keystore.KeyTags, error) {
+	tags := make(map[string]keystore.KeyTags)
+	data, err := store.fs.ReadFile(store.GetPrivateKeyFilePath(keyTagsFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tags, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// GetKeyTags returns tags assigned to key ID, nil if there are no tags
+func (store *KeyStore) GetKeyTags(keyID string) (keystore.KeyTags, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	tags, err := store.readKeyTags()
+	if err != nil {
+		return nil, err
+	}
+	return tags[keyID], nil
+}
+
+// SetKeyTags replaces tags of key ID, empty tags remove them
+func (store *KeyStore) SetKeyTags(keyID string, keyTags keystore.KeyTags) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tags, err := store.readKeyTags()
+	if err != nil {
+		return err
+	}
+	if len(keyTags) == 0 {
+		delete(tags, keyID)
+	} else {
+		tags[keyID] = keyTags
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	if err := store.fs.MkdirAll(store.privateKeyDirectory, keyDirMode); err != nil {
+		return err
+	}
+	return store.fs.WriteFile(store.GetPrivateKeyFilePath(keyTagsFilename), data, PrivateFileMode)
+}
+
+// fillKeyTags sets tags of described keys from keystore metadata
+func (store *KeyStore) fillKeyTags(descriptions []keystore.KeyDescription) error {
+	tags, err := store.readKeyTags()
+	if err != nil {
+		return err
+	}
+	for i := range descriptions {
+		descriptions[i].Tags = tags[descriptions[i].KeyID]
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// keyTombstonesDirname is the name of the directory in private keys directory with soft deleted keys
+const keyTombstonesDirname = ".tombstones"
+
+const keyTombstoneExtension = ".json"
+
+// keyTombstoneFile is stored in tombstones directory and contains soft deleted key file as is
+type keyTombstoneFile struct {
+	Path      string      `json:"path"`
+	Mode      os.FileMode `json:"mode"`
+	DeletedAt time.Time   `json:"deleted_at"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Data      []byte      `json:"data"`
+}
+
+// isTombstonesDir returns true for directory with soft deleted keys which should be ignored as keys
+func isTombstonesDir(name string) bool {
+	return name == keyTombstonesDirname
+}
+
+// SetKeyDestructionMode changes behaviour of following Destroy* calls and resets list of affected key files
+func (store *KeyStore) SetKeyDestructionMode(mode keystore.KeyDestructionMode, gracePeriod time.Duration) {
+	store.destructionMode = mode
+	store.destructionGracePeriod = gracePeriod
+	store.affectedKeyFiles = nil
+}
+
+// AffectedKeyFiles returns key files destroyed after last SetKeyDestructionMode call or which would be destroyed in dry-run mode
+func (store *KeyStore) AffectedKeyFiles() []keystore.AffectedKeyFile {
+	return store.affectedKeyFiles
+}
+
+// removeKeyFile removes key file according to destruction mode. It's okay if the file is already removed (or never existed).
+func (store *KeyStore) removeKeyFile(path string) error {
+	affected := keystore.AffectedKeyFile{Path: path}
+	switch store.destructionMode {
+	case keystore.KeyDestructionDryRun:
+		exists, err := store.fs.Exists(path)
+		if err != nil || !exists {
+			return err
+		}
+	case keystore.KeyDestructionSoft:
+		id, err := store.moveToTombstone(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		affected.TombstoneID = id
+	default:
+		err := store.fs.Remove(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	store.affectedKeyFiles = append(store.affectedKeyFiles, affected)
+	return nil
+}
+
+func (store *KeyStore) getKeyTombstonePath(id string) string {
+	return filepath.Join(store.GetPrivateKeyFilePath(keyTombstonesDirname), id+keyTombstoneExtension)
+}
+
+// moveToTombstone saves key file into tombstones directory and removes it, returns ID of the tombstone
+func (store *KeyStore) moveToTombstone(path string) (string, error) {
+	info, err := store.fs.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	data, err := store.fs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer utils.ZeroizeBytes(data)
+	deletedAt := time.Now().UTC()
+	tombstone := keyTombstoneFile{
+		Path:      path,
+		Mode:      info.Mode().Perm(),
+		DeletedAt: deletedAt,
+		ExpiresAt: deletedAt.Add(store.destructionGracePeriod),
+		Data:      data,
+	}
+	serialized, err := json.Marshal(tombstone)
+	if err != nil {
+		return "", err
+	}
+	defer utils.ZeroizeBytes(serialized)
+	// timestamp orders tombstones and hash of the path distinguishes files deleted at the same time
+	pathHash := sha256.Sum256([]byte(path))
+	id := strconv.FormatInt(deletedAt.UnixNano(), 10) + "-" + hex.EncodeToString(pathHash[:4])
+	if err := store.fs.MkdirAll(store.GetPrivateKeyFilePath(keyTombstonesDirname), keyDirMode); err != nil {
+		return "", err
+	}
+	// tombstone is saved before removal, so key material isn't lost if removal fails
+	if err := store.fs.WriteFile(store.getKeyTombstonePath(id), serialized, PrivateFileMode); err != nil {
+		return "", err
+	}
+	if err := store.fs.Remove(path); err != nil {
+		return "", err
+	}
+	log.WithField("path", path).WithField("tombstone", id).WithField("expires_at", tombstone.ExpiresAt).Infoln("Soft deleted key file")
+	return id, nil
+}
+
+func (store *KeyStore) readKeyTombstone(id string) (*keyTombstoneFile, error) {
+	// ID provided by user shouldn't point outside of tombstones directory
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, keystore.ErrKeyTombstoneNotFound
+	}
+	data, err := store.fs.ReadFile(store.getKeyTombstonePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, keystore.ErrKeyTombstoneNotFound
+		}
+		return nil, err
+	}
+	defer utils.ZeroizeBytes(data)
+	tombstone := &keyTombstoneFile{}
+	if err := json.Unmarshal(data, tombstone); err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+// ListKeyTombstones returns soft deleted keys ordered by deletion time
+func (store *KeyStore) ListKeyTombstones() ([]keystore.KeyTombstone, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.listKeyTombstones()
+}
+
+func (store *KeyStore) listKeyTombstones() ([]keystore.KeyTombstone, error) {
+	files, err := store.fs.ReadDir(store.GetPrivateKeyFilePath(keyTombstonesDirname))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tombstones := make([]keystore.KeyTombstone, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), keyTombstoneExtension) {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), keyTombstoneExtension)
+		tombstone, err := store.readKeyTombstone(id)
+		if err != nil {
+			return nil, err
+		}
+		utils.ZeroizeBytes(tombstone.Data)
+		tombstones = append(tombstones, keystore.KeyTombstone{
+			ID:        id,
+			Path:      tombstone.Path,
+			DeletedAt: tombstone.DeletedAt,
+			ExpiresAt: tombstone.ExpiresAt,
+		})
+	}
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.Before(tombstones[j].DeletedAt)
+	})
+	return tombstones, nil
+}
+
+// RestoreKeyTombstone moves soft deleted key file back to its original path
+func (store *KeyStore) RestoreKeyTombstone(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tombstone, err := store.readKeyTombstone(id)
+	if err != nil {
+		return err
+	}
+	defer utils.ZeroizeBytes(tombstone.Data)
+	if (keystore.KeyTombstone{ExpiresAt: tombstone.ExpiresAt}).Expired(time.Now()) {
+		return keystore.ErrKeyTombstoneExpired
+	}
+	exists, err := store.fs.Exists(tombstone.Path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return keystore.ErrKeyTombstoneRestoreConflict
+	}
+	if err := store.fs.MkdirAll(filepath.Dir(tombstone.Path), keyDirMode); err != nil {
+		return err
+	}
+	if err := store.fs.WriteFile(tombstone.Path, tombstone.Data, tombstone.Mode); err != nil {
+		return err
+	}
+	// destruction leaves empty values of the key in cache
+	store.cache.Clear()
+	return store.fs.Remove(store.getKeyTombstonePath(id))
+}
+
+// PurgeExpiredKeyTombstones overwrites and removes tombstones expired at now. In dry-run mode they are only returned
+func (store *KeyStore) PurgeExpiredKeyTombstones(now time.Time) ([]keystore.KeyTombstone, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tombstones, err := store.listKeyTombstones()
+	if err != nil {
+		return nil, err
+	}
+	purged := make([]keystore.KeyTombstone, 0, len(tombstones))
+	for _, tombstone := range tombstones {
+		if !tombstone.Expired(now) {
+			continue
+		}
+		if store.destructionMode != keystore.KeyDestructionDryRun {
+			if err := store.shredFile(store.getKeyTombstonePath(tombstone.ID)); err != nil {
+				return purged, err
+			}
+		}
+		purged = append(purged, tombstone)
+	}
+	return purged, nil
+}
+
+// shredFile overwrites content of the file with zeros before removal
+func (store *KeyStore) shredFile(path string) error {
+	info, err := store.fs.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := store.fs.WriteFile(path, make([]byte, info.Size()), PrivateFileMode); err != nil {
+		return err
+	}
+	return store.fs.Remove(path)
+}
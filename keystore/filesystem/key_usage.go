@@ -0,0 +1,79 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package filesystem
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// keyUsageFilename is the name of the file in private keys directory with usage statistics of keys
+const keyUsageFilename = ".key_usage"
+
+// isMetadataFile returns true for files with metadata of keys which are stored next to keys
+func isMetadataFile(name string) bool {
+	return name == keyTagsFilename || name == keyUsageFilename || name == approvalRequestsFilename
+}
+
+// SetKeyUsageTracker sets tracker which counts operations with storage keys. It should be called before use of keystore
+func (store *KeyStore) SetKeyUsageTracker(tracker *keystore.KeyUsageTracker) {
+	store.usageTracker = tracker
+}
+
+func (store *KeyStore) readKeysUsage() (map[string]keystore.KeyUsage, error) {
+	usage := make(map[string]keystore.KeyUsage)
+	data, err := store.fs.ReadFile(store.GetPrivateKeyFilePath(keyUsageFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// GetKeysUsage returns usage statistics saved by services by key ID
+func (store *KeyStore) GetKeysUsage() (map[string]keystore.KeyUsage, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.readKeysUsage()
+}
+
+// AddKeysUsage merges usage into saved statistics
+func (store *KeyStore) AddKeysUsage(usage map[string]keystore.KeyUsage) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	saved, err := store.readKeysUsage()
+	if err != nil {
+		return err
+	}
+	for keyID, keyUsage := range usage {
+		saved[keyID] = saved[keyID].Merge(keyUsage)
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := store.fs.MkdirAll(store.privateKeyDirectory, keyDirMode); err != nil {
+		return err
+	}
+	return store.fs.WriteFile(store.GetPrivateKeyFilePath(keyUsageFilename), data, PrivateFileMode)
+}
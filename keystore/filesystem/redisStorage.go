@@ -17,7 +17,6 @@ limitations under the License.
 package filesystem
 
 import (
-	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -28,6 +27,8 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v7"
+
+	"github.com/cossacklabs/acra/utils/redisclient"
 )
 
 // RedisStorage provides a storage backend that keeps key in Redis.
@@ -36,18 +37,12 @@ type RedisStorage struct {
 }
 
 type redisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-// NewRedisStorage returns a new Redis backend.
-func NewRedisStorage(address string, password string, db int, tls *tls.Config) (Storage, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:      address,
-		Password:  password,
-		DB:        db,
-		TLSConfig: tls,
-	})
-	err := client.Ping().Err()
+// NewRedisStorage returns a new Redis backend connected to single node, Sentinel or Cluster described by options.
+func NewRedisStorage(options *redisclient.Options) (Storage, error) {
+	client, err := redisclient.Open(options)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +130,7 @@ func (r *redisStorage) Stat(path string) (os.FileInfo, error) {
 	}
 	// If a key does not exist at given path then it might be a directory
 	// if the path is a prefix of some existing key.
-	keys, _, err := r.client.Scan(0, path+"/*", defaultCount).Result()
+	keys, err := redisclient.ScanKeys(r.client, path+"/*", defaultCount)
 	if err != nil {
 		return nil, err
 	}
@@ -159,18 +154,9 @@ func (r *redisStorage) Exists(path string) (bool, error) {
 }
 
 func (r *redisStorage) ReadDir(path string) ([]os.FileInfo, error) {
-	keys := make([]string, 0)
-	var cursor uint64
-	for {
-		nextKeys, nextCursor, err := r.client.Scan(cursor, path+"/*", defaultCount).Result()
-		if err != nil {
-			return nil, err
-		}
-		cursor = nextCursor
-		keys = append(keys, nextKeys...)
-		if cursor == 0 {
-			break
-		}
+	keys, err := redisclient.ScanKeys(r.client, path+"/*", defaultCount)
+	if err != nil {
+		return nil, err
 	}
 	// We do not distinguish between empty directories and missing directories.
 	// However, keystore never creates empty directories so assume it's missing.
@@ -220,8 +206,7 @@ func (r *redisStorage) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (r *redisStorage) Rename(oldpath, newpath string) error {
-	_, err := r.client.Rename(oldpath, newpath).Result()
-	return err
+	return redisclient.Rename(r.client, oldpath, newpath)
 }
 
 const maxTempFileAttempts = 10
@@ -248,7 +233,7 @@ func (r *redisStorage) TempDir(pattern string, perm os.FileMode) (string, error)
 		if err != nil || n > 0 {
 			continue
 		}
-		keys, _, err := r.client.Scan(0, path+"/*", defaultCount).Result()
+		keys, err := redisclient.ScanKeys(r.client, path+"/*", defaultCount)
 		if err != nil || len(keys) > 0 {
 			continue
 		}
@@ -312,24 +297,15 @@ func (r *redisStorage) Remove(path string) error {
 }
 
 func (r *redisStorage) RemoveAll(path string) error {
-	keys := make([]string, 1)
-	keys[0] = path
 	// There might be no child elements at all, or there might be no key named "path".
 	// RemoveAll does not produce an error in these cases. In only ensures that neither
 	// "${path}" nor any "${path}/*" refers to anything anymore.
-	var cursor uint64
-	for {
-		nextKeys, nextCursor, err := r.client.Scan(cursor, path+"/*", defaultCount).Result()
-		if err != nil {
-			return err
-		}
-		cursor = nextCursor
-		keys = append(keys, nextKeys...)
-		if cursor == 0 {
-			break
-		}
+	keys, err := redisclient.ScanKeys(r.client, path+"/*", defaultCount)
+	if err != nil {
+		return err
 	}
-	_, err := r.client.Del(keys...).Result()
+	keys = append([]string{path}, keys...)
+	_, err = redisclient.Del(r.client, keys...)
 	if err != nil {
 		return err
 	}
@@ -33,7 +33,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -81,6 +80,11 @@ type KeyStore struct {
 	encryptor           keystore.KeyEncryptor
 	cacheEncryptor      keystore.KeyEncryptor
 	encryptorCtx        context.Context
+	usageTracker        *keystore.KeyUsageTracker
+
+	destructionMode        keystore.KeyDestructionMode
+	destructionGracePeriod time.Duration
+	affectedKeyFiles       []keystore.AffectedKeyFile
 }
 
 // NewFileSystemKeyStoreWithCacheSize represents keystore that reads keys from key folders, and stores them in cache.
@@ -105,6 +109,7 @@ type KeyStoreBuilder struct {
 	encryptor     keystore.KeyEncryptor
 	storage       Storage
 	cacheSize     int
+	cacheTTL      time.Duration
 }
 
 // NewCustomFilesystemKeyStore allows a custom-made KeyStore to be built.
@@ -148,6 +153,12 @@ func (b *KeyStoreBuilder) CacheSize(cacheSize int) *KeyStoreBuilder {
 	return b
 }
 
+// CacheTTL sets time after which cached keys are removed from cache. By default cached keys don't expire.
+func (b *KeyStoreBuilder) CacheTTL(ttl time.Duration) *KeyStoreBuilder {
+	b.cacheTTL = ttl
+	return b
+}
+
 var (
 	errNoPrivateKeyDir = errors.New("private key directory not specified")
 	errNoPublicKeyDir  = errors.New("public key directory not specified")
@@ -165,7 +176,7 @@ func (b *KeyStoreBuilder) Build() (*KeyStore, error) {
 	if b.encryptor == nil {
 		return nil, errNoEncryptor
 	}
-	return newFilesystemKeyStore(b.privateKeyDir, b.publicKeyDir, b.storage, b.encryptor, b.cacheSize)
+	return newFilesystemKeyStore(b.privateKeyDir, b.publicKeyDir, b.storage, b.encryptor, b.cacheSize, b.cacheTTL)
 }
 
 // IsKeyDirectory checks if the local directory contains a keystore v1.
@@ -203,20 +214,23 @@ func IsKeyDirectory(keyDirectory string) bool {
 func openKeyStorage() (Storage, error) {
 	redis := cmd.ParseRedisCLIParametersFromFlags(flag.CommandLine, "")
 	if redis.KeysConfigured() {
-		return NewRedisStorage(redis.HostPort, redis.Password, redis.DBKeys, nil)
+		redisOptions, err := redis.KeysOptions(flag.CommandLine)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStorage(redisOptions)
 	}
 	return &DummyStorage{}, nil
 }
 
-func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, storage Storage, encryptor keystore.KeyEncryptor, cacheSize int) (*KeyStore, error) {
+func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, storage Storage, encryptor keystore.KeyEncryptor, cacheSize int, cacheTTL time.Duration) (*KeyStore, error) {
 	fi, err := storage.Stat(privateKeyFolder)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 	if !os.IsNotExist(err) {
-		const expectedPermission = "-rwx------"
-		if runtime.GOOS == "linux" && fi.Mode().Perm().String() != expectedPermission {
-			log.Errorf("Keystore folder has an incorrect permissions %s, expected: %s", fi.Mode().Perm().String(), expectedPermission)
+		if err := utils.CheckPrivateFilePermissions(privateKeyFolder, fi, keyDirMode); err != nil {
+			log.WithError(err).Errorf("Keystore folder has an incorrect permissions %s, expected: %s", fi.Mode().Perm().String(), keyDirMode.String())
 			return nil, errors.New("keystore folder has an incorrect permissions")
 		}
 	}
@@ -231,16 +245,21 @@ func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, storage Sto
 		cacheEncryptor = dummyEncryptor{}
 		cache = keystore.NoCache{}
 	} else {
-		cache, err = lru.NewCacheKeystoreWrapper(cacheSize)
+		cache, err = lru.NewCacheKeystoreWrapperWithTTL(cacheSize, cacheTTL)
 		if err != nil {
 			return nil, err
 		}
 
+		// cached keys are encrypted with ephemeral key which lives only in memory of the process
 		cacheEncryptionKey, err := keystore.GenerateSymmetricKey()
 		if err != nil {
 			log.WithError(err).Errorln("Can't generate cache encryption key")
 			return nil, err
 		}
+		// keep ephemeral key out of swap, it's used as is by Secure Cell
+		if err := utils.LockMemory(cacheEncryptionKey); err != nil {
+			log.WithError(err).Warnln("Can't lock memory of cache encryption key, it may be swapped to disk")
+		}
 
 		cacheEncryptor, err = keystore.NewSCellKeyEncryptor(cacheEncryptionKey)
 		if err != nil {
@@ -515,8 +534,8 @@ func (store *KeyStore) loadPrivateKey(path string) (*keys.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	if runtime.GOOS == "linux" && fi.Mode().Perm() > PrivateFileMode {
-		log.Errorf("Private key file %v has incorrect permissions %s, expected: %s", path, fi.Mode().Perm().String(), PrivateFileMode.String())
+	if err := utils.CheckPrivateFilePermissions(path, fi, PrivateFileMode); err != nil {
+		log.WithError(err).Errorf("Private key file %v has incorrect permissions %s, expected: %s", path, fi.Mode().Perm().String(), PrivateFileMode.String())
 		return nil, fmt.Errorf("private key file %v has incorrect permissions", path)
 	}
 	// Strictly speaking, this is racy because the file we were statting
@@ -600,7 +619,11 @@ func (store *KeyStore) GetClientIDEncryptionPublicKey(clientID []byte) (*keys.Pu
 		getPublicKeyFilename(
 			// use correct suffix as type of key
 			[]byte(GetServerDecryptionKeyFilename(clientID))))
-	return store.getPublicKeyByFilename(fname)
+	publicKey, err := store.getPublicKeyByFilename(fname)
+	if err == nil {
+		store.usageTracker.Track(GetServerDecryptionKeyFilename(clientID), keystore.KeyUsageEncryption)
+	}
+	return publicKey, err
 }
 
 // GetPeerPublicKey returns public key for this clientID, gets it from cache or reads from fs.
@@ -653,7 +676,11 @@ func (store *KeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.Privat
 	}
 
 	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientPrivateKey, id)
-	return store.getPrivateKeysByFilenames(filenames, keyContext)
+	privateKeys, err := store.getPrivateKeysByFilenames(filenames, keyContext)
+	if err == nil {
+		store.usageTracker.Track(GetServerDecryptionKeyFilename(id), keystore.KeyUsageDecryption)
+	}
+	return privateKeys, err
 }
 
 // GenerateConnectorKeys generates AcraConnector transport EC keypair using clientID as part of key name.
@@ -739,6 +766,9 @@ func (store *KeyStore) ListKeys() ([]keystore.KeyDescription, error) {
 		keys = append(keys, publicKeys...)
 	}
 
+	if err := store.fillKeyTags(keys); err != nil {
+		return nil, err
+	}
 	return keys, nil
 }
 
@@ -757,6 +787,9 @@ func (store *KeyStore) ListRotatedKeys() ([]keystore.KeyDescription, error) {
 		keys = append(keys, publicKeys...)
 	}
 
+	if err := store.fillKeyTags(keys); err != nil {
+		return nil, err
+	}
 	return keys, nil
 }
 
@@ -779,7 +812,7 @@ func (store *KeyStore) describeDir(dirName string) ([]keystore.KeyDescription, e
 			continue
 		}
 
-		if strings.HasSuffix(fileInfo.Name(), "old") {
+		if strings.HasSuffix(fileInfo.Name(), "old") || isMetadataFile(fileInfo.Name()) || isTombstonesDir(fileInfo.Name()) {
 			continue
 		}
 
@@ -881,6 +914,14 @@ func (store *KeyStore) Reset() {
 	store.cache.Clear()
 }
 
+// CacheStats returns usage of keys cache, zero stats if cache turned off
+func (store *KeyStore) CacheStats() keystore.CacheStats {
+	if provider, ok := store.cache.(keystore.CacheStatsProvider); ok {
+		return provider.CacheStats()
+	}
+	return keystore.CacheStats{}
+}
+
 // GetPoisonKeyPair reads and returns poison EC keypair from the fs.
 // Returns an error if fs or crypto operations fail. Also, returns ErrKeysNotFound
 // if the key pair doesn't exist.
@@ -1007,16 +1048,10 @@ func (store *KeyStore) destroyKeyWithFilename(filename string) error {
 	// Remove key files. It's okay if they are already removed (or never existed).
 	// Keystore v1 does not differentiate between 'destroying' and 'removing' keys
 	// because multiple functinons depend on the key file to be absent, not empty.
-	err := store.fs.Remove(store.GetPrivateKeyFilePath(filename))
-	if err != nil && !os.IsNotExist(err) {
+	if err := store.removeKeyFile(store.GetPrivateKeyFilePath(filename)); err != nil {
 		return err
 	}
-	err = store.fs.Remove(store.GetPublicKeyFilePath(filename + ".pub"))
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	return nil
+	return store.removeKeyFile(store.GetPublicKeyFilePath(filename + ".pub"))
 }
 
 // destroySymmetricKeyWithFilename removes symmetric key with given filename.
@@ -1027,12 +1062,7 @@ func (store *KeyStore) destroySymmetricKeyWithFilename(filename string) error {
 	// Remove key files. It's okay if they are already removed (or never existed).
 	// Keystore v1 does not differentiate between 'destroying' and 'removing' keys
 	// because multiple functinons depend on the key file to be absent, not empty.
-	err := store.fs.Remove(store.GetPrivateKeyFilePath(getSymmetricKeyName(filename)))
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	return nil
+	return store.removeKeyFile(store.GetPrivateKeyFilePath(getSymmetricKeyName(filename)))
 }
 
 // Add value to inner cache
@@ -1246,7 +1276,11 @@ func (store *KeyStore) GetClientIDSymmetricKeys(id []byte) ([][]byte, error) {
 	keyName := getClientIDSymmetricKeyName(id)
 
 	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientSymmetricKey, id)
-	return store.getSymmetricKeys(keyName, keyContext)
+	symmetricKeys, err := store.getSymmetricKeys(keyName, keyContext)
+	if err == nil {
+		store.usageTracker.Track(keyName, keystore.KeyUsageDecryption)
+	}
+	return symmetricKeys, err
 }
 
 // GetClientIDSymmetricKey return latest symmetric key for encryption by specified client id
@@ -1254,7 +1288,11 @@ func (store *KeyStore) GetClientIDSymmetricKey(id []byte) ([]byte, error) {
 	keyName := getClientIDSymmetricKeyName(id)
 
 	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientSymmetricKey, id)
-	return store.getLatestSymmetricKey(keyName, keyContext)
+	symmetricKey, err := store.getLatestSymmetricKey(keyName, keyContext)
+	if err == nil {
+		store.usageTracker.Track(keyName, keystore.KeyUsageEncryption)
+	}
+	return symmetricKey, err
 }
 
 // DestroyPoisonKeyPair destroy poison key pair
@@ -1330,17 +1368,12 @@ func (store *KeyStore) destroyRotatedKeyByIndex(path string, index int) error {
 
 	// 1 is always index of current key of the keystore
 	// all rotated keys have index after 1
-	if len(rotatedKeyFiles) == 0 || index > len(rotatedKeyFiles)+1 {
+	if len(rotatedKeyFiles) == 0 || index < 2 || index > len(rotatedKeyFiles)+1 {
 		return ErrInvalidIndex
 	}
 
-	rotatedKey := rotatedKeyFiles[index-1]
-	err = store.fs.Remove(filepath.Join(oldDir, rotatedKey.Name()))
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	return nil
+	rotatedKey := rotatedKeyFiles[index-2]
+	return store.removeKeyFile(filepath.Join(oldDir, rotatedKey.Name()))
 }
 
 // DescribeKeyFile describes key by its purpose path for V1 and V2 keystore
@@ -1531,6 +1564,24 @@ func describeV1(fileName string) (*keystore.KeyDescription, bool, error) {
 		}, true, nil
 	}
 
+	if penultimateKeyPart == "pseudonym" && lastKeyPart == "sym" {
+		return &keystore.KeyDescription{
+			KeyID:    fileName,
+			Purpose:  keystore.PurposePseudonymization,
+			ClientID: strings.Join(components[:len(components)-2], "_"),
+			State:    keystore.StateCurrent,
+		}, true, nil
+	}
+
+	if penultimateKeyPart == "re" && (lastKeyPart == "identification" || lastKeyPart == "identification.pub") {
+		return &keystore.KeyDescription{
+			KeyID:    fileName,
+			Purpose:  keystore.PurposeReIdentificationKeyPair,
+			ClientID: strings.Join(components[:len(components)-2], "_"),
+			State:    keystore.StateCurrent,
+		}, true, nil
+	}
+
 	if penultimateKeyPart == "zone" && lastKeyPart == "sym" {
 		return &keystore.KeyDescription{
 			KeyID:   fileName,
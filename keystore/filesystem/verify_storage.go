@@ -0,0 +1,151 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Kinds of problems found by verification of key files.
+const (
+	// KeyFileIssueCorrupted means key file which can't be decrypted with the current master key, because it was
+	// modified or encrypted with another master key
+	KeyFileIssueCorrupted = "corrupted"
+	// KeyFileIssueForeign means file which isn't key of keystore v1
+	KeyFileIssueForeign = "foreign"
+	// KeyFileIssueUnreadable means key file which can't be read from storage
+	KeyFileIssueUnreadable = "unreadable"
+)
+
+var errForeignKeyFile = errors.New("file isn't key of keystore")
+
+// KeyFileVerificationIssue describes problem with one file of keystore.
+type KeyFileVerificationIssue struct {
+	Path  string `json:"path"`
+	Issue string `json:"issue"`
+	Error string `json:"error,omitempty"`
+}
+
+// KeyFilesVerificationReport is the result of VerifyKeyFiles.
+type KeyFilesVerificationReport struct {
+	Keys     int                        `json:"keys"`
+	Verified int                        `json:"verified"`
+	Issues   []KeyFileVerificationIssue `json:"issues"`
+}
+
+// VerifyKeyFiles decrypts all current and rotated private and symmetric keys with the current master key and reports
+// keys which can't be decrypted and unknown files. Public keys aren't encrypted and aren't verified.
+// An error is returned only if the keystore can't be listed.
+func (store *KeyStore) VerifyKeyFiles() (*KeyFilesVerificationReport, error) {
+	report := &KeyFilesVerificationReport{Issues: make([]KeyFileVerificationIssue, 0)}
+	if err := store.verifyKeyDir(store.privateKeyDirectory, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (store *KeyStore) verifyKeyDir(dirName string, report *KeyFilesVerificationReport) error {
+	files, err := store.fs.ReadDir(dirName)
+	if err != nil {
+		return err
+	}
+	addIssue := func(path, issue string, err error) {
+		report.Issues = append(report.Issues, KeyFileVerificationIssue{Path: path, Issue: issue, Error: err.Error()})
+	}
+	for _, fileInfo := range files {
+		path := filepath.Join(dirName, fileInfo.Name())
+		if fileInfo.IsDir() && fileInfo.Name() == ".poison_key" {
+			if err := store.verifyKeyDir(path, report); err != nil {
+				return err
+			}
+			continue
+		}
+		if isMetadataFile(fileInfo.Name()) || isTombstonesDir(fileInfo.Name()) {
+			continue
+		}
+		name := fileInfo.Name()
+		rotated := fileInfo.IsDir() && strings.HasSuffix(name, ".old")
+		if rotated {
+			name = strings.TrimSuffix(name, ".old")
+		}
+		description, err := DescribeKeyFile(name)
+		if err != nil || (fileInfo.IsDir() && !rotated) || strings.HasSuffix(name, ".keyring") {
+			addIssue(path, KeyFileIssueForeign, errForeignKeyFile)
+			continue
+		}
+		keyContext, ok := verifiedKeyContext(name, description)
+		if !ok {
+			continue
+		}
+		if !rotated {
+			store.verifyKeyFile(path, keyContext, report, addIssue)
+			continue
+		}
+		rotatedFiles, err := store.fs.ReadDir(path)
+		if err != nil {
+			addIssue(path, KeyFileIssueUnreadable, err)
+			continue
+		}
+		for _, rotatedFile := range rotatedFiles {
+			rotatedPath := filepath.Join(path, rotatedFile.Name())
+			if rotatedFile.IsDir() {
+				addIssue(rotatedPath, KeyFileIssueForeign, errForeignKeyFile)
+				continue
+			}
+			store.verifyKeyFile(rotatedPath, keyContext, report, addIssue)
+		}
+	}
+	return nil
+}
+
+func (store *KeyStore) verifyKeyFile(path string, keyContext keystore.KeyContext, report *KeyFilesVerificationReport, addIssue func(path, issue string, err error)) {
+	report.Keys++
+	encrypted, err := store.ReadKeyFile(path)
+	if err != nil {
+		addIssue(path, KeyFileIssueUnreadable, err)
+		return
+	}
+	decrypted, err := store.encryptor.Decrypt(store.encryptorCtx, encrypted, keyContext)
+	if err != nil {
+		addIssue(path, KeyFileIssueCorrupted, err)
+		return
+	}
+	utils.ZeroizeBytes(decrypted)
+	report.Verified++
+}
+
+// verifiedKeyContext returns context used to encrypt key file with given name, false for public and legacy keys
+func verifiedKeyContext(name string, description *keystore.KeyDescription) (keystore.KeyContext, bool) {
+	switch description.Purpose {
+	case keystore.PurposeStorageClientPrivateKey, keystore.PurposeStorageClientSymmetricKey, keystore.PurposeSearchHMAC:
+		return keystore.NewClientIDKeyContext(description.Purpose, []byte(description.ClientID)), true
+	case keystore.PurposeAuditLog:
+		return keystore.NewKeyContext(keystore.PurposeAuditLog, []byte(SecureLogKeyFilename)), true
+	case keystore.PurposePoisonRecordSymmetricKey:
+		return keystore.NewKeyContext(keystore.PurposePoisonRecordSymmetricKey, []byte(getSymmetricKeyName(PoisonKeyFilename))), true
+	case keystore.PurposePoisonRecordKeyPair:
+		if name == poisonPrivateKey {
+			return keystore.NewKeyContext(keystore.PurposePoisonRecordKeyPair, []byte(PoisonKeyFilename)), true
+		}
+	}
+	return keystore.KeyContext{}, false
+}
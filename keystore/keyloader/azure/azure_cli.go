@@ -0,0 +1,83 @@
+package azure
+
+import (
+	"flag"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ClientSecretEnv is the name of environment variable with client secret of Azure application
+const ClientSecretEnv = "AZURE_CLIENT_SECRET"
+
+// Default values of Azure Key Vault options
+const (
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+	DefaultKeyName       = "acra-master-key"
+	DefaultAlgorithm     = "RSA-OAEP-256"
+)
+
+const keyVaultURLFlag = "azure_keyvault_url"
+
+// CLIOptions keep command-line options related to Azure Key Vault ACRA_MASTER_KEY loading.
+type CLIOptions struct {
+	KeyVaultURL   string
+	KeyName       string
+	KeyVersion    string
+	Algorithm     string
+	AuthorityHost string
+	TenantID      string
+	ClientID      string
+}
+
+// RegisterCLIParametersWithFlagSet look up for azure_keyvault_url, if none exists, Azure Key Vault flags
+// will be added to provided flags.
+func RegisterCLIParametersWithFlagSet(flags *flag.FlagSet, prefix, description string) {
+	if description != "" {
+		description = " (" + description + ")"
+	}
+	if flags.Lookup(prefix+keyVaultURLFlag) == nil {
+		flags.String(prefix+keyVaultURLFlag, "", "Azure Key Vault URL (https://<name>.vault.azure.net) with the key wrapping ACRA_MASTER_KEY"+description)
+		flags.String(prefix+"azure_keyvault_key_name", DefaultKeyName, "Name of Azure Key Vault key used to wrap new ACRA_MASTER_KEY"+description)
+		flags.String(prefix+"azure_keyvault_key_version", "", "Version of Azure Key Vault key used to wrap new ACRA_MASTER_KEY, the latest if empty"+description)
+		flags.String(prefix+"azure_keyvault_wrap_algorithm", DefaultAlgorithm, "Algorithm of Azure Key Vault key wrapping: <RSA-OAEP-256|RSA-OAEP|A256KW>"+description)
+		flags.String(prefix+"azure_authority_host", DefaultAuthorityHost, "Azure Active Directory authority host used to authenticate to Azure Key Vault"+description)
+		flags.String(prefix+"azure_tenant_id", "", "Azure Active Directory tenant ID of application authenticated to Azure Key Vault"+description)
+		flags.String(prefix+"azure_client_id", "", "Client ID of application authenticated to Azure Key Vault, client secret is read from AZURE_CLIENT_SECRET environment variable"+description)
+	}
+}
+
+// ParseCLIParametersFromFlags CLIOptions from provided FlagSet
+func ParseCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *CLIOptions {
+	options := CLIOptions{}
+	values := map[string]*string{
+		keyVaultURLFlag:                 &options.KeyVaultURL,
+		"azure_keyvault_key_name":       &options.KeyName,
+		"azure_keyvault_key_version":    &options.KeyVersion,
+		"azure_keyvault_wrap_algorithm": &options.Algorithm,
+		"azure_authority_host":          &options.AuthorityHost,
+		"azure_tenant_id":               &options.TenantID,
+		"azure_client_id":               &options.ClientID,
+	}
+	for name, value := range values {
+		if f := flags.Lookup(prefix + name); f != nil {
+			*value = f.Value.String()
+		}
+	}
+	return &options
+}
+
+// NewKeyVaultClient create KeyVaultClient from CLIOptions and client secret from environment
+func (options *CLIOptions) NewKeyVaultClient() (*KeyVaultClient, error) {
+	clientSecret := os.Getenv(ClientSecretEnv)
+	if clientSecret == "" {
+		log.Warnf("%v environment variable is not set", ClientSecretEnv)
+	}
+	return NewKeyVaultClient(ClientConfig{
+		KeyVaultURL:   options.KeyVaultURL,
+		AuthorityHost: options.AuthorityHost,
+		TenantID:      options.TenantID,
+		ClientID:      options.ClientID,
+		ClientSecret:  clientSecret,
+	})
+}
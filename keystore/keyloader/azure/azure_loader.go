@@ -0,0 +1,117 @@
+package azure
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInvalidWrappedKey returned if ACRA_MASTER_KEY isn't a key wrapped with Azure Key Vault
+var ErrInvalidWrappedKey = errors.New("ACRA_MASTER_KEY is not a key wrapped with Azure Key Vault")
+
+// WrappedKey is the format of ACRA_MASTER_KEY wrapped with Azure Key Vault key. It stores full ID of the key with
+// version, so the master key can be unwrapped after the rotation of Azure Key Vault key
+type WrappedKey struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Value     []byte `json:"value"`
+}
+
+// WrapMasterKey wraps new ACRA_MASTER_KEY with the key of Azure Key Vault and returns serialized WrappedKey
+func WrapMasterKey(client *KeyVaultClient, keyName, keyVersion, algorithm string, masterKey []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+	defer cancel()
+	value, keyID, err := client.WrapKey(ctx, keyName, keyVersion, algorithm, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(WrappedKey{KeyID: keyID, Algorithm: algorithm, Value: value})
+}
+
+// Loader is implementation of MasterKeyLoader which unwraps ACRA_MASTER_KEY with Azure Key Vault
+type Loader struct {
+	client *KeyVaultClient
+}
+
+// NewLoader create new Azure Key Vault MasterKeyLoader
+func NewLoader(client *KeyVaultClient) *Loader {
+	return &Loader{client: client}
+}
+
+// LoadMasterKey unwrap ACRA_MASTER_KEY for keystore v1 and validate it
+func (loader *Loader) LoadMasterKey() ([]byte, error) {
+	key, err := loader.unwrapMasterKey()
+	if err != nil {
+		log.WithError(err).Warnf("Failed to unwrap %s with Azure Key Vault", keystore.AcraMasterKeyVarName)
+		return nil, err
+	}
+	if err := keystore.ValidateMasterKey(key); err != nil {
+		log.WithError(err).Warn("Unwrapped key is invalid")
+		return nil, err
+	}
+	log.Infoln("Using Azure Key Vault for ACRA_MASTER_KEY loading...")
+	return key, nil
+}
+
+// LoadMasterKeys unwrap ACRA_MASTER_KEY for keystore v2 and validate it
+func (loader *Loader) LoadMasterKeys() ([]byte, []byte, error) {
+	rawKey, err := loader.unwrapMasterKey()
+	if err != nil {
+		log.WithError(err).Warnf("Failed to unwrap %s with Azure Key Vault", keystore.AcraMasterKeyVarName)
+		return nil, nil, err
+	}
+
+	keys := &keystoreV2.SerializedKeys{}
+	err = keys.Unmarshal(rawKey)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse unwrapped key as SerializedKeys")
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare(keys.Encryption, keys.Signature) == 1 {
+		log.Warn("ACRA_MASTER_KEYs must not be the same")
+		return nil, nil, keystoreV2.ErrEqualMasterKeys
+	}
+
+	err = keystore.ValidateMasterKey(keys.Encryption)
+	if err != nil {
+		log.WithError(err).Warn("Invalid encryption key")
+		return nil, nil, err
+	}
+	err = keystore.ValidateMasterKey(keys.Signature)
+	if err != nil {
+		log.WithError(err).Warn("Invalid signature key")
+		return nil, nil, err
+	}
+	log.Infoln("Using Azure Key Vault for ACRA_MASTER_KEY loading...")
+	return keys.Encryption, keys.Signature, nil
+}
+
+// unwrapMasterKey reads WrappedKey from ACRA_MASTER_KEY environment variable and unwraps it
+func (loader *Loader) unwrapMasterKey() ([]byte, error) {
+	b64value := os.Getenv(keystore.AcraMasterKeyVarName)
+	if len(b64value) == 0 {
+		log.Warnf("%v environment variable is not set", keystore.AcraMasterKeyVarName)
+		return nil, keystore.ErrEmptyMasterKey
+	}
+	serialized, err := base64.StdEncoding.DecodeString(b64value)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to decode %s", keystore.AcraMasterKeyVarName)
+		return nil, err
+	}
+	wrappedKey := WrappedKey{}
+	if err := json.Unmarshal(serialized, &wrappedKey); err != nil || wrappedKey.KeyID == "" || len(wrappedKey.Value) == 0 {
+		return nil, ErrInvalidWrappedKey
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+	defer cancel()
+	return loader.client.UnwrapKey(ctx, wrappedKey.KeyID, wrappedKey.Algorithm, wrappedKey.Value)
+}
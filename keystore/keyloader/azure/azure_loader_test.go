@@ -0,0 +1,151 @@
+package azure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTenantID   = "test-tenant"
+	testToken      = "test-token"
+	testKeyVersion = "0123456789abcdef"
+)
+
+// newTestKeyVault returns server which emulates Azure Active Directory and Azure Key Vault. Keys are "wrapped" with
+// reversing of bytes
+func newTestKeyVault(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+testTenantID+"/oauth2/v2.0/token" {
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":{"code":"invalid_client","message":"invalid client secret"}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(tokenResponse{AccessToken: testToken, ExpiresIn: 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		request := keyOperationRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		value, err := base64.RawURLEncoding.DecodeString(request.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, j := 0, len(value)-1; i < j; i, j = i+1, j-1 {
+			value[i], value[j] = value[j], value[i]
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/keys/")
+		switch {
+		case path == DefaultKeyName+"/wrapkey":
+			path = DefaultKeyName + "/" + testKeyVersion + "/wrapkey"
+		case path != DefaultKeyName+"/"+testKeyVersion+"/wrapkey" && path != DefaultKeyName+"/"+testKeyVersion+"/unwrapkey":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"KeyNotFound","message":"key not found"}}`))
+			return
+		}
+		kid := server.URL + "/keys/" + strings.TrimSuffix(strings.TrimSuffix(path, "/wrapkey"), "/unwrapkey")
+		json.NewEncoder(w).Encode(keyOperationResult{KeyID: kid, Value: base64.RawURLEncoding.EncodeToString(value)})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, secret string) *KeyVaultClient {
+	client, err := NewKeyVaultClient(ClientConfig{
+		KeyVaultURL:   server.URL,
+		AuthorityHost: server.URL,
+		TenantID:      testTenantID,
+		ClientID:      "client",
+		ClientSecret:  secret,
+	})
+	assert.NoError(t, err)
+	return client
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	server := newTestKeyVault(t)
+	client := newTestClient(t, server, "secret")
+
+	masterKey, err := keystore.GenerateSymmetricKey()
+	assert.NoError(t, err)
+	wrapped, err := WrapMasterKey(client, DefaultKeyName, "", DefaultAlgorithm, masterKey)
+	assert.NoError(t, err)
+
+	wrappedKey := WrappedKey{}
+	assert.NoError(t, json.Unmarshal(wrapped, &wrappedKey))
+	assert.Equal(t, server.URL+"/keys/"+DefaultKeyName+"/"+testKeyVersion, wrappedKey.KeyID)
+	assert.NotEqual(t, masterKey, wrappedKey.Value)
+
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(wrapped))
+	loadedKey, err := NewLoader(client).LoadMasterKey()
+	assert.NoError(t, err)
+	assert.Equal(t, masterKey, loadedKey)
+}
+
+func TestLoadMasterKeys(t *testing.T) {
+	server := newTestKeyVault(t)
+	client := newTestClient(t, server, "secret")
+
+	masterKeys, err := keystoreV2.NewSerializedMasterKeys()
+	assert.NoError(t, err)
+	wrapped, err := WrapMasterKey(client, DefaultKeyName, testKeyVersion, DefaultAlgorithm, masterKeys)
+	assert.NoError(t, err)
+
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(wrapped))
+	encryption, signature, err := NewLoader(client).LoadMasterKeys()
+	assert.NoError(t, err)
+
+	keys := &keystoreV2.SerializedKeys{}
+	assert.NoError(t, keys.Unmarshal(masterKeys))
+	assert.Equal(t, keys.Encryption, encryption)
+	assert.Equal(t, keys.Signature, signature)
+}
+
+func TestLoadMasterKeyErrors(t *testing.T) {
+	server := newTestKeyVault(t)
+	client := newTestClient(t, server, "secret")
+	loader := NewLoader(client)
+
+	t.Setenv(keystore.AcraMasterKeyVarName, "")
+	_, err := loader.LoadMasterKey()
+	assert.ErrorIs(t, err, keystore.ErrEmptyMasterKey)
+
+	// raw master key instead of wrapped
+	masterKey, err := keystore.GenerateSymmetricKey()
+	assert.NoError(t, err)
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(masterKey))
+	_, err = loader.LoadMasterKey()
+	assert.ErrorIs(t, err, ErrInvalidWrappedKey)
+
+	// access token mustn't be sent to another host
+	wrapped, err := json.Marshal(WrappedKey{KeyID: "https://attacker.example.com/keys/" + DefaultKeyName, Algorithm: DefaultAlgorithm, Value: masterKey})
+	assert.NoError(t, err)
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(wrapped))
+	_, err = loader.LoadMasterKey()
+	assert.ErrorIs(t, err, ErrKeyIDNotFromKeyVault)
+
+	wrapped, err = json.Marshal(WrappedKey{KeyID: server.URL + "/keys/unknown/" + testKeyVersion, Algorithm: DefaultAlgorithm, Value: masterKey})
+	assert.NoError(t, err)
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(wrapped))
+	_, err = loader.LoadMasterKey()
+	assert.ErrorIs(t, err, ErrKeyVaultRequest)
+
+	_, err = WrapMasterKey(newTestClient(t, server, "invalid"), DefaultKeyName, "", DefaultAlgorithm, masterKey)
+	assert.ErrorIs(t, err, ErrTokenRequest)
+}
@@ -0,0 +1,215 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyVaultAPIVersion = "7.4"
+	keyVaultScope      = "https://vault.azure.net/.default"
+	// tokenExpirationGap is the time before expiration of access token when it is renewed
+	tokenExpirationGap = time.Minute
+)
+
+// set of predefined errors used in Azure Key Vault client
+var (
+	ErrEmptyKeyVaultURL     = errors.New("empty Azure Key Vault URL")
+	ErrEmptyCredentials     = errors.New("Azure tenant ID, client ID and client secret are required")
+	ErrKeyIDNotFromKeyVault = errors.New("key ID doesn't belong to configured Azure Key Vault")
+	ErrKeyVaultRequest      = errors.New("Azure Key Vault request failed")
+	ErrTokenRequest         = errors.New("Azure access token request failed")
+)
+
+// ClientConfig defines Azure Key Vault connection and application credentials
+type ClientConfig struct {
+	// KeyVaultURL like https://<name>.vault.azure.net
+	KeyVaultURL string
+	// AuthorityHost of Azure Active Directory like https://login.microsoftonline.com
+	AuthorityHost string
+	TenantID      string
+	ClientID      string
+	ClientSecret  string
+	HTTPClient    *http.Client
+}
+
+// KeyVaultClient wraps and unwraps keys with a key stored in Azure Key Vault using REST API.
+// Application authenticates with client credentials of Azure Active Directory.
+type KeyVaultClient struct {
+	config     ClientConfig
+	vaultURL   *url.URL
+	httpClient *http.Client
+
+	tokenLock   sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewKeyVaultClient returns client for Azure Key Vault
+func NewKeyVaultClient(config ClientConfig) (*KeyVaultClient, error) {
+	if config.KeyVaultURL == "" {
+		return nil, ErrEmptyKeyVaultURL
+	}
+	if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+		return nil, ErrEmptyCredentials
+	}
+	vaultURL, err := url.Parse(strings.TrimSuffix(config.KeyVaultURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if config.AuthorityHost == "" {
+		config.AuthorityHost = DefaultAuthorityHost
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &KeyVaultClient{config: config, vaultURL: vaultURL, httpClient: httpClient}, nil
+}
+
+// keyOperationRequest is the body of wrapkey and unwrapkey requests
+type keyOperationRequest struct {
+	Algorithm string `json:"alg"`
+	Value     string `json:"value"`
+}
+
+// keyOperationResult is the body of wrapkey and unwrapkey responses
+type keyOperationResult struct {
+	KeyID string `json:"kid"`
+	Value string `json:"value"`
+}
+
+// errorResponse is the body of error responses of Azure Key Vault and Azure Active Directory
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// tokenResponse is the body of access token response
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// WrapKey encrypts key with the key of Azure Key Vault and returns encrypted key and full ID of the key with version
+// which should be used to unwrap it. Latest version of the key is used if version is empty
+func (client *KeyVaultClient) WrapKey(ctx context.Context, keyName, keyVersion, algorithm string, key []byte) ([]byte, string, error) {
+	keyID := client.vaultURL.String() + "/keys/" + url.PathEscape(keyName)
+	if keyVersion != "" {
+		keyID += "/" + url.PathEscape(keyVersion)
+	}
+	result, err := client.keyOperation(ctx, keyID, "wrapkey", algorithm, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return result.value, result.keyID, nil
+}
+
+// UnwrapKey decrypts key wrapped by the key with keyID returned by WrapKey
+func (client *KeyVaultClient) UnwrapKey(ctx context.Context, keyID, algorithm string, wrappedKey []byte) ([]byte, error) {
+	// access token shouldn't be sent anywhere except configured vault
+	parsedKeyID, err := url.Parse(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if parsedKeyID.Scheme != client.vaultURL.Scheme || parsedKeyID.Host != client.vaultURL.Host || !strings.HasPrefix(parsedKeyID.Path, "/keys/") {
+		return nil, ErrKeyIDNotFromKeyVault
+	}
+	result, err := client.keyOperation(ctx, keyID, "unwrapkey", algorithm, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return result.value, nil
+}
+
+type decodedKeyOperationResult struct {
+	keyID string
+	value []byte
+}
+
+func (client *KeyVaultClient) keyOperation(ctx context.Context, keyID, operation, algorithm string, value []byte) (*decodedKeyOperationResult, error) {
+	token, err := client.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(keyOperationRequest{Algorithm: algorithm, Value: base64.RawURLEncoding.EncodeToString(value)})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, keyID+"/"+operation+"?api-version="+keyVaultAPIVersion, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	result := keyOperationResult{}
+	if err := client.do(request, &result, ErrKeyVaultRequest); err != nil {
+		return nil, err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(result.Value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return &decodedKeyOperationResult{keyID: result.KeyID, value: decoded}, nil
+}
+
+// accessToken returns cached access token or requests new one with client credentials
+func (client *KeyVaultClient) accessToken(ctx context.Context) (string, error) {
+	client.tokenLock.Lock()
+	defer client.tokenLock.Unlock()
+	if client.token != "" && time.Now().Before(client.tokenExpiry) {
+		return client.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {client.config.ClientID},
+		"client_secret": {client.config.ClientSecret},
+		"scope":         {keyVaultScope},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(client.config.AuthorityHost, "/"), url.PathEscape(client.config.TenantID))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := tokenResponse{}
+	if err := client.do(request, &response, ErrTokenRequest); err != nil {
+		return "", err
+	}
+	client.token = response.AccessToken
+	client.tokenExpiry = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - tokenExpirationGap)
+	return client.token, nil
+}
+
+// do sends request and decodes JSON response into result, otherwise returns requestError with details of the error
+func (client *KeyVaultClient) do(request *http.Request, result interface{}, requestError error) error {
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		errorBody := errorResponse{}
+		if err := json.Unmarshal(body, &errorBody); err != nil || errorBody.Error.Code == "" {
+			return fmt.Errorf("%w: %s", requestError, response.Status)
+		}
+		return fmt.Errorf("%w: %s: %s", requestError, errorBody.Error.Code, errorBody.Error.Message)
+	}
+	return json.Unmarshal(body, result)
+}
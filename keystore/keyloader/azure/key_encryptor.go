@@ -0,0 +1,64 @@
+package azure
+
+import (
+	"flag"
+
+	"github.com/cossacklabs/acra/keystore"
+	baseKMS "github.com/cossacklabs/acra/keystore/kms/base"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyEncryptorFabric implementation of keyloader.KeyEncryptorFabric for `azure_keyvault` strategy
+type KeyEncryptorFabric struct{}
+
+// NewMasterKeyLoader create Loader from Azure Key Vault CLI options
+func NewMasterKeyLoader(flags *flag.FlagSet, prefix string) (*Loader, error) {
+	client, err := ParseCLIParametersFromFlags(flags, prefix).NewKeyVaultClient()
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialize Azure Key Vault client")
+		return nil, err
+	}
+	return NewLoader(client), nil
+}
+
+// NewKeyEncryptor fabric of keystore.KeyEncryptor for `azure_keyvault` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptor(flags *flag.FlagSet, prefix string) (keystore.KeyEncryptor, error) {
+	loader, err := NewMasterKeyLoader(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := loader.LoadMasterKey()
+	if err != nil {
+		log.WithError(err).Errorln("Cannot load master key")
+		return nil, err
+	}
+	return keystore.NewSCellKeyEncryptor(key)
+}
+
+// NewKeyEncryptorSuite fabric of crypto.KeyStoreSuite for `azure_keyvault` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptorSuite(flags *flag.FlagSet, prefix string) (*crypto.KeyStoreSuite, error) {
+	loader, err := NewMasterKeyLoader(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, signature, err := loader.LoadMasterKeys()
+	if err != nil {
+		log.WithError(err).Errorln("Cannot load master keys")
+		return nil, err
+	}
+	return keystoreV2.NewSCellSuite(encryption, signature)
+}
+
+// RegisterCLIParameters register Azure Key Vault flags
+func (k KeyEncryptorFabric) RegisterCLIParameters(flags *flag.FlagSet, prefix, description string) {
+	RegisterCLIParametersWithFlagSet(flags, prefix, description)
+}
+
+// GetKeyMapper return KeyMapper for `azure_keyvault` strategy
+func (k KeyEncryptorFabric) GetKeyMapper() baseKMS.KeyMapper {
+	panic("No KeyMapper for azure_keyvault strategy")
+}
@@ -0,0 +1,12 @@
+//go:build !azure_keyvault_off
+// +build !azure_keyvault_off
+
+package keyloader
+
+import (
+	"github.com/cossacklabs/acra/keystore/keyloader/azure"
+)
+
+func init() {
+	RegisterKeyEncryptorFabric(KeystoreStrategyAzureKeyVaultMasterKey, azure.KeyEncryptorFabric{})
+}
@@ -0,0 +1,12 @@
+//go:build !gcp_kms_off
+// +build !gcp_kms_off
+
+package keyloader
+
+import (
+	"github.com/cossacklabs/acra/keystore/keyloader/gcp"
+)
+
+func init() {
+	RegisterKeyEncryptorFabric(KeystoreStrategyGCPKMSMasterKey, gcp.KeyEncryptorFabric{})
+}
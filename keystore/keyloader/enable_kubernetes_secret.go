@@ -0,0 +1,12 @@
+//go:build !kubernetes_secret_off
+// +build !kubernetes_secret_off
+
+package keyloader
+
+import (
+	"github.com/cossacklabs/acra/keystore/keyloader/kubernetes"
+)
+
+func init() {
+	RegisterKeyEncryptorFabric(KeystoreStrategyKubernetesSecret, kubernetes.KeyEncryptorFabric{})
+}
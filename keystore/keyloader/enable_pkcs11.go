@@ -0,0 +1,12 @@
+//go:build !pkcs11_off
+// +build !pkcs11_off
+
+package keyloader
+
+import (
+	"github.com/cossacklabs/acra/keystore/keyloader/pkcs11"
+)
+
+func init() {
+	RegisterKeyEncryptorFabric(KeystoreStrategyPKCS11, pkcs11.KeyEncryptorFabric{})
+}
@@ -0,0 +1,241 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenExpirationGap is the time before expiration of access token when it is renewed
+	tokenExpirationGap = time.Minute
+	metadataTokenPath  = "/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// set of predefined errors used in Cloud KMS client
+var (
+	ErrEmptyKeyName     = errors.New("Cloud KMS project, location, key ring and key are required")
+	ErrKMSRequest       = errors.New("Cloud KMS request failed")
+	ErrTokenRequest     = errors.New("access token request to metadata server failed")
+	ErrChecksumMismatch = errors.New("Cloud KMS response checksum mismatch")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// KeyName identifies CryptoKey of Cloud KMS and optional version of it
+type KeyName struct {
+	Project  string
+	Location string
+	KeyRing  string
+	Key      string
+	// Version of CryptoKey used for encryption, primary version is used if empty
+	Version string
+}
+
+// CryptoKey returns resource name of CryptoKey
+func (name KeyName) CryptoKey() string {
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", name.Project, name.Location, name.KeyRing, name.Key)
+}
+
+// EncryptionKey returns resource name of CryptoKeyVersion if version is set, otherwise resource name of CryptoKey
+func (name KeyName) EncryptionKey() string {
+	if name.Version == "" {
+		return name.CryptoKey()
+	}
+	return name.CryptoKey() + "/cryptoKeyVersions/" + name.Version
+}
+
+// ClientConfig defines Cloud KMS connection and source of access tokens
+type ClientConfig struct {
+	// Endpoint of Cloud KMS API like https://cloudkms.googleapis.com
+	Endpoint string
+	// MetadataHost of GCE metadata server which issues tokens of workload identity service account
+	MetadataHost string
+	HTTPClient   *http.Client
+}
+
+// KMSClient encrypts and decrypts data with Cloud KMS REST API. Access tokens are issued by metadata server
+// for the service account attached to the instance or bound to Kubernetes service account with Workload Identity.
+type KMSClient struct {
+	config     ClientConfig
+	keyName    KeyName
+	httpClient *http.Client
+
+	tokenLock   sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewKMSClient returns client for Cloud KMS key
+func NewKMSClient(keyName KeyName, config ClientConfig) (*KMSClient, error) {
+	if keyName.Project == "" || keyName.Location == "" || keyName.KeyRing == "" || keyName.Key == "" {
+		return nil, ErrEmptyKeyName
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultEndpoint
+	}
+	if config.MetadataHost == "" {
+		config.MetadataHost = DefaultMetadataHost
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &KMSClient{config: config, keyName: keyName, httpClient: httpClient}, nil
+}
+
+type encryptRequest struct {
+	Plaintext       string `json:"plaintext"`
+	PlaintextCrc32c string `json:"plaintextCrc32c"`
+}
+
+type encryptResponse struct {
+	Name                    string `json:"name"`
+	Ciphertext              string `json:"ciphertext"`
+	CiphertextCrc32c        string `json:"ciphertextCrc32c"`
+	VerifiedPlaintextCrc32c bool   `json:"verifiedPlaintextCrc32c"`
+}
+
+type decryptRequest struct {
+	Ciphertext       string `json:"ciphertext"`
+	CiphertextCrc32c string `json:"ciphertextCrc32c"`
+}
+
+type decryptResponse struct {
+	Plaintext       string `json:"plaintext"`
+	PlaintextCrc32c string `json:"plaintextCrc32c"`
+}
+
+// errorResponse is the body of error responses of Google APIs
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// tokenResponse is the body of access token response of metadata server
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Encrypt encrypts data with configured key version or primary version of CryptoKey
+func (client *KMSClient) Encrypt(ctx context.Context, data []byte) ([]byte, error) {
+	request := encryptRequest{
+		Plaintext:       base64.StdEncoding.EncodeToString(data),
+		PlaintextCrc32c: checksum(data),
+	}
+	response := encryptResponse{}
+	if err := client.call(ctx, client.keyName.EncryptionKey()+":encrypt", request, &response); err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(response.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if !response.VerifiedPlaintextCrc32c || response.CiphertextCrc32c != checksum(ciphertext) {
+		return nil, ErrChecksumMismatch
+	}
+	return ciphertext, nil
+}
+
+// Decrypt decrypts data with CryptoKey. Cloud KMS detects version of the key used for encryption by ciphertext
+func (client *KMSClient) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	request := decryptRequest{
+		Ciphertext:       base64.StdEncoding.EncodeToString(ciphertext),
+		CiphertextCrc32c: checksum(ciphertext),
+	}
+	response := decryptResponse{}
+	if err := client.call(ctx, client.keyName.CryptoKey()+":decrypt", request, &response); err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if response.PlaintextCrc32c != checksum(plaintext) {
+		return nil, ErrChecksumMismatch
+	}
+	return plaintext, nil
+}
+
+// checksum returns CRC32C of data in format of int64 JSON value of Google APIs
+func checksum(data []byte) string {
+	return strconv.FormatUint(uint64(crc32.Checksum(data, crc32cTable)), 10)
+}
+
+func (client *KMSClient) call(ctx context.Context, method string, requestBody, result interface{}) error {
+	token, err := client.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(client.config.Endpoint, "/")+"/v1/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	return client.do(request, result, ErrKMSRequest)
+}
+
+// accessToken returns cached access token or requests new one from metadata server
+func (client *KMSClient) accessToken(ctx context.Context) (string, error) {
+	client.tokenLock.Lock()
+	defer client.tokenLock.Unlock()
+	if client.token != "" && time.Now().Before(client.tokenExpiry) {
+		return client.token, nil
+	}
+	host := client.config.MetadataHost
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(host, "/")+metadataTokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	response := tokenResponse{}
+	if err := client.do(request, &response, ErrTokenRequest); err != nil {
+		return "", err
+	}
+	client.token = response.AccessToken
+	client.tokenExpiry = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - tokenExpirationGap)
+	return client.token, nil
+}
+
+// do sends request and decodes JSON response into result, otherwise returns requestError with details of the error
+func (client *KMSClient) do(request *http.Request, result interface{}, requestError error) error {
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		errorBody := errorResponse{}
+		if err := json.Unmarshal(body, &errorBody); err != nil || errorBody.Error.Status == "" {
+			return fmt.Errorf("%w: %s", requestError, response.Status)
+		}
+		return fmt.Errorf("%w: %s: %s", requestError, errorBody.Error.Status, errorBody.Error.Message)
+	}
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,84 @@
+package gcp
+
+import (
+	"flag"
+	"os"
+)
+
+// MetadataHostEnv is the name of environment variable which overrides metadata server host, the same as used by
+// Google Cloud client libraries
+const MetadataHostEnv = "GCE_METADATA_HOST"
+
+// Default values of Cloud KMS options
+const (
+	DefaultEndpoint     = "https://cloudkms.googleapis.com"
+	DefaultMetadataHost = "metadata.google.internal"
+	DefaultKeyRing      = "acra"
+	DefaultKey          = "acra-master-key"
+)
+
+const projectFlag = "gcp_kms_project"
+
+// CLIOptions keep command-line options related to Cloud KMS ACRA_MASTER_KEY loading.
+type CLIOptions struct {
+	Project      string
+	Location     string
+	KeyRing      string
+	Key          string
+	KeyVersion   string
+	Endpoint     string
+	MetadataHost string
+}
+
+// RegisterCLIParametersWithFlagSet look up for gcp_kms_project, if none exists, Cloud KMS flags
+// will be added to provided flags.
+func RegisterCLIParametersWithFlagSet(flags *flag.FlagSet, prefix, description string) {
+	if description != "" {
+		description = " (" + description + ")"
+	}
+	if flags.Lookup(prefix+projectFlag) == nil {
+		flags.String(prefix+projectFlag, "", "Google Cloud project with Cloud KMS key ring"+description)
+		flags.String(prefix+"gcp_kms_location", "global", "Location of Cloud KMS key ring"+description)
+		flags.String(prefix+"gcp_kms_key_ring", DefaultKeyRing, "Name of Cloud KMS key ring"+description)
+		flags.String(prefix+"gcp_kms_key", DefaultKey, "Name of Cloud KMS key used to encrypt ACRA_MASTER_KEY"+description)
+		flags.String(prefix+"gcp_kms_key_version", "", "Version of Cloud KMS key used to encrypt new ACRA_MASTER_KEY, the primary version if empty"+description)
+		flags.String(prefix+"gcp_kms_endpoint", DefaultEndpoint, "Endpoint of Cloud KMS API"+description)
+		flags.String(prefix+"gcp_metadata_host", "", "Host of metadata server which issues access tokens with Workload Identity, "+MetadataHostEnv+" or "+DefaultMetadataHost+" if empty"+description)
+	}
+}
+
+// ParseCLIParametersFromFlags CLIOptions from provided FlagSet
+func ParseCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *CLIOptions {
+	options := CLIOptions{}
+	values := map[string]*string{
+		projectFlag:           &options.Project,
+		"gcp_kms_location":    &options.Location,
+		"gcp_kms_key_ring":    &options.KeyRing,
+		"gcp_kms_key":         &options.Key,
+		"gcp_kms_key_version": &options.KeyVersion,
+		"gcp_kms_endpoint":    &options.Endpoint,
+		"gcp_metadata_host":   &options.MetadataHost,
+	}
+	for name, value := range values {
+		if f := flags.Lookup(prefix + name); f != nil {
+			*value = f.Value.String()
+		}
+	}
+	return &options
+}
+
+// NewKMSClient create KMSClient from CLIOptions
+func (options *CLIOptions) NewKMSClient() (*KMSClient, error) {
+	metadataHost := options.MetadataHost
+	if metadataHost == "" {
+		metadataHost = os.Getenv(MetadataHostEnv)
+	}
+	keyName := KeyName{
+		Project:  options.Project,
+		Location: options.Location,
+		KeyRing:  options.KeyRing,
+		Key:      options.Key,
+		Version:  options.KeyVersion,
+	}
+	return NewKMSClient(keyName, ClientConfig{Endpoint: options.Endpoint, MetadataHost: metadataHost})
+}
@@ -0,0 +1,96 @@
+package gcp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"os"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// EncryptMasterKey encrypts new ACRA_MASTER_KEY with the key of Cloud KMS
+func EncryptMasterKey(client *KMSClient, masterKey []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+	defer cancel()
+	return client.Encrypt(ctx, masterKey)
+}
+
+// Loader is implementation of MasterKeyLoader which decrypts ACRA_MASTER_KEY with Cloud KMS
+type Loader struct {
+	client *KMSClient
+}
+
+// NewLoader create new Cloud KMS MasterKeyLoader
+func NewLoader(client *KMSClient) *Loader {
+	return &Loader{client: client}
+}
+
+// LoadMasterKey decrypt ACRA_MASTER_KEY for keystore v1 and validate it
+func (loader *Loader) LoadMasterKey() ([]byte, error) {
+	key, err := loader.decryptMasterKey()
+	if err != nil {
+		log.WithError(err).Warnf("Failed to decrypt %s with Cloud KMS", keystore.AcraMasterKeyVarName)
+		return nil, err
+	}
+	if err := keystore.ValidateMasterKey(key); err != nil {
+		log.WithError(err).Warn("Decrypted key is invalid")
+		return nil, err
+	}
+	log.Infoln("Using Cloud KMS for ACRA_MASTER_KEY loading...")
+	return key, nil
+}
+
+// LoadMasterKeys decrypt ACRA_MASTER_KEY for keystore v2 and validate it
+func (loader *Loader) LoadMasterKeys() ([]byte, []byte, error) {
+	rawKey, err := loader.decryptMasterKey()
+	if err != nil {
+		log.WithError(err).Warnf("Failed to decrypt %s with Cloud KMS", keystore.AcraMasterKeyVarName)
+		return nil, nil, err
+	}
+
+	keys := &keystoreV2.SerializedKeys{}
+	err = keys.Unmarshal(rawKey)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse decrypted key as SerializedKeys")
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare(keys.Encryption, keys.Signature) == 1 {
+		log.Warn("ACRA_MASTER_KEYs must not be the same")
+		return nil, nil, keystoreV2.ErrEqualMasterKeys
+	}
+
+	err = keystore.ValidateMasterKey(keys.Encryption)
+	if err != nil {
+		log.WithError(err).Warn("Invalid encryption key")
+		return nil, nil, err
+	}
+	err = keystore.ValidateMasterKey(keys.Signature)
+	if err != nil {
+		log.WithError(err).Warn("Invalid signature key")
+		return nil, nil, err
+	}
+	log.Infoln("Using Cloud KMS for ACRA_MASTER_KEY loading...")
+	return keys.Encryption, keys.Signature, nil
+}
+
+// decryptMasterKey reads ciphertext from ACRA_MASTER_KEY environment variable and decrypts it
+func (loader *Loader) decryptMasterKey() ([]byte, error) {
+	b64value := os.Getenv(keystore.AcraMasterKeyVarName)
+	if len(b64value) == 0 {
+		log.Warnf("%v environment variable is not set", keystore.AcraMasterKeyVarName)
+		return nil, keystore.ErrEmptyMasterKey
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(b64value)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to decode %s", keystore.AcraMasterKeyVarName)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+	defer cancel()
+	return loader.client.Decrypt(ctx, ciphertext)
+}
@@ -0,0 +1,147 @@
+package gcp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "test-token"
+
+var testKeyName = KeyName{Project: "project", Location: "global", KeyRing: DefaultKeyRing, Key: DefaultKey}
+
+// newTestKMS returns server which emulates metadata server and Cloud KMS. Data is "encrypted" with prepending of
+// used key version and reversing of bytes
+func newTestKMS(t *testing.T, corruptChecksum bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == metadataTokenPath {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(tokenResponse{AccessToken: testToken, ExpiresIn: 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		method := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch {
+		case method == testKeyName.CryptoKey()+":encrypt" || strings.HasPrefix(method, testKeyName.CryptoKey()+"/cryptoKeyVersions/") && strings.HasSuffix(method, ":encrypt"):
+			request := encryptRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			plaintext, _ := base64.StdEncoding.DecodeString(request.Plaintext)
+			version := "1"
+			if strings.Contains(method, "/cryptoKeyVersions/") {
+				version = strings.TrimSuffix(method[strings.LastIndex(method, "/")+1:], ":encrypt")
+			}
+			ciphertext := append([]byte(version+":"), reverse(plaintext)...)
+			json.NewEncoder(w).Encode(encryptResponse{
+				Name:                    testKeyName.CryptoKey() + "/cryptoKeyVersions/" + version,
+				Ciphertext:              base64.StdEncoding.EncodeToString(ciphertext),
+				CiphertextCrc32c:        checksum(ciphertext),
+				VerifiedPlaintextCrc32c: request.PlaintextCrc32c == checksum(plaintext),
+			})
+		case method == testKeyName.CryptoKey()+":decrypt":
+			request := decryptRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			ciphertext, _ := base64.StdEncoding.DecodeString(request.Ciphertext)
+			plaintext := reverse(ciphertext[strings.Index(string(ciphertext), ":")+1:])
+			response := decryptResponse{Plaintext: base64.StdEncoding.EncodeToString(plaintext), PlaintextCrc32c: checksum(plaintext)}
+			if corruptChecksum {
+				response.PlaintextCrc32c = "0"
+			}
+			json.NewEncoder(w).Encode(response)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"key not found","status":"NOT_FOUND"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func reverse(data []byte) []byte {
+	result := make([]byte, len(data))
+	for i := range data {
+		result[len(data)-1-i] = data[i]
+	}
+	return result
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, keyName KeyName) *KMSClient {
+	client, err := NewKMSClient(keyName, ClientConfig{Endpoint: server.URL, MetadataHost: server.URL})
+	assert.NoError(t, err)
+	return client
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	server := newTestKMS(t, false)
+	client := newTestClient(t, server, testKeyName)
+
+	masterKey, err := keystore.GenerateSymmetricKey()
+	assert.NoError(t, err)
+	ciphertext, err := EncryptMasterKey(client, masterKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, masterKey, ciphertext)
+
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(ciphertext))
+	loadedKey, err := NewLoader(client).LoadMasterKey()
+	assert.NoError(t, err)
+	assert.Equal(t, masterKey, loadedKey)
+}
+
+func TestLoadMasterKeysWithKeyVersion(t *testing.T) {
+	server := newTestKMS(t, false)
+	keyName := testKeyName
+	keyName.Version = "2"
+	client := newTestClient(t, server, keyName)
+
+	masterKeys, err := keystoreV2.NewSerializedMasterKeys()
+	assert.NoError(t, err)
+	ciphertext, err := EncryptMasterKey(client, masterKeys)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(ciphertext), "2:"))
+
+	// decryption doesn't depend on configured version
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(ciphertext))
+	encryption, signature, err := NewLoader(newTestClient(t, server, testKeyName)).LoadMasterKeys()
+	assert.NoError(t, err)
+
+	keys := &keystoreV2.SerializedKeys{}
+	assert.NoError(t, keys.Unmarshal(masterKeys))
+	assert.Equal(t, keys.Encryption, encryption)
+	assert.Equal(t, keys.Signature, signature)
+}
+
+func TestLoadMasterKeyErrors(t *testing.T) {
+	server := newTestKMS(t, false)
+
+	_, err := NewKMSClient(KeyName{Project: "project"}, ClientConfig{})
+	assert.ErrorIs(t, err, ErrEmptyKeyName)
+
+	t.Setenv(keystore.AcraMasterKeyVarName, "")
+	_, err = NewLoader(newTestClient(t, server, testKeyName)).LoadMasterKey()
+	assert.ErrorIs(t, err, keystore.ErrEmptyMasterKey)
+
+	masterKey, err := keystore.GenerateSymmetricKey()
+	assert.NoError(t, err)
+	unknownKey := testKeyName
+	unknownKey.Key = "unknown"
+	_, err = EncryptMasterKey(newTestClient(t, server, unknownKey), masterKey)
+	assert.ErrorIs(t, err, ErrKMSRequest)
+
+	ciphertext, err := EncryptMasterKey(newTestClient(t, server, testKeyName), masterKey)
+	assert.NoError(t, err)
+	t.Setenv(keystore.AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(ciphertext))
+	_, err = NewLoader(newTestClient(t, newTestKMS(t, true), testKeyName)).LoadMasterKey()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
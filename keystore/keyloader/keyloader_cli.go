@@ -12,6 +12,10 @@ const (
 	KeystoreStrategyKMSMasterKey            = "kms_encrypted_master_key"
 	KeystoreStrategyHashicorpVaultMasterKey = "vault_master_key"
 	KeystoreStrategyKMSPerClient            = "kms_per_client"
+	KeystoreStrategyAzureKeyVaultMasterKey  = "azure_keyvault"
+	KeystoreStrategyGCPKMSMasterKey         = "gcp_kms"
+	KeystoreStrategyPKCS11                  = "pkcs11"
+	KeystoreStrategyKubernetesSecret        = "kubernetes_secret"
 )
 
 // SupportedKeystoreStrategies contains all possible values for flag `--keystore_encryption_type`
@@ -20,6 +24,10 @@ var SupportedKeystoreStrategies = []string{
 	KeystoreStrategyKMSMasterKey,
 	KeystoreStrategyHashicorpVaultMasterKey,
 	KeystoreStrategyKMSPerClient,
+	KeystoreStrategyAzureKeyVaultMasterKey,
+	KeystoreStrategyGCPKMSMasterKey,
+	KeystoreStrategyPKCS11,
+	KeystoreStrategyKubernetesSecret,
 }
 
 // CLIOptions keep command-line options related to KMS ACRA_MASTER_KEY loading.
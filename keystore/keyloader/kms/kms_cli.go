@@ -4,7 +4,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cossacklabs/acra/keystore/kms/base"
 	log "github.com/sirupsen/logrus"
@@ -36,6 +38,7 @@ var SupportedPolicies = []string{
 type CLIOptions struct {
 	KMSType         string
 	CredentialsPath string
+	Cache           base.CacheOptions
 }
 
 // RegisterCLIParametersWithFlags register kms related flags
@@ -46,6 +49,9 @@ func RegisterCLIParametersWithFlags(flags *flag.FlagSet, prefix string, descript
 	if flags.Lookup(prefix+"kms_type") == nil {
 		flags.String(prefix+"kms_type", "", fmt.Sprintf("KMS type for using: <%s>", strings.Join(supportedTypes, "|")+description))
 		flags.String(prefix+"kms_credentials_path", "", "KMS credentials JSON file path"+description)
+		flags.Duration(prefix+"kms_cache_ttl", 0, "Time of storing keys decrypted by KMS in memory for kms_per_client strategy (e.g. 5m). 0 - disabled"+description)
+		flags.Duration(prefix+"kms_cache_negative_ttl", 0, "Time of storing KMS decryption errors in memory, so invalid keys don't hit KMS on every request (e.g. 30s). 0 - disabled"+description)
+		flags.Int(prefix+"kms_cache_size", base.DefaultCacheSize, "Maximum number of keys decrypted by KMS stored in memory. 0 - no limits"+description)
 	}
 }
 
@@ -64,9 +70,30 @@ func ParseCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *CLIOptions
 	if f := flags.Lookup(prefix + "kms_credentials_path"); f != nil {
 		options.CredentialsPath = f.Value.String()
 	}
+	if f := flags.Lookup(prefix + "kms_cache_ttl"); f != nil {
+		options.Cache.TTL = parseDurationFlag(f)
+	}
+	if f := flags.Lookup(prefix + "kms_cache_negative_ttl"); f != nil {
+		options.Cache.NegativeTTL = parseDurationFlag(f)
+	}
+	if f := flags.Lookup(prefix + "kms_cache_size"); f != nil {
+		size, err := strconv.Atoi(f.Value.String())
+		if err != nil {
+			log.WithField("value", f.Value.String()).Fatalf("Can't cast %s to integer value", f.Name)
+		}
+		options.Cache.Size = size
+	}
 	return &options
 }
 
+func parseDurationFlag(f *flag.Flag) time.Duration {
+	value, err := time.ParseDuration(f.Value.String())
+	if err != nil {
+		log.WithField("value", f.Value.String()).Fatalf("Can't cast %s to duration value", f.Name)
+	}
+	return value
+}
+
 // NewKeyManager create kms.KeyManager from kms.CLIOptions
 func NewKeyManager(options *CLIOptions) (base.KeyManager, error) {
 	createKeyManager, ok := base.GetKeyManagerCreator(options.KMSType)
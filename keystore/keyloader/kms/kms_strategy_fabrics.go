@@ -76,7 +76,17 @@ func (k PerClientKeyEncryptorFabric) NewKeyEncryptor(flags *flag.FlagSet, prefix
 		return nil, err
 	}
 
-	return baseKMS.NewKeyEncryptor(keyManager, k.GetKeyMapper()), nil
+	return baseKMS.NewKeyEncryptor(newCachedEncryptor(keyManager, kmsOptions), k.GetKeyMapper()), nil
+}
+
+// newCachedEncryptor wraps KeyManager with decryption cache if it is enabled
+func newCachedEncryptor(keyManager baseKMS.KeyManager, kmsOptions *CLIOptions) baseKMS.Encryptor {
+	if kmsOptions.Cache.TTL <= 0 && kmsOptions.Cache.NegativeTTL <= 0 {
+		return keyManager
+	}
+	log.WithField("ttl", kmsOptions.Cache.TTL).WithField("negative_ttl", kmsOptions.Cache.NegativeTTL).
+		WithField("size", kmsOptions.Cache.Size).Infoln("Using cache of KMS decrypted keys")
+	return baseKMS.NewCachedEncryptor(keyManager, kmsOptions.Cache)
 }
 
 // NewKeyEncryptorSuite fabric of crypto.KeyStoreSuite for `kms_per_client` strategy
@@ -96,7 +106,7 @@ func (k PerClientKeyEncryptorFabric) NewKeyEncryptorSuite(flags *flag.FlagSet, p
 		return nil, err
 	}
 
-	return crypto.NewSCellSuiteWithEncryptor(baseKMS.NewKeyEncryptor(keyManager, k.GetKeyMapper()), signature)
+	return crypto.NewSCellSuiteWithEncryptor(baseKMS.NewKeyEncryptor(newCachedEncryptor(keyManager, kmsOptions), k.GetKeyMapper()), signature)
 }
 
 // RegisterCLIParameters empty implementation of KMSMasterKeyKeyEncryptorFabric interface
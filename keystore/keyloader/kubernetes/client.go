@@ -0,0 +1,233 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Paths of service account credentials mounted into every pod
+const (
+	ServiceAccountTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	ServiceAccountCAPath        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	ServiceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// set of predefined errors used in Kubernetes API client
+var (
+	ErrNotInCluster    = errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set, not running in Kubernetes cluster")
+	ErrInvalidCA       = errors.New("can't parse CA certificate of Kubernetes API server")
+	ErrAPIRequest      = errors.New("Kubernetes API request failed")
+	ErrWatchExpired    = errors.New("watch of Kubernetes secret expired")
+	ErrSecretNotExists = errors.New("Kubernetes secret doesn't exist")
+)
+
+// Event types of watch API
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+	EventError    = "ERROR"
+)
+
+// ClientConfig defines connection to Kubernetes API server
+type ClientConfig struct {
+	// APIServerURL like https://10.0.0.1:443
+	APIServerURL string
+	// TokenPath of service account token, it is read before every request because tokens are rotated
+	TokenPath  string
+	HTTPClient *http.Client
+}
+
+// Secret is a subset of Kubernetes Secret object
+type Secret struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// status is Kubernetes Status object returned with errors
+type status struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
+
+// Client reads secrets with Kubernetes API
+type Client struct {
+	config     ClientConfig
+	httpClient *http.Client
+}
+
+// NewClient returns client for Kubernetes API
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.TokenPath == "" {
+		config.TokenPath = ServiceAccountTokenPath
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	config.APIServerURL = strings.TrimSuffix(config.APIServerURL, "/")
+	return &Client{config: config, httpClient: httpClient}, nil
+}
+
+// NewInClusterClient returns client configured with service account of the pod
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	caCert, err := os.ReadFile(ServiceAccountCAPath)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, ErrInvalidCA
+	}
+	httpClient := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: caPool, MinVersion: tls.VersionTLS12},
+	}}
+	return NewClient(ClientConfig{
+		APIServerURL: "https://" + net.JoinHostPort(host, port),
+		TokenPath:    ServiceAccountTokenPath,
+		HTTPClient:   httpClient,
+	})
+}
+
+// InClusterNamespace returns namespace of the pod
+func InClusterNamespace() (string, error) {
+	namespace, err := os.ReadFile(ServiceAccountNamespacePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+func (client *Client) newRequest(ctx context.Context, path string, query url.Values) (*http.Request, error) {
+	token, err := os.ReadFile(client.config.TokenPath)
+	if err != nil {
+		return nil, err
+	}
+	requestURL := client.config.APIServerURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	return request, nil
+}
+
+func secretsPath(namespace string) string {
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets"
+}
+
+// GetSecret returns secret by name
+func (client *Client) GetSecret(ctx context.Context, namespace, name string) (*Secret, error) {
+	request, err := client.newRequest(ctx, secretsPath(namespace)+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if err := checkResponse(response); err != nil {
+		return nil, err
+	}
+	secret := &Secret{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1024*1024)).Decode(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// WatchSecret watches changes of the secret after resourceVersion and calls onEvent for every change until
+// the stream is closed by server, ctx is done or watch is expired
+func (client *Client) WatchSecret(ctx context.Context, namespace, name, resourceVersion string, onEvent func(eventType string, secret *Secret)) error {
+	query := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + name},
+		"resourceVersion": {resourceVersion},
+	}
+	request, err := client.newRequest(ctx, secretsPath(namespace), query)
+	if err != nil {
+		return err
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := checkResponse(response); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		event := watchEvent{}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch event.Type {
+		case EventAdded, EventModified, EventDeleted:
+			secret := &Secret{}
+			if err := json.Unmarshal(event.Object, secret); err != nil {
+				return err
+			}
+			onEvent(event.Type, secret)
+		case EventError:
+			// usually 410 Gone when resourceVersion is too old
+			return ErrWatchExpired
+		}
+	}
+}
+
+func checkResponse(response *http.Response) error {
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return ErrSecretNotExists
+	}
+	body := status{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1024*1024)).Decode(&body); err != nil || body.Message == "" {
+		return fmt.Errorf("%w: %s", ErrAPIRequest, response.Status)
+	}
+	return fmt.Errorf("%w: %s: %s", ErrAPIRequest, body.Reason, body.Message)
+}
+
+// watchBackoff returns delay before next attempt to watch secret after number of failed attempts
+func watchBackoff(attempt int) time.Duration {
+	if attempt > 5 {
+		return 30 * time.Second
+	}
+	return time.Second << uint(attempt)
+}
@@ -0,0 +1,172 @@
+package kubernetes
+
+import (
+	"context"
+	encodingASN1 "encoding/asn1"
+	"flag"
+	"sync"
+
+	"github.com/cossacklabs/acra/keystore"
+	baseKMS "github.com/cossacklabs/acra/keystore/kms/base"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/crypto"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/signature"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReloadableKeyEncryptor is keystore.KeyEncryptor which master key may be replaced at runtime
+type ReloadableKeyEncryptor struct {
+	lock      sync.RWMutex
+	encryptor keystore.KeyEncryptor
+}
+
+// Encrypt return key encrypted with current master key.
+func (e *ReloadableKeyEncryptor) Encrypt(ctx context.Context, key []byte, keyContext keystore.KeyContext) ([]byte, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.encryptor.Encrypt(ctx, key, keyContext)
+}
+
+// Decrypt return key decrypted with current master key.
+func (e *ReloadableKeyEncryptor) Decrypt(ctx context.Context, key []byte, keyContext keystore.KeyContext) ([]byte, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.encryptor.Decrypt(ctx, key, keyContext)
+}
+
+// Set replaces encryptor used for next operations
+func (e *ReloadableKeyEncryptor) Set(encryptor keystore.KeyEncryptor) {
+	e.lock.Lock()
+	e.encryptor = encryptor
+	e.lock.Unlock()
+}
+
+// ReloadableSignature is signature.Algorithm which signature key may be replaced at runtime
+type ReloadableSignature struct {
+	lock      sync.RWMutex
+	algorithm signature.Algorithm
+}
+
+// AlgorithmOID returns ASN.1 OID of current algorithm.
+func (s *ReloadableSignature) AlgorithmOID() encodingASN1.ObjectIdentifier {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.algorithm.AlgorithmOID()
+}
+
+// Sign provided data in given context with current key.
+func (s *ReloadableSignature) Sign(data, context []byte) []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.algorithm.Sign(data, context)
+}
+
+// Verify that signature matches data in given context with current key.
+func (s *ReloadableSignature) Verify(signature, data, context []byte) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.algorithm.Verify(signature, data, context)
+}
+
+// Set replaces algorithm used for next operations
+func (s *ReloadableSignature) Set(algorithm signature.Algorithm) {
+	s.lock.Lock()
+	s.algorithm = algorithm
+	s.lock.Unlock()
+}
+
+// KeyEncryptorFabric implementation of keyloader.KeyEncryptorFabric for `kubernetes_secret` strategy
+type KeyEncryptorFabric struct{}
+
+func newLoader(flags *flag.FlagSet, prefix string) (*Loader, *CLIOptions, error) {
+	options := ParseCLIParametersFromFlags(flags, prefix)
+	loader, err := options.NewLoader()
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialize Kubernetes API client")
+		return nil, nil, err
+	}
+	return loader, options, nil
+}
+
+// NewKeyEncryptor fabric of keystore.KeyEncryptor for `kubernetes_secret` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptor(flags *flag.FlagSet, prefix string) (keystore.KeyEncryptor, error) {
+	loader, options, err := newLoader(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := loader.LoadMasterKey()
+	if err != nil {
+		log.WithError(err).Errorln("Cannot load master key")
+		return nil, err
+	}
+	encryptor, err := keystore.NewSCellKeyEncryptor(key)
+	if err != nil {
+		return nil, err
+	}
+	reloadable := &ReloadableKeyEncryptor{encryptor: encryptor}
+	if options.WatchEnable {
+		go loader.Watch(context.Background(), func(value []byte) {
+			key, err := ParseMasterKey(value)
+			if err != nil {
+				log.WithError(err).Errorln("Ignore invalid master key from changed Kubernetes secret")
+				return
+			}
+			encryptor, err := keystore.NewSCellKeyEncryptor(key)
+			if err != nil {
+				log.WithError(err).Errorln("Can't create key encryptor with reloaded master key")
+				return
+			}
+			reloadable.Set(encryptor)
+			log.Infoln("Reloaded ACRA_MASTER_KEY from Kubernetes secret")
+		})
+	}
+	return reloadable, nil
+}
+
+// NewKeyEncryptorSuite fabric of crypto.KeyStoreSuite for `kubernetes_secret` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptorSuite(flags *flag.FlagSet, prefix string) (*crypto.KeyStoreSuite, error) {
+	loader, options, err := newLoader(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+	encryption, signatureKey, err := loader.LoadMasterKeys()
+	if err != nil {
+		log.WithError(err).Errorln("Cannot load master keys")
+		return nil, err
+	}
+	suite, err := keystoreV2.NewSCellSuite(encryption, signatureKey)
+	if err != nil {
+		return nil, err
+	}
+	if !options.WatchEnable {
+		return suite, nil
+	}
+	reloadableEncryptor := &ReloadableKeyEncryptor{encryptor: suite.KeyEncryptor}
+	reloadableSignature := &ReloadableSignature{algorithm: suite.SignatureAlgorithms[0]}
+	go loader.Watch(context.Background(), func(value []byte) {
+		encryption, signatureKey, err := ParseMasterKeys(value)
+		if err != nil {
+			log.WithError(err).Errorln("Ignore invalid master keys from changed Kubernetes secret")
+			return
+		}
+		suite, err := keystoreV2.NewSCellSuite(encryption, signatureKey)
+		if err != nil {
+			log.WithError(err).Errorln("Can't create keystore suite with reloaded master keys")
+			return
+		}
+		reloadableEncryptor.Set(suite.KeyEncryptor)
+		reloadableSignature.Set(suite.SignatureAlgorithms[0])
+		log.Infoln("Reloaded ACRA_MASTER_KEY from Kubernetes secret")
+	})
+	return &crypto.KeyStoreSuite{KeyEncryptor: reloadableEncryptor, SignatureAlgorithms: []signature.Algorithm{reloadableSignature}}, nil
+}
+
+// RegisterCLIParameters register Kubernetes secret flags
+func (k KeyEncryptorFabric) RegisterCLIParameters(flags *flag.FlagSet, prefix, description string) {
+	RegisterCLIParametersWithFlagSet(flags, prefix, description)
+}
+
+// GetKeyMapper return KeyMapper for `kubernetes_secret` strategy
+func (k KeyEncryptorFabric) GetKeyMapper() baseKMS.KeyMapper {
+	panic("No KeyMapper for kubernetes_secret strategy")
+}
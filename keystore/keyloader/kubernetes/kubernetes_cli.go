@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"flag"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Default values of Kubernetes secret options
+const (
+	DefaultSecretName = "acra-master-key"
+	DefaultSecretKey  = "ACRA_MASTER_KEY"
+)
+
+const secretNameFlag = "kubernetes_secret_name"
+
+// CLIOptions keep command-line options related to Kubernetes secret ACRA_MASTER_KEY loading.
+type CLIOptions struct {
+	SecretName      string
+	SecretNamespace string
+	SecretKey       string
+	WatchEnable     bool
+}
+
+// RegisterCLIParametersWithFlagSet look up for kubernetes_secret_name, if none exists, Kubernetes secret flags
+// will be added to provided flags.
+func RegisterCLIParametersWithFlagSet(flags *flag.FlagSet, prefix, description string) {
+	if description != "" {
+		description = " (" + description + ")"
+	}
+	if flags.Lookup(prefix+secretNameFlag) == nil {
+		flags.String(prefix+secretNameFlag, DefaultSecretName, "Name of Kubernetes secret with ACRA_MASTER_KEY"+description)
+		flags.String(prefix+"kubernetes_secret_namespace", "", "Namespace of Kubernetes secret with ACRA_MASTER_KEY, namespace of the pod if empty"+description)
+		flags.String(prefix+"kubernetes_secret_key", DefaultSecretKey, "Key of ACRA_MASTER_KEY in data of Kubernetes secret"+description)
+		flags.Bool(prefix+"kubernetes_secret_watch_enable", true, "Watch Kubernetes secret and reload ACRA_MASTER_KEY on its change"+description)
+	}
+}
+
+// ParseCLIParametersFromFlags CLIOptions from provided FlagSet
+func ParseCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *CLIOptions {
+	options := CLIOptions{}
+	if f := flags.Lookup(prefix + secretNameFlag); f != nil {
+		options.SecretName = f.Value.String()
+	}
+	if f := flags.Lookup(prefix + "kubernetes_secret_namespace"); f != nil {
+		options.SecretNamespace = f.Value.String()
+	}
+	if f := flags.Lookup(prefix + "kubernetes_secret_key"); f != nil {
+		options.SecretKey = f.Value.String()
+	}
+	if f := flags.Lookup(prefix + "kubernetes_secret_watch_enable"); f != nil {
+		v, err := strconv.ParseBool(f.Value.String())
+		if err != nil {
+			log.WithField("value", f.Value.String()).Fatalf("Can't cast %s to boolean value", f.Name)
+		}
+		options.WatchEnable = v
+	}
+	return &options
+}
+
+// NewLoader create Loader with in-cluster Kubernetes API client
+func (options *CLIOptions) NewLoader() (*Loader, error) {
+	client, err := NewInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace := options.SecretNamespace
+	if namespace == "" {
+		namespace, err = InClusterNamespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewLoader(client, namespace, options.SecretName, options.SecretKey), nil
+}
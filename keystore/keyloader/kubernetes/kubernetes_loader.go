@@ -0,0 +1,158 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSecretKeyNotFound returned if the secret doesn't have data with configured key
+var ErrSecretKeyNotFound = errors.New("Kubernetes secret doesn't contain master key")
+
+// Loader is implementation of MasterKeyLoader which reads ACRA_MASTER_KEY from Kubernetes secret. Value of the
+// secret has the same base64 format as ACRA_MASTER_KEY environment variable.
+type Loader struct {
+	client    *Client
+	namespace string
+	name      string
+	key       string
+	// loaded is the value of master key returned by the last Load call, Watch reports changes relative to it
+	loaded []byte
+}
+
+// NewLoader create new Kubernetes secret MasterKeyLoader
+func NewLoader(client *Client, namespace, name, key string) *Loader {
+	return &Loader{client: client, namespace: namespace, name: name, key: key}
+}
+
+// LoadMasterKey read ACRA_MASTER_KEY for keystore v1 from the secret and validate it
+func (loader *Loader) LoadMasterKey() ([]byte, error) {
+	value, _, err := loader.readSecret()
+	if err != nil {
+		return nil, err
+	}
+	loader.loaded = value
+	log.Infoln("Using Kubernetes secret for ACRA_MASTER_KEY loading...")
+	return ParseMasterKey(value)
+}
+
+// LoadMasterKeys read ACRA_MASTER_KEY for keystore v2 from the secret and validate it
+func (loader *Loader) LoadMasterKeys() ([]byte, []byte, error) {
+	value, _, err := loader.readSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	loader.loaded = value
+	log.Infoln("Using Kubernetes secret for ACRA_MASTER_KEY loading...")
+	return ParseMasterKeys(value)
+}
+
+// readSecret returns value of master key and resource version of the secret
+func (loader *Loader) readSecret() ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultNetworkTimeout)
+	defer cancel()
+	secret, err := loader.client.GetSecret(ctx, loader.namespace, loader.name)
+	if err != nil {
+		log.WithError(err).WithField("namespace", loader.namespace).WithField("secret", loader.name).Warnln("Failed to read Kubernetes secret")
+		return nil, "", err
+	}
+	value, ok := secret.Data[loader.key]
+	if !ok || len(value) == 0 {
+		log.WithField("namespace", loader.namespace).WithField("secret", loader.name).WithField("key", loader.key).Warnln("Kubernetes secret doesn't contain master key")
+		return nil, "", ErrSecretKeyNotFound
+	}
+	return value, secret.Metadata.ResourceVersion, nil
+}
+
+// Watch calls onChange with new value of master key every time the secret is changed after the last Load call
+// until ctx is done. Watch is restarted with backoff if connection to API server is lost.
+func (loader *Loader) Watch(ctx context.Context, onChange func(value []byte)) {
+	logger := log.WithField("namespace", loader.namespace).WithField("secret", loader.name)
+	lastValue := loader.loaded
+	attempt := 0
+	for ctx.Err() == nil {
+		value, resourceVersion, err := loader.readSecret()
+		if err == nil {
+			if lastValue != nil && !bytes.Equal(lastValue, value) {
+				onChange(value)
+			}
+			lastValue = value
+			err = loader.client.WatchSecret(ctx, loader.namespace, loader.name, resourceVersion, func(eventType string, secret *Secret) {
+				value, ok := secret.Data[loader.key]
+				if eventType == EventDeleted || !ok || len(value) == 0 {
+					// keep using last key, keystore can't work without it
+					logger.Warnln("Kubernetes secret with master key was deleted or doesn't contain it")
+					return
+				}
+				if !bytes.Equal(lastValue, value) {
+					lastValue = value
+					onChange(value)
+				}
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// server closes watch after timeout, it is restarted with minimal delay and current state of the secret
+		if err == nil || err == ErrWatchExpired {
+			attempt = 0
+		} else {
+			attempt++
+			logger.WithError(err).WithField("retry_in", watchBackoff(attempt)).Warnln("Failed to watch Kubernetes secret")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchBackoff(attempt)):
+		}
+	}
+}
+
+// ParseMasterKey decodes and validates ACRA_MASTER_KEY for keystore v1
+func ParseMasterKey(value []byte) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(value)))
+	if err != nil {
+		log.WithError(err).Warnln("Failed to decode master key from Kubernetes secret")
+		return nil, err
+	}
+	if err := keystore.ValidateMasterKey(key); err != nil {
+		log.WithError(err).Warnln("Invalid master key in Kubernetes secret")
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseMasterKeys decodes and validates ACRA_MASTER_KEY for keystore v2
+func ParseMasterKeys(value []byte) ([]byte, []byte, error) {
+	rawKeys, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(value)))
+	if err != nil {
+		log.WithError(err).Warnln("Failed to decode master keys from Kubernetes secret")
+		return nil, nil, err
+	}
+	keys := &keystoreV2.SerializedKeys{}
+	if err := keys.Unmarshal(rawKeys); err != nil {
+		log.WithError(err).Warnln("Failed to parse master keys from Kubernetes secret")
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(keys.Encryption, keys.Signature) == 1 {
+		log.Warn("ACRA_MASTER_KEYs must not be the same")
+		return nil, nil, keystoreV2.ErrEqualMasterKeys
+	}
+	if err := keystore.ValidateMasterKey(keys.Encryption); err != nil {
+		log.WithError(err).Warn("Invalid encryption key")
+		return nil, nil, err
+	}
+	if err := keystore.ValidateMasterKey(keys.Signature); err != nil {
+		log.WithError(err).Warn("Invalid signature key")
+		return nil, nil, err
+	}
+	return keys.Encryption, keys.Signature, nil
+}
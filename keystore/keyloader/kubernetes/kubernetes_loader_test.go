@@ -0,0 +1,177 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+	keystoreV2 "github.com/cossacklabs/acra/keystore/v2/keystore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testToken     = "test-token"
+	testNamespace = "acra"
+)
+
+// newTestAPIServer returns server which emulates Kubernetes API with single secret. Watch requests receive events
+// from the channel until it is closed
+func newTestAPIServer(t *testing.T, secret *Secret, events <-chan watchEvent) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(status{Message: "Unauthorized", Reason: "Unauthorized", Code: http.StatusUnauthorized})
+			return
+		}
+		switch r.URL.Path {
+		case secretsPath(testNamespace) + "/" + secret.Metadata.Name:
+			json.NewEncoder(w).Encode(secret)
+		case secretsPath(testNamespace):
+			assert.Equal(t, "true", r.URL.Query().Get("watch"))
+			assert.Equal(t, "metadata.name="+secret.Metadata.Name, r.URL.Query().Get("fieldSelector"))
+			assert.Equal(t, secret.Metadata.ResourceVersion, r.URL.Query().Get("resourceVersion"))
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for event := range events {
+				json.NewEncoder(w).Encode(event)
+				w.(http.Flusher).Flush()
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func newTestClient(t *testing.T, serverURL string) *Client {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte(testToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(ClientConfig{APIServerURL: serverURL, TokenPath: tokenPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func newTestSecret(value []byte) *Secret {
+	secret := &Secret{Data: map[string][]byte{DefaultSecretKey: value}}
+	secret.Metadata.Name = DefaultSecretName
+	secret.Metadata.Namespace = testNamespace
+	secret.Metadata.ResourceVersion = "1"
+	return secret
+}
+
+func newEvent(t *testing.T, eventType string, secret *Secret) watchEvent {
+	object, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watchEvent{Type: eventType, Object: object}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestAPIServer(t, newTestSecret([]byte(base64.StdEncoding.EncodeToString(masterKey))), nil)
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	loaded, err := NewLoader(client, testNamespace, DefaultSecretName, DefaultSecretKey).LoadMasterKey()
+	assert.NoError(t, err)
+	assert.Equal(t, masterKey, loaded)
+
+	_, err = NewLoader(client, testNamespace, DefaultSecretName, "unknown").LoadMasterKey()
+	assert.ErrorIs(t, err, ErrSecretKeyNotFound)
+
+	_, err = NewLoader(client, testNamespace, "unknown", DefaultSecretKey).LoadMasterKey()
+	assert.ErrorIs(t, err, ErrSecretNotExists)
+
+	client.config.TokenPath = filepath.Join(t.TempDir(), "invalid")
+	os.WriteFile(client.config.TokenPath, []byte("invalid"), 0600)
+	_, err = NewLoader(client, testNamespace, DefaultSecretName, DefaultSecretKey).LoadMasterKey()
+	assert.ErrorIs(t, err, ErrAPIRequest)
+}
+
+func TestLoadMasterKeys(t *testing.T) {
+	encryption, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := (&keystoreV2.SerializedKeys{Encryption: encryption, Signature: signature}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestAPIServer(t, newTestSecret([]byte(base64.StdEncoding.EncodeToString(serialized))), nil)
+	defer server.Close()
+
+	loader := NewLoader(newTestClient(t, server.URL), testNamespace, DefaultSecretName, DefaultSecretKey)
+	loadedEncryption, loadedSignature, err := loader.LoadMasterKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, encryption, loadedEncryption)
+	assert.Equal(t, signature, loadedSignature)
+}
+
+func TestParseMasterKey(t *testing.T) {
+	_, err := ParseMasterKey([]byte("not base64"))
+	assert.Error(t, err)
+	_, err = ParseMasterKey([]byte(base64.StdEncoding.EncodeToString([]byte("short"))))
+	assert.ErrorIs(t, err, keystore.ErrMasterKeyIncorrectLength)
+	_, _, err = ParseMasterKeys([]byte(base64.StdEncoding.EncodeToString([]byte("not serialized keys"))))
+	assert.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	oldKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldValue := []byte(base64.StdEncoding.EncodeToString(oldKey))
+	newValue := []byte(base64.StdEncoding.EncodeToString(newKey))
+	secret := newTestSecret(oldValue)
+	events := make(chan watchEvent, 4)
+	server := newTestAPIServer(t, secret, events)
+	defer server.Close()
+
+	loader := NewLoader(newTestClient(t, server.URL), testNamespace, DefaultSecretName, DefaultSecretKey)
+	_, err = loader.LoadMasterKey()
+	assert.NoError(t, err)
+
+	changes := make(chan []byte, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go loader.Watch(ctx, func(value []byte) {
+		changes <- value
+	})
+
+	// same value and deletion don't change the key
+	events <- newEvent(t, EventModified, newTestSecret(oldValue))
+	events <- newEvent(t, EventDeleted, newTestSecret(nil))
+	events <- newEvent(t, EventModified, newTestSecret(newValue))
+	select {
+	case value := <-changes:
+		assert.Equal(t, newValue, value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Change of the secret wasn't observed")
+	}
+	assert.Empty(t, changes)
+	cancel()
+	close(events)
+}
@@ -0,0 +1,165 @@
+package pkcs11
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	encodingASN1 "encoding/asn1"
+	"errors"
+	"flag"
+
+	"github.com/cossacklabs/acra/keystore"
+	baseKMS "github.com/cossacklabs/acra/keystore/kms/base"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/asn1"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/crypto"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/signature"
+	log "github.com/sirupsen/logrus"
+)
+
+const gcmIVLength = 12
+
+// ErrInvalidEncryptedKey returned if encrypted key is too short to contain IV and authentication tag
+var ErrInvalidEncryptedKey = errors.New("invalid key encrypted with PKCS#11 token")
+
+// same separator as used by crypto.SignSha256, so signatures don't depend on the place where HMAC key is stored
+var separator = []byte(": ")
+
+// KeyEncryptor implementation of keystore.KeyEncryptor which encrypts keys with AES-GCM by PKCS#11 token.
+// Key context is used as associated data. Encrypted key is IV followed by ciphertext with authentication tag.
+type KeyEncryptor struct {
+	token *Token
+	key   ObjectHandle
+}
+
+// NewKeyEncryptor create new KeyEncryptor with AES key stored on the token
+func NewKeyEncryptor(token *Token, keyLabel string) (*KeyEncryptor, error) {
+	key, err := token.FindSecretKey(keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyEncryptor{token: token, key: key}, nil
+}
+
+// Encrypt return key encrypted by PKCS#11 token with context.
+func (encryptor *KeyEncryptor) Encrypt(ctx context.Context, key []byte, keyContext keystore.KeyContext) ([]byte, error) {
+	iv := make([]byte, gcmIVLength)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptor.token.EncryptAESGCM(encryptor.key, iv, keystore.GetKeyContextFromContext(keyContext), key)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ciphertext...), nil
+}
+
+// Decrypt return key decrypted by PKCS#11 token with context.
+func (encryptor *KeyEncryptor) Decrypt(ctx context.Context, key []byte, keyContext keystore.KeyContext) ([]byte, error) {
+	if len(key) < gcmIVLength+gcmTagLength {
+		return nil, ErrInvalidEncryptedKey
+	}
+	return encryptor.token.DecryptAESGCM(encryptor.key, key[:gcmIVLength], keystore.GetKeyContextFromContext(keyContext), key[gcmIVLength:])
+}
+
+// SignSha256 computes HMAC-SHA-256 signatures of keystore v2 with generic secret key stored on PKCS#11 token.
+type SignSha256 struct {
+	token *Token
+	key   ObjectHandle
+}
+
+// NewSignSha256 create new signature algorithm with HMAC key stored on the token
+func NewSignSha256(token *Token, keyLabel string) (*SignSha256, error) {
+	key, err := token.FindSecretKey(keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return &SignSha256{token: token, key: key}, nil
+}
+
+// AlgorithmOID returns ASN.1 OID for this algorithm.
+func (s *SignSha256) AlgorithmOID() encodingASN1.ObjectIdentifier {
+	return asn1.Sha256OID
+}
+
+// Sign provided data in given context. Returns nil if token failed to compute signature.
+func (s *SignSha256) Sign(data, context []byte) []byte {
+	message := make([]byte, 0, len(context)+len(separator)+len(data))
+	message = append(append(append(message, context...), separator...), data...)
+	result, err := s.token.HMACSHA256(s.key, message)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to compute signature with PKCS#11 token")
+		return nil
+	}
+	return result
+}
+
+// Verify that signature matches data in given context.
+func (s *SignSha256) Verify(signature, data, context []byte) bool {
+	expected := s.Sign(data, context)
+	if expected == nil {
+		return false
+	}
+	// Use constant-time comparison to mitigate side-channel attacks.
+	return subtle.ConstantTimeCompare(expected, signature) == 1
+}
+
+// KeyEncryptorFabric implementation of keyloader.KeyEncryptorFabric for `pkcs11` strategy
+type KeyEncryptorFabric struct{}
+
+func openToken(flags *flag.FlagSet, prefix string) (*Token, *CLIOptions, error) {
+	options := ParseCLIParametersFromFlags(flags, prefix)
+	token, err := options.OpenToken()
+	if err != nil {
+		log.WithError(err).WithField("module", options.ModulePath).WithField("token", options.TokenLabel).Errorln("Can't open PKCS#11 token")
+		return nil, nil, err
+	}
+	return token, options, nil
+}
+
+// NewKeyEncryptor fabric of keystore.KeyEncryptor for `pkcs11` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptor(flags *flag.FlagSet, prefix string) (keystore.KeyEncryptor, error) {
+	token, options, err := openToken(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := NewKeyEncryptor(token, options.EncryptionKeyLabel)
+	if err != nil {
+		log.WithError(err).WithField("label", options.EncryptionKeyLabel).Errorln("Can't find encryption key on PKCS#11 token")
+		token.Close()
+		return nil, err
+	}
+	log.Infoln("Using PKCS#11 token for keystore keys encryption...")
+	return encryptor, nil
+}
+
+// NewKeyEncryptorSuite fabric of crypto.KeyStoreSuite for `pkcs11` strategy
+func (k KeyEncryptorFabric) NewKeyEncryptorSuite(flags *flag.FlagSet, prefix string) (*crypto.KeyStoreSuite, error) {
+	token, options, err := openToken(flags, prefix)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := NewKeyEncryptor(token, options.EncryptionKeyLabel)
+	if err != nil {
+		log.WithError(err).WithField("label", options.EncryptionKeyLabel).Errorln("Can't find encryption key on PKCS#11 token")
+		token.Close()
+		return nil, err
+	}
+	signer, err := NewSignSha256(token, options.SignatureKeyLabel)
+	if err != nil {
+		log.WithError(err).WithField("label", options.SignatureKeyLabel).Errorln("Can't find signature key on PKCS#11 token")
+		token.Close()
+		return nil, err
+	}
+	log.Infoln("Using PKCS#11 token for keystore keys encryption...")
+	return &crypto.KeyStoreSuite{KeyEncryptor: encryptor, SignatureAlgorithms: []signature.Algorithm{signer}}, nil
+}
+
+// RegisterCLIParameters register PKCS#11 flags
+func (k KeyEncryptorFabric) RegisterCLIParameters(flags *flag.FlagSet, prefix, description string) {
+	RegisterCLIParametersWithFlagSet(flags, prefix, description)
+}
+
+// GetKeyMapper return KeyMapper for `pkcs11` strategy
+func (k KeyEncryptorFabric) GetKeyMapper() baseKMS.KeyMapper {
+	panic("No KeyMapper for pkcs11 strategy")
+}
@@ -0,0 +1,90 @@
+package pkcs11
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/crypto"
+)
+
+// openTestToken opens token configured by environment, for example SoftHSM token initialized with:
+//
+//	softhsm2-util --init-token --free --label acra --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $TEST_PKCS11_MODULE --token-label acra --login --pin 1234 --keygen --key-type AES:32 --label acra-keystore-encryption
+//	pkcs11-tool --module $TEST_PKCS11_MODULE --token-label acra --login --pin 1234 --keygen --key-type GENERIC:32 --label acra-keystore-signature
+func openTestToken(t *testing.T) *Token {
+	modulePath := os.Getenv("TEST_PKCS11_MODULE")
+	if modulePath == "" {
+		t.Skip("TEST_PKCS11_MODULE is not set")
+	}
+	token, err := OpenToken(modulePath, os.Getenv("TEST_PKCS11_TOKEN"), []byte(os.Getenv(PINEnv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { token.Close() })
+	return token
+}
+
+func TestKeyEncryptor(t *testing.T) {
+	token := openTestToken(t)
+	encryptor, err := NewKeyEncryptor(token, DefaultEncryptionKeyLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("some key to protect")
+	keyContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientSymmetricKey, []byte("client"))
+	encrypted, err := encryptor.Encrypt(context.Background(), key, keyContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, key) {
+		t.Fatal("Encrypted key contains plaintext")
+	}
+	decrypted, err := encryptor.Decrypt(context.Background(), encrypted, keyContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, decrypted) {
+		t.Fatalf("Expected %v, took %v", key, decrypted)
+	}
+	anotherContext := keystore.NewClientIDKeyContext(keystore.PurposeStorageClientSymmetricKey, []byte("another client"))
+	if _, err := encryptor.Decrypt(context.Background(), encrypted, anotherContext); err == nil {
+		t.Fatal("Expected error on decryption with another context")
+	}
+	if _, err := encryptor.Decrypt(context.Background(), encrypted[:gcmIVLength], keyContext); err != ErrInvalidEncryptedKey {
+		t.Fatalf("Expected ErrInvalidEncryptedKey, took %v", err)
+	}
+	if _, err := NewKeyEncryptor(token, "unknown key"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, took %v", err)
+	}
+}
+
+func TestSignSha256(t *testing.T) {
+	token := openTestToken(t)
+	signer, err := NewSignSha256(token, DefaultSignatureKeyLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := signer.Sign([]byte("data"), []byte("context"))
+	if len(signature) != hmacSHA256Length {
+		t.Fatalf("Invalid signature length %d", len(signature))
+	}
+	if !signer.Verify(signature, []byte("data"), []byte("context")) {
+		t.Fatal("Valid signature is not verified")
+	}
+	if signer.Verify(signature, []byte("data"), []byte("another context")) {
+		t.Fatal("Signature with another context is verified")
+	}
+	if !signer.AlgorithmOID().Equal((&crypto.SignSha256{}).AlgorithmOID()) {
+		t.Fatal("Algorithm OID differs from crypto.SignSha256")
+	}
+}
+
+func TestOpenTokenInvalidModule(t *testing.T) {
+	if _, err := OpenToken("/nonexistent/libpkcs11.so", "acra", nil); err != ErrModuleNotLoaded {
+		t.Fatalf("Expected ErrModuleNotLoaded, took %v", err)
+	}
+}
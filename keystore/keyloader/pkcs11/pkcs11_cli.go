@@ -0,0 +1,67 @@
+package pkcs11
+
+import (
+	"flag"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PINEnv is the name of environment variable with PIN of PKCS#11 token user
+const PINEnv = "ACRA_PKCS11_PIN"
+
+// Default labels of keys stored on PKCS#11 token
+const (
+	DefaultEncryptionKeyLabel = "acra-keystore-encryption"
+	DefaultSignatureKeyLabel  = "acra-keystore-signature"
+)
+
+const modulePathFlag = "pkcs11_module_path"
+
+// CLIOptions keep command-line options related to PKCS#11 key encryption.
+type CLIOptions struct {
+	ModulePath         string
+	TokenLabel         string
+	EncryptionKeyLabel string
+	SignatureKeyLabel  string
+}
+
+// RegisterCLIParametersWithFlagSet look up for pkcs11_module_path, if none exists, PKCS#11 flags
+// will be added to provided flags.
+func RegisterCLIParametersWithFlagSet(flags *flag.FlagSet, prefix, description string) {
+	if description != "" {
+		description = " (" + description + ")"
+	}
+	if flags.Lookup(prefix+modulePathFlag) == nil {
+		flags.String(prefix+modulePathFlag, "", "Path to PKCS#11 module library (SoftHSM, Luna, YubiHSM)"+description)
+		flags.String(prefix+"pkcs11_token_label", "", "Label of PKCS#11 token with keystore keys, user PIN is read from "+PINEnv+" environment variable"+description)
+		flags.String(prefix+"pkcs11_encryption_key_label", DefaultEncryptionKeyLabel, "Label of AES key on PKCS#11 token used to encrypt keystore keys"+description)
+		flags.String(prefix+"pkcs11_signature_key_label", DefaultSignatureKeyLabel, "Label of generic secret key on PKCS#11 token used for HMAC-SHA-256 signatures of keystore v2"+description)
+	}
+}
+
+// ParseCLIParametersFromFlags CLIOptions from provided FlagSet
+func ParseCLIParametersFromFlags(flags *flag.FlagSet, prefix string) *CLIOptions {
+	options := CLIOptions{}
+	values := map[string]*string{
+		modulePathFlag:                &options.ModulePath,
+		"pkcs11_token_label":          &options.TokenLabel,
+		"pkcs11_encryption_key_label": &options.EncryptionKeyLabel,
+		"pkcs11_signature_key_label":  &options.SignatureKeyLabel,
+	}
+	for name, value := range values {
+		if f := flags.Lookup(prefix + name); f != nil {
+			*value = f.Value.String()
+		}
+	}
+	return &options
+}
+
+// OpenToken opens PKCS#11 token from CLIOptions with PIN from environment
+func (options *CLIOptions) OpenToken() (*Token, error) {
+	pin := os.Getenv(PINEnv)
+	if pin == "" {
+		log.Warnf("%v environment variable is not set", PINEnv)
+	}
+	return OpenToken(options.ModulePath, options.TokenLabel, []byte(pin))
+}
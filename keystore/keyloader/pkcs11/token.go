@@ -0,0 +1,392 @@
+package pkcs11
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Subset of PKCS#11 v2.40 types used by Acra. Modules are loaded with dlopen, so headers of vendor aren't required.
+typedef unsigned long CK_ULONG;
+typedef unsigned char CK_BYTE;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_SLOT_ID;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+
+#define CKR_OK                            0x000UL
+#define CKR_USER_ALREADY_LOGGED_IN        0x100UL
+#define CKR_CRYPTOKI_ALREADY_INITIALIZED  0x191UL
+#define CKR_ACRA_TOKEN_NOT_FOUND          0x80000001UL
+#define CKR_ACRA_KEY_NOT_FOUND            0x80000002UL
+
+#define CKF_OS_LOCKING_OK   0x2UL
+#define CKF_RW_SESSION      0x2UL
+#define CKF_SERIAL_SESSION  0x4UL
+#define CKU_USER            1UL
+#define CKA_CLASS           0x000UL
+#define CKA_LABEL           0x003UL
+#define CKO_SECRET_KEY      0x004UL
+#define CKM_SHA256_HMAC     0x251UL
+#define CKM_AES_GCM         0x1087UL
+
+typedef struct { CK_ULONG type; void *pValue; CK_ULONG ulValueLen; } CK_ATTRIBUTE;
+typedef struct { CK_ULONG mechanism; void *pParameter; CK_ULONG ulParameterLen; } CK_MECHANISM;
+typedef struct { CK_BYTE *pIv; CK_ULONG ulIvLen; CK_ULONG ulIvBits; CK_BYTE *pAAD; CK_ULONG ulAADLen; CK_ULONG ulTagBits; } CK_GCM_PARAMS;
+typedef struct { CK_BYTE major; CK_BYTE minor; } CK_VERSION;
+typedef struct {
+	CK_BYTE label[32];
+	CK_BYTE manufacturerID[32];
+	CK_BYTE model[16];
+	CK_BYTE serialNumber[16];
+	CK_ULONG flags;
+	CK_ULONG counters[10];
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	CK_BYTE utcTime[16];
+} CK_TOKEN_INFO;
+typedef struct { void *CreateMutex; void *DestroyMutex; void *LockMutex; void *UnlockMutex; CK_ULONG flags; void *pReserved; } CK_C_INITIALIZE_ARGS;
+
+typedef struct {
+	void *handle;
+	CK_RV (*Initialize)(void *);
+	CK_RV (*Finalize)(void *);
+	CK_RV (*GetSlotList)(CK_BYTE, CK_SLOT_ID *, CK_ULONG *);
+	CK_RV (*GetTokenInfo)(CK_SLOT_ID, CK_TOKEN_INFO *);
+	CK_RV (*OpenSession)(CK_SLOT_ID, CK_ULONG, void *, void *, CK_SESSION_HANDLE *);
+	CK_RV (*CloseSession)(CK_SESSION_HANDLE);
+	CK_RV (*Login)(CK_SESSION_HANDLE, CK_ULONG, CK_BYTE *, CK_ULONG);
+	CK_RV (*FindObjectsInit)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*FindObjects)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *);
+	CK_RV (*FindObjectsFinal)(CK_SESSION_HANDLE);
+	CK_RV (*EncryptInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*Encrypt)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+	CK_RV (*DecryptInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*Decrypt)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+	CK_RV (*SignInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*Sign)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+} p11_module;
+
+static p11_module *p11_load(const char *path) {
+	p11_module *m = calloc(1, sizeof(p11_module));
+	if (!m) {
+		return NULL;
+	}
+	m->handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (!m->handle) {
+		free(m);
+		return NULL;
+	}
+	m->Initialize = dlsym(m->handle, "C_Initialize");
+	m->Finalize = dlsym(m->handle, "C_Finalize");
+	m->GetSlotList = dlsym(m->handle, "C_GetSlotList");
+	m->GetTokenInfo = dlsym(m->handle, "C_GetTokenInfo");
+	m->OpenSession = dlsym(m->handle, "C_OpenSession");
+	m->CloseSession = dlsym(m->handle, "C_CloseSession");
+	m->Login = dlsym(m->handle, "C_Login");
+	m->FindObjectsInit = dlsym(m->handle, "C_FindObjectsInit");
+	m->FindObjects = dlsym(m->handle, "C_FindObjects");
+	m->FindObjectsFinal = dlsym(m->handle, "C_FindObjectsFinal");
+	m->EncryptInit = dlsym(m->handle, "C_EncryptInit");
+	m->Encrypt = dlsym(m->handle, "C_Encrypt");
+	m->DecryptInit = dlsym(m->handle, "C_DecryptInit");
+	m->Decrypt = dlsym(m->handle, "C_Decrypt");
+	m->SignInit = dlsym(m->handle, "C_SignInit");
+	m->Sign = dlsym(m->handle, "C_Sign");
+	if (!m->Initialize || !m->Finalize || !m->GetSlotList || !m->GetTokenInfo || !m->OpenSession ||
+		!m->CloseSession || !m->Login || !m->FindObjectsInit || !m->FindObjects || !m->FindObjectsFinal ||
+		!m->EncryptInit || !m->Encrypt || !m->DecryptInit || !m->Decrypt || !m->SignInit || !m->Sign) {
+		dlclose(m->handle);
+		free(m);
+		return NULL;
+	}
+	return m;
+}
+
+static void p11_unload(p11_module *m) {
+	dlclose(m->handle);
+	free(m);
+}
+
+static CK_RV p11_initialize(p11_module *m) {
+	CK_C_INITIALIZE_ARGS args;
+	memset(&args, 0, sizeof(args));
+	args.flags = CKF_OS_LOCKING_OK;
+	CK_RV rv = m->Initialize(&args);
+	if (rv == CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return CKR_OK;
+	}
+	return rv;
+}
+
+static CK_RV p11_finalize(p11_module *m) {
+	return m->Finalize(NULL);
+}
+
+// p11_open_session opens session with token which label is padded with spaces to 32 bytes and logs in as user
+static CK_RV p11_open_session(p11_module *m, CK_BYTE *label, CK_BYTE *pin, CK_ULONG pinLen, CK_SESSION_HANDLE *session) {
+	CK_ULONG count = 0;
+	CK_RV rv = m->GetSlotList(1, NULL, &count);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	if (count == 0) {
+		return CKR_ACRA_TOKEN_NOT_FOUND;
+	}
+	CK_SLOT_ID *slots = calloc(count, sizeof(CK_SLOT_ID));
+	if (!slots) {
+		return CKR_ACRA_TOKEN_NOT_FOUND;
+	}
+	rv = m->GetSlotList(1, slots, &count);
+	if (rv != CKR_OK) {
+		free(slots);
+		return rv;
+	}
+	CK_RV result = CKR_ACRA_TOKEN_NOT_FOUND;
+	for (CK_ULONG i = 0; i < count; i++) {
+		CK_TOKEN_INFO info;
+		if (m->GetTokenInfo(slots[i], &info) != CKR_OK || memcmp(info.label, label, sizeof(info.label)) != 0) {
+			continue;
+		}
+		result = m->OpenSession(slots[i], CKF_SERIAL_SESSION | CKF_RW_SESSION, NULL, NULL, session);
+		break;
+	}
+	free(slots);
+	if (result != CKR_OK) {
+		return result;
+	}
+	rv = m->Login(*session, CKU_USER, pin, pinLen);
+	if (rv != CKR_OK && rv != CKR_USER_ALREADY_LOGGED_IN) {
+		m->CloseSession(*session);
+		return rv;
+	}
+	return CKR_OK;
+}
+
+static CK_RV p11_close_session(p11_module *m, CK_SESSION_HANDLE session) {
+	return m->CloseSession(session);
+}
+
+static CK_RV p11_find_secret_key(p11_module *m, CK_SESSION_HANDLE session, CK_BYTE *label, CK_ULONG labelLen, CK_OBJECT_HANDLE *key) {
+	CK_ULONG class = CKO_SECRET_KEY;
+	CK_ATTRIBUTE template[2] = {
+		{CKA_CLASS, &class, sizeof(class)},
+		{CKA_LABEL, label, labelLen},
+	};
+	CK_RV rv = m->FindObjectsInit(session, template, 2);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	CK_ULONG count = 0;
+	rv = m->FindObjects(session, key, 1, &count);
+	m->FindObjectsFinal(session);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	if (count == 0) {
+		return CKR_ACRA_KEY_NOT_FOUND;
+	}
+	return CKR_OK;
+}
+
+static CK_RV p11_aes_gcm(p11_module *m, CK_SESSION_HANDLE session, int encrypt, CK_OBJECT_HANDLE key,
+		CK_BYTE *iv, CK_ULONG ivLen, CK_BYTE *aad, CK_ULONG aadLen,
+		CK_BYTE *in, CK_ULONG inLen, CK_BYTE *out, CK_ULONG *outLen) {
+	CK_GCM_PARAMS params = {iv, ivLen, ivLen * 8, aad, aadLen, 128};
+	CK_MECHANISM mechanism = {CKM_AES_GCM, &params, sizeof(params)};
+	CK_RV rv;
+	if (encrypt) {
+		rv = m->EncryptInit(session, &mechanism, key);
+		if (rv != CKR_OK) {
+			return rv;
+		}
+		return m->Encrypt(session, in, inLen, out, outLen);
+	}
+	rv = m->DecryptInit(session, &mechanism, key);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return m->Decrypt(session, in, inLen, out, outLen);
+}
+
+static CK_RV p11_hmac_sha256(p11_module *m, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE key,
+		CK_BYTE *in, CK_ULONG inLen, CK_BYTE *out, CK_ULONG *outLen) {
+	CK_MECHANISM mechanism = {CKM_SHA256_HMAC, NULL, 0};
+	CK_RV rv = m->SignInit(session, &mechanism, key);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return m->Sign(session, in, inLen, out, outLen);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+const (
+	tokenLabelLength = 32
+	gcmTagLength     = 16
+	hmacSHA256Length = 32
+)
+
+// set of predefined errors of PKCS#11 token
+var (
+	ErrModuleNotLoaded = errors.New("failed to load PKCS#11 module")
+	ErrTokenNotFound   = errors.New("PKCS#11 token with provided label not found")
+	ErrKeyNotFound     = errors.New("secret key with provided label not found on PKCS#11 token")
+	ErrTokenClosed     = errors.New("PKCS#11 token is closed")
+)
+
+// Error is a return value of PKCS#11 function
+type Error uint
+
+// Error returns hex value of CK_RV
+func (e Error) Error() string {
+	return fmt.Sprintf("PKCS#11 error 0x%08X", uint(e))
+}
+
+func toError(rv C.CK_RV) error {
+	switch rv {
+	case C.CKR_OK:
+		return nil
+	case C.CKR_ACRA_TOKEN_NOT_FOUND:
+		return ErrTokenNotFound
+	case C.CKR_ACRA_KEY_NOT_FOUND:
+		return ErrKeyNotFound
+	}
+	return Error(rv)
+}
+
+// ObjectHandle is a handle of object stored on the token
+type ObjectHandle uint
+
+// Token is logged in session with PKCS#11 token. Keys never leave the token, all operations with them are
+// performed by the token. Session is used by one operation at the moment.
+type Token struct {
+	lock    sync.Mutex
+	module  *C.p11_module
+	session C.CK_SESSION_HANDLE
+}
+
+// OpenToken loads PKCS#11 module, opens session with the token with provided label and logs in as user with PIN
+func OpenToken(modulePath, tokenLabel string, pin []byte) (*Token, error) {
+	if len(tokenLabel) > tokenLabelLength {
+		return nil, ErrTokenNotFound
+	}
+	cPath := C.CString(modulePath)
+	defer C.free(unsafe.Pointer(cPath))
+	module := C.p11_load(cPath)
+	if module == nil {
+		return nil, ErrModuleNotLoaded
+	}
+	if err := toError(C.p11_initialize(module)); err != nil {
+		C.p11_unload(module)
+		return nil, err
+	}
+	// labels of tokens are padded with spaces
+	label := []byte(fmt.Sprintf("%-32s", tokenLabel))
+	var pinPtr *C.CK_BYTE
+	if len(pin) > 0 {
+		pinPtr = (*C.CK_BYTE)(unsafe.Pointer(&pin[0]))
+	}
+	token := &Token{module: module}
+	if err := toError(C.p11_open_session(module, (*C.CK_BYTE)(unsafe.Pointer(&label[0])), pinPtr, C.CK_ULONG(len(pin)), &token.session)); err != nil {
+		C.p11_finalize(module)
+		C.p11_unload(module)
+		return nil, err
+	}
+	return token, nil
+}
+
+// FindSecretKey returns handle of secret key with provided label
+func (token *Token) FindSecretKey(label string) (ObjectHandle, error) {
+	if label == "" {
+		return 0, ErrKeyNotFound
+	}
+	token.lock.Lock()
+	defer token.lock.Unlock()
+	if token.module == nil {
+		return 0, ErrTokenClosed
+	}
+	cLabel := []byte(label)
+	var key C.CK_OBJECT_HANDLE
+	if err := toError(C.p11_find_secret_key(token.module, token.session, (*C.CK_BYTE)(unsafe.Pointer(&cLabel[0])), C.CK_ULONG(len(cLabel)), &key)); err != nil {
+		return 0, err
+	}
+	return ObjectHandle(key), nil
+}
+
+// EncryptAESGCM encrypts plaintext with AES key using GCM mode and returns ciphertext with authentication tag
+func (token *Token) EncryptAESGCM(key ObjectHandle, iv, aad, plaintext []byte) ([]byte, error) {
+	return token.aesGCM(true, key, iv, aad, plaintext, len(plaintext)+gcmTagLength)
+}
+
+// DecryptAESGCM decrypts ciphertext with authentication tag returned by EncryptAESGCM
+func (token *Token) DecryptAESGCM(key ObjectHandle, iv, aad, ciphertext []byte) ([]byte, error) {
+	return token.aesGCM(false, key, iv, aad, ciphertext, len(ciphertext))
+}
+
+func (token *Token) aesGCM(encrypt bool, key ObjectHandle, iv, aad, in []byte, outLength int) ([]byte, error) {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+	if token.module == nil {
+		return nil, ErrTokenClosed
+	}
+	mode := C.int(0)
+	if encrypt {
+		mode = 1
+	}
+	// reserve at least one byte to have valid pointer for empty output
+	out := make([]byte, outLength+1)
+	outLen := C.CK_ULONG(outLength)
+	err := toError(C.p11_aes_gcm(token.module, token.session, mode, C.CK_OBJECT_HANDLE(key),
+		bytesPtr(iv), C.CK_ULONG(len(iv)), bytesPtr(aad), C.CK_ULONG(len(aad)),
+		bytesPtr(in), C.CK_ULONG(len(in)), (*C.CK_BYTE)(unsafe.Pointer(&out[0])), &outLen))
+	if err != nil {
+		return nil, err
+	}
+	return out[:outLen], nil
+}
+
+// HMACSHA256 computes HMAC-SHA-256 of data with generic secret key
+func (token *Token) HMACSHA256(key ObjectHandle, data []byte) ([]byte, error) {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+	if token.module == nil {
+		return nil, ErrTokenClosed
+	}
+	out := make([]byte, hmacSHA256Length)
+	outLen := C.CK_ULONG(len(out))
+	err := toError(C.p11_hmac_sha256(token.module, token.session, C.CK_OBJECT_HANDLE(key), bytesPtr(data), C.CK_ULONG(len(data)),
+		(*C.CK_BYTE)(unsafe.Pointer(&out[0])), &outLen))
+	if err != nil {
+		return nil, err
+	}
+	return out[:outLen], nil
+}
+
+// Close closes session and unloads PKCS#11 module
+func (token *Token) Close() error {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+	if token.module == nil {
+		return nil
+	}
+	err := toError(C.p11_close_session(token.module, token.session))
+	C.p11_finalize(token.module)
+	C.p11_unload(token.module)
+	token.module = nil
+	return err
+}
+
+func bytesPtr(data []byte) *C.CK_BYTE {
+	if len(data) == 0 {
+		return nil
+	}
+	return (*C.CK_BYTE)(unsafe.Pointer(&data[0]))
+}
@@ -64,6 +64,8 @@ const (
 	PurposeStorageClientKeyPair      KeyPurpose = "storage"
 	PurposeStorageClientPublicKey    KeyPurpose = "public_storage"
 	PurposeStorageClientPrivateKey   KeyPurpose = "private_storage"
+	PurposePseudonymization          KeyPurpose = "pseudonym_sym_key"
+	PurposeReIdentificationKeyPair   KeyPurpose = "re_identification"
 	PurposeLegacy                    KeyPurpose = "legacy"
 	PurposeUndefined                 KeyPurpose = "undefined"
 )
@@ -169,6 +171,28 @@ type HmacKeyGenerator interface {
 	GenerateHmacKey(id []byte) error
 }
 
+// PseudonymizationKeyStore keeps keys used by AcraServer to derive pseudonyms and to encrypt source values for
+// re-identification
+type PseudonymizationKeyStore interface {
+	GetPseudonymizationSymmetricKey(clientID []byte) ([]byte, error)
+	GetReIdentificationPublicKey(clientID []byte) (*keys.PublicKey, error)
+}
+
+// ReIdentificationKeyStore keeps private keys used by AcraTranslator to re-identify pseudonyms. They aren't required
+// by AcraServer and should be deployed only to AcraTranslator
+type ReIdentificationKeyStore interface {
+	GetReIdentificationPrivateKeys(clientID []byte) ([]*keys.PrivateKey, error)
+}
+
+// ErrPseudonymizationNotSupported returned for keystores which can't store keys of pseudonymization
+var ErrPseudonymizationNotSupported = errors.New("keystore doesn't support keys of pseudonymization")
+
+// PseudonymizationKeyGenerator is able to generate keys for PseudonymizationKeyStore and ReIdentificationKeyStore
+type PseudonymizationKeyGenerator interface {
+	GeneratePseudonymizationSymmetricKey(clientID []byte) error
+	GenerateReIdentificationKeyPair(clientID []byte) error
+}
+
 // SymmetricEncryptionKeyStore interface describe access methods to encryption symmetric keys
 type SymmetricEncryptionKeyStore interface {
 	GetClientIDSymmetricKeys(id []byte) ([][]byte, error)
@@ -465,6 +489,8 @@ const (
 // "Purpose" is short human-readable description of the key purpose.
 // "ClientID" and "AdditionalContext" are filled in where relevant.
 // "CreationTime" used to display creation time of rotated key
+// "Tags" are labels assigned to KeyID, filled by keystores which implement KeyTagStore
+// "Usage" is statistics of operations with the key, filled on request from KeyUsageStore
 type KeyDescription struct {
 	Index        int
 	KeyID        string
@@ -472,6 +498,8 @@ type KeyDescription struct {
 	Purpose      KeyPurpose
 	ClientID     string     `json:",omitempty"`
 	CreationTime *time.Time `json:",omitempty"`
+	Tags         KeyTags    `json:",omitempty"`
+	Usage        *KeyUsage  `json:",omitempty"`
 }
 
 // TranslationKeyStore enables AcraStruct translation. It is used by acra-translator tool.
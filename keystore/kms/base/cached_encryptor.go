@@ -0,0 +1,198 @@
+package base
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+// DefaultCacheSize is the default number of decrypted keys stored by CachedEncryptor
+const DefaultCacheSize = 1000
+
+// labels of kmsDecryptionCacheCounter
+const (
+	cacheResultHit         = "hit"
+	cacheResultNegativeHit = "negative_hit"
+	cacheResultMiss        = "miss"
+	cacheResultEviction    = "eviction"
+)
+
+var kmsDecryptionCacheCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acra_kms_decryption_cache_total",
+		Help: "number of KMS decryption cache lookups by result and removals of cached keys",
+	}, []string{"result"})
+
+var registerCacheMetricsLock = sync.Once{}
+
+// RegisterCacheMetrics register in default prometheus registry metrics related with KMS decryption cache
+func RegisterCacheMetrics() {
+	registerCacheMetricsLock.Do(func() {
+		prometheus.MustRegister(kmsDecryptionCacheCounter)
+	})
+}
+
+// CacheOptions configures CachedEncryptor
+type CacheOptions struct {
+	// TTL of successfully decrypted keys, 0 disables caching
+	TTL time.Duration
+	// NegativeTTL of decryption errors, so invalid ciphertexts don't hit KMS on every request. 0 disables it
+	NegativeTTL time.Duration
+	// Size is the maximum number of cached results, 0 - unlimited
+	Size int
+}
+
+type cacheEntry struct {
+	keyID   string
+	value   []byte
+	err     error
+	expires time.Time
+}
+
+// CachedEncryptor is Encryptor which stores results of KMS decryption in memory for configured TTL.
+// Failed decryptions are cached for NegativeTTL. All results related to keyID are invalidated on encryption
+// with it (generation or rotation of keys) or explicitly with Invalidate.
+type CachedEncryptor struct {
+	encryptor Encryptor
+	options   CacheOptions
+
+	mutex sync.Mutex
+	cache *lru.Cache
+	// cache keys of entries grouped by keyID for invalidation
+	keyIDs map[string]map[lru.Key]struct{}
+	now    func() time.Time
+}
+
+// NewCachedEncryptor wraps encryptor with decryption cache
+func NewCachedEncryptor(encryptor Encryptor, options CacheOptions) *CachedEncryptor {
+	cachedEncryptor := &CachedEncryptor{
+		encryptor: encryptor,
+		options:   options,
+		cache:     lru.New(options.Size),
+		keyIDs:    make(map[string]map[lru.Key]struct{}),
+		now:       time.Now,
+	}
+	cachedEncryptor.cache.OnEvicted = cachedEncryptor.onEvicted
+	return cachedEncryptor
+}
+
+// onEvicted zeroizes decrypted key and removes it from index, it is called under lock
+func (encryptor *CachedEncryptor) onEvicted(key lru.Key, value interface{}) {
+	entry := value.(*cacheEntry)
+	utils.ZeroizeBytes(entry.value)
+	if keys, ok := encryptor.keyIDs[entry.keyID]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(encryptor.keyIDs, entry.keyID)
+		}
+	}
+	kmsDecryptionCacheCounter.WithLabelValues(cacheResultEviction).Inc()
+}
+
+// cacheKey returns hash of all parameters of decryption, length prefixes prevent collisions of concatenation
+func cacheKey(keyID, data, context []byte) string {
+	hash := sha256.New()
+	for _, value := range [][]byte{keyID, data, context} {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(value)))
+		hash.Write(length)
+		hash.Write(value)
+	}
+	return string(hash.Sum(nil))
+}
+
+// Encrypt encrypts data with KMS and invalidates cached results of keyID
+func (encryptor *CachedEncryptor) Encrypt(ctx context.Context, keyID []byte, data []byte, context []byte) ([]byte, error) {
+	encryptor.Invalidate(keyID)
+	return encryptor.encryptor.Encrypt(ctx, keyID, data, context)
+}
+
+// Decrypt returns cached result of decryption or decrypts data with KMS and caches the result
+func (encryptor *CachedEncryptor) Decrypt(ctx context.Context, keyID []byte, data []byte, context []byte) ([]byte, error) {
+	key := cacheKey(keyID, data, context)
+	if value, ok, err := encryptor.get(key); ok {
+		return value, err
+	}
+	kmsDecryptionCacheCounter.WithLabelValues(cacheResultMiss).Inc()
+	value, err := encryptor.encryptor.Decrypt(ctx, keyID, data, context)
+	if err != nil {
+		// context errors are related to the caller, not to the ciphertext
+		if ctx.Err() == nil {
+			encryptor.add(key, string(keyID), nil, err, encryptor.options.NegativeTTL)
+		}
+		return nil, err
+	}
+	encryptor.add(key, string(keyID), value, nil, encryptor.options.TTL)
+	return value, nil
+}
+
+// get returns copy of cached value, so callers may zeroize it
+func (encryptor *CachedEncryptor) get(key string) ([]byte, bool, error) {
+	encryptor.mutex.Lock()
+	defer encryptor.mutex.Unlock()
+	value, ok := encryptor.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	entry := value.(*cacheEntry)
+	if !encryptor.now().Before(entry.expires) {
+		encryptor.cache.Remove(key)
+		return nil, false, nil
+	}
+	if entry.err != nil {
+		kmsDecryptionCacheCounter.WithLabelValues(cacheResultNegativeHit).Inc()
+		return nil, true, entry.err
+	}
+	kmsDecryptionCacheCounter.WithLabelValues(cacheResultHit).Inc()
+	return append([]byte(nil), entry.value...), true, nil
+}
+
+func (encryptor *CachedEncryptor) add(key, keyID string, value []byte, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{keyID: keyID, err: err, expires: encryptor.now().Add(ttl)}
+	if value != nil {
+		entry.value = append([]byte(nil), value...)
+	}
+	encryptor.mutex.Lock()
+	defer encryptor.mutex.Unlock()
+	// remove previous entry to keep index consistent
+	encryptor.cache.Remove(key)
+	encryptor.cache.Add(key, entry)
+	keys, ok := encryptor.keyIDs[keyID]
+	if !ok {
+		keys = make(map[lru.Key]struct{})
+		encryptor.keyIDs[keyID] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// Invalidate removes all cached results of keyID, should be called after rotation of the key in KMS
+func (encryptor *CachedEncryptor) Invalidate(keyID []byte) {
+	encryptor.mutex.Lock()
+	defer encryptor.mutex.Unlock()
+	keys := encryptor.keyIDs[string(keyID)]
+	count := len(keys)
+	for key := range keys {
+		encryptor.cache.Remove(key)
+	}
+	if count > 0 {
+		log.WithField("key_id", string(keyID)).WithField("count", count).Debugln("Invalidated cached KMS decryption results")
+	}
+}
+
+// Clear removes all cached results
+func (encryptor *CachedEncryptor) Clear() {
+	encryptor.mutex.Lock()
+	encryptor.cache.Clear()
+	encryptor.mutex.Unlock()
+}
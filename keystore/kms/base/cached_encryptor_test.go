@@ -0,0 +1,130 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestDecryption = errors.New("decryption failed")
+
+// countingEncryptor "decrypts" data to itself and counts calls, data "invalid" can't be decrypted
+type countingEncryptor struct {
+	decryptions int
+}
+
+func (e *countingEncryptor) Encrypt(ctx context.Context, keyID []byte, data []byte, context []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (e *countingEncryptor) Decrypt(ctx context.Context, keyID []byte, data []byte, context []byte) ([]byte, error) {
+	e.decryptions++
+	if string(data) == "invalid" {
+		return nil, errTestDecryption
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func newTestCachedEncryptor(options CacheOptions) (*CachedEncryptor, *countingEncryptor, *time.Time) {
+	kms := &countingEncryptor{}
+	encryptor := NewCachedEncryptor(kms, options)
+	now := time.Now()
+	encryptor.now = func() time.Time { return now }
+	return encryptor, kms, &now
+}
+
+func TestCachedEncryptorTTL(t *testing.T) {
+	encryptor, kms, now := newTestCachedEncryptor(CacheOptions{TTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		value, err := encryptor.Decrypt(ctx, []byte("key"), []byte("data"), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data"), value)
+		// returned value is a copy which may be zeroized by caller
+		value[0] = 0
+	}
+	assert.Equal(t, 1, kms.decryptions)
+
+	// another context is another cache entry
+	_, err := encryptor.Decrypt(ctx, []byte("key"), []byte("data"), []byte("context"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, kms.decryptions)
+
+	*now = now.Add(time.Minute)
+	_, err = encryptor.Decrypt(ctx, []byte("key"), []byte("data"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, kms.decryptions)
+
+	// errors aren't cached without negative TTL
+	for i := 0; i < 2; i++ {
+		_, err = encryptor.Decrypt(ctx, []byte("key"), []byte("invalid"), nil)
+		assert.ErrorIs(t, err, errTestDecryption)
+	}
+	assert.Equal(t, 5, kms.decryptions)
+}
+
+func TestCachedEncryptorNegativeTTL(t *testing.T) {
+	encryptor, kms, now := newTestCachedEncryptor(CacheOptions{TTL: time.Minute, NegativeTTL: time.Second})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := encryptor.Decrypt(ctx, []byte("key"), []byte("invalid"), nil)
+		assert.ErrorIs(t, err, errTestDecryption)
+	}
+	assert.Equal(t, 1, kms.decryptions)
+
+	*now = now.Add(time.Second)
+	_, err := encryptor.Decrypt(ctx, []byte("key"), []byte("invalid"), nil)
+	assert.ErrorIs(t, err, errTestDecryption)
+	assert.Equal(t, 2, kms.decryptions)
+
+	// errors caused by canceled context aren't cached
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = encryptor.Decrypt(canceledCtx, []byte("another key"), []byte("invalid"), nil)
+	assert.ErrorIs(t, err, errTestDecryption)
+	_, err = encryptor.Decrypt(ctx, []byte("another key"), []byte("invalid"), nil)
+	assert.ErrorIs(t, err, errTestDecryption)
+	assert.Equal(t, 4, kms.decryptions)
+}
+
+func TestCachedEncryptorSizeAndInvalidation(t *testing.T) {
+	encryptor, kms, _ := newTestCachedEncryptor(CacheOptions{TTL: time.Minute, Size: 2})
+	ctx := context.Background()
+
+	for _, data := range []string{"first", "second", "third", "first"} {
+		_, err := encryptor.Decrypt(ctx, []byte("key"), []byte(data), nil)
+		assert.NoError(t, err)
+	}
+	// "first" was evicted by "third"
+	assert.Equal(t, 4, kms.decryptions)
+	assert.Equal(t, 2, encryptor.cache.Len())
+	assert.Len(t, encryptor.keyIDs["key"], 2)
+
+	_, err := encryptor.Decrypt(ctx, []byte("another key"), []byte("third"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, kms.decryptions)
+
+	// encryption with the key invalidates only its entries
+	_, err = encryptor.Encrypt(ctx, []byte("another key"), []byte("data"), nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, encryptor.keyIDs, "another key")
+	_, err = encryptor.Decrypt(ctx, []byte("key"), []byte("first"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, kms.decryptions)
+
+	encryptor.Invalidate([]byte("key"))
+	assert.Equal(t, 0, encryptor.cache.Len())
+	assert.Empty(t, encryptor.keyIDs)
+	_, err = encryptor.Decrypt(ctx, []byte("key"), []byte("first"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, kms.decryptions)
+
+	encryptor.Clear()
+	assert.Equal(t, 0, encryptor.cache.Len())
+	assert.Empty(t, encryptor.keyIDs)
+}
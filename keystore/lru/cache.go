@@ -20,22 +20,37 @@ package lru
 
 import (
 	"sync"
+	"time"
 
 	"github.com/cossacklabs/themis/gothemis/keys"
 	"github.com/golang/groupcache/lru"
 
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/utils"
 )
 
-// Cache implement keystore.Cache
+// Cache is LRU cache of keys with optional expiration of values
 type Cache struct {
-	lru   *lru.Cache
-	mutex sync.RWMutex
+	lru *lru.Cache
+	// lru.Cache changes order of values on Get, so exclusive lock is used for all operations
+	mutex  sync.Mutex
+	ttl    time.Duration
+	now    func() time.Time
+	size   int
+	hits   int64
+	misses int64
+}
+
+// cacheEntry is value of the cache with its expiration time
+type cacheEntry struct {
+	value     []byte
+	expiresAt time.Time
 }
 
-// clearCacheValue callback for lru.Cache that called on value remove operation
 func clearCacheValue(key lru.Key, value interface{}) {
 	switch value := value.(type) {
+	case *cacheEntry:
+		utils.ZeroizeBytes(value.value)
 	case []byte:
 		utils.ZeroizeBytes(value)
 	case *keys.PrivateKey:
@@ -43,32 +58,57 @@ func clearCacheValue(key lru.Key, value interface{}) {
 	}
 }
 
-// NewCacheKeystoreWrapper return new *Cache
+// NewCacheKeystoreWrapper returns LRU cache which keeps up to size values, 0 - without limits
 func NewCacheKeystoreWrapper(size int) (*Cache, error) {
-	cache := &Cache{lru: lru.New(size)}
+	return NewCacheKeystoreWrapperWithTTL(size, 0)
+}
+
+// NewCacheKeystoreWrapperWithTTL returns LRU cache which keeps up to size values, 0 - without limits.
+// Values are zeroized and removed on first access after ttl since they were added, 0 - values don't expire
+func NewCacheKeystoreWrapperWithTTL(size int, ttl time.Duration) (*Cache, error) {
+	cache := &Cache{lru: lru.New(size), ttl: ttl, now: time.Now, size: size}
 	cache.lru.OnEvicted = clearCacheValue
 	return cache, nil
 }
 
-// Add value by keyID
+// Add value to the cache
 func (cache *Cache) Add(keyID string, keyValue []byte) {
+	entry := &cacheEntry{value: keyValue}
+	if cache.ttl > 0 {
+		entry.expiresAt = cache.now().Add(cache.ttl)
+	}
 	cache.mutex.Lock()
-	cache.lru.Add(keyID, keyValue)
+	cache.lru.Add(keyID, entry)
 	cache.mutex.Unlock()
 }
 
-// Get value by keyID
+// Get returns value of keyID if it is cached and not expired
 func (cache *Cache) Get(keyID string) ([]byte, bool) {
-	cache.mutex.RLock()
-	defer cache.mutex.RUnlock()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
 	value, ok := cache.lru.Get(keyID)
-	if ok {
-		return value.([]byte), ok
+	if !ok {
+		cache.misses++
+		return nil, false
+	}
+	entry := value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && !cache.now().Before(entry.expiresAt) {
+		cache.lru.Remove(keyID)
+		cache.misses++
+		return nil, false
 	}
-	return nil, ok
+	cache.hits++
+	return entry.value, true
+}
+
+// CacheStats returns number of cached values and cache hits/misses since start
+func (cache *Cache) CacheStats() keystore.CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return keystore.CacheStats{Size: cache.lru.Len(), Capacity: cache.size, Hits: cache.hits, Misses: cache.misses}
 }
 
-// Clear cache and remove all values with zeroing
+// Clear removes and zeroizes all values
 func (cache *Cache) Clear() {
 	cache.mutex.Lock()
 	cache.lru.Clear()
@@ -0,0 +1,92 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lru
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCacheSize(t *testing.T) {
+	cache, err := NewCacheKeystoreWrapper(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted := []byte("key1")
+	cache.Add("key1", evicted)
+	cache.Add("key2", []byte("key2"))
+	cache.Add("key3", []byte("key3"))
+	if _, ok := cache.Get("key1"); ok {
+		t.Fatal("Expected evicted value")
+	}
+	if !bytes.Equal(evicted, make([]byte, len(evicted))) {
+		t.Fatal("Expected zeroized evicted value")
+	}
+	if value, ok := cache.Get("key3"); !ok || !bytes.Equal(value, []byte("key3")) {
+		t.Fatal("Expected cached value")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache, err := NewCacheKeystoreWrapperWithTTL(0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	expired := []byte("key1")
+	cache.Add("key1", expired)
+	now = now.Add(time.Second * 30)
+	cache.Add("key2", []byte("key2"))
+	if _, ok := cache.Get("key1"); !ok {
+		t.Fatal("Expected cached value before expiration")
+	}
+
+	now = now.Add(time.Second * 30)
+	if _, ok := cache.Get("key1"); ok {
+		t.Fatal("Expected expired value")
+	}
+	if !bytes.Equal(expired, make([]byte, len(expired))) {
+		t.Fatal("Expected zeroized expired value")
+	}
+	// TTL is counted from addition of each value
+	if _, ok := cache.Get("key2"); !ok {
+		t.Fatal("Expected cached value before expiration")
+	}
+	cache.Add("key1", []byte("key1"))
+	if _, ok := cache.Get("key1"); !ok {
+		t.Fatal("Expected cached value added again")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	cache, err := NewCacheKeystoreWrapper(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Add("key1", []byte("key1"))
+	cache.Add("key2", []byte("key2"))
+	cache.Add("key3", []byte("key3"))
+	cache.Get("key1")
+	cache.Get("key2")
+	cache.Get("key3")
+	stats := cache.CacheStats()
+	if stats.Size != 2 || stats.Capacity != 2 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("Unexpected cache stats %+v", stats)
+	}
+}
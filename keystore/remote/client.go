@@ -0,0 +1,269 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// DefaultRequestTimeout limits duration of one request to remote keystore
+const DefaultRequestTimeout = time.Minute
+
+// Client implements keystore.KeyMaking, keystore.KeyTagStore and keystore.Exporter over remote keystore, so
+// acra-keys subcommands work with it like with local keystore
+type Client struct {
+	conn    *grpc.ClientConn
+	client  KeyStoreManagementClient
+	timeout time.Duration
+}
+
+// NewClient returns Client connected to remote keystore at address with client certificate from tlsConfig
+func NewClient(address string, tlsConfig *tls.Config) (*Client, error) {
+	if tlsConfig == nil {
+		return nil, ErrTLSConfigRequired
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithConnection(conn), nil
+}
+
+// NewClientWithConnection returns Client which uses established connection
+func NewClientWithConnection(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn, client: NewKeyStoreManagementClient(conn), timeout: DefaultRequestTimeout}
+}
+
+// Close closes connection to remote keystore
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// clientError converts status of failed request into errors of keystore package where they exist
+func clientError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return keystore.ErrKeysNotFound
+	case codes.PermissionDenied:
+		return ErrAccessDenied
+	case codes.FailedPrecondition:
+		return keystore.ErrApprovalRequired
+	}
+	return err
+}
+
+func (c *Client) generateKey(kind string, clientID []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.client.GenerateKey(ctx, &GenerateKeyRequest{Kind: kind, ClientId: clientID})
+	return clientError(err)
+}
+
+func (c *Client) destroyKey(kind string, clientID []byte, index int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.client.DestroyKey(ctx, &DestroyKeyRequest{Kind: kind, ClientId: clientID, Index: int32(index)})
+	return clientError(err)
+}
+
+func (c *Client) listKeys(rotated bool) ([]keystore.KeyDescription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	response, err := c.client.ListKeys(ctx, &ListKeysRequest{Rotated: rotated})
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return keyDescriptionsFromProto(response.Keys), nil
+}
+
+// GenerateDataEncryptionKeys generates storage keypair of clientID on remote side
+func (c *Client) GenerateDataEncryptionKeys(clientID []byte) error {
+	return c.generateKey(keystore.KeyStorageKeypair, clientID)
+}
+
+// SaveDataEncryptionKeys returns ErrOperationForbidden because private keys are never sent to remote keystore
+func (c *Client) SaveDataEncryptionKeys(clientID []byte, keypair *keys.Keypair) error {
+	return ErrOperationForbidden
+}
+
+// GenerateClientIDSymmetricKey generates symmetric storage key of clientID on remote side
+func (c *Client) GenerateClientIDSymmetricKey(clientID []byte) error {
+	return c.generateKey(keystore.KeySymmetric, clientID)
+}
+
+// GenerateHmacKey generates HMAC key of clientID on remote side
+func (c *Client) GenerateHmacKey(clientID []byte) error {
+	return c.generateKey(keystore.KeySearch, clientID)
+}
+
+// GeneratePoisonKeyPair generates poison record keypair on remote side
+func (c *Client) GeneratePoisonKeyPair() error {
+	return c.generateKey(keystore.KeyPoisonKeypair, nil)
+}
+
+// GeneratePoisonSymmetricKey generates poison record symmetric key on remote side
+func (c *Client) GeneratePoisonSymmetricKey() error {
+	return c.generateKey(keystore.KeyPoisonSymmetric, nil)
+}
+
+// GenerateLogKey generates audit log key on remote side
+func (c *Client) GenerateLogKey() error {
+	return c.generateKey(string(keystore.PurposeAuditLog), nil)
+}
+
+// DestroyPoisonKeyPair destroys current poison record keypair
+func (c *Client) DestroyPoisonKeyPair() error {
+	return c.destroyKey(keystore.KeyPoisonKeypair, nil, 0)
+}
+
+// DestroyPoisonSymmetricKey destroys current poison record symmetric key
+func (c *Client) DestroyPoisonSymmetricKey() error {
+	return c.destroyKey(keystore.KeyPoisonSymmetric, nil, 0)
+}
+
+// DestroyClientIDEncryptionKeyPair destroys current storage keypair of clientID
+func (c *Client) DestroyClientIDEncryptionKeyPair(clientID []byte) error {
+	return c.destroyKey(keystore.KeyStorageKeypair, clientID, 0)
+}
+
+// DestroyClientIDSymmetricKey destroys current symmetric storage key of clientID
+func (c *Client) DestroyClientIDSymmetricKey(clientID []byte) error {
+	return c.destroyKey(keystore.KeySymmetric, clientID, 0)
+}
+
+// DestroyHmacSecretKey destroys current HMAC key of clientID
+func (c *Client) DestroyHmacSecretKey(clientID []byte) error {
+	return c.destroyKey(keystore.KeySearch, clientID, 0)
+}
+
+// DestroyRotatedPoisonKeyPair destroys rotated poison record keypair by index
+func (c *Client) DestroyRotatedPoisonKeyPair(index int) error {
+	return c.destroyKey(keystore.KeyPoisonKeypair, nil, index)
+}
+
+// DestroyRotatedPoisonSymmetricKey destroys rotated poison record symmetric key by index
+func (c *Client) DestroyRotatedPoisonSymmetricKey(index int) error {
+	return c.destroyKey(keystore.KeyPoisonSymmetric, nil, index)
+}
+
+// DestroyRotatedClientIDEncryptionKeyPair destroys rotated storage keypair of clientID by index
+func (c *Client) DestroyRotatedClientIDEncryptionKeyPair(clientID []byte, index int) error {
+	return c.destroyKey(keystore.KeyStorageKeypair, clientID, index)
+}
+
+// DestroyRotatedClientIDSymmetricKey destroys rotated symmetric storage key of clientID by index
+func (c *Client) DestroyRotatedClientIDSymmetricKey(clientID []byte, index int) error {
+	return c.destroyKey(keystore.KeySymmetric, clientID, index)
+}
+
+// DestroyRotatedHmacSecretKey destroys rotated HMAC key of clientID by index
+func (c *Client) DestroyRotatedHmacSecretKey(clientID []byte, index int) error {
+	return c.destroyKey(keystore.KeySearch, clientID, index)
+}
+
+// ListKeys returns descriptions of current keys
+func (c *Client) ListKeys() ([]keystore.KeyDescription, error) {
+	return c.listKeys(false)
+}
+
+// ListRotatedKeys returns descriptions of rotated keys
+func (c *Client) ListRotatedKeys() ([]keystore.KeyDescription, error) {
+	return c.listKeys(true)
+}
+
+// GetKeyTags returns tags of key ID
+func (c *Client) GetKeyTags(keyID string) (keystore.KeyTags, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	response, err := c.client.GetKeyTags(ctx, &GetKeyTagsRequest{KeyId: keyID})
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return keyTagsFromProto(response.Tags), nil
+}
+
+// SetKeyTags replaces tags of key ID
+func (c *Client) SetKeyTags(keyID string, tags keystore.KeyTags) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.client.SetKeyTags(ctx, &SetKeyTagsRequest{KeyId: keyID, Tags: keyTagsToProto(tags)})
+	return clientError(err)
+}
+
+// Export returns keys exported by remote keystore
+func (c *Client) Export(exportIDs []keystore.ExportID, mode keystore.ExportMode) (*keystore.KeysBackup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	request := &ExportKeysRequest{Ids: make([]*ExportID, 0, len(exportIDs)), Mode: int32(mode)}
+	for _, id := range exportIDs {
+		request.Ids = append(request.Ids, &ExportID{Kind: id.KeyKind, ClientId: id.ContextID})
+	}
+	response, err := c.client.ExportKeys(ctx, request)
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return &keystore.KeysBackup{Keys: response.Keys, Data: response.Data}, nil
+}
+
+func keyDescriptionsToProto(descriptions []keystore.KeyDescription) []*KeyDescription {
+	result := make([]*KeyDescription, 0, len(descriptions))
+	for _, description := range descriptions {
+		converted := &KeyDescription{
+			Index:    int32(description.Index),
+			KeyId:    description.KeyID,
+			State:    string(description.State),
+			Purpose:  string(description.Purpose),
+			ClientId: []byte(description.ClientID),
+			Tags:     keyTagsToProto(description.Tags),
+		}
+		if description.CreationTime != nil {
+			converted.CreationTime = description.CreationTime.Unix()
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+func keyDescriptionsFromProto(descriptions []*KeyDescription) []keystore.KeyDescription {
+	result := make([]keystore.KeyDescription, 0, len(descriptions))
+	for _, description := range descriptions {
+		converted := keystore.KeyDescription{
+			Index:    int(description.Index),
+			KeyID:    description.KeyId,
+			State:    keystore.KeyState(description.State),
+			Purpose:  keystore.KeyPurpose(description.Purpose),
+			ClientID: string(description.ClientId),
+			Tags:     keyTagsFromProto(description.Tags),
+		}
+		if description.CreationTime != 0 {
+			creationTime := time.Unix(description.CreationTime, 0)
+			converted.CreationTime = &creationTime
+		}
+		result = append(result, converted)
+	}
+	return result
+}
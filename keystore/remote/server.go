@@ -0,0 +1,386 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package remote exposes keystore of AcraServer or AcraTranslator over gRPC with mutual TLS, so acra-keys may
+// generate, list, rotate, destroy and export keys without filesystem access to the keystore.
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// Errors returned by remote keystore
+var (
+	ErrAccessDenied       = errors.New("access to remote keystore is denied")
+	ErrUnknownKeyKind     = errors.New("unknown kind of key")
+	ErrTLSConfigRequired  = errors.New("remote keystore requires TLS configuration")
+	ErrOperationFailed    = errors.New("remote keystore operation failed")
+	ErrOperationForbidden = errors.New("operation isn't available in remote keystore")
+)
+
+// ManagedKeyStore is keystore managed by acra-keys remotely
+type ManagedKeyStore interface {
+	keystore.KeyMaking
+	ListKeys() ([]keystore.KeyDescription, error)
+	ListRotatedKeys() ([]keystore.KeyDescription, error)
+}
+
+// Server implements KeyStoreManagementServer over local keystore. Only clients with verified TLS certificates of
+// admin clientIDs are allowed to manage keys
+type Server struct {
+	UnimplementedKeyStoreManagementServer
+	keyStore       ManagedKeyStore
+	exporter       keystore.Exporter
+	adminClientIDs map[string]bool
+	extractor      network.TLSClientIDExtractor
+	// lock serializes modifications, so concurrent requests don't rotate the same key twice
+	lock sync.Mutex
+}
+
+// NewServer returns Server which manages keyStore on behalf of adminClientIDs. exporter may be nil if export of keys
+// isn't allowed
+func NewServer(keyStore ManagedKeyStore, exporter keystore.Exporter, adminClientIDs map[string]bool, extractor network.TLSClientIDExtractor) *Server {
+	return &Server{keyStore: keyStore, exporter: exporter, adminClientIDs: adminClientIDs, extractor: extractor}
+}
+
+// NewGRPCServer returns gRPC server with registered keystore management service which requires client certificates
+// verified with tlsConfig
+func NewGRPCServer(server *Server, tlsConfig *tls.Config) (*grpc.Server, error) {
+	if tlsConfig == nil {
+		return nil, ErrTLSConfigRequired
+	}
+	config := tlsConfig.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	RegisterKeyStoreManagementServer(grpcServer, server)
+	return grpcServer, nil
+}
+
+// authorize returns logger with clientID of verified client certificate if clientID is allowed to manage keys
+func (server *Server) authorize(ctx context.Context, operation string) (*log.Entry, error) {
+	logger := log.WithField("operation", operation)
+	peerInfo, ok := peer.FromContext(ctx)
+	if !ok {
+		return logger, status.Error(codes.Unauthenticated, network.ErrCantExtractClientID.Error())
+	}
+	tlsInfo, ok := peerInfo.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return logger, status.Error(codes.Unauthenticated, network.ErrCantExtractClientID.Error())
+	}
+	clientID, err := server.extractor.ExtractClientID(tlsInfo.State.VerifiedChains[0][0])
+	if err != nil {
+		logger.WithError(err).Warningln("Can't extract clientID from certificate")
+		return logger, status.Error(codes.Unauthenticated, network.ErrCantExtractClientID.Error())
+	}
+	logger = logger.WithField("client_id", string(clientID))
+	if !server.adminClientIDs[string(clientID)] {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorRemoteKeyStoreAccessDenied).Warningln("Denied access to remote keystore")
+		return logger, status.Error(codes.PermissionDenied, ErrAccessDenied.Error())
+	}
+	return logger, nil
+}
+
+// operationError returns gRPC status of failed operation. Details of internal errors are only logged
+func operationError(logger *log.Entry, err error) error {
+	switch {
+	case errors.Is(err, keystore.ErrKeysNotFound):
+		return status.Error(codes.NotFound, keystore.ErrKeysNotFound.Error())
+	case errors.Is(err, keystore.ErrInvalidClientID), errors.Is(err, ErrUnknownKeyKind):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, keystore.ErrApprovalRequired):
+		logger.WithError(err).Warningln("Remote keystore operation requires approval")
+		return status.Error(codes.FailedPrecondition, keystore.ErrApprovalRequired.Error())
+	}
+	logger.WithError(err).Errorln("Remote keystore operation failed")
+	return status.Error(codes.Internal, ErrOperationFailed.Error())
+}
+
+// isClientIDKind returns true if keys of kind belong to clientID
+func isClientIDKind(kind string) bool {
+	switch kind {
+	case keystore.KeyStorageKeypair, keystore.KeySymmetric, keystore.KeySearch:
+		return true
+	}
+	return false
+}
+
+func validateKeyReference(kind string, clientID []byte) error {
+	if isClientIDKind(kind) && !keystore.ValidateID(clientID) {
+		return keystore.ErrInvalidClientID
+	}
+	return nil
+}
+
+// generateKey generates new key or rotates existing one
+func (server *Server) generateKey(kind string, clientID []byte) error {
+	switch kind {
+	case keystore.KeyStorageKeypair:
+		return server.keyStore.GenerateDataEncryptionKeys(clientID)
+	case keystore.KeySymmetric:
+		return server.keyStore.GenerateClientIDSymmetricKey(clientID)
+	case keystore.KeySearch:
+		return server.keyStore.GenerateHmacKey(clientID)
+	case keystore.KeyPoisonKeypair:
+		return server.keyStore.GeneratePoisonKeyPair()
+	case keystore.KeyPoisonSymmetric:
+		return server.keyStore.GeneratePoisonSymmetricKey()
+	case string(keystore.PurposeAuditLog):
+		return server.keyStore.GenerateLogKey()
+	}
+	return ErrUnknownKeyKind
+}
+
+// keyKindOfPurpose returns kind of keys with purpose like used by generateKey and destroyKey
+func keyKindOfPurpose(purpose keystore.KeyPurpose) string {
+	switch purpose {
+	case keystore.PurposeStorageClientKeyPair:
+		return keystore.KeyStorageKeypair
+	case keystore.PurposeAuditLog:
+		return string(keystore.PurposeAuditLog)
+	}
+	return keystore.KeyPurposeToKeyKind[purpose]
+}
+
+// keyExists returns true if keystore has current key of kind, so generation of key rotates it
+func (server *Server) keyExists(kind string, clientID []byte) (bool, error) {
+	descriptions, err := server.keyStore.ListKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, description := range descriptions {
+		if keyKindOfPurpose(description.Purpose) != kind {
+			continue
+		}
+		if !isClientIDKind(kind) || description.ClientID == string(clientID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkApproval returns keystore.ErrApprovalRequired if keystore has approval policy. Such keystore allows
+// destruction and rotation of keys only with "acra-keys approve" by two operators, which isn't available remotely
+func (server *Server) checkApproval(kind string, clientID []byte, rotation bool) error {
+	err := keystore.CheckApprovalPolicy(server.keyStore)
+	if err != keystore.ErrApprovalRequired || !rotation {
+		return err
+	}
+	// generation of the first key isn't rotation and doesn't require approval
+	exists, err := server.keyExists(kind, clientID)
+	if err != nil && !errors.Is(err, keystore.ErrKeysNotFound) {
+		return err
+	}
+	if exists {
+		return keystore.ErrApprovalRequired
+	}
+	return nil
+}
+
+// destroyKey destroys current key or rotated key with index greater than 1, like `acra-keys destroy`
+func (server *Server) destroyKey(kind string, clientID []byte, index int) error {
+	rotated := index > 1
+	switch kind {
+	case keystore.KeyStorageKeypair:
+		if rotated {
+			return server.keyStore.DestroyRotatedClientIDEncryptionKeyPair(clientID, index)
+		}
+		return server.keyStore.DestroyClientIDEncryptionKeyPair(clientID)
+	case keystore.KeySymmetric:
+		if rotated {
+			return server.keyStore.DestroyRotatedClientIDSymmetricKey(clientID, index)
+		}
+		return server.keyStore.DestroyClientIDSymmetricKey(clientID)
+	case keystore.KeySearch:
+		if rotated {
+			return server.keyStore.DestroyRotatedHmacSecretKey(clientID, index)
+		}
+		return server.keyStore.DestroyHmacSecretKey(clientID)
+	case keystore.KeyPoisonKeypair:
+		if rotated {
+			return server.keyStore.DestroyRotatedPoisonKeyPair(index)
+		}
+		return server.keyStore.DestroyPoisonKeyPair()
+	case keystore.KeyPoisonSymmetric:
+		if rotated {
+			return server.keyStore.DestroyRotatedPoisonSymmetricKey(index)
+		}
+		return server.keyStore.DestroyPoisonSymmetricKey()
+	}
+	return ErrUnknownKeyKind
+}
+
+// GenerateKey generates new key of requested kind or rotates existing one
+func (server *Server) GenerateKey(ctx context.Context, request *GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	logger, err := server.authorize(ctx, "GenerateKey")
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.WithFields(log.Fields{"kind": request.Kind, "key_client_id": string(request.ClientId)})
+	if err := validateKeyReference(request.Kind, request.ClientId); err != nil {
+		return nil, operationError(logger, err)
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if err := server.checkApproval(request.Kind, request.ClientId, true); err != nil {
+		return nil, operationError(logger, err)
+	}
+	if err := server.generateKey(request.Kind, request.ClientId); err != nil {
+		return nil, operationError(logger, err)
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeRemoteKeyStoreOperation).Infoln("Generated key")
+	return &GenerateKeyResponse{}, nil
+}
+
+// DestroyKey destroys current or rotated key
+func (server *Server) DestroyKey(ctx context.Context, request *DestroyKeyRequest) (*DestroyKeyResponse, error) {
+	logger, err := server.authorize(ctx, "DestroyKey")
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.WithFields(log.Fields{"kind": request.Kind, "key_client_id": string(request.ClientId), "index": request.Index})
+	if err := validateKeyReference(request.Kind, request.ClientId); err != nil {
+		return nil, operationError(logger, err)
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if err := server.checkApproval(request.Kind, request.ClientId, false); err != nil {
+		return nil, operationError(logger, err)
+	}
+	if err := server.destroyKey(request.Kind, request.ClientId, int(request.Index)); err != nil {
+		return nil, operationError(logger, err)
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeRemoteKeyStoreOperation).Infoln("Destroyed key")
+	return &DestroyKeyResponse{}, nil
+}
+
+// ListKeys returns descriptions of current or rotated keys
+func (server *Server) ListKeys(ctx context.Context, request *ListKeysRequest) (*ListKeysResponse, error) {
+	logger, err := server.authorize(ctx, "ListKeys")
+	if err != nil {
+		return nil, err
+	}
+	var descriptions []keystore.KeyDescription
+	if request.Rotated {
+		descriptions, err = server.keyStore.ListRotatedKeys()
+	} else {
+		descriptions, err = server.keyStore.ListKeys()
+	}
+	if err != nil {
+		return nil, operationError(logger, err)
+	}
+	return &ListKeysResponse{Keys: keyDescriptionsToProto(descriptions)}, nil
+}
+
+func (server *Server) tagStore() (keystore.KeyTagStore, error) {
+	tagStore, ok := server.keyStore.(keystore.KeyTagStore)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "keystore doesn't support tags of keys")
+	}
+	return tagStore, nil
+}
+
+// GetKeyTags returns tags of key ID
+func (server *Server) GetKeyTags(ctx context.Context, request *GetKeyTagsRequest) (*GetKeyTagsResponse, error) {
+	logger, err := server.authorize(ctx, "GetKeyTags")
+	if err != nil {
+		return nil, err
+	}
+	tagStore, err := server.tagStore()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := tagStore.GetKeyTags(request.KeyId)
+	if err != nil {
+		return nil, operationError(logger.WithField("key_id", request.KeyId), err)
+	}
+	return &GetKeyTagsResponse{Tags: keyTagsToProto(tags)}, nil
+}
+
+// SetKeyTags replaces tags of key ID
+func (server *Server) SetKeyTags(ctx context.Context, request *SetKeyTagsRequest) (*SetKeyTagsResponse, error) {
+	logger, err := server.authorize(ctx, "SetKeyTags")
+	if err != nil {
+		return nil, err
+	}
+	tagStore, err := server.tagStore()
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.WithField("key_id", request.KeyId)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if err := tagStore.SetKeyTags(request.KeyId, keyTagsFromProto(request.Tags)); err != nil {
+		return nil, operationError(logger, err)
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeRemoteKeyStoreOperation).Infoln("Set tags of key")
+	return &SetKeyTagsResponse{}, nil
+}
+
+// ExportKeys exports keys encrypted with new key like `acra-keys export`
+func (server *Server) ExportKeys(ctx context.Context, request *ExportKeysRequest) (*ExportKeysResponse, error) {
+	logger, err := server.authorize(ctx, "ExportKeys")
+	if err != nil {
+		return nil, err
+	}
+	if server.exporter == nil {
+		return nil, status.Error(codes.Unimplemented, ErrOperationForbidden.Error())
+	}
+	exportIDs := make([]keystore.ExportID, 0, len(request.Ids))
+	for _, id := range request.Ids {
+		exportIDs = append(exportIDs, keystore.ExportID{KeyKind: id.Kind, ContextID: id.ClientId})
+	}
+	mode := keystore.ExportMode(request.Mode)
+	logger = logger.WithField("private", mode&(keystore.ExportPrivateKeys|keystore.ExportAllKeys) != 0)
+	backup, err := server.exporter.Export(exportIDs, mode)
+	if err != nil {
+		return nil, operationError(logger, err)
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeRemoteKeyStoreOperation).Infoln("Exported keys")
+	return &ExportKeysResponse{Keys: backup.Keys, Data: backup.Data}, nil
+}
+
+func keyTagsToProto(tags keystore.KeyTags) []*KeyTag {
+	result := make([]*KeyTag, 0, len(tags))
+	for name, value := range tags {
+		result = append(result, &KeyTag{Name: name, Value: value})
+	}
+	return result
+}
+
+func keyTagsFromProto(tags []*KeyTag) keystore.KeyTags {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make(keystore.KeyTags, len(tags))
+	for _, tag := range tags {
+		result[tag.Name] = tag.Value
+	}
+	return result
+}
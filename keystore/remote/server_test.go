@@ -0,0 +1,361 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// testKeyStore records operations instead of keys
+type testKeyStore struct {
+	operations []string
+	keys       []keystore.KeyDescription
+	tags       map[string]keystore.KeyTags
+}
+
+func (k *testKeyStore) record(operation string, args ...interface{}) error {
+	k.operations = append(k.operations, fmt.Sprint(append([]interface{}{operation}, args...)...))
+	return nil
+}
+
+func (k *testKeyStore) GenerateDataEncryptionKeys(clientID []byte) error {
+	return k.record("generate storage ", string(clientID))
+}
+func (k *testKeyStore) SaveDataEncryptionKeys(clientID []byte, keypair *keys.Keypair) error {
+	return k.record("save storage ", string(clientID))
+}
+func (k *testKeyStore) GenerateClientIDSymmetricKey(clientID []byte) error {
+	return k.record("generate symmetric ", string(clientID))
+}
+func (k *testKeyStore) GenerateHmacKey(clientID []byte) error {
+	return k.record("generate hmac ", string(clientID))
+}
+func (k *testKeyStore) GeneratePoisonKeyPair() error { return k.record("generate poison keypair") }
+func (k *testKeyStore) GeneratePoisonSymmetricKey() error {
+	return k.record("generate poison symmetric")
+}
+func (k *testKeyStore) GenerateLogKey() error            { return k.record("generate audit log") }
+func (k *testKeyStore) DestroyPoisonKeyPair() error      { return k.record("destroy poison keypair") }
+func (k *testKeyStore) DestroyPoisonSymmetricKey() error { return k.record("destroy poison symmetric") }
+func (k *testKeyStore) DestroyClientIDEncryptionKeyPair(clientID []byte) error {
+	return keystore.ErrKeysNotFound
+}
+func (k *testKeyStore) DestroyClientIDSymmetricKey(clientID []byte) error {
+	return k.record("destroy symmetric ", string(clientID))
+}
+func (k *testKeyStore) DestroyHmacSecretKey(clientID []byte) error {
+	return k.record("destroy hmac ", string(clientID))
+}
+func (k *testKeyStore) DestroyRotatedPoisonKeyPair(index int) error {
+	return k.record("destroy rotated poison keypair ", index)
+}
+func (k *testKeyStore) DestroyRotatedPoisonSymmetricKey(index int) error {
+	return k.record("destroy rotated poison symmetric ", index)
+}
+func (k *testKeyStore) DestroyRotatedClientIDEncryptionKeyPair(clientID []byte, index int) error {
+	return k.record("destroy rotated storage ", string(clientID), " ", index)
+}
+func (k *testKeyStore) DestroyRotatedClientIDSymmetricKey(clientID []byte, index int) error {
+	return k.record("destroy rotated symmetric ", string(clientID), " ", index)
+}
+func (k *testKeyStore) DestroyRotatedHmacSecretKey(clientID []byte, index int) error {
+	return k.record("destroy rotated hmac ", string(clientID), " ", index)
+}
+func (k *testKeyStore) ListKeys() ([]keystore.KeyDescription, error) { return k.keys, nil }
+func (k *testKeyStore) ListRotatedKeys() ([]keystore.KeyDescription, error) {
+	return nil, keystore.ErrKeysNotFound
+}
+func (k *testKeyStore) GetKeyTags(keyID string) (keystore.KeyTags, error) { return k.tags[keyID], nil }
+func (k *testKeyStore) SetKeyTags(keyID string, tags keystore.KeyTags) error {
+	k.tags[keyID] = tags
+	return nil
+}
+
+type testExporter struct {
+	ids  []keystore.ExportID
+	mode keystore.ExportMode
+}
+
+func (e *testExporter) Export(exportIDs []keystore.ExportID, mode keystore.ExportMode) (*keystore.KeysBackup, error) {
+	e.ids, e.mode = exportIDs, mode
+	return &keystore.KeysBackup{Keys: []byte("keys"), Data: []byte("data")}, nil
+}
+
+// commonNameExtractor uses common name of certificate as clientID
+type commonNameExtractor struct{}
+
+func (commonNameExtractor) ExtractClientID(certificate *x509.Certificate) ([]byte, error) {
+	return []byte(certificate.Subject.CommonName), nil
+}
+
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &testCA{certificate: certificate, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startTestServer serves Server over in-memory listener and returns function which connects clients with
+// certificate of clientID
+func startTestServer(t *testing.T, server *Server) func(clientID string) *Client {
+	ca := newTestCA(t)
+	serverTLSConfig := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", 2)}, ClientCAs: ca.pool}
+	grpcServer, err := NewGRPCServer(server, serverTLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1024 * 1024)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	serial := int64(2)
+	return func(clientID string) *Client {
+		serial++
+		clientTLSConfig := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, clientID, serial)}, RootCAs: ca.pool, ServerName: "server"}
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return listener.DialContext(ctx) }),
+			grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewClientWithConnection(conn)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+}
+
+func TestRemoteKeyStore(t *testing.T) {
+	creationTime := time.Unix(1600000000, 0)
+	keyStore := &testKeyStore{
+		keys: []keystore.KeyDescription{
+			{Index: 1, KeyID: "client/storage", State: keystore.StateCurrent, Purpose: keystore.PurposeStorageClientKeyPair, ClientID: "client", CreationTime: &creationTime, Tags: keystore.KeyTags{"env": "prod"}},
+			{Index: 1, KeyID: "poison_key", State: keystore.StateCurrent, Purpose: keystore.PurposePoisonRecordKeyPair},
+		},
+		tags: make(map[string]keystore.KeyTags),
+	}
+	exporter := &testExporter{}
+	connect := startTestServer(t, NewServer(keyStore, exporter, map[string]bool{"admin": true}, commonNameExtractor{}))
+	admin := connect("admin")
+
+	operations := []func() error{
+		func() error { return admin.GenerateDataEncryptionKeys([]byte("client")) },
+		func() error { return admin.GenerateClientIDSymmetricKey([]byte("client")) },
+		func() error { return admin.GenerateHmacKey([]byte("client")) },
+		admin.GeneratePoisonKeyPair,
+		admin.GenerateLogKey,
+		func() error { return admin.DestroyClientIDSymmetricKey([]byte("client")) },
+		func() error { return admin.DestroyRotatedHmacSecretKey([]byte("client"), 2) },
+		func() error { return admin.DestroyRotatedPoisonKeyPair(3) },
+	}
+	for i, operation := range operations {
+		if err := operation(); err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+	}
+	expectedOperations := []string{
+		"generate storage client",
+		"generate symmetric client",
+		"generate hmac client",
+		"generate poison keypair",
+		"generate audit log",
+		"destroy symmetric client",
+		"destroy rotated hmac client 2",
+		"destroy rotated poison keypair 3",
+	}
+	if !reflect.DeepEqual(keyStore.operations, expectedOperations) {
+		t.Fatalf("Unexpected operations %q", keyStore.operations)
+	}
+
+	if err := admin.GenerateDataEncryptionKeys([]byte("a")); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for invalid clientID, took %v", err)
+	}
+	if err := admin.generateKey("unknown", nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for unknown kind, took %v", err)
+	}
+	if err := admin.DestroyClientIDEncryptionKeyPair([]byte("client")); err != keystore.ErrKeysNotFound {
+		t.Fatalf("Expected ErrKeysNotFound, took %v", err)
+	}
+	if err := admin.SaveDataEncryptionKeys([]byte("client"), nil); err != ErrOperationForbidden {
+		t.Fatalf("Expected ErrOperationForbidden, took %v", err)
+	}
+
+	descriptions, err := admin.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(descriptions, keyStore.keys) {
+		t.Fatalf("Expected %+v, took %+v", keyStore.keys, descriptions)
+	}
+	if _, err := admin.ListRotatedKeys(); err != keystore.ErrKeysNotFound {
+		t.Fatalf("Expected ErrKeysNotFound, took %v", err)
+	}
+
+	tags := keystore.KeyTags{"env": "prod", "team": "billing"}
+	if err := admin.SetKeyTags("client/storage", tags); err != nil {
+		t.Fatal(err)
+	}
+	if savedTags, err := admin.GetKeyTags("client/storage"); err != nil || !reflect.DeepEqual(savedTags, tags) {
+		t.Fatalf("Expected %v, took %v, %v", tags, savedTags, err)
+	}
+
+	exportIDs := []keystore.ExportID{{KeyKind: keystore.KeyStorageKeypair, ContextID: []byte("client")}}
+	backup, err := admin.Export(exportIDs, keystore.ExportPrivateKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup.Keys) != "keys" || string(backup.Data) != "data" || !reflect.DeepEqual(exporter.ids, exportIDs) || exporter.mode != keystore.ExportPrivateKeys {
+		t.Fatalf("Unexpected export %+v, %+v", backup, exporter)
+	}
+
+	operationsCount := len(keyStore.operations)
+	other := connect("other")
+	if _, err := other.ListKeys(); err != ErrAccessDenied {
+		t.Fatalf("Expected ErrAccessDenied, took %v", err)
+	}
+	if err := other.GenerateDataEncryptionKeys([]byte("client")); err != ErrAccessDenied {
+		t.Fatalf("Expected ErrAccessDenied, took %v", err)
+	}
+	if len(keyStore.operations) != operationsCount {
+		t.Fatal("Denied requests shouldn't change keystore")
+	}
+}
+
+func TestRemoteKeyStoreWithoutExport(t *testing.T) {
+	connect := startTestServer(t, NewServer(&testKeyStore{}, nil, map[string]bool{"admin": true}, commonNameExtractor{}))
+	if _, err := connect("admin").Export(nil, keystore.ExportPublicOnly); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Expected Unimplemented, took %v", err)
+	}
+}
+
+func TestNewGRPCServerRequiresTLS(t *testing.T) {
+	if _, err := NewGRPCServer(NewServer(&testKeyStore{}, nil, nil, commonNameExtractor{}), nil); err != ErrTLSConfigRequired {
+		t.Fatalf("Expected ErrTLSConfigRequired, took %v", err)
+	}
+}
+
+// testApprovalKeyStore is keystore with approval policy
+type testApprovalKeyStore struct {
+	*testKeyStore
+	keystore.ApprovalStore
+}
+
+func (k *testApprovalKeyStore) GetApprovalPolicy() (*keystore.ApprovalPolicy, error) {
+	return &keystore.ApprovalPolicy{}, nil
+}
+
+func TestRemoteKeyStoreWithApprovalPolicy(t *testing.T) {
+	keyStore := &testKeyStore{
+		keys: []keystore.KeyDescription{
+			{Index: 1, KeyID: "client/storage", State: keystore.StateCurrent, Purpose: keystore.PurposeStorageClientKeyPair, ClientID: "client"},
+			{Index: 1, KeyID: "poison_key", State: keystore.StateCurrent, Purpose: keystore.PurposePoisonRecordKeyPair},
+		},
+	}
+	connect := startTestServer(t, NewServer(&testApprovalKeyStore{testKeyStore: keyStore}, nil, map[string]bool{"admin": true}, commonNameExtractor{}))
+	admin := connect("admin")
+
+	// destruction and rotation of existing keys require approval of two operators
+	deniedOperations := []func() error{
+		func() error { return admin.DestroyClientIDSymmetricKey([]byte("client")) },
+		func() error { return admin.DestroyRotatedPoisonKeyPair(2) },
+		func() error { return admin.GenerateDataEncryptionKeys([]byte("client")) },
+		admin.GeneratePoisonKeyPair,
+	}
+	for i, operation := range deniedOperations {
+		if err := operation(); err != keystore.ErrApprovalRequired {
+			t.Fatalf("[%d] Expected ErrApprovalRequired, took %v", i, err)
+		}
+	}
+	// generation of new keys isn't rotation
+	if err := admin.GenerateDataEncryptionKeys([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.GenerateClientIDSymmetricKey([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	expectedOperations := []string{"generate storage other", "generate symmetric client"}
+	if !reflect.DeepEqual(keyStore.operations, expectedOperations) {
+		t.Fatalf("Unexpected operations %q", keyStore.operations)
+	}
+}
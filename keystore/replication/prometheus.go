@@ -0,0 +1,50 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package replication
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labels of replicatedFilesCounter
+const (
+	replicationStatusSuccess = "success"
+	replicationStatusFailure = "failure"
+)
+
+var replicatedFilesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acra_keystore_replicated_files_total",
+		Help: "number of key files copied to standby keystore by status",
+	}, []string{"status"})
+
+var lastReplicationTimestamp = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "acra_keystore_replication_last_run_timestamp_seconds",
+		Help: "unix time of the last replication of keys to standby keystore",
+	})
+
+var registerLock = sync.Once{}
+
+// RegisterMetrics register in default prometheus registry metrics related with keystore replication
+func RegisterMetrics() {
+	registerLock.Do(func() {
+		prometheus.MustRegister(replicatedFilesCounter)
+		prometheus.MustRegister(lastReplicationTimestamp)
+	})
+}
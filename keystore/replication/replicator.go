@@ -0,0 +1,209 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// ErrInvalidLocation returned if source or standby keystore location is not configured or they are the same
+var ErrInvalidLocation = errors.New("invalid keystore replication location")
+
+// ErrInvalidInterval returned for non-positive interval of replication
+var ErrInvalidInterval = errors.New("keystore replication interval should be positive")
+
+// ErrIntegrityCheckFailed returned if key file copied to standby keystore differs from the source
+var ErrIntegrityCheckFailed = errors.New("replicated key file doesn't match the source")
+
+// ErrReplicationFailed returned if some of key files weren't replicated
+var ErrReplicationFailed = errors.New("failed to replicate keys")
+
+// replicationDirMode is the mode of directories created in standby keystore, same as keystore v1 expects
+const replicationDirMode = os.FileMode(0700)
+
+// Location is a keystore directory in filesystem or Redis storage
+type Location struct {
+	Storage filesystem.Storage
+	Dir     string
+}
+
+// Replicator copies key files which are new or changed in the source keystore to the standby keystore. Files are
+// never removed from the standby, so destroyed keys should be removed there explicitly.
+type Replicator struct {
+	source      Location
+	destination Location
+}
+
+// NewReplicator returns Replicator of keys from source to destination keystore location
+func NewReplicator(source, destination Location) (*Replicator, error) {
+	if source.Storage == nil || destination.Storage == nil || source.Dir == "" || destination.Dir == "" {
+		return nil, ErrInvalidLocation
+	}
+	if source.Storage == destination.Storage && filepath.Clean(source.Dir) == filepath.Clean(destination.Dir) {
+		return nil, ErrInvalidLocation
+	}
+	return &Replicator{source: source, destination: destination}, nil
+}
+
+// Replicate copies new and changed key files to the standby keystore and returns number of copied files.
+// Failure of one file doesn't stop replication of others, ErrReplicationFailed is returned in this case.
+func (replicator *Replicator) Replicate() (int, error) {
+	if err := replicator.destination.Storage.MkdirAll(replicator.destination.Dir, replicationDirMode); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReplicateKeys).
+			WithField("path", replicator.destination.Dir).Errorln("Can't create standby keystore directory")
+		return 0, err
+	}
+	lastReplicationTimestamp.SetToCurrentTime()
+	replicated, failed, err := replicator.replicateDir("")
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReplicateKeys).Errorln("Can't list keys for replication")
+		return replicated, err
+	}
+	log.WithField("replicated", replicated).WithField("failed", failed).Debugln("Finished replication of keys")
+	if failed > 0 {
+		return replicated, fmt.Errorf("%w: %d of %d", ErrReplicationFailed, failed, replicated+failed)
+	}
+	return replicated, nil
+}
+
+// replicateDir replicates files of source directory relative to keystore root including subdirectories
+func (replicator *Replicator) replicateDir(relDir string) (replicated, failed int, err error) {
+	entries, err := replicator.source.Storage.ReadDir(filepath.Join(replicator.source.Dir, relDir))
+	if err != nil {
+		// Redis storage doesn't distinguish empty and missing directories
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		relPath := filepath.Join(relDir, entry.Name())
+		if entry.IsDir() {
+			dirReplicated, dirFailed, err := replicator.replicateDir(relPath)
+			if err != nil {
+				return replicated, failed, err
+			}
+			replicated += dirReplicated
+			failed += dirFailed
+			continue
+		}
+		logger := log.WithField("path", relPath)
+		copied, err := replicator.replicateFile(relPath, entry.Mode().Perm())
+		if err != nil {
+			failed++
+			replicatedFilesCounter.WithLabelValues(replicationStatusFailure).Inc()
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReplicateKeys).Errorln("Can't replicate key file")
+			continue
+		}
+		if copied {
+			replicated++
+			replicatedFilesCounter.WithLabelValues(replicationStatusSuccess).Inc()
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeKeyReplication).Infoln("Replicated key file")
+		}
+	}
+	return replicated, failed, nil
+}
+
+// replicateFile copies file to the standby keystore if it's missing or differs there. The file is written to temporary
+// file first, verified and then renamed, so the standby never has partially written keys.
+func (replicator *Replicator) replicateFile(relPath string, perm os.FileMode) (bool, error) {
+	data, err := replicator.source.Storage.ReadFile(filepath.Join(replicator.source.Dir, relPath))
+	if err != nil {
+		return false, err
+	}
+	defer utils.ZeroizeBytes(data)
+	sourceHash := sha256.Sum256(data)
+
+	destination := replicator.destination.Storage
+	destinationPath := filepath.Join(replicator.destination.Dir, relPath)
+	if same, err := replicator.hasSameContent(destinationPath, sourceHash[:]); err != nil || same {
+		return false, err
+	}
+	if err := destination.MkdirAll(filepath.Dir(destinationPath), replicationDirMode); err != nil {
+		return false, err
+	}
+	tempPath, err := destination.TempFile(destinationPath+".replication", perm)
+	if err != nil {
+		return false, err
+	}
+	if err := destination.WriteFile(tempPath, data, perm); err != nil {
+		replicator.removeTempFile(tempPath)
+		return false, err
+	}
+	same, err := replicator.hasSameContent(tempPath, sourceHash[:])
+	if err == nil && !same {
+		err = ErrIntegrityCheckFailed
+	}
+	if err != nil {
+		replicator.removeTempFile(tempPath)
+		return false, err
+	}
+	if err := destination.Rename(tempPath, destinationPath); err != nil {
+		replicator.removeTempFile(tempPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// hasSameContent returns true if standby keystore has file with given SHA-256 hash
+func (replicator *Replicator) hasSameContent(path string, hash []byte) (bool, error) {
+	data, err := replicator.destination.Storage.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer utils.ZeroizeBytes(data)
+	dataHash := sha256.Sum256(data)
+	return bytes.Equal(dataHash[:], hash), nil
+}
+
+func (replicator *Replicator) removeTempFile(path string) {
+	if err := replicator.destination.Storage.Remove(path); err != nil {
+		log.WithError(err).WithField("path", path).Warnln("Can't remove temporary file of replicated key")
+	}
+}
+
+// Run replicates keys with given interval until ctx is done
+func (replicator *Replicator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// failed files will be replicated with the next run
+		if _, err := replicator.Replicate(); err != nil {
+			log.WithError(err).Warnln("Replication of keys finished with errors")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore/filesystem"
+)
+
+// corruptingStorage flips bits of written data to emulate broken standby storage
+type corruptingStorage struct {
+	filesystem.DummyStorage
+}
+
+func (s *corruptingStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	corrupted := append([]byte{}, data...)
+	corrupted[0] ^= 0xff
+	return s.DummyStorage.WriteFile(path, corrupted, perm)
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewReplicator(t *testing.T) {
+	storage := &filesystem.DummyStorage{}
+	dir := t.TempDir()
+	invalid := [][2]Location{
+		{{Storage: storage, Dir: dir}, {Storage: storage, Dir: ""}},
+		{{Storage: nil, Dir: dir}, {Storage: storage, Dir: dir + "-standby"}},
+		{{Storage: storage, Dir: dir}, {Storage: storage, Dir: dir + "/"}},
+	}
+	for _, locations := range invalid {
+		if _, err := NewReplicator(locations[0], locations[1]); err != ErrInvalidLocation {
+			t.Fatalf("Expected %v for %v, took %v", ErrInvalidLocation, locations, err)
+		}
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "source")
+	standbyDir := filepath.Join(t.TempDir(), "standby")
+	writeTestFile(t, filepath.Join(sourceDir, "client_storage"), []byte("private key"))
+	writeTestFile(t, filepath.Join(sourceDir, "client_storage.pub"), []byte("public key"))
+	writeTestFile(t, filepath.Join(sourceDir, ".history", "client_storage", "2020-01-01"), []byte("rotated key"))
+
+	storage := &filesystem.DummyStorage{}
+	replicator, err := NewReplicator(Location{Storage: storage, Dir: sourceDir}, Location{Storage: storage, Dir: standbyDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicated, err := replicator.Replicate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicated != 3 {
+		t.Fatalf("Expected 3 replicated files, took %d", replicated)
+	}
+	data, err := os.ReadFile(filepath.Join(standbyDir, ".history", "client_storage", "2020-01-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("rotated key")) {
+		t.Fatalf("Unexpected content of replicated file: %s", data)
+	}
+	info, err := os.Stat(filepath.Join(standbyDir, "client_storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected mode of source file, took %v", info.Mode())
+	}
+
+	// unchanged files are skipped, changed ones are copied again
+	writeTestFile(t, filepath.Join(sourceDir, "client_storage"), []byte("new private key"))
+	replicated, err = replicator.Replicate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicated != 1 {
+		t.Fatalf("Expected 1 replicated file, took %d", replicated)
+	}
+	data, err = os.ReadFile(filepath.Join(standbyDir, "client_storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("new private key")) {
+		t.Fatalf("Unexpected content of replicated file: %s", data)
+	}
+
+	// files removed from source stay in standby
+	if err := os.Remove(filepath.Join(sourceDir, "client_storage.pub")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replicator.Replicate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(standbyDir, "client_storage.pub")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicateIntegrityCheck(t *testing.T) {
+	sourceDir := t.TempDir()
+	standbyDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "client_storage"), []byte("private key"))
+
+	replicator, err := NewReplicator(Location{Storage: &filesystem.DummyStorage{}, Dir: sourceDir}, Location{Storage: &corruptingStorage{}, Dir: standbyDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicated, err := replicator.Replicate()
+	if !errors.Is(err, ErrReplicationFailed) {
+		t.Fatalf("Expected %v, took %v", ErrReplicationFailed, err)
+	}
+	if replicated != 0 {
+		t.Fatalf("Expected no replicated files, took %d", replicated)
+	}
+	entries, err := os.ReadDir(standbyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "client_storage") {
+			t.Fatalf("Expected no corrupted or temporary files in standby, took %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rotation
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labels of keyRotationCounter
+const (
+	rotationStatusSuccess = "success"
+	rotationStatusFailure = "failure"
+)
+
+var keyRotationCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acra_key_rotations_total",
+		Help: "number of scheduled rotations of keys by kind and status",
+	}, []string{"kind", "status"})
+
+var lastRotationTimestamp = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "acra_key_rotation_last_run_timestamp_seconds",
+		Help: "unix time of the last scheduled rotation of keys",
+	})
+
+var registerLock = sync.Once{}
+
+// RegisterMetrics register in default prometheus registry metrics related with scheduled key rotation
+func RegisterMetrics() {
+	registerLock.Do(func() {
+		prometheus.MustRegister(keyRotationCounter)
+		prometheus.MustRegister(lastRotationTimestamp)
+	})
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+)
+
+// ErrUnsupportedKeyKind returned for kinds of keys which can't be rotated on schedule
+var ErrUnsupportedKeyKind = errors.New("unsupported kind of key for scheduled rotation")
+
+// ErrRotationFailed returned if some of selected keys weren't rotated
+var ErrRotationFailed = errors.New("failed to rotate keys")
+
+// SupportedKeyKinds are kinds of per-clientID keys which may be rotated on schedule. Data encrypted with them stays
+// decryptable with rotated versions. Search HMAC keys aren't rotated because search over existing data depends on them.
+var SupportedKeyKinds = []string{keystore.KeyStorageKeypair, keystore.KeySymmetric}
+
+// KeyStore generates new versions of clientID keys keeping previous versions as rotated keys
+type KeyStore interface {
+	ListKeys() ([]keystore.KeyDescription, error)
+	GenerateDataEncryptionKeys(clientID []byte) error
+	GenerateClientIDSymmetricKey(clientID []byte) error
+}
+
+// ParseKeyKinds parses comma-separated list of key kinds
+func ParseKeyKinds(value string) ([]string, error) {
+	kinds := make([]string, 0, len(SupportedKeyKinds))
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if !isSupportedKind(kind) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyKind, kind)
+		}
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) == 0 {
+		return nil, ErrUnsupportedKeyKind
+	}
+	return kinds, nil
+}
+
+func isSupportedKind(kind string) bool {
+	for _, supportedKind := range SupportedKeyKinds {
+		if kind == supportedKind {
+			return true
+		}
+	}
+	return false
+}
+
+// Rotator generates new versions of current clientID keys of configured kinds
+type Rotator struct {
+	keyStore KeyStore
+	kinds    map[string]bool
+	selector keystore.KeyTags
+}
+
+// NewRotator returns Rotator of keys with kinds and tags matching the selector. Empty selector matches all keys.
+func NewRotator(keyStore KeyStore, kinds []string, selector keystore.KeyTags) (*Rotator, error) {
+	kindSet := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		if !isSupportedKind(kind) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyKind, kind)
+		}
+		kindSet[kind] = true
+	}
+	return &Rotator{keyStore: keyStore, kinds: kindSet, selector: selector}, nil
+}
+
+type rotatedKey struct {
+	kind     string
+	clientID string
+}
+
+// selectKeys returns kinds and clientIDs of current keys to rotate. Parts of key pairs are described separately but
+// rotated once.
+func (rotator *Rotator) selectKeys(descriptions []keystore.KeyDescription) []rotatedKey {
+	selected := make([]rotatedKey, 0, len(descriptions))
+	seen := make(map[rotatedKey]bool, len(descriptions))
+	for _, description := range keystore.FilterKeysByTags(descriptions, rotator.selector) {
+		if description.State == keystore.StateRotated || description.ClientID == "" {
+			continue
+		}
+		kind, ok := keystore.KeyPurposeToKeyKind[description.Purpose]
+		if !ok || !rotator.kinds[kind] {
+			continue
+		}
+		key := rotatedKey{kind: kind, clientID: description.ClientID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		selected = append(selected, key)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].clientID != selected[j].clientID {
+			return selected[i].clientID < selected[j].clientID
+		}
+		return selected[i].kind < selected[j].kind
+	})
+	return selected
+}
+
+// Rotate generates new versions of all selected keys and returns number of rotated keys. Failure of one key doesn't
+// stop rotation of others, ErrRotationFailed is returned in this case.
+func (rotator *Rotator) Rotate() (int, error) {
+	descriptions, err := rotator.keyStore.ListKeys()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantRotateKeys).Errorln("Can't list keys for rotation")
+		return 0, err
+	}
+	lastRotationTimestamp.SetToCurrentTime()
+	rotated, failed := 0, 0
+	for _, key := range rotator.selectKeys(descriptions) {
+		logger := log.WithFields(log.Fields{"kind": key.kind, "client_id": key.clientID})
+		var err error
+		switch key.kind {
+		case keystore.KeyStorageKeypair:
+			err = rotator.keyStore.GenerateDataEncryptionKeys([]byte(key.clientID))
+		case keystore.KeySymmetric:
+			err = rotator.keyStore.GenerateClientIDSymmetricKey([]byte(key.clientID))
+		}
+		if err != nil {
+			failed++
+			keyRotationCounter.WithLabelValues(key.kind, rotationStatusFailure).Inc()
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantRotateKeys).Errorln("Can't rotate key")
+			continue
+		}
+		rotated++
+		keyRotationCounter.WithLabelValues(key.kind, rotationStatusSuccess).Inc()
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeKeyRotation).Infoln("Rotated key")
+	}
+	log.WithField("rotated", rotated).WithField("failed", failed).Infoln("Finished scheduled rotation of keys")
+	if failed > 0 {
+		return rotated, fmt.Errorf("%w: %d of %d", ErrRotationFailed, failed, rotated+failed)
+	}
+	return rotated, nil
+}
+
+// Run rotates keys at times returned by schedule until ctx is done
+func (rotator *Rotator) Run(ctx context.Context, schedule Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).Errorln("Rotation schedule has no next time, scheduled rotation stopped")
+			return
+		}
+		log.WithField("next_rotation", next.Format(time.RFC3339)).Debugln("Scheduled next rotation of keys")
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// failed keys will be rotated with the next run
+		if _, err := rotator.Rotate(); err != nil {
+			log.WithError(err).Warnln("Scheduled rotation of keys finished with errors")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rotation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+var errTestGeneration = errors.New("generation failed")
+
+type testKeyStore struct {
+	keys      []keystore.KeyDescription
+	generated []string
+}
+
+func (store *testKeyStore) ListKeys() ([]keystore.KeyDescription, error) {
+	return store.keys, nil
+}
+
+func (store *testKeyStore) GenerateDataEncryptionKeys(clientID []byte) error {
+	if string(clientID) == "broken" {
+		return errTestGeneration
+	}
+	store.generated = append(store.generated, keystore.KeyStorageKeypair+":"+string(clientID))
+	return nil
+}
+
+func (store *testKeyStore) GenerateClientIDSymmetricKey(clientID []byte) error {
+	store.generated = append(store.generated, keystore.KeySymmetric+":"+string(clientID))
+	return nil
+}
+
+func TestRotator(t *testing.T) {
+	store := &testKeyStore{keys: []keystore.KeyDescription{
+		{Purpose: keystore.PurposeStorageClientPrivateKey, ClientID: "client1", State: keystore.StateCurrent},
+		{Purpose: keystore.PurposeStorageClientPublicKey, ClientID: "client1", State: keystore.StateCurrent},
+		{Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client1", Tags: keystore.KeyTags{"env": "prod"}},
+		{Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client2"},
+		{Purpose: keystore.PurposeStorageClientSymmetricKey, ClientID: "client3", State: keystore.StateRotated},
+		{Purpose: keystore.PurposeSearchHMAC, ClientID: "client1"},
+		{Purpose: keystore.PurposePoisonRecordSymmetricKey},
+	}}
+	rotator, err := NewRotator(store, SupportedKeyKinds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := rotator.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"storage-keypair:client1", "symmetric-key:client1", "symmetric-key:client2"}
+	if rotated != len(expected) || len(store.generated) != len(expected) {
+		t.Fatalf("Expected %v, took %v", expected, store.generated)
+	}
+	for i := range expected {
+		if store.generated[i] != expected[i] {
+			t.Fatalf("Expected %v, took %v", expected, store.generated)
+		}
+	}
+
+	store.generated = nil
+	rotator, err = NewRotator(store, []string{keystore.KeySymmetric}, keystore.KeyTags{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated, err := rotator.Rotate(); err != nil || rotated != 1 || store.generated[0] != "symmetric-key:client1" {
+		t.Fatalf("Unexpected rotation result %d, %v, %v", rotated, err, store.generated)
+	}
+
+	// failed key doesn't stop rotation of others
+	store.generated = nil
+	store.keys = append(store.keys, keystore.KeyDescription{Purpose: keystore.PurposeStorageClientPrivateKey, ClientID: "broken"})
+	rotator, err = NewRotator(store, SupportedKeyKinds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err = rotator.Rotate()
+	if !errors.Is(err, ErrRotationFailed) || rotated != 3 {
+		t.Fatalf("Unexpected rotation result %d, %v", rotated, err)
+	}
+
+	if _, err := NewRotator(store, []string{keystore.KeySearch}, nil); !errors.Is(err, ErrUnsupportedKeyKind) {
+		t.Fatalf("Expected ErrUnsupportedKeyKind, took %v", err)
+	}
+}
+
+func TestParseKeyKinds(t *testing.T) {
+	kinds, err := ParseKeyKinds("storage-keypair, symmetric-key")
+	if err != nil || len(kinds) != 2 {
+		t.Fatalf("Unexpected result %v, %v", kinds, err)
+	}
+	for _, value := range []string{"", "hmac-key", "storage-keypair,poison-keypair"} {
+		if _, err := ParseKeyKinds(value); !errors.Is(err, ErrUnsupportedKeyKind) {
+			t.Errorf("%s: expected ErrUnsupportedKeyKind, took %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rotation generates new versions of keys on schedule. Previous versions are kept by keystore as rotated
+// keys, so data encrypted with them remains decryptable.
+package rotation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule returned if schedule can't be parsed
+var ErrInvalidSchedule = errors.New("invalid rotation schedule")
+
+// Schedule returns time of the next rotation after t
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// predefined schedules in addition to cron expressions
+var scheduleDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// ParseSchedule parses cron-like expression with 5 fields "minute hour day-of-month month day-of-week". Fields support
+// "*", values, ranges "a-b", lists "a,b" and steps "*/n", "a-b/n". Descriptors @hourly, @daily, @weekly, @monthly,
+// @yearly and "@every <duration>" are supported too. Time is matched in the time zone of the passed time.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSchedule, err)
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("%w: interval should be at least 1m", ErrInvalidSchedule)
+		}
+		return intervalSchedule(interval), nil
+	}
+	if expression, ok := scheduleDescriptors[spec]; ok {
+		spec = expression
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", ErrInvalidSchedule, len(fields))
+	}
+	schedule := &cronSchedule{}
+	var err error
+	if schedule.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if schedule.dayOfMonth, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if schedule.dayOfWeek, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is Sunday as well as 0
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// parseField returns bitset of values matched by the field
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if index := strings.Index(part, "/"); index != -1 {
+			value, err := strconv.Atoi(part[index+1:])
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("%w: invalid step in \"%s\"", ErrInvalidSchedule, part)
+			}
+			rangePart, step = part[:index], value
+		}
+		start, end := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			value, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("%w: invalid value in \"%s\"", ErrInvalidSchedule, part)
+			}
+			start, end = value, value
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("%w: invalid value in \"%s\"", ErrInvalidSchedule, part)
+				}
+			} else if step != 1 {
+				// "a/n" means from a to max with step n
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%w: \"%s\" is out of range %d-%d", ErrInvalidSchedule, part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+type intervalSchedule time.Duration
+
+// Next returns t shifted by the interval
+func (schedule intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(schedule))
+}
+
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	anyDayOfMonth, anyDayOfWeek                bool
+}
+
+// maxScheduleSearch limits search of the next time for expressions which never match like "0 0 31 2 *"
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t matched by the expression or zero time if there is no such time
+func (schedule *cronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleSearch)
+	for next.Before(limit) {
+		if schedule.month&(1<<uint(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !schedule.matchDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if schedule.hour&(1<<uint(next.Hour())) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if schedule.minute&(1<<uint(next.Minute())) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// matchDay follows cron semantic: if both day of month and day of week are restricted, any of them should match
+func (schedule *cronSchedule) matchDay(t time.Time) bool {
+	dayOfMonth := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if schedule.anyDayOfMonth || schedule.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
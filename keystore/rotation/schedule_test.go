@@ -0,0 +1,71 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rotation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 10, 30, 15, 0, time.UTC)
+	testcases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.February, 1, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		// 2024-02-04 is Sunday
+		{"@weekly", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 2 1,15 */3 *", time.Date(2024, time.April, 1, 2, 30, 0, 0, time.UTC)},
+		// day of month or day of week
+		{"0 0 10 * 5", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"@every 720h", start.Add(720 * time.Hour)},
+	}
+	for _, testcase := range testcases {
+		schedule, err := ParseSchedule(testcase.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", testcase.spec, err)
+		}
+		if next := schedule.Next(start); !next.Equal(testcase.next) {
+			t.Errorf("%s: expected %s, took %s", testcase.spec, testcase.next, next)
+		}
+	}
+
+	schedule, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.Next(start); !next.IsZero() {
+		t.Errorf("Expected no next time, took %s", next)
+	}
+}
+
+func TestParseInvalidSchedule(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every 1s", "@every month"} {
+		if _, err := ParseSchedule(spec); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("%s: expected ErrInvalidSchedule, took %v", spec, err)
+		}
+	}
+}
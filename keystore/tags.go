@@ -0,0 +1,91 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidKeyTags returned for tags or selectors not in "name=value,name2=value2" format
+var ErrInvalidKeyTags = errors.New("invalid key tags")
+
+// KeyTags are arbitrary labels of the key (team, environment, datacenter) stored in keystore metadata
+type KeyTags map[string]string
+
+// KeyTagStore stores tags of keys. Tags are assigned to key ID and shared by current and rotated versions of the key
+type KeyTagStore interface {
+	GetKeyTags(keyID string) (KeyTags, error)
+	SetKeyTags(keyID string, tags KeyTags) error
+}
+
+// ParseKeyTags parses tags in "name=value,name2=value2" format. Empty string returns empty tags
+func ParseKeyTags(value string) (KeyTags, error) {
+	tags := make(KeyTags)
+	if strings.TrimSpace(value) == "" {
+		return tags, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: expected name=value, took %q", ErrInvalidKeyTags, pair)
+		}
+		name, tagValue := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "" {
+			return nil, fmt.Errorf("%w: empty tag name in %q", ErrInvalidKeyTags, pair)
+		}
+		if _, ok := tags[name]; ok {
+			return nil, fmt.Errorf("%w: duplicated tag %q", ErrInvalidKeyTags, name)
+		}
+		tags[name] = tagValue
+	}
+	return tags, nil
+}
+
+// Match returns true if tags contain all tags of the selector with the same values. Empty selector matches any tags
+func (tags KeyTags) Match(selector KeyTags) bool {
+	for name, value := range selector {
+		tagValue, ok := tags[name]
+		if !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns tags in "name=value,name2=value2" format sorted by name
+func (tags KeyTags) String() string {
+	pairs := make([]string, 0, len(tags))
+	for name, value := range tags {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// FilterKeysByTags returns keys which tags match the selector
+func FilterKeysByTags(keys []KeyDescription, selector KeyTags) []KeyDescription {
+	filtered := make([]KeyDescription, 0, len(keys))
+	for _, key := range keys {
+		if key.Tags.Match(selector) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,55 @@
+package keystore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseKeyTags(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected string
+		err      error
+	}{
+		{"", "", nil},
+		{"env=staging", "env=staging", nil},
+		{" team = payments , env=staging", "env=staging,team=payments", nil},
+		{"dc=", "dc=", nil},
+		{"url=http://host/?a=b", "url=http://host/?a=b", nil},
+		{"env", "", ErrInvalidKeyTags},
+		{"=staging", "", ErrInvalidKeyTags},
+		{"env=staging,", "", ErrInvalidKeyTags},
+		{"env=staging,env=production", "", ErrInvalidKeyTags},
+	}
+	for i, tcase := range testcases {
+		tags, err := ParseKeyTags(tcase.value)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+		if err == nil && tags.String() != tcase.expected {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.expected, tags.String())
+		}
+	}
+}
+
+func TestKeyTagsMatch(t *testing.T) {
+	tags := KeyTags{"env": "staging", "team": "payments"}
+	testcases := []struct {
+		selector KeyTags
+		match    bool
+	}{
+		{nil, true},
+		{KeyTags{"env": "staging"}, true},
+		{KeyTags{"env": "staging", "team": "payments"}, true},
+		{KeyTags{"env": "production"}, false},
+		{KeyTags{"env": "staging", "dc": "eu"}, false},
+	}
+	for i, tcase := range testcases {
+		if match := tags.Match(tcase.selector); match != tcase.match {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.match, match)
+		}
+	}
+	if KeyTags(nil).Match(KeyTags{"env": "staging"}) {
+		t.Fatal("Expected keys without tags don't match not empty selector")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"errors"
+	"time"
+)
+
+// KeyDestructionMode defines what Destroy* methods of keystore do with key material
+type KeyDestructionMode int
+
+// Supported modes of key destruction
+const (
+	// KeyDestructionPermanent removes key material immediately
+	KeyDestructionPermanent KeyDestructionMode = iota
+	// KeyDestructionDryRun doesn't change keystore and only reports key material which would be destroyed
+	KeyDestructionDryRun
+	// KeyDestructionSoft moves key material to tombstones which may be restored until grace period passes
+	KeyDestructionSoft
+)
+
+// Set of errors related to soft destruction of keys
+var (
+	ErrKeyDestructionModeNotSupported = errors.New("keystore doesn't support dry-run and soft destruction of keys")
+	ErrKeyTombstoneNotFound           = errors.New("soft deleted key not found")
+	ErrKeyTombstoneExpired            = errors.New("grace period of soft deleted key has passed")
+	ErrKeyTombstoneRestoreConflict    = errors.New("can't restore soft deleted key over existing key")
+)
+
+// AffectedKeyFile is key material destroyed by keystore or which would be destroyed in dry-run mode
+type AffectedKeyFile struct {
+	Path string
+	// TombstoneID is set for soft deleted key material and may be used to restore it
+	TombstoneID string
+}
+
+// KeyTombstone describes soft deleted key material
+type KeyTombstone struct {
+	ID        string
+	Path      string
+	DeletedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Expired returns true if grace period of the tombstone has passed at now
+func (tombstone KeyTombstone) Expired(now time.Time) bool {
+	return !now.Before(tombstone.ExpiresAt)
+}
+
+// SoftKeyDestruction is implemented by keystores which support dry-run and soft destruction of keys
+type SoftKeyDestruction interface {
+	// SetKeyDestructionMode changes behaviour of following Destroy* calls. gracePeriod is used only with KeyDestructionSoft
+	SetKeyDestructionMode(mode KeyDestructionMode, gracePeriod time.Duration)
+	// AffectedKeyFiles returns key material affected by Destroy* calls made after SetKeyDestructionMode
+	AffectedKeyFiles() []AffectedKeyFile
+	ListKeyTombstones() ([]KeyTombstone, error)
+	// RestoreKeyTombstone moves soft deleted key material back if grace period hasn't passed yet
+	RestoreKeyTombstone(id string) error
+	// PurgeExpiredKeyTombstones permanently shreds key material of tombstones expired at now and returns them.
+	// In dry-run mode tombstones are only returned
+	PurgeExpiredKeyTombstones(now time.Time) ([]KeyTombstone, error)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keystore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations with keys counted by KeyUsageTracker
+const (
+	KeyUsageEncryption = "encryption"
+	KeyUsageDecryption = "decryption"
+)
+
+// ErrKeyUsageTrackingNotSupported returned for keystores which can't store usage of keys
+var ErrKeyUsageTrackingNotSupported = errors.New("keystore doesn't support usage statistics of keys")
+
+var (
+	keyUsageCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acra_key_usage_total",
+			Help: "number of operations with storage keys by key ID and operation",
+		}, []string{"key_id", "operation"})
+	keyLastUsedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "acra_key_last_used_timestamp_seconds",
+			Help: "unix time of the last operation with storage key by key ID",
+		}, []string{"key_id"})
+)
+
+var registerKeyUsageMetricsLock = sync.Once{}
+
+// RegisterKeyUsageMetrics register in default prometheus registry metrics related with usage of keys
+func RegisterKeyUsageMetrics() {
+	registerKeyUsageMetricsLock.Do(func() {
+		prometheus.MustRegister(keyUsageCounter)
+		prometheus.MustRegister(keyLastUsedGauge)
+	})
+}
+
+// KeyUsage is statistics of key usage collected by services
+type KeyUsage struct {
+	Encryptions uint64     `json:"encryptions"`
+	Decryptions uint64     `json:"decryptions"`
+	LastUsed    *time.Time `json:"last_used,omitempty"`
+}
+
+// Merge returns sum of counters and the latest access time of both usages
+func (usage KeyUsage) Merge(other KeyUsage) KeyUsage {
+	usage.Encryptions += other.Encryptions
+	usage.Decryptions += other.Decryptions
+	if usage.LastUsed == nil || (other.LastUsed != nil && other.LastUsed.After(*usage.LastUsed)) {
+		usage.LastUsed = other.LastUsed
+	}
+	return usage
+}
+
+// KeyUsageStore persists usage statistics of keys by key ID
+type KeyUsageStore interface {
+	GetKeysUsage() (map[string]KeyUsage, error)
+	AddKeysUsage(usage map[string]KeyUsage) error
+}
+
+// KeyUsageTrackingKeyStore is keystore which reports usage of keys to KeyUsageTracker and stores it
+type KeyUsageTrackingKeyStore interface {
+	KeyUsageStore
+	SetKeyUsageTracker(tracker *KeyUsageTracker)
+}
+
+// KeyUsageTracker counts usage of keys in memory and periodically adds it to KeyUsageStore, so keystore isn't written
+// on every operation
+type KeyUsageTracker struct {
+	lock    sync.Mutex
+	pending map[string]KeyUsage
+	now     func() time.Time
+}
+
+// NewKeyUsageTracker returns new KeyUsageTracker
+func NewKeyUsageTracker() *KeyUsageTracker {
+	return &KeyUsageTracker{pending: make(map[string]KeyUsage), now: time.Now}
+}
+
+// Track counts operation with the key. Nil tracker ignores it, so keystores call it without checks
+func (tracker *KeyUsageTracker) Track(keyID, operation string) {
+	if tracker == nil {
+		return
+	}
+	now := tracker.now()
+	usage := KeyUsage{LastUsed: &now}
+	switch operation {
+	case KeyUsageEncryption:
+		usage.Encryptions = 1
+	case KeyUsageDecryption:
+		usage.Decryptions = 1
+	}
+	tracker.lock.Lock()
+	tracker.pending[keyID] = tracker.pending[keyID].Merge(usage)
+	tracker.lock.Unlock()
+	keyUsageCounter.WithLabelValues(keyID, operation).Inc()
+	keyLastUsedGauge.WithLabelValues(keyID).Set(float64(now.Unix()))
+}
+
+// Flush adds usage collected since the last flush to the store. Usage is kept in memory if the store fails
+func (tracker *KeyUsageTracker) Flush(store KeyUsageStore) error {
+	tracker.lock.Lock()
+	pending := tracker.pending
+	tracker.pending = make(map[string]KeyUsage, len(pending))
+	tracker.lock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := store.AddKeysUsage(pending); err != nil {
+		tracker.lock.Lock()
+		for keyID, usage := range pending {
+			tracker.pending[keyID] = tracker.pending[keyID].Merge(usage)
+		}
+		tracker.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes usage to the store with interval until ctx is done and flushes the rest on exit
+func (tracker *KeyUsageTracker) Run(ctx context.Context, store KeyUsageStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := tracker.Flush(store); err != nil {
+				log.WithError(err).Errorln("Can't save usage of keys")
+			}
+			return
+		case <-ticker.C:
+			if err := tracker.Flush(store); err != nil {
+				log.WithError(err).Warnln("Can't save usage of keys, will retry with next flush")
+			}
+		}
+	}
+}
+
+// FillKeysUsage sets usage of described keys from the store. Public keys share usage with their key pairs
+func FillKeysUsage(descriptions []KeyDescription, store KeyUsageStore) error {
+	usage, err := store.GetKeysUsage()
+	if err != nil {
+		return err
+	}
+	for i := range descriptions {
+		keyUsage, ok := usage[descriptions[i].KeyID]
+		if !ok {
+			keyUsage, ok = usage[strings.TrimSuffix(descriptions[i].KeyID, ".pub")]
+		}
+		if ok {
+			descriptions[i].Usage = &keyUsage
+		} else {
+			descriptions[i].Usage = &KeyUsage{}
+		}
+	}
+	return nil
+}
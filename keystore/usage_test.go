@@ -0,0 +1,94 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTestUsageStore = errors.New("store is unavailable")
+
+type memoryKeyUsageStore struct {
+	usage map[string]KeyUsage
+	fail  bool
+}
+
+func (store *memoryKeyUsageStore) GetKeysUsage() (map[string]KeyUsage, error) {
+	return store.usage, nil
+}
+
+func (store *memoryKeyUsageStore) AddKeysUsage(usage map[string]KeyUsage) error {
+	if store.fail {
+		return errTestUsageStore
+	}
+	for keyID, keyUsage := range usage {
+		store.usage[keyID] = store.usage[keyID].Merge(keyUsage)
+	}
+	return nil
+}
+
+func TestKeyUsageTracker(t *testing.T) {
+	tracker := NewKeyUsageTracker()
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	store := &memoryKeyUsageStore{usage: make(map[string]KeyUsage)}
+
+	tracker.Track("client_storage", KeyUsageEncryption)
+	tracker.Track("client_storage", KeyUsageDecryption)
+	now = now.Add(time.Hour)
+	tracker.Track("client_storage", KeyUsageDecryption)
+
+	store.fail = true
+	if err := tracker.Flush(store); !errors.Is(err, errTestUsageStore) {
+		t.Fatalf("Expected errTestUsageStore, took %v", err)
+	}
+	// usage is kept until the store is available
+	tracker.Track("client_storage_sym", KeyUsageEncryption)
+	store.fail = false
+	if err := tracker.Flush(store); err != nil {
+		t.Fatal(err)
+	}
+	usage := store.usage["client_storage"]
+	if usage.Encryptions != 1 || usage.Decryptions != 2 || !usage.LastUsed.Equal(now) {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+	if store.usage["client_storage_sym"].Encryptions != 1 {
+		t.Fatalf("Unexpected usage %+v", store.usage["client_storage_sym"])
+	}
+	if len(tracker.pending) != 0 {
+		t.Fatal("Flushed usage wasn't removed from tracker")
+	}
+
+	// nil tracker is used by keystores without tracking
+	var nilTracker *KeyUsageTracker
+	nilTracker.Track("client_storage", KeyUsageDecryption)
+}
+
+func TestFillKeysUsage(t *testing.T) {
+	lastUsed := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryKeyUsageStore{usage: map[string]KeyUsage{
+		"client_storage": {Encryptions: 5, Decryptions: 7, LastUsed: &lastUsed},
+	}}
+	keys := []KeyDescription{
+		{KeyID: "client_storage", Purpose: PurposeStorageClientPrivateKey, ClientID: "client"},
+		{KeyID: "client_storage.pub", Purpose: PurposeStorageClientPublicKey, ClientID: "client"},
+		{KeyID: "stale_storage_sym", Purpose: PurposeStorageClientSymmetricKey, ClientID: "stale"},
+	}
+	if err := FillKeysUsage(keys, store); err != nil {
+		t.Fatal(err)
+	}
+	if keys[0].Usage.Decryptions != 7 || keys[1].Usage.Encryptions != 5 || keys[2].Usage.LastUsed != nil {
+		t.Fatalf("Unexpected usage %+v, %+v, %+v", keys[0].Usage, keys[1].Usage, keys[2].Usage)
+	}
+
+	output := &bytes.Buffer{}
+	if err := PrintKeysUsageTable(keys, output); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[2], "2024-01-01T00:00:00Z") || !strings.Contains(lines[4], "never") {
+		t.Fatalf("Unexpected table:\n%s", output.String())
+	}
+}
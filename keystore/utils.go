@@ -3,6 +3,8 @@ package keystore
 import (
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
 const (
@@ -11,6 +13,10 @@ const (
 	keyIDHeader        = "Key ID"
 	creationTimeHeader = "Creation Time"
 	idxHeader          = "Index"
+	encryptionsHeader  = "Encryptions"
+	decryptionsHeader  = "Decryptions"
+	lastUsedHeader     = "Last used"
+	neverUsed          = "never"
 )
 
 // PrintKeysTable prints table which describes keys in a human readable format
@@ -99,3 +105,44 @@ func PrintRotatedKeysTable(keys []KeyDescription, writer io.Writer) error {
 	}
 	return nil
 }
+
+// PrintKeysUsageTable prints table which describes keys with their usage in a readable format into the writer.
+// In format `Index | Key purpose | Client | Encryptions | Decryptions | Last used | Key ID`
+func PrintKeysUsageTable(keys []KeyDescription, writer io.Writer) error {
+	maxPurposeLen := len(purposeHeader)
+	maxExtraIDLen := len(extraIDHeader)
+	maxIdxLen := len(idxHeader)
+	maxEncryptionsLen := len(encryptionsHeader)
+	maxDecryptionsLen := len(decryptionsHeader)
+	maxLastUsedLen := len(lastUsedHeader)
+	lastUsed := make([]string, len(keys))
+	for i, key := range keys {
+		if len(key.Purpose) > maxPurposeLen {
+			maxPurposeLen = len(key.Purpose)
+		}
+		if len(key.ClientID) > maxExtraIDLen {
+			maxExtraIDLen = len(key.ClientID)
+		}
+		lastUsed[i] = neverUsed
+		if key.Usage != nil && key.Usage.LastUsed != nil {
+			lastUsed[i] = key.Usage.LastUsed.Format(time.RFC3339)
+		}
+		if len(lastUsed[i]) > maxLastUsedLen {
+			maxLastUsedLen = len(lastUsed[i])
+		}
+	}
+
+	fmt.Fprintf(writer, "%-*s | %-*s | %-*s | %-*s | %-*s | %-*s | %s\n", maxIdxLen, idxHeader, maxPurposeLen, purposeHeader, maxExtraIDLen, extraIDHeader,
+		maxEncryptionsLen, encryptionsHeader, maxDecryptionsLen, decryptionsHeader, maxLastUsedLen, lastUsedHeader, keyIDHeader)
+	fmt.Fprintln(writer, strings.Repeat("-", maxIdxLen+maxPurposeLen+maxExtraIDLen+maxEncryptionsLen+maxDecryptionsLen+maxLastUsedLen+len(keyIDHeader)+18))
+
+	for i, key := range keys {
+		usage := KeyUsage{}
+		if key.Usage != nil {
+			usage = *key.Usage
+		}
+		fmt.Fprintf(writer, "%-*d | %-*s | %-*s | %-*d | %-*d | %-*s | %s\n", maxIdxLen, key.Index, maxPurposeLen, key.Purpose, maxExtraIDLen, key.ClientID,
+			maxEncryptionsLen, usage.Encryptions, maxDecryptionsLen, usage.Decryptions, maxLastUsedLen, lastUsed[i], key.KeyID)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filesystem
+
+import (
+	encodingASN1 "encoding/asn1"
+	"errors"
+	"strings"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/asn1"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/signature"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Kinds of problems found by key ring audit.
+const (
+	// AuditIssueInvalidSignature means that key ring was modified or signed with another master key
+	AuditIssueInvalidSignature = "invalid_signature"
+	// AuditIssueTruncated means that key ring file is empty or ends prematurely
+	AuditIssueTruncated = "truncated"
+	// AuditIssueCorrupted means that signed key ring has invalid structure or keys which can't be decrypted
+	AuditIssueCorrupted = "corrupted"
+	// AuditIssueOrphaned means file which doesn't belong to any key ring, like leftovers of interrupted writes
+	AuditIssueOrphaned = "orphaned"
+	// AuditIssueInvalidPermissions means key file or directory accessible not only by owner
+	AuditIssueInvalidPermissions = "invalid_permissions"
+	// AuditIssueUnreadable means key ring which can't be read from backend
+	AuditIssueUnreadable = "unreadable"
+)
+
+// Errors found by key ring audit:
+var (
+	errInvalidCurrentKey = errors.New("current key is missing in key ring")
+	errMalformedKeyRing  = errors.New("malformed key ring data")
+	errOrphanedFile      = errors.New("file doesn't belong to any key ring")
+)
+
+// KeyRingAuditIssue describes problem with one file of keystore.
+type KeyRingAuditIssue struct {
+	Path  string `json:"path"`
+	Issue string `json:"issue"`
+	Error string `json:"error,omitempty"`
+}
+
+// Critical returns true for issues which make keys unusable, other issues are warnings.
+func (issue KeyRingAuditIssue) Critical() bool {
+	switch issue.Issue {
+	case AuditIssueOrphaned, AuditIssueInvalidPermissions:
+		return false
+	}
+	return true
+}
+
+// KeyRingAuditReport is the result of keystore integrity audit.
+type KeyRingAuditReport struct {
+	KeyRings int                 `json:"key_rings"`
+	Verified int                 `json:"verified"`
+	Issues   []KeyRingAuditIssue `json:"issues"`
+}
+
+// permissionChecker is implemented by backends which store key rings in files with access permissions.
+type permissionChecker interface {
+	CheckPermissions(path string) error
+}
+
+// AuditKeyRings walks all files of the keystore, verifies signatures, structure and permissions of key rings
+// and decrypts all keys to check master keys. Problems are collected into the report,
+// an error is returned only if the keystore can't be listed.
+func (s *KeyStore) AuditKeyRings() (report *KeyRingAuditReport, err error) {
+	err = s.fs.RLock()
+	if err != nil {
+		s.log.WithError(err).Debug("failed to lock store for reading")
+		return nil, err
+	}
+	defer func() {
+		err2 := s.fs.RUnlock()
+		if err2 != nil {
+			s.log.WithError(err2).Debug("failed to unlock store")
+			if err == nil {
+				err = err2
+			}
+		}
+	}()
+
+	paths, err := s.fs.ListAll()
+	if err != nil {
+		s.log.WithError(err).Debug("failed to list key rings")
+		return nil, err
+	}
+	report = &KeyRingAuditReport{Issues: make([]KeyRingAuditIssue, 0)}
+	addIssue := func(path, issue string, err error) {
+		report.Issues = append(report.Issues, KeyRingAuditIssue{Path: path, Issue: issue, Error: err.Error()})
+	}
+	checker, checkPermissions := s.fs.(permissionChecker)
+	for _, path := range paths {
+		if checkPermissions {
+			if err := checker.CheckPermissions(path); err != nil {
+				addIssue(path, AuditIssueInvalidPermissions, err)
+			}
+		}
+		if !strings.HasSuffix(path, keyringSuffix) {
+			addIssue(path, AuditIssueOrphaned, errOrphanedFile)
+			continue
+		}
+		report.KeyRings++
+		if issue, err := s.auditKeyRing(strings.TrimSuffix(path, keyringSuffix)); err != nil {
+			addIssue(path, issue, err)
+			continue
+		}
+		report.Verified++
+	}
+	return report, nil
+}
+
+// auditKeyRing checks key ring at given path and returns kind of found issue
+func (s *KeyStore) auditKeyRing(path string) (string, error) {
+	data, err := s.fetchASNring(path)
+	if err != nil {
+		return AuditIssueUnreadable, err
+	}
+	if len(data) == 0 {
+		return AuditIssueTruncated, encodingASN1.SyntaxError{Msg: "data truncated"}
+	}
+	ringData, _, err := s.verifyKeyRing(data, path)
+	if err != nil {
+		switch {
+		case err == signature.ErrSignatureError || err == signature.ErrNoSignature:
+			return AuditIssueInvalidSignature, err
+		case isTruncatedASN1(err):
+			return AuditIssueTruncated, err
+		}
+		return AuditIssueCorrupted, err
+	}
+	// verifyKeyRing doesn't return error of key ring parsing
+	if ringData == nil {
+		return AuditIssueCorrupted, errMalformedKeyRing
+	}
+	defer zeroizeKeyRing(ringData)
+	ring := newKeyRing(s, path)
+	if err := ring.loadASN1(ringData); err != nil {
+		return AuditIssueCorrupted, err
+	}
+	currentFound := ringData.Current == asn1.NoKey
+	for _, key := range ringData.Keys {
+		if key.Seqnum == ringData.Current {
+			currentFound = true
+		}
+		for _, keyData := range key.Data {
+			if len(keyData.PrivateKey) != 0 {
+				decrypted, err := ring.decryptPrivateKey(key.Seqnum, keyData.PrivateKey)
+				if err != nil {
+					return AuditIssueCorrupted, err
+				}
+				utils.ZeroizeBytes(decrypted)
+			}
+			if len(keyData.SymmetricKey) != 0 {
+				decrypted, err := ring.decryptSymmetricKey(key.Seqnum, keyData.SymmetricKey)
+				if err != nil {
+					return AuditIssueCorrupted, err
+				}
+				utils.ZeroizeBytes(decrypted)
+			}
+		}
+	}
+	if !currentFound {
+		return AuditIssueCorrupted, errInvalidCurrentKey
+	}
+	return "", nil
+}
+
+// isTruncatedASN1 returns true if ASN.1 parser failed due to premature end of data
+func isTruncatedASN1(err error) bool {
+	var syntaxError encodingASN1.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return strings.Contains(syntaxError.Msg, "truncated")
+	}
+	return false
+}
+
+// ensure that DirectoryBackend reports invalid permissions during audit
+var _ permissionChecker = (*backend.DirectoryBackend)(nil)
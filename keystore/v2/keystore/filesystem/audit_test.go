@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/api"
+)
+
+func TestKeyStoreAuditKeyRings(t *testing.T) {
+	testDir := t.TempDir()
+	if err := os.Chmod(testDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenDirectoryRW(testDir, testKeyStoreSuite(t))
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	defer store.Close()
+	keyStore := store.(*KeyStore)
+
+	for _, path := range []string{"client/valid", "client/exposed"} {
+		ring, err := store.OpenKeyRingRW(path)
+		if err != nil {
+			t.Fatalf("failed to create key ring: %v", err)
+		}
+		now := time.Now()
+		seqnum, err := ring.AddKey(api.KeyDescription{
+			ValidSince: now,
+			ValidUntil: now.Add(time.Hour),
+			Data:       []api.KeyData{{Format: api.ThemisSymmetricKeyFormat, SymmetricKey: []byte("test symmetric key")}},
+		})
+		if err != nil {
+			t.Fatalf("failed to add key: %v", err)
+		}
+		if err := ring.SetCurrent(seqnum); err != nil {
+			t.Fatalf("failed to set current key: %v", err)
+		}
+	}
+	report, err := keyStore.AuditKeyRings()
+	if err != nil {
+		t.Fatalf("failed to audit keystore: %v", err)
+	}
+	if report.KeyRings != 2 || report.Verified != 2 || len(report.Issues) != 0 {
+		t.Fatalf("unexpected report of valid keystore: %+v", report)
+	}
+
+	data, err := keyStore.fetchASNring("client/valid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signature context contains path, so copied key ring is invalid
+	if err := keyStore.fs.Put("client/copied"+keyringSuffix, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyStore.fs.Put("client/truncated"+keyringSuffix, data[:len(data)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyStore.fs.Put("client/interrupted"+keyringSuffix+newSuffix, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(testDir, "client", "exposed"+keyringSuffix), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = keyStore.AuditKeyRings()
+	if err != nil {
+		t.Fatalf("failed to audit keystore: %v", err)
+	}
+	if report.KeyRings != 4 || report.Verified != 2 {
+		t.Fatalf("unexpected report of damaged keystore: %+v", report)
+	}
+	expected := map[string]string{
+		"client/copied" + keyringSuffix:                  "invalid_signature",
+		"client/truncated" + keyringSuffix:               "truncated",
+		"client/interrupted" + keyringSuffix + newSuffix: "orphaned",
+		"client/exposed" + keyringSuffix:                 "invalid_permissions",
+	}
+	if len(report.Issues) != len(expected) {
+		t.Fatalf("expected %d issues, took %+v", len(expected), report.Issues)
+	}
+	for _, issue := range report.Issues {
+		if expected[issue.Path] != issue.Issue {
+			t.Errorf("expected %s issue for %s, took %s", expected[issue.Path], issue.Path, issue.Issue)
+		}
+		if issue.Critical() != (issue.Issue != AuditIssueOrphaned && issue.Issue != AuditIssueInvalidPermissions) {
+			t.Errorf("unexpected criticality of %s issue", issue.Issue)
+		}
+	}
+}
@@ -19,14 +19,14 @@ package backend
 import (
 	"os"
 	"sync"
-	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // fileLock is an interprocess read-write lock that serializes access to filesystem.
 //
-// This lock is implemented with BSD flock(2) and has corresponding semantics.
+// This lock is implemented with BSD flock(2) on Unix-like systems and has corresponding semantics.
+// On Windows it is implemented with LockFileEx which locks the first byte of the file for the handle.
 //
 // Note that this is an advisory lock. That is, this lock can be used to guard
 // access to some filesystem resource by cooperating processes, but it does not
@@ -111,7 +111,7 @@ func (l *fileLock) Lock() error {
 			return err
 		}
 	}
-	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_EX)
+	err := lockFileExclusive(l.lockFile)
 	if err != nil {
 		l.lockSync.Unlock()
 		return err
@@ -121,7 +121,7 @@ func (l *fileLock) Lock() error {
 
 func (l *fileLock) Unlock() error {
 	defer l.lockSync.Unlock()
-	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+	err := unlockFile(l.lockFile)
 	if err != nil {
 		l.poisonLock(err)
 		return err
@@ -138,7 +138,7 @@ func (l *fileLock) RLock() error {
 			return err
 		}
 	}
-	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_SH)
+	err := lockFileShared(l.lockFile)
 	if err != nil {
 		l.lockSync.Unlock()
 		return err
@@ -148,7 +148,7 @@ func (l *fileLock) RLock() error {
 
 func (l *fileLock) RUnlock() error {
 	defer l.lockSync.Unlock()
-	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+	err := unlockFile(l.lockFile)
 	if err != nil {
 		l.poisonLock(err)
 		return err
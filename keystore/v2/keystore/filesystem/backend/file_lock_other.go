@@ -0,0 +1,40 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+/*
+ * Copyright 2022, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrFileLockNotSupported returned by key directory locks on platforms without file locking
+var ErrFileLockNotSupported = errors.New("file locking is not supported on this platform")
+
+func lockFileExclusive(file *os.File) error {
+	return ErrFileLockNotSupported
+}
+
+func lockFileShared(file *os.File) error {
+	return ErrFileLockNotSupported
+}
+
+func unlockFile(file *os.File) error {
+	return ErrFileLockNotSupported
+}
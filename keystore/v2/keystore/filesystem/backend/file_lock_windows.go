@@ -0,0 +1,52 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright 2022, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Locks cover the first byte of the file. Windows allows to lock bytes beyond the end of file, so the lock file
+// may stay empty like on other systems
+const (
+	lockedBytesLow  = 1
+	lockedBytesHigh = 0
+)
+
+func lockFileExclusive(file *os.File) error {
+	return lockFileEx(file, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+func lockFileShared(file *os.File) error {
+	return lockFileEx(file, 0)
+}
+
+func lockFileEx(file *os.File, flags uint32) error {
+	// without LOCKFILE_FAIL_IMMEDIATELY the call blocks until the lock is acquired like flock(2)
+	overlapped := &windows.Overlapped{}
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, lockedBytesLow, lockedBytesHigh, overlapped)
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := &windows.Overlapped{}
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockedBytesLow, lockedBytesHigh, overlapped)
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	"github.com/cossacklabs/acra/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -72,8 +73,8 @@ func CreateDirectoryBackend(root string) (*DirectoryBackend, error) {
 			errLog.Debug("root key directory is not a directory")
 			return nil, ErrNotDirectory
 		}
-		if fi.Mode().Perm() != keyDirPerm {
-			errLog.WithFields(log.Fields{
+		if err := utils.CheckPrivateFilePermissions(root, fi, keyDirPerm); err != nil {
+			errLog.WithError(err).WithFields(log.Fields{
 				"actual-perm":   fi.Mode().Perm(),
 				"expected-perm": keyDirPerm,
 			}).
@@ -128,8 +129,8 @@ func OpenDirectoryBackend(root string) (*DirectoryBackend, error) {
 		errLog.Debug("root key directory is not a directory")
 		return nil, ErrNotDirectory
 	}
-	if fi.Mode().Perm() != keyDirPerm {
-		errLog.WithFields(log.Fields{
+	if err := utils.CheckPrivateFilePermissions(root, fi, keyDirPerm); err != nil {
+		errLog.WithError(err).WithFields(log.Fields{
 			"actual-perm":   fi.Mode().Perm(),
 			"expected-perm": keyDirPerm,
 		}).
@@ -362,6 +363,49 @@ func (b *DirectoryBackend) ListAll() ([]string, error) {
 	return paths, nil
 }
 
+// CheckPermissions checks that file at given path and its parent directories
+// have permissions used for new files and directories, so they are accessible only by owner.
+// Returns ErrInvalidPermissions otherwise.
+func (b *DirectoryBackend) CheckPermissions(path string) error {
+	fullPath, err := b.osPath(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = api.ErrNotExist
+		}
+		return err
+	}
+	if err := utils.CheckPrivateFilePermissions(fullPath, fi, keyFilePerm); err != nil {
+		b.log.WithError(err).WithFields(log.Fields{
+			"path":          fullPath,
+			"actual-perm":   fi.Mode().Perm(),
+			"expected-perm": keyFilePerm,
+		}).Debug("invalid access permissions on key file")
+		return ErrInvalidPermissions
+	}
+	for directory := filepath.Dir(fullPath); ; directory = filepath.Dir(directory) {
+		fi, err := os.Stat(directory)
+		if err != nil {
+			return err
+		}
+		if err := utils.CheckPrivateFilePermissions(directory, fi, keyDirPerm); err != nil {
+			b.log.WithError(err).WithFields(log.Fields{
+				"path":          directory,
+				"actual-perm":   fi.Mode().Perm(),
+				"expected-perm": keyDirPerm,
+			}).Debug("invalid access permissions on key directory")
+			return ErrInvalidPermissions
+		}
+		// osPath ensures that the file is inside the root, stop at it or at filesystem root to be sure
+		if directory == filepath.Clean(b.root) || directory == filepath.Dir(directory) {
+			return nil
+		}
+	}
+}
+
 // Rename oldpath into newpath.
 func (b *DirectoryBackend) Rename(oldpath, newpath string) error {
 	var err error
@@ -18,6 +18,7 @@ package backend
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
@@ -38,3 +39,30 @@ func TestFilesystem(t *testing.T) {
 		return backend
 	})
 }
+
+func TestFilesystemCheckPermissions(t *testing.T) {
+	testRootDir := t.TempDir()
+	if err := os.Chmod(testRootDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := CreateDirectoryBackend(testRootDir)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+	if err := backend.Put("client/test.keyring", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.CheckPermissions("client/test.keyring"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(testRootDir, "client"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.CheckPermissions("client/test.keyring"); err != ErrInvalidPermissions {
+		t.Fatalf("expected %v, took %v", ErrInvalidPermissions, err)
+	}
+	if err := backend.CheckPermissions("client/missing.keyring"); err != api.ErrNotExist {
+		t.Fatalf("expected %v, took %v", api.ErrNotExist, err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	"github.com/go-redis/redis/v7"
 	log "github.com/sirupsen/logrus"
 )
@@ -36,14 +37,15 @@ var ErrLockTimeout = errors.New("timed out while waiting for lock")
 
 // RedisBackend keeps key data in Redis database.
 type RedisBackend struct {
-	redis   *redis.Client
+	redis   redis.UniversalClient
 	rootDir string
 	log     *log.Entry
 }
 
 // RedisConfig defines Redis keystore configuration.
+// Options may describe single Redis node, Redis Sentinel or Redis Cluster.
 type RedisConfig struct {
-	Options *redis.Options
+	Options *redisclient.Options
 	RootDir string
 }
 
@@ -56,13 +58,8 @@ const (
 	maxLockDuration = 10 * time.Second
 )
 
-func openRedisConnection(config *RedisConfig) (*redis.Client, error) {
-	client := redis.NewClient(config.Options)
-	err := client.Ping().Err()
-	if err != nil {
-		return nil, err
-	}
-	return client, err
+func openRedisConnection(config *RedisConfig) (redis.UniversalClient, error) {
+	return redisclient.Open(config.Options)
 }
 
 // CreateRedisBackend opens a Redis backend at given root path.
@@ -108,7 +105,7 @@ func redisVersionKey(config *RedisConfig) string {
 	return filepath.Join(config.RootDir, versionKey)
 }
 
-func checkRedisVersionKey(client *redis.Client, config *RedisConfig) error {
+func checkRedisVersionKey(client redis.UniversalClient, config *RedisConfig) error {
 	content, err := client.Get(redisVersionKey(config)).Result()
 	if err != nil {
 		return err
@@ -119,7 +116,7 @@ func checkRedisVersionKey(client *redis.Client, config *RedisConfig) error {
 	return nil
 }
 
-func ensureRedisVersionKey(client *redis.Client, config *RedisConfig) error {
+func ensureRedisVersionKey(client redis.UniversalClient, config *RedisConfig) error {
 	err := checkRedisVersionKey(client, config)
 	// If the keystore already contains a valid versio key then we're good.
 	if err == nil {
@@ -133,7 +130,7 @@ func ensureRedisVersionKey(client *redis.Client, config *RedisConfig) error {
 	return err
 }
 
-func createRedisVersionKey(client *redis.Client, config *RedisConfig) error {
+func createRedisVersionKey(client redis.UniversalClient, config *RedisConfig) error {
 	return client.Set(redisVersionKey(config), versionString, noExpiration).Err()
 }
 
@@ -229,27 +226,20 @@ func (b *RedisBackend) Put(path string, data []byte) error {
 // The paths are returned in lexicographical order.
 func (b *RedisBackend) ListAll() ([]string, error) {
 	const defaultCount = 10
-	keys := make([]string, 0, defaultCount)
 	// First, enumerate all available keys in the root directory.
-	var cursor uint64
-	for {
-		nextKeys, nextCursor, err := b.redis.Scan(cursor, b.rootDir+"/*", defaultCount).Result()
-		if err != nil {
-			return nil, err
-		}
-		// Trim the root directory from paths, it's implicit.
-		// While we're here, filter out special keys as well.
-		for _, key := range nextKeys {
-			key = strings.TrimPrefix(key, b.rootDir+"/")
-			if key == versionKey || key == lockKey {
-				continue
-			}
-			keys = append(keys, key)
-		}
-		cursor = nextCursor
-		if cursor == 0 {
-			break
+	allKeys, err := redisclient.ScanKeys(b.redis, b.rootDir+"/*", defaultCount)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(allKeys))
+	// Trim the root directory from paths, it's implicit.
+	// While we're here, filter out special keys as well.
+	for _, key := range allKeys {
+		key = strings.TrimPrefix(key, b.rootDir+"/")
+		if key == versionKey || key == lockKey {
+			continue
 		}
+		keys = append(keys, key)
 	}
 	sort.Strings(keys)
 	return keys, nil
@@ -259,7 +249,7 @@ func (b *RedisBackend) ListAll() ([]string, error) {
 func (b *RedisBackend) Rename(oldpath, newpath string) error {
 	oldpath = b.keyPath(oldpath)
 	newpath = b.keyPath(newpath)
-	err := b.redis.Rename(oldpath, newpath).Err()
+	err := redisclient.Rename(b.redis, oldpath, newpath)
 	if err != nil {
 		// Unfortunately, there is no error constant :(
 		if strings.HasSuffix(err.Error(), "no such key") {
@@ -275,7 +265,7 @@ func (b *RedisBackend) Rename(oldpath, newpath string) error {
 func (b *RedisBackend) RenameNX(oldpath, newpath string) error {
 	oldpath = b.keyPath(oldpath)
 	newpath = b.keyPath(newpath)
-	renamed, err := b.redis.RenameNX(oldpath, newpath).Result()
+	renamed, err := redisclient.RenameNX(b.redis, oldpath, newpath)
 	if err != nil || !renamed {
 		// Unfortunately, there is no error constant :(
 		if err != nil && strings.HasSuffix(err.Error(), "no such key") {
@@ -21,7 +21,7 @@ package backend
 
 import (
 	"github.com/cossacklabs/acra/cmd"
-	"github.com/go-redis/redis/v7"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	"testing"
 	"time"
 
@@ -45,8 +45,8 @@ func TestRedis(t *testing.T) {
 	tests.TestBackend(t, func(t *testing.T) api.Backend {
 		config := &RedisConfig{
 			RootDir: testRootDir + "/" + time.Now().Format(time.RFC3339Nano),
-			Options: &redis.Options{
-				Addr:     redisOptions.HostPort,
+			Options: &redisclient.Options{
+				Addrs:    []string{redisOptions.HostPort},
 				Password: redisOptions.Password,
 				DB:       redisOptions.DBKeys,
 			},
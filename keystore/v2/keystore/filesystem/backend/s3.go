@@ -0,0 +1,593 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const s3SubsystemName = "s3-backend"
+
+// Errors returned by S3Backend:
+var (
+	ErrS3EmptyBucket          = errors.New("empty S3 bucket name")
+	ErrS3EmptyRegion          = errors.New("empty S3 region")
+	ErrS3EmptyCredentials     = errors.New("empty S3 credentials provider")
+	ErrS3InvalidObjectLock    = errors.New("invalid S3 Object Lock mode, expected GOVERNANCE or COMPLIANCE")
+	ErrS3InvalidLockRetention = errors.New("S3 Object Lock requires positive retention period")
+	ErrS3RequestFailed        = errors.New("S3 request failed")
+)
+
+// errS3PreconditionFailed is returned when conditional write doesn't match current state of the object.
+var errS3PreconditionFailed = errors.New("S3 precondition failed")
+
+// S3 Object Lock retention modes
+const (
+	S3ObjectLockGovernance = "GOVERNANCE"
+	S3ObjectLockCompliance = "COMPLIANCE"
+)
+
+const (
+	s3ServiceName      = "s3"
+	s3KMSEncryption    = "aws:kms"
+	s3RequestTimeout   = 30 * time.Second
+	s3LockRetryTimeout = 100 * time.Millisecond
+	s3ListPageSize     = 1000
+)
+
+// S3Config defines S3 keystore configuration.
+type S3Config struct {
+	Bucket string
+	Region string
+	// Endpoint overrides default AWS endpoint https://s3.<region>.amazonaws.com, for example, for S3-compatible storages
+	Endpoint string
+	// UsePathStyle addresses bucket as a part of path instead of host name
+	UsePathStyle bool
+	Credentials  aws.CredentialsProvider
+	// HTTPClient used for requests, default client with timeout is used if nil
+	HTTPClient *http.Client
+	// RootDir is the prefix of object keys of the keystore
+	RootDir string
+	// KMSKeyID used for SSE-KMS encryption of objects. Bucket default or AWS managed key is used if empty
+	KMSKeyID string
+	// ObjectLockMode applied to every written version of key data, so overwritten and renamed keys can't be
+	// destroyed until the end of retention period. Requires bucket with enabled Object Lock. Disabled if empty
+	ObjectLockMode      string
+	ObjectLockRetention time.Duration
+}
+
+// S3Backend keeps key data as objects in AWS S3 bucket encrypted with SSE-KMS.
+// Put and locking rely on conditional writes, so several instances may share the same keystore.
+type S3Backend struct {
+	config     S3Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	signer     *v4.Signer
+	rootDir    string
+	log        *log.Entry
+
+	lockMutex sync.Mutex
+	lockOwner string
+}
+
+func newS3Backend(config *S3Config) (*S3Backend, error) {
+	if config.Bucket == "" {
+		return nil, ErrS3EmptyBucket
+	}
+	if config.Region == "" {
+		return nil, ErrS3EmptyRegion
+	}
+	if config.Credentials == nil {
+		return nil, ErrS3EmptyCredentials
+	}
+	switch config.ObjectLockMode {
+	case "":
+	case S3ObjectLockGovernance, S3ObjectLockCompliance:
+		if config.ObjectLockRetention <= 0 {
+			return nil, ErrS3InvalidLockRetention
+		}
+	default:
+		return nil, ErrS3InvalidObjectLock
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !config.UsePathStyle {
+		endpointURL.Host = config.Bucket + "." + endpointURL.Host
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: s3RequestTimeout}
+	}
+	return &S3Backend{
+		config:     *config,
+		endpoint:   endpointURL,
+		httpClient: httpClient,
+		signer:     v4.NewSigner(func(options *v4.SignerOptions) { options.DisableURIPathEscaping = true }),
+		rootDir:    strings.Trim(config.RootDir, api.PathSeparator),
+		log: log.WithFields(log.Fields{
+			"service":   serviceName,
+			"subsystem": s3SubsystemName,
+		}),
+	}, nil
+}
+
+// CreateS3Backend opens a S3 backend at given root path.
+// The keystore version object will be created if it does not exist.
+func CreateS3Backend(config *S3Config) (*S3Backend, error) {
+	b, err := newS3Backend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkVersionKey()
+	if err == api.ErrNotExist {
+		err = b.putObject(b.objectKey(versionKey), []byte(versionString), http.Header{"If-None-Match": {"*"}}, false)
+		// another instance may create the keystore concurrently
+		if err == errS3PreconditionFailed {
+			err = b.checkVersionKey()
+		}
+	}
+	if err != nil {
+		b.log.WithError(err).Debug("Cannot create version key")
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenS3Backend opens a S3 backend at given root path.
+func OpenS3Backend(config *S3Config) (*S3Backend, error) {
+	b, err := newS3Backend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkVersionKey()
+	if err != nil {
+		b.log.WithError(err).Debug("Keystore version key not valid")
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *S3Backend) checkVersionKey() error {
+	content, _, err := b.getObject(b.objectKey(versionKey))
+	if err != nil {
+		return err
+	}
+	if string(content) != versionString {
+		return ErrInvalidVersion
+	}
+	return nil
+}
+
+// Accept either UNIX or Windows separator, S3 uses only UNIX one.
+var s3PathSeparators = strings.NewReplacer("\\", api.PathSeparator)
+
+// keyPath converts "key path" into object key.
+func (b *S3Backend) keyPath(keyPath string) (string, error) {
+	keyPath = s3PathSeparators.Replace(keyPath)
+	for _, part := range strings.Split(keyPath, api.PathSeparator) {
+		if part == "." || part == ".." {
+			b.log.WithField("path", keyPath).Warn("invalid key path used")
+			return "", api.ErrInvalidPath
+		}
+	}
+	return b.objectKey(keyPath), nil
+}
+
+func (b *S3Backend) objectKey(name string) string {
+	return path.Join(b.rootDir, name)
+}
+
+// s3Error is the body of S3 error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3ListBucketResult is the body of ListObjectsV2 response
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3EscapePathSegment percent-encodes all characters except unreserved ones, as AWS Signature Version 4 expects
+func s3EscapePathSegment(segment string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+	return escaped.String()
+}
+
+// do signs and sends request to the bucket. Object key is empty for bucket level requests.
+// Returns response with status code 2xx, otherwise closes the response and returns error.
+func (b *S3Backend) do(method, key string, query url.Values, body []byte, headers http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	requestURL := *b.endpoint
+	escapedPath := strings.TrimSuffix(requestURL.EscapedPath(), "/")
+	if b.config.UsePathStyle {
+		escapedPath += "/" + s3EscapePathSegment(b.config.Bucket)
+	}
+	escapedPath += "/"
+	if key != "" {
+		parts := strings.Split(key, "/")
+		for i, part := range parts {
+			parts[i] = s3EscapePathSegment(part)
+		}
+		escapedPath += strings.Join(parts, "/")
+	}
+	unescapedPath, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+	requestURL.Path, requestURL.RawPath = unescapedPath, escapedPath
+	requestURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequest(method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	payloadHash := sha256.Sum256(body)
+	payloadHashString := hex.EncodeToString(payloadHash[:])
+	request.Header.Set("X-Amz-Content-Sha256", payloadHashString)
+	credentials, err := b.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.signer.SignHTTP(ctx, credentials, request, payloadHashString, s3ServiceName, b.config.Region, time.Now()); err != nil {
+		return nil, err
+	}
+	response, err := b.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return nil, api.ErrNotExist
+	case http.StatusPreconditionFailed:
+		return nil, errS3PreconditionFailed
+	}
+	responseError := s3Error{}
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err := xml.Unmarshal(responseBody, &responseError); err != nil {
+		responseError.Code = response.Status
+	}
+	return nil, fmt.Errorf("%w: %s %s: %s %s", ErrS3RequestFailed, method, key, responseError.Code, responseError.Message)
+}
+
+// getObject returns content and ETag of the object
+func (b *S3Backend) getObject(key string) ([]byte, string, error) {
+	response, err := b.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, response.Header.Get("ETag"), nil
+}
+
+// putObject writes object encrypted with SSE-KMS. Key data is protected with Object Lock retention if configured
+func (b *S3Backend) putObject(key string, data []byte, headers http.Header, keyData bool) error {
+	if headers == nil {
+		headers = http.Header{}
+	}
+	checksum := md5.Sum(data)
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(checksum[:]))
+	headers.Set("X-Amz-Server-Side-Encryption", s3KMSEncryption)
+	if b.config.KMSKeyID != "" {
+		headers.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", b.config.KMSKeyID)
+	}
+	if keyData && b.config.ObjectLockMode != "" {
+		headers.Set("X-Amz-Object-Lock-Mode", b.config.ObjectLockMode)
+		headers.Set("X-Amz-Object-Lock-Retain-Until-Date", time.Now().Add(b.config.ObjectLockRetention).UTC().Format(time.RFC3339))
+	}
+	response, err := b.do(http.MethodPut, key, nil, data, headers)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// deleteObject removes current version of the object. Versions protected with Object Lock are kept
+func (b *S3Backend) deleteObject(key string) error {
+	response, err := b.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// Close this backend instance, releasing the lock if it is held.
+func (b *S3Backend) Close() error {
+	b.lockMutex.Lock()
+	locked := b.lockOwner != ""
+	b.lockMutex.Unlock()
+	if !locked {
+		return nil
+	}
+	err := b.Unlock()
+	if err != nil {
+		b.log.WithError(err).Warn("Failed to release S3 lock")
+	}
+	return err
+}
+
+// Lock is an object with owner and expiration time of the lock. Owner creates it with conditional write which
+// fails if the object exists, or replaces expired lock with conditional write by its ETag. Unlock removes it.
+// This is exclusive-only lock, the same as with Redis backend.
+
+// Lock acquires an exclusive lock on the store.
+func (b *S3Backend) Lock() error {
+	lockPath := b.objectKey(lockKey)
+	ownerBytes := make([]byte, 16)
+	if _, err := rand.Read(ownerBytes); err != nil {
+		return err
+	}
+	owner := hex.EncodeToString(ownerBytes)
+	deadline := time.Now().Add(maxLockDuration)
+	for time.Now().Before(deadline) {
+		content := []byte(owner + " " + strconv.FormatInt(time.Now().Add(maxLockDuration).UnixNano(), 10))
+		err := b.putObject(lockPath, content, http.Header{"If-None-Match": {"*"}}, false)
+		if err == errS3PreconditionFailed {
+			// Lock is held by someone, replace it only if it is expired.
+			var current []byte
+			var etag string
+			current, etag, err = b.getObject(lockPath)
+			if err == api.ErrNotExist {
+				continue
+			}
+			if err != nil {
+				b.log.WithError(err).Debug("Failed to read S3 lock")
+				return err
+			}
+			if _, expired := parseS3Lock(current); !expired {
+				time.Sleep(s3LockRetryTimeout)
+				continue
+			}
+			err = b.putObject(lockPath, content, http.Header{"If-Match": {etag}}, false)
+			if err == errS3PreconditionFailed {
+				continue
+			}
+		}
+		if err != nil {
+			b.log.WithError(err).Debug("Failed to acquire S3 lock")
+			return err
+		}
+		b.lockMutex.Lock()
+		b.lockOwner = owner
+		b.lockMutex.Unlock()
+		return nil
+	}
+	return ErrLockTimeout
+}
+
+// parseS3Lock returns owner of the lock and whether the lock is expired
+func parseS3Lock(content []byte) (string, bool) {
+	parts := strings.Fields(string(content))
+	if len(parts) != 2 {
+		return "", true
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", true
+	}
+	return parts[0], time.Now().UnixNano() >= expires
+}
+
+// Unlock releases currently held exclusive lock.
+func (b *S3Backend) Unlock() error {
+	lockPath := b.objectKey(lockKey)
+	b.lockMutex.Lock()
+	owner := b.lockOwner
+	b.lockOwner = ""
+	b.lockMutex.Unlock()
+	content, _, err := b.getObject(lockPath)
+	if err != nil && err != api.ErrNotExist {
+		b.log.WithError(err).Debug("Failed to read S3 lock")
+		return err
+	}
+	if currentOwner, _ := parseS3Lock(content); err == api.ErrNotExist || currentOwner != owner {
+		b.log.Warn("Releasing expired S3 lock")
+		return nil
+	}
+	err = b.deleteObject(lockPath)
+	if err != nil {
+		b.log.WithError(err).Debug("Failed to release S3 lock")
+	}
+	return err
+}
+
+// RLock acquires a shared lock on the store.
+func (b *S3Backend) RLock() error {
+	return b.Lock()
+}
+
+// RUnlock releases currently held shared lock.
+func (b *S3Backend) RUnlock() error {
+	return b.Unlock()
+}
+
+// Get data at given path.
+func (b *S3Backend) Get(path string) ([]byte, error) {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := b.getObject(path)
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to read key data")
+		return nil, err
+	}
+	return data, nil
+}
+
+// Put data at given path.
+func (b *S3Backend) Put(path string, data []byte) error {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return err
+	}
+	// Put must fail if there is already a key at given path.
+	err = b.putObject(path, data, http.Header{"If-None-Match": {"*"}}, true)
+	if err == errS3PreconditionFailed {
+		err = api.ErrExist
+	}
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to write key data")
+	}
+	return err
+}
+
+// ListAll enumerates all paths currently stored.
+// The paths are returned in lexicographical order.
+func (b *S3Backend) ListAll() ([]string, error) {
+	prefix := ""
+	if b.rootDir != "" {
+		prefix = b.rootDir + "/"
+	}
+	keys := make([]string, 0, 16)
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+		"max-keys":  {strconv.Itoa(s3ListPageSize)},
+	}
+	for {
+		response, err := b.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := s3ListBucketResult{}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Trim the root directory from paths, it's implicit.
+		// While we're here, filter out special keys as well.
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, prefix)
+			if key == versionKey || key == lockKey {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Rename oldpath into newpath.
+// S3 has no atomic rename, so it should be called under the exclusive lock.
+func (b *S3Backend) Rename(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, false)
+}
+
+// RenameNX renames oldpath into newpath non-destructively.
+func (b *S3Backend) RenameNX(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, true)
+}
+
+func (b *S3Backend) rename(oldpath, newpath string, exclusive bool) error {
+	oldpath, err := b.keyPath(oldpath)
+	if err != nil {
+		return err
+	}
+	newpath, err = b.keyPath(newpath)
+	if err != nil {
+		return err
+	}
+	logger := b.log.WithFields(log.Fields{"src": oldpath, "dst": newpath})
+	data, _, err := b.getObject(oldpath)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to rename key")
+		return err
+	}
+	if oldpath == newpath {
+		if exclusive {
+			return api.ErrExist
+		}
+		return nil
+	}
+	var headers http.Header
+	if exclusive {
+		headers = http.Header{"If-None-Match": {"*"}}
+	}
+	err = b.putObject(newpath, data, headers, true)
+	if err == errS3PreconditionFailed {
+		err = api.ErrExist
+	}
+	if err != nil {
+		logger.WithError(err).Debug("Failed to rename key")
+		return err
+	}
+	err = b.deleteObject(oldpath)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to remove renamed key")
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api/tests"
+)
+
+const testS3Bucket = "keystore"
+
+// fakeS3 is a minimal S3-compatible storage with path-style addressing and conditional writes
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	// lockModes stores Object Lock mode of the last written version of the object
+	lockModes map[string]string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), lockModes: make(map[string]string)}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/"+testS3Bucket)
+	key = strings.TrimPrefix(key, "/")
+	if key == "" && r.Method == http.MethodGet {
+		s.list(w, r)
+		return
+	}
+	data, exists := s.objects[key]
+	etag := fmt.Sprintf("\"%x\"", md5.Sum(data))
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case http.MethodPut:
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != etag)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("X-Amz-Server-Side-Encryption") != s3KMSEncryption || r.Header.Get("Content-MD5") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.objects[key] = body
+		s.lockModes[key] = r.Header.Get("X-Amz-Object-Lock-Mode")
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	// small pages to check pagination
+	end := start + 2
+	result := s3ListBucketResult{}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	} else {
+		end = len(keys)
+	}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{Key: key})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Config(server *httptest.Server) *S3Config {
+	return &S3Config{
+		Bucket:       testS3Bucket,
+		Region:       "us-east-1",
+		Endpoint:     server.URL,
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("access_key", "secret_key", ""),
+		RootDir:      "keystore-v2-test/" + time.Now().Format(time.RFC3339Nano),
+	}
+}
+
+func TestS3(t *testing.T) {
+	server := httptest.NewServer(newFakeS3())
+	defer server.Close()
+	tests.TestBackend(t, func(t *testing.T) api.Backend {
+		backend, err := CreateS3Backend(newTestS3Config(server))
+		if err != nil {
+			t.Fatalf("Failed to create S3 backend: %v", err)
+		}
+		return backend
+	})
+}
+
+func TestS3OpenBackend(t *testing.T) {
+	server := httptest.NewServer(newFakeS3())
+	defer server.Close()
+	config := newTestS3Config(server)
+	if _, err := OpenS3Backend(config); err != api.ErrNotExist {
+		t.Fatalf("Expected %v, took %v", api.ErrNotExist, err)
+	}
+	if _, err := CreateS3Backend(config); err != nil {
+		t.Fatal(err)
+	}
+	// the second call uses existing keystore
+	if _, err := CreateS3Backend(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenS3Backend(config); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidConfigs := []struct {
+		config S3Config
+		err    error
+	}{
+		{S3Config{Region: "us-east-1"}, ErrS3EmptyBucket},
+		{S3Config{Bucket: testS3Bucket}, ErrS3EmptyRegion},
+		{S3Config{Bucket: testS3Bucket, Region: "us-east-1"}, ErrS3EmptyCredentials},
+		{S3Config{Bucket: testS3Bucket, Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("a", "b", ""), ObjectLockMode: "unknown"}, ErrS3InvalidObjectLock},
+		{S3Config{Bucket: testS3Bucket, Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("a", "b", ""), ObjectLockMode: S3ObjectLockCompliance}, ErrS3InvalidLockRetention},
+	}
+	for i, tcase := range invalidConfigs {
+		if _, err := OpenS3Backend(&tcase.config); err != tcase.err {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestS3ObjectLock(t *testing.T) {
+	storage := newFakeS3()
+	server := httptest.NewServer(storage)
+	defer server.Close()
+	config := newTestS3Config(server)
+	config.ObjectLockMode = S3ObjectLockGovernance
+	config.ObjectLockRetention = time.Hour
+	backend, err := CreateS3Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put("key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Rename("key", "renamed key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	expectedModes := map[string]string{
+		"key":         S3ObjectLockGovernance,
+		"renamed key": S3ObjectLockGovernance,
+		lockKey:       "",
+		versionKey:    "",
+	}
+	for name, mode := range expectedModes {
+		if storage.lockModes[backend.objectKey(name)] != mode {
+			t.Fatalf("Expected %s mode of %s, took %s", mode, name, storage.lockModes[backend.objectKey(name)])
+		}
+	}
+}
+
+func TestS3EscapePathSegment(t *testing.T) {
+	testcases := []struct {
+		segment string
+		escaped string
+	}{
+		{"client_id.key-v2~", "client_id.key-v2~"},
+		{"into itself", "into%20itself"},
+		{"2020-01-01T00:00:00+03:00", "2020-01-01T00%3A00%3A00%2B03%3A00"},
+		{"a=b/c", "a%3Db%2Fc"},
+	}
+	for i, tcase := range testcases {
+		if escaped := s3EscapePathSegment(tcase.segment); escaped != tcase.escaped {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.escaped, escaped)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const sqlSubsystemName = "sql-backend"
+
+// SQLDialect defines database used by SQLBackend
+type SQLDialect string
+
+// Supported SQL dialects
+const (
+	SQLDialectPostgreSQL SQLDialect = "postgresql"
+	SQLDialectMySQL      SQLDialect = "mysql"
+)
+
+// DefaultSQLKeystoreTable name of table used to store keys by default
+const DefaultSQLKeystoreTable = "acra_keystore"
+
+// Errors returned by SQLBackend:
+var (
+	ErrSQLInvalidTableName = errors.New("invalid keystore table name")
+	ErrSQLUnknownDialect   = errors.New("unknown SQL dialect of keystore")
+)
+
+var sqlTableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+const sqlLockRetryInterval = 50 * time.Millisecond
+
+// sqlQueries contains dialect specific queries, "%[1]s" is replaced with table name
+type sqlQueries struct {
+	createTable string
+	insert      string
+	placeholder func(index int) string
+}
+
+var sqlDialectQueries = map[SQLDialect]sqlQueries{
+	SQLDialectPostgreSQL: {
+		createTable: `CREATE TABLE IF NOT EXISTS %[1]s (
+			path VARCHAR(512) NOT NULL PRIMARY KEY,
+			data BYTEA NOT NULL,
+			version BIGINT NOT NULL,
+			updated BIGINT NOT NULL
+		)`,
+		insert: `INSERT INTO %[1]s (path, data, version, updated) VALUES ($1, $2, 1, $3) ON CONFLICT DO NOTHING`,
+		placeholder: func(index int) string {
+			return "$" + strconv.Itoa(index)
+		},
+	},
+	SQLDialectMySQL: {
+		createTable: `CREATE TABLE IF NOT EXISTS %[1]s (
+			path VARCHAR(512) CHARACTER SET ascii COLLATE ascii_bin NOT NULL PRIMARY KEY,
+			data LONGBLOB NOT NULL,
+			version BIGINT NOT NULL,
+			updated BIGINT NOT NULL
+		)`,
+		insert: `INSERT IGNORE INTO %[1]s (path, data, version, updated) VALUES (?, ?, 1, ?)`,
+		placeholder: func(int) string {
+			return "?"
+		},
+	},
+}
+
+// SQLBackend keeps key data in a table of PostgreSQL or MySQL database.
+// Every key path is a row with data and version which is incremented on every change of the row.
+// Key data is already encrypted by the keystore, so the table contains no plaintext keys.
+// Locking relies on versions of the row with lock, so several instances may share the same keystore.
+type SQLBackend struct {
+	db      *sql.DB
+	table   string
+	queries sqlQueries
+	log     *log.Entry
+
+	lockMutex sync.Mutex
+	lockOwner string
+}
+
+// SQLConfig defines SQL keystore configuration.
+type SQLConfig struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+	// Table used to store keys, DefaultSQLKeystoreTable if empty
+	Table string
+}
+
+func newSQLBackend(config *SQLConfig) (*SQLBackend, error) {
+	table := config.Table
+	if table == "" {
+		table = DefaultSQLKeystoreTable
+	}
+	if !sqlTableNameRegexp.MatchString(table) {
+		return nil, ErrSQLInvalidTableName
+	}
+	queries, ok := sqlDialectQueries[config.Dialect]
+	if !ok {
+		return nil, ErrSQLUnknownDialect
+	}
+	return &SQLBackend{
+		db:      config.DB,
+		table:   table,
+		queries: queries,
+		log: log.WithFields(log.Fields{
+			"service":   serviceName,
+			"subsystem": sqlSubsystemName,
+		}),
+	}, nil
+}
+
+// CreateSQLBackend opens a SQL backend in given table.
+// The table and keystore version row will be created if they do not exist.
+func CreateSQLBackend(config *SQLConfig) (*SQLBackend, error) {
+	b, err := newSQLBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.createTable()
+	if err != nil {
+		b.log.WithError(err).Debug("Cannot create keystore table")
+		return nil, err
+	}
+	// another instance may create the keystore concurrently, so ignore existing rows
+	if err = b.Put(versionKey, []byte(versionString)); err != nil && err != api.ErrExist {
+		b.log.WithError(err).Debug("Cannot create version key")
+		return nil, err
+	}
+	if err = b.Put(lockKey, nil); err != nil && err != api.ErrExist {
+		b.log.WithError(err).Debug("Cannot create lock key")
+		return nil, err
+	}
+	if err = b.checkVersionKey(); err != nil {
+		b.log.WithError(err).Debug("Keystore version key not valid")
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenSQLBackend opens a SQL backend in given table.
+func OpenSQLBackend(config *SQLConfig) (*SQLBackend, error) {
+	b, err := newSQLBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkVersionKey()
+	if err != nil {
+		b.log.WithError(err).Debug("Keystore version key not valid")
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *SQLBackend) createTable() error {
+	_, err := b.db.Exec(fmt.Sprintf(b.queries.createTable, b.table))
+	return err
+}
+
+func (b *SQLBackend) checkVersionKey() error {
+	content, err := b.Get(versionKey)
+	if err != nil {
+		return err
+	}
+	if string(content) != versionString {
+		return ErrInvalidVersion
+	}
+	return nil
+}
+
+// query formats query with table name and dialect specific placeholders written as "?"
+func (b *SQLBackend) query(query string) string {
+	query = fmt.Sprintf(query, b.table)
+	parts := strings.Split(query, "?")
+	result := strings.Builder{}
+	for i, part := range parts {
+		if i > 0 {
+			result.WriteString(b.queries.placeholder(i))
+		}
+		result.WriteString(part)
+	}
+	return result.String()
+}
+
+// Accept either UNIX or Windows separator, paths are stored only with UNIX one.
+var sqlPathSeparators = strings.NewReplacer("\\", api.PathSeparator)
+
+// keyPath converts "key path" into path stored in the table.
+func (b *SQLBackend) keyPath(keyPath string) (string, error) {
+	keyPath = sqlPathSeparators.Replace(keyPath)
+	for _, part := range strings.Split(keyPath, api.PathSeparator) {
+		if part == "." || part == ".." {
+			b.log.WithField("path", keyPath).Warn("invalid key path used")
+			return "", api.ErrInvalidPath
+		}
+	}
+	return keyPath, nil
+}
+
+// Close this backend instance, releasing the lock if it is held.
+// Database connection is owned by the caller and stays open.
+func (b *SQLBackend) Close() error {
+	b.lockMutex.Lock()
+	locked := b.lockOwner != ""
+	b.lockMutex.Unlock()
+	if !locked {
+		return nil
+	}
+	err := b.Unlock()
+	if err != nil {
+		b.log.WithError(err).Warn("Failed to release SQL lock")
+	}
+	return err
+}
+
+// Lock is a row with owner and expiration time of the lock. Owner takes the lock by updating the row only if
+// it has the same version as unlocked or expired lock read before, so only one of concurrent writers succeeds.
+// This is exclusive-only lock, the same as with Redis backend.
+
+// Lock acquires an exclusive lock on the store.
+func (b *SQLBackend) Lock() error {
+	ownerBytes := make([]byte, 16)
+	if _, err := rand.Read(ownerBytes); err != nil {
+		return err
+	}
+	owner := hex.EncodeToString(ownerBytes)
+	deadline := time.Now().Add(maxLockDuration)
+	for time.Now().Before(deadline) {
+		var content []byte
+		var version int64
+		err := b.db.QueryRow(b.query(`SELECT data, version FROM %[1]s WHERE path = ?`), lockKey).Scan(&content, &version)
+		if err != nil {
+			b.log.WithError(err).Debug("Failed to read SQL lock")
+			return err
+		}
+		if _, expired := parseSQLLock(content); !expired {
+			time.Sleep(sqlLockRetryInterval)
+			continue
+		}
+		now := time.Now()
+		content = []byte(owner + " " + strconv.FormatInt(now.Add(maxLockDuration).UnixNano(), 10))
+		result, err := b.db.Exec(b.query(`UPDATE %[1]s SET data = ?, version = version + 1, updated = ? WHERE path = ? AND version = ?`),
+			content, now.Unix(), lockKey, version)
+		if err != nil {
+			b.log.WithError(err).Debug("Failed to acquire SQL lock")
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		// Someone else has taken the lock after we have read it, keep waiting.
+		if affected == 0 {
+			continue
+		}
+		b.lockMutex.Lock()
+		b.lockOwner = owner
+		b.lockMutex.Unlock()
+		return nil
+	}
+	return ErrLockTimeout
+}
+
+// parseSQLLock returns owner of the lock and whether the lock is expired
+func parseSQLLock(content []byte) (string, bool) {
+	parts := strings.Fields(string(content))
+	if len(parts) != 2 {
+		return "", true
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", true
+	}
+	return parts[0], time.Now().UnixNano() >= expires
+}
+
+// Unlock releases currently held exclusive lock.
+func (b *SQLBackend) Unlock() error {
+	b.lockMutex.Lock()
+	owner := b.lockOwner
+	b.lockOwner = ""
+	b.lockMutex.Unlock()
+	var content []byte
+	var version int64
+	err := b.db.QueryRow(b.query(`SELECT data, version FROM %[1]s WHERE path = ?`), lockKey).Scan(&content, &version)
+	if err != nil {
+		b.log.WithError(err).Debug("Failed to read SQL lock")
+		return err
+	}
+	if currentOwner, _ := parseSQLLock(content); currentOwner != owner {
+		b.log.Warn("Releasing expired SQL lock")
+		return nil
+	}
+	_, err = b.db.Exec(b.query(`UPDATE %[1]s SET data = ?, version = version + 1, updated = ? WHERE path = ? AND version = ?`),
+		[]byte{}, time.Now().Unix(), lockKey, version)
+	if err != nil {
+		b.log.WithError(err).Debug("Failed to release SQL lock")
+	}
+	return err
+}
+
+// RLock acquires a shared lock on the store.
+func (b *SQLBackend) RLock() error {
+	return b.Lock()
+}
+
+// RUnlock releases currently held shared lock.
+func (b *SQLBackend) RUnlock() error {
+	return b.Unlock()
+}
+
+// Get data at given path.
+func (b *SQLBackend) Get(path string) ([]byte, error) {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = b.db.QueryRow(b.query(`SELECT data FROM %[1]s WHERE path = ?`), path).Scan(&data)
+	if err == sql.ErrNoRows {
+		err = api.ErrNotExist
+	}
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to read key data")
+		return nil, err
+	}
+	return data, nil
+}
+
+// Put data at given path.
+func (b *SQLBackend) Put(path string, data []byte) error {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	// Put must fail if there is already a key at given path.
+	result, err := b.db.Exec(fmt.Sprintf(b.queries.insert, b.table), path, data, time.Now().Unix())
+	if err == nil {
+		var affected int64
+		affected, err = result.RowsAffected()
+		if err == nil && affected == 0 {
+			err = api.ErrExist
+		}
+	}
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to write key data")
+	}
+	return err
+}
+
+// ListAll enumerates all paths currently stored.
+// The paths are returned in lexicographical order.
+func (b *SQLBackend) ListAll() ([]string, error) {
+	rows, err := b.db.Query(b.query(`SELECT path FROM %[1]s WHERE path <> ? AND path <> ?`), versionKey, lockKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0, 16)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// collation of the database may differ from byte order
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Rename oldpath into newpath atomically.
+func (b *SQLBackend) Rename(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, false)
+}
+
+// RenameNX renames oldpath into newpath non-destructively.
+func (b *SQLBackend) RenameNX(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, true)
+}
+
+func (b *SQLBackend) rename(oldpath, newpath string, exclusive bool) error {
+	oldpath, err := b.keyPath(oldpath)
+	if err != nil {
+		return err
+	}
+	newpath, err = b.keyPath(newpath)
+	if err != nil {
+		return err
+	}
+	logger := b.log.WithFields(log.Fields{"src": oldpath, "dst": newpath})
+	err = b.renameInTransaction(oldpath, newpath, exclusive)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to rename key")
+	}
+	return err
+}
+
+func (b *SQLBackend) renameInTransaction(oldpath, newpath string, exclusive bool) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var version int64
+	err = tx.QueryRow(b.query(`SELECT version FROM %[1]s WHERE path = ? FOR UPDATE`), oldpath).Scan(&version)
+	if err == sql.ErrNoRows {
+		return api.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if oldpath == newpath {
+		if exclusive {
+			return api.ErrExist
+		}
+		return nil
+	}
+	var existingVersion int64
+	err = tx.QueryRow(b.query(`SELECT version FROM %[1]s WHERE path = ? FOR UPDATE`), newpath).Scan(&existingVersion)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case exclusive:
+		return api.ErrExist
+	default:
+		if _, err := tx.Exec(b.query(`DELETE FROM %[1]s WHERE path = ?`), newpath); err != nil {
+			return err
+		}
+		// keep versions increasing for the path
+		if existingVersion > version {
+			version = existingVersion
+		}
+	}
+	_, err = tx.Exec(b.query(`UPDATE %[1]s SET path = ?, version = ?, updated = ? WHERE path = ?`),
+		newpath, version+1, time.Now().Unix(), oldpath)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api/tests"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func testSQLBackend(t *testing.T, dialect SQLDialect, driverName, connectionStringEnv string) {
+	connectionString := os.Getenv(connectionStringEnv)
+	if connectionString == "" {
+		t.Skipf("%s is not set", connectionStringEnv)
+	}
+	db, err := sql.Open(driverName, connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tableCount := 0
+	tests.TestBackend(t, func(t *testing.T) api.Backend {
+		// every test expects empty keystore
+		tableCount++
+		table := fmt.Sprintf("acra_keystore_test_%d", tableCount)
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Exec("DROP TABLE IF EXISTS " + table)
+		})
+		backend, err := CreateSQLBackend(&SQLConfig{DB: db, Dialect: dialect, Table: table})
+		if err != nil {
+			t.Fatalf("Failed to create SQL backend: %v", err)
+		}
+		// the second call uses existing keystore
+		if _, err := CreateSQLBackend(&SQLConfig{DB: db, Dialect: dialect, Table: table}); err != nil {
+			t.Fatalf("Failed to create SQL backend again: %v", err)
+		}
+		return backend
+	})
+}
+
+func TestSQLPostgreSQL(t *testing.T) {
+	testSQLBackend(t, SQLDialectPostgreSQL, "pgx", "TEST_POSTGRESQL_KEYSTORE")
+}
+
+func TestSQLMySQL(t *testing.T) {
+	testSQLBackend(t, SQLDialectMySQL, "mysql", "TEST_MYSQL_KEYSTORE")
+}
+
+func TestSQLInvalidConfig(t *testing.T) {
+	for _, name := range []string{"1table", "table; DROP TABLE users", "schema.table"} {
+		if _, err := OpenSQLBackend(&SQLConfig{Dialect: SQLDialectPostgreSQL, Table: name}); err != ErrSQLInvalidTableName {
+			t.Fatalf("Expected ErrSQLInvalidTableName for %q, took %v", name, err)
+		}
+	}
+	if _, err := OpenSQLBackend(&SQLConfig{Dialect: "oracle"}); err != ErrSQLUnknownDialect {
+		t.Fatalf("Expected ErrSQLUnknownDialect, took %v", err)
+	}
+}
+
+func TestSQLQueryPlaceholders(t *testing.T) {
+	testcases := []struct {
+		dialect SQLDialect
+		query   string
+	}{
+		{SQLDialectPostgreSQL, "UPDATE acra_keystore SET path = $1 WHERE path = $2"},
+		{SQLDialectMySQL, "UPDATE acra_keystore SET path = ? WHERE path = ?"},
+	}
+	for i, tcase := range testcases {
+		backend, err := newSQLBackend(&SQLConfig{Dialect: tcase.dialect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query := backend.query(`UPDATE %[1]s SET path = ? WHERE path = ?`); query != tcase.query {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.query, query)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const vaultSubsystemName = "vault-backend"
+
+// Errors returned by VaultBackend:
+var (
+	ErrVaultEmptyMountPath = errors.New("empty mount path of Vault KV secrets engine")
+	ErrVaultInvalidSecret  = errors.New("invalid format of Vault KV secret")
+)
+
+// errVaultCASMismatch is returned when check-and-set version of the write doesn't match the current version of the secret.
+var errVaultCASMismatch = errors.New("check-and-set version mismatch")
+
+const (
+	vaultDataPathPart     = "data"
+	vaultMetadataPathPart = "metadata"
+	vaultValueField       = "value"
+	vaultLockOwnerField   = "owner"
+	vaultLockExpiresField = "expires"
+
+	// vaultNoCAS writes secret regardless of its current version
+	vaultNoCAS = -1
+	// vaultCASNotExist writes secret only if it doesn't exist
+	vaultCASNotExist = 0
+
+	vaultLockRetryInterval = 50 * time.Millisecond
+)
+
+// VaultBackend keeps key data in HashiCorp Vault KV secrets engine (version 2).
+// Every key path is stored as a separate secret. Writes and locking rely on check-and-set versions of secrets,
+// so several instances may share the same keystore.
+type VaultBackend struct {
+	vault     *vault.Client
+	mountPath string
+	rootDir   string
+	log       *log.Entry
+
+	lockMutex   sync.Mutex
+	lockVersion int
+}
+
+// VaultConfig defines Vault keystore configuration.
+type VaultConfig struct {
+	// Client with configured address and token
+	Client *vault.Client
+	// MountPath of KV secrets engine version 2, like "secret"
+	MountPath string
+	// RootDir is the path of keystore inside of the secrets engine
+	RootDir string
+}
+
+func newVaultBackend(config *VaultConfig) (*VaultBackend, error) {
+	mountPath := strings.Trim(config.MountPath, api.PathSeparator)
+	if mountPath == "" {
+		return nil, ErrVaultEmptyMountPath
+	}
+	return &VaultBackend{
+		vault:     config.Client,
+		mountPath: mountPath,
+		rootDir:   strings.Trim(config.RootDir, api.PathSeparator),
+		log: log.WithFields(log.Fields{
+			"service":   serviceName,
+			"subsystem": vaultSubsystemName,
+		}),
+	}, nil
+}
+
+// CreateVaultBackend opens a Vault backend at given root path.
+// The keystore version key will be created if it does not exist.
+func CreateVaultBackend(config *VaultConfig) (*VaultBackend, error) {
+	b, err := newVaultBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkVersionKey()
+	if err == api.ErrNotExist {
+		err = b.Put(versionKey, []byte(versionString))
+		// another instance may create the keystore concurrently
+		if err == api.ErrExist {
+			err = b.checkVersionKey()
+		}
+	}
+	if err != nil {
+		b.log.WithError(err).Debug("Cannot create version key")
+		return nil, err
+	}
+	return b, nil
+}
+
+// OpenVaultBackend opens a Vault backend at given root path.
+func OpenVaultBackend(config *VaultConfig) (*VaultBackend, error) {
+	b, err := newVaultBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkVersionKey()
+	if err != nil {
+		b.log.WithError(err).Debug("Keystore version key not valid")
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *VaultBackend) checkVersionKey() error {
+	content, err := b.Get(versionKey)
+	if err != nil {
+		return err
+	}
+	if string(content) != versionString {
+		return ErrInvalidVersion
+	}
+	return nil
+}
+
+// Accept either UNIX or Windows separator, Vault uses only UNIX one.
+var vaultPathSeparators = strings.NewReplacer("\\", api.PathSeparator)
+
+// keyPath converts "key path" into path of the secret relative to the mount path.
+func (b *VaultBackend) keyPath(keyPath string) (string, error) {
+	keyPath = vaultPathSeparators.Replace(keyPath)
+	for _, part := range strings.Split(keyPath, api.PathSeparator) {
+		if part == "." || part == ".." {
+			b.log.WithField("path", keyPath).Warn("invalid key path used")
+			return "", api.ErrInvalidPath
+		}
+	}
+	return path.Join(b.rootDir, keyPath), nil
+}
+
+func (b *VaultBackend) dataPath(secretPath string) string {
+	return path.Join(b.mountPath, vaultDataPathPart, secretPath)
+}
+
+func (b *VaultBackend) metadataPath(secretPath string) string {
+	return path.Join(b.mountPath, vaultMetadataPathPart, secretPath)
+}
+
+// parseVaultVersion converts version number from Vault response which is decoded as json.Number.
+func parseVaultVersion(value interface{}) (int, error) {
+	switch version := value.(type) {
+	case json.Number:
+		result, err := version.Int64()
+		return int(result), err
+	case float64:
+		return int(version), nil
+	case int:
+		return version, nil
+	}
+	return 0, ErrVaultInvalidSecret
+}
+
+// readSecret returns data and current version of the secret.
+// Returns ErrNotExist if the secret doesn't exist or its current version is deleted.
+func (b *VaultBackend) readSecret(secretPath string) (map[string]interface{}, int, error) {
+	secret, err := b.vault.Logical().Read(b.dataPath(secretPath))
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, 0, api.ErrNotExist
+	}
+	data, ok := secret.Data[vaultDataPathPart].(map[string]interface{})
+	if !ok {
+		return nil, 0, api.ErrNotExist
+	}
+	metadata, ok := secret.Data[vaultMetadataPathPart].(map[string]interface{})
+	if !ok {
+		return nil, 0, ErrVaultInvalidSecret
+	}
+	version, err := parseVaultVersion(metadata["version"])
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, version, nil
+}
+
+// writeSecret writes new version of the secret if its current version matches cas and returns the written version.
+// Use vaultNoCAS to write regardless of the current version and vaultCASNotExist to write only new secret.
+func (b *VaultBackend) writeSecret(secretPath string, data map[string]interface{}, cas int) (int, error) {
+	payload := map[string]interface{}{vaultDataPathPart: data}
+	if cas != vaultNoCAS {
+		payload["options"] = map[string]interface{}{"cas": cas}
+	}
+	secret, err := b.vault.Logical().Write(b.dataPath(secretPath), payload)
+	if err != nil {
+		// Unfortunately, there is no error constant :(
+		if strings.Contains(err.Error(), "check-and-set parameter did not match") {
+			return 0, errVaultCASMismatch
+		}
+		return 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, ErrVaultInvalidSecret
+	}
+	return parseVaultVersion(secret.Data["version"])
+}
+
+// deleteSecret removes all versions and metadata of the secret.
+func (b *VaultBackend) deleteSecret(secretPath string) error {
+	_, err := b.vault.Logical().Delete(b.metadataPath(secretPath))
+	return err
+}
+
+// Close this backend instance, releasing the lock if it is held.
+func (b *VaultBackend) Close() error {
+	b.lockMutex.Lock()
+	locked := b.lockVersion != 0
+	b.lockMutex.Unlock()
+	if !locked {
+		return nil
+	}
+	err := b.Unlock()
+	if err != nil {
+		b.log.WithError(err).Warn("Failed to release Vault lock")
+	}
+	return err
+}
+
+// Lock is a secret with expiration time of the lock. Owner takes the lock by writing new expiration time
+// with check-and-set version of unlocked or expired lock, so only one of concurrent writers succeeds.
+// This is exclusive-only lock, the same as with Redis backend.
+
+// Lock acquires an exclusive lock on the store.
+func (b *VaultBackend) Lock() error {
+	lockPath, err := b.keyPath(lockKey)
+	if err != nil {
+		return err
+	}
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(maxLockDuration)
+	for time.Now().Before(deadline) {
+		data, version, err := b.readSecret(lockPath)
+		if err != nil && err != api.ErrNotExist {
+			b.log.WithError(err).Debug("Failed to read Vault lock")
+			return err
+		}
+		if err == nil && !vaultLockExpired(data) {
+			time.Sleep(vaultLockRetryInterval)
+			continue
+		}
+		newVersion, err := b.writeSecret(lockPath, map[string]interface{}{
+			vaultLockOwnerField:   hex.EncodeToString(owner),
+			vaultLockExpiresField: strconv.FormatInt(time.Now().Add(maxLockDuration).UnixNano(), 10),
+		}, version)
+		// Someone else has taken the lock after we have read it, keep waiting.
+		if err == errVaultCASMismatch {
+			continue
+		}
+		if err != nil {
+			b.log.WithError(err).Debug("Failed to acquire Vault lock")
+			return err
+		}
+		b.lockMutex.Lock()
+		b.lockVersion = newVersion
+		b.lockMutex.Unlock()
+		return nil
+	}
+	return ErrLockTimeout
+}
+
+func vaultLockExpired(data map[string]interface{}) bool {
+	value, ok := data[vaultLockExpiresField].(string)
+	if !ok {
+		return true
+	}
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return true
+	}
+	return time.Now().UnixNano() >= expires
+}
+
+// Unlock releases currently held exclusive lock.
+func (b *VaultBackend) Unlock() error {
+	lockPath, err := b.keyPath(lockKey)
+	if err != nil {
+		return err
+	}
+	b.lockMutex.Lock()
+	version := b.lockVersion
+	b.lockVersion = 0
+	b.lockMutex.Unlock()
+	_, err = b.writeSecret(lockPath, map[string]interface{}{vaultLockExpiresField: "0"}, version)
+	if err == errVaultCASMismatch {
+		b.log.Warn("Releasing expired Vault lock")
+		return nil
+	}
+	if err != nil {
+		b.log.WithError(err).Debug("Failed to release Vault lock")
+	}
+	return err
+}
+
+// RLock acquires a shared lock on the store.
+func (b *VaultBackend) RLock() error {
+	return b.Lock()
+}
+
+// RUnlock releases currently held shared lock.
+func (b *VaultBackend) RUnlock() error {
+	return b.Unlock()
+}
+
+// Get data at given path.
+func (b *VaultBackend) Get(path string) ([]byte, error) {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := b.readSecret(path)
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to read key data")
+		return nil, err
+	}
+	value, ok := data[vaultValueField].(string)
+	if !ok {
+		return nil, ErrVaultInvalidSecret
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// Put data at given path.
+func (b *VaultBackend) Put(path string, data []byte) error {
+	path, err := b.keyPath(path)
+	if err != nil {
+		return err
+	}
+	// Put must fail if there is already a key at given path.
+	_, err = b.writeSecret(path, map[string]interface{}{vaultValueField: base64.StdEncoding.EncodeToString(data)}, vaultCASNotExist)
+	if err == errVaultCASMismatch {
+		err = api.ErrExist
+	}
+	if err != nil {
+		b.log.WithError(err).WithField("path", path).Debug("Failed to write key data")
+	}
+	return err
+}
+
+// ListAll enumerates all paths currently stored.
+// The paths are returned in lexicographical order.
+func (b *VaultBackend) ListAll() ([]string, error) {
+	keys, err := b.listDirectory("")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// listDirectory recursively enumerates secrets in the directory relative to the root directory,
+// filtering out special keys.
+func (b *VaultBackend) listDirectory(dir string) ([]string, error) {
+	secret, err := b.vault.Logical().List(b.metadataPath(path.Join(b.rootDir, dir)))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	entries, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, ErrVaultInvalidSecret
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := entry.(string)
+		if !ok {
+			return nil, ErrVaultInvalidSecret
+		}
+		if strings.HasSuffix(name, api.PathSeparator) {
+			subKeys, err := b.listDirectory(dir + name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, subKeys...)
+			continue
+		}
+		if dir == "" && (name == versionKey || name == lockKey) {
+			continue
+		}
+		keys = append(keys, dir+name)
+	}
+	return keys, nil
+}
+
+// Rename oldpath into newpath.
+// Vault has no atomic rename, so it should be called under the exclusive lock.
+func (b *VaultBackend) Rename(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, vaultNoCAS)
+}
+
+// RenameNX renames oldpath into newpath non-destructively.
+func (b *VaultBackend) RenameNX(oldpath, newpath string) error {
+	return b.rename(oldpath, newpath, vaultCASNotExist)
+}
+
+func (b *VaultBackend) rename(oldpath, newpath string, cas int) error {
+	oldpath, err := b.keyPath(oldpath)
+	if err != nil {
+		return err
+	}
+	newpath, err = b.keyPath(newpath)
+	if err != nil {
+		return err
+	}
+	logger := b.log.WithFields(log.Fields{"src": oldpath, "dst": newpath})
+	data, _, err := b.readSecret(oldpath)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to rename key")
+		return err
+	}
+	if oldpath == newpath {
+		if cas == vaultCASNotExist {
+			return api.ErrExist
+		}
+		return nil
+	}
+	_, err = b.writeSecret(newpath, data, cas)
+	if err == errVaultCASMismatch {
+		err = api.ErrExist
+	}
+	if err != nil {
+		logger.WithError(err).Debug("Failed to rename key")
+		return err
+	}
+	err = b.deleteSecret(oldpath)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to remove renamed key")
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+//go:build integration && vault
+// +build integration,vault
+
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/filesystem/backend/api/tests"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// The tests expect Vault instance in dev mode with "root_token" token, the same as
+// HashiCorp Vault ACRA_MASTER_KEY loader tests. Every test uses separate root directory
+// in a new KV version 2 secrets engine which is unmounted after the tests.
+const (
+	testVaultMountPath = "keystore_v2_test"
+	testVaultRootDir   = "keystore-v2-test"
+)
+
+func newTestVaultClient(t *testing.T) *vault.Client {
+	port, ok := os.LookupEnv("TEST_VAULT_PORT")
+	if !ok {
+		port = "8200"
+	}
+	host, ok := os.LookupEnv("TEST_VAULT_HOST")
+	if !ok {
+		host = "localhost"
+	}
+	config := vault.DefaultConfig()
+	config.Address = fmt.Sprintf("https://%s:%s", host, port)
+	if err := config.ConfigureTLS(&vault.TLSConfig{Insecure: true}); err != nil {
+		t.Fatal(err)
+	}
+	client, err := vault.NewClient(config)
+	if err != nil {
+		t.Fatalf("Failed to initialize Vault client: %v", err)
+	}
+	client.SetToken("root_token")
+	return client
+}
+
+func TestVault(t *testing.T) {
+	client := newTestVaultClient(t)
+	err := client.Sys().Mount(testVaultMountPath, &vault.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": "2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to mount KV secrets engine: %v", err)
+	}
+	defer func() {
+		if err := client.Sys().Unmount(testVaultMountPath); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// KV version 2 engine upgrades its storage in background after mounting
+	for i := 0; i < 30; i++ {
+		if _, err = client.Logical().Read(testVaultMountPath + "/config"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	tests.TestBackend(t, func(t *testing.T) api.Backend {
+		config := &VaultConfig{
+			Client:    client,
+			MountPath: testVaultMountPath,
+			RootDir:   testVaultRootDir + "/" + time.Now().Format(time.RFC3339Nano),
+		}
+		backend, err := CreateVaultBackend(config)
+		if err != nil {
+			t.Fatalf("Failed to create Vault backend: %v", err)
+		}
+		return backend
+	})
+
+	t.Run("OpenNotExistingKeystore", func(t *testing.T) {
+		_, err := OpenVaultBackend(&VaultConfig{Client: client, MountPath: testVaultMountPath, RootDir: "not-existing"})
+		if err != api.ErrNotExist {
+			t.Fatalf("Expected %v, took %v", api.ErrNotExist, err)
+		}
+	})
+}
@@ -24,7 +24,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v7"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cossacklabs/acra/cmd"
@@ -82,13 +81,14 @@ func OpenDirectoryRW(rootDir string, cryptosuite *crypto.KeyStoreSuite) (api.Mut
 func IsKeyDirectory(keyDirPath string) bool {
 	redisParams := cmd.ParseRedisCLIParametersFromFlags(flag.CommandLine, "")
 	if redisParams.KeysConfigured() {
+		redisOptions, err := redisParams.KeysOptions(flag.CommandLine)
+		if err != nil {
+			log.WithError(err).Debug("Invalid Redis parameters")
+			return false
+		}
 		redisClient, err := backend.OpenRedisBackend(&backend.RedisConfig{
 			RootDir: keyDirPath,
-			Options: &redis.Options{
-				Addr:     redisParams.HostPort,
-				Password: redisParams.Password,
-				DB:       redisParams.DBKeys,
-			},
+			Options: redisOptions,
 		})
 		if err != nil {
 			log.WithError(err).Debug("Failed to find keystore v2 in Redis")
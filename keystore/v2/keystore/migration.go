@@ -0,0 +1,339 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keystore
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+
+	"github.com/cossacklabs/acra/keystore"
+	filesystemV1 "github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/keystore/v2/keystore/api"
+)
+
+// Modes of online migration from keystore v1 to v2
+const (
+	// MigrationModeDualRead reads keys from keystore v2 and falls back to keystore v1
+	MigrationModeDualRead = "dual_read"
+	// MigrationModeLazy works like MigrationModeDualRead and imports keys read from keystore v1 into keystore v2
+	MigrationModeLazy = "lazy"
+)
+
+// SupportedMigrationModes lists values of --keystore_migration_mode
+var SupportedMigrationModes = []string{MigrationModeDualRead, MigrationModeLazy}
+
+// Errors of online migration
+var (
+	ErrUnsupportedMigrationMode = errors.New("unsupported keystore migration mode")
+	ErrInvalidMigrationKeyDirs  = errors.New("keystore migration requires keystore v1 and keystore v2 directories")
+)
+
+// MigratingKeyStore is ServerKeyStore which serves keys from keystore v2 and falls back to keystore v1
+// for keys which weren't migrated yet. New keys are generated, rotated and destroyed in keystore v2 only.
+// Previous keys from keystore v1 are still used for decryption after migration of the current key.
+type MigratingKeyStore struct {
+	*ServerKeyStore
+	v1   *filesystemV1.KeyStore
+	lazy bool
+
+	lock sync.Mutex
+	// attempted contains keys which were imported or failed to import, so they are imported only once
+	attempted map[string]bool
+}
+
+// NewMigratingKeyStore returns keystore which reads keys from keystoreV2 and keystoreV1 in the migration mode
+func NewMigratingKeyStore(keystoreV2 *ServerKeyStore, keystoreV1 *filesystemV1.KeyStore, mode string) (*MigratingKeyStore, error) {
+	switch mode {
+	case MigrationModeDualRead, MigrationModeLazy:
+	default:
+		return nil, ErrUnsupportedMigrationMode
+	}
+	return &MigratingKeyStore{
+		ServerKeyStore: keystoreV2,
+		v1:             keystoreV1,
+		lazy:           mode == MigrationModeLazy,
+		attempted:      make(map[string]bool),
+	}, nil
+}
+
+// fallbackToV1 returns true if key should be read from keystore v1 after error of keystore v2.
+// Keys destroyed in keystore v2 are not restored from keystore v1.
+func fallbackToV1(err error) bool {
+	return err != nil && !errors.Is(err, api.ErrKeyDestroyed)
+}
+
+// migrate imports current key read from keystore v1 into keystore v2 in lazy mode
+func (s *MigratingKeyStore) migrate(purpose keystore.KeyPurpose, clientID []byte) {
+	logger := s.log.WithField("purpose", purpose).WithField("client_id", string(clientID))
+	logger.Debugln("Read key from keystore v1")
+	if !s.lazy {
+		return
+	}
+	id := purpose.String() + "/" + string(clientID)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.attempted[id] {
+		return
+	}
+	s.attempted[id] = true
+	key, err := s.v1.ExportedKeyByPurpose(purpose, clientID)
+	if err == nil {
+		err = s.ImportKeyFileV1(s.v1, *key)
+	}
+	if err != nil {
+		logger.WithError(err).Warnln("Failed to migrate key to keystore v2")
+		return
+	}
+	logger.Infoln("Migrated key to keystore v2")
+}
+
+// mergePrivateKeys returns keys of keystore v2 followed by keys of keystore v1 which weren't migrated
+func mergePrivateKeys(keysV2, keysV1 []*keys.PrivateKey) []*keys.PrivateKey {
+	merged := keysV2
+	for _, keyV1 := range keysV1 {
+		migrated := false
+		for _, keyV2 := range keysV2 {
+			if subtle.ConstantTimeCompare(keyV1.Value, keyV2.Value) == 1 {
+				migrated = true
+				break
+			}
+		}
+		if !migrated {
+			merged = append(merged, keyV1)
+		}
+	}
+	return merged
+}
+
+// mergeSymmetricKeys returns keys of keystore v2 followed by keys of keystore v1 which weren't migrated
+func mergeSymmetricKeys(keysV2, keysV1 [][]byte) [][]byte {
+	merged := keysV2
+	for _, keyV1 := range keysV1 {
+		migrated := false
+		for _, keyV2 := range keysV2 {
+			if subtle.ConstantTimeCompare(keyV1, keyV2) == 1 {
+				migrated = true
+				break
+			}
+		}
+		if !migrated {
+			merged = append(merged, keyV1)
+		}
+	}
+	return merged
+}
+
+// GetClientIDEncryptionPublicKey returns storage public key of the client from keystore v2 or v1
+func (s *MigratingKeyStore) GetClientIDEncryptionPublicKey(clientID []byte) (*keys.PublicKey, error) {
+	key, err := s.ServerKeyStore.GetClientIDEncryptionPublicKey(clientID)
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetClientIDEncryptionPublicKey(clientID)
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposeStorageClientKeyPair, clientID)
+	return key, nil
+}
+
+// GetServerDecryptionPrivateKey returns storage private key of the client from keystore v2 or v1
+func (s *MigratingKeyStore) GetServerDecryptionPrivateKey(clientID []byte) (*keys.PrivateKey, error) {
+	key, err := s.ServerKeyStore.GetServerDecryptionPrivateKey(clientID)
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetServerDecryptionPrivateKey(clientID)
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposeStorageClientKeyPair, clientID)
+	return key, nil
+}
+
+// GetServerDecryptionPrivateKeys returns storage private keys of the client from both keystores
+func (s *MigratingKeyStore) GetServerDecryptionPrivateKeys(clientID []byte) ([]*keys.PrivateKey, error) {
+	keysV2, err := s.ServerKeyStore.GetServerDecryptionPrivateKeys(clientID)
+	if err != nil && !fallbackToV1(err) {
+		return nil, err
+	}
+	keysV1, errV1 := s.v1.GetServerDecryptionPrivateKeys(clientID)
+	if errV1 != nil {
+		return keysV2, err
+	}
+	if err != nil {
+		s.migrate(keystore.PurposeStorageClientKeyPair, clientID)
+	}
+	return mergePrivateKeys(keysV2, keysV1), nil
+}
+
+// GetClientIDSymmetricKey returns storage symmetric key of the client from keystore v2 or v1
+func (s *MigratingKeyStore) GetClientIDSymmetricKey(clientID []byte) ([]byte, error) {
+	key, err := s.ServerKeyStore.GetClientIDSymmetricKey(clientID)
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetClientIDSymmetricKey(clientID)
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposeStorageClientSymmetricKey, clientID)
+	return key, nil
+}
+
+// GetClientIDSymmetricKeys returns storage symmetric keys of the client from both keystores
+func (s *MigratingKeyStore) GetClientIDSymmetricKeys(clientID []byte) ([][]byte, error) {
+	keysV2, err := s.ServerKeyStore.GetClientIDSymmetricKeys(clientID)
+	if err != nil && !fallbackToV1(err) {
+		return nil, err
+	}
+	keysV1, errV1 := s.v1.GetClientIDSymmetricKeys(clientID)
+	if errV1 != nil {
+		return keysV2, err
+	}
+	if err != nil {
+		s.migrate(keystore.PurposeStorageClientSymmetricKey, clientID)
+	}
+	return mergeSymmetricKeys(keysV2, keysV1), nil
+}
+
+// GetHMACSecretKey returns HMAC key of the client from keystore v2 or v1
+func (s *MigratingKeyStore) GetHMACSecretKey(clientID []byte) ([]byte, error) {
+	key, err := s.ServerKeyStore.GetHMACSecretKey(clientID)
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetHMACSecretKey(clientID)
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposeSearchHMAC, clientID)
+	return key, nil
+}
+
+// GetPoisonKeyPair returns poison record key pair from keystore v2 or v1
+func (s *MigratingKeyStore) GetPoisonKeyPair() (*keys.Keypair, error) {
+	keypair, err := s.ServerKeyStore.GetPoisonKeyPair()
+	if !fallbackToV1(err) {
+		return keypair, err
+	}
+	keypair, errV1 := s.v1.GetPoisonKeyPair()
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposePoisonRecordKeyPair, nil)
+	return keypair, nil
+}
+
+// GetPoisonPrivateKeys returns poison record private keys from both keystores
+func (s *MigratingKeyStore) GetPoisonPrivateKeys() ([]*keys.PrivateKey, error) {
+	keysV2, err := s.ServerKeyStore.GetPoisonPrivateKeys()
+	if err != nil && !fallbackToV1(err) {
+		return nil, err
+	}
+	keysV1, errV1 := s.v1.GetPoisonPrivateKeys()
+	if errV1 != nil {
+		return keysV2, err
+	}
+	if err != nil {
+		s.migrate(keystore.PurposePoisonRecordKeyPair, nil)
+	}
+	return mergePrivateKeys(keysV2, keysV1), nil
+}
+
+// GetPoisonSymmetricKey returns poison record symmetric key from keystore v2 or v1
+func (s *MigratingKeyStore) GetPoisonSymmetricKey() ([]byte, error) {
+	key, err := s.ServerKeyStore.GetPoisonSymmetricKey()
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetPoisonSymmetricKey()
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposePoisonRecordSymmetricKey, nil)
+	return key, nil
+}
+
+// GetPoisonSymmetricKeys returns poison record symmetric keys from both keystores
+func (s *MigratingKeyStore) GetPoisonSymmetricKeys() ([][]byte, error) {
+	keysV2, err := s.ServerKeyStore.GetPoisonSymmetricKeys()
+	if err != nil && !fallbackToV1(err) {
+		return nil, err
+	}
+	keysV1, errV1 := s.v1.GetPoisonSymmetricKeys()
+	if errV1 != nil {
+		return keysV2, err
+	}
+	if err != nil {
+		s.migrate(keystore.PurposePoisonRecordSymmetricKey, nil)
+	}
+	return mergeSymmetricKeys(keysV2, keysV1), nil
+}
+
+// GetLogSecretKey returns audit log key from keystore v2 or v1
+func (s *MigratingKeyStore) GetLogSecretKey() ([]byte, error) {
+	key, err := s.ServerKeyStore.GetLogSecretKey()
+	if !fallbackToV1(err) {
+		return key, err
+	}
+	key, errV1 := s.v1.GetLogSecretKey()
+	if errV1 != nil {
+		return nil, err
+	}
+	s.migrate(keystore.PurposeAuditLog, nil)
+	return key, nil
+}
+
+// ListKeys lists current keys of keystore v2 followed by keys of keystore v1
+func (s *MigratingKeyStore) ListKeys() ([]keystore.KeyDescription, error) {
+	keysV2, err := s.ServerKeyStore.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	keysV1, err := s.v1.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	return append(keysV2, keysV1...), nil
+}
+
+// ListRotatedKeys lists rotated keys of keystore v2 followed by keys of keystore v1
+func (s *MigratingKeyStore) ListRotatedKeys() ([]keystore.KeyDescription, error) {
+	keysV2, err := s.ServerKeyStore.ListRotatedKeys()
+	if err != nil {
+		return nil, err
+	}
+	keysV1, err := s.v1.ListRotatedKeys()
+	if err != nil {
+		return nil, err
+	}
+	return append(keysV2, keysV1...), nil
+}
+
+// CacheOnStart caches keys of keystore v1, keystore v2 doesn't support caching
+func (s *MigratingKeyStore) CacheOnStart() error {
+	return s.v1.CacheOnStart()
+}
+
+// Reset resets cache of keystore v1
+func (s *MigratingKeyStore) Reset() {
+	s.v1.Reset()
+}
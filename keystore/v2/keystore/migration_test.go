@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020, Cossack Labs Limited
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keystore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	keystoreV1 "github.com/cossacklabs/acra/keystore"
+	filesystemV1 "github.com/cossacklabs/acra/keystore/filesystem"
+	cryptoV2 "github.com/cossacklabs/acra/keystore/v2/keystore/crypto"
+	filesystemV2 "github.com/cossacklabs/acra/keystore/v2/keystore/filesystem"
+)
+
+func newMigrationTestKeyStores(t *testing.T) (*ServerKeyStore, *filesystemV1.KeyStore) {
+	rootDirectory := t.TempDir()
+	encryptor, err := keystoreV1.NewSCellKeyEncryptor(testMasterKey)
+	if err != nil {
+		t.Fatalf("failed to initialize encryptor: %v", err)
+	}
+	keyStoreV1, err := filesystemV1.NewFilesystemKeyStore(filepath.Join(rootDirectory, "v1"), encryptor)
+	if err != nil {
+		t.Fatalf("failed to initialize keystore v1: %v", err)
+	}
+	suite, err := cryptoV2.NewSCellSuite(testEncryptionKey, testSignatureKey)
+	if err != nil {
+		t.Fatalf("failed to initialize cryptosuite: %v", err)
+	}
+	keyDirectoryV2, err := filesystemV2.OpenDirectoryRW(filepath.Join(rootDirectory, "v2"), suite)
+	if err != nil {
+		t.Fatalf("failed to initialize keystore v2: %v", err)
+	}
+	return NewServerKeyStore(keyDirectoryV2), keyStoreV1
+}
+
+func TestNewMigratingKeyStore(t *testing.T) {
+	keyStoreV2, keyStoreV1 := newMigrationTestKeyStores(t)
+	if _, err := NewMigratingKeyStore(keyStoreV2, keyStoreV1, "eager"); err != ErrUnsupportedMigrationMode {
+		t.Fatalf("expected %v, took %v", ErrUnsupportedMigrationMode, err)
+	}
+	for _, mode := range SupportedMigrationModes {
+		if _, err := NewMigratingKeyStore(keyStoreV2, keyStoreV1, mode); err != nil {
+			t.Fatalf("unexpected error for mode %s: %v", mode, err)
+		}
+	}
+}
+
+func TestMigratingKeyStoreDualRead(t *testing.T) {
+	keyStoreV2, keyStoreV1 := newMigrationTestKeyStores(t)
+	clientID := []byte("Tweedledee and Tweedledum")
+	if err := keyStoreV1.GenerateClientIDSymmetricKey(clientID); err != nil {
+		t.Fatalf("GenerateClientIDSymmetricKey() failed: %v", err)
+	}
+	symmetricKeyV1, err := keyStoreV1.GetClientIDSymmetricKey(clientID)
+	if err != nil {
+		t.Fatalf("GetClientIDSymmetricKey() failed: %v", err)
+	}
+	keyStore, err := NewMigratingKeyStore(keyStoreV2, keyStoreV1, MigrationModeDualRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symmetricKey, err := keyStore.GetClientIDSymmetricKey(clientID)
+	if err != nil {
+		t.Fatalf("GetClientIDSymmetricKey() failed: %v", err)
+	}
+	if !bytes.Equal(symmetricKey, symmetricKeyV1) {
+		t.Fatal("expected key from keystore v1")
+	}
+	// dual read mode doesn't change keystore v2
+	if _, err := keyStoreV2.GetClientIDSymmetricKey(clientID); err == nil {
+		t.Fatal("expected missing key in keystore v2")
+	}
+
+	// new keys are generated in keystore v2 and keys of keystore v1 are still used for decryption
+	if err := keyStore.GenerateClientIDSymmetricKey(clientID); err != nil {
+		t.Fatalf("GenerateClientIDSymmetricKey() failed: %v", err)
+	}
+	symmetricKeyV2, err := keyStore.GetClientIDSymmetricKey(clientID)
+	if err != nil {
+		t.Fatalf("GetClientIDSymmetricKey() failed: %v", err)
+	}
+	if bytes.Equal(symmetricKeyV2, symmetricKeyV1) {
+		t.Fatal("expected new key from keystore v2")
+	}
+	symmetricKeys, err := keyStore.GetClientIDSymmetricKeys(clientID)
+	if err != nil {
+		t.Fatalf("GetClientIDSymmetricKeys() failed: %v", err)
+	}
+	if len(symmetricKeys) != 2 || !bytes.Equal(symmetricKeys[0], symmetricKeyV2) || !bytes.Equal(symmetricKeys[1], symmetricKeyV1) {
+		t.Fatal("expected keys of keystore v2 followed by keys of keystore v1")
+	}
+
+	if _, err := keyStore.GetHMACSecretKey(clientID); err == nil {
+		t.Fatal("expected error for key missing in both keystores")
+	}
+}
+
+func TestMigratingKeyStoreLazy(t *testing.T) {
+	keyStoreV2, keyStoreV1 := newMigrationTestKeyStores(t)
+	clientID := []byte("Tweedledee and Tweedledum")
+	if err := keyStoreV1.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatalf("GenerateDataEncryptionKeys() failed: %v", err)
+	}
+	privateKeyV1, err := keyStoreV1.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatalf("GetServerDecryptionPrivateKey() failed: %v", err)
+	}
+	keyStore, err := NewMigratingKeyStore(keyStoreV2, keyStoreV1, MigrationModeLazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyStore.GetServerDecryptionPrivateKey(clientID); err != nil {
+		t.Fatalf("GetServerDecryptionPrivateKey() failed: %v", err)
+	}
+	privateKeyV2, err := keyStoreV2.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatalf("expected migrated key in keystore v2: %v", err)
+	}
+	if !equalPrivateKeys(privateKeyV1, privateKeyV2) {
+		t.Fatal("migrated key corrupted")
+	}
+	// migrated key is returned only once
+	privateKeys, err := keyStore.GetServerDecryptionPrivateKeys(clientID)
+	if err != nil {
+		t.Fatalf("GetServerDecryptionPrivateKeys() failed: %v", err)
+	}
+	if len(privateKeys) != 1 {
+		t.Fatalf("expected 1 key, took %d", len(privateKeys))
+	}
+}
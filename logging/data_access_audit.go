@@ -0,0 +1,146 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Verdicts of queries recorded in data access events
+const (
+	DataAccessVerdictAllowed = "allowed"
+	DataAccessVerdictBlocked = "blocked"
+	DataAccessVerdictFailed  = "failed"
+)
+
+// Keys of fields which number data access events, used by IntegrityCheckVerifier to find missing events
+const (
+	FieldKeyAuditStream   = "audit_stream"
+	FieldKeyAuditSequence = "audit_seq"
+)
+
+// DataAccessMessage is message of data access events
+const DataAccessMessage = "Data access"
+
+// ErrDataAccessCoverageGap returned by IntegrityCheckVerifier when audit log misses data access events
+var ErrDataAccessCoverageGap = errors.New("audit log misses data access events")
+
+// DataAccessEvent describes access to encrypted data by one query
+type DataAccessEvent struct {
+	ClientID []byte
+	// ClientAddress is address of the client, it's taken from PROXY protocol header if it is turned on
+	ClientAddress string
+	Database      string
+	// Tables and Columns are encrypted tables and columns, columns are qualified with table name
+	Tables  []string
+	Columns []string
+	Rows    int
+	Verdict string
+}
+
+// DataAccessAuditLog writes data access events into log. Events are numbered within stream which is unique for each
+// process, so together with integrity checks of audit log chains verifier can find removed events
+type DataAccessAuditLog struct {
+	stream   string
+	lock     sync.Mutex
+	sequence uint64
+}
+
+// NewDataAccessAuditLog returns DataAccessAuditLog with new random stream
+func NewDataAccessAuditLog() (*DataAccessAuditLog, error) {
+	stream := make([]byte, 8)
+	if _, err := rand.Read(stream); err != nil {
+		return nil, err
+	}
+	return &DataAccessAuditLog{stream: hex.EncodeToString(stream)}, nil
+}
+
+// Log writes event with next sequence number
+func (auditLog *DataAccessAuditLog) Log(event *DataAccessEvent) {
+	// lock keeps order of sequence numbers in the log the same as order of events
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+	auditLog.sequence++
+	entry := log.WithFields(log.Fields{
+		FieldKeyEventCode:     EventCodeDataAccess,
+		FieldKeyAuditStream:   auditLog.stream,
+		FieldKeyAuditSequence: auditLog.sequence,
+		"client_id":           string(event.ClientID),
+		"client_address":      event.ClientAddress,
+		"db":                  event.Database,
+		"tables":              strings.Join(event.Tables, ","),
+		"columns":             strings.Join(event.Columns, ","),
+		"rows":                event.Rows,
+		"verdict":             event.Verdict,
+	})
+	// events are not filtered by log level, otherwise verifier reports skipped sequence numbers as missing events
+	level := log.InfoLevel
+	if !log.IsLevelEnabled(level) {
+		level = log.WarnLevel
+	}
+	entry.Log(level, DataAccessMessage)
+}
+
+// Field values are matched after delimiters of all supported formats: plaintext and CEF use key=value, JSON uses "key":value
+var (
+	auditStreamRegexp   = regexp.MustCompile(`(?:^|[\s|{,])"?` + FieldKeyAuditStream + `"?[=:]"?([0-9a-f]+)`)
+	auditSequenceRegexp = regexp.MustCompile(`(?:^|[\s|{,])"?` + FieldKeyAuditSequence + `"?[=:]"?([0-9]+)`)
+)
+
+// parseDataAccessSequence returns stream and sequence number of data access event or false for other log entries
+func parseDataAccessSequence(rawLogEntry string) (string, uint64, bool) {
+	if !strings.Contains(rawLogEntry, DataAccessMessage) {
+		return "", 0, false
+	}
+	stream := auditStreamRegexp.FindStringSubmatch(rawLogEntry)
+	sequence := auditSequenceRegexp.FindStringSubmatch(rawLogEntry)
+	if stream == nil || sequence == nil {
+		return "", 0, false
+	}
+	number, err := strconv.ParseUint(sequence[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return stream[1], number, true
+}
+
+// dataAccessCoverage tracks last sequence number of each stream of data access events
+type dataAccessCoverage map[string]uint64
+
+// check returns ErrDataAccessCoverageGap if sequence number of data access event doesn't follow previous one of its
+// stream. Each stream should start from the first event
+func (coverage dataAccessCoverage) check(rawLogEntry string) error {
+	stream, sequence, ok := parseDataAccessSequence(rawLogEntry)
+	if !ok {
+		return nil
+	}
+	expected := coverage[stream] + 1
+	if sequence != expected {
+		return fmt.Errorf("%w: stream %s expected event %d, took %d", ErrDataAccessCoverageGap, stream, expected, sequence)
+	}
+	coverage[stream] = sequence
+	return nil
+}
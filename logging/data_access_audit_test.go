@@ -0,0 +1,103 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// generateDataAccessLogEntries returns audit log with two chains with two data access events in each
+func generateDataAccessLogEntries(t *testing.T, format string) []string {
+	hooks, err := NewHooks(auditLogKey, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter := CreateCryptoFormatter(format)
+	formatter.SetServiceName("acra-server")
+	formatter.SetHooks(hooks)
+	output := &bytes.Buffer{}
+	auditLogHandler, err := NewAuditLogHandler(formatter, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(auditLogHandler)
+	log.SetFormatter(auditLogHandler)
+
+	auditLog, err := NewDataAccessAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &DataAccessEvent{ClientID: []byte("client"), Database: "postgresql", Tables: []string{"users"},
+		Columns: []string{"users.email", "users.phone"}, Rows: 2, Verdict: DataAccessVerdictAllowed}
+	auditLog.Log(event)
+	log.Infoln("Some message between events")
+	auditLog.Log(event)
+	auditLogHandler.ResetChain(auditLogKey)
+	auditLog.Log(&DataAccessEvent{ClientID: []byte("client"), Database: "postgresql", Verdict: DataAccessVerdictBlocked})
+	auditLog.Log(event)
+	auditLogHandler.FinalizeChain()
+	return strings.Split(strings.TrimSpace(output.String()), "\n")
+}
+
+func TestDataAccessCoverage(t *testing.T) {
+	for _, format := range []string{PlaintextFormatString, JSONFormatString, CefFormatString} {
+		lines := generateDataAccessLogEntries(t, format)
+		events := 0
+		for _, line := range lines {
+			if _, sequence, ok := parseDataAccessSequence(line); ok {
+				events++
+				if sequence != uint64(events) {
+					t.Fatalf("[%s] Expected event %d, took %d", format, events, sequence)
+				}
+			}
+		}
+		if events != 4 {
+			t.Fatalf("[%s] Expected 4 data access events, took %d", format, events)
+		}
+
+		verifier, source, err := prepareTestInput(auditLogKey, format, lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, verifier, source, format)
+
+		// whole chain removed from the log doesn't break integrity of left chains, but events are missing
+		secondChain := 0
+		for i, line := range lines {
+			if strings.Contains(line, EndOfAuditLogChainMessage) {
+				secondChain = i + 1
+				break
+			}
+		}
+		verifier, source, err = prepareTestInput(auditLogKey, format, lines[secondChain:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		logEntry, err := verifier.VerifyIntegrityCheck(source)
+		if !errors.Is(err, ErrDataAccessCoverageGap) {
+			t.Fatalf("[%s] Expected %v, took %v", format, ErrDataAccessCoverageGap, err)
+		}
+		if logEntry == nil || !strings.Contains(logEntry.RawLogEntry, DataAccessMessage) {
+			t.Fatalf("[%s] Expected data access event with error, took %v", format, logEntry)
+		}
+	}
+}
@@ -21,6 +21,25 @@ const (
 	// 100 .. 200 some events
 	EventCodeGeneral                      = 100
 	EventCodePoisonRecordDetectionMessage = 101
+	EventCodePoisonRecordRotateKeysAlert  = 102
+	EventCodeLatencyBudgetExceeded        = 103
+	EventCodeBackendConnectionReaped      = 104
+	EventCodeReplicationConnectionDenied  = 105
+	EventCodeReplicationPassthrough       = 106
+	EventCodeUnsupportedContainerFormat   = 107
+	EventCodeColumnCopy                   = 108
+	EventCodeKeyRotation                  = 109
+	EventCodeKeyReplication               = 110
+	EventCodePlaintextConnectionDenied    = 111
+	EventCodeSessionExpired               = 112
+	EventCodeSessionLimitExceeded         = 113
+	EventCodeDataAccess                   = 114
+	EventCodeQueryThresholdExceeded       = 115
+	EventCodeReIdentification             = 116
+	EventCodePrivacyBudgetExhausted       = 117
+	EventCodeClientIDProvisioned          = 118
+	EventCodeRemoteKeyStoreOperation      = 119
+	EventCodeDifferentialPrivacyRejected  = 120
 
 	// 500 .. 600 errors
 	EventCodeErrorGeneral         = 500
@@ -40,6 +59,9 @@ const (
 	EventCodeErrorCantLoadMasterKey            = 512
 	EventCodeErrorCantInitPrivateKeysEncryptor = 513
 	EventCodeErrorCacheIssues                  = 514
+	EventCodeErrorCantRotateKeys               = 515
+	EventCodeErrorCantReplicateKeys            = 516
+	EventCodeErrorRemoteKeyStoreAccessDenied   = 517
 
 	// system events
 	EventCodeErrorCantGetFileDescriptor     = 520
@@ -121,12 +143,17 @@ const (
 	EventCodeErrorTranslatorCantAcceptNewGRPCConnection         = 715
 	EventCodeErrorTranslatorCantDecryptAcraBlock                = 716
 	EventCodeErrorTranslatorZoneIDAndAdditionalDataNotSupported = 717
+	EventCodeErrorTranslatorAccessReasonMissing                 = 718
+	EventCodeErrorTranslatorReIdentificationDenied              = 719
+	EventCodeErrorTranslatorProvisioningDenied                  = 720
 
 	// tracing
 	EventCodeErrorTracingCantSendTrace    = 800
 	EventCodeErrorTracingCantReadTrace    = 801
 	EventCodeErrorJaegerInvalidParameters = 811
 	EventCodeErrorJaegerExporter          = 812
+	EventCodeErrorOTLPInvalidParameters   = 813
+	EventCodeErrorOTLPExporter            = 814
 
 	// encryptor
 	EventCodeErrorEncryptQueryData               = 900
@@ -161,4 +188,6 @@ const (
 	EventCodeErrorNetworkWrite      = 1300
 	EventCodeErrorNetworkFlush      = 1301
 	EventCodeErrorNetworkTLSGeneral = 1302
+	// client's certificate doesn't match pins of its clientID
+	EventCodeErrorNetworkTLSCertificatePinMismatch = 1303
 )
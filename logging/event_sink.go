@@ -0,0 +1,251 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/syslog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of event sinks
+const (
+	EventSinkTypeSyslog = "syslog"
+	EventSinkTypeKafka  = "kafka"
+)
+
+// EventSinkTypeList list of all supported event sinks
+var EventSinkTypeList = []string{EventSinkTypeSyslog, EventSinkTypeKafka}
+
+// Errors returned on configuration of event sinks
+var (
+	ErrUnknownEventSinkType   = errors.New("unknown type of event sink")
+	ErrUnknownEventSinkFormat = errors.New("unknown format of event sink, should be cef or leef")
+	ErrInvalidEventSinkCode   = errors.New("invalid event code of event sink")
+)
+
+// SecurityEventCodes are codes of events exported by event sinks by default: AcraCensor blocks, poison record
+// detections, key operations, denied connections and data access
+var SecurityEventCodes = []int{
+	EventCodePoisonRecordDetectionMessage,
+	EventCodePoisonRecordRotateKeysAlert,
+	EventCodeKeyRotation,
+	EventCodeKeyReplication,
+	EventCodePlaintextConnectionDenied,
+	EventCodeSessionLimitExceeded,
+	EventCodeDataAccess,
+	EventCodeErrorCensorQueryIsNotAllowed,
+	EventCodeErrorDecryptorRecognizedPoisonRecord,
+	EventCodeErrorNetworkTLSCertificatePinMismatch,
+}
+
+// eventSinkQueueSize limits count of events waiting for slow sink
+const eventSinkQueueSize = 1024
+
+// eventSinkTimeout limits time of network operations of sinks
+const eventSinkTimeout = 5 * time.Second
+
+// EventSink writes formatted events into external system
+type EventSink interface {
+	WriteEvent(event []byte) error
+	Close() error
+}
+
+// SyslogEventSink is EventSink which writes events to syslog with auth facility
+type SyslogEventSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogEventSink returns SyslogEventSink connected to syslog daemon by address, or to local one if network is empty
+func NewSyslogEventSink(network, address, tag string) (*SyslogEventSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogEventSink{writer: writer}, nil
+}
+
+// WriteEvent writes event as one syslog message
+func (sink *SyslogEventSink) WriteEvent(event []byte) error {
+	_, err := sink.writer.Write(event)
+	return err
+}
+
+// Close closes connection to syslog daemon
+func (sink *SyslogEventSink) Close() error {
+	return sink.writer.Close()
+}
+
+// EventSinkHook is logrus hook which exports entries with selected event codes into EventSink. Entries are formatted
+// on logging and queued for Run, so slow sink doesn't block logging. Entries are dropped if queue is full
+type EventSinkHook struct {
+	sink      EventSink
+	formatter Formatter
+	codes     map[int]bool
+	queue     chan []byte
+	dropped   uint64
+}
+
+// NewEventSinkHook returns EventSinkHook which exports entries with codes formatted by formatter into sink
+func NewEventSinkHook(sink EventSink, formatter Formatter, codes []int) *EventSinkHook {
+	hook := &EventSinkHook{sink: sink, formatter: formatter, codes: make(map[int]bool, len(codes)),
+		queue: make(chan []byte, eventSinkQueueSize)}
+	for _, code := range codes {
+		hook.codes[code] = true
+	}
+	return hook
+}
+
+// Levels returns all levels, entries are filtered by event codes
+func (hook *EventSinkHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire queues entry with selected event code. It is called under lock of logger, so it doesn't log anything
+func (hook *EventSinkHook) Fire(entry *log.Entry) error {
+	code, ok := entry.Data[FieldKeyEventCode].(int)
+	if !ok || !hook.codes[code] {
+		return nil
+	}
+	event, err := hook.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	select {
+	case hook.queue <- bytes.TrimRight(event, "\n"):
+	default:
+		atomic.AddUint64(&hook.dropped, 1)
+	}
+	return nil
+}
+
+// Run writes queued events into sink until ctx is done, then writes left events and closes sink. Should be called as
+// goroutine
+func (hook *EventSinkHook) Run(ctx context.Context) {
+	defer func() {
+		if err := hook.sink.Close(); err != nil {
+			log.WithError(err).Warningln("Can't close event sink")
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-hook.queue:
+					hook.write(event)
+				default:
+					return
+				}
+			}
+		case event := <-hook.queue:
+			hook.write(event)
+		}
+	}
+}
+
+func (hook *EventSinkHook) write(event []byte) {
+	if err := hook.sink.WriteEvent(event); err != nil {
+		log.WithError(err).Warningln("Can't write event into event sink, event dropped")
+		return
+	}
+	if dropped := atomic.SwapUint64(&hook.dropped, 0); dropped > 0 {
+		log.WithField("dropped", dropped).Warningln("Event sink queue was full, events dropped")
+	}
+}
+
+var (
+	eventSinkType          string
+	eventSinkFormat        string
+	eventSinkCodes         string
+	eventSinkSyslogNetwork string
+	eventSinkSyslogAddress string
+	eventSinkKafkaBrokers  string
+	eventSinkKafkaTopic    string
+)
+
+// RegisterEventSinkCLIArgs registers CLI args used to configure export of security events
+func RegisterEventSinkCLIArgs(flags *flag.FlagSet) {
+	codes := make([]string, len(SecurityEventCodes))
+	for i, code := range SecurityEventCodes {
+		codes[i] = strconv.Itoa(code)
+	}
+	flags.StringVar(&eventSinkType, "event_sink_type", "", fmt.Sprintf("Export security events to external system: <%s>. Empty value disables export", strings.Join(EventSinkTypeList, "|")))
+	flags.StringVar(&eventSinkFormat, "event_sink_format", CefFormatString, fmt.Sprintf("Format of exported events: <%s|%s>", CefFormatString, LeefFormatString))
+	flags.StringVar(&eventSinkCodes, "event_sink_event_codes", strings.Join(codes, ","), "Comma separated codes of exported events, only events passed by log level are exported")
+	flags.StringVar(&eventSinkSyslogNetwork, "event_sink_syslog_network", "", "Network of syslog daemon: <udp|tcp|unix>. Empty value uses local syslog daemon")
+	flags.StringVar(&eventSinkSyslogAddress, "event_sink_syslog_address", "", "Address of syslog daemon, e.g. 'localhost:514'")
+	flags.StringVar(&eventSinkKafkaBrokers, "event_sink_kafka_brokers", "", "Comma separated addresses of kafka brokers used to find leader of topic partition, e.g. 'kafka1:9092,kafka2:9092'")
+	flags.StringVar(&eventSinkKafkaTopic, "event_sink_kafka_topic", "acra-events", "Kafka topic of exported events, all events are written into its first partition to keep order")
+}
+
+// NewEventSinkHookFromCLI returns EventSinkHook configured by CLI args or nil if export is disabled
+func NewEventSinkHookFromCLI(serviceName string) (*EventSinkHook, error) {
+	if eventSinkType == "" {
+		return nil, nil
+	}
+	var formatter Formatter
+	switch strings.ToLower(eventSinkFormat) {
+	case CefFormatString:
+		formatter = CEFFormatter()
+	case LeefFormatString:
+		formatter = LEEFFormatter()
+	default:
+		return nil, ErrUnknownEventSinkFormat
+	}
+	formatter.SetServiceName(serviceName)
+	var codes []int
+	for _, value := range strings.Split(eventSinkCodes, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEventSinkCode, value)
+		}
+		codes = append(codes, code)
+	}
+	var sink EventSink
+	var err error
+	switch eventSinkType {
+	case EventSinkTypeSyslog:
+		sink, err = NewSyslogEventSink(eventSinkSyslogNetwork, eventSinkSyslogAddress, serviceName)
+	case EventSinkTypeKafka:
+		var brokers []string
+		for _, broker := range strings.Split(eventSinkKafkaBrokers, ",") {
+			if broker = strings.TrimSpace(broker); broker != "" {
+				brokers = append(brokers, broker)
+			}
+		}
+		sink, err = NewKafkaEventSink(brokers, eventSinkKafkaTopic, eventSinkTimeout)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventSinkType, eventSinkType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewEventSinkHook(sink, formatter, codes), nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+type testEventSink struct {
+	events [][]byte
+	closed bool
+}
+
+func (sink *testEventSink) WriteEvent(event []byte) error {
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+func (sink *testEventSink) Close() error {
+	sink.closed = true
+	return nil
+}
+
+func TestEventSinkHook(t *testing.T) {
+	for _, format := range []string{CefFormatString, LeefFormatString} {
+		formatter := CEFFormatter()
+		prefix := "CEF:0|cossacklabs|acra-server|" + utils.VERSION + "|560|Query blocked|6|"
+		if format == LeefFormatString {
+			formatter = LEEFFormatter()
+			prefix = "LEEF:1.0|cossacklabs|acra-server|" + utils.VERSION + "|560|devTime="
+		}
+		formatter.SetServiceName("acra-server")
+		sink := &testEventSink{}
+		hook := NewEventSinkHook(sink, formatter, []int{EventCodeErrorCensorQueryIsNotAllowed})
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		logger.AddHook(hook)
+		logger.WithField(FieldKeyEventCode, EventCodeErrorCensorQueryIsNotAllowed).WithField("client_id", "client").Errorln("Query blocked")
+		logger.WithField(FieldKeyEventCode, EventCodeErrorGeneral).Errorln("Not exported")
+		logger.Errorln("Without code")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		hook.Run(ctx)
+		if !sink.closed {
+			t.Fatalf("[%s] Sink wasn't closed", format)
+		}
+		if len(sink.events) != 1 {
+			t.Fatalf("[%s] Expected 1 event, took %d", format, len(sink.events))
+		}
+		event := string(sink.events[0])
+		if !strings.HasPrefix(event, prefix) || !strings.Contains(event, "client_id=client") || strings.HasSuffix(event, "\n") {
+			t.Fatalf("[%s] Unexpected event: %q", format, event)
+		}
+		if format == LeefFormatString && !strings.Contains(event, "\tmsg=Query blocked\t") {
+			t.Fatalf("[%s] Unexpected attributes: %q", format, event)
+		}
+	}
+}
+
+// testKafkaBroker serves Metadata requests with itself as leader of partition and sends values of produced records
+// into channel
+func testKafkaBroker(t *testing.T, topic string, values chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					var size int32
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					data := make([]byte, size)
+					if _, err := io.ReadFull(conn, data); err != nil {
+						return
+					}
+					request := &kafkaReader{data: data}
+					apiKey, _, correlationID, _ := request.int16(), request.int16(), request.int32(), request.string()
+					response := &kafkaWriter{}
+					response.int32(correlationID)
+					switch apiKey {
+					case kafkaAPIKeyMetadata:
+						response.int32(0)
+						response.int32(1)
+						response.int32(1)
+						response.string(host)
+						response.int32(int32(portNumber))
+						response.int16(-1)
+						response.int16(-1)
+						response.int32(1)
+						response.int32(1)
+						response.int16(0)
+						response.string(topic)
+						response.int8(0)
+						response.int32(1)
+						response.int16(0)
+						response.int32(kafkaPartition)
+						response.int32(1)
+						response.int32(0)
+						response.int32(0)
+					case kafkaAPIKeyProduce:
+						request.string()
+						request.int16()
+						request.int32()
+						request.int32()
+						request.string()
+						request.int32()
+						request.int32()
+						values <- decodeTestRecordBatch(t, request.bytes())
+						response.int32(1)
+						response.string(topic)
+						response.int32(1)
+						response.int32(kafkaPartition)
+						response.int16(0)
+						response.int64(0)
+						response.int64(-1)
+						response.int32(0)
+					}
+					output := &kafkaWriter{}
+					output.bytes(response.Bytes())
+					if _, err := conn.Write(output.Bytes()); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// decodeTestRecordBatch checks CRC of record batch and returns value of its single record
+func decodeTestRecordBatch(t *testing.T, batch []byte) string {
+	reader := &kafkaReader{data: batch}
+	reader.int64()
+	length := reader.int32()
+	reader.int32()
+	if magic := reader.int8(); magic != kafkaRecordBatchMagic {
+		t.Errorf("Unexpected magic %d", magic)
+	}
+	crc := uint32(reader.int32())
+	if reader.err != nil || int(length) != 4+1+4+len(reader.data) {
+		t.Errorf("Invalid length of record batch")
+		return ""
+	}
+	if crc32.Checksum(reader.data, kafkaCRCTable) != crc {
+		t.Errorf("Invalid CRC of record batch")
+	}
+	// attributes, lastOffsetDelta, timestamps, producer fields and count of records
+	reader.next(2 + 4 + 8 + 8 + 8 + 2 + 4 + 4)
+	records := reader.data
+	if _, n := binary.Varint(records); n > 0 {
+		records = records[n:]
+	}
+	// attributes, timestampDelta, offsetDelta
+	records = records[1:]
+	for _, field := range []string{"timestampDelta", "offsetDelta", "key"} {
+		value, n := binary.Varint(records)
+		if field == "key" && value != -1 {
+			t.Errorf("Expected null key, took length %d", value)
+		}
+		records = records[n:]
+	}
+	valueLength, n := binary.Varint(records)
+	records = records[n:]
+	return string(records[:valueLength])
+}
+
+func TestKafkaEventSink(t *testing.T) {
+	values := make(chan string, 2)
+	broker := testKafkaBroker(t, "acra-events", values)
+	sink, err := NewKafkaEventSink([]string{"127.0.0.1:1", broker}, "acra-events", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for _, event := range []string{"CEF:0|first", "CEF:0|second"} {
+		if err := sink.WriteEvent([]byte(event)); err != nil {
+			t.Fatal(err)
+		}
+		if value := <-values; value != event {
+			t.Fatalf("Expected %s, took %s", event, value)
+		}
+	}
+	if _, err := NewKafkaEventSink(nil, "acra-events", time.Second); err != ErrKafkaNoBrokers {
+		t.Fatalf("Expected %v, took %v", ErrKafkaNoBrokers, err)
+	}
+}
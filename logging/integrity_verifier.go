@@ -29,6 +29,7 @@ type IntegrityCheckVerifier struct {
 	integrityCalculator *LogEntryIntegrityCalculator
 	parser              LogParser
 	lastVerifiedEntry   *ParsedLogEntry
+	dataAccessCoverage  dataAccessCoverage
 }
 
 // NewIntegrityCheckVerifier return new IntegrityCheckVerifier with configure secret key and parser
@@ -37,6 +38,7 @@ func NewIntegrityCheckVerifier(key []byte, parser LogParser) (*IntegrityCheckVer
 		cryptoKey:           key,
 		integrityCalculator: NewLogEntryIntegrityCalculator(key),
 		parser:              parser,
+		dataAccessCoverage:  dataAccessCoverage{},
 	}, nil
 }
 
@@ -55,7 +57,8 @@ type LogEntrySource struct {
 }
 
 // VerifyIntegrityCheck verify all lines incoming to channel (which is a part of input log entry source)
-// until it will be closed and return (nil, nil) if fully verified, otherwise return current log entry with error occurred
+// until it will be closed and return (nil, nil) if fully verified, otherwise return current log entry with error occurred.
+// Returns ErrDataAccessCoverageGap if data access events are missing between verified entries
 func (v *IntegrityCheckVerifier) VerifyIntegrityCheck(source *LogEntrySource) (*LogEntryInfo, error) {
 	for logEntry := range source.Entries {
 		if logEntry == nil {
@@ -90,6 +93,10 @@ func (v *IntegrityCheckVerifier) VerifyIntegrityCheck(source *LogEntrySource) (*
 		if subtle.ConstantTimeCompare(parsedLogEntry.Integrity, calculated) == 0 {
 			return logEntry, ErrIntegrityNotMatch
 		}
+		// data access events may be removed with whole chains, so their numbering is checked across chains
+		if err := v.dataAccessCoverage.check(logEntry.RawLogEntry); err != nil {
+			return logEntry, err
+		}
 		// save current entry
 		v.lastVerifiedEntry = parsedLogEntry
 	}
@@ -0,0 +1,407 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Kafka protocol is implemented only as much as needed to produce events into one partition of topic:
+// https://kafka.apache.org/protocol.html
+const (
+	kafkaAPIKeyProduce   int16 = 0
+	kafkaAPIKeyMetadata  int16 = 3
+	kafkaProduceVersion  int16 = 3
+	kafkaMetadataVersion int16 = 4
+	kafkaClientID              = "acra"
+	// kafkaPartition is the only partition used by sink, so events keep order
+	kafkaPartition int32 = 0
+	// kafkaAcksLeader waits for acknowledgement only from leader of partition
+	kafkaAcksLeader       int16 = 1
+	kafkaRecordBatchMagic int8  = 2
+	kafkaMaxResponseSize        = 16 * 1024 * 1024
+)
+
+// Errors returned by KafkaEventSink
+var (
+	ErrKafkaNoBrokers         = errors.New("kafka brokers are not specified")
+	ErrKafkaNoTopic           = errors.New("kafka topic is not specified")
+	ErrKafkaNoLeader          = errors.New("kafka partition doesn't have leader")
+	ErrKafkaMalformedResponse = errors.New("malformed response from kafka broker")
+)
+
+// KafkaError is error code returned by kafka broker
+type KafkaError int16
+
+func (e KafkaError) Error() string {
+	return fmt.Sprintf("kafka broker returned error code %d", int16(e))
+}
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaEventSink is EventSink which produces events into the first partition of kafka topic
+type KafkaEventSink struct {
+	brokers       []string
+	topic         string
+	timeout       time.Duration
+	conn          net.Conn
+	correlationID int32
+}
+
+// NewKafkaEventSink returns KafkaEventSink which finds leader of partition using one of brokers. Connection is
+// established on the first event and re-established after errors
+func NewKafkaEventSink(brokers []string, topic string, timeout time.Duration) (*KafkaEventSink, error) {
+	if len(brokers) == 0 {
+		return nil, ErrKafkaNoBrokers
+	}
+	if topic == "" {
+		return nil, ErrKafkaNoTopic
+	}
+	return &KafkaEventSink{brokers: brokers, topic: topic, timeout: timeout}, nil
+}
+
+// WriteEvent produces event and waits for acknowledgement from leader of partition
+func (sink *KafkaEventSink) WriteEvent(event []byte) error {
+	if sink.conn == nil {
+		if err := sink.connectToLeader(); err != nil {
+			return err
+		}
+	}
+	if err := sink.produce(event); err != nil {
+		sink.conn.Close()
+		sink.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes connection to the broker
+func (sink *KafkaEventSink) Close() error {
+	if sink.conn == nil {
+		return nil
+	}
+	err := sink.conn.Close()
+	sink.conn = nil
+	return err
+}
+
+// connectToLeader connects to leader of partition found by the first available broker
+func (sink *KafkaEventSink) connectToLeader() error {
+	var lastErr error
+	for _, broker := range sink.brokers {
+		leader, err := sink.findLeader(broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", leader, sink.timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sink.conn = conn
+		return nil
+	}
+	return lastErr
+}
+
+// findLeader requests metadata of topic from broker and returns address of leader of partition
+func (sink *KafkaEventSink) findLeader(broker string) (string, error) {
+	conn, err := net.DialTimeout("tcp", broker, sink.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	request := &kafkaWriter{}
+	request.int32(1)
+	request.string(sink.topic)
+	// allow_auto_topic_creation
+	request.int8(1)
+	response, err := sink.roundTrip(conn, kafkaAPIKeyMetadata, kafkaMetadataVersion, request.Bytes())
+	if err != nil {
+		return "", err
+	}
+	reader := &kafkaReader{data: response}
+	// throttle_time_ms
+	reader.int32()
+	brokers := make(map[int32]string)
+	for i := reader.int32(); i > 0 && reader.err == nil; i-- {
+		nodeID := reader.int32()
+		host := reader.string()
+		port := reader.int32()
+		// rack
+		reader.string()
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	// cluster_id and controller_id
+	reader.string()
+	reader.int32()
+	leader, topicError, partitionError := int32(-1), int16(0), int16(0)
+	for i := reader.int32(); i > 0 && reader.err == nil; i-- {
+		errorCode := reader.int16()
+		name := reader.string()
+		// is_internal
+		reader.int8()
+		if name == sink.topic {
+			topicError = errorCode
+		}
+		for j := reader.int32(); j > 0 && reader.err == nil; j-- {
+			partitionErrorCode := reader.int16()
+			index := reader.int32()
+			leaderID := reader.int32()
+			// replica_nodes and isr_nodes
+			reader.skipInt32Array()
+			reader.skipInt32Array()
+			if name == sink.topic && index == kafkaPartition {
+				leader, partitionError = leaderID, partitionErrorCode
+			}
+		}
+	}
+	if reader.err != nil {
+		return "", ErrKafkaMalformedResponse
+	}
+	if topicError != 0 {
+		return "", KafkaError(topicError)
+	}
+	if partitionError != 0 {
+		return "", KafkaError(partitionError)
+	}
+	address, ok := brokers[leader]
+	if !ok {
+		return "", ErrKafkaNoLeader
+	}
+	return address, nil
+}
+
+// produce sends Produce request with event to leader of partition
+func (sink *KafkaEventSink) produce(event []byte) error {
+	request := &kafkaWriter{}
+	// null transactional_id
+	request.int16(-1)
+	request.int16(kafkaAcksLeader)
+	request.int32(int32(sink.timeout / time.Millisecond))
+	request.int32(1)
+	request.string(sink.topic)
+	request.int32(1)
+	request.int32(kafkaPartition)
+	request.bytes(encodeKafkaRecordBatch(event, time.Now()))
+	response, err := sink.roundTrip(sink.conn, kafkaAPIKeyProduce, kafkaProduceVersion, request.Bytes())
+	if err != nil {
+		return err
+	}
+	reader := &kafkaReader{data: response}
+	for i := reader.int32(); i > 0 && reader.err == nil; i-- {
+		reader.string()
+		for j := reader.int32(); j > 0 && reader.err == nil; j-- {
+			// partition index, error_code, base_offset and log_append_time_ms
+			reader.int32()
+			errorCode := reader.int16()
+			reader.int64()
+			reader.int64()
+			if errorCode != 0 && reader.err == nil {
+				return KafkaError(errorCode)
+			}
+		}
+	}
+	if reader.err != nil {
+		return ErrKafkaMalformedResponse
+	}
+	return nil
+}
+
+// roundTrip sends request with header and returns body of response
+func (sink *KafkaEventSink) roundTrip(conn net.Conn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	if err := conn.SetDeadline(time.Now().Add(sink.timeout)); err != nil {
+		return nil, err
+	}
+	sink.correlationID++
+	header := &kafkaWriter{}
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(sink.correlationID)
+	header.string(kafkaClientID)
+	request := &kafkaWriter{}
+	request.bytes(append(header.Bytes(), body...))
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, ErrKafkaMalformedResponse
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	if int32(binary.BigEndian.Uint32(response)) != sink.correlationID {
+		return nil, ErrKafkaMalformedResponse
+	}
+	return response[4:], nil
+}
+
+// encodeKafkaRecordBatch returns record batch of message format v2 with one record without key
+func encodeKafkaRecordBatch(value []byte, timestamp time.Time) []byte {
+	record := []byte{0}
+	// timestampDelta, offsetDelta and null key
+	record = binary.AppendVarint(record, 0)
+	record = binary.AppendVarint(record, 0)
+	record = binary.AppendVarint(record, -1)
+	record = binary.AppendVarint(record, int64(len(value)))
+	record = append(record, value...)
+	// no headers
+	record = binary.AppendVarint(record, 0)
+
+	// part of batch protected by CRC
+	batch := &kafkaWriter{}
+	// attributes, lastOffsetDelta
+	batch.int16(0)
+	batch.int32(0)
+	// baseTimestamp and maxTimestamp
+	batch.int64(timestamp.UnixMilli())
+	batch.int64(timestamp.UnixMilli())
+	// producerId, producerEpoch and baseSequence without idempotence
+	batch.int64(-1)
+	batch.int16(-1)
+	batch.int32(-1)
+	batch.int32(1)
+	batch.Write(binary.AppendVarint(nil, int64(len(record))))
+	batch.Write(record)
+
+	output := &kafkaWriter{}
+	// baseOffset is assigned by broker
+	output.int64(0)
+	// batchLength counts partitionLeaderEpoch, magic and crc
+	output.int32(int32(4 + 1 + 4 + batch.Len()))
+	output.int32(-1)
+	output.int8(kafkaRecordBatchMagic)
+	output.int32(int32(crc32.Checksum(batch.Bytes(), kafkaCRCTable)))
+	output.Write(batch.Bytes())
+	return output.Bytes()
+}
+
+// kafkaWriter encodes primitive types of kafka protocol
+type kafkaWriter struct {
+	bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(value int8) {
+	w.WriteByte(byte(value))
+}
+
+func (w *kafkaWriter) int16(value int16) {
+	binary.Write(&w.Buffer, binary.BigEndian, value)
+}
+
+func (w *kafkaWriter) int32(value int32) {
+	binary.Write(&w.Buffer, binary.BigEndian, value)
+}
+
+func (w *kafkaWriter) int64(value int64) {
+	binary.Write(&w.Buffer, binary.BigEndian, value)
+}
+
+func (w *kafkaWriter) string(value string) {
+	w.int16(int16(len(value)))
+	w.WriteString(value)
+}
+
+func (w *kafkaWriter) bytes(value []byte) {
+	w.int32(int32(len(value)))
+	w.Write(value)
+}
+
+// kafkaReader decodes primitive types of kafka protocol, the first error is saved and next reads return zero values
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+func (r *kafkaReader) next(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if size < 0 || len(r.data) < size {
+		r.err = ErrKafkaMalformedResponse
+		return nil
+	}
+	value := r.data[:size]
+	r.data = r.data[size:]
+	return value
+}
+
+func (r *kafkaReader) int8() int8 {
+	if value := r.next(1); value != nil {
+		return int8(value[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if value := r.next(2); value != nil {
+		return int16(binary.BigEndian.Uint16(value))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if value := r.next(4); value != nil {
+		return int32(binary.BigEndian.Uint32(value))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if value := r.next(8); value != nil {
+		return int64(binary.BigEndian.Uint64(value))
+	}
+	return 0
+}
+
+// string reads nullable string, null is returned as empty string
+func (r *kafkaReader) string() string {
+	length := r.int16()
+	if length < 0 {
+		return ""
+	}
+	return string(r.next(int(length)))
+}
+
+func (r *kafkaReader) bytes() []byte {
+	length := r.int32()
+	if length < 0 {
+		return nil
+	}
+	return r.next(int(length))
+}
+
+func (r *kafkaReader) skipInt32Array() {
+	length := r.int32()
+	if length > 0 {
+		r.next(int(length) * 4)
+	}
+}
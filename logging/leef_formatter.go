@@ -0,0 +1,98 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Compatible with LEEF 1.0 used by IBM QRadar:
+// LEEF:1.0|Vendor|Product|Version|EventID|key=value<tab>key=value
+
+const defaultLEEFLogStart = "LEEF:1.0"
+
+// leefTimeLayout is layout of devTime attribute, described for receiver by devTimeFormat attribute
+const (
+	leefTimeLayout = "Jan 02 2006 15:04:05.000 MST"
+	leefTimeFormat = "MMM dd yyyy HH:mm:ss.SSS z"
+)
+
+// LEEFTextFormatter formats logs into LEEF text
+type LEEFTextFormatter struct{}
+
+// Format renders a single log entry
+func (f *LEEFTextFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString(defaultLEEFLogStart)
+
+	// |Vendor|Product|Version|EventID|
+	for _, key := range []string{FieldKeyVendor, FieldKeyProduct, FieldKeyVersion, FieldKeyEventCode} {
+		b.WriteString(defaultMessageDivider)
+		b.WriteString(prepareLEEFHeader(fmt.Sprint(entry.Data[key])))
+	}
+	b.WriteString(defaultMessageDivider)
+
+	// Attributes, predefined ones go first
+	f.appendAttribute(b, "devTime", entry.Time.Format(leefTimeLayout))
+	f.appendAttribute(b, "devTimeFormat", leefTimeFormat)
+	f.appendAttribute(b, "sev", severityByLevel(entry.Level))
+	f.appendAttribute(b, "msg", entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		if key != FieldKeyVendor && key != FieldKeyProduct && key != FieldKeyVersion && key != FieldKeyEventCode {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.appendAttribute(b, key, entry.Data[key])
+	}
+	// remove delimiter after the last attribute
+	b.Truncate(b.Len() - 1)
+
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+func (f *LEEFTextFormatter) appendAttribute(b *bytes.Buffer, key string, value interface{}) {
+	stringVal, ok := value.(string)
+	if !ok {
+		stringVal = fmt.Sprint(value)
+	}
+	b.WriteString(prepareLEEFAttribute(key))
+	b.WriteByte('=')
+	b.WriteString(prepareLEEFAttribute(stringVal))
+	b.WriteByte('\t')
+}
+
+// prepareLEEFHeader escapes delimiter of header fields
+func prepareLEEFHeader(value string) string {
+	value = prepareLEEFAttribute(value)
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return strings.Replace(value, "|", `\|`, -1)
+}
+
+// prepareLEEFAttribute replaces tabs, used as delimiter of attributes, and line breaks with spaces
+func prepareLEEFAttribute(value string) string {
+	value = strings.TrimSpace(value)
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
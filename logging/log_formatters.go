@@ -66,6 +66,14 @@ func CEFFormatter() Formatter {
 	}
 }
 
+// LEEFFormatter returns a default LEEFTextFormatter with extra fields of CEF
+func LEEFFormatter() Formatter {
+	return &AcraLEEFFormatter{
+		Fields: nil,
+		Hooks:  nil,
+	}
+}
+
 // ---------------------------
 
 // Using a pool to re-use of old entries when formatting messages.
@@ -193,6 +201,41 @@ func (f *AcraCEFFormatter) GetHooks() []FormatterHook {
 	return f.Hooks
 }
 
+// AcraLEEFFormatter is based on LEEFTextFormatter with the same extra logrus fields as AcraCEFFormatter.
+//
+// Hooks may be used for more fine-tuned post-processing of entries.
+type AcraLEEFFormatter struct {
+	LEEFTextFormatter
+	logrus.Fields
+	Hooks []FormatterHook
+}
+
+// SetServiceName set service name
+func (f *AcraLEEFFormatter) SetServiceName(serviceName string) {
+	fields := log.Fields{FieldKeyProduct: serviceName}
+	for k, v := range extraJSONFields {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	for k, v := range extraCEFFields {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	f.Fields = fields
+}
+
+// SetHooks set formatter hooks
+func (f *AcraLEEFFormatter) SetHooks(hooks []FormatterHook) {
+	f.Hooks = hooks
+}
+
+// GetHooks get formatter hooks
+func (f *AcraLEEFFormatter) GetHooks() []FormatterHook {
+	return f.Hooks
+}
+
 // Constants showing extra filed added to loggers by default
 var (
 	// to be re-defined
@@ -246,6 +289,19 @@ func (f *AcraCEFFormatter) Format(e *logrus.Entry) ([]byte, error) {
 	return dataBytes, err
 }
 
+// Format formats an entry to a AcraLEEF format according to the given Formatter and Fields.
+//
+// Note: the given entry is copied and not changed during the formatting process.
+func (f *AcraLEEFFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	ne := copyEntry(e, f.Fields)
+	if value, ok := ne.Data[FieldKeyUnixTime]; !ok || value == 0 {
+		ne.Data[FieldKeyUnixTime] = unixTimeWithMilliseconds(e)
+	}
+	dataBytes, err := formatEntry(ne, &f.LEEFTextFormatter, f.Hooks)
+	releaseEntry(ne)
+	return dataBytes, err
+}
+
 // TimeToString return string representation of timestamp with milliseconds
 func TimeToString(t time.Time) string {
 	return nanosecondsToMillisecondsString(t.UnixNano())
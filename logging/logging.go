@@ -49,6 +49,7 @@ const (
 	PlaintextFormatString = "plaintext"
 	JSONFormatString      = "json"
 	CefFormatString       = "cef"
+	LeefFormatString      = "leef"
 )
 
 // LoggerSetter abstract types that provide way to set logger which they should use
@@ -0,0 +1,445 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// Protocols of OTLP exporter
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// OTLPProtocolList list of all supported protocols of OTLP exporter
+var OTLPProtocolList = []string{OTLPProtocolGRPC, OTLPProtocolHTTP}
+
+// Errors returned on configuration of OTLP exporter
+var (
+	ErrUnknownOTLPProtocol = errors.New("unknown OTLP protocol")
+	ErrInvalidOTLPEndpoint = errors.New("OTLP endpoint should be URL with http or https scheme")
+)
+
+const (
+	otlpGRPCExportMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+	otlpHTTPTracesPath   = "/v1/traces"
+	otlpHTTPContentType  = "application/x-protobuf"
+	// otlpQueueSize limits count of spans waiting for export
+	otlpQueueSize = 2048
+	// otlpBatchSize is max count of spans in one export request
+	otlpBatchSize = 512
+	// otlpBatchInterval is max time of span waiting in batch
+	otlpBatchInterval = 5 * time.Second
+)
+
+// OTLPOptions configures OTLPSpanExporter
+type OTLPOptions struct {
+	// Endpoint is URL of collector, scheme defines whether TLS is used. Path "/v1/traces" is used by http/protobuf
+	// protocol if URL doesn't contain path
+	Endpoint string
+	Protocol string
+	// Headers are sent with each export request, e.g. for authentication
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+// otlpClient sends encoded ExportTraceServiceRequest to collector
+type otlpClient interface {
+	Export(ctx context.Context, request []byte) error
+}
+
+// OTLPSpanExporter is opencensus exporter which sends spans in batches to OpenTelemetry collector using OTLP
+type OTLPSpanExporter struct {
+	serviceName string
+	client      otlpClient
+	timeout     time.Duration
+	spans       chan *trace.SpanData
+	flushes     chan chan struct{}
+	dropped     uint64
+}
+
+// NewOTLPSpanExporter returns OTLPSpanExporter which exports spans of service in background
+func NewOTLPSpanExporter(serviceName string, options OTLPOptions) (*OTLPSpanExporter, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, ErrInvalidOTLPEndpoint
+	}
+	var client otlpClient
+	switch options.Protocol {
+	case OTLPProtocolGRPC:
+		client, err = newOTLPGRPCClient(endpoint, options.Headers)
+	case OTLPProtocolHTTP:
+		client = newOTLPHTTPClient(endpoint, options.Headers)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOTLPProtocol, options.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	exporter := &OTLPSpanExporter{serviceName: serviceName, client: client, timeout: options.Timeout,
+		spans: make(chan *trace.SpanData, otlpQueueSize), flushes: make(chan chan struct{})}
+	go exporter.run()
+	return exporter, nil
+}
+
+// ExportSpan queues span for export, span is dropped if queue is full
+func (exporter *OTLPSpanExporter) ExportSpan(span *trace.SpanData) {
+	select {
+	case exporter.spans <- span:
+	default:
+		atomic.AddUint64(&exporter.dropped, 1)
+	}
+}
+
+// Flush exports queued spans and waits for the end of export
+func (exporter *OTLPSpanExporter) Flush() {
+	done := make(chan struct{})
+	exporter.flushes <- done
+	<-done
+}
+
+func (exporter *OTLPSpanExporter) run() {
+	ticker := time.NewTicker(otlpBatchInterval)
+	defer ticker.Stop()
+	batch := make([]*trace.SpanData, 0, otlpBatchSize)
+	for {
+		select {
+		case span := <-exporter.spans:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case done := <-exporter.flushes:
+			for len(exporter.spans) > 0 {
+				batch = append(batch, <-exporter.spans)
+			}
+			exporter.export(batch)
+			batch = batch[:0]
+			close(done)
+			continue
+		}
+		exporter.export(batch)
+		batch = batch[:0]
+	}
+}
+
+func (exporter *OTLPSpanExporter) export(spans []*trace.SpanData) {
+	if dropped := atomic.SwapUint64(&exporter.dropped, 0); dropped > 0 {
+		log.WithField(FieldKeyEventCode, EventCodeErrorTracingCantSendTrace).WithField("dropped", dropped).
+			Warningln("OTLP export queue was full, spans dropped")
+	}
+	for len(spans) > 0 {
+		size := len(spans)
+		if size > otlpBatchSize {
+			size = otlpBatchSize
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exporter.timeout)
+		err := exporter.client.Export(ctx, encodeOTLPTraceRequest(exporter.serviceName, spans[:size]))
+		cancel()
+		if err != nil {
+			log.WithError(err).WithField(FieldKeyEventCode, EventCodeErrorTracingCantSendTrace).WithField("dropped", size).
+				Warningln("Can't export spans to OTLP collector")
+		}
+		spans = spans[size:]
+	}
+}
+
+// otlpGRPCClient calls Export method of TraceService
+type otlpGRPCClient struct {
+	conn    *grpc.ClientConn
+	headers metadata.MD
+}
+
+func newOTLPGRPCClient(endpoint *url.URL, headers map[string]string) (*otlpGRPCClient, error) {
+	transportCredentials := insecure.NewCredentials()
+	if endpoint.Scheme == "https" {
+		transportCredentials = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(endpoint.Host, grpc.WithTransportCredentials(transportCredentials))
+	if err != nil {
+		return nil, err
+	}
+	return &otlpGRPCClient{conn: conn, headers: metadata.New(headers)}, nil
+}
+
+func (client *otlpGRPCClient) Export(ctx context.Context, request []byte) error {
+	var response []byte
+	ctx = metadata.NewOutgoingContext(ctx, client.headers)
+	return client.conn.Invoke(ctx, otlpGRPCExportMethod, request, &response, grpc.ForceCodec(otlpRawCodec{}))
+}
+
+// otlpRawCodec passes already encoded protobuf messages to gRPC as is
+type otlpRawCodec struct{}
+
+func (otlpRawCodec) Marshal(v interface{}) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type of message %T", v)
+	}
+	return data, nil
+}
+
+func (otlpRawCodec) Unmarshal(data []byte, v interface{}) error {
+	output, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected type of message %T", v)
+	}
+	*output = append((*output)[:0], data...)
+	return nil
+}
+
+// Name returns name of encoding used in content-type
+func (otlpRawCodec) Name() string {
+	return "proto"
+}
+
+// otlpHTTPClient posts protobuf encoded requests
+type otlpHTTPClient struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newOTLPHTTPClient(endpoint *url.URL, headers map[string]string) *otlpHTTPClient {
+	exportURL := *endpoint
+	if exportURL.Path == "" || exportURL.Path == "/" {
+		exportURL.Path = otlpHTTPTracesPath
+	}
+	return &otlpHTTPClient{url: exportURL.String(), headers: headers, client: &http.Client{}}
+}
+
+func (client *otlpHTTPClient) Export(ctx context.Context, request []byte) error {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, client.url, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	for key, value := range client.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Content-Type", otlpHTTPContentType)
+	response, err := client.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("OTLP collector responded with status %s", response.Status)
+	}
+	return nil
+}
+
+// Numbers of fields and enum values from opentelemetry/proto/trace/v1/trace.proto
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpStatusCodeError  = 2
+)
+
+// encodeOTLPTraceRequest encodes spans as ExportTraceServiceRequest with one ResourceSpans of service
+func encodeOTLPTraceRequest(serviceName string, spans []*trace.SpanData) []byte {
+	resource := &protoWriter{}
+	resource.message(1, encodeOTLPKeyValue("service.name", serviceName))
+
+	scope := &protoWriter{}
+	scope.string(1, "go.opencensus.io")
+	scopeSpans := &protoWriter{}
+	scopeSpans.message(1, scope)
+	for _, span := range spans {
+		scopeSpans.message(2, encodeOTLPSpan(span))
+	}
+
+	resourceSpans := &protoWriter{}
+	resourceSpans.message(1, resource)
+	resourceSpans.message(2, scopeSpans)
+	request := &protoWriter{}
+	request.message(1, resourceSpans)
+	return request.Bytes()
+}
+
+func encodeOTLPSpan(span *trace.SpanData) *protoWriter {
+	output := &protoWriter{}
+	output.bytes(1, span.TraceID[:])
+	output.bytes(2, span.SpanID[:])
+	if traceState := encodeOTLPTraceState(span.SpanContext); traceState != "" {
+		output.string(3, traceState)
+	}
+	if span.ParentSpanID != (trace.SpanID{}) {
+		output.bytes(4, span.ParentSpanID[:])
+	}
+	output.string(5, span.Name)
+	switch span.SpanKind {
+	case trace.SpanKindServer:
+		output.varint(6, otlpSpanKindServer)
+	case trace.SpanKindClient:
+		output.varint(6, otlpSpanKindClient)
+	default:
+		output.varint(6, otlpSpanKindInternal)
+	}
+	output.fixed64(7, uint64(span.StartTime.UnixNano()))
+	output.fixed64(8, uint64(span.EndTime.UnixNano()))
+	for key, value := range span.Attributes {
+		output.message(9, encodeOTLPKeyValue(key, value))
+	}
+	if span.DroppedAttributeCount > 0 {
+		output.varint(10, uint64(span.DroppedAttributeCount))
+	}
+	for _, annotation := range span.Annotations {
+		event := &protoWriter{}
+		event.fixed64(1, uint64(annotation.Time.UnixNano()))
+		event.string(2, annotation.Message)
+		for key, value := range annotation.Attributes {
+			event.message(3, encodeOTLPKeyValue(key, value))
+		}
+		output.message(11, event)
+	}
+	if span.DroppedAnnotationCount > 0 {
+		output.varint(12, uint64(span.DroppedAnnotationCount))
+	}
+	for _, link := range span.Links {
+		encodedLink := &protoWriter{}
+		encodedLink.bytes(1, link.TraceID[:])
+		encodedLink.bytes(2, link.SpanID[:])
+		for key, value := range link.Attributes {
+			encodedLink.message(4, encodeOTLPKeyValue(key, value))
+		}
+		output.message(13, encodedLink)
+	}
+	if span.DroppedLinkCount > 0 {
+		output.varint(14, uint64(span.DroppedLinkCount))
+	}
+	if span.Code != trace.StatusCodeOK {
+		status := &protoWriter{}
+		status.string(2, span.Message)
+		status.varint(3, otlpStatusCodeError)
+		output.message(15, status)
+	}
+	return output
+}
+
+// encodeOTLPTraceState returns tracestate in format of W3C Trace Context header
+func encodeOTLPTraceState(spanContext trace.SpanContext) string {
+	if spanContext.Tracestate == nil {
+		return ""
+	}
+	entries := spanContext.Tracestate.Entries()
+	pairs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		pairs = append(pairs, entry.Key+"="+entry.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// encodeOTLPKeyValue encodes KeyValue with AnyValue of type supported by opencensus attributes
+func encodeOTLPKeyValue(key string, value interface{}) *protoWriter {
+	anyValue := &protoWriter{}
+	switch typedValue := value.(type) {
+	case string:
+		anyValue.string(1, typedValue)
+	case bool:
+		anyValue.bool(2, typedValue)
+	case int64:
+		anyValue.varint(3, uint64(typedValue))
+	case float64:
+		anyValue.double(4, typedValue)
+	default:
+		anyValue.string(1, fmt.Sprint(typedValue))
+	}
+	output := &protoWriter{}
+	output.string(1, key)
+	output.message(2, anyValue)
+	return output
+}
+
+// Wire types of protobuf encoding
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+// protoWriter encodes fields of protobuf messages. Fields are written even with default values, so callers skip
+// optional ones
+type protoWriter struct {
+	bytes.Buffer
+}
+
+func (w *protoWriter) tag(field, wireType int) {
+	w.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (w *protoWriter) uvarint(value uint64) {
+	w.Write(binary.AppendUvarint(nil, value))
+}
+
+func (w *protoWriter) varint(field int, value uint64) {
+	w.tag(field, protoWireVarint)
+	w.uvarint(value)
+}
+
+func (w *protoWriter) bool(field int, value bool) {
+	if value {
+		w.varint(field, 1)
+	} else {
+		w.varint(field, 0)
+	}
+}
+
+func (w *protoWriter) fixed64(field int, value uint64) {
+	w.tag(field, protoWireFixed64)
+	w.Write(binary.LittleEndian.AppendUint64(nil, value))
+}
+
+func (w *protoWriter) double(field int, value float64) {
+	w.fixed64(field, math.Float64bits(value))
+}
+
+func (w *protoWriter) bytes(field int, value []byte) {
+	w.tag(field, protoWireBytes)
+	w.uvarint(uint64(len(value)))
+	w.Write(value)
+}
+
+func (w *protoWriter) string(field int, value string) {
+	w.bytes(field, []byte(value))
+}
+
+func (w *protoWriter) message(field int, value *protoWriter) {
+	w.bytes(field, value.Bytes())
+}
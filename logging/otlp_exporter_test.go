@@ -0,0 +1,205 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// decodeTestProtoFields returns values of fields of protobuf message, varint and fixed64 values are encoded as
+// little endian uint64
+func decodeTestProtoFields(t *testing.T, data []byte) map[int][][]byte {
+	fields := make(map[int][][]byte)
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatal("Invalid tag")
+		}
+		data = data[n:]
+		var value []byte
+		switch tag & 7 {
+		case protoWireVarint:
+			number, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatal("Invalid varint")
+			}
+			value, data = binary.LittleEndian.AppendUint64(nil, number), data[n:]
+		case protoWireFixed64:
+			value, data = data[:8], data[8:]
+		case protoWireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				t.Fatal("Invalid length")
+			}
+			value, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			t.Fatalf("Unexpected wire type %d", tag&7)
+		}
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], value)
+	}
+	return fields
+}
+
+// decodeTestSpans returns service name and spans fields from ExportTraceServiceRequest
+func decodeTestSpans(t *testing.T, request []byte) (string, []map[int][][]byte) {
+	resourceSpans := decodeTestProtoFields(t, decodeTestProtoFields(t, request)[1][0])
+	attribute := decodeTestProtoFields(t, decodeTestProtoFields(t, resourceSpans[1][0])[1][0])
+	if string(attribute[1][0]) != "service.name" {
+		t.Fatalf("Unexpected resource attribute %s", attribute[1][0])
+	}
+	serviceName := string(decodeTestProtoFields(t, attribute[2][0])[1][0])
+	var spans []map[int][][]byte
+	for _, span := range decodeTestProtoFields(t, resourceSpans[2][0])[2] {
+		spans = append(spans, decodeTestProtoFields(t, span))
+	}
+	return serviceName, spans
+}
+
+func testSpanData() *trace.SpanData {
+	start := time.Unix(1600000000, 0)
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:  trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		ParentSpanID: trace.SpanID{8, 7, 6, 5, 4, 3, 2, 1},
+		SpanKind:     trace.SpanKindServer,
+		Name:         "/v2/decrypt",
+		StartTime:    start,
+		EndTime:      start.Add(time.Millisecond),
+		Attributes:   map[string]interface{}{"status_code": int64(400)},
+		Annotations:  []trace.Annotation{{Time: start, Message: "decrypted"}},
+		Status:       trace.Status{Code: trace.StatusCodeUnknown, Message: "Bad Request"},
+	}
+}
+
+func checkTestSpan(t *testing.T, request []byte) {
+	serviceName, spans := decodeTestSpans(t, request)
+	if serviceName != "acra-translator" {
+		t.Fatalf("Unexpected service name %s", serviceName)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, took %d", len(spans))
+	}
+	span, expected := spans[0], testSpanData()
+	if !bytes.Equal(span[1][0], expected.TraceID[:]) || !bytes.Equal(span[2][0], expected.SpanID[:]) ||
+		!bytes.Equal(span[4][0], expected.ParentSpanID[:]) || string(span[5][0]) != expected.Name {
+		t.Fatal("Unexpected identifiers of span")
+	}
+	if kind := binary.LittleEndian.Uint64(span[6][0]); kind != otlpSpanKindServer {
+		t.Fatalf("Unexpected kind %d", kind)
+	}
+	if end := binary.LittleEndian.Uint64(span[8][0]); end != uint64(expected.EndTime.UnixNano()) {
+		t.Fatalf("Unexpected end time %d", end)
+	}
+	attribute := decodeTestProtoFields(t, span[9][0])
+	if value := decodeTestProtoFields(t, attribute[2][0])[3][0]; string(attribute[1][0]) != "status_code" || binary.LittleEndian.Uint64(value) != 400 {
+		t.Fatal("Unexpected attribute")
+	}
+	if event := decodeTestProtoFields(t, span[11][0]); string(event[2][0]) != "decrypted" {
+		t.Fatal("Unexpected event")
+	}
+	status := decodeTestProtoFields(t, span[15][0])
+	if string(status[2][0]) != "Bad Request" || binary.LittleEndian.Uint64(status[3][0]) != otlpStatusCodeError {
+		t.Fatal("Unexpected status")
+	}
+}
+
+func TestOTLPSpanExporterHTTP(t *testing.T) {
+	requests := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpHTTPTracesPath || r.Header.Get("Content-Type") != otlpHTTPContentType || r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests <- body
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPSpanExporter("acra-translator", OTLPOptions{Endpoint: server.URL, Protocol: OTLPProtocolHTTP,
+		Headers: map[string]string{"authorization": "token"}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.ExportSpan(testSpanData())
+	exporter.Flush()
+	select {
+	case request := <-requests:
+		checkTestSpan(t, request)
+	default:
+		t.Fatal("Spans weren't exported")
+	}
+}
+
+func TestOTLPSpanExporterGRPC(t *testing.T) {
+	requests := make(chan []byte, 1)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.ForceServerCodec(otlpRawCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if method != otlpGRPCExportMethod || len(md.Get("authorization")) != 1 || md.Get("authorization")[0] != "token" {
+			return errors.New("unexpected request")
+		}
+		var request []byte
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		requests <- request
+		return stream.SendMsg([]byte{})
+	}))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	exporter, err := NewOTLPSpanExporter("acra-translator", OTLPOptions{Endpoint: "http://" + listener.Addr().String(),
+		Protocol: OTLPProtocolGRPC, Headers: map[string]string{"authorization": "token"}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.ExportSpan(testSpanData())
+	exporter.Flush()
+	select {
+	case request := <-requests:
+		checkTestSpan(t, request)
+	default:
+		t.Fatal("Spans weren't exported")
+	}
+}
+
+func TestNewOTLPSpanExporterInvalidOptions(t *testing.T) {
+	if _, err := NewOTLPSpanExporter("acra-server", OTLPOptions{Endpoint: "localhost:4317", Protocol: OTLPProtocolGRPC}); err != ErrInvalidOTLPEndpoint {
+		t.Fatalf("Expected %v, took %v", ErrInvalidOTLPEndpoint, err)
+	}
+	if _, err := NewOTLPSpanExporter("acra-server", OTLPOptions{Endpoint: "http://localhost:4317", Protocol: "udp"}); !errors.Is(err, ErrUnknownOTLPProtocol) {
+		t.Fatalf("Expected %v, took %v", ErrUnknownOTLPProtocol, err)
+	}
+}
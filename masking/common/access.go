@@ -0,0 +1,113 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Certificate attributes supported by MaskingAccess rules
+const (
+	CertificateAttributeCommonName         = "CN"
+	CertificateAttributeOrganization       = "O"
+	CertificateAttributeOrganizationalUnit = "OU"
+	CertificateAttributeCountry            = "C"
+	CertificateAttributeLocality           = "L"
+	CertificateAttributeProvince           = "ST"
+	// CertificateAttributeSerialNumber matches hex encoded serial number of the certificate
+	CertificateAttributeSerialNumber = "serial_number"
+)
+
+// Validation errors of masking access rules
+var (
+	ErrEmptyMaskingAccess              = errors.New("masking_access should contain unmasked_client_ids or unmasked_certificate_attributes")
+	ErrUnsupportedCertificateAttribute = errors.New("unsupported certificate attribute in masking_access")
+	ErrMaskingAccessWithoutMasking     = errors.New("masking_access can be used only with masking or masking_template")
+	ErrEmptyCertificateAttributeValues = errors.New("certificate attribute in masking_access should have at least one value")
+)
+
+// certificateAttributes returns values of supported certificate attributes
+var certificateAttributes = map[string]func(*x509.Certificate) []string{
+	CertificateAttributeCommonName:         func(c *x509.Certificate) []string { return []string{c.Subject.CommonName} },
+	CertificateAttributeOrganization:       func(c *x509.Certificate) []string { return c.Subject.Organization },
+	CertificateAttributeOrganizationalUnit: func(c *x509.Certificate) []string { return c.Subject.OrganizationalUnit },
+	CertificateAttributeCountry:            func(c *x509.Certificate) []string { return c.Subject.Country },
+	CertificateAttributeLocality:           func(c *x509.Certificate) []string { return c.Subject.Locality },
+	CertificateAttributeProvince:           func(c *x509.Certificate) []string { return c.Subject.Province },
+	CertificateAttributeSerialNumber: func(c *x509.Certificate) []string {
+		if c.SerialNumber == nil {
+			return nil
+		}
+		return []string{hex.EncodeToString(c.SerialNumber.Bytes())}
+	},
+}
+
+// MaskingAccess defines clients which receive decrypted values of masked column. All other clients receive masked
+// values even if data may be decrypted
+type MaskingAccess struct {
+	// UnmaskedClientIDs lists clientIDs which see decrypted data
+	UnmaskedClientIDs []string `yaml:"unmasked_client_ids"`
+	// UnmaskedCertificateAttributes maps subject attribute of client's TLS certificate to allowed values.
+	// Client matches if any of its attributes has any of allowed values
+	UnmaskedCertificateAttributes map[string][]string `yaml:"unmasked_certificate_attributes"`
+}
+
+// Validate checks that access rules are not empty and use supported certificate attributes
+func (a *MaskingAccess) Validate() error {
+	if len(a.UnmaskedClientIDs) == 0 && len(a.UnmaskedCertificateAttributes) == 0 {
+		return ErrEmptyMaskingAccess
+	}
+	for attribute, values := range a.UnmaskedCertificateAttributes {
+		if _, ok := certificateAttributes[attribute]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupportedCertificateAttribute, attribute)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("%w: %s", ErrEmptyCertificateAttributeValues, attribute)
+		}
+	}
+	return nil
+}
+
+// IsUnmasked returns true if client identified by clientID or TLS certificate should receive decrypted data.
+// certificate may be nil if client connected without TLS
+func (a *MaskingAccess) IsUnmasked(clientID []byte, certificate *x509.Certificate) bool {
+	for _, allowed := range a.UnmaskedClientIDs {
+		if allowed == string(clientID) {
+			return true
+		}
+	}
+	if certificate == nil {
+		return false
+	}
+	for attribute, allowedValues := range a.UnmaskedCertificateAttributes {
+		getValues, ok := certificateAttributes[attribute]
+		if !ok {
+			continue
+		}
+		for _, value := range getValues(certificate) {
+			for _, allowed := range allowedValues {
+				if value == allowed {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
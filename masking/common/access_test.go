@@ -0,0 +1,73 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestMaskingAccessValidate(t *testing.T) {
+	testcases := []struct {
+		access MaskingAccess
+		err    error
+	}{
+		{MaskingAccess{}, ErrEmptyMaskingAccess},
+		{MaskingAccess{UnmaskedClientIDs: []string{"admin"}}, nil},
+		{MaskingAccess{UnmaskedCertificateAttributes: map[string][]string{"OU": {"admins"}}}, nil},
+		{MaskingAccess{UnmaskedCertificateAttributes: map[string][]string{"email": {"admin@example.com"}}}, ErrUnsupportedCertificateAttribute},
+		{MaskingAccess{UnmaskedCertificateAttributes: map[string][]string{"CN": {}}}, ErrEmptyCertificateAttributeValues},
+	}
+	for i, tcase := range testcases {
+		if err := tcase.access.Validate(); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, tcase.err, err)
+		}
+	}
+}
+
+func TestMaskingAccessIsUnmasked(t *testing.T) {
+	access := MaskingAccess{
+		UnmaskedClientIDs: []string{"admin"},
+		UnmaskedCertificateAttributes: map[string][]string{
+			CertificateAttributeOrganizationalUnit: {"security", "support"},
+			CertificateAttributeSerialNumber:       {"0a1b"},
+		},
+	}
+	if err := access.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		clientID    string
+		certificate *x509.Certificate
+		unmasked    bool
+	}{
+		{"admin", nil, true},
+		{"user", nil, false},
+		{"user", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"sales", "support"}}}, true},
+		{"user", &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"sales"}}}, false},
+		{"user", &x509.Certificate{SerialNumber: big.NewInt(0x0a1b)}, true},
+		{"user", &x509.Certificate{SerialNumber: big.NewInt(0x0a1c)}, false},
+	}
+	for i, tcase := range testcases {
+		if unmasked := access.IsUnmasked([]byte(tcase.clientID), tcase.certificate); unmasked != tcase.unmasked {
+			t.Fatalf("[%d] Expected %t, took %t", i, tcase.unmasked, unmasked)
+		}
+	}
+}
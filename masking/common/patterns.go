@@ -34,9 +34,10 @@ const (
 
 // Validaton errors
 var (
-	ErrInvalidPlaintextLength = errors.New("plaintext length cannot be negative")
-	ErrInvalidPlaintextSide   = errors.New("plaintext side must be left of right")
-	ErrInvalidMaskingPattern  = errors.New("masking pattern can't be empty")
+	ErrInvalidPlaintextLength  = errors.New("plaintext length cannot be negative")
+	ErrInvalidPlaintextSide    = errors.New("plaintext side must be left of right")
+	ErrInvalidMaskingPattern   = errors.New("masking pattern can't be empty")
+	ErrMaskingTemplateConflict = errors.New("masking_template can't be used together with plaintext_length or plaintext_side")
 )
 
 // ValidateMaskingParams checks and returns an error if masking parameters are incorrect.
@@ -50,6 +51,18 @@ func ValidateMaskingParams(pattern string, plaintextLength int, plaintextSide Pl
 	if plaintextSide != PlainTextSideRight && plaintextSide != PlainTextSideLeft {
 		return ErrInvalidPlaintextSide
 	}
+	return validateMaskingDataType(dataType)
+}
+
+// ValidateMaskingTemplate checks and returns an error if masking template is incorrect.
+func ValidateMaskingTemplate(template *MaskingTemplate, dataType common.EncryptedType) error {
+	if err := template.Validate(); err != nil {
+		return err
+	}
+	return validateMaskingDataType(dataType)
+}
+
+func validateMaskingDataType(dataType common.EncryptedType) error {
 	switch dataType {
 	// support not defined Unknown or String/Bytes
 	case common.EncryptedType_String, common.EncryptedType_Bytes, common.EncryptedType_Unknown:
@@ -0,0 +1,189 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// TemplateType defines how masking template splits value into visible and hidden parts
+type TemplateType string
+
+// Supported masking template types
+const (
+	// TemplateTypeKeepFirst leaves first Length symbols visible
+	TemplateTypeKeepFirst TemplateType = "keep_first"
+	// TemplateTypeKeepLast leaves last Length symbols visible
+	TemplateTypeKeepLast TemplateType = "keep_last"
+	// TemplateTypeEmailDomain leaves domain part of email (starting from the last '@') visible
+	TemplateTypeEmailDomain TemplateType = "email_domain"
+	// TemplateTypeRegex replaces value with Regex and Replacement. Whole value is encrypted,
+	// so template may be applied only to decrypted data with TemplateApplyAlways
+	TemplateTypeRegex TemplateType = "regex"
+)
+
+// TemplateApply defines when masking template should be applied
+type TemplateApply string
+
+// Allowable values for TemplateApply
+const (
+	// TemplateApplyOnFail masks data only if it can't be decrypted
+	TemplateApplyOnFail TemplateApply = "on_fail"
+	// TemplateApplyAlways masks data even if it was decrypted successfully
+	TemplateApplyAlways TemplateApply = "always"
+)
+
+// DefaultTemplateMaskChar used to replace hidden symbols if MaskChar is not set
+const DefaultTemplateMaskChar = "*"
+
+// DefaultTemplateMaskLength is the count of MaskChar symbols returned instead of data which can't be decrypted
+// when the length of hidden part is unknown and masking pattern is not set
+const DefaultTemplateMaskLength = 4
+
+// Validation errors of masking templates
+var (
+	ErrInvalidTemplateType   = errors.New("masking template type must be keep_first, keep_last, email_domain or regex")
+	ErrInvalidTemplateLength = errors.New("masking template length must be positive")
+	ErrInvalidTemplateRegex  = errors.New("masking template regex is invalid")
+	ErrInvalidTemplateApply  = errors.New("masking template apply must be on_fail or always")
+	ErrInvalidTemplateChar   = errors.New("masking template mask_char must be exactly one symbol")
+	ErrRegexTemplateOnFail   = errors.New("regex masking template can be used only with apply: always")
+)
+
+// MaskingTemplate describes structured partial masking of the value
+type MaskingTemplate struct {
+	Type TemplateType `yaml:"type"`
+	// Length of visible part for keep_first/keep_last templates
+	Length int `yaml:"length"`
+	// MaskChar replaces each hidden symbol
+	MaskChar string `yaml:"mask_char"`
+	// MaskLength is count of MaskChar symbols returned instead of data which can't be decrypted
+	MaskLength int `yaml:"mask_length"`
+	// Regex and Replacement used by regex template in the same format as regexp.Regexp.ReplaceAll
+	Regex       string        `yaml:"regex"`
+	Replacement string        `yaml:"replacement"`
+	Apply       TemplateApply `yaml:"apply"`
+
+	compiledRegex *regexp.Regexp
+}
+
+// Validate checks template and initializes default values
+func (t *MaskingTemplate) Validate() error {
+	if t.MaskChar == "" {
+		t.MaskChar = DefaultTemplateMaskChar
+	}
+	if utf8.RuneCountInString(t.MaskChar) != 1 {
+		return ErrInvalidTemplateChar
+	}
+	if t.MaskLength < 0 {
+		return ErrInvalidTemplateLength
+	}
+	if t.MaskLength == 0 {
+		t.MaskLength = DefaultTemplateMaskLength
+	}
+	switch t.Apply {
+	case "":
+		t.Apply = TemplateApplyOnFail
+	case TemplateApplyOnFail, TemplateApplyAlways:
+	default:
+		return ErrInvalidTemplateApply
+	}
+	switch t.Type {
+	case TemplateTypeKeepFirst, TemplateTypeKeepLast:
+		if t.Length <= 0 {
+			return ErrInvalidTemplateLength
+		}
+	case TemplateTypeEmailDomain:
+		break
+	case TemplateTypeRegex:
+		compiled, err := regexp.Compile(t.Regex)
+		if err != nil || t.Regex == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidTemplateRegex, t.Regex)
+		}
+		if t.Apply != TemplateApplyAlways {
+			return ErrRegexTemplateOnFail
+		}
+		t.compiledRegex = compiled
+	default:
+		return ErrInvalidTemplateType
+	}
+	return nil
+}
+
+// IsAlways returns true if template should be applied to successfully decrypted data too
+func (t *MaskingTemplate) IsAlways() bool {
+	return t.Apply == TemplateApplyAlways
+}
+
+// Split returns parts of data that are left in plaintext on both sides and the hidden part which should be encrypted
+func (t *MaskingTemplate) Split(data []byte) (prefix, hidden, suffix []byte) {
+	switch t.Type {
+	case TemplateTypeKeepFirst:
+		n := runeOffset(data, t.Length)
+		return data[:n], data[n:], nil
+	case TemplateTypeKeepLast:
+		count := utf8.RuneCount(data)
+		if count <= t.Length {
+			return nil, data, nil
+		}
+		n := runeOffset(data, count-t.Length)
+		return nil, data[:n], data[n:]
+	case TemplateTypeEmailDomain:
+		index := bytes.LastIndexByte(data, '@')
+		if index < 0 {
+			return nil, data, nil
+		}
+		return nil, data[:index], data[index:]
+	}
+	return nil, data, nil
+}
+
+// MaskHidden replaces each symbol of hidden part with MaskChar. Regex template replaces the whole value
+func (t *MaskingTemplate) MaskHidden(hidden []byte) []byte {
+	if t.Type == TemplateTypeRegex {
+		return t.compiledRegex.ReplaceAll(hidden, []byte(t.Replacement))
+	}
+	return bytes.Repeat([]byte(t.MaskChar), utf8.RuneCount(hidden))
+}
+
+// Mask applies template to the whole plaintext value
+func (t *MaskingTemplate) Mask(data []byte) []byte {
+	prefix, hidden, suffix := t.Split(data)
+	result := make([]byte, 0, len(data))
+	result = append(result, prefix...)
+	result = append(result, t.MaskHidden(hidden)...)
+	return append(result, suffix...)
+}
+
+// MaskUnknown returns mask for hidden part which can't be decrypted and has unknown length
+func (t *MaskingTemplate) MaskUnknown() []byte {
+	return bytes.Repeat([]byte(t.MaskChar), t.MaskLength)
+}
+
+// runeOffset returns byte offset of n-th symbol in data or length of data if it has less symbols
+func runeOffset(data []byte, n int) int {
+	offset := 0
+	for i := 0; i < n && offset < len(data); i++ {
+		_, size := utf8.DecodeRune(data[offset:])
+		offset += size
+	}
+	return offset
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMaskingTemplateMask(t *testing.T) {
+	testcases := []struct {
+		template MaskingTemplate
+		data     string
+		masked   string
+		prefix   string
+		suffix   string
+	}{
+		{MaskingTemplate{Type: TemplateTypeKeepLast, Length: 4}, "4111111111111234", "************1234", "", "1234"},
+		{MaskingTemplate{Type: TemplateTypeKeepLast, Length: 4}, "123", "***", "", ""},
+		{MaskingTemplate{Type: TemplateTypeKeepFirst, Length: 2, MaskChar: "#"}, "секрет", "се####", "се", ""},
+		{MaskingTemplate{Type: TemplateTypeEmailDomain}, "john.doe@example.com", "********@example.com", "", "@example.com"},
+		{MaskingTemplate{Type: TemplateTypeEmailDomain}, "not an email", "************", "", ""},
+		{MaskingTemplate{Type: TemplateTypeRegex, Regex: `^(\d{3})-\d{2}-(\d{4})$`, Replacement: "${1}-XX-${2}", Apply: TemplateApplyAlways}, "123-45-6789", "123-XX-6789", "", ""},
+	}
+	for i, tcase := range testcases {
+		if err := tcase.template.Validate(); err != nil {
+			t.Fatalf("[%d] Unexpected error: %s", i, err)
+		}
+		if masked := tcase.template.Mask([]byte(tcase.data)); string(masked) != tcase.masked {
+			t.Fatalf("[%d] Expected %s, took %s", i, tcase.masked, masked)
+		}
+		prefix, hidden, suffix := tcase.template.Split([]byte(tcase.data))
+		if string(prefix) != tcase.prefix || string(suffix) != tcase.suffix {
+			t.Fatalf("[%d] Unexpected split: %s, %s", i, prefix, suffix)
+		}
+		if joined := bytes.Join([][]byte{prefix, hidden, suffix}, nil); string(joined) != tcase.data {
+			t.Fatalf("[%d] Split parts don't compose data", i)
+		}
+	}
+}
+
+func TestMaskingTemplateDefaults(t *testing.T) {
+	template := MaskingTemplate{Type: TemplateTypeEmailDomain}
+	if err := template.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if template.Apply != TemplateApplyOnFail || template.IsAlways() {
+		t.Fatal("Expected on_fail by default")
+	}
+	if string(template.MaskUnknown()) != "****" {
+		t.Fatalf("Unexpected mask for unknown data: %s", template.MaskUnknown())
+	}
+	invalid := []MaskingTemplate{
+		{Type: TemplateTypeKeepLast},
+		{Type: TemplateTypeEmailDomain, MaskChar: "**"},
+		{Type: TemplateTypeEmailDomain, Apply: "never"},
+		{Type: TemplateTypeRegex, Regex: "(", Apply: TemplateApplyAlways},
+		{Type: "unknown"},
+	}
+	for i, template := range invalid {
+		if err := template.Validate(); err == nil {
+			t.Fatalf("[%d] Expected validation error", i)
+		}
+	}
+}
@@ -49,6 +49,17 @@ func (e *DataEncryptor) encryptByFunction(context, data []byte, settingCE config
 	if !ok {
 		return nil, errors.New("can't cast column encryption settings")
 	}
+	if template := setting.GetMaskingTemplate(); template != nil {
+		prefix, hidden, suffix := template.Split(data)
+		container, err := encryptionFunc(context, hidden, setting)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]byte, 0, len(prefix)+len(container)+len(suffix))
+		result = append(result, prefix...)
+		result = append(result, container...)
+		return append(result, suffix...), nil
+	}
 	if setting.GetMaskingPattern() != "" {
 		partialPlaintextLen := setting.GetPartialPlaintextLen()
 		if partialPlaintextLen >= len(data) {
@@ -2,9 +2,13 @@ package masking
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
+
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/masking/common"
 )
 
 // Processor implements DataProcessor interface and unmask matched data
@@ -22,13 +26,39 @@ func (processor *Processor) Process(data []byte, context *base.DataProcessorCont
 	setting, ok := encryptor.EncryptionSettingFromContext(context.Context)
 	if ok && setting.GetMaskingPattern() != "" {
 		logger.Debugln("Has pattern")
+		template := setting.GetMaskingTemplate()
+		// mask successfully decrypted data only with templates applied always, unless access rules are defined
+		maskDecrypted := template != nil && template.IsAlways()
+		if access := setting.GetMaskingAccess(); access != nil {
+			maskDecrypted = !isUnmaskedClient(context.Context, access)
+			// template needs decrypted data to mask it keeping the length, pattern replaces data as is
+			if maskDecrypted && template == nil {
+				logger.Debugln("Mask data for client without access")
+				return []byte(setting.GetMaskingPattern()), nil
+			}
+		}
 		newData, err := processor.decryptor.Process(data, context)
 		if err != nil || bytes.Equal(newData, data) {
 			logger.Debugln("Mask data")
+			base.ColumnMetricsRecorderFromContext(context.Context).OnMaskingFallback()
 			return []byte(setting.GetMaskingPattern()), nil
 		}
+		if maskDecrypted {
+			logger.Debugln("Mask decrypted data with template")
+			return template.MaskHidden(newData), nil
+		}
 		logger.Debugln("Return decrypted")
 		return newData, nil
 	}
 	return processor.decryptor.Process(data, context)
 }
+
+// isUnmaskedClient checks clientID and TLS certificate of current client against access rules
+func isUnmaskedClient(ctx context.Context, access *common.MaskingAccess) bool {
+	clientID := base.AccessContextFromContext(ctx).GetClientID()
+	var certificate *x509.Certificate
+	if session := base.ClientSessionFromContext(ctx); session != nil {
+		certificate = base.PeerCertificateFromSession(session)
+	}
+	return access.IsUnmasked(clientID, certificate)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultACMEDirectoryURL is directory of Let's Encrypt production CA
+const DefaultACMEDirectoryURL = autocert.DefaultACMEDirectory
+
+// ErrEmptyACMEDomains returned if ACME certificate manager created without domains
+var ErrEmptyACMEDomains = errors.New("domains of ACME certificates should be specified")
+
+// NewACMECertificateManager returns manager which obtains and renews certificates for comma separated domains from
+// ACME CA with directoryURL (Let's Encrypt or internal CA). Certificates and account key are stored in cacheDir,
+// or only in memory if cacheDir is empty. Domains are verified with HTTP-01 challenge served by HTTPHandler of manager
+func NewACMECertificateManager(domains, directoryURL, cacheDir, email string) (*autocert.Manager, error) {
+	var hosts []string
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			hosts = append(hosts, domain)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, ErrEmptyACMEDomains
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Client:     &acme.Client{DirectoryURL: directoryURL},
+		Email:      email,
+	}
+	if cacheDir != "" {
+		manager.Cache = autocert.DirCache(cacheDir)
+	}
+	return manager, nil
+}
+
+// ApplyACMEToConfig configures config to use certificates of ACME manager instead of static certificates. Clients
+// should send Server Name Indication (SNI) with one of manager's domains
+func ApplyACMEToConfig(manager *autocert.Manager, config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = manager.GetCertificate
+}
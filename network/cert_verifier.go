@@ -38,6 +38,10 @@ var (
 	tlsOcspRequired                 string
 	tlsOcspFromCert                 string
 	tlsOcspCheckOnlyLeafCertificate bool
+	tlsOcspFailureMode              string
+	tlsOcspCacheSize                uint
+	tlsOcspCacheTime                uint
+	tlsOcspCacheDir                 string
 	tlsCrlURL                       string
 	tlsCrlClientURL                 string
 	tlsCrlDbURL                     string
@@ -45,6 +49,7 @@ var (
 	tlsCrlCheckOnlyLeafCertificate  bool
 	tlsCrlCacheSize                 uint
 	tlsCrlCacheTime                 uint
+	tlsCrlRefreshInterval           uint
 )
 
 // RegisterCertVerifierArgsForService register CLI args which allow to get CertVerifier by NewCertVerifier() for
@@ -57,6 +62,12 @@ func RegisterCertVerifierArgsForService(flags *flag.FlagSet, serviceName string,
 		fmt.Sprintf("How to treat OCSP server described in certificate itself: <%s>", strings.Join(OcspFromCertValuesList, "|")))
 	flags.Bool(namerFunc(serviceName, "check_only_leaf_certificate", "ocsp"), false,
 		"Put 'true' to check only final/last certificate, or 'false' to check the whole certificate chain using OCSP")
+	flags.String(namerFunc(serviceName, "failure_mode", "ocsp"), OcspFailureModeHardStr,
+		fmt.Sprintf("How to treat certificates when all OCSP servers are unreachable: <%s>. '%s' allows them", strings.Join(OcspFailureModeValuesList, "|"), OcspFailureModeSoftStr))
+	flags.Uint(namerFunc(serviceName, "cache_size", "ocsp"), OcspDefaultCacheSize, "How many OCSP responses to cache in memory (use 0 to disable caching)")
+	flags.Uint(namerFunc(serviceName, "cache_time", "ocsp"), OcspDisableCacheTime,
+		fmt.Sprintf("How long to keep OCSP responses cached, in seconds, but not longer than their next update time (use 0 to disable caching, maximum: %d s)", OcspCacheTimeMax))
+	flags.String(namerFunc(serviceName, "cache_dir", "ocsp"), "", "Folder where cached OCSP responses are stored to be used after restart. Empty value keeps them only in memory")
 	flags.String(namerFunc(serviceName, "url", "crl"), "", "URL of the Certificate Revocation List (CRL) to use")
 	flags.String(namerFunc(serviceName, "from_cert", "crl"), CrlFromCertPreferStr,
 		fmt.Sprintf("How to treat CRL URL described in certificate itself: <%s>", strings.Join(CrlFromCertValuesList, "|")))
@@ -65,6 +76,8 @@ func RegisterCertVerifierArgsForService(flags *flag.FlagSet, serviceName string,
 	flags.Uint(namerFunc(serviceName, "cache_size", "crl"), CrlDefaultCacheSize, "How many CRLs to cache in memory (use 0 to disable caching)")
 	flags.Uint(namerFunc(serviceName, "cache_time", "crl"), CrlDisableCacheTime,
 		fmt.Sprintf("How long to keep CRLs cached, in seconds (use 0 to disable caching, maximum: %d s)", CrlCacheTimeMax))
+	flags.Uint(namerFunc(serviceName, "refresh_interval", "crl"), CrlDisableRefresh,
+		fmt.Sprintf("How often to refresh used CRLs in background, in seconds, they are refreshed earlier on their next update time (use 0 to fetch CRLs on demand, maximum: %d s)", CrlRefreshIntervalMax))
 }
 
 // RegisterCertVerifierArgs register CLI args which allow to get CertVerifier by NewCertVerifier()
@@ -76,6 +89,12 @@ func RegisterCertVerifierArgs(flags *flag.FlagSet) {
 		fmt.Sprintf("How to treat OCSP server described in certificate itself: <%s>", strings.Join(OcspFromCertValuesList, "|")))
 	flags.BoolVar(&tlsOcspCheckOnlyLeafCertificate, "tls_ocsp_check_only_leaf_certificate", false,
 		"Put 'true' to check only final/last certificate, or 'false' to check the whole certificate chain using OCSP")
+	flags.StringVar(&tlsOcspFailureMode, "tls_ocsp_failure_mode", OcspFailureModeHardStr,
+		fmt.Sprintf("How to treat certificates when all OCSP servers are unreachable: <%s>. '%s' allows them", strings.Join(OcspFailureModeValuesList, "|"), OcspFailureModeSoftStr))
+	flags.UintVar(&tlsOcspCacheSize, "tls_ocsp_cache_size", OcspDefaultCacheSize, "How many OCSP responses to cache in memory (use 0 to disable caching)")
+	flags.UintVar(&tlsOcspCacheTime, "tls_ocsp_cache_time", OcspDisableCacheTime,
+		fmt.Sprintf("How long to keep OCSP responses cached, in seconds, but not longer than their next update time (use 0 to disable caching, maximum: %d s)", OcspCacheTimeMax))
+	flags.StringVar(&tlsOcspCacheDir, "tls_ocsp_cache_dir", "", "Folder where cached OCSP responses are stored to be used after restart. Empty value keeps them only in memory")
 	flags.StringVar(&tlsCrlURL, "tls_crl_url", "", "URL of the Certificate Revocation List (CRL) to use")
 	flags.StringVar(&tlsCrlFromCert, "tls_crl_from_cert", CrlFromCertPreferStr,
 		fmt.Sprintf("How to treat CRL URL described in certificate itself: <%s>", strings.Join(CrlFromCertValuesList, "|")))
@@ -84,6 +103,8 @@ func RegisterCertVerifierArgs(flags *flag.FlagSet) {
 	flags.UintVar(&tlsCrlCacheSize, "tls_crl_cache_size", CrlDefaultCacheSize, "How many CRLs to cache in memory (use 0 to disable caching)")
 	flags.UintVar(&tlsCrlCacheTime, "tls_crl_cache_time", CrlDisableCacheTime,
 		fmt.Sprintf("How long to keep CRLs cached, in seconds (use 0 to disable caching, maximum: %d s)", CrlCacheTimeMax))
+	flags.UintVar(&tlsCrlRefreshInterval, "tls_crl_refresh_interval", CrlDisableRefresh,
+		fmt.Sprintf("How often to refresh used CRLs in background, in seconds, they are refreshed earlier on their next update time (use 0 to fetch CRLs on demand, maximum: %d s)", CrlRefreshIntervalMax))
 }
 
 // CertVerifier is a generic certificate verifier
@@ -103,11 +124,20 @@ func NewCertVerifier() (CertVerifier, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := ocspConfig.SetFailureMode(tlsOcspFailureMode); err != nil {
+		return nil, err
+	}
+	if err := ocspConfig.SetCache(tlsOcspCacheSize, tlsOcspCacheTime, tlsOcspCacheDir); err != nil {
+		return nil, err
+	}
 
 	crlConfig, err := NewCRLConfig(tlsCrlURL, tlsCrlFromCert, tlsCrlCheckOnlyLeafCertificate, tlsCrlCacheSize, tlsCrlCacheTime)
 	if err != nil {
 		return nil, err
 	}
+	if err := crlConfig.SetRefreshInterval(tlsCrlRefreshInterval); err != nil {
+		return nil, err
+	}
 
 	return NewCertVerifierFromConfigs(ocspConfig, crlConfig)
 }
@@ -118,9 +148,14 @@ func NewCertVerifierFromConfigs(ocspConfig *OCSPConfig, crlConfig *CRLConfig) (C
 
 	if ocspConfig.UseOCSP() {
 		log.Debugln("NewCertVerifierFromConfigs(): adding OCSP verifier")
+		ocspCache, err := NewOCSPCache(ocspConfig)
+		if err != nil {
+			return nil, err
+		}
 		ocspVerifier := DefaultOCSPVerifier{
 			Config: *ocspConfig,
 			Client: NewDefaultOCSPClient(),
+			Cache:  ocspCache,
 		}
 		certVerifier.Push(ocspVerifier)
 	}
@@ -132,6 +167,9 @@ func NewCertVerifierFromConfigs(ocspConfig *OCSPConfig, crlConfig *CRLConfig) (C
 			Client: NewDefaultCRLClient(),
 			Cache:  NewLRUCRLCache(crlConfig.cacheSize),
 		}
+		if crlConfig.isRefreshEnabled() {
+			crlVerifier.Refresher = NewCRLRefresher(crlVerifier.Client, crlVerifier.Cache, crlConfig.refreshInterval, crlConfig.cacheSize)
+		}
 		certVerifier.Push(crlVerifier)
 	}
 
@@ -0,0 +1,276 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// certificatePinPrefix is optional prefix of pins, the same as used by HTTP Public Key Pinning
+const certificatePinPrefix = "sha256/"
+
+// Errors related to certificate pinning
+var (
+	ErrCertificatePinMismatch = errors.New("client's certificate doesn't match pins of its clientID")
+	ErrNoCertificatePins      = errors.New("clientID doesn't have pinned certificates")
+	ErrInvalidCertificatePin  = errors.New("invalid certificate pin, should be base64 encoded SHA-256 hash of SubjectPublicKeyInfo")
+)
+
+// CertificatePin returns pin of certificate: base64 encoded SHA-256 hash of its SubjectPublicKeyInfo with "sha256/"
+// prefix. Pins are kept after certificate renewal with the same key
+func CertificatePin(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return certificatePinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// parseCertificatePin decodes pin with or without "sha256/" prefix
+func parseCertificatePin(pin string) ([]byte, error) {
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), certificatePinPrefix))
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCertificatePin, pin)
+	}
+	return hash, nil
+}
+
+// CertificatePinStore returns pinned SPKI hashes of certificates allowed for clientID
+type CertificatePinStore interface {
+	GetPins(clientID []byte) ([][]byte, error)
+}
+
+// certificatePinsFile is format of file with pins:
+//
+//	pins:
+//	  <clientID>:
+//	    - sha256/<base64 encoded SHA-256 hash of SubjectPublicKeyInfo>
+type certificatePinsFile struct {
+	Pins map[string][]string `yaml:"pins"`
+}
+
+// FileCertificatePinStore is CertificatePinStore which reads pins from YAML file and reloads them on change
+type FileCertificatePinStore struct {
+	path     string
+	interval time.Duration
+	lock     sync.RWMutex
+	data     []byte
+	pins     map[string][][]byte
+	// fileLock serializes changes of file by AddPins
+	fileLock sync.Mutex
+}
+
+// NewFileCertificatePinStore returns FileCertificatePinStore with pins loaded from file. If interval is not zero, Run
+// polls file with the interval
+func NewFileCertificatePinStore(path string, interval time.Duration) (*FileCertificatePinStore, error) {
+	store := &FileCertificatePinStore{path: path, interval: interval}
+	if _, err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload reads file and replaces pins if file changed. Returns true if pins were replaced. Previous pins are left in
+// use if file is invalid
+func (store *FileCertificatePinStore) Reload() (bool, error) {
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		return false, err
+	}
+	store.lock.RLock()
+	unchanged := store.pins != nil && bytes.Equal(data, store.data)
+	store.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	var file certificatePinsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return false, err
+	}
+	pins := make(map[string][][]byte, len(file.Pins))
+	for clientID, clientPins := range file.Pins {
+		for _, pin := range clientPins {
+			hash, err := parseCertificatePin(pin)
+			if err != nil {
+				return false, err
+			}
+			pins[clientID] = append(pins[clientID], hash)
+		}
+	}
+	store.lock.Lock()
+	store.data = data
+	store.pins = pins
+	store.lock.Unlock()
+	return true, nil
+}
+
+// AddPins adds pins of clientID to file, skipping already pinned ones, and reloads it. File is replaced atomically,
+// so services which poll it never read partially written file. Comments of file aren't kept
+func (store *FileCertificatePinStore) AddPins(clientID []byte, pins []string) error {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		hash, err := parseCertificatePin(pin)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	store.fileLock.Lock()
+	defer store.fileLock.Unlock()
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		return err
+	}
+	var file certificatePinsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return err
+	}
+	if file.Pins == nil {
+		file.Pins = make(map[string][]string)
+	}
+	clientPins := file.Pins[string(clientID)]
+	for i, hash := range hashes {
+		pinned := false
+		for _, existingPin := range clientPins {
+			if existingHash, err := parseCertificatePin(existingPin); err == nil && bytes.Equal(existingHash, hash) {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			clientPins = append(clientPins, pins[i])
+		}
+	}
+	file.Pins[string(clientID)] = clientPins
+	if data, err = yaml.Marshal(&file); err != nil {
+		return err
+	}
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Chmod(info.Mode()); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), store.path); err != nil {
+		return err
+	}
+	_, err = store.Reload()
+	return err
+}
+
+// GetPins returns pins of clientID, or nil if clientID doesn't have them
+func (store *FileCertificatePinStore) GetPins(clientID []byte) ([][]byte, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.pins[string(clientID)], nil
+}
+
+// Run polls file until ctx is done. Should be called as goroutine
+func (store *FileCertificatePinStore) Run(ctx context.Context) {
+	if store.interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(store.interval)
+	defer ticker.Stop()
+	logger := log.WithField("path", store.path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := store.Reload()
+			if err != nil {
+				logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSGeneral).
+					Warningln("Can't reload certificate pins, previous pins left in use")
+				continue
+			}
+			if reloaded {
+				logger.Infoln("Reloaded certificate pins")
+			}
+		}
+	}
+}
+
+// pinnedTLSClientIDExtractor checks that certificate matches pins of clientID extracted by wrapped extractor
+type pinnedTLSClientIDExtractor struct {
+	extractor TLSClientIDExtractor
+	store     CertificatePinStore
+	strict    bool
+}
+
+// NewPinnedTLSClientIDExtractor returns TLSClientIDExtractor which, in addition to CA validation, allows only
+// certificates pinned for clientID in store. Clients without pins are allowed unless strict is set
+func NewPinnedTLSClientIDExtractor(extractor TLSClientIDExtractor, store CertificatePinStore, strict bool) TLSClientIDExtractor {
+	return &pinnedTLSClientIDExtractor{extractor: extractor, store: store, strict: strict}
+}
+
+// ExtractClientID extracts clientID and checks certificate pin
+func (e *pinnedTLSClientIDExtractor) ExtractClientID(certificate *x509.Certificate) ([]byte, error) {
+	clientID, err := e.extractor.ExtractClientID(certificate)
+	if err != nil {
+		return nil, err
+	}
+	pins, err := e.store.GetPins(clientID)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithField("client_id", string(clientID)).WithField("pin", CertificatePin(certificate))
+	if len(pins) == 0 {
+		if e.strict {
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSCertificatePinMismatch).
+				Warningln("Denied client's certificate, clientID doesn't have pinned certificates")
+			return nil, ErrNoCertificatePins
+		}
+		return clientID, nil
+	}
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(pin, hash[:]) {
+			return clientID, nil
+		}
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSCertificatePinMismatch).
+		Warningln("Denied client's certificate, it doesn't match pins of clientID")
+	return nil, ErrCertificatePinMismatch
+}
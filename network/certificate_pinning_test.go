@@ -0,0 +1,143 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticClientIDExtractor returns the same clientID for any certificate
+type staticClientIDExtractor string
+
+func (e staticClientIDExtractor) ExtractClientID(*x509.Certificate) ([]byte, error) {
+	return []byte(e), nil
+}
+
+func TestPinnedTLSClientIDExtractor(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	pinned, rotated, other := issuer.newCertificate(t, 1), issuer.newCertificate(t, 2), issuer.newCertificate(t, 3)
+	pinsPath := filepath.Join(t.TempDir(), "pins.yaml")
+	writePins := func(pins ...string) {
+		data := fmt.Sprintf("pins:\n  client:\n    - %s\n", strings.Join(pins, "\n    - "))
+		if err := os.WriteFile(pinsPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// pins may be specified without prefix
+	writePins(CertificatePin(pinned), strings.TrimPrefix(CertificatePin(rotated), certificatePinPrefix))
+	store, err := NewFileCertificatePinStore(pinsPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		clientID    string
+		strict      bool
+		certificate *x509.Certificate
+		err         error
+	}{
+		{"client", false, pinned, nil},
+		{"client", true, rotated, nil},
+		{"client", false, other, ErrCertificatePinMismatch},
+		{"unknown", false, other, nil},
+		{"unknown", true, other, ErrNoCertificatePins},
+	}
+	for i, testcase := range testcases {
+		extractor := NewPinnedTLSClientIDExtractor(staticClientIDExtractor(testcase.clientID), store, testcase.strict)
+		clientID, err := extractor.ExtractClientID(testcase.certificate)
+		if err != testcase.err {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+		if err == nil && string(clientID) != testcase.clientID {
+			t.Fatalf("[%d] Expected %s, took %s", i, testcase.clientID, clientID)
+		}
+	}
+
+	// changed file replaces pins, invalid one leaves previous pins in use
+	writePins(CertificatePin(other))
+	if reloaded, err := store.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected reloaded pins, took %v, %v", reloaded, err)
+	}
+	if reloaded, err := store.Reload(); err != nil || reloaded {
+		t.Fatalf("Expected unchanged pins, took %v, %v", reloaded, err)
+	}
+	writePins("sha256/invalid")
+	if _, err := store.Reload(); !errors.Is(err, ErrInvalidCertificatePin) {
+		t.Fatalf("Expected %v, took %v", ErrInvalidCertificatePin, err)
+	}
+	extractor := NewPinnedTLSClientIDExtractor(staticClientIDExtractor("client"), store, false)
+	if _, err := extractor.ExtractClientID(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractor.ExtractClientID(pinned); err != ErrCertificatePinMismatch {
+		t.Fatalf("Expected %v, took %v", ErrCertificatePinMismatch, err)
+	}
+}
+
+func TestFileCertificatePinStoreAddPins(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	first, second := issuer.newCertificate(t, 1), issuer.newCertificate(t, 2)
+	pinsPath := filepath.Join(t.TempDir(), "pins.yaml")
+	if err := os.WriteFile(pinsPath, []byte("pins:\n  other:\n    - "+CertificatePin(first)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileCertificatePinStore(pinsPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.AddPins([]byte("client"), []string{"sha256/invalid"}); !errors.Is(err, ErrInvalidCertificatePin) {
+		t.Fatalf("Expected %v, took %v", ErrInvalidCertificatePin, err)
+	}
+	if err = store.AddPins([]byte("client"), []string{CertificatePin(first)}); err != nil {
+		t.Fatal(err)
+	}
+	// already pinned certificates are skipped
+	if err = store.AddPins([]byte("client"), []string{CertificatePin(first), CertificatePin(second)}); err != nil {
+		t.Fatal(err)
+	}
+	for clientID, expected := range map[string]int{"client": 2, "other": 1} {
+		pins, err := store.GetPins([]byte(clientID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pins) != expected {
+			t.Fatalf("Expected %d pins of %s, took %d", expected, clientID, len(pins))
+		}
+	}
+	// file is reloaded by other services with the same result
+	reloadedStore, err := NewFileCertificatePinStore(pinsPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor := NewPinnedTLSClientIDExtractor(staticClientIDExtractor("client"), reloadedStore, true)
+	if _, err = extractor.ExtractClientID(second); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(pinsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected permissions of file to be kept, took %v", info.Mode())
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// ErrInvalidCertificateReloadInterval returned if CertificateReloader created with non-positive interval
+var ErrInvalidCertificateReloadInterval = errors.New("certificate reload interval should be positive")
+
+// ErrEmptyCertificatePaths returned if CertificateReloader created without paths to certificate or private key
+var ErrEmptyCertificatePaths = errors.New("paths to certificate and private key should be specified")
+
+// CertificateReloader polls certificate and private key files and replaces certificate used for new TLS handshakes
+// when they change, so certificates may be rotated without restart. Files are compared by content, so symlinks
+// swapped by orchestrators (like mounted Kubernetes secrets) are handled too
+type CertificateReloader struct {
+	certPath    string
+	keyPath     string
+	interval    time.Duration
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// NewCertificateReloader returns CertificateReloader with loaded certificate. interval is interval of files polling
+func NewCertificateReloader(certPath, keyPath string, interval time.Duration) (*CertificateReloader, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidCertificateReloadInterval
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, ErrEmptyCertificatePaths
+	}
+	reloader := &CertificateReloader{certPath: certPath, keyPath: keyPath, interval: interval}
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns current certificate, used as tls.Config.GetCertificate
+func (reloader *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.certificate, nil
+}
+
+// ApplyToConfig configures config to use certificate from reloader instead of static certificates
+func (reloader *CertificateReloader) ApplyToConfig(config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = reloader.GetCertificate
+}
+
+// Reload reads files and replaces certificate if they changed. Returns true if certificate was replaced. Previous
+// certificate is left in use if new files are invalid
+func (reloader *CertificateReloader) Reload() (bool, error) {
+	certPEM, err := os.ReadFile(reloader.certPath)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(reloader.keyPath)
+	if err != nil {
+		return false, err
+	}
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+	if bytes.Equal(certPEM, reloader.certPEM) && bytes.Equal(keyPEM, reloader.keyPEM) {
+		return false, nil
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		// certificate and key may be replaced not at the same time, new pair will be loaded on next poll when both of
+		// them are updated
+		return false, err
+	}
+	reloader.certificate = &certificate
+	reloader.certPEM = certPEM
+	reloader.keyPEM = keyPEM
+	return true, nil
+}
+
+// Run polls files until ctx is done. Should be called as goroutine
+func (reloader *CertificateReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(reloader.interval)
+	defer ticker.Stop()
+	logger := log.WithFields(log.Fields{"certificate": reloader.certPath, "key": reloader.keyPath})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if err != nil {
+				logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSGeneral).
+					Warningln("Can't reload TLS certificate, previous certificate left in use")
+				continue
+			}
+			if reloaded {
+				logger.Infoln("Reloaded TLS certificate")
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(t *testing.T, certPath, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "acra-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certificateSerial(t *testing.T, reloader *CertificateReloader) int64 {
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if _, err := NewCertificateReloader(certPath, keyPath, time.Second); err == nil {
+		t.Fatal("Expected error for missing files")
+	}
+	writeTestCertificate(t, certPath, keyPath, 1)
+	reloader, err := NewCertificateReloader(certPath, keyPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{}}}
+	reloader.ApplyToConfig(config)
+	if len(config.Certificates) != 0 || config.GetCertificate == nil {
+		t.Fatal("Expected config with GetCertificate instead of static certificates")
+	}
+	if serial := certificateSerial(t, reloader); serial != 1 {
+		t.Fatalf("Expected certificate with serial 1, took %d", serial)
+	}
+	reloaded, err := reloader.Reload()
+	if err != nil || reloaded {
+		t.Fatalf("Expected unchanged certificate, took reloaded=%t, err=%v", reloaded, err)
+	}
+
+	// key from another pair leaves previous certificate in use
+	otherCertPath, otherKeyPath := filepath.Join(dir, "other.crt"), filepath.Join(dir, "other.key")
+	writeTestCertificate(t, otherCertPath, otherKeyPath, 2)
+	otherKey, err := os.ReadFile(otherKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, otherKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("Expected error for mismatched certificate and key")
+	}
+	if serial := certificateSerial(t, reloader); serial != 1 {
+		t.Fatalf("Expected previous certificate, took serial %d", serial)
+	}
+
+	otherCert, err := os.ReadFile(otherCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, otherCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err = reloader.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Expected reloaded certificate, took reloaded=%t, err=%v", reloaded, err)
+	}
+	if serial := certificateSerial(t, reloader); serial != 2 {
+		t.Fatalf("Expected new certificate with serial 2, took %d", serial)
+	}
+}
+
+func TestNewCertificateReloaderInvalidParameters(t *testing.T) {
+	if _, err := NewCertificateReloader("server.crt", "server.key", 0); err != ErrInvalidCertificateReloadInterval {
+		t.Fatalf("Expected ErrInvalidCertificateReloadInterval, took %v", err)
+	}
+	if _, err := NewCertificateReloader("", "server.key", time.Second); err != ErrEmptyCertificatePaths {
+		t.Fatalf("Expected ErrEmptyCertificatePaths, took %v", err)
+	}
+}
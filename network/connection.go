@@ -2,6 +2,7 @@ package network
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net"
 )
 
@@ -49,3 +50,22 @@ func GetClientIDFromConnection(conn net.Conn, tlsExtractor TLSClientIDExtractor)
 		conn = unwrapped.Unwrap()
 	}
 }
+
+// GetPeerCertificateFromConnection unwraps conn until tls.Conn and returns verified client's certificate
+// or nil if connection isn't TLS or client didn't present certificate
+func GetPeerCertificateFromConnection(conn net.Conn) *x509.Certificate {
+	for {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+				return nil
+			}
+			return state.VerifiedChains[0][0]
+		}
+		unwrapped, ok := conn.(WrappedConnection)
+		if !ok {
+			return nil
+		}
+		conn = unwrapped.Unwrap()
+	}
+}
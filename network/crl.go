@@ -20,9 +20,11 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"flag"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	url_ "net/url"
 	"strconv"
@@ -46,6 +48,8 @@ var (
 	ErrOutdatedCRL                  = errors.New("fetched CRLs NextUpdate is behind current time")
 	ErrUnknownCRLExtensionOID       = errors.New("unable to process unknown critical extension inside CRL")
 	ErrUnimplementedCRLExtension    = errors.New("handling of CRL extension is not yet implemented")
+	ErrInvalidConfigCRLRefresh      = errors.New("invalid `tls_crl_refresh_interval` value")
+	ErrDeltaCRLNotApplicable        = errors.New("delta CRL is not applicable to complete CRL")
 )
 
 // --tls_crl_from_cert=<use|trust|prefer|ignore>
@@ -95,6 +99,10 @@ const (
 	CrlCacheTimeMax = 300
 	// CrlDisableCacheTime will disable caching if set in `--tls_crl_cache_time`
 	CrlDisableCacheTime = 0
+	// CrlRefreshIntervalMax is max value for `--tls_crl_refresh_interval`
+	CrlRefreshIntervalMax = 86400
+	// CrlDisableRefresh will disable background refresh if set in `--tls_crl_refresh_interval`
+	CrlDisableRefresh = 0
 )
 
 // CRLConfig contains configuration related to certificate validation using CRL
@@ -104,6 +112,7 @@ type CRLConfig struct {
 	checkOnlyLeafCertificate bool
 	cacheSize                uint
 	cacheTime                time.Duration
+	refreshInterval          time.Duration
 	ClientAuthType           tls.ClientAuthType
 }
 
@@ -177,7 +186,25 @@ func NewCRLConfigByName(flags *flag.FlagSet, name string, namerFunc CLIParamName
 		}
 		crlCacheTime = uint(cacheTime)
 	}
-	return NewCRLConfig(crlURL, crlFromCert, crlCheckOnlyLeafCertificate, crlCacheSize, crlCacheTime)
+
+	refreshInterval := tlsCrlRefreshInterval
+	if isFlagSet(namerFunc(name, "refresh_interval", "crl"), flags) {
+		f := flags.Lookup(namerFunc(name, "refresh_interval", "crl"))
+		interval, err := strconv.ParseUint(f.Value.String(), 10, 64)
+		if err != nil {
+			log.WithField("value", f.Value.String).Fatalf("Can't cast %s to integer value", namerFunc(name, "refresh_interval", "crl"))
+		}
+		refreshInterval = uint(interval)
+	}
+
+	config, err := NewCRLConfig(crlURL, crlFromCert, crlCheckOnlyLeafCertificate, crlCacheSize, crlCacheTime)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.SetRefreshInterval(refreshInterval); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 // NewCRLConfig creates new CRLConfig
@@ -228,8 +255,22 @@ func (c *CRLConfig) UseCRL() bool {
 	return c.url != "" || c.fromCert != crlFromCertIgnore
 }
 
+// SetRefreshInterval sets interval in seconds of background refresh of used CRLs, they are refreshed earlier if their
+// next update time comes. Refreshed CRLs are used from cache regardless of `--tls_crl_cache_time`
+func (c *CRLConfig) SetRefreshInterval(refreshInterval uint) error {
+	if refreshInterval > CrlRefreshIntervalMax {
+		return ErrInvalidConfigCRLRefresh
+	}
+	c.refreshInterval = time.Second * time.Duration(refreshInterval)
+	return nil
+}
+
 func (c *CRLConfig) isCachingEnabled() bool {
-	return c.cacheTime != CrlDisableCacheTime && c.cacheSize != CrlDisableCacheSize
+	return c.cacheSize != CrlDisableCacheSize && (c.cacheTime != CrlDisableCacheTime || c.isRefreshEnabled())
+}
+
+func (c *CRLConfig) isRefreshEnabled() bool {
+	return c.refreshInterval != CrlDisableRefresh && c.cacheSize != CrlDisableCacheSize
 }
 
 // CRLClient is used to fetch CRL from some URL
@@ -306,6 +347,17 @@ type CRLCacheItem struct {
 	Fetched             time.Time                           // When this CRL was fetched and cached
 	CRL                 *pkix.CertificateList               // Parsed CRL itself
 	RevokedCertificates map[string]*pkix.RevokedCertificate // Copy of CRL.TBSCertList.RevokedCertificates with SerialNumber as key
+	// Delta CRL which entries are merged into RevokedCertificates, nil if CRL doesn't point to delta CRL
+	Delta *pkix.CertificateList
+}
+
+// NextUpdate returns time when CRL, or its delta CRL if it was applied, should be updated
+func (item *CRLCacheItem) NextUpdate() time.Time {
+	nextUpdate := item.CRL.TBSCertList.NextUpdate
+	if item.Delta != nil && item.Delta.TBSCertList.NextUpdate.Before(nextUpdate) {
+		nextUpdate = item.Delta.TBSCertList.NextUpdate
+	}
+	return nextUpdate
 }
 
 // CRLCache is used to store fetched CRLs to avoid downloading the same URL more than once,
@@ -366,51 +418,58 @@ type DefaultCRLVerifier struct {
 	Config CRLConfig
 	Client CRLClient
 	Cache  CRLCache
+	// Refresher refreshes used CRLs in background, may be nil
+	Refresher *CRLRefresher
 }
 
 // Tries to find cached CRL, fetches using v.Client if not found, checks the signature of CRL using issuerCert
 func (v DefaultCRLVerifier) getCachedOrFetch(url string, allowLocal bool, issuerCert *x509.Certificate) (*CRLCacheItem, error) {
 	// Try v.Cache first, but only if caching is enabled (cache time > 0)
 	if v.Config.isCachingEnabled() {
-		cacheItem, err := v.Cache.Get(v.Config.url)
+		cacheItem, err := v.Cache.Get(url)
 		if cacheItem != nil {
 			if err != nil {
 				// non-empty result + error, should never happen
 				return nil, err
 			}
 
-			if time.Now().Before(cacheItem.Fetched.Add(v.Config.cacheTime)) && time.Now().Before(cacheItem.CRL.TBSCertList.NextUpdate) {
+			// CRLs tracked by refresher are kept up to date in background, so cache time is not applied to them
+			fresh := v.Refresher != nil || time.Now().Before(cacheItem.Fetched.Add(v.Config.cacheTime))
+			if fresh && time.Now().Before(cacheItem.NextUpdate()) {
 				return cacheItem, nil
 			}
 		}
 	}
 
 	// Not found in cache (or the CRL was outdated), gotta fetch
-	rawCRL, err := v.Client.Fetch(url, allowLocal)
+	cacheItem, err := fetchCRL(v.Client, url, allowLocal, issuerCert)
 	if err != nil {
 		return nil, err
 	}
 
-	crl, err := x509.ParseCRL(rawCRL)
-	if err != nil {
-		log.WithError(err).Debugf("CRL: Cannot parse CRL from '%s'", url)
-		return nil, err
+	if v.Config.isCachingEnabled() {
+		v.Cache.Put(url, cacheItem)
+	}
+	if v.Refresher != nil {
+		v.Refresher.Track(url, allowLocal, issuerCert, cacheItem)
 	}
 
-	err = issuerCert.CheckCRLSignature(crl)
+	return cacheItem, nil
+}
+
+// fetchCRL fetches CRL using client, checks the signature of CRL using issuerCert and applies delta CRL if CRL
+// points to it
+func fetchCRL(client CRLClient, url string, allowLocal bool, issuerCert *x509.Certificate) (*CRLCacheItem, error) {
+	crl, err := fetchAndVerifyCRL(client, url, allowLocal, issuerCert)
 	if err != nil {
-		log.WithError(err).Warnf("CRL: Failed to check signature for CRL at %s", url)
+		crlFetchCounter.WithLabelValues(crlFetchStatusFailure).Inc()
 		return nil, err
 	}
 
-	if crl.TBSCertList.NextUpdate.Before(time.Now()) {
-		log.Warnf("CRL: CRL at %s is outdated", url)
-		return nil, ErrOutdatedCRL
-	}
-
 	// Cannot use big.Int as map key unfortunately, using string with hex-encoded serial instead
 	revokedCertificates := make(map[string]*pkix.RevokedCertificate, len(crl.TBSCertList.RevokedCertificates))
-	for _, cert := range crl.TBSCertList.RevokedCertificates {
+	for i := range crl.TBSCertList.RevokedCertificates {
+		cert := crl.TBSCertList.RevokedCertificates[i]
 		revokedCertificates[cert.SerialNumber.Text(SerialEncodeBase)] = &cert
 	}
 
@@ -423,13 +482,148 @@ func (v DefaultCRLVerifier) getCachedOrFetch(url string, allowLocal bool, issuer
 		RevokedCertificates: revokedCertificates,
 	}
 
-	if v.Config.isCachingEnabled() {
-		v.Cache.Put(url, cacheItem)
+	for _, deltaURL := range getFreshestCRLURLs(crl) {
+		// delta CRL is optional, complete CRL is used alone if delta CRL is unavailable
+		delta, err := fetchAndVerifyCRL(client, deltaURL, false, issuerCert)
+		if err == nil {
+			err = applyDeltaCRL(cacheItem, delta)
+		}
+		if err != nil {
+			crlFetchCounter.WithLabelValues(crlFetchStatusFailure).Inc()
+			log.WithError(err).WithField("url", deltaURL).Warnln("CRL: Cannot apply delta CRL, using complete CRL only")
+			continue
+		}
+		break
 	}
 
+	crlFetchCounter.WithLabelValues(crlFetchStatusSuccess).Inc()
+	crlLastUpdateGauge.WithLabelValues(url).Set(float64(cacheItem.Fetched.Unix()))
+	crlNextUpdateGauge.WithLabelValues(url).Set(float64(cacheItem.NextUpdate().Unix()))
 	return cacheItem, nil
 }
 
+// fetchAndVerifyCRL fetches and parses CRL, checks its signature and next update time
+func fetchAndVerifyCRL(client CRLClient, url string, allowLocal bool, issuerCert *x509.Certificate) (*pkix.CertificateList, error) {
+	rawCRL, err := client.Fetch(url, allowLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(rawCRL)
+	if err != nil {
+		log.WithError(err).Debugf("CRL: Cannot parse CRL from '%s'", url)
+		return nil, err
+	}
+
+	err = issuerCert.CheckCRLSignature(crl)
+	if err != nil {
+		log.WithError(err).Warnf("CRL: Failed to check signature for CRL at %s", url)
+		return nil, err
+	}
+
+	if crl.TBSCertList.NextUpdate.Before(time.Now()) {
+		log.Warnf("CRL: CRL at %s is outdated", url)
+		return nil, ErrOutdatedCRL
+	}
+	return crl, nil
+}
+
+// distributionPoint and distributionPointName are ASN.1 structures of CRL distribution points (RFC 5280 section
+// 4.2.1.13) used in id-ce-freshestCRL extension, like the ones used by crypto/x509
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+// crlReasonRemoveFromCRL is reason code of delta CRL entry which unrevokes certificate from complete CRL, RFC 5280
+// section 5.3.1
+const crlReasonRemoveFromCRL = 8
+
+// getFreshestCRLURLs returns URLs of delta CRLs from id-ce-freshestCRL extension of complete CRL
+func getFreshestCRLURLs(crl *pkix.CertificateList) []string {
+	var urls []string
+	for _, extension := range crl.TBSCertList.Extensions {
+		if extension.Id.String() != "2.5.29.46" {
+			continue
+		}
+		var points []distributionPoint
+		if _, err := asn1.Unmarshal(extension.Value, &points); err != nil {
+			log.WithError(err).Warnln("CRL: Cannot parse freshest CRL extension")
+			return nil
+		}
+		for _, point := range points {
+			for _, name := range point.DistributionPoint.FullName {
+				// uniformResourceIdentifier of GeneralName
+				if name.Tag == 6 {
+					urls = append(urls, string(name.Bytes))
+				}
+			}
+		}
+	}
+	return urls
+}
+
+// getCRLNumber returns value of id-ce-cRLNumber (or id-ce-deltaCRLIndicator with corresponding oid) extension
+func getCRLNumber(crl *pkix.CertificateList, oid string) (*big.Int, bool) {
+	for _, extension := range crl.TBSCertList.Extensions {
+		if extension.Id.String() != oid {
+			continue
+		}
+		number := new(big.Int)
+		if _, err := asn1.Unmarshal(extension.Value, &number); err != nil {
+			return nil, false
+		}
+		return number, true
+	}
+	return nil, false
+}
+
+// applyDeltaCRL merges entries of delta CRL into revoked certificates of complete CRL from cacheItem. Delta CRL
+// should be issued for the same or earlier complete CRL and be newer than it
+func applyDeltaCRL(cacheItem *CRLCacheItem, delta *pkix.CertificateList) error {
+	crlNumber, ok := getCRLNumber(cacheItem.CRL, "2.5.29.20")
+	if !ok {
+		return ErrDeltaCRLNotApplicable
+	}
+	baseCRLNumber, ok := getCRLNumber(delta, "2.5.29.27")
+	if !ok || baseCRLNumber.Cmp(crlNumber) > 0 {
+		return ErrDeltaCRLNotApplicable
+	}
+	deltaCRLNumber, ok := getCRLNumber(delta, "2.5.29.20")
+	if !ok || deltaCRLNumber.Cmp(crlNumber) <= 0 {
+		return ErrDeltaCRLNotApplicable
+	}
+
+	for i := range delta.TBSCertList.RevokedCertificates {
+		cert := delta.TBSCertList.RevokedCertificates[i]
+		serial := cert.SerialNumber.Text(SerialEncodeBase)
+		removed := false
+		for _, extension := range cert.Extensions {
+			if extension.Id.String() != "2.5.29.21" {
+				continue
+			}
+			var reason asn1.Enumerated
+			if _, err := asn1.Unmarshal(extension.Value, &reason); err == nil && reason == crlReasonRemoveFromCRL {
+				removed = true
+			}
+		}
+		if removed {
+			delete(cacheItem.RevokedCertificates, serial)
+			continue
+		}
+		cacheItem.RevokedCertificates[serial] = &cert
+	}
+	delta.TBSCertList.RevokedCertificates = nil
+	cacheItem.Delta = delta
+	return nil
+}
+
 // Returns `nil` if certificate was not cound in CRL, returns error if it was there
 // or if there was unknown Object ID in revoked certificate extensions
 func checkCertWithCRL(cert *x509.Certificate, cacheItem *CRLCacheItem) error {
@@ -437,7 +631,7 @@ func checkCertWithCRL(cert *x509.Certificate, cacheItem *CRLCacheItem) error {
 		// For CRL v2 (RFC 5280 section 5.2), CRL issuers are REQUIRED to include
 		// the authority key identifier (Section 5.2.1) and the CRL number (Section 5.2.3).
 		// TODO handle all these extensions; this will require some refactoring:
-		//      create DB with revoked certificates, update it from delta CRL or rewrite from usual CRL;
+		//      create DB with revoked certificates, rewrite it from usual CRL (delta CRLs are merged on fetch);
 		//      these extensions cannot exist in older CRL v1 though
 		//      (like the one generated with `openssl ca -gencrl ...` without `-crlexts` option)
 		switch extension.Id.String() {
@@ -453,9 +647,13 @@ func checkCertWithCRL(cert *x509.Certificate, cacheItem *CRLCacheItem) error {
 		case "2.5.29.27":
 			// section 5.2.4, id-ce-deltaCRLIndicator
 			// > The delta CRL indicator is a critical CRL extension that identifies a CRL as being a delta CRL
-			log.WithField("oid", extension.Id.String()).Warnln("CRL: handling of CRL extension is not yet implemented")
+			// delta CRLs are applied only to complete CRLs which point to them with id-ce-freshestCRL
+			log.WithField("oid", extension.Id.String()).Warnln("CRL: delta CRL can't be used without complete CRL")
 			return ErrUnimplementedCRLExtension
 
+		case "2.5.29.46":
+			// section 5.2.6, id-ce-freshestCRL, delta CRL is applied on fetch
+
 		default:
 			if extension.Critical {
 				log.WithField("oid", extension.Id.String()).Warnln("CRL: Unable to process critical extension with unknown Object ID")
@@ -505,6 +703,10 @@ func checkCertWithCRL(cert *x509.Certificate, cacheItem *CRLCacheItem) error {
 		case "2.5.29.33":
 			// section 4.2.1.5, id-ce-policyMappings
 
+		// CRL entry extensions, section 5.3
+		case "2.5.29.21":
+			// section 5.3.1, id-ce-cRLReasons
+
 		default:
 			if extension.Critical {
 				log.WithField("oid", extension.Id.String()).Warnln("CRL: Unable to process critical extension with unknown Object ID")
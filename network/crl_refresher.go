@@ -0,0 +1,150 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+const (
+	// crlRefreshCheckInterval is max interval of checking whether tracked CRLs should be refreshed
+	crlRefreshCheckInterval = time.Second * 10
+	// crlRefreshRetryInterval is delay before next fetch of CRL after failure
+	crlRefreshRetryInterval = time.Minute
+)
+
+// crlRefreshTarget is CRL tracked by CRLRefresher
+type crlRefreshTarget struct {
+	allowLocal bool
+	issuer     *x509.Certificate
+	refreshAt  time.Time
+}
+
+// CRLRefresher fetches CRLs used by DefaultCRLVerifier in background and puts them into cache, so TLS handshakes
+// don't wait for CRL fetching. CRLs are refreshed every interval or earlier when their next update time comes
+type CRLRefresher struct {
+	client     CRLClient
+	cache      CRLCache
+	interval   time.Duration
+	maxTargets int
+	lock       sync.Mutex
+	targets    map[string]*crlRefreshTarget
+	runOnce    sync.Once
+}
+
+// NewCRLRefresher returns CRLRefresher which tracks up to maxTargets CRLs
+func NewCRLRefresher(client CRLClient, cache CRLCache, interval time.Duration, maxTargets uint) *CRLRefresher {
+	return &CRLRefresher{
+		client:     client,
+		cache:      cache,
+		interval:   interval,
+		maxTargets: int(maxTargets),
+		targets:    make(map[string]*crlRefreshTarget),
+	}
+}
+
+// Track adds fetched CRL to the list of refreshed ones. Refreshing is started on first call
+func (r *CRLRefresher) Track(url string, allowLocal bool, issuer *x509.Certificate, item *CRLCacheItem) {
+	r.lock.Lock()
+	target, ok := r.targets[url]
+	if !ok {
+		if len(r.targets) >= r.maxTargets {
+			r.lock.Unlock()
+			log.WithField("url", url).Debugln("CRL: Too many tracked CRLs, CRL will be fetched on demand")
+			return
+		}
+		target = &crlRefreshTarget{}
+		r.targets[url] = target
+	}
+	target.allowLocal = allowLocal
+	target.issuer = issuer
+	target.refreshAt = r.refreshTime(item)
+	r.lock.Unlock()
+
+	r.runOnce.Do(func() {
+		// verifiers live as long as the process, so refreshing is not stopped
+		go r.Run(context.Background())
+	})
+}
+
+// refreshTime returns time of next refresh of CRL
+func (r *CRLRefresher) refreshTime(item *CRLCacheItem) time.Time {
+	refreshAt := item.Fetched.Add(r.interval)
+	if nextUpdate := item.NextUpdate(); nextUpdate.Before(refreshAt) {
+		refreshAt = nextUpdate
+	}
+	return refreshAt
+}
+
+// Refresh fetches tracked CRLs which refresh time has come. Previously fetched CRL is left in cache if it fails
+func (r *CRLRefresher) Refresh() {
+	type dueTarget struct {
+		url string
+		crlRefreshTarget
+	}
+	now := time.Now()
+	var due []dueTarget
+	r.lock.Lock()
+	for url, target := range r.targets {
+		if !now.Before(target.refreshAt) {
+			due = append(due, dueTarget{url, *target})
+		}
+	}
+	r.lock.Unlock()
+
+	for _, target := range due {
+		item, err := fetchCRL(r.client, target.url, target.allowLocal, target.issuer)
+		r.lock.Lock()
+		if err != nil {
+			r.targets[target.url].refreshAt = time.Now().Add(crlRefreshRetryInterval)
+			r.lock.Unlock()
+			log.WithError(err).WithField("url", target.url).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSGeneral).
+				Warningln("CRL: Can't refresh CRL, previous one is used until its next update time")
+			continue
+		}
+		r.targets[target.url].refreshAt = r.refreshTime(item)
+		r.lock.Unlock()
+		if err := r.cache.Put(target.url, item); err != nil {
+			log.WithError(err).WithField("url", target.url).Warningln("CRL: Can't cache refreshed CRL")
+		}
+	}
+}
+
+// Run refreshes tracked CRLs until ctx is done. Should be called as goroutine
+func (r *CRLRefresher) Run(ctx context.Context) {
+	checkInterval := crlRefreshCheckInterval
+	if r.interval < checkInterval {
+		checkInterval = r.interval
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Refresh()
+		}
+	}
+}
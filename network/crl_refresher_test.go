@@ -0,0 +1,225 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	oidCRLReasons         = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidDeltaCRLIndicator  = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidFreshestCRL        = asn1.ObjectIdentifier{2, 5, 29, 46}
+	testDeltaCRLURL       = "http://127.0.0.1:1/delta.crl"
+	testCompleteCRLURL    = "http://127.0.0.1:1/complete.crl"
+	errTestCRLUnreachable = errors.New("unreachable")
+)
+
+// mapCRLClient returns CRLs by URL and counts fetches
+type mapCRLClient struct {
+	lock    sync.Mutex
+	crls    map[string][]byte
+	fetches int
+}
+
+func (c *mapCRLClient) Fetch(url string, allowLocal bool) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fetches++
+	crl, ok := c.crls[url]
+	if !ok {
+		return nil, errTestCRLUnreachable
+	}
+	return crl, nil
+}
+
+func (c *mapCRLClient) set(url string, crl []byte) {
+	c.lock.Lock()
+	c.crls[url] = crl
+	c.lock.Unlock()
+}
+
+func (c *mapCRLClient) fetchCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.fetches
+}
+
+func testRevokedCertificate(t *testing.T, serial int64, reason asn1.Enumerated) pkix.RevokedCertificate {
+	revoked := pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Minute)}
+	if reason != 0 {
+		value, err := asn1.Marshal(reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revoked.Extensions = []pkix.Extension{{Id: oidCRLReasons, Value: value}}
+	}
+	return revoked
+}
+
+// newCRL returns DER encoded CRL with number, which is delta CRL if baseNumber > 0 or points to delta CRL if
+// deltaURL is set
+func (issuer *testOCSPIssuer) newCRL(t *testing.T, number, baseNumber int64, deltaURL string, nextUpdate time.Duration, revoked ...pkix.RevokedCertificate) []byte {
+	var extensions []pkix.Extension
+	if baseNumber > 0 {
+		value, err := asn1.Marshal(big.NewInt(baseNumber))
+		if err != nil {
+			t.Fatal(err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidDeltaCRLIndicator, Critical: true, Value: value})
+	}
+	if deltaURL != "" {
+		value, err := asn1.Marshal([]distributionPoint{{DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(deltaURL)}},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidFreshestCRL, Value: value})
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(number),
+		ThisUpdate:          time.Now().Add(-time.Minute),
+		NextUpdate:          time.Now().Add(nextUpdate),
+		RevokedCertificates: revoked,
+		ExtraExtensions:     extensions,
+	}, issuer.cert, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func TestDeltaCRL(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	client := &mapCRLClient{crls: map[string][]byte{
+		testCompleteCRLURL: issuer.newCRL(t, 5, 0, testDeltaCRLURL, time.Hour,
+			testRevokedCertificate(t, 2, 0), testRevokedCertificate(t, 3, 0)),
+		testDeltaCRLURL: issuer.newCRL(t, 6, 5, "", time.Minute*10,
+			testRevokedCertificate(t, 3, crlReasonRemoveFromCRL), testRevokedCertificate(t, 4, 1)),
+	}}
+
+	item, err := fetchCRL(client, testCompleteCRLURL, false, issuer.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Delta == nil {
+		t.Fatal("Expected applied delta CRL")
+	}
+	if item.NextUpdate() != item.Delta.TBSCertList.NextUpdate {
+		t.Fatal("Expected next update time of delta CRL")
+	}
+	for serial, expected := range map[int64]error{2: ErrCertWasRevoked, 3: nil, 4: ErrCertWasRevoked, 5: nil} {
+		if err := checkCertWithCRL(issuer.newCertificate(t, serial), item); err != expected {
+			t.Fatalf("Expected %v for serial %d, took %v", expected, serial, err)
+		}
+	}
+
+	// delta CRL is ignored if it's not newer than complete CRL
+	client.set(testDeltaCRLURL, issuer.newCRL(t, 5, 4, "", time.Minute*10, testRevokedCertificate(t, 4, 1)))
+	item, err = fetchCRL(client, testCompleteCRLURL, false, issuer.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Delta != nil {
+		t.Fatal("Expected ignored delta CRL")
+	}
+	if err := checkCertWithCRL(issuer.newCertificate(t, 4), item); err != nil {
+		t.Fatalf("Expected certificate unknown to complete CRL, took %v", err)
+	}
+
+	// delta CRL alone can't be used
+	deltaItem, err := fetchCRL(client, testDeltaCRLURL, false, issuer.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCertWithCRL(issuer.newCertificate(t, 5), deltaItem); err != ErrUnimplementedCRLExtension {
+		t.Fatalf("Expected ErrUnimplementedCRLExtension, took %v", err)
+	}
+}
+
+func TestCRLRefresher(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	template := issuer.newCertificate(t, 2)
+	template.CRLDistributionPoints = []string{testCompleteCRLURL}
+	chains := [][]*x509.Certificate{{template, issuer.cert}}
+	client := &mapCRLClient{crls: map[string][]byte{
+		testCompleteCRLURL: issuer.newCRL(t, 1, 0, "", time.Hour),
+	}}
+
+	config, err := NewCRLConfig("", CrlFromCertUseStr, true, CrlDefaultCacheSize, CrlDisableCacheTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SetRefreshInterval(CrlRefreshIntervalMax + 1); err != ErrInvalidConfigCRLRefresh {
+		t.Fatalf("Expected ErrInvalidConfigCRLRefresh, took %v", err)
+	}
+	if err := config.SetRefreshInterval(3600); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewLRUCRLCache(config.cacheSize)
+	refresher := NewCRLRefresher(client, cache, config.refreshInterval, config.cacheSize)
+	verifier := DefaultCRLVerifier{Config: *config, Client: client, Cache: cache, Refresher: refresher}
+
+	// tracked CRL is used from cache even though cache time is not set
+	for i := 0; i < 3; i++ {
+		if err := verifier.Verify(nil, chains); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches := client.fetchCount(); fetches != 1 {
+		t.Fatalf("Expected one fetch, took %d", fetches)
+	}
+
+	client.set(testCompleteCRLURL, issuer.newCRL(t, 2, 0, "", time.Hour, testRevokedCertificate(t, 2, 0)))
+	// not yet time to refresh
+	refresher.Refresh()
+	if err := verifier.Verify(nil, chains); err != nil {
+		t.Fatal(err)
+	}
+	refresher.lock.Lock()
+	refresher.targets[testCompleteCRLURL].refreshAt = time.Now()
+	refresher.lock.Unlock()
+	refresher.Refresh()
+	if err := verifier.Verify(nil, chains); !errors.Is(err, ErrCertWasRevoked) {
+		t.Fatalf("Expected ErrCertWasRevoked after refresh, took %v", err)
+	}
+
+	// failed refresh leaves previous CRL in use
+	client.set(testCompleteCRLURL, nil)
+	refresher.lock.Lock()
+	refresher.targets[testCompleteCRLURL].refreshAt = time.Now()
+	refresher.lock.Unlock()
+	refresher.Refresh()
+	if err := verifier.Verify(nil, chains); !errors.Is(err, ErrCertWasRevoked) {
+		t.Fatalf("Expected ErrCertWasRevoked with previous CRL, took %v", err)
+	}
+	refresher.lock.Lock()
+	retryAt := refresher.targets[testCompleteCRLURL].refreshAt
+	refresher.lock.Unlock()
+	if !retryAt.After(time.Now()) {
+		t.Fatal("Expected retry of failed refresh later")
+	}
+}
@@ -0,0 +1,272 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// DefaultJWTClientIDClaim name of JWT claim used as clientID by default
+const DefaultJWTClientIDClaim = "sub"
+
+const (
+	// jwksRefreshInterval limits how often JWKS reloaded from URL when token signed with unknown key
+	jwksRefreshInterval = time.Minute
+	jwksRequestTimeout  = time.Second * 10
+	maxJWKSSize         = 1 << 20
+)
+
+// Errors related to JWT authentication
+var (
+	ErrMissingJWT               = errors.New("request doesn't contain bearer token")
+	ErrInvalidJWT               = errors.New("invalid JWT")
+	ErrJWTKeyNotFound           = errors.New("no key to verify JWT signature")
+	ErrJWTExpirationMissing     = errors.New("JWT doesn't contain expiration time")
+	ErrInvalidJWTClientIDClaim  = errors.New("JWT doesn't contain clientID claim with non-empty string value")
+	ErrEmptyJWKS                = errors.New("JWKS doesn't contain keys")
+	ErrNotPublicJWK             = errors.New("JWKS should contain only public RSA, ECDSA or Ed25519 keys")
+	ErrInvalidJWKSConfiguration = errors.New("only one of JWKS file or JWKS URL should be specified")
+	ErrJWTIssuerAudienceMissing = errors.New("JWT issuer and audience should be specified")
+)
+
+// JWTKeySet provides public keys used to verify signatures of JWT
+type JWTKeySet interface {
+	// Keys returns keys with keyID or all keys if keyID is empty
+	Keys(keyID string) []jose.JSONWebKey
+}
+
+// NewJWTKeySet returns JWTKeySet loaded from file or URL, only one of them should be specified
+func NewJWTKeySet(url, path string) (JWTKeySet, error) {
+	if (url == "") == (path == "") {
+		return nil, ErrInvalidJWKSConfiguration
+	}
+	if path != "" {
+		return NewJWTKeySetFromFile(path)
+	}
+	return NewRemoteJWTKeySet(url)
+}
+
+// StaticJWTKeySet JWTKeySet with keys loaded once
+type StaticJWTKeySet struct {
+	keys jose.JSONWebKeySet
+}
+
+// NewStaticJWTKeySet returns StaticJWTKeySet with keys from JSON encoded JWKS
+func NewStaticJWTKeySet(data []byte) (*StaticJWTKeySet, error) {
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticJWTKeySet{keys: keys}, nil
+}
+
+// NewJWTKeySetFromFile returns StaticJWTKeySet with keys from JWKS file
+func NewJWTKeySetFromFile(path string) (*StaticJWTKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticJWTKeySet(data)
+}
+
+// Keys returns keys with keyID or all keys if keyID is empty
+func (keySet *StaticJWTKeySet) Keys(keyID string) []jose.JSONWebKey {
+	return filterJWKS(keySet.keys, keyID)
+}
+
+// RemoteJWTKeySet JWTKeySet with keys loaded from URL, like jwks_uri of OpenID Connect provider. Keys reloaded
+// when token signed with unknown key to support key rotation on provider's side
+type RemoteJWTKeySet struct {
+	url    string
+	client *http.Client
+	// lock protects keys and lastUpdate, it isn't held during requests to provider
+	lock       sync.Mutex
+	keys       jose.JSONWebKeySet
+	lastUpdate time.Time
+	// refreshGroup deduplicates concurrent refreshes of keys
+	refreshGroup singleflight.Group
+}
+
+// NewRemoteJWTKeySet returns RemoteJWTKeySet with keys loaded from url
+func NewRemoteJWTKeySet(url string) (*RemoteJWTKeySet, error) {
+	keySet := &RemoteJWTKeySet{url: url, client: &http.Client{Timeout: jwksRequestTimeout}}
+	if err := keySet.refresh(); err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+func (keySet *RemoteJWTKeySet) refresh() error {
+	keySet.lock.Lock()
+	if time.Since(keySet.lastUpdate) <= jwksRefreshInterval {
+		// keys were refreshed after caller checked them
+		keySet.lock.Unlock()
+		return nil
+	}
+	// update time even on failures to not flood provider with requests
+	keySet.lastUpdate = time.Now()
+	keySet.lock.Unlock()
+	keys, err := keySet.fetch()
+	if err != nil {
+		return err
+	}
+	keySet.lock.Lock()
+	keySet.keys = keys
+	keySet.lock.Unlock()
+	return nil
+}
+
+func (keySet *RemoteJWTKeySet) fetch() (jose.JSONWebKeySet, error) {
+	response, err := keySet.client.Get(keySet.url)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return jose.JSONWebKeySet{}, fmt.Errorf("unexpected status code of JWKS response: %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxJWKSSize))
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+	return parseJWKS(data)
+}
+
+// cachedKeys returns loaded keys with keyID and true if there are no such keys and they may be refreshed
+func (keySet *RemoteJWTKeySet) cachedKeys(keyID string) ([]jose.JSONWebKey, bool) {
+	keySet.lock.Lock()
+	defer keySet.lock.Unlock()
+	keys := filterJWKS(keySet.keys, keyID)
+	return keys, len(keys) == 0 && time.Since(keySet.lastUpdate) > jwksRefreshInterval
+}
+
+// Keys returns keys with keyID or all keys if keyID is empty. Tokens with known keys aren't blocked while keys are
+// refreshed for unknown one, and tokens with unknown keys wait for the same refresh
+func (keySet *RemoteJWTKeySet) Keys(keyID string) []jose.JSONWebKey {
+	keys, shouldRefresh := keySet.cachedKeys(keyID)
+	if !shouldRefresh {
+		return keys
+	}
+	_, err, _ := keySet.refreshGroup.Do(keySet.url, func() (interface{}, error) {
+		return nil, keySet.refresh()
+	})
+	if err != nil {
+		log.WithError(err).WithField("url", keySet.url).Warningln("Can't refresh JWKS")
+	}
+	keys, _ = keySet.cachedKeys(keyID)
+	return keys
+}
+
+func parseJWKS(data []byte) (jose.JSONWebKeySet, error) {
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return keys, err
+	}
+	if len(keys.Keys) == 0 {
+		return keys, ErrEmptyJWKS
+	}
+	for _, key := range keys.Keys {
+		switch key.Key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			// symmetric keys allow to sign tokens and private keys shouldn't be shared with AcraTranslator
+			return keys, ErrNotPublicJWK
+		}
+	}
+	return keys, nil
+}
+
+func filterJWKS(keys jose.JSONWebKeySet, keyID string) []jose.JSONWebKey {
+	if keyID == "" {
+		return keys.Keys
+	}
+	return keys.Key(keyID)
+}
+
+// JWTClientIDExtractor validates JWT signature with registered claims and returns clientID from configured claim
+type JWTClientIDExtractor struct {
+	keySet   JWTKeySet
+	issuer   string
+	audience string
+	claim    string
+}
+
+// NewJWTClientIDExtractor returns new JWTClientIDExtractor. Tokens should be issued by issuer for audience, otherwise
+// tokens of the same provider issued for other services would be accepted, so ErrJWTIssuerAudienceMissing returned if
+// they are empty. Value of claim used as clientID, DefaultJWTClientIDClaim used if claim is empty
+func NewJWTClientIDExtractor(keySet JWTKeySet, issuer, audience, claim string) (*JWTClientIDExtractor, error) {
+	if issuer == "" || audience == "" {
+		return nil, ErrJWTIssuerAudienceMissing
+	}
+	if claim == "" {
+		claim = DefaultJWTClientIDClaim
+	}
+	return &JWTClientIDExtractor{keySet: keySet, issuer: issuer, audience: audience, claim: claim}, nil
+}
+
+// ExtractClientID validates token and returns clientID from its claim
+func (extractor *JWTClientIDExtractor) ExtractClientID(token string) ([]byte, error) {
+	parsedToken, err := jwt.ParseSigned(token)
+	if err != nil || len(parsedToken.Headers) != 1 {
+		return nil, ErrInvalidJWT
+	}
+	header := parsedToken.Headers[0]
+	keys := extractor.keySet.Keys(header.KeyID)
+	if len(keys) == 0 {
+		return nil, ErrJWTKeyNotFound
+	}
+	var claims jwt.Claims
+	var customClaims map[string]interface{}
+	verified := false
+	for _, key := range keys {
+		if (key.Algorithm != "" && key.Algorithm != header.Algorithm) || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		if err := parsedToken.Claims(key, &claims, &customClaims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidJWT
+	}
+	if claims.Expiry == nil {
+		return nil, ErrJWTExpirationMissing
+	}
+	expected := jwt.Expected{Issuer: extractor.issuer, Audience: jwt.Audience{extractor.audience}, Time: time.Now()}
+	if err := claims.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidJWT, err)
+	}
+	clientID, ok := customClaims[extractor.claim].(string)
+	if !ok || clientID == "" {
+		return nil, ErrInvalidJWTClientIDClaim
+	}
+	return []byte(clientID), nil
+}
+
+// GetBearerToken returns token from value of Authorization header
+func GetBearerToken(authorization string) (string, error) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", ErrMissingJWT
+	}
+	token := strings.TrimSpace(authorization[len(prefix):])
+	if token == "" {
+		return "", ErrMissingJWT
+	}
+	return token, nil
+}
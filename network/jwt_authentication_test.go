@@ -0,0 +1,241 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testJWTClaims struct {
+	jwt.Claims
+	ClientID string `json:"client_id,omitempty"`
+}
+
+func newTestJWK(t *testing.T, keyID string) (*ecdsa.PrivateKey, []byte) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &privateKey.PublicKey, KeyID: keyID, Algorithm: string(jose.ES256), Use: "sig"}}}
+	jwks, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, jwks
+}
+
+func newTestJWT(t *testing.T, privateKey *ecdsa.PrivateKey, keyID string, claims testJWTClaims) string {
+	options := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTClientIDExtractor(t *testing.T) {
+	privateKey, jwks := newTestJWK(t, "key1")
+	otherPrivateKey, _ := newTestJWK(t, "key1")
+	keySet, err := NewStaticJWTKeySet(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor, err := NewJWTClientIDExtractor(keySet, "issuer", "acra-translator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens of the same provider issued for other services shouldn't be accepted
+	for _, expected := range [][2]string{{"", "acra-translator"}, {"issuer", ""}, {"", ""}} {
+		if _, err := NewJWTClientIDExtractor(keySet, expected[0], expected[1], ""); err != ErrJWTIssuerAudienceMissing {
+			t.Fatalf("Expected %v for %q, took %v", ErrJWTIssuerAudienceMissing, expected, err)
+		}
+	}
+
+	now := time.Now()
+	validClaims := func() testJWTClaims {
+		return testJWTClaims{Claims: jwt.Claims{
+			Subject:  "client",
+			Issuer:   "issuer",
+			Audience: jwt.Audience{"acra-translator"},
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}, ClientID: "custom_client"}
+	}
+	clientID, err := extractor.ExtractClientID(newTestJWT(t, privateKey, "key1", validClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientID, []byte("client")) {
+		t.Fatalf("Expected clientID from sub claim, took %s", clientID)
+	}
+
+	customClaimExtractor, err := NewJWTClientIDExtractor(keySet, "issuer", "acra-translator", "client_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID, err = customClaimExtractor.ExtractClientID(newTestJWT(t, privateKey, "key1", validClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientID, []byte("custom_client")) {
+		t.Fatalf("Expected clientID from custom claim, took %s", clientID)
+	}
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "other"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.Audience{"other"}
+	withoutIssuer := validClaims()
+	withoutIssuer.Issuer = ""
+	withoutAudience := validClaims()
+	withoutAudience.Audience = nil
+	expired := validClaims()
+	expired.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+	withoutExpiry := validClaims()
+	withoutExpiry.Expiry = nil
+	withoutSubject := validClaims()
+	withoutSubject.Subject = ""
+	testcases := []struct {
+		token string
+		err   error
+	}{
+		{"invalid token", ErrInvalidJWT},
+		{newTestJWT(t, otherPrivateKey, "key1", validClaims()), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "unknown", validClaims()), ErrJWTKeyNotFound},
+		{newTestJWT(t, privateKey, "key1", wrongIssuer), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "key1", wrongAudience), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "key1", withoutIssuer), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "key1", withoutAudience), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "key1", expired), ErrInvalidJWT},
+		{newTestJWT(t, privateKey, "key1", withoutExpiry), ErrJWTExpirationMissing},
+		{newTestJWT(t, privateKey, "key1", withoutSubject), ErrInvalidJWTClientIDClaim},
+	}
+	for i, testcase := range testcases {
+		if _, err := extractor.ExtractClientID(testcase.token); !errors.Is(err, testcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	privateKey, _ := newTestJWK(t, "key1")
+	testcases := []struct {
+		keys jose.JSONWebKeySet
+		err  error
+	}{
+		{jose.JSONWebKeySet{}, ErrEmptyJWKS},
+		{jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: privateKey, KeyID: "private"}}}, ErrNotPublicJWK},
+		{jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: []byte("symmetric key"), KeyID: "symmetric"}}}, ErrNotPublicJWK},
+	}
+	for i, testcase := range testcases {
+		data, err := json.Marshal(testcase.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewStaticJWTKeySet(data); err != testcase.err {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+	}
+	if _, err := NewJWTKeySet("", ""); err != ErrInvalidJWKSConfiguration {
+		t.Fatalf("Expected %v, took %v", ErrInvalidJWKSConfiguration, err)
+	}
+}
+
+func TestRemoteJWTKeySet(t *testing.T) {
+	privateKey, jwks := newTestJWK(t, "key1")
+	var requestCount int32
+	refreshStarted := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// first request loads keys on start, refreshes wait for unblock
+		if atomic.AddInt32(&requestCount, 1) > 1 {
+			refreshStarted <- struct{}{}
+			<-unblock
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	keySet, err := NewJWTKeySet(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := keySet.Keys("key1"); len(keys) != 1 {
+		t.Fatalf("Expected one key, took %d", len(keys))
+	}
+	extractor, err := NewJWTClientIDExtractor(keySet, "issuer", "acra-translator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := newTestJWT(t, privateKey, "key1", testJWTClaims{Claims: jwt.Claims{Subject: "client", Issuer: "issuer",
+		Audience: jwt.Audience{"acra-translator"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+	if _, err := extractor.ExtractClientID(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// refresh for unknown keys doesn't block tokens with known keys, concurrent refreshes make one request
+	keySet.(*RemoteJWTKeySet).lastUpdate = time.Now().Add(-jwksRefreshInterval * 2)
+	refreshed := make(chan int, 3)
+	for i := 0; i < cap(refreshed); i++ {
+		go func() {
+			refreshed <- len(keySet.Keys("unknown"))
+		}()
+	}
+	<-refreshStarted
+	result := make(chan error)
+	go func() {
+		_, err := extractor.ExtractClientID(token)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Token with known key is blocked by refresh of keys")
+	}
+	close(unblock)
+	for i := 0; i < cap(refreshed); i++ {
+		if keys := <-refreshed; keys != 0 {
+			t.Fatalf("Expected no unknown keys, took %d", keys)
+		}
+	}
+	if count := atomic.LoadInt32(&requestCount); count != 2 {
+		t.Fatalf("Expected one request to refresh keys, took %d", count-1)
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	testcases := []struct {
+		header string
+		token  string
+		err    error
+	}{
+		{"Bearer token", "token", nil},
+		{"bearer token ", "token", nil},
+		{"Bearer ", "", ErrMissingJWT},
+		{"Basic dXNlcjpwYXNz", "", ErrMissingJWT},
+		{"", "", ErrMissingJWT},
+	}
+	for _, testcase := range testcases {
+		token, err := GetBearerToken(testcase.header)
+		if err != testcase.err || token != testcase.token {
+			t.Fatalf("Expected %q, %v, took %q, %v", testcase.token, testcase.err, token, err)
+		}
+	}
+}
@@ -30,16 +30,22 @@ import (
 	url_ "net/url"
 	"strconv"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Errors returned by OCSP verifier
 var (
-	ErrInvalidConfigOCSPRequired   = errors.New("invalid `ocsp_required` value")
-	ErrInvalidConfigOCSPFromCert   = errors.New("invalid `ocsp_from_cert` value")
-	ErrInvalidConfigAllRequiresURL = errors.New("when passing `--tls_ocsp_required=" + OcspRequiredGoodStr + "`, URL is mandatory")
-	ErrOCSPRequiredAllButGotError  = errors.New("cannot query OCSP server, but --tls_ocsp_required=" + OcspRequiredGoodStr + " was passed")
-	ErrOCSPUnknownCertificate      = errors.New("OCSP server doesn't know about certificate")
-	ErrOCSPNoConfirms              = errors.New("none of OCSP servers confirmed the certificate")
+	ErrInvalidConfigOCSPRequired    = errors.New("invalid `ocsp_required` value")
+	ErrInvalidConfigOCSPFromCert    = errors.New("invalid `ocsp_from_cert` value")
+	ErrInvalidConfigAllRequiresURL  = errors.New("when passing `--tls_ocsp_required=" + OcspRequiredGoodStr + "`, URL is mandatory")
+	ErrOCSPRequiredAllButGotError   = errors.New("cannot query OCSP server, but --tls_ocsp_required=" + OcspRequiredGoodStr + " was passed")
+	ErrOCSPUnknownCertificate       = errors.New("OCSP server doesn't know about certificate")
+	ErrOCSPNoConfirms               = errors.New("none of OCSP servers confirmed the certificate")
+	ErrInvalidConfigOCSPFailureMode = errors.New("invalid `ocsp_failure_mode` value")
+	ErrInvalidConfigOCSPSoftFail    = errors.New("`--tls_ocsp_failure_mode=" + OcspFailureModeSoftStr + "` can't be used with `--tls_ocsp_required=" + OcspRequiredGoodStr + "`")
+	ErrInvalidConfigOCSPCacheSize   = errors.New("invalid `tls_ocsp_cache_size` value")
+	ErrInvalidConfigOCSPCacheTime   = errors.New("invalid `tls_ocsp_cache_time` value")
 )
 
 // Possible values for flag `--tls_ocsp_required`
@@ -112,12 +118,43 @@ const (
 	ocspFromCertIgnore
 )
 
+// Possible values for flag `--tls_ocsp_failure_mode`
+const (
+	// Deny certificate if none of OCSP servers confirmed it, including the case when all of them are unreachable
+	OcspFailureModeHardStr = "hard"
+	// Allow certificate if all OCSP servers are unreachable, revoked and unknown certificates are still denied
+	OcspFailureModeSoftStr = "soft"
+)
+
+// OcspFailureModeValuesList contains all possible values for flag `--tls_ocsp_failure_mode`
+var OcspFailureModeValuesList = []string{
+	OcspFailureModeHardStr,
+	OcspFailureModeSoftStr,
+}
+
+const (
+	// OcspDefaultCacheSize is default value for `--tls_ocsp_cache_size`
+	OcspDefaultCacheSize = 1024
+	// OcspCacheSizeMax is max value for `--tls_ocsp_cache_size`
+	OcspCacheSizeMax = 1_000_000
+	// OcspDisableCacheSize will disable caching if set in `--tls_ocsp_cache_size`
+	OcspDisableCacheSize = 0
+	// OcspCacheTimeMax is max value for `--tls_ocsp_cache_time`
+	OcspCacheTimeMax = 86400
+	// OcspDisableCacheTime will disable caching if set in `--tls_ocsp_cache_time`
+	OcspDisableCacheTime = 0
+)
+
 // OCSPConfig contains configuration related to certificate validation using OCSP
 type OCSPConfig struct {
 	url                      string
 	required                 int // ocspRequired*
 	fromCert                 int // ocspFromCert*
 	checkOnlyLeafCertificate bool
+	softFail                 bool
+	cacheSize                uint
+	cacheTime                time.Duration
+	cacheDir                 string
 	ClientAuthType           tls.ClientAuthType
 }
 
@@ -159,7 +196,47 @@ func NewOCSPConfigByName(flags *flag.FlagSet, name string, namerFunc CLIParamNam
 		checkOnlyLeafCert = v
 	}
 
-	return NewOCSPConfig(url, required, fromCert, checkOnlyLeafCert)
+	failureMode := tlsOcspFailureMode
+	if isFlagSet(namerFunc(name, "failure_mode", "ocsp"), flags) {
+		failureMode = flags.Lookup(namerFunc(name, "failure_mode", "ocsp")).Value.String()
+	}
+
+	cacheSize := tlsOcspCacheSize
+	if isFlagSet(namerFunc(name, "cache_size", "ocsp"), flags) {
+		f := flags.Lookup(namerFunc(name, "cache_size", "ocsp"))
+		size, err := strconv.ParseUint(f.Value.String(), 10, 64)
+		if err != nil {
+			log.WithField("value", f.Value.String).Fatalf("Can't cast %s to unsigned integer value", namerFunc(name, "cache_size", "ocsp"))
+		}
+		cacheSize = uint(size)
+	}
+
+	cacheTime := tlsOcspCacheTime
+	if isFlagSet(namerFunc(name, "cache_time", "ocsp"), flags) {
+		f := flags.Lookup(namerFunc(name, "cache_time", "ocsp"))
+		value, err := strconv.ParseUint(f.Value.String(), 10, 64)
+		if err != nil {
+			log.WithField("value", f.Value.String).Fatalf("Can't cast %s to unsigned integer value", namerFunc(name, "cache_time", "ocsp"))
+		}
+		cacheTime = uint(value)
+	}
+
+	cacheDir := tlsOcspCacheDir
+	if isFlagSet(namerFunc(name, "cache_dir", "ocsp"), flags) {
+		cacheDir = flags.Lookup(namerFunc(name, "cache_dir", "ocsp")).Value.String()
+	}
+
+	config, err := NewOCSPConfig(url, required, fromCert, checkOnlyLeafCert)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.SetFailureMode(failureMode); err != nil {
+		return nil, err
+	}
+	if err := config.SetCache(cacheSize, cacheTime, cacheDir); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 // NewOCSPConfig creates new OCSPConfig
@@ -222,6 +299,42 @@ func NewOCSPConfig(url, required, fromCert string, checkOnlyLeafCertificate bool
 	}, nil
 }
 
+// SetFailureMode sets whether certificates are allowed when all OCSP servers are unreachable
+func (c *OCSPConfig) SetFailureMode(mode string) error {
+	switch mode {
+	case OcspFailureModeHardStr:
+		c.softFail = false
+	case OcspFailureModeSoftStr:
+		if c.required == ocspRequiredGood {
+			return ErrInvalidConfigOCSPSoftFail
+		}
+		c.softFail = true
+		log.Debugln("OCSP: Allowing certificates when OCSP servers are unreachable")
+	default:
+		return ErrInvalidConfigOCSPFailureMode
+	}
+	return nil
+}
+
+// SetCache sets how many OCSP responses are cached in memory and for how long in seconds. Responses are also stored
+// in cacheDir if it is not empty, so they survive restarts
+func (c *OCSPConfig) SetCache(cacheSize, cacheTime uint, cacheDir string) error {
+	if cacheSize > OcspCacheSizeMax {
+		return ErrInvalidConfigOCSPCacheSize
+	}
+	if cacheTime > OcspCacheTimeMax {
+		return ErrInvalidConfigOCSPCacheTime
+	}
+	c.cacheSize = cacheSize
+	c.cacheTime = time.Duration(cacheTime) * time.Second
+	c.cacheDir = cacheDir
+	return nil
+}
+
+func (c *OCSPConfig) isCachingEnabled() bool {
+	return c.cacheTime != OcspDisableCacheTime && c.cacheSize != OcspDisableCacheSize
+}
+
 // UseOCSP returns true if verification via OCSP is enabled
 func (c *OCSPConfig) UseOCSP() bool {
 	if c == nil {
@@ -283,6 +396,57 @@ func (c DefaultOCSPClient) Query(commonName string, clientCert, issuerCert *x509
 type DefaultOCSPVerifier struct {
 	Config OCSPConfig
 	Client OCSPClient
+	Cache  OCSPCache
+}
+
+// query returns cached response which is still valid or queries OCSP server and caches its response
+func (v DefaultOCSPVerifier) query(cert, issuer *x509.Certificate, url string) (*ocsp.Response, error) {
+	useCache := v.Cache != nil && v.Config.isCachingEnabled()
+	key := ocspCacheKey(url, cert, issuer)
+	if useCache {
+		if response := v.getCached(key, cert, issuer); response != nil {
+			ocspCacheHitsCounter.Inc()
+			return response, nil
+		}
+	}
+	timer := prometheus.NewTimer(ocspRequestDurationHistogram)
+	response, err := v.Client.Query(cert.Issuer.CommonName, cert, issuer, url)
+	timer.ObserveDuration()
+	if err != nil {
+		ocspRequestsCounter.WithLabelValues(ocspStatusError).Inc()
+		return nil, err
+	}
+	ocspRequestsCounter.WithLabelValues(ocspStatusLabel(response.Status)).Inc()
+	if useCache && !response.NextUpdate.IsZero() {
+		if err := v.Cache.Put(key, &OCSPCacheItem{Fetched: time.Now(), Response: response}); err != nil {
+			log.WithError(err).Warnln("OCSP: Can't cache response")
+		}
+	}
+	return response, nil
+}
+
+// getCached returns cached response if it is not expired
+func (v DefaultOCSPVerifier) getCached(key string, cert, issuer *x509.Certificate) *ocsp.Response {
+	cacheItem, err := v.Cache.Get(key)
+	if err != nil || cacheItem == nil {
+		return nil
+	}
+	if cacheItem.Response == nil {
+		// item loaded from disk contains only raw response
+		response, err := ocsp.ParseResponseForCert(cacheItem.Raw, cert, issuer)
+		if err != nil {
+			log.WithError(err).Debugln("OCSP: Can't parse cached response")
+			v.Cache.Remove(key)
+			return nil
+		}
+		cacheItem = &OCSPCacheItem{Fetched: cacheItem.Fetched, Raw: cacheItem.Raw, Response: response}
+		v.Cache.Put(key, cacheItem)
+	}
+	now := time.Now()
+	if now.After(cacheItem.Fetched.Add(v.Config.cacheTime)) || now.After(cacheItem.Response.NextUpdate) {
+		return nil
+	}
+	return cacheItem.Response
 }
 
 // ocspServerToCheck is used to plan OCSP requests
@@ -325,6 +489,8 @@ func (v DefaultOCSPVerifier) verifyCertWithIssuer(cert, issuer *x509.Certificate
 	queriedOCSPs := make(map[string]struct{})
 
 	confirms := 0
+	// attempts and failures count queried servers and ones which didn't respond, used for soft-fail
+	attempts, failures := 0, 0
 
 	for _, serverToCheck := range serversToCheck {
 		log.Debugf("OCSP: Trying server %s", serverToCheck.url)
@@ -334,8 +500,10 @@ func (v DefaultOCSPVerifier) verifyCertWithIssuer(cert, issuer *x509.Certificate
 			continue
 		}
 
-		response, err := v.Client.Query(cert.Issuer.CommonName, cert, issuer, serverToCheck.url)
+		attempts++
+		response, err := v.query(cert, issuer, serverToCheck.url)
 		if err != nil {
+			failures++
 			log.WithError(err).WithField("url", serverToCheck.url).Warnln("Cannot query OCSP server")
 			log.WithError(err).WithField("url", serverToCheck.url).
 				Infoln(OCSPCheckErrorSuggestion)
@@ -382,6 +550,10 @@ func (v DefaultOCSPVerifier) verifyCertWithIssuer(cert, issuer *x509.Certificate
 	}
 
 	if len(serversToCheck) > 0 && confirms == 0 {
+		if v.Config.softFail && attempts > 0 && failures == attempts {
+			log.WithField("serial", cert.SerialNumber.Text(16)).Warnln("OCSP: All servers are unreachable, certificate allowed according to soft-fail mode")
+			return nil
+		}
 		return ErrOCSPNoConfirms
 	}
 	return nil
@@ -0,0 +1,183 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrOCSPCacheKeyNotFound returned by OCSPCache if response is not cached
+var ErrOCSPCacheKeyNotFound = errors.New("cannot find cached OCSP response with given key")
+
+// OCSPCacheItem is OCSP response with time when it was received
+type OCSPCacheItem struct {
+	Fetched time.Time // When this response was received and cached
+	// Raw is DER encoded response, Response may be nil if it was not parsed yet
+	Raw      []byte
+	Response *ocsp.Response
+}
+
+// OCSPCache is used to store OCSP responses to avoid querying OCSP server on each TLS handshake
+type OCSPCache interface {
+	Get(key string) (*OCSPCacheItem, error)
+	Put(key string, value *OCSPCacheItem) error
+	Remove(key string) error
+}
+
+// ocspCacheKey returns key of response about cert from OCSP server with url
+func ocspCacheKey(url string, cert, issuer *x509.Certificate) string {
+	issuerHash := sha256.Sum256(issuer.Raw)
+	return url + "|" + hex.EncodeToString(issuerHash[:]) + "|" + cert.SerialNumber.Text(SerialEncodeBase)
+}
+
+// LRUOCSPCache is an implementation of OCSPCache that uses LRU cache inside
+type LRUOCSPCache struct {
+	cache lru.Cache
+	mutex sync.Mutex
+}
+
+// NewLRUOCSPCache creates new LRUOCSPCache, able to store at most maxEntries values
+func NewLRUOCSPCache(maxEntries uint) *LRUOCSPCache {
+	return &LRUOCSPCache{cache: lru.Cache{MaxEntries: int(maxEntries)}}
+}
+
+// Get tries to get OCSP response from cache, returns error if failed
+func (c *LRUOCSPCache) Get(key string) (*OCSPCacheItem, error) {
+	// lru.Cache.Get moves item to front of the list, so exclusive lock is required
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.cache.Get(key)
+	if ok {
+		value, _ := value.(*OCSPCacheItem)
+		return value, nil
+	}
+	return nil, ErrOCSPCacheKeyNotFound
+}
+
+// Put stores OCSP response in cache
+func (c *LRUOCSPCache) Put(key string, value *OCSPCacheItem) error {
+	c.mutex.Lock()
+	c.cache.Add(key, value)
+	c.mutex.Unlock()
+	log.Debugf("OCSP: LRU cache: inserted '%s'", key)
+	return nil
+}
+
+// Remove removes item from cache
+func (c *LRUOCSPCache) Remove(key string) error {
+	c.mutex.Lock()
+	c.cache.Remove(key)
+	c.mutex.Unlock()
+	return nil
+}
+
+// FileOCSPCache is an implementation of OCSPCache which stores responses in memory and in folder, so they are
+// available after restart. Modification time of file is time when response was received
+type FileOCSPCache struct {
+	memory *LRUOCSPCache
+	dir    string
+}
+
+// NewFileOCSPCache creates new FileOCSPCache which keeps at most maxEntries values in memory and all values in dir
+func NewFileOCSPCache(dir string, maxEntries uint) (*FileOCSPCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileOCSPCache{memory: NewLRUOCSPCache(maxEntries), dir: dir}, nil
+}
+
+func (c *FileOCSPCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".der")
+}
+
+// Get returns OCSP response from memory or from file with not parsed response
+func (c *FileOCSPCache) Get(key string) (*OCSPCacheItem, error) {
+	if item, err := c.memory.Get(key); err == nil {
+		return item, nil
+	}
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, ErrOCSPCacheKeyNotFound
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &OCSPCacheItem{Fetched: info.ModTime(), Raw: raw}, nil
+}
+
+// Put stores OCSP response in memory and in file
+func (c *FileOCSPCache) Put(key string, value *OCSPCacheItem) error {
+	if err := c.memory.Put(key, value); err != nil {
+		return err
+	}
+	raw := value.Raw
+	if raw == nil && value.Response != nil {
+		raw = value.Response.Raw
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	path := c.path(key)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0600); err != nil {
+		return err
+	}
+	// keep time of receiving to not prolong lifetime of response on rewrite
+	if err := os.Chtimes(tmpPath, value.Fetched, value.Fetched); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Remove removes response from memory and file
+func (c *FileOCSPCache) Remove(key string) error {
+	c.memory.Remove(key)
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NewOCSPCache returns cache according to config or nil if caching is disabled
+func NewOCSPCache(config *OCSPConfig) (OCSPCache, error) {
+	if !config.isCachingEnabled() {
+		return nil, nil
+	}
+	if config.cacheDir != "" {
+		cache, err := NewFileOCSPCache(config.cacheDir, config.cacheSize)
+		if err != nil {
+			return nil, err
+		}
+		return cache, nil
+	}
+	return NewLRUOCSPCache(config.cacheSize), nil
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type testOCSPIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestOCSPIssuer(t *testing.T) *testOCSPIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testOCSPIssuer{cert: cert, key: key}
+}
+
+func (issuer *testOCSPIssuer) newCertificate(t *testing.T, serial int64, ocspServers ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "acra-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   ocspServers,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.cert, &key.PublicKey, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (issuer *testOCSPIssuer) newResponse(t *testing.T, cert *x509.Certificate, status int) *ocsp.Response {
+	raw, err := ocsp.CreateResponse(issuer.cert, issuer.cert, ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ocsp.ParseResponseForCert(raw, cert, issuer.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// countingOCSPClient returns prepared response or error and counts queries
+type countingOCSPClient struct {
+	response *ocsp.Response
+	err      error
+	queries  int
+}
+
+func (c *countingOCSPClient) Query(commonName string, clientCert, issuerCert *x509.Certificate, ocspServerURL string) (*ocsp.Response, error) {
+	c.queries++
+	return c.response, c.err
+}
+
+func TestDefaultOCSPVerifierCache(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	cert := issuer.newCertificate(t, 2)
+	chains := [][]*x509.Certificate{{cert, issuer.cert}}
+	const url = "http://127.0.0.1:1"
+
+	for _, cacheDir := range []string{"", t.TempDir()} {
+		config, err := NewOCSPConfig(url, OcspRequiredDenyUnknownStr, OcspFromCertIgnoreStr, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := config.SetCache(16, 60, cacheDir); err != nil {
+			t.Fatal(err)
+		}
+		cache, err := NewOCSPCache(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &countingOCSPClient{response: issuer.newResponse(t, cert, ocsp.Good)}
+		verifier := DefaultOCSPVerifier{Config: *config, Client: client, Cache: cache}
+		for i := 0; i < 3; i++ {
+			if err := verifier.Verify(nil, chains); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if client.queries != 1 {
+			t.Fatalf("Expected one query with cached responses, took %d", client.queries)
+		}
+		if cacheDir == "" {
+			continue
+		}
+		// new cache with the same folder loads response saved by previous one
+		cache, err = NewOCSPCache(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = &countingOCSPClient{err: errors.New("unreachable")}
+		verifier = DefaultOCSPVerifier{Config: *config, Client: client, Cache: cache}
+		if err := verifier.Verify(nil, chains); err != nil {
+			t.Fatal(err)
+		}
+		if client.queries != 0 {
+			t.Fatalf("Expected response from folder without queries, took %d queries", client.queries)
+		}
+	}
+
+	// caching disabled by default
+	config, err := NewOCSPConfig(url, OcspRequiredDenyUnknownStr, OcspFromCertIgnoreStr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewOCSPCache(config)
+	if err != nil || cache != nil {
+		t.Fatalf("Expected disabled cache, took %v, %v", cache, err)
+	}
+}
+
+func TestOCSPConfigInvalidCacheAndFailureMode(t *testing.T) {
+	config, err := NewOCSPConfig("http://127.0.0.1:1", OcspRequiredGoodStr, OcspFromCertIgnoreStr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SetCache(OcspCacheSizeMax+1, 60, ""); err != ErrInvalidConfigOCSPCacheSize {
+		t.Fatalf("Expected ErrInvalidConfigOCSPCacheSize, took %v", err)
+	}
+	if err := config.SetCache(16, OcspCacheTimeMax+1, ""); err != ErrInvalidConfigOCSPCacheTime {
+		t.Fatalf("Expected ErrInvalidConfigOCSPCacheTime, took %v", err)
+	}
+	if err := config.SetFailureMode("unknown"); err != ErrInvalidConfigOCSPFailureMode {
+		t.Fatalf("Expected ErrInvalidConfigOCSPFailureMode, took %v", err)
+	}
+	if err := config.SetFailureMode(OcspFailureModeSoftStr); err != ErrInvalidConfigOCSPSoftFail {
+		t.Fatalf("Expected ErrInvalidConfigOCSPSoftFail, took %v", err)
+	}
+}
+
+func TestDefaultOCSPVerifierSoftFail(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	cert := issuer.newCertificate(t, 2)
+	chains := [][]*x509.Certificate{{cert, issuer.cert}}
+	config, err := NewOCSPConfig("http://127.0.0.1:1", OcspRequiredDenyUnknownStr, OcspFromCertIgnoreStr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := DefaultOCSPVerifier{Config: *config, Client: &countingOCSPClient{err: errors.New("unreachable")}}
+	if err := verifier.Verify(nil, chains); err != ErrOCSPNoConfirms {
+		t.Fatalf("Expected ErrOCSPNoConfirms in hard-fail mode, took %v", err)
+	}
+
+	if err := config.SetFailureMode(OcspFailureModeSoftStr); err != nil {
+		t.Fatal(err)
+	}
+	verifier = DefaultOCSPVerifier{Config: *config, Client: &countingOCSPClient{err: errors.New("unreachable")}}
+	if err := verifier.Verify(nil, chains); err != nil {
+		t.Fatalf("Expected allowed certificate in soft-fail mode, took %v", err)
+	}
+	// revoked certificate denied in soft-fail mode too
+	verifier = DefaultOCSPVerifier{Config: *config, Client: &countingOCSPClient{response: issuer.newResponse(t, cert, ocsp.Revoked)}}
+	if err := verifier.Verify(nil, chains); err != ErrCertWasRevoked {
+		t.Fatalf("Expected ErrCertWasRevoked, took %v", err)
+	}
+}
+
+func TestOCSPStapler(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	cert := issuer.newCertificate(t, 2, "http://127.0.0.1:1")
+	certificate := tls.Certificate{Certificate: [][]byte{cert.Raw, issuer.cert.Raw}}
+	client := &countingOCSPClient{response: issuer.newResponse(t, cert, ocsp.Good)}
+
+	if _, err := NewOCSPStapler(&tls.Config{}, client); err != ErrOCSPStaplingNoCertificate {
+		t.Fatalf("Expected ErrOCSPStaplingNoCertificate, took %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	stapler, err := NewOCSPStapler(config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stapler.ApplyToConfig(config)
+
+	stapled, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stapled.OCSPStaple != nil {
+		t.Fatal("Expected certificate without staple before refresh")
+	}
+	if err := stapler.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// response is fresh, so second refresh doesn't query server
+	if err := stapler.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if client.queries != 1 {
+		t.Fatalf("Expected one query, took %d", client.queries)
+	}
+	stapled, err = config.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stapled.OCSPStaple) == 0 {
+		t.Fatal("Expected stapled OCSP response")
+	}
+
+	// certificate without issuer in chain can't be stapled
+	stapler, err = NewOCSPStapler(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}}}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stapler.Refresh(); err != ErrOCSPStaplingNoIssuer {
+		t.Fatalf("Expected ErrOCSPStaplingNoIssuer, took %v", err)
+	}
+
+	// revoked certificate is not stapled
+	client = &countingOCSPClient{response: issuer.newResponse(t, cert, ocsp.Revoked)}
+	stapler, err = NewOCSPStapler(&tls.Config{Certificates: []tls.Certificate{certificate}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stapler.Refresh(); err != ErrOCSPStaplingNotGood {
+		t.Fatalf("Expected ErrOCSPStaplingNotGood, took %v", err)
+	}
+}
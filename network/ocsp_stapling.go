@@ -0,0 +1,184 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+const (
+	// ocspStaplingCheckInterval is interval of checking whether stapled response should be refreshed
+	ocspStaplingCheckInterval = time.Minute
+	// ocspStaplingRetryInterval is delay before next request after failure or response without next update time
+	ocspStaplingRetryInterval = time.Minute * 5
+)
+
+// Errors returned by OCSPStapler
+var (
+	ErrOCSPStaplingNoCertificate = errors.New("TLS config doesn't contain certificate for OCSP stapling")
+	ErrOCSPStaplingNoIssuer      = errors.New("certificate file should contain issuer's certificate after server's one for OCSP stapling")
+	ErrOCSPStaplingNoServer      = errors.New("certificate doesn't contain URL of OCSP server for OCSP stapling")
+	ErrOCSPStaplingNotGood       = errors.New("OCSP server didn't confirm server's certificate")
+)
+
+// OCSPStapler staples OCSP response about server's certificate to TLS handshakes, so clients don't need to query
+// OCSP server themselves. Responses are refreshed in background at half of their validity period
+type OCSPStapler struct {
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	client         OCSPClient
+	lock           sync.RWMutex
+	// leaf is DER encoded certificate which response is stapled
+	leaf        []byte
+	response    *ocsp.Response
+	nextRefresh time.Time
+}
+
+// NewOCSPStapler returns OCSPStapler for certificate of config, which may be static or returned by GetCertificate
+// callback, like one of CertificateReloader
+func NewOCSPStapler(config *tls.Config, client OCSPClient) (*OCSPStapler, error) {
+	getCertificate := config.GetCertificate
+	if getCertificate == nil {
+		if len(config.Certificates) == 0 {
+			return nil, ErrOCSPStaplingNoCertificate
+		}
+		certificate := &config.Certificates[0]
+		getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate, nil
+		}
+	}
+	return &OCSPStapler{getCertificate: getCertificate, client: client}, nil
+}
+
+// ApplyToConfig configures config to use certificate with stapled OCSP response
+func (stapler *OCSPStapler) ApplyToConfig(config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = stapler.GetCertificate
+}
+
+// GetCertificate returns certificate with stapled OCSP response if it was received and is still valid
+func (stapler *OCSPStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate, err := stapler.getCertificate(hello)
+	if err != nil || certificate == nil || len(certificate.Certificate) == 0 {
+		return certificate, err
+	}
+	stapler.lock.RLock()
+	defer stapler.lock.RUnlock()
+	if stapler.response == nil || !bytes.Equal(stapler.leaf, certificate.Certificate[0]) ||
+		(!stapler.response.NextUpdate.IsZero() && time.Now().After(stapler.response.NextUpdate)) {
+		return certificate, nil
+	}
+	stapled := *certificate
+	stapled.OCSPStaple = stapler.response.Raw
+	return &stapled, nil
+}
+
+// Refresh queries OCSP server about current certificate if it was changed or stapled response should be refreshed
+func (stapler *OCSPStapler) Refresh() error {
+	certificate, err := stapler.getCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		return err
+	}
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return ErrOCSPStaplingNoCertificate
+	}
+	stapler.lock.RLock()
+	upToDate := bytes.Equal(stapler.leaf, certificate.Certificate[0]) && time.Now().Before(stapler.nextRefresh)
+	stapler.lock.RUnlock()
+	if upToDate {
+		return nil
+	}
+	if len(certificate.Certificate) < 2 {
+		return ErrOCSPStaplingNoIssuer
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	issuer, err := x509.ParseCertificate(certificate.Certificate[1])
+	if err != nil {
+		return err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return ErrOCSPStaplingNoServer
+	}
+	var response *ocsp.Response
+	for _, url := range leaf.OCSPServer {
+		timer := prometheus.NewTimer(ocspRequestDurationHistogram)
+		response, err = stapler.client.Query(issuer.Subject.CommonName, leaf, issuer, url)
+		timer.ObserveDuration()
+		if err != nil {
+			ocspRequestsCounter.WithLabelValues(ocspStatusError).Inc()
+			log.WithError(err).WithField("url", url).Warnln("OCSP: Can't query server for stapling")
+			continue
+		}
+		ocspRequestsCounter.WithLabelValues(ocspStatusLabel(response.Status)).Inc()
+		break
+	}
+	stapler.lock.Lock()
+	defer stapler.lock.Unlock()
+	if err != nil {
+		// previous response is still stapled until its next update time
+		stapler.nextRefresh = time.Now().Add(ocspStaplingRetryInterval)
+		return err
+	}
+	if response.Status != ocsp.Good {
+		// only confirmed certificate is stapled, clients check revoked or unknown certificate themselves
+		stapler.response = nil
+		stapler.nextRefresh = time.Now().Add(ocspStaplingRetryInterval)
+		return ErrOCSPStaplingNotGood
+	}
+	stapler.leaf = certificate.Certificate[0]
+	stapler.response = response
+	stapler.nextRefresh = time.Now().Add(ocspStaplingRetryInterval)
+	if !response.NextUpdate.IsZero() {
+		stapler.nextRefresh = response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+	}
+	return nil
+}
+
+// Run refreshes stapled response until ctx is done. Should be called as goroutine
+func (stapler *OCSPStapler) Run(ctx context.Context) {
+	refresh := func() {
+		if err := stapler.Refresh(); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSGeneral).
+				Warningln("Can't refresh stapled OCSP response")
+		}
+	}
+	refresh()
+	ticker := time.NewTicker(ocspStaplingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
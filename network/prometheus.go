@@ -16,10 +16,97 @@ package network
 
 import (
 	"net"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ocsp"
 )
 
+// labels of ocspRequestsCounter
+const (
+	ocspStatusGood    = "good"
+	ocspStatusRevoked = "revoked"
+	ocspStatusUnknown = "unknown"
+	ocspStatusError   = "error"
+)
+
+var ocspRequestsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acra_ocsp_requests_total",
+		Help: "number of requests to OCSP servers by status of response, error if server didn't respond with valid response",
+	}, []string{"status"})
+
+var ocspRequestDurationHistogram = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "acra_ocsp_request_duration_seconds",
+		Help:    "Time of requests to OCSP servers",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15},
+	})
+
+var ocspCacheHitsCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "acra_ocsp_cache_hits_total",
+		Help: "number of certificate checks answered with cached OCSP response",
+	})
+
+var registerOCSPMetricsOnce = sync.Once{}
+
+// RegisterOCSPMetrics register in default prometheus registry metrics related with OCSP requests
+func RegisterOCSPMetrics() {
+	registerOCSPMetricsOnce.Do(func() {
+		prometheus.MustRegister(ocspRequestsCounter)
+		prometheus.MustRegister(ocspRequestDurationHistogram)
+		prometheus.MustRegister(ocspCacheHitsCounter)
+	})
+}
+
+// labels of crlFetchCounter
+const (
+	crlFetchStatusSuccess = "success"
+	crlFetchStatusFailure = "failure"
+)
+
+var crlFetchCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acra_crl_fetches_total",
+		Help: "number of CRL fetches by status, failure if CRL or delta CRL wasn't fetched or verified",
+	}, []string{"status"})
+
+var crlLastUpdateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "acra_crl_last_update_timestamp_seconds",
+		Help: "Unix time of last successful fetch of CRL by its URL",
+	}, []string{"url"})
+
+var crlNextUpdateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "acra_crl_next_update_timestamp_seconds",
+		Help: "Unix time of next update of last fetched CRL (or its delta CRL) by its URL, CRL is stale after it",
+	}, []string{"url"})
+
+var registerCRLMetricsOnce = sync.Once{}
+
+// RegisterCRLMetrics register in default prometheus registry metrics related with CRL fetching
+func RegisterCRLMetrics() {
+	registerCRLMetricsOnce.Do(func() {
+		prometheus.MustRegister(crlFetchCounter)
+		prometheus.MustRegister(crlLastUpdateGauge)
+		prometheus.MustRegister(crlNextUpdateGauge)
+	})
+}
+
+// ocspStatusLabel returns label of ocspRequestsCounter for status of OCSP response
+func ocspStatusLabel(status int) string {
+	switch status {
+	case ocsp.Good:
+		return ocspStatusGood
+	case ocsp.Revoked:
+		return ocspStatusRevoked
+	default:
+		return ocspStatusUnknown
+	}
+}
+
 // MetricConnectionCallback callback used for new incoming connections from gRPC
 // or http.Server connection handlers and wraps new connections with time
 // tracking of lifetime on Close calls
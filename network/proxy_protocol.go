@@ -0,0 +1,248 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProxyProtocolHeaderTimeout is time of waiting for PROXY protocol header after connection accepted
+const DefaultProxyProtocolHeaderTimeout = time.Second * 5
+
+const (
+	// proxyProtocolV1MaxLength is max length of v1 header including CRLF
+	proxyProtocolV1MaxLength    = 107
+	proxyProtocolV2HeaderLength = 16
+)
+
+var proxyProtocolV2Signature = []byte{'\r', '\n', '\r', '\n', 0, '\r', '\n', 'Q', 'U', 'I', 'T', '\n'}
+
+// Errors returned by ProxyProtocolReader
+var (
+	ErrInvalidProxyProtocolHeader     = errors.New("invalid PROXY protocol header")
+	ErrUntrustedProxyProtocolPeer     = errors.New("connection is not from trusted network of PROXY protocol")
+	ErrNoProxyProtocolTrustedNetworks = errors.New("trusted networks of PROXY protocol are empty")
+)
+
+// ProxyProtocolReader reads HAProxy PROXY protocol header (v1 or v2) sent by L4 load balancer at the start of
+// connection and replaces remote and local addresses of connection with addresses of the client
+type ProxyProtocolReader struct {
+	trustedNetworks []*net.IPNet
+	headerTimeout   time.Duration
+}
+
+// NewProxyProtocolReader returns ProxyProtocolReader which accepts headers only from peers in comma separated CIDRs
+// trustedNetworks. Returns ErrNoProxyProtocolTrustedNetworks if trustedNetworks is empty, because otherwise any peer
+// may spoof address of the client
+func NewProxyProtocolReader(trustedNetworks string, headerTimeout time.Duration) (*ProxyProtocolReader, error) {
+	reader := &ProxyProtocolReader{headerTimeout: headerTimeout}
+	for _, value := range strings.Split(trustedNetworks, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		reader.trustedNetworks = append(reader.trustedNetworks, network)
+	}
+	if len(reader.trustedNetworks) == 0 {
+		return nil, ErrNoProxyProtocolTrustedNetworks
+	}
+	return reader, nil
+}
+
+func (reader *ProxyProtocolReader) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range reader.trustedNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtocolConnection overrides addresses of connection with addresses from PROXY protocol header
+type proxyProtocolConnection struct {
+	net.Conn
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// RemoteAddr returns address of the client
+func (conn *proxyProtocolConnection) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// LocalAddr returns address which the client connected to
+func (conn *proxyProtocolConnection) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+// ReadHeader reads PROXY protocol header from connection and returns connection with addresses from it. Connection
+// is returned as is for LOCAL command or UNKNOWN protocol, like health checks of load balancer
+func (reader *ProxyProtocolReader) ReadHeader(conn net.Conn) (net.Conn, error) {
+	if !reader.isTrusted(conn.RemoteAddr()) {
+		return nil, ErrUntrustedProxyProtocolPeer
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(reader.headerTimeout)); err != nil {
+		return nil, err
+	}
+	// header is read without buffering to not consume data of the client after it
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return nil, err
+	}
+	var source, destination net.Addr
+	var err error
+	switch first[0] {
+	case 'P':
+		source, destination, err = readProxyProtocolV1(conn)
+	case proxyProtocolV2Signature[0]:
+		source, destination, err = readProxyProtocolV2(conn)
+	default:
+		err = ErrInvalidProxyProtocolHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return conn, nil
+	}
+	return &proxyProtocolConnection{Conn: conn, remoteAddr: source, localAddr: destination}, nil
+}
+
+// readProxyProtocolV1 reads rest of human-readable header after first byte: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readProxyProtocolV1(conn net.Conn) (net.Addr, net.Addr, error) {
+	line := []byte{'P'}
+	symbol := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyProtocolV1MaxLength {
+			return nil, nil, ErrInvalidProxyProtocolHeader
+		}
+		if _, err := io.ReadFull(conn, symbol); err != nil {
+			return nil, nil, err
+		}
+		line = append(line, symbol[0])
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	if len(fields) != 6 {
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	source, err := parseProxyProtocolV1Address(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := parseProxyProtocolV1Address(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, destination, nil
+}
+
+func parseProxyProtocolV1Address(host, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidProxyProtocolHeader, host)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidProxyProtocolHeader, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(portNumber)}, nil
+}
+
+// readProxyProtocolV2 reads rest of binary header after first byte of signature
+func readProxyProtocolV2(conn net.Conn) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyProtocolV2HeaderLength)
+	header[0] = proxyProtocolV2Signature[0]
+	if _, err := io.ReadFull(conn, header[1:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(header[:len(proxyProtocolV2Signature)], proxyProtocolV2Signature) {
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	versionCommand, family := header[12], header[13]
+	if versionCommand>>4 != 2 {
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, nil, err
+	}
+	const (
+		commandLocal = 0
+		commandProxy = 1
+		familyInet   = 0x1
+		familyInet6  = 0x2
+	)
+	switch versionCommand & 0xf {
+	case commandLocal:
+		return nil, nil, nil
+	case commandProxy:
+	default:
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	var ipLength int
+	switch family >> 4 {
+	case familyInet:
+		ipLength = net.IPv4len
+	case familyInet6:
+		ipLength = net.IPv6len
+	default:
+		// UNSPEC or UNIX addresses, keep addresses of connection
+		return nil, nil, nil
+	}
+	// source and destination addresses followed by source and destination ports, the rest is TLVs
+	if len(payload) < ipLength*2+4 {
+		return nil, nil, ErrInvalidProxyProtocolHeader
+	}
+	source := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[:ipLength]...)),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2:])),
+	}
+	destination := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[ipLength:ipLength*2]...)),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2+2:])),
+	}
+	return source, destination, nil
+}
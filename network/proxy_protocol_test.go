@@ -0,0 +1,149 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// proxyProtocolTestLoadBalancer is address of load balancer which sends data of proxyProtocolTestConnection
+var proxyProtocolTestLoadBalancer = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+// proxyProtocolTestConnection returns server side of connection with data written by load balancer
+func proxyProtocolTestConnection(t *testing.T, data []byte) net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	t.Cleanup(func() { server.Close() })
+	return &proxyProtocolConnection{Conn: server, remoteAddr: proxyProtocolTestLoadBalancer, localAddr: server.LocalAddr()}
+}
+
+func proxyProtocolV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addresses)))
+	header = append(header, length...)
+	return append(header, addresses...)
+}
+
+func TestProxyProtocolReader(t *testing.T) {
+	reader, err := NewProxyProtocolReader("127.0.0.0/8", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4Addresses := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x15, 0x38}
+	// TLV after addresses should be skipped
+	ipv4AddressesWithTLV := append(append([]byte{}, ipv4Addresses...), 0x04, 0x00, 0x01, 0x00)
+	ipv6Addresses := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x1f, 0x90, 0x15, 0x38)
+	testcases := []struct {
+		name   string
+		header []byte
+		remote string
+		local  string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 8080 5432\r\n"), "10.0.0.1:8080", "10.0.0.2:5432"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 8080 5432\r\n"), "[2001:db8::1]:8080", "[2001:db8::2]:5432"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), proxyProtocolTestLoadBalancer.String(), "pipe"},
+		{"v2 inet", proxyProtocolV2Header(1, 0x11, ipv4AddressesWithTLV), "10.0.0.1:8080", "10.0.0.2:5432"},
+		{"v2 inet6", proxyProtocolV2Header(1, 0x21, ipv6Addresses), "[2001:db8::1]:8080", "[2001:db8::2]:5432"},
+		{"v2 local", proxyProtocolV2Header(0, 0x00, nil), proxyProtocolTestLoadBalancer.String(), "pipe"},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			payload := []byte("client data")
+			conn, err := reader.ReadHeader(proxyProtocolTestConnection(t, append(append([]byte{}, testcase.header...), payload...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conn.RemoteAddr().String() != testcase.remote || conn.LocalAddr().String() != testcase.local {
+				t.Fatalf("Expected %s -> %s, took %s -> %s", testcase.remote, testcase.local, conn.RemoteAddr(), conn.LocalAddr())
+			}
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Fatalf("Expected data after header %q, took %q", payload, data)
+			}
+		})
+	}
+
+	invalidHeaders := [][]byte{
+		[]byte("GET / HTTP/1.1\r\n"),
+		[]byte("PROXY TCP4 10.0.0.1 10.0.0.2 8080\r\n"),
+		[]byte("PROXY TCP4 2001:db8::1 10.0.0.2 8080 5432\r\n"),
+		[]byte("PROXY TCP4 10.0.0.1 10.0.0.2 8080 70000\r\n"),
+		bytes.Repeat([]byte("PROXY "), 20),
+		proxyProtocolV2Header(1, 0x11, ipv4Addresses[:8]),
+		proxyProtocolV2Header(2, 0x11, ipv4Addresses),
+	}
+	for i, header := range invalidHeaders {
+		if _, err := reader.ReadHeader(proxyProtocolTestConnection(t, header)); !errors.Is(err, ErrInvalidProxyProtocolHeader) {
+			t.Fatalf("[%d] Expected %v, took %v", i, ErrInvalidProxyProtocolHeader, err)
+		}
+	}
+}
+
+func TestProxyProtocolTrustedNetworks(t *testing.T) {
+	if _, err := NewProxyProtocolReader("10.0.0.0/8,invalid", time.Second); err == nil {
+		t.Fatal("Expected error on invalid CIDR")
+	}
+	// any peer could spoof address of the client without trusted networks
+	for _, networks := range []string{"", " , "} {
+		if _, err := NewProxyProtocolReader(networks, time.Second); err != ErrNoProxyProtocolTrustedNetworks {
+			t.Fatalf("Expected %v for %q, took %v", ErrNoProxyProtocolTrustedNetworks, networks, err)
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("PROXY TCP4 10.0.0.1 10.0.0.2 8080 5432\r\n"))
+			defer conn.Close()
+		}
+	}()
+
+	untrusted, err := NewProxyProtocolReader("10.0.0.0/8", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := untrusted.ReadHeader(conn); err != ErrUntrustedProxyProtocolPeer {
+		t.Fatalf("Expected %v, took %v", ErrUntrustedProxyProtocolPeer, err)
+	}
+
+	trusted, err := NewProxyProtocolReader("10.0.0.0/8, 127.0.0.0/8", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	proxiedConn, err := trusted.ReadHeader(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxiedConn.RemoteAddr().String() != "10.0.0.1:8080" {
+		t.Fatalf("Unexpected client address %s", proxiedConn.RemoteAddr())
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	url_ "net/url"
+)
+
+// Errors returned by ListenWithReusePort
+var (
+	ErrReusePortNotSupported = errors.New("SO_REUSEPORT is not supported on this platform")
+	ErrReusePortUnixSocket   = errors.New("SO_REUSEPORT is supported only for TCP listeners")
+)
+
+// ListenWithReusePort returns TCP listener for connection string with SO_REUSEPORT option. It allows new process to
+// listen the same address while the old one still accepts connections or drains them, so binary may be upgraded
+// without refused connections. The option should be set by all processes sharing the address
+func ListenWithReusePort(connectionString string) (net.Listener, error) {
+	url, err := url_.Parse(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	url.Scheme = customSchemeToBaseGolangScheme(url.Scheme)
+	if url.Scheme == "unix" {
+		return nil, ErrReusePortUnixSocket
+	}
+	config := net.ListenConfig{Control: reusePortControl}
+	listener, err := config.Listen(context.Background(), url.Scheme, url.Host)
+	if err != nil {
+		return nil, err
+	}
+	return newSafeCloseListener(listener), nil
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenWithReusePort(t *testing.T) {
+	listener, err := ListenWithReusePort("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.Addr().String()
+
+	// new process with SO_REUSEPORT can listen the same address
+	secondListener, err := ListenWithReusePort("tcp://" + address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondListener.Close()
+
+	if plainListener, err := net.Listen("tcp", address); err == nil {
+		plainListener.Close()
+		t.Fatal("Expected error on listening the same address without SO_REUSEPORT")
+	}
+	if _, err := ListenWithReusePort("unix:///tmp/acra.sock"); err != ErrReusePortUnixSocket {
+		t.Fatalf("Expected %v, took %v", ErrReusePortUnixSocket, err)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/cossacklabs/acra/logging"
+)
+
+// SPIFFEEndpointSocketEnv is environment variable with address of SPIFFE Workload API used if address is not specified
+const SPIFFEEndpointSocketEnv = "SPIFFE_ENDPOINT_SOCKET"
+
+const (
+	spiffeScheme = "spiffe"
+	// spiffeFetchX509SVIDMethod is server streaming method of SPIFFE Workload API which returns X509-SVIDs of workload
+	// and sends new ones on rotation
+	spiffeFetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+	// spiffeWorkloadAPIHeader is metadata required by Workload API to prevent requests from browsers
+	spiffeWorkloadAPIHeader = "workload.spiffe.io"
+	// spiffeWorkloadAPIRetryInterval is delay before reconnection to Workload API after failure
+	spiffeWorkloadAPIRetryInterval = time.Second * 5
+)
+
+// Errors related to SPIFFE identities and Workload API
+var (
+	ErrNoSPIFFEID          = errors.New("certificate doesn't contain SPIFFE ID")
+	ErrInvalidSPIFFEID     = errors.New("invalid SPIFFE ID")
+	ErrEmptySPIFFEEndpoint = errors.New("address of SPIFFE Workload API should be specified with " + SPIFFEEndpointSocketEnv + " environment variable or explicitly")
+	ErrNoSPIFFEX509SVID    = errors.New("SPIFFE Workload API didn't return X509-SVID")
+)
+
+// ParseSPIFFEID validates that uri is SPIFFE ID: spiffe://<trust domain>/<path> without port, user info, query and
+// fragment
+func ParseSPIFFEID(uri *url.URL) (string, error) {
+	if uri == nil || uri.Scheme != spiffeScheme || uri.Host == "" || uri.Port() != "" || uri.User != nil ||
+		uri.RawQuery != "" || uri.Fragment != "" || uri.Opaque != "" {
+		return "", ErrInvalidSPIFFEID
+	}
+	return uri.String(), nil
+}
+
+// SPIFFEIDExtractor implementation for CertificateIdentifierExtractor interface, which return SPIFFE ID from URI SAN
+// of X509-SVID as client's identifier
+type SPIFFEIDExtractor struct{}
+
+// GetCertificateIdentifier return SPIFFE ID (like spiffe://example.org/app) as client's identifier. According to
+// X509-SVID specification certificate should contain exactly one URI SAN
+func (e SPIFFEIDExtractor) GetCertificateIdentifier(certificate *x509.Certificate) ([]byte, error) {
+	if certificate == nil {
+		return nil, ErrNoPeerCertificate
+	}
+	switch len(certificate.URIs) {
+	case 0:
+		return nil, ErrNoSPIFFEID
+	case 1:
+		id, err := ParseSPIFFEID(certificate.URIs[0])
+		if err != nil {
+			return nil, err
+		}
+		return []byte(id), nil
+	default:
+		return nil, ErrInvalidSPIFFEID
+	}
+}
+
+// spiffeRawCodec passes already encoded protobuf messages to gRPC, so Workload API messages are encoded with
+// protowire without generated code
+type spiffeRawCodec struct{}
+
+func (spiffeRawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (spiffeRawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (spiffeRawCodec) Name() string {
+	return "proto"
+}
+
+// spiffeX509SVID is X509-SVID received from Workload API
+type spiffeX509SVID struct {
+	id          string
+	certificate tls.Certificate
+	bundle      *x509.CertPool
+}
+
+// parseX509SVIDResponse decodes X509SVIDResponse message of Workload API and returns the first (default) SVID
+func parseX509SVIDResponse(data []byte) (*spiffeX509SVID, error) {
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		// field 1 is `repeated X509SVID svids`, the first one is default
+		if number == 1 && wireType == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			return parseX509SVID(value)
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil, ErrNoSPIFFEX509SVID
+}
+
+// parseX509SVID decodes X509SVID message of Workload API with SPIFFE ID, DER encoded certificate chain, PKCS#8 private
+// key and DER encoded certificates of trust bundle
+func parseX509SVID(data []byte) (*spiffeX509SVID, error) {
+	var id string
+	var chain, key, bundle []byte
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+		} else {
+			var value []byte
+			value, n = protowire.ConsumeBytes(data)
+			switch number {
+			case 1:
+				id = string(value)
+			case 2:
+				chain = value
+			case 3:
+				key = value
+			case 4:
+				bundle = value
+			}
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	certificates, err := x509.ParseCertificates(chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificates) == 0 {
+		return nil, ErrNoSPIFFEX509SVID
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	bundleCertificates, err := x509.ParseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	svid := &spiffeX509SVID{
+		id:          id,
+		certificate: tls.Certificate{PrivateKey: privateKey, Leaf: certificates[0]},
+		bundle:      x509.NewCertPool(),
+	}
+	for _, certificate := range certificates {
+		svid.certificate.Certificate = append(svid.certificate.Certificate, certificate.Raw)
+	}
+	for _, certificate := range bundleCertificates {
+		svid.bundle.AddCert(certificate)
+	}
+	return svid, nil
+}
+
+// SPIFFEWorkloadSource obtains X509-SVID of AcraServer and trust bundle from SPIFFE Workload API (like SPIRE agent)
+// and keeps them up to date when they are rotated
+type SPIFFEWorkloadSource struct {
+	conn *grpc.ClientConn
+	lock sync.RWMutex
+	svid *spiffeX509SVID
+}
+
+// NewSPIFFEWorkloadSource returns SPIFFEWorkloadSource which connects to Workload API by address like
+// unix:///run/spire/sockets/agent.sock. Value of SPIFFE_ENDPOINT_SOCKET environment variable used if address is empty.
+// Fetch should be called to get the first SVID
+func NewSPIFFEWorkloadSource(address string) (*SPIFFEWorkloadSource, error) {
+	if address == "" {
+		address = os.Getenv(SPIFFEEndpointSocketEnv)
+	}
+	if address == "" {
+		return nil, ErrEmptySPIFFEEndpoint
+	}
+	if !strings.Contains(address, "://") {
+		address = "unix://" + address
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &SPIFFEWorkloadSource{conn: conn}, nil
+}
+
+// watch receives SVIDs from Workload API until stream is closed, or until the first SVID if firstOnly
+func (source *SPIFFEWorkloadSource) watch(ctx context.Context, firstOnly bool) error {
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, spiffeWorkloadAPIHeader, "true"))
+	defer cancel()
+	stream, err := source.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, spiffeFetchX509SVIDMethod,
+		grpc.ForceCodec(spiffeRawCodec{}))
+	if err != nil {
+		return err
+	}
+	// X509SVIDRequest is empty message
+	if err := stream.SendMsg(&[]byte{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var response []byte
+		if err := stream.RecvMsg(&response); err != nil {
+			return err
+		}
+		svid, err := parseX509SVIDResponse(response)
+		if err != nil {
+			return err
+		}
+		source.lock.Lock()
+		source.svid = svid
+		source.lock.Unlock()
+		log.WithField("spiffe_id", svid.id).WithField("expires", svid.certificate.Leaf.NotAfter).Infoln("Received X509-SVID from SPIFFE Workload API")
+		if firstOnly {
+			return nil
+		}
+	}
+}
+
+// Fetch receives current SVID from Workload API
+func (source *SPIFFEWorkloadSource) Fetch(ctx context.Context) error {
+	return source.watch(ctx, true)
+}
+
+// Run receives rotated SVIDs until ctx is done, reconnecting to Workload API on failures. Should be called as goroutine
+func (source *SPIFFEWorkloadSource) Run(ctx context.Context) {
+	defer source.conn.Close()
+	for {
+		err := source.watch(ctx, false)
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorNetworkTLSGeneral).
+			Warningln("Lost connection to SPIFFE Workload API, previous X509-SVID left in use")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(spiffeWorkloadAPIRetryInterval):
+		}
+	}
+}
+
+// GetCertificate returns current X509-SVID, used as tls.Config.GetCertificate
+func (source *SPIFFEWorkloadSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+	if source.svid == nil {
+		return nil, ErrNoSPIFFEX509SVID
+	}
+	return &source.svid.certificate, nil
+}
+
+// GetClientCertificate returns current X509-SVID, used as tls.Config.GetClientCertificate
+func (source *SPIFFEWorkloadSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return source.GetCertificate(nil)
+}
+
+// Bundle returns current trust bundle with certificates of CAs of trust domain
+func (source *SPIFFEWorkloadSource) Bundle() *x509.CertPool {
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+	if source.svid == nil {
+		return x509.NewCertPool()
+	}
+	return source.svid.bundle
+}
+
+// ApplyToConfig configures server side config to use X509-SVID as certificate and to verify clients' certificates
+// with current trust bundle instead of configured CA
+func (source *SPIFFEWorkloadSource) ApplyToConfig(config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = source.GetCertificate
+	config.GetClientCertificate = source.GetClientCertificate
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		handshakeConfig := config.Clone()
+		handshakeConfig.ClientCAs = source.Bundle()
+		return handshakeConfig, nil
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestSPIFFEIDExtractor(t *testing.T) {
+	parse := func(rawURL string) *url.URL {
+		uri, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uri
+	}
+	testCases := []struct {
+		uris     []*url.URL
+		expected string
+		err      error
+	}{
+		{[]*url.URL{parse("spiffe://example.org/ns/default/sa/app")}, "spiffe://example.org/ns/default/sa/app", nil},
+		{nil, "", ErrNoSPIFFEID},
+		{[]*url.URL{parse("https://example.org/app")}, "", ErrInvalidSPIFFEID},
+		{[]*url.URL{parse("spiffe://example.org:8080/app")}, "", ErrInvalidSPIFFEID},
+		{[]*url.URL{parse("spiffe://example.org/app?query=1")}, "", ErrInvalidSPIFFEID},
+		{[]*url.URL{parse("spiffe:///app")}, "", ErrInvalidSPIFFEID},
+		{[]*url.URL{parse("spiffe://example.org/app"), parse("spiffe://example.org/other")}, "", ErrInvalidSPIFFEID},
+	}
+	extractor, err := NewIdentifierExtractorByType(IdentifierExtractorTypeSPIFFEID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, testCase := range testCases {
+		id, err := extractor.GetCertificateIdentifier(&x509.Certificate{URIs: testCase.uris})
+		if err != testCase.err {
+			t.Fatalf("[%d] Expected %v, took %v", i, testCase.err, err)
+		}
+		if string(id) != testCase.expected {
+			t.Fatalf("[%d] Expected %s, took %s", i, testCase.expected, id)
+		}
+	}
+	if _, err := extractor.GetCertificateIdentifier(nil); err != ErrNoPeerCertificate {
+		t.Fatalf("Expected %v, took %v", ErrNoPeerCertificate, err)
+	}
+}
+
+// newTestX509SVIDResponse returns encoded X509SVIDResponse with SVID signed by issuer
+func newTestX509SVIDResponse(t *testing.T, issuer *testOCSPIssuer, id string, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "acra-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{uri},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, issuer.cert, &key.PublicKey, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var svid []byte
+	svid = protowire.AppendTag(svid, 1, protowire.BytesType)
+	svid = protowire.AppendString(svid, id)
+	svid = protowire.AppendTag(svid, 2, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, certificate)
+	svid = protowire.AppendTag(svid, 3, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, privateKey)
+	svid = protowire.AppendTag(svid, 4, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, issuer.cert.Raw)
+	svid = protowire.AppendTag(svid, 5, protowire.BytesType)
+	svid = protowire.AppendString(svid, "internal")
+
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.BytesType)
+	return protowire.AppendBytes(response, svid)
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	const id = "spiffe://example.org/acra-server"
+	svid, err := parseX509SVIDResponse(newTestX509SVIDResponse(t, issuer, id, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid.id != id || svid.certificate.Leaf.SerialNumber.Int64() != 2 || len(svid.certificate.Certificate) != 1 {
+		t.Fatalf("Unexpected SVID %v", svid)
+	}
+	if _, err := svid.certificate.Leaf.Verify(x509.VerifyOptions{Roots: svid.bundle}); err != nil {
+		t.Fatalf("Expected certificate verified by bundle, took %v", err)
+	}
+	if _, err := parseX509SVIDResponse(nil); err != ErrNoSPIFFEX509SVID {
+		t.Fatalf("Expected %v, took %v", ErrNoSPIFFEX509SVID, err)
+	}
+	if _, err := parseX509SVIDResponse([]byte{0x0a, 0x10}); err == nil {
+		t.Fatal("Expected error for truncated response")
+	}
+}
+
+func TestSPIFFEWorkloadSource(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	const id = "spiffe://example.org/acra-server"
+	initial, rotated := newTestX509SVIDResponse(t, issuer, id, 2), newTestX509SVIDResponse(t, issuer, id, 3)
+	rotate := make(chan struct{})
+
+	// fake Workload API which sends initial SVID to every stream and rotated one after rotate is closed
+	server := grpc.NewServer(grpc.ForceServerCodec(spiffeRawCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		if method, _ := grpc.MethodFromServerStream(stream); method != spiffeFetchX509SVIDMethod {
+			return errors.New("unexpected method")
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if values := md.Get(spiffeWorkloadAPIHeader); len(values) != 1 || values[0] != "true" {
+			return errors.New("missing security header")
+		}
+		var request []byte
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		if err := stream.SendMsg(&initial); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-rotate:
+			if err := stream.SendMsg(&rotated); err != nil {
+				return err
+			}
+		}
+		<-stream.Context().Done()
+		return nil
+	}))
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	t.Setenv(SPIFFEEndpointSocketEnv, "")
+	if _, err := NewSPIFFEWorkloadSource(""); err != ErrEmptySPIFFEEndpoint {
+		t.Fatalf("Expected %v, took %v", ErrEmptySPIFFEEndpoint, err)
+	}
+	t.Setenv(SPIFFEEndpointSocketEnv, "unix://"+socketPath)
+	source, err := NewSPIFFEWorkloadSource("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{}}}
+	source.ApplyToConfig(config)
+	if _, err := config.GetCertificate(nil); err != ErrNoSPIFFEX509SVID {
+		t.Fatalf("Expected %v before fetch, took %v", ErrNoSPIFFEX509SVID, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := source.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSerial := func(expected int64) {
+		certificate, err := config.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial := certificate.Leaf.SerialNumber.Int64(); serial != expected {
+			t.Fatalf("Expected SVID with serial %d, took %d", expected, serial)
+		}
+	}
+	checkSerial(2)
+	handshakeConfig, err := config.GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshakeConfig.ClientCAs == nil || handshakeConfig.GetCertificate == nil {
+		t.Fatal("Expected config with trust bundle and SVID")
+	}
+
+	// rotated SVID is used after it was received
+	go source.Run(ctx)
+	close(rotate)
+	for {
+		certificate, err := source.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certificate.Leaf.SerialNumber.Int64() == 3 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("Rotated SVID wasn't received")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
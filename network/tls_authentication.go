@@ -4,15 +4,20 @@ import (
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	log "github.com/sirupsen/logrus"
 	"hash"
+	"os"
+	"strings"
 )
 
 // Set of constants with
 const (
 	IdentifierExtractorTypeDistinguishedName        = "distinguished_name"
 	IdentifierExtractorTypeSerialNumber             = "serial_number"
+	IdentifierExtractorTypeSPIFFEID                 = "spiffe_id"
+	IdentifierExtractorTypeTemplate                 = "template"
 	DefaultIdentifierExtractorTypeDistinguishedName = IdentifierExtractorTypeDistinguishedName
 )
 
@@ -20,6 +25,8 @@ const (
 var IdentifierExtractorTypesList = []string{
 	IdentifierExtractorTypeDistinguishedName,
 	IdentifierExtractorTypeSerialNumber,
+	IdentifierExtractorTypeSPIFFEID,
+	IdentifierExtractorTypeTemplate,
 }
 
 // ErrInvalidIdentifierExtractorType return when used invalid value of identifier extractor type
@@ -32,6 +39,10 @@ func NewIdentifierExtractorByType(extractorType string) (CertificateIdentifierEx
 		return DistinguishedNameExtractor{}, nil
 	case IdentifierExtractorTypeSerialNumber:
 		return SerialNumberExtractor{}, nil
+	case IdentifierExtractorTypeSPIFFEID:
+		return SPIFFEIDExtractor{}, nil
+	case IdentifierExtractorTypeTemplate:
+		return NewTemplateIdentifierExtractor(tlsIdentifierExtractorTemplate, strings.Split(tlsIdentifierNormalization, ","))
 	default:
 		return nil, ErrInvalidIdentifierExtractorType
 	}
@@ -132,6 +143,61 @@ func NewDefaultTLSClientIDExtractor() (TLSClientIDExtractor, error) {
 	return &tlsClientIDExtractor{idExtractor, idConverter}, nil
 }
 
+// NewTLSClientIDExtractorByType create new TLSClientIDExtractor with identifier extractor of extractorType and default
+// converter, the same as used by AcraServer and AcraTranslator
+func NewTLSClientIDExtractorByType(extractorType string) (TLSClientIDExtractor, error) {
+	idConverter, err := NewDefaultHexIdentifierConverter()
+	if err != nil {
+		return nil, err
+	}
+	idExtractor, err := NewIdentifierExtractorByType(extractorType)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsClientIDExtractor{idExtractor, idConverter}, nil
+}
+
+// ErrInvalidCertificateFile return when file contains neither PEM encoded nor DER encoded certificate
+var ErrInvalidCertificateFile = errors.New("file doesn't contain PEM or DER encoded certificate")
+
+// ReadCertificateFile reads x509 certificate from PEM or DER encoded file. The first certificate of PEM chain is used.
+func ReadCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	certificate, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, ErrInvalidCertificateFile
+	}
+	return certificate, nil
+}
+
+// ClientIDFromCertificateFile returns clientID which AcraServer with extractorType identifier extractor assigns
+// to clients authenticated with the certificate from file
+func ClientIDFromCertificateFile(path, extractorType string) ([]byte, error) {
+	extractor, err := NewTLSClientIDExtractorByType(extractorType)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := ReadCertificateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return extractor.ExtractClientID(certificate)
+}
+
 // ExtractClientID extract clientID from certificate
 func (extractor *tlsClientIDExtractor) ExtractClientID(certificate *x509.Certificate) ([]byte, error) {
 	identifier, err := extractor.idExtractor.GetCertificateIdentifier(certificate)
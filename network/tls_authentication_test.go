@@ -204,3 +204,70 @@ func TestHexIdentifierConverter_HashError(t *testing.T) {
 		t.Fatalf("Got something else than expected error, %s\n", err)
 	}
 }
+
+func TestReadCertificateFile(t *testing.T) {
+	certPath := filepath.Join(tests.GetSourceRootDirectory(t), "tests/ssl/acra-writer/acra-writer.crt")
+	expected := getAcraWriterTestx509Certificate(t)
+
+	certificate, err := ReadCertificateFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(expected) {
+		t.Fatal("Certificate read from PEM file doesn't match expected")
+	}
+
+	derPath := filepath.Join(t.TempDir(), "acra-writer.der")
+	if err := ioutil.WriteFile(derPath, expected.Raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err = ReadCertificateFile(derPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(expected) {
+		t.Fatal("Certificate read from DER file doesn't match expected")
+	}
+
+	invalidPath := filepath.Join(t.TempDir(), "invalid.crt")
+	if err := ioutil.WriteFile(invalidPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCertificateFile(invalidPath); err != ErrInvalidCertificateFile {
+		t.Fatalf("Expected %v, took %v", ErrInvalidCertificateFile, err)
+	}
+}
+
+func TestClientIDFromCertificateFile(t *testing.T) {
+	certPath := filepath.Join(tests.GetSourceRootDirectory(t), "tests/ssl/acra-writer/acra-writer.crt")
+	certificate := getAcraWriterTestx509Certificate(t)
+	SetIdentifierExtractorTemplate("{CN}-{serial}", IdentifierNormalizationLowercase)
+	defer SetIdentifierExtractorTemplate("", "")
+	for _, extractorType := range IdentifierExtractorTypesList {
+		extractor, err := NewTLSClientIDExtractorByType(extractorType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if extractorType == IdentifierExtractorTypeSPIFFEID {
+			// test certificate is not X509-SVID
+			if _, err := ClientIDFromCertificateFile(certPath, extractorType); err != ErrNoSPIFFEID {
+				t.Fatalf("Expected %v, took %v", ErrNoSPIFFEID, err)
+			}
+			continue
+		}
+		expected, err := extractor.ExtractClientID(certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientID, err := ClientIDFromCertificateFile(certPath, extractorType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(clientID, expected) {
+			t.Fatalf("Expected clientID %s for %s, took %s", expected, extractorType, clientID)
+		}
+	}
+	if _, err := ClientIDFromCertificateFile(certPath, "unknown"); err != ErrInvalidIdentifierExtractorType {
+		t.Fatalf("Expected %v, took %v", ErrInvalidIdentifierExtractorType, err)
+	}
+}
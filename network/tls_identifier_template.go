@@ -0,0 +1,319 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Set of normalization rules applied to values of template fields
+const (
+	IdentifierNormalizationLowercase      = "lowercase"
+	IdentifierNormalizationUppercase      = "uppercase"
+	IdentifierNormalizationTrim           = "trim"
+	IdentifierNormalizationCollapseSpaces = "collapse_spaces"
+)
+
+// IdentifierNormalizationList list of all acceptable normalization rules
+var IdentifierNormalizationList = []string{
+	IdentifierNormalizationLowercase,
+	IdentifierNormalizationUppercase,
+	IdentifierNormalizationTrim,
+	IdentifierNormalizationCollapseSpaces,
+}
+
+// Errors returned by TemplateIdentifierExtractor
+var (
+	ErrInvalidIdentifierTemplate       = errors.New("invalid template of certificate identifier")
+	ErrUnknownIdentifierTemplateField  = errors.New("unknown field in template of certificate identifier")
+	ErrMissingIdentifierTemplateField  = errors.New("certificate doesn't contain field used in identifier template")
+	ErrUnknownIdentifierNormalization  = errors.New("unknown normalization rule of certificate identifier")
+	ErrConflictedIdentifierNormalizers = errors.New("lowercase and uppercase normalization rules can't be used together")
+)
+
+var (
+	tlsIdentifierExtractorTemplate string
+	tlsIdentifierNormalization     string
+)
+
+// subjectAttributeOIDs maps short names of subject attributes which may be used in template to their OIDs
+var subjectAttributeOIDs = map[string]asn1.ObjectIdentifier{
+	"CN":           {2, 5, 4, 3},
+	"SERIALNUMBER": {2, 5, 4, 5},
+	"C":            {2, 5, 4, 6},
+	"L":            {2, 5, 4, 7},
+	"ST":           {2, 5, 4, 8},
+	"STREET":       {2, 5, 4, 9},
+	"O":            {2, 5, 4, 10},
+	"OU":           {2, 5, 4, 11},
+	"POSTALCODE":   {2, 5, 4, 17},
+	"UID":          {0, 9, 2342, 19200300, 100, 1, 1},
+	"DC":           {0, 9, 2342, 19200300, 100, 1, 25},
+	"EMAIL":        {1, 2, 840, 113549, 1, 9, 1},
+}
+
+// RegisterIdentifierExtractorTemplateArgs register CLI args used by identifier extractor of
+// IdentifierExtractorTypeTemplate type
+func RegisterIdentifierExtractorTemplateArgs(flags *flag.FlagSet) {
+	flags.StringVar(&tlsIdentifierExtractorTemplate, "tls_identifier_extractor_template", "",
+		fmt.Sprintf("Template of certificate identifier used as ClientID with --tls_identifier_extractor_type=%s. Fields in braces are replaced with values from certificate: "+
+			"subject attributes ({CN}, {OU}, {O}, {C}, {L}, {ST}, {DC}, {UID}, ...), {serial}, {san:dns|email|uri|ip} or {oid:<OID>} of subject attribute or extension. E.g. '{OU}/{CN}'", IdentifierExtractorTypeTemplate))
+	flags.StringVar(&tlsIdentifierNormalization, "tls_identifier_normalization", "",
+		fmt.Sprintf("Comma separated normalization rules applied to values of fields of --tls_identifier_extractor_template: <%s>", strings.Join(IdentifierNormalizationList, "|")))
+}
+
+// SetIdentifierExtractorTemplate overrides values of --tls_identifier_extractor_template and
+// --tls_identifier_normalization, used when they are taken from config of another service
+func SetIdentifierExtractorTemplate(template, normalization string) {
+	tlsIdentifierExtractorTemplate = template
+	tlsIdentifierNormalization = normalization
+}
+
+// templateField is placeholder of template replaced with value from certificate
+type templateField interface {
+	value(certificate *x509.Certificate) (string, bool)
+}
+
+// templateLiteral is text of template outside of placeholders
+type templateLiteral string
+
+func (l templateLiteral) value(*x509.Certificate) (string, bool) {
+	return string(l), true
+}
+
+// subjectAttributeField is value of subject attribute, multiple values are joined with comma
+type subjectAttributeField asn1.ObjectIdentifier
+
+func (f subjectAttributeField) value(certificate *x509.Certificate) (string, bool) {
+	// Names contains all attributes of parsed certificates, including ones without fields in pkix.Name
+	attributes := certificate.Subject.Names
+	if len(attributes) == 0 {
+		for _, rdn := range certificate.Subject.ToRDNSequence() {
+			attributes = append(attributes, rdn...)
+		}
+	}
+	var values []string
+	for _, attribute := range attributes {
+		if attribute.Type.Equal(asn1.ObjectIdentifier(f)) {
+			values = append(values, fmt.Sprintf("%v", attribute.Value))
+		}
+	}
+	return strings.Join(values, ","), len(values) > 0
+}
+
+// oidField is value of subject attribute or, if subject doesn't contain it, value of certificate extension
+type oidField asn1.ObjectIdentifier
+
+func (f oidField) value(certificate *x509.Certificate) (string, bool) {
+	if value, ok := subjectAttributeField(f).value(certificate); ok {
+		return value, true
+	}
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(asn1.ObjectIdentifier(f)) {
+			continue
+		}
+		// string values are used as is, others are hex encoded
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(extension.Value, &raw); err == nil && len(rest) == 0 && raw.Class == asn1.ClassUniversal {
+			switch raw.Tag {
+			case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, asn1.TagT61String:
+				return string(raw.Bytes), true
+			}
+		}
+		return hex.EncodeToString(extension.Value), true
+	}
+	return "", false
+}
+
+// serialField is certificate's serial number in hex
+type serialField struct{}
+
+func (serialField) value(certificate *x509.Certificate) (string, bool) {
+	if certificate.SerialNumber == nil {
+		return "", false
+	}
+	return certificate.SerialNumber.Text(16), true
+}
+
+// sanField is the first value of subject alternative name of some type
+type sanField string
+
+func (f sanField) value(certificate *x509.Certificate) (string, bool) {
+	switch f {
+	case "dns":
+		if len(certificate.DNSNames) > 0 {
+			return certificate.DNSNames[0], true
+		}
+	case "email":
+		if len(certificate.EmailAddresses) > 0 {
+			return certificate.EmailAddresses[0], true
+		}
+	case "uri":
+		if len(certificate.URIs) > 0 {
+			return certificate.URIs[0].String(), true
+		}
+	case "ip":
+		if len(certificate.IPAddresses) > 0 {
+			return certificate.IPAddresses[0].String(), true
+		}
+	}
+	return "", false
+}
+
+// parseTemplateField returns field by name of placeholder
+func parseTemplateField(name string) (templateField, error) {
+	if oid, ok := subjectAttributeOIDs[strings.ToUpper(name)]; ok {
+		return subjectAttributeField(oid), nil
+	}
+	if name == "serial" {
+		return serialField{}, nil
+	}
+	if strings.HasPrefix(name, "san:") {
+		switch sanType := strings.TrimPrefix(name, "san:"); sanType {
+		case "dns", "email", "uri", "ip":
+			return sanField(sanType), nil
+		}
+	}
+	if strings.HasPrefix(name, "oid:") {
+		var oid asn1.ObjectIdentifier
+		for _, part := range strings.Split(strings.TrimPrefix(name, "oid:"), ".") {
+			number, err := strconv.Atoi(part)
+			if err != nil || number < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownIdentifierTemplateField, name)
+			}
+			oid = append(oid, number)
+		}
+		if len(oid) < 2 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownIdentifierTemplateField, name)
+		}
+		return oidField(oid), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownIdentifierTemplateField, name)
+}
+
+// TemplateIdentifierExtractor implementation for CertificateIdentifierExtractor interface, which return identifier
+// composed by template from certificate's fields
+type TemplateIdentifierExtractor struct {
+	parts      []templateField
+	normalizer func(string) string
+}
+
+// NewTemplateIdentifierExtractor returns extractor which replaces fields in braces of template with values from
+// certificate normalized by normalization rules
+func NewTemplateIdentifierExtractor(template string, normalizations []string) (*TemplateIdentifierExtractor, error) {
+	var parts []templateField
+	rest := template
+	hasFields := false
+	for rest != "" {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			parts = append(parts, templateLiteral(rest))
+			break
+		}
+		if rest[start] == '}' {
+			return nil, ErrInvalidIdentifierTemplate
+		}
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] != '}' {
+			return nil, ErrInvalidIdentifierTemplate
+		}
+		if start > 0 {
+			parts = append(parts, templateLiteral(rest[:start]))
+		}
+		field, err := parseTemplateField(strings.TrimSpace(rest[start+1 : start+1+end]))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, field)
+		hasFields = true
+		rest = rest[start+1+end+1:]
+	}
+	if !hasFields {
+		return nil, ErrInvalidIdentifierTemplate
+	}
+	normalizer, err := newIdentifierNormalizer(normalizations)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateIdentifierExtractor{parts: parts, normalizer: normalizer}, nil
+}
+
+// newIdentifierNormalizer returns function which applies normalization rules, whitespaces are handled before case
+func newIdentifierNormalizer(normalizations []string) (func(string) string, error) {
+	enabled := make(map[string]bool, len(normalizations))
+	for _, normalization := range normalizations {
+		normalization = strings.TrimSpace(normalization)
+		if normalization == "" {
+			continue
+		}
+		known := false
+		for _, value := range IdentifierNormalizationList {
+			known = known || value == normalization
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownIdentifierNormalization, normalization)
+		}
+		enabled[normalization] = true
+	}
+	if enabled[IdentifierNormalizationLowercase] && enabled[IdentifierNormalizationUppercase] {
+		return nil, ErrConflictedIdentifierNormalizers
+	}
+	return func(value string) string {
+		if enabled[IdentifierNormalizationCollapseSpaces] {
+			value = strings.Join(strings.Fields(value), " ")
+		}
+		if enabled[IdentifierNormalizationTrim] {
+			value = strings.TrimSpace(value)
+		}
+		if enabled[IdentifierNormalizationLowercase] {
+			value = strings.ToLower(value)
+		}
+		if enabled[IdentifierNormalizationUppercase] {
+			value = strings.ToUpper(value)
+		}
+		return value
+	}, nil
+}
+
+// GetCertificateIdentifier return identifier composed by template
+func (e *TemplateIdentifierExtractor) GetCertificateIdentifier(certificate *x509.Certificate) ([]byte, error) {
+	if certificate == nil {
+		return nil, ErrNoPeerCertificate
+	}
+	var identifier strings.Builder
+	for _, part := range e.parts {
+		value, ok := part.value(certificate)
+		if !ok {
+			return nil, ErrMissingIdentifierTemplateField
+		}
+		if _, isLiteral := part.(templateLiteral); !isLiteral {
+			value = e.normalizer(value)
+		}
+		identifier.WriteString(value)
+	}
+	if identifier.Len() == 0 {
+		return nil, ErrEmptyIdentifier
+	}
+	return []byte(identifier.String()), nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+)
+
+func TestTemplateIdentifierExtractor(t *testing.T) {
+	employeeIDOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	employeeID, err := asn1.Marshal("E-1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabc),
+		Subject: pkix.Name{
+			Names: []pkix.AttributeTypeAndValue{
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "  Acra   Writer "},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Payments"},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "EU"},
+			},
+		},
+		DNSNames:    []string{"writer.example.org"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		Extensions: []pkix.Extension{
+			{Id: employeeIDOID, Value: employeeID},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}, Value: []byte{0x02, 0x01, 0x05}},
+		},
+	}
+	testcases := []struct {
+		template       string
+		normalizations []string
+		expected       string
+		err            error
+	}{
+		{"{OU}/{CN}", nil, "Payments,EU/  Acra   Writer ", nil},
+		{"{ou}/{cn}", []string{"trim", "collapse_spaces", "lowercase"}, "payments,eu/acra writer", nil},
+		{"{CN}", []string{IdentifierNormalizationUppercase, IdentifierNormalizationTrim}, "ACRA   WRITER", nil},
+		{"id-{oid:1.3.6.1.4.1.99999.1}", nil, "id-E-1024", nil},
+		{"{oid:1.3.6.1.4.1.99999.2}", nil, "020105", nil},
+		{"{oid:2.5.4.11}", nil, "Payments,EU", nil},
+		{"{ serial }@{san:dns} {san:ip}", nil, "abc@writer.example.org 10.0.0.1", nil},
+		{"{san:email}", nil, "", ErrMissingIdentifierTemplateField},
+		{"{O}", nil, "", ErrMissingIdentifierTemplateField},
+		{"{unknown}", nil, "", ErrUnknownIdentifierTemplateField},
+		{"{oid:1.x}", nil, "", ErrUnknownIdentifierTemplateField},
+		{"{san:rfc822}", nil, "", ErrUnknownIdentifierTemplateField},
+		{"static", nil, "", ErrInvalidIdentifierTemplate},
+		{"", nil, "", ErrInvalidIdentifierTemplate},
+		{"{CN", nil, "", ErrInvalidIdentifierTemplate},
+		{"CN}", nil, "", ErrInvalidIdentifierTemplate},
+		{"{{CN}}", nil, "", ErrInvalidIdentifierTemplate},
+		{"{CN}", []string{"reverse"}, "", ErrUnknownIdentifierNormalization},
+		{"{CN}", []string{"lowercase", "uppercase"}, "", ErrConflictedIdentifierNormalizers},
+	}
+	for i, testcase := range testcases {
+		extractor, err := NewTemplateIdentifierExtractor(testcase.template, testcase.normalizations)
+		if err == nil {
+			var identifier []byte
+			identifier, err = extractor.GetCertificateIdentifier(certificate)
+			if string(identifier) != testcase.expected {
+				t.Fatalf("[%d] Expected %q, took %q", i, testcase.expected, identifier)
+			}
+		}
+		if !errors.Is(err, testcase.err) {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+	}
+}
+
+func TestTemplateIdentifierExtractorByType(t *testing.T) {
+	defer SetIdentifierExtractorTemplate("", "")
+	SetIdentifierExtractorTemplate("", "")
+	if _, err := NewIdentifierExtractorByType(IdentifierExtractorTypeTemplate); err != ErrInvalidIdentifierTemplate {
+		t.Fatalf("Expected %v for empty template, took %v", ErrInvalidIdentifierTemplate, err)
+	}
+	SetIdentifierExtractorTemplate("{CN}", "trim, lowercase")
+	extractor, err := NewIdentifierExtractorByType(IdentifierExtractorTypeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifier, err := extractor.GetCertificateIdentifier(&x509.Certificate{Subject: pkix.Name{CommonName: " Writer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(identifier) != "writer" {
+		t.Fatalf("Expected writer, took %s", identifier)
+	}
+	if _, err := extractor.GetCertificateIdentifier(nil); err != ErrNoPeerCertificate {
+		t.Fatalf("Expected %v, took %v", ErrNoPeerCertificate, err)
+	}
+}
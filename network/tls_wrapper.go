@@ -140,18 +140,7 @@ func NewTLSConfigByName(flags *flag.FlagSet, name, host string, namerFunc CLIPar
 	if f := flags.Lookup(namerFunc(name, "sni", "")); f != nil {
 		sni = f.Value.String()
 	}
-	if f := flags.Lookup(namerFunc(name, "cert", "")); f != nil {
-		cert = f.Value.String()
-		if cert == "" {
-			cert = tlsCert
-		}
-	}
-	if f := flags.Lookup(namerFunc(name, "key", "")); f != nil {
-		key = f.Value.String()
-		if key == "" {
-			key = tlsKey
-		}
-	}
+	cert, key = TLSCertificatePathsByName(flags, name, namerFunc)
 	if f := flags.Lookup(namerFunc(name, "auth", "")); f != nil {
 		v, err := strconv.ParseInt(f.Value.String(), 10, 64)
 		if err != nil {
@@ -177,6 +166,24 @@ func NewTLSConfigByName(flags *flag.FlagSet, name, host string, namerFunc CLIPar
 	return NewTLSConfig(SNIOrHostname(sni, host), ca, key, cert, auth, verifier)
 }
 
+// TLSCertificatePathsByName returns paths to certificate and private key from flags registered via
+// RegisterTLSArgsForService or from --tls_cert and --tls_key if they are not specified
+func TLSCertificatePathsByName(flags *flag.FlagSet, name string, namerFunc CLIParamNameConstructorFunc) (cert, key string) {
+	if f := flags.Lookup(namerFunc(name, "cert", "")); f != nil {
+		cert = f.Value.String()
+		if cert == "" {
+			cert = tlsCert
+		}
+	}
+	if f := flags.Lookup(namerFunc(name, "key", "")); f != nil {
+		key = f.Value.String()
+		if key == "" {
+			key = tlsKey
+		}
+	}
+	return cert, key
+}
+
 // NewTLSConfigFromBaseArgs return new tls clientConfig with params passed by cli params
 func NewTLSConfigFromBaseArgs() (*tls.Config, error) {
 	certVerifier, err := NewCertVerifier()
@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cossacklabs/themis/gothemis/errors"
 	log "github.com/sirupsen/logrus"
@@ -166,6 +167,22 @@ func Dial(connectionString string) (net.Conn, error) {
 	return newSafeCloseConnection(conn), err
 }
 
+// DialWithTimeout connectionString like Dial but fails if connection isn't established in timeout
+func DialWithTimeout(connectionString string, timeout time.Duration) (net.Conn, error) {
+	url, err := url_.Parse(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	url.Scheme = customSchemeToBaseGolangScheme(url.Scheme)
+	var conn net.Conn
+	if url.Scheme == "unix" {
+		conn, err = net.DialTimeout(url.Scheme, url.Path, timeout)
+	} else {
+		conn, err = net.DialTimeout(url.Scheme, url.Host, timeout)
+	}
+	return newSafeCloseConnection(conn), err
+}
+
 // ListenerWithFileDescriptor listens to file
 type ListenerWithFileDescriptor interface {
 	net.Listener
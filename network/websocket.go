@@ -0,0 +1,211 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	url_ "net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket tunnel schemes
+const (
+	WebSocketScheme       = "ws"
+	WebSocketSecureScheme = "wss"
+)
+
+// WebSocket listener errors
+var (
+	ErrUnsupportedWebSocketScheme = errors.New("unsupported scheme of WebSocket connection string, expected ws or wss")
+	ErrWebSocketTLSConfigRequired = errors.New("wss connection string requires TLS configuration")
+)
+
+// webSocketConnectionKey is the key of the accepted TCP connection in the context of HTTP request
+type webSocketConnectionKey struct{}
+
+// webSocketConnection is a binary WebSocket stream used as a tunneled database connection
+type webSocketConnection struct {
+	*websocket.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// LocalAddr returns local address of the underlying TCP connection
+func (conn *webSocketConnection) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+// RemoteAddr returns address of the client instead of WebSocket origin
+func (conn *webSocketConnection) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// Close closes WebSocket connection and releases the handler of WebSocket request
+func (conn *webSocketConnection) Close() error {
+	err := conn.Conn.Close()
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+	return err
+}
+
+// webSocketListener accepts WebSocket connections over HTTP(S) and returns them as tunneled connections
+type webSocketListener struct {
+	listener    net.Listener
+	server      *http.Server
+	connections chan net.Conn
+	closeOnce   sync.Once
+	closed      chan struct{}
+
+	lock             sync.Mutex
+	deadlineTimer    *time.Timer
+	deadlineExceeded chan struct{}
+	deadlineUpdated  chan struct{}
+}
+
+// ListenWebSocket returns listener for connection string like ws://host:port/path or wss://host:port/path that
+// accepts database protocol tunneled over binary WebSocket messages. wss connection string terminates TLS with tlsConfig.
+func ListenWebSocket(connectionString string, tlsConfig *tls.Config) (net.Listener, error) {
+	url, err := url_.Parse(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme != WebSocketScheme && url.Scheme != WebSocketSecureScheme {
+		return nil, ErrUnsupportedWebSocketScheme
+	}
+	if url.Scheme == WebSocketSecureScheme && tlsConfig == nil {
+		return nil, ErrWebSocketTLSConfigRequired
+	}
+	path := url.Path
+	if path == "" {
+		path = "/"
+	}
+	listener, err := net.Listen("tcp", url.Host)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme == WebSocketSecureScheme {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	wsListener := &webSocketListener{
+		listener:         listener,
+		connections:      make(chan net.Conn),
+		closed:           make(chan struct{}),
+		deadlineExceeded: make(chan struct{}),
+		deadlineUpdated:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	// websocket.Server without Handshake accepts requests with any Origin, applications aren't browsers
+	mux.Handle(path, websocket.Server{Handler: wsListener.handle})
+	wsListener.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: DefaultNetworkTimeout,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, webSocketConnectionKey{}, conn)
+		},
+	}
+	go func() {
+		if err := wsListener.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).WithField("connection_string", connectionString).Errorln("WebSocket listener stopped")
+		}
+		wsListener.Close()
+	}()
+	return wsListener, nil
+}
+
+// handle passes WebSocket connection to Accept and blocks until it is closed, because returning from handler closes it
+func (listener *webSocketListener) handle(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	conn := &webSocketConnection{Conn: ws, localAddr: listener.listener.Addr(), remoteAddr: ws.RemoteAddr(), closed: make(chan struct{})}
+	if tcpConn, ok := ws.Request().Context().Value(webSocketConnectionKey{}).(net.Conn); ok {
+		conn.localAddr = tcpConn.LocalAddr()
+		conn.remoteAddr = tcpConn.RemoteAddr()
+	}
+	select {
+	case listener.connections <- conn:
+	case <-listener.closed:
+		ws.Close()
+		return
+	}
+	<-conn.closed
+}
+
+// Accept waits for the next tunneled connection
+func (listener *webSocketListener) Accept() (net.Conn, error) {
+	for {
+		listener.lock.Lock()
+		deadlineExceeded, deadlineUpdated := listener.deadlineExceeded, listener.deadlineUpdated
+		listener.lock.Unlock()
+		select {
+		case conn := <-listener.connections:
+			return conn, nil
+		case <-listener.closed:
+			return nil, net.ErrClosed
+		case <-deadlineExceeded:
+			return nil, os.ErrDeadlineExceeded
+		case <-deadlineUpdated:
+			continue
+		}
+	}
+}
+
+// SetDeadline sets deadline for Accept calls, zero value disables it
+func (listener *webSocketListener) SetDeadline(t time.Time) error {
+	listener.lock.Lock()
+	defer listener.lock.Unlock()
+	if listener.deadlineTimer != nil {
+		listener.deadlineTimer.Stop()
+		listener.deadlineTimer = nil
+	}
+	deadlineExceeded := make(chan struct{})
+	listener.deadlineExceeded = deadlineExceeded
+	if !t.IsZero() {
+		listener.deadlineTimer = time.AfterFunc(time.Until(t), func() {
+			close(deadlineExceeded)
+		})
+	}
+	// wake up pending Accept calls to use new deadline
+	close(listener.deadlineUpdated)
+	listener.deadlineUpdated = make(chan struct{})
+	return nil
+}
+
+// Close stops accepting new WebSocket connections. Already accepted connections stay open
+func (listener *webSocketListener) Close() error {
+	var err error
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+		err = listener.server.Close()
+	})
+	return err
+}
+
+// Addr returns address of the listener
+func (listener *webSocketListener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
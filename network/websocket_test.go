@@ -0,0 +1,106 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestListenWebSocketInvalidConnectionString(t *testing.T) {
+	_, err := ListenWebSocket("tcp://127.0.0.1:0", nil)
+	assert.Equal(t, ErrUnsupportedWebSocketScheme, err)
+
+	_, err = ListenWebSocket("wss://127.0.0.1:0", nil)
+	assert.Equal(t, ErrWebSocketTLSConfigRequired, err)
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	listener, err := ListenWebSocket("ws://127.0.0.1:0/tunnel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	address := listener.Addr().String()
+	client, err := websocket.Dial(fmt.Sprintf("ws://%s/tunnel", address), "", fmt.Sprintf("http://%s/", address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.PayloadType = websocket.BinaryFrame
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	assert.True(t, ok && remoteAddr.IP.IsLoopback())
+
+	request := []byte{0, 0, 0, 8, 4, 210, 22, 47}
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len(request))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, request, data)
+
+	response := []byte("N")
+	if _, err := conn.Write(response); err != nil {
+		t.Fatal(err)
+	}
+	data = make([]byte, len(response))
+	if _, err := io.ReadFull(client, data); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response, data)
+}
+
+func TestWebSocketListenerDeadline(t *testing.T) {
+	listener, err := ListenWebSocket("ws://127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadlineListener, err := CastListenerToDeadline(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deadlineListener.SetDeadline(time.Now().Add(time.Millisecond * 100)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = listener.Accept()
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok && netErr.Timeout())
+
+	if err := deadlineListener.SetDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, listener.Close())
+	_, err = listener.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
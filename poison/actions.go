@@ -0,0 +1,296 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poison
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Action types which may be used in poison record action pipeline configuration
+const (
+	ActionTypeLog              = "log"
+	ActionTypeMetric           = "metric"
+	ActionTypeWebhook          = "webhook"
+	ActionTypeRunScript        = "run_script"
+	ActionTypeTerminateSession = "terminate_session"
+	ActionTypeShutdown         = "shutdown"
+	ActionTypeRotateKeysAlert  = "rotate_keys_alert"
+)
+
+// SupportedActionTypes list of all action types that may be configured
+var SupportedActionTypes = []string{
+	ActionTypeLog,
+	ActionTypeMetric,
+	ActionTypeWebhook,
+	ActionTypeRunScript,
+	ActionTypeTerminateSession,
+	ActionTypeShutdown,
+	ActionTypeRotateKeysAlert,
+}
+
+// DefaultWebhookTimeout used for webhook action if timeout not specified
+const DefaultWebhookTimeout = time.Second * 5
+
+// Errors returned by poison record actions
+var (
+	ErrTerminateSession      = errors.New("session terminated due to poison record detection")
+	ErrUnsupportedActionType = errors.New("unsupported poison record action type")
+	ErrInvalidActionConfig   = errors.New("invalid poison record action configuration")
+	ErrWebhookFailed         = errors.New("poison record webhook returned unexpected status")
+)
+
+// Action is one step of poison record action pipeline. Actions implement base.Callback to be compatible with
+// CallbackStorage and base.ContextCallback to get information about client which triggered poison record
+type Action interface {
+	base.Callback
+	base.ContextCallback
+	Type() string
+}
+
+// clientIDFromContext returns clientID of the connection where poison record was detected or nil
+func clientIDFromContext(ctx context.Context) []byte {
+	if ctx == nil {
+		return nil
+	}
+	return base.AccessContextFromContext(ctx).GetClientID()
+}
+
+// LogAction logs poison record detection with clientID
+type LogAction struct{}
+
+// Type returns ActionTypeLog
+func (LogAction) Type() string {
+	return ActionTypeLog
+}
+
+// Call logs detection without context
+func (action LogAction) Call() error {
+	return action.CallWithContext(context.Background())
+}
+
+// CallWithContext logs detection with clientID from context
+func (LogAction) CallWithContext(ctx context.Context) error {
+	log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetectionMessage).
+		WithField("client_id", string(clientIDFromContext(ctx))).Warningln("Recognized poison record")
+	return nil
+}
+
+// MetricAction increments prometheus counter of detected poison records
+type MetricAction struct{}
+
+// Type returns ActionTypeMetric
+func (MetricAction) Type() string {
+	return ActionTypeMetric
+}
+
+// Call increments counter
+func (action MetricAction) Call() error {
+	return action.CallWithContext(context.Background())
+}
+
+// CallWithContext increments counter
+func (MetricAction) CallWithContext(ctx context.Context) error {
+	PoisonRecordDetectionCounter.Inc()
+	return nil
+}
+
+// webhookPayload is JSON body sent by WebhookAction
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookEventPoisonRecordDetected value of event field in webhook payload
+const WebhookEventPoisonRecordDetected = "poison_record_detected"
+
+// WebhookSignatureHeader is HTTP header with HMAC-SHA256 of request body signed by webhook secret
+const WebhookSignatureHeader = "X-Acra-Signature"
+
+// webhookSignaturePrefix precedes hex encoded signature in WebhookSignatureHeader value
+const webhookSignaturePrefix = "sha256="
+
+// SignWebhookPayload returns value of WebhookSignatureHeader for payload signed with secret
+func SignWebhookPayload(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookAction sends POST request with JSON description of detection to configured URL
+type WebhookAction struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookAction returns new WebhookAction which uses timeout for requests
+func NewWebhookAction(url string, timeout time.Duration) (*WebhookAction, error) {
+	if url == "" {
+		return nil, fmt.Errorf("%w: empty webhook url", ErrInvalidActionConfig)
+	}
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	return &WebhookAction{url: url, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// NewSignedWebhookAction returns new WebhookAction which signs payload with secret and sends signature
+// in WebhookSignatureHeader so receiver can verify that notification was sent by Acra
+func NewSignedWebhookAction(url string, timeout time.Duration, secret []byte) (*WebhookAction, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: empty webhook secret", ErrInvalidActionConfig)
+	}
+	action, err := NewWebhookAction(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+	action.secret = secret
+	return action, nil
+}
+
+// Type returns ActionTypeWebhook
+func (*WebhookAction) Type() string {
+	return ActionTypeWebhook
+}
+
+// Call sends webhook without clientID
+func (action *WebhookAction) Call() error {
+	return action.CallWithContext(context.Background())
+}
+
+// CallWithContext sends webhook with clientID from context
+func (action *WebhookAction) CallWithContext(ctx context.Context) error {
+	body, err := json.Marshal(webhookPayload{
+		Event:     WebhookEventPoisonRecordDetected,
+		ClientID:  string(clientIDFromContext(ctx)),
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, action.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(action.secret) > 0 {
+		request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(action.secret, body))
+	}
+	response, err := action.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %d", ErrWebhookFailed, response.StatusCode)
+	}
+	return nil
+}
+
+// RunScriptAction executes script on detecting poison record
+type RunScriptAction struct {
+	*ExecuteScriptCallback
+}
+
+// NewRunScriptAction returns new RunScriptAction for script by path
+func NewRunScriptAction(path string) (*RunScriptAction, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: empty script path", ErrInvalidActionConfig)
+	}
+	return &RunScriptAction{NewExecuteScriptCallback(path)}, nil
+}
+
+// Type returns ActionTypeRunScript
+func (*RunScriptAction) Type() string {
+	return ActionTypeRunScript
+}
+
+// CallWithContext runs script
+func (action *RunScriptAction) CallWithContext(ctx context.Context) error {
+	return action.Call()
+}
+
+// TerminateSessionAction interrupts processing of current client's connection. It returns ErrTerminateSession
+// which stops the pipeline and closes connection with client
+type TerminateSessionAction struct{}
+
+// Type returns ActionTypeTerminateSession
+func (TerminateSessionAction) Type() string {
+	return ActionTypeTerminateSession
+}
+
+// Call returns ErrTerminateSession
+func (action TerminateSessionAction) Call() error {
+	return action.CallWithContext(context.Background())
+}
+
+// CallWithContext returns ErrTerminateSession
+func (TerminateSessionAction) CallWithContext(ctx context.Context) error {
+	log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetectionMessage).
+		WithField("client_id", string(clientIDFromContext(ctx))).Warningln("Detected poison record, terminate session")
+	return ErrTerminateSession
+}
+
+// ShutdownAction stops the service on detecting poison record
+type ShutdownAction struct {
+	StopCallback
+}
+
+// Type returns ActionTypeShutdown
+func (*ShutdownAction) Type() string {
+	return ActionTypeShutdown
+}
+
+// CallWithContext stops the service
+func (action *ShutdownAction) CallWithContext(ctx context.Context) error {
+	return action.Call()
+}
+
+// RotateKeysAlertAction logs alert that keys used with compromised data should be rotated
+type RotateKeysAlertAction struct{}
+
+// Type returns ActionTypeRotateKeysAlert
+func (RotateKeysAlertAction) Type() string {
+	return ActionTypeRotateKeysAlert
+}
+
+// Call logs alert
+func (action RotateKeysAlertAction) Call() error {
+	return action.CallWithContext(context.Background())
+}
+
+// CallWithContext logs alert with clientID from context
+func (RotateKeysAlertAction) CallWithContext(ctx context.Context) error {
+	log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordRotateKeysAlert).
+		WithField("client_id", string(clientIDFromContext(ctx))).
+		Errorln("Detected poison record, keys of affected clientID should be rotated")
+	return nil
+}
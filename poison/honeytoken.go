@@ -0,0 +1,72 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poison
+
+import (
+	"errors"
+
+	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+// ErrUnsupportedTokenType returned for token types which can't store poison records
+var ErrUnsupportedTokenType = errors.New("poison record may be tokenized only with str or bytes token type")
+
+// CreateSearchablePoisonRecord generates poison record prefixed with HMAC of its data calculated with search key of
+// clientID, same as values of searchable encrypted columns. AcraBlock is used instead of AcraStruct if symmetric is true
+func CreateSearchablePoisonRecord(keyStore keystore.PoisonKeyStorageAndGenerator, hmacKeyStore keystore.HmacKeyStore, clientID []byte, dataLength int, symmetric bool) ([]byte, error) {
+	data, err := createPoisonRecordData(dataLength)
+	if err != nil {
+		return nil, err
+	}
+	hmacKey, err := hmacKeyStore.GetHMACSecretKey(clientID)
+	if err != nil {
+		return nil, err
+	}
+	var poisonRecord []byte
+	if symmetric {
+		poisonRecord, err = createSymmetricPoisonRecordWithData(keyStore, data)
+	} else {
+		poisonRecord, err = createPoisonRecordWithData(keyStore, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(hmac.GenerateHMAC(hmacKey, data), poisonRecord...), nil
+}
+
+// CreateTokenizedPoisonRecord saves poison record in token storage of tokenizer and returns token which is
+// detokenized into poison record for columns with tokenType and clientID
+func CreateTokenizedPoisonRecord(poisonRecord []byte, tokenizer common.Pseudoanonymizer, clientID []byte, tokenType common.TokenType) ([]byte, error) {
+	context := common.TokenContext{ClientID: clientID}
+	switch tokenType {
+	case common.TokenType_String:
+		token, err := tokenizer.Anonymize(string(poisonRecord), context, tokenType)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(token.(string)), nil
+	case common.TokenType_Bytes:
+		token, err := tokenizer.Anonymize(poisonRecord, context, tokenType)
+		if err != nil {
+			return nil, err
+		}
+		return token.([]byte), nil
+	}
+	return nil, ErrUnsupportedTokenType
+}
@@ -0,0 +1,132 @@
+package poison
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/acra/acrablock"
+	"github.com/cossacklabs/acra/crypto"
+	"github.com/cossacklabs/acra/hmac"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/pseudonymization"
+	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/pseudonymization/storage"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+type testSymmetricKeystore struct {
+	symmetricKey []byte
+	hmacKeys     map[string][]byte
+}
+
+func (store *testSymmetricKeystore) GetPoisonKeyPair() (*keys.Keypair, error) {
+	panic("implement me")
+}
+
+func (store *testSymmetricKeystore) GetPoisonPrivateKeys() ([]*keys.PrivateKey, error) {
+	panic("implement me")
+}
+
+func (store *testSymmetricKeystore) GetPoisonSymmetricKeys() ([][]byte, error) {
+	return [][]byte{store.symmetricKey}, nil
+}
+
+func (store *testSymmetricKeystore) GetPoisonSymmetricKey() ([]byte, error) {
+	return append([]byte{}, store.symmetricKey...), nil
+}
+
+func (store *testSymmetricKeystore) GeneratePoisonSymmetricKey() error {
+	panic("implement me")
+}
+
+func (store *testSymmetricKeystore) GeneratePoisonKeyPair() error {
+	panic("implement me")
+}
+
+func (store *testSymmetricKeystore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	key, ok := store.hmacKeys[string(id)]
+	if !ok {
+		return nil, keystore.ErrKeysNotFound
+	}
+	return append([]byte{}, key...), nil
+}
+
+func TestCreateSearchablePoisonRecord(t *testing.T) {
+	symmetricKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte("hmac key")
+	store := &testSymmetricKeystore{symmetricKey: symmetricKey, hmacKeys: map[string][]byte{"client": hmacKey}}
+
+	poisonRecord, err := CreateSearchablePoisonRecord(store, store, []byte("client"), 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, container := hmac.ExtractHashAndData(poisonRecord)
+	if hash == nil {
+		t.Fatal("Expected searchable poison record with hash prefix")
+	}
+	internal, envelopeID, err := crypto.DeserializeEncryptedData(container)
+	if err != nil || envelopeID != crypto.AcraBlockEnvelopeID {
+		t.Fatalf("Expected serialized AcraBlock, took %v, %v", envelopeID, err)
+	}
+	acraBlock, err := acrablock.NewAcraBlockFromData(internal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := acraBlock.Decrypt([][]byte{symmetricKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 10 || !hash.IsEqual(data, []byte("client"), store) {
+		t.Fatal("Expected hash of poison record data with client's HMAC key")
+	}
+
+	if _, err := CreateSearchablePoisonRecord(store, store, []byte("unknown"), 10, true); !errors.Is(err, keystore.ErrKeysNotFound) {
+		t.Fatalf("Expected %v, took %v", keystore.ErrKeysNotFound, err)
+	}
+}
+
+func TestCreateTokenizedPoisonRecord(t *testing.T) {
+	tokenStorage, err := storage.NewMemoryTokenStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenizer, err := pseudonymization.NewPseudoanonymizer(tokenStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poisonRecord := []byte("poison record")
+	clientID := []byte("client")
+	context := common.TokenContext{ClientID: clientID}
+
+	token, err := CreateTokenizedPoisonRecord(poisonRecord, tokenizer, clientID, common.TokenType_Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := tokenizer.Deanonymize(token, context, common.TokenType_Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value.([]byte), poisonRecord) {
+		t.Fatalf("Expected poison record, took %q", value)
+	}
+
+	token, err = CreateTokenizedPoisonRecord(poisonRecord, tokenizer, clientID, common.TokenType_String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err = tokenizer.Deanonymize(string(token), context, common.TokenType_String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.(string) != string(poisonRecord) {
+		t.Fatalf("Expected poison record, took %q", value)
+	}
+
+	if _, err := CreateTokenizedPoisonRecord(poisonRecord, tokenizer, clientID, common.TokenType_Int32); err != ErrUnsupportedTokenType {
+		t.Fatalf("Expected %v, took %v", ErrUnsupportedTokenType, err)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poison
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"gopkg.in/yaml.v2"
+)
+
+// ActionConfig describes one action of the pipeline in YAML configuration
+type ActionConfig struct {
+	Type string `yaml:"type"`
+	// URL used by webhook action
+	URL string `yaml:"url,omitempty"`
+	// Timeout used by webhook action, in Go duration format (5s, 1m)
+	Timeout string `yaml:"timeout,omitempty"`
+	// SecretEnv is name of environment variable with secret used by webhook action to sign payload
+	SecretEnv string `yaml:"secret_env,omitempty"`
+	// Script used by run_script action
+	Script string `yaml:"script,omitempty"`
+}
+
+// ClientActionsConfig overrides default pipeline for specific clientID
+type ClientActionsConfig struct {
+	ClientID string         `yaml:"client_id"`
+	Actions  []ActionConfig `yaml:"actions"`
+}
+
+// ActionsConfig is the root of poison record actions configuration file
+//
+//	actions:
+//	  - type: log
+//	  - type: webhook
+//	    url: https://incidents.example.com/acra
+//	    secret_env: ACRA_POISON_WEBHOOK_SECRET
+//	clients:
+//	  - client_id: reporting
+//	    actions:
+//	      - type: log
+//	      - type: terminate_session
+type ActionsConfig struct {
+	Actions []ActionConfig        `yaml:"actions"`
+	Clients []ClientActionsConfig `yaml:"clients"`
+}
+
+// NewActionFromConfig creates Action according to its configuration
+func NewActionFromConfig(config ActionConfig) (Action, error) {
+	switch config.Type {
+	case ActionTypeLog:
+		return LogAction{}, nil
+	case ActionTypeMetric:
+		return MetricAction{}, nil
+	case ActionTypeWebhook:
+		var timeout time.Duration
+		if config.Timeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(config.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid webhook timeout: %s", ErrInvalidActionConfig, err)
+			}
+		}
+		if config.SecretEnv != "" {
+			secret := os.Getenv(config.SecretEnv)
+			if secret == "" {
+				return nil, fmt.Errorf("%w: empty webhook secret in %s environment variable", ErrInvalidActionConfig, config.SecretEnv)
+			}
+			return NewSignedWebhookAction(config.URL, timeout, []byte(secret))
+		}
+		return NewWebhookAction(config.URL, timeout)
+	case ActionTypeRunScript:
+		return NewRunScriptAction(config.Script)
+	case ActionTypeTerminateSession:
+		return TerminateSessionAction{}, nil
+	case ActionTypeShutdown:
+		return &ShutdownAction{}, nil
+	case ActionTypeRotateKeysAlert:
+		return RotateKeysAlertAction{}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedActionType, config.Type)
+}
+
+func newActionsFromConfig(configs []ActionConfig) ([]Action, error) {
+	actions := make([]Action, 0, len(configs))
+	for i, actionConfig := range configs {
+		action, err := NewActionFromConfig(actionConfig)
+		if err != nil {
+			return nil, fmt.Errorf("action #%d: %w", i, err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// ActionPipeline calls ordered list of actions on detecting poison record. Pipeline may have separate list of actions
+// for specific clientIDs which replace the default list. Actions called in sequence until the first error.
+// ActionPipeline implements base.PoisonRecordCallbackStorage and may be used instead of CallbackStorage
+type ActionPipeline struct {
+	callbacks       []base.Callback
+	clientCallbacks map[string][]base.Callback
+}
+
+// NewActionPipeline creates empty ActionPipeline
+func NewActionPipeline() *ActionPipeline {
+	return &ActionPipeline{clientCallbacks: make(map[string][]base.Callback)}
+}
+
+// NewActionPipelineFromConfig creates ActionPipeline with actions from config
+func NewActionPipelineFromConfig(config *ActionsConfig) (*ActionPipeline, error) {
+	pipeline := NewActionPipeline()
+	actions, err := newActionsFromConfig(config.Actions)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		pipeline.AddCallback(action)
+	}
+	for _, client := range config.Clients {
+		if client.ClientID == "" {
+			return nil, fmt.Errorf("%w: empty client_id in clients section", ErrInvalidActionConfig)
+		}
+		if _, ok := pipeline.clientCallbacks[client.ClientID]; ok {
+			return nil, fmt.Errorf("%w: duplicated client_id %s", ErrInvalidActionConfig, client.ClientID)
+		}
+		actions, err := newActionsFromConfig(client.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("client_id %s: %w", client.ClientID, err)
+		}
+		callbacks := make([]base.Callback, 0, len(actions))
+		for _, action := range actions {
+			callbacks = append(callbacks, action)
+		}
+		pipeline.clientCallbacks[client.ClientID] = callbacks
+	}
+	return pipeline, nil
+}
+
+// ParseActionsConfig parses YAML configuration of poison record actions
+func ParseActionsConfig(data []byte) (*ActionsConfig, error) {
+	config := &ActionsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewActionPipelineFromFile reads YAML configuration from file and creates ActionPipeline
+func NewActionPipelineFromFile(path string) (*ActionPipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseActionsConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewActionPipelineFromConfig(config)
+}
+
+// NewActionsConfigFromList creates configuration of default pipeline from comma separated list of action types
+// as it set with command line flags. Parameters of webhook and run_script actions are taken from template
+func NewActionsConfigFromList(actionTypes string, template ActionConfig) (*ActionsConfig, error) {
+	config := &ActionsConfig{}
+	for _, actionType := range strings.Split(actionTypes, ",") {
+		actionType = strings.TrimSpace(actionType)
+		if actionType == "" {
+			continue
+		}
+		actionConfig := template
+		actionConfig.Type = actionType
+		config.Actions = append(config.Actions, actionConfig)
+	}
+	if len(config.Actions) == 0 {
+		return nil, fmt.Errorf("%w: empty list of actions", ErrInvalidActionConfig)
+	}
+	return config, nil
+}
+
+// NewLegacyActionPipeline creates ActionPipeline equal to the behaviour configured with
+// --poison_run_script_file and --poison_shutdown_enable flags
+func NewLegacyActionPipeline(scriptPath string, shutdown bool) (*ActionPipeline, error) {
+	pipeline := NewActionPipeline()
+	pipeline.AddCallback(LogAction{})
+	pipeline.AddCallback(MetricAction{})
+	if scriptPath != "" {
+		action, err := NewRunScriptAction(scriptPath)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.AddCallback(action)
+	}
+	// should be the last action
+	if shutdown {
+		pipeline.AddCallback(&ShutdownAction{})
+	}
+	return pipeline, nil
+}
+
+// AddCallback adds callback to the end of default list
+func (pipeline *ActionPipeline) AddCallback(callback base.Callback) {
+	pipeline.callbacks = append(pipeline.callbacks, callback)
+}
+
+// HasCallbacks returns true if pipeline has any configured actions
+func (pipeline *ActionPipeline) HasCallbacks() bool {
+	return len(pipeline.callbacks) > 0 || len(pipeline.clientCallbacks) > 0
+}
+
+// Call calls default list of actions
+func (pipeline *ActionPipeline) Call() error {
+	return pipeline.CallWithContext(context.Background())
+}
+
+// CallWithContext calls list of actions configured for clientID from context or default list
+func (pipeline *ActionPipeline) CallWithContext(ctx context.Context) error {
+	for _, callback := range pipeline.callbacksForContext(ctx) {
+		var err error
+		if contextCallback, ok := callback.(base.ContextCallback); ok {
+			err = contextCallback.CallWithContext(ctx)
+		} else {
+			err = callback.Call()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pipeline *ActionPipeline) callbacksForContext(ctx context.Context) []base.Callback {
+	clientID := clientIDFromContext(ctx)
+	if len(clientID) == 0 {
+		return pipeline.callbacks
+	}
+	if callbacks, ok := pipeline.clientCallbacks[string(clientID)]; ok {
+		return callbacks
+	}
+	return pipeline.callbacks
+}
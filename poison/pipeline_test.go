@@ -0,0 +1,207 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poison
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func contextWithClientID(clientID string) context.Context {
+	return base.SetAccessContextToContext(context.Background(), base.NewAccessContext(base.WithClientID([]byte(clientID))))
+}
+
+func TestParseActionsConfig(t *testing.T) {
+	config, err := ParseActionsConfig([]byte(`
+actions:
+  - type: log
+  - type: webhook
+    url: http://localhost/
+    timeout: 1s
+clients:
+  - client_id: client
+    actions:
+      - type: terminate_session
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := NewActionPipelineFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline.callbacks) != 2 {
+		t.Fatalf("Expected 2 default actions, took %d", len(pipeline.callbacks))
+	}
+	if len(pipeline.clientCallbacks["client"]) != 1 {
+		t.Fatal("Expected 1 action for client")
+	}
+}
+
+func TestInvalidActionsConfig(t *testing.T) {
+	testcases := []struct {
+		config string
+		err    error
+	}{
+		{"actions:\n  - type: unknown", ErrUnsupportedActionType},
+		{"actions:\n  - type: webhook", ErrInvalidActionConfig},
+		{"actions:\n  - type: webhook\n    url: http://localhost\n    timeout: invalid", ErrInvalidActionConfig},
+		{"actions:\n  - type: run_script", ErrInvalidActionConfig},
+		{"actions:\n  - type: webhook\n    url: http://localhost\n    secret_env: ACRA_TEST_UNSET_WEBHOOK_SECRET", ErrInvalidActionConfig},
+		{"clients:\n  - actions:\n    - type: log", ErrInvalidActionConfig},
+		{"clients:\n  - client_id: a\n    actions: []\n  - client_id: a\n    actions: []", ErrInvalidActionConfig},
+	}
+	for i, tcase := range testcases {
+		config, err := ParseActionsConfig([]byte(tcase.config))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected error: %s", i, err)
+		}
+		if _, err := NewActionPipelineFromConfig(config); !errors.Is(err, tcase.err) {
+			t.Fatalf("[%d] Expected %s, took %v", i, tcase.err, err)
+		}
+	}
+	if _, err := ParseActionsConfig([]byte("unknown_field: 1")); err == nil {
+		t.Fatal("Expected error on unknown field")
+	}
+}
+
+func TestPipelineClientOverrides(t *testing.T) {
+	defaultCount := 0
+	clientCount := 0
+	pipeline := NewActionPipeline()
+	pipeline.AddCallback(&TestCallback{CallCount: &defaultCount})
+	pipeline.clientCallbacks["client"] = []base.Callback{&TestCallback{CallCount: &clientCount}, TerminateSessionAction{}, &TestCallback{CallCount: &clientCount}}
+
+	if err := pipeline.CallWithContext(contextWithClientID("another client")); err != nil {
+		t.Fatal(err)
+	}
+	if defaultCount != 1 || clientCount != 0 {
+		t.Fatal("Expected call of default actions")
+	}
+	if err := pipeline.CallWithContext(contextWithClientID("client")); !errors.Is(err, ErrTerminateSession) {
+		t.Fatalf("Expected ErrTerminateSession, took %v", err)
+	}
+	// pipeline should stop after terminate_session action
+	if defaultCount != 1 || clientCount != 1 {
+		t.Fatal("Expected call of client's actions until terminate_session")
+	}
+}
+
+func TestWebhookAction(t *testing.T) {
+	var payload webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if payload.ClientID == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	action, err := NewWebhookAction(server.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := action.CallWithContext(contextWithClientID("client")); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ClientID != "client" || payload.Event != WebhookEventPoisonRecordDetected {
+		t.Fatalf("Unexpected payload: %+v", payload)
+	}
+	if err := action.CallWithContext(contextWithClientID("fail")); !errors.Is(err, ErrWebhookFailed) {
+		t.Fatalf("Expected ErrWebhookFailed, took %v", err)
+	}
+}
+
+func TestSignedWebhookAction(t *testing.T) {
+	secret := []byte("secret")
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(WebhookSignatureHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	t.Setenv("ACRA_TEST_WEBHOOK_SECRET", string(secret))
+	action, err := NewActionFromConfig(ActionConfig{Type: ActionTypeWebhook, URL: server.URL, SecretEnv: "ACRA_TEST_WEBHOOK_SECRET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := action.CallWithContext(contextWithClientID("client")); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Fatalf("Expected signature %s, took %s", expected, signature)
+	}
+	if _, err := NewSignedWebhookAction(server.URL, 0, nil); !errors.Is(err, ErrInvalidActionConfig) {
+		t.Fatalf("Expected ErrInvalidActionConfig, took %v", err)
+	}
+}
+
+func TestNewActionsConfigFromList(t *testing.T) {
+	config, err := NewActionsConfigFromList("log, webhook,,terminate_session", ActionConfig{URL: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := NewActionPipelineFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{ActionTypeLog, ActionTypeWebhook, ActionTypeTerminateSession}
+	if len(pipeline.callbacks) != len(expected) {
+		t.Fatalf("Expected %d actions, took %d", len(expected), len(pipeline.callbacks))
+	}
+	for i, callback := range pipeline.callbacks {
+		if callback.(Action).Type() != expected[i] {
+			t.Fatalf("Expected %s action on %d position", expected[i], i)
+		}
+	}
+	if _, err := NewActionsConfigFromList(" , ", ActionConfig{}); !errors.Is(err, ErrInvalidActionConfig) {
+		t.Fatalf("Expected ErrInvalidActionConfig, took %v", err)
+	}
+}
+
+func TestLegacyActionPipeline(t *testing.T) {
+	pipeline, err := NewLegacyActionPipeline("/bin/true", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{ActionTypeLog, ActionTypeMetric, ActionTypeRunScript, ActionTypeShutdown}
+	if len(pipeline.callbacks) != len(expected) {
+		t.Fatalf("Expected %d actions, took %d", len(expected), len(pipeline.callbacks))
+	}
+	for i, callback := range pipeline.callbacks {
+		if callback.(Action).Type() != expected[i] {
+			t.Fatalf("Expected %s action on %d position", expected[i], i)
+		}
+	}
+}
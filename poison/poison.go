@@ -59,6 +59,10 @@ func CreatePoisonRecord(keyStore keystore.PoisonKeyStorageAndGenerator, dataLeng
 	if err != nil {
 		return nil, err
 	}
+	return createPoisonRecordWithData(keyStore, data)
+}
+
+func createPoisonRecordWithData(keyStore keystore.PoisonKeyStorageAndGenerator, data []byte) ([]byte, error) {
 	poisonKeypair, err := keyStore.GetPoisonKeyPair()
 	if err == keystore.ErrKeysNotFound {
 		if err = keyStore.GeneratePoisonKeyPair(); err != nil {
@@ -85,6 +89,10 @@ func CreateSymmetricPoisonRecord(keyStore keystore.PoisonKeyStorageAndGenerator,
 	if err != nil {
 		return nil, err
 	}
+	return createSymmetricPoisonRecordWithData(keyStore, data)
+}
+
+func createSymmetricPoisonRecordWithData(keyStore keystore.PoisonKeyStorageAndGenerator, data []byte) ([]byte, error) {
 	symmetricKey, err := keyStore.GetPoisonSymmetricKey()
 	if err == keystore.ErrKeysNotFound {
 		if err = keyStore.GeneratePoisonSymmetricKey(); err != nil {
@@ -0,0 +1,39 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poison
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoisonRecordDetectionCounter collect count of detected poison records
+var PoisonRecordDetectionCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "acra_poison_record_detections_total",
+		Help: "number of detected poison records",
+	})
+
+var registerLock = sync.Once{}
+
+// RegisterPoisonRecordMetrics register in default prometheus registry metrics related with poison records detection
+func RegisterPoisonRecordMetrics() {
+	registerLock.Do(func() {
+		prometheus.MustRegister(PoisonRecordDetectionCounter)
+	})
+}
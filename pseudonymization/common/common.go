@@ -38,6 +38,9 @@ var ErrTokenExists = errors.New("token already exists")
 // ErrTokenDisabled is returned when a token was found, but is explicitly disabled
 var ErrTokenDisabled = errors.New("disabled token accessed")
 
+// ErrInvalidTokenRecord is passed to TokenRecordVisitor callback for records which can't be decoded
+var ErrInvalidTokenRecord = errors.New("invalid token record")
+
 // Encryptor interface used as abstraction for token encryption
 type Encryptor interface {
 	Encrypt(data, context TokenContext) ([]byte, error)
@@ -64,6 +67,22 @@ type TokenStorage interface {
 	SetAccessTimeGranularity(granularity time.Duration) error
 }
 
+// TokenRecord is an entry of TokenStorage with encrypted token data. Storages keep only the digest of TokenContext
+// returned by AggregateTokenContextToBytes, so the context of record can be found only by matching known contexts.
+type TokenRecord struct {
+	ContextDigest []byte
+	ID            []byte
+	Data          []byte
+	Metadata      TokenMetadata
+}
+
+// TokenRecordVisitor is implemented by storages which allow iteration over raw token records, for example, to verify
+// their integrity. Records which can't be decoded are passed to the callback with non-nil error and without data.
+// Return a non-nil error from the callback to stop iteration and return this error.
+type TokenRecordVisitor interface {
+	VisitRecords(cb func(record TokenRecord, err error) error) error
+}
+
 // DefaultAccessTimeGranularity is the default difference in time required for the access time to be updated.
 const DefaultAccessTimeGranularity = 24 * time.Hour
 
@@ -0,0 +1,144 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pseudonymization
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/cossacklabs/acra/acrastruct"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPseudonymStorageNotConfigured returned if pseudonymized columns are configured without token storage
+var ErrPseudonymStorageNotConfigured = errors.New("token storage for source values of pseudonyms is not configured")
+
+// pseudonymLength is length of truncated HMAC used as pseudonym, it's encoded to hex string twice as long
+const pseudonymLength = 16
+
+// pseudonymContextPrefix separates source values of pseudonyms from tokens in the same token storage
+const pseudonymContextPrefix = "pseudonym:"
+
+func pseudonymTokenContext(clientID []byte) common.TokenContext {
+	additionalContext := make([]byte, 0, len(pseudonymContextPrefix)+len(clientID))
+	additionalContext = append(additionalContext, pseudonymContextPrefix...)
+	additionalContext = append(additionalContext, clientID...)
+	return common.TokenContext{ClientID: clientID, AdditionalContext: additionalContext}
+}
+
+// Pseudonymizer replaces values of pseudonymized columns with pseudonyms. Unlike random tokens, pseudonym is derived
+// from the value by keyed PRF (HMAC-SHA256) of ClientID, so equal values always have equal pseudonyms and may be
+// joined or grouped without re-identification. Source values are encrypted with re-identification public key and
+// saved in token storage, so only AcraTranslator with the private key can re-identify them.
+type Pseudonymizer struct {
+	keyStore keystore.PseudonymizationKeyStore
+	storage  common.TokenStorage
+}
+
+// NewPseudonymizer return new Pseudonymizer which saves source values to storage or ErrPseudonymStorageNotConfigured
+// if storage is nil
+func NewPseudonymizer(keyStore keystore.PseudonymizationKeyStore, storage common.TokenStorage) (*Pseudonymizer, error) {
+	if storage == nil {
+		return nil, ErrPseudonymStorageNotConfigured
+	}
+	return &Pseudonymizer{keyStore: keyStore, storage: storage}, nil
+}
+
+// Pseudonym returns pseudonym of data derived with key of clientID
+func (p *Pseudonymizer) Pseudonym(clientID, data []byte) ([]byte, error) {
+	key, err := p.keyStore.GetPseudonymizationSymmetricKey(clientID)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	utils.ZeroizeSymmetricKey(key)
+	mac.Write(data)
+	digest := mac.Sum(nil)[:pseudonymLength]
+	pseudonym := make([]byte, hex.EncodedLen(len(digest)))
+	hex.Encode(pseudonym, digest)
+	return pseudonym, nil
+}
+
+// EncryptWithClientID replaces data with pseudonym if the column is pseudonymized
+func (p *Pseudonymizer) EncryptWithClientID(clientID, data []byte, setting config.ColumnEncryptionSetting) ([]byte, error) {
+	if !setting.IsPseudonymized() {
+		return data, nil
+	}
+	logger := logrus.WithField("column", setting.ColumnName())
+	pseudonym, err := p.Pseudonym(clientID, data)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't derive pseudonym")
+		return nil, err
+	}
+	tokenContext := pseudonymTokenContext(clientID)
+	// source value of the same pseudonym is already saved
+	if _, err = p.storage.Stat(pseudonym, tokenContext); err == nil {
+		return pseudonym, nil
+	}
+	publicKey, err := p.keyStore.GetReIdentificationPublicKey(clientID)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't load re-identification public key")
+		return nil, err
+	}
+	// bind encrypted value to pseudonym, so it can't be substituted with value of another pseudonym
+	encrypted, err := acrastruct.CreateAcrastruct(data, publicKey, pseudonym)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't encrypt source value of pseudonym")
+		return nil, err
+	}
+	// concurrent queries may save the same pseudonym
+	if err = p.storage.Save(pseudonym, tokenContext, encrypted); err != nil && err != common.ErrTokenExists {
+		logger.WithError(err).Errorln("Can't save source value of pseudonym")
+		return nil, err
+	}
+	return pseudonym, nil
+}
+
+// ReIdentifier restores source values of pseudonyms with re-identification private keys
+type ReIdentifier struct {
+	keyStore keystore.ReIdentificationKeyStore
+	storage  common.TokenStorage
+}
+
+// NewReIdentifier return new ReIdentifier which reads source values from storage or ErrPseudonymStorageNotConfigured
+// if storage is nil
+func NewReIdentifier(keyStore keystore.ReIdentificationKeyStore, storage common.TokenStorage) (*ReIdentifier, error) {
+	if storage == nil {
+		return nil, ErrPseudonymStorageNotConfigured
+	}
+	return &ReIdentifier{keyStore: keyStore, storage: storage}, nil
+}
+
+// ReIdentify returns source value of pseudonym derived for clientID
+func (r *ReIdentifier) ReIdentify(clientID, pseudonym []byte) ([]byte, error) {
+	encrypted, err := r.storage.Get(pseudonym, pseudonymTokenContext(clientID))
+	if err != nil {
+		return nil, err
+	}
+	privateKeys, err := r.keyStore.GetReIdentificationPrivateKeys(clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.ZeroizePrivateKeys(privateKeys)
+	return acrastruct.DecryptRotatedAcrastruct(encrypted, privateKeys, pseudonym)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pseudonymization
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/pseudonymization/storage"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+type testPseudonymizationKeyStore struct {
+	symmetricKeys map[string][]byte
+	keypairs      map[string]*keys.Keypair
+}
+
+func (store *testPseudonymizationKeyStore) GetPseudonymizationSymmetricKey(clientID []byte) ([]byte, error) {
+	return append([]byte{}, store.symmetricKeys[string(clientID)]...), nil
+}
+
+func (store *testPseudonymizationKeyStore) GetReIdentificationPublicKey(clientID []byte) (*keys.PublicKey, error) {
+	return store.keypairs[string(clientID)].Public, nil
+}
+
+func (store *testPseudonymizationKeyStore) GetReIdentificationPrivateKeys(clientID []byte) ([]*keys.PrivateKey, error) {
+	private := store.keypairs[string(clientID)].Private
+	return []*keys.PrivateKey{{Value: append([]byte{}, private.Value...)}}, nil
+}
+
+func TestPseudonymizer(t *testing.T) {
+	keyStore := &testPseudonymizationKeyStore{
+		symmetricKeys: map[string][]byte{"client1": []byte("first key"), "client2": []byte("second key")},
+		keypairs:      make(map[string]*keys.Keypair),
+	}
+	for _, clientID := range []string{"client1", "client2"} {
+		keypair, err := keys.New(keys.TypeEC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyStore.keypairs[clientID] = keypair
+	}
+	tokenStorage, err := storage.NewMemoryTokenStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pseudonymizer, err := NewPseudonymizer(keyStore, tokenStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := &config.BasicColumnEncryptionSetting{Name: "email", Pseudonymized: true}
+	value := []byte("user@example.com")
+
+	pseudonym, err := pseudonymizer.EncryptWithClientID([]byte("client1"), value, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pseudonym) != pseudonymLength*2 || bytes.Contains(pseudonym, value) {
+		t.Fatalf("Unexpected pseudonym %s", pseudonym)
+	}
+	samePseudonym, err := pseudonymizer.EncryptWithClientID([]byte("client1"), value, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pseudonym, samePseudonym) {
+		t.Fatal("Pseudonyms of the same value should be equal")
+	}
+	otherPseudonym, err := pseudonymizer.EncryptWithClientID([]byte("client2"), value, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(pseudonym, otherPseudonym) {
+		t.Fatal("Pseudonyms of different clients should differ")
+	}
+	data, err := pseudonymizer.EncryptWithClientID([]byte("client1"), value, &config.BasicColumnEncryptionSetting{Name: "name"})
+	if err != nil || !bytes.Equal(data, value) {
+		t.Fatalf("Columns without pseudonymization should be passed as is: %s, %v", data, err)
+	}
+
+	reIdentifier, err := NewReIdentifier(keyStore, tokenStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := reIdentifier.ReIdentify([]byte("client1"), pseudonym)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(source, value) {
+		t.Fatalf("Expected %s, took %s", value, source)
+	}
+	if _, err = reIdentifier.ReIdentify([]byte("client2"), pseudonym); err != common.ErrTokenNotFound {
+		t.Fatalf("Expected ErrTokenNotFound for pseudonym of another client, took %v", err)
+	}
+	// tokens and pseudonyms don't share values
+	if _, err = tokenStorage.Get(pseudonym, common.TokenContext{ClientID: []byte("client1")}); err != common.ErrTokenNotFound {
+		t.Fatalf("Expected ErrTokenNotFound for token context, took %v", err)
+	}
+}
+
+func TestPseudonymizerWithoutStorage(t *testing.T) {
+	keyStore := &testPseudonymizationKeyStore{}
+	if _, err := NewPseudonymizer(keyStore, nil); err != ErrPseudonymStorageNotConfigured {
+		t.Fatalf("Expected ErrPseudonymStorageNotConfigured, took %v", err)
+	}
+	if _, err := NewReIdentifier(keyStore, nil); err != ErrPseudonymStorageNotConfigured {
+		t.Fatalf("Expected ErrPseudonymStorageNotConfigured, took %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+// TokenStorageBackend is a plugin interface for token storages which may be selected by name
+// with --token_storage_backend parameter
+type TokenStorageBackend interface {
+	// Open connects to the storage described by backend specific connection string.
+	// Returned io.Closer used to release resources of the storage
+	Open(connectionString string) (common.TokenStorage, io.Closer, error)
+}
+
+// TokenStorageBackendFunc adapter to use functions as TokenStorageBackend
+type TokenStorageBackendFunc func(connectionString string) (common.TokenStorage, io.Closer, error)
+
+// Open calls the function
+func (f TokenStorageBackendFunc) Open(connectionString string) (common.TokenStorage, io.Closer, error) {
+	return f(connectionString)
+}
+
+// Errors related to token storage backends
+var (
+	ErrUnknownTokenStorageBackend    = errors.New("unknown token storage backend")
+	ErrTokenStorageBackendRegistered = errors.New("token storage backend already registered")
+)
+
+var (
+	backendsLock sync.RWMutex
+	backends     = make(map[string]TokenStorageBackend)
+)
+
+// RegisterTokenStorageBackend registers backend with name. Usually called from init() of backend's package
+func RegisterTokenStorageBackend(name string, backend TokenStorageBackend) error {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	if _, ok := backends[name]; ok {
+		return fmt.Errorf("%w: %s", ErrTokenStorageBackendRegistered, name)
+	}
+	backends[name] = backend
+	return nil
+}
+
+// GetTokenStorageBackend returns registered backend by name
+func GetTokenStorageBackend(name string) (TokenStorageBackend, error) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTokenStorageBackend, name)
+	}
+	return backend, nil
+}
+
+// SupportedTokenStorageBackends returns sorted names of registered backends
+func SupportedTokenStorageBackends() []string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenTokenStorageBackend opens token storage using registered backend
+func OpenTokenStorageBackend(name, connectionString string) (common.TokenStorage, io.Closer, error) {
+	backend, err := GetTokenStorageBackend(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend.Open(connectionString)
+}
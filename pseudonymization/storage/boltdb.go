@@ -17,6 +17,7 @@ limitations under the License.
 package storage
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/cossacklabs/acra/pseudonymization/common"
@@ -213,3 +214,37 @@ func (b *boltdbStorage) visitBucket(ctxBucket *bolt.Bucket, cb func(dataLength i
 	}
 	return nil
 }
+
+// VisitRecords over raw token records in the storage.
+func (b *boltdbStorage) VisitRecords(cb func(record common.TokenRecord, err error) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokenBucket)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for ctx, v := cursor.First(); ctx != nil; ctx, v = cursor.Next() {
+			if v != nil {
+				continue
+			}
+			err := bucket.Bucket(ctx).ForEach(func(id, value []byte) error {
+				if value == nil {
+					return nil
+				}
+				// slices are valid only during transaction, so they are copied for callback
+				record := common.TokenRecord{ContextDigest: append([]byte(nil), ctx...), ID: append([]byte(nil), id...)}
+				data, metadata, err := common.ExtractMetadata(value)
+				if err != nil {
+					return cb(record, fmt.Errorf("%w: %s", common.ErrInvalidTokenRecord, err))
+				}
+				record.Data = append([]byte(nil), data...)
+				record.Metadata = metadata
+				return cb(record, nil)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
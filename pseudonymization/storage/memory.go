@@ -141,3 +141,27 @@ func (m *MemoryTokenStorage) VisitMetadata(cb func(dataLength int, metadata comm
 	}
 	return nil
 }
+
+// VisitRecords over raw token records in the storage.
+func (m *MemoryTokenStorage) VisitRecords(cb func(record common.TokenRecord, err error) error) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for ctxStr, ctxMap := range m.data {
+		ctx, err := hex.DecodeString(ctxStr)
+		if err != nil {
+			return err
+		}
+		for idStr, token := range ctxMap {
+			id, err := hex.DecodeString(idStr)
+			if err != nil {
+				return err
+			}
+			record := common.TokenRecord{ContextDigest: ctx, ID: id, Data: token.data, Metadata: token.metadata}
+			if err := cb(record, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgreSQLBackendName name of PostgreSQL token storage backend
+const PostgreSQLBackendName = "postgresql"
+
+// DefaultPostgreSQLTokenTable name of table used to store tokens by default
+const DefaultPostgreSQLTokenTable = "acra_tokens"
+
+// ErrInvalidTableName returned for table names which can't be used as SQL identifier
+var ErrInvalidTableName = errors.New("invalid token storage table name")
+
+var tableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+func init() {
+	if err := RegisterTokenStorageBackend(PostgreSQLBackendName, TokenStorageBackendFunc(openPostgreSQLBackend)); err != nil {
+		panic(err)
+	}
+}
+
+// openPostgreSQLBackend connects to PostgreSQL, migrates schema of default table and returns storage
+func openPostgreSQLBackend(connectionString string) (common.TokenStorage, io.Closer, error) {
+	config, err := pgx.ParseConfig(connectionString)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := stdlib.OpenDB(*config)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if err := MigratePostgreSQLTokenStorage(db, DefaultPostgreSQLTokenTable); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	storage, err := NewPostgreSQLTokenStorage(db, DefaultPostgreSQLTokenTable)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return storage, db, nil
+}
+
+// postgresqlMigrations ordered list of schema changes. Index+1 is the schema version after applying the migration.
+// Never change existing migrations, append new ones instead
+var postgresqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+		context BYTEA NOT NULL,
+		id BYTEA NOT NULL,
+		data BYTEA NOT NULL,
+		created BIGINT NOT NULL,
+		accessed BIGINT NOT NULL,
+		disabled BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (context, id)
+	)`,
+}
+
+// PostgreSQLTokenStorageSchemaVersion is the latest schema version of PostgreSQL token storage
+var PostgreSQLTokenStorageSchemaVersion = len(postgresqlMigrations)
+
+// MigratePostgreSQLTokenStorage creates or updates schema of the table used to store tokens. Applied version is stored
+// in <table>_schema_version table, so the function may be called safely on each start
+func MigratePostgreSQLTokenStorage(db *sql.DB, table string) error {
+	if !tableNameRegexp.MatchString(table) {
+		return ErrInvalidTableName
+	}
+	versionTable := table + "_schema_version"
+	if len(versionTable) > 63 {
+		return ErrInvalidTableName
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL)`, versionTable)); err != nil {
+		return err
+	}
+	// serialize concurrent migrations from several instances
+	if _, err := tx.Exec(fmt.Sprintf(`LOCK TABLE %s IN EXCLUSIVE MODE`, versionTable)); err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow(fmt.Sprintf(`SELECT version FROM %s`, versionTable)).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (version) VALUES (0)`, versionTable)); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+	if version > len(postgresqlMigrations) {
+		return fmt.Errorf("token storage schema version %d is newer than supported %d", version, len(postgresqlMigrations))
+	}
+	for ; version < len(postgresqlMigrations); version++ {
+		if _, err := tx.Exec(fmt.Sprintf(postgresqlMigrations[version], table)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET version = $1`, versionTable), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PostgreSQLTokenStorage implements TokenStorage using PostgreSQL table as storage backend
+type PostgreSQLTokenStorage struct {
+	db    *sql.DB
+	table string
+
+	accessGranularity time.Duration
+}
+
+// NewPostgreSQLTokenStorage returns storage which uses table with schema created by MigratePostgreSQLTokenStorage
+func NewPostgreSQLTokenStorage(db *sql.DB, table string) (*PostgreSQLTokenStorage, error) {
+	if !tableNameRegexp.MatchString(table) {
+		return nil, ErrInvalidTableName
+	}
+	return &PostgreSQLTokenStorage{db: db, table: table, accessGranularity: common.DefaultAccessTimeGranularity}, nil
+}
+
+// Save data with defined id and context
+func (s *PostgreSQLTokenStorage) Save(id []byte, context common.TokenContext, data []byte) error {
+	metadata := common.NewTokenMetadata()
+	result, err := s.db.Exec(fmt.Sprintf(
+		`INSERT INTO %s (context, id, data, created, accessed, disabled) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`, s.table),
+		common.AggregateTokenContextToBytes(context), id, data, metadata.Created.Unix(), metadata.Accessed.Unix(), metadata.Disabled)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrTokenExists
+	}
+	return nil
+}
+
+// Get data with defined id and context
+func (s *PostgreSQLTokenStorage) Get(id []byte, context common.TokenContext) ([]byte, error) {
+	ctx := common.AggregateTokenContextToBytes(context)
+	var data []byte
+	var accessed int64
+	var disabled bool
+	err := s.db.QueryRow(fmt.Sprintf(`SELECT data, accessed, disabled FROM %s WHERE context = $1 AND id = $2`, s.table), ctx, id).
+		Scan(&data, &accessed, &disabled)
+	if err == sql.ErrNoRows {
+		return nil, common.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// If the token is disabled, pretend that it's not there. (Don't update last access time either.)
+	if disabled {
+		return nil, common.ErrTokenDisabled
+	}
+	// Keep last access time updated, but don't update it more often than specified granularity.
+	metadata := common.TokenMetadata{Accessed: time.Unix(accessed, 0)}
+	now := time.Now().UTC()
+	if metadata.AccessedBefore(now, s.accessGranularity) {
+		_, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET accessed = $1 WHERE context = $2 AND id = $3`, s.table), now.Unix(), ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Stat returns metadata of a token entry.
+func (s *PostgreSQLTokenStorage) Stat(id []byte, context common.TokenContext) (common.TokenMetadata, error) {
+	var created, accessed int64
+	var disabled bool
+	err := s.db.QueryRow(fmt.Sprintf(`SELECT created, accessed, disabled FROM %s WHERE context = $1 AND id = $2`, s.table),
+		common.AggregateTokenContextToBytes(context), id).Scan(&created, &accessed, &disabled)
+	if err == sql.ErrNoRows {
+		return common.TokenMetadata{}, common.ErrTokenNotFound
+	}
+	if err != nil {
+		return common.TokenMetadata{}, err
+	}
+	return common.TokenMetadata{Created: time.Unix(created, 0), Accessed: time.Unix(accessed, 0), Disabled: disabled}, nil
+}
+
+// SetAccessTimeGranularity sets access time granularity.
+func (s *PostgreSQLTokenStorage) SetAccessTimeGranularity(granularity time.Duration) error {
+	s.accessGranularity = granularity
+	return nil
+}
+
+type postgresqlTokenKey struct {
+	context []byte
+	id      []byte
+}
+
+// VisitMetadata iterates over token metadata in the storage. Changes requested by callback applied after iteration
+// in one transaction
+func (s *PostgreSQLTokenStorage) VisitMetadata(cb func(dataLength int, metadata common.TokenMetadata) (common.TokenAction, error)) error {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT context, id, length(data), created, accessed, disabled FROM %s`, s.table))
+	if err != nil {
+		return err
+	}
+	changes := make(map[common.TokenAction][]postgresqlTokenKey)
+	for rows.Next() {
+		var key postgresqlTokenKey
+		var dataLength int
+		var created, accessed int64
+		var disabled bool
+		if err := rows.Scan(&key.context, &key.id, &dataLength, &created, &accessed, &disabled); err != nil {
+			rows.Close()
+			return err
+		}
+		metadata := common.TokenMetadata{Created: time.Unix(created, 0), Accessed: time.Unix(accessed, 0), Disabled: disabled}
+		action, err := cb(dataLength, metadata)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		switch {
+		case action == common.TokenDisable && !disabled,
+			action == common.TokenEnable && disabled,
+			action == common.TokenRemove:
+			changes[action] = append(changes[action], key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+	if len(changes) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for action, keys := range changes {
+		var query string
+		switch action {
+		case common.TokenDisable:
+			query = `UPDATE %s SET disabled = TRUE WHERE context = $1 AND id = $2`
+		case common.TokenEnable:
+			query = `UPDATE %s SET disabled = FALSE WHERE context = $1 AND id = $2`
+		case common.TokenRemove:
+			query = `DELETE FROM %s WHERE context = $1 AND id = $2`
+		}
+		statement, err := tx.Prepare(fmt.Sprintf(query, s.table))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if _, err := statement.Exec(key.context, key.id); err != nil {
+				statement.Close()
+				return err
+			}
+		}
+		statement.Close()
+	}
+	return tx.Commit()
+}
+
+// VisitRecords iterates over raw token records in the storage.
+func (s *PostgreSQLTokenStorage) VisitRecords(cb func(record common.TokenRecord, err error) error) error {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT context, id, data, created, accessed, disabled FROM %s`, s.table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record common.TokenRecord
+		var created, accessed int64
+		if err := rows.Scan(&record.ContextDigest, &record.ID, &record.Data, &created, &accessed, &record.Metadata.Disabled); err != nil {
+			return err
+		}
+		record.Metadata.Created = time.Unix(created, 0)
+		record.Metadata.Accessed = time.Unix(accessed, 0)
+		if err := cb(record, nil); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/pseudonymization/common"
+)
+
+func TestPostgreSQLStorage(t *testing.T) {
+	connectionString := os.Getenv("TEST_POSTGRESQL_TOKEN_STORAGE")
+	if connectionString == "" {
+		t.Skip("TEST_POSTGRESQL_TOKEN_STORAGE is not set")
+	}
+	tokenStorage, closer, err := OpenTokenStorageBackend(PostgreSQLBackendName, connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	pgStorage := tokenStorage.(*PostgreSQLTokenStorage)
+	// migrations should be idempotent
+	if err := MigratePostgreSQLTokenStorage(pgStorage.db, DefaultPostgreSQLTokenTable); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pgStorage.db.Exec(fmt.Sprintf("TRUNCATE %s", DefaultPostgreSQLTokenTable)); err != nil {
+		t.Fatal(err)
+	}
+	testStorage(tokenStorage, t)
+}
+
+func TestInvalidPostgreSQLTableName(t *testing.T) {
+	for _, name := range []string{"", "1table", "table; DROP TABLE users", "schema.table", "таблиця"} {
+		if _, err := NewPostgreSQLTokenStorage(nil, name); !errors.Is(err, ErrInvalidTableName) {
+			t.Fatalf("Expected ErrInvalidTableName for %q, took %v", name, err)
+		}
+		if err := MigratePostgreSQLTokenStorage(nil, name); !errors.Is(err, ErrInvalidTableName) {
+			t.Fatalf("Expected ErrInvalidTableName for %q, took %v", name, err)
+		}
+	}
+}
+
+func TestTokenStorageBackendRegistry(t *testing.T) {
+	if _, err := GetTokenStorageBackend(PostgreSQLBackendName); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := OpenTokenStorageBackend("unknown", ""); !errors.Is(err, ErrUnknownTokenStorageBackend) {
+		t.Fatalf("Expected ErrUnknownTokenStorageBackend, took %v", err)
+	}
+	memoryBackend := TokenStorageBackendFunc(func(string) (common.TokenStorage, io.Closer, error) {
+		storage, err := NewMemoryTokenStorage()
+		return storage, io.NopCloser(nil), err
+	})
+	if err := RegisterTokenStorageBackend("test_memory", memoryBackend); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTokenStorageBackend("test_memory", memoryBackend); !errors.Is(err, ErrTokenStorageBackendRegistered) {
+		t.Fatalf("Expected ErrTokenStorageBackendRegistered, took %v", err)
+	}
+	found := false
+	for _, name := range SupportedTokenStorageBackends() {
+		if name == "test_memory" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Registered backend not found in supported list")
+	}
+}
@@ -19,9 +19,12 @@ package storage
 import (
 	"crypto/tls"
 	"encoding/hex"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cossacklabs/acra/pseudonymization/common"
+	"github.com/cossacklabs/acra/utils/redisclient"
 	"github.com/go-redis/redis/v7"
 )
 
@@ -43,15 +46,15 @@ func NewRedisClient(hostport, password string, db int, tlsConfig *tls.Config) (*
 
 // RedisStorage implements TokenStorage using Redis as storage backend
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 
 	accessGranularity time.Duration
 }
 
 const noExpiration = 0
 
-// NewRedisStorage return new redis storage for tokens using client
-func NewRedisStorage(client *redis.Client) (*RedisStorage, error) {
+// NewRedisStorage return new redis storage for tokens using client of single node, Sentinel or Cluster
+func NewRedisStorage(client redis.UniversalClient) (*RedisStorage, error) {
 	return &RedisStorage{client, common.DefaultAccessTimeGranularity}, nil
 }
 
@@ -145,6 +148,77 @@ const redisDefaultKeyCount = 10
 
 // VisitMetadata over token metadata in the storage.
 func (m *RedisStorage) VisitMetadata(cb func(dataLength int, metadata common.TokenMetadata) (common.TokenAction, error)) error {
+	return m.scanTokenKeys(func(keys []string) error {
+		return m.visitKeys(keys, cb)
+	})
+}
+
+// VisitRecords over raw token records in the storage.
+func (m *RedisStorage) VisitRecords(cb func(record common.TokenRecord, err error) error) error {
+	return m.scanTokenKeys(func(keys []string) error {
+		valueStrings, err := redisclient.MGet(m.client, keys...)
+		if err != nil {
+			return err
+		}
+		for i, valueStr := range valueStrings {
+			// keys removed during iteration are skipped like in VisitMetadata
+			if valueStr == nil {
+				continue
+			}
+			if err := cb(parseRedisTokenRecord(keys[i], valueStr.(string))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseRedisTokenRecord decodes record from key generated by generateKey and hex encoded value
+func parseRedisTokenRecord(key, valueStr string) (common.TokenRecord, error) {
+	var record common.TokenRecord
+	ctxStr, idStr, ok := strings.Cut(strings.TrimPrefix(key, redisTokensPrefix), "/")
+	if !ok {
+		return record, fmt.Errorf("%w: unexpected key %s", common.ErrInvalidTokenRecord, key)
+	}
+	var err error
+	if record.ContextDigest, err = hex.DecodeString(ctxStr); err != nil {
+		return record, fmt.Errorf("%w: unexpected key %s", common.ErrInvalidTokenRecord, key)
+	}
+	if record.ID, err = hex.DecodeString(idStr); err != nil {
+		return record, fmt.Errorf("%w: unexpected key %s", common.ErrInvalidTokenRecord, key)
+	}
+	value, err := hex.DecodeString(valueStr)
+	if err != nil {
+		return record, fmt.Errorf("%w: %s", common.ErrInvalidTokenRecord, err)
+	}
+	data, metadata, err := common.ExtractMetadata(value)
+	if err != nil {
+		return record, fmt.Errorf("%w: %s", common.ErrInvalidTokenRecord, err)
+	}
+	record.Data, record.Metadata = data, metadata
+	return record, nil
+}
+
+// scanTokenKeys calls visit for non-empty pages of token keys
+func (m *RedisStorage) scanTokenKeys(visit func(keys []string) error) error {
+	// keys of Redis Cluster are scanned on each master node at once, other clients are scanned page by page
+	if redisclient.IsCluster(m.client) {
+		keys, err := redisclient.ScanKeys(m.client, redisTokensPrefix+"*", redisDefaultKeyCount)
+		if err != nil {
+			return err
+		}
+		for len(keys) > 0 {
+			page := keys
+			if len(page) > redisDefaultKeyCount {
+				page = page[:redisDefaultKeyCount]
+			}
+			keys = keys[len(page):]
+			if err := visit(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	var cursor uint64
 	for {
 		nextKeys, nextCursor, err := m.client.Scan(cursor, redisTokensPrefix+"*", redisDefaultKeyCount).Result()
@@ -154,61 +228,9 @@ func (m *RedisStorage) VisitMetadata(cb func(dataLength int, metadata common.Tok
 		// MGET requires non-empty list of keys, and if there is nothing to iterate through, don't make unnecessary requests.
 		// However, note that SCAN may return empty key sets during the iteration. It's not over until the cursor is zero.
 		if len(nextKeys) > 0 {
-			valueStrings, err := m.client.MGet(nextKeys...).Result()
-			if err != nil {
+			if err := visit(nextKeys); err != nil {
 				return err
 			}
-			var updates []string
-			var removals []string
-			for i, valueStr := range valueStrings {
-				// MGET may return nil values if keys have been removed during iteration. Just skip them.
-				if valueStr == nil {
-					continue
-				}
-				value, err := hex.DecodeString(valueStr.(string))
-				if err != nil {
-					return err
-				}
-				data, metadata, err := common.ExtractMetadata(value)
-				if err != nil {
-					return err
-				}
-				action, err := cb(len(data), metadata)
-				if err != nil {
-					return err
-				}
-				switch action {
-				case common.TokenDisable:
-					if !metadata.Disabled {
-						metadata.Disabled = true
-						value := common.EmbedMetadata(data, metadata)
-						valueStr := hex.EncodeToString(value)
-						updates = append(updates, nextKeys[i], valueStr)
-					}
-				case common.TokenEnable:
-					if metadata.Disabled {
-						metadata.Disabled = false
-						value := common.EmbedMetadata(data, metadata)
-						valueStr := hex.EncodeToString(value)
-						updates = append(updates, nextKeys[i], valueStr)
-					}
-				case common.TokenRemove:
-					removals = append(removals, nextKeys[i])
-				}
-			}
-			// If there are any pending metadata updates, apply them now (atomically).
-			if len(updates) > 0 {
-				_, err := m.client.MSet(updates).Result()
-				if err != nil {
-					return err
-				}
-			}
-			if len(removals) > 0 {
-				_, err := m.client.Del(removals...).Result()
-				if err != nil {
-					return err
-				}
-			}
 		}
 		cursor = nextCursor
 		if cursor == 0 {
@@ -217,3 +239,62 @@ func (m *RedisStorage) VisitMetadata(cb func(dataLength int, metadata common.Tok
 	}
 	return nil
 }
+
+// visitKeys calls cb for metadata of tokens stored with keys and applies returned actions
+func (m *RedisStorage) visitKeys(keys []string, cb func(dataLength int, metadata common.TokenMetadata) (common.TokenAction, error)) error {
+	valueStrings, err := redisclient.MGet(m.client, keys...)
+	if err != nil {
+		return err
+	}
+	var updates []string
+	var removals []string
+	for i, valueStr := range valueStrings {
+		// MGET may return nil values if keys have been removed during iteration. Just skip them.
+		if valueStr == nil {
+			continue
+		}
+		value, err := hex.DecodeString(valueStr.(string))
+		if err != nil {
+			return err
+		}
+		data, metadata, err := common.ExtractMetadata(value)
+		if err != nil {
+			return err
+		}
+		action, err := cb(len(data), metadata)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case common.TokenDisable:
+			if !metadata.Disabled {
+				metadata.Disabled = true
+				value := common.EmbedMetadata(data, metadata)
+				valueStr := hex.EncodeToString(value)
+				updates = append(updates, keys[i], valueStr)
+			}
+		case common.TokenEnable:
+			if metadata.Disabled {
+				metadata.Disabled = false
+				value := common.EmbedMetadata(data, metadata)
+				valueStr := hex.EncodeToString(value)
+				updates = append(updates, keys[i], valueStr)
+			}
+		case common.TokenRemove:
+			removals = append(removals, keys[i])
+		}
+	}
+	// If there are any pending metadata updates, apply them now (atomically, unless it is Redis Cluster).
+	if len(updates) > 0 {
+		if err := redisclient.MSet(m.client, updates); err != nil {
+			return err
+		}
+	}
+	if len(removals) > 0 {
+		_, err := redisclient.Del(m.client, removals...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
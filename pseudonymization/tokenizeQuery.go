@@ -52,6 +52,10 @@ func (encryptor *TokenizeQuery) ID() string {
 //	WHERE column = $1        ===>   WHERE column = tokenize($1)
 //
 // and actual "value" is passed via parameters later. See OnBind() for details.
+//
+// Each value of IN lists is processed in the same way:
+//
+//	WHERE column IN ('a', $1)   ===>   WHERE column IN (tokenize('a'), tokenize($1))
 func (encryptor *TokenizeQuery) OnQuery(ctx context.Context, query base.OnQueryObject) (base.OnQueryObject, bool, error) {
 	logrus.Debugln("TokenizeQuery.OnQuery")
 	stmt, err := query.Statement()
@@ -74,27 +78,29 @@ func (encryptor *TokenizeQuery) OnQuery(ctx context.Context, query base.OnQueryO
 			continue
 		}
 
-		rightVal, ok := item.Expr.Right.(*sqlparser.SQLVal)
-		if !ok {
+		values := item.Values()
+		if len(values) == 0 {
 			logrus.Debugln("expect SQLVal as Right expression for searchable consistent tokenization")
 			continue
 		}
 
 		encryptor.searchableQueryFilter.ChangeSearchableOperator(item.Expr)
 
-		err = queryEncryptor.UpdateExpressionValue(ctx, item.Expr.Right, encryptor.coder, encryptor.getTokenizerDataWithSetting(item.Setting))
-		if err != nil {
-			logrus.WithError(err).Debugln("Failed to update expression")
-			return query, false, err
-		}
+		for _, rightVal := range values {
+			err = queryEncryptor.UpdateExpressionValue(ctx, rightVal, encryptor.coder, encryptor.getTokenizerDataWithSetting(item.Setting))
+			if err != nil {
+				logrus.WithError(err).Debugln("Failed to update expression")
+				return query, false, err
+			}
 
-		placeholderIndex, err := queryEncryptor.ParsePlaceholderIndex(rightVal)
-		if err == queryEncryptor.ErrInvalidPlaceholder {
-			continue
-		} else if err != nil {
-			return query, false, err
+			placeholderIndex, err := queryEncryptor.ParsePlaceholderIndex(rightVal)
+			if err == queryEncryptor.ErrInvalidPlaceholder {
+				continue
+			} else if err != nil {
+				return query, false, err
+			}
+			bindSettings[placeholderIndex] = item.Setting
 		}
-		bindSettings[placeholderIndex] = item.Setting
 	}
 	logrus.Debugln("TokenizeQuery.OnQuery changed query")
 	return base.NewOnQueryObjectFromStatement(stmt, nil), true, nil
@@ -137,6 +143,22 @@ func (encryptor *TokenizeQuery) OnBind(ctx context.Context, statement sqlparser.
 				return values, false, queryEncryptor.ErrInvalidPlaceholder
 			}
 			indexes = append(indexes, index)
+		case sqlparser.ValTuple:
+			// IN list may contain both literals processed by OnQuery and placeholders
+			for _, sqlVal := range item.Values() {
+				index, err := queryEncryptor.ParsePlaceholderIndex(sqlVal)
+				if err == queryEncryptor.ErrInvalidPlaceholder {
+					continue
+				} else if err != nil {
+					return values, false, err
+				}
+				if index >= len(values) {
+					logrus.WithFields(logrus.Fields{"placeholder": sqlVal.Val, "index": index, "values": len(values)}).
+						Warning("Invalid placeholder index")
+					return values, false, queryEncryptor.ErrInvalidPlaceholder
+				}
+				indexes = append(indexes, index)
+			}
 		}
 	}
 	// Finally, once we know which values to replace with tokenized values, do this replacement.
@@ -0,0 +1,199 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transform implements deployment-specific transforms of decrypted column values, like locale formatting,
+// unit conversion or watermarking. Transforms are registered by name in the built-in registry from init() of packages
+// compiled into AcraServer or of Go plugins loaded from the config, and are applied to decrypted values in
+// configured order before they are encoded and sent to the client.
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"plugin"
+	"sort"
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Errors returned on loading of transforms config
+var (
+	ErrUnknownTransform = errors.New("unknown column transform")
+	ErrNoTransforms     = errors.New("column transforms config doesn't contain transforms")
+)
+
+// ColumnTransform changes decrypted value of the column. Data is passed in text format of the database, as it's
+// stored encrypted. Every transform receives own copy of data, so it may keep or change it in place. Transforms are
+// shared between connections and should be safe for concurrent use
+type ColumnTransform interface {
+	Transform(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// ColumnTransformFunc is a function which implements ColumnTransform
+type ColumnTransformFunc func(ctx context.Context, data []byte) ([]byte, error)
+
+// Transform calls function
+func (f ColumnTransformFunc) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return f(ctx, data)
+}
+
+// Factory creates ColumnTransform with options from the config
+type Factory func(options map[string]string) (ColumnTransform, error)
+
+var (
+	lock      = sync.Mutex{}
+	factories = map[string]Factory{}
+)
+
+// Register registers Factory of transform with name used in the config. Should be called from init() of packages
+// or Go plugins, factory registered with the same name is replaced
+func Register(name string, factory Factory) {
+	lock.Lock()
+	factories[name] = factory
+	lock.Unlock()
+	logrus.WithField("transform", name).Debug("Registered column transform")
+}
+
+func getFactory(name string) (Factory, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Config of one transform
+type Config struct {
+	// Name of registered transform
+	Name string `yaml:"name"`
+	// Order of transform in the chain, transforms with lower order are applied first and with the same order
+	// in the order of declaration
+	Order int `yaml:"order"`
+	// Columns limits transform to columns with these names from encryptor config, all decrypted columns if empty
+	Columns []string `yaml:"columns"`
+	// Options passed to Factory
+	Options map[string]string `yaml:"options"`
+}
+
+// FileConfig is the structure of transforms config file
+type FileConfig struct {
+	// Plugins are paths to Go plugins which register transforms on loading
+	Plugins    []string `yaml:"plugins"`
+	Transforms []Config `yaml:"transforms"`
+}
+
+type configuredTransform struct {
+	ColumnTransform
+	name    string
+	columns map[string]bool
+}
+
+func (transform *configuredTransform) matchColumn(column string) bool {
+	return len(transform.columns) == 0 || transform.columns[column]
+}
+
+// Processor is base.DecryptionSubscriber which applies transforms to decrypted values. It should be subscribed after
+// decryption and before encoding of values to formats requested by the client
+type Processor struct {
+	transforms []*configuredTransform
+}
+
+// NewProcessor creates transforms from configs and returns Processor which applies them in configured order
+func NewProcessor(configs []Config) (*Processor, error) {
+	sortedConfigs := make([]Config, len(configs))
+	copy(sortedConfigs, configs)
+	sort.SliceStable(sortedConfigs, func(i, j int) bool {
+		return sortedConfigs[i].Order < sortedConfigs[j].Order
+	})
+	transforms := make([]*configuredTransform, 0, len(sortedConfigs))
+	for _, config := range sortedConfigs {
+		factory, ok := getFactory(config.Name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTransform, config.Name)
+		}
+		transform, err := factory(config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("can't create column transform %s: %w", config.Name, err)
+		}
+		columns := make(map[string]bool, len(config.Columns))
+		for _, column := range config.Columns {
+			columns[column] = true
+		}
+		transforms = append(transforms, &configuredTransform{ColumnTransform: transform, name: config.Name, columns: columns})
+	}
+	return &Processor{transforms: transforms}, nil
+}
+
+// NewProcessorFromConfig loads Go plugins and creates Processor from config file
+func NewProcessorFromConfig(data []byte) (*Processor, error) {
+	fileConfig := FileConfig{}
+	if err := yaml.UnmarshalStrict(data, &fileConfig); err != nil {
+		return nil, err
+	}
+	if len(fileConfig.Transforms) == 0 {
+		return nil, ErrNoTransforms
+	}
+	for _, path := range fileConfig.Plugins {
+		// init() of the plugin registers its transforms
+		if _, err := plugin.Open(path); err != nil {
+			return nil, fmt.Errorf("can't load plugin of column transforms %s: %w", path, err)
+		}
+		logrus.WithField("path", path).Infoln("Loaded plugin of column transforms")
+	}
+	return NewProcessor(fileConfig.Transforms)
+}
+
+// NewProcessorFromFile reads config file and creates Processor
+func NewProcessorFromFile(path string) (*Processor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewProcessorFromConfig(data)
+}
+
+// ID returns name of processor
+func (processor *Processor) ID() string {
+	return "ColumnTransformProcessor"
+}
+
+// OnColumn applies transforms to decrypted values, other values are passed as is
+func (processor *Processor) OnColumn(ctx context.Context, data []byte) (context.Context, []byte, error) {
+	if !base.IsDecryptedFromContext(ctx) {
+		return ctx, data, nil
+	}
+	column := ""
+	if setting, ok := encryptor.EncryptionSettingFromContext(ctx); ok {
+		column = setting.ColumnName()
+	}
+	for _, transform := range processor.transforms {
+		if !transform.matchColumn(column) {
+			continue
+		}
+		// data may be stored in buffer from pool which is reused for next rows, so transforms receive own copy
+		newData, err := transform.Transform(ctx, append([]byte(nil), data...))
+		if err != nil {
+			return ctx, data, fmt.Errorf("column transform %s failed: %w", transform.name, err)
+		}
+		data = newData
+	}
+	return ctx, data, nil
+}
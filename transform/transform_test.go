@@ -0,0 +1,147 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+func init() {
+	Register("append", func(options map[string]string) (ColumnTransform, error) {
+		suffix, ok := options["suffix"]
+		if !ok {
+			return nil, errors.New("suffix is not specified")
+		}
+		return ColumnTransformFunc(func(ctx context.Context, data []byte) ([]byte, error) {
+			return append(append([]byte{}, data...), suffix...), nil
+		}), nil
+	})
+}
+
+func TestProcessorOrder(t *testing.T) {
+	processor, err := NewProcessorFromConfig([]byte(`
+transforms:
+  - name: append
+    order: 20
+    options:
+      suffix: "-second"
+  - name: append
+    order: 10
+    columns:
+      - email
+    options:
+      suffix: "-first"
+  - name: append
+    order: 20
+    options:
+      suffix: "-third"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newContext := func(column string) context.Context {
+		ctx := encryptor.NewContextWithEncryptionSetting(context.Background(), &config.BasicColumnEncryptionSetting{Name: column})
+		return base.MarkDecryptedContext(ctx)
+	}
+
+	_, data, err := processor.OnColumn(newContext("email"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("value-first-second-third")) {
+		t.Fatalf("Unexpected order of transforms: %s", data)
+	}
+
+	_, data, err = processor.OnColumn(newContext("name"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("value-second-third")) {
+		t.Fatalf("Transform should be applied only to configured columns: %s", data)
+	}
+
+	_, data, err = processor.OnColumn(context.Background(), []byte("ciphertext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("ciphertext")) {
+		t.Fatalf("Values which weren't decrypted should be passed as is: %s", data)
+	}
+}
+
+func TestProcessorErrors(t *testing.T) {
+	Register("fail", func(options map[string]string) (ColumnTransform, error) {
+		return ColumnTransformFunc(func(ctx context.Context, data []byte) ([]byte, error) {
+			return nil, errors.New("failed transform")
+		}), nil
+	})
+	processor, err := NewProcessor([]Config{{Name: "fail"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := processor.OnColumn(base.MarkDecryptedContext(context.Background()), []byte("value"))
+	if err == nil || !bytes.Equal(data, []byte("value")) {
+		t.Fatalf("Expected error and source value, took %v, %s", err, data)
+	}
+
+	if _, err := NewProcessor([]Config{{Name: "unknown"}}); !errors.Is(err, ErrUnknownTransform) {
+		t.Fatalf("Expected ErrUnknownTransform, took %v", err)
+	}
+	if _, err := NewProcessor([]Config{{Name: "append"}}); err == nil {
+		t.Fatal("Expected error of factory without options")
+	}
+	if _, err := NewProcessorFromConfig([]byte(`plugins: []`)); err != ErrNoTransforms {
+		t.Fatalf("Expected ErrNoTransforms, took %v", err)
+	}
+	if _, err := NewProcessorFromConfig([]byte(`transforms: [{name: append, unknown: 1}]`)); err == nil {
+		t.Fatal("Expected error of unknown field")
+	}
+}
+
+func TestProcessorRetainedData(t *testing.T) {
+	var retained [][]byte
+	Register("retain", func(options map[string]string) (ColumnTransform, error) {
+		return ColumnTransformFunc(func(ctx context.Context, data []byte) ([]byte, error) {
+			retained = append(retained, data)
+			return data, nil
+		}), nil
+	})
+	processor, err := NewProcessor([]Config{{Name: "retain"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := base.MarkDecryptedContext(context.Background())
+	// rows are read into the same pooled buffer which is overwritten by every next row
+	pooled := base.GetBytes(len("first row"))
+	defer base.PutBytes(pooled)
+	for _, row := range []string{"first row", "next row!"} {
+		copy(*pooled, row)
+		if _, _, err := processor.OnColumn(ctx, *pooled); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(retained) != 2 || string(retained[0]) != "first row" || string(retained[1]) != "next row!" {
+		t.Fatalf("Retained data was overwritten by next rows: %q", retained)
+	}
+}
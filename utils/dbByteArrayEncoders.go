@@ -141,6 +141,37 @@ func (e *MysqlEncoder) Encode(data []byte) interface{} {
 	return data
 }
 
+// MysqlEscapeEncoder encodes binary data as MySQL string literal with escaped special characters
+type MysqlEscapeEncoder struct{}
+
+// EncodeToString bytes to binary string literal with escape sequences supported by MySQL
+func (e *MysqlEscapeEncoder) EncodeToString(data []byte) string {
+	output := make([]byte, 0, len(data)+len("_binary''"))
+	output = append(output, "_binary'"...)
+	for _, c := range data {
+		switch c {
+		case 0:
+			output = append(output, '\\', '0')
+		case '\n':
+			output = append(output, '\\', 'n')
+		case '\r':
+			output = append(output, '\\', 'r')
+		case 0x1a:
+			output = append(output, '\\', 'Z')
+		case '\\', '\'', '"':
+			output = append(output, '\\', c)
+		default:
+			output = append(output, c)
+		}
+	}
+	return string(append(output, '\''))
+}
+
+// Encode return data as is
+func (e *MysqlEscapeEncoder) Encode(data []byte) interface{} {
+	return data
+}
+
 // EscapeEncoder for Postgres
 type EscapeEncoder struct{}
 
@@ -35,6 +35,14 @@ func TestEncodeToOctal(t *testing.T) {
 	}
 }
 
+func TestMysqlEscapeEncoder(t *testing.T) {
+	data := []byte("a\x00b\nc\rd\x1ae\\f'g\"h")
+	expected := `_binary'a\0b\nc\rd\Ze\\f\'g\"h'`
+	if output := (&MysqlEscapeEncoder{}).EncodeToString(data); output != expected {
+		t.Fatalf("Expected %s, took %s", expected, output)
+	}
+}
+
 func BenchmarkEncodeToOctal(b *testing.B) {
 	data := make([]byte, 256)
 	for i := 0; i < len(data); i++ {
@@ -0,0 +1,33 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrInsecureFilePermissions returned if file or directory with keys is accessible by other users
+var ErrInsecureFilePermissions = errors.New("file is accessible by other users")
+
+// checkPermissionBits checks that permission bits of fileInfo don't grant more access than perm
+func checkPermissionBits(fileInfo os.FileInfo, perm os.FileMode) error {
+	if fileInfo.Mode().Perm()&^perm != 0 {
+		return ErrInsecureFilePermissions
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPrivateFilePermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		mode os.FileMode
+		err  error
+	}{
+		{0600, nil},
+		{0400, nil},
+		{0640, ErrInsecureFilePermissions},
+		{0604, ErrInsecureFilePermissions},
+		{0700, ErrInsecureFilePermissions},
+	}
+	for _, tcase := range testcases {
+		if err := os.Chmod(path, tcase.mode); err != nil {
+			t.Fatal(err)
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckPrivateFilePermissions(path, fileInfo, 0600); err != tcase.err {
+			t.Fatalf("[%s] Expected %v, took %v", tcase.mode, tcase.err, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "os"
+
+// CheckPrivateFilePermissions checks that file or directory at path is accessible only by owner: permission bits of
+// fileInfo shouldn't grant more access than perm
+func CheckPrivateFilePermissions(path string, fileInfo os.FileInfo, perm os.FileMode) error {
+	return checkPermissionBits(fileInfo, perm)
+}
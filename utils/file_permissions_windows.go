@@ -0,0 +1,105 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// accessAllowedACEType is type of ACE which grants access
+const accessAllowedACEType = 0
+
+// aclHeader is layout of ACL structure, fields of windows.ACL are not exported
+type aclHeader struct {
+	revision byte
+	sbz1     byte
+	size     uint16
+	aceCount uint16
+	sbz2     uint16
+}
+
+// aceHeader is layout of header of ACE, SID of ACCESS_ALLOWED_ACE follows header and access mask
+type aceHeader struct {
+	aceType  byte
+	aceFlags byte
+	aceSize  uint16
+}
+
+// aceSIDOffset is offset of SID in ACCESS_ALLOWED_ACE
+const aceSIDOffset = unsafe.Sizeof(aceHeader{}) + unsafe.Sizeof(uint32(0))
+
+// CheckPrivateFilePermissions checks that file or directory at path is accessible only by owner. Permission bits don't
+// reflect access rights on Windows, so DACL of file should grant access only to owner, SYSTEM and Administrators.
+// Files which aren't stored in filesystem, like keys in Redis, are checked by permission bits
+func CheckPrivateFilePermissions(path string, fileInfo os.FileInfo, perm os.FileMode) error {
+	if fileInfo.Sys() == nil {
+		return checkPermissionBits(fileInfo, perm)
+	}
+	descriptor, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	owner, _, err := descriptor.Owner()
+	if err != nil {
+		return err
+	}
+	dacl, _, err := descriptor.DACL()
+	if err != nil {
+		return err
+	}
+	// NULL DACL grants full access to everyone
+	if dacl == nil {
+		return ErrInsecureFilePermissions
+	}
+	trusted := []*windows.SID{owner}
+	for _, sidType := range []windows.WELL_KNOWN_SID_TYPE{windows.WinLocalSystemSid, windows.WinBuiltinAdministratorsSid} {
+		sid, err := windows.CreateWellKnownSid(sidType)
+		if err != nil {
+			return err
+		}
+		trusted = append(trusted, sid)
+	}
+	header := (*aclHeader)(unsafe.Pointer(dacl))
+	offset := unsafe.Sizeof(aclHeader{})
+	for i := uint16(0); i < header.aceCount; i++ {
+		ace := (*aceHeader)(unsafe.Add(unsafe.Pointer(dacl), offset))
+		offset += uintptr(ace.aceSize)
+		if ace.aceType != accessAllowedACEType {
+			continue
+		}
+		sid := (*windows.SID)(unsafe.Add(unsafe.Pointer(ace), aceSIDOffset))
+		if !isTrustedSID(sid, trusted) {
+			return ErrInsecureFilePermissions
+		}
+	}
+	return nil
+}
+
+func isTrustedSID(sid *windows.SID, trusted []*windows.SID) bool {
+	for _, trustedSID := range trusted {
+		if sid.Equals(trustedSID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "errors"
+
+// ErrMemoryLockNotSupported returned by LockMemory on platforms without mlock
+var ErrMemoryLockNotSupported = errors.New("locking memory is not supported on this platform")
+
+// LockMemory prevents memory pages of data from being swapped to disk.
+// Not supported on this platform.
+func LockMemory(data []byte) error {
+	return ErrMemoryLockNotSupported
+}
+
+// UnlockMemory allows memory pages of data locked by LockMemory to be swapped again.
+// Not supported on this platform.
+func UnlockMemory(data []byte) error {
+	return ErrMemoryLockNotSupported
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+/*
+Copyright 2020, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "syscall"
+
+// LockMemory prevents memory pages of data from being swapped to disk.
+// It may fail due to RLIMIT_MEMLOCK, so callers should treat an error as a warning.
+func LockMemory(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Mlock(data)
+}
+
+// UnlockMemory allows memory pages of data locked by LockMemory to be swapped again.
+func UnlockMemory(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Munlock(data)
+}
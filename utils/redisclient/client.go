@@ -0,0 +1,137 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redisclient creates connections to single Redis node, Redis Sentinel failover group or Redis Cluster and
+// implements operations which keystores and token storages use and which differ between these topologies.
+package redisclient
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Errors returned by New
+var (
+	ErrNoAddress         = errors.New("address of Redis is not specified")
+	ErrClusterDB         = errors.New("Redis Cluster supports only database 0")
+	ErrTopologyConflict  = errors.New("Redis Sentinel and Redis Cluster can't be used together")
+	errUnexpectedAuthCmd = errors.New("unexpected response on AUTH")
+)
+
+// Options of connection to Redis
+type Options struct {
+	// Addrs of Redis node, Redis Sentinel nodes or seed nodes of Redis Cluster
+	Addrs []string
+	// Username of ACL user (Redis 6+), empty to authenticate with Password only
+	Username string
+	Password string
+	DB       int
+	// SentinelMasterName turns on failover with Redis Sentinel nodes from Addrs
+	SentinelMasterName string
+	SentinelPassword   string
+	// Cluster turns on Redis Cluster with seed nodes from Addrs
+	Cluster   bool
+	TLSConfig *tls.Config
+}
+
+// Validate checks that options describe one of supported topologies
+func (options *Options) Validate() error {
+	if len(options.Addrs) == 0 {
+		return ErrNoAddress
+	}
+	if options.Cluster && options.SentinelMasterName != "" {
+		return ErrTopologyConflict
+	}
+	if options.Cluster && options.DB != 0 {
+		return ErrClusterDB
+	}
+	return nil
+}
+
+// authenticate returns OnConnect hook which authenticates ACL user and selects database. AUTH with username isn't
+// supported by go-redis, so it's sent manually and database is selected after it, because SELECT requires auth
+func (options *Options) authenticate() func(conn *redis.Conn) error {
+	username, password, db := options.Username, options.Password, options.DB
+	return func(conn *redis.Conn) error {
+		auth := redis.NewStatusCmd("auth", username, password)
+		if err := conn.Process(auth); err != nil {
+			return err
+		}
+		if auth.Val() != "OK" {
+			return errUnexpectedAuthCmd
+		}
+		if db > 0 {
+			return conn.Select(db).Err()
+		}
+		return nil
+	}
+}
+
+// New returns client of Redis Cluster if options.Cluster is set, failover client if options.SentinelMasterName is
+// set, otherwise client of single Redis node. Connection isn't checked, use Open to ping Redis
+func New(options *Options) (redis.UniversalClient, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	password, db := options.Password, options.DB
+	var onConnect func(conn *redis.Conn) error
+	if options.Username != "" {
+		onConnect = options.authenticate()
+		// go-redis sends AUTH and SELECT before OnConnect hook, hook does it instead
+		password, db = "", 0
+	}
+	switch {
+	case options.Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     options.Addrs,
+			Password:  password,
+			OnConnect: onConnect,
+			TLSConfig: options.TLSConfig,
+		}), nil
+	case options.SentinelMasterName != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       options.SentinelMasterName,
+			SentinelAddrs:    options.Addrs,
+			SentinelPassword: options.SentinelPassword,
+			Password:         password,
+			DB:               db,
+			OnConnect:        onConnect,
+			TLSConfig:        options.TLSConfig,
+		}), nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:      options.Addrs[0],
+		Password:  password,
+		DB:        db,
+		OnConnect: onConnect,
+		TLSConfig: options.TLSConfig,
+	}), nil
+}
+
+// Open returns client created by New after successful ping of Redis
+func Open(options *Options) (redis.UniversalClient, error) {
+	client, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
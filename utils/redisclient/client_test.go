@@ -0,0 +1,53 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redisclient
+
+import (
+	"testing"
+)
+
+func TestNewClientTopologies(t *testing.T) {
+	addrs := []string{"127.0.0.1:7000", "127.0.0.1:7001"}
+	testcases := []struct {
+		name      string
+		options   Options
+		isCluster bool
+		err       error
+	}{
+		{"single node", Options{Addrs: addrs[:1], DB: 1}, false, nil},
+		{"single node with ACL user", Options{Addrs: addrs[:1], Username: "acra", Password: "password", DB: 1}, false, nil},
+		{"sentinel", Options{Addrs: addrs, SentinelMasterName: "mymaster", DB: 1}, false, nil},
+		{"cluster", Options{Addrs: addrs, Cluster: true}, true, nil},
+		{"cluster with one seed node", Options{Addrs: addrs[:1], Cluster: true}, true, nil},
+		{"no address", Options{}, false, ErrNoAddress},
+		{"cluster with database", Options{Addrs: addrs, Cluster: true, DB: 1}, false, ErrClusterDB},
+		{"cluster with sentinel", Options{Addrs: addrs, Cluster: true, SentinelMasterName: "mymaster"}, false, ErrTopologyConflict},
+	}
+	for _, tcase := range testcases {
+		client, err := New(&tcase.options)
+		if err != tcase.err {
+			t.Fatalf("[%s] Expected %v, took %v", tcase.name, tcase.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if IsCluster(client) != tcase.isCluster {
+			t.Fatalf("[%s] Unexpected type of client %T", tcase.name, client)
+		}
+		client.Close()
+	}
+}
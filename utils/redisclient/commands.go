@@ -0,0 +1,187 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redisclient
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ErrNoSuchKey returned by Rename and RenameNX of Redis Cluster keys, has the same text as error of RENAME
+var ErrNoSuchKey = errors.New("ERR no such key")
+
+// busyKeyErrorPrefix is the prefix of error returned by RESTORE if key already exists
+const busyKeyErrorPrefix = "BUSYKEY"
+
+const noExpiration = 0
+
+// IsCluster returns true if client is connected to Redis Cluster. Multi-key commands of Redis Cluster fail with
+// CROSSSLOT error for keys from different hash slots, so they are replaced with single-key commands
+func IsCluster(client redis.UniversalClient) bool {
+	_, ok := client.(*redis.ClusterClient)
+	return ok
+}
+
+// ScanKeys returns all keys matching pattern. Keys of Redis Cluster are scanned on every master node
+func ScanKeys(client redis.UniversalClient, match string, count int64) ([]string, error) {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(client, match, count)
+	}
+	var mutex sync.Mutex
+	keys := make([]string, 0)
+	// callback is called concurrently for each master node
+	err := cluster.ForEachMaster(func(master *redis.Client) error {
+		masterKeys, err := scanNode(master, match, count)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		keys = append(keys, masterKeys...)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func scanNode(client redis.Cmdable, match string, count int64) ([]string, error) {
+	keys := make([]string, 0)
+	var cursor uint64
+	for {
+		nextKeys, nextCursor, err := client.Scan(cursor, match, count).Result()
+		if err != nil {
+			return nil, err
+		}
+		cursor = nextCursor
+		keys = append(keys, nextKeys...)
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// Rename renames key, replacing newKey if it exists. Keys of Redis Cluster may belong to different hash slots, so
+// they are copied with DUMP and RESTORE and old key is removed. Unlike RENAME, it's not atomic
+func Rename(client redis.UniversalClient, oldKey, newKey string) error {
+	if !IsCluster(client) {
+		return client.Rename(oldKey, newKey).Err()
+	}
+	data, err := dump(client, oldKey)
+	if err != nil {
+		return err
+	}
+	if err := client.RestoreReplace(newKey, noExpiration, data).Err(); err != nil {
+		return err
+	}
+	return client.Del(oldKey).Err()
+}
+
+// RenameNX renames key if newKey doesn't exist and returns true if key was renamed. Keys of Redis Cluster are
+// copied like with Rename
+func RenameNX(client redis.UniversalClient, oldKey, newKey string) (bool, error) {
+	if !IsCluster(client) {
+		return client.RenameNX(oldKey, newKey).Result()
+	}
+	data, err := dump(client, oldKey)
+	if err != nil {
+		return false, err
+	}
+	if err := client.Restore(newKey, noExpiration, data).Err(); err != nil {
+		if strings.HasPrefix(err.Error(), busyKeyErrorPrefix) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, client.Del(oldKey).Err()
+}
+
+func dump(client redis.UniversalClient, key string) (string, error) {
+	data, err := client.Dump(key).Result()
+	if err == redis.Nil {
+		return "", ErrNoSuchKey
+	}
+	return data, err
+}
+
+// Del removes keys and returns the number of removed ones
+func Del(client redis.UniversalClient, keys ...string) (int64, error) {
+	if !IsCluster(client) {
+		return client.Del(keys...).Result()
+	}
+	cmds, err := client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, cmd := range cmds {
+		removed += cmd.(*redis.IntCmd).Val()
+	}
+	return removed, nil
+}
+
+// MGet returns values of keys, nil for missing ones
+func MGet(client redis.UniversalClient, keys ...string) ([]interface{}, error) {
+	if !IsCluster(client) {
+		return client.MGet(keys...).Result()
+	}
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	// pipeline returns redis.Nil for missing keys, so errors are checked for each command
+	_, _ = client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.Get(key))
+		}
+		return nil
+	})
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// MSet sets values of keys from pairs of key and value. Unlike MSET, values of Redis Cluster keys aren't set atomically
+func MSet(client redis.UniversalClient, pairs []string) error {
+	if !IsCluster(client) {
+		return client.MSet(pairs).Err()
+	}
+	_, err := client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			pipe.Set(pairs[i], pairs[i+1], noExpiration)
+		}
+		return nil
+	})
+	return err
+}
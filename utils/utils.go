@@ -30,7 +30,6 @@ import (
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
-	"runtime"
 )
 
 // WriteFull writes data to io.Writer.
@@ -123,7 +122,7 @@ func LoadPrivateKey(path string) (*keys.PrivateKey, error) {
 		return nil, err
 	}
 	const expectedPerm = os.FileMode(0600)
-	if nil == err && runtime.GOOS == "linux" && fi.Mode().Perm() > expectedPerm {
+	if err := CheckPrivateFilePermissions(path, fi, expectedPerm); err != nil {
 		log.Errorf("Private key file %v has incorrect permissions %s, expected: %s", path, fi.Mode().Perm().String(), expectedPerm.String())
 		return nil, fmt.Errorf("private key file %v has incorrect permissions", path)
 	}
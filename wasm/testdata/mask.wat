@@ -0,0 +1,21 @@
+;; Source of mask.wasm used by wazero_test.go. Module returns buffer at the same address from every alloc call and
+;; processes value in place, so it doesn't export dealloc
+(module
+  (memory (export "memory") 1)
+  (func (export "alloc") (param $size i32) (result i32)
+    i32.const 1024)
+  ;; replaces every byte with "*" and returns (ptr << 32) | len of result
+  (func (export "mask") (param $ptr i32) (param $len i32) (result i64)
+    (memory.fill (local.get $ptr) (i32.const 42) (local.get $len))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $ptr)) (i64.const 32))
+      (i64.extend_i32_u (local.get $len))))
+  ;; rejects every value with code 1
+  (func (export "reject") (param i32 i32) (result i64)
+    i64.const -1)
+  (func (export "trap") (param i32 i32) (result i64)
+    unreachable)
+  ;; never returns
+  (func (export "loop") (param i32 i32) (result i64)
+    (loop (br 0))
+    unreachable))
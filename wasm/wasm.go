@@ -0,0 +1,216 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wasm applies user-defined functions of WebAssembly modules to values of columns configured with
+// `wasm_processor` in encryptor config. Modules run sandboxed without imports of host functions, so they can't access
+// files, network or memory of AcraServer.
+//
+// Modules should export memory and functions:
+//
+//	alloc(size i32) i32 - returns pointer to buffer of size bytes where AcraServer writes the value
+//	<function>(ptr i32, len i32) i64 - processes the value and returns pointer to result in high 32 bits and its
+//	length in low 32 bits, or negative code if the value is rejected
+//
+// and optionally dealloc(ptr i32, len i32) which is called for value and result buffers after each call.
+//
+// Every call is interrupted after `timeout` of wasm_processor. Runtime based on wazero is registered by default.
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// Errors returned by WebAssembly processors
+var (
+	ErrNoRuntime     = errors.New("WebAssembly runtime isn't registered")
+	ErrMissingExport = errors.New("WebAssembly module doesn't export required function or memory")
+	ErrValueRejected = errors.New("value is rejected by WebAssembly function")
+)
+
+// Module is compiled WebAssembly module
+type Module interface {
+	// Call passes data to exported function and returns its result. Should be safe for concurrent use and interrupt
+	// execution of the function when ctx is done
+	Call(ctx context.Context, function string, data []byte) ([]byte, error)
+	Close(ctx context.Context) error
+}
+
+// Runtime compiles WebAssembly modules
+type Runtime interface {
+	// Load compiles module and checks that it exports functions required by ABI
+	Load(ctx context.Context, code []byte) (Module, error)
+}
+
+var (
+	lock           = sync.Mutex{}
+	defaultRuntime Runtime
+)
+
+// RegisterRuntime sets Runtime used by processors
+func RegisterRuntime(runtime Runtime) {
+	lock.Lock()
+	defaultRuntime = runtime
+	lock.Unlock()
+}
+
+// Processor applies functions of WebAssembly modules configured for columns. It implements encryptor.DataEncryptor
+// to process values from queries before encryption and base.DecryptionSubscriber to process decrypted values.
+// Modules are loaded on first use and shared between connections
+type Processor struct {
+	runtime Runtime
+	lock    sync.Mutex
+	modules map[string]Module
+	// loads deduplicates concurrent loading of the same module without holding lock
+	loads singleflight.Group
+}
+
+// NewProcessor returns new Processor which loads modules with runtime
+func NewProcessor(runtime Runtime) (*Processor, error) {
+	if runtime == nil {
+		return nil, ErrNoRuntime
+	}
+	return &Processor{runtime: runtime, modules: make(map[string]Module)}, nil
+}
+
+var (
+	defaultProcessor     *Processor
+	defaultProcessorOnce sync.Once
+	defaultProcessorErr  error
+)
+
+// GetDefaultProcessor returns Processor with registered Runtime shared by all proxies
+func GetDefaultProcessor() (*Processor, error) {
+	defaultProcessorOnce.Do(func() {
+		lock.Lock()
+		runtime := defaultRuntime
+		lock.Unlock()
+		defaultProcessor, defaultProcessorErr = NewProcessor(runtime)
+	})
+	return defaultProcessor, defaultProcessorErr
+}
+
+func (processor *Processor) getModule(path string) (Module, error) {
+	processor.lock.Lock()
+	module, ok := processor.modules[path]
+	processor.lock.Unlock()
+	if ok {
+		return module, nil
+	}
+	result, err, _ := processor.loads.Do(path, func() (interface{}, error) {
+		processor.lock.Lock()
+		module, ok := processor.modules[path]
+		processor.lock.Unlock()
+		if ok {
+			return module, nil
+		}
+		code, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// module is shared by callers, so loading isn't bound to context of one of them
+		module, err = processor.runtime.Load(context.Background(), code)
+		if err != nil {
+			return nil, fmt.Errorf("can't load WebAssembly module %s: %w", path, err)
+		}
+		processor.lock.Lock()
+		processor.modules[path] = module
+		processor.lock.Unlock()
+		logrus.WithField("path", path).Infoln("Loaded WebAssembly module")
+		return module, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(Module), nil
+}
+
+// call applies function to data and interrupts it after timeout of setting
+func (processor *Processor) call(ctx context.Context, setting *config.WasmProcessorSetting, function string, data []byte) ([]byte, error) {
+	module, err := processor.getModule(setting.Module)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, setting.GetTimeout())
+	defer cancel()
+	return module.Call(ctx, function, data)
+}
+
+// Close closes all loaded modules
+func (processor *Processor) Close(ctx context.Context) error {
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+	var err error
+	for path, module := range processor.modules {
+		if closeErr := module.Close(ctx); closeErr != nil {
+			err = closeErr
+		}
+		delete(processor.modules, path)
+	}
+	return err
+}
+
+// EncryptWithClientID applies `on_encrypt` function to the value before it's encrypted by next encryptors in chain
+func (processor *Processor) EncryptWithClientID(clientID, data []byte, setting config.ColumnEncryptionSetting) ([]byte, error) {
+	wasmSetting := setting.GetWasmProcessor()
+	if wasmSetting == nil || wasmSetting.OnEncrypt == "" {
+		return data, nil
+	}
+	result, err := processor.call(context.Background(), wasmSetting, wasmSetting.OnEncrypt, data)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"column": setting.ColumnName(), "function": wasmSetting.OnEncrypt}).
+			Errorln("Can't process value with WebAssembly function")
+		return data, err
+	}
+	return result, nil
+}
+
+// ID returns name of processor
+func (processor *Processor) ID() string {
+	return "WasmProcessor"
+}
+
+// OnColumn applies `on_decrypt` function to decrypted values, other values are passed as is
+func (processor *Processor) OnColumn(ctx context.Context, data []byte) (context.Context, []byte, error) {
+	if !base.IsDecryptedFromContext(ctx) {
+		return ctx, data, nil
+	}
+	setting, ok := encryptor.EncryptionSettingFromContext(ctx)
+	if !ok {
+		return ctx, data, nil
+	}
+	wasmSetting := setting.GetWasmProcessor()
+	if wasmSetting == nil || wasmSetting.OnDecrypt == "" {
+		return ctx, data, nil
+	}
+	result, err := processor.call(ctx, wasmSetting, wasmSetting.OnDecrypt, data)
+	if err != nil {
+		logging.GetLoggerFromContext(ctx).WithError(err).WithFields(logrus.Fields{"column": setting.ColumnName(), "function": wasmSetting.OnDecrypt}).
+			Errorln("Can't process decrypted value with WebAssembly function")
+		return ctx, data, err
+	}
+	return ctx, result, nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+type testModule struct {
+	functions map[string]func([]byte) ([]byte, error)
+}
+
+func (module *testModule) Call(ctx context.Context, function string, data []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("call without timeout")
+	}
+	f, ok := module.functions[function]
+	if !ok {
+		return nil, ErrMissingExport
+	}
+	return f(data)
+}
+
+func (module *testModule) Close(ctx context.Context) error {
+	return nil
+}
+
+type testRuntime struct {
+	lock  sync.Mutex
+	loads int
+}
+
+func (runtime *testRuntime) Load(ctx context.Context, code []byte) (Module, error) {
+	runtime.lock.Lock()
+	runtime.loads++
+	runtime.lock.Unlock()
+	return &testModule{functions: map[string]func([]byte) ([]byte, error){
+		"mask": func(data []byte) ([]byte, error) {
+			return bytes.Repeat([]byte("*"), len(data)), nil
+		},
+		"validate": func(data []byte) ([]byte, error) {
+			if len(data) == 0 {
+				return nil, ErrValueRejected
+			}
+			return data, nil
+		},
+	}}, nil
+}
+
+func TestProcessor(t *testing.T) {
+	if _, err := NewProcessor(nil); err != ErrNoRuntime {
+		t.Fatalf("Expected ErrNoRuntime, took %v", err)
+	}
+	modulePath := filepath.Join(t.TempDir(), "module.wasm")
+	if err := os.WriteFile(modulePath, []byte("module"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	runtime := &testRuntime{}
+	processor, err := NewProcessor(runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := &config.BasicColumnEncryptionSetting{Name: "email", WasmProcessor: &config.WasmProcessorSetting{
+		Module: modulePath, OnEncrypt: "validate", OnDecrypt: "mask",
+	}}
+
+	ctx := encryptor.NewContextWithEncryptionSetting(context.Background(), setting)
+	// concurrent first calls load module once
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.OnColumn(base.MarkDecryptedContext(ctx), []byte("value"))
+		}()
+	}
+	wg.Wait()
+
+	data, err := processor.EncryptWithClientID(nil, []byte("value"), setting)
+	if err != nil || !bytes.Equal(data, []byte("value")) {
+		t.Fatalf("Unexpected result of validation: %s, %v", data, err)
+	}
+	if _, err = processor.EncryptWithClientID(nil, []byte{}, setting); !errors.Is(err, ErrValueRejected) {
+		t.Fatalf("Expected ErrValueRejected, took %v", err)
+	}
+	data, err = processor.EncryptWithClientID(nil, []byte("value"), &config.BasicColumnEncryptionSetting{Name: "name"})
+	if err != nil || !bytes.Equal(data, []byte("value")) {
+		t.Fatalf("Columns without wasm_processor should be passed as is: %s, %v", data, err)
+	}
+
+	_, data, err = processor.OnColumn(base.MarkDecryptedContext(ctx), []byte("value"))
+	if err != nil || !bytes.Equal(data, []byte("*****")) {
+		t.Fatalf("Unexpected result of masking: %s, %v", data, err)
+	}
+	_, data, err = processor.OnColumn(ctx, []byte("ciphertext"))
+	if err != nil || !bytes.Equal(data, []byte("ciphertext")) {
+		t.Fatalf("Values which weren't decrypted should be passed as is: %s, %v", data, err)
+	}
+	if runtime.loads != 1 {
+		t.Fatalf("Module should be loaded once, took %d loads", runtime.loads)
+	}
+
+	setting.WasmProcessor.OnDecrypt = "unknown"
+	if _, _, err = processor.OnColumn(base.MarkDecryptedContext(ctx), []byte("value")); !errors.Is(err, ErrMissingExport) {
+		t.Fatalf("Expected ErrMissingExport, took %v", err)
+	}
+	if err = processor.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Names of exports required by ABI
+const (
+	allocFunction   = "alloc"
+	deallocFunction = "dealloc"
+	memoryExport    = "memory"
+)
+
+// maxMemoryPages limits memory of module instance to 64MB
+const maxMemoryPages = 1024
+
+// wazeroRuntime implements Runtime with wazero which doesn't require cgo, so it's compiled into AcraServer by default
+type wazeroRuntime struct{}
+
+// Load compiles module in separate wazero runtime without host functions
+func (wazeroRuntime) Load(ctx context.Context, code []byte) (Module, error) {
+	// calls are interrupted when their context is done, so looping functions can't block queries
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(maxMemoryPages).
+		WithCloseOnContextDone(true)
+	wasmRuntime := wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
+	compiled, err := wasmRuntime.CompileModule(ctx, code)
+	if err != nil {
+		wasmRuntime.Close(ctx)
+		return nil, err
+	}
+	if _, ok := compiled.ExportedFunctions()[allocFunction]; !ok {
+		wasmRuntime.Close(ctx)
+		return nil, fmt.Errorf("%w: %s", ErrMissingExport, allocFunction)
+	}
+	if _, ok := compiled.ExportedMemories()[memoryExport]; !ok {
+		wasmRuntime.Close(ctx)
+		return nil, fmt.Errorf("%w: %s", ErrMissingExport, memoryExport)
+	}
+	return &wazeroModule{
+		runtime:   wasmRuntime,
+		compiled:  compiled,
+		instances: make(chan api.Module, runtime.NumCPU()),
+	}, nil
+}
+
+// wazeroModule keeps pool of instances because instance can't be called concurrently
+type wazeroModule struct {
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	instances chan api.Module
+}
+
+func (module *wazeroModule) getInstance(ctx context.Context) (api.Module, error) {
+	select {
+	case instance := <-module.instances:
+		return instance, nil
+	default:
+		// anonymous modules may be instantiated several times
+		return module.runtime.InstantiateModule(ctx, module.compiled, wazero.NewModuleConfig().WithName(""))
+	}
+}
+
+func (module *wazeroModule) putInstance(ctx context.Context, instance api.Module) {
+	select {
+	case module.instances <- instance:
+	default:
+		instance.Close(ctx)
+	}
+}
+
+// Call implementation of Call method of Module interface
+func (module *wazeroModule) Call(ctx context.Context, function string, data []byte) ([]byte, error) {
+	instance, err := module.getInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := callInstance(ctx, instance, function, data)
+	if err != nil {
+		// state of instance is unknown after trap
+		instance.Close(ctx)
+		return nil, err
+	}
+	module.putInstance(ctx, instance)
+	return result, nil
+}
+
+func callInstance(ctx context.Context, instance api.Module, function string, data []byte) ([]byte, error) {
+	processFunction := instance.ExportedFunction(function)
+	if processFunction == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissingExport, function)
+	}
+	results, err := instance.ExportedFunction(allocFunction).Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	dataPtr := uint32(results[0])
+	if !instance.Memory().Write(dataPtr, data) {
+		return nil, fmt.Errorf("alloc returned buffer out of memory range")
+	}
+	results, err = processFunction.Call(ctx, uint64(dataPtr), uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	result := int64(results[0])
+	if result < 0 {
+		return nil, fmt.Errorf("%w: code %d", ErrValueRejected, -result)
+	}
+	resultPtr, resultLength := uint32(result>>32), uint32(result)
+	output, ok := instance.Memory().Read(resultPtr, resultLength)
+	if !ok {
+		return nil, fmt.Errorf("%s returned result out of memory range", function)
+	}
+	// memory may be reused by next calls
+	output = append([]byte{}, output...)
+	if dealloc := instance.ExportedFunction(deallocFunction); dealloc != nil {
+		if _, err = dealloc.Call(ctx, uint64(dataPtr), uint64(len(data))); err != nil {
+			return nil, err
+		}
+		// function may process value in place
+		if resultPtr != dataPtr {
+			if _, err = dealloc.Call(ctx, uint64(resultPtr), uint64(resultLength)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return output, nil
+}
+
+// Close implementation of Close method of Module interface
+func (module *wazeroModule) Close(ctx context.Context) error {
+	// closing of runtime closes all instances
+	return module.runtime.Close(ctx)
+}
+
+func init() {
+	RegisterRuntime(wazeroRuntime{})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/encryptor/config"
+)
+
+// testModulePath is module compiled from testdata/mask.wat
+var testModulePath = filepath.Join("testdata", "mask.wasm")
+
+func TestWazeroRuntime(t *testing.T) {
+	ctx := context.Background()
+	code, err := os.ReadFile(testModulePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (wazeroRuntime{}).Load(ctx, []byte("module")); err == nil {
+		t.Fatal("Expected error for invalid module")
+	}
+	module, err := wazeroRuntime{}.Load(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer module.Close(ctx)
+
+	data, err := module.Call(ctx, "mask", []byte("value"))
+	if err != nil || !bytes.Equal(data, []byte("*****")) {
+		t.Fatalf("Unexpected result of masking: %s, %v", data, err)
+	}
+	if _, err := module.Call(ctx, "reject", []byte("value")); !errors.Is(err, ErrValueRejected) {
+		t.Fatalf("Expected ErrValueRejected, took %v", err)
+	}
+	if _, err := module.Call(ctx, "unknown", []byte("value")); !errors.Is(err, ErrMissingExport) {
+		t.Fatalf("Expected ErrMissingExport, took %v", err)
+	}
+	if _, err := module.Call(ctx, "trap", []byte("value")); err == nil {
+		t.Fatal("Expected error of trapped call")
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := module.Call(timeoutCtx, "loop", []byte("value")); err == nil {
+		t.Fatal("Expected error of interrupted call")
+	}
+	// instance after trap or interruption is replaced with new one
+	data, err = module.Call(ctx, "mask", []byte("other"))
+	if err != nil || !bytes.Equal(data, []byte("*****")) {
+		t.Fatalf("Unexpected result of masking after trap: %s, %v", data, err)
+	}
+
+	// instances aren't shared between concurrent calls
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(value []byte) {
+			defer wg.Done()
+			data, err := module.Call(ctx, "mask", value)
+			if err == nil && !bytes.Equal(data, bytes.Repeat([]byte("*"), len(value))) {
+				err = errors.New("unexpected result of concurrent masking")
+			}
+			errs <- err
+		}(bytes.Repeat([]byte("v"), i+1))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessorWithWazero(t *testing.T) {
+	// wazero runtime is registered without build tags
+	processor, err := GetDefaultProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := &config.BasicColumnEncryptionSetting{Name: "email", WasmProcessor: &config.WasmProcessorSetting{
+		Module: testModulePath, OnEncrypt: "reject", OnDecrypt: "mask",
+	}}
+	if _, err = processor.EncryptWithClientID(nil, []byte("value"), setting); !errors.Is(err, ErrValueRejected) {
+		t.Fatalf("Expected ErrValueRejected, took %v", err)
+	}
+	ctx := encryptor.NewContextWithEncryptionSetting(context.Background(), setting)
+	_, data, err := processor.OnColumn(base.MarkDecryptedContext(ctx), []byte("value"))
+	if err != nil || !bytes.Equal(data, []byte("*****")) {
+		t.Fatalf("Unexpected result of masking: %s, %v", data, err)
+	}
+
+	// looping function doesn't block query longer than timeout
+	setting.WasmProcessor = &config.WasmProcessorSetting{Module: testModulePath, OnEncrypt: "loop", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err = processor.EncryptWithClientID(nil, []byte("value"), setting); err == nil {
+		t.Fatal("Expected error of interrupted call")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Call wasn't interrupted after timeout, took %s", elapsed)
+	}
+}